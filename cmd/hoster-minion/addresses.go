@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/topology"
+)
+
+const (
+	metadataTimeout = time.Second
+	stunTimeout     = 2 * time.Second
+)
+
+// metadataService is a cloud provider metadata endpoint that returns the
+// instance's public IPv4 address as plain text.
+type metadataService struct {
+	provider string
+	url      string
+	header   [2]string
+	token    func(ctx context.Context, client *http.Client) (string, error) // Session token, sent as header[0]
+}
+
+var metadataServices = []metadataService{
+	{
+		provider: "aws",
+		url:      "http://169.254.169.254/latest/meta-data/public-ipv4",
+		header:   [2]string{"X-aws-ec2-metadata-token", ""},
+		token:    awsMetadataToken,
+	},
+	{
+		provider: "gcp",
+		url:      "http://169.254.169.254/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip",
+		header:   [2]string{"Metadata-Flavor", "Google"},
+	},
+	{
+		provider: "azure",
+		url:      "http://169.254.169.254/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2021-02-01&format=text",
+		header:   [2]string{"Metadata", "true"},
+	},
+	{
+		provider: "digitalocean",
+		url:      "http://169.254.169.254/metadata/v1/interfaces/public/0/ipv4/address",
+	},
+	{
+		provider: "hetzner",
+		url:      "http://169.254.169.254/hetzner/v1/metadata/public-ipv4",
+	},
+}
+
+// networkAddressesCmd handles the "network-addresses" command.
+// It reports the node's private address and the address the internet sees it
+// at, asking the cloud metadata service first and a STUN server otherwise.
+// Options are read from stdin (optional).
+func networkAddressesCmd() error {
+	var opts minion.NetworkAddressOptions
+	_ = decodeInput(&opts) // Ignore error - stdin may be empty
+
+	servers := opts.STUNServers
+	if len(servers) == 0 {
+		servers = topology.DefaultSTUNServers
+	}
+
+	result := minion.NetworkAddresses{PrivateAddress: outboundAddress()}
+	var errs []string
+
+	if !opts.SkipMetadata {
+		if provider, addr, err := metadataPublicAddress(context.Background()); err == nil {
+			result.PublicAddress, result.Source, result.Provider = addr, string(topology.SourceMetadata), provider
+		}
+	}
+	if result.PublicAddress == "" {
+		for _, server := range servers {
+			addr, err := stunPublicAddress(server)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("stun %s: %v", server, err))
+				continue
+			}
+			result.PublicAddress, result.Source = addr, string(topology.SourceSTUN)
+			break
+		}
+	}
+	if result.PublicAddress == "" {
+		result.Error = strings.Join(errs, "; ")
+		if result.Error == "" {
+			result.Error = "no metadata service or STUN server answered"
+		}
+	}
+
+	outputSuccess(result)
+	return nil
+}
+
+// outboundAddress returns the local address of the interface with the
+// default route. Connecting a UDP socket sends no packets.
+func outboundAddress() string {
+	conn, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// metadataPublicAddress asks each cloud metadata service for the public
+// address until one answers with a public IP.
+func metadataPublicAddress(ctx context.Context) (string, string, error) {
+	client := &http.Client{Timeout: metadataTimeout}
+	for _, svc := range metadataServices {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, svc.url, nil)
+		if err != nil {
+			continue
+		}
+		if svc.header[0] != "" {
+			value := svc.header[1]
+			if svc.token != nil {
+				if value, err = svc.token(ctx, client); err != nil {
+					continue
+				}
+			}
+			req.Header.Set(svc.header[0], value)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if isTimeout(err) {
+				// The metadata address is not routed here; no other service will answer either
+				break
+			}
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		resp.Body.Close()
+		addr := strings.TrimSpace(string(body))
+		if resp.StatusCode == http.StatusOK && topology.IsPublicIP(addr) {
+			return svc.provider, addr, nil
+		}
+	}
+	return "", "", fmt.Errorf("no metadata service reported a public address")
+}
+
+// awsMetadataToken gets an IMDSv2 session token.
+func awsMetadataToken(ctx context.Context, client *http.Client) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://169.254.169.254/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %d", resp.StatusCode)
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return string(token), err
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// stunPublicAddress sends a Binding request to server and returns the
+// address it saw the request come from.
+func stunPublicAddress(server string) (string, error) {
+	conn, err := net.DialTimeout("udp", server, stunTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var txID [12]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return "", err
+	}
+	conn.SetDeadline(time.Now().Add(stunTimeout))
+	if _, err := conn.Write(topology.STUNBindingRequest(txID)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	ip, _, err := topology.ParseSTUNBindingResponse(buf[:n], txID)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
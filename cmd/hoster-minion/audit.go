@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Command Policy and Audit Log
+// =============================================================================
+//
+// Every command is checked against the operator's policy file and recorded in
+// an append-only audit log before its result is returned. Both paths can be
+// overridden for nodes where the minion's home is not writable.
+
+const (
+	policyPathEnv   = "HOSTER_MINION_POLICY"
+	auditLogPathEnv = "HOSTER_MINION_AUDIT_LOG"
+
+	defaultPolicyFile   = ".hoster/policy.json"
+	defaultAuditLogFile = ".hoster/audit.log"
+)
+
+// errCommandRefused is returned for commands the policy does not allow.
+var errCommandRefused = &commandError{msg: "command refused by policy"}
+
+// minionPath returns the path in env, or file under the home directory.
+func minionPath(env, file string) (string, error) {
+	if p := os.Getenv(env); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	return filepath.Join(home, file), nil
+}
+
+// loadCommandPolicy reads the policy file. A missing file allows every
+// command; an unreadable or invalid one refuses all but the always-allowed
+// commands, so a broken policy never widens access.
+func loadCommandPolicy() (minion.CommandPolicy, error) {
+	path, err := minionPath(policyPathEnv, defaultPolicyFile)
+	if err != nil {
+		return minion.CommandPolicy{Deny: []string{"*"}}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return minion.CommandPolicy{}, nil
+	}
+	if err != nil {
+		return minion.CommandPolicy{Deny: []string{"*"}}, fmt.Errorf("read %s: %w", path, err)
+	}
+	policy, err := minion.ParseCommandPolicy(data)
+	if err != nil {
+		return minion.CommandPolicy{Deny: []string{"*"}}, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// openAuditLog opens the audit log for appending, creating it if needed.
+func openAuditLog() (*os.File, error) {
+	path, err := minionPath(auditLogPathEnv, defaultAuditLogFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+// runAudited checks cmd against the policy, runs it and appends the outcome
+// to the audit log. Commands other than "version" are refused when the audit
+// log cannot be written.
+func runAudited(cmd string, args []string) error {
+	entry := minion.AuditEntry{
+		Time:    time.Now().UTC(),
+		Command: cmd,
+		Args:    args,
+	}
+	if id := os.Getenv(minion.RequestIDEnv); minion.ValidRequestID(id) {
+		entry.RequestID = id
+	}
+
+	auditLog, err := openAuditLog()
+	if err != nil && cmd != "version" {
+		outputError(cmd, minion.ErrCodeInternal, "audit log unavailable: "+err.Error())
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	policy, policyErr := loadCommandPolicy()
+	if !policy.Allows(cmd) {
+		msg := "command not allowed by the node's command policy"
+		if policyErr != nil {
+			msg = "invalid command policy: " + policyErr.Error()
+		}
+		entry.Error = msg
+		writeAuditEntry(auditLog, entry)
+		outputError(cmd, minion.ErrCodeForbidden, msg)
+		return errCommandRefused
+	}
+
+	entry.Allowed = true
+	err = dispatch(cmd, args)
+	entry.Success = err == nil
+	entry.DurationMs = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	writeAuditEntry(auditLog, entry)
+	return err
+}
+
+// writeAuditEntry appends one JSON line. O_APPEND keeps concurrent minion
+// processes from interleaving lines.
+func writeAuditEntry(f *os.File, entry minion.AuditEntry) {
+	if f == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	f.Write(append(line, '\n'))
+}
+
+// auditLogCmd handles the "audit-log" command.
+// Reads AuditLogOptions JSON from stdin.
+func auditLogCmd() error {
+	var opts minion.AuditLogOptions
+	if err := decodeInput(&opts); err != nil && !errors.Is(err, io.EOF) {
+		outputError("audit-log", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+
+	path, err := minionPath(auditLogPathEnv, defaultAuditLogFile)
+	if err != nil {
+		outputError("audit-log", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		outputSuccess(minion.AuditLog{Entries: []minion.AuditEntry{}})
+		return nil
+	}
+	if err != nil {
+		outputError("audit-log", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	defer f.Close()
+
+	limit := opts.EffectiveLimit()
+	result := minion.AuditLog{Entries: []minion.AuditEntry{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry minion.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // A line cut short by a crash
+		}
+		if !opts.Accepts(entry) {
+			continue
+		}
+		if len(result.Entries) == limit {
+			result.Truncated = true
+			break
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		outputError("audit-log", minion.ErrCodeInternal, "read audit log: "+err.Error())
+		return err
+	}
+
+	outputSuccess(result)
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/checkpoint"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+	dockercheckpoint "github.com/docker/docker/api/types/checkpoint"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Checkpoints (experimental) are taken with Docker's CRIU support into
+// ~/.hoster/checkpoints/<transfer_id>/<name> and archived into the volume
+// transfer stage, so the backend relays them with volume-read/volume-receive.
+// The target unpacks the verified stage into the same layout and starts the
+// new container from it. The minion user must be able to read the files
+// dockerd writes there.
+
+const checkpointsDir = ".hoster/checkpoints"
+
+// checkpointDir returns the directory a transfer's checkpoint lives in.
+func checkpointDir(transferID string) (string, error) {
+	if err := transfer.ValidateTransferID(transferID); err != nil {
+		return "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	dir := filepath.Join(home, checkpointsDir, transferID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// checkpointSupportCmd handles "checkpoint-support".
+func checkpointSupportCmd() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("checkpoint-support", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	info, err := cli.Info(ctx)
+	if err != nil {
+		outputError("checkpoint-support", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+
+	host := checkpoint.Host{OSType: info.OSType, Experimental: info.ExperimentalBuild}
+	// criu --version exits non-zero on some builds but still prints the version
+	if out, err := exec.CommandContext(ctx, "criu", "--version").CombinedOutput(); err == nil || len(out) > 0 {
+		host.CRIUVersion = string(out)
+	}
+	outputSuccess(checkpoint.Evaluate(host))
+	return nil
+}
+
+// checkpointCreateCmd handles "checkpoint-create <transfer_id> <container>".
+// It checkpoints the container, which stops it, and archives the checkpoint
+// into the transfer stage, or returns the existing stage.
+func checkpointCreateCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("checkpoint-create", minion.ErrCodeInvalidInput, "usage: checkpoint-create <transfer_id> <container>")
+		return errInvalidArgs
+	}
+	transferID, containerID := args[0], args[1]
+
+	archivePath, metaPath, err := stagePaths(transferID)
+	if err != nil {
+		outputError("checkpoint-create", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	// The container exited at the first checkpoint, so a resumed transfer
+	// must reuse the stage rather than checkpoint again.
+	if snap, ok := readSnapshotMeta(archivePath, metaPath); ok && snap.Volume == containerID {
+		outputSuccess(snap)
+		return nil
+	}
+
+	dir, err := checkpointDir(transferID)
+	if err != nil {
+		outputError("checkpoint-create", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	root := filepath.Join(dir, checkpoint.Name)
+
+	if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			outputError("checkpoint-create", minion.ErrCodeConnectionFailed, err.Error())
+			return err
+		}
+		defer cli.Close()
+
+		err = cli.CheckpointCreate(ctx, containerID, dockercheckpoint.CreateOptions{
+			CheckpointID:  checkpoint.Name,
+			CheckpointDir: dir,
+			Exit:          true,
+		})
+		if err != nil {
+			code := minion.ErrCodeInternal
+			if strings.Contains(err.Error(), "No such container") {
+				code = minion.ErrCodeNotFound
+			}
+			outputError("checkpoint-create", code, err.Error())
+			return err
+		}
+	}
+
+	snap, err := writeVolumeArchive(root, archivePath)
+	if err != nil {
+		outputError("checkpoint-create", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	snap.TransferID = transferID
+	snap.Volume = containerID
+
+	meta, _ := json.Marshal(snap)
+	if err := os.WriteFile(metaPath, meta, 0o600); err != nil {
+		outputError("checkpoint-create", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	// The stage now holds the checkpoint.
+	os.RemoveAll(dir)
+
+	outputSuccess(snap)
+	return nil
+}
+
+// checkpointUnpackCmd handles "checkpoint-unpack <transfer_id> <sha256>".
+// The staged archive must match sha256; it is extracted into the transfer's
+// checkpoint directory, replacing anything there.
+func checkpointUnpackCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("checkpoint-unpack", minion.ErrCodeInvalidInput, "usage: checkpoint-unpack <transfer_id> <sha256>")
+		return errInvalidArgs
+	}
+	transferID, expected := args[0], args[1]
+
+	archivePath, _, err := stagePaths(transferID)
+	if err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	size, sum, err := fileChecksum(archivePath)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if errors.Is(err, fs.ErrNotExist) {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("checkpoint-unpack", code, err.Error())
+		return err
+	}
+	if sum != expected {
+		os.Remove(archivePath)
+		msg := fmt.Sprintf("staged archive checksum %s does not match %s", sum, expected)
+		outputError("checkpoint-unpack", minion.ErrCodeChecksumMismatch, msg)
+		return errors.New(msg)
+	}
+
+	dir, err := checkpointDir(transferID)
+	if err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	root := filepath.Join(dir, checkpoint.Name)
+	if err := os.RemoveAll(root); err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if err := extractArchive(archivePath, root); err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	var entries []transfer.ManifestEntry
+	var dataBytes int64
+	err = walkVolume(root, func(full string, _ fs.FileInfo, e *transfer.ManifestEntry) error {
+		if e.Type == transfer.EntryFile {
+			n, sum, err := fileChecksum(full)
+			if err != nil {
+				return err
+			}
+			e.SHA256 = sum
+			dataBytes += n
+		}
+		entries = append(entries, *e)
+		return nil
+	})
+	if err != nil {
+		outputError("checkpoint-unpack", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	outputSuccess(minion.VolumeRestoreResult{
+		Volume:         transferID,
+		Size:           size,
+		SHA256:         sum,
+		Files:          len(entries),
+		DataBytes:      dataBytes,
+		ManifestDigest: transfer.ManifestDigest(entries),
+	})
+	return nil
+}
+
+// checkpointRestoreCmd handles "checkpoint-restore <transfer_id> <container>".
+// It starts the (created, not yet started) container from the unpacked
+// checkpoint and removes the checkpoint once it is running.
+func checkpointRestoreCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("checkpoint-restore", minion.ErrCodeInvalidInput, "usage: checkpoint-restore <transfer_id> <container>")
+		return errInvalidArgs
+	}
+	transferID, containerID := args[0], args[1]
+
+	dir, err := checkpointDir(transferID)
+	if err != nil {
+		outputError("checkpoint-restore", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkpoint.Name)); err != nil {
+		code := minion.ErrCodeInternal
+		if errors.Is(err, fs.ErrNotExist) {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("checkpoint-restore", code, err.Error())
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("checkpoint-restore", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	err = cli.ContainerStart(ctx, containerID, container.StartOptions{
+		CheckpointID:  checkpoint.Name,
+		CheckpointDir: dir,
+	})
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such container") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("checkpoint-restore", code, err.Error())
+		return err
+	}
+
+	os.RemoveAll(dir)
+	outputSuccess(nil)
+	return nil
+}
+
+// checkpointDiscardCmd handles "checkpoint-discard <transfer_id>". It removes
+// the transfer's checkpoint and stage; missing ones are not an error.
+func checkpointDiscardCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("checkpoint-discard", minion.ErrCodeInvalidInput, "usage: checkpoint-discard <transfer_id>")
+		return errInvalidArgs
+	}
+	archivePath, metaPath, err := stagePaths(args[0])
+	if err != nil {
+		outputError("checkpoint-discard", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	dir, err := checkpointDir(args[0])
+	if err != nil {
+		outputError("checkpoint-discard", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	for _, p := range []string{archivePath, metaPath, dir} {
+		if err := os.RemoveAll(p); err != nil {
+			outputError("checkpoint-discard", minion.ErrCodeInternal, err.Error())
+			return err
+		}
+	}
+	outputSuccess(nil)
+	return nil
+}
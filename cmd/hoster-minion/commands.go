@@ -14,6 +14,18 @@ func dispatch(cmd string, args []string) error {
 		return pingCmd()
 	case "system-info":
 		return systemInfoCmd()
+	case "node-metrics":
+		return nodeMetricsCmd()
+	case "node-housekeeping":
+		return nodeHousekeepingCmd()
+	case "network-addresses":
+		return networkAddressesCmd()
+	case "gpu-info":
+		return gpuInfoCmd()
+	case "traefik-config":
+		return traefikConfigCmd()
+	case "write-file":
+		return writeFileCmd()
 
 	// Container commands
 	case "create-container":
@@ -30,8 +42,20 @@ func dispatch(cmd string, args []string) error {
 		return listContainersCmd()
 	case "container-logs":
 		return containerLogsCmd(args)
+	case "container-logs-stream":
+		return containerLogsStreamCmd(args)
 	case "container-stats":
 		return containerStatsCmd(args)
+	case "update-container":
+		return updateContainerCmd(args)
+	case "probe-container":
+		return probeContainerCmd(args)
+	case "exec-container":
+		return execContainerCmd(args)
+	case "container-events":
+		return containerEventsCmd()
+	case "tunnel":
+		return tunnelCmd(args)
 
 	// Network commands
 	case "create-network":
@@ -48,12 +72,50 @@ func dispatch(cmd string, args []string) error {
 		return createVolumeCmd()
 	case "remove-volume":
 		return removeVolumeCmd(args)
+	case "volume-usage":
+		return volumeUsageCmd(args)
+
+	// Volume transfer commands
+	case "volume-snapshot":
+		return volumeSnapshotCmd(args)
+	case "volume-read":
+		return volumeReadCmd(args)
+	case "volume-receive":
+		return volumeReceiveCmd(args)
+	case "volume-staged":
+		return volumeStagedCmd(args)
+	case "volume-restore":
+		return volumeRestoreCmd(args)
+	case "volume-discard":
+		return volumeDiscardCmd(args)
+
+	// Checkpoint commands (experimental)
+	case "checkpoint-support":
+		return checkpointSupportCmd()
+	case "checkpoint-create":
+		return checkpointCreateCmd(args)
+	case "checkpoint-unpack":
+		return checkpointUnpackCmd(args)
+	case "checkpoint-restore":
+		return checkpointRestoreCmd(args)
+	case "checkpoint-discard":
+		return checkpointDiscardCmd(args)
 
 	// Image commands
 	case "pull-image":
 		return pullImageCmd(args)
 	case "image-exists":
 		return imageExistsCmd(args)
+	case "image-digest":
+		return imageDigestCmd(args)
+	case "image-save":
+		return imageSaveCmd(args)
+	case "image-load":
+		return imageLoadCmd()
+
+	// Audit commands
+	case "audit-log":
+		return auditLogCmd()
 
 	default:
 		outputError(cmd, minion.ErrCodeInvalidInput, "unknown command: "+cmd)
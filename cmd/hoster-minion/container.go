@@ -27,8 +27,8 @@ func createContainerCmd() error {
 
 	// Read spec from stdin
 	var spec minion.ContainerSpec
-	if err := json.NewDecoder(os.Stdin).Decode(&spec); err != nil {
-		outputError("create-container", minion.ErrCodeInvalidInput, "invalid JSON input: "+err.Error())
+	if err := decodeInput(&spec); err != nil {
+		outputError("create-container", inputErrorCode(err), "invalid JSON input: "+err.Error())
 		return err
 	}
 
@@ -115,6 +115,13 @@ func createContainerCmd() error {
 	if spec.Resources.MemoryLimit > 0 {
 		hostConfig.Memory = spec.Resources.MemoryLimit
 	}
+	if len(spec.Resources.GPUDeviceIDs) > 0 {
+		hostConfig.DeviceRequests = []container.DeviceRequest{{
+			Driver:       "nvidia",
+			DeviceIDs:    spec.Resources.GPUDeviceIDs,
+			Capabilities: [][]string{{"gpu"}},
+		}}
+	}
 
 	// Restart policy
 	if spec.RestartPolicy.Name != "" {
@@ -124,6 +131,14 @@ func createContainerCmd() error {
 		}
 	}
 
+	// Init process, ulimits and device cgroup rules
+	hostConfig.Init = spec.Init
+	for _, u := range spec.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	hostConfig.DeviceCgroupRules = spec.DeviceCgroupRules
+	hostConfig.ExtraHosts = spec.ExtraHosts
+
 	// Health check
 	if spec.HealthCheck != nil {
 		config.Healthcheck = &container.HealthConfig{
@@ -142,7 +157,7 @@ func createContainerCmd() error {
 			EndpointsConfig: map[string]*network.EndpointSettings{},
 		}
 		for _, n := range spec.Networks {
-			networkConfig.EndpointsConfig[n] = &network.EndpointSettings{}
+			networkConfig.EndpointsConfig[n] = &network.EndpointSettings{Aliases: spec.NetworkAliases[n]}
 		}
 	}
 
@@ -254,7 +269,7 @@ func removeContainerCmd(args []string) error {
 
 	// Try to read options from stdin (optional)
 	var opts minion.RemoveOptions
-	_ = json.NewDecoder(os.Stdin).Decode(&opts) // Ignore error - stdin may be empty
+	_ = decodeInput(&opts) // Ignore error - stdin may be empty
 
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -320,7 +335,7 @@ func listContainersCmd() error {
 
 	// Read options from stdin
 	var opts minion.ListOptions
-	_ = json.NewDecoder(os.Stdin).Decode(&opts) // Ignore error - stdin may be empty
+	_ = decodeInput(&opts) // Ignore error - stdin may be empty
 
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -391,19 +406,63 @@ func containerLogsCmd(args []string) error {
 		return errInvalidArgs
 	}
 
+	reader, code, err := openContainerLogs(args[0])
+	if err != nil {
+		outputError("container-logs", code, err.Error())
+		return err
+	}
+	defer reader.Close()
+
+	// Refuse logs over the limit rather than returning part of them
+	buf := new(bytes.Buffer)
+	n, _ := io.CopyN(buf, reader, minion.MaxLogBytes+1)
+	if n > minion.MaxLogBytes {
+		err := fmt.Errorf("%w; request fewer lines with tail or since", minion.TooLargeError("logs", 0, minion.MaxLogBytes))
+		outputError("container-logs", minion.ErrCodeTooLarge, err.Error())
+		return err
+	}
+
+	outputSuccess(minion.LogsResult{Logs: buf.String()})
+	return nil
+}
+
+// containerLogsStreamCmd handles the "container-logs-stream <id>" command.
+// It writes the logs to stdout as a chunked stream; failures, including ones
+// before the first byte, are reported in the stream's trailer.
+func containerLogsStreamCmd(args []string) error {
+	stream := minion.NewChunkWriter(os.Stdout)
+	if len(args) < 1 {
+		stream.CloseWithError(minion.ErrorInfo{Command: "container-logs-stream", Code: minion.ErrCodeInvalidInput, Message: "usage: container-logs-stream <container_id>"})
+		return errInvalidArgs
+	}
+
+	reader, code, err := openContainerLogs(args[0])
+	if err != nil {
+		stream.CloseWithError(minion.ErrorInfo{Command: "container-logs-stream", Code: code, Message: err.Error()})
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(stream, reader); err != nil {
+		stream.CloseWithError(minion.ErrorInfo{Command: "container-logs-stream", Code: minion.ErrCodeInternal, Message: err.Error()})
+		return err
+	}
+	return stream.Close()
+}
+
+// openContainerLogs reads LogOptions from stdin and opens the container's
+// logs. On failure it returns the error code to report.
+func openContainerLogs(containerID string) (io.ReadCloser, string, error) {
 	ctx := context.Background()
-	containerID := args[0]
 
 	// Read options from stdin
 	var opts minion.LogOptions
-	_ = json.NewDecoder(os.Stdin).Decode(&opts)
+	_ = decodeInput(&opts)
 
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
-		outputError("container-logs", minion.ErrCodeConnectionFailed, err.Error())
-		return err
+		return nil, minion.ErrCodeConnectionFailed, err
 	}
-	defer cli.Close()
 
 	logOpts := container.LogsOptions{
 		ShowStdout: true,
@@ -427,21 +486,26 @@ func containerLogsCmd(args []string) error {
 
 	reader, err := cli.ContainerLogs(ctx, containerID, logOpts)
 	if err != nil {
+		cli.Close()
 		code := minion.ErrCodeInternal
 		if strings.Contains(err.Error(), "No such container") {
 			code = minion.ErrCodeNotFound
 		}
-		outputError("container-logs", code, err.Error())
-		return err
+		return nil, code, err
 	}
-	defer reader.Close()
+	return &clientReadCloser{ReadCloser: reader, cli: cli}, "", nil
+}
 
-	// Read logs (limit to 64KB to avoid huge responses)
-	buf := new(bytes.Buffer)
-	_, _ = io.CopyN(buf, reader, 64*1024)
+// clientReadCloser closes the Docker client along with a reader from it.
+type clientReadCloser struct {
+	io.ReadCloser
+	cli *client.Client
+}
 
-	outputSuccess(minion.LogsResult{Logs: buf.String()})
-	return nil
+func (r *clientReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cli.Close()
+	return err
 }
 
 // containerStatsCmd handles the "container-stats <id>" command.
@@ -485,6 +549,50 @@ func containerStatsCmd(args []string) error {
 	return nil
 }
 
+// updateContainerCmd handles the "update-container <id>" command.
+// Reads ContainerUpdate JSON from stdin.
+func updateContainerCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("update-container", minion.ErrCodeInvalidInput, "usage: update-container <container_id>")
+		return errInvalidArgs
+	}
+
+	ctx := context.Background()
+	containerID := args[0]
+
+	var update minion.ContainerUpdate
+	if err := decodeInput(&update); err != nil {
+		outputError("update-container", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if update.CPULimit <= 0 {
+		outputError("update-container", minion.ErrCodeInvalidInput, "cpu_limit must be positive")
+		return errInvalidArgs
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("update-container", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	updateConfig := container.UpdateConfig{
+		Resources: container.Resources{NanoCPUs: int64(update.CPULimit * 1e9)},
+	}
+	if _, err := cli.ContainerUpdate(ctx, containerID, updateConfig); err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such container") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("update-container", code, err.Error())
+		return err
+	}
+
+	outputSuccess(nil)
+	return nil
+}
+
 // =============================================================================
 // Helper Functions
 // =============================================================================
@@ -522,8 +630,10 @@ func convertContainerInspect(inspect *container.InspectResponse) *minion.Contain
 		info.Health = inspect.State.Health.Status
 	}
 
-	// Exit code
+	// Exit code and restarts
 	info.ExitCode = inspect.State.ExitCode
+	info.OOMKilled = inspect.State.OOMKilled
+	info.RestartCount = inspect.RestartCount
 
 	// Port bindings
 	if inspect.NetworkSettings != nil && len(inspect.NetworkSettings.Ports) > 0 {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// containerEventsCmd handles the "container-events" command.
+// Reads EventsOptions JSON from stdin and returns the "die" and "oom" events
+// the daemon still holds for the range. Until defaults to now so the stream
+// ends instead of following.
+func containerEventsCmd() error {
+	var opts minion.EventsOptions
+	if err := decodeInput(&opts); err != nil {
+		outputError("container-events", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if opts.Until.IsZero() {
+		opts.Until = time.Now()
+	}
+	if !opts.Since.Before(opts.Until) {
+		outputError("container-events", minion.ErrCodeInvalidInput, "since must be before until")
+		return errInvalidArgs
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("container-events", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	f := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionOOM)),
+	)
+	for k, v := range opts.Filters {
+		f.Add(k, v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	msgs, errs := cli.Events(ctx, events.ListOptions{
+		Since:   strconv.FormatInt(opts.Since.Unix(), 10),
+		Until:   strconv.FormatInt(opts.Until.Unix(), 10),
+		Filters: f,
+	})
+
+	result := []minion.ContainerLifecycleEvent{}
+	for {
+		select {
+		case msg := <-msgs:
+			result = append(result, convertLifecycleEvent(msg))
+		case err := <-errs:
+			if err != nil && !errors.Is(err, io.EOF) {
+				outputError("container-events", minion.ErrCodeInternal, err.Error())
+				return err
+			}
+			outputSuccess(result)
+			return nil
+		}
+	}
+}
+
+// convertLifecycleEvent converts a Docker event message to our format.
+func convertLifecycleEvent(msg events.Message) minion.ContainerLifecycleEvent {
+	attrs := msg.Actor.Attributes
+	ev := minion.ContainerLifecycleEvent{
+		ContainerID: msg.Actor.ID,
+		Name:        attrs["name"],
+		Action:      string(msg.Action),
+		Time:        time.Unix(0, msg.TimeNano).UTC(),
+		Labels:      map[string]string{},
+	}
+	if code, err := strconv.Atoi(attrs["exitCode"]); err == nil {
+		ev.ExitCode = code
+	}
+	for k, v := range attrs {
+		if k != "name" && k != "image" && k != "exitCode" {
+			ev.Labels[k] = v
+		}
+	}
+	return ev
+}
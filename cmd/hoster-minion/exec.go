@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// maxExecOutput bounds each of stdout and stderr returned by an exec, and
+// maxExecTimeout how long one may run, whatever the spec asks for.
+const (
+	maxExecOutput  = 1 << 20
+	maxExecTimeout = time.Hour
+)
+
+// execContainerCmd handles the "exec-container <id>" command.
+// Reads ExecSpec JSON from stdin.
+func execContainerCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("exec-container", minion.ErrCodeInvalidInput, "usage: exec-container <container_id>")
+		return errInvalidArgs
+	}
+	containerID := args[0]
+
+	var spec minion.ExecSpec
+	if err := decodeInput(&spec); err != nil {
+		outputError("exec-container", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if len(spec.Command) == 0 {
+		outputError("exec-container", minion.ErrCodeInvalidInput, "command is required")
+		return errInvalidArgs
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = time.Minute
+	}
+	spec.Timeout = min(spec.Timeout, maxExecTimeout)
+	if spec.MaxOutput <= 0 || spec.MaxOutput > maxExecOutput {
+		spec.MaxOutput = maxExecOutput
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("exec-container", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), spec.Timeout)
+	defer cancel()
+
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such container") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("exec-container", code, err.Error())
+		return err
+	}
+	if !inspect.State.Running {
+		outputError("exec-container", minion.ErrCodeNotRunning, "container is "+inspect.State.Status)
+		return errInvalidArgs
+	}
+
+	start := time.Now()
+	result, err := execCommand(ctx, cli, containerID, spec)
+	if err != nil {
+		outputError("exec-container", minion.ErrCodeInternal, "exec: "+err.Error())
+		return err
+	}
+	result.Duration = time.Since(start)
+
+	outputSuccess(result)
+	return nil
+}
+
+// execCommand runs the command in the container, unprivileged and as
+// spec.User, writing spec.Stdin to it. A command still running when ctx
+// ends is killed, and the result is marked timed out.
+func execCommand(ctx context.Context, cli *client.Client, containerID string, spec minion.ExecSpec) (minion.ExecResult, error) {
+	exec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          spec.Command,
+		User:         spec.User,
+		WorkingDir:   spec.WorkingDir,
+		Privileged:   false,
+		AttachStdin:  spec.Stdin != "",
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return minion.ExecResult{}, err
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return minion.ExecResult{}, err
+	}
+	defer attach.Close()
+
+	if spec.Stdin != "" {
+		go func() {
+			attach.Conn.Write([]byte(spec.Stdin))
+			attach.CloseWrite()
+		}()
+	}
+
+	// The hijacked connection ignores ctx, so copy in the background and
+	// close it when the deadline passes.
+	var stdout, stderr bytes.Buffer
+	outW := &limitedWriter{buf: &stdout, max: spec.MaxOutput}
+	errW := &limitedWriter{buf: &stderr, max: spec.MaxOutput}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(outW, errW, attach.Reader)
+		copied <- err
+	}()
+
+	var result minion.ExecResult
+	select {
+	case err := <-copied:
+		if err != nil {
+			return minion.ExecResult{}, err
+		}
+	case <-ctx.Done():
+		attach.Close()
+		<-copied
+		killExec(cli, exec.ID)
+		result.TimedOut = true
+		result.ExitCode = -1
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = outW.truncated || errW.truncated
+	if result.TimedOut {
+		return result, nil
+	}
+
+	state, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return minion.ExecResult{}, err
+	}
+	result.ExitCode = state.ExitCode
+	return result, nil
+}
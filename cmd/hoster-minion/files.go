@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// Files written by "write-file" live under configsDir in the minion user's
+// home, one directory per deployment, so they can be bind mounted into its
+// containers.
+const configsDir = ".hoster/configs"
+
+// writeFileCmd handles the "write-file" command.
+// It writes a file under the config directory and reports its absolute path.
+// Input is read from stdin.
+func writeFileCmd() error {
+	var in minion.WriteFileInput
+	if err := decodeInput(&in); err != nil {
+		outputError("write-file", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if err := in.Validate(); err != nil {
+		outputError("write-file", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		outputError("write-file", minion.ErrCodeInternal, "resolve home directory: "+err.Error())
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if in.Mode != 0 {
+		mode = os.FileMode(in.Mode)
+	}
+	path := filepath.Join(home, configsDir, in.Dir, in.Name)
+	changed, err := replaceFile(path, []byte(in.Content), mode)
+	if err != nil {
+		outputError("write-file", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	outputSuccess(minion.WriteFileResult{Path: path, Changed: changed, Bytes: len(in.Content)})
+	return nil
+}
+
+// replaceFile writes content to path with the given mode, creating its
+// directory. The file is replaced atomically, so readers never see half of
+// it, and left alone when it already has the content and mode. It reports
+// whether the file changed.
+func replaceFile(path string, content []byte, mode os.FileMode) (bool, error) {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() == mode.Perm() {
+			return false, nil
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(dir, ".hoster-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, fmt.Errorf("replace %s: %w", path, err)
+	}
+	return true, nil
+}
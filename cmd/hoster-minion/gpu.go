@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/gpu"
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// gpuInfoCmd handles the "gpu-info" command.
+// It reports the node's NVIDIA GPUs as nvidia-smi sees them. A node without
+// the driver succeeds with no devices and the reason in Error.
+func gpuInfoCmd() error {
+	info := minion.GPUInfo{Devices: []minion.GPUDevice{}, CollectedAt: time.Now().UTC()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu="+gpu.QueryFields, "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case errors.Is(err, exec.ErrNotFound):
+			info.Error = "nvidia-smi not found"
+		case errors.As(err, &exitErr) && len(exitErr.Stderr) > 0:
+			info.Error = "nvidia-smi: " + strings.TrimSpace(string(exitErr.Stderr))
+		default:
+			info.Error = "nvidia-smi: " + err.Error()
+		}
+		outputSuccess(info)
+		return nil
+	}
+
+	driver, devices, err := gpu.ParseNvidiaSMI(string(out))
+	if err != nil {
+		info.Error = "parse nvidia-smi output: " + err.Error()
+	} else {
+		info.Driver, info.Devices = driver, devices
+	}
+	outputSuccess(info)
+	return nil
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// managedLabel marks containers, networks, and volumes created by hoster.
+// Housekeeping never prunes them: a stopped deployment keeps its containers.
+const managedLabel = "com.hoster.managed"
+
+const (
+	daemonConfigPath      = "/etc/docker/daemon.json"
+	housekeepingExecLimit = 2 * time.Minute
+)
+
+// Defaults for the "node-housekeeping" command.
+var defaultTmpDirs = []string{"/tmp", "/var/tmp"}
+
+// nodeHousekeepingCmd handles the "node-housekeeping" command.
+// It runs the requested cleanup tasks in order and reports, per task, how many
+// objects were removed and how much space was reclaimed. With dry_run set it
+// only reports what would be removed. A failing task does not stop the rest.
+// Options are read from stdin.
+func nodeHousekeepingCmd() error {
+	var opts minion.HousekeepingOptions
+	if err := decodeInput(&opts); err != nil {
+		outputError("node-housekeeping", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("node-housekeeping", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	report := minion.HousekeepingReport{DryRun: opts.DryRun, StartedAt: time.Now().UTC()}
+	for _, task := range opts.Tasks {
+		res := minion.HousekeepingTaskResult{Task: task}
+		var err error
+		switch task {
+		case "containers":
+			err = pruneContainers(ctx, cli, opts, &res)
+		case "images":
+			err = pruneImages(ctx, cli, opts, &res)
+		case "build_cache":
+			err = pruneBuildCache(ctx, cli, opts, &res)
+		case "networks":
+			err = pruneNetworks(ctx, cli, opts, &res)
+		case "container_logs":
+			err = enforceLogRotation(ctx, cli, opts, &res)
+		case "journal":
+			err = vacuumJournal(opts, &res)
+		case "tmp":
+			err = cleanTmp(opts, &res)
+		default:
+			err = fmt.Errorf("unknown task %q", task)
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		report.ReclaimedBytes += res.ReclaimedBytes
+		report.Tasks = append(report.Tasks, res)
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	outputSuccess(report)
+	return nil
+}
+
+// pruneCutoff returns the creation time objects must predate to be pruned.
+// The zero time means no age limit.
+func pruneCutoff(opts minion.HousekeepingOptions) time.Time {
+	d, err := time.ParseDuration(opts.PruneUntil)
+	if err != nil || d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-d)
+}
+
+// pruneFilters builds the docker prune filters shared by all prune tasks.
+func pruneFilters(opts minion.HousekeepingOptions, excludeManaged bool) filters.Args {
+	f := filters.NewArgs()
+	if !pruneCutoff(opts).IsZero() {
+		f.Add("until", opts.PruneUntil)
+	}
+	if excludeManaged {
+		f.Add("label!", managedLabel)
+	}
+	return f
+}
+
+func olderThan(created, cutoff time.Time) bool {
+	return cutoff.IsZero() || created.Before(cutoff)
+}
+
+// pruneContainers removes stopped containers that hoster does not manage.
+func pruneContainers(ctx context.Context, cli *client.Client, opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if !opts.DryRun {
+		report, err := cli.ContainersPrune(ctx, pruneFilters(opts, true))
+		if err != nil {
+			return err
+		}
+		res.Items = len(report.ContainersDeleted)
+		res.ReclaimedBytes = int64(report.SpaceReclaimed)
+		return nil
+	}
+
+	stopped, err := cli.ContainerList(ctx, container.ListOptions{
+		All:  true,
+		Size: true,
+		Filters: filters.NewArgs(
+			filters.Arg("status", "created"),
+			filters.Arg("status", "exited"),
+			filters.Arg("status", "dead"),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	cutoff := pruneCutoff(opts)
+	for _, c := range stopped {
+		if _, managed := c.Labels[managedLabel]; managed || !olderThan(time.Unix(c.Created, 0), cutoff) {
+			continue
+		}
+		res.Items++
+		res.ReclaimedBytes += c.SizeRw
+	}
+	return nil
+}
+
+// pruneImages removes dangling images, or all unused images with AllImages.
+func pruneImages(ctx context.Context, cli *client.Client, opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if !opts.DryRun {
+		f := pruneFilters(opts, false)
+		f.Add("dangling", strconv.FormatBool(!opts.AllImages))
+		report, err := cli.ImagesPrune(ctx, f)
+		if err != nil {
+			return err
+		}
+		for _, d := range report.ImagesDeleted {
+			if d.Deleted != "" {
+				res.Items++
+			}
+		}
+		res.ReclaimedBytes = int64(report.SpaceReclaimed)
+		return nil
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, c := range containers {
+		used[c.ImageID] = true
+	}
+	images, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return err
+	}
+	cutoff := pruneCutoff(opts)
+	for _, img := range images {
+		dangling := len(img.RepoTags) == 0 || (len(img.RepoTags) == 1 && img.RepoTags[0] == "<none>:<none>")
+		if used[img.ID] || (!dangling && !opts.AllImages) || !olderThan(time.Unix(img.Created, 0), cutoff) {
+			continue
+		}
+		res.Items++
+		res.ReclaimedBytes += img.Size
+	}
+	// Image sizes include layers shared with other images
+	res.Detail = "estimate; shared layers may be counted more than once"
+	return nil
+}
+
+// pruneBuildCache removes build cache records not used by a running build.
+func pruneBuildCache(ctx context.Context, cli *client.Client, opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if !opts.DryRun {
+		report, err := cli.BuildCachePrune(ctx, build.CachePruneOptions{
+			All:     opts.AllImages,
+			Filters: pruneFilters(opts, false),
+		})
+		if err != nil {
+			return err
+		}
+		res.Items = len(report.CachesDeleted)
+		res.ReclaimedBytes = int64(report.SpaceReclaimed)
+		return nil
+	}
+
+	du, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		return err
+	}
+	cutoff := pruneCutoff(opts)
+	for _, rec := range du.BuildCache {
+		lastUsed := rec.CreatedAt
+		if rec.LastUsedAt != nil {
+			lastUsed = *rec.LastUsedAt
+		}
+		if rec.InUse || !olderThan(lastUsed, cutoff) {
+			continue
+		}
+		res.Items++
+		if !rec.Shared {
+			res.ReclaimedBytes += rec.Size
+		}
+	}
+	return nil
+}
+
+// pruneNetworks removes networks with no containers that hoster does not manage.
+// Networks hold no disk space, so only the count is reported.
+func pruneNetworks(ctx context.Context, cli *client.Client, opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if !opts.DryRun {
+		report, err := cli.NetworksPrune(ctx, pruneFilters(opts, true))
+		if err != nil {
+			return err
+		}
+		res.Items = len(report.NetworksDeleted)
+		return nil
+	}
+
+	networks, err := cli.NetworkList(ctx, network.ListOptions{})
+	if err != nil {
+		return err
+	}
+	cutoff := pruneCutoff(opts)
+	for _, n := range networks {
+		if _, managed := n.Labels[managedLabel]; managed || n.Ingress || n.Scope == "swarm" || !olderThan(n.Created, cutoff) {
+			continue
+		}
+		if n.Name == "bridge" || n.Name == "host" || n.Name == "none" {
+			continue
+		}
+		inspect, err := cli.NetworkInspect(ctx, n.ID, network.InspectOptions{})
+		if err != nil || len(inspect.Containers) > 0 {
+			continue
+		}
+		res.Items++
+	}
+	return nil
+}
+
+// enforceLogRotation makes json-file log rotation the daemon default and
+// truncates oversized logs of existing containers that were created without
+// rotation. Daemon defaults only apply to containers created after dockerd
+// restarts; housekeeping never restarts dockerd itself.
+func enforceLogRotation(ctx context.Context, cli *client.Client, opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if opts.LogMaxSizeBytes <= 0 || opts.LogMaxFiles <= 0 {
+		return fmt.Errorf("log_max_size_bytes and log_max_files are required")
+	}
+
+	var notes []string
+	changed, err := ensureDaemonLogOpts(opts)
+	switch {
+	case err != nil:
+		notes = append(notes, "daemon.json: "+err.Error())
+	case changed && opts.DryRun:
+		notes = append(notes, "daemon.json log-opts would be updated")
+	case changed:
+		notes = append(notes, "daemon.json log-opts updated; restart docker to apply to new containers")
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		inspect, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil || inspect.HostConfig == nil || inspect.LogPath == "" {
+			continue
+		}
+		logCfg := inspect.HostConfig.LogConfig
+		if logCfg.Type != "json-file" || logCfg.Config["max-size"] != "" {
+			continue
+		}
+		info, err := os.Stat(inspect.LogPath)
+		if err != nil || info.Size() <= opts.LogMaxSizeBytes {
+			continue
+		}
+		// dockerd appends with O_APPEND, so truncating in place is safe
+		if !opts.DryRun {
+			if err := os.Truncate(inspect.LogPath, 0); err != nil {
+				notes = append(notes, fmt.Sprintf("truncate %s: %v", strings.TrimPrefix(inspect.Name, "/"), err))
+				continue
+			}
+		}
+		res.Items++
+		res.ReclaimedBytes += info.Size()
+	}
+	res.Detail = strings.Join(notes, "; ")
+	return nil
+}
+
+// ensureDaemonLogOpts sets json-file max-size and max-file in daemon.json,
+// keeping every other setting. It reports whether the file needed a change
+// and leaves daemons configured with another log driver alone.
+func ensureDaemonLogOpts(opts minion.HousekeepingOptions) (bool, error) {
+	cfg := map[string]interface{}{}
+	data, err := os.ReadFile(daemonConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return false, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+	if driver, _ := cfg["log-driver"].(string); driver != "" && driver != "json-file" {
+		return false, nil
+	}
+
+	logOpts, _ := cfg["log-opts"].(map[string]interface{})
+	if logOpts == nil {
+		logOpts = map[string]interface{}{}
+	}
+	want := map[string]string{
+		"max-size": strconv.FormatInt(opts.LogMaxSizeBytes, 10),
+		"max-file": strconv.Itoa(opts.LogMaxFiles),
+	}
+	changed := false
+	for k, v := range want {
+		if cur, _ := logOpts[k].(string); cur != v {
+			logOpts[k] = v
+			changed = true
+		}
+	}
+	if !changed || opts.DryRun {
+		return changed, nil
+	}
+
+	cfg["log-opts"] = logOpts
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	tmp := daemonConfigPath + ".hoster-tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, daemonConfigPath); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// vacuumJournal shrinks the systemd journal to JournalMaxBytes. The dry-run
+// figure is an upper bound: only archived journal files can be removed.
+func vacuumJournal(opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if opts.JournalMaxBytes <= 0 {
+		return fmt.Errorf("journal_max_bytes is required")
+	}
+	before, err := journalDiskUsage()
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		if before > opts.JournalMaxBytes {
+			res.ReclaimedBytes = before - opts.JournalMaxBytes
+		}
+		res.Detail = fmt.Sprintf("journal uses %d bytes", before)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), housekeepingExecLimit)
+	defer cancel()
+	// Rotate first so the active files become archived and can be vacuumed
+	_ = exec.CommandContext(ctx, "journalctl", "--rotate").Run()
+	out, err := exec.CommandContext(ctx, "journalctl", "--vacuum-size="+strconv.FormatInt(opts.JournalMaxBytes, 10)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("journalctl --vacuum-size: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	after, err := journalDiskUsage()
+	if err != nil {
+		return err
+	}
+	if before > after {
+		res.ReclaimedBytes = before - after
+	}
+	res.Detail = fmt.Sprintf("journal uses %d bytes", after)
+	return nil
+}
+
+// journalUsagePattern matches "Archived and active journals take up 1.2G in the file system."
+var journalUsagePattern = regexp.MustCompile(`take up ([0-9.]+)([BKMGTPE]?)`)
+
+// journalDiskUsage returns the journal's size in bytes.
+func journalDiskUsage() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "journalctl", "--disk-usage").Output()
+	if err != nil {
+		return 0, fmt.Errorf("journalctl --disk-usage: %w", err)
+	}
+	size, ok := parseJournalUsage(string(out))
+	if !ok {
+		return 0, fmt.Errorf("unexpected journalctl output: %s", strings.TrimSpace(string(out)))
+	}
+	return size, nil
+}
+
+// parseJournalUsage parses journalctl --disk-usage output (binary units).
+func parseJournalUsage(out string) (int64, bool) {
+	m := journalUsagePattern.FindStringSubmatch(out)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	shift := strings.Index("BKMGTPE", m[2])
+	if shift < 0 {
+		shift = 0
+	}
+	return int64(n * float64(uint64(1)<<(10*shift))), true
+}
+
+// cleanTmp removes regular files not modified for TmpMaxAgeSeconds from the
+// tmp directories. It stays on each directory's filesystem, never follows
+// symlinks, and skips systemd PrivateTmp directories of running services.
+func cleanTmp(opts minion.HousekeepingOptions, res *minion.HousekeepingTaskResult) error {
+	if opts.TmpMaxAgeSeconds <= 0 {
+		return fmt.Errorf("tmp_max_age_seconds is required")
+	}
+	dirs := opts.TmpDirs
+	if len(dirs) == 0 {
+		dirs = defaultTmpDirs
+	}
+	cutoff := time.Now().Add(-time.Duration(opts.TmpMaxAgeSeconds) * time.Second)
+
+	var failed int
+	for _, dir := range dirs {
+		var rootStat syscall.Stat_t
+		if err := syscall.Lstat(dir, &rootStat); err != nil {
+			continue
+		}
+		_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if p == dir {
+					return nil
+				}
+				var st syscall.Stat_t
+				if strings.HasPrefix(d.Name(), "systemd-private-") || syscall.Lstat(p, &st) != nil || st.Dev != rootStat.Dev {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+			if !opts.DryRun {
+				if err := os.Remove(p); err != nil {
+					failed++
+					return nil
+				}
+			}
+			res.Items++
+			res.ReclaimedBytes += info.Size()
+			return nil
+		})
+	}
+	res.Detail = "cleaned " + strings.Join(dirs, ", ")
+	if failed > 0 {
+		res.Detail += fmt.Sprintf(" (%d files could not be removed)", failed)
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/artpar/hoster/internal/core/minion"
@@ -69,7 +74,7 @@ func imageExistsCmd(args []string) error {
 	}
 	defer cli.Close()
 
-	_, _, err = cli.ImageInspectWithRaw(ctx, imageName)
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		if strings.Contains(err.Error(), "No such image") {
 			outputSuccess(minion.ImageExistsResult{Exists: false})
@@ -79,6 +84,145 @@ func imageExistsCmd(args []string) error {
 		return err
 	}
 
-	outputSuccess(minion.ImageExistsResult{Exists: true})
+	outputSuccess(minion.ImageExistsResult{Exists: true, ID: inspect.ID, RepoDigests: inspect.RepoDigests})
+	return nil
+}
+
+// imageDigestCmd handles the "image-digest <image>" command. It asks the
+// image's registry which digest the tag points at, without pulling it.
+func imageDigestCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("image-digest", minion.ErrCodeInvalidInput, "usage: image-digest <image>")
+		return errInvalidArgs
+	}
+
+	ctx := context.Background()
+	imageName := args[0]
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("image-digest", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	dist, err := cli.DistributionInspect(ctx, imageName, "")
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "manifest unknown") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("image-digest", code, err.Error())
+		return err
+	}
+
+	outputSuccess(minion.ImageDigestResult{Digest: dist.Descriptor.Digest.String()})
+	return nil
+}
+
+// imageSaveCmd handles the "image-save <image>" command.
+// It writes a docker save archive of the image to stdout, so errors go to
+// stderr. With the chunked transport the archive is a chunked stream and
+// errors go in its trailer, so a save that fails halfway is never mistaken
+// for a complete archive.
+func imageSaveCmd(args []string) error {
+	var out io.Writer = os.Stdout
+	fail := outputStreamError
+	if transport().Chunked {
+		stream := minion.NewChunkWriter(os.Stdout)
+		out = stream
+		fail = func(command, code, message string) {
+			stream.CloseWithError(minion.ErrorInfo{Command: command, Code: code, Message: message})
+		}
+		defer stream.Close()
+	}
+
+	if len(args) < 1 {
+		fail("image-save", minion.ErrCodeInvalidInput, "usage: image-save <image>")
+		return errInvalidArgs
+	}
+
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		fail("image-save", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	reader, err := cli.ImageSave(ctx, []string{args[0]})
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such image") || strings.Contains(err.Error(), "not found") {
+			code = minion.ErrCodeNotFound
+		}
+		fail("image-save", code, err.Error())
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(out, reader); err != nil {
+		fail("image-save", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	return nil
+}
+
+// imageLoadCmd handles the "image-load" command.
+// It loads a docker save archive from stdin and reports its size and SHA-256.
+func imageLoadCmd() error {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("image-load", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	resp, err := cli.ImageLoad(ctx, io.TeeReader(os.Stdin, io.MultiWriter(hash, counter)), client.ImageLoadWithQuiet(true))
+	if err != nil {
+		outputError("image-load", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The daemon reports "Loaded image: <ref>" or "Loaded image ID: <id>"
+	// per image, and errors in the same stream.
+	result := minion.ImageLoadResult{}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			outputError("image-load", minion.ErrCodeInternal, err.Error())
+			return err
+		}
+		if msg.Error != "" {
+			outputError("image-load", minion.ErrCodeInternal, msg.Error)
+			return errors.New(msg.Error)
+		}
+		line := strings.TrimSpace(msg.Stream)
+		if ref, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			result.Loaded = append(result.Loaded, ref)
+		} else if ref, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			result.Loaded = append(result.Loaded, ref)
+		}
+	}
+
+	// Count anything the daemon left unread, so the checksum covers what was sent
+	if _, err := io.Copy(io.MultiWriter(hash, counter), os.Stdin); err != nil {
+		outputError("image-load", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	result.Size = counter.n
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	outputSuccess(result)
 	return nil
 }
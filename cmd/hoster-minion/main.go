@@ -9,8 +9,13 @@
 //
 // Commands:
 //
-//	version                           - Show minion version
+//	version                           - Show minion version and protocol version
 //	ping                              - Test Docker connection
+//	node-metrics                      - Node load, disk, dockerd, journal errors (JSON opts from stdin)
+//	node-housekeeping                 - Prune docker objects, rotate logs, clean tmp (JSON opts from stdin)
+//	network-addresses                 - Private and public address via metadata/STUN (JSON opts from stdin)
+//	gpu-info                          - NVIDIA GPU models, memory and utilization via nvidia-smi
+//	write-file                        - Write a file under ~/.hoster/configs for bind mounting (JSON input from stdin)
 //	create-container                  - Create a container (JSON spec from stdin)
 //	start-container <id>              - Start a container
 //	stop-container <id> [timeout_ms]  - Stop a container
@@ -18,19 +23,51 @@
 //	inspect-container <id>            - Inspect a container
 //	list-containers                   - List containers (JSON opts from stdin)
 //	container-logs <id>               - Get container logs (JSON opts from stdin)
+//	container-logs-stream <id>        - Write container logs as a chunked stream (JSON opts from stdin)
 //	container-stats <id>              - Get container resource stats
+//	update-container <id>             - Change a container's CPU limit (JSON update from stdin)
+//	probe-container <id>              - Run a TCP or command probe (JSON spec from stdin)
+//	exec-container <id>               - Run a one-off command, with its stdin (JSON spec from stdin)
+//	container-events                  - Container die/oom events in a time range (JSON opts from stdin)
+//	tunnel <id> <port>                - Relay stdin/stdout to a container port (raw)
 //	create-network                    - Create a network (JSON spec from stdin)
 //	remove-network <id>               - Remove a network
 //	connect-network <net> <container> - Connect container to network
 //	disconnect-network <net> <container> [--force] - Disconnect container
 //	create-volume                     - Create a volume (JSON spec from stdin)
 //	remove-volume <name> [--force]    - Remove a volume
+//	volume-usage <name>               - Report bytes and files stored in a volume
+//	volume-snapshot <id> <volume>     - Stage a tar archive of a volume for transfer
+//	volume-read <id> <offset> <len>   - Write staged archive bytes to stdout (raw)
+//	volume-receive <id> <offset>      - Write stdin into the stage at offset (raw)
+//	volume-staged <id>                - Report how many bytes are staged
+//	volume-restore <id> <sha256>      - Verify the stage and extract it (JSON volume spec from stdin)
+//	volume-discard <id>               - Remove a transfer's stage
+//	checkpoint-support                - Report whether CRIU checkpoint/restore is available
+//	checkpoint-create <id> <container> - Checkpoint a container (stopping it) into the stage
+//	checkpoint-unpack <id> <sha256>   - Verify a staged checkpoint and unpack it
+//	checkpoint-restore <id> <container> - Start a container from an unpacked checkpoint
+//	checkpoint-discard <id>           - Remove a transfer's checkpoint and stage
 //	pull-image <image>                - Pull an image
-//	image-exists <image>              - Check if image exists
+//	image-exists <image>              - Check if image exists and report its ID
+//	image-save <image>                - Write a docker save archive to stdout (raw, or chunked)
+//	image-load                        - Load a docker save archive from stdin (raw)
+//	audit-log                         - Read the command audit log (JSON opts from stdin)
+//
+// Commands are checked against the policy file (~/.hoster/policy.json, or
+// $HOSTER_MINION_POLICY) and recorded in ~/.hoster/audit.log (or
+// $HOSTER_MINION_AUDIT_LOG) with the caller's $HOSTER_REQUEST_ID.
+//
+// $HOSTER_MINION_TRANSPORT selects transport features: "gzip" compresses
+// large JSON responses, "chunked" frames image-save output as a chunked
+// stream. JSON input on stdin may be gzip-compressed either way.
 package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"runtime"
 
@@ -41,6 +78,7 @@ import (
 var (
 	Version   = "dev"
 	BuildTime = "unknown"
+	Commit    = "unknown"
 )
 
 func main() {
@@ -52,7 +90,7 @@ func main() {
 	cmd := os.Args[1]
 	args := os.Args[2:]
 
-	if err := dispatch(cmd, args); err != nil {
+	if err := runAudited(cmd, args); err != nil {
 		// Error already written to stdout by command handler
 		os.Exit(1)
 	}
@@ -65,21 +103,70 @@ func outputSuccess(data interface{}) {
 		outputError("internal", minion.ErrCodeInternal, err.Error())
 		return
 	}
-	json.NewEncoder(os.Stdout).Encode(resp)
+	if err := writeResponse(resp); errors.Is(err, minion.ErrPayloadTooLarge) {
+		outputError(os.Args[1], minion.ErrCodeTooLarge, err.Error())
+	}
 }
 
 // outputError writes an error response to stdout.
 func outputError(command, code, message string) {
 	resp := minion.NewErrorResponse(command, code, message)
-	json.NewEncoder(os.Stdout).Encode(resp)
+	writeResponse(resp)
+}
+
+// writeResponse writes a response envelope to stdout, compressed if the
+// backend asked for gzip. Envelopes over minion.MaxEnvelopeBytes are not
+// written.
+func writeResponse(resp *minion.Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	data, err = minion.EncodeEnvelope(append(data, '\n'), transport().Gzip)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// transport returns the transport features the backend asked for.
+func transport() minion.Transport {
+	return minion.ParseTransport(os.Getenv(minion.TransportEnv))
+}
+
+// decodeInput decodes the JSON input on stdin into v, decompressing it if
+// it is gzip-compressed. It returns io.EOF when stdin is empty.
+func decodeInput(v any) error {
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, minion.MaxEnvelopeBytes+1))
+	if err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+	if len(data) == 0 {
+		return io.EOF
+	}
+	if data, err = minion.DecodeEnvelope(data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// inputErrorCode returns the error code for input decodeInput rejected.
+func inputErrorCode(err error) string {
+	if errors.Is(err, minion.ErrPayloadTooLarge) {
+		return minion.ErrCodeTooLarge
+	}
+	return minion.ErrCodeInvalidInput
 }
 
 // versionCmd handles the "version" command.
 func versionCmd() error {
 	info := minion.VersionInfo{
-		Version:   Version,
-		BuildTime: BuildTime,
-		GoVersion: runtime.Version(),
+		Version:         Version,
+		BuildTime:       BuildTime,
+		GoVersion:       runtime.Version(),
+		ProtocolVersion: minion.Version,
+		Commit:          Commit,
 	}
 	outputSuccess(info)
 	return nil
@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"os"
 	"strings"
 
 	"github.com/artpar/hoster/internal/core/minion"
@@ -18,8 +16,8 @@ func createNetworkCmd() error {
 
 	// Read spec from stdin
 	var spec minion.NetworkSpec
-	if err := json.NewDecoder(os.Stdin).Decode(&spec); err != nil {
-		outputError("create-network", minion.ErrCodeInvalidInput, "invalid JSON input: "+err.Error())
+	if err := decodeInput(&spec); err != nil {
+		outputError("create-network", inputErrorCode(err), "invalid JSON input: "+err.Error())
 		return err
 	}
 
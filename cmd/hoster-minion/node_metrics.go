@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// Defaults for the "node-metrics" command.
+var defaultMetricMounts = []string{"/", "/var/lib/docker"}
+
+const (
+	defaultJournalSince   = "-1h"
+	defaultJournalMaxRows = 20
+	nodeCommandTimeout    = 10 * time.Second
+)
+
+// nodeMetricsCmd handles the "node-metrics" command.
+// It reports load average, memory, disk pressure, dockerd status, and recent
+// journal errors for the node itself. Options are read from stdin (optional).
+func nodeMetricsCmd() error {
+	var opts minion.NodeMetricsOptions
+	_ = decodeInput(&opts) // Ignore error - stdin may be empty
+
+	mounts := opts.Mounts
+	if len(mounts) == 0 {
+		mounts = defaultMetricMounts
+	}
+	since := opts.JournalSince
+	if since == "" {
+		since = defaultJournalSince
+	}
+	maxRows := opts.JournalMaxRows
+	if maxRows <= 0 {
+		maxRows = defaultJournalMaxRows
+	}
+
+	m := minion.NodeMetrics{
+		CPUCores:    float64(runtime.NumCPU()),
+		CollectedAt: time.Now().UTC(),
+	}
+
+	m.LoadAvg1, m.LoadAvg5, m.LoadAvg15 = readLoadAvg()
+	m.UptimeSeconds = readUptime()
+
+	if memTotal, memAvail, err := readMemInfo(); err == nil {
+		m.MemoryTotalMB = memTotal / 1024
+		m.MemoryUsedMB = (memTotal - memAvail) / 1024
+	}
+	m.CPUUsedPct = readCPUPercent()
+
+	m.Disks = readDiskUsage(mounts)
+	m.DockerdStatus = readDockerdStatus()
+	m.JournalErrors = readJournalErrors(since, maxRows)
+
+	outputSuccess(m)
+	return nil
+}
+
+// readLoadAvg parses /proc/loadavg ("0.52 0.58 0.59 1/467 12345").
+func readLoadAvg() (load1, load5, load15 float64) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0
+	}
+	load1, _ = strconv.ParseFloat(fields[0], 64)
+	load5, _ = strconv.ParseFloat(fields[1], 64)
+	load15, _ = strconv.ParseFloat(fields[2], 64)
+	return load1, load5, load15
+}
+
+// readUptime parses /proc/uptime ("350735.47 234388.90") into whole seconds.
+func readUptime() int64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	secs, _ := strconv.ParseFloat(fields[0], 64)
+	return int64(secs)
+}
+
+// readDiskUsage returns usage for each mount. Mounts that don't exist are skipped,
+// as are mounts on the same filesystem as one already reported.
+func readDiskUsage(mounts []string) []minion.DiskUsage {
+	var disks []minion.DiskUsage
+	seen := map[uint64]bool{}
+	for _, mount := range mounts {
+		var st syscall.Stat_t
+		if err := syscall.Stat(mount, &st); err != nil {
+			continue
+		}
+		if seen[uint64(st.Dev)] {
+			continue
+		}
+		seen[uint64(st.Dev)] = true
+
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mount, &stat); err != nil {
+			continue
+		}
+
+		totalBytes := stat.Blocks * uint64(stat.Bsize)
+		freeBytes := stat.Bavail * uint64(stat.Bsize)
+		disks = append(disks, minion.DiskUsage{
+			Mount:   mount,
+			TotalMB: int64(totalBytes / (1024 * 1024)),
+			UsedMB:  int64((totalBytes - freeBytes) / (1024 * 1024)),
+		})
+	}
+	return disks
+}
+
+// readDockerdStatus asks systemd whether the docker unit is active.
+// Returns "unknown" when systemctl is unavailable (e.g. non-systemd hosts).
+func readDockerdStatus() string {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeCommandTimeout)
+	defer cancel()
+
+	// is-active exits non-zero for inactive units but still prints the state
+	out, _ := exec.CommandContext(ctx, "systemctl", "is-active", "docker").Output()
+	status := strings.TrimSpace(string(out))
+	if status == "" {
+		return "unknown"
+	}
+	return status
+}
+
+// readJournalErrors returns the most recent error-priority journal entries.
+// Returns nil when journalctl is unavailable.
+func readJournalErrors(since string, maxRows int) []minion.JournalEntry {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "journalctl",
+		"-p", "err", "--since", since, "-n", strconv.Itoa(maxRows),
+		"-o", "json", "--no-pager").Output()
+	if err != nil {
+		return nil
+	}
+	return parseJournalJSON(out)
+}
+
+// parseJournalJSON parses journalctl's one-object-per-line JSON output.
+func parseJournalJSON(out []byte) []minion.JournalEntry {
+	var entries []minion.JournalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var raw struct {
+			Timestamp string      `json:"__REALTIME_TIMESTAMP"`
+			Unit      string      `json:"_SYSTEMD_UNIT"`
+			Ident     string      `json:"SYSLOG_IDENTIFIER"`
+			Message   interface{} `json:"MESSAGE"` // string, or byte array for binary messages
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			continue
+		}
+		msg, ok := raw.Message.(string)
+		if !ok {
+			continue
+		}
+		entry := minion.JournalEntry{Unit: raw.Unit, Message: msg}
+		if entry.Unit == "" {
+			entry.Unit = raw.Ident
+		}
+		if usec, err := strconv.ParseInt(raw.Timestamp, 10, 64); err == nil {
+			entry.Timestamp = time.UnixMicro(usec).UTC()
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
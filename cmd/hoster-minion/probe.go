@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// maxProbeOutput bounds the command output returned by a probe, and
+// maxProbeTimeout how long one may run, whatever the spec asks for.
+const (
+	maxProbeOutput  = 4096
+	maxProbeTimeout = 60 * time.Second
+)
+
+// probeContainerCmd handles the "probe-container <id>" command.
+// Reads ProbeSpec JSON from stdin.
+func probeContainerCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("probe-container", minion.ErrCodeInvalidInput, "usage: probe-container <container_id>")
+		return errInvalidArgs
+	}
+	containerID := args[0]
+
+	var spec minion.ProbeSpec
+	if err := decodeInput(&spec); err != nil {
+		outputError("probe-container", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if (spec.TCPPort == 0) == (len(spec.Command) == 0) {
+		outputError("probe-container", minion.ErrCodeInvalidInput, "exactly one of tcp_port and command is required")
+		return errInvalidArgs
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = 5 * time.Second
+	}
+	spec.Timeout = min(spec.Timeout, maxProbeTimeout)
+	if spec.MaxOutput <= 0 || spec.MaxOutput > maxProbeOutput {
+		spec.MaxOutput = maxProbeOutput
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("probe-container", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), spec.Timeout)
+	defer cancel()
+
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such container") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("probe-container", code, err.Error())
+		return err
+	}
+
+	start := time.Now()
+	var result minion.ProbeResult
+	switch {
+	case !inspect.State.Running:
+		result.Output = "container is " + inspect.State.Status
+	case spec.TCPPort != 0:
+		result = probeTCP(ctx, &inspect, spec.TCPPort)
+	default:
+		result = probeCommand(ctx, cli, containerID, spec)
+	}
+	result.Duration = time.Since(start)
+
+	outputSuccess(result)
+	return nil
+}
+
+// containerAddress returns the address of a container port: the
+// container's address on one of its networks, or localhost for a container
+// sharing the host network.
+func containerAddress(inspect *container.InspectResponse, port int) string {
+	host := "127.0.0.1"
+	if inspect.NetworkSettings != nil {
+		for _, n := range inspect.NetworkSettings.Networks {
+			if n != nil && n.IPAddress != "" {
+				host = n.IPAddress
+				break
+			}
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// probeTCP connects to the port on the container's address.
+func probeTCP(ctx context.Context, inspect *container.InspectResponse, port int) minion.ProbeResult {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", containerAddress(inspect, port))
+	if err != nil {
+		return minion.ProbeResult{Output: err.Error()}
+	}
+	conn.Close()
+	return minion.ProbeResult{Passed: true}
+}
+
+// probeCommand runs the command in the container, unprivileged and as
+// spec.User; it passes on exit code 0. A command still running when ctx
+// ends is killed and the probe fails as timed out.
+func probeCommand(ctx context.Context, cli *client.Client, containerID string, spec minion.ProbeSpec) minion.ProbeResult {
+	exec, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          spec.Command,
+		User:         spec.User,
+		Privileged:   false,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+	defer attach.Close()
+
+	// The hijacked connection ignores ctx, so copy in the background and
+	// close it when the deadline passes.
+	var out bytes.Buffer
+	w := &limitedWriter{buf: &out, max: spec.MaxOutput}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(w, w, attach.Reader)
+		copied <- err
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return minion.ProbeResult{Output: "exec: " + err.Error()}
+		}
+	case <-ctx.Done():
+		attach.Close()
+		<-copied
+		killExec(cli, exec.ID)
+		return minion.ProbeResult{
+			Output:    fmt.Sprintf("timed out after %s", spec.Timeout),
+			TimedOut:  true,
+			Truncated: w.truncated,
+		}
+	}
+
+	state, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+	output := strings.TrimSpace(out.String())
+	if state.ExitCode != 0 {
+		if output == "" {
+			output = fmt.Sprintf("exit code %d", state.ExitCode)
+		}
+		return minion.ProbeResult{Output: output, Truncated: w.truncated}
+	}
+	return minion.ProbeResult{Passed: true, Output: output, Truncated: w.truncated}
+}
+
+// killExec kills a timed-out probe command. Docker has no API to stop an
+// exec, but the minion runs on the host and can signal its process.
+func killExec(cli *client.Client, execID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	state, err := cli.ContainerExecInspect(ctx, execID)
+	if err != nil || !state.Running || state.Pid <= 0 {
+		return
+	}
+	syscall.Kill(state.Pid, syscall.SIGKILL)
+}
+
+// limitedWriter discards writes past max bytes so a chatty probe cannot
+// grow the response without bound.
+type limitedWriter struct {
+	buf       *bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	room := w.max - w.buf.Len()
+	if len(p) > room {
+		w.truncated = true
+	}
+	if room > 0 {
+		w.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
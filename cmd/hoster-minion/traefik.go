@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/traefik"
+)
+
+// traefikConfigCmd handles the "traefik-config" command.
+// It writes a Traefik dynamic configuration file for the file provider. The
+// file is replaced atomically, so Traefik never reads half of it, and left
+// alone when it already has the content, so Traefik does not reload.
+// Input is read from stdin.
+func traefikConfigCmd() error {
+	var in minion.TraefikConfigInput
+	if err := decodeInput(&in); err != nil {
+		outputError("traefik-config", inputErrorCode(err), "invalid JSON input: "+err.Error())
+		return err
+	}
+	if err := traefik.ValidateConfigPath(in.Path); err != nil {
+		outputError("traefik-config", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+
+	changed, err := replaceFile(in.Path, []byte(in.Content), 0644)
+	if err != nil {
+		outputError("traefik-config", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	outputSuccess(minion.TraefikConfigResult{Path: in.Path, Changed: changed, Bytes: len(in.Content)})
+	return nil
+}
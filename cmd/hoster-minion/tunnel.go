@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/client"
+)
+
+// tunnelDialTimeout bounds connecting to the container port.
+const tunnelDialTimeout = 10 * time.Second
+
+// tunnelCmd handles "tunnel <container_id> <port>". It connects to the port
+// on the container's address and relays stdin to it and its replies to
+// stdout, raw, until the container closes the connection. The end of stdin
+// half-closes the connection, so the container still sees a clean EOF.
+//
+// Once the connection is up stdout carries only relayed bytes, so errors
+// go to stderr.
+func tunnelCmd(args []string) error {
+	if len(args) < 2 {
+		outputStreamError("tunnel", minion.ErrCodeInvalidInput, "usage: tunnel <container_id> <port>")
+		return errInvalidArgs
+	}
+	containerID := args[0]
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port < 1 || port > 65535 {
+		outputStreamError("tunnel", minion.ErrCodeInvalidInput, "port must be between 1 and 65535")
+		return errInvalidArgs
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputStreamError("tunnel", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tunnelDialTimeout)
+	defer cancel()
+
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "No such container") {
+			code = minion.ErrCodeNotFound
+		}
+		outputStreamError("tunnel", code, err.Error())
+		return err
+	}
+	if !inspect.State.Running {
+		outputStreamError("tunnel", minion.ErrCodeNotRunning, "container is "+inspect.State.Status)
+		return errInvalidArgs
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", containerAddress(&inspect, port))
+	if err != nil {
+		outputStreamError("tunnel", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+	}()
+	// The relay ends when the container closes its side; a failure after
+	// this point is a closed tunnel, not a command error.
+	io.Copy(os.Stdout, conn)
+	return nil
+}
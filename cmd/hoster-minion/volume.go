@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"os"
+	"io/fs"
+	"path/filepath"
 	"strings"
 
 	"github.com/artpar/hoster/internal/core/minion"
@@ -18,8 +18,8 @@ func createVolumeCmd() error {
 
 	// Read spec from stdin
 	var spec minion.VolumeSpec
-	if err := json.NewDecoder(os.Stdin).Decode(&spec); err != nil {
-		outputError("create-volume", minion.ErrCodeInvalidInput, "invalid JSON input: "+err.Error())
+	if err := decodeInput(&spec); err != nil {
+		outputError("create-volume", inputErrorCode(err), "invalid JSON input: "+err.Error())
 		return err
 	}
 
@@ -87,3 +87,56 @@ func removeVolumeCmd(args []string) error {
 	outputSuccess(nil)
 	return nil
 }
+
+// volumeUsageCmd handles "volume-usage <volume_name>".
+// It reports the bytes and regular files stored in the volume.
+func volumeUsageCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("volume-usage", minion.ErrCodeInvalidInput, "usage: volume-usage <volume_name>")
+		return errInvalidArgs
+	}
+
+	ctx := context.Background()
+	volumeName := args[0]
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("volume-usage", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	root, err := volumeMountpoint(ctx, cli, volumeName)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "no such volume") || strings.Contains(err.Error(), "not found") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("volume-usage", code, err.Error())
+		return err
+	}
+
+	usage := minion.VolumeUsage{Volume: volumeName}
+	err = filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Bytes += info.Size()
+		usage.Files++
+		return nil
+	})
+	if err != nil {
+		outputError("volume-usage", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	outputSuccess(usage)
+	return nil
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// Volume transfers stage a tar archive of the volume under stagingDir. The
+// source writes the whole archive once ("volume-snapshot"); the backend then
+// copies it in chunks ("volume-read" on the source, "volume-receive" on the
+// target) and the target verifies and extracts it ("volume-restore").
+//
+// volume-read writes raw archive bytes to stdout, so its errors go to stderr.
+
+const stagingDir = ".hoster/staging"
+
+// stagePaths returns the archive and snapshot metadata paths for a transfer.
+func stagePaths(transferID string) (archivePath, metaPath string, err error) {
+	if err := transfer.ValidateTransferID(transferID); err != nil {
+		return "", "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	dir := filepath.Join(home, stagingDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, transferID+".tar"), filepath.Join(dir, transferID+".json"), nil
+}
+
+// volumeMountpoint returns the host path of a volume's data.
+func volumeMountpoint(ctx context.Context, cli *client.Client, name string) (string, error) {
+	vol, err := cli.VolumeInspect(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if vol.Mountpoint == "" {
+		return "", fmt.Errorf("volume %s has no local mountpoint (driver %s)", name, vol.Driver)
+	}
+	return vol.Mountpoint, nil
+}
+
+// volumeSnapshotCmd handles "volume-snapshot <transfer_id> <volume>".
+// It archives the volume into the stage, or returns the existing stage.
+func volumeSnapshotCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("volume-snapshot", minion.ErrCodeInvalidInput, "usage: volume-snapshot <transfer_id> <volume>")
+		return errInvalidArgs
+	}
+	transferID, volumeName := args[0], args[1]
+
+	archivePath, metaPath, err := stagePaths(transferID)
+	if err != nil {
+		outputError("volume-snapshot", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+
+	// Reuse a complete stage so resumed transfers see the same archive.
+	if snap, ok := readSnapshotMeta(archivePath, metaPath); ok && snap.Volume == volumeName {
+		outputSuccess(snap)
+		return nil
+	}
+
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("volume-snapshot", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	root, err := volumeMountpoint(ctx, cli, volumeName)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if strings.Contains(err.Error(), "no such volume") || strings.Contains(err.Error(), "not found") {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("volume-snapshot", code, err.Error())
+		return err
+	}
+
+	snap, err := writeVolumeArchive(root, archivePath)
+	if err != nil {
+		outputError("volume-snapshot", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	snap.TransferID = transferID
+	snap.Volume = volumeName
+
+	meta, _ := json.Marshal(snap)
+	if err := os.WriteFile(metaPath, meta, 0o600); err != nil {
+		outputError("volume-snapshot", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	outputSuccess(snap)
+	return nil
+}
+
+// readSnapshotMeta loads the metadata of a complete stage.
+func readSnapshotMeta(archivePath, metaPath string) (minion.VolumeSnapshot, bool) {
+	var snap minion.VolumeSnapshot
+	data, err := os.ReadFile(metaPath)
+	if err != nil || json.Unmarshal(data, &snap) != nil {
+		return snap, false
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil || info.Size() != snap.Size {
+		return snap, false
+	}
+	return snap, true
+}
+
+// writeVolumeArchive tars root into archivePath via a temp file and returns
+// the archive size, checksum and manifest.
+func writeVolumeArchive(root, archivePath string) (minion.VolumeSnapshot, error) {
+	var snap minion.VolumeSnapshot
+
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), ".snapshot-*")
+	if err != nil {
+		return snap, err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	archiveHash := sha256.New()
+	counter := &countingWriter{}
+	tw := tar.NewWriter(io.MultiWriter(tmp, archiveHash, counter))
+
+	var entries []transfer.ManifestEntry
+	err = walkVolume(root, func(full string, info fs.FileInfo, e *transfer.ManifestEntry) error {
+		hdr, err := tar.FileInfoHeader(info, e.Link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.Path
+		if e.Type == transfer.EntryDir {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Format = tar.FormatPAX
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if e.Type == transfer.EntryFile {
+			f, err := os.Open(full)
+			if err != nil {
+				return err
+			}
+			fileHash := sha256.New()
+			n, err := io.Copy(io.MultiWriter(tw, fileHash), f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if n != e.Size {
+				return fmt.Errorf("%s changed size while archiving; stop the deployment first", e.Path)
+			}
+			e.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+			snap.DataBytes += n
+		}
+		entries = append(entries, *e)
+		return nil
+	})
+	if err != nil {
+		tmp.Close()
+		return snap, err
+	}
+	if err := tw.Close(); err != nil {
+		tmp.Close()
+		return snap, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return snap, err
+	}
+	if err := tmp.Close(); err != nil {
+		return snap, err
+	}
+	if err := os.Rename(tmp.Name(), archivePath); err != nil {
+		return snap, err
+	}
+
+	snap.Size = counter.n
+	snap.SHA256 = hex.EncodeToString(archiveHash.Sum(nil))
+	snap.Files = len(entries)
+	snap.ManifestDigest = transfer.ManifestDigest(entries)
+	snap.CreatedAt = time.Now().UTC()
+	return snap, nil
+}
+
+// walkVolume calls fn for every directory, regular file and symlink under root
+// in lexical order, with its manifest entry filled in except for SHA256.
+// Sockets, devices and pipes are skipped.
+func walkVolume(root string, fn func(full string, info fs.FileInfo, e *transfer.ManifestEntry) error) error {
+	return filepath.WalkDir(root, func(full string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if full == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+		e := transfer.ManifestEntry{Path: filepath.ToSlash(rel), Mode: uint32(info.Mode().Perm())}
+		switch {
+		case info.Mode().IsRegular():
+			e.Type = transfer.EntryFile
+			e.Size = info.Size()
+		case info.IsDir():
+			e.Type = transfer.EntryDir
+		case info.Mode()&fs.ModeSymlink != 0:
+			e.Type = transfer.EntrySymlink
+			if e.Link, err = os.Readlink(full); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		return fn(full, info, &e)
+	})
+}
+
+// volumeReadCmd handles "volume-read <transfer_id> <offset> <length>".
+// It writes length bytes of the staged archive starting at offset to stdout.
+func volumeReadCmd(args []string) error {
+	if len(args) < 3 {
+		outputStreamError("volume-read", minion.ErrCodeInvalidInput, "usage: volume-read <transfer_id> <offset> <length>")
+		return errInvalidArgs
+	}
+	offset, err1 := strconv.ParseInt(args[1], 10, 64)
+	length, err2 := strconv.ParseInt(args[2], 10, 64)
+	if err1 != nil || err2 != nil || offset < 0 || length < 0 {
+		outputStreamError("volume-read", minion.ErrCodeInvalidInput, "offset and length must be non-negative integers")
+		return errInvalidArgs
+	}
+
+	archivePath, _, err := stagePaths(args[0])
+	if err != nil {
+		outputStreamError("volume-read", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if errors.Is(err, fs.ErrNotExist) {
+			code = minion.ErrCodeNotFound
+		}
+		outputStreamError("volume-read", code, err.Error())
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		outputStreamError("volume-read", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if _, err := io.CopyN(os.Stdout, f, length); err != nil {
+		outputStreamError("volume-read", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	return nil
+}
+
+// volumeReceiveCmd handles "volume-receive <transfer_id> <offset>".
+// It writes stdin into the stage at offset, dropping anything staged after it.
+func volumeReceiveCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("volume-receive", minion.ErrCodeInvalidInput, "usage: volume-receive <transfer_id> <offset>")
+		return errInvalidArgs
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 {
+		outputError("volume-receive", minion.ErrCodeInvalidInput, "offset must be a non-negative integer")
+		return errInvalidArgs
+	}
+
+	archivePath, _, err := stagePaths(args[0])
+	if err != nil {
+		outputError("volume-receive", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	f, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		outputError("volume-receive", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		outputError("volume-receive", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if info.Size() < offset {
+		msg := fmt.Sprintf("offset %d is past the staged size %d", offset, info.Size())
+		outputError("volume-receive", minion.ErrCodeInvalidInput, msg)
+		return errors.New(msg)
+	}
+	if err := f.Truncate(offset); err != nil {
+		outputError("volume-receive", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		outputError("volume-receive", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), os.Stdin)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		outputError("volume-receive", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	outputSuccess(minion.VolumeChunkResult{
+		Offset:     offset,
+		Written:    n,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		StagedSize: offset + n,
+	})
+	return nil
+}
+
+// volumeStagedCmd handles "volume-staged <transfer_id>".
+func volumeStagedCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("volume-staged", minion.ErrCodeInvalidInput, "usage: volume-staged <transfer_id>")
+		return errInvalidArgs
+	}
+	archivePath, _, err := stagePaths(args[0])
+	if err != nil {
+		outputError("volume-staged", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+
+	result := minion.VolumeStageInfo{TransferID: args[0]}
+	if info, err := os.Stat(archivePath); err == nil {
+		result.Size = info.Size()
+	}
+	outputSuccess(result)
+	return nil
+}
+
+// volumeRestoreCmd handles "volume-restore <transfer_id> <sha256>".
+// Reads the target VolumeSpec JSON from stdin. The staged archive must match
+// sha256; the volume is created if missing and its contents are replaced.
+func volumeRestoreCmd(args []string) error {
+	if len(args) < 2 {
+		outputError("volume-restore", minion.ErrCodeInvalidInput, "usage: volume-restore <transfer_id> <sha256>")
+		return errInvalidArgs
+	}
+	transferID, expected := args[0], args[1]
+
+	var spec minion.VolumeSpec
+	if err := decodeInput(&spec); err != nil || spec.Name == "" {
+		outputError("volume-restore", minion.ErrCodeInvalidInput, "volume spec with a name is required on stdin")
+		return errInvalidArgs
+	}
+
+	archivePath, _, err := stagePaths(transferID)
+	if err != nil {
+		outputError("volume-restore", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+
+	size, sum, err := fileChecksum(archivePath)
+	if err != nil {
+		code := minion.ErrCodeInternal
+		if errors.Is(err, fs.ErrNotExist) {
+			code = minion.ErrCodeNotFound
+		}
+		outputError("volume-restore", code, err.Error())
+		return err
+	}
+	if sum != expected {
+		// A corrupt stage cannot be resumed; drop it so the next attempt starts over.
+		os.Remove(archivePath)
+		msg := fmt.Sprintf("staged archive checksum %s does not match %s", sum, expected)
+		outputError("volume-restore", minion.ErrCodeChecksumMismatch, msg)
+		return errors.New(msg)
+	}
+
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		outputError("volume-restore", minion.ErrCodeConnectionFailed, err.Error())
+		return err
+	}
+	defer cli.Close()
+
+	root, err := volumeMountpoint(ctx, cli, spec.Name)
+	if err != nil {
+		driver := spec.Driver
+		if driver == "" {
+			driver = "local"
+		}
+		if _, err := cli.VolumeCreate(ctx, volume.CreateOptions{Name: spec.Name, Driver: driver, Labels: spec.Labels}); err != nil {
+			outputError("volume-restore", minion.ErrCodeInternal, err.Error())
+			return err
+		}
+		if root, err = volumeMountpoint(ctx, cli, spec.Name); err != nil {
+			outputError("volume-restore", minion.ErrCodeInternal, err.Error())
+			return err
+		}
+	}
+
+	if err := clearDir(root); err != nil {
+		outputError("volume-restore", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+	if err := extractArchive(archivePath, root); err != nil {
+		outputError("volume-restore", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	// Verify what is on disk now, not what was in the archive.
+	var entries []transfer.ManifestEntry
+	var dataBytes int64
+	err = walkVolume(root, func(full string, _ fs.FileInfo, e *transfer.ManifestEntry) error {
+		if e.Type == transfer.EntryFile {
+			n, sum, err := fileChecksum(full)
+			if err != nil {
+				return err
+			}
+			e.SHA256 = sum
+			dataBytes += n
+		}
+		entries = append(entries, *e)
+		return nil
+	})
+	if err != nil {
+		outputError("volume-restore", minion.ErrCodeInternal, err.Error())
+		return err
+	}
+
+	outputSuccess(minion.VolumeRestoreResult{
+		Volume:         spec.Name,
+		Size:           size,
+		SHA256:         sum,
+		Files:          len(entries),
+		DataBytes:      dataBytes,
+		ManifestDigest: transfer.ManifestDigest(entries),
+	})
+	return nil
+}
+
+// volumeDiscardCmd handles "volume-discard <transfer_id>". Missing stages are not an error.
+func volumeDiscardCmd(args []string) error {
+	if len(args) < 1 {
+		outputError("volume-discard", minion.ErrCodeInvalidInput, "usage: volume-discard <transfer_id>")
+		return errInvalidArgs
+	}
+	archivePath, metaPath, err := stagePaths(args[0])
+	if err != nil {
+		outputError("volume-discard", minion.ErrCodeInvalidInput, err.Error())
+		return err
+	}
+	for _, p := range []string{archivePath, metaPath} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			outputError("volume-discard", minion.ErrCodeInternal, err.Error())
+			return err
+		}
+	}
+	outputSuccess(nil)
+	return nil
+}
+
+// fileChecksum returns the size and SHA-256 of a file.
+func fileChecksum(p string) (int64, string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// clearDir removes everything inside dir but not dir itself.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive unpacks a volume archive into root. Entries may not escape
+// root. Directory modes and times are applied last so read-only directories
+// can still be filled. Ownership is restored when running as root.
+func extractArchive(archivePath, root string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var dirs []*tar.Header
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimSuffix(hdr.Name, "/"))
+		if name == "." || name == ".." || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry %q escapes the volume", hdr.Name)
+		}
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if err := checkInsideRoot(root, filepath.Dir(target)); err != nil {
+			return fmt.Errorf("archive entry %q: %w", hdr.Name, err)
+		}
+		mode := fs.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+			continue
+		case tar.TypeReg:
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		_ = os.Lchown(target, hdr.Uid, hdr.Gid) // best effort when not running as root
+		if hdr.Typeflag != tar.TypeSymlink {
+			_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+
+	// Deepest directories first so parents' times are not bumped afterwards.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		hdr := dirs[i]
+		target := filepath.Join(root, filepath.FromSlash(path.Clean(strings.TrimSuffix(hdr.Name, "/"))))
+		if err := os.Chmod(target, fs.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+		_ = os.Lchown(target, hdr.Uid, hdr.Gid)
+		_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
+
+// checkInsideRoot rejects a directory that resolves outside root through a
+// symlink extracted earlier.
+func checkInsideRoot(root, dir string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // created below by MkdirAll, inside root
+	}
+	if err != nil {
+		return err
+	}
+	if resolved != resolvedRoot && !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return errors.New("path escapes the volume through a symlink")
+	}
+	return nil
+}
+
+// outputStreamError writes an error response to stderr, for commands whose
+// stdout carries raw data.
+func outputStreamError(command, code, message string) {
+	resp := minion.NewErrorResponse(command, code, message)
+	json.NewEncoder(os.Stderr).Encode(resp)
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
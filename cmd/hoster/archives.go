@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/archive"
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// runArchives implements `hoster archives [--run] [--table name] [--limit n] [--json] [-config path]`.
+// It lists event archive files for audit, and with --run archives eligible rows first.
+func runArchives(args []string) int {
+	fs := flag.NewFlagSet("archives", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	runNow := fs.Bool("run", false, "Archive events older than archive.retention before listing")
+	table := fs.String("table", "", "Only list archives of this table (usage_events, container_events)")
+	limit := fs.Int("limit", 100, "Maximum number of archives to list")
+	asJSON := fs.Bool("json", false, "Print the listing as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+	if *table != "" && !archive.ValidTable(*table) {
+		fmt.Fprintf(os.Stderr, "unknown table %q\n", *table)
+		return ExitConfigError
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+
+	logger := SetupLogger(cfg)
+	store, err := engine.OpenDB(cfg.Database.DSN, engine.Schema(), logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return ExitDatabaseError
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if *runNow {
+		cutoff := archive.Cutoff(time.Now(), cfg.Archive.Retention)
+		for _, t := range archive.Tables {
+			if *table != "" && t != *table {
+				continue
+			}
+			res, err := store.ArchiveEvents(ctx, cfg.Archive.Dir, t, cutoff, cfg.Archive.BatchSize)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "archive %s: %v\n", t, err)
+				return ExitDatabaseError
+			}
+			fmt.Fprintf(os.Stderr, "%s: archived %d rows into %d files (before %s)\n",
+				t, res.Rows, res.Files, cutoff.Format(time.DateOnly))
+		}
+	}
+
+	archives, err := store.ListEventArchives(ctx, *table, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "archives: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	if *asJSON {
+		if archives == nil {
+			archives = []engine.EventArchive{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(archives)
+		return ExitSuccess
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS\tIDS\tFROM\tTO\tBYTES\tFILE")
+	for _, a := range archives {
+		fmt.Fprintf(w, "%s\t%d\t%d-%d\t%s\t%s\t%d\t%s\n",
+			a.TableName, a.RowCount, a.FirstID, a.LastID, a.FirstTimestamp, a.LastTimestamp, a.Bytes, a.FilePath)
+	}
+	w.Flush()
+	fmt.Printf("%d archives in %s\n", len(archives), cfg.Archive.Dir)
+	return ExitSuccess
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	coreauth "github.com/artpar/hoster/internal/core/auth"
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// =============================================================================
+// Auth Backends
+// =============================================================================
+
+// Auth backend names, as listed in auth.backends.
+const (
+	authBackendSharedSecret = "shared_secret"
+	authBackendAPIToken     = "api_token"
+	authBackendOIDC         = "oidc"
+	authBackendMTLS         = "mtls"
+)
+
+var authBackends = []string{authBackendSharedSecret, authBackendAPIToken, authBackendOIDC, authBackendMTLS}
+
+// validateAuthBackends checks auth.backends and the settings of the backends
+// it lists.
+func validateAuthBackends(c *Config) error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	seen := map[string]bool{}
+	for _, b := range c.Auth.Backends {
+		switch {
+		case !slices.Contains(authBackends, b):
+			fail("auth.backends", "unknown backend %q (want shared_secret, api_token, oidc or mtls)", b)
+		case seen[b]:
+			fail("auth.backends", "lists %s twice", b)
+		}
+		seen[b] = true
+	}
+
+	if seen[authBackendOIDC] {
+		if c.Auth.OIDC.Issuer == "" {
+			fail("auth.oidc.issuer", "is required by the oidc backend")
+		}
+		if c.Auth.OIDC.Audience == "" {
+			fail("auth.oidc.audience", "is required by the oidc backend")
+		}
+	}
+	if seen[authBackendMTLS] {
+		if c.Server.TLSCertFile == "" {
+			fail("server.tls_cert_file", "is required by the mtls backend")
+		}
+		if c.Auth.MTLS.ClientCAFile == "" {
+			fail("auth.mtls.client_ca_file", "is required by the mtls backend")
+		}
+	}
+	if _, err := coreauth.ParseCertIdentity(c.Auth.MTLS.Identity); err != nil {
+		fail("auth.mtls.identity", "%v", err)
+	}
+	return errors.Join(errs...)
+}
+
+// newAuthenticators builds the authenticators auth.backends lists, in its
+// order.
+func newAuthenticators(cfg AuthConfig, store *engine.Store) []engine.Authenticator {
+	var out []engine.Authenticator
+	for _, b := range cfg.Backends {
+		switch b {
+		case authBackendSharedSecret:
+			out = append(out, engine.NewSharedSecretAuthenticator(store, cfg.SharedSecret))
+		case authBackendAPIToken:
+			out = append(out, engine.NewAPITokenAuthenticator(store))
+		case authBackendOIDC:
+			out = append(out, engine.NewOIDCAuthenticator(store, engine.OIDCConfig{
+				Issuer:    cfg.OIDC.Issuer,
+				Audience:  cfg.OIDC.Audience,
+				JWKSURL:   cfg.OIDC.JWKSURL,
+				Cookie:    cfg.OIDC.Cookie,
+				UserClaim: cfg.OIDC.UserClaim,
+				PlanClaim: cfg.OIDC.PlanClaim,
+			}))
+		case authBackendMTLS:
+			identity, _ := coreauth.ParseCertIdentity(cfg.MTLS.Identity)
+			out = append(out, engine.NewClientCertAuthenticator(store, identity))
+		}
+	}
+	return out
+}
+
+// newServerTLSConfig returns the API server's TLS config, or nil to serve
+// HTTP. With the mtls backend, clients may present a certificate, which is
+// verified against auth.mtls.client_ca_file.
+func newServerTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.Server.TLSCertFile == "" {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if slices.Contains(cfg.Auth.Backends, authBackendMTLS) {
+		pem, err := os.ReadFile(cfg.Auth.MTLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("read client CA: no certificates in %s", cfg.Auth.MTLS.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
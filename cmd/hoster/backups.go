@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/backup"
+	"github.com/artpar/hoster/internal/engine"
+	"github.com/artpar/hoster/internal/shell/storage"
+)
+
+// newBackupRemote returns the S3 location backups are uploaded to, or nil
+// when backup.s3_bucket is not set.
+func newBackupRemote(cfg *Config) (engine.BackupRemote, error) {
+	if cfg.Backup.S3Bucket == "" {
+		return nil, nil
+	}
+	s3Cfg := s3Config(cfg.Storage)
+	s3Cfg.IAM = false
+	s3Cfg.Timeout = cfg.Backup.Timeout
+	provider, err := storage.NewS3Provider(s3Cfg)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	return provider.Objects(cfg.Backup.S3Bucket, cfg.Backup.S3Prefix), nil
+}
+
+func backupRetention(cfg BackupConfig) backup.Retention {
+	return backup.Retention{Keep: cfg.Keep, MaxAge: cfg.MaxAge}
+}
+
+// runBackups implements `hoster backups [--run] [--remote] [--json] [-config path]`.
+// It lists database backups, and with --run takes one first.
+func runBackups(args []string) int {
+	fs := flag.NewFlagSet("backups", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	runNow := fs.Bool("run", false, "Back up the database (and upload it) before listing")
+	fromRemote := fs.Bool("remote", false, "List the backups in backup.s3_bucket instead of backup.dir")
+	asJSON := fs.Bool("json", false, "Print the listing as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+	remote, err := newBackupRemote(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+	if *fromRemote && remote == nil {
+		fmt.Fprintln(os.Stderr, "--remote requires backup.s3_bucket")
+		return ExitConfigError
+	}
+
+	ctx := context.Background()
+	if *runNow {
+		logger := SetupLogger(cfg)
+		store, err := engine.OpenDB(cfg.Database.DSN, engine.Schema(), logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+			return ExitDatabaseError
+		}
+		m, err := store.Backup(ctx, cfg.Backup.Dir, Version)
+		store.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+			return ExitDatabaseError
+		}
+		fmt.Fprintf(os.Stderr, "backed up %s (%d bytes, %d compressed)\n", m.ID, m.DatabaseBytes, m.Bytes)
+		if remote != nil {
+			if err := engine.UploadBackup(ctx, remote, cfg.Backup.Dir, m); err != nil {
+				fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+				return ExitDatabaseError
+			}
+			fmt.Fprintf(os.Stderr, "uploaded %s to s3://%s/%s\n", m.ID, cfg.Backup.S3Bucket, cfg.Backup.S3Prefix)
+		}
+	}
+
+	var manifests []backup.Manifest
+	location := cfg.Backup.Dir
+	if *fromRemote {
+		manifests, err = engine.ListRemoteBackups(ctx, remote)
+		location = "s3://" + cfg.Backup.S3Bucket + "/" + cfg.Backup.S3Prefix
+	} else {
+		manifests, err = engine.ListBackups(cfg.Backup.Dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backups: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	if *asJSON {
+		if manifests == nil {
+			manifests = []backup.Manifest{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(manifests)
+		return ExitSuccess
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tDATABASE BYTES\tBYTES\tVERSION")
+	for _, m := range manifests {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n",
+			m.ID, m.CreatedAt.Format(time.RFC3339), m.DatabaseBytes, m.Bytes, m.Version)
+	}
+	w.Flush()
+	fmt.Printf("%d backups in %s\n", len(manifests), location)
+	return ExitSuccess
+}
+
+// runRestore implements `hoster restore (--backup id | --at time) [--force] [-config path]`.
+// It replaces the database with a backup from backup.dir, downloading it from
+// backup.s3_bucket when it is only there. The server must be stopped.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	id := fs.String("backup", "", "ID of the backup to restore, as listed by hoster backups")
+	at := fs.String("at", "", "Restore the newest backup taken at or before this RFC 3339 time")
+	force := fs.Bool("force", false, "Restore even though the server port is accepting connections")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+	if (*id == "") == (*at == "") {
+		fmt.Fprintln(os.Stderr, "restore requires exactly one of --backup or --at")
+		return ExitConfigError
+	}
+	if *id != "" {
+		if _, err := backup.ParseID(*id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitConfigError
+		}
+	}
+	var atTime time.Time
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --at %q: want RFC 3339, e.g. 2025-03-01T12:00:00Z\n", *at)
+			return ExitConfigError
+		}
+		atTime = t
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+	remote, err := newBackupRemote(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+
+	if addr := serverProbeAddress(cfg.Server); !*force && portOpen(addr) {
+		fmt.Fprintf(os.Stderr, "a server is listening on %s; stop hoster before restoring (or pass --force)\n", addr)
+		return ExitDatabaseError
+	}
+
+	ctx := context.Background()
+	manifests, err := engine.ListBackups(cfg.Backup.Dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backups: %v\n", err)
+		return ExitDatabaseError
+	}
+	local := make(map[string]bool, len(manifests))
+	for _, m := range manifests {
+		local[m.ID] = true
+	}
+	if remote != nil {
+		remoteManifests, err := engine.ListRemoteBackups(ctx, remote)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backups: %v\n", err)
+			return ExitDatabaseError
+		}
+		for _, m := range remoteManifests {
+			if !local[m.ID] {
+				manifests = append(manifests, m)
+			}
+		}
+	}
+
+	var chosen backup.Manifest
+	if *id != "" {
+		found := false
+		for _, m := range manifests {
+			if m.ID == *id {
+				chosen, found = m, true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "backup %s not found\n", *id)
+			return ExitDatabaseError
+		}
+	} else {
+		chosen, err = backup.PointInTime(manifests, atTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitDatabaseError
+		}
+	}
+
+	if !local[chosen.ID] {
+		fmt.Fprintf(os.Stderr, "downloading %s from s3://%s/%s\n", chosen.ID, cfg.Backup.S3Bucket, cfg.Backup.S3Prefix)
+		if err := engine.FetchBackup(ctx, remote, cfg.Backup.Dir, chosen); err != nil {
+			fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+			return ExitDatabaseError
+		}
+	}
+
+	aside, err := engine.RestoreDatabase(ctx, cfg.Database.DSN, cfg.Backup.Dir, chosen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		if aside != "" {
+			fmt.Fprintf(os.Stderr, "the previous database was moved to %s\n", aside)
+		}
+		return ExitDatabaseError
+	}
+	fmt.Printf("restored backup %s (taken %s) into %s\n", chosen.ID, chosen.CreatedAt.Format(time.RFC3339), cfg.Database.DSN)
+	if aside != "" {
+		fmt.Printf("the previous database was moved to %s\n", aside)
+	}
+	return ExitSuccess
+}
+
+// serverProbeAddress returns the address a local server would answer on.
+func serverProbeAddress(cfg ServerConfig) string {
+	host := cfg.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+}
+
+func portOpen(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
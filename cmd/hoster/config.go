@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/artpar/hoster/internal/core/coordination"
+	"github.com/artpar/hoster/internal/shell/logging"
 	"github.com/spf13/viper"
 )
 
@@ -26,6 +28,25 @@ type Config struct {
 	Billing  BillingConfig  `mapstructure:"billing"`
 	Nodes    NodesConfig    `mapstructure:"nodes"`
 	Proxy    ProxyConfig    `mapstructure:"proxy"`
+	Secrets  SecretsConfig  `mapstructure:"secrets"`
+	Archive  ArchiveConfig  `mapstructure:"archive"`
+	Backup   BackupConfig   `mapstructure:"backup"`
+	Storage  StorageConfig  `mapstructure:"storage"`
+	Registry RegistryConfig `mapstructure:"registry"`
+	Uploads  UploadsConfig  `mapstructure:"uploads"`
+	ReadOnly ReadOnlyConfig `mapstructure:"read_only"`
+	Chaos    ChaosConfig    `mapstructure:"chaos"`
+
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	Replication   ReplicationConfig   `mapstructure:"replication"`
+	Playground    PlaygroundConfig    `mapstructure:"playground"`
+	Tunnel        TunnelConfig        `mapstructure:"tunnel"`
+	Exec          ExecConfig          `mapstructure:"exec"`
+	ACME          ACMEConfig          `mapstructure:"acme"`
+
+	// Warnings name config file keys and HOSTER_ environment variables that
+	// match no config key, and so were ignored.
+	Warnings []string `mapstructure:"-"`
 }
 
 // ServerConfig holds HTTP server configuration.
@@ -35,6 +56,25 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout    time.Duration `mapstructure:"write_timeout"`
 	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// IdempotencyTTL is how long Idempotency-Key responses are kept for replay.
+	IdempotencyTTL time.Duration `mapstructure:"idempotency_ttl"`
+	// APIV1DeprecatedAt and APIV1SunsetAt schedule the retirement of /api/v1
+	// (YYYY-MM-DD or RFC 3339). Empty keeps v1 current.
+	APIV1DeprecatedAt string `mapstructure:"api_v1_deprecated_at"`
+	APIV1SunsetAt     string `mapstructure:"api_v1_sunset_at"`
+	// ReplicaID names this replica in leases. Empty derives one from the
+	// hostname and process, which is unique per run.
+	ReplicaID string `mapstructure:"replica_id"`
+	// LeaseTTL is how long a replica's leader lease and deployment locks
+	// outlive it if it dies without releasing them.
+	LeaseTTL time.Duration `mapstructure:"lease_ttl"`
+	// MetricsToken admits scrapers to GET /metrics. Empty limits the
+	// metrics to administrators.
+	MetricsToken string `mapstructure:"metrics_token"`
+	// TLSCertFile and TLSKeyFile serve the API over HTTPS. The mtls auth
+	// backend needs them to receive client certificates.
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
 }
 
 // Address returns the server address in host:port format.
@@ -45,12 +85,19 @@ func (c ServerConfig) Address() string {
 // DatabaseConfig holds database configuration.
 type DatabaseConfig struct {
 	DSN string `mapstructure:"dsn"`
+	// SlowQueryThreshold logs store queries that take at least this long.
+	// Zero turns the slow-query log off.
+	SlowQueryThreshold time.Duration `mapstructure:"slow_query_threshold"`
 }
 
 // LogConfig holds logging configuration.
 type LogConfig struct {
 	Level  string `mapstructure:"level"`
 	Format string `mapstructure:"format"`
+
+	// DebugSampleRate keeps one in every DebugSampleRate debug logs with
+	// the same message; 1 keeps them all.
+	DebugSampleRate int `mapstructure:"debug_sample_rate"`
 }
 
 // DomainConfig holds domain generation configuration.
@@ -61,11 +108,64 @@ type DomainConfig struct {
 
 // AuthConfig holds authentication configuration.
 // Following ADR-005: APIGate Integration for Authentication and Billing
-// Auth is always via APIGate-injected headers (X-User-ID etc.)
+// By default auth is via APIGate-injected headers (X-User-ID etc.); Backends
+// adds API tokens, OIDC sessions and TLS client certificates.
 type AuthConfig struct {
+	// Backends are the authenticators asked who a request comes from, in
+	// order: shared_secret, api_token, oidc and mtls.
+	Backends []string `mapstructure:"backends"`
+
+	// OIDC configures the oidc backend.
+	OIDC OIDCAuthConfig `mapstructure:"oidc"`
+
+	// MTLS configures the mtls backend.
+	MTLS MTLSAuthConfig `mapstructure:"mtls"`
+
 	// SharedSecret is an optional secret to validate X-APIGate-Secret header.
 	// If empty, secret validation is skipped.
 	SharedSecret string `mapstructure:"shared_secret"`
+
+	// Moderators are the reference IDs of users allowed to moderate template
+	// reviews.
+	Moderators []string `mapstructure:"moderators"`
+
+	// Admins are the reference IDs of platform administrators, who can see
+	// and cancel scheduled commands.
+	Admins []string `mapstructure:"admins"`
+}
+
+// OIDCAuthConfig configures sign-in through an OIDC provider.
+type OIDCAuthConfig struct {
+	// Issuer is the provider's issuer URL.
+	Issuer string `mapstructure:"issuer"`
+
+	// Audience is the client ID ID tokens must be issued for.
+	Audience string `mapstructure:"audience"`
+
+	// JWKSURL is where the provider publishes its keys. Empty discovers it
+	// from the issuer.
+	JWKSURL string `mapstructure:"jwks_url"`
+
+	// Cookie is the cookie holding a session's ID token.
+	Cookie string `mapstructure:"cookie"`
+
+	// UserClaim names the claim users are identified by.
+	UserClaim string `mapstructure:"user_claim"`
+
+	// PlanClaim names the claim holding a user's plan; empty leaves plans
+	// to APIGate.
+	PlanClaim string `mapstructure:"plan_claim"`
+}
+
+// MTLSAuthConfig configures sign-in with TLS client certificates.
+type MTLSAuthConfig struct {
+	// ClientCAFile holds the PEM certificates client certificates must
+	// chain to.
+	ClientCAFile string `mapstructure:"client_ca_file"`
+
+	// Identity is the certificate field users are identified by: cn,
+	// email or uri.
+	Identity string `mapstructure:"identity"`
 }
 
 // BillingConfig holds billing/metering configuration.
@@ -88,6 +188,29 @@ type BillingConfig struct {
 
 	// InvoiceInterval is how often to check and generate invoices.
 	InvoiceInterval time.Duration `mapstructure:"invoice_interval"`
+
+	// PlatformFeePercent is the platform's share of template revenue (0-100).
+	// Creators receive the remainder of each paid invoice line.
+	PlatformFeePercent float64 `mapstructure:"platform_fee_percent"`
+
+	// PayoutInterval is how often to check for creator payouts that are due.
+	PayoutInterval time.Duration `mapstructure:"payout_interval"`
+
+	// UsagePrices are "event_type=cents" prices of metered usage, counted
+	// against accounts' spending limits.
+	UsagePrices []string `mapstructure:"usage_prices"`
+
+	// PlanUsagePrices are "plan:event_type=cents" prices of metered usage
+	// that replace UsagePrices on invoices of customers on the plan.
+	PlanUsagePrices []string `mapstructure:"plan_usage_prices"`
+
+	// SpendingCheckInterval is how often accounts' spend is checked against
+	// their limits to send alerts.
+	SpendingCheckInterval time.Duration `mapstructure:"spending_check_interval"`
+
+	// MeteringInterval is how often running deployments' containers are
+	// sampled for CPU, memory and egress usage events.
+	MeteringInterval time.Duration `mapstructure:"metering_interval"`
 }
 
 // NodesConfig holds worker nodes configuration.
@@ -98,6 +221,11 @@ type NodesConfig struct {
 	// Set via HOSTER_NODES_ENCRYPTION_KEY environment variable.
 	EncryptionKey string `mapstructure:"encryption_key"`
 
+	// Sandbox runs every node on an in-memory Docker host instead of over
+	// SSH, for development without Docker or SSH targets. Nothing is
+	// deployed. Set by the --dev-sandbox flag.
+	Sandbox bool `mapstructure:"sandbox"`
+
 	// HealthCheckInterval is how often to check node health.
 	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
 
@@ -106,6 +234,400 @@ type NodesConfig struct {
 
 	// HealthCheckMaxConcurrent is the max number of concurrent health checks.
 	HealthCheckMaxConcurrent int `mapstructure:"health_check_max_concurrent"`
+
+	// StartConcurrency is how many services of one dependency level are
+	// started at once when a deployment starts.
+	StartConcurrency int `mapstructure:"start_concurrency"`
+
+	// CommandWorkers is how many queued commands (deployment starts, stops
+	// and other jobs) run at once.
+	CommandWorkers int `mapstructure:"command_workers"`
+
+	// SchedulingStrategy is how deployments without a selected node are
+	// placed: spread, binpack or random.
+	SchedulingStrategy string `mapstructure:"scheduling_strategy"`
+
+	// MetricsInterval is how often to collect node-level metrics (load, disk, dockerd, journal).
+	MetricsInterval time.Duration `mapstructure:"metrics_interval"`
+
+	// MetricsRetention is how long node metrics samples are kept.
+	MetricsRetention time.Duration `mapstructure:"metrics_retention"`
+
+	// ContainerMetricsInterval is how often to sample deployment containers' CPU and memory usage.
+	ContainerMetricsInterval time.Duration `mapstructure:"container_metrics_interval"`
+
+	// ContainerMetricsRetention is how long container usage samples are kept for capacity reports.
+	ContainerMetricsRetention time.Duration `mapstructure:"container_metrics_retention"`
+
+	// MinionPublicKey is the base64 Ed25519 key that verifies minion build signatures.
+	// When empty, signatures are not checked (protocol version is still enforced).
+	// Set via HOSTER_NODES_MINION_PUBLIC_KEY environment variable.
+	MinionPublicKey string `mapstructure:"minion_public_key"`
+
+	// MinMinionProtocol is the oldest minion protocol version the backend will dispatch to.
+	MinMinionProtocol string `mapstructure:"min_minion_protocol"`
+
+	// VolumeMigrationInterval is how often the volume migrator looks for pending migrations.
+	VolumeMigrationInterval time.Duration `mapstructure:"volume_migration_interval"`
+
+	// VolumeMigrationChunkMB is the size of each chunk relayed between nodes.
+	VolumeMigrationChunkMB int64 `mapstructure:"volume_migration_chunk_mb"`
+
+	// HousekeepingInterval is how often the housekeeping scheduler checks node schedules.
+	HousekeepingInterval time.Duration `mapstructure:"housekeeping_interval"`
+
+	// ImageDriftInterval is how often running deployments' pinned images are
+	// checked against the digests their tags point at now.
+	ImageDriftInterval time.Duration `mapstructure:"image_drift_interval"`
+
+	// ServiceHealthInterval is how often running deployments' services are
+	// checked. x-hoster probes run at their own interval, rounded up to this.
+	ServiceHealthInterval time.Duration `mapstructure:"service_health_interval"`
+
+	// SLOInterval is how often deployments with an SLO get an uptime check
+	// and their burn rates are evaluated, and how often the proxy's counts
+	// are recorded.
+	SLOInterval time.Duration `mapstructure:"slo_interval"`
+
+	// ProbeMaxTimeout caps the timeout of one x-hoster probe, and
+	// ProbeMaxOutput the command output kept from it, in bytes.
+	ProbeMaxTimeout time.Duration `mapstructure:"probe_max_timeout"`
+	ProbeMaxOutput  int           `mapstructure:"probe_max_output"`
+
+	// ProbeRate is how many probes may run on one node per minute (0: no limit).
+	ProbeRate int `mapstructure:"probe_rate"`
+
+	// ProbeFailureBudget is how many failed probes a deployment may run per
+	// ProbeBudgetWindow before its probes are suspended (0: no budget).
+	ProbeFailureBudget int           `mapstructure:"probe_failure_budget"`
+	ProbeBudgetWindow  time.Duration `mapstructure:"probe_budget_window"`
+
+	// LogExportDir is where deployment log export archives are written.
+	// Defaults to <data_dir>/log-exports.
+	LogExportDir string `mapstructure:"log_export_dir"`
+
+	// LogExportTTL is how long a log export's download link stays valid.
+	LogExportTTL time.Duration `mapstructure:"log_export_ttl"`
+
+	// ExperimentalCheckpoint enables checkpoint-mode volume migrations, which
+	// move running deployments with CRIU checkpoint/restore. Both nodes must
+	// run Docker with experimental features and have criu installed.
+	ExperimentalCheckpoint bool `mapstructure:"experimental_checkpoint"`
+}
+
+// SecretsConfig holds external secret manager configuration for secret-reference
+// variable values (vault://path#key, awssm://secret-id#key).
+// A backend is enabled when its address (Vault) or region (AWS) is set.
+type SecretsConfig struct {
+	// VaultAddress is the Vault server URL.
+	VaultAddress string `mapstructure:"vault_address"`
+
+	// VaultToken is the Vault token used to read secrets.
+	// Set via HOSTER_SECRETS_VAULT_TOKEN environment variable.
+	VaultToken string `mapstructure:"vault_token"`
+
+	// VaultNamespace is the Vault Enterprise namespace (optional).
+	VaultNamespace string `mapstructure:"vault_namespace"`
+
+	// AWSRegion is the AWS Secrets Manager region.
+	AWSRegion string `mapstructure:"aws_region"`
+
+	// AWSAccessKeyID and AWSSecretAccessKey are the AWS credentials. When empty,
+	// the standard AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN
+	// environment variables are used.
+	AWSAccessKeyID     string `mapstructure:"aws_access_key_id"`
+	AWSSecretAccessKey string `mapstructure:"aws_secret_access_key"`
+
+	// CacheTTL is how long a resolved secret is reused before it is fetched again.
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+}
+
+// ArchiveConfig holds tiered storage configuration for old usage and container events.
+type ArchiveConfig struct {
+	// Dir is where compressed NDJSON archive files are written.
+	// Defaults to <domain.config_dir>/archives.
+	Dir string `mapstructure:"dir"`
+
+	// Retention is how long raw event rows stay in the database before archival.
+	Retention time.Duration `mapstructure:"retention"`
+
+	// BatchSize is the number of rows archived and deleted per batch.
+	BatchSize int `mapstructure:"batch_size"`
+
+	// Interval is how often the archiver runs.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// BackupConfig holds scheduled backup configuration for the control plane
+// database. Backups are also uploaded to s3_bucket when it is set, using the
+// storage.s3_* endpoint and credentials.
+type BackupConfig struct {
+	// Enabled turns on scheduled backups while the server runs.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Dir is where backups are written. Defaults to <data_dir>/backups.
+	Dir string `mapstructure:"dir"`
+
+	// Interval is how often a backup is taken.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// Keep is how many backups are kept; 0 keeps all.
+	Keep int `mapstructure:"keep"`
+
+	// MaxAge drops backups older than this; 0 disables it.
+	MaxAge time.Duration `mapstructure:"max_age"`
+
+	// S3Bucket is the existing bucket backups are uploaded to.
+	S3Bucket string `mapstructure:"s3_bucket"`
+
+	// S3Prefix is the key prefix of uploaded backups.
+	S3Prefix string `mapstructure:"s3_prefix"`
+
+	// Timeout bounds each S3 request, including uploads.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// StorageConfig holds managed object storage configuration for deployment buckets.
+// The node backend runs a MinIO container per bucket on the deployment's node and
+// is available when remote nodes are enabled. The S3 backend is enabled when
+// s3_endpoint or s3_region is set. Both store bucket credentials encrypted with
+// nodes.encryption_key.
+type StorageConfig struct {
+	// NodeBackend enables buckets hosted on deployment nodes.
+	NodeBackend bool `mapstructure:"node_backend"`
+
+	// DefaultBackend is "node" or "s3"; defaults to node when available.
+	DefaultBackend string `mapstructure:"default_backend"`
+
+	// BucketPrefix starts every provisioned bucket name.
+	BucketPrefix string `mapstructure:"bucket_prefix"`
+
+	// MinIOImage is the image run for node-hosted buckets.
+	MinIOImage string `mapstructure:"minio_image"`
+
+	// S3Endpoint is the S3-compatible API endpoint (default AWS for s3_region).
+	S3Endpoint string `mapstructure:"s3_endpoint"`
+
+	// S3PublicEndpoint is the endpoint given to deployments, if it differs.
+	S3PublicEndpoint string `mapstructure:"s3_public_endpoint"`
+
+	// S3Region is the region buckets are created in.
+	S3Region string `mapstructure:"s3_region"`
+
+	// S3AccessKeyID and S3SecretAccessKey are the platform's credentials. When
+	// empty, the standard AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY /
+	// AWS_SESSION_TOKEN environment variables are used.
+	S3AccessKeyID     string `mapstructure:"s3_access_key_id"`
+	S3SecretAccessKey string `mapstructure:"s3_secret_access_key"`
+
+	// S3PathStyle addresses buckets by path, as most self-hosted services require.
+	S3PathStyle bool `mapstructure:"s3_path_style"`
+
+	// S3IAM creates an IAM user per bucket so each deployment gets credentials
+	// limited to its own bucket (AWS only).
+	S3IAM bool `mapstructure:"s3_iam"`
+
+	// Interval is how often pending, released and expired buckets are processed.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// UsageInterval is how often stored data is metered for billing.
+	UsageInterval time.Duration `mapstructure:"usage_interval"`
+}
+
+// NotificationsConfig holds user notification channel configuration. Slack
+// channels are always available; Web Push is enabled when the VAPID key pair
+// is set. Channel configs are stored encrypted with nodes.encryption_key.
+// Collaborator invitations are emailed when smtp_host is set.
+type NotificationsConfig struct {
+	// VAPIDPublicKey and VAPIDPrivateKey are the base64url-encoded P-256
+	// application server keys browsers subscribe with.
+	VAPIDPublicKey  string `mapstructure:"vapid_public_key"`
+	VAPIDPrivateKey string `mapstructure:"vapid_private_key"`
+
+	// VAPIDSubject is the contact given to push services (mailto: or https:).
+	VAPIDSubject string `mapstructure:"vapid_subject"`
+
+	// AppURL is the web UI base URL linked from notifications.
+	AppURL string `mapstructure:"app_url"`
+
+	// Timeout bounds one delivery to a channel.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// SMTPHost and SMTPPort address the relay invitation emails are sent
+	// through. Port 465 uses implicit TLS; others use STARTTLS when offered.
+	SMTPHost string `mapstructure:"smtp_host"`
+	SMTPPort int    `mapstructure:"smtp_port"`
+
+	// SMTPUsername and SMTPPassword authenticate with the relay (optional).
+	SMTPUsername string `mapstructure:"smtp_username"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+
+	// MailFrom is the sender of invitation emails.
+	MailFrom string `mapstructure:"mail_from"`
+}
+
+// RegistryConfig holds template registry configuration: signing the
+// template bundles this instance exports and trusting other instances'.
+// Export is enabled when signing_key is set; import when either is set.
+type RegistryConfig struct {
+	// SigningKey is the base64 Ed25519 key (seed) exported bundles are
+	// signed with. This instance's own bundles are always trusted.
+	SigningKey string `mapstructure:"signing_key"`
+
+	// TrustedKeys are the base64 public keys of the instances whose bundles
+	// may be imported.
+	TrustedKeys []string `mapstructure:"trusted_keys"`
+
+	// Origin is this instance's public base URL, recorded in the provenance
+	// of exported bundles. Defaults to notifications.app_url.
+	Origin string `mapstructure:"origin"`
+}
+
+// UploadsConfig holds configuration for template files uploaded from a
+// browser.
+type UploadsConfig struct {
+	// Dir is where chunked uploads are kept until their last chunk arrives.
+	// Defaults to <data_dir>/uploads.
+	Dir string `mapstructure:"dir"`
+
+	// ClamdAddress is the host:port or unix socket path of a ClamAV daemon
+	// that scans uploads for malware. Empty leaves the built-in checks.
+	ClamdAddress string `mapstructure:"clamd_address"`
+}
+
+// ReadOnlyConfig holds read-only replica configuration. A read-only replica
+// serves public marketplace endpoints from a copy of the control plane's
+// database and can be scaled out behind a CDN.
+type ReadOnlyConfig struct {
+	// Enabled runs the server as a read-only replica.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Source is how the replica database is kept current: "replica" when it
+	// is replicated externally (e.g. Litestream), "backups" to sync it from
+	// the control plane's backups.
+	Source string `mapstructure:"source"`
+
+	// SyncInterval is how often a backups-sourced replica looks for a newer
+	// backup.
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+
+	// CacheMaxAge is the Cache-Control max-age of the replica's responses.
+	CacheMaxAge time.Duration `mapstructure:"cache_max_age"`
+}
+
+// ReplicationConfig holds cold-standby replication configuration. A primary
+// ships snapshots of its database and config directory to a standby, which
+// serves only health endpoints until `hoster promote` makes it the primary.
+type ReplicationConfig struct {
+	// Role is "primary", "standby", or empty to not replicate.
+	Role string `mapstructure:"role"`
+
+	// StandbyURL is the base URL of the standby a primary ships to.
+	StandbyURL string `mapstructure:"standby_url"`
+
+	// Secret is sent by the primary and checked by the standby.
+	Secret string `mapstructure:"secret"`
+
+	// Interval is how often a primary ships a snapshot.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// Timeout bounds one shipment.
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// MaxLag is the snapshot age over which a standby reports it is lagging.
+	MaxLag time.Duration `mapstructure:"max_lag"`
+
+	// Keep is how many snapshots a standby keeps.
+	Keep int `mapstructure:"keep"`
+
+	// Dir is where snapshots are staged and received. Defaults to
+	// <data_dir>/replication.
+	Dir string `mapstructure:"dir"`
+}
+
+// PlaygroundConfig holds template playground configuration.
+type PlaygroundConfig struct {
+	// Node is the reference ID of the sandbox node playgrounds run on.
+	// Empty disables playgrounds.
+	Node string `mapstructure:"node"`
+
+	// TTL is how long a playground runs before it is reaped.
+	TTL time.Duration `mapstructure:"ttl"`
+
+	// MaxSessions caps the playgrounds running at once.
+	MaxSessions int `mapstructure:"max_sessions"`
+
+	// MaxPerClient caps the playgrounds running at once from one address.
+	MaxPerClient int `mapstructure:"max_per_client"`
+
+	// MemoryMB and CPUCores cap each service of a playground.
+	MemoryMB int64   `mapstructure:"memory_mb"`
+	CPUCores float64 `mapstructure:"cpu_cores"`
+}
+
+// TunnelConfig holds deployment tunnel configuration.
+type TunnelConfig struct {
+	// Enabled lets deployment owners open tunnels to container ports.
+	Enabled bool `mapstructure:"enabled"`
+
+	// MaxDuration is the longest a tunnel session may last.
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+
+	// BandwidthKB caps each direction of a session, in KiB/s; 0 is unlimited.
+	BandwidthKB int64 `mapstructure:"bandwidth_kb"`
+}
+
+// ExecConfig holds deployment exec configuration.
+type ExecConfig struct {
+	// Allowlist is the commands deployment owners may run in their
+	// containers: argument prefixes such as "php artisan", or "*" for any
+	// command. Empty disables exec.
+	Allowlist []string `mapstructure:"allowlist"`
+
+	// MaxTimeout is the longest a command may run.
+	MaxTimeout time.Duration `mapstructure:"max_timeout"`
+
+	// MaxOutputKB caps each of a command's stdout and stderr, in KiB.
+	MaxOutputKB int `mapstructure:"max_output_kb"`
+}
+
+// ACMEConfig holds certificate issuance configuration for custom domains.
+type ACMEConfig struct {
+	// Enabled issues and renews verified custom domains' certificates over
+	// ACME instead of leaving them to Traefik's resolver.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Email is the ACME account's contact address.
+	Email string `mapstructure:"email"`
+
+	// DirectoryURL is the ACME directory of the certificate authority.
+	DirectoryURL string `mapstructure:"directory_url"`
+
+	// Interval is how often due certificates are requested.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// RenewBefore is how long before expiry certificates are renewed.
+	RenewBefore time.Duration `mapstructure:"renew_before"`
+
+	// MaxPerRun caps the certificates requested per run.
+	MaxPerRun int `mapstructure:"max_per_run"`
+
+	// ChallengeUpstream is where nodes' Traefik forwards HTTP-01 challenge
+	// requests: a URL of hoster's API server.
+	ChallengeUpstream string `mapstructure:"challenge_upstream"`
+
+	// DNSProvider publishes DNS-01 challenge records in DNSZone with
+	// DNSToken; empty allows HTTP-01 only.
+	DNSProvider string `mapstructure:"dns_provider"`
+	DNSZone     string `mapstructure:"dns_zone"`
+	DNSToken    string `mapstructure:"dns_token"`
+}
+
+// ChaosConfig holds chaos testing configuration.
+type ChaosConfig struct {
+	// Enabled injects the faults administrators configure at /admin/faults
+	// into minion commands and store operations. Only binaries built with
+	// -tags chaos accept it.
+	Enabled bool `mapstructure:"enabled"`
 }
 
 // ProxyConfig holds App Proxy server configuration.
@@ -132,6 +654,50 @@ type ProxyConfig struct {
 
 	// IdleTimeout is the HTTP idle timeout for the proxy server.
 	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+
+	// WebSocketTimeout is how long a WebSocket connection through the proxy
+	// may stay open, unless the deployment's routing options say otherwise.
+	WebSocketTimeout time.Duration `mapstructure:"websocket_timeout"`
+
+	// Traefik routes deployments through Traefik on their nodes.
+	Traefik TraefikConfig `mapstructure:"traefik"`
+
+	// InternalSecret lets proxies on other hosts call GET /internal/routes,
+	// sending it in the X-Hoster-Internal-Secret header. Without it the
+	// endpoint only answers local requests.
+	InternalSecret string `mapstructure:"internal_secret"`
+
+	// RouteCacheTTL bounds how long /internal/routes serves a cached route.
+	// Changes made through this replica invalidate the cache at once.
+	RouteCacheTTL time.Duration `mapstructure:"route_cache_ttl"`
+
+	// TLSPort serves HTTPS with the certificates issued over ACME. Zero
+	// disables it.
+	TLSPort int `mapstructure:"tls_port"`
+}
+
+// TraefikConfig selects how Traefik on each node learns about deployments.
+// The App Proxy keeps working alongside it.
+type TraefikConfig struct {
+	// Mode is "labels" (Docker provider), "file" (file provider), or empty
+	// to leave routing to the App Proxy.
+	Mode string `mapstructure:"mode"`
+
+	// TLS adds HTTPS routers with Let's Encrypt certificates.
+	TLS bool `mapstructure:"tls"`
+
+	// RedirectHTTP redirects HTTP to HTTPS when TLS is set.
+	RedirectHTTP bool `mapstructure:"redirect_http"`
+
+	// ConfigPath is where the file provider's file is written on each node.
+	ConfigPath string `mapstructure:"config_path"`
+
+	// Upstream is the address Traefik reaches deployments' proxy ports at
+	// in file mode.
+	Upstream string `mapstructure:"upstream"`
+
+	// Interval is how often nodes' files are brought up to date.
+	Interval time.Duration `mapstructure:"interval"`
 }
 
 // Address returns the proxy server address in host:port format.
@@ -143,46 +709,27 @@ func (c ProxyConfig) Address() string {
 // Config Loading
 // =============================================================================
 
-// LoadConfig loads configuration from file and environment.
+// LoadConfig loads configuration from file and environment, and validates it.
 func LoadConfig(configPath string) (*Config, error) {
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// readConfig loads configuration from file and environment without
+// validating it, deriving the paths that are not set.
+func readConfig(configPath string) (*Config, error) {
 	v := viper.New()
 
 	// Set defaults
-	v.SetDefault("data_dir", "./data")
-	v.SetDefault("server.host", "0.0.0.0")
-	v.SetDefault("server.port", 8080)
-	v.SetDefault("server.read_timeout", "30s")
-	v.SetDefault("server.write_timeout", "30s")
-	v.SetDefault("server.shutdown_timeout", "30s")
-	v.SetDefault("database.dsn", "")
-	v.SetDefault("log.level", "info")
-	v.SetDefault("log.format", "json")
-	v.SetDefault("domain.base_domain", "apps.localhost")
-	v.SetDefault("domain.config_dir", "")
-	v.SetDefault("auth.shared_secret", "")     // No secret validation by default
-
-	// Billing defaults — always enabled
-	v.SetDefault("billing.apigate_url", "http://localhost:8082")
-	v.SetDefault("billing.api_key", "")
-	v.SetDefault("billing.report_interval", "60s")
-	v.SetDefault("billing.batch_size", 100)
-	v.SetDefault("billing.stripe_key", "")
-	v.SetDefault("billing.invoice_interval", "24h")
-
-	// Node defaults (Creator Worker Nodes)
-	v.SetDefault("nodes.encryption_key", "")                // Must be set via environment
-	v.SetDefault("nodes.health_check_interval", "60s")      // Check nodes every minute
-	v.SetDefault("nodes.health_check_timeout", "10s")       // 10 second timeout per node
-	v.SetDefault("nodes.health_check_max_concurrent", 5)    // Max 5 concurrent checks
-
-	// Proxy defaults (App Proxy - specs/domain/proxy.md)
-	v.SetDefault("proxy.enabled", true)                     // Enabled by default
-	v.SetDefault("proxy.host", "0.0.0.0")
-	v.SetDefault("proxy.port", 9091)                        // Default proxy port
-	v.SetDefault("proxy.base_domain", "apps.localhost")     // Default base domain
-	v.SetDefault("proxy.read_timeout", "30s")
-	v.SetDefault("proxy.write_timeout", "60s")
-	v.SetDefault("proxy.idle_timeout", "120s")
+	for _, k := range configSchema {
+		v.SetDefault(k.Key, k.Default)
+	}
 
 	// Load from file if provided
 	if configPath != "" {
@@ -206,6 +753,7 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	cfg.Warnings = unknownKeys(v.AllKeys(), os.Environ())
 
 	// Derive paths from data_dir when not explicitly set
 	if cfg.Database.DSN == "" {
@@ -214,6 +762,25 @@ func LoadConfig(configPath string) (*Config, error) {
 	if cfg.Domain.ConfigDir == "" {
 		cfg.Domain.ConfigDir = filepath.Join(cfg.DataDir, "configs")
 	}
+	if cfg.Archive.Dir == "" {
+		cfg.Archive.Dir = filepath.Join(cfg.Domain.ConfigDir, "archives")
+	}
+	if cfg.Backup.Dir == "" {
+		cfg.Backup.Dir = filepath.Join(cfg.DataDir, "backups")
+	}
+	if cfg.Nodes.LogExportDir == "" {
+		cfg.Nodes.LogExportDir = filepath.Join(cfg.DataDir, "log-exports")
+	}
+	if cfg.Uploads.Dir == "" {
+		cfg.Uploads.Dir = filepath.Join(cfg.DataDir, "uploads")
+	}
+	if cfg.Replication.Dir == "" {
+		cfg.Replication.Dir = filepath.Join(cfg.DataDir, "replication")
+	}
+	if cfg.Server.ReplicaID == "" {
+		hostname, _ := os.Hostname()
+		cfg.Server.ReplicaID = coordination.HolderID(hostname, os.Getpid(), time.Now())
+	}
 
 	return &cfg, nil
 }
@@ -249,5 +816,5 @@ func SetupLogger(cfg *Config) *slog.Logger {
 		handler = slog.NewJSONHandler(os.Stdout, opts)
 	}
 
-	return slog.New(handler)
+	return slog.New(logging.NewSamplingHandler(handler, cfg.Log.DebugSampleRate))
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runConfig implements `hoster config check [--json] [-config path]`, which
+// prints the effective config with secrets redacted and validates it, and
+// `hoster config env`, which lists every key with its environment variable.
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: hoster config check [--json] [-config path] | hoster config env")
+		return ExitConfigError
+	}
+	switch args[0] {
+	case "check":
+		return runConfigCheck(args[1:])
+	case "env":
+		return runConfigEnv(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "config: unknown subcommand %q (want check or env)\n", args[0])
+	return ExitConfigError
+}
+
+func runConfigCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	asJSON := fs.Bool("json", false, "Print the effective config as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+
+	fields := configFields(cfg)
+	if *asJSON {
+		values := make(map[string]any, len(configSchema))
+		for _, k := range configSchema {
+			values[k.Key] = effectiveValue(k, fields[k.Key])
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(values)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tENV")
+		for _, k := range configSchema {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", k.Key, formatValue(effectiveValue(k, fields[k.Key])), k.Env())
+		}
+		w.Flush()
+	}
+
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		return ExitConfigError
+	}
+	fmt.Fprintln(os.Stderr, "config is valid")
+	return ExitSuccess
+}
+
+func runConfigEnv(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "config env: takes no arguments")
+		return ExitConfigError
+	}
+	fields := configFields(&Config{})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENV\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, k := range configSchema {
+		doc := k.Doc
+		if k.Required {
+			doc += " (required)"
+		}
+		if k.Secret {
+			doc += " (secret)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.Env(), typeName(fields[k.Key].Type()), formatValue(k.Default), doc)
+	}
+	w.Flush()
+	return ExitSuccess
+}
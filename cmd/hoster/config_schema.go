@@ -0,0 +1,667 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/containerexec"
+	"github.com/artpar/hoster/internal/core/invoice"
+	"github.com/artpar/hoster/internal/core/playground"
+	"github.com/artpar/hoster/internal/core/replication"
+	"github.com/artpar/hoster/internal/core/scheduler"
+	"github.com/artpar/hoster/internal/core/spending"
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+	"github.com/artpar/hoster/internal/core/traefik"
+	"github.com/artpar/hoster/internal/core/tunnel"
+	"github.com/artpar/hoster/internal/engine"
+	"github.com/artpar/hoster/internal/shell/mail"
+	"github.com/artpar/hoster/internal/shell/notify"
+)
+
+// =============================================================================
+// Config Schema
+// =============================================================================
+
+// configKey describes one configuration key. Its type comes from the Config
+// field the key decodes into.
+type configKey struct {
+	// Key is the dotted key, as written in the config file.
+	Key string
+
+	// Default is the value used when neither the file nor the environment
+	// sets the key.
+	Default any
+
+	// Doc describes the key for `hoster config env`.
+	Doc string
+
+	// Secret keys are redacted when the effective config is printed.
+	Secret bool
+
+	// Required keys must not be empty.
+	Required bool
+
+	// ZeroOK allows a zero duration or count, which disables the feature.
+	// Other durations and counts must be positive.
+	ZeroOK bool
+}
+
+// Env returns the environment variable that overrides the key.
+func (k configKey) Env() string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(k.Key, ".", "_"))
+}
+
+// envPrefix starts every environment variable that overrides a config key.
+const envPrefix = "HOSTER"
+
+// configSchema lists every configuration key, in the order `hoster config`
+// prints them. LoadConfig takes its defaults from here.
+var configSchema = []configKey{
+	{Key: "data_dir", Default: "./data", Required: true, Doc: "Directory the database and other state are kept in"},
+
+	// Server
+	{Key: "server.host", Default: "0.0.0.0", Doc: "Interface the API server binds to"},
+	{Key: "server.port", Default: 8080, Doc: "API server port"},
+	{Key: "server.read_timeout", Default: "30s", Doc: "API server read timeout"},
+	{Key: "server.write_timeout", Default: "30s", Doc: "API server write timeout"},
+	{Key: "server.shutdown_timeout", Default: "30s", Doc: "Time allowed for a graceful shutdown"},
+	{Key: "server.idempotency_ttl", Default: "24h", Doc: "How long Idempotency-Key responses are kept for replay"},
+	{Key: "server.api_v1_deprecated_at", Default: "", Doc: "Date /api/v1 is deprecated (YYYY-MM-DD or RFC 3339); empty keeps it current"},
+	{Key: "server.api_v1_sunset_at", Default: "", Doc: "Date /api/v1 is removed (YYYY-MM-DD or RFC 3339)"},
+	{Key: "server.replica_id", Default: "", Doc: "Name of this replica in leases; defaults to one unique per run"},
+	{Key: "server.lease_ttl", Default: "30s", Doc: "How long leases and deployment locks outlive a replica that died"},
+	{Key: "server.metrics_token", Default: "", Secret: true, Doc: "Bearer token scrapers send to GET /metrics; empty allows administrators only"},
+	{Key: "server.tls_cert_file", Default: "", Doc: "PEM certificate to serve the API over HTTPS; empty serves HTTP"},
+	{Key: "server.tls_key_file", Default: "", Doc: "PEM private key for server.tls_cert_file"},
+
+	{Key: "database.dsn", Default: "", Doc: "SQLite database path; defaults to <data_dir>/hoster.db"},
+	{Key: "database.slow_query_threshold", Default: "250ms", ZeroOK: true, Doc: "Store queries at least this slow are logged; 0 disables the log"},
+
+	// Logging
+	{Key: "log.level", Default: "info", Doc: "Log level: debug, info, warn or error"},
+	{Key: "log.format", Default: "json", Doc: "Log format: json or text"},
+	{Key: "log.debug_sample_rate", Default: 1, Doc: "Keep one in N debug logs with the same message; 1 keeps them all"},
+
+	// Domains
+	{Key: "domain.base_domain", Default: "apps.localhost", Required: true, Doc: "Base domain deployment domains are generated under"},
+	{Key: "domain.config_dir", Default: "", Doc: "Directory for deployment config files; defaults to <data_dir>/configs"},
+
+	// Authentication
+	{Key: "auth.shared_secret", Default: "", Secret: true, Doc: "Secret APIGate sends in X-APIGate-Secret; empty skips the check"},
+	{Key: "auth.moderators", Default: []string{}, Doc: "Comma-separated reference IDs of template review moderators"},
+	{Key: "auth.admins", Default: []string{}, Doc: "Comma-separated reference IDs of platform administrators"},
+	{Key: "auth.backends", Default: []string{"shared_secret"}, Doc: "Authenticators asked in order: shared_secret, api_token, oidc, mtls"},
+	{Key: "auth.oidc.issuer", Default: "", Doc: "OIDC provider issuer URL (oidc backend)"},
+	{Key: "auth.oidc.audience", Default: "", Doc: "Client ID OIDC ID tokens must be issued for"},
+	{Key: "auth.oidc.jwks_url", Default: "", Doc: "OIDC provider's key set URL; empty discovers it from the issuer"},
+	{Key: "auth.oidc.cookie", Default: "hoster_session", Doc: "Cookie holding a session's OIDC ID token"},
+	{Key: "auth.oidc.user_claim", Default: "sub", Doc: "ID token claim users are identified by"},
+	{Key: "auth.oidc.plan_claim", Default: "", Doc: "ID token claim holding the user's plan; empty leaves plans unset"},
+	{Key: "auth.mtls.client_ca_file", Default: "", Doc: "PEM CA certificates client certificates must chain to (mtls backend)"},
+	{Key: "auth.mtls.identity", Default: "cn", Doc: "Client certificate field users are identified by: cn, email or uri"},
+
+	// Billing (always enabled)
+	{Key: "billing.apigate_url", Default: "http://localhost:8082", Required: true, Doc: "Base URL of the APIGate billing API"},
+	{Key: "billing.api_key", Default: "", Secret: true, Doc: "API key for APIGate"},
+	{Key: "billing.report_interval", Default: "60s", Doc: "How often usage events are reported"},
+	{Key: "billing.batch_size", Default: 100, Doc: "Maximum usage events per report"},
+	{Key: "billing.stripe_key", Default: "", Secret: true, Doc: "Stripe secret key for checkout sessions"},
+	{Key: "billing.invoice_interval", Default: "24h", Doc: "How often invoices are generated"},
+	{Key: "billing.platform_fee_percent", Default: 20, Doc: "Platform share of template revenue, 0-100"},
+	{Key: "billing.payout_interval", Default: "6h", Doc: "How often due creator payouts are checked"},
+	{Key: "billing.usage_prices", Default: []string{}, Doc: "Comma-separated event_type=cents prices of metered usage for spending limits, e.g. gpu.usage=150"},
+	{Key: "billing.plan_usage_prices", Default: []string{}, Doc: "Comma-separated plan:event_type=cents prices of metered usage on invoices of customers on a plan, e.g. pro:gpu.usage=120"},
+	{Key: "billing.spending_check_interval", Default: "15m", Doc: "How often accounts' spend is checked against their limits"},
+	{Key: "billing.metering_interval", Default: "5m", Doc: "How often deployments' containers are sampled for cpu.usage, memory.usage and egress.usage events"},
+
+	// Nodes (Creator Worker Nodes)
+	{Key: "nodes.encryption_key", Default: "", Secret: true, Doc: "32-byte key encrypting SSH keys and credentials; enables remote nodes"},
+	{Key: "nodes.sandbox", Default: false, Doc: "Run nodes on in-memory Docker hosts instead of SSH, for development; also set by --dev-sandbox"},
+	{Key: "nodes.health_check_interval", Default: "60s", Doc: "How often node health is checked"},
+	{Key: "nodes.health_check_timeout", Default: "10s", Doc: "Timeout for checking one node"},
+	{Key: "nodes.health_check_max_concurrent", Default: 5, Doc: "Maximum concurrent node health checks"},
+	{Key: "nodes.start_concurrency", Default: 4, Doc: "Services of one dependency level started at once when a deployment starts"},
+	{Key: "nodes.command_workers", Default: 4, Doc: "Queued commands (deployment starts, stops and other jobs) run at once"},
+	{Key: "nodes.scheduling_strategy", Default: "spread", Doc: "How deployments without a selected node are placed: spread (most free capacity), binpack (fill nodes first) or random"},
+	{Key: "nodes.metrics_interval", Default: "5m", Doc: "How often node metrics are collected"},
+	{Key: "nodes.metrics_retention", Default: "168h", Doc: "How long node metrics are kept"},
+	{Key: "nodes.container_metrics_interval", Default: "5m", Doc: "How often container usage is sampled"},
+	{Key: "nodes.container_metrics_retention", Default: "336h", Doc: "How long container usage samples are kept"},
+	{Key: "nodes.minion_public_key", Default: "", Doc: "Base64 Ed25519 key verifying minion builds; empty skips signature checks"},
+	{Key: "nodes.min_minion_protocol", Default: "1.2.0", Doc: "Oldest minion protocol version dispatched to"},
+	{Key: "nodes.volume_migration_interval", Default: "15s", Doc: "How often pending volume migrations are picked up"},
+	{Key: "nodes.volume_migration_chunk_mb", Default: 64, Doc: "Size of the chunks volume archives are relayed in, in MiB"},
+	{Key: "nodes.housekeeping_interval", Default: "1m", Doc: "How often node housekeeping schedules are checked"},
+	{Key: "nodes.image_drift_interval", Default: "6h", Doc: "How often pinned images are checked for tag drift"},
+	{Key: "nodes.service_health_interval", Default: "15s", Doc: "How often deployment services are checked"},
+	{Key: "nodes.slo_interval", Default: "1m", Doc: "How often SLO uptime checks and burn rates are evaluated"},
+	{Key: "nodes.probe_max_timeout", Default: "30s", Doc: "Longest timeout an x-hoster probe may have"},
+	{Key: "nodes.probe_max_output", Default: 4096, Doc: "Command probe output kept, in bytes"},
+	{Key: "nodes.probe_rate", Default: 60, ZeroOK: true, Doc: "Probes run per node per minute; 0 disables the limit"},
+	{Key: "nodes.probe_failure_budget", Default: 30, ZeroOK: true, Doc: "Failed probes per deployment per window before its probes are suspended; 0 disables it"},
+	{Key: "nodes.probe_budget_window", Default: "1h", Doc: "Window the probe failure budget is counted over"},
+	{Key: "nodes.log_export_dir", Default: "", Doc: "Directory for log export archives; defaults to <data_dir>/log-exports"},
+	{Key: "nodes.log_export_ttl", Default: "24h", Doc: "How long log export links stay valid"},
+	{Key: "nodes.experimental_checkpoint", Default: false, Doc: "Allow checkpoint-mode volume migrations (needs CRIU on both nodes)"},
+
+	// App Proxy (specs/domain/proxy.md)
+	{Key: "proxy.enabled", Default: true, Doc: "Run the App Proxy"},
+	{Key: "proxy.host", Default: "0.0.0.0", Doc: "Interface the App Proxy binds to"},
+	{Key: "proxy.port", Default: 9091, Doc: "App Proxy port"},
+	{Key: "proxy.base_domain", Default: "apps.localhost", Doc: "Domain apps are served under as {slug}.{base_domain}"},
+	{Key: "proxy.read_timeout", Default: "30s", Doc: "App Proxy read timeout"},
+	{Key: "proxy.write_timeout", Default: "60s", Doc: "App Proxy write timeout"},
+	{Key: "proxy.idle_timeout", Default: "120s", Doc: "App Proxy idle timeout"},
+	{Key: "proxy.websocket_timeout", Default: "1h", Doc: "How long a WebSocket connection may stay open, unless the deployment's routing options say"},
+	{Key: "proxy.traefik.mode", Default: "", Doc: "Traefik routing: labels, file, or empty for the App Proxy only"},
+	{Key: "proxy.traefik.tls", Default: true, Doc: "Add HTTPS routers with Let's Encrypt certificates"},
+	{Key: "proxy.traefik.redirect_http", Default: false, Doc: "Redirect HTTP to HTTPS (needs proxy.traefik.tls)"},
+	{Key: "proxy.traefik.config_path", Default: "/etc/traefik/dynamic/hoster.yml", Doc: "File provider file written on each node"},
+	{Key: "proxy.traefik.upstream", Default: "127.0.0.1", Doc: "Address Traefik reaches deployments at in file mode"},
+	{Key: "proxy.traefik.interval", Default: "15s", Doc: "How often nodes' Traefik files are brought up to date"},
+	{Key: "proxy.internal_secret", Default: "", Secret: true, Doc: "Secret remote proxies send to GET /internal/routes; empty allows local requests only"},
+	{Key: "proxy.route_cache_ttl", Default: "30s", Doc: "How long /internal/routes serves a cached route"},
+	{Key: "proxy.tls_port", Default: 0, ZeroOK: true, Doc: "App Proxy HTTPS port, serving certificates issued over ACME; 0 disables it (needs acme.enabled)"},
+
+	// ACME certificates for custom domains
+	{Key: "acme.enabled", Default: false, Doc: "Issue and renew custom domains' certificates over ACME; needs nodes.encryption_key"},
+	{Key: "acme.email", Default: "", Doc: "Contact address of the ACME account, for expiry notices"},
+	{Key: "acme.directory_url", Default: "https://acme-v02.api.letsencrypt.org/directory", Doc: "ACME directory; Let's Encrypt production by default"},
+	{Key: "acme.interval", Default: "10m", Doc: "How often certificates due for issuance or renewal are requested"},
+	{Key: "acme.renew_before", Default: "720h", Doc: "How long before expiry certificates are renewed"},
+	{Key: "acme.max_per_run", Default: 10, Doc: "Certificates requested per run at most"},
+	{Key: "acme.challenge_upstream", Default: "", Doc: "URL nodes' Traefik forwards HTTP-01 challenges to, e.g. http://10.0.0.2:8080; needed with proxy.traefik.mode"},
+	{Key: "acme.dns_provider", Default: "", Doc: "DNS provider for DNS-01 challenges: digitalocean, or empty for HTTP-01 only"},
+	{Key: "acme.dns_zone", Default: "", Doc: "Zone DNS-01 challenges are delegated to, e.g. acme.hoster.io"},
+	{Key: "acme.dns_token", Default: "", Secret: true, Doc: "API token of the DNS provider"},
+
+	// Secret managers (secret-reference variable values)
+	{Key: "secrets.vault_address", Default: "", Doc: "Vault server URL; enables vault:// references"},
+	{Key: "secrets.vault_token", Default: "", Secret: true, Doc: "Vault token"},
+	{Key: "secrets.vault_namespace", Default: "", Doc: "Vault Enterprise namespace"},
+	{Key: "secrets.aws_region", Default: "", Doc: "AWS Secrets Manager region; enables awssm:// references"},
+	{Key: "secrets.aws_access_key_id", Default: "", Doc: "AWS access key ID; defaults to the standard AWS variables"},
+	{Key: "secrets.aws_secret_access_key", Default: "", Secret: true, Doc: "AWS secret access key"},
+	{Key: "secrets.cache_ttl", Default: "5m", Doc: "How long a resolved secret is reused"},
+
+	// Event archive (tiered storage of usage and container events)
+	{Key: "archive.dir", Default: "", Doc: "Directory for event archives; defaults to <domain.config_dir>/archives"},
+	{Key: "archive.retention", Default: "2160h", Doc: "How long raw events stay in the database"},
+	{Key: "archive.batch_size", Default: 1000, Doc: "Rows archived per batch"},
+	{Key: "archive.interval", Default: "24h", Doc: "How often the archiver runs"},
+
+	// Control plane database backups
+	{Key: "backup.enabled", Default: true, Doc: "Take scheduled backups while the server runs"},
+	{Key: "backup.dir", Default: "", Doc: "Directory for backups; defaults to <data_dir>/backups"},
+	{Key: "backup.interval", Default: "6h", Doc: "How often a backup is taken"},
+	{Key: "backup.keep", Default: 28, ZeroOK: true, Doc: "Backups kept; 0 keeps all"},
+	{Key: "backup.max_age", Default: "0s", ZeroOK: true, Doc: "Drop backups older than this; 0 disables it"},
+	{Key: "backup.s3_bucket", Default: "", Doc: "Bucket backups are uploaded to, with the storage.s3_* settings"},
+	{Key: "backup.s3_prefix", Default: "hoster/backups", Doc: "Key prefix of uploaded backups"},
+	{Key: "backup.timeout", Default: "10m", Doc: "Timeout of each S3 request"},
+
+	// Managed object storage (deployment buckets)
+	{Key: "storage.node_backend", Default: true, Doc: "Host buckets on deployment nodes when remote nodes are enabled"},
+	{Key: "storage.default_backend", Default: "", Doc: "node or s3; defaults to node when available"},
+	{Key: "storage.bucket_prefix", Default: "hoster", Required: true, Doc: "Prefix of every provisioned bucket name"},
+	{Key: "storage.minio_image", Default: "minio/minio:latest", Required: true, Doc: "Image run for node-hosted buckets"},
+	{Key: "storage.s3_endpoint", Default: "", Doc: "S3-compatible endpoint; enables the s3 backend"},
+	{Key: "storage.s3_public_endpoint", Default: "", Doc: "Endpoint given to deployments, if it differs"},
+	{Key: "storage.s3_region", Default: "", Doc: "Region buckets are created in; enables the s3 backend"},
+	{Key: "storage.s3_access_key_id", Default: "", Doc: "S3 access key ID; defaults to the standard AWS variables"},
+	{Key: "storage.s3_secret_access_key", Default: "", Secret: true, Doc: "S3 secret access key"},
+	{Key: "storage.s3_path_style", Default: false, Doc: "Address buckets by path"},
+	{Key: "storage.s3_iam", Default: false, Doc: "Create an IAM user per bucket (AWS only)"},
+	{Key: "storage.interval", Default: "30s", Doc: "How often pending, released and expired buckets are processed"},
+	{Key: "storage.usage_interval", Default: "1h", Doc: "How often stored data is metered"},
+
+	// Notification channels
+	{Key: "notifications.vapid_public_key", Default: "", Doc: "Web Push public key (hoster vapid-keys)"},
+	{Key: "notifications.vapid_private_key", Default: "", Secret: true, Doc: "Web Push private key"},
+	{Key: "notifications.vapid_subject", Default: "", Doc: "Contact given to push services (mailto: or https:)"},
+	{Key: "notifications.app_url", Default: "", Doc: "Web UI base URL linked from notifications"},
+	{Key: "notifications.timeout", Default: "15s", Doc: "Timeout of one delivery to a channel"},
+	{Key: "notifications.smtp_host", Default: "", Doc: "SMTP relay for invitation emails; empty disables them"},
+	{Key: "notifications.smtp_port", Default: 587, Doc: "SMTP relay port; 465 uses implicit TLS"},
+	{Key: "notifications.smtp_username", Default: "", Doc: "SMTP username"},
+	{Key: "notifications.smtp_password", Default: "", Secret: true, Doc: "SMTP password"},
+	{Key: "notifications.mail_from", Default: "", Doc: "Sender of invitation emails"},
+
+	// Template registry
+	{Key: "registry.signing_key", Default: "", Secret: true, Doc: "Base64 Ed25519 key exported bundles are signed with (hoster registry-keys)"},
+	{Key: "registry.trusted_keys", Default: []string{}, Doc: "Comma-separated base64 public keys of instances whose bundles may be imported"},
+	{Key: "registry.origin", Default: "", Doc: "Public base URL recorded in exported bundles; defaults to notifications.app_url"},
+
+	// Template uploads
+	{Key: "uploads.dir", Default: "", Doc: "Directory for chunked uploads; defaults to <data_dir>/uploads"},
+	{Key: "uploads.clamd_address", Default: "", Doc: "ClamAV daemon host:port or unix socket path; empty skips malware scans"},
+
+	// Read-only replicas
+	{Key: "read_only.enabled", Default: false, Doc: "Serve only public GET endpoints from a replica database; writes get 503 and no workers run"},
+	{Key: "read_only.source", Default: "replica", Doc: "replica: database.dsn is replicated externally; backups: sync it from the newest backup in backup.dir or backup.s3_bucket"},
+	{Key: "read_only.sync_interval", Default: "5m", Doc: "How often a backups-sourced replica looks for a newer backup"},
+	{Key: "read_only.cache_max_age", Default: "60s", ZeroOK: true, Doc: "Cache-Control max-age of replica responses, for CDNs; 0 disables caching"},
+
+	// Cold-standby replication
+	{Key: "replication.role", Default: "", Doc: "primary: ship snapshots to replication.standby_url; standby: receive them and serve only health endpoints until hoster promote; empty disables replication"},
+	{Key: "replication.standby_url", Default: "", Doc: "Base URL of the standby a primary ships snapshots to"},
+	{Key: "replication.secret", Default: "", Secret: true, Doc: "Shared secret the primary sends with snapshots and the standby checks"},
+	{Key: "replication.interval", Default: "1m", Doc: "How often a primary ships a snapshot of the database and domain.config_dir"},
+	{Key: "replication.timeout", Default: "5m", Doc: "Timeout of one shipment"},
+	{Key: "replication.max_lag", Default: "5m", Doc: "Snapshot age over which a standby reports it is lagging"},
+	{Key: "replication.keep", Default: 3, Doc: "How many snapshots a standby keeps"},
+	{Key: "replication.dir", Default: "", Doc: "Directory for snapshots; defaults to <data_dir>/replication"},
+
+	// Template playgrounds
+	{Key: "playground.node", Default: "", Doc: "Reference ID of the sandbox node marketplace playgrounds run on; empty disables playgrounds"},
+	{Key: "playground.ttl", Default: "30m", Doc: "How long a playground runs before it is stopped and deleted; 30m to 60m"},
+	{Key: "playground.max_sessions", Default: 20, Doc: "Playgrounds running at once"},
+	{Key: "playground.max_per_client", Default: 1, Doc: "Playgrounds running at once from one client address"},
+	{Key: "playground.memory_mb", Default: 256, Doc: "Memory cap of each playground service"},
+	{Key: "playground.cpu_cores", Default: 0.5, Doc: "CPU cap of each playground service"},
+
+	// Deployment tunnels
+	{Key: "tunnel.enabled", Default: true, Doc: "Let deployment owners open WebSocket tunnels to container ports (hoster tunnel); needs remote nodes"},
+	{Key: "tunnel.max_duration", Default: "1h", Doc: "Longest a tunnel session may last; at least 1m"},
+	{Key: "tunnel.bandwidth_kb", Default: 0, ZeroOK: true, Doc: "Bandwidth cap of each direction of a tunnel session, in KiB/s; 0 disables the cap"},
+
+	// Deployment exec
+	{Key: "exec.allowlist", Default: []string{}, Doc: "Comma-separated commands deployment owners may run in containers, as argument prefixes (e.g. php artisan) or * for any; empty disables exec"},
+	{Key: "exec.max_timeout", Default: "10m", Doc: "Longest a deployment exec command may run; at least 1s"},
+	{Key: "exec.max_output_kb", Default: 1024, Doc: "Cap of each of an exec command's stdout and stderr, in KiB; at most 1024"},
+
+	// Chaos testing
+	{Key: "chaos.enabled", Default: false, Doc: "Inject the fault rules managed at /admin/faults; test environments only, needs a build with -tags chaos"},
+}
+
+// otherEnvPrefixes are HOSTER_ variables read by other commands and the
+// minion, which are not config keys.
+var otherEnvPrefixes = []string{"HOSTER_INIT_", "HOSTER_MINION_", "HOSTER_REQUEST_ID"}
+
+// schemaKey returns the schema entry for key.
+func schemaKey(key string) (configKey, bool) {
+	for _, k := range configSchema {
+		if k.Key == key {
+			return k, true
+		}
+	}
+	return configKey{}, false
+}
+
+// unknownKeys returns warnings for the keys in keys (as read from a config
+// file) and the HOSTER_ variables in environ that match no config key.
+func unknownKeys(keys, environ []string) []string {
+	known := make(map[string]bool, len(configSchema))
+	knownEnv := make(map[string]bool, len(configSchema))
+	for _, k := range configSchema {
+		known[k.Key] = true
+		knownEnv[k.Env()] = true
+	}
+
+	var warnings []string
+	for _, key := range keys {
+		if !known[key] {
+			warnings = append(warnings, fmt.Sprintf("unknown config key %q is ignored", key))
+		}
+	}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix+"_") || knownEnv[name] || hasAnyPrefix(name, otherEnvPrefixes) {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("unknown environment variable %s is ignored", name))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Config Values
+// =============================================================================
+
+// configFields returns the leaf fields of cfg keyed by their dotted
+// mapstructure key.
+func configFields(cfg *Config) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			key := prefix + tag
+			if f := v.Field(i); f.Kind() == reflect.Struct {
+				walk(key+".", f)
+			} else {
+				fields[key] = f
+			}
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// typeName names a config field's type for `hoster config env`.
+func typeName(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "duration"
+	case t.Kind() == reflect.Bool:
+		return "bool"
+	case t.Kind() == reflect.Int, t.Kind() == reflect.Int64:
+		return "int"
+	case t.Kind() == reflect.Float64:
+		return "float"
+	case t.Kind() == reflect.Slice:
+		return "list"
+	}
+	return "string"
+}
+
+// formatValue renders a config value the way it is written in the
+// environment.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// redacted replaces the values of set secret keys when the config is printed.
+const redacted = "<redacted>"
+
+// effectiveValue returns key's value in cfg for printing, redacting secrets.
+func effectiveValue(k configKey, f reflect.Value) any {
+	if k.Secret && !f.IsZero() {
+		return redacted
+	}
+	if f.Type() == durationType {
+		return time.Duration(f.Int()).String()
+	}
+	return f.Interface()
+}
+
+// =============================================================================
+// Validation
+// =============================================================================
+
+// Validate checks the config for values that would otherwise only fail at
+// runtime. It reports every problem at once, each prefixed with its key.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Types, ranges and required keys from the schema
+	fields := configFields(c)
+	for _, k := range configSchema {
+		f, ok := fields[k.Key]
+		if !ok {
+			continue
+		}
+		switch {
+		case k.Required && f.IsZero():
+			fail(k.Key, "is required")
+		case f.Type() == durationType || f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+			if n := f.Int(); n < 0 || (n == 0 && !k.ZeroOK) {
+				fail(k.Key, "must be positive, got %s", formatValue(f.Interface()))
+			}
+		}
+	}
+	for _, port := range []struct {
+		key  string
+		port int
+	}{
+		{"server.port", c.Server.Port},
+		{"proxy.port", c.Proxy.Port},
+		{"notifications.smtp_port", c.Notifications.SMTPPort},
+	} {
+		if port.port > 65535 {
+			fail(port.key, "must be a port between 1 and 65535, got %d", port.port)
+		}
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		fail("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		fail("log.format", "must be json or text, got %q", c.Log.Format)
+	}
+
+	if c.Billing.PlatformFeePercent < 0 || c.Billing.PlatformFeePercent > 100 {
+		fail("billing.platform_fee_percent", "must be between 0 and 100, got %v", c.Billing.PlatformFeePercent)
+	}
+	if _, err := spending.ParsePrices(c.Billing.UsagePrices); err != nil {
+		fail("billing.usage_prices", "%v", err)
+	}
+	if _, err := invoice.ParsePlanPrices(c.Billing.PlanUsagePrices); err != nil {
+		fail("billing.plan_usage_prices", "%v", err)
+	}
+
+	// Nodes
+	remoteNodes := c.Nodes.EncryptionKey != ""
+	if n := len(c.Nodes.EncryptionKey); remoteNodes && n != 32 {
+		fail("nodes.encryption_key", "must be exactly 32 bytes for AES-256-GCM, got %d", n)
+	}
+	_, err := minionHandshakePolicy(c.Nodes)
+	check(err)
+	if _, err := scheduler.ParseStrategy(c.Nodes.SchedulingStrategy); err != nil {
+		fail("nodes.scheduling_strategy", "%v", err)
+	}
+	if c.Nodes.CommandWorkers < 1 {
+		fail("nodes.command_workers", "must be at least 1, got %d", c.Nodes.CommandWorkers)
+	}
+
+	// Proxy
+	if c.Proxy.Enabled && c.Proxy.BaseDomain == "" {
+		fail("proxy.base_domain", "is required when proxy.enabled is set")
+	}
+	mode, err := traefik.ParseMode(c.Proxy.Traefik.Mode)
+	if err != nil {
+		fail("proxy.traefik.mode", "%v", err)
+	}
+	// Labels mode writes a file too, with the certificates hoster issues
+	if mode == traefik.ModeFile || (mode == traefik.ModeLabels && c.ACME.Enabled) {
+		if err := traefik.ValidateConfigPath(c.Proxy.Traefik.ConfigPath); err != nil {
+			fail("proxy.traefik.config_path", "%v", err)
+		}
+	}
+	if c.Proxy.Traefik.RedirectHTTP && !c.Proxy.Traefik.TLS {
+		fail("proxy.traefik.redirect_http", "requires proxy.traefik.tls")
+	}
+
+	// Credential pairs are set together or not at all
+	for _, pair := range []struct {
+		a, b       string
+		aSet, bSet bool
+	}{
+		{"secrets.aws_access_key_id", "secrets.aws_secret_access_key", c.Secrets.AWSAccessKeyID != "", c.Secrets.AWSSecretAccessKey != ""},
+		{"storage.s3_access_key_id", "storage.s3_secret_access_key", c.Storage.S3AccessKeyID != "", c.Storage.S3SecretAccessKey != ""},
+		{"notifications.vapid_public_key", "notifications.vapid_private_key", c.Notifications.VAPIDPublicKey != "", c.Notifications.VAPIDPrivateKey != ""},
+		{"notifications.smtp_username", "notifications.smtp_password", c.Notifications.SMTPUsername != "", c.Notifications.SMTPPassword != ""},
+		{"server.tls_cert_file", "server.tls_key_file", c.Server.TLSCertFile != "", c.Server.TLSKeyFile != ""},
+	} {
+		if pair.aSet != pair.bSet {
+			fail(pair.a, "must be set together with %s", pair.b)
+		}
+	}
+
+	// Storage
+	s3 := c.Storage.S3Endpoint != "" || c.Storage.S3Region != ""
+	if s3 && !remoteNodes {
+		fail("storage.s3_endpoint", "requires nodes.encryption_key to store bucket credentials")
+	}
+	switch corestorage.Backend(c.Storage.DefaultBackend) {
+	case "":
+	case corestorage.BackendNode:
+		if !c.Storage.NodeBackend || !remoteNodes {
+			fail("storage.default_backend", "node requires storage.node_backend and nodes.encryption_key")
+		}
+	case corestorage.BackendS3:
+		if !s3 {
+			fail("storage.default_backend", "s3 requires storage.s3_endpoint or storage.s3_region")
+		}
+	default:
+		fail("storage.default_backend", "must be node or s3, got %q", c.Storage.DefaultBackend)
+	}
+	if c.Backup.S3Bucket != "" && !s3 {
+		fail("backup.s3_bucket", "requires storage.s3_endpoint or storage.s3_region")
+	}
+
+	// Notifications
+	if c.Notifications.VAPIDPublicKey != "" && c.Notifications.VAPIDPrivateKey != "" {
+		if _, err := notify.NewVAPID(notify.VAPIDConfig{
+			PublicKey:  c.Notifications.VAPIDPublicKey,
+			PrivateKey: c.Notifications.VAPIDPrivateKey,
+			Subject:    c.Notifications.VAPIDSubject,
+		}); err != nil {
+			fail("notifications.vapid_private_key", "%v", err)
+		}
+	}
+	if c.Notifications.SMTPHost != "" {
+		if _, err := mail.NewSMTPMailer(mail.SMTPConfig{
+			Host: c.Notifications.SMTPHost,
+			Port: c.Notifications.SMTPPort,
+			From: c.Notifications.MailFrom,
+		}); err != nil {
+			fail("notifications.smtp_host", "%v", err)
+		}
+	}
+
+	// Auth backends
+	check(validateAuthBackends(c))
+
+	// Registry, API versions and uploads
+	_, err = newTemplateRegistry(c.Registry, c.Notifications.AppURL)
+	check(err)
+	_, err = newAPILifecycles(c.Server)
+	check(err)
+	if addr := c.Uploads.ClamdAddress; addr != "" && !strings.HasPrefix(addr, "/") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			fail("uploads.clamd_address", "must be host:port or an absolute unix socket path, got %q", addr)
+		}
+	}
+
+	// Read-only replicas
+	switch c.ReadOnly.Source {
+	case readOnlySourceReplica, readOnlySourceBackups:
+	default:
+		fail("read_only.source", "must be replica or backups, got %q", c.ReadOnly.Source)
+	}
+
+	// Cold-standby replication
+	if err := replication.ValidateRole(c.Replication.Role); err != nil {
+		fail("replication.role", "%v", err)
+	}
+	if c.Replication.Role != "" {
+		if c.ReadOnly.Enabled {
+			fail("replication.role", "can't be set on a read-only replica")
+		}
+		if c.Replication.Secret == "" {
+			fail("replication.secret", "is required when replication.role is set")
+		}
+	}
+	if c.Replication.Role == replication.RolePrimary {
+		if u, err := url.Parse(c.Replication.StandbyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("replication.standby_url", "must be an http or https URL when replication.role is primary, got %q", c.Replication.StandbyURL)
+		}
+	}
+
+	// Template playgrounds
+	if c.Playground.Node != "" {
+		if err := playground.ValidateTTL(c.Playground.TTL); err != nil {
+			fail("playground.ttl", "%v", err)
+		}
+		if c.Playground.CPUCores <= 0 {
+			fail("playground.cpu_cores", "must be positive, got %v", c.Playground.CPUCores)
+		}
+		if c.ReadOnly.Enabled {
+			fail("playground.node", "can't be set on a read-only replica")
+		}
+	}
+
+	// ACME certificates
+	if c.ACME.Enabled {
+		if !remoteNodes {
+			fail("acme.enabled", "requires nodes.encryption_key, which encrypts certificate keys")
+		}
+		if u, err := url.Parse(c.ACME.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			fail("acme.directory_url", "must be an https URL, got %q", c.ACME.DirectoryURL)
+		}
+		if mode != traefik.ModeOff {
+			if u, err := url.Parse(c.ACME.ChallengeUpstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("acme.challenge_upstream", "must be an http or https URL when proxy.traefik.mode is set, got %q", c.ACME.ChallengeUpstream)
+			}
+		}
+		switch c.ACME.DNSProvider {
+		case "":
+		case "digitalocean":
+			if c.ACME.DNSZone == "" {
+				fail("acme.dns_zone", "is required with acme.dns_provider")
+			}
+			if c.ACME.DNSToken == "" {
+				fail("acme.dns_token", "is required with acme.dns_provider")
+			}
+		default:
+			fail("acme.dns_provider", "must be digitalocean or empty, got %q", c.ACME.DNSProvider)
+		}
+	}
+	if c.Proxy.TLSPort != 0 && !c.ACME.Enabled {
+		fail("proxy.tls_port", "requires acme.enabled")
+	}
+
+	// Deployment tunnels
+	if c.Tunnel.Enabled && c.Tunnel.MaxDuration < tunnel.MinDuration {
+		fail("tunnel.max_duration", "must be at least %s, got %s", tunnel.MinDuration, c.Tunnel.MaxDuration)
+	}
+
+	// Deployment exec
+	if _, err := containerexec.ParseAllowlist(c.Exec.Allowlist); err != nil {
+		fail("exec.allowlist", "%v", err)
+	}
+	if c.Exec.MaxTimeout < containerexec.MinTimeout {
+		fail("exec.max_timeout", "must be at least %s, got %s", containerexec.MinTimeout, c.Exec.MaxTimeout)
+	}
+	if c.Exec.MaxOutputKB > 1024 {
+		fail("exec.max_output_kb", "must be at most 1024, got %d", c.Exec.MaxOutputKB)
+	}
+
+	// Chaos testing
+	if c.Chaos.Enabled && !engine.FaultInjectionBuilt {
+		fail("chaos.enabled", "needs a hoster binary built with -tags chaos")
+	}
+
+	return errors.Join(errs...)
+}
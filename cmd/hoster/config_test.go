@@ -1,11 +1,14 @@
 package main
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/artpar/hoster/internal/engine"
+	"github.com/artpar/hoster/internal/shell/logging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -29,6 +32,9 @@ func TestLoadConfig_DefaultValues(t *testing.T) {
 	assert.Equal(t, "data/hoster.db", cfg.Database.DSN)
 	assert.Equal(t, "info", cfg.Log.Level)
 	assert.Equal(t, "json", cfg.Log.Format)
+	assert.True(t, cfg.Backup.Enabled)
+	assert.Equal(t, 6*time.Hour, cfg.Backup.Interval)
+	assert.Equal(t, 28, cfg.Backup.Keep)
 }
 
 func TestLoadConfig_FromFile(t *testing.T) {
@@ -96,6 +102,7 @@ func TestLoadConfig_DataDirDerivesDSN(t *testing.T) {
 
 	assert.Equal(t, "/var/lib/hoster/hoster.db", cfg.Database.DSN)
 	assert.Equal(t, "/var/lib/hoster/configs", cfg.Domain.ConfigDir)
+	assert.Equal(t, "/var/lib/hoster/backups", cfg.Backup.Dir)
 }
 
 func TestLoadConfig_ExplicitDSNOverridesDataDir(t *testing.T) {
@@ -209,6 +216,23 @@ func TestSetupLogger_ErrorLevel(t *testing.T) {
 	assert.NotNil(t, logger)
 }
 
+func TestSetupLogger_DebugSampling(t *testing.T) {
+	cfg := &Config{
+		Log: LogConfig{
+			Level:           "debug",
+			Format:          "json",
+			DebugSampleRate: 10,
+		},
+	}
+
+	logger := SetupLogger(cfg)
+	assert.IsType(t, &logging.SamplingHandler{}, logger.Handler())
+
+	cfg.Log.DebugSampleRate = 1
+	logger = SetupLogger(cfg)
+	assert.IsType(t, &slog.JSONHandler{}, logger.Handler(), "1 keeps every debug log")
+}
+
 // =============================================================================
 // Config Validation Tests
 // =============================================================================
@@ -224,6 +248,159 @@ func TestConfig_Address(t *testing.T) {
 	assert.Equal(t, "localhost:8080", cfg.Server.Address())
 }
 
+// =============================================================================
+// Config Schema Tests
+// =============================================================================
+
+func TestConfigSchema_CoversConfig(t *testing.T) {
+	fields := configFields(&Config{})
+	seen := map[string]bool{}
+	for _, k := range configSchema {
+		assert.False(t, seen[k.Key], "duplicate key %s", k.Key)
+		seen[k.Key] = true
+		assert.Contains(t, fields, k.Key, "schema key %s has no config field", k.Key)
+		assert.NotEmpty(t, k.Doc, "key %s is undocumented", k.Key)
+	}
+	for key := range fields {
+		assert.True(t, seen[key], "config field %s is missing from the schema", key)
+	}
+	assert.Equal(t, "HOSTER_PROXY_TRAEFIK_CONFIG_PATH", configKey{Key: "proxy.traefik.config_path"}.Env())
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HOSTER_LOG_LEVEL", "loud")
+	t.Setenv("HOSTER_NODES_ENCRYPTION_KEY", "too-short")
+	t.Setenv("HOSTER_NOTIFICATIONS_SMTP_USERNAME", "mailer")
+	t.Setenv("HOSTER_PROXY_TRAEFIK_MODE", "sidecar")
+
+	_, err := LoadConfig("")
+	require.Error(t, err)
+	for _, key := range []string{"log.level", "nodes.encryption_key", "notifications.smtp_username", "proxy.traefik.mode"} {
+		assert.Contains(t, err.Error(), key+":")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	clearEnv(t)
+	valid, err := LoadConfig("")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+		key    string
+	}{
+		{"port out of range", func(c *Config) { c.Proxy.Port = 70000 }, "proxy.port"},
+		{"zero duration", func(c *Config) { c.Billing.ReportInterval = 0 }, "billing.report_interval"},
+		{"required key", func(c *Config) { c.Domain.BaseDomain = "" }, "domain.base_domain"},
+		{"fee over 100", func(c *Config) { c.Billing.PlatformFeePercent = 120 }, "billing.platform_fee_percent"},
+		{"bad usage price", func(c *Config) { c.Billing.UsagePrices = []string{"gpu.usage"} }, "billing.usage_prices"},
+		{"bad plan usage price", func(c *Config) { c.Billing.PlanUsagePrices = []string{"gpu.usage=1"} }, "billing.plan_usage_prices"},
+		{"half a key pair", func(c *Config) { c.Storage.S3AccessKeyID = "AKIA" }, "storage.s3_access_key_id"},
+		{"s3 without endpoint", func(c *Config) { c.Storage.DefaultBackend = "s3" }, "storage.default_backend"},
+		{"backup bucket without s3", func(c *Config) { c.Backup.S3Bucket = "backups" }, "backup.s3_bucket"},
+		{"smtp without sender", func(c *Config) { c.Notifications.SMTPHost = "smtp.example.com" }, "notifications.smtp_host"},
+		{"redirect without tls", func(c *Config) { c.Proxy.Traefik.TLS, c.Proxy.Traefik.RedirectHTTP = false, true }, "proxy.traefik.redirect_http"},
+		{"bad api date", func(c *Config) { c.Server.APIV1SunsetAt = "soon" }, "server.api_v1_sunset_at"},
+		{"bad clamd address", func(c *Config) { c.Uploads.ClamdAddress = "clamd" }, "uploads.clamd_address"},
+		{"unknown auth backend", func(c *Config) { c.Auth.Backends = []string{"ldap"} }, "auth.backends"},
+		{"duplicate auth backend", func(c *Config) { c.Auth.Backends = []string{"api_token", "api_token"} }, "auth.backends"},
+		{"oidc without issuer", func(c *Config) { c.Auth.Backends = []string{"oidc"} }, "auth.oidc.issuer"},
+		{"mtls without tls", func(c *Config) { c.Auth.Backends = []string{"mtls"} }, "server.tls_cert_file"},
+		{"bad cert identity", func(c *Config) { c.Auth.MTLS.Identity = "serial" }, "auth.mtls.identity"},
+		{"tls cert without key", func(c *Config) { c.Server.TLSCertFile = "api.pem" }, "server.tls_cert_file"},
+		{"unknown read-only source", func(c *Config) { c.ReadOnly.Source = "litestream" }, "read_only.source"},
+		{"unknown replication role", func(c *Config) { c.Replication.Role = "replica" }, "replication.role"},
+		{"replication without secret", func(c *Config) { c.Replication.Role = "standby" }, "replication.secret"},
+		{"primary without standby", func(c *Config) { c.Replication.Role, c.Replication.Secret = "primary", "s3cret" }, "replication.standby_url"},
+		{"playground ttl too long", func(c *Config) { c.Playground.Node, c.Playground.TTL = "node_1", 2*time.Hour }, "playground.ttl"},
+		{"playground without cpu cap", func(c *Config) { c.Playground.Node, c.Playground.CPUCores = "node_1", 0 }, "playground.cpu_cores"},
+		{"tunnel duration too short", func(c *Config) { c.Tunnel.MaxDuration = 30 * time.Second }, "tunnel.max_duration"},
+		{"bad exec allowlist", func(c *Config) { c.Exec.Allowlist = []string{"php *"} }, "exec.allowlist"},
+		{"exec timeout too short", func(c *Config) { c.Exec.MaxTimeout = time.Millisecond }, "exec.max_timeout"},
+		{"acme without encryption key", func(c *Config) { c.ACME.Enabled = true }, "acme.enabled"},
+		{"acme dns without zone", func(c *Config) { c.ACME.Enabled, c.ACME.DNSProvider = true, "digitalocean" }, "acme.dns_zone"},
+		{"acme with traefik but no upstream", func(c *Config) { c.ACME.Enabled, c.Proxy.Traefik.Mode = true, "file" }, "acme.challenge_upstream"},
+		{"proxy tls without acme", func(c *Config) { c.Proxy.TLSPort = 9443 }, "proxy.tls_port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *valid
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.key+":")
+		})
+	}
+
+	cfg := *valid
+	cfg.Backup.Keep, cfg.Backup.MaxAge = 0, 0
+	cfg.Uploads.ClamdAddress = "/run/clamav/clamd.ctl"
+	assert.NoError(t, cfg.Validate(), "zero keep and max age disable retention")
+
+	cfg = *valid
+	cfg.Auth.Backends = []string{"api_token", "oidc", "shared_secret"}
+	cfg.Auth.OIDC.Issuer, cfg.Auth.OIDC.Audience = "https://idp.example.com", "hoster"
+	assert.NoError(t, cfg.Validate(), "chained backends")
+
+	cfg = *valid
+	cfg.Chaos.Enabled = true
+	if engine.FaultInjectionBuilt {
+		assert.NoError(t, cfg.Validate(), "chaos build")
+	} else {
+		assert.ErrorContains(t, cfg.Validate(), "chaos.enabled:", "chaos mode needs a chaos build")
+	}
+
+	cfg = *valid
+	cfg.Replication.Role, cfg.Replication.Secret = "primary", "s3cret"
+	cfg.Replication.StandbyURL = "https://standby.example.com"
+	assert.NoError(t, cfg.Validate(), "replication primary")
+
+	cfg = *valid
+	cfg.Playground.Node, cfg.Playground.TTL = "node_1", 45*time.Minute
+	assert.NoError(t, cfg.Validate(), "playground")
+
+	cfg = *valid
+	cfg.Nodes.EncryptionKey = "0123456789abcdef0123456789abcdef"
+	cfg.ACME.Enabled, cfg.Proxy.TLSPort = true, 9443
+	cfg.Proxy.Traefik.Mode, cfg.ACME.ChallengeUpstream = "labels", "http://10.0.0.2:8080"
+	cfg.ACME.DNSProvider, cfg.ACME.DNSZone, cfg.ACME.DNSToken = "digitalocean", "acme.hoster.io", "dop_v1_token"
+	assert.NoError(t, cfg.Validate(), "acme")
+}
+
+func TestLoadConfig_WarnsUnknownKeys(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HOSTER_APP_PROXY_ENABLED", "true")
+	t.Setenv("HOSTER_INIT_ADMIN_EMAIL", "admin@example.com")
+
+	tmpFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmpFile, []byte("server:\n  prot: 9000\n"), 0644))
+
+	cfg, err := LoadConfig(tmpFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`unknown config key "server.prot" is ignored`,
+		"unknown environment variable HOSTER_APP_PROXY_ENABLED is ignored",
+	}, cfg.Warnings)
+}
+
+func TestEffectiveValue_RedactsSecrets(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("HOSTER_BILLING_API_KEY", "ak_live_secret")
+
+	cfg, err := LoadConfig("")
+	require.NoError(t, err)
+	fields := configFields(cfg)
+
+	apiKey, _ := schemaKey("billing.api_key")
+	assert.Equal(t, redacted, effectiveValue(apiKey, fields["billing.api_key"]))
+	stripeKey, _ := schemaKey("billing.stripe_key")
+	assert.Equal(t, "", effectiveValue(stripeKey, fields["billing.stripe_key"]), "unset secrets show as empty")
+	interval, _ := schemaKey("billing.report_interval")
+	assert.Equal(t, "1m0s", effectiveValue(interval, fields["billing.report_interval"]))
+}
+
 // =============================================================================
 // Test Helpers
 // =============================================================================
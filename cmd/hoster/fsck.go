@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/artpar/hoster/internal/core/integrity"
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// runFsck implements `hoster fsck [--repair] [--json] [-config path]`.
+// It scans the store for integrity problems and exits non-zero if any are found.
+// The database is checked as it is: fsck runs no migrations, and refuses to
+// scan a schema at another version than this build's.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	repair := fs.Bool("repair", false, "Back up and null out unparseable JSON columns")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+
+	ctx := context.Background()
+	store, err := engine.OpenExistingDB(cfg.Database.DSN, engine.Schema())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return ExitDatabaseError
+	}
+	defer store.Close()
+
+	version, latest, dirty, err := store.SchemaVersion(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		return ExitDatabaseError
+	}
+	if err := integrity.CheckSchemaVersion(version, latest, dirty); err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	report, err := store.CheckIntegrity(ctx, *repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, issue := range report.Issues {
+			fmt.Println(issue.String())
+		}
+		fmt.Println(report.Summary())
+		if !*repair && report.CountByKind()[integrity.IssueInvalidJSON] > 0 {
+			fmt.Println("run with --repair to back up and null out unparseable JSON columns")
+		}
+	}
+
+	if !report.OK() && report.Repaired < len(report.Issues) {
+		return ExitIntegrityError
+	}
+	return ExitSuccess
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/bootstrap"
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// initResult is what `hoster init --json` prints.
+type initResult struct {
+	Admin            bootstrap.Admin              `json:"admin"`
+	StarterTemplates bool                         `json:"starter_templates"`
+	SharedSecret     string                       `json:"shared_secret,omitempty"`
+	Plans            map[string]engine.PlanLimits `json:"plans"`
+}
+
+// runInit implements `hoster init [--admin-email addr] [--admin-id id]
+// [--admin-name name] [--admin-plan plan] [--starter-templates=false]
+// [--non-interactive] [--json] [-config path]`. It creates the first admin
+// on a fresh database and prints the settings APIGate needs. Flags default
+// to HOSTER_INIT_* environment variables; anything still missing is asked
+// for on the terminal unless --non-interactive is set.
+func runInit(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	email := fs.String("admin-email", os.Getenv("HOSTER_INIT_ADMIN_EMAIL"), "Admin email address")
+	name := fs.String("admin-name", os.Getenv("HOSTER_INIT_ADMIN_NAME"), "Admin display name (default: email local part)")
+	refID := fs.String("admin-id", os.Getenv("HOSTER_INIT_ADMIN_ID"), "Admin user ID as sent by APIGate in X-User-ID (default: generated)")
+	plan := fs.String("admin-plan", os.Getenv("HOSTER_INIT_ADMIN_PLAN"), "Admin plan: "+strings.Join(engine.DefaultPlanIDs, ", ")+" (default: "+bootstrap.DefaultAdminPlan+")")
+	starter := fs.Bool("starter-templates", envBool("HOSTER_INIT_STARTER_TEMPLATES", true), "Keep the built-in starter templates")
+	nonInteractive := fs.Bool("non-interactive", envBool("HOSTER_INIT_NON_INTERACTIVE", false), "Fail instead of prompting for missing values")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "init: unexpected arguments")
+		return ExitConfigError
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+
+	logger := SetupLogger(cfg)
+	store, err := engine.OpenDB(cfg.Database.DSN, engine.Schema(), logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return ExitDatabaseError
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	initialized, err := store.Initialized(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init: %v\n", err)
+		return ExitDatabaseError
+	}
+	if initialized {
+		fmt.Fprintf(os.Stderr, "init: %v; add admins with auth.admins instead\n", engine.ErrAlreadyInitialized)
+		return ExitAlreadyInitialized
+	}
+
+	if !*nonInteractive {
+		in := bufio.NewReader(os.Stdin)
+		if *email == "" {
+			*email = prompt(in, "Admin email", "")
+		}
+		if *refID == "" {
+			*refID = prompt(in, "Admin user ID from APIGate (blank to generate)", "")
+		}
+		if *plan == "" {
+			*plan = prompt(in, "Admin plan", bootstrap.DefaultAdminPlan)
+		}
+	}
+
+	if *refID == "" {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			fmt.Fprintf(os.Stderr, "init: %v\n", err)
+			return ExitConfigError
+		}
+		*refID = bootstrap.NewReferenceID(random)
+	}
+	admin, err := bootstrap.Admin{ReferenceID: *refID, Email: *email, Name: *name, PlanID: *plan}.Normalize(engine.DefaultPlanIDs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init: %v\n", err)
+		return ExitConfigError
+	}
+
+	// Keep a configured secret; generate one only for installs without it.
+	secret := ""
+	if cfg.Auth.SharedSecret == "" {
+		random := make([]byte, bootstrap.SecretBytes)
+		if _, err := rand.Read(random); err != nil {
+			fmt.Fprintf(os.Stderr, "init: %v\n", err)
+			return ExitConfigError
+		}
+		secret, _ = bootstrap.EncodeSharedSecret(random)
+	} else if err := bootstrap.ValidateSharedSecret(cfg.Auth.SharedSecret); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: auth.shared_secret: %v\n", err)
+	}
+
+	if _, err := store.Bootstrap(ctx, admin, *starter); err != nil {
+		if errors.Is(err, engine.ErrAlreadyInitialized) {
+			fmt.Fprintf(os.Stderr, "init: %v\n", err)
+			return ExitAlreadyInitialized
+		}
+		fmt.Fprintf(os.Stderr, "init: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	plans := make(map[string]engine.PlanLimits, len(engine.DefaultPlanIDs))
+	for _, id := range engine.DefaultPlanIDs {
+		plans[id] = engine.DefaultPlanLimits(id)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(initResult{Admin: admin, StarterTemplates: *starter, SharedSecret: secret, Plans: plans})
+		return ExitSuccess
+	}
+
+	fmt.Printf("created admin %s <%s> (user id %s, plan %s)\n", admin.Name, admin.Email, admin.ReferenceID, admin.PlanID)
+	if *starter {
+		fmt.Println("kept the built-in starter templates")
+	} else {
+		fmt.Println("removed the built-in starter templates")
+	}
+	if secret != "" {
+		fmt.Println()
+		fmt.Println("shared secret for APIGate's X-APIGate-Secret header (keep secret):")
+		fmt.Printf("HOSTER_AUTH_SHARED_SECRET=%s\n", secret)
+	}
+	fmt.Println()
+	fmt.Println("plans (X-Plan-ID / X-Plan-Limits):")
+	for _, id := range engine.DefaultPlanIDs {
+		limits, _ := json.Marshal(plans[id])
+		fmt.Printf("  %s: %s\n", id, limits)
+	}
+	fmt.Println()
+	fmt.Println("the admin authenticates through APIGate, or directly with:")
+	fmt.Printf("  X-User-ID: %s\n", admin.ReferenceID)
+	fmt.Printf("  X-Plan-ID: %s\n", admin.PlanID)
+	fmt.Println("  X-APIGate-Secret: <shared secret>")
+	return ExitSuccess
+}
+
+// prompt asks for a value on stderr and reads a line, returning def for a
+// blank answer or when input is closed.
+func prompt(in *bufio.Reader, label, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return def
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def
+	}
+	return line
+}
+
+// envBool reads a boolean environment variable, falling back to def when it
+// is unset or malformed.
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
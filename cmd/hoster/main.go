@@ -4,7 +4,9 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	_ "time/tzdata" // users' time zones load without a zone database on the host
 )
 
 // Version information (set by build)
@@ -18,9 +20,38 @@ func main() {
 }
 
 func run() int {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			return runInit(os.Args[2:])
+		case "fsck":
+			return runFsck(os.Args[2:])
+		case "archives":
+			return runArchives(os.Args[2:])
+		case "backups":
+			return runBackups(os.Args[2:])
+		case "restore":
+			return runRestore(os.Args[2:])
+		case "promote":
+			return runPromote(os.Args[2:])
+		case "minion-sign":
+			return runMinionSign(os.Args[2:])
+		case "vapid-keys":
+			return runVAPIDKeys(os.Args[2:])
+		case "registry-keys":
+			return runRegistryKeys(os.Args[2:])
+		case "config":
+			return runConfig(os.Args[2:])
+		case "tunnel":
+			return runTunnel(os.Args[2:])
+		}
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "", "Path to config file")
 	showVersion := flag.Bool("version", false, "Print version and exit")
+	devSandbox := flag.Bool("dev-sandbox", false, "Run nodes on in-memory Docker hosts instead of SSH (development only)")
 	flag.Parse()
 
 	// Handle version flag
@@ -35,13 +66,22 @@ func run() int {
 		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 		return ExitConfigError
 	}
+	if *devSandbox {
+		cfg.Nodes.Sandbox = true
+	}
 
 	// Setup logger
 	logger := SetupLogger(cfg)
+	// Code without a request-scoped logger, such as minion calls made
+	// outside a request, logs through the default.
+	slog.SetDefault(logger)
 	logger.Info("starting hoster",
 		"version", Version,
 		"config", *configPath,
 	)
+	for _, warning := range cfg.Warnings {
+		logger.Warn("configuration warning", "warning", warning)
+	}
 
 	// Create server
 	server, err := NewServer(cfg, logger)
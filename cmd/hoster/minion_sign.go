@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// runMinionSign implements `hoster minion-sign`.
+//
+//	hoster minion-sign -keygen
+//	hoster minion-sign internal/shell/docker/binaries/minion-linux-amd64 ...
+//
+// The signing key is read from HOSTER_MINION_SIGNING_KEY (base64 Ed25519 seed)
+// or -key-file. Each binary's sha256 digest is signed and the signature
+// written beside it as <binary>.sig. See `make build-minion`.
+func runMinionSign(args []string) int {
+	fs := flag.NewFlagSet("minion-sign", flag.ContinueOnError)
+	keygen := fs.Bool("keygen", false, "Generate a new signing key pair")
+	keyFile := fs.String("key-file", "", "File containing the base64 signing key (default: $HOSTER_MINION_SIGNING_KEY)")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+
+	if *keygen {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "generate key: %v\n", err)
+			return ExitConfigError
+		}
+		fmt.Printf("signing key (HOSTER_MINION_SIGNING_KEY, keep secret): %s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
+		fmt.Printf("public key (HOSTER_NODES_MINION_PUBLIC_KEY): %s\n", base64.StdEncoding.EncodeToString(pub))
+		return ExitSuccess
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "minion-sign: no minion binaries given")
+		return ExitConfigError
+	}
+
+	encoded := os.Getenv("HOSTER_MINION_SIGNING_KEY")
+	if *keyFile != "" {
+		b, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read key file: %v\n", err)
+			return ExitConfigError
+		}
+		encoded = string(b)
+	}
+	if encoded == "" {
+		fmt.Fprintln(os.Stderr, "minion-sign: no signing key (set HOSTER_MINION_SIGNING_KEY or -key-file)")
+		return ExitConfigError
+	}
+	key, err := minion.ParsePrivateKey(encoded)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minion-sign: %v\n", err)
+		return ExitConfigError
+	}
+
+	for _, path := range fs.Args() {
+		binary, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "minion-sign: %v\n", err)
+			return ExitConfigError
+		}
+		digest := minion.Digest(binary)
+		signature := minion.SignDigest(key, digest)
+		if err := os.WriteFile(path+minion.SignatureSuffix, []byte(signature+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "minion-sign: %v\n", err)
+			return ExitConfigError
+		}
+		fmt.Printf("%s  sha256=%s\n", path+minion.SignatureSuffix, digest)
+	}
+	return ExitSuccess
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// =============================================================================
+// Read-Only Replica
+// =============================================================================
+
+// Read-only replica sources, as set in read_only.source.
+const (
+	readOnlySourceReplica = "replica"
+	readOnlySourceBackups = "backups"
+)
+
+// newReadOnlyServer creates a server that serves public endpoints from a
+// replica database. It runs no workers, node pool or app proxy.
+func newReadOnlyServer(cfg *Config, logger *slog.Logger) (*Server, error) {
+	fail := func(err error, code int) (*Server, error) {
+		return nil, &ServerError{Op: "NewServer", Err: err, ExitCode: code}
+	}
+
+	var replica *engine.ReplicaSyncer
+	if cfg.ReadOnly.Source == readOnlySourceBackups {
+		remote, err := newBackupRemote(cfg)
+		if err != nil {
+			return fail(err, ExitConfigError)
+		}
+		replica = engine.NewReplicaSyncer(cfg.Database.DSN, cfg.Backup.Dir, remote, cfg.ReadOnly.SyncInterval, logger)
+		if _, err := replica.Sync(context.Background()); err != nil {
+			// The database of an earlier run is served until a sync succeeds
+			if _, statErr := os.Stat(cfg.Database.DSN); statErr != nil {
+				return fail(err, ExitDatabaseError)
+			}
+			logger.Warn("replica sync failed, serving the existing database", "error", err)
+		}
+	}
+
+	store, err := engine.OpenReadOnlyDB(cfg.Database.DSN, engine.Schema(), logger)
+	if err != nil {
+		return fail(err, ExitDatabaseError)
+	}
+	store.SetSlowQueryLog(cfg.Database.SlowQueryThreshold, logger)
+
+	apiLifecycles, err := newAPILifecycles(cfg.Server)
+	if err != nil {
+		store.Close()
+		return fail(err, ExitConfigError)
+	}
+	tlsConfig, err := newServerTLSConfig(cfg)
+	if err != nil {
+		store.Close()
+		return fail(err, ExitConfigError)
+	}
+
+	handler := engine.Setup(engine.SetupConfig{
+		Store:         store,
+		Logger:        logger,
+		BaseDomain:    cfg.Domain.BaseDomain,
+		ConfigDir:     cfg.Domain.ConfigDir,
+		Version:       Version,
+		APILifecycles: apiLifecycles,
+		AppURL:        cfg.Notifications.AppURL,
+		MetricsToken:  cfg.Server.MetricsToken,
+		ReadOnly:      true,
+		CacheMaxAge:   cfg.ReadOnly.CacheMaxAge,
+		Replica:       replica,
+	})
+	logger.Info("read-only replica mode", "source", cfg.ReadOnly.Source, "cache_max_age", cfg.ReadOnly.CacheMaxAge)
+
+	return &Server{
+		config: cfg,
+		httpServer: &http.Server{
+			Addr:         cfg.Server.Address(),
+			Handler:      handler,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		},
+		store:   store,
+		replica: replica,
+		logger:  logger,
+	}, nil
+}
+
+// startReadOnly serves a read-only replica until shutdown.
+func (s *Server) startReadOnly(ctx context.Context) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	if s.replica != nil {
+		s.replica.Start()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting read-only HTTP server",
+			"address", s.config.Server.Address(),
+			"tls", s.config.Server.TLSCertFile != "")
+		var err error
+		if s.config.Server.TLSCertFile != "" {
+			err = s.httpServer.ListenAndServeTLS(s.config.Server.TLSCertFile, s.config.Server.TLSKeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case sig := <-sigCh:
+		s.logger.Info("received shutdown signal", "signal", sig)
+	case err := <-errCh:
+		return &ServerError{
+			Op:       "Start",
+			Err:      err,
+			ExitCode: ExitHTTPServerError,
+		}
+	case <-ctx.Done():
+		s.logger.Info("context cancelled")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("HTTP server shutdown error", "error", err)
+	}
+	if s.replica != nil {
+		s.replica.Stop()
+	}
+	if err := s.store.Close(); err != nil {
+		s.logger.Error("database close error", "error", err)
+	}
+	s.logger.Info("shutdown complete")
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// runRegistryKeys implements `hoster registry-keys`, which generates the key
+// pair that signs exported template bundles. Other instances add the public
+// key to registry.trusted_keys to import them.
+func runRegistryKeys(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "registry-keys: takes no arguments")
+		return ExitConfigError
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate key: %v\n", err)
+		return ExitConfigError
+	}
+	fmt.Printf("signing key (HOSTER_REGISTRY_SIGNING_KEY, keep secret): %s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
+	fmt.Printf("public key (for other instances' HOSTER_REGISTRY_TRUSTED_KEYS): %s\n", base64.StdEncoding.EncodeToString(pub))
+	return ExitSuccess
+}
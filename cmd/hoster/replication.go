@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/replication"
+	"github.com/artpar/hoster/internal/engine"
+)
+
+// =============================================================================
+// Cold Standby
+// =============================================================================
+
+// newStandbyServer creates a server that receives the primary's snapshots
+// and serves only health endpoints. It opens no database and runs no
+// workers, node pool or app proxy.
+func newStandbyServer(cfg *Config, logger *slog.Logger) (*Server, error) {
+	fail := func(err error, code int) (*Server, error) {
+		return nil, &ServerError{Op: "NewServer", Err: err, ExitCode: code}
+	}
+
+	receiver, err := engine.NewStandbyReceiver(cfg.Replication.Dir, cfg.Replication.Keep, cfg.Replication.MaxLag, logger)
+	if err != nil {
+		return fail(err, ExitDatabaseError)
+	}
+	tlsConfig, err := newServerTLSConfig(cfg)
+	if err != nil {
+		return fail(err, ExitConfigError)
+	}
+
+	handler := engine.StandbyHandler(engine.StandbyConfig{
+		Receiver: receiver,
+		Secret:   cfg.Replication.Secret,
+		Version:  Version,
+		Logger:   logger,
+	})
+	logger.Info("cold standby mode", "dir", cfg.Replication.Dir, "max_lag", cfg.Replication.MaxLag)
+
+	return &Server{
+		config: cfg,
+		httpServer: &http.Server{
+			Addr:         cfg.Server.Address(),
+			Handler:      handler,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		},
+		standby: receiver,
+		logger:  logger,
+	}, nil
+}
+
+// startStandby serves a standby until shutdown.
+func (s *Server) startStandby(ctx context.Context) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting standby HTTP server",
+			"address", s.config.Server.Address(),
+			"tls", s.config.Server.TLSCertFile != "")
+		var err error
+		if s.config.Server.TLSCertFile != "" {
+			err = s.httpServer.ListenAndServeTLS(s.config.Server.TLSCertFile, s.config.Server.TLSKeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case sig := <-sigCh:
+		s.logger.Info("received shutdown signal", "signal", sig)
+	case err := <-errCh:
+		return &ServerError{
+			Op:       "Start",
+			Err:      err,
+			ExitCode: ExitHTTPServerError,
+		}
+	case <-ctx.Done():
+		s.logger.Info("context cancelled")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("HTTP server shutdown error", "error", err)
+	}
+	s.logger.Info("shutdown complete")
+	return nil
+}
+
+// runPromote implements `hoster promote [--force] [-config path]`.
+// It makes a stopped standby the primary: the newest snapshot it received is
+// restored into database.dsn and domain.config_dir, the leases the old
+// primary held are released, and the promotion is recorded, so the next start
+// runs as the primary.
+func runPromote(args []string) int {
+	fs := flag.NewFlagSet("promote", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	force := fs.Bool("force", false, "Promote even though the server port is accepting connections")
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		return ExitConfigError
+	}
+	if cfg.Replication.Role != replication.RoleStandby {
+		fmt.Fprintln(os.Stderr, "promote runs on a standby; replication.role is not standby")
+		return ExitConfigError
+	}
+	promotion, err := engine.ReadPromotion(cfg.Replication.Dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "promote: %v\n", err)
+		return ExitDatabaseError
+	}
+	if promotion != nil {
+		fmt.Fprintf(os.Stderr, "this standby was already promoted at %s\n", promotion.PromotedAt.Format(time.RFC3339))
+		return ExitConfigError
+	}
+	if addr := serverProbeAddress(cfg.Server); !*force && portOpen(addr) {
+		fmt.Fprintf(os.Stderr, "a server is listening on %s; stop the standby before promoting it (or pass --force)\n", addr)
+		return ExitDatabaseError
+	}
+
+	ctx := context.Background()
+	m, aside, err := engine.PromoteStandby(ctx, cfg.Replication.Dir, cfg.Database.DSN, cfg.Domain.ConfigDir)
+	for _, path := range aside {
+		fmt.Fprintf(os.Stderr, "moved %s aside\n", path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "promote: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	// Open the database to migrate a snapshot taken by an older version and
+	// release the leases the old primary held, so this instance's workers
+	// and deployment locks start at once instead of waiting for them to
+	// expire.
+	logger := SetupLogger(cfg)
+	store, err := engine.OpenDB(cfg.Database.DSN, engine.Schema(), logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return ExitDatabaseError
+	}
+	released, err := store.ReleaseAllLeases(ctx)
+	store.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "promote: %v\n", err)
+		return ExitDatabaseError
+	}
+	if err := engine.MarkPromoted(cfg.Replication.Dir, m); err != nil {
+		fmt.Fprintf(os.Stderr, "promote: %v\n", err)
+		return ExitDatabaseError
+	}
+
+	fmt.Printf("promoted snapshot %s (taken %s, %s ago) into %s and %s\n",
+		m.ID, m.CreatedAt.Format(time.RFC3339), time.Since(m.CreatedAt).Round(time.Second), cfg.Database.DSN, cfg.Domain.ConfigDir)
+	fmt.Printf("released %d leases held by the old primary\n", released)
+	fmt.Println("start hoster to serve as the primary, then point DNS, remote proxies and payment webhooks at this host")
+	return ExitSuccess
+}
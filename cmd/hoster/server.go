@@ -2,17 +2,45 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/artpar/hoster/internal/core/containerexec"
+	"github.com/artpar/hoster/internal/core/coordination"
+	"github.com/artpar/hoster/internal/core/invoice"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	"github.com/artpar/hoster/internal/core/payout"
+	"github.com/artpar/hoster/internal/core/playground"
+	"github.com/artpar/hoster/internal/core/registry"
+	"github.com/artpar/hoster/internal/core/replication"
+	"github.com/artpar/hoster/internal/core/scheduler"
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+	"github.com/artpar/hoster/internal/core/spending"
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+	"github.com/artpar/hoster/internal/core/traefik"
+	"github.com/artpar/hoster/internal/core/tunnel"
 	"github.com/artpar/hoster/internal/engine"
 	"github.com/artpar/hoster/internal/shell/billing"
+	"github.com/artpar/hoster/internal/shell/dns"
 	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/artpar/hoster/internal/shell/mail"
+	"github.com/artpar/hoster/internal/shell/notify"
 	"github.com/artpar/hoster/internal/shell/proxy"
+	"github.com/artpar/hoster/internal/shell/scan"
+	"github.com/artpar/hoster/internal/shell/secrets"
+	"github.com/artpar/hoster/internal/shell/storage"
 )
 
 // =============================================================================
@@ -20,10 +48,12 @@ import (
 // =============================================================================
 
 const (
-	ExitSuccess         = 0
-	ExitConfigError     = 1
-	ExitDatabaseError   = 2
-	ExitHTTPServerError = 3
+	ExitSuccess            = 0
+	ExitConfigError        = 1
+	ExitDatabaseError      = 2
+	ExitHTTPServerError    = 3
+	ExitIntegrityError     = 4
+	ExitAlreadyInitialized = 5
 )
 
 // =============================================================================
@@ -35,18 +65,64 @@ type Server struct {
 	config          *Config
 	httpServer      *http.Server
 	proxyServer     *http.Server
+	proxyTLSServer  *http.Server
 	store           *engine.Store
 	nodePool        *docker.NodePool
+	bus             *engine.Bus
+	leader          *engine.LeaderElector
 	billingReporter  *billing.Reporter
 	invoiceGenerator *engine.InvoiceGenerator
+	spendingMonitor  *engine.SpendingMonitor
+	payoutScheduler  *engine.PayoutScheduler
+	upgradeScheduler *engine.UpgradeScheduler
+	powerScheduler   *engine.PowerScheduler
+	eventArchiver    *engine.EventArchiver
+	statsRollup      *engine.StatsRollup
+	webhooks         *engine.WebhookDispatcher
+	incidentMonitor  *engine.IncidentMonitor
+	backups          *engine.BackupScheduler
+	replication      *engine.ReplicationShipper
 	healthChecker    *engine.HealthChecker
+	nodeMetrics      *engine.NodeMetricsCollector
+	containerMetrics *engine.ContainerMetricsCollector
+	usageMeter       *engine.UsageMeter
+	volumeMigrator   *engine.VolumeMigrator
+	deplMigrator     *engine.DeploymentMigrator
+	logExporter      *engine.LogExporter
+	housekeeping     *engine.HousekeepingScheduler
+	nodeKeys         *engine.NodeKeyManager
+	imageDrift       *engine.ImageDriftChecker
+	serviceHealth    *engine.ServiceHealthMonitor
+	sloTracker       *engine.SLOTracker
+	sloTraffic       engine.Singleton
+	bucketManager    *engine.BucketManager
 	provisioner      *engine.Provisioner
 	dnsVerifier      *engine.DNSVerifier
+	traefikSync      *engine.TraefikFileSync
+	certificates     *engine.CertificateManager
+	notifier         *engine.Notifier
+	replica          *engine.ReplicaSyncer
+	standby          *engine.StandbyReceiver
 	logger           *slog.Logger
 }
 
 // NewServer creates a new server with the given config.
 func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
+	if cfg.ReadOnly.Enabled {
+		return newReadOnlyServer(cfg, logger)
+	}
+	if cfg.Replication.Role == replication.RoleStandby {
+		promotion, err := engine.ReadPromotion(cfg.Replication.Dir)
+		if err != nil {
+			return nil, &ServerError{Op: "NewServer", Err: err, ExitCode: ExitDatabaseError}
+		}
+		if promotion == nil {
+			return newStandbyServer(cfg, logger)
+		}
+		logger.Warn("this standby was promoted and runs as the primary; set replication.role accordingly",
+			"promoted_at", promotion.PromotedAt, "snapshot", promotion.Snapshot.ID)
+	}
+
 	// Open database and run migrations via engine
 	store, err := engine.OpenDB(cfg.Database.DSN, engine.Schema(), logger)
 	if err != nil {
@@ -56,6 +132,15 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 			ExitCode: ExitDatabaseError,
 		}
 	}
+	store.SetSlowQueryLog(cfg.Database.SlowQueryThreshold, logger)
+
+	// Chaos testing: inject faults into store operations and minion calls
+	var faultInjector *engine.FaultInjector
+	if cfg.Chaos.Enabled {
+		faultInjector = engine.NewFaultInjector(logger)
+		store.SetFaultInjector(faultInjector)
+		logger.Warn("chaos mode enabled: fault rules at /admin/faults can make requests fail")
+	}
 
 	// Initialize encryption key (needed for SSH keys, cloud credentials, etc.)
 	var encryptionKey []byte
@@ -70,15 +155,83 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 			}
 		}
 	}
+	if cfg.Nodes.Sandbox && encryptionKey == nil {
+		// Sandbox nodes need no SSH keys, but the node features are keyed on
+		// an encryption key; a throwaway one loses nothing worth keeping
+		encryptionKey = make([]byte, 32)
+		rand.Read(encryptionKey)
+		logger.Warn("nodes.encryption_key not set: using a throwaway key for the dev sandbox, secrets stored now won't decrypt after a restart")
+	}
 
 	// Create NodePool and health checker if encryption key is configured
 	var nodePool *docker.NodePool
 	var healthChecker *engine.HealthChecker
+	var nodeMetrics *engine.NodeMetricsCollector
+	var containerMetrics *engine.ContainerMetricsCollector
+	var usageMeter *engine.UsageMeter
+	var volumeMigrator *engine.VolumeMigrator
+	var logExporter *engine.LogExporter
+	var housekeeping *engine.HousekeepingScheduler
+	var imageDrift *engine.ImageDriftChecker
+	var serviceHealth *engine.ServiceHealthMonitor
+	var nodeAudit engine.NodeAuditReader
+	var nodeKeys *engine.NodeKeyManager
 
 	if encryptionKey != nil {
-		nodePool = docker.NewNodePool(store, encryptionKey, docker.DefaultNodePoolConfig())
+		handshake, err := minionHandshakePolicy(cfg.Nodes)
+		if err != nil {
+			store.Close()
+			return nil, &ServerError{
+				Op:       "NewServer",
+				Err:      err,
+				ExitCode: ExitConfigError,
+			}
+		}
+		if handshake.PublicKey == nil {
+			logger.Warn("nodes.minion_public_key not set: minion build signatures will not be verified")
+		}
+
+		poolConfig := docker.DefaultNodePoolConfig()
+		poolConfig.SSHClientConfig.Handshake = handshake
+		if faultInjector != nil {
+			poolConfig.SSHClientConfig.Faults = faultInjector
+		}
+		poolConfig.Sandbox = cfg.Nodes.Sandbox
+		nodePool = docker.NewNodePool(store, encryptionKey, poolConfig)
+		if cfg.Nodes.Sandbox {
+			logger.Warn("dev sandbox enabled: nodes run on in-memory Docker hosts and nothing is deployed")
+		}
 
 		healthChecker = engine.NewHealthChecker(store, nodePool, encryptionKey, 0, logger)
+		nodeMetrics = engine.NewNodeMetricsCollector(store, nodePool, cfg.Nodes.MetricsInterval, cfg.Nodes.MetricsRetention, logger)
+		containerMetrics = engine.NewContainerMetricsCollector(store, nodePool, cfg.Nodes.ContainerMetricsInterval, cfg.Nodes.ContainerMetricsRetention, logger)
+		usageMeter = engine.NewUsageMeter(store, nodePool, cfg.Billing.MeteringInterval, logger)
+
+		// Volume migrator moves stopped deployments' volumes between nodes
+		volumeMigrator = engine.NewVolumeMigrator(store, nodePool, cfg.Nodes.VolumeMigrationChunkMB<<20, cfg.Nodes.VolumeMigrationInterval, logger)
+
+		// Log exporter archives deployment container logs for download
+		logExporter = engine.NewLogExporter(store, nodePool, cfg.Nodes.LogExportDir, encryptionKey, cfg.Nodes.LogExportTTL, 0, logger)
+
+		// Housekeeping scheduler runs creator-scheduled node cleanup via the minion
+		housekeeping = engine.NewHousekeepingScheduler(store, nodePool, cfg.Nodes.HousekeepingInterval, logger)
+		nodeAudit = nodePool
+
+		// Node key manager rotates the SSH keys hoster connects to nodes with
+		nodeKeys = engine.NewNodeKeyManager(store, nodePool, 0, logger)
+
+		// Image drift checker reports pinned image tags that moved to a new digest
+		imageDrift = engine.NewImageDriftChecker(store, nodePool, cfg.Nodes.ImageDriftInterval, logger)
+
+		// Service health monitor checks deployment services, running x-hoster probes
+		serviceHealth = engine.NewServiceHealthMonitor(store, nodePool, cfg.Nodes.ServiceHealthInterval, logger)
+		serviceHealth.SetProbeLimits(monitoring.ProbeLimits{
+			MaxTimeout:    cfg.Nodes.ProbeMaxTimeout,
+			MaxOutput:     cfg.Nodes.ProbeMaxOutput,
+			NodeRate:      cfg.Nodes.ProbeRate,
+			FailureBudget: cfg.Nodes.ProbeFailureBudget,
+			BudgetWindow:  cfg.Nodes.ProbeBudgetWindow,
+		})
 
 		logger.Info("remote nodes enabled",
 			"health_check_interval", cfg.Nodes.HealthCheckInterval,
@@ -98,11 +251,78 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 	dnsVerifier := engine.NewDNSVerifier(store, cfg.Domain.BaseDomain, 0, logger)
 
 	// Create invoice generator worker
-	invoiceGenerator := engine.NewInvoiceGenerator(store, cfg.Billing.InvoiceInterval, logger)
+	usagePrices, _ := spending.ParsePrices(cfg.Billing.UsagePrices)
+	planPrices, _ := invoice.ParsePlanPrices(cfg.Billing.PlanUsagePrices)
+	invoiceGenerator := engine.NewInvoiceGenerator(store, invoice.Pricing{Usage: usagePrices, Plans: planPrices}, cfg.Billing.InvoiceInterval, logger)
+
+	// Create payout scheduler worker for creator revenue share
+	platformFeeBps := payout.PercentToBps(cfg.Billing.PlatformFeePercent)
+	if err := payout.ValidateFeeBps(platformFeeBps); err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+	payoutScheduler := engine.NewPayoutScheduler(store, cfg.Billing.StripeKey, cfg.Billing.PayoutInterval, logger)
+
+	// Create event archiver worker (moves old usage/container events to archive files)
+	eventArchiver := engine.NewEventArchiver(store, cfg.Archive.Dir, cfg.Archive.Retention, cfg.Archive.BatchSize, cfg.Archive.Interval, logger)
+
+	// Create stats rollup worker (daily statistics for dashboards)
+	statsRollup := engine.NewStatsRollup(store, 0, logger)
+
+	// Create webhook dispatcher worker (signed event deliveries with retries)
+	webhooks := engine.NewWebhookDispatcher(store, nil, 0, logger)
+
+	// Create incident monitor worker (resolves incidents once affected targets recover)
+	incidentMonitor := engine.NewIncidentMonitor(store, 0, 0, logger)
+
+	// Create backup scheduler worker (control plane database backups)
+	var backups *engine.BackupScheduler
+	if cfg.Backup.Enabled {
+		remote, err := newBackupRemote(cfg)
+		if err != nil {
+			store.Close()
+			return nil, &ServerError{
+				Op:       "NewServer",
+				Err:      err,
+				ExitCode: ExitConfigError,
+			}
+		}
+		backups = engine.NewBackupScheduler(store, cfg.Backup.Dir, cfg.Backup.Interval, backupRetention(cfg.Backup), remote, Version, logger)
+	}
+
+	// Create replication shipper worker (snapshots for a cold standby)
+	var replicationShipper *engine.ReplicationShipper
+	if cfg.Replication.Role == replication.RolePrimary {
+		replicationShipper = engine.NewReplicationShipper(store, engine.ReplicationShipperConfig{
+			StandbyURL: cfg.Replication.StandbyURL,
+			Secret:     cfg.Replication.Secret,
+			Dir:        cfg.Replication.Dir,
+			ConfigDir:  cfg.Domain.ConfigDir,
+			Interval:   cfg.Replication.Interval,
+			Timeout:    cfg.Replication.Timeout,
+			Version:    Version,
+		}, logger)
+	}
+
+	// Create bucket manager worker (managed object storage for deployments)
+	bucketManager, err := newBucketManager(cfg.Storage, store, nodePool, encryptionKey, logger)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
 
 	// Create command bus and register handlers
 	bus := engine.NewBus(store, logger)
 	engine.RegisterHandlers(bus)
+	bus.SetWorkers(cfg.Nodes.CommandWorkers)
 
 	// Set extra dependencies for command handlers
 	if nodePool != nil {
@@ -110,19 +330,236 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 	}
 	bus.SetExtra("base_domain", cfg.Domain.BaseDomain)
 	bus.SetExtra("config_dir", cfg.Domain.ConfigDir)
+	bus.SetExtra("start_concurrency", cfg.Nodes.StartConcurrency)
+	strategy, _ := scheduler.ParseStrategy(cfg.Nodes.SchedulingStrategy) // checked by Validate
+	bus.SetExtra("scheduling_strategy", strategy)
 	bus.SetExtra("encryption_key", encryptionKey)
+	bus.SetExtra("platform_fee_bps", platformFeeBps)
+
+	// Set secret manager for secret-reference variables (resolved at container-plan time)
+	if secretManager := newSecretManager(cfg.Secrets, store, logger); secretManager != nil {
+		bus.SetExtra("secret_manager", secretManager)
+	}
+
+	// Issue custom domains' certificates over ACME
+	certificates := newCertificateManager(cfg.ACME, store, logger)
+
+	// Route deployments through Traefik on their nodes (labels or file provider)
+	traefikSync, err := newTraefikRouting(cfg.Proxy.Traefik, certificates, cfg.ACME.ChallengeUpstream, store, nodePool, bus, logger)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+
+	// Clones start through the bus once their volumes are copied
+	if volumeMigrator != nil {
+		volumeMigrator.EnableClones(bus)
+	}
+
+	// Checkpoint migrations restart migrated deployments through the bus
+	checkpoints := volumeMigrator != nil && cfg.Nodes.ExperimentalCheckpoint
+	if checkpoints {
+		volumeMigrator.EnableCheckpoints(bus)
+		logger.Warn("experimental checkpoint migrations enabled")
+	}
+
+	// Deployment migrator moves deployments between nodes at their owners' request
+	var deplMigrator *engine.DeploymentMigrator
+	if volumeMigrator != nil {
+		deplMigrator = engine.NewDeploymentMigrator(store, bus, nodePool, cfg.Nodes.VolumeMigrationInterval, logger)
+	}
+
+	// Coordinate with other replicas sharing the database: deployment
+	// commands hold per-deployment locks, background workers run on the leader
+	leaseTTL := cfg.Server.LeaseTTL
+	if err := coordination.ValidateTTL(leaseTTL); err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      fmt.Errorf("server.lease_ttl: %w", err),
+			ExitCode: ExitConfigError,
+		}
+	}
+	coordinator := engine.NewCoordinator(store, cfg.Server.ReplicaID, leaseTTL, logger)
+	bus.SetExtra("coordinator", coordinator)
+	leader := engine.NewLeaderElector(coordinator, logger)
+
+	// Create upgrade scheduler worker (applies per-deployment upgrade policies)
+	upgradeScheduler := engine.NewUpgradeScheduler(store, bus, 0, logger)
+
+	apiLifecycles, err := newAPILifecycles(cfg.Server)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+
+	// Template registry: signed template bundles to and from other instances
+	templateRegistry, err := newTemplateRegistry(cfg.Registry, cfg.Notifications.AppURL)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+
+	// Create notifier (Slack and Web Push channels)
+	notifier, err := newNotifier(cfg.Notifications, store, logger)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+	if nodeMetrics != nil {
+		nodeMetrics.SetNotifier(notifier)
+	}
+	if healthChecker != nil {
+		healthChecker.SetNotifier(notifier)
+	}
+	if serviceHealth != nil {
+		serviceHealth.SetNotifier(notifier)
+	}
+	if nodeKeys != nil {
+		nodeKeys.SetNotifier(notifier)
+	}
+	// Trial expiry warnings are sent from the command bus
+	bus.SetExtra("notifier", notifier)
+
+	// Create spending monitor: alerts accounts nearing their spending limits
+	spendingMonitor := engine.NewSpendingMonitor(store, notifier, usagePrices, cfg.Billing.SpendingCheckInterval, logger)
+
+	// Create power scheduler worker (starts and stops deployments on their schedules)
+	powerScheduler := engine.NewPowerScheduler(store, bus, usagePrices, 0, logger)
+
+	// Template playgrounds run on the operator's sandbox node (optional)
+	var playgroundConfig *engine.PlaygroundConfig
+	if cfg.Playground.Node != "" {
+		playgroundConfig = &engine.PlaygroundConfig{
+			NodeID:       cfg.Playground.Node,
+			TTL:          cfg.Playground.TTL,
+			MaxSessions:  cfg.Playground.MaxSessions,
+			MaxPerClient: cfg.Playground.MaxPerClient,
+			Caps:         playground.Caps{MemoryMB: cfg.Playground.MemoryMB, CPUCores: cfg.Playground.CPUCores},
+		}
+		logger.Info("template playgrounds enabled", "node", cfg.Playground.Node, "ttl", cfg.Playground.TTL)
+	}
+
+	// Deployment tunnels relay through the nodes' minions (optional)
+	var tunnelConfig *engine.TunnelConfig
+	if cfg.Tunnel.Enabled && nodePool != nil {
+		tunnelConfig = &engine.TunnelConfig{
+			Nodes:       nodePool,
+			MaxDuration: cfg.Tunnel.MaxDuration,
+			Throttle:    tunnel.Throttle{BytesPerSecond: cfg.Tunnel.BandwidthKB << 10},
+		}
+	}
+
+	// Deployment exec runs allowlisted commands through the nodes' minions (optional)
+	var execConfig *engine.ExecConfig
+	if len(cfg.Exec.Allowlist) > 0 && nodePool != nil {
+		allowlist, _ := containerexec.ParseAllowlist(cfg.Exec.Allowlist)
+		execConfig = &engine.ExecConfig{
+			Nodes:      nodePool,
+			Allowlist:  allowlist,
+			MaxTimeout: cfg.Exec.MaxTimeout,
+			MaxOutput:  cfg.Exec.MaxOutputKB << 10,
+		}
+		logger.Info("deployment exec enabled", "allowlist", cfg.Exec.Allowlist, "max_timeout", cfg.Exec.MaxTimeout)
+	}
+
+	// Create mailer for collaborator invitations (optional)
+	mailer, err := newMailer(cfg.Notifications, logger)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
+
+	// The admin created by `hoster init` is an admin alongside auth.admins
+	bootstrapAdmins, err := store.BootstrapAdmins(context.Background())
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitDatabaseError,
+		}
+	}
+
+	// TLS for the API server; with the mtls backend it asks for client certificates
+	tlsConfig, err := newServerTLSConfig(cfg)
+	if err != nil {
+		store.Close()
+		return nil, &ServerError{
+			Op:       "NewServer",
+			Err:      err,
+			ExitCode: ExitConfigError,
+		}
+	}
 
 	// Create HTTP handler using the engine
+	if containerMetrics != nil {
+		containerMetrics.SetAbuseAlerts(notifier, slices.Concat(cfg.Auth.Admins, bootstrapAdmins))
+	}
+
+	// Proxies resolve hostnames through /internal/routes, cached in memory
+	routes := engine.NewRouteCache(store, cfg.Proxy.RouteCacheTTL)
+
 	handler := engine.Setup(engine.SetupConfig{
-		Store:         store,
-		Bus:           bus,
-		Logger:        logger,
-		BaseDomain:    cfg.Domain.BaseDomain,
-		ConfigDir:     cfg.Domain.ConfigDir,
-		SharedSecret:  cfg.Auth.SharedSecret,
-		EncryptionKey: encryptionKey,
-		Version:       Version,
-		StripeKey:     cfg.Billing.StripeKey,
+		Store:          store,
+		Bus:            bus,
+		Logger:         logger,
+		BaseDomain:     cfg.Domain.BaseDomain,
+		ConfigDir:      cfg.Domain.ConfigDir,
+		SharedSecret:   cfg.Auth.SharedSecret,
+		Authenticators: newAuthenticators(cfg.Auth, store),
+		EncryptionKey:  encryptionKey,
+		Version:        Version,
+		StripeKey:      cfg.Billing.StripeKey,
+		IdempotencyTTL: cfg.Server.IdempotencyTTL,
+		Buckets:        bucketManager,
+		Housekeeping:   housekeeping,
+		ImageDrift:     imageDrift,
+		NodeAudit:      nodeAudit,
+		NodeKeys:       nodeKeys,
+		APILifecycles:  apiLifecycles,
+		Notifier:       notifier,
+		Mailer:         mailer,
+		AppURL:         cfg.Notifications.AppURL,
+		Backups:        backups,
+		Replication:    replicationShipper,
+		Leader:         leader,
+		Moderators:     cfg.Auth.Moderators,
+		Admins:         slices.Concat(cfg.Auth.Admins, bootstrapAdmins),
+		LogExports:     logExporter,
+		Routes:         routes,
+		InternalSecret: cfg.Proxy.InternalSecret,
+		MetricsToken:   cfg.Server.MetricsToken,
+		Registry:       templateRegistry,
+		Uploads:        newTemplateUploads(store, cfg.Uploads, logger),
+		UsagePrices:    usagePrices,
+		Faults:         faultInjector,
+		Playground:     playgroundConfig,
+		Tunnels:        tunnelConfig,
+		Exec:           execConfig,
+		Certificates:   certificates,
+
+		ExperimentalCheckpoints: checkpoints,
 	})
 
 	// Create HTTP server
@@ -131,6 +568,7 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 		Handler:      handler,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
+		TLSConfig:    tlsConfig,
 	}
 
 	// Create billing reporter — always enabled
@@ -155,14 +593,31 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 	})
 
 	// Create App Proxy server (specs/domain/proxy.md)
-	var proxyHTTPServer *http.Server
+	var proxyHTTPServer, proxyTLSServer *http.Server
+	var requestMeter *engine.RequestMeter
 	if cfg.Proxy.Enabled {
+		// Canary upgrades split traffic in the proxy and read its counts
+		trafficMeter := engine.NewTrafficMeter()
+		bus.SetExtra("traffic_meter", trafficMeter)
+		// SLOs are measured on the responses the proxy serves
+		requestMeter = engine.NewRequestMeter()
+		// HTTP-01 challenges reach custom domains' deployments through the proxy
+		var challenges http.Handler
+		if certificates != nil {
+			challenges = engine.NewACMEChallengeHandler(store)
+		}
+
 		proxyHandler, err := proxy.NewServer(proxy.Config{
 			Address:      cfg.Proxy.Address(),
 			BaseDomain:   cfg.Proxy.BaseDomain,
 			ReadTimeout:  cfg.Proxy.ReadTimeout,
 			WriteTimeout: cfg.Proxy.WriteTimeout,
 			IdleTimeout:  cfg.Proxy.IdleTimeout,
+			Meter:        trafficMeter,
+			Requests:     requestMeter,
+			Challenges:   challenges,
+
+			WebSocketTimeout: cfg.Proxy.WebSocketTimeout,
 		}, store, logger)
 		if err != nil {
 			store.Close()
@@ -181,58 +636,233 @@ func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
 			IdleTimeout:  cfg.Proxy.IdleTimeout,
 		}
 
+		if cfg.Proxy.TLSPort != 0 && certificates != nil {
+			proxyTLSServer = &http.Server{
+				Addr:         fmt.Sprintf("%s:%d", cfg.Proxy.Host, cfg.Proxy.TLSPort),
+				Handler:      proxyHandler,
+				ReadTimeout:  cfg.Proxy.ReadTimeout,
+				WriteTimeout: cfg.Proxy.WriteTimeout,
+				IdleTimeout:  cfg.Proxy.IdleTimeout,
+				TLSConfig: &tls.Config{
+					GetCertificate: certificates.GetCertificate,
+					MinVersion:     tls.VersionTLS12,
+				},
+			}
+		}
+
 		logger.Info("app proxy enabled",
 			"address", cfg.Proxy.Address(),
 			"base_domain", cfg.Proxy.BaseDomain,
+			"tls_port", cfg.Proxy.TLSPort,
 		)
 	} else {
 		logger.Info("app proxy disabled")
 	}
 
+	// Create SLO tracker: uptime checks, proxy traffic and burn-rate alerts
+	sloTracker := engine.NewSLOTracker(store, requestMeter, notifier, cfg.Nodes.SLOInterval, logger)
+	var sloTraffic engine.Singleton
+	if requestMeter != nil {
+		sloTraffic = engine.Loop(sloTracker.RecordTraffic)
+	}
+
 	return &Server{
 		config:           cfg,
 		httpServer:       httpServer,
 		proxyServer:      proxyHTTPServer,
+		proxyTLSServer:   proxyTLSServer,
 		store:            store,
 		nodePool:         nodePool,
+		bus:              bus,
+		leader:           leader,
 		billingReporter:  billingReporter,
 		invoiceGenerator: invoiceGenerator,
+		spendingMonitor:  spendingMonitor,
+		payoutScheduler:  payoutScheduler,
+		upgradeScheduler: upgradeScheduler,
+		powerScheduler:   powerScheduler,
+		eventArchiver:    eventArchiver,
+		statsRollup:      statsRollup,
+		webhooks:         webhooks,
+		incidentMonitor:  incidentMonitor,
+		backups:          backups,
+		replication:      replicationShipper,
 		healthChecker:    healthChecker,
+		nodeMetrics:      nodeMetrics,
+		containerMetrics: containerMetrics,
+		usageMeter:       usageMeter,
+		volumeMigrator:   volumeMigrator,
+		deplMigrator:     deplMigrator,
+		logExporter:      logExporter,
+		housekeeping:     housekeeping,
+		nodeKeys:         nodeKeys,
+		imageDrift:       imageDrift,
+		serviceHealth:    serviceHealth,
+		sloTracker:       sloTracker,
+		sloTraffic:       sloTraffic,
+		bucketManager:    bucketManager,
 		provisioner:      provisioner,
 		dnsVerifier:      dnsVerifier,
+		traefikSync:      traefikSync,
+		certificates:     certificates,
+		notifier:         notifier,
 		logger:           logger,
 	}, nil
 }
 
 // Start starts the server and blocks until shutdown.
 func (s *Server) Start(ctx context.Context) error {
+	if s.config.ReadOnly.Enabled {
+		return s.startReadOnly(ctx)
+	}
+	if s.standby != nil {
+		return s.startStandby(ctx)
+	}
+
 	// Setup signal handling
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start billing reporter in background
-	go s.billingReporter.Start(ctx)
+	// Background workers run only on the leader replica, so replicas sharing
+	// the database never double-run them. They start when this replica wins
+	// the leader lease and stop if it loses it.
+
+	// Billing reporter
+	s.leader.Add("billing_reporter", engine.Loop(s.billingReporter.Run))
 
-	// Start health checker in background
+	// Health checker
 	if s.healthChecker != nil {
-		s.healthChecker.Start()
+		s.leader.Add("health_checker", s.healthChecker)
+	}
+
+	// Node metrics collector
+	if s.nodeMetrics != nil {
+		s.leader.Add("node_metrics", s.nodeMetrics)
+	}
+
+	// Container metrics collector
+	if s.containerMetrics != nil {
+		s.leader.Add("container_metrics", s.containerMetrics)
+	}
+
+	// Usage meter records deployments' CPU, memory and egress per billing hour
+	if s.usageMeter != nil {
+		s.leader.Add("usage_meter", s.usageMeter)
+	}
+
+	// Service health monitor
+	if s.serviceHealth != nil {
+		s.leader.Add("service_health", s.serviceHealth)
+	}
+
+	// SLO uptime checks and burn-rate alerts
+	s.leader.Add("slo_tracker", s.sloTracker)
+
+	// Volume migrator
+	if s.volumeMigrator != nil {
+		s.leader.Add("volume_migrator", s.volumeMigrator)
+	}
+
+	// Deployment migrator
+	if s.deplMigrator != nil {
+		s.leader.Add("deployment_migrator", s.deplMigrator)
+	}
+
+	// Log exporter
+	if s.logExporter != nil {
+		s.leader.Add("log_exporter", s.logExporter)
+	}
+
+	// Node housekeeping scheduler
+	if s.housekeeping != nil {
+		s.leader.Add("housekeeping", s.housekeeping)
+	}
+
+	// Node key rotation and retirement
+	if s.nodeKeys != nil {
+		s.leader.Add("node_keys", s.nodeKeys)
+	}
+
+	// Image drift checker
+	if s.imageDrift != nil {
+		s.leader.Add("image_drift", s.imageDrift)
 	}
 
-	// Start cloud provisioner worker
+	// Cloud provisioner worker
 	if s.provisioner != nil {
-		s.provisioner.Start()
+		s.leader.Add("provisioner", s.provisioner)
 	}
 
-	// Start DNS verifier worker
+	// DNS verifier worker
 	if s.dnsVerifier != nil {
-		s.dnsVerifier.Start()
+		s.leader.Add("dns_verifier", s.dnsVerifier)
+	}
+
+	// Traefik file sync
+	if s.traefikSync != nil {
+		s.leader.Add("traefik_file_sync", s.traefikSync)
+	}
+
+	// ACME certificate issuance and renewal
+	if s.certificates != nil {
+		s.leader.Add("certificate_manager", s.certificates)
 	}
 
-	// Start invoice generator worker
-	s.invoiceGenerator.Start()
+	// Invoice generator worker
+	s.leader.Add("invoice_generator", s.invoiceGenerator)
+
+	// Spending limit alerts
+	s.leader.Add("spending_monitor", s.spendingMonitor)
+
+	// Creator payout scheduler
+	s.leader.Add("payout_scheduler", s.payoutScheduler)
+
+	// Deployment upgrade scheduler
+	s.leader.Add("upgrade_scheduler", s.upgradeScheduler)
+
+	// Deployment power schedules
+	s.leader.Add("power_scheduler", s.powerScheduler)
+
+	// Job workers (enqueued and delayed commands)
+	s.leader.Add("jobs", s.bus)
+
+	// Event archiver
+	s.leader.Add("event_archiver", s.eventArchiver)
+
+	// Stats rollup
+	s.leader.Add("stats_rollup", s.statsRollup)
+
+	// Webhook dispatcher
+	s.leader.Add("webhooks", s.webhooks)
+
+	// Incident monitor
+	s.leader.Add("incident_monitor", s.incidentMonitor)
+
+	// Database backups
+	if s.backups != nil {
+		s.leader.Add("backups", s.backups)
+	}
+
+	// Cold-standby replication
+	if s.replication != nil {
+		s.leader.Add("replication", s.replication)
+	}
+
+	// Bucket manager
+	if s.bucketManager != nil {
+		s.leader.Add("bucket_manager", s.bucketManager)
+	}
+
+	// Campaign for leadership
+	s.leader.Start()
+
+	// Every replica serving proxy traffic records it for SLOs
+	if s.sloTraffic != nil {
+		s.sloTraffic.Start()
+	}
 
 	// Start App Proxy server in goroutine
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
 	if s.proxyServer != nil {
 		go func() {
 			s.logger.Info("starting App Proxy server",
@@ -243,12 +873,27 @@ func (s *Server) Start(ctx context.Context) error {
 			}
 		}()
 	}
+	if s.proxyTLSServer != nil {
+		go func() {
+			s.logger.Info("starting App Proxy TLS server", "address", s.proxyTLSServer.Addr)
+			if err := s.proxyTLSServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+		}()
+	}
 
 	// Start HTTP server in goroutine
 	go func() {
 		s.logger.Info("starting HTTP server",
-			"address", s.config.Server.Address())
-		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			"address", s.config.Server.Address(),
+			"tls", s.config.Server.TLSCertFile != "")
+		var err error
+		if s.config.Server.TLSCertFile != "" {
+			err = s.httpServer.ListenAndServeTLS(s.config.Server.TLSCertFile, s.config.Server.TLSKeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		}
 	}()
@@ -289,27 +934,24 @@ func (s *Server) Shutdown(ctx context.Context) error {
 			s.logger.Error("App Proxy server shutdown error", "error", err)
 		}
 	}
-
-	// Stop billing reporter
-	s.billingReporter.Stop()
-
-	// Stop health checker
-	if s.healthChecker != nil {
-		s.healthChecker.Stop()
+	if s.proxyTLSServer != nil {
+		if err := s.proxyTLSServer.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("App Proxy TLS server shutdown error", "error", err)
+		}
 	}
 
-	// Stop cloud provisioner worker
-	if s.provisioner != nil {
-		s.provisioner.Stop()
-	}
+	// Stop background workers and hand the leader lease to another replica
+	// (interrupted migrations, exports, housekeeping runs, commands and
+	// webhook deliveries resume wherever the workers next start)
+	s.leader.Stop()
 
-	// Stop DNS verifier worker
-	if s.dnsVerifier != nil {
-		s.dnsVerifier.Stop()
+	// Record the proxy's last SLO traffic
+	if s.sloTraffic != nil {
+		s.sloTraffic.Stop()
 	}
 
-	// Stop invoice generator
-	s.invoiceGenerator.Stop()
+	// Let in-flight notifications finish before the database closes
+	s.notifier.Wait()
 
 	// Close node pool connections
 	if s.nodePool != nil {
@@ -327,6 +969,274 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// minionHandshakePolicy builds the minion verification policy from config.
+func minionHandshakePolicy(cfg NodesConfig) (*minion.HandshakePolicy, error) {
+	policy := &minion.HandshakePolicy{MinProtocolVersion: cfg.MinMinionProtocol}
+	if cfg.MinionPublicKey != "" {
+		key, err := minion.ParsePublicKey(cfg.MinionPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("nodes.minion_public_key: %w", err)
+		}
+		policy.PublicKey = key
+	}
+	return policy, nil
+}
+
+// newTemplateUploads builds the template upload handler state from config,
+// scanning uploads with clamd when an address is set.
+func newTemplateUploads(store *engine.Store, cfg UploadsConfig, logger *slog.Logger) *engine.TemplateUploads {
+	var scanner engine.UploadScanner
+	if cfg.ClamdAddress != "" {
+		scanner = scan.NewClamd(cfg.ClamdAddress)
+		logger.Info("upload malware scanning enabled", "clamd", cfg.ClamdAddress)
+	}
+	return engine.NewTemplateUploads(store, cfg.Dir, scanner, logger)
+}
+
+// newTemplateRegistry builds the template registry from config; nil when
+// neither a signing key nor trusted keys are set.
+func newTemplateRegistry(cfg RegistryConfig, appURL string) (*engine.TemplateRegistry, error) {
+	if cfg.SigningKey == "" && len(cfg.TrustedKeys) == 0 {
+		return nil, nil
+	}
+	var key ed25519.PrivateKey
+	if cfg.SigningKey != "" {
+		var err error
+		if key, err = registry.ParsePrivateKey(cfg.SigningKey); err != nil {
+			return nil, fmt.Errorf("registry.signing_key: %w", err)
+		}
+	}
+	trusted := make([]ed25519.PublicKey, 0, len(cfg.TrustedKeys))
+	for _, s := range cfg.TrustedKeys {
+		pub, err := registry.ParsePublicKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("registry.trusted_keys: %w", err)
+		}
+		trusted = append(trusted, pub)
+	}
+	origin := cfg.Origin
+	if origin == "" {
+		origin = appURL
+	}
+	if key != nil && origin == "" {
+		return nil, fmt.Errorf("registry.origin: required to export templates (or set notifications.app_url)")
+	}
+	return engine.NewTemplateRegistry(key, trusted, origin), nil
+}
+
+// newAPILifecycles builds the API version lifecycles from config.
+func newAPILifecycles(cfg ServerConfig) (map[apiversion.Version]apiversion.Lifecycle, error) {
+	deprecatedAt, err := apiversion.ParseDate(cfg.APIV1DeprecatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("server.api_v1_deprecated_at: %w", err)
+	}
+	sunsetAt, err := apiversion.ParseDate(cfg.APIV1SunsetAt)
+	if err != nil {
+		return nil, fmt.Errorf("server.api_v1_sunset_at: %w", err)
+	}
+	v1 := apiversion.Lifecycle{DeprecatedAt: deprecatedAt, SunsetAt: sunsetAt}
+	if err := apiversion.Validate(apiversion.V1, v1); err != nil {
+		return nil, err
+	}
+	return map[apiversion.Version]apiversion.Lifecycle{apiversion.V1: v1}, nil
+}
+
+// newNotifier builds the notifier from config. Slack is always enabled; Web
+// Push is enabled when the VAPID key pair is set.
+func newNotifier(cfg NotificationsConfig, store *engine.Store, logger *slog.Logger) (*engine.Notifier, error) {
+	channels := notify.NewRegistry()
+	channels.Register(corenotify.ChannelSlack, notify.SlackFactory(nil))
+
+	var vapidPublicKey string
+	if cfg.VAPIDPublicKey != "" || cfg.VAPIDPrivateKey != "" {
+		vapid, err := notify.NewVAPID(notify.VAPIDConfig{
+			PublicKey:  cfg.VAPIDPublicKey,
+			PrivateKey: cfg.VAPIDPrivateKey,
+			Subject:    cfg.VAPIDSubject,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("notifications: %w", err)
+		}
+		channels.Register(corenotify.ChannelWebPush, notify.WebPushFactory(vapid, nil))
+		vapidPublicKey = vapid.PublicKey()
+		logger.Info("web push notifications enabled")
+	}
+
+	return engine.NewNotifier(store, engine.NotifierConfig{
+		Channels:       channels,
+		VAPIDPublicKey: vapidPublicKey,
+		AppURL:         cfg.AppURL,
+		Timeout:        cfg.Timeout,
+	}, logger), nil
+}
+
+// newMailer builds the SMTP mailer for collaborator invitations. It returns
+// nil when no SMTP host is configured.
+func newMailer(cfg NotificationsConfig, logger *slog.Logger) (mail.Mailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, nil
+	}
+	mailer, err := mail.NewSMTPMailer(mail.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("notifications: %w", err)
+	}
+	logger.Info("invitation email enabled", "smtp_host", cfg.SMTPHost)
+	return mailer, nil
+}
+
+// newSecretManager builds the secret manager from config. It returns nil when
+// no secret backend is configured.
+func newSecretManager(cfg SecretsConfig, store *engine.Store, logger *slog.Logger) *secrets.Manager {
+	resolvers := make(map[string]secrets.Resolver)
+	if cfg.VaultAddress != "" {
+		resolvers[coresecrets.SchemeVault] = secrets.NewVaultResolver(secrets.VaultConfig{
+			Address:   cfg.VaultAddress,
+			Token:     cfg.VaultToken,
+			Namespace: cfg.VaultNamespace,
+		})
+	}
+	if cfg.AWSRegion != "" {
+		awsCfg := secrets.AWSConfig{
+			Region:          cfg.AWSRegion,
+			AccessKeyID:     cfg.AWSAccessKeyID,
+			SecretAccessKey: cfg.AWSSecretAccessKey,
+		}
+		if awsCfg.AccessKeyID == "" {
+			awsCfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			awsCfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			awsCfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		resolvers[coresecrets.SchemeAWS] = secrets.NewAWSResolver(awsCfg)
+	}
+	if len(resolvers) == 0 {
+		return nil
+	}
+	return secrets.NewManager(resolvers, cfg.CacheTTL, store, logger)
+}
+
+// s3Config returns the S3 provider config of the storage section. Credentials
+// fall back to the standard AWS environment variables.
+func s3Config(cfg StorageConfig) storage.S3Config {
+	s3Cfg := storage.S3Config{
+		Endpoint:        cfg.S3Endpoint,
+		PublicEndpoint:  cfg.S3PublicEndpoint,
+		Region:          cfg.S3Region,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		PathStyle:       cfg.S3PathStyle,
+		IAM:             cfg.S3IAM,
+	}
+	if s3Cfg.AccessKeyID == "" {
+		s3Cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3Cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s3Cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return s3Cfg
+}
+
+// newBucketManager builds the bucket manager from config. It returns nil when
+// no storage backend is available.
+func newBucketManager(cfg StorageConfig, store *engine.Store, nodePool *docker.NodePool, encryptionKey []byte, logger *slog.Logger) (*engine.BucketManager, error) {
+	providers := make(map[corestorage.Backend]storage.Provider)
+	if cfg.NodeBackend && nodePool != nil {
+		providers[corestorage.BackendNode] = storage.NewNodeProvider(nodePool, cfg.MinIOImage)
+	}
+	if cfg.S3Endpoint != "" || cfg.S3Region != "" {
+		if encryptionKey == nil {
+			return nil, errors.New("storage.s3_endpoint requires nodes.encryption_key to store bucket credentials")
+		}
+		provider, err := storage.NewS3Provider(s3Config(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+		providers[corestorage.BackendS3] = provider
+	}
+	if len(providers) == 0 {
+		return nil, nil
+	}
+
+	backend := corestorage.Backend(cfg.DefaultBackend)
+	if backend != "" {
+		if _, ok := providers[backend]; !ok {
+			return nil, fmt.Errorf("storage.default_backend %q is not configured", cfg.DefaultBackend)
+		}
+	}
+	return engine.NewBucketManager(store, engine.BucketManagerConfig{
+		Providers:      providers,
+		DefaultBackend: backend,
+		BucketPrefix:   cfg.BucketPrefix,
+		Interval:       cfg.Interval,
+		UsageInterval:  cfg.UsageInterval,
+	}, logger), nil
+}
+
+// newCertificateManager creates the worker issuing custom domains'
+// certificates over ACME, or nil when acme.enabled is off.
+func newCertificateManager(cfg ACMEConfig, store *engine.Store, logger *slog.Logger) *engine.CertificateManager {
+	if !cfg.Enabled {
+		return nil
+	}
+	certCfg := engine.CertificateConfig{
+		DirectoryURL: cfg.DirectoryURL,
+		Email:        cfg.Email,
+		RenewBefore:  cfg.RenewBefore,
+		MaxPerRun:    cfg.MaxPerRun,
+	}
+	if cfg.DNSProvider == "digitalocean" {
+		certCfg.DNS = dns.NewDigitalOceanTXT(cfg.DNSToken, cfg.DNSZone)
+	}
+	logger.Info("acme certificates enabled", "directory", cfg.DirectoryURL, "dns_provider", cfg.DNSProvider)
+	return engine.NewCertificateManager(store, certCfg, cfg.Interval, logger)
+}
+
+// newTraefikRouting sets up the configured Traefik routing mode. Labels mode
+// has the command bus label containers; file mode returns the worker that
+// writes nodes' files, or nil without remote nodes. With certificates, the
+// files also carry the issued certificates and route HTTP-01 challenges to
+// challengeUpstream; labels mode then writes files with those alone.
+func newTraefikRouting(cfg TraefikConfig, certificates *engine.CertificateManager, challengeUpstream string, store *engine.Store, nodePool *docker.NodePool, bus *engine.Bus, logger *slog.Logger) (*engine.TraefikFileSync, error) {
+	mode, err := traefik.ParseMode(cfg.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.traefik.mode: %w", err)
+	}
+	opts := traefik.RouteOptions{EnableTLS: cfg.TLS, RedirectHTTP: cfg.RedirectHTTP}
+
+	fileCfg := engine.TraefikFileConfig{
+		Path:              cfg.ConfigPath,
+		Upstream:          cfg.Upstream,
+		Options:           opts,
+		Certificates:      certificates,
+		ChallengeUpstream: challengeUpstream,
+	}
+	switch mode {
+	case traefik.ModeLabels:
+		bus.SetExtra("traefik_labels", opts)
+		logger.Info("traefik routing enabled", "mode", mode)
+		if certificates == nil {
+			return nil, nil
+		}
+		fileCfg.RoutesFromLabels = true
+	case traefik.ModeFile:
+		logger.Info("traefik routing enabled", "mode", mode, "config_path", cfg.ConfigPath)
+	default:
+		return nil, nil
+	}
+	if err := traefik.ValidateConfigPath(cfg.ConfigPath); err != nil {
+		return nil, fmt.Errorf("proxy.traefik.config_path: %w", err)
+	}
+	if nodePool == nil {
+		logger.Warn("proxy.traefik.mode needs a traefik config file but remote nodes are disabled: none will be written", "mode", mode)
+		return nil, nil
+	}
+	return engine.NewTraefikFileSync(store, nodePool, fileCfg, cfg.Interval, logger), nil
+}
+
 // =============================================================================
 // Server Error
 // =============================================================================
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/tunnel"
+	"github.com/artpar/hoster/internal/shell/websocket"
+)
+
+// runTunnel implements `hoster tunnel [-server url] [-token token]
+// [-listen addr] [-duration d] <deployment> <service>:<port>`. It listens
+// locally and opens a tunnel through the control plane to the container
+// port for each connection, so clients such as psql can reach services
+// without public routing. It runs until interrupted.
+func runTunnel(args []string) int {
+	fs := flag.NewFlagSet("tunnel", flag.ContinueOnError)
+	server := fs.String("server", os.Getenv("HOSTER_URL"), "Control plane URL (default $HOSTER_URL)")
+	token := fs.String("token", os.Getenv("HOSTER_TOKEN"), "API token (default $HOSTER_TOKEN)")
+	listen := fs.String("listen", "", "Local address to listen on (default 127.0.0.1:<port>)")
+	duration := fs.Duration("duration", 0, "Time limit of each tunnel session (default the server's limit)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hoster tunnel [flags] <deployment> <service>:<port>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return ExitConfigError
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ExitConfigError
+	}
+	deployment := fs.Arg(0)
+	target, err := tunnel.ParseTarget(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel: %v\n", err)
+		return ExitConfigError
+	}
+	if *server == "" || *token == "" {
+		fmt.Fprintln(os.Stderr, "tunnel: -server and -token (or $HOSTER_URL and $HOSTER_TOKEN) are required")
+		return ExitConfigError
+	}
+	endpoint, err := tunnelURL(*server, deployment, target, *duration)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel: %v\n", err)
+		return ExitConfigError
+	}
+	if *listen == "" {
+		*listen = net.JoinHostPort("127.0.0.1", strconv.Itoa(target.Port))
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel: %v\n", err)
+		return ExitConfigError
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, func() { ln.Close() })
+
+	fmt.Fprintf(os.Stderr, "forwarding %s to %s of deployment %s; press Ctrl-C to stop\n", ln.Addr(), target, deployment)
+	header := http.Header{"Authorization": {"Bearer " + *token}}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ExitSuccess
+			}
+			fmt.Fprintf(os.Stderr, "tunnel: %v\n", err)
+			return ExitConfigError
+		}
+		go forwardTunnel(ctx, conn, endpoint, header)
+	}
+}
+
+// tunnelURL returns the WebSocket URL of a deployment's tunnel endpoint.
+func tunnelURL(server, deployment string, target tunnel.Target, duration time.Duration) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("server must be an http or https URL, got %q", server)
+	}
+	u.Path += "/api/v1/deployments/" + url.PathEscape(deployment) + "/tunnel"
+	q := url.Values{"target": {target.String()}}
+	if duration > 0 {
+		q.Set("duration", duration.String())
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// forwardTunnel relays one local connection through a new tunnel session.
+func forwardTunnel(ctx context.Context, conn net.Conn, endpoint string, header http.Header) {
+	defer conn.Close()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ws, resp, err := websocket.Dial(dialCtx, endpoint, header)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tunnel: %s\n", tunnelDialError(resp, err))
+		return
+	}
+	defer ws.Close()
+	fmt.Fprintf(os.Stderr, "connection from %s opened\n", conn.RemoteAddr())
+
+	go func() {
+		io.Copy(ws, conn)
+		ws.CloseWrite()
+	}()
+	_, err = io.Copy(conn, ws)
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		fmt.Fprintf(os.Stderr, "connection from %s closed by the server: %s\n", conn.RemoteAddr(), closeErr.Reason)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "connection from %s closed\n", conn.RemoteAddr())
+}
+
+// tunnelDialError describes a refused tunnel by the problem the server
+// returned, when it returned one.
+func tunnelDialError(resp *http.Response, err error) string {
+	if resp == nil {
+		return err.Error()
+	}
+	var problem struct {
+		Detail string `json:"detail"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&problem) == nil && problem.Detail != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, problem.Detail)
+	}
+	return err.Error()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/artpar/hoster/internal/shell/notify"
+)
+
+// runVAPIDKeys implements `hoster vapid-keys`, which generates the key pair
+// that enables Web Push notifications.
+func runVAPIDKeys(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "vapid-keys: takes no arguments")
+		return ExitConfigError
+	}
+	pub, priv, err := notify.GenerateVAPIDKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate key: %v\n", err)
+		return ExitConfigError
+	}
+	fmt.Printf("private key (HOSTER_NOTIFICATIONS_VAPID_PRIVATE_KEY, keep secret): %s\n", priv)
+	fmt.Printf("public key (HOSTER_NOTIFICATIONS_VAPID_PUBLIC_KEY): %s\n", pub)
+	return ExitSuccess
+}
@@ -0,0 +1,171 @@
+// Package acme provides pure functions for issuing certificates to custom
+// domains over ACME: challenge names, DNS delegation, certificate
+// inspection and the renewal and retry schedule.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package acme
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Challenges
+// =============================================================================
+
+// Challenge is how a domain proves it points at hoster.
+type Challenge string
+
+const (
+	// ChallengeHTTP01 serves a token under /.well-known/acme-challenge/ on
+	// the domain. It is the default: the domain already routes to hoster.
+	ChallengeHTTP01 Challenge = "http-01"
+	// ChallengeDNS01 publishes a TXT record at _acme-challenge.<domain>.
+	// The owner delegates that name to hoster's DNS zone with a CNAME once,
+	// so hoster can answer every renewal.
+	ChallengeDNS01 Challenge = "dns-01"
+)
+
+// ParseChallenge parses a domain's challenge setting. Empty is http-01.
+func ParseChallenge(s string) (Challenge, error) {
+	switch c := Challenge(strings.ToLower(strings.TrimSpace(s))); c {
+	case "":
+		return ChallengeHTTP01, nil
+	case ChallengeHTTP01, ChallengeDNS01:
+		return c, nil
+	}
+	return ChallengeHTTP01, fmt.Errorf("invalid challenge %q: must be http-01 or dns-01", s)
+}
+
+// ChallengePathPrefix is where HTTP-01 tokens are served.
+const ChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// TokenFromPath returns the token of an HTTP-01 challenge request path. ok
+// is false for other paths and malformed tokens.
+func TokenFromPath(p string) (token string, ok bool) {
+	token, ok = strings.CutPrefix(p, ChallengePathPrefix)
+	if !ok || token == "" || len(token) > 128 {
+		return "", false
+	}
+	for _, r := range token {
+		// Tokens are base64url
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", false
+		}
+	}
+	return token, true
+}
+
+// DNSChallengeName is the name a domain's DNS-01 TXT record is looked up at.
+func DNSChallengeName(hostname string) string {
+	return "_acme-challenge." + domain.NormalizeHostname(hostname)
+}
+
+// DelegationTarget is the name in hoster's zone that a domain's
+// _acme-challenge name is CNAMEd to. It is derived from the hostname, so it
+// stays the same across renewals and is unique per domain.
+func DelegationTarget(hostname, zone string) string {
+	sum := sha256.Sum256([]byte(domain.NormalizeHostname(hostname)))
+	return hex.EncodeToString(sum[:10]) + "." + strings.TrimSuffix(strings.ToLower(zone), ".")
+}
+
+// =============================================================================
+// Certificates
+// =============================================================================
+
+// Certificate statuses.
+const (
+	// StatusPending is a domain waiting for its first certificate.
+	StatusPending = "pending"
+	// StatusIssued is a domain with a certificate. It stays issued while a
+	// renewal fails, until the certificate expires.
+	StatusIssued = "issued"
+	// StatusFailed is a domain whose first issuance failed. It is retried
+	// after RetryAfter.
+	StatusFailed = "failed"
+)
+
+// Info describes a certificate chain's leaf.
+type Info struct {
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ParseChain reads the leaf of a PEM certificate chain.
+func ParseChain(chainPEM []byte) (Info, error) {
+	block, _ := pem.Decode(chainPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return Info{}, errors.New("no certificate in PEM chain")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return Info{}, fmt.Errorf("parse certificate: %w", err)
+	}
+	return Info{DNSNames: cert.DNSNames, NotBefore: cert.NotBefore, NotAfter: cert.NotAfter}, nil
+}
+
+// Covers reports whether the certificate names hostname exactly.
+func (i Info) Covers(hostname string) bool {
+	hostname = domain.NormalizeHostname(hostname)
+	for _, name := range i.DNSNames {
+		if strings.EqualFold(name, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether the certificate is usable at now.
+func (i Info) Valid(now time.Time) bool {
+	return !now.Before(i.NotBefore) && now.Before(i.NotAfter)
+}
+
+// =============================================================================
+// Renewal and Retries
+// =============================================================================
+
+// DefaultRenewBefore is how long before expiry certificates are renewed.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// RenewAt returns when a certificate valid from notBefore to notAfter is
+// renewed: renewBefore ahead of expiry, but never before a third of its
+// lifetime has passed, so short-lived certificates aren't renewed
+// continuously.
+func RenewAt(notBefore, notAfter time.Time, renewBefore time.Duration) time.Time {
+	at := notAfter.Add(-renewBefore)
+	if earliest := notBefore.Add(notAfter.Sub(notBefore) / 3); at.Before(earliest) {
+		return earliest
+	}
+	return at
+}
+
+// NeedsRenewal reports whether a certificate is due for renewal at now.
+func NeedsRenewal(notBefore, notAfter, now time.Time, renewBefore time.Duration) bool {
+	return !now.Before(RenewAt(notBefore, notAfter, renewBefore))
+}
+
+// Retry limits. ACME servers rate-limit failed validations, so failures back
+// off quickly.
+const (
+	MinRetryDelay = time.Hour
+	MaxRetryDelay = 24 * time.Hour
+)
+
+// RetryAfter returns how long to wait after the given number of consecutive
+// failures: an hour, doubling, up to a day.
+func RetryAfter(failures int) time.Duration {
+	delay := MinRetryDelay
+	for i := 1; i < failures && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, MaxRetryDelay)
+}
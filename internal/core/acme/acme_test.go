@@ -0,0 +1,128 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Challenge Tests
+// =============================================================================
+
+func TestParseChallenge(t *testing.T) {
+	for in, want := range map[string]Challenge{
+		"":        ChallengeHTTP01,
+		"http-01": ChallengeHTTP01,
+		" DNS-01": ChallengeDNS01,
+	} {
+		got, err := ParseChallenge(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseChallenge("tls-alpn-01")
+	assert.Error(t, err)
+}
+
+func TestTokenFromPath(t *testing.T) {
+	token, ok := TokenFromPath("/.well-known/acme-challenge/Ab-9_xYz")
+	assert.True(t, ok)
+	assert.Equal(t, "Ab-9_xYz", token)
+
+	for _, p := range []string{
+		"/.well-known/acme-challenge/",
+		"/.well-known/acme-challenge/../etc/passwd",
+		"/.well-known/acme-challenge/a/b",
+		"/index.html",
+		"/.well-known/acme-challenge/" + strings.Repeat("a", 129),
+	} {
+		_, ok := TokenFromPath(p)
+		assert.False(t, ok, p)
+	}
+}
+
+func TestDNSChallengeName(t *testing.T) {
+	assert.Equal(t, "_acme-challenge.shop.example.com", DNSChallengeName("Shop.Example.com."))
+}
+
+func TestDelegationTarget(t *testing.T) {
+	target := DelegationTarget("shop.example.com", "ACME.Hoster.io.")
+	assert.True(t, strings.HasSuffix(target, ".acme.hoster.io"), target)
+	assert.Len(t, strings.TrimSuffix(target, ".acme.hoster.io"), 20)
+	assert.Equal(t, target, DelegationTarget("SHOP.example.com.", "acme.hoster.io"))
+	assert.NotEqual(t, target, DelegationTarget("blog.example.com", "acme.hoster.io"))
+}
+
+// =============================================================================
+// Certificate Tests
+// =============================================================================
+
+func selfSigned(t *testing.T, names []string, notBefore, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseChain(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	info, err := ParseChain(selfSigned(t, []string{"shop.example.com"}, notBefore, notAfter))
+	require.NoError(t, err)
+
+	assert.True(t, info.NotBefore.Equal(notBefore))
+	assert.True(t, info.NotAfter.Equal(notAfter))
+	assert.True(t, info.Covers("Shop.Example.com"))
+	assert.False(t, info.Covers("www.shop.example.com"))
+	assert.True(t, info.Valid(notBefore.Add(time.Hour)))
+	assert.False(t, info.Valid(notAfter))
+
+	_, err = ParseChain([]byte("not pem"))
+	assert.Error(t, err)
+}
+
+// =============================================================================
+// Renewal Tests
+// =============================================================================
+
+func TestRenewAt(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 90-day certificate: 30 days before expiry
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	assert.Equal(t, notAfter.Add(-DefaultRenewBefore), RenewAt(notBefore, notAfter, DefaultRenewBefore))
+	assert.False(t, NeedsRenewal(notBefore, notAfter, notBefore.Add(59*24*time.Hour), DefaultRenewBefore))
+	assert.True(t, NeedsRenewal(notBefore, notAfter, notBefore.Add(60*24*time.Hour), DefaultRenewBefore))
+
+	// 6-day certificate: after a third of its lifetime
+	notAfter = notBefore.Add(6 * 24 * time.Hour)
+	assert.Equal(t, notBefore.Add(2*24*time.Hour), RenewAt(notBefore, notAfter, DefaultRenewBefore))
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Hour, RetryAfter(0))
+	assert.Equal(t, time.Hour, RetryAfter(1))
+	assert.Equal(t, 2*time.Hour, RetryAfter(2))
+	assert.Equal(t, 16*time.Hour, RetryAfter(5))
+	assert.Equal(t, MaxRetryDelay, RetryAfter(6))
+	assert.Equal(t, MaxRetryDelay, RetryAfter(100))
+}
@@ -0,0 +1,441 @@
+// Package apiversion provides pure functions for the versioned public API:
+// which versions exist, their deprecation and sunset lifecycle and the HTTP
+// headers that announce it, and the per-version serializers that turn store
+// rows into JSON:API documents and request bodies back into attributes.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package apiversion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Versions
+// =============================================================================
+
+// Version is a public API version, used as the path prefix /api/{version}/.
+type Version string
+
+const (
+	// V1 is the original API: flat attributes, reference fields as attributes.
+	V1 Version = "v1"
+	// V2 moves reference fields into relationships and adds links and meta.
+	V2 Version = "v2"
+)
+
+// Supported lists every served version, oldest first.
+var Supported = []Version{V1, V2}
+
+// Latest is the newest version; deprecated versions point to it.
+const Latest = V2
+
+// Valid reports whether v is a served version.
+func (v Version) Valid() bool {
+	for _, s := range Supported {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix returns the path prefix for v, e.g. "/api/v1".
+func (v Version) Prefix() string {
+	return "/api/" + string(v)
+}
+
+// FromPath returns the version a request path addresses ("/api/v2/nodes" → v2).
+func FromPath(path string) (Version, bool) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return "", false
+	}
+	seg, _, _ := strings.Cut(rest, "/")
+	v := Version(seg)
+	return v, v.Valid()
+}
+
+// SuccessorPath rewrites a path from its version to Latest.
+func SuccessorPath(path string) string {
+	v, ok := FromPath(path)
+	if !ok || v == Latest {
+		return path
+	}
+	return Latest.Prefix() + strings.TrimPrefix(path, v.Prefix())
+}
+
+// =============================================================================
+// Lifecycle
+// =============================================================================
+
+// Lifecycle is a version's deprecation schedule. Zero times mean unset.
+type Lifecycle struct {
+	// DeprecatedAt is when the version was (or will be) deprecated.
+	DeprecatedAt time.Time
+	// SunsetAt is when the version stops being served.
+	SunsetAt time.Time
+}
+
+// ErrInvalidLifecycle is returned for lifecycles that fail validation.
+var ErrInvalidLifecycle = errors.New("invalid API version lifecycle")
+
+// Validate checks that a sunset is only scheduled for a deprecated version,
+// after its deprecation, and that the latest version is never deprecated.
+func Validate(v Version, l Lifecycle) error {
+	if !v.Valid() {
+		return fmt.Errorf("%w: unknown version %q", ErrInvalidLifecycle, v)
+	}
+	if v == Latest && (!l.DeprecatedAt.IsZero() || !l.SunsetAt.IsZero()) {
+		return fmt.Errorf("%w: the latest version %s cannot be deprecated", ErrInvalidLifecycle, v)
+	}
+	if !l.SunsetAt.IsZero() {
+		if l.DeprecatedAt.IsZero() {
+			return fmt.Errorf("%w: %s has a sunset date but no deprecation date", ErrInvalidLifecycle, v)
+		}
+		if !l.SunsetAt.After(l.DeprecatedAt) {
+			return fmt.Errorf("%w: %s sunset must be after its deprecation", ErrInvalidLifecycle, v)
+		}
+	}
+	return nil
+}
+
+// Deprecated reports whether a deprecation date is set. The Deprecation
+// header is sent before that date too, announcing it in advance.
+func (l Lifecycle) Deprecated() bool {
+	return !l.DeprecatedAt.IsZero()
+}
+
+// Sunset reports whether the version is no longer served at now.
+func (l Lifecycle) Sunset(now time.Time) bool {
+	return !l.SunsetAt.IsZero() && !now.Before(l.SunsetAt)
+}
+
+// Status is "current", "deprecated", or "sunset" at now.
+func (l Lifecycle) Status(now time.Time) string {
+	switch {
+	case l.Sunset(now):
+		return "sunset"
+	case l.Deprecated():
+		return "deprecated"
+	default:
+		return "current"
+	}
+}
+
+// httpDate is the IMF-fixdate format required by the Sunset header.
+const httpDate = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Headers returns the headers announcing a version's lifecycle for a request
+// to path: Deprecation (RFC 9745, "@" + Unix seconds), Sunset (RFC 8594), and
+// a successor-version Link to the same path under Latest. Current versions
+// get no headers.
+func Headers(l Lifecycle, path string) map[string]string {
+	if !l.Deprecated() {
+		return nil
+	}
+	h := map[string]string{
+		"Deprecation": "@" + strconv.FormatInt(l.DeprecatedAt.Unix(), 10),
+		"Link":        fmt.Sprintf(`<%s>; rel="successor-version"`, SuccessorPath(path)),
+	}
+	if !l.SunsetAt.IsZero() {
+		h["Sunset"] = l.SunsetAt.UTC().Format(httpDate)
+	}
+	return h
+}
+
+// ParseDate parses a lifecycle date given as "2006-01-02" (midnight UTC) or
+// RFC 3339. An empty string is the zero time.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: date %q must be YYYY-MM-DD or RFC 3339", ErrInvalidLifecycle, s)
+	}
+	return t.UTC(), nil
+}
+
+// =============================================================================
+// Serializers
+// =============================================================================
+
+// ResourceInfo describes a resource type to the serializers.
+type ResourceInfo struct {
+	// Type is the JSON:API type and path segment, e.g. "deployments".
+	Type string
+	// Refs maps reference attributes to the type they point at,
+	// e.g. "template_id" → "templates".
+	Refs map[string]string
+}
+
+// Page describes the slice of a collection in a list response.
+type Page struct {
+	Limit  int
+	Offset int
+	// Path is the collection path, without query, for pagination links.
+	Path string
+	// More reports whether rows may follow this page. Callers set it from
+	// the rows fetched, before any visibility filtering shrinks the page.
+	More bool
+	// Cursor is the page[cursor] the page was requested with, if any. Pages
+	// requested by cursor link onward by cursor rather than by offset.
+	Cursor string
+	// NextCursor is the cursor of the following page, set when More is.
+	NextCursor string
+}
+
+// Serializer renders store rows as JSON:API documents for one version and
+// reads request documents back into attributes. Rows must already have
+// internal fields stripped; "reference_id" becomes the resource id.
+type Serializer interface {
+	// Resource renders one resource object.
+	Resource(info ResourceInfo, row map[string]any) map[string]any
+	// Collection renders a complete list document.
+	Collection(info ResourceInfo, rows []map[string]any, page Page) map[string]any
+	// Attributes extracts writable attributes from a request's "data" object.
+	Attributes(info ResourceInfo, data RequestData) (map[string]any, error)
+}
+
+// RequestData is the "data" member of a create or update request.
+type RequestData struct {
+	Type          string                     `json:"type"`
+	Attributes    map[string]any             `json:"attributes"`
+	Relationships map[string]RequestRelation `json:"relationships"`
+}
+
+// RequestRelation is a to-one relationship in a request: {"data": {"type", "id"}}
+// or {"data": null} to clear it.
+type RequestRelation struct {
+	Data *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"data"`
+}
+
+// ErrInvalidDocument is returned for request documents a serializer can't read.
+var ErrInvalidDocument = errors.New("invalid request document")
+
+// For returns the serializer for v, falling back to V1 for unknown versions.
+func For(v Version) Serializer {
+	if v == V2 {
+		return v2Serializer{}
+	}
+	return v1Serializer{}
+}
+
+// v1Serializer keeps the original shapes. Its output is locked by the
+// compatibility tests: change V2 instead.
+type v1Serializer struct{}
+
+func (v1Serializer) Resource(info ResourceInfo, row map[string]any) map[string]any {
+	refID, _ := row["reference_id"].(string)
+	attrs := make(map[string]any, len(row))
+	for k, v := range row {
+		if k == "id" || k == "reference_id" {
+			continue
+		}
+		attrs[k] = v
+	}
+	return map[string]any{
+		"type":       info.Type,
+		"id":         refID,
+		"attributes": attrs,
+	}
+}
+
+func (s v1Serializer) Collection(info ResourceInfo, rows []map[string]any, page Page) map[string]any {
+	data := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		data[i] = s.Resource(info, row)
+	}
+	meta := map[string]any{
+		"total":  len(rows),
+		"limit":  page.Limit,
+		"offset": page.Offset,
+	}
+	if page.NextCursor != "" {
+		meta["next_cursor"] = page.NextCursor
+	}
+	return map[string]any{
+		"data": data,
+		"meta": meta,
+	}
+}
+
+func (v1Serializer) Attributes(info ResourceInfo, data RequestData) (map[string]any, error) {
+	if data.Attributes == nil {
+		return nil, fmt.Errorf("missing data.attributes in request body")
+	}
+	return data.Attributes, nil
+}
+
+// v2Serializer moves reference attributes into relationships named without
+// the "_id" suffix, moves timestamps into meta, and adds self and pagination
+// links.
+type v2Serializer struct{}
+
+// v2MetaFields are row fields rendered under a resource's meta in V2.
+var v2MetaFields = []string{"created_at", "updated_at"}
+
+func (v2Serializer) Resource(info ResourceInfo, row map[string]any) map[string]any {
+	refID, _ := row["reference_id"].(string)
+	attrs := make(map[string]any, len(row))
+	relationships := map[string]any{}
+	meta := map[string]any{}
+	for k, v := range row {
+		if k == "id" || k == "reference_id" {
+			continue
+		}
+		if target, ok := info.Refs[k]; ok {
+			relationships[relationshipName(k)] = map[string]any{"data": relationshipData(target, v)}
+			continue
+		}
+		attrs[k] = v
+	}
+	for _, k := range v2MetaFields {
+		if v, ok := attrs[k]; ok {
+			meta[k] = v
+			delete(attrs, k)
+		}
+	}
+
+	obj := map[string]any{
+		"type":       info.Type,
+		"id":         refID,
+		"attributes": attrs,
+		"links":      map[string]any{"self": V2.Prefix() + "/" + info.Type + "/" + refID},
+	}
+	if len(relationships) > 0 {
+		obj["relationships"] = relationships
+	}
+	if len(meta) > 0 {
+		obj["meta"] = meta
+	}
+	return obj
+}
+
+func (s v2Serializer) Collection(info ResourceInfo, rows []map[string]any, page Page) map[string]any {
+	data := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		data[i] = s.Resource(info, row)
+	}
+	meta := map[string]any{
+		"limit":  page.Limit,
+		"offset": page.Offset,
+		"count":  len(rows),
+	}
+	if page.NextCursor != "" {
+		meta["next_cursor"] = page.NextCursor
+	}
+
+	var links map[string]any
+	if page.Cursor != "" {
+		// Cursor pages only link forward: a cursor has no page before it
+		links = map[string]any{"self": cursorLink(page, page.Cursor)}
+		if page.More && page.NextCursor != "" {
+			links["next"] = cursorLink(page, page.NextCursor)
+		}
+	} else {
+		links = map[string]any{"self": pageLink(page, page.Offset)}
+		if page.Offset > 0 {
+			prev := page.Offset - page.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links["prev"] = pageLink(page, prev)
+		}
+		if page.More {
+			links["next"] = pageLink(page, page.Offset+page.Limit)
+		}
+	}
+	return map[string]any{
+		"data":  data,
+		"meta":  map[string]any{"page": meta},
+		"links": links,
+	}
+}
+
+func (v2Serializer) Attributes(info ResourceInfo, data RequestData) (map[string]any, error) {
+	if data.Attributes == nil && data.Relationships == nil {
+		return nil, fmt.Errorf("%w: missing data.attributes or data.relationships", ErrInvalidDocument)
+	}
+	attrs := make(map[string]any, len(data.Attributes)+len(data.Relationships))
+	for k, v := range data.Attributes {
+		if _, isRef := info.Refs[k]; isRef {
+			return nil, fmt.Errorf("%w: %s must be set through relationships.%s", ErrInvalidDocument, k, relationshipName(k))
+		}
+		attrs[k] = v
+	}
+
+	names := make([]string, 0, len(data.Relationships))
+	for name := range data.Relationships {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rel := data.Relationships[name]
+		field, target, ok := refForRelationship(info, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown relationship %q", ErrInvalidDocument, name)
+		}
+		if rel.Data == nil {
+			attrs[field] = nil
+			continue
+		}
+		if rel.Data.Type != "" && rel.Data.Type != target {
+			return nil, fmt.Errorf("%w: relationship %q must reference %s", ErrInvalidDocument, name, target)
+		}
+		attrs[field] = rel.Data.ID
+	}
+	return attrs, nil
+}
+
+// relationshipName maps a reference attribute to its V2 relationship name.
+func relationshipName(field string) string {
+	return strings.TrimSuffix(field, "_id")
+}
+
+func refForRelationship(info ResourceInfo, name string) (field, target string, ok bool) {
+	for f, t := range info.Refs {
+		if relationshipName(f) == name {
+			return f, t, true
+		}
+	}
+	return "", "", false
+}
+
+// relationshipData renders a reference value as resource linkage; empty
+// references render as null.
+func relationshipData(target string, v any) any {
+	var id string
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		id = val
+	default:
+		id = fmt.Sprint(val)
+	}
+	if id == "" {
+		return nil
+	}
+	return map[string]any{"type": target, "id": id}
+}
+
+func pageLink(page Page, offset int) string {
+	return fmt.Sprintf("%s?page[size]=%d&page[offset]=%d", page.Path, page.Limit, offset)
+}
+
+func cursorLink(page Page, cursor string) string {
+	return fmt.Sprintf("%s?page[size]=%d&page[cursor]=%s", page.Path, page.Limit, cursor)
+}
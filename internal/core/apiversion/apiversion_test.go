@@ -0,0 +1,274 @@
+package apiversion
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Version Tests
+// =============================================================================
+
+func TestFromPath(t *testing.T) {
+	v, ok := FromPath("/api/v1/deployments/depl_1")
+	assert.True(t, ok)
+	assert.Equal(t, V1, v)
+
+	v, ok = FromPath("/api/v2")
+	assert.True(t, ok)
+	assert.Equal(t, V2, v)
+
+	for _, p := range []string{"/api/v3/nodes", "/api/versions", "/v1/nodes", "/"} {
+		_, ok := FromPath(p)
+		assert.False(t, ok, p)
+	}
+}
+
+func TestSuccessorPath(t *testing.T) {
+	assert.Equal(t, "/api/v2/nodes/node_1/housekeeping", SuccessorPath("/api/v1/nodes/node_1/housekeeping"))
+	assert.Equal(t, "/api/v2/nodes", SuccessorPath("/api/v2/nodes"))
+	assert.Equal(t, "/health", SuccessorPath("/health"))
+}
+
+// =============================================================================
+// Lifecycle Tests
+// =============================================================================
+
+func TestValidate(t *testing.T) {
+	dep := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := dep.AddDate(0, 6, 0)
+
+	assert.NoError(t, Validate(V1, Lifecycle{}))
+	assert.NoError(t, Validate(V1, Lifecycle{DeprecatedAt: dep}))
+	assert.NoError(t, Validate(V1, Lifecycle{DeprecatedAt: dep, SunsetAt: sunset}))
+
+	bad := map[Version]Lifecycle{
+		"v9": {},
+		V2:   {DeprecatedAt: dep},
+	}
+	for v, l := range bad {
+		assert.ErrorIs(t, Validate(v, l), ErrInvalidLifecycle, v)
+	}
+	assert.ErrorIs(t, Validate(V1, Lifecycle{SunsetAt: sunset}), ErrInvalidLifecycle)
+	assert.ErrorIs(t, Validate(V1, Lifecycle{DeprecatedAt: sunset, SunsetAt: dep}), ErrInvalidLifecycle)
+}
+
+func TestLifecycleStatus(t *testing.T) {
+	dep := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := Lifecycle{DeprecatedAt: dep, SunsetAt: dep.AddDate(0, 6, 0)}
+
+	assert.Equal(t, "current", Lifecycle{}.Status(dep))
+	assert.Equal(t, "deprecated", l.Status(dep.AddDate(0, -1, 0)))
+	assert.Equal(t, "deprecated", l.Status(dep))
+	assert.Equal(t, "sunset", l.Status(l.SunsetAt))
+}
+
+func TestHeaders(t *testing.T) {
+	assert.Nil(t, Headers(Lifecycle{}, "/api/v1/nodes"))
+
+	dep := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := Headers(Lifecycle{DeprecatedAt: dep}, "/api/v1/nodes")
+	assert.Equal(t, map[string]string{
+		"Deprecation": "@1767225600",
+		"Link":        `</api/v2/nodes>; rel="successor-version"`,
+	}, h)
+
+	h = Headers(Lifecycle{DeprecatedAt: dep, SunsetAt: time.Date(2026, 7, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))}, "/api/v1/nodes")
+	assert.Equal(t, "Wed, 01 Jul 2026 10:00:00 GMT", h["Sunset"])
+}
+
+func TestParseDate(t *testing.T) {
+	d, err := ParseDate("")
+	require.NoError(t, err)
+	assert.True(t, d.IsZero())
+
+	d, err = ParseDate("2026-07-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), d)
+
+	d, err = ParseDate("2026-07-01T12:00:00+02:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 7, 1, 10, 0, 0, 0, time.UTC), d)
+
+	_, err = ParseDate("next summer")
+	assert.ErrorIs(t, err, ErrInvalidLifecycle)
+}
+
+// =============================================================================
+// V1 Compatibility Tests
+// =============================================================================
+
+// These tests lock the v1 wire format. If one fails, the change breaks v1
+// clients: make it in V2 instead.
+
+var deploymentInfo = ResourceInfo{
+	Type: "deployments",
+	Refs: map[string]string{"template_id": "templates", "node_id": "nodes"},
+}
+
+func deploymentRow() map[string]any {
+	return map[string]any{
+		"reference_id": "depl_1",
+		"name":         "blog",
+		"status":       "running",
+		"template_id":  "tmpl_1",
+		"node_id":      "",
+		"created_at":   "2026-01-01T00:00:00Z",
+		"updated_at":   "2026-01-02T00:00:00Z",
+	}
+}
+
+func assertJSON(t *testing.T, want string, got any) {
+	t.Helper()
+	b, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.JSONEq(t, want, string(b))
+}
+
+func TestV1Resource(t *testing.T) {
+	row := deploymentRow()
+	row["id"] = 7
+	assertJSON(t, `{
+		"type": "deployments",
+		"id": "depl_1",
+		"attributes": {
+			"name": "blog",
+			"status": "running",
+			"template_id": "tmpl_1",
+			"node_id": "",
+			"created_at": "2026-01-01T00:00:00Z",
+			"updated_at": "2026-01-02T00:00:00Z"
+		}
+	}`, For(V1).Resource(deploymentInfo, row))
+}
+
+func TestV1Collection(t *testing.T) {
+	doc := For(V1).Collection(deploymentInfo, []map[string]any{deploymentRow()}, Page{Limit: 1, Offset: 0, Path: "/api/v1/deployments"})
+	assertJSON(t, `{
+		"data": [{
+			"type": "deployments",
+			"id": "depl_1",
+			"attributes": {
+				"name": "blog",
+				"status": "running",
+				"template_id": "tmpl_1",
+				"node_id": "",
+				"created_at": "2026-01-01T00:00:00Z",
+				"updated_at": "2026-01-02T00:00:00Z"
+			}
+		}],
+		"meta": {"total": 1, "limit": 1, "offset": 0}
+	}`, doc)
+
+	assertJSON(t, `{"data": [], "meta": {"total": 0, "limit": 20, "offset": 40}}`,
+		For(V1).Collection(deploymentInfo, nil, Page{Limit: 20, Offset: 40}))
+
+	// A full page carries the cursor of the next one
+	doc = For(V1).Collection(deploymentInfo, []map[string]any{deploymentRow()}, Page{Limit: 1, More: true, NextCursor: "abc"})
+	assertJSON(t, `{"total": 1, "limit": 1, "offset": 0, "next_cursor": "abc"}`, doc["meta"])
+}
+
+func TestV1Attributes(t *testing.T) {
+	attrs, err := For(V1).Attributes(deploymentInfo, RequestData{Attributes: map[string]any{"name": "blog", "template_id": "tmpl_1"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "blog", "template_id": "tmpl_1"}, attrs)
+
+	// v1 ignores relationships
+	_, err = For(V1).Attributes(deploymentInfo, RequestData{Relationships: map[string]RequestRelation{"template": {}}})
+	assert.Error(t, err)
+}
+
+// =============================================================================
+// V2 Tests
+// =============================================================================
+
+func TestV2Resource(t *testing.T) {
+	assertJSON(t, `{
+		"type": "deployments",
+		"id": "depl_1",
+		"attributes": {"name": "blog", "status": "running"},
+		"relationships": {
+			"template": {"data": {"type": "templates", "id": "tmpl_1"}},
+			"node": {"data": null}
+		},
+		"links": {"self": "/api/v2/deployments/depl_1"},
+		"meta": {"created_at": "2026-01-01T00:00:00Z", "updated_at": "2026-01-02T00:00:00Z"}
+	}`, For(V2).Resource(deploymentInfo, deploymentRow()))
+
+	// Unselected refs don't produce empty relationships
+	assertJSON(t, `{
+		"type": "deployments",
+		"id": "depl_1",
+		"attributes": {"name": "blog"},
+		"links": {"self": "/api/v2/deployments/depl_1"}
+	}`, For(V2).Resource(deploymentInfo, map[string]any{"reference_id": "depl_1", "name": "blog"}))
+}
+
+func TestV2Collection(t *testing.T) {
+	rows := []map[string]any{deploymentRow(), deploymentRow()}
+	doc := For(V2).Collection(deploymentInfo, rows, Page{Limit: 2, Offset: 2, Path: "/api/v2/deployments", More: true})
+
+	assert.Len(t, doc["data"], 2)
+	assertJSON(t, `{"page": {"limit": 2, "offset": 2, "count": 2}}`, doc["meta"])
+	assertJSON(t, `{
+		"self": "/api/v2/deployments?page[size]=2&page[offset]=2",
+		"prev": "/api/v2/deployments?page[size]=2&page[offset]=0",
+		"next": "/api/v2/deployments?page[size]=2&page[offset]=4"
+	}`, doc["links"])
+
+	// The last page has no next link
+	doc = For(V2).Collection(deploymentInfo, rows[:1], Page{Limit: 2, Offset: 0, Path: "/api/v2/deployments"})
+	assertJSON(t, `{"self": "/api/v2/deployments?page[size]=2&page[offset]=0"}`, doc["links"])
+}
+
+func TestV2Collection_Cursor(t *testing.T) {
+	rows := []map[string]any{deploymentRow(), deploymentRow()}
+	doc := For(V2).Collection(deploymentInfo, rows, Page{Limit: 2, Path: "/api/v2/deployments", More: true, Cursor: "abc", NextCursor: "def"})
+
+	assertJSON(t, `{"page": {"limit": 2, "offset": 0, "count": 2, "next_cursor": "def"}}`, doc["meta"])
+	assertJSON(t, `{
+		"self": "/api/v2/deployments?page[size]=2&page[cursor]=abc",
+		"next": "/api/v2/deployments?page[size]=2&page[cursor]=def"
+	}`, doc["links"])
+
+	// The last cursor page has no next link
+	doc = For(V2).Collection(deploymentInfo, rows[:1], Page{Limit: 2, Path: "/api/v2/deployments", Cursor: "abc"})
+	assertJSON(t, `{"self": "/api/v2/deployments?page[size]=2&page[cursor]=abc"}`, doc["links"])
+}
+
+func TestV2Attributes(t *testing.T) {
+	var body struct {
+		Data RequestData `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"data": {
+		"type": "deployments",
+		"attributes": {"name": "blog"},
+		"relationships": {
+			"template": {"data": {"type": "templates", "id": "tmpl_1"}},
+			"node": {"data": null}
+		}
+	}}`), &body))
+
+	attrs, err := For(V2).Attributes(deploymentInfo, body.Data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "blog", "template_id": "tmpl_1", "node_id": nil}, attrs)
+
+	bad := []RequestData{
+		{},
+		{Attributes: map[string]any{"template_id": "tmpl_1"}},
+		{Relationships: map[string]RequestRelation{"owner": {}}},
+	}
+	for _, d := range bad {
+		_, err := For(V2).Attributes(deploymentInfo, d)
+		assert.ErrorIs(t, err, ErrInvalidDocument)
+	}
+
+	var rel RequestRelation
+	require.NoError(t, json.Unmarshal([]byte(`{"data": {"type": "nodes", "id": "node_1"}}`), &rel))
+	_, err = For(V2).Attributes(deploymentInfo, RequestData{Relationships: map[string]RequestRelation{"template": rel}})
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+}
@@ -0,0 +1,123 @@
+// Package archive provides pure functions for tiered storage of old event rows:
+// retention cutoffs, daily usage rollups, and archive file naming.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package archive
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+)
+
+// =============================================================================
+// Policy
+// =============================================================================
+
+// Archivable event tables.
+const (
+	TableUsageEvents     = "usage_events"
+	TableContainerEvents = "container_events"
+)
+
+// Tables lists the tables the archiver processes, in order.
+var Tables = []string{TableUsageEvents, TableContainerEvents}
+
+// ValidTable reports whether name is an archivable table.
+func ValidTable(name string) bool {
+	for _, t := range Tables {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// DefaultRetention is how long raw event rows stay in the database.
+	DefaultRetention = 90 * 24 * time.Hour
+	// MinRetention guards against archiving rows that are still in active use.
+	MinRetention = 24 * time.Hour
+	// DefaultBatchSize is the number of rows archived and deleted per batch.
+	DefaultBatchSize = 1000
+)
+
+// Cutoff returns the instant before which rows are archived: the start of the
+// UTC day retention ago, so daily rollups only ever cover whole days.
+func Cutoff(now time.Time, retention time.Duration) time.Time {
+	if retention < MinRetention {
+		retention = MinRetention
+	}
+	t := now.UTC().Add(-retention)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FileName returns the archive file path, relative to the archive root, for a
+// batch of rows from table. Files are grouped by the month of the first row.
+func FileName(table string, first time.Time, firstID, lastID int64) string {
+	return path.Join(table, first.UTC().Format("2006-01"),
+		fmt.Sprintf("%s-%010d-%010d.ndjson.gz", table, firstID, lastID))
+}
+
+// =============================================================================
+// Daily Usage Rollup
+// =============================================================================
+
+// UsageRecord is the part of a usage event kept in the daily rollup.
+type UsageRecord struct {
+	UserID       int
+	EventType    string
+	ResourceType string
+	Quantity     int64
+	Timestamp    time.Time
+}
+
+// DailyUsage aggregates usage events per UTC day, user, event type and resource type.
+type DailyUsage struct {
+	Day          string `json:"day"` // YYYY-MM-DD (UTC)
+	UserID       int    `json:"user_id"`
+	EventType    string `json:"event_type"`
+	ResourceType string `json:"resource_type"`
+	Events       int64  `json:"events"`
+	Quantity     int64  `json:"quantity"`
+}
+
+// RollupDaily groups usage records into daily aggregates, sorted by day,
+// user, event type and resource type.
+func RollupDaily(records []UsageRecord) []DailyUsage {
+	type key struct {
+		day          string
+		userID       int
+		eventType    string
+		resourceType string
+	}
+	index := make(map[key]int)
+	var out []DailyUsage
+
+	for _, r := range records {
+		k := key{r.Timestamp.UTC().Format("2006-01-02"), r.UserID, r.EventType, r.ResourceType}
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			out = append(out, DailyUsage{Day: k.day, UserID: k.userID, EventType: k.eventType, ResourceType: k.resourceType})
+		}
+		out[i].Events++
+		out[i].Quantity += r.Quantity
+	}
+
+	sort.Slice(out, func(a, b int) bool {
+		x, y := out[a], out[b]
+		if x.Day != y.Day {
+			return x.Day < y.Day
+		}
+		if x.UserID != y.UserID {
+			return x.UserID < y.UserID
+		}
+		if x.EventType != y.EventType {
+			return x.EventType < y.EventType
+		}
+		return x.ResourceType < y.ResourceType
+	})
+	return out
+}
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidTable(t *testing.T) {
+	assert.True(t, ValidTable("usage_events"))
+	assert.True(t, ValidTable("container_events"))
+	assert.False(t, ValidTable("deployments"))
+}
+
+func TestCutoff(t *testing.T) {
+	now := time.Date(2026, 5, 20, 15, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC), Cutoff(now, DefaultRetention))
+
+	// Never less than MinRetention
+	assert.Equal(t, time.Date(2026, 5, 19, 0, 0, 0, 0, time.UTC), Cutoff(now, time.Minute))
+
+	// Non-UTC input is normalised
+	loc := time.FixedZone("UTC+10", 10*3600)
+	assert.Equal(t, time.Date(2026, 5, 19, 0, 0, 0, 0, time.UTC), Cutoff(time.Date(2026, 5, 21, 8, 0, 0, 0, loc), 24*time.Hour))
+}
+
+func TestFileName(t *testing.T) {
+	first := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, "usage_events/2026-01/usage_events-0000000001-0000000500.ndjson.gz",
+		FileName(TableUsageEvents, first, 1, 500))
+}
+
+func TestRollupDaily(t *testing.T) {
+	day1 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 1, 2, 0, 0, 1, 0, time.UTC)
+
+	got := RollupDaily([]UsageRecord{
+		{UserID: 2, EventType: "deployment.started", ResourceType: "deployment", Quantity: 1, Timestamp: day2},
+		{UserID: 1, EventType: "deployment.started", ResourceType: "deployment", Quantity: 1, Timestamp: day1},
+		{UserID: 1, EventType: "deployment.started", ResourceType: "deployment", Quantity: 3, Timestamp: day1.Add(time.Hour)},
+		{UserID: 1, EventType: "deployment.created", ResourceType: "deployment", Quantity: 1, Timestamp: day1},
+	})
+
+	assert.Equal(t, []DailyUsage{
+		{Day: "2026-01-01", UserID: 1, EventType: "deployment.created", ResourceType: "deployment", Events: 1, Quantity: 1},
+		{Day: "2026-01-01", UserID: 1, EventType: "deployment.started", ResourceType: "deployment", Events: 2, Quantity: 4},
+		{Day: "2026-01-02", UserID: 2, EventType: "deployment.started", ResourceType: "deployment", Events: 1, Quantity: 1},
+	}, got)
+
+	assert.Empty(t, RollupDaily(nil))
+}
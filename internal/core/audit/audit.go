@@ -0,0 +1,150 @@
+// Package audit provides pure functions for the audit log of API mutations:
+// the action a request performs on a resource, and the changes it made to
+// the resource's fields.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// =============================================================================
+// Actions
+// =============================================================================
+
+// Generic actions. Other mutations are named after their route below the
+// resource's ID, such as "start" or "domains/verify".
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionDelete     = "delete"
+	ActionTransition = "transition"
+)
+
+// Mutating reports whether requests with the method change state.
+func Mutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// ActionFor returns the action of a mutating request from its method and
+// the segments of its route template below the resource name, e.g. nil for
+// POST /deployments, ["{id}"] for PATCH /deployments/{id} and
+// ["{id}", "domains", "{hostname}", "verify"] for a custom action. It
+// returns "" for routes that address no resource.
+func ActionFor(method string, segs []string) string {
+	if len(segs) == 0 {
+		if method == http.MethodPost {
+			return ActionCreate
+		}
+		return ""
+	}
+	if !isVar(segs[0]) {
+		return ""
+	}
+	if len(segs) == 1 {
+		switch method {
+		case http.MethodPatch, http.MethodPut:
+			return ActionUpdate
+		case http.MethodDelete:
+			return ActionDelete
+		}
+		return ""
+	}
+	if segs[1] == "transition" {
+		return ActionTransition
+	}
+	var parts []string
+	for _, s := range segs[1:] {
+		if !isVar(s) {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// isVar reports whether a route template segment is a path variable.
+func isVar(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+// =============================================================================
+// Changes
+// =============================================================================
+
+// Redacted replaces the values of sensitive fields in changes.
+const Redacted = "[redacted]"
+
+// Change is a field's value before and after a mutation. From is absent for
+// a field the mutation set, To for one it cleared.
+type Change struct {
+	From any `json:"from,omitempty"`
+	To   any `json:"to,omitempty"`
+}
+
+// Options controls how changes are computed.
+type Options struct {
+	// Redact are fields whose values are replaced with Redacted.
+	Redact map[string]bool
+	// Ignore are fields left out, such as bookkeeping timestamps.
+	Ignore map[string]bool
+}
+
+// Diff returns the fields whose values differ between before and after,
+// either of which may be nil for a created or deleted resource. Values are
+// compared by their JSON encoding, so an int and an int64 of the same value
+// are equal.
+func Diff(before, after map[string]any, opts Options) map[string]Change {
+	changes := map[string]Change{}
+	visit := func(field string) {
+		if opts.Ignore[field] {
+			return
+		}
+		if _, seen := changes[field]; seen {
+			return
+		}
+		from, to := before[field], after[field]
+		if equal(from, to) {
+			return
+		}
+		if opts.Redact[field] {
+			from, to = redact(from), redact(to)
+		}
+		changes[field] = Change{From: from, To: to}
+	}
+	for field := range before {
+		visit(field)
+	}
+	for field := range after {
+		visit(field)
+	}
+	return changes
+}
+
+// equal reports whether two field values encode to the same JSON. Values
+// that cannot be encoded are never equal, so they are kept.
+func equal(a, b any) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ja, jb)
+}
+
+// redact hides a value, keeping whether it was set.
+func redact(v any) any {
+	if v == nil || v == "" {
+		return nil
+	}
+	return Redacted
+}
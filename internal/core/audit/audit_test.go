@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Action Tests
+// =============================================================================
+
+func TestMutating(t *testing.T) {
+	assert.False(t, Mutating("GET"))
+	assert.False(t, Mutating("HEAD"))
+	assert.False(t, Mutating("OPTIONS"))
+	assert.True(t, Mutating("POST"))
+	assert.True(t, Mutating("PATCH"))
+	assert.True(t, Mutating("DELETE"))
+}
+
+func TestActionFor(t *testing.T) {
+	tests := []struct {
+		method string
+		segs   []string
+		want   string
+	}{
+		{"POST", nil, ActionCreate},
+		{"PUT", nil, ""},
+		{"PATCH", []string{"{id}"}, ActionUpdate},
+		{"PUT", []string{"{id}"}, ActionUpdate},
+		{"DELETE", []string{"{id}"}, ActionDelete},
+		{"POST", []string{"{id}"}, ""},
+		{"POST", []string{"{id}", "transition", "{state}"}, ActionTransition},
+		{"POST", []string{"{id}", "start"}, "start"},
+		{"POST", []string{"{id}", "upgrade", "approve"}, "upgrade/approve"},
+		{"DELETE", []string{"{id}", "domains", "{hostname}"}, "domains"},
+		{"POST", []string{"{id}", "domains", "{hostname}", "verify"}, "domains/verify"},
+		{"POST", []string{"settings"}, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ActionFor(tt.method, tt.segs), "%s %v", tt.method, tt.segs)
+	}
+}
+
+// =============================================================================
+// Diff Tests
+// =============================================================================
+
+func TestDiff_Update(t *testing.T) {
+	before := map[string]any{"name": "shop", "replicas": int64(1), "status": "running", "updated_at": "a"}
+	after := map[string]any{"name": "shop", "replicas": 2, "status": "stopped", "updated_at": "b"}
+
+	changes := Diff(before, after, Options{Ignore: map[string]bool{"updated_at": true}})
+	assert.Equal(t, map[string]Change{
+		"replicas": {From: int64(1), To: 2},
+		"status":   {From: "running", To: "stopped"},
+	}, changes)
+}
+
+func TestDiff_NumericTypesEqual(t *testing.T) {
+	changes := Diff(map[string]any{"port": int64(80)}, map[string]any{"port": 80}, Options{})
+	assert.Empty(t, changes)
+}
+
+func TestDiff_CreateAndDelete(t *testing.T) {
+	row := map[string]any{"name": "shop", "port": 80}
+
+	created := Diff(nil, row, Options{})
+	assert.Equal(t, Change{To: "shop"}, created["name"])
+	assert.Len(t, created, 2)
+
+	deleted := Diff(row, nil, Options{})
+	assert.Equal(t, Change{From: 80}, deleted["port"])
+	assert.Len(t, deleted, 2)
+}
+
+func TestDiff_Redact(t *testing.T) {
+	opts := Options{Redact: map[string]bool{"private_key": true}}
+
+	changes := Diff(map[string]any{"private_key": "old"}, map[string]any{"private_key": "new"}, opts)
+	assert.Equal(t, Change{From: Redacted, To: Redacted}, changes["private_key"])
+
+	changes = Diff(map[string]any{"private_key": ""}, map[string]any{"private_key": "new"}, opts)
+	assert.Equal(t, Change{To: Redacted}, changes["private_key"])
+
+	assert.Empty(t, Diff(map[string]any{"private_key": "same"}, map[string]any{"private_key": "same"}, opts))
+}
+
+func TestDiff_NestedValues(t *testing.T) {
+	before := map[string]any{"env": map[string]any{"A": "1"}}
+	after := map[string]any{"env": map[string]any{"A": "1", "B": "2"}}
+	changes := Diff(before, after, Options{})
+	assert.Contains(t, changes, "env")
+
+	assert.Empty(t, Diff(before, map[string]any{"env": map[string]any{"A": "1"}}, Options{}))
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// Client Certificates
+// =============================================================================
+
+// CertIdentity names the part of a verified client certificate that
+// identifies its user.
+type CertIdentity string
+
+const (
+	// CertIdentityCN is the subject's common name.
+	CertIdentityCN CertIdentity = "cn"
+	// CertIdentityEmail is the first email address SAN.
+	CertIdentityEmail CertIdentity = "email"
+	// CertIdentityURI is the first URI SAN, such as a SPIFFE ID.
+	CertIdentityURI CertIdentity = "uri"
+)
+
+// ParseCertIdentity parses a CertIdentity; empty means CertIdentityCN.
+func ParseCertIdentity(s string) (CertIdentity, error) {
+	switch id := CertIdentity(strings.ToLower(strings.TrimSpace(s))); id {
+	case "":
+		return CertIdentityCN, nil
+	case CertIdentityCN, CertIdentityEmail, CertIdentityURI:
+		return id, nil
+	}
+	return "", fmt.Errorf("unknown client certificate identity %q (want cn, email or uri)", s)
+}
+
+// ClientCertUser returns the user a client certificate identifies, by its
+// identity field. The certificate must already have been verified.
+func ClientCertUser(cert *x509.Certificate, identity CertIdentity) (string, error) {
+	if identity == "" {
+		identity = CertIdentityCN
+	}
+	var user string
+	switch identity {
+	case CertIdentityCN:
+		user = cert.Subject.CommonName
+	case CertIdentityEmail:
+		if len(cert.EmailAddresses) > 0 {
+			user = cert.EmailAddresses[0]
+		}
+	case CertIdentityURI:
+		if len(cert.URIs) > 0 {
+			user = cert.URIs[0].String()
+		}
+	default:
+		return "", fmt.Errorf("unknown client certificate identity %q", identity)
+	}
+	if user = strings.TrimSpace(user); user == "" {
+		return "", fmt.Errorf("client certificate has no %s", identity)
+	}
+	return user, nil
+}
+
+// ClientCertEmail returns the certificate's first email address SAN, or "".
+func ClientCertEmail(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
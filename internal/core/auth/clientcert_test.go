@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCertIdentity(t *testing.T) {
+	for in, want := range map[string]CertIdentity{"": CertIdentityCN, "CN": CertIdentityCN, "email": CertIdentityEmail, " uri ": CertIdentityURI} {
+		got, err := ParseCertIdentity(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseCertIdentity("serial")
+	assert.Error(t, err)
+}
+
+func TestClientCertUser(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://corp.example/ci")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		EmailAddresses: []string{"alice@corp.example", "a@corp.example"},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	for identity, want := range map[CertIdentity]string{
+		"":                "alice",
+		CertIdentityCN:    "alice",
+		CertIdentityEmail: "alice@corp.example",
+		CertIdentityURI:   "spiffe://corp.example/ci",
+	} {
+		got, err := ClientCertUser(cert, identity)
+		require.NoError(t, err, identity)
+		assert.Equal(t, want, got, identity)
+	}
+	assert.Equal(t, "alice@corp.example", ClientCertEmail(cert))
+
+	_, err := ClientCertUser(&x509.Certificate{}, CertIdentityEmail)
+	assert.Error(t, err, "no email SAN")
+	_, err = ClientCertUser(&x509.Certificate{Subject: pkix.Name{CommonName: " "}}, CertIdentityCN)
+	assert.Error(t, err, "blank CN")
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// User Namespaces
+// =============================================================================
+//
+// Every authentication backend names users in users.reference_id. Each
+// backend other than APIGate prefixes its names, so a subject one backend
+// vouches for can't sign in as a user another backend created. APIGate's
+// user IDs were there first and stay unprefixed; they may not use a prefix.
+
+const (
+	// OIDCUserPrefix starts the names of users signed in with an ID token.
+	OIDCUserPrefix = "oidc:"
+	// CertUserPrefix starts the names of users signed in with a client
+	// certificate.
+	CertUserPrefix = "cert:"
+)
+
+// OIDCUser returns the name of the user an issuer's ID token identifies by
+// subject.
+func OIDCUser(issuer, subject string) string {
+	return OIDCUserPrefix + issuer + "|" + subject
+}
+
+// CertUser returns the name of the user a client certificate identifies by
+// the value of its identity field.
+func CertUser(identity CertIdentity, value string) string {
+	if identity == "" {
+		identity = CertIdentityCN
+	}
+	return CertUserPrefix + string(identity) + ":" + value
+}
+
+// ValidateGatewayUser checks a user ID APIGate sent, which must not fall in
+// another backend's namespace.
+func ValidateGatewayUser(referenceID string) error {
+	for _, prefix := range []string{OIDCUserPrefix, CertUserPrefix} {
+		if strings.HasPrefix(referenceID, prefix) {
+			return fmt.Errorf("user ID %q uses the reserved prefix %q", referenceID, prefix)
+		}
+	}
+	return nil
+}
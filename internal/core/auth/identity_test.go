@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendUsersDoNotCollide(t *testing.T) {
+	names := []string{
+		"alice",
+		OIDCUser("https://idp.example", "alice"),
+		OIDCUser("https://other-idp.example", "alice"),
+		CertUser(CertIdentityCN, "alice"),
+		CertUser(CertIdentityEmail, "alice"),
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		assert.False(t, seen[name], "%s is named twice", name)
+		seen[name] = true
+	}
+	assert.Equal(t, "oidc:https://idp.example|alice", names[1])
+	assert.Equal(t, "cert:cn:alice", CertUser("", "alice"))
+}
+
+func TestValidateGatewayUser(t *testing.T) {
+	assert.NoError(t, ValidateGatewayUser("usr_123"))
+	assert.Error(t, ValidateGatewayUser(OIDCUser("https://idp.example", "alice")))
+	assert.Error(t, ValidateGatewayUser(CertUser(CertIdentityCN, "alice")))
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// OIDC ID Tokens
+// =============================================================================
+//
+// An OIDC session is an ID token issued by the enterprise's identity provider
+// and carried in a cookie or a Bearer header. Unlike APIGate's tokens, which
+// arrive already verified, these are verified here against the provider's
+// published keys (its JWKS).
+
+var (
+	// ErrInvalidToken is a token that is malformed or whose signature or
+	// claims don't check out.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrUnknownKey is a token signed with a key the key set doesn't have,
+	// which may mean the provider rotated its keys.
+	ErrUnknownKey = errors.New("token signed with an unknown key")
+)
+
+// ClockSkew is how far exp and nbf may be off from the local clock.
+const ClockSkew = time.Minute
+
+// JWK is a public key of a JSON Web Key Set. RSA keys have N and E, EC keys
+// Crv, X and Y, all base64url encoded.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as served at an OIDC provider's jwks_uri.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// TokenClaims are the claims of a verified token.
+type TokenClaims map[string]any
+
+// String returns a string claim, or "".
+func (c TokenClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwsAlgorithm is a signature algorithm ID tokens may use. Symmetric
+// algorithms and "none" are never accepted.
+type jwsAlgorithm struct {
+	kty   string
+	hash  crypto.Hash
+	curve string // EC only
+}
+
+var jwsAlgorithms = map[string]jwsAlgorithm{
+	"RS256": {kty: "RSA", hash: crypto.SHA256},
+	"RS384": {kty: "RSA", hash: crypto.SHA384},
+	"RS512": {kty: "RSA", hash: crypto.SHA512},
+	"ES256": {kty: "EC", hash: crypto.SHA256, curve: "P-256"},
+	"ES384": {kty: "EC", hash: crypto.SHA384, curve: "P-384"},
+}
+
+// IsJWT reports whether s has the shape of a JWT.
+func IsJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// PeekIssuer returns the iss claim of a JWT without verifying it, so a token
+// can be routed to the verifier of its issuer. Returns "" if s is not a JWT.
+func PeekIssuer(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+	}
+	if decodeSegment(parts[1], &claims) != nil {
+		return ""
+	}
+	return claims.Iss
+}
+
+// VerifyJWT checks a token's signature against the key set and returns its
+// claims. The claims themselves are checked by ValidateClaims.
+func VerifyJWT(token string, keys JWKSet) (TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	alg, ok := jwsAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	found := false
+	for _, k := range keys.Keys {
+		if k.Kty != alg.kty || (header.Kid != "" && k.Kid != header.Kid) || (k.Alg != "" && k.Alg != header.Alg) || k.Use == "enc" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		found = true
+		if verifySignature(alg, pub, digest, sig) {
+			var claims TokenClaims
+			if err := decodeSegment(parts[1], &claims); err != nil {
+				return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+			}
+			return claims, nil
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, header.Kid)
+	}
+	return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// ValidateClaims checks that a token was issued by issuer for audience and is
+// valid at now.
+func ValidateClaims(c TokenClaims, issuer, audience string, now time.Time) error {
+	if iss := c.String("iss"); iss != issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, iss)
+	}
+	if !hasAudience(c["aud"], audience) {
+		return fmt.Errorf("%w: audience does not include %q", ErrInvalidToken, audience)
+	}
+	exp, ok := numericDate(c["exp"])
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.After(exp.Add(ClockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericDate(c["nbf"]); ok && now.Add(ClockSkew).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+func hasAudience(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		for _, a := range v {
+			if s, _ := a.(string); s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// publicKey decodes the key.
+func (k JWK) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func verifySignature(alg jwsAlgorithm, pub crypto.PublicKey, digest, sig []byte) bool {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, alg.hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().Name != alg.curve {
+			return false
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
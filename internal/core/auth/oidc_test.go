@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Test Helpers
+// =============================================================================
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func segment(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return b64(data)
+}
+
+func rsaJWK(key *rsa.PublicKey, kid string) JWK {
+	return JWK{Kty: "RSA", Kid: kid, N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	signing := segment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(t, claims)
+	h := crypto.SHA256.New()
+	h.Write([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	require.NoError(t, err)
+	return signing + "." + b64(sig)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	signing := segment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + segment(t, claims)
+	h := crypto.SHA256.New()
+	h.Write([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+	require.NoError(t, err)
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + b64(sig)
+}
+
+func validClaims(now time.Time) map[string]any {
+	return map[string]any{
+		"iss": "https://idp.example.com",
+		"aud": "hoster",
+		"sub": "alice",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// =============================================================================
+// VerifyJWT Tests
+// =============================================================================
+
+func TestVerifyJWT_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := JWKSet{Keys: []JWK{rsaJWK(&key.PublicKey, "k1")}}
+
+	token := signRS256(t, key, "k1", validClaims(time.Now()))
+	claims, err := VerifyJWT(token, keys)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.String("sub"))
+	assert.Equal(t, "https://idp.example.com", PeekIssuer(token))
+}
+
+func TestVerifyJWT_ES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys := JWKSet{Keys: []JWK{{Kty: "EC", Kid: "e1", Crv: "P-256",
+		X: b64(key.X.FillBytes(make([]byte, 32))), Y: b64(key.Y.FillBytes(make([]byte, 32)))}}}
+
+	claims, err := VerifyJWT(signES256(t, key, "e1", validClaims(time.Now())), keys)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.String("sub"))
+}
+
+func TestVerifyJWT_Rejects(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := JWKSet{Keys: []JWK{rsaJWK(&key.PublicKey, "k1")}}
+	now := time.Now()
+
+	_, err = VerifyJWT(signRS256(t, other, "k1", validClaims(now)), keys)
+	assert.ErrorIs(t, err, ErrInvalidToken, "signed by another key")
+
+	_, err = VerifyJWT(signRS256(t, key, "k2", validClaims(now)), keys)
+	assert.ErrorIs(t, err, ErrUnknownKey, "unknown kid")
+
+	// A token whose payload was swapped after signing
+	token := signRS256(t, key, "k1", validClaims(now))
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + segment(t, map[string]any{"sub": "mallory"}) + "." + parts[2]
+	_, err = VerifyJWT(tampered, keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Unsigned and symmetric tokens are never accepted
+	for _, alg := range []string{"none", "HS256"} {
+		unsigned := segment(t, map[string]string{"alg": alg}) + "." + segment(t, validClaims(now)) + "."
+		_, err = VerifyJWT(unsigned, keys)
+		assert.ErrorIs(t, err, ErrInvalidToken, alg)
+	}
+
+	_, err = VerifyJWT("not-a-jwt", keys)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+// =============================================================================
+// ValidateClaims Tests
+// =============================================================================
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Now()
+	const iss, aud = "https://idp.example.com", "hoster"
+
+	assert.NoError(t, ValidateClaims(roundTrip(t, validClaims(now)), iss, aud, now))
+
+	withAudList := validClaims(now)
+	withAudList["aud"] = []string{"other", "hoster"}
+	assert.NoError(t, ValidateClaims(roundTrip(t, withAudList), iss, aud, now))
+
+	tests := map[string]func(map[string]any){
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c map[string]any) { c["aud"] = "other" },
+		"no expiry":      func(c map[string]any) { delete(c, "exp") },
+		"expired":        func(c map[string]any) { c["exp"] = now.Add(-2 * ClockSkew).Unix() },
+		"not valid yet":  func(c map[string]any) { c["nbf"] = now.Add(2 * ClockSkew).Unix() },
+	}
+	for name, mutate := range tests {
+		c := validClaims(now)
+		mutate(c)
+		assert.ErrorIs(t, ValidateClaims(roundTrip(t, c), iss, aud, now), ErrInvalidToken, name)
+	}
+
+	skewed := validClaims(now)
+	skewed["exp"] = now.Add(-ClockSkew / 2).Unix()
+	assert.NoError(t, ValidateClaims(roundTrip(t, skewed), iss, aud, now), "within clock skew")
+}
+
+// roundTrip decodes claims the way VerifyJWT does.
+func roundTrip(t *testing.T, claims map[string]any) TokenClaims {
+	var out TokenClaims
+	data, err := json.Marshal(claims)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &out))
+	return out
+}
+
+func TestPeekIssuer(t *testing.T) {
+	assert.Equal(t, "", PeekIssuer("hst_abc"))
+	assert.Equal(t, "", PeekIssuer("a.b.c"))
+	assert.True(t, IsJWT("a.b.c"))
+	assert.False(t, IsJWT("hst_abc"))
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// API Tokens
+// =============================================================================
+
+// APITokenPrefix starts every API token, so tokens are told apart from
+// gateway JWTs and found by secret scanners.
+const APITokenPrefix = "hst_"
+
+// APITokenBytes is how many random bytes an API token carries.
+const APITokenBytes = 32
+
+// apiTokenHintLen is how much of a token is kept in the clear to tell
+// tokens apart in listings.
+const apiTokenHintLen = len(APITokenPrefix) + 4
+
+// NewAPIToken encodes random bytes, from a cryptographic source, as an API
+// token. The token is shown to its owner once; only its hash is stored.
+func NewAPIToken(random []byte) (string, error) {
+	if len(random) < APITokenBytes {
+		return "", fmt.Errorf("api token needs %d random bytes, got %d", APITokenBytes, len(random))
+	}
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// IsAPIToken reports whether s has the shape of an API token.
+func IsAPIToken(s string) bool {
+	return strings.HasPrefix(s, APITokenPrefix) && len(s) > apiTokenHintLen
+}
+
+// HashAPIToken returns the hash an API token is stored and looked up by.
+// Tokens are long random strings, so an unsalted hash is enough.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokenHint returns the start of a token, kept to tell tokens apart.
+func APITokenHint(token string) string {
+	if len(token) < apiTokenHintLen {
+		return token
+	}
+	return token[:apiTokenHintLen]
+}
+
+// BearerToken returns the token of an Authorization: Bearer header, or "".
+func BearerToken(authorization string) string {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
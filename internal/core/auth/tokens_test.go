@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	token, err := NewAPIToken(bytes.Repeat([]byte{0xab}, APITokenBytes))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, APITokenPrefix))
+	assert.True(t, IsAPIToken(token))
+	assert.Equal(t, token[:8], APITokenHint(token))
+
+	other, err := NewAPIToken(bytes.Repeat([]byte{0xcd}, APITokenBytes))
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	_, err = NewAPIToken(make([]byte, 8))
+	assert.Error(t, err, "too few random bytes")
+}
+
+func TestIsAPIToken(t *testing.T) {
+	assert.False(t, IsAPIToken(""))
+	assert.False(t, IsAPIToken("hst_"))
+	assert.False(t, IsAPIToken("eyJhbGciOiJSUzI1NiJ9.e30.sig"))
+}
+
+func TestHashAPIToken(t *testing.T) {
+	h := HashAPIToken("hst_abc")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, HashAPIToken("hst_abc"))
+	assert.NotEqual(t, h, HashAPIToken("hst_abd"))
+}
+
+func TestBearerToken(t *testing.T) {
+	assert.Equal(t, "abc", BearerToken("Bearer abc"))
+	assert.Equal(t, "abc", BearerToken("Bearer  abc "))
+	assert.Equal(t, "", BearerToken("Basic abc"))
+	assert.Equal(t, "", BearerToken(""))
+}
@@ -0,0 +1,187 @@
+// Package backup provides pure functions for control plane database backups:
+// backup IDs and file names, manifests, retention, choosing the backup for a
+// point-in-time restore, and judging whether backups are current.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Naming
+// =============================================================================
+
+// idLayout formats backup IDs; IDs sort in creation order.
+const idLayout = "20060102T150405Z"
+
+// NewID returns the ID of a backup taken at t.
+func NewID(t time.Time) string {
+	return t.UTC().Format(idLayout)
+}
+
+// ParseID returns the time a backup ID was taken at.
+func ParseID(id string) (time.Time, error) {
+	t, err := time.Parse(idLayout, id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid backup id %q: want YYYYMMDDTHHMMSSZ", id)
+	}
+	return t, nil
+}
+
+// DataFile returns the name of a backup's gzipped database file.
+func DataFile(id string) string {
+	return id + ".db.gz"
+}
+
+// ManifestFile returns the name of a backup's manifest.
+func ManifestFile(id string) string {
+	return id + ".json"
+}
+
+// IDFromManifestFile returns the backup ID of a manifest file name, or false
+// for other files.
+func IDFromManifestFile(name string) (string, bool) {
+	id, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return "", false
+	}
+	if _, err := ParseID(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// =============================================================================
+// Manifest
+// =============================================================================
+
+// Manifest describes one backup. It is stored next to the data file so a
+// backup can be found and verified without the database it came from.
+type Manifest struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Bytes and SHA256 describe the gzipped data file.
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+	// DatabaseBytes is the size of the uncompressed database.
+	DatabaseBytes int64 `json:"database_bytes"`
+	// Version is the hoster version that took the backup.
+	Version string `json:"version"`
+}
+
+// Validate checks a manifest read back from storage.
+func (m Manifest) Validate() error {
+	if _, err := ParseID(m.ID); err != nil {
+		return err
+	}
+	if m.Bytes <= 0 {
+		return fmt.Errorf("backup %s: empty data file", m.ID)
+	}
+	if len(m.SHA256) != 64 {
+		return fmt.Errorf("backup %s: missing checksum", m.ID)
+	}
+	return nil
+}
+
+// SortNewestFirst orders manifests by creation time, newest first.
+func SortNewestFirst(ms []Manifest) {
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID > ms[j].ID })
+}
+
+// =============================================================================
+// Retention
+// =============================================================================
+
+const (
+	// DefaultInterval is how often backups are taken.
+	DefaultInterval = 6 * time.Hour
+	// DefaultKeep is how many backups are kept.
+	DefaultKeep = 28
+	// MinInterval guards against backing up continuously.
+	MinInterval = 5 * time.Minute
+)
+
+// Retention decides which backups are kept. A backup is dropped when it is
+// beyond the newest Keep or older than MaxAge; zero disables either rule. The
+// newest backup is always kept.
+type Retention struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+// Prune returns the backups the policy drops, newest first.
+func (r Retention) Prune(ms []Manifest, now time.Time) []Manifest {
+	sorted := append([]Manifest(nil), ms...)
+	SortNewestFirst(sorted)
+
+	var drop []Manifest
+	for i, m := range sorted {
+		if i == 0 {
+			continue
+		}
+		if r.Keep > 0 && i >= r.Keep {
+			drop = append(drop, m)
+			continue
+		}
+		if r.MaxAge > 0 && now.Sub(m.CreatedAt) > r.MaxAge {
+			drop = append(drop, m)
+		}
+	}
+	return drop
+}
+
+// =============================================================================
+// Restore
+// =============================================================================
+
+// PointInTime returns the newest backup taken at or before at, which is the
+// state a restore to that instant recovers.
+func PointInTime(ms []Manifest, at time.Time) (Manifest, error) {
+	var best *Manifest
+	for i := range ms {
+		if ms[i].CreatedAt.After(at) {
+			continue
+		}
+		if best == nil || ms[i].CreatedAt.After(best.CreatedAt) {
+			best = &ms[i]
+		}
+	}
+	if best == nil {
+		return Manifest{}, fmt.Errorf("no backup taken at or before %s", at.UTC().Format(time.RFC3339))
+	}
+	return *best, nil
+}
+
+// =============================================================================
+// Status
+// =============================================================================
+
+// Status is the outcome of recent backups, reported by readiness checks.
+type Status struct {
+	LastSuccess *Manifest `json:"last_success,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+// Check returns "ok" when the newest backup is at most two intervals old, or
+// why backups are behind. A server that has not finished its first interval
+// is "pending".
+func (s Status) Check(interval time.Duration, startedAt, now time.Time) string {
+	if s.LastSuccess != nil && now.Sub(s.LastSuccess.CreatedAt) <= 2*interval {
+		return "ok"
+	}
+	if s.LastSuccess == nil && now.Sub(startedAt) <= 2*interval {
+		if s.LastError != "" {
+			return "failing: " + s.LastError
+		}
+		return "pending"
+	}
+	if s.LastError != "" {
+		return "stale: " + s.LastError
+	}
+	return "stale"
+}
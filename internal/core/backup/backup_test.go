@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func manifestAt(t time.Time) Manifest {
+	return Manifest{ID: NewID(t), CreatedAt: t, Bytes: 100, SHA256: strings.Repeat("a", 64)}
+}
+
+// =============================================================================
+// Naming Tests
+// =============================================================================
+
+func TestNewID_ParseID(t *testing.T) {
+	id := NewID(t0.In(time.FixedZone("X", 3600)))
+	assert.Equal(t, "20250301T120000Z", id)
+
+	got, err := ParseID(id)
+	require.NoError(t, err)
+	assert.True(t, got.Equal(t0))
+
+	_, err = ParseID("latest")
+	assert.ErrorContains(t, err, "invalid backup id")
+}
+
+func TestFileNames(t *testing.T) {
+	assert.Equal(t, "20250301T120000Z.db.gz", DataFile("20250301T120000Z"))
+	assert.Equal(t, "20250301T120000Z.json", ManifestFile("20250301T120000Z"))
+
+	id, ok := IDFromManifestFile("20250301T120000Z.json")
+	assert.True(t, ok)
+	assert.Equal(t, "20250301T120000Z", id)
+
+	for _, name := range []string{"20250301T120000Z.db.gz", "notes.json", "20250301T120000Z.json.tmp"} {
+		_, ok := IDFromManifestFile(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestManifest_Validate(t *testing.T) {
+	m := manifestAt(t0)
+	assert.NoError(t, m.Validate())
+
+	bad := m
+	bad.ID = "x"
+	assert.Error(t, bad.Validate())
+	bad = m
+	bad.Bytes = 0
+	assert.ErrorContains(t, bad.Validate(), "empty")
+	bad = m
+	bad.SHA256 = ""
+	assert.ErrorContains(t, bad.Validate(), "checksum")
+}
+
+// =============================================================================
+// Retention Tests
+// =============================================================================
+
+func ids(ms []Manifest) []string {
+	out := make([]string, len(ms))
+	for i, m := range ms {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func TestRetention_Prune(t *testing.T) {
+	var ms []Manifest
+	for i := 0; i < 5; i++ {
+		ms = append(ms, manifestAt(t0.Add(-time.Duration(i)*24*time.Hour)))
+	}
+	now := t0.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		policy Retention
+		want   []string
+	}{
+		{"keep all", Retention{}, nil},
+		{"keep 3", Retention{Keep: 3}, []string{"20250226T120000Z", "20250225T120000Z"}},
+		{"max age", Retention{MaxAge: 30 * time.Hour}, []string{"20250227T120000Z", "20250226T120000Z", "20250225T120000Z"}},
+		{"both", Retention{Keep: 4, MaxAge: 80 * time.Hour}, []string{"20250225T120000Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drop := tt.policy.Prune(ms, now)
+			if tt.want == nil {
+				assert.Empty(t, drop)
+				return
+			}
+			assert.Equal(t, tt.want, ids(drop))
+		})
+	}
+}
+
+func TestRetention_PruneKeepsNewest(t *testing.T) {
+	ms := []Manifest{manifestAt(t0.Add(-30 * 24 * time.Hour))}
+	assert.Empty(t, Retention{Keep: 1, MaxAge: time.Hour}.Prune(ms, t0))
+}
+
+// =============================================================================
+// Restore Tests
+// =============================================================================
+
+func TestPointInTime(t *testing.T) {
+	ms := []Manifest{
+		manifestAt(t0),
+		manifestAt(t0.Add(-6 * time.Hour)),
+		manifestAt(t0.Add(-12 * time.Hour)),
+	}
+
+	m, err := PointInTime(ms, t0.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, NewID(t0.Add(-6*time.Hour)), m.ID)
+
+	m, err = PointInTime(ms, t0)
+	require.NoError(t, err)
+	assert.Equal(t, NewID(t0), m.ID, "a backup taken at the instant counts")
+
+	_, err = PointInTime(ms, t0.Add(-13*time.Hour))
+	assert.ErrorContains(t, err, "no backup taken at or before")
+}
+
+// =============================================================================
+// Status Tests
+// =============================================================================
+
+func TestStatus_Check(t *testing.T) {
+	interval := 6 * time.Hour
+	started := t0.Add(-time.Hour)
+	recent := manifestAt(t0.Add(-7 * time.Hour))
+	old := manifestAt(t0.Add(-13 * time.Hour))
+
+	assert.Equal(t, "ok", Status{LastSuccess: &recent}.Check(interval, started, t0))
+	assert.Equal(t, "ok", Status{LastSuccess: &recent, LastError: "disk full"}.Check(interval, started, t0))
+	assert.Equal(t, "stale", Status{LastSuccess: &old}.Check(interval, started, t0))
+	assert.Equal(t, "stale: disk full", Status{LastSuccess: &old, LastError: "disk full"}.Check(interval, started, t0))
+
+	assert.Equal(t, "pending", Status{}.Check(interval, started, t0))
+	assert.Equal(t, "failing: disk full", Status{LastError: "disk full"}.Check(interval, started, t0))
+	assert.Equal(t, "stale", Status{}.Check(interval, t0.Add(-13*time.Hour), t0))
+}
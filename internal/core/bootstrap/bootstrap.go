@@ -0,0 +1,107 @@
+// Package bootstrap provides pure functions for initializing a fresh
+// installation: validating the first admin account and the shared secret
+// the API gateway authenticates with.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package bootstrap
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/sharing"
+)
+
+// =============================================================================
+// Admin Account
+// =============================================================================
+
+// SystemUserID is the reference ID of the built-in user that owns the
+// default templates. It cannot be used for the admin.
+const SystemUserID = "system"
+
+// DefaultAdminPlan is the plan given to the admin when none is chosen.
+const DefaultAdminPlan = "pro"
+
+// ErrInvalidAdmin is returned for admin details that cannot be used.
+var ErrInvalidAdmin = errors.New("invalid admin")
+
+// referenceIDPattern matches the user IDs the API gateway sends in X-User-ID.
+var referenceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:@-]{0,127}$`)
+
+// Admin is the first administrator of an installation.
+type Admin struct {
+	ReferenceID string `json:"reference_id"` // The gateway's user ID for the admin
+	Email       string `json:"email"`
+	Name        string `json:"name"`
+	PlanID      string `json:"plan_id"`
+}
+
+// Normalize validates an admin and fills in defaults: the name defaults to
+// the email's local part and the plan to DefaultAdminPlan. The plan must be
+// one of plans. ReferenceID must already be set (see NewReferenceID).
+func (a Admin) Normalize(plans []string) (Admin, error) {
+	email, err := sharing.NormalizeEmail(a.Email)
+	if err != nil {
+		return a, fmt.Errorf("%w: %v", ErrInvalidAdmin, err)
+	}
+	a.Email = email
+
+	a.ReferenceID = strings.TrimSpace(a.ReferenceID)
+	if !referenceIDPattern.MatchString(a.ReferenceID) {
+		return a, fmt.Errorf("%w: user id %q must be 1-128 letters, digits or _.:@- characters", ErrInvalidAdmin, a.ReferenceID)
+	}
+	if a.ReferenceID == SystemUserID {
+		return a, fmt.Errorf("%w: user id %q is reserved", ErrInvalidAdmin, SystemUserID)
+	}
+
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		a.Name, _, _ = strings.Cut(email, "@")
+	}
+
+	a.PlanID = strings.TrimSpace(a.PlanID)
+	if a.PlanID == "" {
+		a.PlanID = DefaultAdminPlan
+	}
+	if !slices.Contains(plans, a.PlanID) {
+		return a, fmt.Errorf("%w: plan %q must be one of %s", ErrInvalidAdmin, a.PlanID, strings.Join(plans, ", "))
+	}
+	return a, nil
+}
+
+// NewReferenceID returns a user ID for an admin from random bytes, for
+// installs where the gateway account is created afterwards.
+func NewReferenceID(random []byte) string {
+	return "usr_" + hex.EncodeToString(random)
+}
+
+// =============================================================================
+// Shared Secret
+// =============================================================================
+
+// SecretBytes is how many random bytes a generated shared secret encodes.
+const SecretBytes = 32
+
+// MinSharedSecretLength is the shortest shared secret accepted.
+const MinSharedSecretLength = 32
+
+// EncodeSharedSecret returns a shared secret from random bytes.
+func EncodeSharedSecret(random []byte) (string, error) {
+	if len(random) < SecretBytes {
+		return "", fmt.Errorf("need %d random bytes, got %d", SecretBytes, len(random))
+	}
+	return base64.RawURLEncoding.EncodeToString(random[:SecretBytes]), nil
+}
+
+// ValidateSharedSecret rejects secrets too short to withstand guessing.
+func ValidateSharedSecret(s string) error {
+	if len(s) < MinSharedSecretLength {
+		return fmt.Errorf("shared secret must be at least %d characters", MinSharedSecretLength)
+	}
+	return nil
+}
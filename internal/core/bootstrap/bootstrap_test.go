@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var plans = []string{"free", "starter", "pro"}
+
+// =============================================================================
+// Admin Tests
+// =============================================================================
+
+func TestAdmin_Normalize_Defaults(t *testing.T) {
+	a, err := Admin{ReferenceID: " usr_1 ", Email: " Ops@Example.com "}.Normalize(plans)
+	require.NoError(t, err)
+	assert.Equal(t, Admin{ReferenceID: "usr_1", Email: "ops@example.com", Name: "ops", PlanID: DefaultAdminPlan}, a)
+}
+
+func TestAdmin_Normalize_KeepsGivenValues(t *testing.T) {
+	a, err := Admin{ReferenceID: "auth0|abc", Email: "a@b.io", Name: "Ada", PlanID: "starter"}.Normalize(plans)
+	assert.True(t, errors.Is(err, ErrInvalidAdmin), "| is not allowed in user ids")
+
+	a, err = Admin{ReferenceID: "auth0:abc", Email: "a@b.io", Name: "Ada", PlanID: "starter"}.Normalize(plans)
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", a.Name)
+	assert.Equal(t, "starter", a.PlanID)
+}
+
+func TestAdmin_Normalize_Rejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		admin Admin
+		msg   string
+	}{
+		{"bad email", Admin{ReferenceID: "usr_1", Email: "not-an-email"}, "email"},
+		{"display name", Admin{ReferenceID: "usr_1", Email: "Ops <ops@example.com>"}, "email"},
+		{"empty id", Admin{Email: "ops@example.com"}, "user id"},
+		{"long id", Admin{ReferenceID: strings.Repeat("a", 129), Email: "ops@example.com"}, "user id"},
+		{"system id", Admin{ReferenceID: SystemUserID, Email: "ops@example.com"}, "reserved"},
+		{"unknown plan", Admin{ReferenceID: "usr_1", Email: "ops@example.com", PlanID: "gold"}, "free, starter, pro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.admin.Normalize(plans)
+			assert.True(t, errors.Is(err, ErrInvalidAdmin))
+			assert.Contains(t, err.Error(), tt.msg)
+		})
+	}
+}
+
+func TestNewReferenceID(t *testing.T) {
+	assert.Equal(t, "usr_00ff", NewReferenceID([]byte{0x00, 0xff}))
+}
+
+// =============================================================================
+// Shared Secret Tests
+// =============================================================================
+
+func TestEncodeSharedSecret(t *testing.T) {
+	s, err := EncodeSharedSecret(bytes.Repeat([]byte{7}, SecretBytes))
+	require.NoError(t, err)
+	assert.NoError(t, ValidateSharedSecret(s))
+
+	_, err = EncodeSharedSecret([]byte{1, 2})
+	assert.Error(t, err)
+}
+
+func TestValidateSharedSecret(t *testing.T) {
+	assert.Error(t, ValidateSharedSecret("short"))
+	assert.NoError(t, ValidateSharedSecret(strings.Repeat("x", MinSharedSecretLength)))
+}
@@ -0,0 +1,100 @@
+// Package catalog provides pure functions for searching the template
+// catalog: reading search parameters and turning free text into full-text
+// match expressions.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package catalog
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =============================================================================
+// Queries
+// =============================================================================
+
+// Query is a search of the template catalog. Every part that is set must
+// match.
+type Query struct {
+	// Text is matched against template names, descriptions, categories and
+	// tags. Each word must appear, as a word or the start of one.
+	Text string
+	// Category must equal the template's category, ignoring case.
+	Category string
+	// Tags must all be among the template's tags, ignoring case.
+	Tags []string
+	// Published, when set, must equal the template's published flag.
+	Published *bool
+}
+
+// Search limits.
+const (
+	MaxTextLength = 200
+	MaxTags       = 10
+)
+
+// ParseQuery reads a search from the query parameters q, category, tags (a
+// comma-separated list) and published. ok is false when none of them is
+// given, so the request is a plain list.
+func ParseQuery(values url.Values) (q Query, ok bool, err error) {
+	for _, key := range []string{"q", "category", "tags", "published"} {
+		if values.Has(key) {
+			ok = true
+		}
+	}
+	if !ok {
+		return Query{}, false, nil
+	}
+
+	q.Text = strings.TrimSpace(values.Get("q"))
+	if len(q.Text) > MaxTextLength {
+		return Query{}, true, fmt.Errorf("q must be at most %d characters", MaxTextLength)
+	}
+	q.Category = strings.TrimSpace(values.Get("category"))
+	for _, tag := range strings.Split(values.Get("tags"), ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(q.Tags, tag) {
+			q.Tags = append(q.Tags, tag)
+		}
+	}
+	if len(q.Tags) > MaxTags {
+		return Query{}, true, fmt.Errorf("tags may list at most %d tags", MaxTags)
+	}
+	if v := values.Get("published"); v != "" {
+		published, err := strconv.ParseBool(v)
+		if err != nil {
+			return Query{}, true, fmt.Errorf("published must be true or false, got %q", v)
+		}
+		q.Published = &published
+	}
+	return q, true, nil
+}
+
+// Terms returns the words of the query text, lowercased: runs of letters and
+// digits. Everything else separates words, so the text cannot carry
+// full-text query syntax.
+func (q Query) Terms() []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(q.Text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !slices.Contains(terms, word) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// MatchExpression returns the SQLite FTS5 MATCH expression for the query
+// text: every term, as a prefix. It is "" when the text has no terms.
+func (q Query) MatchExpression() string {
+	terms := q.Terms()
+	for i, t := range terms {
+		terms[i] = `"` + t + `"*`
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Query Tests
+// =============================================================================
+
+func TestParseQuery(t *testing.T) {
+	q, ok, err := ParseQuery(url.Values{
+		"q":         {" wordpress blog "},
+		"category":  {"cms"},
+		"tags":      {"DB, php,,db"},
+		"published": {"true"},
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "wordpress blog", q.Text)
+	assert.Equal(t, "cms", q.Category)
+	assert.Equal(t, []string{"db", "php"}, q.Tags)
+	require.NotNil(t, q.Published)
+	assert.True(t, *q.Published)
+}
+
+func TestParseQuery_None(t *testing.T) {
+	_, ok, err := ParseQuery(url.Values{"page[size]": {"10"}})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	for name, values := range map[string]url.Values{
+		"published": {"published": {"maybe"}},
+		"long text": {"q": {strings.Repeat("a", MaxTextLength+1)}},
+		"many tags": {"tags": {"a,b,c,d,e,f,g,h,i,j,k"}},
+	} {
+		_, ok, err := ParseQuery(values)
+		assert.True(t, ok, name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"word", "press", "php8", "or"}, Query{Text: `Word-Press "php8" OR word*`}.Terms())
+	assert.Empty(t, Query{Text: ` -*" `}.Terms())
+}
+
+func TestMatchExpression(t *testing.T) {
+	assert.Equal(t, `"wordpress"* "db"*`, Query{Text: "WordPress (db)"}.MatchExpression())
+	assert.Equal(t, "", Query{}.MatchExpression())
+}
@@ -0,0 +1,153 @@
+// Package checkpoint provides pure functions for the experimental live
+// migration of running deployments with Docker's CRIU checkpoint/restore:
+// migration modes, node capability evaluation, and planning which containers
+// are checkpointed under which transfer IDs.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+)
+
+// =============================================================================
+// Migration Mode
+// =============================================================================
+
+// Mode is how a deployment's containers move during a volume migration.
+type Mode string
+
+const (
+	// ModeCold migrates a stopped deployment; containers start fresh on the target.
+	ModeCold Mode = "cold"
+	// ModeCheckpoint checkpoints a running deployment's containers on the
+	// source and restores them on the target, keeping their memory state.
+	ModeCheckpoint Mode = "checkpoint"
+)
+
+// ErrInvalidMode is returned for unknown migration modes.
+var ErrInvalidMode = errors.New("invalid migration mode")
+
+// ParseMode parses a migration mode. The empty string is ModeCold.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.TrimSpace(s)) {
+	case "", ModeCold:
+		return ModeCold, nil
+	case ModeCheckpoint:
+		return ModeCheckpoint, nil
+	default:
+		return "", fmt.Errorf("%w: %q (must be %q or %q)", ErrInvalidMode, s, ModeCold, ModeCheckpoint)
+	}
+}
+
+// =============================================================================
+// Node Capability
+// =============================================================================
+
+// Name is the Docker checkpoint ID the minion creates and restores.
+const Name = "hoster"
+
+// Host is what a minion learns about its node to decide whether it can
+// checkpoint and restore containers.
+type Host struct {
+	OSType       string // Docker daemon OS, e.g. "linux"
+	Experimental bool   // Daemon runs with experimental features enabled
+	CRIUVersion  string // Output of criu --version; empty if criu is missing
+}
+
+// Evaluate reports whether a host supports checkpoint/restore. Docker only
+// exposes checkpoints on Linux daemons with experimental features enabled,
+// and needs criu installed on the host.
+func Evaluate(h Host) minion.CheckpointSupport {
+	s := minion.CheckpointSupport{
+		Experimental: h.Experimental,
+		CRIUVersion:  ParseCRIUVersion(h.CRIUVersion),
+	}
+	switch {
+	case h.OSType != "" && h.OSType != "linux":
+		s.Reason = "docker daemon is not running on linux"
+	case !h.Experimental:
+		s.Reason = "docker daemon does not have experimental features enabled"
+	case s.CRIUVersion == "":
+		s.Reason = "criu is not installed"
+	default:
+		s.Supported = true
+	}
+	return s
+}
+
+// ParseCRIUVersion extracts the version from criu --version output
+// ("Version: 3.17.1"), returning "" if there is none.
+func ParseCRIUVersion(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Version:"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// ErrUnsupported is returned when a node cannot checkpoint or restore containers.
+var ErrUnsupported = errors.New("checkpoint/restore not supported")
+
+// Require returns ErrUnsupported, naming the node's role and the reason,
+// unless s reports support.
+func Require(role string, s minion.CheckpointSupport) error {
+	if s.Supported {
+		return nil
+	}
+	reason := s.Reason
+	if reason == "" {
+		reason = "unknown reason"
+	}
+	return fmt.Errorf("%w on %s node: %s", ErrUnsupported, role, reason)
+}
+
+// =============================================================================
+// Checkpoint Planning
+// =============================================================================
+
+// Container is one container to checkpoint within a migration.
+type Container struct {
+	Service     string
+	ContainerID string
+	TransferID  string
+}
+
+// TransferID returns the transfer ID of a service's checkpoint within a
+// migration. It cannot collide with volume transfer IDs, which are named
+// after Docker volumes.
+func TransferID(migrationID, service string) string {
+	return transfer.TransferID(migrationID, "checkpoint-"+service)
+}
+
+// Plan lists the containers of a running deployment to checkpoint, in the
+// order they were started. Every container must have an ID and a distinct
+// service name so it can be matched to the container created on the target.
+func Plan(migrationID string, containers []domain.ContainerInfo) ([]Container, error) {
+	if len(containers) == 0 {
+		return nil, errors.New("deployment has no containers to checkpoint")
+	}
+	seen := make(map[string]bool, len(containers))
+	plan := make([]Container, 0, len(containers))
+	for i, c := range containers {
+		if c.ID == "" || c.ServiceName == "" {
+			return nil, fmt.Errorf("container %d has no id or service name", i+1)
+		}
+		if seen[c.ServiceName] {
+			return nil, fmt.Errorf("service %s has more than one container", c.ServiceName)
+		}
+		seen[c.ServiceName] = true
+		id := TransferID(migrationID, c.ServiceName)
+		if err := transfer.ValidateTransferID(id); err != nil {
+			return nil, err
+		}
+		plan = append(plan, Container{Service: c.ServiceName, ContainerID: c.ID, TransferID: id})
+	}
+	return plan, nil
+}
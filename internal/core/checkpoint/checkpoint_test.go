@@ -0,0 +1,111 @@
+package checkpoint
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Mode Tests
+// =============================================================================
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeCold, "cold": ModeCold, " checkpoint ": ModeCheckpoint} {
+		got, err := ParseMode(in)
+		require.NoError(t, err, "mode %q", in)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseMode("live")
+	assert.True(t, errors.Is(err, ErrInvalidMode))
+}
+
+// =============================================================================
+// Capability Tests
+// =============================================================================
+
+func TestEvaluate_Supported(t *testing.T) {
+	s := Evaluate(Host{OSType: "linux", Experimental: true, CRIUVersion: "Version: 3.17.1\n"})
+	assert.True(t, s.Supported)
+	assert.Equal(t, "3.17.1", s.CRIUVersion)
+	assert.Empty(t, s.Reason)
+}
+
+func TestEvaluate_Unsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   Host
+		reason string
+	}{
+		{"not linux", Host{OSType: "windows", Experimental: true, CRIUVersion: "Version: 3.17"}, "linux"},
+		{"not experimental", Host{OSType: "linux", CRIUVersion: "Version: 3.17"}, "experimental"},
+		{"no criu", Host{OSType: "linux", Experimental: true}, "criu"},
+		{"unparseable criu output", Host{OSType: "linux", Experimental: true, CRIUVersion: "criu: command not found"}, "criu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Evaluate(tt.host)
+			assert.False(t, s.Supported)
+			assert.Contains(t, s.Reason, tt.reason)
+		})
+	}
+}
+
+func TestParseCRIUVersion(t *testing.T) {
+	assert.Equal(t, "3.16", ParseCRIUVersion("Version: 3.16\nGitID: v3.16\n"))
+	assert.Equal(t, "", ParseCRIUVersion(""))
+}
+
+func TestRequire(t *testing.T) {
+	assert.NoError(t, Require("source", minion.CheckpointSupport{Supported: true}))
+
+	err := Require("target", minion.CheckpointSupport{Reason: "criu is not installed"})
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.Contains(t, err.Error(), "target node: criu is not installed")
+
+	err = Require("source", minion.CheckpointSupport{})
+	assert.Contains(t, err.Error(), "unknown reason")
+}
+
+// =============================================================================
+// Planning Tests
+// =============================================================================
+
+func TestTransferID_DistinctFromVolumes(t *testing.T) {
+	id := TransferID("vm_abc", "web")
+	assert.Equal(t, "vm_abc.checkpoint-web", id)
+	assert.NotEqual(t, transfer.TransferID("vm_abc", "web"), id)
+	assert.NoError(t, transfer.ValidateTransferID(id))
+}
+
+func TestPlan(t *testing.T) {
+	plan, err := Plan("vm_abc", []domain.ContainerInfo{
+		{ID: "c1", ServiceName: "db"},
+		{ID: "c2", ServiceName: "web"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Container{
+		{Service: "db", ContainerID: "c1", TransferID: "vm_abc.checkpoint-db"},
+		{Service: "web", ContainerID: "c2", TransferID: "vm_abc.checkpoint-web"},
+	}, plan)
+}
+
+func TestPlan_Rejects(t *testing.T) {
+	_, err := Plan("vm_abc", nil)
+	assert.Error(t, err)
+
+	_, err = Plan("vm_abc", []domain.ContainerInfo{{ID: "c1"}})
+	assert.Error(t, err)
+
+	_, err = Plan("vm_abc", []domain.ContainerInfo{{ID: "c1", ServiceName: "web"}, {ID: "c2", ServiceName: "web"}})
+	assert.Error(t, err)
+
+	_, err = Plan("vm_abc", []domain.ContainerInfo{{ID: "c1", ServiceName: "bad service"}})
+	assert.True(t, errors.Is(err, transfer.ErrInvalidTransferID))
+}
@@ -0,0 +1,144 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// Docker Engine Compatibility
+// =============================================================================
+//
+// Some compose features map to container options that older Docker engines
+// don't know. Depending on the daemon and client, they are rejected with a
+// cryptic error or silently ignored. The compatibility check compares the
+// features a spec uses with a node's Docker API version before anything is
+// created on it.
+
+// APIVersion is a Docker Engine API version, such as 1.41.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// ParseAPIVersion parses a Docker Engine API version such as "1.41".
+func ParseAPIVersion(s string) (APIVersion, error) {
+	major, minor, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if !ok {
+		return APIVersion{}, fmt.Errorf("invalid Docker API version %q", s)
+	}
+	v := APIVersion{}
+	var err error
+	if v.Major, err = strconv.Atoi(major); err != nil || v.Major < 0 {
+		return APIVersion{}, fmt.Errorf("invalid Docker API version %q", s)
+	}
+	if v.Minor, err = strconv.Atoi(minor); err != nil || v.Minor < 0 {
+		return APIVersion{}, fmt.Errorf("invalid Docker API version %q", s)
+	}
+	return v, nil
+}
+
+// Less reports whether v is older than o.
+func (v APIVersion) Less(o APIVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	return v.Minor < o.Minor
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// EngineFeature is a compose feature that needs a minimum Docker API version.
+type EngineFeature struct {
+	Name      string     // compose key, e.g. "init"
+	MinAPI    APIVersion // first API version that supports it
+	MinEngine string     // first Docker Engine release with MinAPI
+}
+
+// Engine features, with the API version that introduced them.
+var (
+	FeatureUlimits           = EngineFeature{Name: "ulimits", MinAPI: APIVersion{1, 18}, MinEngine: "1.6"}
+	FeatureInit              = EngineFeature{Name: "init", MinAPI: APIVersion{1, 25}, MinEngine: "1.13"}
+	FeatureDeviceCgroupRules = EngineFeature{Name: "device_cgroup_rules", MinAPI: APIVersion{1, 28}, MinEngine: "17.04"}
+	FeatureHealthStartPeriod = EngineFeature{Name: "healthcheck.start_period", MinAPI: APIVersion{1, 29}, MinEngine: "17.05"}
+	FeatureGPUs              = EngineFeature{Name: "gpus", MinAPI: APIVersion{1, 40}, MinEngine: "19.03"}
+)
+
+// FeatureUse is an engine feature a service uses.
+type FeatureUse struct {
+	Service string
+	Feature EngineFeature
+}
+
+// EngineFeatures returns the engine features each service of a spec uses,
+// ordered by service name.
+func EngineFeatures(spec *ParsedSpec) []FeatureUse {
+	var uses []FeatureUse
+	for _, svc := range spec.Services {
+		add := func(f EngineFeature) {
+			uses = append(uses, FeatureUse{Service: svc.Name, Feature: f})
+		}
+		if len(svc.Ulimits) > 0 {
+			add(FeatureUlimits)
+		}
+		if svc.Init != nil && *svc.Init {
+			add(FeatureInit)
+		}
+		if len(svc.DeviceCgroupRules) > 0 {
+			add(FeatureDeviceCgroupRules)
+		}
+		if svc.HealthCheck != nil && svc.HealthCheck.StartPeriod != "" {
+			add(FeatureHealthStartPeriod)
+		}
+		if svc.Resources.GPUs != 0 {
+			add(FeatureGPUs)
+		}
+	}
+	sort.SliceStable(uses, func(i, j int) bool { return uses[i].Service < uses[j].Service })
+	return uses
+}
+
+// IncompatibleEngineError reports the features of a spec a Docker engine
+// doesn't support.
+type IncompatibleEngineError struct {
+	EngineVersion string // Docker Engine version, if known
+	APIVersion    APIVersion
+	Unsupported   []FeatureUse
+}
+
+func (e *IncompatibleEngineError) Error() string {
+	engine := "API " + e.APIVersion.String()
+	if e.EngineVersion != "" {
+		engine = fmt.Sprintf("Docker Engine %s (API %s)", e.EngineVersion, e.APIVersion)
+	}
+	parts := make([]string, len(e.Unsupported))
+	for i, u := range e.Unsupported {
+		parts[i] = fmt.Sprintf("service %q uses %s, which needs API %s (Docker Engine %s or newer)",
+			u.Service, u.Feature.Name, u.Feature.MinAPI, u.Feature.MinEngine)
+	}
+	return fmt.Sprintf("the node runs %s: %s", engine, strings.Join(parts, "; "))
+}
+
+func (e *IncompatibleEngineError) Unwrap() error {
+	return ErrIncompatibleEngine
+}
+
+// CheckEngineCompatibility returns an *IncompatibleEngineError when the spec
+// uses features the Docker API version api doesn't support. engineVersion is
+// the engine's release, used in the error only.
+func CheckEngineCompatibility(spec *ParsedSpec, api APIVersion, engineVersion string) error {
+	var unsupported []FeatureUse
+	for _, u := range EngineFeatures(spec) {
+		if api.Less(u.Feature.MinAPI) {
+			unsupported = append(unsupported, u)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return &IncompatibleEngineError{EngineVersion: engineVersion, APIVersion: api, Unsupported: unsupported}
+}
@@ -0,0 +1,135 @@
+package compose
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const engineFeaturesSpec = `
+services:
+  web:
+    image: nginx:latest
+    init: true
+    ulimits:
+      nproc: 65535
+      nofile:
+        soft: 20000
+        hard: 40000
+  usb:
+    image: app:1.0
+    device_cgroup_rules:
+      - "c 189:* rmw"
+  db:
+    image: postgres:16
+`
+
+func TestParseComposeSpec_EngineOptions(t *testing.T) {
+	spec, err := ParseComposeSpec(engineFeaturesSpec)
+	require.NoError(t, err)
+
+	web := findService(t, spec, "web")
+	require.NotNil(t, web.Init)
+	assert.True(t, *web.Init)
+	assert.Equal(t, map[string]Ulimit{
+		"nproc":  {Soft: 65535, Hard: 65535},
+		"nofile": {Soft: 20000, Hard: 40000},
+	}, web.Ulimits)
+
+	usb := findService(t, spec, "usb")
+	assert.Nil(t, usb.Init)
+	assert.Equal(t, []string{"c 189:* rmw"}, usb.DeviceCgroupRules)
+}
+
+func TestParseAPIVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    APIVersion
+		wantErr bool
+	}{
+		{in: "1.41", want: APIVersion{1, 41}},
+		{in: "v1.24", want: APIVersion{1, 24}},
+		{in: " 1.9 ", want: APIVersion{1, 9}},
+		{in: "", wantErr: true},
+		{in: "1", wantErr: true},
+		{in: "1.x", wantErr: true},
+		{in: "-1.2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAPIVersion(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAPIVersion_Less(t *testing.T) {
+	assert.True(t, APIVersion{1, 24}.Less(APIVersion{1, 25}))
+	assert.True(t, APIVersion{1, 41}.Less(APIVersion{2, 0}))
+	assert.False(t, APIVersion{1, 25}.Less(APIVersion{1, 25}))
+	assert.False(t, APIVersion{1, 9}.Less(APIVersion{1, 8}))
+	assert.Equal(t, "1.9", APIVersion{1, 9}.String())
+}
+
+func TestEngineFeatures(t *testing.T) {
+	spec, err := ParseComposeSpec(engineFeaturesSpec)
+	require.NoError(t, err)
+
+	uses := EngineFeatures(spec)
+	require.Len(t, uses, 3)
+	assert.Equal(t, FeatureUse{Service: "usb", Feature: FeatureDeviceCgroupRules}, uses[0])
+	assert.Equal(t, FeatureUse{Service: "web", Feature: FeatureUlimits}, uses[1])
+	assert.Equal(t, FeatureUse{Service: "web", Feature: FeatureInit}, uses[2])
+}
+
+func TestEngineFeatures_HealthCheckAndGPUs(t *testing.T) {
+	spec := &ParsedSpec{Services: []Service{
+		{Name: "app", HealthCheck: &HealthCheck{Test: []string{"CMD", "true"}, StartPeriod: "30s"}},
+		{Name: "ml", Resources: ServiceResources{GPUs: -1}},
+		{Name: "off", Init: new(bool)},
+	}}
+	uses := EngineFeatures(spec)
+	require.Len(t, uses, 2)
+	assert.Equal(t, FeatureHealthStartPeriod, uses[0].Feature)
+	assert.Equal(t, FeatureGPUs, uses[1].Feature)
+}
+
+func TestCheckEngineCompatibility(t *testing.T) {
+	spec, err := ParseComposeSpec(engineFeaturesSpec)
+	require.NoError(t, err)
+
+	assert.NoError(t, CheckEngineCompatibility(spec, APIVersion{1, 28}, "17.04.0-ce"))
+	assert.NoError(t, CheckEngineCompatibility(&ParsedSpec{}, APIVersion{1, 12}, ""))
+
+	err = CheckEngineCompatibility(spec, APIVersion{1, 24}, "1.12.6")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrIncompatibleEngine))
+	var incompatible *IncompatibleEngineError
+	require.True(t, errors.As(err, &incompatible))
+	require.Len(t, incompatible.Unsupported, 2)
+	assert.Equal(t, "the node runs Docker Engine 1.12.6 (API 1.24): "+
+		`service "usb" uses device_cgroup_rules, which needs API 1.28 (Docker Engine 17.04 or newer); `+
+		`service "web" uses init, which needs API 1.25 (Docker Engine 1.13 or newer)`, err.Error())
+
+	err = CheckEngineCompatibility(spec, APIVersion{1, 26}, "")
+	require.Error(t, err)
+	assert.Equal(t, `the node runs API 1.26: service "usb" uses device_cgroup_rules, which needs API 1.28 (Docker Engine 17.04 or newer)`, err.Error())
+}
+
+func findService(t *testing.T, spec *ParsedSpec, name string) Service {
+	t.Helper()
+	for _, svc := range spec.Services {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	t.Fatalf("service %q not found", name)
+	return Service{}
+}
@@ -27,12 +27,22 @@ var (
 	ErrServiceInvalidVolume = errors.New("invalid volume configuration")
 	ErrCircularDependency   = errors.New("circular dependency detected")
 
+	ErrInvalidDependencyCondition = errors.New("invalid depends_on condition")
+	ErrInvalidHostname            = errors.New("invalid hostname")
+	ErrInvalidExtraHost           = errors.New("invalid extra_hosts entry")
+
 	// Resource validation errors
 	ErrInvalidCPU    = errors.New("invalid CPU value")
 	ErrInvalidMemory = errors.New("invalid memory value")
 
 	// Unsupported feature errors
 	ErrUnsupportedFeature = errors.New("unsupported compose feature")
+
+	// Engine compatibility errors
+	ErrIncompatibleEngine = errors.New("compose feature not supported by the Docker engine")
+
+	// Extension errors
+	ErrInvalidExtension = errors.New("invalid x-hoster extension")
 )
 
 // ParseError wraps errors with context about where parsing failed.
@@ -0,0 +1,578 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/slo"
+	"gopkg.in/yaml.v3"
+)
+
+// =============================================================================
+// x-hoster Extension
+// =============================================================================
+//
+// Template authors can keep Hoster-specific configuration in the compose file
+// itself under a top-level x-hoster key:
+//
+//	x-hoster:
+//	  routing:
+//	    service: web
+//	    port: 8080
+//	  variables:
+//	    - name: ADMIN_EMAIL
+//	      label: Admin email
+//	      type: string
+//	      required: true
+//	  healthchecks:
+//	    web:
+//	      test: curl -f http://localhost:8080/health
+//	      interval: 15s
+//	  probes:
+//	    redis:
+//	      tcp: 6379
+//	    db:
+//	      command: pg_isready -U postgres
+//	  presets:
+//	    - name: small
+//	      values:
+//	        WORKERS: "2"
+//	  slo:
+//	    availability: 99.9
+//	    latency:
+//	      threshold: 500ms
+//	      target: 99
+//	  streams:
+//	    - service: db
+//	      port: 5432
+//	      protocol: tcp
+//	      tls: true
+//	  replicas:
+//	    web: 3
+//
+// Docker Compose ignores x- keys, so the same file still works with
+// docker compose up.
+
+// ExtensionKey is the top-level compose key holding the Hoster extension.
+const ExtensionKey = "x-hoster"
+
+// Extension is the parsed x-hoster block of a compose spec.
+type Extension struct {
+	Routing      *Routing                       `json:"routing,omitempty" yaml:"routing"`
+	Variables    []domain.Variable              `json:"variables,omitempty" yaml:"variables"`
+	HealthChecks map[string]HealthCheckOverride `json:"healthchecks,omitempty" yaml:"healthchecks"`
+	Probes       map[string]Probe               `json:"probes,omitempty" yaml:"probes"`
+	Presets      []domain.Preset                `json:"presets,omitempty" yaml:"presets"`
+	SLO          *SLO                           `json:"slo,omitempty" yaml:"slo"`
+	Streams      []Stream                       `json:"streams,omitempty" yaml:"streams"`
+	Replicas     map[string]int                 `json:"replicas,omitempty" yaml:"replicas"`
+}
+
+// MaxReplicas caps the replicas of one service. Each replica after the first
+// runs on a node of its own.
+const MaxReplicas = 10
+
+// Routing selects the service and container port the App Proxy routes to.
+// Without it, the first service with ports (in start order) and its first
+// port are used.
+type Routing struct {
+	Service string `json:"service" yaml:"service"`
+	Port    uint32 `json:"port" yaml:"port"`
+}
+
+// Stream exposes a service's TCP or UDP port through Traefik, for services
+// that don't speak HTTP (databases, brokers, game servers). A TLS stream is
+// routed by TLS server name on the deployment's hostnames; every other
+// stream gets a host port of its own from the stream port range.
+type Stream struct {
+	Service  string `json:"service" yaml:"service"`
+	Port     uint32 `json:"port" yaml:"port"`
+	Protocol string `json:"protocol" yaml:"protocol"`
+	TLS      bool   `json:"tls,omitempty" yaml:"tls"`
+}
+
+// HealthCheckOverride replaces parts of a service's health check. Fields left
+// empty keep the compose value; Disable removes the health check.
+type HealthCheckOverride struct {
+	Test        healthCheckTest `json:"test,omitempty" yaml:"test"`
+	Interval    string          `json:"interval,omitempty" yaml:"interval"`
+	Timeout     string          `json:"timeout,omitempty" yaml:"timeout"`
+	Retries     *int            `json:"retries,omitempty" yaml:"retries"`
+	StartPeriod string          `json:"start_period,omitempty" yaml:"start_period"`
+	Disable     bool            `json:"disable,omitempty" yaml:"disable"`
+}
+
+// healthCheckTest accepts the compose forms of test: a list, or a string
+// that is run with the container's shell (CMD-SHELL).
+type healthCheckTest []string
+
+func (t *healthCheckTest) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var cmd string
+		if err := node.Decode(&cmd); err != nil {
+			return err
+		}
+		*t = healthCheckTest{"CMD-SHELL", cmd}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// Probe is a health check Hoster runs against a service from outside the
+// container, for services whose image has no tooling for a Docker health
+// check (redis, postgres). Exactly one of TCP and Command is set.
+type Probe struct {
+	// TCP is a container port that must accept connections.
+	TCP uint32 `json:"tcp,omitempty" yaml:"tcp"`
+	// Command is run in the container and must exit 0.
+	Command probeCommand `json:"command,omitempty" yaml:"command"`
+	// Interval is the time between probes (default 30s).
+	Interval string `json:"interval,omitempty" yaml:"interval"`
+	// Timeout bounds one probe (default 5s).
+	Timeout string `json:"timeout,omitempty" yaml:"timeout"`
+	// Retries is the number of consecutive failures that make the service
+	// unhealthy (default 3).
+	Retries *int `json:"retries,omitempty" yaml:"retries"`
+	// User runs a command probe as this user instead of DefaultProbeUser.
+	User string `json:"user,omitempty" yaml:"user"`
+}
+
+// Probe defaults.
+const (
+	DefaultProbeInterval = 30 * time.Second
+	DefaultProbeTimeout  = 5 * time.Second
+	DefaultProbeRetries  = 3
+
+	// DefaultProbeUser is the unprivileged user (nobody) command probes run
+	// as, so a template's probe cannot act with root's privileges in its
+	// container.
+	DefaultProbeUser = "65534:65534"
+)
+
+// Settings returns the probe's interval, timeout and retries with defaults
+// applied. The probe must already be validated.
+func (p Probe) Settings() (interval, timeout time.Duration, retries int) {
+	interval, timeout, retries = DefaultProbeInterval, DefaultProbeTimeout, DefaultProbeRetries
+	if d, err := time.ParseDuration(p.Interval); err == nil {
+		interval = d
+	}
+	if d, err := time.ParseDuration(p.Timeout); err == nil {
+		timeout = d
+	}
+	if p.Retries != nil {
+		retries = *p.Retries
+	}
+	return interval, timeout, retries
+}
+
+// ExecUser returns the user a command probe runs as.
+func (p Probe) ExecUser() string {
+	if p.User != "" {
+		return p.User
+	}
+	return DefaultProbeUser
+}
+
+// probeCommand accepts a command as a list (exec form) or a string that is
+// run with sh -c.
+type probeCommand []string
+
+func (c *probeCommand) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var cmd string
+		if err := node.Decode(&cmd); err != nil {
+			return err
+		}
+		*c = probeCommand{"sh", "-c", cmd}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// SLO declares the service level objectives of the template's deployments,
+// measured on the routed endpoint.
+type SLO struct {
+	// Availability is the target percentage of passed uptime checks and of
+	// requests served without a server error.
+	Availability float64     `json:"availability" yaml:"availability"`
+	Latency      *SLOLatency `json:"latency,omitempty" yaml:"latency"`
+	// WindowDays is the rolling compliance window (default 30).
+	WindowDays int `json:"window_days,omitempty" yaml:"window_days"`
+}
+
+// SLOLatency is the target percentage of requests answered within the
+// threshold, measured as time to first byte at the App Proxy.
+type SLOLatency struct {
+	Threshold string  `json:"threshold" yaml:"threshold"`
+	Target    float64 `json:"target" yaml:"target"`
+}
+
+// Objective returns the SLO as an slo.Objective. The SLO must already be
+// validated.
+func (s SLO) Objective() slo.Objective {
+	o := slo.Objective{Availability: s.Availability, WindowDays: s.WindowDays}
+	if o.WindowDays == 0 {
+		o.WindowDays = slo.DefaultWindowDays
+	}
+	if s.Latency != nil {
+		o.LatencyThreshold, _ = time.ParseDuration(s.Latency.Threshold)
+		o.LatencyTarget = s.Latency.Target
+	}
+	return o
+}
+
+// variableNameRegex matches the names usable in ${VAR} placeholders.
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// =============================================================================
+// Parsing
+// =============================================================================
+
+// ParseExtension extracts the x-hoster block from compose YAML. It returns
+// nil when the spec has no extension. Unknown keys are rejected so typos do
+// not silently drop configuration.
+func ParseExtension(yamlContent string) (*Extension, error) {
+	var doc struct {
+		Hoster yaml.Node `yaml:"x-hoster"`
+	}
+	if err := yaml.Unmarshal([]byte(yamlContent), &doc); err != nil {
+		return nil, NewParseError("", "invalid YAML syntax", ErrInvalidYAML)
+	}
+	if doc.Hoster.Kind == 0 || doc.Hoster.Tag == "!!null" {
+		return nil, nil // absent or empty
+	}
+	if doc.Hoster.Kind != yaml.MappingNode {
+		return nil, NewParseError(ExtensionKey, "must be a mapping", ErrInvalidExtension)
+	}
+
+	// Re-encode the block so it can be decoded strictly. Aliases are expanded
+	// first: their anchors may be outside the block, e.g. in an x- field.
+	block, err := expandAliases(&doc.Hoster, map[*yaml.Node]bool{})
+	if err != nil {
+		return nil, NewParseError(ExtensionKey, err.Error(), ErrInvalidExtension)
+	}
+	raw, err := yaml.Marshal(block)
+	if err != nil {
+		return nil, NewParseError(ExtensionKey, err.Error(), ErrInvalidExtension)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	var ext Extension
+	if err := dec.Decode(&ext); err != nil {
+		return nil, NewParseError(ExtensionKey, err.Error(), ErrInvalidExtension)
+	}
+	return &ext, nil
+}
+
+// expandAliases returns a copy of node with every alias replaced by a copy of
+// the node it refers to, so the copy can be encoded on its own.
+func expandAliases(node *yaml.Node, visiting map[*yaml.Node]bool) (*yaml.Node, error) {
+	if node.Kind == yaml.AliasNode {
+		if visiting[node.Alias] {
+			return nil, fmt.Errorf("alias *%s refers to itself", node.Value)
+		}
+		visiting[node.Alias] = true
+		defer delete(visiting, node.Alias)
+		return expandAliases(node.Alias, visiting)
+	}
+	out := *node
+	out.Anchor = ""
+	out.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		expanded, err := expandAliases(child, visiting)
+		if err != nil {
+			return nil, err
+		}
+		out.Content[i] = expanded
+	}
+	return &out, nil
+}
+
+// =============================================================================
+// Validation
+// =============================================================================
+
+// ValidateExtension checks the extension against the services of the spec
+// and the variable placeholders used in the compose YAML.
+func ValidateExtension(ext *Extension, spec *ParsedSpec, placeholders []string) error {
+	services := make(map[string]Service, len(spec.Services))
+	for _, svc := range spec.Services {
+		services[svc.Name] = svc
+	}
+
+	if r := ext.Routing; r != nil {
+		if _, ok := services[r.Service]; !ok {
+			return NewParseError(ExtensionKey+".routing.service",
+				fmt.Sprintf("unknown service %q", r.Service), ErrInvalidExtension)
+		}
+		if r.Port == 0 || r.Port > 65535 {
+			return NewParseError(ExtensionKey+".routing.port", "port must be between 1 and 65535", ErrInvalidExtension)
+		}
+	}
+
+	declared := make(map[string]domain.Variable, len(ext.Variables))
+	for i, v := range ext.Variables {
+		field := fmt.Sprintf("%s.variables[%d]", ExtensionKey, i)
+		if !variableNameRegex.MatchString(v.Name) {
+			return NewParseError(field+".name", fmt.Sprintf("invalid variable name %q", v.Name), ErrInvalidExtension)
+		}
+		if errs := domain.ValidateVariables([]domain.Variable{v}); len(errs) > 0 {
+			return NewParseError(field, errs[0].Error(), ErrInvalidExtension)
+		}
+		if _, dup := declared[v.Name]; dup {
+			return NewParseError(field+".name", fmt.Sprintf("duplicate variable %q", v.Name), ErrInvalidExtension)
+		}
+		declared[v.Name] = v
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(ext.HealthChecks)) {
+		hc := ext.HealthChecks[name]
+		field := ExtensionKey + ".healthchecks." + name
+		svc, ok := services[name]
+		if !ok {
+			return NewParseError(field, fmt.Sprintf("unknown service %q", name), ErrInvalidExtension)
+		}
+		if hc.Disable {
+			continue
+		}
+		if len(hc.Test) == 0 && svc.HealthCheck == nil {
+			return NewParseError(field+".test", "test is required when the service has no health check", ErrInvalidExtension)
+		}
+		for _, d := range [][2]string{{"interval", hc.Interval}, {"timeout", hc.Timeout}, {"start_period", hc.StartPeriod}} {
+			if d[1] == "" {
+				continue
+			}
+			if parsed, err := time.ParseDuration(d[1]); err != nil || parsed <= 0 {
+				return NewParseError(field+"."+d[0], fmt.Sprintf("invalid duration %q", d[1]), ErrInvalidExtension)
+			}
+		}
+		if hc.Retries != nil && *hc.Retries < 0 {
+			return NewParseError(field+".retries", "retries cannot be negative", ErrInvalidExtension)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(ext.Probes)) {
+		p := ext.Probes[name]
+		field := ExtensionKey + ".probes." + name
+		if _, ok := services[name]; !ok {
+			return NewParseError(field, fmt.Sprintf("unknown service %q", name), ErrInvalidExtension)
+		}
+		switch {
+		case p.TCP == 0 && len(p.Command) == 0:
+			return NewParseError(field, "one of tcp or command is required", ErrInvalidExtension)
+		case p.TCP != 0 && len(p.Command) > 0:
+			return NewParseError(field, "tcp and command cannot both be set", ErrInvalidExtension)
+		case p.TCP > 65535:
+			return NewParseError(field+".tcp", "port must be between 1 and 65535", ErrInvalidExtension)
+		case p.User != "" && len(p.Command) == 0:
+			return NewParseError(field+".user", "user only applies to command probes", ErrInvalidExtension)
+		}
+		for _, d := range [][2]string{{"interval", p.Interval}, {"timeout", p.Timeout}} {
+			if d[1] == "" {
+				continue
+			}
+			if parsed, err := time.ParseDuration(d[1]); err != nil || parsed <= 0 {
+				return NewParseError(field+"."+d[0], fmt.Sprintf("invalid duration %q", d[1]), ErrInvalidExtension)
+			}
+		}
+		if p.Retries != nil && *p.Retries < 1 {
+			return NewParseError(field+".retries", "retries must be at least 1", ErrInvalidExtension)
+		}
+	}
+
+	seenPresets := make(map[string]bool, len(ext.Presets))
+	for i, p := range ext.Presets {
+		field := fmt.Sprintf("%s.presets[%d]", ExtensionKey, i)
+		if p.Name == "" {
+			return NewParseError(field+".name", "preset name is required", ErrInvalidExtension)
+		}
+		if seenPresets[p.Name] {
+			return NewParseError(field+".name", fmt.Sprintf("duplicate preset %q", p.Name), ErrInvalidExtension)
+		}
+		seenPresets[p.Name] = true
+		for _, key := range slices.Sorted(maps.Keys(p.Values)) {
+			value := p.Values[key]
+			v, ok := declared[key]
+			if !ok && !slices.Contains(placeholders, key) {
+				return NewParseError(field+".values."+key, "not a variable of this template", ErrInvalidExtension)
+			}
+			if ok && v.Type == domain.VarTypeSelect && !slices.Contains(v.Options, value) {
+				return NewParseError(field+".values."+key, fmt.Sprintf("%q is not one of the options", value), ErrInvalidExtension)
+			}
+		}
+	}
+
+	if s := ext.SLO; s != nil {
+		if err := validateSLO(s, ext, spec); err != nil {
+			return err
+		}
+	}
+
+	if err := validateReplicas(ext.Replicas, services); err != nil {
+		return err
+	}
+	return validateStreams(ext.Streams, services)
+}
+
+// validateReplicas checks the extension's replicas. Replicas on other nodes
+// can't share a named volume, so replicated services must not mount one.
+func validateReplicas(replicas map[string]int, services map[string]Service) error {
+	for _, name := range slices.Sorted(maps.Keys(replicas)) {
+		field := ExtensionKey + ".replicas." + name
+		svc, ok := services[name]
+		if !ok {
+			return NewParseError(field, fmt.Sprintf("unknown service %q", name), ErrInvalidExtension)
+		}
+		if n := replicas[name]; n < 1 || n > MaxReplicas {
+			return NewParseError(field, fmt.Sprintf("replicas must be between 1 and %d", MaxReplicas), ErrInvalidExtension)
+		}
+		for _, v := range svc.Volumes {
+			if v.Type == VolumeMountTypeVolume {
+				return NewParseError(field, fmt.Sprintf("service %q mounts volume %q; replicated services must be stateless", name, v.Source), ErrInvalidExtension)
+			}
+		}
+	}
+	return nil
+}
+
+// validateStreams checks the extension's streams. TLS streams share one
+// entry point per hostname, so a template can have at most one.
+func validateStreams(streams []Stream, services map[string]Service) error {
+	seen := make(map[string]bool, len(streams))
+	tls := false
+	for i, s := range streams {
+		field := fmt.Sprintf("%s.streams[%d]", ExtensionKey, i)
+		if _, ok := services[s.Service]; !ok {
+			return NewParseError(field+".service", fmt.Sprintf("unknown service %q", s.Service), ErrInvalidExtension)
+		}
+		if s.Port == 0 || s.Port > 65535 {
+			return NewParseError(field+".port", "port must be between 1 and 65535", ErrInvalidExtension)
+		}
+		if s.Protocol != "tcp" && s.Protocol != "udp" {
+			return NewParseError(field+".protocol", fmt.Sprintf("invalid protocol %q: must be tcp or udp", s.Protocol), ErrInvalidExtension)
+		}
+		if s.TLS && s.Protocol != "tcp" {
+			return NewParseError(field+".tls", "tls only applies to tcp streams", ErrInvalidExtension)
+		}
+		if s.TLS && tls {
+			return NewParseError(field+".tls", "only one stream can use tls", ErrInvalidExtension)
+		}
+		tls = tls || s.TLS
+		key := fmt.Sprintf("%s/%s/%d", s.Service, s.Protocol, s.Port)
+		if seen[key] {
+			return NewParseError(field, fmt.Sprintf("duplicate stream %s %d on %q", s.Protocol, s.Port, s.Service), ErrInvalidExtension)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// validateSLO checks the extension's SLO. It needs a routed service, since
+// that is what it is measured on.
+func validateSLO(s *SLO, ext *Extension, spec *ParsedSpec) error {
+	field := ExtensionKey + ".slo"
+	if _, _, ok := ProxyRoute(&ParsedSpec{Services: spec.Services, Extension: ext}, spec.Services); !ok {
+		return NewParseError(field, "the template has no routed service to measure", ErrInvalidExtension)
+	}
+	if err := slo.ValidateTarget(s.Availability); err != nil {
+		return NewParseError(field+".availability", err.Error(), ErrInvalidExtension)
+	}
+	if s.WindowDays < 0 || s.WindowDays > slo.MaxWindowDays {
+		return NewParseError(field+".window_days", fmt.Sprintf("must be between 1 and %d", slo.MaxWindowDays), ErrInvalidExtension)
+	}
+	if l := s.Latency; l != nil {
+		d, err := time.ParseDuration(l.Threshold)
+		if err != nil || !slo.ValidThreshold(d) {
+			bounds := make([]string, len(slo.LatencyBuckets))
+			for i, b := range slo.LatencyBuckets {
+				bounds[i] = b.String()
+			}
+			return NewParseError(field+".latency.threshold",
+				fmt.Sprintf("invalid threshold %q (valid: %s)", l.Threshold, strings.Join(bounds, ", ")), ErrInvalidExtension)
+		}
+		if err := slo.ValidateTarget(l.Target); err != nil {
+			return NewParseError(field+".latency.target", err.Error(), ErrInvalidExtension)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Application
+// =============================================================================
+
+// applyHealthCheckOverrides merges the extension's health check overrides
+// into the parsed services. The extension must already be validated.
+func applyHealthCheckOverrides(ext *Extension, spec *ParsedSpec) {
+	for i := range spec.Services {
+		svc := &spec.Services[i]
+		hc, ok := ext.HealthChecks[svc.Name]
+		if !ok {
+			continue
+		}
+		if hc.Disable {
+			svc.HealthCheck = nil
+			continue
+		}
+		if svc.HealthCheck == nil {
+			svc.HealthCheck = &HealthCheck{}
+		}
+		if len(hc.Test) > 0 {
+			svc.HealthCheck.Test = hc.Test
+		}
+		if hc.Interval != "" {
+			svc.HealthCheck.Interval = hc.Interval
+		}
+		if hc.Timeout != "" {
+			svc.HealthCheck.Timeout = hc.Timeout
+		}
+		if hc.Retries != nil {
+			svc.HealthCheck.Retries = *hc.Retries
+		}
+		if hc.StartPeriod != "" {
+			svc.HealthCheck.StartPeriod = hc.StartPeriod
+		}
+	}
+}
+
+// Replicas returns how many containers of a service the spec asks for: its
+// x-hoster replicas, else one.
+func (s *ParsedSpec) Replicas(service string) int {
+	if s.Extension != nil {
+		if n := s.Extension.Replicas[service]; n > 1 {
+			return n
+		}
+	}
+	return 1
+}
+
+// ProxyRoute returns the service and container port the App Proxy routes to.
+// ordered is the services in start order. Without x-hoster routing, the first
+// service with ports and its first port are used; ok is false if there is none.
+func ProxyRoute(spec *ParsedSpec, ordered []Service) (service string, port uint32, ok bool) {
+	if spec.Extension != nil && spec.Extension.Routing != nil {
+		return spec.Extension.Routing.Service, spec.Extension.Routing.Port, true
+	}
+	for _, svc := range ordered {
+		if len(svc.Ports) > 0 {
+			return svc.Name, svc.Ports[0].Target, true
+		}
+	}
+	return "", 0, false
+}
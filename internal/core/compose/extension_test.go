@@ -0,0 +1,326 @@
+package compose
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Test Fixtures
+// =============================================================================
+
+const extensionSpec = `
+services:
+  web:
+    image: myapp:1.0
+    environment:
+      WORKERS: ${WORKERS:-2}
+      SIZE: ${SIZE}
+    ports:
+      - "80:80"
+      - "8080:8080"
+    healthcheck:
+      test: ["CMD", "true"]
+      interval: 30s
+      retries: 3
+  worker:
+    image: myapp:1.0
+x-hoster:
+  routing:
+    service: web
+    port: 8080
+  variables:
+    - name: SIZE
+      label: Instance size
+      type: select
+      options: [small, large]
+      required: true
+  healthchecks:
+    web:
+      test: curl -f http://localhost:8080/health
+      interval: 15s
+    worker:
+      test: ["CMD", "pgrep", "worker"]
+  probes:
+    web:
+      tcp: 8080
+      interval: 10s
+    worker:
+      command: test -f /tmp/alive
+      retries: 5
+  presets:
+    - name: small
+      description: For personal use
+      values:
+        SIZE: small
+        WORKERS: "1"
+`
+
+// =============================================================================
+// Parsing Tests
+// =============================================================================
+
+func TestParseExtension_Absent(t *testing.T) {
+	ext, err := ParseExtension(minimalValidSpec)
+	require.NoError(t, err)
+	assert.Nil(t, ext)
+}
+
+func TestParseExtension_Full(t *testing.T) {
+	ext, err := ParseExtension(extensionSpec)
+	require.NoError(t, err)
+	require.NotNil(t, ext)
+
+	assert.Equal(t, &Routing{Service: "web", Port: 8080}, ext.Routing)
+	require.Len(t, ext.Variables, 1)
+	assert.Equal(t, domain.Variable{
+		Name: "SIZE", Label: "Instance size", Type: domain.VarTypeSelect,
+		Options: []string{"small", "large"}, Required: true,
+	}, ext.Variables[0])
+	assert.Equal(t, []string{"CMD-SHELL", "curl -f http://localhost:8080/health"}, []string(ext.HealthChecks["web"].Test))
+	assert.Equal(t, []string{"CMD", "pgrep", "worker"}, []string(ext.HealthChecks["worker"].Test))
+	assert.Equal(t, uint32(8080), ext.Probes["web"].TCP)
+	assert.Equal(t, []string{"sh", "-c", "test -f /tmp/alive"}, []string(ext.Probes["worker"].Command))
+	require.Len(t, ext.Presets, 1)
+	assert.Equal(t, "small", ext.Presets[0].Name)
+	assert.Equal(t, map[string]string{"SIZE": "small", "WORKERS": "1"}, ext.Presets[0].Values)
+}
+
+func TestParseExtension_UnknownKey(t *testing.T) {
+	_, err := ParseExtension(minimalValidSpec + "x-hoster:\n  routes:\n    service: app\n")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidExtension))
+}
+
+func TestParseExtension_NotMapping(t *testing.T) {
+	_, err := ParseExtension(minimalValidSpec + "x-hoster: [1, 2]\n")
+	assert.True(t, errors.Is(err, ErrInvalidExtension))
+}
+
+func TestParseExtension_Aliases(t *testing.T) {
+	// Anchors outside the x-hoster block can be used inside it
+	ext, err := ParseExtension(`
+x-size: &size
+  name: SIZE
+  label: Instance size
+  type: select
+  options: [small, large]
+x-route: &route
+  service: app
+services:
+  app:
+    image: nginx:latest
+x-hoster:
+  routing:
+    <<: *route
+    port: 8080
+  variables:
+    - *size
+`)
+	require.NoError(t, err)
+	require.Len(t, ext.Variables, 1)
+	assert.Equal(t, "SIZE", ext.Variables[0].Name)
+	assert.Equal(t, []string{"small", "large"}, ext.Variables[0].Options)
+	assert.Equal(t, "app", ext.Routing.Service)
+	assert.Equal(t, uint32(8080), ext.Routing.Port)
+}
+
+// =============================================================================
+// ParseComposeSpec Integration Tests
+// =============================================================================
+
+func TestParseComposeSpec_ExtensionApplied(t *testing.T) {
+	spec, err := ParseComposeSpec(extensionSpec)
+	require.NoError(t, err)
+	require.NotNil(t, spec.Extension)
+
+	byName := map[string]Service{}
+	for _, svc := range spec.Services {
+		byName[svc.Name] = svc
+	}
+
+	web := byName["web"].HealthCheck
+	require.NotNil(t, web)
+	assert.Equal(t, []string{"CMD-SHELL", "curl -f http://localhost:8080/health"}, web.Test)
+	assert.Equal(t, "15s", web.Interval, "overridden")
+	assert.Equal(t, 3, web.Retries, "kept from compose")
+
+	worker := byName["worker"].HealthCheck
+	require.NotNil(t, worker, "added by the extension")
+	assert.Equal(t, []string{"CMD", "pgrep", "worker"}, worker.Test)
+}
+
+func TestParseComposeSpec_ExtensionDisableHealthCheck(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: nginx:latest
+    healthcheck:
+      test: ["CMD", "true"]
+x-hoster:
+  healthchecks:
+    web:
+      disable: true
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	assert.Nil(t, spec.Services[0].HealthCheck)
+}
+
+func TestParseComposeSpec_ExtensionInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		ext   string
+		field string
+	}{
+		{"unknown routing service", "routing: {service: db, port: 80}", "x-hoster.routing.service"},
+		{"routing port zero", "routing: {service: app, port: 0}", "x-hoster.routing.port"},
+		{"invalid variable name", "variables: [{name: 1BAD, type: string}]", "x-hoster.variables[0].name"},
+		{"invalid variable type", "variables: [{name: A, type: color}]", "x-hoster.variables[0]"},
+		{"duplicate variable", "variables: [{name: A, type: string}, {name: A, type: string}]", "x-hoster.variables[1].name"},
+		{"unknown healthcheck service", "healthchecks: {db: {test: [CMD, 'true']}}", "x-hoster.healthchecks.db"},
+		{"healthcheck without test", "healthchecks: {app: {interval: 10s}}", "x-hoster.healthchecks.app.test"},
+		{"invalid duration", "healthchecks: {app: {test: [CMD, 'true'], timeout: soon}}", "x-hoster.healthchecks.app.timeout"},
+		{"unknown probe service", "probes: {db: {tcp: 5432}}", "x-hoster.probes.db"},
+		{"probe without check", "probes: {app: {interval: 10s}}", "x-hoster.probes.app"},
+		{"probe with both checks", "probes: {app: {tcp: 80, command: 'true'}}", "x-hoster.probes.app"},
+		{"probe port too large", "probes: {app: {tcp: 70000}}", "x-hoster.probes.app.tcp"},
+		{"probe invalid interval", "probes: {app: {tcp: 80, interval: often}}", "x-hoster.probes.app.interval"},
+		{"probe zero retries", "probes: {app: {tcp: 80, retries: 0}}", "x-hoster.probes.app.retries"},
+		{"tcp probe with user", "probes: {app: {tcp: 80, user: root}}", "x-hoster.probes.app.user"},
+		{"preset without name", "presets: [{values: {}}]", "x-hoster.presets[0].name"},
+		{"preset unknown variable", "presets: [{name: p, values: {NOPE: x}}]", "x-hoster.presets[0].values.NOPE"},
+		{"preset invalid option", "variables: [{name: S, type: select, options: [a]}]\n  presets: [{name: p, values: {S: b}}]", "x-hoster.presets[0].values.S"},
+		{"slo without routed service", "slo: {availability: 99.9}", "x-hoster.slo"},
+		{"slo availability 100", "routing: {service: app, port: 80}\n  slo: {availability: 100}", "x-hoster.slo.availability"},
+		{"slo window too long", "routing: {service: app, port: 80}\n  slo: {availability: 99, window_days: 365}", "x-hoster.slo.window_days"},
+		{"slo threshold not a bucket", "routing: {service: app, port: 80}\n  slo: {availability: 99, latency: {threshold: 300ms, target: 95}}", "x-hoster.slo.latency.threshold"},
+		{"slo latency without target", "routing: {service: app, port: 80}\n  slo: {availability: 99, latency: {threshold: 1s}}", "x-hoster.slo.latency.target"},
+		{"unknown stream service", "streams: [{service: db, port: 5432, protocol: tcp}]", "x-hoster.streams[0].service"},
+		{"stream port zero", "streams: [{service: app, port: 0, protocol: tcp}]", "x-hoster.streams[0].port"},
+		{"stream invalid protocol", "streams: [{service: app, port: 80, protocol: sctp}]", "x-hoster.streams[0].protocol"},
+		{"udp stream with tls", "streams: [{service: app, port: 80, protocol: udp, tls: true}]", "x-hoster.streams[0].tls"},
+		{"two tls streams", "streams: [{service: app, port: 80, protocol: tcp, tls: true}, {service: app, port: 81, protocol: tcp, tls: true}]", "x-hoster.streams[1].tls"},
+		{"duplicate stream", "streams: [{service: app, port: 80, protocol: tcp}, {service: app, port: 80, protocol: tcp}]", "x-hoster.streams[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseComposeSpec(minimalValidSpec + "x-hoster:\n  " + tt.ext + "\n")
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidExtension))
+			var parseErr *ParseError
+			require.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tt.field, parseErr.Field)
+		})
+	}
+}
+
+func TestParseComposeSpec_ExtensionStreams(t *testing.T) {
+	spec, err := ParseComposeSpec(minimalValidSpec + "x-hoster:\n  streams:\n    - {service: app, port: 5432, protocol: tcp, tls: true}\n    - {service: app, port: 5432, protocol: udp}\n")
+	require.NoError(t, err)
+	assert.Equal(t, []Stream{
+		{Service: "app", Port: 5432, Protocol: "tcp", TLS: true},
+		{Service: "app", Port: 5432, Protocol: "udp"},
+	}, spec.Extension.Streams)
+}
+
+func TestParseComposeSpec_ExtensionReplicas(t *testing.T) {
+	spec, err := ParseComposeSpec(`
+services:
+  web:
+    image: app:1
+    depends_on: [db]
+  db:
+    image: postgres:16
+    expose: ["5432", "6000-6010"]
+    volumes: [data:/var/lib/postgresql/data]
+volumes:
+  data:
+x-hoster:
+  replicas:
+    web: 3
+`)
+	require.NoError(t, err)
+	assert.Equal(t, 3, spec.Replicas("web"))
+	assert.Equal(t, 1, spec.Replicas("db"))
+	for _, svc := range spec.Services {
+		if svc.Name == "db" {
+			assert.Equal(t, []uint32{5432}, svc.Expose, "ranges are left out")
+		}
+	}
+
+	for name, ext := range map[string]string{
+		"unknown service": "x-hoster:\n  replicas:\n    worker: 2\n",
+		"zero":            "x-hoster:\n  replicas:\n    app: 0\n",
+		"too many":        "x-hoster:\n  replicas:\n    app: 11\n",
+	} {
+		_, err := ParseComposeSpec(minimalValidSpec + ext)
+		assert.ErrorIs(t, err, ErrInvalidExtension, name)
+	}
+
+	_, err = ParseComposeSpec("services:\n  db:\n    image: postgres:16\n    volumes: [data:/data]\nvolumes:\n  data:\nx-hoster:\n  replicas:\n    db: 2\n")
+	assert.ErrorContains(t, err, "stateless")
+}
+
+func TestSLO_Objective(t *testing.T) {
+	spec, err := ParseComposeSpec(minimalValidSpec + "x-hoster:\n  routing: {service: app, port: 80}\n  slo: {availability: 99.5, latency: {threshold: 500ms, target: 95}}\n")
+	require.NoError(t, err)
+	o := spec.Extension.SLO.Objective()
+	assert.Equal(t, 99.5, o.Availability)
+	assert.Equal(t, 500*time.Millisecond, o.LatencyThreshold)
+	assert.Equal(t, 95.0, o.LatencyTarget)
+	assert.Equal(t, 30, o.WindowDays, "default window")
+}
+
+func TestProbe_ExecUser(t *testing.T) {
+	assert.Equal(t, DefaultProbeUser, Probe{Command: []string{"true"}}.ExecUser())
+	assert.Equal(t, "redis", Probe{Command: []string{"true"}, User: "redis"}.ExecUser())
+}
+
+func TestProbe_Settings(t *testing.T) {
+	interval, timeout, retries := Probe{TCP: 6379}.Settings()
+	assert.Equal(t, DefaultProbeInterval, interval)
+	assert.Equal(t, DefaultProbeTimeout, timeout)
+	assert.Equal(t, DefaultProbeRetries, retries)
+
+	five := 5
+	interval, timeout, retries = Probe{TCP: 6379, Interval: "10s", Timeout: "2s", Retries: &five}.Settings()
+	assert.Equal(t, 10*time.Second, interval)
+	assert.Equal(t, 2*time.Second, timeout)
+	assert.Equal(t, 5, retries)
+}
+
+// =============================================================================
+// ProxyRoute Tests
+// =============================================================================
+
+func TestProxyRoute_FromExtension(t *testing.T) {
+	spec, err := ParseComposeSpec(extensionSpec)
+	require.NoError(t, err)
+
+	service, port, ok := ProxyRoute(spec, spec.Services)
+	assert.True(t, ok)
+	assert.Equal(t, "web", service)
+	assert.Equal(t, uint32(8080), port)
+}
+
+func TestProxyRoute_Default(t *testing.T) {
+	ordered := []Service{
+		{Name: "db"},
+		{Name: "api", Ports: []Port{{Target: 3000}, {Target: 9090}}},
+		{Name: "web", Ports: []Port{{Target: 80}}},
+	}
+	service, port, ok := ProxyRoute(&ParsedSpec{Services: ordered}, ordered)
+	assert.True(t, ok)
+	assert.Equal(t, "api", service)
+	assert.Equal(t, uint32(3000), port)
+
+	_, _, ok = ProxyRoute(&ParsedSpec{}, []Service{{Name: "db"}})
+	assert.False(t, ok)
+}
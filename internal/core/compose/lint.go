@@ -0,0 +1,416 @@
+package compose
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// =============================================================================
+// Lint Pass
+// =============================================================================
+//
+// LintSpec checks a compose spec the way a template author needs it checked
+// before saving: it reports every problem it finds, not just the first, and
+// points each one at the line of the compose YAML it is on. Errors are
+// problems deployments fail on or silently ignore; warnings are likely
+// mistakes that still deploy.
+
+// Severity is how serious a lint issue is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Lint issue codes.
+const (
+	IssueInvalidSpec        = "invalid_spec"
+	IssueInvalidExtension   = "invalid_extension"
+	IssueUnsupportedFeature = "unsupported_feature"
+	IssueBuild              = "build"
+	IssuePrivileged         = "privileged"
+	IssueHostNetwork        = "host_network"
+	IssueUndeclaredVariable = "undeclared_variable"
+	IssueUnusedVariable     = "unused_variable"
+	IssueCircularDependency = "circular_dependency"
+)
+
+// Issue is a problem found by LintSpec. Line and Column are 1-based and zero
+// when the issue has no position in the YAML.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Field    string   `json:"field,omitempty"` // e.g. "services.web.privileged"
+	Message  string   `json:"message"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+}
+
+// LintOptions describes the template a spec belongs to.
+type LintOptions struct {
+	// Published applies the rules of published templates, which customers
+	// deploy without the author's help.
+	Published bool
+	// Variables are the names of the template's variables declared outside
+	// the spec. Variables declared in x-hoster are read from the spec.
+	Variables []string
+}
+
+// yamlLineRegex finds the line number in a YAML syntax error.
+var yamlLineRegex = regexp.MustCompile(`line (\d+)`)
+
+// LintSpec returns the issues of a compose spec, ordered by position.
+func LintSpec(yamlContent string, opts LintOptions) []Issue {
+	issues := []Issue{}
+	if strings.TrimSpace(yamlContent) == "" {
+		return append(issues, Issue{Severity: SeverityError, Code: IssueInvalidSpec, Message: ErrEmptyInput.Error()})
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(yamlContent), &root); err != nil {
+		issue := Issue{Severity: SeverityError, Code: IssueInvalidSpec, Message: err.Error()}
+		if m := yamlLineRegex.FindStringSubmatch(err.Error()); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+		}
+		return append(issues, issue)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return append(issues, Issue{Severity: SeverityError, Code: IssueInvalidSpec, Message: "compose spec must be a mapping", Line: 1, Column: 1})
+	}
+	doc := root.Content[0]
+
+	issues = append(issues, lintExternalFiles(doc, yamlContent)...)
+	issues = append(issues, lintServices(doc, opts)...)
+	cycles := lintDependencyCycles(doc)
+	issues = append(issues, cycles...)
+	issues = append(issues, lintVariables(doc, yamlContent, opts)...)
+
+	// The parser stops at the first error; report it unless the lint pass
+	// already reported the same problem with a position.
+	if _, err := ParseComposeSpec(yamlContent); err != nil {
+		var pe *ParseError
+		errors.As(err, &pe)
+		duplicate := errors.Is(err, ErrCircularDependency) && len(cycles) > 0
+		if pe != nil {
+			for _, issue := range issues {
+				duplicate = duplicate || (pe.Field != "" && issue.Field == pe.Field)
+			}
+		}
+		code := IssueInvalidSpec
+		if errors.Is(err, ErrInvalidExtension) {
+			code = IssueInvalidExtension
+		}
+		if !duplicate {
+			issues = append(issues, errorIssue(doc, code, err))
+		}
+	}
+
+	slices.SortStableFunc(issues, func(a, b Issue) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return issues
+}
+
+// errorIssue returns an error issue for err, at the position of its field.
+func errorIssue(doc *yaml.Node, code string, err error) Issue {
+	issue := Issue{Severity: SeverityError, Code: code, Message: err.Error()}
+	var pe *ParseError
+	if errors.As(err, &pe) && pe.Field != "" {
+		issue.Field = pe.Field
+		issue.Message = pe.Message
+		if node := locate(doc, pe.Field); node != nil {
+			issue.Line, issue.Column = node.Line, node.Column
+		}
+	}
+	return issue
+}
+
+// lintExternalFiles reports every reference to another compose file.
+func lintExternalFiles(doc *yaml.Node, yamlContent string) []Issue {
+	var dict map[string]interface{}
+	if err := yaml.Unmarshal([]byte(yamlContent), &dict); err != nil {
+		return nil
+	}
+	var issues []Issue
+	for _, pe := range externalFiles(dict) {
+		issues = append(issues, errorIssue(doc, IssueUnsupportedFeature, pe))
+	}
+	return issues
+}
+
+// lintServices reports service fields deployments don't support.
+func lintServices(doc *yaml.Node, opts LintOptions) []Issue {
+	var issues []Issue
+	services := mappingValue(doc, "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, svc := services.Content[i].Value, services.Content[i+1]
+		if svc.Kind != yaml.MappingNode {
+			continue
+		}
+		field := "services." + name + "."
+
+		if key := mappingKey(svc, "build"); key != nil {
+			issue := Issue{
+				Severity: SeverityWarning,
+				Code:     IssueBuild,
+				Field:    field + "build",
+				Message:  "deployments pull images and never build them; push the image to a registry and set image",
+				Line:     key.Line,
+				Column:   key.Column,
+			}
+			if opts.Published {
+				issue.Severity = SeverityError
+				issue.Message = "published templates cannot use build; push the image to a registry and set image"
+			}
+			issues = append(issues, issue)
+		}
+		if key := mappingKey(svc, "privileged"); key != nil && isTrue(mappingValue(svc, "privileged")) {
+			issues = append(issues, Issue{
+				Severity: SeverityError,
+				Code:     IssuePrivileged,
+				Field:    field + "privileged",
+				Message:  "privileged containers are not supported; deployments run without it",
+				Line:     key.Line,
+				Column:   key.Column,
+			})
+		}
+		if key := mappingKey(svc, "network_mode"); key != nil && mappingValue(svc, "network_mode").Value == "host" {
+			issues = append(issues, Issue{
+				Severity: SeverityError,
+				Code:     IssueHostNetwork,
+				Field:    field + "network_mode",
+				Message:  "host networking is not supported; publish the ports the service needs",
+				Line:     key.Line,
+				Column:   key.Column,
+			})
+		}
+	}
+	return issues
+}
+
+// lintDependencyCycles reports each depends_on cycle once, at the depends_on
+// of the first service in it by name.
+func lintDependencyCycles(doc *yaml.Node) []Issue {
+	services := mappingValue(doc, "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil
+	}
+	deps := map[string][]string{}
+	keys := map[string]*yaml.Node{}
+	var names []string
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, svc := services.Content[i].Value, services.Content[i+1]
+		names = append(names, name)
+		if svc.Kind != yaml.MappingNode {
+			continue
+		}
+		keys[name] = mappingKey(svc, "depends_on")
+		switch on := mappingValue(svc, "depends_on"); {
+		case on == nil:
+		case on.Kind == yaml.SequenceNode:
+			for _, dep := range on.Content {
+				deps[name] = append(deps[name], dep.Value)
+			}
+		case on.Kind == yaml.MappingNode:
+			for j := 0; j < len(on.Content); j += 2 {
+				deps[name] = append(deps[name], on.Content[j].Value)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	var issues []Issue
+	seen := map[string]bool{}
+	state := map[string]int{} // 1 on the current path, 2 done
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = 1
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			switch state[dep] {
+			case 0:
+				visit(dep)
+			case 1:
+				cycle := append([]string(nil), path[slices.Index(path, dep):]...)
+				// Rotate to the smallest name so each cycle is reported once
+				first := slices.Index(cycle, slices.Min(cycle))
+				cycle = append(cycle[first:], cycle[:first]...)
+				id := strings.Join(cycle, ",")
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				issue := Issue{
+					Severity: SeverityError,
+					Code:     IssueCircularDependency,
+					Field:    "services." + cycle[0] + ".depends_on",
+					Message:  "circular depends_on: " + strings.Join(append(cycle, cycle[0]), " -> "),
+				}
+				if key := keys[cycle[0]]; key != nil {
+					issue.Line, issue.Column = key.Line, key.Column
+				}
+				issues = append(issues, issue)
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = 2
+	}
+	for _, name := range names {
+		if state[name] == 0 {
+			visit(name)
+		}
+	}
+	return issues
+}
+
+// lintVariables reports ${VAR} placeholders without a declared variable, and
+// declared variables no placeholder uses. ${secret:NAME} placeholders refer
+// to stored secrets and are not variables.
+func lintVariables(doc *yaml.Node, yamlContent string, opts LintOptions) []Issue {
+	declared := map[string]*yaml.Node{}
+	for _, name := range opts.Variables {
+		declared[name] = nil
+	}
+	if vars := mappingValue(mappingValue(doc, ExtensionKey), "variables"); vars != nil && vars.Kind == yaml.SequenceNode {
+		for _, v := range vars.Content {
+			if name := mappingValue(v, "name"); name != nil && name.Value != "" {
+				declared[name.Value] = name
+			}
+		}
+	}
+
+	var issues []Issue
+	used := map[string]bool{}
+	walkScalars(doc, func(node *yaml.Node) {
+		for _, m := range variablePlaceholderRegex.FindAllStringSubmatchIndex(node.Value, -1) {
+			if m[0] > 0 && node.Value[m[0]-1] == '$' {
+				continue // $${VAR} is an escaped dollar sign
+			}
+			name := node.Value[m[2]:m[3]]
+			if used[name] {
+				continue
+			}
+			used[name] = true
+			if _, ok := declared[name]; ok {
+				continue
+			}
+			issue := Issue{
+				Severity: SeverityError,
+				Code:     IssueUndeclaredVariable,
+				Message:  fmt.Sprintf("${%s} has no declared variable, so deployments cannot set it", name),
+				Line:     node.Line,
+				Column:   node.Column,
+			}
+			if strings.Contains(node.Value[m[0]:m[1]], ":-") {
+				issue.Severity = SeverityWarning
+				issue.Message = fmt.Sprintf("${%s} has no declared variable and always uses its default", name)
+			}
+			issues = append(issues, issue)
+		}
+	})
+
+	for name, node := range declared {
+		if used[name] {
+			continue
+		}
+		issue := Issue{
+			Severity: SeverityWarning,
+			Code:     IssueUnusedVariable,
+			Message:  fmt.Sprintf("variable %s is declared but no ${%s} placeholder uses it", name, name),
+		}
+		if node != nil {
+			issue.Line, issue.Column = node.Line, node.Column
+		}
+		issues = append(issues, issue)
+	}
+	slices.SortFunc(issues, func(a, b Issue) int { return cmp.Compare(a.Message, b.Message) })
+	return issues
+}
+
+// walkScalars calls fn for every scalar of node outside the x-hoster block,
+// mapping keys included.
+func walkScalars(node *yaml.Node, fn func(*yaml.Node)) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		fn(node)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == ExtensionKey {
+				continue
+			}
+			walkScalars(node.Content[i], fn)
+			walkScalars(node.Content[i+1], fn)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkScalars(child, fn)
+		}
+	}
+}
+
+// isTrue reports whether a YAML node holds the boolean true.
+func isTrue(node *yaml.Node) bool {
+	var b bool
+	return node.Decode(&b) == nil && b
+}
+
+// mappingKey returns the key node of key in a mapping node, or nil.
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// locate returns the node a ParseError field such as "services.web.ports[0]"
+// refers to, or the deepest node of the field that exists.
+func locate(doc *yaml.Node, field string) *yaml.Node {
+	var found *yaml.Node
+	node := doc
+	for _, part := range strings.Split(field, ".") {
+		name, index, indexed := strings.Cut(part, "[")
+		key := mappingKey(node, name)
+		if key == nil {
+			break
+		}
+		found, node = key, mappingValue(node, name)
+		if indexed {
+			i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+			if err != nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				break
+			}
+			found, node = node.Content[i], node.Content[i]
+		}
+	}
+	return found
+}
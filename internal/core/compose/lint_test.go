@@ -0,0 +1,158 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Lint Tests
+// =============================================================================
+
+// issueCodes returns the codes of issues, in order.
+func issueCodes(issues []Issue) []string {
+	codes := make([]string, len(issues))
+	for i, issue := range issues {
+		codes[i] = issue.Code
+	}
+	return codes
+}
+
+func TestLintSpec_Clean(t *testing.T) {
+	spec := `
+services:
+  web:
+    image: nginx:latest
+    environment:
+      TITLE: ${TITLE}
+      DB_PASSWORD: ${secret:DB_PASSWORD}
+      PRICE: $${NOT_A_VARIABLE}
+x-hoster:
+  variables:
+    - name: TITLE
+      label: Title
+      type: string
+`
+	assert.Empty(t, LintSpec(spec, LintOptions{Published: true}))
+}
+
+func TestLintSpec_UnsupportedServiceFields(t *testing.T) {
+	spec := `services:
+  web:
+    build: .
+    privileged: true
+    network_mode: host
+  worker:
+    image: busybox
+    privileged: false
+`
+	issues := LintSpec(spec, LintOptions{})
+	require.Len(t, issues, 3)
+	assert.Equal(t, Issue{
+		Severity: SeverityWarning,
+		Code:     IssueBuild,
+		Field:    "services.web.build",
+		Message:  "deployments pull images and never build them; push the image to a registry and set image",
+		Line:     3,
+		Column:   5,
+	}, issues[0])
+	assert.Equal(t, IssuePrivileged, issues[1].Code)
+	assert.Equal(t, 4, issues[1].Line)
+	assert.Equal(t, IssueHostNetwork, issues[2].Code)
+	assert.Equal(t, 5, issues[2].Line)
+
+	issues = LintSpec(spec, LintOptions{Published: true})
+	assert.Equal(t, SeverityError, issues[0].Severity, "build is an error in published templates")
+}
+
+func TestLintSpec_Variables(t *testing.T) {
+	spec := `services:
+  web:
+    image: nginx:${TAG:-latest}
+    environment:
+      A: ${DECLARED}
+      B: ${MISSING}
+      C: ${MISSING}
+x-hoster:
+  variables:
+    - name: UNUSED
+      label: Unused
+      type: string
+`
+	issues := LintSpec(spec, LintOptions{Variables: []string{"DECLARED"}})
+	assert.Equal(t, []string{IssueUndeclaredVariable, IssueUndeclaredVariable, IssueUnusedVariable}, issueCodes(issues))
+
+	assert.Equal(t, SeverityWarning, issues[0].Severity, "a placeholder with a default still deploys")
+	assert.Contains(t, issues[0].Message, "${TAG}")
+	assert.Equal(t, 3, issues[0].Line)
+
+	assert.Equal(t, SeverityError, issues[1].Severity)
+	assert.Contains(t, issues[1].Message, "${MISSING}")
+	assert.Equal(t, 6, issues[1].Line, "reported once, at its first use")
+
+	assert.Equal(t, SeverityWarning, issues[2].Severity)
+	assert.Equal(t, 10, issues[2].Line)
+}
+
+func TestLintSpec_CircularDependency(t *testing.T) {
+	spec := `services:
+  web:
+    image: nginx
+    depends_on: [api]
+  api:
+    image: myapi
+    depends_on:
+      db:
+        condition: service_started
+  db:
+    image: postgres
+    depends_on: [web]
+`
+	issues := LintSpec(spec, LintOptions{})
+	require.Len(t, issues, 1, "the parser's cycle error is not repeated")
+	assert.Equal(t, IssueCircularDependency, issues[0].Code)
+	assert.Equal(t, "services.api.depends_on", issues[0].Field)
+	assert.Equal(t, "circular depends_on: api -> db -> web -> api", issues[0].Message)
+	assert.Equal(t, 7, issues[0].Line)
+}
+
+func TestLintSpec_ParserErrors(t *testing.T) {
+	issues := LintSpec("services:\n  web: [\n", LintOptions{})
+	require.Len(t, issues, 1)
+	assert.Equal(t, IssueInvalidSpec, issues[0].Code)
+	assert.NotZero(t, issues[0].Line)
+
+	issues = LintSpec("", LintOptions{})
+	assert.Equal(t, []string{IssueInvalidSpec}, issueCodes(issues))
+
+	issues = LintSpec(`services:
+  web:
+    image: nginx
+x-hoster:
+  routing:
+    service: missing
+    port: 80
+`, LintOptions{})
+	require.Len(t, issues, 1)
+	assert.Equal(t, IssueInvalidExtension, issues[0].Code)
+	assert.Equal(t, "x-hoster.routing.service", issues[0].Field)
+	assert.Equal(t, 6, issues[0].Line)
+}
+
+func TestLintSpec_ExternalFiles(t *testing.T) {
+	issues := LintSpec(`include:
+  - other.yaml
+services:
+  web:
+    image: nginx
+  worker:
+    extends:
+      file: base.yaml
+      service: worker
+`, LintOptions{})
+	assert.Equal(t, []string{IssueUnsupportedFeature, IssueUnsupportedFeature}, issueCodes(issues))
+	assert.Equal(t, 1, issues[0].Line)
+	assert.Equal(t, 8, issues[1].Line)
+}
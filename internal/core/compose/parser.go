@@ -2,7 +2,11 @@ package compose
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"net"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -98,10 +102,30 @@ func ParseComposeSpec(yamlContent string) (*ParsedSpec, error) {
 		spec.Volumes = append(spec.Volumes, convertVolume(name, vol))
 	}
 
+	// Parse and apply the x-hoster extension
+	ext, err := ParseExtension(yamlContent)
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil {
+		if err := ValidateExtension(ext, spec, ExtractVariablesFromYAML(yamlContent)); err != nil {
+			return nil, err
+		}
+		applyHealthCheckOverrides(ext, spec)
+		spec.Extension = ext
+	}
+
 	return spec, nil
 }
 
-// loadComposeSpec loads a compose spec using compose-go
+// loadComposeSpec loads a compose spec using compose-go.
+//
+// compose-go reads the YAML itself, the way docker compose does: anchors and
+// aliases are expanded, each alias into its own copy, merge keys (<<) are
+// applied with the mapping's own keys winning, and the !reset and !override
+// tags are honoured. Services may extend other services of the same file;
+// the extended service is still a service of its own. Specs that refer to
+// other files are rejected before loading, so loading never reads the disk.
 func loadComposeSpec(yamlContent string) (*types.Project, error) {
 	// Parse YAML into a map first
 	var dict map[string]interface{}
@@ -114,12 +138,16 @@ func loadComposeSpec(yamlContent string) (*types.Project, error) {
 		return nil, NewParseError("", "invalid YAML syntax", ErrInvalidYAML)
 	}
 
+	if err := checkExternalFiles(dict); err != nil {
+		return nil, err
+	}
+
 	// Load the project
 	project, err := loader.LoadWithContext(context.Background(), types.ConfigDetails{
 		ConfigFiles: []types.ConfigFile{
 			{
-				Content: []byte(yamlContent),
-				Config:  dict,
+				Filename: "compose.yaml",
+				Content:  []byte(escapeSecretPlaceholders(yamlContent)),
 			},
 		},
 	}, func(opts *loader.Options) {
@@ -128,7 +156,7 @@ func loadComposeSpec(yamlContent string) (*types.Project, error) {
 		opts.SkipInterpolation = false // Enable interpolation for proper type parsing
 		// Don't resolve paths since we're in-memory
 		opts.SkipNormalization = true
-		opts.SkipExtends = true // Don't try to load external files
+		opts.SkipInclude = true // Rejected by checkExternalFiles
 	})
 	if err != nil {
 		errStr := err.Error()
@@ -136,6 +164,10 @@ func loadComposeSpec(yamlContent string) (*types.Project, error) {
 		if strings.Contains(errStr, "dependency cycle detected") {
 			return nil, NewParseError("", "circular dependency detected", ErrCircularDependency)
 		}
+		// Check for an extends cycle
+		if strings.Contains(errStr, "Circular reference") {
+			return nil, NewParseError("", "circular extends detected", ErrCircularDependency)
+		}
 		// Check if it's a service validation error
 		if strings.Contains(errStr, "image") && strings.Contains(errStr, "build") {
 			return nil, NewParseError("", "service must have image or build", ErrServiceNoImage)
@@ -146,6 +178,71 @@ func loadComposeSpec(yamlContent string) (*types.Project, error) {
 	return project, nil
 }
 
+// checkExternalFiles rejects specs that include other compose files or
+// extend services defined in them: a template is a single file.
+func checkExternalFiles(dict map[string]interface{}) error {
+	if issues := externalFiles(dict); len(issues) > 0 {
+		return issues[0]
+	}
+	return nil
+}
+
+// externalFiles returns an error for each reference to another compose file.
+func externalFiles(dict map[string]interface{}) []*ParseError {
+	var issues []*ParseError
+	if _, ok := dict["include"]; ok {
+		issues = append(issues, NewParseError("include", "including other compose files is not supported", ErrUnsupportedFeature))
+	}
+	for _, name := range sortedKeys(dict["services"]) {
+		if file := extendsFile(dict["services"], name); file != "" {
+			issues = append(issues, NewParseError("services."+name+".extends.file",
+				fmt.Sprintf("extending a service from another file (%s) is not supported; copy the service into this file", file),
+				ErrUnsupportedFeature))
+		}
+	}
+	return issues
+}
+
+// extendsFile returns the file a service's extends refers to, or "" when it
+// extends a service of the same file.
+func extendsFile(services any, name string) string {
+	svcs, _ := services.(map[string]interface{})
+	svc, _ := svcs[name].(map[string]interface{})
+	ext, _ := svc["extends"].(map[string]interface{})
+	if file, ok := ext["file"]; ok && file != nil {
+		return fmt.Sprint(file)
+	}
+	return ""
+}
+
+// sortedKeys returns the keys of a YAML mapping in order, or nil if v is not
+// a mapping.
+func sortedKeys(v any) []string {
+	m, _ := v.(map[string]interface{})
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Lint returns warnings about a compose spec that parses as YAML but uses
+// features deployments reject, so creators learn of them when they save a
+// template rather than when it is deployed. Invalid YAML has no warnings;
+// ParseComposeSpec reports it.
+func Lint(yamlContent string) []string {
+	var dict map[string]interface{}
+	if err := yaml.Unmarshal([]byte(yamlContent), &dict); err != nil || dict == nil {
+		return []string{}
+	}
+	warnings := []string{}
+	for _, issue := range externalFiles(dict) {
+		warnings = append(warnings, issue.Error())
+	}
+	return warnings
+}
+
 // checkUnsupportedFeatures checks for features we don't support
 func checkUnsupportedFeatures(project *types.Project) error {
 	// Check for secrets
@@ -158,13 +255,6 @@ func checkUnsupportedFeatures(project *types.Project) error {
 		return NewParseError("configs", "configs are not supported", ErrUnsupportedFeature)
 	}
 
-	// Check for extends in services
-	for _, svc := range project.Services {
-		if svc.Extends != nil && svc.Extends.File != "" {
-			return NewParseError("services."+svc.Name+".extends", "extends is not supported", ErrUnsupportedFeature)
-		}
-	}
-
 	return nil
 }
 
@@ -212,6 +302,13 @@ func convertService(svc types.ServiceConfig) (Service, error) {
 		service.Ports = append(service.Ports, port)
 	}
 
+	// Exposed ports; ranges are left out
+	for _, e := range svc.Expose {
+		if port, err := strconv.ParseUint(strings.TrimSuffix(e, "/tcp"), 10, 16); err == nil && port > 0 {
+			service.Expose = append(service.Expose, uint32(port))
+		}
+	}
+
 	// Environment
 	for k, v := range svc.Environment {
 		if v != nil {
@@ -244,19 +341,77 @@ func convertService(svc types.ServiceConfig) (Service, error) {
 		service.Volumes = append(service.Volumes, mount)
 	}
 
-	// Networks
-	for net := range svc.Networks {
-		service.Networks = append(service.Networks, net)
+	// Networks and their aliases
+	for _, name := range slices.Sorted(maps.Keys(svc.Networks)) {
+		service.Networks = append(service.Networks, name)
+		cfg := svc.Networks[name]
+		if cfg == nil {
+			continue
+		}
+		for i, alias := range cfg.Aliases {
+			if !validHostname(alias) {
+				return Service{}, NewParseError(fmt.Sprintf("services.%s.networks.%s.aliases[%d]", svc.Name, name, i),
+					fmt.Sprintf("%q is not a valid hostname", alias), ErrInvalidHostname)
+			}
+			if !slices.Contains(service.Aliases, alias) {
+				service.Aliases = append(service.Aliases, alias)
+			}
+		}
+	}
+
+	// Extra hosts
+	for _, host := range slices.Sorted(maps.Keys(svc.ExtraHosts)) {
+		field := "services." + svc.Name + ".extra_hosts." + host
+		if !validHostname(host) {
+			return Service{}, NewParseError(field, fmt.Sprintf("%q is not a valid hostname", host), ErrInvalidHostname)
+		}
+		for _, ip := range svc.ExtraHosts[host] {
+			if ip != HostGateway && net.ParseIP(strings.Trim(ip, "[]")) == nil {
+				return Service{}, NewParseError(field, fmt.Sprintf("%q is not an IP address or %s", ip, HostGateway), ErrInvalidExtraHost)
+			}
+			service.ExtraHosts = append(service.ExtraHosts, ExtraHost{Host: host, IP: strings.Trim(ip, "[]")})
+		}
 	}
 
 	// DependsOn
-	for dep := range svc.DependsOn {
+	for dep, cfg := range svc.DependsOn {
 		service.DependsOn = append(service.DependsOn, dep)
+
+		condition := DependencyCondition(cfg.Condition)
+		switch condition {
+		case "":
+			condition = ConditionServiceStarted
+		case ConditionServiceStarted, ConditionServiceHealthy, ConditionServiceCompletedSuccessfully:
+		default:
+			return Service{}, NewParseError("services."+svc.Name+".depends_on."+dep+".condition",
+				fmt.Sprintf("unsupported condition %q", cfg.Condition), ErrInvalidDependencyCondition)
+		}
+		if service.DependsOnConditions == nil {
+			service.DependsOnConditions = make(map[string]DependencyCondition)
+		}
+		service.DependsOnConditions[dep] = condition
 	}
 
 	// Restart policy
 	service.Restart = RestartPolicy(svc.Restart)
 
+	// Init, ulimits and device cgroup rules
+	service.Init = svc.Init
+	for name, u := range svc.Ulimits {
+		if u == nil {
+			continue
+		}
+		limit := Ulimit{Soft: int64(u.Soft), Hard: int64(u.Hard)}
+		if u.Single != 0 {
+			limit = Ulimit{Soft: int64(u.Single), Hard: int64(u.Single)}
+		}
+		if service.Ulimits == nil {
+			service.Ulimits = make(map[string]Ulimit)
+		}
+		service.Ulimits[name] = limit
+	}
+	service.DeviceCgroupRules = svc.DeviceCgroupRules
+
 	// Labels
 	for k, v := range svc.Labels {
 		service.Labels[k] = v
@@ -292,11 +447,56 @@ func convertService(svc types.ServiceConfig) (Service, error) {
 		reservations := svc.Deploy.Resources.Reservations
 		service.Resources.CPUReservation = float64(reservations.NanoCPUs)
 		service.Resources.MemoryReservation = int64(reservations.MemoryBytes)
+		service.Resources.GPUs = gpuCount(reservations.Devices)
 	}
 
 	return service, nil
 }
 
+// HostGateway is the extra_hosts address Docker resolves to the node's address.
+const HostGateway = "host-gateway"
+
+// validHostname reports whether s is a hostname: dot-separated labels of
+// letters, digits, '-' and '_', each 1-63 characters and not starting or
+// ending with '-', at most 253 characters in all.
+func validHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// gpuCount returns the GPUs reserved by device requests with the "gpu"
+// capability: their count, the number of device_ids given, or -1 (all)
+// when neither is set, as docker compose does.
+func gpuCount(devices []types.DeviceRequest) int {
+	total := 0
+	for _, d := range devices {
+		if !slices.Contains(d.Capabilities, "gpu") {
+			continue
+		}
+		switch {
+		case d.Count == -1 || (d.Count == 0 && len(d.IDs) == 0):
+			return -1
+		case d.Count > 0:
+			total += int(d.Count)
+		default:
+			total += len(d.IDs)
+		}
+	}
+	return total
+}
+
 // convertNetwork converts a compose-go network to our Network type
 func convertNetwork(name string, net types.NetworkConfig) Network {
 	return Network{
@@ -437,6 +637,17 @@ func CalculateResources(spec *ParsedSpec) domain.Resources {
 // Variable Extraction
 // =============================================================================
 
+// secretPlaceholderRegex matches the start of a ${secret:NAME} placeholder
+// that is not already escaped as $${secret:NAME}.
+var secretPlaceholderRegex = regexp.MustCompile(`(^|[^$])\$\{secret:`)
+
+// escapeSecretPlaceholders escapes ${secret:NAME} placeholders so compose-go
+// keeps them as they are instead of rejecting them; stored secrets are
+// substituted into environment values when the container plan is built.
+func escapeSecretPlaceholders(yamlContent string) string {
+	return secretPlaceholderRegex.ReplaceAllString(yamlContent, "${1}$$$${secret:")
+}
+
 // variablePlaceholderRegex matches ${VAR_NAME} or ${VAR_NAME:-default}
 var variablePlaceholderRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-[^}]*)?\}`)
 
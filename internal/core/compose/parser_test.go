@@ -647,6 +647,26 @@ services:
 	assert.Contains(t, vars, "API_KEY")
 }
 
+func TestParseComposeSpec_SecretPlaceholders(t *testing.T) {
+	yaml := `
+services:
+  app:
+    image: myapp:latest
+    environment:
+      DB_PASSWORD: ${secret:DB_PASSWORD}
+      DATABASE_URL: postgres://app:${secret:DB_PASSWORD}@db/app
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+
+	// Stored secret placeholders survive parsing, to be substituted when the
+	// container plan is built
+	env := spec.Services[0].Environment
+	assert.Equal(t, "${secret:DB_PASSWORD}", env["DB_PASSWORD"])
+	assert.Equal(t, "postgres://app:${secret:DB_PASSWORD}@db/app", env["DATABASE_URL"])
+	assert.NotContains(t, ExtractVariablesFromYAML(yaml), "secret")
+}
+
 func TestExtractVariables(t *testing.T) {
 	// ExtractVariables works on parsed spec - since compose-go interpolates
 	// placeholders, we need ExtractVariablesFromYAML for raw extraction
@@ -852,6 +872,57 @@ services:
 	require.NotNil(t, webService)
 	assert.Contains(t, webService.DependsOn, "db")
 	assert.Contains(t, webService.DependsOn, "redis")
+	assert.Equal(t, ConditionServiceHealthy, webService.DependsOnConditions["db"])
+	assert.Equal(t, ConditionServiceStarted, webService.DependsOnConditions["redis"])
+}
+
+func TestParseComposeSpec_DependsOnCompletedSuccessfully(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: nginx:latest
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+      cache:
+        condition: service_started
+
+  migrate:
+    image: app:latest
+    restart: "no"
+
+  cache:
+    image: redis:7
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+
+	var webService *Service
+	for i := range spec.Services {
+		if spec.Services[i].Name == "web" {
+			webService = &spec.Services[i]
+			break
+		}
+	}
+	require.NotNil(t, webService)
+	assert.Equal(t, ConditionServiceCompletedSuccessfully, webService.DependsOnConditions["migrate"])
+	assert.Equal(t, ConditionServiceStarted, webService.DependsOnConditions["cache"])
+}
+
+func TestParseComposeSpec_DependsOnInvalidCondition(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: nginx:latest
+    depends_on:
+      db:
+        condition: service_ready
+
+  db:
+    image: postgres:15
+`
+	_, err := ParseComposeSpec(yaml)
+	require.Error(t, err)
 }
 
 func TestParseComposeSpec_CircularDependency(t *testing.T) {
@@ -959,6 +1030,37 @@ func TestParseComposeSpec_ResourceLimits(t *testing.T) {
 	assert.Equal(t, int64(512*1024*1024), res.MemoryReservation) // 512M in bytes
 }
 
+func TestParseComposeSpec_GPUReservations(t *testing.T) {
+	spec, err := ParseComposeSpec(`
+services:
+  train:
+    image: pytorch/pytorch
+    deploy:
+      resources:
+        reservations:
+          devices:
+            - driver: nvidia
+              count: 2
+              capabilities: [gpu]
+  infer:
+    image: vllm/vllm-openai
+    deploy:
+      resources:
+        reservations:
+          devices:
+            - capabilities: [gpu]
+  web:
+    image: nginx
+`)
+	require.NoError(t, err)
+
+	gpus := map[string]int{}
+	for _, svc := range spec.Services {
+		gpus[svc.Name] = svc.Resources.GPUs
+	}
+	assert.Equal(t, map[string]int{"train": 2, "infer": -1, "web": 0}, gpus)
+}
+
 func TestCalculateResources_Defaults(t *testing.T) {
 	spec, err := ParseComposeSpec(minimalValidSpec)
 	require.NoError(t, err)
@@ -1194,11 +1296,8 @@ configs:
 }
 
 func TestParseComposeSpec_ExtendsUnsupported(t *testing.T) {
-	// When extends references an external file, compose-go will either:
-	// 1. Try to load the file (if SkipExtends is false)
-	// 2. Skip extends processing (if SkipExtends is true), leaving service without image
-	// Either way, we get an error. We check it's an error (not unsupported feature
-	// because compose-go handles this before our checks run)
+	// Extending a service from another file is rejected before compose-go
+	// would read that file
 	yaml := `
 services:
   app:
@@ -1208,8 +1307,22 @@ services:
 `
 	_, err := ParseComposeSpec(yaml)
 	require.Error(t, err)
-	// Error could be "service must have image" or file not found
-	assert.True(t, errors.Is(err, ErrServiceNoImage) || errors.Is(err, ErrInvalidYAML))
+	assert.ErrorIs(t, err, ErrUnsupportedFeature)
+	assert.Contains(t, err.Error(), "services.app.extends.file")
+	assert.Contains(t, err.Error(), "base.yml")
+}
+
+func TestParseComposeSpec_IncludeUnsupported(t *testing.T) {
+	yaml := `
+include:
+  - other.yml
+services:
+  app:
+    image: nginx:latest
+`
+	_, err := ParseComposeSpec(yaml)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrUnsupportedFeature)
 }
 
 // Replicas should be silently ignored (not an error)
@@ -1280,27 +1393,332 @@ services:
 	assert.ErrorIs(t, err, ErrServiceInvalidPort)
 }
 
-// Test that internal extends (same file) - compose-go may or may not process it
+// =============================================================================
+// Extends and YAML Anchor Tests
+// =============================================================================
+
+func serviceByName(t *testing.T, spec *ParsedSpec, name string) Service {
+	t.Helper()
+	for _, svc := range spec.Services {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	t.Fatalf("service %s not found", name)
+	return Service{}
+}
+
 func TestParseComposeSpec_InternalExtends(t *testing.T) {
 	yaml := `
 services:
   base:
     image: nginx:latest
+    environment:
+      LOG_LEVEL: info
+      MODE: base
+    ports:
+      - "80"
   app:
     extends:
       service: base
+    environment:
+      MODE: app
+    ports:
+      - "443"
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	require.Len(t, spec.Services, 2, "the extended service is still deployed")
+
+	app := serviceByName(t, spec, "app")
+	assert.Equal(t, "nginx:latest", app.Image)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info", "MODE": "app"}, app.Environment)
+	assert.Len(t, app.Ports, 2, "sequences are appended")
+
+	base := serviceByName(t, spec, "base")
+	assert.Equal(t, "base", base.Environment["MODE"], "the extended service is unchanged")
+}
+
+func TestParseComposeSpec_InternalExtendsShorthand(t *testing.T) {
+	yaml := `
+services:
+  base:
+    image: redis:7
+  cache:
+    extends: base
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	assert.Equal(t, "redis:7", serviceByName(t, spec, "cache").Image)
+}
+
+func TestParseComposeSpec_InternalExtendsChain(t *testing.T) {
+	yaml := `
+services:
+  base:
+    image: nginx:latest
+  web:
+    extends: base
+    environment:
+      TIER: web
+  admin:
+    extends: web
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	admin := serviceByName(t, spec, "admin")
+	assert.Equal(t, "nginx:latest", admin.Image)
+	assert.Equal(t, "web", admin.Environment["TIER"])
+}
+
+func TestParseComposeSpec_InternalExtendsMissingService(t *testing.T) {
+	yaml := `
+services:
+  app:
+    extends: base
+`
+	_, err := ParseComposeSpec(yaml)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `service "base" not found`)
+}
+
+func TestParseComposeSpec_InternalExtendsCycle(t *testing.T) {
+	yaml := `
+services:
+  a:
+    image: nginx:latest
+    extends: b
+  b:
+    image: nginx:latest
+    extends: a
+`
+	_, err := ParseComposeSpec(yaml)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrCircularDependency)
+}
+
+func TestParseComposeSpec_Anchors(t *testing.T) {
+	yaml := `
+x-defaults: &defaults
+  image: nginx:latest
+  restart: always
+  environment: &env
+    LOG_LEVEL: info
+
+services:
+  web:
+    <<: *defaults
+  worker:
+    <<: *defaults
+    image: myworker:1.0
+    environment:
+      <<: *env
+      QUEUE: jobs
+  sidecar:
+    image: busybox
+    environment: *env
 `
 	spec, err := ParseComposeSpec(yaml)
-	// Internal extends behavior depends on compose-go version and SkipExtends setting
-	// With SkipExtends=true, the extends is skipped and app gets no image
-	// We expect an error because app has no image after extends is skipped
-	if err != nil {
-		// Error is expected - app has no image because extends was skipped
-		// Our checkUnsupportedFeatures may also catch it
-		assert.Error(t, err)
-	} else {
-		// If compose-go processed the extends, we should have 2 services
-		assert.NotEmpty(t, spec.Services)
+	require.NoError(t, err)
+
+	web := serviceByName(t, spec, "web")
+	assert.Equal(t, "nginx:latest", web.Image)
+	assert.Equal(t, RestartAlways, web.Restart)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info"}, web.Environment)
+
+	worker := serviceByName(t, spec, "worker")
+	assert.Equal(t, "myworker:1.0", worker.Image, "keys of the mapping win over merged keys")
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info", "QUEUE": "jobs"}, worker.Environment)
+
+	sidecar := serviceByName(t, spec, "sidecar")
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "info"}, sidecar.Environment,
+		"each alias is its own copy: worker's merge does not leak into it")
+}
+
+func TestParseComposeSpec_AnchorMergeList(t *testing.T) {
+	yaml := `
+x-image: &image
+  image: postgres:15
+x-env: &env
+  environment:
+    POSTGRES_DB: app
+
+services:
+  db:
+    <<: [*image, *env]
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	db := serviceByName(t, spec, "db")
+	assert.Equal(t, "postgres:15", db.Image)
+	assert.Equal(t, "app", db.Environment["POSTGRES_DB"])
+}
+
+func TestParseComposeSpec_UnknownAnchor(t *testing.T) {
+	yaml := `
+services:
+  app:
+    <<: *missing
+`
+	_, err := ParseComposeSpec(yaml)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidYAML)
+}
+
+func TestParseComposeSpec_AliasesAndExtraHosts(t *testing.T) {
+	yaml := `
+services:
+  db:
+    image: postgres:16
+    networks:
+      backend:
+        aliases:
+          - database
+          - pg.internal
+      frontend:
+        aliases:
+          - database
+    extra_hosts:
+      - "metadata.internal=169.254.169.254"
+      - "host.docker.internal:host-gateway"
+      - "v6.internal=[::1]"
+networks:
+  backend:
+  frontend:
+`
+	spec, err := ParseComposeSpec(yaml)
+	require.NoError(t, err)
+	require.Len(t, spec.Services, 1)
+	svc := spec.Services[0]
+	assert.Equal(t, []string{"backend", "frontend"}, svc.Networks)
+	assert.Equal(t, []string{"database", "pg.internal"}, svc.Aliases)
+	assert.Equal(t, []ExtraHost{
+		{Host: "host.docker.internal", IP: "host-gateway"},
+		{Host: "metadata.internal", IP: "169.254.169.254"},
+		{Host: "v6.internal", IP: "::1"},
+	}, svc.ExtraHosts)
+	assert.Equal(t, "metadata.internal:169.254.169.254", svc.ExtraHosts[1].String())
+}
+
+func TestParseComposeSpec_InvalidHostnames(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		field   string
+		wantErr error
+	}{
+		{
+			name: "alias with space",
+			yaml: `
+services:
+  db:
+    image: postgres:16
+    networks:
+      backend:
+        aliases: ["my db"]
+networks:
+  backend:
+`,
+			field:   "services.db.networks.backend.aliases[0]",
+			wantErr: ErrInvalidHostname,
+		},
+		{
+			name: "alias label starting with hyphen",
+			yaml: `
+services:
+  db:
+    image: postgres:16
+    networks:
+      backend:
+        aliases: ["db.-internal"]
+networks:
+  backend:
+`,
+			field:   "services.db.networks.backend.aliases[0]",
+			wantErr: ErrInvalidHostname,
+		},
+		{
+			name: "extra host name",
+			yaml: `
+services:
+  app:
+    image: app:1
+    extra_hosts: ["bad..host=10.0.0.1"]
+`,
+			field:   "services.app.extra_hosts.bad..host",
+			wantErr: ErrInvalidHostname,
+		},
+		{
+			name: "extra host address",
+			yaml: `
+services:
+  app:
+    image: app:1
+    extra_hosts: ["api.internal=not-an-ip"]
+`,
+			field:   "services.app.extra_hosts.api.internal",
+			wantErr: ErrInvalidExtraHost,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseComposeSpec(tt.yaml)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tt.wantErr)
+			var parseErr *ParseError
+			require.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tt.field, parseErr.Field)
+		})
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	for _, s := range []string{"db", "pg.internal", "my_db", "a-b.c-d", "x1"} {
+		assert.True(t, validHostname(s), s)
+	}
+	long := strings.Repeat("a", 64)
+	for _, s := range []string{"", "-db", "db-", "a..b", "my db", "db!", long, strings.Repeat("a.", 127) + "a"} {
+		assert.False(t, validHostname(s), s)
+	}
+}
+
+// =============================================================================
+// Lint Tests
+// =============================================================================
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{"clean", minimalValidSpec, []string{}},
+		{"invalid YAML", "services: [", []string{}},
+		{"internal extends", `
+services:
+  base: {image: nginx}
+  app: {extends: base}
+`, []string{}},
+		{"external extends", `
+services:
+  web:
+    extends: {file: common.yml, service: web}
+  app:
+    extends: {file: ../base.yml, service: app}
+`, []string{
+			"services.app.extends.file: extending a service from another file (../base.yml) is not supported; copy the service into this file",
+			"services.web.extends.file: extending a service from another file (common.yml) is not supported; copy the service into this file",
+		}},
+		{"include", `
+include: [other.yml]
+services:
+  app: {image: nginx}
+`, []string{"include: including other compose files is not supported"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Lint(tt.yaml))
+		})
 	}
 }
 
@@ -10,6 +10,10 @@ type ParsedSpec struct {
 	Services []Service `json:"services"`
 	Networks []Network `json:"networks,omitempty"`
 	Volumes  []Volume  `json:"volumes,omitempty"`
+
+	// Extension is the x-hoster block, if the spec has one. Its health check
+	// overrides are already applied to Services.
+	Extension *Extension `json:"extension,omitempty"`
 }
 
 // =============================================================================
@@ -24,6 +28,7 @@ type Service struct {
 	Command     []string          `json:"command,omitempty"`
 	Entrypoint  []string          `json:"entrypoint,omitempty"`
 	Ports       []Port            `json:"ports,omitempty"`
+	Expose      []uint32          `json:"expose,omitempty"` // Container ports reachable from other services only
 	Environment map[string]string `json:"environment,omitempty"`
 	Volumes     []VolumeMount     `json:"volumes,omitempty"`
 	Networks    []string          `json:"networks,omitempty"`
@@ -32,8 +37,57 @@ type Service struct {
 	Resources   ServiceResources  `json:"resources"`
 	HealthCheck *HealthCheck      `json:"healthcheck,omitempty"`
 	Labels      map[string]string `json:"labels,omitempty"`
+
+	// DependsOnConditions maps each dependency to the state it must reach
+	// before this service starts (service_started when not specified).
+	DependsOnConditions map[string]DependencyCondition `json:"depends_on_conditions,omitempty"`
+
+	// Init runs an init process as PID 1 that reaps zombies and forwards signals.
+	Init *bool `json:"init,omitempty"`
+	// Ulimits overrides the container's resource limits by name (e.g. "nofile").
+	Ulimits map[string]Ulimit `json:"ulimits,omitempty"`
+	// DeviceCgroupRules are added to the container's device cgroup, e.g. "c 189:* rmw".
+	DeviceCgroupRules []string `json:"device_cgroup_rules,omitempty"`
+
+	// Aliases are extra hostnames other services reach this one by, from the
+	// aliases of its networks. A deployment's services share one network, so
+	// they all apply there.
+	Aliases []string `json:"aliases,omitempty"`
+	// ExtraHosts are added to the container's /etc/hosts.
+	ExtraHosts []ExtraHost `json:"extra_hosts,omitempty"`
+}
+
+// ExtraHost maps a hostname to an IP address, or to "host-gateway" for the
+// node's address.
+type ExtraHost struct {
+	Host string `json:"host"`
+	IP   string `json:"ip"`
+}
+
+// String returns the entry in Docker's "host:ip" form.
+func (h ExtraHost) String() string {
+	return h.Host + ":" + h.IP
 }
 
+// Ulimit is a soft and hard resource limit.
+type Ulimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+// DependencyCondition is the state a dependency must reach before a dependent
+// service is started (compose depends_on.condition).
+type DependencyCondition string
+
+const (
+	// ConditionServiceStarted waits until the dependency's container is started (default).
+	ConditionServiceStarted DependencyCondition = "service_started"
+	// ConditionServiceHealthy waits until the dependency's health check passes.
+	ConditionServiceHealthy DependencyCondition = "service_healthy"
+	// ConditionServiceCompletedSuccessfully waits until the dependency exits with code 0.
+	ConditionServiceCompletedSuccessfully DependencyCondition = "service_completed_successfully"
+)
+
 // BuildConfig represents build configuration (optional).
 type BuildConfig struct {
 	Context    string `json:"context"`
@@ -71,6 +125,7 @@ type ServiceResources struct {
 	CPUReservation    float64 `json:"cpu_reservation"`
 	MemoryLimit       int64   `json:"memory_limit"`       // Bytes
 	MemoryReservation int64   `json:"memory_reservation"` // Bytes
+	GPUs              int     `json:"gpus,omitempty"`     // Reserved GPU devices; -1 for all of the node's
 }
 
 // RestartPolicy represents the restart policy.
@@ -0,0 +1,147 @@
+// Package containerexec provides pure functions for running one-off
+// commands in deployment containers, such as database migrations: the
+// allowlist of commands operators let deployment owners run, and the
+// checks a request must pass before it is sent to the node.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package containerexec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Allowlist
+// =============================================================================
+
+// Wildcard is the allowlist entry that allows every command.
+const Wildcard = "*"
+
+// ErrNotAllowed is returned for commands no allowlist entry matches.
+var ErrNotAllowed = errors.New("command is not allowed")
+
+// Allowlist is the commands that may be run. Each rule is the leading
+// arguments a command must start with: "php artisan" allows
+// "php artisan migrate" but not "php -r ...". Arguments are compared
+// exactly, so "php" does not allow "/usr/bin/php".
+type Allowlist struct {
+	rules [][]string
+	any   bool
+}
+
+// ParseAllowlist parses allowlist entries: space-separated argument
+// prefixes, or "*" for any command. An empty list allows nothing.
+func ParseAllowlist(entries []string) (Allowlist, error) {
+	var a Allowlist
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		switch {
+		case len(fields) == 0:
+			return Allowlist{}, fmt.Errorf("allowlist: empty entry")
+		case len(fields) == 1 && fields[0] == Wildcard:
+			a.any = true
+		default:
+			for _, f := range fields {
+				if strings.Contains(f, Wildcard) {
+					return Allowlist{}, fmt.Errorf("allowlist: %q: %q is only allowed as a whole entry", entry, Wildcard)
+				}
+			}
+			a.rules = append(a.rules, fields)
+		}
+	}
+	return a, nil
+}
+
+// Empty reports whether the allowlist allows nothing.
+func (a Allowlist) Empty() bool {
+	return !a.any && len(a.rules) == 0
+}
+
+// Allows reports whether the command starts with one of the rules.
+func (a Allowlist) Allows(command []string) bool {
+	if len(command) == 0 {
+		return false
+	}
+	if a.any {
+		return true
+	}
+	for _, rule := range a.rules {
+		if len(rule) > len(command) {
+			continue
+		}
+		match := true
+		for i, arg := range rule {
+			if command[i] != arg {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Requests
+// =============================================================================
+
+const (
+	// DefaultTimeout is how long a command may run when the request doesn't
+	// say.
+	DefaultTimeout = time.Minute
+	// MinTimeout is the shortest timeout a request may ask for, and the
+	// shortest limit an operator may configure.
+	MinTimeout = time.Second
+	// MaxStdin bounds the input written to a command.
+	MaxStdin = 1 << 20
+	// MaxArgs bounds the arguments of a command.
+	MaxArgs = 256
+)
+
+// Request is a command to run in one of a deployment's services.
+type Request struct {
+	Service    string
+	Command    []string
+	Stdin      string
+	User       string
+	WorkingDir string
+	Timeout    time.Duration // 0 for the default
+}
+
+// Check validates a request against the allowlist and returns how long
+// the command may run: the requested timeout, or DefaultTimeout capped at
+// maxTimeout when none was requested. A request over maxTimeout is refused
+// rather than cut short. Errors for commands the allowlist refuses wrap
+// ErrNotAllowed.
+func Check(req Request, allow Allowlist, maxTimeout time.Duration) (time.Duration, error) {
+	if req.Service == "" {
+		return 0, fmt.Errorf("service is required")
+	}
+	if len(req.Command) == 0 || req.Command[0] == "" {
+		return 0, fmt.Errorf("command is required")
+	}
+	if len(req.Command) > MaxArgs {
+		return 0, fmt.Errorf("command has %d arguments; at most %d are allowed", len(req.Command), MaxArgs)
+	}
+	if len(req.Stdin) > MaxStdin {
+		return 0, fmt.Errorf("stdin is %d bytes; at most %d are allowed", len(req.Stdin), MaxStdin)
+	}
+	if req.WorkingDir != "" && !strings.HasPrefix(req.WorkingDir, "/") {
+		return 0, fmt.Errorf("working_dir must be an absolute path, got %q", req.WorkingDir)
+	}
+	if !allow.Allows(req.Command) {
+		return 0, fmt.Errorf("%w: %s", ErrNotAllowed, req.Command[0])
+	}
+
+	if req.Timeout == 0 {
+		return min(DefaultTimeout, maxTimeout), nil
+	}
+	if req.Timeout < MinTimeout || req.Timeout > maxTimeout {
+		return 0, fmt.Errorf("timeout must be between %s and %s, got %s", MinTimeout, maxTimeout, req.Timeout)
+	}
+	return req.Timeout, nil
+}
@@ -0,0 +1,87 @@
+package containerexec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	a, err := ParseAllowlist([]string{"php artisan", "  rails   db:migrate ", "psql"})
+	require.NoError(t, err)
+	assert.False(t, a.Empty())
+
+	assert.True(t, a.Allows([]string{"php", "artisan", "migrate"}))
+	assert.True(t, a.Allows([]string{"php", "artisan"}))
+	assert.False(t, a.Allows([]string{"php", "-r", "system('id');"}))
+	assert.True(t, a.Allows([]string{"rails", "db:migrate"}))
+	assert.False(t, a.Allows([]string{"rails", "console"}))
+	assert.True(t, a.Allows([]string{"psql", "-c", "select 1"}))
+	assert.False(t, a.Allows([]string{"/usr/bin/psql"}), "arguments compare exactly")
+	assert.False(t, a.Allows(nil))
+
+	_, err = ParseAllowlist([]string{"php", " "})
+	assert.Error(t, err)
+	_, err = ParseAllowlist([]string{"php *"})
+	assert.Error(t, err)
+}
+
+func TestAllowlistWildcard(t *testing.T) {
+	none, err := ParseAllowlist(nil)
+	require.NoError(t, err)
+	assert.True(t, none.Empty())
+	assert.False(t, none.Allows([]string{"ls"}))
+
+	all, err := ParseAllowlist([]string{"*"})
+	require.NoError(t, err)
+	assert.False(t, all.Empty())
+	assert.True(t, all.Allows([]string{"sh", "-c", "anything"}))
+	assert.False(t, all.Allows(nil))
+}
+
+func TestCheck(t *testing.T) {
+	allow, _ := ParseAllowlist([]string{"php artisan"})
+	req := Request{Service: "app", Command: []string{"php", "artisan", "migrate"}}
+
+	timeout, err := Check(req, allow, 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTimeout, timeout)
+
+	timeout, err = Check(req, allow, 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout, "the default is capped at the limit")
+
+	req.Timeout = 5 * time.Minute
+	timeout, err = Check(req, allow, 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, timeout)
+
+	req.Timeout = time.Hour
+	_, err = Check(req, allow, 10*time.Minute)
+	assert.Error(t, err, "over the limit")
+	req.Timeout = time.Millisecond
+	_, err = Check(req, allow, 10*time.Minute)
+	assert.Error(t, err, "under the minimum")
+	req.Timeout = 0
+
+	bad := req
+	bad.Command = []string{"sh", "-c", "id"}
+	_, err = Check(bad, allow, time.Minute)
+	assert.ErrorIs(t, err, ErrNotAllowed)
+
+	for name, r := range map[string]Request{
+		"no service":   {Command: req.Command},
+		"no command":   {Service: "app"},
+		"empty argv0":  {Service: "app", Command: []string{""}},
+		"long stdin":   {Service: "app", Command: req.Command, Stdin: strings.Repeat("x", MaxStdin+1)},
+		"many args":    {Service: "app", Command: append([]string{"php", "artisan"}, make([]string, MaxArgs)...)},
+		"relative dir": {Service: "app", Command: req.Command, WorkingDir: "app"},
+	} {
+		_, err := Check(r, allow, time.Minute)
+		assert.Error(t, err, name)
+		assert.NotErrorIs(t, err, ErrNotAllowed, name)
+	}
+}
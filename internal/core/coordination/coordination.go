@@ -0,0 +1,97 @@
+// Package coordination provides pure functions for running several control
+// plane replicas against one database: leases that elect a leader for
+// singleton loops and lock per-resource operations, and the timings that
+// keep them alive.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package coordination
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Leases
+// =============================================================================
+
+// DefaultLeaseTTL is how long a lease lasts without renewal. A replica that
+// dies holding a lease blocks others for at most this long.
+const DefaultLeaseTTL = 30 * time.Second
+
+// MinLeaseTTL is the shortest lease accepted. Shorter leases risk expiring
+// between renewals on a busy database.
+const MinLeaseTTL = 3 * time.Second
+
+// LeaderLease is the name of the lease whose holder runs singleton loops.
+const LeaderLease = "leader"
+
+// ErrLeaseHeld is returned when another replica holds a lease.
+var ErrLeaseHeld = errors.New("lease held by another replica")
+
+// Lease is a named, expiring claim by one replica. Token increases each time
+// the lease changes hands, so work started under an older token can be told
+// apart from work under the current one.
+type Lease struct {
+	Name       string
+	Holder     string
+	Token      int64
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Expired reports whether the lease has lapsed at now.
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// HeldBy reports whether holder has the lease at now.
+func (l Lease) HeldBy(holder string, now time.Time) bool {
+	return l.Holder == holder && !l.Expired(now)
+}
+
+// ValidateTTL checks a lease TTL.
+func ValidateTTL(ttl time.Duration) error {
+	if ttl < MinLeaseTTL {
+		return fmt.Errorf("lease TTL must be at least %s", MinLeaseTTL)
+	}
+	return nil
+}
+
+// RenewInterval is how often a held lease is renewed: three times per TTL,
+// so one slow or failed renewal does not lose it.
+func RenewInterval(ttl time.Duration) time.Duration {
+	return ttl / 3
+}
+
+// RenewDeadline is how long a holder may go without renewing a lease before
+// it must stop acting on it: one renewal interval short of the TTL, so work
+// is cancelled while the lease is still the holder's.
+func RenewDeadline(ttl time.Duration) time.Duration {
+	return ttl - RenewInterval(ttl)
+}
+
+// =============================================================================
+// Names
+// =============================================================================
+
+// LockName returns the lease name that serializes operations on one
+// resource, e.g. "lock:deployments:depl_1a2b".
+func LockName(resource, ref string) string {
+	return "lock:" + resource + ":" + ref
+}
+
+// IsLock reports whether a lease name was built by LockName.
+func IsLock(name string) bool {
+	return strings.HasPrefix(name, "lock:")
+}
+
+// HolderID identifies a replica by host and process. Replicas on different
+// hosts, or restarted on the same one, never share an ID.
+func HolderID(hostname string, pid int, startedAt time.Time) string {
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, pid, startedAt.UnixNano())
+}
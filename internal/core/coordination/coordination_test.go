@@ -0,0 +1,56 @@
+package coordination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Lease Tests
+// =============================================================================
+
+func TestLease_HeldBy(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := Lease{Name: LeaderLease, Holder: "a", Token: 1, ExpiresAt: now.Add(time.Second)}
+
+	assert.True(t, l.HeldBy("a", now))
+	assert.False(t, l.HeldBy("b", now))
+	assert.False(t, l.HeldBy("a", now.Add(time.Second)))
+	assert.True(t, l.Expired(now.Add(time.Second)))
+	assert.False(t, l.Expired(now))
+}
+
+func TestValidateTTL(t *testing.T) {
+	assert.NoError(t, ValidateTTL(DefaultLeaseTTL))
+	assert.NoError(t, ValidateTTL(MinLeaseTTL))
+	assert.Error(t, ValidateTTL(time.Second))
+}
+
+func TestRenewInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, RenewInterval(DefaultLeaseTTL))
+}
+
+func TestRenewDeadline(t *testing.T) {
+	assert.Equal(t, 20*time.Second, RenewDeadline(DefaultLeaseTTL))
+	assert.Less(t, RenewDeadline(3*time.Second), 3*time.Second)
+}
+
+// =============================================================================
+// Name Tests
+// =============================================================================
+
+func TestLockName(t *testing.T) {
+	name := LockName("deployments", "depl_1a2b")
+	assert.Equal(t, "lock:deployments:depl_1a2b", name)
+	assert.True(t, IsLock(name))
+	assert.False(t, IsLock(LeaderLease))
+}
+
+func TestHolderID(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	assert.Equal(t, "web-1-42-1700000000000000000", HolderID("web-1", 42, at))
+	assert.Equal(t, "unknown-42-1700000000000000000", HolderID("", 42, at))
+	assert.NotEqual(t, HolderID("web-1", 42, at), HolderID("web-1", 42, at.Add(time.Nanosecond)))
+}
@@ -0,0 +1,268 @@
+// Package costs provides pure functions for creator node costs: the monthly
+// cost of a node, its allocation to the deployments it runs, and margins
+// against the revenue those deployments earn.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package costs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// =============================================================================
+// Months
+// =============================================================================
+
+// MonthLayout is the format of month keys (UTC).
+const MonthLayout = "2006-01"
+
+// HoursPerMonth is the hours in an average month, which providers use to
+// turn hourly prices into monthly ones.
+const HoursPerMonth = 730
+
+// ParseMonth parses a month key, returning the first instant of the month.
+func ParseMonth(s string) (time.Time, error) {
+	t, err := time.Parse(MonthLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q: want YYYY-MM", s)
+	}
+	return t, nil
+}
+
+// MonthBounds returns the start of t's month and the start of the next.
+func MonthBounds(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// =============================================================================
+// Node Costs
+// =============================================================================
+
+// Source says where a node's monthly cost comes from.
+type Source string
+
+const (
+	// SourceManual is a cost the creator entered.
+	SourceManual Source = "manual"
+	// SourceCatalog is a provisioned node's size priced from the provider catalog.
+	SourceCatalog Source = "catalog"
+)
+
+// Entry is a node's monthly cost from a point in time on, until a later
+// entry for the node takes over.
+type Entry struct {
+	MonthlyCents  int64
+	EffectiveFrom time.Time
+}
+
+// CostForMonth returns the entry in effect for the month starting at
+// start: the latest one effective before the month ends. An entry made
+// partway through a month applies to the whole month.
+func CostForMonth(entries []Entry, start time.Time) (Entry, bool) {
+	_, end := MonthBounds(start)
+	var best Entry
+	found := false
+	for _, e := range entries {
+		if !e.EffectiveFrom.Before(end) {
+			continue
+		}
+		if !found || e.EffectiveFrom.After(best.EffectiveFrom) {
+			best, found = e, true
+		}
+	}
+	return best, found
+}
+
+// CatalogMonthlyCents converts a catalog hourly price in dollars to a
+// monthly cost in cents.
+func CatalogMonthlyCents(priceHourly float64) int64 {
+	return int64(math.Round(priceHourly * HoursPerMonth * 100))
+}
+
+// =============================================================================
+// Allocation
+// =============================================================================
+
+// Usage is a deployment's claim on its node's cost for a month.
+type Usage struct {
+	DeploymentID string
+	CPUCores     float64
+	MemoryMB     int64
+	Days         float64 // Days of the month the deployment was on the node
+}
+
+// ActiveDays returns the days of the month [start, end) a deployment
+// running from `from` until `until` covers. A zero until means it is still
+// running; days after now are not counted.
+func ActiveDays(from, until, start, end, now time.Time) float64 {
+	if until.IsZero() || until.After(now) {
+		until = now
+	}
+	if from.Before(start) {
+		from = start
+	}
+	if until.After(end) {
+		until = end
+	}
+	if !until.After(from) {
+		return 0
+	}
+	return until.Sub(from).Hours() / 24
+}
+
+// Allocate splits a node's monthly cost among its deployments by their
+// share of the node's reserved CPU and memory, weighted by the days each
+// ran. CPU and memory count equally; a deployment without reservations
+// gets an equal share by days when none has any. Idle capacity is spread
+// over the deployments, so the shares add up to the cost exactly. Returns
+// nil when no deployment ran during the month.
+func Allocate(costCents int64, usages []Usage) map[string]int64 {
+	var totalCPU, totalMem float64
+	for _, u := range usages {
+		if u.Days > 0 {
+			totalCPU += u.CPUCores
+			totalMem += float64(u.MemoryMB)
+		}
+	}
+
+	weights := make(map[string]float64, len(usages))
+	var total float64
+	for _, u := range usages {
+		if u.Days <= 0 {
+			continue
+		}
+		var share float64
+		switch {
+		case totalCPU > 0 && totalMem > 0:
+			share = (u.CPUCores/totalCPU + float64(u.MemoryMB)/totalMem) / 2
+		case totalCPU > 0:
+			share = u.CPUCores / totalCPU
+		case totalMem > 0:
+			share = float64(u.MemoryMB) / totalMem
+		default:
+			share = 1
+		}
+		weights[u.DeploymentID] += share * u.Days
+		total += share * u.Days
+	}
+	if total == 0 {
+		return nil
+	}
+
+	// Largest remainder: floor every share, then hand out the leftover
+	// cents by the largest fractions
+	type part struct {
+		id   string
+		frac float64
+	}
+	out := make(map[string]int64, len(weights))
+	parts := make([]part, 0, len(weights))
+	var given int64
+	for id, w := range weights {
+		exact := float64(costCents) * w / total
+		cents := int64(math.Floor(exact))
+		out[id] = cents
+		given += cents
+		parts = append(parts, part{id, exact - float64(cents)})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].frac != parts[j].frac {
+			return parts[i].frac > parts[j].frac
+		}
+		return parts[i].id < parts[j].id
+	})
+	for i := 0; given < costCents; i++ {
+		out[parts[i%len(parts)].id]++
+		given++
+	}
+	return out
+}
+
+// =============================================================================
+// Margins
+// =============================================================================
+
+// Line is one deployment's revenue and allocated cost for a month.
+type Line struct {
+	DeploymentID string
+	TemplateID   string
+	CustomerID   string
+	GrossCents   int64 // Billed to the customer
+	NetCents     int64 // The creator's share after the platform fee
+	CostCents    int64 // Node cost allocated to the deployment
+}
+
+// Margin totals lines: the creator's net revenue less the node cost
+// allocated to them.
+type Margin struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Deployments int    `json:"deployments"`
+	GrossCents  int64  `json:"gross_cents"`
+	NetCents    int64  `json:"net_cents"`
+	CostCents   int64  `json:"cost_cents"`
+	MarginCents int64  `json:"margin_cents"`
+	// MarginBps is the margin as a share of net revenue in basis points;
+	// nil without revenue.
+	MarginBps *int64 `json:"margin_bps"`
+}
+
+func (m *Margin) add(l Line) {
+	m.Deployments++
+	m.GrossCents += l.GrossCents
+	m.NetCents += l.NetCents
+	m.CostCents += l.CostCents
+	m.MarginCents = m.NetCents - m.CostCents
+	m.MarginBps = nil
+	if m.NetCents > 0 {
+		bps := m.MarginCents * 10000 / m.NetCents
+		m.MarginBps = &bps
+	}
+}
+
+// Total sums all lines.
+func Total(lines []Line) Margin {
+	var m Margin
+	for _, l := range lines {
+		m.add(l)
+	}
+	return m
+}
+
+// ByTemplate sums lines per template, lowest margin first.
+func ByTemplate(lines []Line) []Margin {
+	return group(lines, func(l Line) string { return l.TemplateID })
+}
+
+// ByCustomer sums lines per customer, lowest margin first.
+func ByCustomer(lines []Line) []Margin {
+	return group(lines, func(l Line) string { return l.CustomerID })
+}
+
+func group(lines []Line, key func(Line) string) []Margin {
+	byKey := make(map[string]*Margin)
+	for _, l := range lines {
+		k := key(l)
+		m, ok := byKey[k]
+		if !ok {
+			m = &Margin{ID: k}
+			byKey[k] = m
+		}
+		m.add(l)
+	}
+	out := make([]Margin, 0, len(byKey))
+	for _, m := range byKey {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].MarginCents != out[j].MarginCents {
+			return out[i].MarginCents < out[j].MarginCents
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
@@ -0,0 +1,190 @@
+package costs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// =============================================================================
+// Month Tests
+// =============================================================================
+
+func TestParseMonth(t *testing.T) {
+	got, err := ParseMonth("2026-02")
+	require.NoError(t, err)
+	assert.Equal(t, date("2026-02-01"), got)
+
+	_, err = ParseMonth("2026-13")
+	assert.Error(t, err)
+	_, err = ParseMonth("February")
+	assert.Error(t, err)
+}
+
+func TestMonthBounds(t *testing.T) {
+	start, end := MonthBounds(time.Date(2026, 12, 15, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, date("2026-12-01"), start)
+	assert.Equal(t, date("2027-01-01"), end)
+}
+
+// =============================================================================
+// Node Cost Tests
+// =============================================================================
+
+func TestCostForMonth(t *testing.T) {
+	entries := []Entry{
+		{MonthlyCents: 2000, EffectiveFrom: date("2026-01-10")},
+		{MonthlyCents: 3000, EffectiveFrom: date("2026-03-20")},
+		{MonthlyCents: 2500, EffectiveFrom: date("2026-02-01")},
+	}
+
+	_, ok := CostForMonth(entries, date("2025-12-01"))
+	assert.False(t, ok, "no entry before the node's first cost")
+
+	got, ok := CostForMonth(entries, date("2026-01-01"))
+	require.True(t, ok)
+	assert.Equal(t, int64(2000), got.MonthlyCents, "an entry made mid-month covers the month")
+
+	got, _ = CostForMonth(entries, date("2026-02-01"))
+	assert.Equal(t, int64(2500), got.MonthlyCents)
+
+	got, _ = CostForMonth(entries, date("2026-03-01"))
+	assert.Equal(t, int64(3000), got.MonthlyCents)
+
+	got, _ = CostForMonth(entries, date("2026-07-01"))
+	assert.Equal(t, int64(3000), got.MonthlyCents, "the latest entry stays in effect")
+}
+
+func TestCatalogMonthlyCents(t *testing.T) {
+	assert.Equal(t, int64(759), CatalogMonthlyCents(0.0104))
+	assert.Equal(t, int64(475), CatalogMonthlyCents(0.0065))
+	assert.Equal(t, int64(0), CatalogMonthlyCents(0))
+}
+
+// =============================================================================
+// Allocation Tests
+// =============================================================================
+
+func TestActiveDays(t *testing.T) {
+	start, end := date("2026-04-01"), date("2026-05-01")
+	now := date("2026-06-01")
+
+	assert.Equal(t, 30.0, ActiveDays(date("2026-01-01"), time.Time{}, start, end, now))
+	assert.Equal(t, 15.0, ActiveDays(date("2026-04-16"), time.Time{}, start, end, now))
+	assert.Equal(t, 10.0, ActiveDays(date("2026-03-01"), date("2026-04-11"), start, end, now))
+	assert.Equal(t, 0.0, ActiveDays(date("2026-05-02"), time.Time{}, start, end, now))
+	assert.Equal(t, 0.0, ActiveDays(date("2026-01-01"), date("2026-03-01"), start, end, now))
+
+	// The current month counts up to now
+	assert.Equal(t, 9.0, ActiveDays(date("2026-01-01"), time.Time{}, start, end, date("2026-04-10")))
+}
+
+func TestAllocate_ByResourceShare(t *testing.T) {
+	got := Allocate(1000, []Usage{
+		{DeploymentID: "a", CPUCores: 3, MemoryMB: 3072, Days: 30},
+		{DeploymentID: "b", CPUCores: 1, MemoryMB: 1024, Days: 30},
+	})
+	assert.Equal(t, map[string]int64{"a": 750, "b": 250}, got)
+}
+
+func TestAllocate_WeightsByDays(t *testing.T) {
+	got := Allocate(900, []Usage{
+		{DeploymentID: "a", CPUCores: 1, MemoryMB: 1024, Days: 30},
+		{DeploymentID: "b", CPUCores: 1, MemoryMB: 1024, Days: 15},
+		{DeploymentID: "c", CPUCores: 4, MemoryMB: 4096, Days: 0},
+	})
+	assert.Equal(t, map[string]int64{"a": 600, "b": 300}, got)
+}
+
+func TestAllocate_MixedResources(t *testing.T) {
+	// a has all the CPU, b all the memory: equal shares
+	got := Allocate(100, []Usage{
+		{DeploymentID: "a", CPUCores: 2, Days: 1},
+		{DeploymentID: "b", MemoryMB: 512, Days: 1},
+	})
+	assert.Equal(t, map[string]int64{"a": 50, "b": 50}, got)
+}
+
+func TestAllocate_NoReservations(t *testing.T) {
+	got := Allocate(100, []Usage{
+		{DeploymentID: "a", Days: 30},
+		{DeploymentID: "b", Days: 30},
+		{DeploymentID: "c", Days: 30},
+	})
+	assert.Equal(t, map[string]int64{"a": 34, "b": 33, "c": 33}, got)
+}
+
+func TestAllocate_SumsToCost(t *testing.T) {
+	usages := []Usage{
+		{DeploymentID: "a", CPUCores: 0.5, MemoryMB: 300, Days: 7.3},
+		{DeploymentID: "b", CPUCores: 1.25, MemoryMB: 900, Days: 30},
+		{DeploymentID: "c", CPUCores: 2, MemoryMB: 128, Days: 12.9},
+	}
+	var sum int64
+	for _, cents := range Allocate(9999, usages) {
+		sum += cents
+	}
+	assert.Equal(t, int64(9999), sum)
+}
+
+func TestAllocate_NothingRan(t *testing.T) {
+	assert.Nil(t, Allocate(1000, nil))
+	assert.Nil(t, Allocate(1000, []Usage{{DeploymentID: "a", CPUCores: 1, Days: 0}}))
+}
+
+// =============================================================================
+// Margin Tests
+// =============================================================================
+
+func TestTotal(t *testing.T) {
+	m := Total([]Line{
+		{GrossCents: 1000, NetCents: 800, CostCents: 300},
+		{GrossCents: 500, NetCents: 400, CostCents: 100},
+	})
+	assert.Equal(t, 2, m.Deployments)
+	assert.Equal(t, int64(1500), m.GrossCents)
+	assert.Equal(t, int64(1200), m.NetCents)
+	assert.Equal(t, int64(400), m.CostCents)
+	assert.Equal(t, int64(800), m.MarginCents)
+	require.NotNil(t, m.MarginBps)
+	assert.Equal(t, int64(6666), *m.MarginBps)
+}
+
+func TestTotal_NoRevenue(t *testing.T) {
+	m := Total([]Line{{CostCents: 300}})
+	assert.Equal(t, int64(-300), m.MarginCents)
+	assert.Nil(t, m.MarginBps)
+}
+
+func TestByTemplateAndCustomer(t *testing.T) {
+	lines := []Line{
+		{DeploymentID: "d1", TemplateID: "tmpl_a", CustomerID: "usr_1", NetCents: 800, CostCents: 100},
+		{DeploymentID: "d2", TemplateID: "tmpl_a", CustomerID: "usr_2", NetCents: 800, CostCents: 900},
+		{DeploymentID: "d3", TemplateID: "tmpl_b", CustomerID: "usr_1", NetCents: 400, CostCents: 200},
+	}
+
+	byTemplate := ByTemplate(lines)
+	require.Len(t, byTemplate, 2)
+	assert.Equal(t, "tmpl_b", byTemplate[0].ID, "lowest margin first")
+	assert.Equal(t, int64(200), byTemplate[0].MarginCents)
+	assert.Equal(t, "tmpl_a", byTemplate[1].ID)
+	assert.Equal(t, 2, byTemplate[1].Deployments)
+	assert.Equal(t, int64(600), byTemplate[1].MarginCents)
+
+	byCustomer := ByCustomer(lines)
+	require.Len(t, byCustomer, 2)
+	assert.Equal(t, "usr_2", byCustomer[0].ID)
+	assert.Equal(t, int64(-100), byCustomer[0].MarginCents)
+	assert.Equal(t, "usr_1", byCustomer[1].ID)
+	assert.Equal(t, int64(900), byCustomer[1].MarginCents)
+}
@@ -0,0 +1,196 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// Upgrade Strategy
+// =============================================================================
+
+// UpgradeStrategy controls how an upgrade replaces a deployment's containers.
+type UpgradeStrategy string
+
+const (
+	// StrategyRecreate removes the old containers and starts the new ones.
+	StrategyRecreate UpgradeStrategy = "recreate"
+	// StrategyCanary first runs the new version of the routed service next to
+	// the old one and sends it a share of the traffic. It is promoted when its
+	// error rate stays low and aborted when it regresses.
+	StrategyCanary UpgradeStrategy = "canary"
+)
+
+// ValidUpgradeStrategy reports whether s is a known strategy. Empty means
+// recreate, matching the column default.
+func ValidUpgradeStrategy(s UpgradeStrategy) bool {
+	switch s {
+	case "", StrategyRecreate, StrategyCanary:
+		return true
+	}
+	return false
+}
+
+// =============================================================================
+// Canary Policy
+// =============================================================================
+
+// CanaryPolicy configures a canary upgrade. Zero fields take the defaults.
+type CanaryPolicy struct {
+	// Percent is the share of requests sent to the canary, 1-99.
+	Percent int `json:"percent,omitempty"`
+	// Duration is how long the canary is observed before promotion, e.g. "15m".
+	Duration string `json:"duration,omitempty"`
+	// MaxErrorRate is the highest acceptable fraction of canary responses
+	// that are server errors (5xx), 0-1.
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	// MinRequests is how many canary requests are needed before the canary
+	// can be aborted early. At the end of the observation period it is
+	// judged on whatever traffic it received.
+	MinRequests int64 `json:"min_requests,omitempty"`
+}
+
+// Canary policy defaults and bounds.
+const (
+	DefaultCanaryPercent      = 10
+	DefaultCanaryDuration     = 15 * time.Minute
+	DefaultCanaryMaxErrorRate = 0.05
+	DefaultCanaryMinRequests  = 20
+
+	MinCanaryDuration = time.Minute
+	MaxCanaryDuration = 24 * time.Hour
+)
+
+// WithDefaults fills zero fields with the defaults.
+func (p CanaryPolicy) WithDefaults() CanaryPolicy {
+	if p.Percent == 0 {
+		p.Percent = DefaultCanaryPercent
+	}
+	if p.Duration == "" {
+		p.Duration = DefaultCanaryDuration.String()
+	}
+	if p.MaxErrorRate == 0 {
+		p.MaxErrorRate = DefaultCanaryMaxErrorRate
+	}
+	if p.MinRequests == 0 {
+		p.MinRequests = DefaultCanaryMinRequests
+	}
+	return p
+}
+
+// ObservationPeriod returns the policy's duration, or the default when it
+// is unset or invalid.
+func (p CanaryPolicy) ObservationPeriod() time.Duration {
+	d, err := time.ParseDuration(p.Duration)
+	if err != nil || d <= 0 {
+		return DefaultCanaryDuration
+	}
+	return d
+}
+
+// ValidateUpgradeStrategy checks a strategy and its canary policy.
+func ValidateUpgradeStrategy(s UpgradeStrategy, p CanaryPolicy) error {
+	if !ValidUpgradeStrategy(s) {
+		return fmt.Errorf("upgrade_strategy must be one of recreate, canary")
+	}
+	if p.Percent < 0 || p.Percent > 99 {
+		return fmt.Errorf("canary_policy.percent must be between 1 and 99")
+	}
+	if p.Duration != "" {
+		d, err := time.ParseDuration(p.Duration)
+		if err != nil {
+			return fmt.Errorf("canary_policy.duration: invalid duration %q", p.Duration)
+		}
+		if d < MinCanaryDuration || d > MaxCanaryDuration {
+			return fmt.Errorf("canary_policy.duration must be between %s and %s", MinCanaryDuration, MaxCanaryDuration)
+		}
+	}
+	if p.MaxErrorRate < 0 || p.MaxErrorRate > 1 {
+		return fmt.Errorf("canary_policy.max_error_rate must be between 0 and 1")
+	}
+	if p.MinRequests < 0 {
+		return fmt.Errorf("canary_policy.min_requests must not be negative")
+	}
+	return nil
+}
+
+// =============================================================================
+// Canary Evaluation (Pure Functions)
+// =============================================================================
+
+// TrafficStats counts the responses served by one container set.
+type TrafficStats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// Record counts a response with the given HTTP status. Server errors (5xx)
+// count as errors.
+func (s *TrafficStats) Record(status int) {
+	s.Requests++
+	if status >= 500 {
+		s.Errors++
+	}
+}
+
+// ErrorRate returns the fraction of responses that were errors.
+func (s TrafficStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// CanaryStats compares the stable and canary container sets.
+type CanaryStats struct {
+	Stable TrafficStats `json:"stable"`
+	Canary TrafficStats `json:"canary"`
+}
+
+// Add returns the sum of two sets of counts.
+func (s CanaryStats) Add(o CanaryStats) CanaryStats {
+	s.Stable.Requests += o.Stable.Requests
+	s.Stable.Errors += o.Stable.Errors
+	s.Canary.Requests += o.Canary.Requests
+	s.Canary.Errors += o.Canary.Errors
+	return s
+}
+
+// CanaryAction is what to do with a running canary.
+type CanaryAction string
+
+const (
+	CanaryContinue CanaryAction = "continue"
+	CanaryPromote  CanaryAction = "promote"
+	CanaryAbort    CanaryAction = "abort"
+)
+
+// CanaryDecision is the result of evaluating a canary.
+type CanaryDecision struct {
+	Action CanaryAction
+	Reason string
+}
+
+// EvaluateCanary decides whether a canary started at startedAt is promoted,
+// aborted or kept running at now. The canary regresses when its error rate
+// is above the policy's maximum and above the stable set's. A regression
+// aborts it as soon as MinRequests canary requests have been seen, and at the
+// end of the observation period regardless. Otherwise it is promoted once the
+// observation period has passed.
+func EvaluateCanary(p CanaryPolicy, stats CanaryStats, startedAt, now time.Time) CanaryDecision {
+	p = p.WithDefaults()
+	canaryRate, stableRate := stats.Canary.ErrorRate(), stats.Stable.ErrorRate()
+	regressed := stats.Canary.Requests > 0 && canaryRate > p.MaxErrorRate && canaryRate > stableRate
+	done := now.Sub(startedAt) >= p.ObservationPeriod()
+
+	if regressed && (done || stats.Canary.Requests >= p.MinRequests) {
+		return CanaryDecision{Action: CanaryAbort, Reason: fmt.Sprintf(
+			"canary error rate %.1f%% over %d requests exceeds %.1f%% (stable %.1f%%)",
+			canaryRate*100, stats.Canary.Requests, p.MaxErrorRate*100, stableRate*100)}
+	}
+	if !done {
+		return CanaryDecision{Action: CanaryContinue}
+	}
+	return CanaryDecision{Action: CanaryPromote, Reason: fmt.Sprintf(
+		"canary error rate %.1f%% over %d requests", canaryRate*100, stats.Canary.Requests)}
+}
@@ -0,0 +1,103 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Canary Policy Tests
+// =============================================================================
+
+func TestCanaryPolicy_WithDefaults(t *testing.T) {
+	p := CanaryPolicy{}.WithDefaults()
+	assert.Equal(t, CanaryPolicy{Percent: 10, Duration: "15m0s", MaxErrorRate: 0.05, MinRequests: 20}, p)
+	assert.Equal(t, 15*time.Minute, p.ObservationPeriod())
+
+	p = CanaryPolicy{Percent: 25, Duration: "1h"}.WithDefaults()
+	assert.Equal(t, 25, p.Percent)
+	assert.Equal(t, time.Hour, p.ObservationPeriod())
+}
+
+func TestValidateUpgradeStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy UpgradeStrategy
+		policy   CanaryPolicy
+		wantErr  string
+	}{
+		{"default", "", CanaryPolicy{}, ""},
+		{"recreate", StrategyRecreate, CanaryPolicy{}, ""},
+		{"canary", StrategyCanary, CanaryPolicy{Percent: 20, Duration: "30m", MaxErrorRate: 0.01, MinRequests: 100}, ""},
+		{"unknown strategy", "rolling", CanaryPolicy{}, "upgrade_strategy"},
+		{"percent too high", StrategyCanary, CanaryPolicy{Percent: 100}, "percent"},
+		{"negative percent", StrategyCanary, CanaryPolicy{Percent: -1}, "percent"},
+		{"bad duration", StrategyCanary, CanaryPolicy{Duration: "soon"}, "invalid duration"},
+		{"short duration", StrategyCanary, CanaryPolicy{Duration: "30s"}, "between 1m0s and 24h0m0s"},
+		{"error rate", StrategyCanary, CanaryPolicy{MaxErrorRate: 1.5}, "max_error_rate"},
+		{"min requests", StrategyCanary, CanaryPolicy{MinRequests: -5}, "min_requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUpgradeStrategy(tt.strategy, tt.policy)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+// =============================================================================
+// Canary Evaluation Tests
+// =============================================================================
+
+func TestTrafficStats_Record(t *testing.T) {
+	var s TrafficStats
+	assert.Equal(t, 0.0, s.ErrorRate())
+	for _, status := range []int{200, 404, 500, 502} {
+		s.Record(status)
+	}
+	assert.Equal(t, TrafficStats{Requests: 4, Errors: 2}, s)
+	assert.Equal(t, 0.5, s.ErrorRate())
+}
+
+func TestCanaryStats_Add(t *testing.T) {
+	a := CanaryStats{Stable: TrafficStats{Requests: 10, Errors: 1}, Canary: TrafficStats{Requests: 2}}
+	b := CanaryStats{Stable: TrafficStats{Requests: 5}, Canary: TrafficStats{Requests: 3, Errors: 3}}
+	assert.Equal(t, CanaryStats{Stable: TrafficStats{Requests: 15, Errors: 1}, Canary: TrafficStats{Requests: 5, Errors: 3}}, a.Add(b))
+}
+
+func TestEvaluateCanary(t *testing.T) {
+	start := time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC)
+	during, after := start.Add(5*time.Minute), start.Add(15*time.Minute)
+	policy := CanaryPolicy{}
+
+	tests := []struct {
+		name  string
+		stats CanaryStats
+		now   time.Time
+		want  CanaryAction
+	}{
+		{"no traffic yet", CanaryStats{}, during, CanaryContinue},
+		{"healthy during", CanaryStats{Canary: TrafficStats{Requests: 100, Errors: 1}}, during, CanaryContinue},
+		{"regression", CanaryStats{Stable: TrafficStats{Requests: 900, Errors: 9}, Canary: TrafficStats{Requests: 100, Errors: 20}}, during, CanaryAbort},
+		{"too few requests to abort early", CanaryStats{Canary: TrafficStats{Requests: 5, Errors: 5}}, during, CanaryContinue},
+		{"few failing requests at the end", CanaryStats{Canary: TrafficStats{Requests: 5, Errors: 5}}, after, CanaryAbort},
+		{"no worse than stable", CanaryStats{Stable: TrafficStats{Requests: 900, Errors: 180}, Canary: TrafficStats{Requests: 100, Errors: 20}}, after, CanaryPromote},
+		{"healthy at the end", CanaryStats{Stable: TrafficStats{Requests: 900}, Canary: TrafficStats{Requests: 100, Errors: 2}}, after, CanaryPromote},
+		{"idle at the end", CanaryStats{}, after, CanaryPromote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateCanary(policy, tt.stats, start, tt.now)
+			assert.Equal(t, tt.want, d.Action)
+		})
+	}
+
+	d := EvaluateCanary(policy, CanaryStats{Stable: TrafficStats{Requests: 900, Errors: 9}, Canary: TrafficStats{Requests: 100, Errors: 20}}, start, during)
+	assert.Equal(t, "canary error rate 20.0% over 100 requests exceeds 5.0% (stable 1.0%)", d.Reason)
+}
@@ -0,0 +1,128 @@
+package deployment
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Config File Rendering
+// =============================================================================
+
+// ConfigData is what a config file template is executed with.
+//
+// Example:
+//
+//	server_name {{ .Domain }};
+//	proxy_pass http://{{ .ServiceHost "app" }}:{{ or .Variables.APP_PORT "8080" }};
+type ConfigData struct {
+	DeploymentID   string
+	DeploymentName string
+	Domain         string            // Hostname the deployment is reached at, "" before one is assigned
+	Variables      map[string]string // The deployment's variables; missing ones are ""
+
+	services map[string]bool
+}
+
+// NewConfigData returns the data for rendering a deployment's config files.
+// services are the services of its compose spec.
+func NewConfigData(d *domain.Deployment, services []compose.Service) ConfigData {
+	data := ConfigData{
+		DeploymentID:   d.ReferenceID,
+		DeploymentName: d.Name,
+		Domain:         ConfigDomain(d.Domains),
+		Variables:      d.Variables,
+		services:       make(map[string]bool, len(services)),
+	}
+	if data.Variables == nil {
+		data.Variables = map[string]string{}
+	}
+	for _, svc := range services {
+		data.services[svc.Name] = true
+	}
+	return data
+}
+
+// ServiceHost returns the hostname other containers of the deployment reach
+// a service at. It fails for a service the compose spec doesn't have.
+func (c ConfigData) ServiceHost(service string) (string, error) {
+	if !c.services[service] {
+		return "", fmt.Errorf("unknown service %q", service)
+	}
+	return service, nil
+}
+
+// ConfigDomain picks the hostname config files see as .Domain: the first
+// verified custom domain, else the auto domain.
+func ConfigDomain(domains []domain.Domain) string {
+	auto := ""
+	for _, d := range domains {
+		switch {
+		case d.Type == domain.DomainTypeCustom && d.VerificationStatus == domain.DomainVerificationVerified:
+			return d.Hostname
+		case d.Type == domain.DomainTypeAuto && auto == "":
+			auto = d.Hostname
+		}
+	}
+	return auto
+}
+
+// parseConfigTemplate parses a config file's content as a Go text/template.
+func parseConfigTemplate(cf domain.ConfigFile) (*template.Template, error) {
+	tmpl, err := template.New(cf.Name).Option("missingkey=zero").Parse(cf.Content)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", configFileLabel(cf), err)
+	}
+	return tmpl, nil
+}
+
+// ValidateConfigTemplates checks that every config file marked as a
+// template parses. Execution errors, such as an unknown service, can only
+// be found at deploy time.
+func ValidateConfigTemplates(files []domain.ConfigFile) error {
+	for _, cf := range files {
+		if !cf.Template {
+			continue
+		}
+		if _, err := parseConfigTemplate(cf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderConfigFiles returns the files with every one marked as a template
+// rendered with data. Other files are returned as they are.
+func RenderConfigFiles(files []domain.ConfigFile, data ConfigData) ([]domain.ConfigFile, error) {
+	if len(files) == 0 {
+		return files, nil
+	}
+	rendered := make([]domain.ConfigFile, len(files))
+	for i, cf := range files {
+		rendered[i] = cf
+		if !cf.Template {
+			continue
+		}
+		tmpl, err := parseConfigTemplate(cf)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("render config file %s: %w", configFileLabel(cf), err)
+		}
+		rendered[i].Content = b.String()
+	}
+	return rendered, nil
+}
+
+func configFileLabel(cf domain.ConfigFile) string {
+	if cf.Name != "" {
+		return cf.Name
+	}
+	return cf.Path
+}
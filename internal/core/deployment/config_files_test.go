@@ -0,0 +1,74 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Config File Rendering Tests
+// =============================================================================
+
+func configTestDeployment() *domain.Deployment {
+	return &domain.Deployment{
+		ReferenceID: "depl_abc",
+		Name:        "blog",
+		Variables:   map[string]string{"DB_USER": "wp"},
+		Domains: []domain.Domain{
+			{Hostname: "blog.apps.example.com", Type: domain.DomainTypeAuto},
+			{Hostname: "blog.example.org", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationPending},
+		},
+	}
+}
+
+func TestRenderConfigFiles(t *testing.T) {
+	services := []compose.Service{{Name: "web"}, {Name: "db"}}
+	data := NewConfigData(configTestDeployment(), services)
+	files := []domain.ConfigFile{
+		{Name: "app.conf", Path: "/etc/app.conf", Template: true, Content: `id={{ .DeploymentID }} name={{ .DeploymentName }}
+host={{ .Domain }}
+db={{ .ServiceHost "db" }} user={{ .Variables.DB_USER }} port={{ or .Variables.DB_PORT "3306" }}`},
+		{Name: "static.tmpl", Path: "/etc/static.tmpl", Content: "{{ .Labels }}"},
+	}
+
+	rendered, err := RenderConfigFiles(files, data)
+	require.NoError(t, err)
+	require.Len(t, rendered, 2)
+	assert.Equal(t, "id=depl_abc name=blog\nhost=blog.apps.example.com\ndb=db user=wp port=3306", rendered[0].Content)
+	assert.Equal(t, "{{ .Labels }}", rendered[1].Content, "files not marked as templates are left alone")
+	assert.Contains(t, files[0].Content, "{{", "input is not modified")
+}
+
+func TestRenderConfigFiles_Errors(t *testing.T) {
+	data := NewConfigData(configTestDeployment(), []compose.Service{{Name: "web"}})
+
+	_, err := RenderConfigFiles([]domain.ConfigFile{{Name: "a.conf", Template: true, Content: `{{ .ServiceHost "db" }}`}}, data)
+	assert.ErrorContains(t, err, `unknown service "db"`)
+
+	_, err = RenderConfigFiles([]domain.ConfigFile{{Name: "b.conf", Template: true, Content: `{{ .Nope }}`}}, data)
+	assert.ErrorContains(t, err, "b.conf")
+}
+
+func TestValidateConfigTemplates(t *testing.T) {
+	assert.NoError(t, ValidateConfigTemplates(nil))
+	assert.NoError(t, ValidateConfigTemplates([]domain.ConfigFile{
+		{Name: "ok.conf", Template: true, Content: `{{ if .Domain }}{{ .Domain }}{{ end }}`},
+		{Name: "static.conf", Content: `{{ unclosed`},
+	}))
+	assert.ErrorContains(t, ValidateConfigTemplates([]domain.ConfigFile{
+		{Path: "/etc/bad.conf", Template: true, Content: `{{ unclosed`},
+	}), "/etc/bad.conf")
+}
+
+func TestConfigDomain(t *testing.T) {
+	assert.Equal(t, "", ConfigDomain(nil))
+	assert.Equal(t, "blog.apps.example.com", ConfigDomain(configTestDeployment().Domains))
+	assert.Equal(t, "blog.example.org", ConfigDomain([]domain.Domain{
+		{Hostname: "blog.apps.example.com", Type: domain.DomainTypeAuto},
+		{Hostname: "blog.example.org", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationVerified},
+	}))
+}
@@ -23,6 +23,8 @@ import (
 //   - Parses health check durations
 //   - Maps restart policy to Docker format
 //   - Copies and merges labels
+//   - Adds the service name and its aliases as network aliases
+//   - Formats extra hosts for Docker
 //
 // Example:
 //
@@ -51,7 +53,13 @@ func BuildContainerPlan(params BuildContainerPlanParams) ContainerPlan {
 			LabelTemplate:   params.TemplateID,
 			LabelService:    params.ServiceName,
 		},
-		Networks: []string{params.NetworkName},
+		Networks:       []string{params.NetworkName},
+		NetworkAliases: map[string][]string{params.NetworkName: append([]string{params.ServiceName}, svc.Aliases...)},
+	}
+
+	// Extra hosts
+	for _, h := range svc.ExtraHosts {
+		plan.ExtraHosts = append(plan.ExtraHosts, h.String())
 	}
 
 	// Merge environment: service env + deployment variables
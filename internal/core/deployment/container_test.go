@@ -39,6 +39,26 @@ func TestBuildContainerPlan_BasicService(t *testing.T) {
 	assert.Equal(t, "web", plan.Labels[LabelService])
 }
 
+func TestBuildContainerPlan_AliasesAndExtraHosts(t *testing.T) {
+	service := compose.Service{
+		Name:       "db",
+		Image:      "postgres:16",
+		Aliases:    []string{"database", "pg.internal"},
+		ExtraHosts: []compose.ExtraHost{{Host: "metadata.internal", IP: "169.254.169.254"}, {Host: "gw", IP: compose.HostGateway}},
+	}
+	params := BuildContainerPlanParams{
+		DeploymentID: "deploy-123",
+		ServiceName:  "db",
+		Service:      service,
+		NetworkName:  "hoster_deploy-123",
+	}
+
+	plan := BuildContainerPlan(params)
+
+	assert.Equal(t, map[string][]string{"hoster_deploy-123": {"db", "database", "pg.internal"}}, plan.NetworkAliases)
+	assert.Equal(t, []string{"metadata.internal:169.254.169.254", "gw:host-gateway"}, plan.ExtraHosts)
+}
+
 func TestBuildContainerPlan_WithEnvironment(t *testing.T) {
 	service := compose.Service{
 		Name:  "app",
@@ -0,0 +1,122 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+)
+
+// =============================================================================
+// Dependency Conditions
+// =============================================================================
+
+// DependencyState is the observed state of a dependency's container.
+type DependencyState struct {
+	Status   string // "created", "running", "restarting", "exited", "dead", ...
+	Health   string // "healthy", "unhealthy", "starting", "" (no health check)
+	ExitCode int
+}
+
+// DependencyResult is the outcome of checking a dependency condition.
+type DependencyResult string
+
+const (
+	// DependencyWaiting means the condition may still be met; check again later.
+	DependencyWaiting DependencyResult = "waiting"
+	// DependencySatisfied means the dependent service may start.
+	DependencySatisfied DependencyResult = "satisfied"
+	// DependencyFailed means the condition can no longer be met.
+	DependencyFailed DependencyResult = "failed"
+)
+
+// EvaluateDependency checks whether a dependency's container meets the
+// condition. For DependencyFailed the returned reason explains why.
+func EvaluateDependency(condition compose.DependencyCondition, state DependencyState) (DependencyResult, string) {
+	if state.Status == "dead" {
+		return DependencyFailed, "container is dead"
+	}
+
+	switch condition {
+	case compose.ConditionServiceHealthy:
+		switch {
+		case state.Health == "healthy":
+			return DependencySatisfied, ""
+		case state.Health == "unhealthy":
+			return DependencyFailed, "container is unhealthy"
+		case state.Status == "exited":
+			return DependencyFailed, fmt.Sprintf("container exited with code %d before becoming healthy", state.ExitCode)
+		case state.Health == "" && state.Status == "running":
+			return DependencyFailed, "container has no health check configured"
+		}
+		return DependencyWaiting, ""
+
+	case compose.ConditionServiceCompletedSuccessfully:
+		if state.Status != "exited" {
+			return DependencyWaiting, ""
+		}
+		if state.ExitCode != 0 {
+			return DependencyFailed, fmt.Sprintf("container exited with code %d", state.ExitCode)
+		}
+		return DependencySatisfied, ""
+
+	default: // service_started
+		if state.Status == "running" || state.Status == "exited" {
+			return DependencySatisfied, ""
+		}
+		return DependencyWaiting, ""
+	}
+}
+
+// Docker's health check defaults, used when a compose health check leaves them unset.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+const (
+	// DefaultDependencyTimeout is the minimum time to wait for a dependency condition.
+	DefaultDependencyTimeout = 5 * time.Minute
+	// MaxDependencyTimeout caps the wait regardless of the health check settings.
+	MaxDependencyTimeout = 30 * time.Minute
+)
+
+// DependencyTimeout returns how long to wait for a dependency's condition.
+// For service_healthy it allows the dependency's full health check budget
+// (start period plus every retry), never less than DefaultDependencyTimeout.
+func DependencyTimeout(condition compose.DependencyCondition, dep compose.Service) time.Duration {
+	timeout := DefaultDependencyTimeout
+	if condition != compose.ConditionServiceHealthy || dep.HealthCheck == nil {
+		return timeout
+	}
+
+	hc := dep.HealthCheck
+	interval := parseDurationOr(hc.Interval, defaultHealthInterval)
+	probe := parseDurationOr(hc.Timeout, defaultHealthTimeout)
+	startPeriod := parseDurationOr(hc.StartPeriod, 0)
+	retries := hc.Retries
+	if retries <= 0 {
+		retries = defaultHealthRetries
+	}
+
+	budget := startPeriod + time.Duration(retries+1)*(interval+probe)
+	if budget > timeout {
+		timeout = budget
+	}
+	if timeout > MaxDependencyTimeout {
+		timeout = MaxDependencyTimeout
+	}
+	return timeout
+}
+
+func parseDurationOr(s string, fallback time.Duration) time.Duration {
+	if s == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
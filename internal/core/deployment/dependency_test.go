@@ -0,0 +1,79 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateDependency_Started(t *testing.T) {
+	cond := compose.ConditionServiceStarted
+
+	r, _ := EvaluateDependency(cond, DependencyState{Status: "created"})
+	assert.Equal(t, DependencyWaiting, r)
+	r, _ = EvaluateDependency(cond, DependencyState{Status: "running"})
+	assert.Equal(t, DependencySatisfied, r)
+	r, _ = EvaluateDependency(cond, DependencyState{Status: "exited", ExitCode: 1})
+	assert.Equal(t, DependencySatisfied, r)
+	r, _ = EvaluateDependency(cond, DependencyState{Status: "dead"})
+	assert.Equal(t, DependencyFailed, r)
+}
+
+func TestEvaluateDependency_Healthy(t *testing.T) {
+	cond := compose.ConditionServiceHealthy
+
+	tests := []struct {
+		name   string
+		state  DependencyState
+		want   DependencyResult
+		reason string
+	}{
+		{"starting", DependencyState{Status: "running", Health: "starting"}, DependencyWaiting, ""},
+		{"created", DependencyState{Status: "created"}, DependencyWaiting, ""},
+		{"healthy", DependencyState{Status: "running", Health: "healthy"}, DependencySatisfied, ""},
+		{"unhealthy", DependencyState{Status: "running", Health: "unhealthy"}, DependencyFailed, "container is unhealthy"},
+		{"no health check", DependencyState{Status: "running"}, DependencyFailed, "container has no health check configured"},
+		{"exited", DependencyState{Status: "exited", ExitCode: 2}, DependencyFailed, "container exited with code 2 before becoming healthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := EvaluateDependency(cond, tt.state)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestEvaluateDependency_CompletedSuccessfully(t *testing.T) {
+	cond := compose.ConditionServiceCompletedSuccessfully
+
+	r, _ := EvaluateDependency(cond, DependencyState{Status: "running"})
+	assert.Equal(t, DependencyWaiting, r)
+	r, _ = EvaluateDependency(cond, DependencyState{Status: "exited", ExitCode: 0})
+	assert.Equal(t, DependencySatisfied, r)
+	r, reason := EvaluateDependency(cond, DependencyState{Status: "exited", ExitCode: 3})
+	assert.Equal(t, DependencyFailed, r)
+	assert.Equal(t, "container exited with code 3", reason)
+}
+
+func TestDependencyTimeout(t *testing.T) {
+	db := compose.Service{Name: "db"}
+	assert.Equal(t, DefaultDependencyTimeout, DependencyTimeout(compose.ConditionServiceHealthy, db))
+
+	// Short health check: budget below the default
+	db.HealthCheck = &compose.HealthCheck{Interval: "5s", Timeout: "3s", Retries: 5}
+	assert.Equal(t, DefaultDependencyTimeout, DependencyTimeout(compose.ConditionServiceHealthy, db))
+
+	// Long start period: 10m + 4 * (30s + 30s)
+	db.HealthCheck = &compose.HealthCheck{StartPeriod: "10m"}
+	assert.Equal(t, 14*time.Minute, DependencyTimeout(compose.ConditionServiceHealthy, db))
+
+	// Capped
+	db.HealthCheck = &compose.HealthCheck{StartPeriod: "2h"}
+	assert.Equal(t, MaxDependencyTimeout, DependencyTimeout(compose.ConditionServiceHealthy, db))
+
+	// Other conditions ignore the health check
+	assert.Equal(t, DefaultDependencyTimeout, DependencyTimeout(compose.ConditionServiceCompletedSuccessfully, db))
+}
@@ -7,10 +7,15 @@
 // # Functions
 //
 //   - Naming: Generate consistent resource names (NetworkName, VolumeName, ContainerName)
-//   - Ordering: Sort services by dependencies (TopologicalSort)
+//   - Ordering: Sort services by dependencies (TopologicalSort) and group them
+//     into levels that can start concurrently (StartLevels, BootWeights)
 //   - Variables: Substitute environment variable placeholders (SubstituteVariables)
+//     and check values against a template version's definitions (CheckVariables)
 //   - Ports: Convert port bindings to domain types (ConvertPorts)
 //   - Container: Build container plans from compose services (BuildContainerPlan)
+//   - Upgrade: Apply upgrade policies and maintenance windows (DecideUpgrade)
+//   - Dependencies: Check depends_on conditions before starting a service (EvaluateDependency)
+//   - Images: Pin image tags to digests and detect tag drift (RepoDigest, DetectImageDrift)
 //
 // # Usage
 //
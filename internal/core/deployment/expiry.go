@@ -0,0 +1,146 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// Trial Types
+// =============================================================================
+
+// TrialSource is what made a deployment a trial.
+type TrialSource string
+
+const (
+	// TrialFromPlan means the customer's plan is a trial plan.
+	TrialFromPlan TrialSource = "plan"
+	// TrialFromTemplate means the template offers a trial.
+	TrialFromTemplate TrialSource = "template"
+	// TrialFromPlayground means the deployment is a marketplace playground
+	// session.
+	TrialFromPlayground TrialSource = "playground"
+)
+
+// TrialConfig is a template's trial offer: deployments of it expire after
+// Days unless converted, and are deleted GraceDays after that
+// (DefaultTrialGraceDays when 0).
+type TrialConfig struct {
+	Days      int `json:"days"`
+	GraceDays int `json:"grace_days,omitempty"`
+}
+
+const (
+	// MaxTrialDays bounds a trial's length.
+	MaxTrialDays = 90
+	// MaxTrialGraceDays bounds how long an expired deployment is kept.
+	MaxTrialGraceDays = 30
+	// DefaultTrialGraceDays is how long an expired deployment is kept
+	// stopped before it is deleted.
+	DefaultTrialGraceDays = 7
+)
+
+// ValidateTrialConfig checks a template's trial offer.
+func ValidateTrialConfig(c TrialConfig) error {
+	if c.Days < 1 || c.Days > MaxTrialDays {
+		return fmt.Errorf("trial days must be between 1 and %d", MaxTrialDays)
+	}
+	if c.GraceDays < 0 || c.GraceDays > MaxTrialGraceDays {
+		return fmt.Errorf("trial grace_days must be between 0 and %d", MaxTrialGraceDays)
+	}
+	return nil
+}
+
+// Expiry is when a trial deployment stops and when it is deleted.
+type Expiry struct {
+	ExpiresAt time.Time
+	DeleteAt  time.Time
+	Source    TrialSource
+}
+
+// Expired reports whether the deployment has expired at now.
+func (e Expiry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// TrialExpiry returns the expiry of a deployment created at created, from
+// the plan's trial length (0 for none) and the template's trial offer (nil
+// for none). The earlier trial wins. ok is false when neither applies.
+func TrialExpiry(created time.Time, planDays int, tmpl *TrialConfig) (e Expiry, ok bool) {
+	if planDays > 0 {
+		e = Expiry{
+			ExpiresAt: created.AddDate(0, 0, planDays),
+			Source:    TrialFromPlan,
+		}
+		e.DeleteAt = e.ExpiresAt.AddDate(0, 0, DefaultTrialGraceDays)
+		ok = true
+	}
+	if tmpl != nil && tmpl.Days > 0 {
+		expires := created.AddDate(0, 0, tmpl.Days)
+		if !ok || expires.Before(e.ExpiresAt) {
+			grace := tmpl.GraceDays
+			if grace == 0 {
+				grace = DefaultTrialGraceDays
+			}
+			e = Expiry{
+				ExpiresAt: expires,
+				DeleteAt:  expires.AddDate(0, 0, grace),
+				Source:    TrialFromTemplate,
+			}
+			ok = true
+		}
+	}
+	return e, ok
+}
+
+// =============================================================================
+// Expiry Steps
+// =============================================================================
+
+// ExpiryStage is one step of taking down an expiring deployment.
+type ExpiryStage string
+
+const (
+	// ExpiryWarn notifies the customer that the deployment will expire.
+	ExpiryWarn ExpiryStage = "warn"
+	// ExpiryStop stops the deployment when it expires.
+	ExpiryStop ExpiryStage = "stop"
+	// ExpiryDelete deletes the deployment when the grace period ends.
+	ExpiryDelete ExpiryStage = "delete"
+)
+
+// ExpiryWarnings are how long before expiry the customer is warned.
+var ExpiryWarnings = []time.Duration{72 * time.Hour, 24 * time.Hour}
+
+// ExpiryStep is a stage and when it runs.
+type ExpiryStep struct {
+	Stage ExpiryStage
+	At    time.Time
+}
+
+// ExpirySteps returns every step of an expiry in order: the warnings, the
+// stop at expiry and the delete at the end of the grace period.
+func ExpirySteps(e Expiry) []ExpiryStep {
+	steps := make([]ExpiryStep, 0, len(ExpiryWarnings)+2)
+	for _, before := range ExpiryWarnings {
+		steps = append(steps, ExpiryStep{Stage: ExpiryWarn, At: e.ExpiresAt.Add(-before)})
+	}
+	steps = append(steps,
+		ExpiryStep{Stage: ExpiryStop, At: e.ExpiresAt},
+		ExpiryStep{Stage: ExpiryDelete, At: e.DeleteAt},
+	)
+	return steps
+}
+
+// NextExpiryStep returns the first step after the given time. Scheduling
+// from a deployment's creation skips warnings a short trial is already past;
+// scheduling from a step's time yields the step after it, even when that is
+// overdue.
+func NextExpiryStep(e Expiry, after time.Time) (ExpiryStep, bool) {
+	for _, s := range ExpirySteps(e) {
+		if s.At.After(after) {
+			return s, true
+		}
+	}
+	return ExpiryStep{}, false
+}
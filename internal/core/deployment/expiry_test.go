@@ -0,0 +1,86 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var created = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// =============================================================================
+// TrialExpiry Tests
+// =============================================================================
+
+func TestTrialExpiry(t *testing.T) {
+	_, ok := TrialExpiry(created, 0, nil)
+	assert.False(t, ok)
+
+	e, ok := TrialExpiry(created, 14, nil)
+	require.True(t, ok)
+	assert.Equal(t, TrialFromPlan, e.Source)
+	assert.Equal(t, time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC), e.ExpiresAt)
+	assert.Equal(t, time.Date(2026, 3, 22, 12, 0, 0, 0, time.UTC), e.DeleteAt)
+
+	// The earlier trial wins, with its own grace period
+	e, ok = TrialExpiry(created, 14, &TrialConfig{Days: 7, GraceDays: 2})
+	require.True(t, ok)
+	assert.Equal(t, TrialFromTemplate, e.Source)
+	assert.Equal(t, time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC), e.ExpiresAt)
+	assert.Equal(t, time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), e.DeleteAt)
+
+	e, _ = TrialExpiry(created, 3, &TrialConfig{Days: 7})
+	assert.Equal(t, TrialFromPlan, e.Source)
+
+	assert.False(t, e.Expired(e.ExpiresAt.Add(-time.Second)))
+	assert.True(t, e.Expired(e.ExpiresAt))
+}
+
+func TestValidateTrialConfig(t *testing.T) {
+	assert.NoError(t, ValidateTrialConfig(TrialConfig{Days: 14}))
+	assert.NoError(t, ValidateTrialConfig(TrialConfig{Days: 90, GraceDays: 30}))
+	assert.Error(t, ValidateTrialConfig(TrialConfig{Days: 0}))
+	assert.Error(t, ValidateTrialConfig(TrialConfig{Days: 91}))
+	assert.Error(t, ValidateTrialConfig(TrialConfig{Days: 7, GraceDays: -1}))
+	assert.Error(t, ValidateTrialConfig(TrialConfig{Days: 7, GraceDays: 31}))
+}
+
+// =============================================================================
+// Expiry Step Tests
+// =============================================================================
+
+func TestNextExpiryStep(t *testing.T) {
+	e, _ := TrialExpiry(created, 14, nil)
+
+	var stages []ExpiryStage
+	after := created
+	for {
+		s, ok := NextExpiryStep(e, after)
+		if !ok {
+			break
+		}
+		stages = append(stages, s.Stage)
+		after = s.At
+	}
+	assert.Equal(t, []ExpiryStage{ExpiryWarn, ExpiryWarn, ExpiryStop, ExpiryDelete}, stages)
+
+	s, ok := NextExpiryStep(e, created)
+	require.True(t, ok)
+	assert.Equal(t, e.ExpiresAt.Add(-72*time.Hour), s.At)
+}
+
+func TestNextExpiryStep_ShortTrial(t *testing.T) {
+	// A two-day trial is already past the three-day warning
+	e, _ := TrialExpiry(created, 2, nil)
+	s, ok := NextExpiryStep(e, created)
+	require.True(t, ok)
+	assert.Equal(t, ExpiryWarn, s.Stage)
+	assert.Equal(t, e.ExpiresAt.Add(-24*time.Hour), s.At)
+
+	// An overdue step still leads to the next one
+	s, ok = NextExpiryStep(e, e.ExpiresAt)
+	require.True(t, ok)
+	assert.Equal(t, ExpiryDelete, s.Stage)
+}
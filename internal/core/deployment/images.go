@@ -0,0 +1,128 @@
+package deployment
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Image References
+// =============================================================================
+
+// ImageRepository returns an image reference without its tag or digest.
+//
+// Example:
+//
+//	ImageRepository("ghcr.io/acme/app:1.2") // returns "ghcr.io/acme/app"
+//	ImageRepository("localhost:5000/app")   // returns "localhost:5000/app"
+func ImageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// IsDigestReference reports whether an image reference names a digest
+// rather than a tag, so it can't drift.
+func IsDigestReference(image string) bool {
+	return strings.Contains(image, "@")
+}
+
+// PinnedImage returns the reference that creates a container from exactly
+// the given digest of an image's repository.
+//
+// Example:
+//
+//	PinnedImage("nginx:latest", "sha256:abc") // returns "nginx@sha256:abc"
+func PinnedImage(image, digest string) string {
+	return ImageRepository(image) + "@" + digest
+}
+
+// RepoDigest picks the digest of an image's repository from the repository
+// digests Docker reports for it ("repo@sha256:..."). Docker Hub names
+// match with or without their docker.io/library/ prefix. Reports false when
+// the image has no digest for its repository, e.g. when it was built or
+// loaded locally rather than pulled.
+func RepoDigest(image string, repoDigests []string) (string, bool) {
+	repo := familiarRepository(ImageRepository(image))
+	for _, rd := range repoDigests {
+		name, digest, ok := strings.Cut(rd, "@")
+		if ok && digest != "" && familiarRepository(name) == repo {
+			return digest, true
+		}
+	}
+	return "", false
+}
+
+// familiarRepository shortens a Docker Hub repository to the name Docker
+// shows for it.
+func familiarRepository(repo string) string {
+	for _, prefix := range []string{"docker.io/", "index.docker.io/"} {
+		if rest, ok := strings.CutPrefix(repo, prefix); ok {
+			repo = rest
+			break
+		}
+	}
+	if rest, ok := strings.CutPrefix(repo, "library/"); ok && !strings.Contains(rest, "/") {
+		repo = rest
+	}
+	return repo
+}
+
+// =============================================================================
+// Image Drift
+// =============================================================================
+
+// ImageDrift reports a service whose image tag now points at a different
+// digest than the one its container was created from.
+type ImageDrift struct {
+	Service      string `json:"service"`
+	Image        string `json:"image"`
+	PinnedDigest string `json:"pinned_digest"`
+	LatestDigest string `json:"latest_digest"`
+}
+
+// PinnedTags returns the tags the containers are pinned from, sorted and
+// without duplicates: the images whose registry digests a drift check needs.
+func PinnedTags(containers []domain.ContainerInfo) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, c := range containers {
+		if c.Digest == "" || c.Image == "" || IsDigestReference(c.Image) || seen[c.Image] {
+			continue
+		}
+		seen[c.Image] = true
+		tags = append(tags, c.Image)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// DetectImageDrift compares each pinned container with the digest its tag
+// points at now, given by latest (image -> digest). Images missing from
+// latest are skipped. The result is sorted by service.
+func DetectImageDrift(containers []domain.ContainerInfo, latest map[string]string) []ImageDrift {
+	var drift []ImageDrift
+	for _, c := range containers {
+		if c.Digest == "" || IsDigestReference(c.Image) {
+			continue
+		}
+		now, ok := latest[c.Image]
+		if !ok || now == "" || now == c.Digest {
+			continue
+		}
+		drift = append(drift, ImageDrift{
+			Service:      c.ServiceName,
+			Image:        c.Image,
+			PinnedDigest: c.Digest,
+			LatestDigest: now,
+		})
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Service < drift[j].Service })
+	return drift
+}
@@ -0,0 +1,103 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Image Reference Tests
+// =============================================================================
+
+func TestImageRepository(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                          "nginx",
+		"nginx:latest":                   "nginx",
+		"ghcr.io/acme/app:1.2":           "ghcr.io/acme/app",
+		"localhost:5000/app":             "localhost:5000/app",
+		"localhost:5000/app:v1":          "localhost:5000/app",
+		"nginx@sha256:abc":               "nginx",
+		"nginx:1.27@sha256:abc":          "nginx",
+		"registry.example.com/a/b/c:dev": "registry.example.com/a/b/c",
+	}
+	for image, want := range tests {
+		assert.Equal(t, want, ImageRepository(image), image)
+	}
+}
+
+func TestPinnedImage(t *testing.T) {
+	assert.Equal(t, "nginx@sha256:abc", PinnedImage("nginx:latest", "sha256:abc"))
+	assert.Equal(t, "localhost:5000/app@sha256:abc", PinnedImage("localhost:5000/app:v1", "sha256:abc"))
+}
+
+func TestRepoDigest(t *testing.T) {
+	digests := []string{"ghcr.io/acme/app@sha256:other", "nginx@sha256:abc"}
+
+	got, ok := RepoDigest("nginx:latest", digests)
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:abc", got)
+
+	got, ok = RepoDigest("docker.io/library/nginx:latest", digests)
+	assert.True(t, ok, "Docker Hub names match their familiar form")
+	assert.Equal(t, "sha256:abc", got)
+
+	got, ok = RepoDigest("ghcr.io/acme/app:1.2", digests)
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:other", got)
+}
+
+func TestRepoDigest_NoMatch(t *testing.T) {
+	_, ok := RepoDigest("redis:7", []string{"nginx@sha256:abc"})
+	assert.False(t, ok)
+
+	_, ok = RepoDigest("myapp:dev", nil)
+	assert.False(t, ok, "locally built images have no repository digest")
+
+	_, ok = RepoDigest("acme/nginx:latest", []string{"nginx@sha256:abc"})
+	assert.False(t, ok, "only official images drop the library/ prefix")
+}
+
+// =============================================================================
+// Image Drift Tests
+// =============================================================================
+
+func TestPinnedTags(t *testing.T) {
+	containers := []domain.ContainerInfo{
+		{ServiceName: "web", Image: "nginx:latest", Digest: "sha256:a"},
+		{ServiceName: "worker", Image: "nginx:latest", Digest: "sha256:a"},
+		{ServiceName: "db", Image: "postgres:16", Digest: "sha256:b"},
+		{ServiceName: "cache", Image: "redis:7"},
+		{ServiceName: "fixed", Image: "busybox@sha256:c", Digest: "sha256:c"},
+	}
+	assert.Equal(t, []string{"nginx:latest", "postgres:16"}, PinnedTags(containers))
+}
+
+func TestDetectImageDrift(t *testing.T) {
+	containers := []domain.ContainerInfo{
+		{ServiceName: "web", Image: "nginx:latest", Digest: "sha256:a"},
+		{ServiceName: "db", Image: "postgres:16", Digest: "sha256:b"},
+		{ServiceName: "cache", Image: "redis:7"},
+		{ServiceName: "api", Image: "acme/api:main", Digest: "sha256:c"},
+	}
+	latest := map[string]string{
+		"nginx:latest":  "sha256:new",
+		"postgres:16":   "sha256:b",
+		"redis:7":       "sha256:r",
+		"acme/api:main": "sha256:d",
+	}
+
+	assert.Equal(t, []ImageDrift{
+		{Service: "api", Image: "acme/api:main", PinnedDigest: "sha256:c", LatestDigest: "sha256:d"},
+		{Service: "web", Image: "nginx:latest", PinnedDigest: "sha256:a", LatestDigest: "sha256:new"},
+	}, DetectImageDrift(containers, latest))
+}
+
+func TestDetectImageDrift_UnknownLatest(t *testing.T) {
+	containers := []domain.ContainerInfo{
+		{ServiceName: "web", Image: "nginx:latest", Digest: "sha256:a"},
+	}
+	assert.Empty(t, DetectImageDrift(containers, nil))
+	assert.Empty(t, DetectImageDrift(containers, map[string]string{"nginx:latest": ""}))
+}
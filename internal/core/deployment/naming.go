@@ -35,3 +35,14 @@ func VolumeName(deploymentID, volumeName string) string {
 func ContainerName(deploymentID, serviceName string) string {
 	return fmt.Sprintf("hoster_%s_%s", deploymentID, serviceName)
 }
+
+// CanaryID generates the identifier a deployment's canary containers are
+// labelled and named with, keeping them apart from the stable set.
+// Pattern: {deploymentID}-canary
+//
+// Example:
+//
+//	ContainerName(CanaryID("abc123"), "web") // returns "hoster_abc123-canary_web"
+func CanaryID(deploymentID string) string {
+	return deploymentID + "-canary"
+}
@@ -73,6 +73,15 @@ func TestContainerName_UUID(t *testing.T) {
 	assert.Equal(t, "hoster_550e8400-e29b-41d4-a716-446655440000_api", got)
 }
 
+// =============================================================================
+// CanaryID Tests
+// =============================================================================
+
+func TestCanaryID(t *testing.T) {
+	assert.Equal(t, "abc123-canary", CanaryID("abc123"))
+	assert.Equal(t, "hoster_abc123-canary_web", ContainerName(CanaryID("abc123"), "web"))
+}
+
 // =============================================================================
 // Table-Driven Tests
 // =============================================================================
@@ -1,6 +1,9 @@
 package deployment
 
 import (
+	"cmp"
+	"slices"
+
 	"github.com/artpar/hoster/internal/core/compose"
 )
 
@@ -94,3 +97,114 @@ func TopologicalSort(services []compose.Service) []compose.Service {
 
 	return result
 }
+
+// StartLevels groups services into dependency levels: level 0 holds the
+// services with no dependencies, and each later level the services whose
+// dependencies are all in earlier levels. Services within a level don't
+// depend on each other and can start concurrently.
+//
+// Within a level, services are ordered by BootWeights, heaviest first, so
+// that when only some of a level can start at once, the services that
+// unblock the most of the stack go first. Ties keep their compose order.
+//
+// Services in a cycle or depending on an unknown service (which should be
+// caught at parse time) form a final level, as TopologicalSort appends
+// them last.
+//
+// Example:
+//
+//	// Services: web → api → db, web → cache
+//	levels := StartLevels(services)
+//	// Result: [[db, cache], [api], [web]]
+func StartLevels(services []compose.Service) [][]compose.Service {
+	if len(services) == 0 {
+		return nil
+	}
+
+	index := make(map[string]int, len(services))
+	for i, svc := range services {
+		index[svc.Name] = i
+	}
+	inDegree := make(map[string]int, len(services))
+	dependents := make(map[string][]string)
+	for _, svc := range services {
+		inDegree[svc.Name] = len(svc.DependsOn)
+		for _, dep := range svc.DependsOn {
+			dependents[dep] = append(dependents[dep], svc.Name)
+		}
+	}
+	weights := BootWeights(services)
+	byWeight := func(level []compose.Service) []compose.Service {
+		slices.SortStableFunc(level, func(a, b compose.Service) int {
+			if c := cmp.Compare(weights[b.Name], weights[a.Name]); c != 0 {
+				return c
+			}
+			return cmp.Compare(index[a.Name], index[b.Name])
+		})
+		return level
+	}
+
+	var current []compose.Service
+	for _, svc := range services {
+		if inDegree[svc.Name] == 0 {
+			current = append(current, svc)
+		}
+	}
+
+	var levels [][]compose.Service
+	placed := 0
+	for len(current) > 0 {
+		levels = append(levels, byWeight(current))
+		placed += len(current)
+		var next []compose.Service
+		for _, svc := range current {
+			for _, name := range dependents[svc.Name] {
+				inDegree[name]--
+				if inDegree[name] == 0 {
+					next = append(next, services[index[name]])
+				}
+			}
+		}
+		current = next
+	}
+
+	if placed < len(services) {
+		var rest []compose.Service
+		for _, svc := range services {
+			if inDegree[svc.Name] > 0 {
+				rest = append(rest, svc)
+			}
+		}
+		levels = append(levels, byWeight(rest))
+	}
+	return levels
+}
+
+// BootWeights returns each service's boot weight: the number of services
+// that depend on it, directly or through other services. A database the
+// whole stack waits on weighs more than a sidecar nothing needs.
+func BootWeights(services []compose.Service) map[string]int {
+	dependents := make(map[string][]string)
+	for _, svc := range services {
+		for _, dep := range svc.DependsOn {
+			dependents[dep] = append(dependents[dep], svc.Name)
+		}
+	}
+
+	weights := make(map[string]int, len(services))
+	for _, svc := range services {
+		seen := map[string]bool{svc.Name: true}
+		stack := slices.Clone(dependents[svc.Name])
+		for len(stack) > 0 {
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			weights[svc.Name]++
+			stack = append(stack, dependents[name]...)
+		}
+	}
+	return weights
+}
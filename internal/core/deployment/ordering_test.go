@@ -216,3 +216,57 @@ func TestTopologicalSort_MissingDependency(t *testing.T) {
 	assert.Len(t, result, 1)
 	assert.Equal(t, "web", result[0].Name)
 }
+
+// =============================================================================
+// StartLevels Tests
+// =============================================================================
+
+func levelNames(levels [][]compose.Service) [][]string {
+	out := make([][]string, len(levels))
+	for i, level := range levels {
+		for _, svc := range level {
+			out[i] = append(out[i], svc.Name)
+		}
+	}
+	return out
+}
+
+func TestStartLevels_Empty(t *testing.T) {
+	assert.Empty(t, StartLevels(nil))
+}
+
+func TestStartLevels_GroupsIndependentServices(t *testing.T) {
+	services := []compose.Service{
+		{Name: "web", DependsOn: []string{"api", "cache"}},
+		{Name: "worker", DependsOn: []string{"db", "queue"}},
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "cache"},
+		{Name: "queue"},
+		{Name: "db"},
+	}
+	assert.Equal(t, [][]string{
+		{"db", "cache", "queue"}, // db unblocks api, web and worker
+		{"api", "worker"},
+		{"web"},
+	}, levelNames(StartLevels(services)))
+}
+
+func TestStartLevels_CycleLast(t *testing.T) {
+	services := []compose.Service{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c"},
+		{Name: "d", DependsOn: []string{"missing"}},
+	}
+	assert.Equal(t, [][]string{{"c"}, {"a", "b", "d"}}, levelNames(StartLevels(services)))
+}
+
+func TestBootWeights(t *testing.T) {
+	services := []compose.Service{
+		{Name: "web", DependsOn: []string{"api", "db"}},
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "db"},
+		{Name: "sidecar"},
+	}
+	assert.Equal(t, map[string]int{"db": 2, "api": 1}, BootWeights(services))
+}
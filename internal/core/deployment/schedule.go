@@ -0,0 +1,93 @@
+package deployment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Power Schedules
+// =============================================================================
+
+// PowerSchedule starts and stops a deployment at set times, so that e.g. a
+// dev environment only runs in working hours. Start and Stop are 5-field
+// cron expressions read in the deployment node's time zone; either may be
+// empty, but not both.
+type PowerSchedule struct {
+	Start string `json:"start,omitempty"`
+	Stop  string `json:"stop,omitempty"`
+}
+
+// PowerAction is what a power schedule does at one of its times.
+type PowerAction string
+
+const (
+	PowerStart PowerAction = "start"
+	PowerStop  PowerAction = "stop"
+)
+
+// maxCatchUpRuns bounds how many missed runs LastPowerAction steps through.
+const maxCatchUpRuns = 10000
+
+// ValidatePowerSchedule checks that a schedule has a start or a stop time
+// and that both are valid cron expressions that differ.
+func ValidatePowerSchedule(s PowerSchedule) error {
+	start, stop := strings.TrimSpace(s.Start), strings.TrimSpace(s.Stop)
+	if start == "" && stop == "" {
+		return fmt.Errorf("schedule needs a start or a stop cron expression")
+	}
+	if start != "" {
+		if err := ValidateSchedule(start); err != nil {
+			return fmt.Errorf("schedule.start: %w", err)
+		}
+	}
+	if stop != "" {
+		if err := ValidateSchedule(stop); err != nil {
+			return fmt.Errorf("schedule.stop: %w", err)
+		}
+	}
+	if start == stop {
+		return fmt.Errorf("schedule start and stop must differ")
+	}
+	return nil
+}
+
+// NextPowerAction returns the schedule's first action strictly after t, read
+// in loc (UTC if nil). When a start and a stop fall on the same minute, the
+// stop wins. The boolean is false when the schedule never runs.
+func NextPowerAction(s PowerSchedule, t time.Time, loc *time.Location) (PowerAction, time.Time, bool) {
+	start, startOK := nextRun(s.Start, t, loc)
+	stop, stopOK := nextRun(s.Stop, t, loc)
+	switch {
+	case stopOK && (!startOK || !start.Before(stop)):
+		return PowerStop, stop, true
+	case startOK:
+		return PowerStart, start, true
+	default:
+		return "", time.Time{}, false
+	}
+}
+
+// LastPowerAction returns the schedule's last action in (since, now], read
+// in loc (UTC if nil): the one a deployment should be left in after missing
+// every run of the period. The boolean is false when none is due.
+func LastPowerAction(s PowerSchedule, since, now time.Time, loc *time.Location) (PowerAction, bool) {
+	var last PowerAction
+	t := since
+	for range maxCatchUpRuns {
+		action, at, ok := NextPowerAction(s, t, loc)
+		if !ok || at.After(now) {
+			break
+		}
+		last, t = action, at
+	}
+	return last, last != ""
+}
+
+func nextRun(expr string, t time.Time, loc *time.Location) (time.Time, bool) {
+	if strings.TrimSpace(expr) == "" {
+		return time.Time{}, false
+	}
+	return NextScheduleRun(expr, t, loc)
+}
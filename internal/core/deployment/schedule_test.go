@@ -0,0 +1,85 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Working hours on weekdays
+var workingHours = PowerSchedule{Start: "0 8 * * 1-5", Stop: "0 19 * * 1-5"}
+
+// =============================================================================
+// Power Schedule Tests
+// =============================================================================
+
+func TestValidatePowerSchedule(t *testing.T) {
+	assert.NoError(t, ValidatePowerSchedule(workingHours))
+	assert.NoError(t, ValidatePowerSchedule(PowerSchedule{Stop: "0 19 * * *"}))
+
+	for name, s := range map[string]PowerSchedule{
+		"empty":       {},
+		"bad start":   {Start: "0 25 * * *"},
+		"bad stop":    {Start: "0 8 * * *", Stop: "every evening"},
+		"same times":  {Start: "0 8 * * *", Stop: " 0 8 * * * "},
+		"four fields": {Stop: "0 19 * *"},
+	} {
+		assert.Error(t, ValidatePowerSchedule(s), name)
+	}
+}
+
+func TestNextPowerAction(t *testing.T) {
+	// Friday 2026-03-06 12:00 UTC
+	noon := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+
+	action, at, ok := NextPowerAction(workingHours, noon, nil)
+	require.True(t, ok)
+	assert.Equal(t, PowerStop, action)
+	assert.Equal(t, time.Date(2026, 3, 6, 19, 0, 0, 0, time.UTC), at.UTC())
+
+	// Over the weekend, the next action is Monday's start
+	action, at, ok = NextPowerAction(workingHours, at, nil)
+	require.True(t, ok)
+	assert.Equal(t, PowerStart, action)
+	assert.Equal(t, time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC), at.UTC())
+
+	_, _, ok = NextPowerAction(PowerSchedule{Start: "0 0 31 2 *"}, noon, nil)
+	assert.False(t, ok, "a schedule that never runs")
+}
+
+func TestNextPowerAction_StopWinsTies(t *testing.T) {
+	s := PowerSchedule{Start: "0 8 * * *", Stop: "0 8 * * 1"}
+	monday := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	action, _, ok := NextPowerAction(s, monday, nil)
+	require.True(t, ok)
+	assert.Equal(t, PowerStop, action)
+}
+
+func TestNextPowerAction_Location(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	noon := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+
+	_, at, ok := NextPowerAction(workingHours, noon, loc)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 6, 18, 0, 0, 0, time.UTC), at.UTC(), "19:00 in Berlin is 18:00 UTC in winter")
+}
+
+func TestLastPowerAction(t *testing.T) {
+	friday := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+
+	_, ok := LastPowerAction(workingHours, friday, friday.Add(time.Hour), nil)
+	assert.False(t, ok, "nothing due")
+
+	action, ok := LastPowerAction(workingHours, friday, friday.Add(8*time.Hour), nil)
+	require.True(t, ok)
+	assert.Equal(t, PowerStop, action)
+
+	// Missing the weekend and Monday's start leaves it started
+	action, ok = LastPowerAction(workingHours, friday, time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC), nil)
+	require.True(t, ok)
+	assert.Equal(t, PowerStart, action)
+}
@@ -0,0 +1,176 @@
+package deployment
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Replica Spreading
+// =============================================================================
+//
+// A template's x-hoster replicas spread a deployment over several nodes. The
+// deployment's own node runs every service once, as without replicas. Each
+// extra node runs one more replica of the replicated services that have one
+// left. Services an extra node doesn't run are reached through relays: the
+// deployment's node publishes their ports on its private address
+// (BridgePort), and a relay container on the extra node, named and aliased
+// like the service, forwards connections there.
+
+// RelayImage is the image of the relay containers.
+const RelayImage = "alpine/socat:1.8.0.1"
+
+// ReplicaServices returns the services each extra node of a spread
+// deployment runs, by name: node i (from 0) runs the services with more
+// than i+1 replicas. It is empty for a spec without replicas.
+func ReplicaServices(spec *compose.ParsedSpec) [][]string {
+	var nodes [][]string
+	for _, svc := range servicesByName(spec) {
+		for i := 0; i < spec.Replicas(svc.Name)-1; i++ {
+			if i == len(nodes) {
+				nodes = append(nodes, nil)
+			}
+			nodes[i] = append(nodes[i], svc.Name)
+		}
+	}
+	return nodes
+}
+
+// BridgePorts returns the ports the deployment's node publishes for its
+// extra nodes: every published or exposed TCP container port of the services
+// some extra node doesn't run. Host ports are left to be allocated.
+func BridgePorts(spec *compose.ParsedSpec) []domain.BridgePort {
+	nodes := ReplicaServices(spec)
+	if len(nodes) == 0 {
+		return nil
+	}
+	last := nodes[len(nodes)-1]
+
+	var bridges []domain.BridgePort
+	for _, svc := range servicesByName(spec) {
+		if slices.Contains(last, svc.Name) {
+			continue
+		}
+		var ports []int
+		for _, p := range svc.Ports {
+			if p.Protocol == "" || p.Protocol == "tcp" {
+				ports = append(ports, int(p.Target))
+			}
+		}
+		for _, p := range svc.Expose {
+			ports = append(ports, int(p))
+		}
+		slices.Sort(ports)
+		for _, p := range slices.Compact(ports) {
+			bridges = append(bridges, domain.BridgePort{Service: svc.Name, Port: p})
+		}
+	}
+	return bridges
+}
+
+// ReplicaSpec returns the spec an extra node runs: the services it runs
+// replicas of, with their dependencies on other services kept only where a
+// relay stands in, and a relay for every service with bridges to address,
+// the deployment node's private address. Named volumes are left out, as
+// replicated services can't mount them.
+func ReplicaSpec(spec *compose.ParsedSpec, services []string, bridges []domain.BridgePort, address string) *compose.ParsedSpec {
+	bridged := make(map[string][]domain.BridgePort)
+	for _, b := range bridges {
+		if b.HostPort > 0 && !slices.Contains(services, b.Service) {
+			bridged[b.Service] = append(bridged[b.Service], b)
+		}
+	}
+
+	sub := &compose.ParsedSpec{Networks: spec.Networks, Extension: spec.Extension}
+	for _, svc := range spec.Services {
+		switch {
+		case slices.Contains(services, svc.Name):
+			svc.DependsOn = slices.DeleteFunc(slices.Clone(svc.DependsOn), func(dep string) bool {
+				return !slices.Contains(services, dep) && bridged[dep] == nil
+			})
+			sub.Services = append(sub.Services, svc)
+		case bridged[svc.Name] != nil:
+			sub.Services = append(sub.Services, RelayService(svc, bridged[svc.Name], address))
+		}
+	}
+	// A relay is up as soon as it starts, so dependents wait for no more
+	for i, svc := range sub.Services {
+		if len(svc.DependsOnConditions) == 0 {
+			continue
+		}
+		conds := make(map[string]compose.DependencyCondition, len(svc.DependsOnConditions))
+		for dep, cond := range svc.DependsOnConditions {
+			if slices.Contains(svc.DependsOn, dep) && slices.Contains(services, dep) {
+				conds[dep] = cond
+			}
+		}
+		sub.Services[i].DependsOnConditions = conds
+	}
+	return sub
+}
+
+// RelayService returns the relay standing in for svc on an extra node. It
+// has svc's name and aliases and forwards each bridged port to its host
+// port on address.
+func RelayService(svc compose.Service, bridges []domain.BridgePort, address string) compose.Service {
+	listeners := make([]string, len(bridges))
+	for i, b := range bridges {
+		listeners[i] = fmt.Sprintf("socat TCP-LISTEN:%d,fork,reuseaddr TCP:%s:%d &", b.Port, address, b.HostPort)
+	}
+	return compose.Service{
+		Name:       svc.Name,
+		Image:      RelayImage,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{strings.Join(listeners, " ") + " wait"},
+		Restart:    compose.RestartUnlessStopped,
+		Labels:     map[string]string{"com.hoster.relay": "true"},
+		Aliases:    svc.Aliases,
+	}
+}
+
+// servicesByName returns the spec's services sorted by name.
+func servicesByName(spec *compose.ParsedSpec) []compose.Service {
+	return slices.SortedFunc(slices.Values(spec.Services), func(a, b compose.Service) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// =============================================================================
+// Replica Status
+// =============================================================================
+
+// ReplicaCount is how many containers of a service a spread deployment asks
+// for and how many are running.
+type ReplicaCount struct {
+	Desired int `json:"desired"`
+	Ready   int `json:"ready"`
+}
+
+// ReplicaStatus sums up a spread deployment's replicas per replicated
+// service. The deployment's own node runs one replica of each, counted ready
+// when primaryRunning; each extra node adds the services it runs, ready when
+// its status is running.
+func ReplicaStatus(primaryRunning bool, replicas []domain.ReplicaNode) map[string]ReplicaCount {
+	status := make(map[string]ReplicaCount)
+	for _, r := range replicas {
+		for _, svc := range r.Services {
+			c, ok := status[svc]
+			if !ok {
+				c.Desired = 1
+				if primaryRunning {
+					c.Ready = 1
+				}
+			}
+			c.Desired++
+			if r.Status == domain.ReplicaRunning {
+				c.Ready++
+			}
+			status[svc] = c
+		}
+	}
+	return status
+}
@@ -0,0 +1,101 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Replica Spreading Tests
+// =============================================================================
+
+const spreadSpec = `
+services:
+  web:
+    image: app:1
+    ports: ["8080:80"]
+    depends_on:
+      db: {condition: service_healthy}
+      api: {condition: service_healthy}
+  api:
+    image: api:1
+    expose: ["9000"]
+    healthcheck: {test: ["CMD", "true"]}
+  db:
+    image: postgres:16
+    expose: ["5432"]
+    volumes: [data:/var/lib/postgresql/data]
+    healthcheck: {test: ["CMD", "pg_isready"]}
+volumes:
+  data:
+x-hoster:
+  replicas:
+    web: 3
+    api: 2
+`
+
+func TestReplicaServices(t *testing.T) {
+	spec, err := compose.ParseComposeSpec(spreadSpec)
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"api", "web"}, {"web"}}, ReplicaServices(spec))
+	assert.Equal(t, []domain.BridgePort{
+		{Service: "api", Port: 9000},
+		{Service: "db", Port: 5432},
+	}, BridgePorts(spec), "web runs on every node")
+
+	single, err := compose.ParseComposeSpec("services:\n  web:\n    image: app:1\n")
+	require.NoError(t, err)
+	assert.Empty(t, ReplicaServices(single))
+	assert.Empty(t, BridgePorts(single))
+}
+
+func TestReplicaSpec(t *testing.T) {
+	spec, err := compose.ParseComposeSpec(spreadSpec)
+	require.NoError(t, err)
+	bridges := []domain.BridgePort{
+		{Service: "api", Port: 9000, HostPort: 31001},
+		{Service: "db", Port: 5432, HostPort: 31002},
+	}
+
+	sub := ReplicaSpec(spec, []string{"web"}, bridges, "10.0.0.5")
+	assert.Empty(t, sub.Volumes)
+	byName := map[string]compose.Service{}
+	for _, svc := range sub.Services {
+		byName[svc.Name] = svc
+	}
+	require.Len(t, byName, 3)
+	assert.ElementsMatch(t, []string{"api", "db"}, byName["web"].DependsOn)
+	assert.Empty(t, byName["web"].DependsOnConditions, "relays aren't waited on for health")
+	assert.Equal(t, RelayImage, byName["db"].Image)
+	assert.Equal(t, []string{"socat TCP-LISTEN:5432,fork,reuseaddr TCP:10.0.0.5:31002 & wait"}, byName["db"].Command)
+	assert.Empty(t, byName["db"].Volumes)
+
+	sub = ReplicaSpec(spec, []string{"web", "api"}, bridges, "10.0.0.5")
+	for _, svc := range sub.Services {
+		if svc.Name == "web" {
+			assert.Equal(t, compose.DependencyCondition("service_healthy"), svc.DependsOnConditions["api"])
+			assert.NotContains(t, svc.DependsOnConditions, "db")
+		}
+		if svc.Name == "api" {
+			assert.Equal(t, "api:1", svc.Image, "a replicated service is run, not relayed")
+		}
+	}
+}
+
+func TestReplicaStatus(t *testing.T) {
+	replicas := []domain.ReplicaNode{
+		{NodeID: "node_b", Services: []string{"web", "api"}, Status: domain.ReplicaRunning},
+		{NodeID: "node_c", Services: []string{"web"}, Status: domain.ReplicaFailed},
+	}
+
+	assert.Equal(t, map[string]ReplicaCount{
+		"web": {Desired: 3, Ready: 2},
+		"api": {Desired: 2, Ready: 2},
+	}, ReplicaStatus(true, replicas))
+	assert.Equal(t, ReplicaCount{Desired: 3, Ready: 1}, ReplicaStatus(false, replicas)["web"])
+}
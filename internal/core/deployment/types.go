@@ -25,6 +25,12 @@ type ContainerPlan struct {
 	RestartPolicy RestartPolicyPlan
 	Resources     ResourcePlan
 	HealthCheck   *HealthCheckPlan
+
+	// NetworkAliases maps each network to the hostnames the container is
+	// reachable by on it: the service name and its compose aliases.
+	NetworkAliases map[string][]string
+	// ExtraHosts are /etc/hosts entries in "host:ip" form.
+	ExtraHosts []string
 }
 
 // PortPlan represents a planned port binding.
@@ -0,0 +1,419 @@
+package deployment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Upgrade Policy Types
+// =============================================================================
+
+// UpgradePolicy controls when a deployment may be upgraded to a newer
+// template version.
+type UpgradePolicy string
+
+const (
+	// UpgradeAuto upgrades as soon as a new template version is available.
+	UpgradeAuto UpgradePolicy = "auto"
+	// UpgradeWindowed upgrades only inside one of the deployment's maintenance windows.
+	UpgradeWindowed UpgradePolicy = "windowed"
+	// UpgradeManual waits for the customer to approve each upgrade.
+	UpgradeManual UpgradePolicy = "manual"
+)
+
+// ValidUpgradePolicy reports whether p is a known policy.
+func ValidUpgradePolicy(p UpgradePolicy) bool {
+	switch p {
+	case UpgradeAuto, UpgradeWindowed, UpgradeManual:
+		return true
+	}
+	return false
+}
+
+// UpgradeStatus is the state of a deployment's pending upgrade.
+type UpgradeStatus string
+
+const (
+	UpgradeStatusNone            UpgradeStatus = ""
+	UpgradeStatusPendingApproval UpgradeStatus = "pending_approval"
+	UpgradeStatusScheduled       UpgradeStatus = "scheduled"
+	UpgradeStatusApproved        UpgradeStatus = "approved"
+	UpgradeStatusFailed          UpgradeStatus = "failed"
+	// UpgradeStatusCanary means the new version is serving a share of the
+	// traffic alongside the old one (canary strategy only).
+	UpgradeStatusCanary UpgradeStatus = "canary"
+	// UpgradeStatusBlocked means the new version needs variables the
+	// deployment does not supply (see CheckVariables); the upgrade waits
+	// until they are.
+	UpgradeStatusBlocked UpgradeStatus = "blocked"
+)
+
+// UpgradeAction is what the upgrade job should do with a deployment now.
+type UpgradeAction string
+
+const (
+	UpgradeActionNow           UpgradeAction = "upgrade"
+	UpgradeActionWaitForWindow UpgradeAction = "wait_for_window"
+	UpgradeActionAwaitApproval UpgradeAction = "await_approval"
+)
+
+// UpgradeDecision is the result of applying a policy at a point in time.
+type UpgradeDecision struct {
+	Action UpgradeAction
+	// Status to record on the deployment while the upgrade is outstanding.
+	Status UpgradeStatus
+	// ScheduledAt is the start of the next maintenance window (windowed only).
+	ScheduledAt time.Time
+}
+
+// MaintenanceWindow is a recurring period in which upgrades may run.
+// Schedule is a 5-field cron expression (minute hour day-of-month month
+// day-of-week) giving the window start, evaluated in the deployment owner's
+// time zone. Duration is a Go duration string, e.g. "2h".
+type MaintenanceWindow struct {
+	Schedule string `json:"schedule"`
+	Duration string `json:"duration"`
+}
+
+// MaxWindowDuration bounds a single maintenance window.
+const MaxWindowDuration = 24 * time.Hour
+
+// =============================================================================
+// Upgrade Decision (Pure Functions)
+// =============================================================================
+
+// NeedsUpgrade reports whether a deployment on currentVersion is behind the
+// template's latestVersion. Versions are compared as semver (major.minor.patch);
+// an unparseable version is treated as needing an upgrade only if it differs.
+func NeedsUpgrade(currentVersion, latestVersion string) bool {
+	if latestVersion == "" || currentVersion == latestVersion {
+		return false
+	}
+	cur, ok1 := parseSemver(currentVersion)
+	latest, ok2 := parseSemver(latestVersion)
+	if !ok1 || !ok2 {
+		return true
+	}
+	for i := range cur {
+		if latest[i] != cur[i] {
+			return latest[i] > cur[i]
+		}
+	}
+	return false
+}
+
+// DecideUpgrade applies a deployment's upgrade policy at time now, reading
+// the windows in loc (UTC if nil). An approved upgrade runs regardless of
+// policy. Windows must have been validated; invalid windows are ignored.
+func DecideUpgrade(policy UpgradePolicy, windows []MaintenanceWindow, status UpgradeStatus, now time.Time, loc *time.Location) UpgradeDecision {
+	if status == UpgradeStatusApproved {
+		return UpgradeDecision{Action: UpgradeActionNow, Status: UpgradeStatusApproved}
+	}
+
+	switch policy {
+	case UpgradeManual:
+		return UpgradeDecision{Action: UpgradeActionAwaitApproval, Status: UpgradeStatusPendingApproval}
+	case UpgradeWindowed:
+		if InMaintenanceWindow(windows, now, loc) {
+			return UpgradeDecision{Action: UpgradeActionNow, Status: UpgradeStatusScheduled}
+		}
+		next, _ := NextMaintenanceWindow(windows, now, loc)
+		return UpgradeDecision{Action: UpgradeActionWaitForWindow, Status: UpgradeStatusScheduled, ScheduledAt: next}
+	default:
+		return UpgradeDecision{Action: UpgradeActionNow, Status: UpgradeStatusNone}
+	}
+}
+
+// =============================================================================
+// Maintenance Windows
+// =============================================================================
+
+// ValidateUpgradePolicy checks a policy and its windows.
+// The windowed policy requires at least one window.
+func ValidateUpgradePolicy(policy UpgradePolicy, windows []MaintenanceWindow) error {
+	if !ValidUpgradePolicy(policy) {
+		return fmt.Errorf("upgrade_policy must be one of auto, windowed, manual")
+	}
+	if policy == UpgradeWindowed && len(windows) == 0 {
+		return fmt.Errorf("windowed upgrade policy requires at least one maintenance window")
+	}
+	for i, w := range windows {
+		if _, _, err := parseWindow(w); err != nil {
+			return fmt.Errorf("maintenance_windows[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// InMaintenanceWindow reports whether t falls inside any window, reading the
+// windows in loc (UTC if nil). A window lasts its duration in elapsed time,
+// so one spanning a daylight saving change ends an hour earlier or later on
+// the wall clock.
+func InMaintenanceWindow(windows []MaintenanceWindow, t time.Time, loc *time.Location) bool {
+	t = t.Truncate(time.Minute)
+	for _, w := range windows {
+		sched, dur, err := parseWindow(w)
+		if err != nil {
+			continue
+		}
+		// A window is open if it started within the last dur
+		if start, ok := sched.next(t.Add(-dur), loc); ok && !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextMaintenanceWindow returns the earliest window start strictly after t,
+// reading the windows in loc (UTC if nil).
+func NextMaintenanceWindow(windows []MaintenanceWindow, t time.Time, loc *time.Location) (time.Time, bool) {
+	var best time.Time
+	for _, w := range windows {
+		sched, _, err := parseWindow(w)
+		if err != nil {
+			continue
+		}
+		if next, ok := sched.next(t, loc); ok && (best.IsZero() || next.Before(best)) {
+			best = next
+		}
+	}
+	return best, !best.IsZero()
+}
+
+func parseWindow(w MaintenanceWindow) (cronSchedule, time.Duration, error) {
+	sched, err := parseCron(w.Schedule)
+	if err != nil {
+		return cronSchedule{}, 0, err
+	}
+	dur, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return cronSchedule{}, 0, fmt.Errorf("invalid duration %q", w.Duration)
+	}
+	if dur < time.Minute || dur > MaxWindowDuration {
+		return cronSchedule{}, 0, fmt.Errorf("duration must be between 1m and %s", MaxWindowDuration)
+	}
+	return sched, dur, nil
+}
+
+// =============================================================================
+// Cron Expressions
+// =============================================================================
+
+// cronSchedule is a parsed 5-field cron expression. Each field is a set of
+// allowed values. Day-of-month and day-of-week follow cron semantics: if both
+// are restricted, a day matches when either matches.
+//
+// Schedules match wall-clock time in a location. A time skipped when clocks
+// go forward runs when the jump ends; a time repeated when clocks go back
+// runs only the first time.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+// ValidateSchedule checks that expr is a valid 5-field cron expression.
+func ValidateSchedule(expr string) error {
+	_, err := parseCron(expr)
+	return err
+}
+
+// NextScheduleRun returns the first minute strictly after t matching expr,
+// read as wall-clock time in loc (UTC if nil). The boolean is false when
+// expr is invalid or never matches.
+func NextScheduleRun(expr string, t time.Time, loc *time.Location) (time.Time, bool) {
+	sched, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return sched.next(t, loc)
+}
+
+// NextScheduleRuns returns up to n runs of expr after t in loc, for previews.
+func NextScheduleRuns(expr string, t time.Time, loc *time.Location, n int) []time.Time {
+	sched, err := parseCron(expr)
+	if err != nil {
+		return nil
+	}
+	var runs []time.Time
+	for len(runs) < n {
+		next, ok := sched.next(t, loc)
+		if !ok {
+			break
+		}
+		runs = append(runs, next)
+		t = next
+	}
+	return runs
+}
+
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("schedule %q must have 5 fields (minute hour day month weekday)", expr)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	names := [5]string{"minute", "hour", "day", "month", "weekday"}
+	var sets [5]map[int]bool
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("schedule %q: %s: %w", expr, names[i], err)
+		}
+		sets[i] = set
+	}
+	// 7 is an alias for Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+		delete(sets[4], 7)
+	}
+	return cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", s)
+			}
+			step = n
+			part = base
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			a, b, isRange := strings.Cut(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return nil, fmt.Errorf("invalid value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return nil, fmt.Errorf("invalid value %q", b)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first matching minute strictly after t, in loc (UTC if nil).
+// The result is expressed in loc.
+// Gives up after five years, which only happens for impossible dates like "0 0 31 2 *".
+func (c cronSchedule) next(t time.Time, loc *time.Location) (time.Time, bool) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	// Step through wall-clock times, kept as UTC so every day has 24 hours,
+	// and resolve each match to an instant in loc.
+	w := wallClock(t, loc).Truncate(time.Minute).Add(time.Minute)
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		switch {
+		case !c.month[int(w.Month())]:
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(w):
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !c.hour[w.Hour()]:
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+		case !c.minute[w.Minute()]:
+			w = w.Add(time.Minute)
+		default:
+			// A repeated time already ran if its first instant is not after t
+			if at := resolveWallClock(w, loc); at.After(t) {
+				return at.In(loc), true
+			}
+			w = w.Add(time.Minute)
+		}
+	}
+	return time.Time{}, false
+}
+
+// wallClock returns t's wall-clock time in loc with the fields kept in UTC.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+// resolveWallClock returns the instant at which loc's clocks show the
+// wall-clock time w (whose fields are in UTC). A time shown twice resolves to
+// the first instant; a time skipped by a forward jump resolves to the
+// instant the jump ends.
+func resolveWallClock(w time.Time, loc *time.Location) time.Time {
+	// Offsets a day either side bracket any transition near w
+	_, before := w.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := w.Add(24 * time.Hour).In(loc).Zone()
+
+	var found time.Time
+	for _, off := range []int{before, after} {
+		at := w.Add(-time.Duration(off) * time.Second)
+		if wallClock(at, loc).Equal(w) && (found.IsZero() || at.Before(found)) {
+			found = at
+		}
+	}
+	if !found.IsZero() {
+		return found
+	}
+
+	// Skipped: find the transition between the two readings of w
+	lo := w.Add(-time.Duration(before) * time.Second)
+	hi := w.Add(-time.Duration(after) * time.Second)
+	if hi.Before(lo) {
+		lo, hi = hi, lo
+	}
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if _, off := mid.In(loc).Zone(); off == after {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi.Truncate(time.Second)
+}
+
+// parseSemver parses "major.minor.patch".
+func parseSemver(v string) ([3]int, bool) {
+	var out [3]int
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(parts) != 3 {
+		return out, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
@@ -0,0 +1,218 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Saturday 2026-03-07
+var sat0130 = time.Date(2026, 3, 7, 1, 30, 0, 0, time.UTC)
+
+var weekendNights = []MaintenanceWindow{{Schedule: "0 2 * * 6,0", Duration: "2h"}}
+
+// =============================================================================
+// NeedsUpgrade Tests
+// =============================================================================
+
+func TestNeedsUpgrade(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		want            bool
+	}{
+		{"1.0.0", "1.0.0", false},
+		{"1.0.0", "1.0.1", true},
+		{"1.2.0", "1.10.0", true},
+		{"2.0.0", "1.9.9", false},
+		{"", "1.0.0", true},
+		{"1.0.0", "", false},
+		{"custom", "1.0.0", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NeedsUpgrade(tt.current, tt.latest), "%s -> %s", tt.current, tt.latest)
+	}
+}
+
+// =============================================================================
+// DecideUpgrade Tests
+// =============================================================================
+
+func TestDecideUpgrade_Auto(t *testing.T) {
+	d := DecideUpgrade(UpgradeAuto, nil, UpgradeStatusNone, sat0130, nil)
+	assert.Equal(t, UpgradeActionNow, d.Action)
+}
+
+func TestDecideUpgrade_Manual(t *testing.T) {
+	d := DecideUpgrade(UpgradeManual, nil, UpgradeStatusNone, sat0130, nil)
+	assert.Equal(t, UpgradeActionAwaitApproval, d.Action)
+	assert.Equal(t, UpgradeStatusPendingApproval, d.Status)
+
+	d = DecideUpgrade(UpgradeManual, nil, UpgradeStatusApproved, sat0130, nil)
+	assert.Equal(t, UpgradeActionNow, d.Action)
+}
+
+func TestDecideUpgrade_Windowed(t *testing.T) {
+	d := DecideUpgrade(UpgradeWindowed, weekendNights, UpgradeStatusNone, sat0130, nil)
+	assert.Equal(t, UpgradeActionWaitForWindow, d.Action)
+	assert.Equal(t, UpgradeStatusScheduled, d.Status)
+	assert.Equal(t, time.Date(2026, 3, 7, 2, 0, 0, 0, time.UTC), d.ScheduledAt)
+
+	d = DecideUpgrade(UpgradeWindowed, weekendNights, UpgradeStatusScheduled, sat0130.Add(time.Hour), nil)
+	assert.Equal(t, UpgradeActionNow, d.Action)
+}
+
+// =============================================================================
+// Maintenance Window Tests
+// =============================================================================
+
+func TestInMaintenanceWindow(t *testing.T) {
+	assert.False(t, InMaintenanceWindow(weekendNights, sat0130, nil))
+	assert.True(t, InMaintenanceWindow(weekendNights, time.Date(2026, 3, 7, 2, 0, 0, 0, time.UTC), nil))
+	assert.True(t, InMaintenanceWindow(weekendNights, time.Date(2026, 3, 8, 3, 59, 0, 0, time.UTC), nil))
+	assert.False(t, InMaintenanceWindow(weekendNights, time.Date(2026, 3, 8, 4, 0, 0, 0, time.UTC), nil))
+	assert.False(t, InMaintenanceWindow(weekendNights, time.Date(2026, 3, 9, 2, 30, 0, 0, time.UTC), nil)) // Monday
+}
+
+func TestInMaintenanceWindow_SpansMidnight(t *testing.T) {
+	windows := []MaintenanceWindow{{Schedule: "0 23 * * 5", Duration: "3h"}} // Friday 23:00
+	assert.True(t, InMaintenanceWindow(windows, time.Date(2026, 3, 7, 1, 0, 0, 0, time.UTC), nil))
+}
+
+func TestNextMaintenanceWindow(t *testing.T) {
+	// Monday → next Saturday 02:00
+	next, ok := NextMaintenanceWindow(weekendNights, time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC), nil)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 14, 2, 0, 0, 0, time.UTC), next)
+
+	// Earliest of several windows
+	windows := append([]MaintenanceWindow{{Schedule: "30 4 1 * *", Duration: "1h"}}, weekendNights...)
+	next, ok = NextMaintenanceWindow(windows, time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC), nil)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 4, 1, 4, 30, 0, 0, time.UTC), next)
+
+	// Impossible date
+	_, ok = NextMaintenanceWindow([]MaintenanceWindow{{Schedule: "0 0 31 2 *", Duration: "1h"}}, sat0130, nil)
+	assert.False(t, ok)
+}
+
+func TestNextScheduleRun(t *testing.T) {
+	next, ok := NextScheduleRun("0 3 * * 0", sat0130, nil) // Sundays 03:00
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 8, 3, 0, 0, 0, time.UTC), next)
+
+	// Strictly after t
+	next, ok = NextScheduleRun("0 3 * * 0", next, nil)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 15, 3, 0, 0, 0, time.UTC), next)
+
+	_, ok = NextScheduleRun("0 3 * *", sat0130, nil)
+	assert.False(t, ok)
+	assert.Error(t, ValidateSchedule("0 3 * *"))
+	assert.NoError(t, ValidateSchedule("*/30 * * * *"))
+}
+
+// =============================================================================
+// Time Zone Tests
+// =============================================================================
+
+func newYork(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	return loc
+}
+
+func TestNextScheduleRun_InLocation(t *testing.T) {
+	ny := newYork(t)
+	// Sundays 03:00 New York is 08:00 UTC in winter
+	next, ok := NextScheduleRun("0 3 * * 0", time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC), ny)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 1, 11, 8, 0, 0, 0, time.UTC), next.UTC())
+	assert.Equal(t, 3, next.In(ny).Hour())
+
+	// and 07:00 UTC in summer
+	next, ok = NextScheduleRun("0 3 * * 0", time.Date(2026, 6, 6, 12, 0, 0, 0, time.UTC), ny)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 6, 7, 7, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestNextScheduleRun_SpringForward(t *testing.T) {
+	ny := newYork(t)
+	// 2026-03-08 02:00-03:00 does not exist in New York; 02:30 runs at 03:00 EDT
+	runs := NextScheduleRuns("30 2 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny), ny, 3)
+	require.Len(t, runs, 3)
+	assert.Equal(t, time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC), runs[0].UTC())
+	assert.Equal(t, "03:00 EDT", runs[0].In(ny).Format("15:04 MST"))
+	assert.Equal(t, time.Date(2026, 3, 9, 2, 30, 0, 0, ny), runs[1])
+	assert.Equal(t, time.Date(2026, 3, 10, 2, 30, 0, 0, ny), runs[2])
+
+	// Every-minute schedules run once at the jump, not once per skipped minute
+	runs = NextScheduleRuns("* 2 * * *", time.Date(2026, 3, 8, 1, 58, 0, 0, ny), ny, 2)
+	require.Len(t, runs, 2)
+	assert.Equal(t, time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC), runs[0].UTC())
+	assert.Equal(t, time.Date(2026, 3, 9, 2, 0, 0, 0, ny), runs[1])
+}
+
+func TestNextScheduleRun_FallBack(t *testing.T) {
+	ny := newYork(t)
+	// 2026-11-01 01:00-02:00 happens twice in New York; 01:30 runs only the first time
+	runs := NextScheduleRuns("30 1 * * *", time.Date(2026, 10, 31, 12, 0, 0, 0, ny), ny, 2)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "01:30 EDT", runs[0].In(ny).Format("15:04 MST"))
+	assert.Equal(t, time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), runs[0].UTC())
+	assert.Equal(t, time.Date(2026, 11, 2, 1, 30, 0, 0, ny), runs[1])
+
+	// Starting during the repeated hour does not rerun the first pass
+	secondPass := time.Date(2026, 11, 1, 6, 10, 0, 0, time.UTC) // 01:10 EST
+	next, ok := NextScheduleRun("30 1 * * *", secondPass, ny)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 11, 2, 1, 30, 0, 0, ny), next)
+}
+
+func TestInMaintenanceWindow_InLocation(t *testing.T) {
+	ny := newYork(t)
+	// Saturdays and Sundays 02:00-04:00 New York time
+	assert.False(t, InMaintenanceWindow(weekendNights, time.Date(2026, 1, 10, 2, 30, 0, 0, time.UTC), ny))
+	assert.True(t, InMaintenanceWindow(weekendNights, time.Date(2026, 1, 10, 2, 30, 0, 0, ny), ny))
+
+	next, ok := NextMaintenanceWindow(weekendNights, time.Date(2026, 1, 12, 12, 0, 0, 0, ny), ny)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 1, 17, 7, 0, 0, 0, time.UTC), next.UTC())
+
+	d := DecideUpgrade(UpgradeWindowed, weekendNights, UpgradeStatusNone, time.Date(2026, 1, 10, 3, 0, 0, 0, ny), ny)
+	assert.Equal(t, UpgradeActionNow, d.Action)
+}
+
+func TestInMaintenanceWindow_SpringForward(t *testing.T) {
+	ny := newYork(t)
+	// The 02:00 window opens at 03:00 EDT when 02:00 is skipped, and lasts 2h
+	opens := time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC)
+	assert.False(t, InMaintenanceWindow(weekendNights, opens.Add(-time.Minute), ny))
+	assert.True(t, InMaintenanceWindow(weekendNights, opens, ny))
+	assert.True(t, InMaintenanceWindow(weekendNights, opens.Add(119*time.Minute), ny))
+	assert.False(t, InMaintenanceWindow(weekendNights, opens.Add(2*time.Hour), ny))
+}
+
+func TestValidateUpgradePolicy(t *testing.T) {
+	assert.NoError(t, ValidateUpgradePolicy(UpgradeAuto, nil))
+	assert.NoError(t, ValidateUpgradePolicy(UpgradeWindowed, weekendNights))
+	assert.NoError(t, ValidateUpgradePolicy(UpgradeWindowed, []MaintenanceWindow{{Schedule: "*/15 1-5 * 1,7 1-5", Duration: "30m"}}))
+
+	assert.Error(t, ValidateUpgradePolicy("sometimes", nil))
+	assert.Error(t, ValidateUpgradePolicy(UpgradeWindowed, nil))
+
+	bad := []MaintenanceWindow{
+		{Schedule: "0 2 * *", Duration: "1h"},
+		{Schedule: "60 2 * * *", Duration: "1h"},
+		{Schedule: "0 2 * * mon", Duration: "1h"},
+		{Schedule: "0 5-2 * * *", Duration: "1h"},
+		{Schedule: "*/0 2 * * *", Duration: "1h"},
+		{Schedule: "0 2 * * *", Duration: "soon"},
+		{Schedule: "0 2 * * *", Duration: "25h"},
+	}
+	for _, w := range bad {
+		assert.Error(t, ValidateUpgradePolicy(UpgradeWindowed, []MaintenanceWindow{w}), "%+v", w)
+	}
+}
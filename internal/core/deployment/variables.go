@@ -1,16 +1,26 @@
 package deployment
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/secrets"
+)
 
 // =============================================================================
 // Variable Substitution Functions
 // =============================================================================
 
-// varPlaceholderRegex matches ${VAR} and ${VAR:-default} patterns.
+// varPlaceholderRegex matches ${VAR} and ${VAR:-default} patterns, and
+// ${secret:NAME} for a stored secret, substituted as the variable "secret:NAME".
 // Groups:
 //   - Group 1: Variable name (required)
 //   - Group 2: Default value (optional, after :-)
-var varPlaceholderRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+var varPlaceholderRegex = regexp.MustCompile(`\$\{((?:secret:)?[A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
 
 // SubstituteVariables replaces ${VAR} and ${VAR:-default} placeholders with values
 // from the variables map.
@@ -60,3 +70,78 @@ func SubstituteVariables(value string, variables map[string]string) string {
 		return match // Return original if no substitution
 	})
 }
+
+// =============================================================================
+// Variable Checks
+// =============================================================================
+
+// Variable problems reported by CheckVariables.
+const (
+	VariableMissing = "missing" // Required, without a default, and not supplied
+	VariableInvalid = "invalid" // Supplied, but not valid for the variable's type
+)
+
+// VariableIssue is a variable a deployment must supply or fix before it can
+// run a template version. Variable is the version's definition, so a client
+// can prompt for the value.
+type VariableIssue struct {
+	Name     string          `json:"name"`
+	Problem  string          `json:"problem"`
+	Message  string          `json:"message"`
+	Variable domain.Variable `json:"variable"`
+}
+
+// CheckVariables checks a deployment's variable values against a template
+// version's definitions, in definition order. Required variables without a
+// default must be supplied. Supplied values must suit the variable's type:
+// numbers and booleans must parse, a select value must be one of the options,
+// and a value must match the variable's validation pattern, if it has one.
+// Secret references are only resolved at deploy time, so they are not
+// checked against the type.
+func CheckVariables(vars []domain.Variable, values map[string]string) []VariableIssue {
+	var issues []VariableIssue
+	for _, v := range vars {
+		value := values[v.Name]
+		if strings.TrimSpace(value) == "" {
+			if v.Required && v.Default == "" {
+				issues = append(issues, VariableIssue{
+					Name: v.Name, Problem: VariableMissing, Variable: v,
+					Message: fmt.Sprintf("%q is required", v.Name),
+				})
+			}
+			continue
+		}
+		if secrets.IsReference(value) {
+			continue
+		}
+		if msg := checkVariableValue(v, value); msg != "" {
+			issues = append(issues, VariableIssue{Name: v.Name, Problem: VariableInvalid, Message: msg, Variable: v})
+		}
+	}
+	return issues
+}
+
+// checkVariableValue returns why value does not suit v, or "".
+func checkVariableValue(v domain.Variable, value string) string {
+	switch v.Type {
+	case domain.VarTypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("%q must be a number", v.Name)
+		}
+	case domain.VarTypeBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Sprintf("%q must be true or false", v.Name)
+		}
+	case domain.VarTypeSelect:
+		if !slices.Contains(v.Options, value) {
+			return fmt.Sprintf("%q must be one of %s", v.Name, strings.Join(v.Options, ", "))
+		}
+	}
+	if v.Validation != "" {
+		// A pattern that does not compile was never enforced, so it is skipped
+		if re, err := regexp.Compile(v.Validation); err == nil && !re.MatchString(value) {
+			return fmt.Sprintf("%q does not match the required format", v.Name)
+		}
+	}
+	return ""
+}
@@ -3,7 +3,9 @@ package deployment
 import (
 	"testing"
 
+	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // =============================================================================
@@ -69,6 +71,15 @@ func TestSubstituteVariables_MixedContent(t *testing.T) {
 	assert.Equal(t, "Starting myapp version 1.0...", result)
 }
 
+func TestSubstituteVariables_StoredSecret(t *testing.T) {
+	vars := map[string]string{"secret:DB_PASSWORD": "s3cret", "DB_PASSWORD": "plain"}
+	result := SubstituteVariables("postgres://app:${secret:DB_PASSWORD}@db/${DB_PASSWORD}", vars)
+	assert.Equal(t, "postgres://app:s3cret@db/plain", result)
+
+	// Unresolved secrets are left as-is
+	assert.Equal(t, "${secret:MISSING}", SubstituteVariables("${secret:MISSING}", vars))
+}
+
 func TestSubstituteVariables_PartialMatch(t *testing.T) {
 	vars := map[string]string{"A": "1"}
 	result := SubstituteVariables("${A}-${B:-2}", vars)
@@ -179,3 +190,50 @@ func TestSubstituteVariables_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+// =============================================================================
+// CheckVariables Tests
+// =============================================================================
+
+func TestCheckVariables_Missing(t *testing.T) {
+	vars := []domain.Variable{
+		{Name: "DB_PASSWORD", Type: domain.VarTypePassword, Required: true},
+		{Name: "REGION", Type: domain.VarTypeString, Required: true, Default: "eu"},
+		{Name: "NOTE", Type: domain.VarTypeString},
+	}
+
+	issues := CheckVariables(vars, map[string]string{"DB_PASSWORD": "  "})
+	require.Len(t, issues, 1)
+	assert.Equal(t, "DB_PASSWORD", issues[0].Name)
+	assert.Equal(t, VariableMissing, issues[0].Problem)
+	assert.Equal(t, domain.VarTypePassword, issues[0].Variable.Type)
+
+	assert.Empty(t, CheckVariables(vars, map[string]string{"DB_PASSWORD": "s3cret"}))
+}
+
+func TestCheckVariables_Invalid(t *testing.T) {
+	vars := []domain.Variable{
+		{Name: "WORKERS", Type: domain.VarTypeNumber},
+		{Name: "DEBUG", Type: domain.VarTypeBoolean},
+		{Name: "SIZE", Type: domain.VarTypeSelect, Options: []string{"small", "large"}},
+		{Name: "SLUG", Type: domain.VarTypeString, Validation: `^[a-z]+$`},
+	}
+
+	issues := CheckVariables(vars, map[string]string{"WORKERS": "four", "DEBUG": "maybe", "SIZE": "medium", "SLUG": "Bad Slug"})
+	require.Len(t, issues, 4)
+	for _, issue := range issues {
+		assert.Equal(t, VariableInvalid, issue.Problem, issue.Name)
+	}
+	assert.Equal(t, []string{"WORKERS", "DEBUG", "SIZE", "SLUG"}, []string{issues[0].Name, issues[1].Name, issues[2].Name, issues[3].Name})
+
+	assert.Empty(t, CheckVariables(vars, map[string]string{"WORKERS": "4", "DEBUG": "true", "SIZE": "large", "SLUG": "blog"}))
+}
+
+func TestCheckVariables_SecretReferenceAndBadPattern(t *testing.T) {
+	vars := []domain.Variable{
+		{Name: "WORKERS", Type: domain.VarTypeNumber, Required: true},
+		{Name: "NAME", Type: domain.VarTypeString, Validation: `([`},
+	}
+
+	assert.Empty(t, CheckVariables(vars, map[string]string{"WORKERS": "vault://kv/app#workers", "NAME": "anything"}))
+}
@@ -122,16 +122,53 @@ func Verify(input VerificationInput, expectedAutoDomain string, expectedIPs []st
 	}
 }
 
+// =============================================================================
+// Ownership (TXT)
+// =============================================================================
+//
+// A customer who cannot point a domain at a deployment before go-live proves
+// they own it with a TXT record holding the domain's token instead. Routing
+// is switched over separately, once ownership is proven.
+
+// ChallengeLabel is the label the ownership TXT record is created under.
+const ChallengeLabel = "_hoster-challenge"
+
+// ChallengeName returns the name of the TXT record that proves ownership of
+// hostname.
+func ChallengeName(hostname string) string {
+	return ChallengeLabel + "." + hostname
+}
+
+// ChallengeValue returns the TXT record value for a verification token.
+func ChallengeValue(token string) string {
+	return "hoster-verification=" + token
+}
+
+// VerifyOwnership reports whether the TXT records found at ChallengeName
+// include the value for token.
+func VerifyOwnership(records []string, token string) bool {
+	if token == "" {
+		return false
+	}
+	want := ChallengeValue(token)
+	for _, r := range records {
+		if strings.TrimSpace(r) == want {
+			return true
+		}
+	}
+	return false
+}
+
 // =============================================================================
 // DNS Instructions
 // =============================================================================
 
 // DNSInstruction represents a DNS record the user needs to create.
 type DNSInstruction struct {
-	Type     string `json:"type"`     // "CNAME" or "A"
+	Type     string `json:"type"`     // "CNAME", "A" or "TXT"
 	Name     string `json:"name"`     // The hostname to set
 	Value    string `json:"value"`    // The target (auto domain or IP)
-	Priority string `json:"priority"` // "recommended" or "alternative"
+	Priority string `json:"priority"` // "recommended", "alternative" or "ownership"
 }
 
 // GenerateInstructions returns DNS setup instructions for a custom domain.
@@ -157,6 +194,17 @@ func GenerateInstructions(customDomain, autoDomain, nodeIP string) []DNSInstruct
 	return instructions
 }
 
+// OwnershipInstruction returns the TXT record that proves ownership of
+// customDomain before it points at the deployment.
+func OwnershipInstruction(customDomain, token string) DNSInstruction {
+	return DNSInstruction{
+		Type:     "TXT",
+		Name:     ChallengeName(customDomain),
+		Value:    ChallengeValue(token),
+		Priority: "ownership",
+	}
+}
+
 // FindAutoDomain returns the first auto-generated domain from a domain list.
 func FindAutoDomain(domains []domain.Domain) string {
 	for _, d := range domains {
@@ -0,0 +1,24 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyOwnership(t *testing.T) {
+	records := []string{"v=spf1 -all", " hoster-verification=abc123 "}
+	assert.True(t, VerifyOwnership(records, "abc123"))
+	assert.False(t, VerifyOwnership(records, "other"))
+	assert.False(t, VerifyOwnership([]string{"hoster-verification="}, ""), "an empty token never verifies")
+	assert.False(t, VerifyOwnership(nil, "abc123"))
+}
+
+func TestOwnershipInstruction(t *testing.T) {
+	assert.Equal(t, DNSInstruction{
+		Type:     "TXT",
+		Name:     "_hoster-challenge.shop.example.com",
+		Value:    "hoster-verification=abc123",
+		Priority: "ownership",
+	}, OwnershipInstruction("shop.example.com", "abc123"))
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
+// =============================================================================
+// Node Wildcard DNS
+// =============================================================================
+//
+// A node with a base_domain serves deployments at <name>.<base_domain>, which
+// only works when *.<base_domain> resolves to the node. Resolving a random
+// label under the base domain tests the wildcard record itself rather than
+// any record that happens to exist.
+
+// WildcardStatus is the outcome of checking a node's wildcard DNS.
+type WildcardStatus string
+
+const (
+	WildcardVerified   WildcardStatus = "verified"   // the probe name resolves to the node
+	WildcardMismatch   WildcardStatus = "mismatch"   // it resolves, but not to the node
+	WildcardUnresolved WildcardStatus = "unresolved" // it doesn't resolve
+	WildcardUnknown    WildcardStatus = "unknown"    // the node's public address isn't known yet
+)
+
+// WildcardProbeLabel prefixes the random label probe names are made of.
+const WildcardProbeLabel = "hoster-probe-"
+
+// WildcardProbeName returns the name resolved to check baseDomain's wildcard
+// record, for a random token.
+func WildcardProbeName(baseDomain, token string) string {
+	return WildcardProbeLabel + token + "." + strings.TrimSuffix(baseDomain, ".")
+}
+
+// WildcardResult is the outcome of a wildcard DNS check, with why it failed.
+type WildcardResult struct {
+	Status WildcardStatus
+	Error  string
+}
+
+// VerifyWildcard checks the lookup of a probe name against the node's
+// public IPs. Any A record for one of them verifies the wildcard.
+func VerifyWildcard(input VerificationInput, expectedIPs []string) WildcardResult {
+	wildcard := "*." + input.Hostname
+	if _, base, ok := strings.Cut(input.Hostname, "."); ok {
+		wildcard = "*." + base
+	}
+	if len(expectedIPs) == 0 {
+		return WildcardResult{Status: WildcardUnknown, Error: "node has no known public address to check " + wildcard + " against"}
+	}
+	if input.LookupError != "" || len(input.ARecords) == 0 {
+		msg := wildcard + " does not resolve"
+		if input.LookupError != "" {
+			msg += ": " + input.LookupError
+		}
+		return WildcardResult{Status: WildcardUnresolved, Error: msg}
+	}
+	found := make([]string, len(input.ARecords))
+	for i, ip := range input.ARecords {
+		found[i] = ip.String()
+		if slices.ContainsFunc(expectedIPs, func(want string) bool { return ipEqual(ip, want) }) {
+			return WildcardResult{Status: WildcardVerified}
+		}
+	}
+	return WildcardResult{
+		Status: WildcardMismatch,
+		Error:  wildcard + " resolves to " + strings.Join(found, ", ") + ", not the node's public address " + strings.Join(expectedIPs, ", "),
+	}
+}
+
+// WildcardRegressed reports whether a check found a previously verified
+// wildcard broken. A public address that is no longer known is not a
+// regression of the DNS.
+func WildcardRegressed(previous, current WildcardStatus) bool {
+	return previous == WildcardVerified && (current == WildcardMismatch || current == WildcardUnresolved)
+}
+
+// ipEqual compares an IP with a textual address, treating IPv4-mapped IPv6
+// addresses as their IPv4 form.
+func ipEqual(ip net.IP, addr string) bool {
+	want := net.ParseIP(addr)
+	return want != nil && ip.Equal(want)
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWildcardProbeName(t *testing.T) {
+	assert.Equal(t, "hoster-probe-abc123.apps.example.com", WildcardProbeName("apps.example.com.", "abc123"))
+}
+
+func TestVerifyWildcard(t *testing.T) {
+	probe := "hoster-probe-abc.apps.example.com"
+	lookup := func(ips ...string) VerificationInput {
+		in := VerificationInput{Hostname: probe}
+		for _, ip := range ips {
+			in.ARecords = append(in.ARecords, net.ParseIP(ip))
+		}
+		return in
+	}
+
+	assert.Equal(t, WildcardResult{Status: WildcardVerified}, VerifyWildcard(lookup("10.0.0.1", "203.0.113.7"), []string{"203.0.113.7"}))
+	assert.Equal(t, WildcardVerified, VerifyWildcard(lookup("::ffff:203.0.113.7"), []string{"203.0.113.7"}).Status, "IPv4-mapped addresses match")
+
+	r := VerifyWildcard(lookup("198.51.100.1"), []string{"203.0.113.7"})
+	assert.Equal(t, WildcardMismatch, r.Status)
+	assert.Equal(t, "*.apps.example.com resolves to 198.51.100.1, not the node's public address 203.0.113.7", r.Error)
+
+	r = VerifyWildcard(VerificationInput{Hostname: probe, LookupError: "no such host"}, []string{"203.0.113.7"})
+	assert.Equal(t, WildcardUnresolved, r.Status)
+	assert.Equal(t, "*.apps.example.com does not resolve: no such host", r.Error)
+	assert.Equal(t, WildcardUnresolved, VerifyWildcard(lookup(), []string{"203.0.113.7"}).Status)
+
+	assert.Equal(t, WildcardUnknown, VerifyWildcard(lookup("203.0.113.7"), nil).Status)
+}
+
+func TestWildcardRegressed(t *testing.T) {
+	assert.True(t, WildcardRegressed(WildcardVerified, WildcardMismatch))
+	assert.True(t, WildcardRegressed(WildcardVerified, WildcardUnresolved))
+	assert.False(t, WildcardRegressed(WildcardVerified, WildcardUnknown))
+	assert.False(t, WildcardRegressed(WildcardVerified, WildcardVerified))
+	assert.False(t, WildcardRegressed(WildcardUnresolved, WildcardMismatch), "never verified")
+	assert.False(t, WildcardRegressed("", WildcardUnresolved))
+}
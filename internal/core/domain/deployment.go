@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -55,7 +56,7 @@ const (
 type DomainVerificationStatus string
 
 const (
-	DomainVerificationNone     DomainVerificationStatus = ""         // Auto domains (no verification needed)
+	DomainVerificationNone     DomainVerificationStatus = "" // Auto domains (no verification needed)
 	DomainVerificationPending  DomainVerificationStatus = "pending"
 	DomainVerificationVerified DomainVerificationStatus = "verified"
 	DomainVerificationFailed   DomainVerificationStatus = "failed"
@@ -68,6 +69,9 @@ const (
 	DomainVerificationMethodNone  DomainVerificationMethod = ""
 	DomainVerificationMethodCNAME DomainVerificationMethod = "cname"
 	DomainVerificationMethodA     DomainVerificationMethod = "a_record"
+	// DomainVerificationMethodTXT routes a domain whose ownership a TXT
+	// record proved, before its CNAME or A record points at the deployment.
+	DomainVerificationMethodTXT DomainVerificationMethod = "txt"
 )
 
 // Domain represents a hostname assigned to a deployment.
@@ -98,35 +102,144 @@ type ContainerInfo struct {
 	ID          string        `json:"id"`
 	ServiceName string        `json:"service_name"`
 	Image       string        `json:"image"`
+	Digest      string        `json:"digest,omitempty"` // Repository digest the container was created from
 	Status      string        `json:"status"`
 	Ports       []PortMapping `json:"ports,omitempty"`
 }
 
+// ServiceOverride is a customer's change to one service's resources, applied
+// over the compose spec's limits. Zero fields keep the spec's value.
+type ServiceOverride struct {
+	MemoryMB int64   `json:"memory_mb,omitempty"`
+	CPUCores float64 `json:"cpu_cores,omitempty"`
+}
+
+// DefaultStickyCookie names the cookie sticky sessions use when the routing
+// options don't name one.
+const DefaultStickyCookie = "hoster_affinity"
+
+// RoutingOptions tune how proxies route a deployment's requests. A template's
+// options are the defaults for its deployments, and a deployment's own
+// options override them field by field. Zero fields keep the proxy's
+// defaults.
+type RoutingOptions struct {
+	// StickySessions pins each client to one backend with a cookie, so
+	// requests of a session aren't split between replicas or a canary.
+	StickySessions *bool  `json:"sticky_sessions,omitempty"`
+	StickyCookie   string `json:"sticky_cookie,omitempty"`
+	// WebSocketTimeoutSeconds is how long an upgraded (WebSocket)
+	// connection may stay open.
+	WebSocketTimeoutSeconds int `json:"websocket_timeout_seconds,omitempty"`
+	// MaxBodyMB caps the size of request bodies.
+	MaxBodyMB int64 `json:"max_body_mb,omitempty"`
+}
+
+// Override returns the options with the fields set in o applied over them.
+func (r RoutingOptions) Override(o RoutingOptions) RoutingOptions {
+	if o.StickySessions != nil {
+		r.StickySessions = o.StickySessions
+	}
+	if o.StickyCookie != "" {
+		r.StickyCookie = o.StickyCookie
+	}
+	if o.WebSocketTimeoutSeconds != 0 {
+		r.WebSocketTimeoutSeconds = o.WebSocketTimeoutSeconds
+	}
+	if o.MaxBodyMB != 0 {
+		r.MaxBodyMB = o.MaxBodyMB
+	}
+	return r
+}
+
+// Cookie returns the name of the sticky session cookie, or "" when sessions
+// aren't sticky.
+func (r RoutingOptions) Cookie() string {
+	if r.StickySessions == nil || !*r.StickySessions {
+		return ""
+	}
+	if r.StickyCookie != "" {
+		return r.StickyCookie
+	}
+	return DefaultStickyCookie
+}
+
+// MaxBodyBytes returns the request body limit in bytes, 0 for none.
+func (r RoutingOptions) MaxBodyBytes() int64 {
+	return r.MaxBodyMB * 1024 * 1024
+}
+
+// StreamPort is a service's TCP or UDP port that Traefik routes raw
+// connections to. TLS streams share Traefik's SNI entry point and are told
+// apart by the deployment's hostnames; the others each get their own entry
+// point, on HostPort.
+type StreamPort struct {
+	Service  string `json:"service"`
+	Port     int    `json:"port"`     // Container port
+	Protocol string `json:"protocol"` // tcp or udp
+	TLS      bool   `json:"tls,omitempty"`
+	HostPort int    `json:"host_port,omitempty"` // Entry point port; 0 for TLS streams
+}
+
+// ReplicaNode is an extra node a deployment with x-hoster replicas runs on.
+// The deployment's own node runs the first replica of every service.
+type ReplicaNode struct {
+	NodeID     string          `json:"node_id"`
+	Services   []string        `json:"services"`             // Replicated services the node runs
+	ProxyPort  int             `json:"proxy_port,omitempty"` // Host port of the routed service; 0 when the node doesn't run it
+	Status     string          `json:"status"`               // ReplicaRunning, ReplicaStopped or ReplicaFailed
+	Containers []ContainerInfo `json:"containers,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Replica node statuses.
+const (
+	ReplicaRunning = "running"
+	ReplicaStopped = "stopped"
+	ReplicaFailed  = "failed"
+)
+
+// BridgePort is a container port of a service that not every replica node
+// runs. The deployment's node publishes it on its private address at
+// HostPort, where relays on the replica nodes forward to.
+type BridgePort struct {
+	Service  string `json:"service"`
+	Port     int    `json:"port"` // Container port
+	HostPort int    `json:"host_port"`
+}
+
 // =============================================================================
 // Deployment
 // =============================================================================
 
 // Deployment represents a running instance of a template.
 type Deployment struct {
-	ID              int               `json:"-"`
-	ReferenceID     string            `json:"id"`
-	Name            string            `json:"name"`
-	TemplateID      int               `json:"-"`
-	TemplateRefID   string            `json:"template_id"`
-	TemplateVersion string            `json:"template_version"`
-	CustomerID      int               `json:"-"`
-	NodeID          string            `json:"node_id,omitempty"`
-	Status          DeploymentStatus  `json:"status"`
-	Variables       map[string]string `json:"variables,omitempty"`
-	Domains         []Domain          `json:"domains,omitempty"`
-	Containers      []ContainerInfo   `json:"containers,omitempty"`
-	Resources       Resources         `json:"resources"`
-	ProxyPort       int               `json:"proxy_port,omitempty"` // Host port for App Proxy routing
-	ErrorMessage    string            `json:"error_message,omitempty"`
-	CreatedAt       time.Time         `json:"created_at"`
-	UpdatedAt       time.Time         `json:"updated_at"`
-	StartedAt       *time.Time        `json:"started_at,omitempty"`
-	StoppedAt       *time.Time        `json:"stopped_at,omitempty"`
+	ID               int                        `json:"-"`
+	ReferenceID      string                     `json:"id"`
+	Name             string                     `json:"name"`
+	TemplateID       int                        `json:"-"`
+	TemplateRefID    string                     `json:"template_id"`
+	TemplateVersion  string                     `json:"template_version"`
+	CustomerID       int                        `json:"-"`
+	NodeID           string                     `json:"node_id,omitempty"`
+	Status           DeploymentStatus           `json:"status"`
+	Variables        map[string]string          `json:"variables,omitempty"`
+	Domains          []Domain                   `json:"domains,omitempty"`
+	Containers       []ContainerInfo            `json:"containers,omitempty"`
+	Resources        Resources                  `json:"resources"`
+	ServiceOverrides map[string]ServiceOverride `json:"service_overrides,omitempty"`
+	RoutingOptions   RoutingOptions             `json:"routing_options"`          // Template defaults with the deployment's own options applied
+	Labels           map[string]string          `json:"labels,omitempty"`         // Customer labels, added to every container
+	ProxyPort        int                        `json:"proxy_port,omitempty"`     // Host port for App Proxy routing
+	CanaryPort       int                        `json:"canary_port,omitempty"`    // Host port of a canary upgrade's container
+	CanaryPercent    int                        `json:"canary_percent,omitempty"` // Share of requests routed to CanaryPort
+	Streams          []StreamPort               `json:"streams,omitempty"`        // TCP and UDP routes
+	Replicas         []ReplicaNode              `json:"replicas,omitempty"`       // Extra nodes of a spread deployment
+	Bridges          []BridgePort               `json:"bridges,omitempty"`        // Ports published for the replica nodes
+	ErrorMessage     string                     `json:"error_message,omitempty"`
+	CreatedAt        time.Time                  `json:"created_at"`
+	UpdatedAt        time.Time                  `json:"updated_at"`
+	StartedAt        *time.Time                 `json:"started_at,omitempty"`
+	StoppedAt        *time.Time                 `json:"stopped_at,omitempty"`
 }
 
 // NewDeployment creates a new deployment from a template.
@@ -283,6 +396,77 @@ func NewCustomDomain(hostname string) Domain {
 	}
 }
 
+// NormalizeHostname returns the canonical form of a hostname used for uniqueness
+// checks: trimmed, lowercased, and without a trailing dot.
+func NormalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+}
+
+// DomainClaim is a hostname claimed by a deployment (by internal ID).
+type DomainClaim struct {
+	DeploymentID int
+	Hostname     string
+}
+
+// ResolveDomainClaims enforces global hostname uniqueness over a list of claims.
+// Claims are processed in order and the first claim on a normalized hostname wins;
+// later claims on the same hostname are returned as dropped.
+func ResolveDomainClaims(claims []DomainClaim) (kept, dropped []DomainClaim) {
+	owners := make(map[string]int, len(claims))
+	for _, c := range claims {
+		host := NormalizeHostname(c.Hostname)
+		if host == "" {
+			continue
+		}
+		if owner, taken := owners[host]; taken {
+			if owner != c.DeploymentID {
+				dropped = append(dropped, DomainClaim{DeploymentID: c.DeploymentID, Hostname: host})
+			}
+			continue
+		}
+		owners[host] = c.DeploymentID
+		kept = append(kept, DomainClaim{DeploymentID: c.DeploymentID, Hostname: host})
+	}
+	return kept, dropped
+}
+
+// =============================================================================
+// Container Labels
+// =============================================================================
+
+// UserLabelPrefix namespaces a deployment's labels on its containers, so
+// customer labels can't set or shadow the ones hoster and Traefik read.
+const UserLabelPrefix = "hoster.user."
+
+// ContainerLabels returns the deployment's labels as Docker labels, each key
+// prefixed with UserLabelPrefix. Returns nil when there are none.
+func (d *Deployment) ContainerLabels() map[string]string {
+	if len(d.Labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(d.Labels))
+	for k, v := range d.Labels {
+		out[UserLabelPrefix+k] = v
+	}
+	return out
+}
+
+// =============================================================================
+// Image Digests
+// =============================================================================
+
+// ImageDigest returns the digest the service's image is pinned to: the one
+// its current container was created from, provided the service still runs
+// the same image. Returns "" when the service is not pinned.
+func (d *Deployment) ImageDigest(service, image string) string {
+	for _, c := range d.Containers {
+		if c.ServiceName == service && c.Image == image {
+			return c.Digest
+		}
+	}
+	return ""
+}
+
 // =============================================================================
 // Variable Validation
 // =============================================================================
@@ -261,6 +261,97 @@ func TestGenerateDomain_SlugifiesName(t *testing.T) {
 	assert.Equal(t, DomainTypeAuto, domain.Type)
 }
 
+func TestNormalizeHostname(t *testing.T) {
+	assert.Equal(t, "app.example.com", NormalizeHostname("  App.Example.COM. "))
+	assert.Equal(t, "", NormalizeHostname(""))
+}
+
+func TestResolveDomainClaims_FirstClaimWins(t *testing.T) {
+	kept, dropped := ResolveDomainClaims([]DomainClaim{
+		{DeploymentID: 1, Hostname: "blog.example.com"},
+		{DeploymentID: 2, Hostname: "Blog.Example.com."},
+		{DeploymentID: 2, Hostname: "shop.example.com"},
+	})
+
+	assert.Equal(t, []DomainClaim{
+		{DeploymentID: 1, Hostname: "blog.example.com"},
+		{DeploymentID: 2, Hostname: "shop.example.com"},
+	}, kept)
+	assert.Equal(t, []DomainClaim{{DeploymentID: 2, Hostname: "blog.example.com"}}, dropped)
+}
+
+func TestResolveDomainClaims_SameDeploymentDuplicateIsNotDropped(t *testing.T) {
+	kept, dropped := ResolveDomainClaims([]DomainClaim{
+		{DeploymentID: 1, Hostname: "a.example.com"},
+		{DeploymentID: 1, Hostname: "A.example.com"},
+	})
+
+	assert.Len(t, kept, 1)
+	assert.Empty(t, dropped)
+}
+
+func TestResolveDomainClaims_SkipsEmpty(t *testing.T) {
+	kept, dropped := ResolveDomainClaims([]DomainClaim{{DeploymentID: 1, Hostname: " "}})
+	assert.Empty(t, kept)
+	assert.Empty(t, dropped)
+}
+
+// =============================================================================
+// Container Label Tests
+// =============================================================================
+
+func TestContainerLabels(t *testing.T) {
+	d := createPendingDeployment()
+	assert.Nil(t, d.ContainerLabels())
+
+	d.Labels = map[string]string{"team": "payments", "app": "blog"}
+	assert.Equal(t, map[string]string{
+		"hoster.user.team": "payments",
+		"hoster.user.app":  "blog",
+	}, d.ContainerLabels())
+}
+
+// =============================================================================
+// Image Digest Tests
+// =============================================================================
+
+func TestImageDigest(t *testing.T) {
+	d := createPendingDeployment()
+	assert.Empty(t, d.ImageDigest("web", "nginx:latest"))
+
+	d.Containers = []ContainerInfo{
+		{ServiceName: "web", Image: "nginx:latest", Digest: "sha256:aaa"},
+		{ServiceName: "db", Image: "postgres:16"},
+	}
+	assert.Equal(t, "sha256:aaa", d.ImageDigest("web", "nginx:latest"))
+	assert.Empty(t, d.ImageDigest("web", "nginx:1.27"), "a new image is not pinned")
+	assert.Empty(t, d.ImageDigest("db", "postgres:16"))
+}
+
+// =============================================================================
+// Routing Options Tests
+// =============================================================================
+
+func TestRoutingOptions_Override(t *testing.T) {
+	on, off := true, false
+	tmpl := RoutingOptions{StickySessions: &on, StickyCookie: "sid", MaxBodyMB: 10}
+
+	got := tmpl.Override(RoutingOptions{WebSocketTimeoutSeconds: 600, MaxBodyMB: 50})
+	assert.Equal(t, "sid", got.Cookie(), "unset fields keep the template's")
+	assert.Equal(t, 600, got.WebSocketTimeoutSeconds)
+	assert.Equal(t, int64(50*1024*1024), got.MaxBodyBytes())
+
+	got = tmpl.Override(RoutingOptions{StickySessions: &off})
+	assert.Empty(t, got.Cookie(), "a deployment can turn stickiness off")
+}
+
+func TestRoutingOptions_Cookie(t *testing.T) {
+	on := true
+	assert.Empty(t, RoutingOptions{StickyCookie: "sid"}.Cookie())
+	assert.Equal(t, DefaultStickyCookie, RoutingOptions{StickySessions: &on}.Cookie())
+	assert.Equal(t, "sid", RoutingOptions{StickySessions: &on, StickyCookie: "sid"}.Cookie())
+}
+
 // =============================================================================
 // Variable Validation Tests
 // =============================================================================
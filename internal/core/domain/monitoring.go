@@ -31,6 +31,21 @@ type ContainerHealth struct {
 	Health    HealthStatus `json:"health"`
 	StartedAt *time.Time   `json:"started_at,omitempty"`
 	Restarts  int          `json:"restarts"`
+	// Probe is the state of the service's x-hoster probe, if it has one.
+	Probe *ProbeStatus `json:"probe,omitempty"`
+}
+
+// ProbeStatus is the running result of a TCP or command probe Hoster runs
+// against a service.
+type ProbeStatus struct {
+	Kind                string       `json:"kind"` // tcp, command
+	Status              HealthStatus `json:"status"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastOutput          string       `json:"last_output,omitempty"`
+	LastProbeAt         time.Time    `json:"last_probe_at"`
+	// SuspendedUntil is set while the deployment's probes are suspended for
+	// having failed too often.
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
 }
 
 // =============================================================================
@@ -95,18 +110,45 @@ const (
 	EventContainerOOM       ContainerEventType = "container_oom"
 	EventHealthUnhealthy    ContainerEventType = "health_unhealthy"
 	EventHealthHealthy      ContainerEventType = "health_healthy"
+
+	// Deployment migration steps, recorded on the same timeline
+	EventMigrationStep       ContainerEventType = "migration_step"
+	EventMigrationCompleted  ContainerEventType = "migration_completed"
+	EventMigrationFailed     ContainerEventType = "migration_failed"
+	EventMigrationRolledBack ContainerEventType = "migration_rolled_back"
 )
 
 // ContainerEvent represents a container lifecycle event.
 type ContainerEvent struct {
-	ID           int                `json:"-"`
-	ReferenceID  string             `json:"id"`
-	DeploymentID int                `json:"-"`
-	Type         ContainerEventType `json:"type"`
-	Container    string             `json:"container"`
-	Message      string             `json:"message"`
-	Timestamp    time.Time          `json:"timestamp"`
-	CreatedAt    time.Time          `json:"created_at"`
+	ID           int                 `json:"-"`
+	ReferenceID  string              `json:"id"`
+	DeploymentID int                 `json:"-"`
+	Type         ContainerEventType  `json:"type"`
+	Container    string              `json:"container"`
+	Message      string              `json:"message"`
+	Forensics    *ContainerForensics `json:"forensics,omitempty"`
+	Timestamp    time.Time           `json:"timestamp"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+// ContainerForensics is what was captured about a container when it died:
+// why it stopped, its last log lines and its memory use just before.
+type ContainerForensics struct {
+	OOMKilled    bool            `json:"oom_killed"`
+	ExitCode     int             `json:"exit_code"`
+	Signal       string          `json:"signal,omitempty"` // e.g., "SIGKILL" for exit code 137
+	RestartCount int             `json:"restart_count"`
+	DiedAt       time.Time       `json:"died_at"`
+	LogTail      []string        `json:"log_tail,omitempty"`
+	Memory       *MemorySnapshot `json:"memory,omitempty"`
+}
+
+// MemorySnapshot is the last memory sample of a container before it died.
+type MemorySnapshot struct {
+	UsageBytes int64     `json:"usage_bytes"`
+	LimitBytes int64     `json:"limit_bytes"`
+	Percent    float64   `json:"percent"`
+	SampledAt  time.Time `json:"sampled_at"`
 }
 
 // NewContainerEvent creates a new container event.
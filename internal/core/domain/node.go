@@ -48,12 +48,13 @@ const (
 	NodeStatusOnline      NodeStatus = "online"
 	NodeStatusOffline     NodeStatus = "offline"
 	NodeStatusMaintenance NodeStatus = "maintenance"
+	NodeStatusUnverified  NodeStatus = "unverified" // Reachable, but the minion failed signature/protocol verification
 )
 
 // IsValid checks if the node status is valid.
 func (s NodeStatus) IsValid() bool {
 	switch s {
-	case NodeStatusOnline, NodeStatusOffline, NodeStatusMaintenance:
+	case NodeStatusOnline, NodeStatusOffline, NodeStatusMaintenance, NodeStatusUnverified:
 		return true
 	default:
 		return false
@@ -157,6 +158,7 @@ type Node struct {
 	Capabilities    []string     `json:"capabilities"`
 	Capacity        NodeCapacity `json:"capacity"`
 	Location        string       `json:"location,omitempty"`
+	Unschedulable   bool         `json:"unschedulable,omitempty"` // Draining: keeps running what it has, takes nothing new
 	LastHealthCheck *time.Time   `json:"last_health_check,omitempty"`
 	ErrorMessage    string       `json:"error_message,omitempty"`
 	ProviderType    string       `json:"provider_type,omitempty"`  // "manual", "aws", "digitalocean", "hetzner"
@@ -246,7 +248,7 @@ func (n *Node) HasAnyCapability(caps []string) bool {
 
 // IsAvailable returns true if the node can accept new deployments.
 func (n *Node) IsAvailable() bool {
-	return n.Status.IsAvailable()
+	return n.Status.IsAvailable() && !n.Unschedulable
 }
 
 // SSHAddress returns the SSH connection address (host:port).
@@ -20,6 +20,7 @@ func TestNodeStatus_IsValid(t *testing.T) {
 		{"online is valid", NodeStatusOnline, true},
 		{"offline is valid", NodeStatusOffline, true},
 		{"maintenance is valid", NodeStatusMaintenance, true},
+		{"unverified is valid", NodeStatusUnverified, true},
 		{"empty is invalid", NodeStatus(""), false},
 		{"random is invalid", NodeStatus("random"), false},
 	}
@@ -40,6 +41,7 @@ func TestNodeStatus_IsAvailable(t *testing.T) {
 		{"online is available", NodeStatusOnline, true},
 		{"offline is not available", NodeStatusOffline, false},
 		{"maintenance is not available", NodeStatusMaintenance, false},
+		{"unverified is not available", NodeStatusUnverified, false},
 	}
 
 	for _, tt := range tests {
@@ -84,6 +84,77 @@ type Variable struct {
 	Validation  string       `json:"validation,omitempty"`
 }
 
+// =============================================================================
+// Preset
+// =============================================================================
+
+// Preset is a named set of variable values a customer can start from
+// (e.g. "small" or "production"). Values only pre-fill the variables;
+// the customer can still change them.
+type Preset struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Values      map[string]string `json:"values"`
+}
+
+// =============================================================================
+// Setup Flow
+// =============================================================================
+
+// SetupFlow is a guided, multi-step wizard for filling in template variables.
+// Steps are presented in order; each step groups a set of variables.
+type SetupFlow struct {
+	Steps []SetupStep `json:"steps"`
+}
+
+// SetupStep is one page of a setup wizard.
+type SetupStep struct {
+	ID        string         `json:"id"`
+	Title     string         `json:"title"`
+	Help      string         `json:"help,omitempty"`      // Markdown shown alongside the step
+	Variables []string       `json:"variables,omitempty"` // Variable names, in display order
+	Condition *StepCondition `json:"condition,omitempty"` // Step is shown only if the condition holds
+}
+
+// StepCondition shows a step only when a variable from an earlier step
+// equals (or, with Not, does not equal) a value.
+type StepCondition struct {
+	Variable string `json:"variable"`
+	Equals   string `json:"equals"`
+	Not      bool   `json:"not,omitempty"`
+}
+
+// Matches reports whether the condition holds for the given variable values.
+func (c StepCondition) Matches(values map[string]string) bool {
+	eq := values[c.Variable] == c.Equals
+	if c.Not {
+		return !eq
+	}
+	return eq
+}
+
+// ActiveSteps returns the steps whose conditions hold for the given values.
+// Variable defaults are used for values that were not provided.
+func (f SetupFlow) ActiveSteps(vars []Variable, values map[string]string) []SetupStep {
+	resolved := make(map[string]string, len(vars))
+	for _, v := range vars {
+		if v.Default != "" {
+			resolved[v.Name] = v.Default
+		}
+	}
+	for k, v := range values {
+		resolved[k] = v
+	}
+
+	var active []SetupStep
+	for _, step := range f.Steps {
+		if step.Condition == nil || step.Condition.Matches(resolved) {
+			active = append(active, step)
+		}
+	}
+	return active
+}
+
 // =============================================================================
 // ConfigFile
 // =============================================================================
@@ -103,6 +174,10 @@ type ConfigFile struct {
 
 	// Mode is the file permission mode (e.g., "0644"). Defaults to "0644" if empty.
 	Mode string `json:"mode,omitempty"`
+
+	// Template renders Content as a Go text/template at deployment time,
+	// with the deployment's variables, domain and service hostnames.
+	Template bool `json:"template,omitempty"`
 }
 
 // =============================================================================
@@ -116,31 +191,43 @@ type Resources struct {
 	DiskMB   int64   `json:"disk_mb"`
 }
 
+// ResourceCeilings are a creator's upper bounds on the resources customers
+// may request for a template's deployments. Zero means no ceiling.
+type ResourceCeilings struct {
+	MaxReplicas        int     `json:"max_replicas,omitempty"`          // Containers per service
+	MaxServiceMemoryMB int64   `json:"max_service_memory_mb,omitempty"` // Memory limit of one container
+	MaxTotalCPUCores   float64 `json:"max_total_cpu_cores,omitempty"`   // CPU across all containers
+}
+
 // =============================================================================
 // Template
 // =============================================================================
 
 // Template represents a deployable package definition.
 type Template struct {
-	ID                   int          `json:"-"`
-	ReferenceID          string       `json:"id"`
-	Name                 string       `json:"name"`
-	Slug                 string       `json:"slug"`
-	Description          string       `json:"description,omitempty"`
-	Version              string       `json:"version"`
-	ComposeSpec          string       `json:"compose_spec"`
-	Variables            []Variable   `json:"variables,omitempty"`
-	ConfigFiles          []ConfigFile `json:"config_files,omitempty"`
-	ResourceRequirements Resources    `json:"resource_requirements"`
-	RequiredCapabilities []string     `json:"required_capabilities,omitempty"` // Node capabilities required (e.g., ["gpu"])
-	PriceMonthly         int64        `json:"price_monthly_cents"`
-	Category             string       `json:"category,omitempty"`
-	Tags                 []string     `json:"tags,omitempty"`
-	Published            bool         `json:"published"`
-	CreatorID            int          `json:"-"`
-	CreatorRefID         string       `json:"-"` // populated via JOIN with users table
-	CreatedAt            time.Time    `json:"created_at"`
-	UpdatedAt            time.Time    `json:"updated_at"`
+	ID                   int              `json:"-"`
+	ReferenceID          string           `json:"id"`
+	Name                 string           `json:"name"`
+	Slug                 string           `json:"slug"`
+	Description          string           `json:"description,omitempty"`
+	Version              string           `json:"version"`
+	ComposeSpec          string           `json:"compose_spec"`
+	Variables            []Variable       `json:"variables,omitempty"`
+	ConfigFiles          []ConfigFile     `json:"config_files,omitempty"`
+	SetupFlow            *SetupFlow       `json:"setup_flow,omitempty"`
+	Presets              []Preset         `json:"presets,omitempty"`
+	ResourceRequirements Resources        `json:"resource_requirements"`
+	ResourceCeilings     ResourceCeilings `json:"resource_ceilings"`
+	RoutingOptions       RoutingOptions   `json:"routing_options"`                 // Defaults for deployments
+	RequiredCapabilities []string         `json:"required_capabilities,omitempty"` // Node capabilities required (e.g., ["gpu"])
+	PriceMonthly         int64            `json:"price_monthly_cents"`
+	Category             string           `json:"category,omitempty"`
+	Tags                 []string         `json:"tags,omitempty"`
+	Published            bool             `json:"published"`
+	CreatorID            int              `json:"-"`
+	CreatorRefID         string           `json:"-"` // populated via JOIN with users table
+	CreatedAt            time.Time        `json:"created_at"`
+	UpdatedAt            time.Time        `json:"updated_at"`
 }
 
 // NewTemplate creates a new template with the given name, version, and compose spec.
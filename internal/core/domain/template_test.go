@@ -288,3 +288,24 @@ services:
     environment:
       MYSQL_ROOT_PASSWORD: ${DB_PASSWORD}
 `
+
+// =============================================================================
+// Setup Flow Tests
+// =============================================================================
+
+func TestSetupFlow_ActiveSteps(t *testing.T) {
+	vars := []Variable{{Name: "MODE", Default: "simple"}}
+	flow := SetupFlow{Steps: []SetupStep{
+		{ID: "mode", Title: "Mode", Variables: []string{"MODE"}},
+		{ID: "advanced", Title: "Advanced", Condition: &StepCondition{Variable: "MODE", Equals: "advanced"}},
+		{ID: "basic", Title: "Basic", Condition: &StepCondition{Variable: "MODE", Equals: "advanced", Not: true}},
+	}}
+
+	active := flow.ActiveSteps(vars, nil)
+	assert.Len(t, active, 2)
+	assert.Equal(t, "basic", active[1].ID, "default value drives conditions")
+
+	active = flow.ActiveSteps(vars, map[string]string{"MODE": "advanced"})
+	assert.Len(t, active, 2)
+	assert.Equal(t, "advanced", active[1].ID)
+}
@@ -26,6 +26,27 @@ const (
 	// EventDeploymentDeleted is recorded when a deployment is deleted.
 	// Uses dot notation to match APIGate's JSON:API format.
 	EventDeploymentDeleted EventType = "deployment.deleted"
+
+	// EventStorageUsage is recorded periodically for each managed bucket.
+	// Quantity is the stored size in megabytes at the time of measurement.
+	EventStorageUsage EventType = "storage.usage"
+
+	// EventGPUUsage is recorded for deployments holding GPUs: periodically
+	// for each whole hour, and when the GPUs are released.
+	// Quantity is GPU-hours (devices held times hours).
+	EventGPUUsage EventType = "gpu.usage"
+
+	// EventCPUUsage is recorded for each running deployment's billing hour.
+	// Quantity is CPU-seconds (cores in use times seconds).
+	EventCPUUsage EventType = "cpu.usage"
+
+	// EventMemoryUsage is recorded for each running deployment's billing hour.
+	// Quantity is memory megabyte-hours.
+	EventMemoryUsage EventType = "memory.usage"
+
+	// EventEgressUsage is recorded for each running deployment's billing hour.
+	// Quantity is the bytes its containers sent.
+	EventEgressUsage EventType = "egress.usage"
 )
 
 // MeterEvent represents a usage event to be reported to APIGate for billing.
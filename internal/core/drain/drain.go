@@ -0,0 +1,141 @@
+// Package drain provides pure functions for draining a node: moving every
+// deployment off it, one at a time, so it can be serviced or retired. It
+// decides which deployments can be moved now, which have to wait for a
+// transition to settle and which are left alone, and how far a drain is.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package drain
+
+import (
+	"github.com/artpar/hoster/internal/core/relocation"
+)
+
+// =============================================================================
+// Status
+// =============================================================================
+
+// Status is the state of a drain as a whole.
+type Status string
+
+const (
+	// StatusRunning is a drain still moving deployments.
+	StatusRunning Status = "running"
+	// StatusCompleted is a drain that moved or skipped every deployment.
+	StatusCompleted Status = "completed"
+	// StatusFailed is a drain that left at least one deployment on the node.
+	StatusFailed Status = "failed"
+	// StatusCancelled is a drain stopped by the node's owner.
+	StatusCancelled Status = "cancelled"
+)
+
+// Active reports whether the drain is still moving deployments.
+func (s Status) Active() bool {
+	return s == StatusRunning
+}
+
+// ItemStatus is the state of one deployment in a drain.
+type ItemStatus string
+
+const (
+	// ItemPending is a deployment waiting its turn.
+	ItemPending ItemStatus = "pending"
+	// ItemMigrating is the deployment being moved, by a deployment migration.
+	ItemMigrating ItemStatus = "migrating"
+	// ItemCompleted is a deployment moved to another node.
+	ItemCompleted ItemStatus = "completed"
+	// ItemFailed is a deployment that could not be moved and is still on the node.
+	ItemFailed ItemStatus = "failed"
+	// ItemSkipped is a deployment that did not need moving: it was deleted,
+	// left the node on its own, or the drain was cancelled first.
+	ItemSkipped ItemStatus = "skipped"
+)
+
+// Done reports whether the item needs no more work.
+func (s ItemStatus) Done() bool {
+	switch s {
+	case ItemCompleted, ItemFailed, ItemSkipped:
+		return true
+	}
+	return false
+}
+
+// =============================================================================
+// Classification
+// =============================================================================
+
+// Action is what a drain does with a deployment whose turn it is.
+type Action int
+
+const (
+	// Move migrates the deployment to another node now.
+	Move Action = iota
+	// Wait leaves the deployment for a later run, until its transition settles.
+	Wait
+	// Skip leaves the deployment alone; there is nothing on the node to move.
+	Skip
+)
+
+// Classify returns what to do with a deployment in the given status. Only a
+// deployment at rest can be migrated; one mid-transition is waited for, and
+// one that was never placed or is going away is skipped.
+func Classify(deploymentStatus string) Action {
+	switch deploymentStatus {
+	case "running", "stopped", "failed":
+		return Move
+	case "scheduled", "starting", "stopping":
+		return Wait
+	}
+	return Skip
+}
+
+// ItemForMigration maps a finished deployment migration to the item status it
+// leaves behind. ok is false while the migration is still running.
+func ItemForMigration(s relocation.Status) (status ItemStatus, ok bool) {
+	switch s {
+	case relocation.StatusCompleted:
+		return ItemCompleted, true
+	case relocation.StatusRolledBack, relocation.StatusFailed:
+		return ItemFailed, true
+	}
+	return ItemMigrating, false
+}
+
+// =============================================================================
+// Progress
+// =============================================================================
+
+// Item is one deployment's place in a drain, as far as progress is concerned.
+type Item struct {
+	Status ItemStatus
+	// MigrationPercent is the progress of the item's migration while it is
+	// migrating.
+	MigrationPercent int
+}
+
+// Progress returns how far along a drain is, in percent: each deployment
+// counts equally, the one being moved by how far its migration is.
+func Progress(items []Item) int {
+	if len(items) == 0 {
+		return 100
+	}
+	total := 0
+	for _, it := range items {
+		switch {
+		case it.Status.Done():
+			total += 100
+		case it.Status == ItemMigrating:
+			total += min(max(it.MigrationPercent, 0), 100)
+		}
+	}
+	return total / len(items)
+}
+
+// Outcome returns the status of a drain whose items are all done: failed if
+// any deployment is still on the node, completed otherwise.
+func Outcome(items []ItemStatus) Status {
+	for _, s := range items {
+		if s == ItemFailed {
+			return StatusFailed
+		}
+	}
+	return StatusCompleted
+}
@@ -0,0 +1,73 @@
+package drain
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/relocation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemStatus_Done(t *testing.T) {
+	for _, s := range []ItemStatus{ItemCompleted, ItemFailed, ItemSkipped} {
+		assert.True(t, s.Done(), s)
+	}
+	for _, s := range []ItemStatus{ItemPending, ItemMigrating} {
+		assert.False(t, s.Done(), s)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := map[string]Action{
+		"running":   Move,
+		"stopped":   Move,
+		"failed":    Move,
+		"scheduled": Wait,
+		"starting":  Wait,
+		"stopping":  Wait,
+		"pending":   Skip,
+		"deleting":  Skip,
+		"deleted":   Skip,
+	}
+	for status, want := range tests {
+		assert.Equal(t, want, Classify(status), status)
+	}
+}
+
+func TestItemForMigration(t *testing.T) {
+	s, ok := ItemForMigration(relocation.StatusCompleted)
+	assert.True(t, ok)
+	assert.Equal(t, ItemCompleted, s)
+
+	s, ok = ItemForMigration(relocation.StatusRolledBack)
+	assert.True(t, ok)
+	assert.Equal(t, ItemFailed, s)
+
+	s, ok = ItemForMigration(relocation.StatusFailed)
+	assert.True(t, ok)
+	assert.Equal(t, ItemFailed, s)
+
+	_, ok = ItemForMigration(relocation.StatusTransferring)
+	assert.False(t, ok)
+}
+
+func TestProgress(t *testing.T) {
+	assert.Equal(t, 100, Progress(nil), "a drain with nothing to move is done")
+	assert.Equal(t, 0, Progress([]Item{{Status: ItemPending}, {Status: ItemPending}}))
+	assert.Equal(t, 50, Progress([]Item{{Status: ItemCompleted}, {Status: ItemPending}}))
+	assert.Equal(t, 75, Progress([]Item{{Status: ItemSkipped}, {Status: ItemMigrating, MigrationPercent: 50}}))
+	assert.Equal(t, 100, Progress([]Item{{Status: ItemFailed}, {Status: ItemMigrating, MigrationPercent: 300}}),
+		"migration progress is capped")
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, StatusCompleted, Outcome(nil))
+	assert.Equal(t, StatusCompleted, Outcome([]ItemStatus{ItemCompleted, ItemSkipped}))
+	assert.Equal(t, StatusFailed, Outcome([]ItemStatus{ItemCompleted, ItemFailed}))
+}
+
+func TestStatus_Active(t *testing.T) {
+	assert.True(t, StatusRunning.Active())
+	for _, s := range []Status{StatusCompleted, StatusFailed, StatusCancelled} {
+		assert.False(t, s.Active(), s)
+	}
+}
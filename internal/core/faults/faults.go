@@ -0,0 +1,192 @@
+// Package faults provides pure functions for chaos testing: fault rules that
+// add latency, timeouts or typed errors to minion calls and store operations,
+// and which calls they apply to.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Rules
+// =============================================================================
+
+// Targets are the layers faults are injected into.
+const (
+	TargetMinion = "minion" // commands sent to a node's minion
+	TargetStore  = "store"  // engine store operations
+)
+
+// Effects are what a fault does to a call.
+const (
+	EffectLatency = "latency" // delay the call, then run it
+	EffectTimeout = "timeout" // hang, then fail the call as timed out
+	EffectError   = "error"   // fail the call with a typed error
+)
+
+// Store operations a rule can target.
+var StoreOperations = []string{"create", "get", "list", "update", "delete", "transition"}
+
+// Store errors a rule can inject.
+const (
+	StoreErrNotFound    = "not_found"
+	StoreErrValidation  = "validation"
+	StoreErrUnavailable = "unavailable"
+)
+
+// MinionErrors are the minion error codes a rule can inject; the store
+// errors are StoreErrNotFound, StoreErrValidation and StoreErrUnavailable.
+var MinionErrors = []string{
+	minion.ErrCodeNotFound, minion.ErrCodeAlreadyExists, minion.ErrCodeNotRunning,
+	minion.ErrCodeAlreadyRunning, minion.ErrCodeInUse, minion.ErrCodePortConflict,
+	minion.ErrCodeConnectionFailed, minion.ErrCodeTimeout, minion.ErrCodePullFailed,
+	minion.ErrCodeInternal,
+}
+
+// DefaultTimeout is how long a timeout fault hangs when the rule doesn't say
+// and the call has no deadline.
+const DefaultTimeout = 30 * time.Second
+
+// ErrInvalidRule rejects a rule that can't be applied.
+var ErrInvalidRule = errors.New("invalid fault rule")
+
+// Rule injects a fault into the calls it matches. Empty match fields match
+// every call.
+type Rule struct {
+	ID         string `json:"id"`
+	Target     string `json:"target"`
+	Operation  string `json:"operation,omitempty"`     // minion command or store operation
+	Resource   string `json:"resource,omitempty"`      // store resource
+	Node       string `json:"node_id,omitempty"`       // node reference ID
+	Deployment string `json:"deployment_id,omitempty"` // deployment reference ID
+
+	Percent   float64 `json:"percent"`              // share of matching calls, 0-100
+	Effect    string  `json:"effect"`               // latency, timeout or error
+	LatencyMS int     `json:"latency_ms,omitempty"` // delay, or how long a timeout hangs
+	Error     string  `json:"error,omitempty"`      // error code for the error effect
+	MaxHits   int     `json:"max_hits,omitempty"`   // rule is removed after this many faults; 0 = no limit
+
+	Hits int `json:"hits"`
+}
+
+// Validate checks that the rule can be applied.
+func (r Rule) Validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidRule, fmt.Sprintf(format, args...))
+	}
+	switch r.Target {
+	case TargetMinion:
+		if r.Resource != "" {
+			return invalid("resource applies to store rules only")
+		}
+	case TargetStore:
+		if r.Operation != "" && !slices.Contains(StoreOperations, r.Operation) {
+			return invalid("store operation must be one of %s", strings.Join(StoreOperations, ", "))
+		}
+	default:
+		return invalid("target must be %s or %s", TargetMinion, TargetStore)
+	}
+	if r.Percent <= 0 || r.Percent > 100 {
+		return invalid("percent must be greater than 0 and at most 100")
+	}
+	if r.LatencyMS < 0 || r.MaxHits < 0 {
+		return invalid("latency_ms and max_hits must not be negative")
+	}
+	switch r.Effect {
+	case EffectLatency:
+		if r.LatencyMS == 0 {
+			return invalid("latency needs latency_ms")
+		}
+	case EffectTimeout:
+	case EffectError:
+		if !slices.Contains(r.errors(), r.Error) {
+			return invalid("%s error must be one of %s", r.Target, strings.Join(r.errors(), ", "))
+		}
+		return nil
+	default:
+		return invalid("effect must be %s, %s or %s", EffectLatency, EffectTimeout, EffectError)
+	}
+	if r.Error != "" {
+		return invalid("error applies to the error effect only")
+	}
+	return nil
+}
+
+// errors returns the error codes the rule's target accepts.
+func (r Rule) errors() []string {
+	if r.Target == TargetStore {
+		return []string{StoreErrNotFound, StoreErrValidation, StoreErrUnavailable}
+	}
+	return MinionErrors
+}
+
+// Latency returns the rule's delay.
+func (r Rule) Latency() time.Duration {
+	return time.Duration(r.LatencyMS) * time.Millisecond
+}
+
+// Call is a minion call or store operation faults may be injected into.
+type Call struct {
+	Target     string
+	Operation  string
+	Resource   string
+	Node       string
+	Deployment string
+	// Subject is free text that identifies what a minion call acts on, such
+	// as its arguments and container name. A rule's deployment also matches
+	// calls whose subject names it, since container names carry it.
+	Subject string
+}
+
+// Matches reports whether the rule applies to a call.
+func (r Rule) Matches(c Call) bool {
+	if r.Target != c.Target {
+		return false
+	}
+	if r.Operation != "" && r.Operation != c.Operation {
+		return false
+	}
+	if r.Resource != "" && r.Resource != c.Resource {
+		return false
+	}
+	if r.Node != "" && r.Node != c.Node {
+		return false
+	}
+	if r.Deployment != "" && r.Deployment != c.Deployment && !strings.Contains(c.Subject, r.Deployment) {
+		return false
+	}
+	return true
+}
+
+// Pick returns the first rule that matches a call and fires. roll returns a
+// number in [0, 1) and is drawn once per matching rule, so each rule fires
+// for its percent of the calls it matches.
+func Pick(rules []Rule, c Call, roll func() float64) (Rule, bool) {
+	for _, r := range rules {
+		if r.Matches(c) && roll()*100 < r.Percent {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Exhausted reports whether a rule has injected all the faults it may.
+func (r Rule) Exhausted() bool {
+	return r.MaxHits > 0 && r.Hits >= r.MaxHits
+}
+
+// TimeoutAfter returns how long a timeout fault hangs: the rule's latency,
+// or DefaultTimeout without one. A call's deadline ends it sooner.
+func (r Rule) TimeoutAfter() time.Duration {
+	if r.LatencyMS > 0 {
+		return r.Latency()
+	}
+	return DefaultTimeout
+}
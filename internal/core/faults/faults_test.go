@@ -0,0 +1,98 @@
+package faults
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Rule Tests
+// =============================================================================
+
+func TestRuleValidate(t *testing.T) {
+	valid := []Rule{
+		{Target: TargetMinion, Percent: 100, Effect: EffectLatency, LatencyMS: 500},
+		{Target: TargetMinion, Operation: "create-container", Node: "node_a", Percent: 25, Effect: EffectError, Error: "connection_failed"},
+		{Target: TargetMinion, Deployment: "depl_a", Percent: 50, Effect: EffectTimeout},
+		{Target: TargetStore, Operation: "update", Resource: "deployments", Percent: 10, Effect: EffectError, Error: StoreErrUnavailable},
+		{Target: TargetStore, Percent: 0.5, Effect: EffectTimeout, LatencyMS: 2000, MaxHits: 3},
+	}
+	for _, r := range valid {
+		assert.NoError(t, r.Validate(), "%+v", r)
+	}
+
+	invalid := []Rule{
+		{Target: "proxy", Percent: 100, Effect: EffectTimeout},
+		{Target: TargetMinion, Percent: 0, Effect: EffectTimeout},
+		{Target: TargetMinion, Percent: 101, Effect: EffectTimeout},
+		{Target: TargetMinion, Percent: 100, Effect: "crash"},
+		{Target: TargetMinion, Percent: 100, Effect: EffectLatency},
+		{Target: TargetMinion, Percent: 100, Effect: EffectLatency, LatencyMS: -1},
+		{Target: TargetMinion, Percent: 100, Effect: EffectTimeout, MaxHits: -1},
+		{Target: TargetMinion, Percent: 100, Effect: EffectError},
+		{Target: TargetMinion, Percent: 100, Effect: EffectError, Error: StoreErrUnavailable},
+		{Target: TargetMinion, Percent: 100, Effect: EffectTimeout, Error: "internal"},
+		{Target: TargetMinion, Resource: "deployments", Percent: 100, Effect: EffectTimeout},
+		{Target: TargetStore, Operation: "vacuum", Percent: 100, Effect: EffectTimeout},
+		{Target: TargetStore, Percent: 100, Effect: EffectError, Error: "connection_failed"},
+	}
+	for _, r := range invalid {
+		err := r.Validate()
+		assert.True(t, errors.Is(err, ErrInvalidRule), "%+v: %v", r, err)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	call := Call{Target: TargetMinion, Operation: "start-container", Node: "node_a", Subject: "hoster_depl_a_web"}
+
+	assert.True(t, Rule{Target: TargetMinion}.Matches(call))
+	assert.True(t, Rule{Target: TargetMinion, Operation: "start-container", Node: "node_a"}.Matches(call))
+	assert.True(t, Rule{Target: TargetMinion, Deployment: "depl_a"}.Matches(call), "subject names the deployment")
+	assert.False(t, Rule{Target: TargetStore}.Matches(call))
+	assert.False(t, Rule{Target: TargetMinion, Operation: "ping"}.Matches(call))
+	assert.False(t, Rule{Target: TargetMinion, Node: "node_b"}.Matches(call))
+	assert.False(t, Rule{Target: TargetMinion, Deployment: "depl_b"}.Matches(call))
+
+	op := Call{Target: TargetStore, Operation: "update", Resource: "deployments", Deployment: "depl_a"}
+	assert.True(t, Rule{Target: TargetStore, Resource: "deployments", Deployment: "depl_a"}.Matches(op))
+	assert.False(t, Rule{Target: TargetStore, Resource: "nodes"}.Matches(op))
+}
+
+func TestPick(t *testing.T) {
+	rules := []Rule{
+		{ID: "a", Target: TargetMinion, Node: "node_b", Percent: 100},
+		{ID: "b", Target: TargetMinion, Percent: 30},
+		{ID: "c", Target: TargetMinion, Percent: 100},
+	}
+	call := Call{Target: TargetMinion, Node: "node_a"}
+
+	rolls := []float64{0.1}
+	next := func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+	r, ok := Pick(rules, call, next)
+	assert.True(t, ok)
+	assert.Equal(t, "b", r.ID, "a roll under 30% fires b")
+
+	rolls = []float64{0.5, 0.99}
+	r, ok = Pick(rules, call, next)
+	assert.True(t, ok)
+	assert.Equal(t, "c", r.ID, "b misses, c always fires")
+
+	_, ok = Pick(rules[:2], call, func() float64 { return 0.3 })
+	assert.False(t, ok)
+}
+
+func TestRuleExhaustedAndTimeout(t *testing.T) {
+	assert.False(t, Rule{Hits: 10}.Exhausted())
+	assert.False(t, Rule{MaxHits: 2, Hits: 1}.Exhausted())
+	assert.True(t, Rule{MaxHits: 2, Hits: 2}.Exhausted())
+
+	assert.Equal(t, DefaultTimeout, Rule{}.TimeoutAfter())
+	assert.Equal(t, 1500*time.Millisecond, Rule{LatencyMS: 1500}.TimeoutAfter())
+}
@@ -0,0 +1,205 @@
+// Package features provides pure functions for plan feature flags: what a
+// plan lets its users do (custom domains, their own compose files, commands
+// run in containers) and how much (deployments, backup retention). Flags come
+// from the plan catalog and can be overridden for a single user.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package features
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// =============================================================================
+// Catalog
+// =============================================================================
+
+// Flag names a feature flag.
+type Flag string
+
+const (
+	CustomDomains      Flag = "custom_domains"       // Attach custom hostnames to deployments
+	ExecAccess         Flag = "exec_access"          // Run commands inside containers (command probes)
+	BYOCompose         Flag = "byo_compose"          // Create templates from one's own compose file
+	Autoscaling        Flag = "autoscaling"          // Scale services with load
+	MaxDeployments     Flag = "max_deployments"      // Deployments that are not deleted
+	MaxBackupRetention Flag = "max_backup_retention" // Days backups are kept
+)
+
+// Kind is how a flag's value is read.
+type Kind string
+
+const (
+	KindBool  Kind = "bool"  // On or off
+	KindLimit Kind = "limit" // A maximum; Unlimited for none
+)
+
+// Unlimited is the value of a limit flag without a maximum.
+const Unlimited int64 = -1
+
+// Definition describes a flag of the catalog.
+type Definition struct {
+	Flag        Flag   `json:"flag"`
+	Kind        Kind   `json:"kind"`
+	Description string `json:"description"`
+}
+
+// Catalog lists every flag, in display order.
+var Catalog = []Definition{
+	{CustomDomains, KindBool, "Attach custom domains to deployments"},
+	{ExecAccess, KindBool, "Run commands inside containers, such as command probes"},
+	{BYOCompose, KindBool, "Create templates from your own compose file"},
+	{Autoscaling, KindBool, "Scale services automatically with load"},
+	{MaxDeployments, KindLimit, "Maximum number of deployments"},
+	{MaxBackupRetention, KindLimit, "Maximum days backups are kept"},
+}
+
+// Lookup returns the catalog entry of a flag.
+func Lookup(f Flag) (Definition, bool) {
+	for _, d := range Catalog {
+		if d.Flag == f {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// =============================================================================
+// Sets
+// =============================================================================
+
+// ErrInvalidFlag is returned for unknown flags and values of the wrong kind.
+var ErrInvalidFlag = errors.New("invalid feature flag")
+
+// ErrNotInPlan is returned when the caller's plan does not include a feature.
+var ErrNotInPlan = errors.New("feature not included in plan")
+
+// Set holds flag values: 0 or 1 for bool flags, a maximum or Unlimited for
+// limit flags. A flag missing from a resolved set is not in the catalog.
+type Set map[Flag]int64
+
+// Enabled reports whether a bool flag is on, or a limit flag allows any use.
+func (s Set) Enabled(f Flag) bool {
+	return s[f] != 0
+}
+
+// Limit returns a limit flag's maximum; ok is false when it is Unlimited.
+func (s Set) Limit(f Flag) (n int64, ok bool) {
+	n = s[f]
+	return n, n != Unlimited
+}
+
+// Require returns an ErrNotInPlan error unless the flag is enabled.
+func (s Set) Require(f Flag) error {
+	if s.Enabled(f) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotInPlan, f)
+}
+
+// Attributes renders the set for API responses: bool flags as booleans,
+// limits as numbers and Unlimited as null.
+func (s Set) Attributes() map[string]any {
+	out := make(map[string]any, len(Catalog))
+	for _, d := range Catalog {
+		v, ok := s[d.Flag]
+		if !ok {
+			continue
+		}
+		switch {
+		case d.Kind == KindBool:
+			out[string(d.Flag)] = v != 0
+		case v == Unlimited:
+			out[string(d.Flag)] = nil
+		default:
+			out[string(d.Flag)] = v
+		}
+	}
+	return out
+}
+
+// Flags returns the set's flags in name order.
+func (s Set) Flags() []Flag {
+	out := make([]Flag, 0, len(s))
+	for f := range s {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Parse reads flag values from JSON: booleans for bool flags, non-negative
+// whole numbers or null (Unlimited) for limit flags.
+func Parse(raw map[string]any) (Set, error) {
+	s := make(Set, len(raw))
+	for name, v := range raw {
+		d, ok := Lookup(Flag(name))
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown flag %q", ErrInvalidFlag, name)
+		}
+		n, err := parseValue(d, v)
+		if err != nil {
+			return nil, err
+		}
+		s[d.Flag] = n
+	}
+	return s, nil
+}
+
+func parseValue(d Definition, v any) (int64, error) {
+	if d.Kind == KindBool {
+		b, ok := v.(bool)
+		if !ok {
+			return 0, fmt.Errorf("%w: %s must be true or false", ErrInvalidFlag, d.Flag)
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if v == nil {
+		return Unlimited, nil
+	}
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	default:
+		return 0, fmt.Errorf("%w: %s must be a number or null", ErrInvalidFlag, d.Flag)
+	}
+	if f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %s must be a whole number of at least 0", ErrInvalidFlag, d.Flag)
+	}
+	return int64(f), nil
+}
+
+// =============================================================================
+// Resolution
+// =============================================================================
+
+// Resolve returns the effective flags of a user: the override where there is
+// one, else the plan's value. A flag the plan does not mention is allowed
+// (on, or Unlimited), so plans that predate a flag keep what they could do.
+func Resolve(plan, overrides Set) Set {
+	s := make(Set, len(Catalog))
+	for _, d := range Catalog {
+		v, ok := overrides[d.Flag]
+		if !ok {
+			v, ok = plan[d.Flag]
+		}
+		if !ok {
+			v = 1
+			if d.Kind == KindLimit {
+				v = Unlimited
+			}
+		}
+		s[d.Flag] = v
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package features
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Parse Tests
+// =============================================================================
+
+func TestParse(t *testing.T) {
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{"custom_domains": true, "exec_access": false, "max_deployments": 5, "max_backup_retention": null}`), &raw))
+
+	s, err := Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, Set{CustomDomains: 1, ExecAccess: 0, MaxDeployments: 5, MaxBackupRetention: Unlimited}, s)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	bad := []map[string]any{
+		{"teleportation": true},
+		{"custom_domains": 1},
+		{"max_deployments": true},
+		{"max_deployments": -1.0},
+		{"max_deployments": 2.5},
+		{"max_deployments": "5"},
+	}
+	for _, raw := range bad {
+		_, err := Parse(raw)
+		assert.ErrorIs(t, err, ErrInvalidFlag, "%v", raw)
+	}
+}
+
+// =============================================================================
+// Resolve Tests
+// =============================================================================
+
+func TestResolve(t *testing.T) {
+	plan := Set{CustomDomains: 0, BYOCompose: 1, MaxDeployments: 1}
+	overrides := Set{CustomDomains: 1, MaxDeployments: 10}
+
+	s := Resolve(plan, overrides)
+	assert.Len(t, s, len(Catalog))
+	assert.True(t, s.Enabled(CustomDomains), "override wins")
+	assert.True(t, s.Enabled(BYOCompose))
+	assert.True(t, s.Enabled(ExecAccess), "flags the plan does not mention are allowed")
+
+	n, limited := s.Limit(MaxDeployments)
+	assert.True(t, limited)
+	assert.Equal(t, int64(10), n)
+	_, limited = s.Limit(MaxBackupRetention)
+	assert.False(t, limited)
+}
+
+func TestResolve_OverrideRevokes(t *testing.T) {
+	s := Resolve(Set{ExecAccess: 1}, Set{ExecAccess: 0})
+	assert.False(t, s.Enabled(ExecAccess))
+	assert.ErrorIs(t, s.Require(ExecAccess), ErrNotInPlan)
+	assert.NoError(t, s.Require(CustomDomains))
+}
+
+func TestSet_Attributes(t *testing.T) {
+	s := Resolve(Set{CustomDomains: 0, MaxDeployments: 3}, nil)
+	attrs := s.Attributes()
+	assert.Equal(t, false, attrs["custom_domains"])
+	assert.Equal(t, true, attrs["autoscaling"])
+	assert.Equal(t, int64(3), attrs["max_deployments"])
+	v, ok := attrs["max_backup_retention"]
+	assert.True(t, ok)
+	assert.Nil(t, v)
+}
+
+func TestLookup(t *testing.T) {
+	d, ok := Lookup(MaxDeployments)
+	require.True(t, ok)
+	assert.Equal(t, KindLimit, d.Kind)
+
+	_, ok = Lookup("nope")
+	assert.False(t, ok)
+}
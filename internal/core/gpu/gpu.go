@@ -0,0 +1,222 @@
+// Package gpu provides pure functions for GPU nodes: parsing the device
+// inventory nvidia-smi reports, assigning devices to a deployment's services
+// without handing one device to two deployments, and the GPU-hours a
+// deployment accrues while it holds devices.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Inventory
+// =============================================================================
+
+// QueryFields are the nvidia-smi --query-gpu fields ParseNvidiaSMI expects,
+// in order. Run with --format=csv,noheader,nounits.
+const QueryFields = "index,uuid,name,memory.total,memory.used,utilization.gpu,driver_version"
+
+// ParseNvidiaSMI parses nvidia-smi CSV output for QueryFields into the
+// driver version and one device per line. Fields nvidia-smi reports as
+// "[N/A]" are left zero.
+func ParseNvidiaSMI(out string) (string, []minion.GPUDevice, error) {
+	var driver string
+	devices := []minion.GPUDevice{}
+	for n, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 7 {
+			return "", nil, fmt.Errorf("line %d: expected 7 fields, got %d", n+1, len(fields))
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return "", nil, fmt.Errorf("line %d: invalid index %q", n+1, fields[0])
+		}
+		if fields[1] == "" {
+			return "", nil, fmt.Errorf("line %d: missing uuid", n+1)
+		}
+		devices = append(devices, minion.GPUDevice{
+			Index:          index,
+			UUID:           fields[1],
+			Model:          fields[2],
+			MemoryMB:       parseInt(fields[3]),
+			MemoryUsedMB:   parseInt(fields[4]),
+			UtilizationPct: parseFloat(fields[5]),
+		})
+		driver = fields[6]
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Index < devices[j].Index })
+	return driver, devices, nil
+}
+
+func parseInt(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// =============================================================================
+// Assignment
+// =============================================================================
+
+// All is the demand of a service that asks for every GPU on its node
+// (compose count: all).
+const All = -1
+
+// ErrOversubscribed is returned when a deployment asks for more GPUs than
+// its node has free.
+var ErrOversubscribed = errors.New("not enough free GPUs")
+
+// Demand returns the GPUs each service of a spec asks for, leaving out
+// services that ask for none.
+func Demand(spec *compose.ParsedSpec) map[string]int {
+	demand := map[string]int{}
+	if spec == nil {
+		return demand
+	}
+	for _, svc := range spec.Services {
+		if svc.Resources.GPUs != 0 {
+			demand[svc.Name] = svc.Resources.GPUs
+		}
+	}
+	return demand
+}
+
+// Assign picks devices from a node's inventory for each service's demand,
+// skipping devices in held (UUID -> deployment holding it). Devices are
+// handed out by index and never to two services.
+//
+// A service listed in keep with as many devices as it asks for must get
+// exactly those devices again: its containers already exist, and their
+// device requests were fixed when they were created.
+func Assign(inventory []minion.GPUDevice, held map[string]string, demand map[string]int, keep map[string][]string) (map[string][]string, error) {
+	assigned := map[string][]string{}
+	if len(demand) == 0 {
+		return assigned, nil
+	}
+	if len(inventory) == 0 {
+		return nil, fmt.Errorf("%w: node has no GPUs", ErrOversubscribed)
+	}
+
+	taken := map[string]bool{}
+	for _, d := range inventory {
+		if held[d.UUID] != "" {
+			taken[d.UUID] = true
+		}
+	}
+
+	// Services keeping their devices go first, so new picks avoid them
+	services := make([]string, 0, len(demand))
+	for name := range demand {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	for _, name := range services {
+		ids, ok := keep[name]
+		if !ok || len(ids) != want(demand[name], inventory) {
+			continue
+		}
+		for _, id := range ids {
+			if holder := held[id]; holder != "" {
+				return nil, fmt.Errorf("%w: service %s needs GPU %s, held by deployment %s", ErrOversubscribed, name, id, holder)
+			}
+			if !inInventory(inventory, id) {
+				return nil, fmt.Errorf("%w: service %s needs GPU %s, which the node no longer reports", ErrOversubscribed, name, id)
+			}
+			taken[id] = true
+		}
+		assigned[name] = ids
+	}
+
+	for _, name := range services {
+		if _, ok := assigned[name]; ok {
+			continue
+		}
+		n := want(demand[name], inventory)
+		var picked []string
+		for _, d := range inventory {
+			if len(picked) == n {
+				break
+			}
+			if !taken[d.UUID] {
+				picked = append(picked, d.UUID)
+			}
+		}
+		if len(picked) < n {
+			return nil, fmt.Errorf("%w: service %s asks for %d, %d of %d free", ErrOversubscribed, name, n, len(picked), len(inventory))
+		}
+		for _, id := range picked {
+			taken[id] = true
+		}
+		assigned[name] = picked
+	}
+	return assigned, nil
+}
+
+// want returns the number of devices a demand asks for on a node.
+func want(demand int, inventory []minion.GPUDevice) int {
+	if demand == All {
+		return len(inventory)
+	}
+	return demand
+}
+
+func inInventory(inventory []minion.GPUDevice, uuid string) bool {
+	for _, d := range inventory {
+		if d.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of devices in an assignment.
+func Count(assigned map[string][]string) int {
+	n := 0
+	for _, ids := range assigned {
+		n += len(ids)
+	}
+	return n
+}
+
+// =============================================================================
+// Metering
+// =============================================================================
+
+// Hours returns the GPU-hours devices GPUs accrued from since to now, and
+// the time metering continues from. Periodic metering bills whole hours
+// only and carries the remainder; the final metering, when the devices are
+// released, bills a started hour as a whole one.
+func Hours(devices int, since, now time.Time, final bool) (int64, time.Time) {
+	elapsed := now.Sub(since)
+	if devices <= 0 || elapsed <= 0 {
+		return 0, since
+	}
+	hours := int64(elapsed / time.Hour)
+	if final {
+		if elapsed%time.Hour > 0 {
+			hours++
+		}
+		return hours * int64(devices), now
+	}
+	return hours * int64(devices), since.Add(time.Duration(hours) * time.Hour)
+}
@@ -0,0 +1,123 @@
+package gpu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNvidiaSMI(t *testing.T) {
+	out := `1, GPU-bbbb, NVIDIA A100-SXM4-40GB, 40960, 1024, 37, 535.104.05
+0, GPU-aaaa, NVIDIA A100-SXM4-40GB, 40960, [N/A], [N/A], 535.104.05
+`
+	driver, devices, err := ParseNvidiaSMI(out)
+	require.NoError(t, err)
+	assert.Equal(t, "535.104.05", driver)
+	require.Len(t, devices, 2)
+	assert.Equal(t, minion.GPUDevice{Index: 0, UUID: "GPU-aaaa", Model: "NVIDIA A100-SXM4-40GB", MemoryMB: 40960}, devices[0])
+	assert.Equal(t, int64(1024), devices[1].MemoryUsedMB)
+	assert.Equal(t, 37.0, devices[1].UtilizationPct)
+
+	_, devices, err = ParseNvidiaSMI("")
+	require.NoError(t, err)
+	assert.Empty(t, devices)
+
+	_, _, err = ParseNvidiaSMI("NVIDIA-SMI has failed because it couldn't communicate with the NVIDIA driver")
+	assert.Error(t, err)
+}
+
+// =============================================================================
+// Assignment Tests
+// =============================================================================
+
+func inventory(n int) []minion.GPUDevice {
+	var devices []minion.GPUDevice
+	for i := 0; i < n; i++ {
+		devices = append(devices, minion.GPUDevice{Index: i, UUID: "GPU-" + string(rune('a'+i))})
+	}
+	return devices
+}
+
+func TestDemand(t *testing.T) {
+	spec := &compose.ParsedSpec{Services: []compose.Service{
+		{Name: "web"},
+		{Name: "train", Resources: compose.ServiceResources{GPUs: 2}},
+		{Name: "infer", Resources: compose.ServiceResources{GPUs: All}},
+	}}
+	assert.Equal(t, map[string]int{"train": 2, "infer": All}, Demand(spec))
+	assert.Empty(t, Demand(nil))
+}
+
+func TestAssign(t *testing.T) {
+	got, err := Assign(inventory(4), map[string]string{"GPU-a": "depl-1"}, map[string]int{"infer": 1, "train": 2}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GPU-b"}, got["infer"])
+	assert.Equal(t, []string{"GPU-c", "GPU-d"}, got["train"])
+	assert.Equal(t, 3, Count(got))
+}
+
+func TestAssign_Oversubscribed(t *testing.T) {
+	_, err := Assign(inventory(2), map[string]string{"GPU-a": "depl-1"}, map[string]int{"train": 2}, nil)
+	assert.ErrorIs(t, err, ErrOversubscribed)
+
+	_, err = Assign(inventory(2), map[string]string{"GPU-b": "depl-1"}, map[string]int{"train": All}, nil)
+	assert.ErrorIs(t, err, ErrOversubscribed, "all needs every device free")
+
+	_, err = Assign(nil, nil, map[string]int{"train": 1}, nil)
+	assert.ErrorIs(t, err, ErrOversubscribed)
+}
+
+func TestAssign_NoDemand(t *testing.T) {
+	got, err := Assign(nil, nil, map[string]int{}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestAssign_Keep(t *testing.T) {
+	keep := map[string][]string{"train": {"GPU-c"}}
+	got, err := Assign(inventory(3), nil, map[string]int{"infer": 1, "train": 1}, keep)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GPU-c"}, got["train"])
+	assert.Equal(t, []string{"GPU-a"}, got["infer"])
+
+	_, err = Assign(inventory(3), map[string]string{"GPU-c": "depl-2"}, map[string]int{"train": 1}, keep)
+	assert.ErrorIs(t, err, ErrOversubscribed)
+	assert.Contains(t, err.Error(), "depl-2")
+
+	_, err = Assign(inventory(2), nil, map[string]int{"train": 1}, keep)
+	assert.ErrorIs(t, err, ErrOversubscribed, "kept device is gone from the node")
+
+	got, err = Assign(inventory(3), nil, map[string]int{"train": 2}, keep)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GPU-a", "GPU-b"}, got["train"], "a changed demand is assigned afresh")
+}
+
+// =============================================================================
+// Metering Tests
+// =============================================================================
+
+func TestHours(t *testing.T) {
+	since := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	hours, next := Hours(2, since, since.Add(150*time.Minute), false)
+	assert.Equal(t, int64(4), hours)
+	assert.Equal(t, since.Add(2*time.Hour), next, "the partial hour carries over")
+
+	hours, next = Hours(2, since, since.Add(30*time.Minute), false)
+	assert.Zero(t, hours)
+	assert.Equal(t, since, next)
+
+	end := since.Add(150 * time.Minute)
+	hours, next = Hours(2, since, end, true)
+	assert.Equal(t, int64(6), hours, "the final started hour is billed whole")
+	assert.Equal(t, end, next)
+
+	hours, _ = Hours(0, since, end, true)
+	assert.Zero(t, hours)
+	hours, _ = Hours(1, end, since, true)
+	assert.Zero(t, hours)
+}
@@ -0,0 +1,121 @@
+// Package graphql provides pure functions for hoster's GraphQL API: naming
+// the types and fields generated from the engine's resources, and measuring
+// how deeply a query nests.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package graphql
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// =============================================================================
+// Limits
+// =============================================================================
+
+// MaxDepth is the deepest a query may nest its fields. Every relation adds
+// a level, and every list of a relation a level more for its items.
+const MaxDepth = 10
+
+// MaxResolves is the most resources and lists one request may read or
+// write.
+const MaxResolves = 1000
+
+// =============================================================================
+// Naming
+// =============================================================================
+
+// Singular names one row of a resource: "deployments" → "deployment".
+func Singular(resource string) string {
+	return strings.TrimSuffix(resource, "s")
+}
+
+// TypeName names a resource's object type: "cloud_credentials" →
+// "CloudCredential".
+func TypeName(resource string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(Singular(resource), "_") {
+		if word == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]))
+		b.WriteString(word[1:])
+	}
+	return b.String()
+}
+
+// RelationName names the field that follows a reference field to the row
+// it references: "template_id" → "template". References not named for
+// their target add it: "colocate_with" → "colocate_with_deployment".
+func RelationName(field, target string) string {
+	if name, ok := strings.CutSuffix(field, "_id"); ok {
+		return name
+	}
+	return field + "_" + Singular(target)
+}
+
+// InverseName names the field that lists the rows of resource referencing
+// a row through field: "deployments". When several of resource's fields
+// reference the same resource, each inverse is qualified by its relation:
+// "deployments_by_colocate_with_deployment".
+func InverseName(resource, field, target string, shared bool) string {
+	if !shared {
+		return resource
+	}
+	return resource + "_by_" + RelationName(field, target)
+}
+
+// =============================================================================
+// Depth
+// =============================================================================
+
+// Depth returns how deeply the operations of a document nest their fields,
+// following fragments. Introspection fields (__schema, __type, ...) don't
+// count, so schema tooling isn't limited.
+func Depth(doc *ast.Document) int {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok && f.Name != nil {
+			fragments[f.Name.Value] = f
+		}
+	}
+	deepest := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			deepest = max(deepest, selectionDepth(op.SelectionSet, fragments, map[string]bool{}))
+		}
+	}
+	return deepest
+}
+
+func selectionDepth(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, visiting map[string]bool) int {
+	if set == nil {
+		return 0
+	}
+	deepest := 0
+	for _, sel := range set.Selections {
+		switch s := sel.(type) {
+		case *ast.Field:
+			if s.Name == nil || strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			deepest = max(deepest, 1+selectionDepth(s.SelectionSet, fragments, visiting))
+		case *ast.InlineFragment:
+			deepest = max(deepest, selectionDepth(s.SelectionSet, fragments, visiting))
+		case *ast.FragmentSpread:
+			if s.Name == nil {
+				continue
+			}
+			// Cyclic fragments are rejected by validation; stop at the cycle
+			f, ok := fragments[s.Name.Value]
+			if !ok || visiting[s.Name.Value] {
+				continue
+			}
+			visiting[s.Name.Value] = true
+			deepest = max(deepest, selectionDepth(f.SelectionSet, fragments, visiting))
+			delete(visiting, s.Name.Value)
+		}
+	}
+	return deepest
+}
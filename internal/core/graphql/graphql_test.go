@@ -0,0 +1,63 @@
+package graphql
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Naming Tests
+// =============================================================================
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "Deployment", TypeName("deployments"))
+	assert.Equal(t, "CloudCredential", TypeName("cloud_credentials"))
+	assert.Equal(t, "NodeCost", TypeName("node_costs"))
+}
+
+func TestRelationName(t *testing.T) {
+	assert.Equal(t, "template", RelationName("template_id", "templates"))
+	assert.Equal(t, "colocate_with_deployment", RelationName("colocate_with", "deployments"))
+}
+
+func TestInverseName(t *testing.T) {
+	assert.Equal(t, "deployments", InverseName("deployments", "template_id", "templates", false))
+	assert.Equal(t, "deployments_by_node", InverseName("deployments", "node_id", "nodes", true))
+}
+
+// =============================================================================
+// Depth Tests
+// =============================================================================
+
+func depthOf(t *testing.T, query string) int {
+	t.Helper()
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	require.NoError(t, err)
+	return Depth(doc)
+}
+
+func TestDepth(t *testing.T) {
+	assert.Equal(t, 1, depthOf(t, `{ deployment(id: "d") }`))
+	assert.Equal(t, 4, depthOf(t, `{ templates { items { deployments { items } } } }`))
+	assert.Equal(t, 2, depthOf(t, `query A { a { b } } query B { c }`))
+}
+
+func TestDepth_Fragments(t *testing.T) {
+	assert.Equal(t, 3, depthOf(t, `
+		{ templates { ...T } }
+		fragment T on TemplateList { items { name } }`))
+	assert.Equal(t, 3, depthOf(t, `{ templates { ... on TemplateList { items { name } } } }`))
+}
+
+func TestDepth_CyclicFragments(t *testing.T) {
+	assert.Equal(t, 2, depthOf(t, `
+		{ a { ...A } }
+		fragment A on X { b ...A }`))
+}
+
+func TestDepth_IgnoresIntrospection(t *testing.T) {
+	assert.Equal(t, 1, depthOf(t, `{ __schema { types { fields { type { ofType { name } } } } } deployments }`))
+}
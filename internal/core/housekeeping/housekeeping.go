@@ -0,0 +1,263 @@
+// Package housekeeping provides pure functions for scheduled node maintenance:
+// which cleanup tasks exist, validating a node's housekeeping policy, deciding
+// when the next run is due, and turning a policy into minion options.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package housekeeping
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Tasks
+// =============================================================================
+
+// Task is one kind of cleanup performed on a node.
+type Task string
+
+const (
+	// TaskContainers removes stopped containers not managed by hoster.
+	TaskContainers Task = "containers"
+	// TaskImages removes dangling (or, with AllImages, all unused) images.
+	TaskImages Task = "images"
+	// TaskBuildCache removes unused build cache.
+	TaskBuildCache Task = "build_cache"
+	// TaskNetworks removes unused networks not managed by hoster.
+	TaskNetworks Task = "networks"
+	// TaskContainerLogs enforces json-file log rotation in the docker daemon
+	// config and truncates oversized logs of containers created without it.
+	TaskContainerLogs Task = "container_logs"
+	// TaskJournal vacuums the systemd journal down to JournalMaxSize.
+	TaskJournal Task = "journal"
+	// TaskTmp removes stale files from /tmp and /var/tmp.
+	TaskTmp Task = "tmp"
+)
+
+// AllTasks lists every task in the order the minion runs them.
+var AllTasks = []Task{TaskContainers, TaskImages, TaskBuildCache, TaskNetworks, TaskContainerLogs, TaskJournal, TaskTmp}
+
+// Valid reports whether t is a known task.
+func (t Task) Valid() bool {
+	for _, k := range AllTasks {
+		if t == k {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Runs
+// =============================================================================
+
+// RunStatus is the lifecycle state of a housekeeping run.
+type RunStatus string
+
+const (
+	RunPending   RunStatus = "pending"
+	RunRunning   RunStatus = "running"
+	RunCompleted RunStatus = "completed"
+	RunFailed    RunStatus = "failed"
+)
+
+// Trigger records why a run happened.
+type Trigger string
+
+const (
+	TriggerSchedule Trigger = "schedule"
+	TriggerManual   Trigger = "manual"
+)
+
+// =============================================================================
+// Policy
+// =============================================================================
+
+// Defaults applied to unset policy fields.
+const (
+	DefaultSchedule       = "0 4 * * 0" // Sundays 04:00 in the node creator's time zone
+	DefaultPruneUntil     = "24h"
+	DefaultLogMaxSize     = "50m"
+	DefaultLogMaxFiles    = 3
+	DefaultJournalMaxSize = "500m"
+	DefaultTmpMaxAge      = "168h"
+)
+
+// Bounds that keep a policy from doing more harm than good.
+const (
+	MinLogMaxSize     = 1 << 20 // 1 MiB
+	MaxLogMaxFiles    = 20
+	MinJournalMaxSize = 64 << 20 // 64 MiB
+	MinTmpMaxAge      = time.Hour
+)
+
+// ErrInvalidPolicy is returned for housekeeping policies that fail validation.
+var ErrInvalidPolicy = errors.New("invalid housekeeping policy")
+
+// Policy is a node's housekeeping configuration, set by the node creator.
+// Empty fields take the Default* values.
+type Policy struct {
+	Enabled        bool   `json:"enabled"`
+	Schedule       string `json:"schedule,omitempty"`         // 5-field cron expression, creator's time zone
+	Tasks          []Task `json:"tasks,omitempty"`            // Tasks to run (default all)
+	PruneUntil     string `json:"prune_until,omitempty"`      // Only prune objects older than this duration
+	AllImages      bool   `json:"all_images,omitempty"`       // Prune all unused images, not just dangling ones
+	LogMaxSize     string `json:"log_max_size,omitempty"`     // Size per container log file, e.g. "50m"
+	LogMaxFiles    int    `json:"log_max_files,omitempty"`    // Rotated container log files kept
+	JournalMaxSize string `json:"journal_max_size,omitempty"` // Journal size after vacuuming, e.g. "500m"
+	TmpMaxAge      string `json:"tmp_max_age,omitempty"`      // Remove tmp files not modified for this long
+}
+
+// WithDefaults returns p with empty fields filled in.
+func (p Policy) WithDefaults() Policy {
+	if p.Schedule == "" {
+		p.Schedule = DefaultSchedule
+	}
+	if len(p.Tasks) == 0 {
+		p.Tasks = append([]Task(nil), AllTasks...)
+	}
+	if p.PruneUntil == "" {
+		p.PruneUntil = DefaultPruneUntil
+	}
+	if p.LogMaxSize == "" {
+		p.LogMaxSize = DefaultLogMaxSize
+	}
+	if p.LogMaxFiles == 0 {
+		p.LogMaxFiles = DefaultLogMaxFiles
+	}
+	if p.JournalMaxSize == "" {
+		p.JournalMaxSize = DefaultJournalMaxSize
+	}
+	if p.TmpMaxAge == "" {
+		p.TmpMaxAge = DefaultTmpMaxAge
+	}
+	return p
+}
+
+// Validate checks a policy after applying defaults.
+func Validate(p Policy) error {
+	p = p.WithDefaults()
+	if err := deployment.ValidateSchedule(p.Schedule); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if _, err := ParseTasks(p.Tasks); err != nil {
+		return err
+	}
+	if d, err := time.ParseDuration(p.PruneUntil); err != nil || d < 0 {
+		return fmt.Errorf("%w: prune_until %q must be a non-negative duration", ErrInvalidPolicy, p.PruneUntil)
+	}
+	if n, err := ParseSize(p.LogMaxSize); err != nil || n < MinLogMaxSize {
+		return fmt.Errorf("%w: log_max_size %q must be at least 1m", ErrInvalidPolicy, p.LogMaxSize)
+	}
+	if p.LogMaxFiles < 1 || p.LogMaxFiles > MaxLogMaxFiles {
+		return fmt.Errorf("%w: log_max_files must be between 1 and %d", ErrInvalidPolicy, MaxLogMaxFiles)
+	}
+	if n, err := ParseSize(p.JournalMaxSize); err != nil || n < MinJournalMaxSize {
+		return fmt.Errorf("%w: journal_max_size %q must be at least 64m", ErrInvalidPolicy, p.JournalMaxSize)
+	}
+	if d, err := time.ParseDuration(p.TmpMaxAge); err != nil || d < MinTmpMaxAge {
+		return fmt.Errorf("%w: tmp_max_age %q must be a duration of at least %s", ErrInvalidPolicy, p.TmpMaxAge, MinTmpMaxAge)
+	}
+	return nil
+}
+
+// ParseTasks validates a task list, dropping duplicates and returning the
+// tasks in AllTasks order. An empty list means every task.
+func ParseTasks(tasks []Task) ([]Task, error) {
+	if len(tasks) == 0 {
+		return append([]Task(nil), AllTasks...), nil
+	}
+	want := make(map[Task]bool, len(tasks))
+	for _, t := range tasks {
+		if !t.Valid() {
+			return nil, fmt.Errorf("%w: unknown task %q", ErrInvalidPolicy, t)
+		}
+		want[t] = true
+	}
+	var out []Task
+	for _, t := range AllTasks {
+		if want[t] {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+)([kmg]?)b?$`)
+
+// ParseSize parses sizes like "512k", "50m" or "2g" (case-insensitive,
+// binary units) into bytes. A bare number is bytes.
+func ParseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	shift := map[string]uint{"": 0, "k": 10, "m": 20, "g": 30}[m[2]]
+	if n > (1<<62)>>shift {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return n << shift, nil
+}
+
+// =============================================================================
+// Scheduling
+// =============================================================================
+
+// NextRun returns when a policy next runs strictly after t, reading its
+// schedule in loc (UTC if nil). The boolean is false for disabled or
+// invalid policies.
+func NextRun(p Policy, t time.Time, loc *time.Location) (time.Time, bool) {
+	if !p.Enabled {
+		return time.Time{}, false
+	}
+	return deployment.NextScheduleRun(p.WithDefaults().Schedule, t, loc)
+}
+
+// Due reports whether a scheduled run should start at now, given the time of
+// the last scheduled run (or when scheduling began, if none has run yet).
+func Due(p Policy, last, now time.Time, loc *time.Location) bool {
+	next, ok := NextRun(p, last, loc)
+	return ok && !next.After(now)
+}
+
+// =============================================================================
+// Minion Options
+// =============================================================================
+
+// Options converts a policy into minion options. tasks overrides the policy's
+// task list when non-empty. p must have passed Validate.
+func Options(p Policy, tasks []Task, dryRun bool) minion.HousekeepingOptions {
+	p = p.WithDefaults()
+	if len(tasks) == 0 {
+		tasks = p.Tasks
+	}
+	tasks, _ = ParseTasks(tasks)
+	names := make([]string, len(tasks))
+	for i, t := range tasks {
+		names[i] = string(t)
+	}
+	logSize, _ := ParseSize(p.LogMaxSize)
+	journalSize, _ := ParseSize(p.JournalMaxSize)
+	tmpAge, _ := time.ParseDuration(p.TmpMaxAge)
+	return minion.HousekeepingOptions{
+		DryRun:           dryRun,
+		Tasks:            names,
+		PruneUntil:       p.PruneUntil,
+		AllImages:        p.AllImages,
+		LogMaxSizeBytes:  logSize,
+		LogMaxFiles:      p.LogMaxFiles,
+		JournalMaxBytes:  journalSize,
+		TmpMaxAgeSeconds: int64(tmpAge / time.Second),
+	}
+}
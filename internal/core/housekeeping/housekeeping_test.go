@@ -0,0 +1,115 @@
+package housekeeping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Task Tests
+// =============================================================================
+
+func TestParseTasks(t *testing.T) {
+	all, err := ParseTasks(nil)
+	require.NoError(t, err)
+	assert.Equal(t, AllTasks, all)
+
+	tasks, err := ParseTasks([]Task{TaskTmp, TaskImages, TaskTmp})
+	require.NoError(t, err)
+	assert.Equal(t, []Task{TaskImages, TaskTmp}, tasks)
+
+	_, err = ParseTasks([]Task{"volumes"})
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+}
+
+// =============================================================================
+// Policy Tests
+// =============================================================================
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"1024": 1024, "512k": 512 << 10, "50m": 50 << 20, "2G": 2 << 30, "10mb": 10 << 20}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "m", "-5m", "1.5g", "10t", "99999999999g"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(Policy{}))
+	assert.NoError(t, Validate(Policy{Enabled: true, Schedule: "30 2 * * *", Tasks: []Task{TaskImages}, PruneUntil: "0s", LogMaxSize: "10m", LogMaxFiles: 5, JournalMaxSize: "1g", TmpMaxAge: "24h"}))
+
+	bad := []Policy{
+		{Schedule: "daily"},
+		{Tasks: []Task{"volumes"}},
+		{PruneUntil: "-1h"},
+		{PruneUntil: "a day"},
+		{LogMaxSize: "100k"},
+		{LogMaxFiles: 21},
+		{LogMaxFiles: -1},
+		{JournalMaxSize: "10m"},
+		{TmpMaxAge: "30m"},
+	}
+	for _, p := range bad {
+		assert.ErrorIs(t, Validate(p), ErrInvalidPolicy, "%+v", p)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	p := Policy{Enabled: true, LogMaxFiles: 7}.WithDefaults()
+	assert.Equal(t, DefaultSchedule, p.Schedule)
+	assert.Equal(t, AllTasks, p.Tasks)
+	assert.Equal(t, 7, p.LogMaxFiles)
+	assert.Equal(t, DefaultTmpMaxAge, p.TmpMaxAge)
+}
+
+// =============================================================================
+// Scheduling Tests
+// =============================================================================
+
+func TestNextRunAndDue(t *testing.T) {
+	p := Policy{Enabled: true, Schedule: "0 3 * * *"}
+	last := time.Date(2026, 5, 1, 3, 0, 0, 0, time.UTC)
+
+	next, ok := NextRun(p, last, nil)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 5, 2, 3, 0, 0, 0, time.UTC), next)
+
+	assert.False(t, Due(p, last, next.Add(-time.Minute), nil))
+	assert.True(t, Due(p, last, next, nil))
+	assert.True(t, Due(p, last, next.Add(6*time.Hour), nil))
+
+	p.Enabled = false
+	_, ok = NextRun(p, last, nil)
+	assert.False(t, ok)
+	assert.False(t, Due(p, last, next, nil))
+}
+
+// =============================================================================
+// Options Tests
+// =============================================================================
+
+func TestOptions(t *testing.T) {
+	p := Policy{Tasks: []Task{TaskImages, TaskTmp}, AllImages: true, TmpMaxAge: "48h"}
+
+	opts := Options(p, nil, true)
+	assert.True(t, opts.DryRun)
+	assert.Equal(t, []string{"images", "tmp"}, opts.Tasks)
+	assert.True(t, opts.AllImages)
+	assert.Equal(t, DefaultPruneUntil, opts.PruneUntil)
+	assert.Equal(t, int64(50<<20), opts.LogMaxSizeBytes)
+	assert.Equal(t, DefaultLogMaxFiles, opts.LogMaxFiles)
+	assert.Equal(t, int64(500<<20), opts.JournalMaxBytes)
+	assert.Equal(t, int64(48*3600), opts.TmpMaxAgeSeconds)
+
+	opts = Options(p, []Task{TaskJournal}, false)
+	assert.False(t, opts.DryRun)
+	assert.Equal(t, []string{"journal"}, opts.Tasks)
+}
@@ -0,0 +1,118 @@
+// Package idempotency provides pure functions for Idempotency-Key handling:
+// key validation, request fingerprinting, and deciding whether a request
+// should run, be replayed from a stored response, or be rejected.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// Keys
+// =============================================================================
+
+const (
+	// HeaderKey is the request header carrying the client's idempotency key.
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed is set on responses served from a stored result.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// DefaultTTL is how long a key and its response are kept.
+	DefaultTTL = 24 * time.Hour
+	// LockTimeout is how long an in-progress key blocks retries. After this a
+	// request whose original never completed (e.g. server crash) may run again.
+	LockTimeout = 5 * time.Minute
+	// MaxKeyLength bounds the key size.
+	MaxKeyLength = 255
+)
+
+// ErrInvalidKey is returned for malformed idempotency keys.
+var ErrInvalidKey = errors.New("invalid idempotency key")
+
+// ValidateKey checks that a key is 1-255 printable ASCII characters without spaces.
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: must not be empty", ErrInvalidKey)
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: must be at most %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return fmt.Errorf("%w: must be printable ASCII without spaces", ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// Fingerprint identifies a request so a key reused with a different request
+// can be detected. It covers the method, path, and body.
+func Fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// =============================================================================
+// Decisions
+// =============================================================================
+
+// Record is the stored state of a key.
+type Record struct {
+	Fingerprint string
+	StatusCode  int // 0 while the original request is still running
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// InProgress reports whether the original request has not completed.
+func (r Record) InProgress() bool {
+	return r.StatusCode == 0
+}
+
+// Outcome is what to do with an incoming request carrying a key.
+type Outcome string
+
+const (
+	// OutcomeProceed runs the request (new, expired, or abandoned key).
+	OutcomeProceed Outcome = "proceed"
+	// OutcomeReplay returns the stored response without running the request.
+	OutcomeReplay Outcome = "replay"
+	// OutcomeMismatch rejects a key reused for a different request.
+	OutcomeMismatch Outcome = "mismatch"
+	// OutcomeInProgress rejects a retry while the original is still running.
+	OutcomeInProgress Outcome = "in_progress"
+)
+
+// Evaluate decides what to do with a request given the key's existing record
+// (nil if the key is unused).
+func Evaluate(rec *Record, fingerprint string, now time.Time) Outcome {
+	if rec == nil || !now.Before(rec.ExpiresAt) {
+		return OutcomeProceed
+	}
+	if rec.Fingerprint != fingerprint {
+		return OutcomeMismatch
+	}
+	if rec.InProgress() {
+		if now.Sub(rec.CreatedAt) >= LockTimeout {
+			return OutcomeProceed
+		}
+		return OutcomeInProgress
+	}
+	return OutcomeReplay
+}
+
+// ShouldStore reports whether a response should be saved for replay.
+// Server errors are not stored so the client can retry them.
+func ShouldStore(statusCode int) bool {
+	return statusCode > 0 && statusCode < 500
+}
@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestValidateKey(t *testing.T) {
+	assert.NoError(t, ValidateKey("3f2b9c1e-7d4a-4b8e-9f0a-1c2d3e4f5a6b"))
+	assert.NoError(t, ValidateKey(strings.Repeat("k", MaxKeyLength)))
+
+	for _, key := range []string{"", "has space", "tab\there", "ünïcode", strings.Repeat("k", MaxKeyLength+1)} {
+		assert.ErrorIs(t, ValidateKey(key), ErrInvalidKey, "%q", key)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint("POST", "/api/v1/deployments", []byte(`{"a":1}`))
+	assert.Len(t, a, 64)
+	assert.Equal(t, a, Fingerprint("POST", "/api/v1/deployments", []byte(`{"a":1}`)))
+	assert.NotEqual(t, a, Fingerprint("POST", "/api/v1/deployments", []byte(`{"a":2}`)))
+	assert.NotEqual(t, a, Fingerprint("POST", "/api/v1/templates", []byte(`{"a":1}`)))
+}
+
+func TestEvaluate(t *testing.T) {
+	fp := Fingerprint("POST", "/x", nil)
+	done := &Record{Fingerprint: fp, StatusCode: 201, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	running := &Record{Fingerprint: fp, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}
+	abandoned := &Record{Fingerprint: fp, CreatedAt: now.Add(-LockTimeout), ExpiresAt: now.Add(time.Hour)}
+	expired := &Record{Fingerprint: "other", StatusCode: 201, CreatedAt: now.Add(-2 * DefaultTTL), ExpiresAt: now}
+
+	assert.Equal(t, OutcomeProceed, Evaluate(nil, fp, now))
+	assert.Equal(t, OutcomeReplay, Evaluate(done, fp, now))
+	assert.Equal(t, OutcomeMismatch, Evaluate(done, "different", now))
+	assert.Equal(t, OutcomeInProgress, Evaluate(running, fp, now))
+	assert.Equal(t, OutcomeProceed, Evaluate(abandoned, fp, now))
+	assert.Equal(t, OutcomeProceed, Evaluate(expired, fp, now))
+}
+
+func TestShouldStore(t *testing.T) {
+	assert.True(t, ShouldStore(201))
+	assert.True(t, ShouldStore(422))
+	assert.False(t, ShouldStore(500))
+	assert.False(t, ShouldStore(503))
+	assert.False(t, ShouldStore(0))
+}
@@ -0,0 +1,285 @@
+// Package incident provides pure functions for operator-managed incidents:
+// severities and statuses, the nodes and deployments an incident affects,
+// its update timeline, the banner shown to affected customers, and automatic
+// resolution once everything affected has recovered.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package incident
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Severity and Status
+// =============================================================================
+
+// Severity is how badly an incident affects customers.
+type Severity string
+
+const (
+	SeverityMinor    Severity = "minor"
+	SeverityMajor    Severity = "major"
+	SeverityCritical Severity = "critical"
+)
+
+// Severities lists the valid severities, least severe first.
+var Severities = []Severity{SeverityMinor, SeverityMajor, SeverityCritical}
+
+// ParseSeverity returns the severity named s.
+func ParseSeverity(s string) (Severity, error) {
+	for _, sev := range Severities {
+		if string(sev) == s {
+			return sev, nil
+		}
+	}
+	return "", fmt.Errorf("invalid severity %q: must be one of minor, major, critical", s)
+}
+
+// Rank orders severities: higher is more severe, 0 for unknown.
+func (s Severity) Rank() int {
+	for i, sev := range Severities {
+		if sev == s {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Status is where an incident is in its lifecycle.
+type Status string
+
+const (
+	StatusInvestigating Status = "investigating"
+	StatusIdentified    Status = "identified"
+	StatusMonitoring    Status = "monitoring"
+	StatusResolved      Status = "resolved"
+)
+
+// Statuses lists the valid statuses in lifecycle order.
+var Statuses = []Status{StatusInvestigating, StatusIdentified, StatusMonitoring, StatusResolved}
+
+// ParseStatus returns the status named s.
+func ParseStatus(s string) (Status, error) {
+	for _, st := range Statuses {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("invalid status %q: must be one of investigating, identified, monitoring, resolved", s)
+}
+
+// Open reports whether an incident with this status is still ongoing.
+func (s Status) Open() bool {
+	return s != StatusResolved
+}
+
+// =============================================================================
+// Scope
+// =============================================================================
+
+// Scope is what an incident affects: nodes and deployments by reference ID,
+// and locations standing for every node of the incident's operator there.
+type Scope struct {
+	Nodes       []string `json:"nodes,omitempty"`
+	Deployments []string `json:"deployments,omitempty"`
+	Locations   []string `json:"locations,omitempty"`
+}
+
+// Validate checks that the scope names at least one target and no empty ones.
+func (s Scope) Validate() error {
+	if len(s.Nodes)+len(s.Deployments)+len(s.Locations) == 0 {
+		return fmt.Errorf("an incident must affect at least one node, deployment or location")
+	}
+	for _, list := range [][]string{s.Nodes, s.Deployments, s.Locations} {
+		for _, v := range list {
+			if strings.TrimSpace(v) == "" {
+				return fmt.Errorf("affected nodes, deployments and locations must not be empty")
+			}
+		}
+	}
+	return nil
+}
+
+// Node is an operator's node as seen by scope expansion.
+type Node struct {
+	ID       string
+	Location string
+}
+
+// Expand returns the scope with the operator's nodes in its locations added
+// to Nodes. nodes must be the incident operator's own nodes.
+func (s Scope) Expand(nodes []Node) Scope {
+	if len(s.Locations) == 0 {
+		return s
+	}
+	out := Scope{
+		Nodes:       append([]string(nil), s.Nodes...),
+		Deployments: s.Deployments,
+		Locations:   s.Locations,
+	}
+	seen := make(map[string]bool, len(s.Nodes))
+	for _, n := range s.Nodes {
+		seen[n] = true
+	}
+	for _, n := range nodes {
+		if n.Location != "" && contains(s.Locations, n.Location) && !seen[n.ID] {
+			seen[n.ID] = true
+			out.Nodes = append(out.Nodes, n.ID)
+		}
+	}
+	return out
+}
+
+// Affects reports whether a deployment running on nodeID is affected. Call
+// it on an expanded scope.
+func (s Scope) Affects(deploymentID, nodeID string) bool {
+	return contains(s.Deployments, deploymentID) || (nodeID != "" && contains(s.Nodes, nodeID))
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Timeline
+// =============================================================================
+
+// Update is one entry of an incident's timeline.
+type Update struct {
+	Status    Status    `json:"status"`
+	Message   string    `json:"message"`
+	At        time.Time `json:"at"`
+	Automatic bool      `json:"automatic,omitempty"` // Posted by automatic resolution
+}
+
+// MaxMessageLength bounds an update's message.
+const MaxMessageLength = 2000
+
+// NewUpdate validates and builds a timeline entry.
+func NewUpdate(status Status, message string, at time.Time) (Update, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return Update{}, fmt.Errorf("message is required")
+	}
+	if len(message) > MaxMessageLength {
+		return Update{}, fmt.Errorf("message must be at most %d characters", MaxMessageLength)
+	}
+	return Update{Status: status, Message: message, At: at.UTC()}, nil
+}
+
+// =============================================================================
+// Banners
+// =============================================================================
+
+// Incident is the part of an incident that customers see.
+type Incident struct {
+	ID        string
+	Title     string
+	Severity  Severity
+	Status    Status
+	StartedAt time.Time
+	Updates   []Update
+}
+
+// Banner is what an affected deployment shows about an incident.
+type Banner struct {
+	Incident  string    `json:"incident"`
+	Title     string    `json:"title"`
+	Severity  Severity  `json:"severity"`
+	Status    Status    `json:"status"`
+	Message   string    `json:"message,omitempty"` // Latest update
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BannerFor returns an incident's banner.
+func BannerFor(inc Incident) Banner {
+	b := Banner{
+		Incident:  inc.ID,
+		Title:     inc.Title,
+		Severity:  inc.Severity,
+		Status:    inc.Status,
+		StartedAt: inc.StartedAt,
+		UpdatedAt: inc.StartedAt,
+	}
+	if n := len(inc.Updates); n > 0 {
+		b.Message = inc.Updates[n-1].Message
+		b.UpdatedAt = inc.Updates[n-1].At
+	}
+	return b
+}
+
+// SortBanners orders banners most severe first, then most recently started.
+func SortBanners(banners []Banner) {
+	for i := 1; i < len(banners); i++ {
+		for j := i; j > 0 && bannerBefore(banners[j], banners[j-1]); j-- {
+			banners[j], banners[j-1] = banners[j-1], banners[j]
+		}
+	}
+}
+
+func bannerBefore(a, b Banner) bool {
+	if a.Severity.Rank() != b.Severity.Rank() {
+		return a.Severity.Rank() > b.Severity.Rank()
+	}
+	return a.StartedAt.After(b.StartedAt)
+}
+
+// =============================================================================
+// Automatic Resolution
+// =============================================================================
+
+// DefaultRecoveryWindow is how long everything an incident affects must stay
+// healthy before it is resolved automatically.
+const DefaultRecoveryWindow = 10 * time.Minute
+
+// Target is the health of one node or deployment an incident affects.
+type Target struct {
+	Kind    string // "node" or "deployment"
+	ID      string
+	Healthy bool
+}
+
+// Recovery is the outcome of evaluating an incident's targets.
+type Recovery struct {
+	// RecoveredSince is when every target was last seen healthy without
+	// interruption; zero while any target is unhealthy.
+	RecoveredSince time.Time
+	// Resolve is set once the targets have been healthy for the window.
+	Resolve bool
+	// Reason describes the unhealthy targets, or the recovery.
+	Reason string
+}
+
+// EvaluateRecovery decides whether an incident can be resolved automatically.
+// An incident without targets is never resolved automatically.
+func EvaluateRecovery(targets []Target, recoveredSince, now time.Time, window time.Duration) Recovery {
+	if len(targets) == 0 {
+		return Recovery{Reason: "no targets to check"}
+	}
+	var unhealthy []string
+	for _, t := range targets {
+		if !t.Healthy {
+			unhealthy = append(unhealthy, t.Kind+" "+t.ID)
+		}
+	}
+	if len(unhealthy) > 0 {
+		return Recovery{Reason: "unhealthy: " + strings.Join(unhealthy, ", ")}
+	}
+	if recoveredSince.IsZero() || recoveredSince.After(now) {
+		recoveredSince = now
+	}
+	r := Recovery{RecoveredSince: recoveredSince}
+	if now.Sub(recoveredSince) >= window {
+		r.Resolve = true
+		r.Reason = fmt.Sprintf("all %d affected nodes and deployments have been healthy for %s", len(targets), window)
+	}
+	return r
+}
@@ -0,0 +1,121 @@
+package incident
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSeverityAndStatus(t *testing.T) {
+	sev, err := ParseSeverity("major")
+	require.NoError(t, err)
+	assert.Equal(t, SeverityMajor, sev)
+	_, err = ParseSeverity("catastrophic")
+	assert.Error(t, err)
+
+	assert.Greater(t, SeverityCritical.Rank(), SeverityMajor.Rank())
+	assert.Greater(t, SeverityMajor.Rank(), SeverityMinor.Rank())
+	assert.Zero(t, Severity("x").Rank())
+
+	st, err := ParseStatus("monitoring")
+	require.NoError(t, err)
+	assert.True(t, st.Open())
+	assert.False(t, StatusResolved.Open())
+	_, err = ParseStatus("done")
+	assert.Error(t, err)
+}
+
+func TestScope_Validate(t *testing.T) {
+	assert.Error(t, Scope{}.Validate())
+	assert.Error(t, Scope{Nodes: []string{" "}}.Validate())
+	assert.NoError(t, Scope{Locations: []string{"eu-west"}}.Validate())
+	assert.NoError(t, Scope{Deployments: []string{"d1"}}.Validate())
+}
+
+func TestScope_ExpandAndAffects(t *testing.T) {
+	s := Scope{Nodes: []string{"node_a"}, Deployments: []string{"d9"}, Locations: []string{"eu-west"}}
+	expanded := s.Expand([]Node{
+		{ID: "node_a", Location: "eu-west"},
+		{ID: "node_b", Location: "eu-west"},
+		{ID: "node_c", Location: "us-east"},
+		{ID: "node_d"},
+	})
+
+	assert.Equal(t, []string{"node_a", "node_b"}, expanded.Nodes)
+	assert.Equal(t, []string{"node_a"}, s.Nodes, "original scope is not modified")
+
+	assert.True(t, expanded.Affects("d1", "node_b"))
+	assert.True(t, expanded.Affects("d9", ""))
+	assert.False(t, expanded.Affects("d1", "node_c"))
+	assert.False(t, expanded.Affects("d1", ""))
+}
+
+func TestNewUpdate(t *testing.T) {
+	at := time.Date(2026, 10, 18, 9, 0, 0, 0, time.FixedZone("x", 3600))
+	u, err := NewUpdate(StatusIdentified, "  Disk full on node_a  ", at)
+	require.NoError(t, err)
+	assert.Equal(t, "Disk full on node_a", u.Message)
+	assert.Equal(t, time.UTC, u.At.Location())
+
+	_, err = NewUpdate(StatusIdentified, "   ", at)
+	assert.Error(t, err)
+	_, err = NewUpdate(StatusIdentified, string(make([]byte, MaxMessageLength+1)), at)
+	assert.Error(t, err)
+}
+
+func TestBannerFor(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	inc := Incident{ID: "inc_1", Title: "Degraded network", Severity: SeverityMajor, Status: StatusIdentified, StartedAt: start}
+
+	b := BannerFor(inc)
+	assert.Empty(t, b.Message)
+	assert.Equal(t, start, b.UpdatedAt)
+
+	inc.Updates = []Update{
+		{Status: StatusInvestigating, Message: "Looking into it", At: start},
+		{Status: StatusIdentified, Message: "Upstream provider issue", At: start.Add(time.Hour)},
+	}
+	b = BannerFor(inc)
+	assert.Equal(t, "Upstream provider issue", b.Message)
+	assert.Equal(t, start.Add(time.Hour), b.UpdatedAt)
+}
+
+func TestSortBanners(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	banners := []Banner{
+		{Incident: "old-minor", Severity: SeverityMinor, StartedAt: start},
+		{Incident: "old-critical", Severity: SeverityCritical, StartedAt: start},
+		{Incident: "new-minor", Severity: SeverityMinor, StartedAt: start.Add(time.Hour)},
+	}
+	SortBanners(banners)
+	assert.Equal(t, "old-critical", banners[0].Incident)
+	assert.Equal(t, "new-minor", banners[1].Incident)
+	assert.Equal(t, "old-minor", banners[2].Incident)
+}
+
+func TestEvaluateRecovery(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	healthy := []Target{{Kind: "node", ID: "node_a", Healthy: true}, {Kind: "deployment", ID: "d1", Healthy: true}}
+
+	r := EvaluateRecovery(nil, time.Time{}, now, DefaultRecoveryWindow)
+	assert.False(t, r.Resolve)
+
+	r = EvaluateRecovery([]Target{{Kind: "node", ID: "node_a"}, healthy[1]}, now.Add(-time.Hour), now, DefaultRecoveryWindow)
+	assert.False(t, r.Resolve)
+	assert.True(t, r.RecoveredSince.IsZero(), "an unhealthy target resets recovery")
+	assert.Equal(t, "unhealthy: node node_a", r.Reason)
+
+	r = EvaluateRecovery(healthy, time.Time{}, now, DefaultRecoveryWindow)
+	assert.False(t, r.Resolve)
+	assert.Equal(t, now, r.RecoveredSince, "recovery starts now")
+
+	r = EvaluateRecovery(healthy, now.Add(-5*time.Minute), now, DefaultRecoveryWindow)
+	assert.False(t, r.Resolve)
+	assert.Equal(t, now.Add(-5*time.Minute), r.RecoveredSince)
+
+	r = EvaluateRecovery(healthy, now.Add(-DefaultRecoveryWindow), now, DefaultRecoveryWindow)
+	assert.True(t, r.Resolve)
+	assert.Contains(t, r.Reason, "all 2 affected nodes and deployments")
+}
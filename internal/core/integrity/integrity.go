@@ -0,0 +1,202 @@
+// Package integrity provides pure functions for checking stored data against
+// the engine schema: JSON column validity, foreign key resolution, and state
+// machine invariants.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package integrity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// =============================================================================
+// Issue Types
+// =============================================================================
+
+// IssueKind classifies an integrity problem.
+type IssueKind string
+
+const (
+	IssueInvalidJSON  IssueKind = "invalid_json"
+	IssueJSONShape    IssueKind = "json_shape"
+	IssueDanglingRef  IssueKind = "dangling_ref"
+	IssueInvalidState IssueKind = "invalid_state"
+	IssueMissingRefID IssueKind = "missing_reference_id"
+)
+
+// Issue describes a single integrity problem found in a row.
+type Issue struct {
+	Kind        IssueKind `json:"kind"`
+	Resource    string    `json:"resource"`
+	ReferenceID string    `json:"reference_id"`
+	Field       string    `json:"field"`
+	Value       string    `json:"value,omitempty"`
+	Message     string    `json:"message"`
+	Repairable  bool      `json:"repairable"`
+}
+
+// String formats the issue for CLI output.
+func (i Issue) String() string {
+	ref := i.ReferenceID
+	if ref == "" {
+		ref = "?"
+	}
+	return fmt.Sprintf("%s %s.%s [%s]: %s", i.Kind, i.Resource, i.Field, ref, i.Message)
+}
+
+// Report aggregates the issues found during a scan.
+type Report struct {
+	RowsScanned int     `json:"rows_scanned"`
+	Issues      []Issue `json:"issues"`
+	Repaired    int     `json:"repaired"`
+}
+
+// Add appends an issue to the report.
+func (r *Report) Add(issue Issue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+// OK returns true when no issues were found.
+func (r *Report) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// CountByKind returns the number of issues per kind.
+func (r *Report) CountByKind() map[IssueKind]int {
+	counts := make(map[IssueKind]int)
+	for _, i := range r.Issues {
+		counts[i.Kind]++
+	}
+	return counts
+}
+
+// Summary returns a one-line, deterministic summary of the report.
+func (r *Report) Summary() string {
+	if r.OK() {
+		return fmt.Sprintf("%d rows scanned, no issues found", r.RowsScanned)
+	}
+	counts := r.CountByKind()
+	kinds := make([]string, 0, len(counts))
+	for k := range counts {
+		kinds = append(kinds, string(k))
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, k := range kinds {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[IssueKind(k)]))
+	}
+	return fmt.Sprintf("%d rows scanned, %d issues (%s), %d repaired",
+		r.RowsScanned, len(r.Issues), strings.Join(parts, ", "), r.Repaired)
+}
+
+// =============================================================================
+// Checks (Pure Functions)
+// =============================================================================
+
+// CheckJSON reports whether a raw JSON column value is valid.
+// Empty strings are treated as NULL and are valid.
+func CheckJSON(raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if !json.Valid([]byte(raw)) {
+		return fmt.Errorf("value is not valid JSON")
+	}
+	return nil
+}
+
+// Shape is the structure a JSON column is declared to hold.
+type Shape string
+
+const (
+	ShapeAny         Shape = ""                  // Any well-formed JSON
+	ShapeObject      Shape = "object"            // {"key": ...}
+	ShapeStringMap   Shape = "object of strings" // {"key": "value"}
+	ShapeObjectArray Shape = "array of objects"  // [{...}, {...}]
+	ShapeStringArray Shape = "array of strings"  // ["a", "b"]
+)
+
+// CheckJSONShape reports whether a well-formed JSON column value has the
+// shape its field declares. Empty strings and null are treated as NULL and
+// fit every shape, as do empty objects and arrays of the declared kind.
+func CheckJSONShape(raw string, shape Shape) error {
+	if shape == ShapeAny || strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return fmt.Errorf("value is not valid JSON")
+	}
+	if v == nil {
+		return nil
+	}
+
+	fits := false
+	switch shape {
+	case ShapeObject:
+		_, fits = v.(map[string]any)
+	case ShapeStringMap:
+		var m map[string]any
+		if m, fits = v.(map[string]any); fits {
+			for _, e := range m {
+				if _, ok := e.(string); !ok {
+					fits = false
+				}
+			}
+		}
+	case ShapeObjectArray, ShapeStringArray:
+		var a []any
+		if a, fits = v.([]any); fits {
+			for _, e := range a {
+				if shape == ShapeObjectArray {
+					_, fits = e.(map[string]any)
+				} else {
+					_, fits = e.(string)
+				}
+				if !fits {
+					break
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unknown shape %q", shape)
+	}
+	if !fits {
+		return fmt.Errorf("value is not an %s", shape)
+	}
+	return nil
+}
+
+// CheckSchemaVersion reports whether a database's file migration version is
+// the newest one this build has, so the scan reads the schema it expects.
+func CheckSchemaVersion(version, latest uint, dirty bool) error {
+	switch {
+	case dirty:
+		return fmt.Errorf("schema migration %d did not finish; fix it before checking the data", version)
+	case version < latest:
+		return fmt.Errorf("database schema is at version %d, this hoster expects %d; start hoster once to migrate it", version, latest)
+	case version > latest:
+		return fmt.Errorf("database schema is at version %d, newer than this hoster's %d; run the fsck of the hoster that migrated it", version, latest)
+	}
+	return nil
+}
+
+// CheckState reports whether state is one of the known states of a state machine.
+func CheckState(state string, known []string) error {
+	for _, s := range known {
+		if s == state {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown state %q", state)
+}
+
+// Truncate shortens a value for inclusion in an issue report.
+func Truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
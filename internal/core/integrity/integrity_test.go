@@ -0,0 +1,127 @@
+package integrity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// CheckJSON Tests
+// =============================================================================
+
+func TestCheckJSON_Valid(t *testing.T) {
+	assert.NoError(t, CheckJSON(`{"a":1}`))
+	assert.NoError(t, CheckJSON(`[1,2,3]`))
+	assert.NoError(t, CheckJSON(`"str"`))
+}
+
+func TestCheckJSON_EmptyIsNull(t *testing.T) {
+	assert.NoError(t, CheckJSON(""))
+	assert.NoError(t, CheckJSON("   "))
+}
+
+func TestCheckJSON_Invalid(t *testing.T) {
+	assert.Error(t, CheckJSON(`{"a":`))
+	assert.Error(t, CheckJSON(`not json`))
+}
+
+// =============================================================================
+// CheckJSONShape Tests
+// =============================================================================
+
+func TestCheckJSONShape_Fits(t *testing.T) {
+	for _, tc := range []struct {
+		raw   string
+		shape Shape
+	}{
+		{`"anything"`, ShapeAny},
+		{`{"a":[1]}`, ShapeObject},
+		{`{"a":"b"}`, ShapeStringMap},
+		{`[{"a":1},{}]`, ShapeObjectArray},
+		{`["a","b"]`, ShapeStringArray},
+		{`[]`, ShapeObjectArray},
+		{`{}`, ShapeStringMap},
+		{`null`, ShapeObject},
+		{``, ShapeStringArray},
+	} {
+		assert.NoError(t, CheckJSONShape(tc.raw, tc.shape), "%s as %s", tc.raw, tc.shape)
+	}
+}
+
+func TestCheckJSONShape_Mismatch(t *testing.T) {
+	for _, tc := range []struct {
+		raw   string
+		shape Shape
+	}{
+		{`[1]`, ShapeObject},
+		{`"str"`, ShapeObject},
+		{`{"a":1}`, ShapeStringMap},
+		{`{"a":1}`, ShapeObjectArray},
+		{`[{"a":1},"b"]`, ShapeObjectArray},
+		{`["a",2]`, ShapeStringArray},
+		{`{"a":`, ShapeObject},
+	} {
+		assert.Error(t, CheckJSONShape(tc.raw, tc.shape), "%s as %s", tc.raw, tc.shape)
+	}
+	assert.EqualError(t, CheckJSONShape(`[1]`, ShapeObject), "value is not an object")
+}
+
+// =============================================================================
+// CheckState Tests
+// =============================================================================
+
+func TestCheckState_Known(t *testing.T) {
+	assert.NoError(t, CheckState("running", []string{"pending", "running"}))
+}
+
+func TestCheckState_Unknown(t *testing.T) {
+	err := CheckState("exploded", []string{"pending", "running"})
+	assert.EqualError(t, err, `unknown state "exploded"`)
+}
+
+func TestCheckState_EmptyIsUnknown(t *testing.T) {
+	assert.Error(t, CheckState("", []string{"pending"}))
+}
+
+// =============================================================================
+// CheckSchemaVersion Tests
+// =============================================================================
+
+func TestCheckSchemaVersion(t *testing.T) {
+	assert.NoError(t, CheckSchemaVersion(5, 5, false))
+	assert.ErrorContains(t, CheckSchemaVersion(4, 5, false), "start hoster once to migrate it")
+	assert.ErrorContains(t, CheckSchemaVersion(6, 5, false), "newer than this hoster's 5")
+	assert.ErrorContains(t, CheckSchemaVersion(5, 5, true), "did not finish")
+}
+
+// =============================================================================
+// Report Tests
+// =============================================================================
+
+func TestReport_OK(t *testing.T) {
+	r := &Report{RowsScanned: 3}
+	assert.True(t, r.OK())
+	assert.Equal(t, "3 rows scanned, no issues found", r.Summary())
+}
+
+func TestReport_SummaryCountsByKind(t *testing.T) {
+	r := &Report{RowsScanned: 10, Repaired: 1}
+	r.Add(Issue{Kind: IssueInvalidJSON})
+	r.Add(Issue{Kind: IssueDanglingRef})
+	r.Add(Issue{Kind: IssueDanglingRef})
+
+	assert.False(t, r.OK())
+	assert.Equal(t, 2, r.CountByKind()[IssueDanglingRef])
+	assert.Equal(t, "10 rows scanned, 3 issues (dangling_ref=2, invalid_json=1), 1 repaired", r.Summary())
+}
+
+func TestIssue_String(t *testing.T) {
+	i := Issue{Kind: IssueDanglingRef, Resource: "deployments", ReferenceID: "depl_1", Field: "node_id", Message: "nodes node_x does not exist"}
+	assert.Equal(t, "dangling_ref deployments.node_id [depl_1]: nodes node_x does not exist", i.String())
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", Truncate("abc", 5))
+	assert.Equal(t, "ab...", Truncate("abcdef", 2))
+}
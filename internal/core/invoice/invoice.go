@@ -0,0 +1,192 @@
+// Package invoice provides pure functions for monthly customer invoices:
+// pricing a customer's deployments and metered usage into line items under
+// their plan's prices, and rendering an invoice for export.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package invoice
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/spending"
+)
+
+// =============================================================================
+// Pricing
+// =============================================================================
+
+// Pricing prices metered usage: Usage for every customer, overridden per
+// event type by the prices of the customer's plan.
+type Pricing struct {
+	Usage spending.Prices
+	Plans map[string]spending.Prices
+}
+
+// For returns the usage prices of a customer on planID.
+func (p Pricing) For(planID string) spending.Prices {
+	plan := p.Plans[planID]
+	if len(plan) == 0 {
+		return p.Usage
+	}
+	prices := maps.Clone(p.Usage)
+	if prices == nil {
+		prices = spending.Prices{}
+	}
+	maps.Copy(prices, plan)
+	return prices
+}
+
+// ParsePlanPrices parses "plan:event_type=cents" entries into the usage
+// prices of each plan.
+func ParsePlanPrices(entries []string) (map[string]spending.Prices, error) {
+	byPlan := map[string][]string{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		plan, price, ok := strings.Cut(e, ":")
+		plan = strings.TrimSpace(plan)
+		if !ok || plan == "" {
+			return nil, fmt.Errorf("invalid plan usage price %q: want plan:event_type=cents", e)
+		}
+		byPlan[plan] = append(byPlan[plan], price)
+	}
+
+	plans := make(map[string]spending.Prices, len(byPlan))
+	for plan, prices := range byPlan {
+		parsed, err := spending.ParsePrices(prices)
+		if err != nil {
+			return nil, fmt.Errorf("plan %s: %w", plan, err)
+		}
+		plans[plan] = parsed
+	}
+	return plans, nil
+}
+
+// =============================================================================
+// Line Items
+// =============================================================================
+
+// Kind is what an invoice line charges for.
+type Kind string
+
+const (
+	KindSubscription Kind = "subscription" // A deployment's template monthly price
+	KindUsage        Kind = "usage"        // One metered usage event type
+)
+
+// Line is an invoice line item. Subscription lines keep deployment_id and
+// monthly_cents, which creator earnings are attributed from; usage lines
+// carry neither.
+type Line struct {
+	Kind           Kind   `json:"kind"`
+	DeploymentID   string `json:"deployment_id,omitempty"`
+	DeploymentName string `json:"deployment_name,omitempty"`
+	TemplateName   string `json:"template_name,omitempty"`
+	MonthlyCents   int64  `json:"monthly_cents,omitempty"`
+	EventType      string `json:"event_type,omitempty"`
+	Quantity       int64  `json:"quantity,omitempty"`
+	UnitCents      int64  `json:"unit_cents,omitempty"`
+	AmountCents    int64  `json:"amount_cents"`
+	Description    string `json:"description"`
+}
+
+// Amount is what the line charges. Lines invoiced before usage was billed
+// have only monthly_cents.
+func (l Line) Amount() int64 {
+	if l.AmountCents == 0 && l.Kind == "" {
+		return l.MonthlyCents
+	}
+	return l.AmountCents
+}
+
+// Subscription is a billed deployment and its template's monthly price.
+type Subscription struct {
+	DeploymentID   string
+	DeploymentName string
+	TemplateName   string
+	MonthlyCents   int64
+}
+
+// Build returns the lines of a customer's invoice for the month starting at
+// month: one per subscription, then one per usage event type that prices
+// give a price, by event type.
+func Build(month time.Time, subs []Subscription, usage []spending.Usage, prices spending.Prices) []Line {
+	lines := make([]Line, 0, len(subs)+len(usage))
+	for _, s := range subs {
+		lines = append(lines, Line{
+			Kind:           KindSubscription,
+			DeploymentID:   s.DeploymentID,
+			DeploymentName: s.DeploymentName,
+			TemplateName:   s.TemplateName,
+			MonthlyCents:   s.MonthlyCents,
+			AmountCents:    s.MonthlyCents,
+			Description:    fmt.Sprintf("%s (%s) — %s", s.DeploymentName, s.TemplateName, month.Format("Jan 2006")),
+		})
+	}
+	return append(lines, usageLines(usage, prices)...)
+}
+
+// Refresh replaces the usage lines of an invoice with lines for usage,
+// keeping its subscription lines. It finishes the invoice of a month that
+// has ended, whose deployments may have stopped since.
+func Refresh(lines []Line, usage []spending.Usage, prices spending.Prices) []Line {
+	kept := slices.DeleteFunc(slices.Clone(lines), func(l Line) bool {
+		return l.Kind == KindUsage
+	})
+	return append(kept, usageLines(usage, prices)...)
+}
+
+// usageLines prices usage, summing the quantities of each event type.
+func usageLines(usage []spending.Usage, prices spending.Prices) []Line {
+	quantities := map[string]int64{}
+	for _, u := range usage {
+		if _, priced := prices[u.EventType]; priced {
+			quantities[u.EventType] += u.Quantity
+		}
+	}
+
+	var lines []Line
+	for _, typ := range slices.Sorted(maps.Keys(quantities)) {
+		qty, unit := quantities[typ], prices[typ]
+		if qty <= 0 || unit <= 0 {
+			continue
+		}
+		lines = append(lines, Line{
+			Kind:        KindUsage,
+			EventType:   typ,
+			Quantity:    qty,
+			UnitCents:   unit,
+			AmountCents: qty * unit,
+			Description: fmt.Sprintf("%s: %d × %s", typ, qty, FormatCents(unit, "USD")),
+		})
+	}
+	return lines
+}
+
+// Total sums the lines.
+func Total(lines []Line) int64 {
+	var total int64
+	for _, l := range lines {
+		total += l.Amount()
+	}
+	return total
+}
+
+// FormatCents renders an amount in currency: dollars for USD, otherwise the
+// amount followed by the currency code.
+func FormatCents(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	amount := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+	if currency == "" || strings.EqualFold(currency, "USD") {
+		return sign + "$" + amount
+	}
+	return sign + amount + " " + strings.ToUpper(currency)
+}
@@ -0,0 +1,140 @@
+package invoice
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/spending"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Pricing Tests
+// =============================================================================
+
+func TestParsePlanPrices(t *testing.T) {
+	plans, err := ParsePlanPrices([]string{"pro:gpu.usage=120", " pro:egress.usage=1", "", "starter:gpu.usage=150"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]spending.Prices{
+		"pro":     {"gpu.usage": 120, "egress.usage": 1},
+		"starter": {"gpu.usage": 150},
+	}, plans)
+
+	for _, bad := range [][]string{
+		{"gpu.usage=120"},
+		{":gpu.usage=1"},
+		{"pro:gpu.usage=-1"},
+		{"pro:gpu.usage=1", "pro:gpu.usage=2"},
+	} {
+		_, err := ParsePlanPrices(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPricing_For(t *testing.T) {
+	p := Pricing{
+		Usage: spending.Prices{"gpu.usage": 150, "egress.usage": 2},
+		Plans: map[string]spending.Prices{"pro": {"gpu.usage": 120}},
+	}
+
+	assert.Equal(t, spending.Prices{"gpu.usage": 120, "egress.usage": 2}, p.For("pro"))
+	assert.Equal(t, p.Usage, p.For("free"))
+	assert.Equal(t, spending.Prices{"gpu.usage": 150, "egress.usage": 2}, p.Usage, "plan prices don't leak into the base prices")
+	assert.Equal(t, spending.Prices{"gpu.usage": 120}, Pricing{Plans: p.Plans}.For("pro"))
+}
+
+// =============================================================================
+// Line Item Tests
+// =============================================================================
+
+var march = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func TestBuild(t *testing.T) {
+	subs := []Subscription{{DeploymentID: "depl_a", DeploymentName: "blog", TemplateName: "WordPress", MonthlyCents: 900}}
+	usage := []spending.Usage{
+		{EventType: "gpu.usage", Quantity: 3},
+		{EventType: "egress.usage", Quantity: 40},
+		{EventType: "gpu.usage", Quantity: 2},
+		{EventType: "cpu.usage", Quantity: 1000},
+	}
+	prices := spending.Prices{"gpu.usage": 150, "egress.usage": 2}
+
+	lines := Build(march, subs, usage, prices)
+	require.Len(t, lines, 3)
+	assert.Equal(t, Line{
+		Kind: KindSubscription, DeploymentID: "depl_a", DeploymentName: "blog", TemplateName: "WordPress",
+		MonthlyCents: 900, AmountCents: 900, Description: "blog (WordPress) — Mar 2026",
+	}, lines[0])
+	assert.Equal(t, Line{Kind: KindUsage, EventType: "egress.usage", Quantity: 40, UnitCents: 2, AmountCents: 80, Description: "egress.usage: 40 × $0.02"}, lines[1])
+	assert.Equal(t, Line{Kind: KindUsage, EventType: "gpu.usage", Quantity: 5, UnitCents: 150, AmountCents: 750, Description: "gpu.usage: 5 × $1.50"}, lines[2])
+	assert.Equal(t, int64(1730), Total(lines))
+
+	assert.Empty(t, Build(march, nil, usage, nil), "unpriced usage isn't billed")
+}
+
+func TestRefresh(t *testing.T) {
+	prices := spending.Prices{"gpu.usage": 150}
+	lines := Build(march, []Subscription{{DeploymentID: "depl_a", MonthlyCents: 900}}, []spending.Usage{{EventType: "gpu.usage", Quantity: 1}}, prices)
+
+	refreshed := Refresh(lines, []spending.Usage{{EventType: "gpu.usage", Quantity: 4}}, prices)
+	require.Len(t, refreshed, 2)
+	assert.Equal(t, lines[0], refreshed[0])
+	assert.Equal(t, int64(600), refreshed[1].AmountCents)
+	assert.Equal(t, int64(150), lines[1].AmountCents, "the lines refreshed are left alone")
+}
+
+func TestLine_Amount(t *testing.T) {
+	assert.Equal(t, int64(900), Line{DeploymentID: "depl_a", MonthlyCents: 900}.Amount(), "lines from before usage billing")
+	assert.Equal(t, int64(80), Line{Kind: KindUsage, AmountCents: 80}.Amount())
+	assert.Equal(t, int64(0), Line{Kind: KindSubscription}.Amount())
+}
+
+func TestFormatCents(t *testing.T) {
+	assert.Equal(t, "$12.05", FormatCents(1205, "USD"))
+	assert.Equal(t, "$0.02", FormatCents(2, ""))
+	assert.Equal(t, "-$1.50", FormatCents(-150, "usd"))
+	assert.Equal(t, "9.99 EUR", FormatCents(999, "eur"))
+}
+
+// =============================================================================
+// Export Tests
+// =============================================================================
+
+func TestRenderPDF(t *testing.T) {
+	doc := Document{
+		Number:      "inv_abc",
+		Customer:    "ada@example.com",
+		Status:      "draft",
+		Currency:    "USD",
+		PeriodStart: march,
+		PeriodEnd:   march.AddDate(0, 1, 0).Add(-time.Second),
+		Lines: []Line{
+			{Kind: KindSubscription, AmountCents: 900, Description: "blog (WordPress) — Mar 2026"},
+			{Kind: KindUsage, AmountCents: 750, Description: "gpu.usage: 5 × $1.50"},
+		},
+		SubtotalCents: 1650,
+		TotalCents:    1650,
+	}
+
+	pdf := RenderPDF(doc)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	assert.Contains(t, string(pdf), "(Invoice inv_abc) Tj")
+	assert.Contains(t, string(pdf), "(blog \\(WordPress\\) - Mar 2026) Tj")
+	assert.Contains(t, string(pdf), "(gpu.usage: 5 x $1.50) Tj")
+	assert.Contains(t, string(pdf), "($16.50) Tj")
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	require.NotNil(t, m)
+	xref, _ := strconv.Atoi(string(m[1]))
+	assert.True(t, bytes.HasPrefix(pdf[xref:], []byte("xref\n0 7\n")), "startxref points at the xref table")
+
+	for i := 0; i < 80; i++ {
+		doc.Lines = append(doc.Lines, Line{Kind: KindUsage, AmountCents: 1, Description: "line"})
+	}
+	assert.Contains(t, string(RenderPDF(doc)), "/Count 3", "long invoices continue over pages")
+}
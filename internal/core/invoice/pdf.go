@@ -0,0 +1,149 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Export
+// =============================================================================
+
+// Document is an invoice as exported.
+type Document struct {
+	Number        string    `json:"number"`
+	Customer      string    `json:"customer"`
+	Status        string    `json:"status"`
+	Currency      string    `json:"currency"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	Lines         []Line    `json:"items"`
+	SubtotalCents int64     `json:"subtotal_cents"`
+	TaxCents      int64     `json:"tax_cents"`
+	TotalCents    int64     `json:"total_cents"`
+}
+
+// PDF page geometry, in points (US Letter).
+const (
+	pageWidth    = 612
+	pageHeight   = 792
+	pageMargin   = 56
+	lineHeight   = 16
+	linesPerPage = (pageHeight - 2*pageMargin) / lineHeight
+	amountColumn = pageWidth - pageMargin - 90
+)
+
+// pdfRow is a line of text on a PDF page, with an optional right-hand
+// amount.
+type pdfRow struct {
+	text   string
+	amount string
+	bold   bool
+}
+
+// RenderPDF renders the document as a PDF: a heading, then a row per line
+// item and the totals, continued over as many pages as they take. Text
+// outside ASCII is transliterated, as only the standard Helvetica fonts are
+// used.
+func RenderPDF(doc Document) []byte {
+	rows := []pdfRow{
+		{text: "Invoice " + doc.Number, bold: true},
+		{text: "Customer: " + doc.Customer},
+		{text: fmt.Sprintf("Period: %s to %s", doc.PeriodStart.UTC().Format(time.DateOnly), doc.PeriodEnd.UTC().Format(time.DateOnly))},
+		{text: "Status: " + doc.Status},
+		{},
+		{text: "Description", amount: "Amount", bold: true},
+	}
+	for _, l := range doc.Lines {
+		rows = append(rows, pdfRow{text: l.Description, amount: FormatCents(l.Amount(), doc.Currency)})
+	}
+	rows = append(rows,
+		pdfRow{},
+		pdfRow{text: "Subtotal", amount: FormatCents(doc.SubtotalCents, doc.Currency)},
+		pdfRow{text: "Tax", amount: FormatCents(doc.TaxCents, doc.Currency)},
+		pdfRow{text: "Total", amount: FormatCents(doc.TotalCents, doc.Currency), bold: true},
+	)
+
+	var pages [][]pdfRow
+	for len(rows) > linesPerPage {
+		pages = append(pages, rows[:linesPerPage])
+		rows = rows[linesPerPage:]
+	}
+	pages = append(pages, rows)
+
+	// Objects: 1 catalog, 2 page tree, 3-4 fonts, then a page and its
+	// content stream per page.
+	objects := []string{"", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>"}
+	kids := make([]string, len(pages))
+	for i, page := range pages {
+		pageObj, contentObj := len(objects)+1, len(objects)+2
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj)
+		content := pageContent(page)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, contentObj),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// pageContent returns the content stream drawing rows from the top of a
+// page.
+func pageContent(rows []pdfRow) string {
+	var b strings.Builder
+	y := pageHeight - pageMargin
+	for _, row := range rows {
+		font := "F1"
+		if row.bold {
+			font = "F2"
+		}
+		if row.text != "" {
+			fmt.Fprintf(&b, "BT /%s 11 Tf %d %d Td (%s) Tj ET\n", font, pageMargin, y, pdfText(row.text))
+		}
+		if row.amount != "" {
+			fmt.Fprintf(&b, "BT /%s 11 Tf %d %d Td (%s) Tj ET\n", font, amountColumn, y, pdfText(row.amount))
+		}
+		y -= lineHeight
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// pdfText escapes s for a PDF string literal, transliterating what the
+// standard fonts can't show.
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '—' || r == '–':
+			b.WriteByte('-')
+		case r == '×':
+			b.WriteByte('x')
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
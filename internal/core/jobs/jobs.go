@@ -0,0 +1,138 @@
+// Package jobs provides pure functions for the command bus's job queue: the
+// keys delayed jobs are cancelled by, the lifecycle of a job, the backoff
+// between attempts of a failing one, and when a dead job may be requeued.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// Keys
+// =============================================================================
+
+// MaxKeyLength bounds the key size.
+const MaxKeyLength = 255
+
+// ErrInvalidKey is returned for malformed keys.
+var ErrInvalidKey = errors.New("invalid job key")
+
+// ValidateKey checks that a key is 1-255 printable ASCII characters without
+// spaces. Callers build keys from what a delayed job acts on, e.g.
+// "expire:depl_1a2b", so rescheduling or cancelling needs no stored ID.
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: must not be empty", ErrInvalidKey)
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+	for _, c := range key {
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("%w: must be printable ASCII without spaces", ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Lifecycle
+// =============================================================================
+
+// Status is where a job is in its lifecycle.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusDead      Status = "dead" // Every attempt failed; waits to be requeued
+	StatusCancelled Status = "cancelled"
+)
+
+// ParseStatus parses a status name.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case StatusQueued, StatusRunning, StatusSucceeded, StatusDead, StatusCancelled:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown job status %q", s)
+}
+
+// Active reports whether the job may still run. An active job holds back
+// the later jobs of its resource, so a resource's commands run in the order
+// they were enqueued.
+func (s Status) Active() bool {
+	return s == StatusQueued || s == StatusRunning
+}
+
+// =============================================================================
+// Retries
+// =============================================================================
+
+const (
+	// DefaultMaxAttempts is how often a failing job is tried before it is
+	// dead-lettered.
+	DefaultMaxAttempts = 5
+	// BaseBackoff is the wait after the first failed attempt; it doubles
+	// with each further failure.
+	BaseBackoff = 5 * time.Second
+	// MaxBackoff bounds the wait between attempts.
+	MaxBackoff = 10 * time.Minute
+)
+
+// Backoff returns the wait after the given number of failed attempts.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	d := BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= MaxBackoff {
+			return MaxBackoff
+		}
+	}
+	return d
+}
+
+// Outcome is what becomes of a job after an attempt.
+type Outcome struct {
+	Status Status
+	RunAt  time.Time // Next attempt, for a queued outcome
+}
+
+// AfterAttempt decides what follows an attempt of a job that has now been
+// tried attempts times. A failure is retried after Backoff until
+// maxAttempts is reached, and the job is then dead.
+func AfterAttempt(attempts, maxAttempts int, err error, now time.Time) Outcome {
+	if err == nil {
+		return Outcome{Status: StatusSucceeded}
+	}
+	if maxAttempts < 1 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if attempts >= maxAttempts {
+		return Outcome{Status: StatusDead}
+	}
+	return Outcome{Status: StatusQueued, RunAt: now.Add(Backoff(attempts))}
+}
+
+// =============================================================================
+// Requeue
+// =============================================================================
+
+// ErrNotDead is returned when requeueing a job that is not dead.
+var ErrNotDead = errors.New("only dead jobs can be requeued")
+
+// CheckRequeue checks that a job in the given status may be requeued. A
+// requeued job starts over with a fresh set of attempts.
+func CheckRequeue(status Status) error {
+	if status != StatusDead {
+		return fmt.Errorf("%w: job is %s", ErrNotDead, status)
+	}
+	return nil
+}
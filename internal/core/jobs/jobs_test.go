@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateKey(t *testing.T) {
+	assert.NoError(t, ValidateKey("expire:depl_1a2b"))
+	assert.ErrorIs(t, ValidateKey(""), ErrInvalidKey)
+	assert.ErrorIs(t, ValidateKey("has space"), ErrInvalidKey)
+	assert.ErrorIs(t, ValidateKey("tab\tkey"), ErrInvalidKey)
+	assert.ErrorIs(t, ValidateKey(string(make([]byte, MaxKeyLength+1))), ErrInvalidKey)
+}
+
+func TestParseStatus(t *testing.T) {
+	st, err := ParseStatus("dead")
+	require.NoError(t, err)
+	assert.Equal(t, StatusDead, st)
+
+	_, err = ParseStatus("failed")
+	assert.Error(t, err)
+}
+
+func TestStatusActive(t *testing.T) {
+	assert.True(t, StatusQueued.Active())
+	assert.True(t, StatusRunning.Active())
+	assert.False(t, StatusSucceeded.Active())
+	assert.False(t, StatusDead.Active(), "dead jobs don't hold back their resource")
+	assert.False(t, StatusCancelled.Active())
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), Backoff(0))
+	assert.Equal(t, 5*time.Second, Backoff(1))
+	assert.Equal(t, 10*time.Second, Backoff(2))
+	assert.Equal(t, 40*time.Second, Backoff(4))
+	assert.Equal(t, MaxBackoff, Backoff(30))
+}
+
+func TestAfterAttempt(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("node unreachable")
+
+	assert.Equal(t, Outcome{Status: StatusSucceeded}, AfterAttempt(1, 5, nil, now))
+	assert.Equal(t, Outcome{Status: StatusQueued, RunAt: now.Add(5 * time.Second)}, AfterAttempt(1, 5, failure, now))
+	assert.Equal(t, Outcome{Status: StatusQueued, RunAt: now.Add(20 * time.Second)}, AfterAttempt(3, 5, failure, now))
+	assert.Equal(t, Outcome{Status: StatusDead}, AfterAttempt(5, 5, failure, now))
+	assert.Equal(t, Outcome{Status: StatusDead}, AfterAttempt(1, 1, failure, now), "single attempt")
+	assert.Equal(t, StatusQueued, AfterAttempt(4, 0, failure, now).Status, "zero uses the default")
+	assert.Equal(t, StatusDead, AfterAttempt(5, 0, failure, now).Status)
+}
+
+func TestCheckRequeue(t *testing.T) {
+	assert.NoError(t, CheckRequeue(StatusDead))
+	assert.ErrorIs(t, CheckRequeue(StatusQueued), ErrNotDead)
+	assert.ErrorIs(t, CheckRequeue(StatusRunning), ErrNotDead)
+	assert.ErrorIs(t, CheckRequeue(StatusSucceeded), ErrNotDead)
+}
@@ -125,6 +125,97 @@ func ValidateCapability(limits auth.PlanLimits, capability string) ValidationRes
 	}
 }
 
+// =============================================================================
+// Resource Quotas
+// =============================================================================
+
+// DeploymentUsage is one of a customer's deployments as counted against
+// their resource quota.
+type DeploymentUsage struct {
+	Status    string
+	Resources Resources
+}
+
+// IsActive reports whether a deployment in status holds its resources:
+// from creation until it stops, fails or is deleted.
+func IsActive(status string) bool {
+	switch status {
+	case "pending", "scheduled", "starting", "running", "stopping":
+		return true
+	}
+	return false
+}
+
+// SumUsage sums the resources of a customer's active deployments.
+func SumUsage(deployments []DeploymentUsage) CurrentUsage {
+	var usage CurrentUsage
+	for _, d := range deployments {
+		if !IsActive(d.Status) {
+			continue
+		}
+		usage.DeploymentCount++
+		usage.TotalCPUCores += d.Resources.CPUCores
+		usage.TotalMemoryMB += d.Resources.MemoryMB
+		usage.TotalDiskMB += d.Resources.DiskMB
+	}
+	return usage
+}
+
+// Quota resources, as named in QuotaError.Resource.
+const (
+	QuotaCPU    = "cpu_cores"
+	QuotaMemory = "memory_mb"
+	QuotaDisk   = "disk_mb"
+)
+
+// QuotaError reports a deployment that would take its customer's active
+// deployments over their plan's quota.
+type QuotaError struct {
+	// Resource is the first resource over quota: QuotaCPU, QuotaMemory or QuotaDisk
+	Resource string
+
+	Quota     Resources
+	Usage     CurrentUsage
+	Requested Resources
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Resource {
+	case QuotaCPU:
+		return fmt.Sprintf("CPU quota exceeded: %g cores in use + %g requested > %g",
+			e.Usage.TotalCPUCores, e.Requested.CPUCores, e.Quota.CPUCores)
+	case QuotaMemory:
+		return fmt.Sprintf("memory quota exceeded: %d MB in use + %d requested > %d",
+			e.Usage.TotalMemoryMB, e.Requested.MemoryMB, e.Quota.MemoryMB)
+	default:
+		return fmt.Sprintf("disk quota exceeded: %d MB in use + %d requested > %d",
+			e.Usage.TotalDiskMB, e.Requested.DiskMB, e.Quota.DiskMB)
+	}
+}
+
+// CheckQuota checks that a deployment requesting resources fits in quota
+// alongside the customer's active deployments. A zero quota field is
+// unlimited, unlike ValidateDeploymentCreation's limits, so plans that
+// predate resource quotas keep working. It returns nil or a *QuotaError.
+func CheckQuota(quota Resources, usage CurrentUsage, requested Resources) error {
+	fail := func(resource string) error {
+		return &QuotaError{Resource: resource, Quota: quota, Usage: usage, Requested: requested}
+	}
+	if quota.CPUCores > 0 && usage.TotalCPUCores+requested.CPUCores > quota.CPUCores+cpuEpsilon {
+		return fail(QuotaCPU)
+	}
+	if quota.MemoryMB > 0 && usage.TotalMemoryMB+requested.MemoryMB > quota.MemoryMB {
+		return fail(QuotaMemory)
+	}
+	if quota.DiskMB > 0 && usage.TotalDiskMB+requested.DiskMB > quota.DiskMB {
+		return fail(QuotaDisk)
+	}
+	return nil
+}
+
+// cpuEpsilon absorbs float rounding when fractional cores add up to the quota.
+const cpuEpsilon = 1e-9
+
 // =============================================================================
 // Convenience Methods
 // =============================================================================
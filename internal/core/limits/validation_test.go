@@ -259,3 +259,48 @@ func TestValidationResult_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "plan limit exceeded")
 	assert.Contains(t, err.Error(), "limit exceeded")
 }
+
+func TestSumUsage_CountsActiveDeployments(t *testing.T) {
+	usage := SumUsage([]DeploymentUsage{
+		{Status: "running", Resources: Resources{CPUCores: 1.5, MemoryMB: 1024, DiskMB: 2048}},
+		{Status: "pending", Resources: Resources{CPUCores: 0.5, MemoryMB: 512, DiskMB: 1024}},
+		{Status: "stopped", Resources: Resources{CPUCores: 4, MemoryMB: 8192, DiskMB: 10240}},
+		{Status: "failed", Resources: Resources{CPUCores: 4, MemoryMB: 8192, DiskMB: 10240}},
+		{Status: "deleted", Resources: Resources{CPUCores: 4, MemoryMB: 8192, DiskMB: 10240}},
+	})
+
+	assert.Equal(t, CurrentUsage{
+		DeploymentCount: 2,
+		TotalCPUCores:   2.0,
+		TotalMemoryMB:   1536,
+		TotalDiskMB:     3072,
+	}, usage)
+}
+
+func TestCheckQuota(t *testing.T) {
+	quota := Resources{CPUCores: 4, MemoryMB: 4096, DiskMB: 10240}
+	usage := CurrentUsage{DeploymentCount: 2, TotalCPUCores: 2.9, TotalMemoryMB: 3072, TotalDiskMB: 8192}
+
+	assert.NoError(t, CheckQuota(quota, usage, Resources{CPUCores: 1.1, MemoryMB: 1024, DiskMB: 2048}), "exactly at quota")
+
+	err := CheckQuota(quota, usage, Resources{CPUCores: 0.5, MemoryMB: 2048, DiskMB: 1024})
+	var qe *QuotaError
+	assert.ErrorAs(t, err, &qe)
+	assert.Equal(t, QuotaMemory, qe.Resource)
+	assert.Equal(t, usage, qe.Usage)
+	assert.Equal(t, "memory quota exceeded: 3072 MB in use + 2048 requested > 4096", err.Error())
+
+	err = CheckQuota(quota, usage, Resources{CPUCores: 2})
+	assert.ErrorAs(t, err, &qe)
+	assert.Equal(t, QuotaCPU, qe.Resource)
+
+	err = CheckQuota(quota, usage, Resources{DiskMB: 4096})
+	assert.ErrorAs(t, err, &qe)
+	assert.Equal(t, QuotaDisk, qe.Resource)
+}
+
+func TestCheckQuota_ZeroIsUnlimited(t *testing.T) {
+	usage := CurrentUsage{DeploymentCount: 50, TotalCPUCores: 100, TotalMemoryMB: 1 << 20, TotalDiskMB: 1 << 30}
+	assert.NoError(t, CheckQuota(Resources{}, usage, Resources{CPUCores: 8, MemoryMB: 16384, DiskMB: 102400}))
+	assert.Error(t, CheckQuota(Resources{MemoryMB: 1024}, usage, Resources{}), "one quota set")
+}
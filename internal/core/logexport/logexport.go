@@ -0,0 +1,162 @@
+// Package logexport provides pure functions for exporting a deployment's
+// container logs to a downloadable archive: validating the requested time
+// range and services, the per-plan size cap, and the signed, expiring links
+// archives are downloaded through.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package logexport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// =============================================================================
+// Status
+// =============================================================================
+
+// Status is where an export is in its lifecycle.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusExpired   Status = "expired" // The archive was deleted after its link expired
+)
+
+// Active reports whether the export is still being produced.
+func (s Status) Active() bool {
+	return s == StatusPending || s == StatusRunning
+}
+
+// =============================================================================
+// Requests
+// =============================================================================
+
+const (
+	// DefaultRange is the time range exported when no start is given.
+	DefaultRange = 24 * time.Hour
+	// MaxRange bounds the time range of one export.
+	MaxRange = 7 * 24 * time.Hour
+)
+
+// Request is what to export: log lines between Since and Until from the
+// named services.
+type Request struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Services []string  `json:"services"`
+}
+
+// Normalize fills in defaults and validates a request against the
+// deployment's services. Until defaults to now, Since to DefaultRange before
+// Until, and Services to every service. Services are returned sorted.
+func (r Request) Normalize(now time.Time, available []string) (Request, error) {
+	out := Request{Since: r.Since.UTC(), Until: r.Until.UTC()}
+	if r.Until.IsZero() || r.Until.After(now) {
+		out.Until = now.UTC()
+	}
+	if r.Since.IsZero() {
+		out.Since = out.Until.Add(-DefaultRange)
+	}
+	if !out.Since.Before(out.Until) {
+		return Request{}, fmt.Errorf("since must be before until")
+	}
+	if out.Until.Sub(out.Since) > MaxRange {
+		return Request{}, fmt.Errorf("time range must be at most %s", MaxRange)
+	}
+
+	known := make(map[string]bool, len(available))
+	for _, s := range available {
+		known[s] = true
+	}
+	if len(available) == 0 {
+		return Request{}, fmt.Errorf("deployment has no containers to export logs from")
+	}
+	if len(r.Services) == 0 {
+		out.Services = append([]string(nil), available...)
+	}
+	seen := map[string]bool{}
+	for _, s := range r.Services {
+		if !known[s] {
+			return Request{}, fmt.Errorf("unknown service %q", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			out.Services = append(out.Services, s)
+		}
+	}
+	sort.Strings(out.Services)
+	return out, nil
+}
+
+// =============================================================================
+// Size Caps
+// =============================================================================
+
+// DefaultCapMB caps exports of plans without a log export limit.
+const DefaultCapMB = 50
+
+// CapBytes returns the uncompressed size cap for a plan's limit in MB.
+func CapBytes(planMB int64) int64 {
+	if planMB <= 0 {
+		planMB = DefaultCapMB
+	}
+	return planMB << 20
+}
+
+// EntryName is the archive entry holding a service's logs.
+func EntryName(service string) string {
+	return service + ".log"
+}
+
+// TruncationNotice is appended to the entry where the cap was reached.
+func TruncationNotice(capBytes int64) string {
+	return fmt.Sprintf("\n[hoster] log export truncated: size cap of %d MB reached\n", capBytes>>20)
+}
+
+// =============================================================================
+// Signed Links
+// =============================================================================
+
+// DefaultLinkTTL is how long an archive can be downloaded after it is ready.
+const DefaultLinkTTL = 24 * time.Hour
+
+// Link errors.
+var (
+	ErrLinkExpired   = errors.New("download link has expired")
+	ErrBadSignature  = errors.New("download link signature is invalid")
+	ErrMalformedLink = errors.New("download link is malformed")
+)
+
+// Sign returns the signature of a download link for export id expiring at
+// expires.
+func Sign(key []byte, id string, expires time.Time) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a download link's expires (Unix seconds) and signature
+// query parameters.
+func Verify(key []byte, id, expires, signature string, now time.Time) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || signature == "" {
+		return ErrMalformedLink
+	}
+	at := time.Unix(unix, 0)
+	if !hmac.Equal([]byte(Sign(key, id, at)), []byte(signature)) {
+		return ErrBadSignature
+	}
+	if !now.Before(at) {
+		return ErrLinkExpired
+	}
+	return nil
+}
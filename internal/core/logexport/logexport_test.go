@@ -0,0 +1,68 @@
+package logexport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus_Active(t *testing.T) {
+	assert.True(t, StatusPending.Active())
+	assert.True(t, StatusRunning.Active())
+	assert.False(t, StatusCompleted.Active())
+	assert.False(t, StatusExpired.Active())
+}
+
+func TestRequest_NormalizeDefaults(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	r, err := Request{}.Normalize(now, []string{"web", "db"})
+	require.NoError(t, err)
+	assert.Equal(t, now, r.Until)
+	assert.Equal(t, now.Add(-DefaultRange), r.Since)
+	assert.Equal(t, []string{"db", "web"}, r.Services)
+
+	r, err = Request{Until: now.Add(time.Hour), Services: []string{"web", "web"}}.Normalize(now, []string{"web", "db"})
+	require.NoError(t, err)
+	assert.Equal(t, now, r.Until, "future until is clamped to now")
+	assert.Equal(t, []string{"web"}, r.Services)
+}
+
+func TestRequest_NormalizeErrors(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	services := []string{"web"}
+
+	_, err := Request{Since: now, Until: now.Add(-time.Hour)}.Normalize(now, services)
+	assert.Error(t, err)
+	_, err = Request{Since: now.Add(-MaxRange - time.Hour)}.Normalize(now, services)
+	assert.Error(t, err)
+	_, err = Request{Services: []string{"worker"}}.Normalize(now, services)
+	assert.Error(t, err)
+	_, err = Request{}.Normalize(now, nil)
+	assert.Error(t, err)
+}
+
+func TestCapBytes(t *testing.T) {
+	assert.Equal(t, int64(DefaultCapMB)<<20, CapBytes(0))
+	assert.Equal(t, int64(100)<<20, CapBytes(100))
+	assert.Contains(t, TruncationNotice(CapBytes(10)), "10 MB")
+	assert.Equal(t, "web.log", EntryName("web"))
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(DefaultLinkTTL)
+	sig := Sign(key, "lexp_1", expires)
+	exp := "1792411200" // now + 24h
+	require.Equal(t, expires.Unix(), int64(1792411200))
+
+	assert.NoError(t, Verify(key, "lexp_1", exp, sig, now))
+	assert.ErrorIs(t, Verify(key, "lexp_1", exp, sig, expires), ErrLinkExpired)
+	assert.ErrorIs(t, Verify(key, "lexp_2", exp, sig, now), ErrBadSignature)
+	assert.ErrorIs(t, Verify([]byte("other"), "lexp_1", exp, sig, now), ErrBadSignature)
+	assert.ErrorIs(t, Verify(key, "lexp_1", "1792411201", sig, now), ErrBadSignature, "expiry cannot be extended")
+	assert.ErrorIs(t, Verify(key, "lexp_1", "soon", sig, now), ErrMalformedLink)
+	assert.ErrorIs(t, Verify(key, "lexp_1", exp, "", now), ErrMalformedLink)
+}
@@ -0,0 +1,120 @@
+// Package metering provides pure functions for metering deployments'
+// resource usage from periodic container stats: CPU-seconds, memory
+// megabyte-hours and egress bytes, accrued per billing hour.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package metering
+
+import (
+	"math"
+	"time"
+)
+
+// =============================================================================
+// Readings
+// =============================================================================
+
+// Reading is one sample of a deployment's containers.
+type Reading struct {
+	At time.Time
+	// CPUPercent is the deployment's CPU use, summed over its containers;
+	// 100 is one core.
+	CPUPercent float64
+	// MemoryBytes is the deployment's memory use, summed over its containers.
+	MemoryBytes int64
+	// TxBytes are the bytes each container has sent since it started, by
+	// container ID.
+	TxBytes map[string]int64
+}
+
+// Egress returns the bytes sent between two readings' counters. A counter
+// lower than before belongs to a restarted container, and counts from zero;
+// so does a new container's.
+func Egress(prev, cur map[string]int64) int64 {
+	var total int64
+	for id, n := range cur {
+		if p, ok := prev[id]; ok && n >= p {
+			total += n - p
+		} else {
+			total += n
+		}
+	}
+	return total
+}
+
+// =============================================================================
+// Usage
+// =============================================================================
+
+// Usage is resource usage accrued over a period.
+type Usage struct {
+	CPUSeconds      float64
+	MemoryMBSeconds float64
+	EgressBytes     int64
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		CPUSeconds:      u.CPUSeconds + o.CPUSeconds,
+		MemoryMBSeconds: u.MemoryMBSeconds + o.MemoryMBSeconds,
+		EgressBytes:     u.EgressBytes + o.EgressBytes,
+	}
+}
+
+// CPUSecondsQuantity is the usage's whole CPU-seconds, rounded.
+func (u Usage) CPUSecondsQuantity() int64 {
+	return int64(math.Round(u.CPUSeconds))
+}
+
+// MemoryMBHoursQuantity is the usage's whole memory megabyte-hours, rounded.
+func (u Usage) MemoryMBHoursQuantity() int64 {
+	return int64(math.Round(u.MemoryMBSeconds / 3600))
+}
+
+// Hour returns the billing hour t falls in.
+func Hour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// Accrue returns the usage between two readings by billing hour. CPU and
+// memory are taken to have held at cur's values since prev, and egress is
+// spread evenly over the interval. An interval longer than maxGap, such as
+// while a node was unreachable, is shortened to the maxGap before cur, and
+// its egress is all counted. It returns nil when cur is not after prev.
+func Accrue(prev, cur Reading, maxGap time.Duration) map[time.Time]Usage {
+	from, to := prev.At, cur.At
+	if !to.After(from) {
+		return nil
+	}
+	if maxGap > 0 && to.Sub(from) > maxGap {
+		from = to.Add(-maxGap)
+	}
+	total := to.Sub(from).Seconds()
+	egress := Egress(prev.TxBytes, cur.TxBytes)
+	memoryMB := float64(cur.MemoryBytes) / (1 << 20)
+
+	usage := map[time.Time]Usage{}
+	var spread int64
+	for start := from; start.Before(to); {
+		hour := Hour(start)
+		end := hour.Add(time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		secs := end.Sub(start).Seconds()
+		u := Usage{
+			CPUSeconds:      cur.CPUPercent / 100 * secs,
+			MemoryMBSeconds: memoryMB * secs,
+		}
+		if end.Equal(to) {
+			// The last hour takes the rounding remainder
+			u.EgressBytes = egress - spread
+		} else {
+			u.EgressBytes = int64(float64(egress) * secs / total)
+			spread += u.EgressBytes
+		}
+		usage[hour] = usage[hour].Add(u)
+		start = end
+	}
+	return usage
+}
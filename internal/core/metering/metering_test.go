@@ -0,0 +1,86 @@
+package metering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// =============================================================================
+// Egress Tests
+// =============================================================================
+
+func TestEgress(t *testing.T) {
+	prev := map[string]int64{"a": 1000, "b": 500, "gone": 50}
+	cur := map[string]int64{"a": 1500, "b": 200, "new": 70}
+	// a sent 500; b restarted and sent 200; new sent 70
+	assert.Equal(t, int64(770), Egress(prev, cur))
+	assert.Equal(t, int64(0), Egress(cur, cur))
+	assert.Equal(t, int64(1770), Egress(nil, cur))
+}
+
+// =============================================================================
+// Accrue Tests
+// =============================================================================
+
+func TestAccrue_WithinHour(t *testing.T) {
+	prev := Reading{At: base.Add(10 * time.Minute), TxBytes: map[string]int64{"a": 100}}
+	cur := Reading{At: base.Add(15 * time.Minute), CPUPercent: 50, MemoryBytes: 256 << 20, TxBytes: map[string]int64{"a": 1100}}
+
+	usage := Accrue(prev, cur, time.Hour)
+	require.Len(t, usage, 1)
+	u := usage[base]
+	assert.InDelta(t, 150, u.CPUSeconds, 1e-9)          // half a core for 300s
+	assert.InDelta(t, 256*300, u.MemoryMBSeconds, 1e-9) // 256 MB for 300s
+	assert.Equal(t, int64(1000), u.EgressBytes)
+}
+
+func TestAccrue_SplitsAcrossHours(t *testing.T) {
+	prev := Reading{At: base.Add(-5 * time.Minute), TxBytes: map[string]int64{"a": 0}}
+	cur := Reading{At: base.Add(15 * time.Minute), CPUPercent: 100, MemoryBytes: 1 << 20, TxBytes: map[string]int64{"a": 1001}}
+
+	usage := Accrue(prev, cur, time.Hour)
+	require.Len(t, usage, 2)
+	before, after := usage[base.Add(-time.Hour)], usage[base]
+	assert.InDelta(t, 300, before.CPUSeconds, 1e-9)
+	assert.InDelta(t, 900, after.CPUSeconds, 1e-9)
+	assert.InDelta(t, 900, after.MemoryMBSeconds, 1e-9)
+	assert.Equal(t, int64(250), before.EgressBytes)
+	assert.Equal(t, int64(751), after.EgressBytes)
+}
+
+func TestAccrue_ClipsGaps(t *testing.T) {
+	prev := Reading{At: base, TxBytes: map[string]int64{"a": 0}}
+	cur := Reading{At: base.Add(3*time.Hour + 30*time.Minute), CPUPercent: 100, TxBytes: map[string]int64{"a": 500}}
+
+	usage := Accrue(prev, cur, 10*time.Minute)
+	require.Len(t, usage, 1)
+	u := usage[base.Add(3*time.Hour)]
+	assert.InDelta(t, 600, u.CPUSeconds, 1e-9)
+	assert.Equal(t, int64(500), u.EgressBytes)
+}
+
+func TestAccrue_NotAfter(t *testing.T) {
+	assert.Nil(t, Accrue(Reading{At: base}, Reading{At: base}, time.Hour))
+	assert.Nil(t, Accrue(Reading{At: base}, Reading{At: base.Add(-time.Minute)}, time.Hour))
+}
+
+// =============================================================================
+// Usage Tests
+// =============================================================================
+
+func TestUsageQuantities(t *testing.T) {
+	u := Usage{CPUSeconds: 12.6, MemoryMBSeconds: 512 * 3600, EgressBytes: 10}.Add(Usage{CPUSeconds: 0.2, MemoryMBSeconds: 1800})
+	assert.Equal(t, int64(13), u.CPUSecondsQuantity())
+	assert.Equal(t, int64(513), u.MemoryMBHoursQuantity())
+	assert.Equal(t, int64(10), u.EgressBytes)
+}
+
+func TestHour(t *testing.T) {
+	loc := time.FixedZone("x", 5*3600+1800)
+	assert.Equal(t, base, Hour(time.Date(2026, 10, 1, 18, 10, 0, 0, loc)))
+}
@@ -0,0 +1,150 @@
+// Package metrics provides pure functions for hoster's own Prometheus
+// metrics: duration histograms, and writing metric families in the text
+// exposition format.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Histograms
+// =============================================================================
+
+// RequestBuckets are the upper bounds of the API request duration histogram.
+var RequestBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LoopBuckets are the upper bounds of the worker loop duration histogram.
+var LoopBuckets = []time.Duration{
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+}
+
+// Histogram counts durations at or under each of its bounds.
+type Histogram struct {
+	Bounds []time.Duration
+	// Counts are cumulative: Counts[i] is the observations at or under
+	// Bounds[i].
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+	// Last is the most recent observation.
+	Last time.Duration
+}
+
+// NewHistogram returns an empty histogram with the bounds.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]int64, len(bounds))}
+}
+
+// Observe adds one duration to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	h.Count++
+	h.Sum += d
+	h.Last = d
+	for i, bound := range h.Bounds {
+		if d <= bound {
+			h.Counts[i]++
+		}
+	}
+}
+
+// Clone returns a copy of the histogram that shares no counts with it.
+func (h Histogram) Clone() Histogram {
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
+
+// =============================================================================
+// Text Exposition
+// =============================================================================
+
+// Label is a metric label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels pairs up names and values: Labels("status", "running").
+func Labels(nameValues ...string) []Label {
+	labels := make([]Label, 0, len(nameValues)/2)
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		labels = append(labels, Label{Name: nameValues[i], Value: nameValues[i+1]})
+	}
+	return labels
+}
+
+// Writer builds a Prometheus text exposition. Each family's HELP and TYPE
+// are written before its samples.
+type Writer struct {
+	b strings.Builder
+}
+
+// Family starts a metric family of the type: counter, gauge or histogram.
+func (w *Writer) Family(name, typ, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample writes one sample.
+func (w *Writer) Sample(name string, labels []Label, value float64) {
+	w.b.WriteString(name)
+	w.b.WriteString(formatLabels(labels))
+	w.b.WriteByte(' ')
+	w.b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.b.WriteByte('\n')
+}
+
+// Histogram writes the bucket, sum and count samples of one histogram.
+func (w *Writer) Histogram(name string, labels []Label, h Histogram) {
+	for i, bound := range h.Bounds {
+		w.Sample(name+"_bucket", append(slices.Clone(labels), Label{"le", seconds(bound)}), float64(h.Counts[i]))
+	}
+	w.Sample(name+"_bucket", append(slices.Clone(labels), Label{"le", "+Inf"}), float64(h.Count))
+	w.Sample(name+"_sum", labels, h.Sum.Seconds())
+	w.Sample(name+"_count", labels, float64(h.Count))
+}
+
+// String returns the exposition written so far.
+func (w *Writer) String() string {
+	return w.b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.Name + `="` + labelEscaper.Replace(l.Value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Histogram Tests
+// =============================================================================
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram([]time.Duration{10 * time.Millisecond, time.Second})
+	h.Observe(5 * time.Millisecond)
+	h.Observe(500 * time.Millisecond)
+	h.Observe(2 * time.Second)
+
+	assert.Equal(t, []int64{1, 2}, h.Counts)
+	assert.Equal(t, int64(3), h.Count)
+	assert.Equal(t, 2505*time.Millisecond, h.Sum)
+	assert.Equal(t, 2*time.Second, h.Last)
+}
+
+func TestHistogram_Clone(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Second})
+	c := h.Clone()
+	h.Observe(time.Millisecond)
+	assert.Equal(t, []int64{0}, c.Counts)
+	assert.Equal(t, int64(0), c.Count)
+}
+
+// =============================================================================
+// Writer Tests
+// =============================================================================
+
+func TestWriter(t *testing.T) {
+	var w Writer
+	w.Family("hoster_deployments", "gauge", "Deployments by status.")
+	w.Sample("hoster_deployments", Labels("status", "running"), 3)
+	w.Sample("hoster_up", nil, 1)
+
+	assert.Equal(t, `# HELP hoster_deployments Deployments by status.
+# TYPE hoster_deployments gauge
+hoster_deployments{status="running"} 3
+hoster_up 1
+`, w.String())
+}
+
+func TestWriter_Histogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{100 * time.Millisecond, time.Second})
+	h.Observe(50 * time.Millisecond)
+	h.Observe(1500 * time.Millisecond)
+
+	var w Writer
+	w.Histogram("x_seconds", Labels("route", "/a"), *h)
+	assert.Equal(t, `x_seconds_bucket{route="/a",le="0.1"} 1
+x_seconds_bucket{route="/a",le="1"} 1
+x_seconds_bucket{route="/a",le="+Inf"} 2
+x_seconds_sum{route="/a"} 1.55
+x_seconds_count{route="/a"} 2
+`, w.String())
+}
+
+func TestWriter_EscapesLabels(t *testing.T) {
+	var w Writer
+	w.Sample("m", Labels("v", "a\"b\\c\nd"), 1)
+	assert.Equal(t, "m{v=\"a\\\"b\\\\c\\nd\"} 1\n", w.String())
+}
+
+func TestLabels_OddArgs(t *testing.T) {
+	assert.Equal(t, []Label{{"a", "1"}}, Labels("a", "1", "b"))
+}
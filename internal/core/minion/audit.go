@@ -0,0 +1,157 @@
+package minion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Command Policy
+// =============================================================================
+//
+// A node operator can restrict which commands the minion executes with a
+// policy file (~/.hoster/policy.json by default). Without a policy file every
+// command is allowed. The handshake and audit commands are always allowed so
+// the backend can still verify the minion and collect its audit log.
+
+// AlwaysAllowedCommands run regardless of the command policy.
+var AlwaysAllowedCommands = []string{"version", "audit-log"}
+
+// CommandPolicy restricts the commands a minion executes. Patterns are command
+// names, or a prefix followed by "*" (e.g. "volume-*"). An empty Allow list
+// allows every command not matched by Deny.
+type CommandPolicy struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// ParseCommandPolicy parses and validates a policy file.
+func ParseCommandPolicy(data []byte) (CommandPolicy, error) {
+	var p CommandPolicy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return CommandPolicy{}, fmt.Errorf("parse command policy: %w", err)
+	}
+	for _, pattern := range append(append([]string(nil), p.Allow...), p.Deny...) {
+		if err := validateCommandPattern(pattern); err != nil {
+			return CommandPolicy{}, err
+		}
+	}
+	return p, nil
+}
+
+func validateCommandPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("command policy: empty pattern")
+	}
+	if i := strings.Index(pattern, "*"); i >= 0 && i != len(pattern)-1 {
+		return fmt.Errorf("command policy: %q: \"*\" is only allowed at the end", pattern)
+	}
+	return nil
+}
+
+// Allows reports whether the policy lets the minion execute a command.
+// Deny takes precedence over Allow.
+func (p CommandPolicy) Allows(command string) bool {
+	for _, c := range AlwaysAllowedCommands {
+		if command == c {
+			return true
+		}
+	}
+	if matchesAnyCommand(p.Deny, command) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchesAnyCommand(p.Allow, command)
+}
+
+func matchesAnyCommand(patterns []string, command string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(command, prefix) {
+				return true
+			}
+		} else if pattern == command {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Audit Log
+// =============================================================================
+//
+// The minion appends one JSON line per command to its audit log
+// (~/.hoster/audit.log by default), including refused ones. The backend
+// passes its request ID in the RequestIDEnv environment variable so entries
+// can be matched with backend logs, and collects the log with "audit-log".
+
+// RequestIDEnv is the environment variable carrying the caller's request ID.
+const RequestIDEnv = "HOSTER_REQUEST_ID"
+
+// maxRequestIDLength bounds request IDs passed to the minion.
+const maxRequestIDLength = 64
+
+// ValidRequestID reports whether id can be passed to the minion. Only
+// letters, digits, '-', '_' and '.' are allowed, as the ID is part of the
+// command line.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AuditEntry is one line of the minion's audit log. Command input read from
+// stdin is never logged, as it may carry secrets.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Command    string    `json:"command"`
+	Args       []string  `json:"args,omitempty"`
+	Allowed    bool      `json:"allowed"` // False when the command policy refused it
+	Success    bool      `json:"success"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLogOptions are passed to "audit-log" via stdin.
+type AuditLogOptions struct {
+	Since time.Time `json:"since,omitempty"` // Only entries after this time
+	Limit int       `json:"limit,omitempty"` // Max entries returned (default 500)
+}
+
+// DefaultAuditLogLimit is how many entries "audit-log" returns by default.
+const DefaultAuditLogLimit = 500
+
+// AuditLog is returned by "audit-log": the oldest entries after Since, up to
+// Limit. When Truncated is set, the caller collects the rest by passing the
+// last entry's time as Since.
+type AuditLog struct {
+	Entries   []AuditEntry `json:"entries"`
+	Truncated bool         `json:"truncated"`
+}
+
+// Accepts reports whether an entry is selected by the options, ignoring Limit.
+func (o AuditLogOptions) Accepts(e AuditEntry) bool {
+	return o.Since.IsZero() || e.Time.After(o.Since)
+}
+
+// EffectiveLimit returns Limit, or DefaultAuditLogLimit when it is not set.
+func (o AuditLogOptions) EffectiveLimit() int {
+	if o.Limit <= 0 {
+		return DefaultAuditLogLimit
+	}
+	return o.Limit
+}
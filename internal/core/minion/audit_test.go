@@ -0,0 +1,71 @@
+package minion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Command Policy Tests
+// =============================================================================
+
+func TestParseCommandPolicy(t *testing.T) {
+	p, err := ParseCommandPolicy([]byte(`{"allow": ["ping", "volume-*"], "deny": ["volume-restore"]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ping", "volume-*"}, p.Allow)
+	assert.Equal(t, []string{"volume-restore"}, p.Deny)
+
+	_, err = ParseCommandPolicy([]byte(`{"allowed": ["ping"]}`))
+	assert.Error(t, err, "unknown fields are rejected")
+	_, err = ParseCommandPolicy([]byte(`{"allow": [""]}`))
+	assert.Error(t, err)
+	_, err = ParseCommandPolicy([]byte(`{"deny": ["volume-*-restore"]}`))
+	assert.Error(t, err)
+	_, err = ParseCommandPolicy([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestCommandPolicy_Allows(t *testing.T) {
+	p := CommandPolicy{Allow: []string{"ping", "volume-*"}, Deny: []string{"volume-restore"}}
+	assert.True(t, p.Allows("ping"))
+	assert.True(t, p.Allows("volume-usage"))
+	assert.False(t, p.Allows("volume-restore"), "deny wins over allow")
+	assert.False(t, p.Allows("image-save"))
+	assert.True(t, p.Allows("version"), "handshake is always allowed")
+	assert.True(t, p.Allows("audit-log"))
+
+	denyOnly := CommandPolicy{Deny: []string{"image-*"}}
+	assert.True(t, denyOnly.Allows("create-container"))
+	assert.False(t, denyOnly.Allows("image-load"))
+
+	assert.True(t, CommandPolicy{}.Allows("anything"))
+	assert.False(t, CommandPolicy{Deny: []string{"*"}}.Allows("ping"))
+	assert.True(t, CommandPolicy{Deny: []string{"*"}}.Allows("version"))
+}
+
+// =============================================================================
+// Audit Log Tests
+// =============================================================================
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, ValidRequestID("req_a1B2c3.d-4"))
+	assert.False(t, ValidRequestID(""))
+	assert.False(t, ValidRequestID("req; rm -rf /"))
+	assert.False(t, ValidRequestID("req$(id)"))
+	assert.False(t, ValidRequestID(string(make([]byte, 65))))
+}
+
+func TestAuditLogOptions(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	entry := AuditEntry{Time: at, Command: "ping"}
+
+	assert.True(t, AuditLogOptions{}.Accepts(entry))
+	assert.True(t, AuditLogOptions{Since: at.Add(-time.Second)}.Accepts(entry))
+	assert.False(t, AuditLogOptions{Since: at}.Accepts(entry), "since is exclusive")
+
+	assert.Equal(t, DefaultAuditLogLimit, AuditLogOptions{}.EffectiveLimit())
+	assert.Equal(t, 10, AuditLogOptions{Limit: 10}.EffectiveLimit())
+}
@@ -10,6 +10,7 @@ package minion
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -19,7 +20,7 @@ import (
 
 // Version is the current minion protocol version.
 // Bump MAJOR for breaking changes, MINOR for new commands, PATCH for fixes.
-const Version = "1.1.0"
+const Version = "1.20.0"
 
 // =============================================================================
 // Response Envelope
@@ -68,8 +69,13 @@ func NewErrorResponse(command, code, message string) *Response {
 	}
 }
 
-// ParseResponse parses a JSON response from the minion.
+// ParseResponse parses a JSON response from the minion, decompressing it
+// first if it is gzip-compressed.
 func ParseResponse(data []byte) (*Response, error) {
+	data, err := DecodeEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
 	var resp Response
 	if err := json.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("parse response: %w", err)
@@ -102,6 +108,9 @@ const (
 	ErrCodePullFailed      = "pull_failed"
 	ErrCodeInvalidInput    = "invalid_input"
 	ErrCodeInternal        = "internal"
+	ErrCodeChecksumMismatch = "checksum_mismatch"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeTooLarge        = "too_large"
 )
 
 // =============================================================================
@@ -109,10 +118,13 @@ const (
 // =============================================================================
 
 // VersionInfo is returned by the "version" command.
+// It doubles as the handshake the backend verifies before dispatching commands.
 type VersionInfo struct {
-	Version   string `json:"version"`
-	BuildTime string `json:"build_time"`
-	GoVersion string `json:"go_version"`
+	Version         string `json:"version"`
+	BuildTime       string `json:"build_time"`
+	GoVersion       string `json:"go_version"`
+	ProtocolVersion string `json:"protocol_version,omitempty"`
+	Commit          string `json:"commit,omitempty"`
 }
 
 // PingInfo is returned by the "ping" command.
@@ -134,6 +146,165 @@ type SystemInfo struct {
 	DiskUsedMB    int64   `json:"disk_used_mb"`
 }
 
+// NodeMetrics is returned by the "node-metrics" command.
+// It describes the health of the node itself, independent of containers.
+type NodeMetrics struct {
+	LoadAvg1      float64        `json:"load_avg_1"`
+	LoadAvg5      float64        `json:"load_avg_5"`
+	LoadAvg15     float64        `json:"load_avg_15"`
+	CPUCores      float64        `json:"cpu_cores"`
+	CPUUsedPct    float64        `json:"cpu_used_percent"`
+	MemoryTotalMB int64          `json:"memory_total_mb"`
+	MemoryUsedMB  int64          `json:"memory_used_mb"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Disks         []DiskUsage    `json:"disks"`
+	DockerdStatus string         `json:"dockerd_status"` // active, inactive, failed, unknown
+	JournalErrors []JournalEntry `json:"journal_errors,omitempty"`
+	CollectedAt   time.Time      `json:"collected_at"`
+}
+
+// DiskUsage is the usage of one mounted filesystem.
+type DiskUsage struct {
+	Mount   string `json:"mount"`
+	TotalMB int64  `json:"total_mb"`
+	UsedMB  int64  `json:"used_mb"`
+}
+
+// UsedPercent returns disk usage as a percentage (0 if the size is unknown).
+func (d DiskUsage) UsedPercent() float64 {
+	if d.TotalMB <= 0 {
+		return 0
+	}
+	return float64(d.UsedMB) / float64(d.TotalMB) * 100
+}
+
+// JournalEntry is an error-priority line from the system journal.
+type JournalEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Unit      string    `json:"unit,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// NodeMetricsOptions are passed to "node-metrics" via stdin.
+type NodeMetricsOptions struct {
+	Mounts         []string `json:"mounts,omitempty"`           // Filesystems to report (default "/" and "/var/lib/docker")
+	JournalSince   string   `json:"journal_since,omitempty"`    // journalctl --since value (default "-1h")
+	JournalMaxRows int      `json:"journal_max_rows,omitempty"` // Max journal errors returned (default 20)
+}
+
+// NetworkAddresses is returned by the "network-addresses" command.
+// PublicAddress is empty when neither a metadata service nor STUN answered.
+type NetworkAddresses struct {
+	PrivateAddress string `json:"private_address,omitempty"` // Address of the interface with the default route
+	PublicAddress  string `json:"public_address,omitempty"`  // Address the internet sees the node at
+	Source         string `json:"source,omitempty"`          // "metadata" or "stun"
+	Provider       string `json:"provider,omitempty"`        // Metadata service that answered, e.g. "aws"
+	Error          string `json:"error,omitempty"`           // Why no public address was found
+}
+
+// NetworkAddressOptions are passed to "network-addresses" via stdin.
+type NetworkAddressOptions struct {
+	STUNServers  []string `json:"stun_servers,omitempty"`  // host:port of STUN servers (default Google and Cloudflare)
+	SkipMetadata bool     `json:"skip_metadata,omitempty"` // Don't query cloud metadata services
+}
+
+// GPUInfo is returned by the "gpu-info" command. Devices is empty and Error
+// set when the node has no NVIDIA driver or nvidia-smi failed.
+type GPUInfo struct {
+	Driver      string      `json:"driver,omitempty"` // NVIDIA driver version
+	Devices     []GPUDevice `json:"devices"`
+	Error       string      `json:"error,omitempty"`
+	CollectedAt time.Time   `json:"collected_at"`
+}
+
+// GPUDevice is one GPU as reported by nvidia-smi.
+type GPUDevice struct {
+	Index          int     `json:"index"`
+	UUID           string  `json:"uuid"` // e.g. "GPU-5f1c...", used in container device requests
+	Model          string  `json:"model"`
+	MemoryMB       int64   `json:"memory_mb"`
+	MemoryUsedMB   int64   `json:"memory_used_mb"`
+	UtilizationPct float64 `json:"utilization_percent"`
+}
+
+// TraefikConfigInput is passed to "traefik-config" via stdin: a Traefik
+// dynamic configuration file to write on the node.
+type TraefikConfigInput struct {
+	Path    string `json:"path"`    // Absolute .yml or .yaml path
+	Content string `json:"content"` // Whole file
+}
+
+// TraefikConfigResult is returned by "traefik-config".
+type TraefikConfigResult struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"` // False when the file already had the content
+	Bytes   int    `json:"bytes"`
+}
+
+// WriteFileInput is passed to "write-file" via stdin: a file to write under
+// the minion's config directory, e.g. a deployment's rendered config file
+// for bind mounting. Dir and Name are single path elements.
+type WriteFileInput struct {
+	Dir     string `json:"dir"`            // Usually the deployment's reference ID
+	Name    string `json:"name"`           // File name within Dir
+	Content string `json:"content"`        // Whole file
+	Mode    uint32 `json:"mode,omitempty"` // Permission bits; 0 for 0644
+}
+
+// Validate checks that Dir and Name stay inside the config directory.
+func (in WriteFileInput) Validate() error {
+	for field, v := range map[string]string{"dir": in.Dir, "name": in.Name} {
+		if v == "" || v == "." || v == ".." || strings.ContainsAny(v, "/\\\x00") {
+			return fmt.Errorf("%s %q must be a single path element", field, v)
+		}
+	}
+	if in.Mode > 0o777 {
+		return fmt.Errorf("mode %o has bits other than permissions", in.Mode)
+	}
+	return nil
+}
+
+// WriteFileResult is returned by "write-file".
+type WriteFileResult struct {
+	Path    string `json:"path"`    // Absolute path on the node
+	Changed bool   `json:"changed"` // False when the file already had the content and mode
+	Bytes   int    `json:"bytes"`
+}
+
+// HousekeepingOptions are passed to "node-housekeeping" via stdin.
+// Prune tasks never touch containers, networks, or volumes labelled
+// com.hoster.managed, so stopped deployments keep their containers.
+type HousekeepingOptions struct {
+	DryRun           bool     `json:"dry_run"`                       // Report what would be reclaimed without changing anything
+	Tasks            []string `json:"tasks"`                         // Tasks to run (see core/housekeeping)
+	PruneUntil       string   `json:"prune_until,omitempty"`         // Docker "until" filter: only prune objects older than this
+	AllImages        bool     `json:"all_images,omitempty"`          // Prune all unused images, not just dangling ones
+	LogMaxSizeBytes  int64    `json:"log_max_size_bytes,omitempty"`  // json-file max-size enforced for container logs
+	LogMaxFiles      int      `json:"log_max_files,omitempty"`       // json-file max-file enforced for container logs
+	JournalMaxBytes  int64    `json:"journal_max_bytes,omitempty"`   // journalctl --vacuum-size target
+	TmpMaxAgeSeconds int64    `json:"tmp_max_age_seconds,omitempty"` // Remove tmp files not modified for this long
+	TmpDirs          []string `json:"tmp_dirs,omitempty"`            // Directories cleaned by the tmp task (default /tmp and /var/tmp)
+}
+
+// HousekeepingReport is returned by "node-housekeeping".
+type HousekeepingReport struct {
+	DryRun         bool                     `json:"dry_run"`
+	Tasks          []HousekeepingTaskResult `json:"tasks"`
+	ReclaimedBytes int64                    `json:"reclaimed_bytes"`
+	StartedAt      time.Time                `json:"started_at"`
+	FinishedAt     time.Time                `json:"finished_at"`
+}
+
+// HousekeepingTaskResult is the outcome of one housekeeping task. A failed
+// task sets Error; the remaining tasks still run.
+type HousekeepingTaskResult struct {
+	Task           string `json:"task"`
+	Items          int    `json:"items"`           // Objects removed (or that would be removed)
+	ReclaimedBytes int64  `json:"reclaimed_bytes"` // Space freed (or that would be freed)
+	Detail         string `json:"detail,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
 // CreateResult is returned when creating containers, networks, or volumes.
 type CreateResult struct {
 	ID string `json:"id"`
@@ -147,9 +318,92 @@ type VolumeCreateResult struct {
 // ImageExistsResult is returned by "image-exists" command.
 type ImageExistsResult struct {
 	Exists bool `json:"exists"`
+	// ID is the image's content-addressed config digest ("sha256:..."),
+	// identical on every node that holds the same image.
+	ID          string   `json:"id,omitempty"`
+	RepoDigests []string `json:"repo_digests,omitempty"`
 }
 
-// LogsResult is returned by "container-logs" command.
+// ImageDigestResult is returned by "image-digest": the digest an image tag
+// points at in its registry now, looked up without pulling the image.
+type ImageDigestResult struct {
+	Digest string `json:"digest"`
+}
+
+// ImageLoadResult is returned by "image-load" after loading a docker save
+// archive from stdin.
+type ImageLoadResult struct {
+	Size   int64    `json:"size"`   // Archive bytes read from stdin
+	SHA256 string   `json:"sha256"` // Hex SHA-256 of the archive bytes
+	Loaded []string `json:"loaded"` // Image references and IDs docker reported loading
+}
+
+// ProbeSpec is read by "probe-container": a TCP port to connect to, or a
+// command to run in the container. Exactly one is set. A command runs
+// unprivileged as User, and is killed when Timeout passes.
+type ProbeSpec struct {
+	TCPPort   int           `json:"tcp_port,omitempty"`
+	Command   []string      `json:"command,omitempty"`
+	Timeout   time.Duration `json:"timeout"`
+	User      string        `json:"user,omitempty"`       // Empty runs as the container's user
+	MaxOutput int           `json:"max_output,omitempty"` // Output bytes returned; the minion caps it too
+}
+
+// ProbeResult is returned by "probe-container". A failed probe is a result,
+// not a command error.
+type ProbeResult struct {
+	Passed    bool          `json:"passed"`
+	Output    string        `json:"output,omitempty"` // Failure reason or command output (truncated)
+	Duration  time.Duration `json:"duration"`
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"` // Output was cut at the output cap
+}
+
+// ExecSpec is read by "exec-container": a command to run in the container,
+// unprivileged and as User, with Stdin written to it. The command is killed
+// when Timeout passes.
+type ExecSpec struct {
+	Command    []string      `json:"command"`
+	Stdin      string        `json:"stdin,omitempty"`
+	User       string        `json:"user,omitempty"`        // Empty runs as the container's user
+	WorkingDir string        `json:"working_dir,omitempty"` // Empty uses the container's
+	Timeout    time.Duration `json:"timeout"`
+	MaxOutput  int           `json:"max_output,omitempty"` // Bytes kept of stdout and of stderr; the minion caps it too
+}
+
+// ExecResult is returned by "exec-container". A command that exits non-zero
+// or times out is a result, not a command error.
+type ExecResult struct {
+	ExitCode  int           `json:"exit_code"`
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	Duration  time.Duration `json:"duration"`
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"` // Output was cut at the output cap
+}
+
+// EventsOptions is read by "container-events": the time range of Docker
+// events to return and filters (e.g. {"label": "com.hoster.deployment=depl_x"}).
+type EventsOptions struct {
+	Since   time.Time         `json:"since"`
+	Until   time.Time         `json:"until"`
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// ContainerLifecycleEvent is a "die" or "oom" Docker event returned by
+// "container-events". ExitCode is only set on "die".
+type ContainerLifecycleEvent struct {
+	ContainerID string            `json:"container_id"`
+	Name        string            `json:"name"`
+	Action      string            `json:"action"`
+	ExitCode    int               `json:"exit_code,omitempty"`
+	Time        time.Time         `json:"time"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// LogsResult is returned by "container-logs" command. Logs over MaxLogBytes
+// are refused with ErrCodeTooLarge rather than cut short; minions with
+// chunked streams serve them with "container-logs-stream".
 type LogsResult struct {
 	Logs string `json:"logs"`
 }
@@ -161,20 +415,32 @@ type LogsResult struct {
 // ContainerSpec defines the specification for creating a container.
 // This mirrors internal/shell/docker.ContainerSpec but with JSON tags.
 type ContainerSpec struct {
-	Name          string            `json:"name"`
-	Image         string            `json:"image"`
-	Command       []string          `json:"command,omitempty"`
-	Entrypoint    []string          `json:"entrypoint,omitempty"`
-	Env           map[string]string `json:"env,omitempty"`
-	Labels        map[string]string `json:"labels,omitempty"`
-	Ports         []PortBinding     `json:"ports,omitempty"`
-	Volumes       []VolumeMount     `json:"volumes,omitempty"`
-	Networks      []string          `json:"networks,omitempty"`
-	WorkingDir    string            `json:"working_dir,omitempty"`
-	User          string            `json:"user,omitempty"`
-	RestartPolicy RestartPolicy     `json:"restart_policy,omitempty"`
-	Resources     ResourceLimits    `json:"resources,omitempty"`
-	HealthCheck   *HealthCheck      `json:"health_check,omitempty"`
+	Name              string              `json:"name"`
+	Image             string              `json:"image"`
+	Command           []string            `json:"command,omitempty"`
+	Entrypoint        []string            `json:"entrypoint,omitempty"`
+	Env               map[string]string   `json:"env,omitempty"`
+	Labels            map[string]string   `json:"labels,omitempty"`
+	Ports             []PortBinding       `json:"ports,omitempty"`
+	Volumes           []VolumeMount       `json:"volumes,omitempty"`
+	Networks          []string            `json:"networks,omitempty"`
+	WorkingDir        string              `json:"working_dir,omitempty"`
+	User              string              `json:"user,omitempty"`
+	RestartPolicy     RestartPolicy       `json:"restart_policy,omitempty"`
+	Resources         ResourceLimits      `json:"resources,omitempty"`
+	HealthCheck       *HealthCheck        `json:"health_check,omitempty"`
+	Init              *bool               `json:"init,omitempty"`
+	Ulimits           []Ulimit            `json:"ulimits,omitempty"`
+	DeviceCgroupRules []string            `json:"device_cgroup_rules,omitempty"`
+	NetworkAliases    map[string][]string `json:"network_aliases,omitempty"` // network -> hostnames
+	ExtraHosts        []string            `json:"extra_hosts,omitempty"`     // "host:ip"
+}
+
+// Ulimit defines a resource limit override.
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
 }
 
 // PortBinding defines a port mapping.
@@ -198,10 +464,18 @@ type RestartPolicy struct {
 	MaximumRetryCount int    `json:"maximum_retry_count,omitempty"`
 }
 
+// ContainerUpdate is the input of the "update-container" command: limits to
+// change on an existing container.
+type ContainerUpdate struct {
+	CPULimit float64 `json:"cpu_limit"` // CPU cores
+}
+
 // ResourceLimits defines resource constraints.
 type ResourceLimits struct {
 	CPULimit    float64 `json:"cpu_limit,omitempty"`    // CPU cores
 	MemoryLimit int64   `json:"memory_limit,omitempty"` // Bytes
+	// GPU UUIDs exposed to the container through the nvidia driver
+	GPUDeviceIDs []string `json:"gpu_device_ids,omitempty"`
 }
 
 // HealthCheck defines container health check configuration.
@@ -215,18 +489,20 @@ type HealthCheck struct {
 
 // ContainerInfo contains information about a container.
 type ContainerInfo struct {
-	ID         string            `json:"id"`
-	Name       string            `json:"name"`
-	Image      string            `json:"image"`
-	Status     string            `json:"status"` // "created", "running", etc.
-	State      string            `json:"state"`
-	Health     string            `json:"health,omitempty"` // "healthy", "unhealthy", "starting", ""
-	CreatedAt  time.Time         `json:"created_at"`
-	StartedAt  *time.Time        `json:"started_at,omitempty"`
-	FinishedAt *time.Time        `json:"finished_at,omitempty"`
-	Ports      []PortBinding     `json:"ports,omitempty"`
-	Labels     map[string]string `json:"labels,omitempty"`
-	ExitCode   int               `json:"exit_code,omitempty"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Image        string            `json:"image"`
+	Status       string            `json:"status"` // "created", "running", etc.
+	State        string            `json:"state"`
+	Health       string            `json:"health,omitempty"` // "healthy", "unhealthy", "starting", ""
+	CreatedAt    time.Time         `json:"created_at"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	Ports        []PortBinding     `json:"ports,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	ExitCode     int               `json:"exit_code,omitempty"`
+	OOMKilled    bool              `json:"oom_killed,omitempty"`
+	RestartCount int               `json:"restart_count,omitempty"`
 }
 
 // ContainerResourceStats represents resource statistics for a container.
@@ -260,6 +536,78 @@ type VolumeSpec struct {
 	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// VolumeUsage is returned by "volume-usage": the bytes and regular files
+// stored in a volume.
+type VolumeUsage struct {
+	Volume string `json:"volume"`
+	Bytes  int64  `json:"bytes"`
+	Files  int64  `json:"files"`
+}
+
+// =============================================================================
+// Volume Transfer Types
+// =============================================================================
+//
+// Volumes move between nodes as a tar archive staged on each side under
+// ~/.hoster/staging/<transfer_id>.tar. The source snapshots the volume into
+// its stage once; the backend copies the stage in chunks to the target, which
+// verifies the whole archive before extracting it into the target volume.
+
+// VolumeSnapshot is returned by "volume-snapshot". Repeated calls for the same
+// transfer return the existing stage so an interrupted transfer can resume.
+type VolumeSnapshot struct {
+	TransferID     string    `json:"transfer_id"`
+	Volume         string    `json:"volume"`
+	Size           int64     `json:"size"`            // Archive bytes
+	SHA256         string    `json:"sha256"`          // Archive checksum
+	Files          int       `json:"files"`           // Manifest entries
+	DataBytes      int64     `json:"data_bytes"`      // Sum of regular file sizes
+	ManifestDigest string    `json:"manifest_digest"` // transfer.ManifestDigest of the volume
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// VolumeChunkResult is returned by "volume-receive" after a chunk is staged.
+type VolumeChunkResult struct {
+	Offset     int64  `json:"offset"`
+	Written    int64  `json:"written"`
+	SHA256     string `json:"sha256"`      // Checksum of the bytes written
+	StagedSize int64  `json:"staged_size"` // Stage size after the write
+}
+
+// VolumeStageInfo is returned by "volume-staged".
+type VolumeStageInfo struct {
+	TransferID string `json:"transfer_id"`
+	Size       int64  `json:"size"` // 0 if nothing is staged
+}
+
+// VolumeRestoreResult is returned by "volume-restore".
+type VolumeRestoreResult struct {
+	Volume         string `json:"volume"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+	Files          int    `json:"files"`
+	DataBytes      int64  `json:"data_bytes"`
+	ManifestDigest string `json:"manifest_digest"`
+}
+
+// =============================================================================
+// Checkpoint Types
+// =============================================================================
+//
+// Experimental: a running container is checkpointed with CRIU into
+// ~/.hoster/checkpoints/<transfer_id>, archived into the same stage as volume
+// transfers ("checkpoint-create"), relayed with volume-read/volume-receive,
+// unpacked on the target ("checkpoint-unpack") and started from there
+// ("checkpoint-restore").
+
+// CheckpointSupport is returned by "checkpoint-support".
+type CheckpointSupport struct {
+	Supported    bool   `json:"supported"`
+	Experimental bool   `json:"experimental"`           // Docker daemon runs with experimental features
+	CRIUVersion  string `json:"criu_version,omitempty"` // Empty if criu is not installed
+	Reason       string `json:"reason,omitempty"`       // Why checkpoints are not supported
+}
+
 // =============================================================================
 // Options Types
 // =============================================================================
@@ -544,3 +544,59 @@ func TestErrorCodes_Values(t *testing.T) {
 		seen[code] = true
 	}
 }
+
+// =============================================================================
+// NodeMetrics Tests
+// =============================================================================
+
+func TestNodeMetrics_JSON(t *testing.T) {
+	collected := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	metrics := NodeMetrics{
+		LoadAvg1:      1.5,
+		CPUCores:      4,
+		MemoryTotalMB: 8192,
+		MemoryUsedMB:  2048,
+		Disks:         []DiskUsage{{Mount: "/", TotalMB: 100000, UsedMB: 91000}},
+		DockerdStatus: "active",
+		JournalErrors: []JournalEntry{{Timestamp: collected, Unit: "docker.service", Message: "oom"}},
+		CollectedAt:   collected,
+	}
+
+	bytes, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	var parsed NodeMetrics
+	require.NoError(t, json.Unmarshal(bytes, &parsed))
+
+	assert.Equal(t, 1.5, parsed.LoadAvg1)
+	assert.Equal(t, "active", parsed.DockerdStatus)
+	require.Len(t, parsed.Disks, 1)
+	assert.Equal(t, "/", parsed.Disks[0].Mount)
+	require.Len(t, parsed.JournalErrors, 1)
+	assert.Equal(t, "docker.service", parsed.JournalErrors[0].Unit)
+	assert.True(t, collected.Equal(parsed.CollectedAt))
+}
+
+func TestDiskUsage_UsedPercent(t *testing.T) {
+	assert.InDelta(t, 91.0, DiskUsage{TotalMB: 100000, UsedMB: 91000}.UsedPercent(), 0.001)
+	assert.Equal(t, 0.0, DiskUsage{}.UsedPercent())
+}
+
+// =============================================================================
+// WriteFileInput Tests
+// =============================================================================
+
+func TestWriteFileInput_Validate(t *testing.T) {
+	assert.NoError(t, WriteFileInput{Dir: "depl_abc", Name: "nginx.conf", Mode: 0o600}.Validate())
+
+	for name, in := range map[string]WriteFileInput{
+		"empty dir":      {Name: "nginx.conf"},
+		"empty name":     {Dir: "depl_abc"},
+		"dot dot dir":    {Dir: "..", Name: "nginx.conf"},
+		"nested name":    {Dir: "depl_abc", Name: "../../etc/passwd"},
+		"backslash name": {Dir: "depl_abc", Name: `a\b`},
+		"setuid mode":    {Dir: "depl_abc", Name: "run.sh", Mode: 0o4755},
+	} {
+		assert.Error(t, in.Validate(), name)
+	}
+}
@@ -0,0 +1,172 @@
+package minion
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// Build Signatures
+// =============================================================================
+//
+// Release builds of hoster-minion are signed: `hoster minion-sign` signs the
+// sha256 digest of each binary with an Ed25519 key and writes the signature
+// beside it (<binary>.sig), which is installed next to the minion on each
+// node. Before dispatching any other command to a node, the backend computes
+// the digest of the installed binary on the node with sha256sum, rather than
+// asking the minion, and checks the signature against it. Only then does it
+// trust the protocol version the minion reports.
+
+// SignatureSuffix is appended to a minion binary's path to name its signature file.
+const SignatureSuffix = ".sig"
+
+// Handshake verification errors.
+var (
+	ErrUnsigned          = errors.New("minion binary is not signed")
+	ErrBadSignature      = errors.New("minion signature verification failed")
+	ErrProtocolTooOld    = errors.New("minion protocol version is below the required minimum")
+	ErrInvalidSigningKey = errors.New("invalid minion signing key")
+	ErrInvalidDigest     = errors.New("invalid minion binary digest")
+)
+
+// Digest returns the hex sha256 digest of a minion binary.
+func Digest(binary []byte) string {
+	sum := sha256.Sum256(binary)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseSHA256Sum extracts the digest from sha256sum output ("<digest>  <path>").
+func ParseSHA256Sum(output string) (string, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: empty sha256sum output", ErrInvalidDigest)
+	}
+	digest := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, fields[0])
+	}
+	return digest, nil
+}
+
+// signedMessage returns the exact bytes that are signed for a binary digest.
+func signedMessage(digest string) []byte {
+	return []byte("hoster-minion\nsha256=" + strings.ToLower(digest) + "\n")
+}
+
+// SignDigest signs a binary digest and returns the base64 signature.
+func SignDigest(key ed25519.PrivateKey, digest string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(digest)))
+}
+
+// VerifyDigest checks a base64 signature against a binary digest.
+func VerifyDigest(key ed25519.PublicKey, digest, signature string) error {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return ErrUnsigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	if !ed25519.Verify(key, signedMessage(digest), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key must be %d base64-encoded bytes", ErrInvalidSigningKey, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// ParsePrivateKey decodes a base64 Ed25519 private key seed (32 bytes) or full key (64 bytes).
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSigningKey, err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("%w: private key must be a %d-byte seed or %d-byte key", ErrInvalidSigningKey, ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// =============================================================================
+// Handshake Verification
+// =============================================================================
+
+// HandshakePolicy is what the backend requires of a minion before using it.
+type HandshakePolicy struct {
+	// PublicKey verifies build signatures. Nil disables signature checks.
+	PublicKey ed25519.PublicKey
+	// MinProtocolVersion is the oldest acceptable protocol version ("" = any).
+	MinProtocolVersion string
+}
+
+// Handshake is what the backend learns about a node's minion before using it.
+type Handshake struct {
+	// Info is the minion's answer to the "version" command.
+	Info VersionInfo
+	// Digest is the sha256 of the installed binary, computed on the node.
+	Digest string
+	// Signature is the content of the binary's signature file ("" if none).
+	Signature string
+}
+
+// VerifyHandshake checks a minion's handshake against the policy. The
+// signature is checked first: the protocol version is self-reported, so it
+// is only meaningful from a binary known to be genuine.
+func VerifyHandshake(h Handshake, policy HandshakePolicy) error {
+	if policy.PublicKey != nil {
+		if err := VerifyDigest(policy.PublicKey, h.Digest, h.Signature); err != nil {
+			return err
+		}
+	}
+	if policy.MinProtocolVersion != "" {
+		got := h.Info.ProtocolVersion
+		if got == "" || CompareVersions(got, policy.MinProtocolVersion) < 0 {
+			if got == "" {
+				got = "unknown"
+			}
+			return fmt.Errorf("%w: got %s, need %s", ErrProtocolTooOld, got, policy.MinProtocolVersion)
+		}
+	}
+	return nil
+}
+
+// CompareVersions compares dotted numeric versions ("1.2.0"), returning
+// -1, 0, or 1. Missing components count as zero; a non-numeric component
+// compares as zero.
+func CompareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(pb[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
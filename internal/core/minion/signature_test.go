@@ -0,0 +1,123 @@
+package minion
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	return priv.Public().(ed25519.PublicKey), priv
+}
+
+func signedHandshake(priv ed25519.PrivateKey) Handshake {
+	digest := Digest([]byte("minion binary"))
+	return Handshake{
+		Info:      VersionInfo{Version: "1.2.0", ProtocolVersion: "1.2.0", BuildTime: "2026-03-01T00:00:00Z", Commit: "abc123"},
+		Digest:    digest,
+		Signature: SignDigest(priv, digest),
+	}
+}
+
+// =============================================================================
+// Signature Tests
+// =============================================================================
+
+func TestVerifyDigest(t *testing.T) {
+	pub, priv := testKeys(t)
+	h := signedHandshake(priv)
+
+	assert.NoError(t, VerifyDigest(pub, h.Digest, h.Signature))
+	assert.NoError(t, VerifyDigest(pub, strings.ToUpper(h.Digest), h.Signature+"\n"), "case and trailing newline")
+	assert.ErrorIs(t, VerifyDigest(pub, h.Digest, ""), ErrUnsigned)
+	assert.ErrorIs(t, VerifyDigest(pub, h.Digest, "not-base64!"), ErrBadSignature)
+	assert.ErrorIs(t, VerifyDigest(pub, Digest([]byte("tampered binary")), h.Signature), ErrBadSignature)
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyDigest(otherPub, h.Digest, h.Signature), ErrBadSignature)
+}
+
+func TestParseSHA256Sum(t *testing.T) {
+	digest := Digest([]byte("minion binary"))
+
+	got, err := ParseSHA256Sum(digest + "  /root/.hoster/minion\n")
+	require.NoError(t, err)
+	assert.Equal(t, digest, got)
+
+	got, err = ParseSHA256Sum(strings.ToUpper(digest))
+	require.NoError(t, err)
+	assert.Equal(t, digest, got)
+
+	for _, out := range []string{"", "sha256sum: /root/.hoster/minion: No such file or directory", "abc123  minion"} {
+		_, err := ParseSHA256Sum(out)
+		assert.ErrorIs(t, err, ErrInvalidDigest, out)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	pub, priv := testKeys(t)
+
+	parsedPub, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub))
+	require.NoError(t, err)
+	assert.Equal(t, pub, parsedPub)
+
+	fromSeed, err := ParsePrivateKey(base64.StdEncoding.EncodeToString(priv.Seed()))
+	require.NoError(t, err)
+	assert.Equal(t, priv, fromSeed)
+
+	fromFull, err := ParsePrivateKey(base64.StdEncoding.EncodeToString(priv))
+	require.NoError(t, err)
+	assert.Equal(t, priv, fromFull)
+
+	_, err = ParsePublicKey("c2hvcnQ=")
+	assert.ErrorIs(t, err, ErrInvalidSigningKey)
+	_, err = ParsePrivateKey("c2hvcnQ=")
+	assert.ErrorIs(t, err, ErrInvalidSigningKey)
+}
+
+// =============================================================================
+// Handshake Tests
+// =============================================================================
+
+func TestVerifyHandshake(t *testing.T) {
+	pub, priv := testKeys(t)
+	h := signedHandshake(priv)
+
+	assert.NoError(t, VerifyHandshake(h, HandshakePolicy{PublicKey: pub, MinProtocolVersion: "1.2.0"}))
+	assert.NoError(t, VerifyHandshake(Handshake{}, HandshakePolicy{}))
+
+	old := h
+	old.Info.ProtocolVersion = "1.1.0"
+	assert.ErrorIs(t, VerifyHandshake(old, HandshakePolicy{MinProtocolVersion: "1.2.0"}), ErrProtocolTooOld)
+
+	legacy := Handshake{Info: VersionInfo{Version: "1.0.0"}}
+	assert.ErrorIs(t, VerifyHandshake(legacy, HandshakePolicy{MinProtocolVersion: "1.0.0"}), ErrProtocolTooOld)
+
+	unsigned := h
+	unsigned.Signature = ""
+	assert.ErrorIs(t, VerifyHandshake(unsigned, HandshakePolicy{PublicKey: pub}), ErrUnsigned)
+	assert.NoError(t, VerifyHandshake(unsigned, HandshakePolicy{MinProtocolVersion: "1.2.0"}))
+
+	// A binary other than the signed one fails, whatever it reports about itself
+	swapped := h
+	swapped.Digest = Digest([]byte("other binary"))
+	assert.ErrorIs(t, VerifyHandshake(swapped, HandshakePolicy{PublicKey: pub, MinProtocolVersion: "1.2.0"}), ErrBadSignature)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, CompareVersions("1.2.0", "1.2.0"))
+	assert.Equal(t, 0, CompareVersions("1.2", "1.2.0"))
+	assert.Equal(t, -1, CompareVersions("1.2.0", "1.10.0"))
+	assert.Equal(t, 1, CompareVersions("v2.0.0", "1.99.99"))
+}
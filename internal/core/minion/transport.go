@@ -0,0 +1,376 @@
+package minion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// =============================================================================
+// Transport Negotiation
+// =============================================================================
+//
+// Minions that speak TransportProtocolVersion or later accept gzip-compressed
+// JSON envelopes and can frame large outputs as chunked streams. The backend
+// learns the protocol version from the "version" handshake and passes the
+// features it wants in TransportEnv; older minions never see the variable
+// and keep exchanging plain JSON and raw streams.
+
+// TransportEnv is the environment variable carrying the transport features
+// the backend wants, comma-separated (e.g. "gzip,chunked").
+const TransportEnv = "HOSTER_MINION_TRANSPORT"
+
+// TransportProtocolVersion is the first protocol version with compressed
+// envelopes and chunked streams.
+const TransportProtocolVersion = "1.12.0"
+
+// Transport features.
+const (
+	TransportGzip    = "gzip"
+	TransportChunked = "chunked"
+)
+
+// Transport is the set of transport features in use for a command.
+type Transport struct {
+	Gzip    bool // JSON responses on stdout are gzip-compressed when large
+	Chunked bool // Large outputs are framed as chunked streams
+}
+
+// SupportsTransport reports whether a minion with the given protocol version
+// understands compressed envelopes and chunked streams.
+func SupportsTransport(protocolVersion string) bool {
+	return protocolVersion != "" && CompareVersions(protocolVersion, TransportProtocolVersion) >= 0
+}
+
+// NegotiateTransport returns the transport to use with a minion that reported
+// the given protocol version: every feature, or none for older minions.
+func NegotiateTransport(protocolVersion string) Transport {
+	if !SupportsTransport(protocolVersion) {
+		return Transport{}
+	}
+	return Transport{Gzip: true, Chunked: true}
+}
+
+// ParseTransport parses the value of TransportEnv. Unknown features are
+// ignored.
+func ParseTransport(s string) Transport {
+	var t Transport
+	for _, f := range strings.Split(s, ",") {
+		switch strings.TrimSpace(f) {
+		case TransportGzip:
+			t.Gzip = true
+		case TransportChunked:
+			t.Chunked = true
+		}
+	}
+	return t
+}
+
+// String returns the TransportEnv value of a transport ("" for none).
+func (t Transport) String() string {
+	var fs []string
+	if t.Gzip {
+		fs = append(fs, TransportGzip)
+	}
+	if t.Chunked {
+		fs = append(fs, TransportChunked)
+	}
+	return strings.Join(fs, ",")
+}
+
+// =============================================================================
+// Envelopes
+// =============================================================================
+//
+// A JSON envelope (a command's stdin input or its stdout Response) may be
+// gzip-compressed. Compressed envelopes are recognized by the gzip magic
+// bytes, which JSON never starts with, so decoding needs no negotiation.
+
+const (
+	// MaxEnvelopeBytes bounds the decoded size of one JSON envelope. Larger
+	// outputs must be streamed.
+	MaxEnvelopeBytes = 16 << 20
+	// CompressMinBytes is the smallest envelope worth compressing.
+	CompressMinBytes = 1 << 10
+)
+
+// ErrPayloadTooLarge is returned when an envelope or stream exceeds its size
+// limit. It is reported instead of truncating the payload.
+var ErrPayloadTooLarge = errors.New("payload exceeds size limit")
+
+// TooLargeError describes a payload over its size limit. It wraps
+// ErrPayloadTooLarge.
+func TooLargeError(what string, size, limit int64) error {
+	if size > 0 {
+		return fmt.Errorf("%w: %s of %d bytes is over the %d byte limit", ErrPayloadTooLarge, what, size, limit)
+	}
+	return fmt.Errorf("%w: %s is over the %d byte limit", ErrPayloadTooLarge, what, limit)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// IsGzip reports whether data starts with the gzip magic bytes.
+func IsGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// EncodeEnvelope compresses an envelope when compress is set and the envelope
+// is at least CompressMinBytes. Envelopes over MaxEnvelopeBytes are refused.
+func EncodeEnvelope(data []byte, compress bool) ([]byte, error) {
+	if len(data) > MaxEnvelopeBytes {
+		return nil, TooLargeError("envelope", int64(len(data)), MaxEnvelopeBytes)
+	}
+	if !compress || len(data) < CompressMinBytes {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("compress envelope: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress envelope: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeEnvelope decompresses a gzip envelope and returns plain envelopes
+// as they are. Envelopes that decode to more than MaxEnvelopeBytes are
+// refused.
+func DecodeEnvelope(data []byte) ([]byte, error) {
+	if !IsGzip(data) {
+		if len(data) > MaxEnvelopeBytes {
+			return nil, TooLargeError("envelope", int64(len(data)), MaxEnvelopeBytes)
+		}
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress envelope: %w", err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, MaxEnvelopeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress envelope: %w", err)
+	}
+	if len(out) > MaxEnvelopeBytes {
+		return nil, TooLargeError("decompressed envelope", 0, MaxEnvelopeBytes)
+	}
+	return out, nil
+}
+
+// =============================================================================
+// Chunked Streams
+// =============================================================================
+//
+// A chunked stream is a sequence of frames, each a 4-byte big-endian length
+// followed by that many bytes. A zero-length frame ends the data and is
+// followed by a StreamTrailer JSON line with the total size and SHA-256, or
+// the error that ended the stream early. A stream that ends without its
+// trailer was cut off, so a failure halfway through is never mistaken for a
+// complete but short output.
+
+const (
+	// MaxChunkBytes bounds one frame.
+	MaxChunkBytes = 1 << 20
+	// MaxLogBytes bounds the logs returned in a LogsResult envelope.
+	MaxLogBytes = 1 << 20
+	// MaxLogStreamBytes bounds the logs read from "container-logs-stream".
+	MaxLogStreamBytes = 256 << 20
+)
+
+// Stream errors.
+var (
+	ErrStreamTruncated = errors.New("stream ended without a trailer")
+	ErrStreamCorrupt   = errors.New("stream does not match its trailer")
+)
+
+// StreamTrailer ends a chunked stream.
+type StreamTrailer struct {
+	Size   int64      `json:"size"`
+	SHA256 string     `json:"sha256"`
+	Error  *ErrorInfo `json:"error,omitempty"` // Set when the stream failed
+}
+
+// StreamError is a failure the minion reported in a stream's trailer.
+type StreamError struct {
+	Info ErrorInfo
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Info.Command, e.Info.Message)
+}
+
+// ChunkWriter writes a chunked stream.
+type ChunkWriter struct {
+	w      io.Writer
+	size   int64
+	hash   hash.Hash
+	closed bool
+}
+
+// NewChunkWriter returns a writer framing its data as a chunked stream on w.
+// Close or CloseWithError must be called to end the stream.
+func NewChunkWriter(w io.Writer) *ChunkWriter {
+	return &ChunkWriter{w: w, hash: sha256.New()}
+}
+
+// Write frames p, splitting it into frames of at most MaxChunkBytes.
+func (c *ChunkWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errors.New("write to closed chunk stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > MaxChunkBytes {
+			n = MaxChunkBytes
+		}
+		if err := c.frame(p[:n]); err != nil {
+			return written, err
+		}
+		c.hash.Write(p[:n])
+		c.size += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *ChunkWriter) frame(p []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(p)))
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := c.w.Write(p)
+	return err
+}
+
+// Close ends the stream with a trailer describing the data written.
+func (c *ChunkWriter) Close() error {
+	return c.end(nil)
+}
+
+// CloseWithError ends the stream with a trailer reporting a failure.
+func (c *ChunkWriter) CloseWithError(info ErrorInfo) error {
+	return c.end(&info)
+}
+
+func (c *ChunkWriter) end(info *ErrorInfo) error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if err := c.frame(nil); err != nil {
+		return err
+	}
+	trailer, err := json.Marshal(StreamTrailer{
+		Size:   c.size,
+		SHA256: hex.EncodeToString(c.hash.Sum(nil)),
+		Error:  info,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(trailer, '\n'))
+	return err
+}
+
+// ChunkReader reads the data of a chunked stream. Read returns io.EOF only
+// after a trailer that matches the data; a failure reported in the trailer
+// is returned as a *StreamError.
+type ChunkReader struct {
+	r       io.Reader
+	limit   int64
+	size    int64
+	hash    hash.Hash
+	pending int // Bytes left in the current frame
+	err     error
+	trailer *StreamTrailer
+}
+
+// NewChunkReader returns a reader of the chunked stream on r. Streams with
+// more than limit bytes of data fail with ErrPayloadTooLarge (0 for no
+// limit).
+func NewChunkReader(r io.Reader, limit int64) *ChunkReader {
+	return &ChunkReader{r: r, limit: limit, hash: sha256.New()}
+}
+
+// Trailer returns the stream's trailer once Read has returned io.EOF or a
+// *StreamError.
+func (c *ChunkReader) Trailer() *StreamTrailer {
+	return c.trailer
+}
+
+func (c *ChunkReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.pending == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return 0, c.fail(truncated(err))
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n == 0 {
+			return 0, c.fail(c.readTrailer())
+		}
+		if n > MaxChunkBytes {
+			return 0, c.fail(fmt.Errorf("%w: frame of %d bytes", ErrStreamCorrupt, n))
+		}
+		if c.limit > 0 && c.size+int64(n) > c.limit {
+			return 0, c.fail(TooLargeError("stream", 0, c.limit))
+		}
+		c.pending = int(n)
+	}
+	if len(p) > c.pending {
+		p = p[:c.pending]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	c.pending -= n
+	if err != nil && (c.pending > 0 || err != io.EOF) {
+		return n, c.fail(truncated(err))
+	}
+	return n, nil
+}
+
+func (c *ChunkReader) readTrailer() error {
+	var t StreamTrailer
+	if err := json.NewDecoder(c.r).Decode(&t); err != nil {
+		return fmt.Errorf("%w: %v", ErrStreamTruncated, err)
+	}
+	c.trailer = &t
+	if t.Error != nil {
+		return &StreamError{Info: *t.Error}
+	}
+	if t.Size != c.size || t.SHA256 != hex.EncodeToString(c.hash.Sum(nil)) {
+		return fmt.Errorf("%w: got %d bytes, trailer says %d", ErrStreamCorrupt, c.size, t.Size)
+	}
+	return io.EOF
+}
+
+func (c *ChunkReader) fail(err error) error {
+	c.err = err
+	return err
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrStreamTruncated
+	}
+	return err
+}
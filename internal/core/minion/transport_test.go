@@ -0,0 +1,178 @@
+package minion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Transport Negotiation Tests
+// =============================================================================
+
+func TestNegotiateTransport(t *testing.T) {
+	assert.Equal(t, Transport{}, NegotiateTransport(""))
+	assert.Equal(t, Transport{}, NegotiateTransport("1.11.0"))
+	assert.Equal(t, Transport{Gzip: true, Chunked: true}, NegotiateTransport(TransportProtocolVersion))
+	assert.Equal(t, Transport{Gzip: true, Chunked: true}, NegotiateTransport("2.0.0"))
+}
+
+func TestTransport_StringRoundTrip(t *testing.T) {
+	for _, tr := range []Transport{{}, {Gzip: true}, {Chunked: true}, {Gzip: true, Chunked: true}} {
+		assert.Equal(t, tr, ParseTransport(tr.String()))
+	}
+	assert.Equal(t, "gzip,chunked", Transport{Gzip: true, Chunked: true}.String())
+	assert.Equal(t, Transport{Gzip: true}, ParseTransport(" gzip , brotli"))
+}
+
+// =============================================================================
+// Envelope Tests
+// =============================================================================
+
+func TestEnvelope_SmallIsNotCompressed(t *testing.T) {
+	data := []byte(`{"success":true}`)
+
+	out, err := EncodeEnvelope(data, true)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+}
+
+func TestEnvelope_CompressRoundTrip(t *testing.T) {
+	data := []byte(`{"success":true,"data":{"logs":"` + strings.Repeat("line\\n", 2000) + `"}}`)
+
+	out, err := EncodeEnvelope(data, true)
+	require.NoError(t, err)
+	assert.True(t, IsGzip(out))
+	assert.Less(t, len(out), len(data))
+
+	decoded, err := DecodeEnvelope(out)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	resp, err := ParseResponse(out)
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+}
+
+func TestEnvelope_PlainPassesThrough(t *testing.T) {
+	data := []byte(`{"success":true}`)
+
+	out, err := DecodeEnvelope(data)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+}
+
+func TestEnvelope_TooLarge(t *testing.T) {
+	big := bytes.Repeat([]byte("a"), MaxEnvelopeBytes+1)
+
+	_, err := EncodeEnvelope(big, true)
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	_, err = DecodeEnvelope(big)
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestEnvelope_DecompressedTooLarge(t *testing.T) {
+	// Compresses to a few KB but expands past the limit
+	big := bytes.Repeat([]byte("a"), MaxEnvelopeBytes+1)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(big)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	_, err = DecodeEnvelope(buf.Bytes())
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+// =============================================================================
+// Chunked Stream Tests
+// =============================================================================
+
+func TestChunkStream_RoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), MaxChunkBytes/4) // Spans several frames
+	var buf bytes.Buffer
+	w := NewChunkWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r := NewChunkReader(&buf, 0)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+	require.NotNil(t, r.Trailer())
+	assert.Equal(t, int64(len(data)), r.Trailer().Size)
+}
+
+func TestChunkStream_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewChunkWriter(&buf).Close())
+
+	out, err := io.ReadAll(NewChunkReader(&buf, 0))
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestChunkStream_ErrorTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChunkWriter(&buf)
+	_, err := w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, w.CloseWithError(ErrorInfo{Command: "image-save", Code: ErrCodeInternal, Message: "disk full"}))
+
+	out, err := io.ReadAll(NewChunkReader(&buf, 0))
+	assert.Equal(t, "partial", string(out))
+	var streamErr *StreamError
+	require.True(t, errors.As(err, &streamErr))
+	assert.Equal(t, ErrCodeInternal, streamErr.Info.Code)
+	assert.Equal(t, "disk full", streamErr.Info.Message)
+}
+
+func TestChunkStream_Truncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChunkWriter(&buf)
+	_, err := w.Write([]byte("some data"))
+	require.NoError(t, err)
+	// No Close: the minion died mid-stream
+
+	_, err = io.ReadAll(NewChunkReader(&buf, 0))
+	assert.ErrorIs(t, err, ErrStreamTruncated)
+
+	// Cut inside a frame
+	var cut bytes.Buffer
+	w = NewChunkWriter(&cut)
+	_, err = w.Write([]byte("some data"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(NewChunkReader(bytes.NewReader(cut.Bytes()[:6]), 0))
+	assert.ErrorIs(t, err, ErrStreamTruncated)
+}
+
+func TestChunkStream_Limit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChunkWriter(&buf)
+	_, err := w.Write(bytes.Repeat([]byte("x"), 100))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = io.ReadAll(NewChunkReader(&buf, 50))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestChunkStream_CorruptTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChunkWriter(&buf)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	corrupt := bytes.Replace(buf.Bytes(), []byte("hello"), []byte("jello"), 1)
+	_, err = io.ReadAll(NewChunkReader(bytes.NewReader(corrupt), 0))
+	assert.ErrorIs(t, err, ErrStreamCorrupt)
+}
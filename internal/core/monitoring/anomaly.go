@@ -0,0 +1,247 @@
+package monitoring
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// =============================================================================
+// Abuse Detection
+// =============================================================================
+//
+// Crypto-miners and similar abuse show up in container stats as a service
+// that keeps its CPU saturated while nothing talks to it, or as a sudden
+// burst of egress. Detection is deliberately simple: each finding adds to a
+// deployment's abuse score, and a deployment at or above AbuseFlagScore is
+// flagged for review.
+
+// AnomalyKind identifies what a usage anomaly is.
+type AnomalyKind string
+
+const (
+	AnomalyIdleCPU     AnomalyKind = "saturated_cpu_without_inbound"
+	AnomalyEgressSpike AnomalyKind = "egress_spike"
+)
+
+// anomalyScores is how much each kind of anomaly adds to the abuse score.
+var anomalyScores = map[AnomalyKind]int{
+	AnomalyIdleCPU:     70,
+	AnomalyEgressSpike: 50,
+}
+
+// AbuseFlagScore is the abuse score at which a deployment is flagged.
+const AbuseFlagScore = 50
+
+// Abuse actions a plan's policy takes on flagged deployments.
+const (
+	AbuseActionFlag     = "flag"     // notify only
+	AbuseActionThrottle = "throttle" // also cut each container's CPU to ThrottleCPU
+)
+
+// ThrottleCPU is the CPU, in cores, a throttled deployment's containers may use.
+const ThrottleCPU = 0.1
+
+// UsageSample is one stats reading of a service's container. The network
+// counters count from the container's start.
+type UsageSample struct {
+	At         time.Time
+	CPUPercent float64 // Percent of one core
+	RxBytes    int64
+	TxBytes    int64
+}
+
+// AnomalyThresholds decide when usage counts as anomalous.
+type AnomalyThresholds struct {
+	// SaturationWindow is how long a service's CPU must stay saturated.
+	SaturationWindow time.Duration
+	// Saturation is the share of the service's CPU limit, or of one core
+	// without a limit, at which its CPU counts as saturated.
+	Saturation float64
+	// IdleInboundBytes is the most a saturated service may receive over the
+	// window and still count as having no inbound traffic.
+	IdleInboundBytes int64
+	// EgressSpikeFactor flags egress at this many times the service's median rate.
+	EgressSpikeFactor float64
+	// EgressMinBytesPerHour ignores egress below this rate.
+	EgressMinBytesPerHour int64
+	// MinSamples is how many samples each check needs.
+	MinSamples int
+}
+
+// DefaultAnomalyThresholds returns the thresholds used by abuse detection.
+// With the default 5 minute collection interval, MinSamples is half an hour.
+func DefaultAnomalyThresholds() AnomalyThresholds {
+	return AnomalyThresholds{
+		SaturationWindow:      time.Hour,
+		Saturation:            0.9,
+		IdleInboundBytes:      1 << 20,
+		EgressSpikeFactor:     10,
+		EgressMinBytesPerHour: 1 << 30,
+		MinSamples:            6,
+	}
+}
+
+// Anomaly is a finding about one service's usage.
+type Anomaly struct {
+	Kind    AnomalyKind `json:"kind"`
+	Service string      `json:"service"`
+	Score   int         `json:"score"`
+	Message string      `json:"message"`
+}
+
+// =============================================================================
+// Detection (Pure Functions)
+// =============================================================================
+
+// DetectAnomalies checks each service's samples up to now. cpuLimits holds
+// each service's CPU limit in cores; zero or missing means no limit.
+// Anomalies are returned ordered by service, then kind.
+func DetectAnomalies(samples map[string][]UsageSample, cpuLimits map[string]float64, now time.Time, t AnomalyThresholds) []Anomaly {
+	services := make([]string, 0, len(samples))
+	for s := range samples {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+
+	var anomalies []Anomaly
+	for _, service := range services {
+		series := slices.Clone(samples[service])
+		sort.SliceStable(series, func(i, j int) bool { return series[i].At.Before(series[j].At) })
+		if a, ok := detectIdleCPU(service, series, cpuLimits[service], now, t); ok {
+			anomalies = append(anomalies, a)
+		}
+		if a, ok := detectEgressSpike(service, series, t); ok {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// AbuseScore sums the scores of anomalies, up to 100.
+func AbuseScore(anomalies []Anomaly) int {
+	score := 0
+	for _, a := range anomalies {
+		score += a.Score
+	}
+	return min(score, 100)
+}
+
+// detectIdleCPU finds a service whose CPU stayed saturated for the window
+// while it received next to nothing.
+func detectIdleCPU(service string, series []UsageSample, cpuLimit float64, now time.Time, t AnomalyThresholds) (Anomaly, bool) {
+	since := now.Add(-t.SaturationWindow)
+	var window []UsageSample
+	for _, s := range series {
+		if !s.At.Before(since) && !s.At.After(now) {
+			window = append(window, s)
+		}
+	}
+	if len(window) < max(t.MinSamples, 2) {
+		return Anomaly{}, false
+	}
+	span := window[len(window)-1].At.Sub(window[0].At)
+	if span < t.SaturationWindow/2 {
+		return Anomaly{}, false
+	}
+
+	cores := cpuLimit
+	if cores <= 0 {
+		cores = 1
+	}
+	for _, s := range window {
+		if s.CPUPercent < t.Saturation*cores*100 {
+			return Anomaly{}, false
+		}
+	}
+	rx := make([]int64, len(window))
+	for i, s := range window {
+		rx[i] = s.RxBytes
+	}
+	inbound := counterGrowth(rx)
+	if inbound > t.IdleInboundBytes {
+		return Anomaly{}, false
+	}
+	return Anomaly{
+		Kind:    AnomalyIdleCPU,
+		Service: service,
+		Score:   anomalyScores[AnomalyIdleCPU],
+		Message: fmt.Sprintf("CPU at %.0f%% or more of %s for %d minutes while receiving %s",
+			t.Saturation*100, coresText(cpuLimit), int(span.Minutes()), bytesText(inbound)),
+	}, true
+}
+
+// detectEgressSpike finds a service whose latest egress rate is far above
+// its median rate.
+func detectEgressSpike(service string, series []UsageSample, t AnomalyThresholds) (Anomaly, bool) {
+	var rates []float64 // Bytes per hour between consecutive samples
+	for i := 1; i < len(series); i++ {
+		dt := series[i].At.Sub(series[i-1].At)
+		if dt <= 0 {
+			continue
+		}
+		sent := float64(counterGrowth([]int64{series[i-1].TxBytes, series[i].TxBytes}))
+		rates = append(rates, sent/dt.Hours())
+	}
+	if len(rates) < max(t.MinSamples, 2) {
+		return Anomaly{}, false
+	}
+	latest, baseline := rates[len(rates)-1], median(rates[:len(rates)-1])
+	if latest < float64(t.EgressMinBytesPerHour) || latest < t.EgressSpikeFactor*baseline {
+		return Anomaly{}, false
+	}
+	return Anomaly{
+		Kind:    AnomalyEgressSpike,
+		Service: service,
+		Score:   anomalyScores[AnomalyEgressSpike],
+		Message: fmt.Sprintf("sending %s per hour against a usual %s per hour",
+			bytesText(int64(latest)), bytesText(int64(baseline))),
+	}, true
+}
+
+// counterGrowth returns how much a counter grew over readings. A reading
+// below the one before means the container restarted and counts from zero.
+func counterGrowth(readings []int64) int64 {
+	var growth int64
+	for i := 1; i < len(readings); i++ {
+		if d := readings[i] - readings[i-1]; d >= 0 {
+			growth += d
+		} else {
+			growth += readings[i]
+		}
+	}
+	return growth
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func coresText(cores float64) string {
+	if cores <= 0 {
+		return "one core"
+	}
+	return fmt.Sprintf("its %g-core limit", cores)
+}
+
+// bytesText renders a byte count in the largest binary unit that keeps it
+// at or above one.
+func bytesText(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
@@ -0,0 +1,81 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usageSeries returns n samples five minutes apart ending at end, with the
+// network counters growing by rx and tx bytes per sample.
+func usageSeries(n int, end time.Time, cpuPercent float64, rx, tx int64) []UsageSample {
+	samples := make([]UsageSample, n)
+	for i := range samples {
+		samples[i] = UsageSample{
+			At:         end.Add(-time.Duration(n-1-i) * 5 * time.Minute),
+			CPUPercent: cpuPercent,
+			RxBytes:    int64(i) * rx,
+			TxBytes:    int64(i) * tx,
+		}
+	}
+	return samples
+}
+
+func TestDetectAnomalies_IdleCPU(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	th := DefaultAnomalyThresholds()
+
+	miner := usageSeries(13, now, 198, 100, 100)
+	anomalies := DetectAnomalies(map[string][]UsageSample{"worker": miner}, map[string]float64{"worker": 2}, now, th)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, Anomaly{
+		Kind:    AnomalyIdleCPU,
+		Service: "worker",
+		Score:   70,
+		Message: "CPU at 90% or more of its 2-core limit for 60 minutes while receiving 1.2 KiB",
+	}, anomalies[0])
+	assert.Equal(t, 70, AbuseScore(anomalies))
+
+	// Without a limit, one busy core counts as saturated
+	assert.Len(t, DetectAnomalies(map[string][]UsageSample{"worker": usageSeries(13, now, 95, 0, 0)}, nil, now, th), 1)
+
+	busy := usageSeries(13, now, 198, 10<<20, 100)
+	assert.Empty(t, DetectAnomalies(map[string][]UsageSample{"web": busy}, map[string]float64{"web": 2}, now, th), "serving traffic")
+
+	dip := usageSeries(13, now, 198, 0, 0)
+	dip[6].CPUPercent = 20
+	assert.Empty(t, DetectAnomalies(map[string][]UsageSample{"worker": dip}, map[string]float64{"worker": 2}, now, th), "not sustained")
+
+	assert.Empty(t, DetectAnomalies(map[string][]UsageSample{"worker": usageSeries(4, now, 198, 0, 0)}, nil, now, th), "too few samples")
+}
+
+func TestDetectAnomalies_EgressSpike(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	th := DefaultAnomalyThresholds()
+
+	series := usageSeries(10, now, 5, 1<<20, 10<<20) // 120 MiB/h
+	series[9].TxBytes = series[8].TxBytes + 1<<30    // 12 GiB/h
+	anomalies := DetectAnomalies(map[string][]UsageSample{"web": series}, nil, now, th)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, AnomalyEgressSpike, anomalies[0].Kind)
+	assert.Equal(t, "sending 12.0 GiB per hour against a usual 120.0 MiB per hour", anomalies[0].Message)
+
+	steady := usageSeries(10, now, 5, 1<<20, 1<<30) // steadily 12 GiB/h
+	assert.Empty(t, DetectAnomalies(map[string][]UsageSample{"cdn": steady}, nil, now, th), "no spike")
+
+	small := usageSeries(10, now, 5, 0, 1<<10)
+	small[9].TxBytes = small[8].TxBytes + 1<<20
+	assert.Empty(t, DetectAnomalies(map[string][]UsageSample{"web": small}, nil, now, th), "below the minimum rate")
+}
+
+func TestAbuseScore(t *testing.T) {
+	assert.Equal(t, 0, AbuseScore(nil))
+	assert.Equal(t, 100, AbuseScore([]Anomaly{{Score: 70}, {Score: 50}}))
+}
+
+func TestCounterGrowth(t *testing.T) {
+	assert.Equal(t, int64(20), counterGrowth([]int64{10, 20, 30}))
+	assert.Equal(t, int64(25), counterGrowth([]int64{10, 20, 5, 15}), "restart counts from zero")
+}
@@ -0,0 +1,194 @@
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// =============================================================================
+// Capacity Types
+// =============================================================================
+
+// CapacitySample is one stats reading of a service's container.
+type CapacitySample struct {
+	CPUPercent  float64 // Percent of one core, so 150 is one and a half cores
+	MemoryBytes int64
+}
+
+// ServiceLimits are the resource limits a template declares for a service.
+// Zero means no limit.
+type ServiceLimits struct {
+	CPU         float64 // Cores
+	MemoryBytes int64
+}
+
+// Sizing is the verdict on one resource limit of a service.
+type Sizing string
+
+const (
+	SizingOK               Sizing = "ok"
+	SizingOverProvisioned  Sizing = "over_provisioned"
+	SizingUnderProvisioned Sizing = "under_provisioned"
+	SizingNoLimit          Sizing = "no_limit"
+	SizingInsufficientData Sizing = "insufficient_data"
+)
+
+// CapacityThresholds decide when a limit is reported as too big or too small.
+type CapacityThresholds struct {
+	// OverProvisioned flags a limit whose P95 usage is below this fraction of it.
+	OverProvisioned float64
+	// UnderProvisioned flags a limit whose P95 usage is above this fraction of it.
+	UnderProvisioned float64
+	// Headroom multiplies observed usage to suggest a limit.
+	Headroom float64
+	// MinSamples is how many samples a service needs for a verdict.
+	MinSamples int
+}
+
+// DefaultCapacityThresholds returns the thresholds used by capacity reports.
+// With the default 5 minute collection interval, MinSamples is one hour.
+func DefaultCapacityThresholds() CapacityThresholds {
+	return CapacityThresholds{
+		OverProvisioned:  0.3,
+		UnderProvisioned: 0.9,
+		Headroom:         1.5,
+		MinSamples:       12,
+	}
+}
+
+// ResourceCapacity compares one resource's limit with its usage. CPU values
+// are in cores, memory values in MB.
+type ResourceCapacity struct {
+	Limit          float64 `json:"limit"` // 0 when no limit is declared
+	P95            float64 `json:"p95"`
+	Max            float64 `json:"max"`
+	Utilization    float64 `json:"utilization"` // P95 / Limit; 0 without a limit
+	Sizing         Sizing  `json:"sizing"`
+	Suggested      float64 `json:"suggested,omitempty"`
+	Recommendation string  `json:"recommendation,omitempty"`
+}
+
+// ServiceCapacity is a service's line in a capacity report.
+type ServiceCapacity struct {
+	Service string           `json:"service"`
+	Samples int              `json:"samples"`
+	CPU     ResourceCapacity `json:"cpu"`
+	Memory  ResourceCapacity `json:"memory"`
+}
+
+// =============================================================================
+// Capacity Report (Pure Functions)
+// =============================================================================
+
+// CapacityReport compares each service's declared limits with its sampled
+// usage. Services that have limits but no samples, or samples but no entry
+// in limits, are included. The report is sorted by service name.
+func CapacityReport(limits map[string]ServiceLimits, samples map[string][]CapacitySample, t CapacityThresholds) []ServiceCapacity {
+	names := make(map[string]bool, len(limits)+len(samples))
+	for name := range limits {
+		names[name] = true
+	}
+	for name := range samples {
+		names[name] = true
+	}
+
+	report := make([]ServiceCapacity, 0, len(names))
+	for name := range names {
+		report = append(report, EvaluateServiceCapacity(name, limits[name], samples[name], t))
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Service < report[j].Service })
+	return report
+}
+
+// EvaluateServiceCapacity compares one service's limits with its samples.
+func EvaluateServiceCapacity(service string, limits ServiceLimits, samples []CapacitySample, t CapacityThresholds) ServiceCapacity {
+	cpu := make([]float64, len(samples))
+	memory := make([]float64, len(samples))
+	for i, s := range samples {
+		cpu[i] = s.CPUPercent / 100
+		memory[i] = float64(s.MemoryBytes) / (1024 * 1024)
+	}
+
+	enough := len(samples) >= t.MinSamples && len(samples) > 0
+	return ServiceCapacity{
+		Service: service,
+		Samples: len(samples),
+		CPU:     evaluateResource("cpu", "cores", limits.CPU, cpu, enough, t, roundUpCores),
+		Memory:  evaluateResource("memory", "MB", float64(limits.MemoryBytes)/(1024*1024), memory, enough, t, roundUpMB),
+	}
+}
+
+func evaluateResource(name, unit string, limit float64, values []float64, enough bool, t CapacityThresholds, round func(float64) float64) ResourceCapacity {
+	rc := ResourceCapacity{Limit: limit, P95: Percentile(values, 95), Max: maxOf(values)}
+	if limit > 0 {
+		rc.Utilization = rc.P95 / limit
+	}
+
+	switch {
+	case !enough:
+		rc.Sizing = SizingInsufficientData
+	case limit <= 0:
+		rc.Sizing = SizingNoLimit
+		rc.Suggested = round(rc.Max * t.Headroom)
+		rc.Recommendation = fmt.Sprintf("no %s limit is declared; peak usage was %s %s, consider a limit of %s %s",
+			name, formatAmount(rc.Max), unit, formatAmount(rc.Suggested), unit)
+	case rc.Utilization > t.UnderProvisioned:
+		// Usage is capped at the limit, so the peak says more than P95
+		rc.Sizing = SizingUnderProvisioned
+		rc.Suggested = round(math.Max(rc.Max, limit) * t.Headroom)
+		rc.Recommendation = fmt.Sprintf("P95 %s usage is %.0f%% of the %s %s limit; consider raising it to %s %s",
+			name, rc.Utilization*100, formatAmount(limit), unit, formatAmount(rc.Suggested), unit)
+	case rc.Utilization < t.OverProvisioned:
+		rc.Sizing = SizingOverProvisioned
+		rc.Suggested = round(rc.P95 * t.Headroom)
+		rc.Recommendation = fmt.Sprintf("P95 %s usage is %.0f%% of the %s %s limit; consider lowering it to %s %s",
+			name, rc.Utilization*100, formatAmount(limit), unit, formatAmount(rc.Suggested), unit)
+	default:
+		rc.Sizing = SizingOK
+	}
+	return rc
+}
+
+// Percentile returns the p-th percentile (0-100) of values using the
+// nearest-rank method, or 0 for no values. values is not modified.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func maxOf(values []float64) float64 {
+	var m float64
+	for _, v := range values {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+// roundUpCores rounds a CPU amount up to a multiple of 0.05 cores.
+func roundUpCores(v float64) float64 {
+	return math.Max(0.05, math.Ceil(v*20-1e-9)/20)
+}
+
+// roundUpMB rounds a memory amount up to a multiple of 16 MB.
+func roundUpMB(v float64) float64 {
+	return math.Max(16, math.Ceil(v/16-1e-9)*16)
+}
+
+func formatAmount(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
@@ -0,0 +1,99 @@
+package monitoring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mb = 1024 * 1024
+
+// steadySamples returns n samples at the given CPU percent and memory MB.
+func steadySamples(n int, cpuPercent float64, memoryMB int64) []CapacitySample {
+	samples := make([]CapacitySample, n)
+	for i := range samples {
+		samples[i] = CapacitySample{CPUPercent: cpuPercent, MemoryBytes: memoryMB * mb}
+	}
+	return samples
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, 0.0, Percentile(nil, 95))
+	assert.Equal(t, 7.0, Percentile([]float64{7}, 95))
+
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(100 - i) // 100..1, unsorted
+	}
+	assert.Equal(t, 95.0, Percentile(values, 95))
+	assert.Equal(t, 50.0, Percentile(values, 50))
+	assert.Equal(t, 100.0, Percentile(values, 100))
+	assert.Equal(t, 100.0, values[0], "input is not modified")
+}
+
+func TestEvaluateServiceCapacity_OverProvisioned(t *testing.T) {
+	c := EvaluateServiceCapacity("web", ServiceLimits{CPU: 2, MemoryBytes: 1024 * mb},
+		steadySamples(20, 10, 100), DefaultCapacityThresholds())
+
+	assert.Equal(t, 20, c.Samples)
+	assert.Equal(t, SizingOverProvisioned, c.CPU.Sizing)
+	assert.InDelta(t, 0.1, c.CPU.P95, 1e-9)
+	assert.InDelta(t, 0.05, c.CPU.Utilization, 1e-9)
+	assert.Equal(t, 0.15, c.CPU.Suggested)
+	assert.Equal(t, "P95 cpu usage is 5% of the 2 cores limit; consider lowering it to 0.15 cores", c.CPU.Recommendation)
+
+	assert.Equal(t, SizingOverProvisioned, c.Memory.Sizing)
+	assert.Equal(t, 100.0, c.Memory.P95)
+	assert.Equal(t, 160.0, c.Memory.Suggested)
+}
+
+func TestEvaluateServiceCapacity_UnderProvisioned(t *testing.T) {
+	samples := append(steadySamples(18, 47, 500), steadySamples(2, 50, 510)...)
+	c := EvaluateServiceCapacity("worker", ServiceLimits{CPU: 0.5, MemoryBytes: 512 * mb}, samples, DefaultCapacityThresholds())
+
+	assert.Equal(t, SizingUnderProvisioned, c.CPU.Sizing)
+	assert.Equal(t, 0.75, c.CPU.Suggested)
+	assert.Equal(t, SizingUnderProvisioned, c.Memory.Sizing)
+	assert.Equal(t, 768.0, c.Memory.Suggested)
+	assert.Contains(t, c.Memory.Recommendation, "consider raising it to 768 MB")
+}
+
+func TestEvaluateServiceCapacity_OKAndNoLimit(t *testing.T) {
+	c := EvaluateServiceCapacity("db", ServiceLimits{CPU: 1}, steadySamples(12, 60, 300), DefaultCapacityThresholds())
+
+	assert.Equal(t, SizingOK, c.CPU.Sizing)
+	assert.Empty(t, c.CPU.Recommendation)
+	assert.Zero(t, c.CPU.Suggested)
+
+	assert.Equal(t, SizingNoLimit, c.Memory.Sizing)
+	assert.Zero(t, c.Memory.Utilization)
+	assert.Equal(t, 464.0, c.Memory.Suggested)
+	assert.Equal(t, "no memory limit is declared; peak usage was 300 MB, consider a limit of 464 MB", c.Memory.Recommendation)
+}
+
+func TestEvaluateServiceCapacity_InsufficientData(t *testing.T) {
+	c := EvaluateServiceCapacity("web", ServiceLimits{CPU: 1}, steadySamples(3, 10, 100), DefaultCapacityThresholds())
+	assert.Equal(t, SizingInsufficientData, c.CPU.Sizing)
+	assert.Equal(t, SizingInsufficientData, c.Memory.Sizing)
+	assert.InDelta(t, 0.1, c.CPU.P95, 1e-9)
+
+	c = EvaluateServiceCapacity("web", ServiceLimits{}, nil, CapacityThresholds{})
+	assert.Equal(t, SizingInsufficientData, c.CPU.Sizing)
+}
+
+func TestCapacityReport(t *testing.T) {
+	report := CapacityReport(
+		map[string]ServiceLimits{"web": {CPU: 1}, "db": {MemoryBytes: 256 * mb}},
+		map[string][]CapacitySample{"web": steadySamples(12, 50, 64), "legacy": steadySamples(12, 1, 8)},
+		DefaultCapacityThresholds(),
+	)
+
+	require.Len(t, report, 3)
+	assert.Equal(t, "db", report[0].Service)
+	assert.Equal(t, 0, report[0].Samples)
+	assert.Equal(t, "legacy", report[1].Service)
+	assert.Equal(t, SizingNoLimit, report[1].CPU.Sizing)
+	assert.Equal(t, "web", report[2].Service)
+	assert.Equal(t, SizingOK, report[2].CPU.Sizing)
+}
@@ -0,0 +1,152 @@
+package monitoring
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Container Deaths
+// =============================================================================
+//
+// A crash is found in the Docker daemon's "die" events rather than by
+// inspecting containers, because a restart policy brings a container back
+// (and Docker clears its OOMKilled flag and exit code) before the next
+// inspect. Docker reports an "oom" event just before the "die" of a container
+// whose main process the kernel killed.
+
+// ForensicsLogLines is how many log lines are kept with a crash.
+const ForensicsLogLines = 50
+
+// maxForensicsLineLength bounds each kept log line, in bytes.
+const maxForensicsLineLength = 1024
+
+// Death is one container exit found in the daemon's events.
+type Death struct {
+	ContainerID string
+	Name        string
+	ExitCode    int
+	OOMKilled   bool
+	At          time.Time
+}
+
+// CollectDeaths pairs die events with the oom event that preceded them, in
+// time order. An oom not followed by a die killed a process other than the
+// container's main one; the container kept running, so it is not a death.
+func CollectDeaths(events []minion.ContainerLifecycleEvent) []Death {
+	sorted := append([]minion.ContainerLifecycleEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	oom := map[string]bool{}
+	var deaths []Death
+	for _, ev := range sorted {
+		switch ev.Action {
+		case "oom":
+			oom[ev.ContainerID] = true
+		case "die":
+			deaths = append(deaths, Death{
+				ContainerID: ev.ContainerID,
+				Name:        ev.Name,
+				ExitCode:    ev.ExitCode,
+				OOMKilled:   oom[ev.ContainerID],
+				At:          ev.Time,
+			})
+			delete(oom, ev.ContainerID)
+		}
+	}
+	return deaths
+}
+
+// ExitSignal names the signal behind an exit code above 128, the shell
+// convention Docker follows. It returns "" for ordinary exit codes.
+func ExitSignal(exitCode int) string {
+	if exitCode <= 128 || exitCode > 128+64 {
+		return ""
+	}
+	switch sig := exitCode - 128; sig {
+	case 1:
+		return "SIGHUP"
+	case 2:
+		return "SIGINT"
+	case 6:
+		return "SIGABRT"
+	case 9:
+		return "SIGKILL"
+	case 11:
+		return "SIGSEGV"
+	case 15:
+		return "SIGTERM"
+	default:
+		return fmt.Sprintf("signal %d", sig)
+	}
+}
+
+// NewForensics builds the forensics of a death. restartCount, logs and memory
+// come from inspecting the container afterwards and may be empty.
+func NewForensics(d Death, restartCount int, logs string, memory *domain.MemorySnapshot) domain.ContainerForensics {
+	return domain.ContainerForensics{
+		OOMKilled:    d.OOMKilled,
+		ExitCode:     d.ExitCode,
+		Signal:       ExitSignal(d.ExitCode),
+		RestartCount: restartCount,
+		DiedAt:       d.At,
+		LogTail:      TailLines(logs, ForensicsLogLines),
+		Memory:       memory,
+	}
+}
+
+// NewMemorySnapshot builds a memory snapshot from a stats sample. Percent is
+// zero when the container had no limit.
+func NewMemorySnapshot(usageBytes, limitBytes int64, sampledAt time.Time) *domain.MemorySnapshot {
+	m := &domain.MemorySnapshot{UsageBytes: usageBytes, LimitBytes: limitBytes, SampledAt: sampledAt}
+	if limitBytes > 0 {
+		m.Percent = float64(usageBytes) / float64(limitBytes) * 100
+	}
+	return m
+}
+
+// DeathEventType is the container event recorded for a death.
+func DeathEventType(f domain.ContainerForensics) domain.ContainerEventType {
+	if f.OOMKilled {
+		return domain.EventContainerOOM
+	}
+	return domain.EventContainerDied
+}
+
+// DeathMessage describes a death for the events timeline.
+func DeathMessage(service string, f domain.ContainerForensics) string {
+	detail := fmt.Sprintf("exit code %d", f.ExitCode)
+	if f.Signal != "" {
+		detail += ", " + f.Signal
+	}
+	if f.Memory != nil && f.Memory.LimitBytes > 0 {
+		detail += fmt.Sprintf(", memory %.0f%% of %d MiB", f.Memory.Percent, f.Memory.LimitBytes>>20)
+	}
+	return fmt.Sprintf("%s (%s)", ContainerEventMessage(DeathEventType(f), service), detail)
+}
+
+// TailLines returns the last n non-empty lines of logs, each cut to a bounded
+// length.
+func TailLines(logs string, n int) []string {
+	lines := strings.Split(strings.ReplaceAll(logs, "\r\n", "\n"), "\n")
+	var out []string
+	for i := len(lines) - 1; i >= 0 && len(out) < n; i-- {
+		line := strings.TrimRight(lines[i], " \t")
+		if line == "" {
+			continue
+		}
+		if len(line) > maxForensicsLineLength {
+			line = strings.ToValidUTF8(line[:maxForensicsLineLength], "") + "…"
+		}
+		out = append(out, line)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
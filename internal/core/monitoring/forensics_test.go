@@ -0,0 +1,93 @@
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// CollectDeaths Tests
+// =============================================================================
+
+func TestCollectDeaths(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := []minion.ContainerLifecycleEvent{
+		{ContainerID: "web", Name: "depl-web", Action: "die", ExitCode: 137, Time: at.Add(2 * time.Second)},
+		{ContainerID: "web", Action: "oom", Time: at.Add(time.Second)},
+		{ContainerID: "db", Action: "oom", Time: at.Add(3 * time.Second)}, // a child process; db kept running
+		{ContainerID: "worker", Name: "depl-worker", Action: "die", ExitCode: 1, Time: at.Add(4 * time.Second)},
+		{ContainerID: "web", Name: "depl-web", Action: "die", ExitCode: 0, Time: at.Add(5 * time.Second)},
+	}
+
+	deaths := CollectDeaths(events)
+	require.Len(t, deaths, 3)
+	assert.Equal(t, Death{ContainerID: "web", Name: "depl-web", ExitCode: 137, OOMKilled: true, At: at.Add(2 * time.Second)}, deaths[0])
+	assert.Equal(t, "worker", deaths[1].ContainerID)
+	assert.False(t, deaths[1].OOMKilled)
+	assert.False(t, deaths[2].OOMKilled, "the oom belongs to the earlier death")
+
+	assert.Empty(t, CollectDeaths(nil))
+}
+
+// =============================================================================
+// Forensics Tests
+// =============================================================================
+
+func TestExitSignal(t *testing.T) {
+	assert.Equal(t, "", ExitSignal(0))
+	assert.Equal(t, "", ExitSignal(1))
+	assert.Equal(t, "", ExitSignal(128))
+	assert.Equal(t, "SIGKILL", ExitSignal(137))
+	assert.Equal(t, "SIGTERM", ExitSignal(143))
+	assert.Equal(t, "SIGSEGV", ExitSignal(139))
+	assert.Equal(t, "signal 10", ExitSignal(138))
+	assert.Equal(t, "", ExitSignal(255))
+}
+
+func TestNewForensics(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	mem := NewMemorySnapshot(255<<20, 256<<20, at.Add(-10*time.Second))
+	f := NewForensics(Death{ExitCode: 137, OOMKilled: true, At: at}, 3, "starting\nallocating\n\n", mem)
+
+	assert.True(t, f.OOMKilled)
+	assert.Equal(t, "SIGKILL", f.Signal)
+	assert.Equal(t, 3, f.RestartCount)
+	assert.Equal(t, at, f.DiedAt)
+	assert.Equal(t, []string{"starting", "allocating"}, f.LogTail)
+	assert.InDelta(t, 99.6, f.Memory.Percent, 0.1)
+
+	assert.Equal(t, domain.EventContainerOOM, DeathEventType(f))
+	assert.Equal(t, "Container web killed due to out of memory (exit code 137, SIGKILL, memory 100% of 256 MiB)", DeathMessage("web", f))
+
+	crash := NewForensics(Death{ExitCode: 1, At: at}, 0, "", nil)
+	assert.Equal(t, domain.EventContainerDied, DeathEventType(crash))
+	assert.Equal(t, "Container web died unexpectedly (exit code 1)", DeathMessage("web", crash))
+	assert.Nil(t, crash.LogTail)
+}
+
+func TestNewMemorySnapshot_NoLimit(t *testing.T) {
+	m := NewMemorySnapshot(100, 0, time.Time{})
+	assert.Zero(t, m.Percent)
+}
+
+func TestTailLines(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 80; i++ {
+		fmt.Fprintf(&b, "line %d\r\n", i)
+	}
+	lines := TailLines(b.String(), ForensicsLogLines)
+	require.Len(t, lines, ForensicsLogLines)
+	assert.Equal(t, "line 31", lines[0])
+	assert.Equal(t, "line 80", lines[len(lines)-1])
+
+	long := TailLines(strings.Repeat("x", 5000), 10)
+	require.Len(t, long, 1)
+	assert.Equal(t, maxForensicsLineLength+len("…"), len(long[0]))
+}
@@ -0,0 +1,180 @@
+package monitoring
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Node Alert Types
+// =============================================================================
+
+// NodeAlertKind identifies what a node alert is about.
+type NodeAlertKind string
+
+const (
+	NodeAlertDiskPressure   NodeAlertKind = "disk_pressure"
+	NodeAlertMemoryPressure NodeAlertKind = "memory_pressure"
+	NodeAlertHighLoad       NodeAlertKind = "high_load"
+	NodeAlertDockerdDown    NodeAlertKind = "dockerd_down"
+	NodeAlertJournalErrors  NodeAlertKind = "journal_errors"
+	NodeAlertWildcardDNS    NodeAlertKind = "wildcard_dns"
+)
+
+// NodeAlertSeverity is how urgent a node alert is.
+type NodeAlertSeverity string
+
+const (
+	SeverityWarning  NodeAlertSeverity = "warning"
+	SeverityCritical NodeAlertSeverity = "critical"
+)
+
+// NodeAlert is a threshold violation found in a node metrics sample.
+type NodeAlert struct {
+	Kind      NodeAlertKind     `json:"kind"`
+	Severity  NodeAlertSeverity `json:"severity"`
+	Subject   string            `json:"subject,omitempty"` // e.g. the mount point for disk alerts
+	Value     float64           `json:"value"`
+	Threshold float64           `json:"threshold"`
+	Message   string            `json:"message"`
+}
+
+// NodeThresholds configures when node alerts fire.
+type NodeThresholds struct {
+	DiskPercent   float64 // Alert when any disk is at or above this usage
+	MemoryPercent float64 // Alert when memory usage is at or above this
+	LoadPerCore   float64 // Alert when 5-minute load average per core is at or above this
+	JournalErrors int     // Alert when at least this many journal errors were reported
+}
+
+// DefaultNodeThresholds returns the default alert thresholds.
+func DefaultNodeThresholds() NodeThresholds {
+	return NodeThresholds{
+		DiskPercent:   90,
+		MemoryPercent: 95,
+		LoadPerCore:   2.0,
+		JournalErrors: 10,
+	}
+}
+
+// =============================================================================
+// Node Alert Evaluation (Pure Functions)
+// =============================================================================
+
+// EvaluateNodeAlerts checks a metrics sample against thresholds.
+// Alerts are returned in a deterministic order (by kind, then subject).
+// A zero threshold disables that check.
+func EvaluateNodeAlerts(m minion.NodeMetrics, t NodeThresholds) []NodeAlert {
+	var alerts []NodeAlert
+
+	if t.DiskPercent > 0 {
+		for _, d := range m.Disks {
+			pct := d.UsedPercent()
+			if pct >= t.DiskPercent {
+				alerts = append(alerts, NodeAlert{
+					Kind:      NodeAlertDiskPressure,
+					Severity:  severityAbove(pct, t.DiskPercent, 98),
+					Subject:   d.Mount,
+					Value:     pct,
+					Threshold: t.DiskPercent,
+					Message:   fmt.Sprintf("disk %s is %.1f%% full", d.Mount, pct),
+				})
+			}
+		}
+	}
+
+	if t.MemoryPercent > 0 && m.MemoryTotalMB > 0 {
+		pct := float64(m.MemoryUsedMB) / float64(m.MemoryTotalMB) * 100
+		if pct >= t.MemoryPercent {
+			alerts = append(alerts, NodeAlert{
+				Kind:      NodeAlertMemoryPressure,
+				Severity:  SeverityWarning,
+				Value:     pct,
+				Threshold: t.MemoryPercent,
+				Message:   fmt.Sprintf("memory is %.1f%% used", pct),
+			})
+		}
+	}
+
+	if t.LoadPerCore > 0 && m.CPUCores > 0 {
+		perCore := m.LoadAvg5 / m.CPUCores
+		if perCore >= t.LoadPerCore {
+			alerts = append(alerts, NodeAlert{
+				Kind:      NodeAlertHighLoad,
+				Severity:  SeverityWarning,
+				Value:     perCore,
+				Threshold: t.LoadPerCore,
+				Message:   fmt.Sprintf("5-minute load average is %.2f per core", perCore),
+			})
+		}
+	}
+
+	if m.DockerdStatus != "" && m.DockerdStatus != "active" && m.DockerdStatus != "unknown" {
+		alerts = append(alerts, NodeAlert{
+			Kind:     NodeAlertDockerdDown,
+			Severity: SeverityCritical,
+			Message:  fmt.Sprintf("dockerd is %s", m.DockerdStatus),
+		})
+	}
+
+	if t.JournalErrors > 0 && len(m.JournalErrors) >= t.JournalErrors {
+		alerts = append(alerts, NodeAlert{
+			Kind:      NodeAlertJournalErrors,
+			Severity:  SeverityWarning,
+			Value:     float64(len(m.JournalErrors)),
+			Threshold: float64(t.JournalErrors),
+			Message:   fmt.Sprintf("%d errors in the system journal", len(m.JournalErrors)),
+		})
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Kind != alerts[j].Kind {
+			return alerts[i].Kind < alerts[j].Kind
+		}
+		return alerts[i].Subject < alerts[j].Subject
+	})
+	return alerts
+}
+
+// NewNodeAlerts returns alerts in current that were not present in previous,
+// matched by kind and subject. Used to notify only when an alert starts firing.
+func NewNodeAlerts(previous, current []NodeAlert) []NodeAlert {
+	seen := make(map[string]bool, len(previous))
+	for _, a := range previous {
+		seen[string(a.Kind)+"|"+a.Subject] = true
+	}
+	var fresh []NodeAlert
+	for _, a := range current {
+		if !seen[string(a.Kind)+"|"+a.Subject] {
+			fresh = append(fresh, a)
+		}
+	}
+	return fresh
+}
+
+// MaxDiskPercent returns the highest disk usage percentage across mounts.
+func MaxDiskPercent(disks []minion.DiskUsage) float64 {
+	var max float64
+	for _, d := range disks {
+		if pct := d.UsedPercent(); pct > max {
+			max = pct
+		}
+	}
+	return max
+}
+
+// RetentionCutoff returns the time before which metrics samples should be pruned.
+func RetentionCutoff(now time.Time, retention time.Duration) time.Time {
+	return now.Add(-retention)
+}
+
+// severityAbove escalates to critical once value reaches the critical level.
+func severityAbove(value, warning, critical float64) NodeAlertSeverity {
+	if critical > warning && value >= critical {
+		return SeverityCritical
+	}
+	return SeverityWarning
+}
@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func healthyNodeMetrics() minion.NodeMetrics {
+	return minion.NodeMetrics{
+		LoadAvg5:      1.0,
+		CPUCores:      4,
+		MemoryTotalMB: 8000,
+		MemoryUsedMB:  4000,
+		Disks:         []minion.DiskUsage{{Mount: "/", TotalMB: 1000, UsedMB: 500}},
+		DockerdStatus: "active",
+	}
+}
+
+func TestEvaluateNodeAlerts_Healthy(t *testing.T) {
+	assert.Empty(t, EvaluateNodeAlerts(healthyNodeMetrics(), DefaultNodeThresholds()))
+}
+
+func TestEvaluateNodeAlerts_DiskPressure(t *testing.T) {
+	m := healthyNodeMetrics()
+	m.Disks = []minion.DiskUsage{
+		{Mount: "/var/lib/docker", TotalMB: 1000, UsedMB: 990},
+		{Mount: "/", TotalMB: 1000, UsedMB: 900},
+	}
+
+	alerts := EvaluateNodeAlerts(m, DefaultNodeThresholds())
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "/", alerts[0].Subject)
+	assert.Equal(t, SeverityWarning, alerts[0].Severity)
+	assert.Equal(t, "/var/lib/docker", alerts[1].Subject)
+	assert.Equal(t, SeverityCritical, alerts[1].Severity)
+}
+
+func TestEvaluateNodeAlerts_AllKinds(t *testing.T) {
+	m := minion.NodeMetrics{
+		LoadAvg5:      10,
+		CPUCores:      4,
+		MemoryTotalMB: 1000,
+		MemoryUsedMB:  990,
+		DockerdStatus: "failed",
+		JournalErrors: make([]minion.JournalEntry, 10),
+	}
+
+	alerts := EvaluateNodeAlerts(m, DefaultNodeThresholds())
+	kinds := make([]NodeAlertKind, 0, len(alerts))
+	for _, a := range alerts {
+		kinds = append(kinds, a.Kind)
+	}
+	assert.Equal(t, []NodeAlertKind{
+		NodeAlertDockerdDown, NodeAlertHighLoad, NodeAlertJournalErrors, NodeAlertMemoryPressure,
+	}, kinds)
+}
+
+func TestEvaluateNodeAlerts_UnknownDockerdAndDisabledChecks(t *testing.T) {
+	m := healthyNodeMetrics()
+	m.DockerdStatus = "unknown"
+	m.Disks[0].UsedMB = 999
+
+	assert.Empty(t, EvaluateNodeAlerts(m, NodeThresholds{}))
+}
+
+func TestNewNodeAlerts(t *testing.T) {
+	prev := []NodeAlert{{Kind: NodeAlertDiskPressure, Subject: "/"}}
+	cur := []NodeAlert{
+		{Kind: NodeAlertDiskPressure, Subject: "/"},
+		{Kind: NodeAlertDiskPressure, Subject: "/data"},
+		{Kind: NodeAlertDockerdDown},
+	}
+
+	fresh := NewNodeAlerts(prev, cur)
+	require.Len(t, fresh, 2)
+	assert.Equal(t, "/data", fresh[0].Subject)
+	assert.Equal(t, NodeAlertDockerdDown, fresh[1].Kind)
+}
+
+func TestMaxDiskPercent(t *testing.T) {
+	assert.Equal(t, 0.0, MaxDiskPercent(nil))
+	assert.InDelta(t, 75.0, MaxDiskPercent([]minion.DiskUsage{
+		{TotalMB: 100, UsedMB: 50},
+		{TotalMB: 100, UsedMB: 75},
+	}), 0.001)
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), RetentionCutoff(now, 7*24*time.Hour))
+}
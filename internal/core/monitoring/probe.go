@@ -0,0 +1,149 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Service Probes (Pure Functions)
+// =============================================================================
+
+// maxProbeOutput bounds the probe output kept in a ProbeStatus.
+const maxProbeOutput = 512
+
+// ProbeDue reports whether a probe last recorded as prev should run again.
+func ProbeDue(prev *domain.ProbeStatus, interval time.Duration, now time.Time) bool {
+	return prev == nil || !now.Before(prev.LastProbeAt.Add(interval))
+}
+
+// RecordProbe folds one probe result into the previous status. A success
+// makes the service healthy at once; it becomes unhealthy after retries
+// consecutive failures. Until then a failing service keeps its previous
+// status, or is unknown if it has never passed.
+func RecordProbe(prev *domain.ProbeStatus, kind string, passed bool, output string, retries int, at time.Time) *domain.ProbeStatus {
+	if len(output) > maxProbeOutput {
+		output = output[:maxProbeOutput]
+	}
+	next := &domain.ProbeStatus{
+		Kind:        kind,
+		Status:      domain.HealthStatusUnknown,
+		LastOutput:  output,
+		LastProbeAt: at,
+	}
+	if passed {
+		next.Status = domain.HealthStatusHealthy
+		return next
+	}
+
+	next.ConsecutiveFailures = 1
+	if prev != nil {
+		next.ConsecutiveFailures = prev.ConsecutiveFailures + 1
+		next.Status = prev.Status
+	}
+	if next.ConsecutiveFailures >= retries {
+		next.Status = domain.HealthStatusUnhealthy
+	}
+	return next
+}
+
+// DetermineServiceHealth determines a service's health from its container
+// and, if the service has one, its probe. A probe only refines a running
+// container: a failing probe makes it unhealthy and a probe that has not yet
+// passed makes it unknown.
+func DetermineServiceHealth(status string, healthCheck *string, restarts int, probe *domain.ProbeStatus) domain.HealthStatus {
+	health := DetermineContainerHealth(status, healthCheck, restarts)
+	if probe == nil || health == domain.HealthStatusUnhealthy {
+		return health
+	}
+	switch probe.Status {
+	case domain.HealthStatusUnhealthy:
+		return domain.HealthStatusUnhealthy
+	case domain.HealthStatusUnknown:
+		return domain.HealthStatusUnknown
+	}
+	return health
+}
+
+// =============================================================================
+// Probe Limits
+// =============================================================================
+
+// ProbeLimits bound the probes run through a node's minion, so templates
+// cannot overload small nodes with slow, chatty or constantly failing
+// probes.
+type ProbeLimits struct {
+	// MaxTimeout caps the timeout of one probe.
+	MaxTimeout time.Duration
+	// MaxOutput caps the command output a node returns, in bytes.
+	MaxOutput int
+	// NodeRate is how many probes may run on one node per minute.
+	NodeRate int
+	// FailureBudget is how many failed probes a deployment may run per
+	// BudgetWindow before its probes are suspended for the rest of it.
+	FailureBudget int
+	BudgetWindow  time.Duration
+}
+
+// DefaultProbeLimits returns the limits used when none are configured.
+func DefaultProbeLimits() ProbeLimits {
+	return ProbeLimits{
+		MaxTimeout:    30 * time.Second,
+		MaxOutput:     4096,
+		NodeRate:      60,
+		FailureBudget: 30,
+		BudgetWindow:  time.Hour,
+	}
+}
+
+// CapTimeout returns a probe's timeout capped to MaxTimeout.
+func (l ProbeLimits) CapTimeout(timeout time.Duration) time.Duration {
+	if l.MaxTimeout > 0 && timeout > l.MaxTimeout {
+		return l.MaxTimeout
+	}
+	return timeout
+}
+
+// ProbeWindow counts probes in a fixed window of time.
+type ProbeWindow struct {
+	Start time.Time
+	Count int
+}
+
+// Add returns the window with one more probe at now, starting a new window
+// when the current one has ended.
+func (w ProbeWindow) Add(now time.Time, length time.Duration) ProbeWindow {
+	if w.ended(now, length) {
+		return ProbeWindow{Start: now, Count: 1}
+	}
+	w.Count++
+	return w
+}
+
+// Full reports whether limit probes were already counted in the window
+// containing now.
+func (w ProbeWindow) Full(limit int, now time.Time, length time.Duration) bool {
+	return !w.ended(now, length) && w.Count >= limit
+}
+
+// End returns when the window ends.
+func (w ProbeWindow) End(length time.Duration) time.Time {
+	return w.Start.Add(length)
+}
+
+func (w ProbeWindow) ended(now time.Time, length time.Duration) bool {
+	return w.Start.IsZero() || !now.Before(w.End(length))
+}
+
+// SuspendProbe returns the probe status of a service whose deployment spent
+// its failure budget: its last status, kept until probing resumes at until.
+func SuspendProbe(prev *domain.ProbeStatus, kind string, until time.Time) *domain.ProbeStatus {
+	next := &domain.ProbeStatus{Kind: kind, Status: domain.HealthStatusUnknown}
+	if prev != nil {
+		copied := *prev
+		next = &copied
+	}
+	next.SuspendedUntil = &until
+	return next
+}
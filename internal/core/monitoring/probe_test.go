@@ -0,0 +1,131 @@
+package monitoring
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// ProbeDue Tests
+// =============================================================================
+
+func TestProbeDue(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	prev := &domain.ProbeStatus{LastProbeAt: now.Add(-20 * time.Second)}
+
+	assert.True(t, ProbeDue(nil, 30*time.Second, now), "never probed")
+	assert.False(t, ProbeDue(prev, 30*time.Second, now))
+	assert.True(t, ProbeDue(prev, 20*time.Second, now))
+}
+
+// =============================================================================
+// RecordProbe Tests
+// =============================================================================
+
+func TestRecordProbe_Threshold(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s := RecordProbe(nil, "tcp", false, "connection refused", 3, now)
+	assert.Equal(t, domain.HealthStatusUnknown, s.Status, "never passed")
+	assert.Equal(t, 1, s.ConsecutiveFailures)
+
+	s = RecordProbe(s, "tcp", true, "", 3, now)
+	assert.Equal(t, domain.HealthStatusHealthy, s.Status)
+	assert.Equal(t, 0, s.ConsecutiveFailures)
+
+	s = RecordProbe(s, "tcp", false, "connection refused", 3, now)
+	s = RecordProbe(s, "tcp", false, "connection refused", 3, now)
+	assert.Equal(t, domain.HealthStatusHealthy, s.Status, "below threshold keeps status")
+	assert.Equal(t, 2, s.ConsecutiveFailures)
+
+	s = RecordProbe(s, "tcp", false, "connection refused", 3, now)
+	assert.Equal(t, domain.HealthStatusUnhealthy, s.Status)
+	assert.Equal(t, "connection refused", s.LastOutput)
+	assert.Equal(t, now, s.LastProbeAt)
+}
+
+func TestRecordProbe_TruncatesOutput(t *testing.T) {
+	s := RecordProbe(nil, "command", false, strings.Repeat("x", 2000), 1, time.Now())
+	assert.Len(t, s.LastOutput, maxProbeOutput)
+	assert.Equal(t, domain.HealthStatusUnhealthy, s.Status)
+}
+
+// =============================================================================
+// DetermineServiceHealth Tests
+// =============================================================================
+
+func TestDetermineServiceHealth(t *testing.T) {
+	healthy := &domain.ProbeStatus{Status: domain.HealthStatusHealthy}
+	failing := &domain.ProbeStatus{Status: domain.HealthStatusUnhealthy}
+	pending := &domain.ProbeStatus{Status: domain.HealthStatusUnknown}
+	starting := "starting"
+
+	tests := []struct {
+		name   string
+		status string
+		check  *string
+		probe  *domain.ProbeStatus
+		want   domain.HealthStatus
+	}{
+		{"no probe", "running", nil, nil, domain.HealthStatusHealthy},
+		{"probe passing", "running", nil, healthy, domain.HealthStatusHealthy},
+		{"probe failing", "running", nil, failing, domain.HealthStatusUnhealthy},
+		{"probe pending", "running", nil, pending, domain.HealthStatusUnknown},
+		{"stopped container", "exited", nil, healthy, domain.HealthStatusUnhealthy},
+		{"health check starting", "running", &starting, healthy, domain.HealthStatusDegraded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetermineServiceHealth(tt.status, tt.check, 0, tt.probe))
+		})
+	}
+}
+
+// =============================================================================
+// Probe Limits Tests
+// =============================================================================
+
+func TestProbeLimits_CapTimeout(t *testing.T) {
+	limits := DefaultProbeLimits()
+	assert.Equal(t, 5*time.Second, limits.CapTimeout(5*time.Second))
+	assert.Equal(t, 30*time.Second, limits.CapTimeout(10*time.Minute))
+}
+
+func TestProbeWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var w ProbeWindow
+	assert.False(t, w.Full(2, now, time.Minute), "empty window")
+
+	w = w.Add(now, time.Minute)
+	w = w.Add(now.Add(10*time.Second), time.Minute)
+	assert.Equal(t, 2, w.Count)
+	assert.True(t, w.Full(2, now.Add(30*time.Second), time.Minute))
+	assert.False(t, w.Full(3, now.Add(30*time.Second), time.Minute))
+
+	assert.False(t, w.Full(2, now.Add(time.Minute), time.Minute), "the window ended")
+	w = w.Add(now.Add(time.Minute), time.Minute)
+	assert.Equal(t, ProbeWindow{Start: now.Add(time.Minute), Count: 1}, w)
+}
+
+func TestSuspendProbe(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	until := now.Add(time.Hour)
+	prev := &domain.ProbeStatus{Kind: "command", Status: domain.HealthStatusUnhealthy, ConsecutiveFailures: 9, LastProbeAt: now}
+
+	s := SuspendProbe(prev, "command", until)
+	assert.Equal(t, domain.HealthStatusUnhealthy, s.Status, "keeps the last status")
+	assert.Equal(t, 9, s.ConsecutiveFailures)
+	assert.Equal(t, until, *s.SuspendedUntil)
+	assert.Nil(t, prev.SuspendedUntil, "prev is not modified")
+
+	s = SuspendProbe(nil, "tcp", until)
+	assert.Equal(t, domain.HealthStatusUnknown, s.Status)
+	assert.Equal(t, "tcp", s.Kind)
+
+	resumed := RecordProbe(s, "tcp", true, "", 3, until)
+	assert.Nil(t, resumed.SuspendedUntil)
+}
@@ -0,0 +1,123 @@
+// Package nodekey provides pure functions for the lifecycle of the SSH keys
+// hoster issues to nodes: the per-creator rotation policy, when a key is due
+// for rotation, how long a superseded key stays valid, and the
+// authorized_keys entries that identify hoster's keys on a node.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package nodekey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// =============================================================================
+// Key Status
+// =============================================================================
+
+// Status is where a node key is in its lifecycle.
+type Status string
+
+const (
+	// StatusActive is the key hoster connects to the node with.
+	StatusActive Status = "active"
+	// StatusRetiring is a superseded key that stays valid until its
+	// rotation window ends.
+	StatusRetiring Status = "retiring"
+	// StatusRevoked is a key hoster no longer accepts. It is removed from the
+	// node's authorized_keys as soon as the node can be reached.
+	StatusRevoked Status = "revoked"
+)
+
+// ErrActiveKey rejects revoking the key hoster connects with; rotate first.
+var ErrActiveKey = errors.New("the active node key cannot be revoked; rotate it first")
+
+// =============================================================================
+// Rotation Policy
+// =============================================================================
+
+// DefaultWindow is how long a superseded key stays valid when neither the
+// rotation nor the creator's policy chooses another window.
+const DefaultWindow = 24 * time.Hour
+
+// MaxWindowHours caps the window a superseded key stays valid.
+const MaxWindowHours = 7 * 24
+
+// MaxAgeDaysLimit caps the key age a policy can allow.
+const MaxAgeDaysLimit = 3650
+
+// Policy is a creator's rotation policy for the keys of their nodes.
+type Policy struct {
+	MaxAgeDays  int  // Keys older than this are due for rotation; 0 never
+	AutoRotate  bool // Rotate due keys automatically instead of reminding
+	WindowHours int  // Dual-valid window of superseded keys; 0 uses DefaultWindow
+}
+
+// Validate checks a policy set by its creator.
+func (p Policy) Validate() error {
+	if p.MaxAgeDays < 0 || p.MaxAgeDays > MaxAgeDaysLimit {
+		return fmt.Errorf("max_age_days must be between 0 and %d, got %d", MaxAgeDaysLimit, p.MaxAgeDays)
+	}
+	if p.AutoRotate && p.MaxAgeDays == 0 {
+		return errors.New("auto_rotate needs max_age_days")
+	}
+	if err := ValidateWindowHours(p.WindowHours); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateWindowHours checks a dual-valid window in hours.
+func ValidateWindowHours(hours int) error {
+	if hours < 0 || hours > MaxWindowHours {
+		return fmt.Errorf("window_hours must be between 0 and %d, got %d", MaxWindowHours, hours)
+	}
+	return nil
+}
+
+// Window returns the dual-valid window of the policy.
+func (p Policy) Window() time.Duration {
+	if p.WindowHours == 0 {
+		return DefaultWindow
+	}
+	return time.Duration(p.WindowHours) * time.Hour
+}
+
+// RotationDue reports whether a key issued at issuedAt is due for rotation
+// under the policy.
+func RotationDue(p Policy, issuedAt, now time.Time) bool {
+	if p.MaxAgeDays == 0 {
+		return false
+	}
+	return !now.Before(issuedAt.AddDate(0, 0, p.MaxAgeDays))
+}
+
+// =============================================================================
+// authorized_keys Entries
+// =============================================================================
+
+// CommentPrefix starts the comment of every authorized_keys entry hoster
+// installs, followed by the node key's reference ID.
+const CommentPrefix = "hoster-node-key:"
+
+// ErrInvalidPublicKey rejects a public key that can't be installed.
+var ErrInvalidPublicKey = errors.New("invalid node public key")
+
+// Entry returns the authorized_keys line that installs a public key for a
+// node key, and the key's base64 blob, which identifies the line when it is
+// removed. Both are safe to quote in a shell command.
+func Entry(publicKey, keyRefID string) (line, blob string, err error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	if strings.ContainsAny(keyRefID, " '\n") {
+		return "", "", fmt.Errorf("%w: bad key reference %q", ErrInvalidPublicKey, keyRefID)
+	}
+	line = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	_, blob, _ = strings.Cut(line, " ")
+	return line + " " + CommentPrefix + keyRefID, blob, nil
+}
@@ -0,0 +1,55 @@
+package nodekey
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Policy Tests
+// =============================================================================
+
+func TestPolicyValidate(t *testing.T) {
+	assert.NoError(t, Policy{}.Validate())
+	assert.NoError(t, Policy{MaxAgeDays: 90, AutoRotate: true, WindowHours: 48}.Validate())
+	assert.Error(t, Policy{MaxAgeDays: -1}.Validate())
+	assert.Error(t, Policy{MaxAgeDays: MaxAgeDaysLimit + 1}.Validate())
+	assert.Error(t, Policy{AutoRotate: true}.Validate(), "auto rotation needs a max age")
+	assert.Error(t, Policy{WindowHours: -1}.Validate())
+	assert.Error(t, Policy{WindowHours: MaxWindowHours + 1}.Validate())
+}
+
+func TestPolicyWindow(t *testing.T) {
+	assert.Equal(t, DefaultWindow, Policy{}.Window())
+	assert.Equal(t, 2*time.Hour, Policy{WindowHours: 2}.Window())
+}
+
+func TestRotationDue(t *testing.T) {
+	issued := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := Policy{MaxAgeDays: 30}
+
+	assert.False(t, RotationDue(Policy{}, issued, issued.AddDate(10, 0, 0)), "no max age")
+	assert.False(t, RotationDue(p, issued, issued.AddDate(0, 0, 30).Add(-time.Second)))
+	assert.True(t, RotationDue(p, issued, issued.AddDate(0, 0, 30)))
+}
+
+// =============================================================================
+// Entry Tests
+// =============================================================================
+
+const testPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOa3sd6gPZBsMiJ2yfmQ+98vbGyvotU2KGO3w34WCank"
+
+func TestEntry(t *testing.T) {
+	line, blob, err := Entry(testPublicKey+" someone@laptop\n", "nkey_abc123")
+	require.NoError(t, err)
+	assert.Equal(t, testPublicKey+" hoster-node-key:nkey_abc123", line, "the key's own comment is replaced")
+	assert.Equal(t, "AAAAC3NzaC1lZDI1NTE5AAAAIOa3sd6gPZBsMiJ2yfmQ+98vbGyvotU2KGO3w34WCank", blob)
+
+	_, _, err = Entry("not a key", "nkey_abc123")
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+	_, _, err = Entry(testPublicKey, "nkey_'; rm -rf ~")
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+}
@@ -0,0 +1,336 @@
+// Package notify provides pure functions for user notifications: the events
+// users can subscribe to, per-channel event routing, validating channel
+// configuration, and rendering events into Slack and Web Push payloads.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package notify
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Events
+// =============================================================================
+
+// EventType identifies what a notification is about.
+type EventType string
+
+const (
+	// EventDeploymentRunning fires when a deployment has started.
+	EventDeploymentRunning EventType = "deployment.running"
+	// EventDeploymentFailed fires when a deployment fails to start or upgrade.
+	EventDeploymentFailed EventType = "deployment.failed"
+	// EventDeploymentStopped fires when a deployment has stopped.
+	EventDeploymentStopped EventType = "deployment.stopped"
+	// EventDeploymentExpiring fires ahead of a trial deployment's expiry.
+	EventDeploymentExpiring EventType = "deployment.expiring"
+	// EventDeploymentExpired fires when a trial deployment has expired and is stopped.
+	EventDeploymentExpired EventType = "deployment.expired"
+	// EventDeploymentUnhealthy fires when a deployment's service fails its health check.
+	EventDeploymentUnhealthy EventType = "deployment.unhealthy"
+	// EventNodeAlert fires when a node raises a new threshold alert.
+	EventNodeAlert EventType = "node.alert"
+	// EventNodeOffline fires when an online node stops answering health checks.
+	EventNodeOffline EventType = "node.offline"
+	// EventNodeKeyRotationDue fires when a node key is older than its creator's rotation policy allows.
+	EventNodeKeyRotationDue EventType = "node.key_rotation_due"
+	// EventProvisionCompleted fires when a cloud provision is ready and its node created.
+	EventProvisionCompleted EventType = "provision.completed"
+	// EventSpendingAlert fires when an account's month spend nears or reaches its limit.
+	EventSpendingAlert EventType = "spending.alert"
+	// EventDeploymentAbuse fires when a deployment on one's node is flagged for likely abuse.
+	EventDeploymentAbuse EventType = "deployment.abuse"
+	// EventDeploymentSLOBurn fires when a deployment spends its SLO error budget too fast.
+	EventDeploymentSLOBurn EventType = "deployment.slo_burn"
+	// EventTest is sent by the channel test action. It is not routable.
+	EventTest EventType = "notification.test"
+)
+
+// Template events go to a template creator's template webhooks, never to
+// channels: they are about other users' deployments of the template.
+const (
+	// EventTemplateDeploymentCreated fires when a deployment of the template is created.
+	EventTemplateDeploymentCreated EventType = "template.deployment.created"
+	// EventTemplateDeploymentStarted fires when a deployment of the template is running.
+	EventTemplateDeploymentStarted EventType = "template.deployment.started"
+	// EventTemplateDeploymentDeleted fires when a deployment of the template is deleted.
+	EventTemplateDeploymentDeleted EventType = "template.deployment.deleted"
+)
+
+// TemplateEventTypes lists the event types a template webhook can subscribe to.
+var TemplateEventTypes = []EventType{EventTemplateDeploymentCreated, EventTemplateDeploymentStarted, EventTemplateDeploymentDeleted}
+
+// AllEventTypes lists the event types a channel can subscribe to.
+var AllEventTypes = []EventType{EventDeploymentRunning, EventDeploymentFailed, EventDeploymentStopped, EventDeploymentExpiring, EventDeploymentExpired, EventDeploymentUnhealthy, EventNodeAlert, EventNodeOffline, EventNodeKeyRotationDue, EventProvisionCompleted, EventSpendingAlert, EventDeploymentAbuse, EventDeploymentSLOBurn}
+
+// Valid reports whether t is an event type channels can subscribe to.
+func (t EventType) Valid() bool {
+	for _, k := range AllEventTypes {
+		if t == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Severity is how urgent a notification is.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Event is one notification to deliver to a user's channels.
+type Event struct {
+	Type         EventType `json:"type"`
+	Severity     Severity  `json:"severity"`
+	Title        string    `json:"title"`
+	Message      string    `json:"message"`
+	ResourceType string    `json:"resource_type,omitempty"`
+	ResourceID   string    `json:"resource_id,omitempty"`
+	URL          string    `json:"url,omitempty"` // Where the user can act on it
+	OccurredAt   time.Time `json:"occurred_at"`
+	Data         any       `json:"data,omitempty"` // Details for webhooks; channels ignore it
+}
+
+// =============================================================================
+// Routing
+// =============================================================================
+
+// ValidateEvents checks a channel's event subscription list.
+func ValidateEvents(events []string) error {
+	return validateEvents(events, AllEventTypes)
+}
+
+// ValidateTemplateEvents checks a template webhook's event subscription list.
+func ValidateTemplateEvents(events []string) error {
+	return validateEvents(events, TemplateEventTypes)
+}
+
+func validateEvents(events []string, valid []EventType) error {
+	seen := make(map[string]bool, len(events))
+	for _, e := range events {
+		if !slices.Contains(valid, EventType(e)) {
+			return fmt.Errorf("unknown event type %q (valid: %s)", e, joinEventTypes(valid))
+		}
+		if seen[e] {
+			return fmt.Errorf("event type %q listed twice", e)
+		}
+		seen[e] = true
+	}
+	return nil
+}
+
+// Routes reports whether a channel subscribed to events receives an event of
+// type t. An empty subscription receives every event; test events reach every
+// channel.
+func Routes(events []string, t EventType) bool {
+	if len(events) == 0 || t == EventTest {
+		return true
+	}
+	for _, e := range events {
+		if EventType(e) == t {
+			return true
+		}
+	}
+	return false
+}
+
+func joinEventTypes(types []EventType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// =============================================================================
+// Channel Configuration
+// =============================================================================
+
+// ChannelType is a kind of notification channel.
+type ChannelType string
+
+const (
+	ChannelSlack   ChannelType = "slack"
+	ChannelWebPush ChannelType = "webpush"
+)
+
+// ErrInvalidConfig is returned for channel configuration that cannot be used.
+var ErrInvalidConfig = errors.New("invalid channel config")
+
+// SlackConfig configures a Slack channel: either an incoming webhook URL, or
+// an app token and the channel to post to.
+type SlackConfig struct {
+	WebhookURL string `json:"webhook_url,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+}
+
+// Validate checks that exactly one way of posting is configured.
+func (c SlackConfig) Validate() error {
+	switch {
+	case c.WebhookURL != "" && c.Token != "":
+		return fmt.Errorf("%w: set webhook_url or token, not both", ErrInvalidConfig)
+	case c.WebhookURL != "":
+		if err := validateHTTPS(c.WebhookURL); err != nil {
+			return fmt.Errorf("%w: webhook_url %v", ErrInvalidConfig, err)
+		}
+	case c.Token != "":
+		if !strings.HasPrefix(c.Token, "xoxb-") && !strings.HasPrefix(c.Token, "xoxp-") {
+			return fmt.Errorf("%w: token must be a Slack bot (xoxb-) or user (xoxp-) token", ErrInvalidConfig)
+		}
+		if c.Channel == "" {
+			return fmt.Errorf("%w: channel is required with token", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: webhook_url or token is required", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// WebPushSubscription is a browser push subscription, in the shape
+// PushSubscription.toJSON() produces.
+type WebPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Validate checks the endpoint and the subscription's public key and secret.
+func (s WebPushSubscription) Validate() error {
+	if err := validateHTTPS(s.Endpoint); err != nil {
+		return fmt.Errorf("%w: endpoint %v", ErrInvalidConfig, err)
+	}
+	key, err := DecodeBase64URL(s.Keys.P256dh)
+	if err != nil || len(key) != 65 || key[0] != 0x04 {
+		return fmt.Errorf("%w: keys.p256dh must be an uncompressed P-256 public key", ErrInvalidConfig)
+	}
+	auth, err := DecodeBase64URL(s.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return fmt.Errorf("%w: keys.auth must be a 16-byte secret", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// ValidateConfig checks the JSON configuration of a channel of type t.
+func ValidateConfig(t ChannelType, raw []byte) error {
+	switch t {
+	case ChannelSlack:
+		var c SlackConfig
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		return c.Validate()
+	case ChannelWebPush:
+		var s WebPushSubscription
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		return s.Validate()
+	}
+	return fmt.Errorf("unknown channel type %q", t)
+}
+
+func validateHTTPS(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("must be a URL")
+	}
+	if u.Scheme != "https" {
+		return errors.New("must use https")
+	}
+	return nil
+}
+
+// DecodeBase64URL decodes URL-safe base64 with or without padding, as push
+// subscriptions and VAPID keys are written either way.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// =============================================================================
+// Payloads
+// =============================================================================
+
+var severityEmoji = map[Severity]string{
+	SeverityInfo:     ":information_source:",
+	SeverityWarning:  ":warning:",
+	SeverityCritical: ":rotating_light:",
+}
+
+// SlackMessage renders an event as a chat.postMessage / incoming webhook
+// body. channel is only set for token-based posting.
+func SlackMessage(ev Event, channel string) map[string]any {
+	headline := ev.Title
+	if emoji, ok := severityEmoji[ev.Severity]; ok {
+		headline = emoji + " " + ev.Title
+	}
+	section := "*" + headline + "*"
+	if ev.Message != "" {
+		section += "\n" + ev.Message
+	}
+	blocks := []map[string]any{
+		{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": section}},
+	}
+	contextText := "`" + string(ev.Type) + "`"
+	if ev.ResourceID != "" {
+		contextText += " · " + ev.ResourceID
+	}
+	if ev.URL != "" {
+		contextText += " · <" + ev.URL + "|Open in Hoster>"
+	}
+	blocks = append(blocks, map[string]any{
+		"type":     "context",
+		"elements": []map[string]any{{"type": "mrkdwn", "text": contextText}},
+	})
+
+	msg := map[string]any{
+		"text":   headline, // Fallback for notifications and clients without blocks
+		"blocks": blocks,
+	}
+	if channel != "" {
+		msg["channel"] = channel
+	}
+	return msg
+}
+
+// WebPushMessage renders an event as the JSON a service worker receives in
+// its push event.
+func WebPushMessage(ev Event) []byte {
+	tag := string(ev.Type)
+	if ev.ResourceID != "" {
+		tag += ":" + ev.ResourceID // Newer notifications replace older ones
+	}
+	b, _ := json.Marshal(map[string]any{
+		"title":    ev.Title,
+		"body":     ev.Message,
+		"url":      ev.URL,
+		"tag":      tag,
+		"type":     ev.Type,
+		"severity": ev.Severity,
+	})
+	return b
+}
+
+// PushUrgency maps severity to the Web Push Urgency header (RFC 8030).
+func PushUrgency(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "high"
+	case SeverityWarning:
+		return "normal"
+	}
+	return "low"
+}
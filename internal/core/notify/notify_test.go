@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Routing Tests
+// =============================================================================
+
+func TestValidateEvents(t *testing.T) {
+	assert.NoError(t, ValidateEvents(nil))
+	assert.NoError(t, ValidateEvents([]string{"deployment.failed", "node.alert"}))
+	assert.NoError(t, ValidateEvents([]string{"deployment.unhealthy", "node.offline", "provision.completed"}))
+
+	err := ValidateEvents([]string{"deployment.exploded"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deployment.running")
+
+	assert.ErrorContains(t, ValidateEvents([]string{"node.alert", "node.alert"}), "listed twice")
+	assert.Error(t, ValidateEvents([]string{string(EventTest)}), "test events are not subscribable")
+}
+
+func TestValidateTemplateEvents(t *testing.T) {
+	assert.NoError(t, ValidateTemplateEvents([]string{"template.deployment.created", "template.deployment.deleted"}))
+	assert.Error(t, ValidateTemplateEvents([]string{"deployment.failed"}), "a template webhook only gets template events")
+	assert.Error(t, ValidateEvents([]string{"template.deployment.started"}), "channels never get template events")
+}
+
+func TestRoutes(t *testing.T) {
+	assert.True(t, Routes(nil, EventDeploymentFailed), "empty subscription receives everything")
+	assert.True(t, Routes([]string{"deployment.failed"}, EventDeploymentFailed))
+	assert.False(t, Routes([]string{"deployment.failed"}, EventNodeAlert))
+	assert.True(t, Routes([]string{"deployment.failed"}, EventTest), "test events reach every channel")
+}
+
+// =============================================================================
+// Config Validation Tests
+// =============================================================================
+
+func TestSlackConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SlackConfig
+		wantErr string
+	}{
+		{"webhook", SlackConfig{WebhookURL: "https://hooks.slack.com/services/T0/B0/x"}, ""},
+		{"token", SlackConfig{Token: "xoxb-1-2-abc", Channel: "#ops"}, ""},
+		{"empty", SlackConfig{}, "webhook_url or token is required"},
+		{"both", SlackConfig{WebhookURL: "https://hooks.slack.com/x", Token: "xoxb-1"}, "not both"},
+		{"http webhook", SlackConfig{WebhookURL: "http://hooks.slack.com/x"}, "must use https"},
+		{"bad token", SlackConfig{Token: "abc", Channel: "#ops"}, "xoxb-"},
+		{"token without channel", SlackConfig{Token: "xoxb-1"}, "channel is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func testSubscription() WebPushSubscription {
+	var s WebPushSubscription
+	s.Endpoint = "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV"
+	s.Keys.P256dh = rfcUAPublic
+	s.Keys.Auth = rfcAuthSecret
+	return s
+}
+
+func TestWebPushSubscription_Validate(t *testing.T) {
+	assert.NoError(t, testSubscription().Validate())
+
+	s := testSubscription()
+	s.Endpoint = "http://push.example.net/x"
+	assert.ErrorContains(t, s.Validate(), "must use https")
+
+	s = testSubscription()
+	s.Keys.P256dh = "AAAA"
+	assert.ErrorContains(t, s.Validate(), "p256dh")
+
+	s = testSubscription()
+	s.Keys.Auth = base64.RawURLEncoding.EncodeToString(make([]byte, 8))
+	assert.ErrorContains(t, s.Validate(), "16-byte")
+
+	// Padded base64 is accepted too
+	s = testSubscription()
+	s.Keys.Auth += "=="
+	assert.NoError(t, s.Validate())
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, ValidateConfig(ChannelSlack, []byte(`{"webhook_url":"https://hooks.slack.com/x"}`)))
+	assert.ErrorIs(t, ValidateConfig(ChannelSlack, []byte(`{`)), ErrInvalidConfig)
+
+	sub, _ := json.Marshal(testSubscription())
+	assert.NoError(t, ValidateConfig(ChannelWebPush, sub))
+	assert.ErrorIs(t, ValidateConfig(ChannelWebPush, []byte(`{"endpoint":"https://x"}`)), ErrInvalidConfig)
+
+	assert.ErrorContains(t, ValidateConfig("discord", []byte(`{}`)), "unknown channel type")
+}
+
+// =============================================================================
+// Payload Tests
+// =============================================================================
+
+func TestSlackMessage(t *testing.T) {
+	ev := Event{
+		Type: EventDeploymentFailed, Severity: SeverityCritical,
+		Title: "Deployment blog failed", Message: "image not found",
+		ResourceID: "depl_1", URL: "https://hoster.example.com/deployments/depl_1",
+	}
+
+	msg := SlackMessage(ev, "")
+	assert.Equal(t, ":rotating_light: Deployment blog failed", msg["text"])
+	assert.NotContains(t, msg, "channel")
+	blocks := msg["blocks"].([]map[string]any)
+	require.Len(t, blocks, 2)
+	assert.Equal(t, "*:rotating_light: Deployment blog failed*\nimage not found", blocks[0]["text"].(map[string]any)["text"])
+	ctx := blocks[1]["elements"].([]map[string]any)[0]["text"].(string)
+	assert.Equal(t, "`deployment.failed` · depl_1 · <https://hoster.example.com/deployments/depl_1|Open in Hoster>", ctx)
+
+	assert.Equal(t, "#ops", SlackMessage(ev, "#ops")["channel"])
+}
+
+func TestWebPushMessage(t *testing.T) {
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(WebPushMessage(Event{
+		Type: EventNodeAlert, Severity: SeverityWarning, Title: "Disk pressure", Message: "/ at 92%", ResourceID: "node_1",
+	}), &got))
+	assert.Equal(t, "Disk pressure", got["title"])
+	assert.Equal(t, "/ at 92%", got["body"])
+	assert.Equal(t, "node.alert:node_1", got["tag"])
+	assert.Equal(t, "warning", got["severity"])
+}
+
+func TestPushUrgency(t *testing.T) {
+	assert.Equal(t, "high", PushUrgency(SeverityCritical))
+	assert.Equal(t, "normal", PushUrgency(SeverityWarning))
+	assert.Equal(t, "low", PushUrgency(SeverityInfo))
+}
+
+// =============================================================================
+// Web Push Encryption Tests
+// =============================================================================
+
+// Test vector from RFC 8291, Appendix A.
+const (
+	rfcPlaintext  = "When I grow up, I want to be a watermelon"
+	rfcUAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcAuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcSalt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcBody       = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := DecodeBase64URL(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestEncryptWebPush_RFC8291Vector(t *testing.T) {
+	asKey, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfcASPrivate))
+	require.NoError(t, err)
+
+	body, err := EncryptWebPush([]byte(rfcPlaintext), testSubscription(), asKey, mustDecode(t, rfcSalt))
+	require.NoError(t, err)
+	assert.Equal(t, rfcBody, base64.RawURLEncoding.EncodeToString(body))
+}
+
+func TestEncryptWebPush_Errors(t *testing.T) {
+	asKey, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfcASPrivate))
+	require.NoError(t, err)
+	salt := mustDecode(t, rfcSalt)
+
+	_, err = EncryptWebPush([]byte(strings.Repeat("x", MaxWebPushPayload+1)), testSubscription(), asKey, salt)
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	_, err = EncryptWebPush([]byte("hi"), testSubscription(), asKey, salt[:8])
+	assert.ErrorContains(t, err, "salt")
+
+	sub := testSubscription()
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(append([]byte{0x04}, make([]byte, 64)...))
+	_, err = EncryptWebPush([]byte("hi"), sub, asKey, salt)
+	assert.ErrorIs(t, err, ErrInvalidConfig, "a point not on the curve is rejected")
+}
+
+func TestVAPIDAudience(t *testing.T) {
+	aud, err := VAPIDAudience("https://fcm.googleapis.com/fcm/send/abc:def")
+	require.NoError(t, err)
+	assert.Equal(t, "https://fcm.googleapis.com", aud)
+
+	_, err = VAPIDAudience("not a url")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// =============================================================================
+// Web Push Encryption (RFC 8291)
+// =============================================================================
+
+// pushRecordSize is the aes128gcm record size. Payloads are sent as a single
+// record, so it also bounds the encrypted body.
+const pushRecordSize = 4096
+
+// MaxWebPushPayload is the largest plaintext that fits the 4096-byte body
+// every push service accepts: one record minus the 86-byte header, the
+// padding delimiter and the 16-byte tag.
+const MaxWebPushPayload = pushRecordSize - 86 - 1 - 16
+
+// ErrPayloadTooLarge is returned for a push payload over MaxWebPushPayload.
+var ErrPayloadTooLarge = errors.New("web push payload too large")
+
+// EncryptWebPush encrypts plaintext for a subscription with the aes128gcm
+// content coding. asKey is a fresh P-256 key pair and salt 16 random bytes;
+// both must be new for every message. The result is the request body.
+func EncryptWebPush(plaintext []byte, sub WebPushSubscription, asKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(plaintext) > MaxWebPushPayload {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrPayloadTooLarge, len(plaintext), MaxWebPushPayload)
+	}
+	if len(salt) != 16 {
+		return nil, errors.New("salt must be 16 bytes")
+	}
+	uaPublicBytes, err := DecodeBase64URL(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("%w: keys.p256dh: %v", ErrInvalidConfig, err)
+	}
+	authSecret, err := DecodeBase64URL(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("%w: keys.auth: %v", ErrInvalidConfig, err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: keys.p256dh: %v", ErrInvalidConfig, err)
+	}
+	ecdhSecret, err := asKey.ECDH(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+	asPublicBytes := asKey.PublicKey().Bytes()
+
+	// IKM binds the shared secret to both keys and the subscription's auth secret
+	keyInfo := "WebPush: info\x00" + string(uaPublicBytes) + string(asPublicBytes)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt | record size | key id length | key id (the sender's public key)
+	body := make([]byte, 0, 16+4+1+len(asPublicBytes)+len(plaintext)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, pushRecordSize)
+	body = append(body, byte(len(asPublicBytes)))
+	body = append(body, asPublicBytes...)
+
+	// A single, final record: the 0x02 delimiter marks the last record
+	record := append(append([]byte(nil), plaintext...), 0x02)
+	return gcm.Seal(body, nonce, record, nil), nil
+}
+
+// VAPIDAudience returns the "aud" claim for a push endpoint: its origin.
+func VAPIDAudience(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%w: endpoint must be a URL", ErrInvalidConfig)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
@@ -0,0 +1,95 @@
+// Package pagination provides pure functions for keyset (cursor) pagination.
+// A cursor names the last row of a page by its sort timestamp and ID, so the
+// next page starts strictly after it no matter how many rows were inserted
+// or deleted in between, which offsets cannot guarantee.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Cursors
+// =============================================================================
+
+// TimeFormat is the layout cursor timestamps use. It is what SQLite's
+// datetime() returns, so cursors compare directly against
+// datetime(created_at) whichever layout the column was written in.
+const TimeFormat = "2006-01-02 15:04:05"
+
+// ErrInvalidCursor is returned for a cursor that was not issued by Encode.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Cursor is the position after a row in a list ordered by (At, ID). At is
+// the list's sort timestamp: created_at for most lists.
+type Cursor struct {
+	At time.Time
+	ID int64
+}
+
+// After returns the cursor positioned after the row with the given sort
+// timestamp and ID. Timestamps are truncated to the second, like the
+// columns they are compared with; ID breaks ties.
+func After(at time.Time, id int64) Cursor {
+	return Cursor{At: at.UTC().Truncate(time.Second), ID: id}
+}
+
+// Key returns the cursor's timestamp in TimeFormat, for use as a query
+// argument.
+func (c Cursor) Key() string {
+	return c.At.UTC().Format(TimeFormat)
+}
+
+// Encode returns the opaque string clients pass back as page[cursor].
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.At.Unix(), 10) + "." + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decode parses a cursor returned by Encode.
+func Decode(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	secs, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	unix, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil || unix < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{At: time.Unix(unix, 0).UTC(), ID: n}, nil
+}
+
+// =============================================================================
+// Timestamps
+// =============================================================================
+
+// ParseTime reads a sort timestamp as stored: RFC 3339 or TimeFormat.
+func ParseTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case []byte:
+		return ParseTime(string(t))
+	case string:
+		if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+			return parsed, true
+		}
+		if parsed, err := time.Parse(TimeFormat, t); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,61 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Cursor Tests
+// =============================================================================
+
+func TestCursor_RoundTrip(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 45, 500_000_000, time.UTC)
+	c := After(at, 42)
+
+	got, err := Decode(c.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, c, got)
+	assert.Equal(t, "2026-03-01 12:30:45", got.Key())
+	assert.Equal(t, int64(42), got.ID)
+}
+
+func TestCursor_KeyIsUTC(t *testing.T) {
+	at := time.Date(2026, 3, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	assert.Equal(t, "2026-03-01 12:00:00", After(at, 1).Key())
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"not base64!",
+		"MTIz",       // "123": no ID
+		"YWJjLjE",    // "abc.1"
+		"MTIzLjA",    // "123.0": IDs start at 1
+		"MTIzLmFiYw", // "123.abc"
+	} {
+		_, err := Decode(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
+
+// =============================================================================
+// Timestamp Tests
+// =============================================================================
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, v := range []any{want, "2026-03-01T12:00:00Z", "2026-03-01 12:00:00"} {
+		got, ok := ParseTime(v)
+		require.True(t, ok, v)
+		assert.True(t, want.Equal(got), v)
+	}
+
+	_, ok := ParseTime("yesterday")
+	assert.False(t, ok)
+	_, ok = ParseTime(nil)
+	assert.False(t, ok)
+}
@@ -0,0 +1,266 @@
+// Package payout provides pure functions for creator revenue share, earnings
+// ledgers, and payout scheduling.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package payout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// =============================================================================
+// Revenue Share
+// =============================================================================
+
+const (
+	// DefaultPlatformFeeBps is the platform's share of template revenue (20%).
+	DefaultPlatformFeeBps = 2000
+
+	// DefaultMinimumPayoutCents is the smallest balance paid out to a creator.
+	DefaultMinimumPayoutCents = 2500
+
+	// maxBps is 100% expressed in basis points.
+	maxBps = 10000
+)
+
+// ErrInvalidFee is returned when a platform fee is outside 0–100%.
+var ErrInvalidFee = errors.New("platform fee must be between 0 and 10000 basis points")
+
+// ValidateFeeBps checks that a platform fee is a valid percentage in basis points.
+func ValidateFeeBps(feeBps int) error {
+	if feeBps < 0 || feeBps > maxBps {
+		return fmt.Errorf("%w: got %d", ErrInvalidFee, feeBps)
+	}
+	return nil
+}
+
+// PercentToBps converts a percentage (e.g. 20 or 12.5) to basis points.
+func PercentToBps(percent float64) int {
+	return int(percent*100 + 0.5)
+}
+
+// Split divides a gross amount into the platform fee and the creator's net share.
+// The fee is rounded half-up; the creator receives the remainder so that
+// fee + net always equals gross.
+func Split(grossCents int64, feeBps int) (feeCents, netCents int64) {
+	if grossCents <= 0 {
+		return 0, 0
+	}
+	feeCents = (grossCents*int64(feeBps) + maxBps/2) / maxBps
+	return feeCents, grossCents - feeCents
+}
+
+// =============================================================================
+// Earnings
+// =============================================================================
+
+// InvoiceLine is a billed line item on a paid invoice.
+// Tags match the invoice items JSON written by the invoice generator.
+type InvoiceLine struct {
+	DeploymentID string `json:"deployment_id"`
+	MonthlyCents int64  `json:"monthly_cents"`
+}
+
+// Attribution identifies the template and creator behind a deployment.
+type Attribution struct {
+	CreatorID  int
+	TemplateID int
+}
+
+// Earning is a single creator earnings ledger entry derived from an invoice line.
+type Earning struct {
+	CreatorID    int
+	TemplateID   int
+	DeploymentID string
+	GrossCents   int64
+	FeeCents     int64
+	NetCents     int64
+}
+
+// ComputeEarnings derives creator earnings from the lines of a paid invoice.
+// Lines without an attribution or amount are skipped, as are lines where the
+// creator paid for their own template (payerID == creator).
+func ComputeEarnings(lines []InvoiceLine, attributions map[string]Attribution, payerID int, feeBps int) []Earning {
+	var earnings []Earning
+	for _, line := range lines {
+		if line.MonthlyCents <= 0 {
+			continue
+		}
+		attr, ok := attributions[line.DeploymentID]
+		if !ok || attr.CreatorID == 0 || attr.CreatorID == payerID {
+			continue
+		}
+		fee, net := Split(line.MonthlyCents, feeBps)
+		earnings = append(earnings, Earning{
+			CreatorID:    attr.CreatorID,
+			TemplateID:   attr.TemplateID,
+			DeploymentID: line.DeploymentID,
+			GrossCents:   line.MonthlyCents,
+			FeeCents:     fee,
+			NetCents:     net,
+		})
+	}
+	return earnings
+}
+
+// =============================================================================
+// Ledger Status
+// =============================================================================
+
+// LedgerStatus is the payout state of an earnings ledger entry.
+type LedgerStatus string
+
+const (
+	LedgerPending  LedgerStatus = "pending"   // Earned, not yet included in a payout
+	LedgerInPayout LedgerStatus = "in_payout" // Reserved by a payout in flight
+	LedgerPaidOut  LedgerStatus = "paid_out"  // Transferred to the creator
+)
+
+// =============================================================================
+// Payout Scheduling
+// =============================================================================
+
+// Schedule is how often a creator is paid out.
+type Schedule string
+
+const (
+	ScheduleWeekly  Schedule = "weekly"
+	ScheduleMonthly Schedule = "monthly"
+)
+
+// ValidSchedule reports whether s is a known payout schedule.
+func ValidSchedule(s Schedule) bool {
+	return s == ScheduleWeekly || s == ScheduleMonthly
+}
+
+// NextPayoutAt returns when the next payout is due after the last one.
+// A zero last time means the creator has never been paid and is due immediately.
+// Unknown schedules fall back to monthly.
+func NextPayoutAt(last time.Time, schedule Schedule) time.Time {
+	if last.IsZero() {
+		return last
+	}
+	last = last.UTC()
+	if schedule == ScheduleWeekly {
+		return last.AddDate(0, 0, 7)
+	}
+	return last.AddDate(0, 1, 0)
+}
+
+// IsDue reports whether a payout should run now.
+func IsDue(last time.Time, schedule Schedule, now time.Time) bool {
+	return !now.Before(NextPayoutAt(last, schedule))
+}
+
+// ShouldPayout reports whether a balance meets the minimum payout threshold.
+func ShouldPayout(balanceCents, minimumCents int64) bool {
+	if minimumCents <= 0 {
+		return balanceCents > 0
+	}
+	return balanceCents >= minimumCents
+}
+
+// =============================================================================
+// Earnings Report
+// =============================================================================
+
+// LedgerEntry is the subset of a ledger row needed for reporting.
+type LedgerEntry struct {
+	TemplateID int
+	Month      string // "YYYY-MM"
+	GrossCents int64
+	FeeCents   int64
+	NetCents   int64
+	Status     LedgerStatus
+}
+
+// TemplateEarnings aggregates earnings for one template.
+type TemplateEarnings struct {
+	TemplateID   int    `json:"-"`
+	TemplateName string `json:"template_name,omitempty"`
+	TemplateRef  string `json:"template_id,omitempty"`
+	Entries      int    `json:"entries"`
+	GrossCents   int64  `json:"gross_cents"`
+	NetCents     int64  `json:"net_cents"`
+}
+
+// MonthEarnings aggregates earnings for one calendar month.
+type MonthEarnings struct {
+	Month      string `json:"month"`
+	GrossCents int64  `json:"gross_cents"`
+	NetCents   int64  `json:"net_cents"`
+}
+
+// Report is a creator-facing earnings summary.
+type Report struct {
+	GrossCents    int64              `json:"gross_cents"`
+	FeeCents      int64              `json:"fee_cents"`
+	NetCents      int64              `json:"net_cents"`
+	PendingCents  int64              `json:"pending_cents"`
+	InPayoutCents int64              `json:"in_payout_cents"`
+	PaidOutCents  int64              `json:"paid_out_cents"`
+	ByTemplate    []TemplateEarnings `json:"by_template"`
+	ByMonth       []MonthEarnings    `json:"by_month"`
+}
+
+// Summarize aggregates ledger entries into a report.
+// Templates are sorted by net earnings (highest first); months chronologically.
+func Summarize(entries []LedgerEntry) Report {
+	var r Report
+	byTemplate := map[int]*TemplateEarnings{}
+	byMonth := map[string]*MonthEarnings{}
+
+	for _, e := range entries {
+		r.GrossCents += e.GrossCents
+		r.FeeCents += e.FeeCents
+		r.NetCents += e.NetCents
+		switch e.Status {
+		case LedgerPaidOut:
+			r.PaidOutCents += e.NetCents
+		case LedgerInPayout:
+			r.InPayoutCents += e.NetCents
+		default:
+			r.PendingCents += e.NetCents
+		}
+
+		t := byTemplate[e.TemplateID]
+		if t == nil {
+			t = &TemplateEarnings{TemplateID: e.TemplateID}
+			byTemplate[e.TemplateID] = t
+		}
+		t.Entries++
+		t.GrossCents += e.GrossCents
+		t.NetCents += e.NetCents
+
+		if e.Month != "" {
+			m := byMonth[e.Month]
+			if m == nil {
+				m = &MonthEarnings{Month: e.Month}
+				byMonth[e.Month] = m
+			}
+			m.GrossCents += e.GrossCents
+			m.NetCents += e.NetCents
+		}
+	}
+
+	r.ByTemplate = make([]TemplateEarnings, 0, len(byTemplate))
+	for _, t := range byTemplate {
+		r.ByTemplate = append(r.ByTemplate, *t)
+	}
+	sort.Slice(r.ByTemplate, func(i, j int) bool {
+		if r.ByTemplate[i].NetCents != r.ByTemplate[j].NetCents {
+			return r.ByTemplate[i].NetCents > r.ByTemplate[j].NetCents
+		}
+		return r.ByTemplate[i].TemplateID < r.ByTemplate[j].TemplateID
+	})
+
+	r.ByMonth = make([]MonthEarnings, 0, len(byMonth))
+	for _, m := range byMonth {
+		r.ByMonth = append(r.ByMonth, *m)
+	}
+	sort.Slice(r.ByMonth, func(i, j int) bool { return r.ByMonth[i].Month < r.ByMonth[j].Month })
+
+	return r
+}
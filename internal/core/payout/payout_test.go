@@ -0,0 +1,132 @@
+package payout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFeeBps(t *testing.T) {
+	assert.NoError(t, ValidateFeeBps(0))
+	assert.NoError(t, ValidateFeeBps(2000))
+	assert.NoError(t, ValidateFeeBps(10000))
+	assert.ErrorIs(t, ValidateFeeBps(-1), ErrInvalidFee)
+	assert.ErrorIs(t, ValidateFeeBps(10001), ErrInvalidFee)
+}
+
+func TestPercentToBps(t *testing.T) {
+	assert.Equal(t, 2000, PercentToBps(20))
+	assert.Equal(t, 1250, PercentToBps(12.5))
+	assert.Equal(t, 0, PercentToBps(0))
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		gross   int64
+		feeBps  int
+		wantFee int64
+		wantNet int64
+	}{
+		{"twenty percent", 1000, 2000, 200, 800},
+		{"rounds fee half up", 999, 2000, 200, 799},
+		{"zero fee", 1000, 0, 0, 1000},
+		{"full fee", 1000, 10000, 1000, 0},
+		{"zero gross", 0, 2000, 0, 0},
+		{"negative gross", -500, 2000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee, net := Split(tt.gross, tt.feeBps)
+			assert.Equal(t, tt.wantFee, fee)
+			assert.Equal(t, tt.wantNet, net)
+			if tt.gross > 0 {
+				assert.Equal(t, tt.gross, fee+net)
+			}
+		})
+	}
+}
+
+func TestComputeEarnings(t *testing.T) {
+	lines := []InvoiceLine{
+		{DeploymentID: "d1", MonthlyCents: 1000},
+		{DeploymentID: "d2", MonthlyCents: 500},
+		{DeploymentID: "d3", MonthlyCents: 0},   // free template
+		{DeploymentID: "d4", MonthlyCents: 700}, // unknown deployment
+		{DeploymentID: "d5", MonthlyCents: 300}, // payer is the creator
+	}
+	attrs := map[string]Attribution{
+		"d1": {CreatorID: 10, TemplateID: 1},
+		"d2": {CreatorID: 11, TemplateID: 2},
+		"d3": {CreatorID: 10, TemplateID: 3},
+		"d5": {CreatorID: 99, TemplateID: 4},
+	}
+
+	earnings := ComputeEarnings(lines, attrs, 99, 2000)
+	require.Len(t, earnings, 2)
+
+	assert.Equal(t, Earning{CreatorID: 10, TemplateID: 1, DeploymentID: "d1", GrossCents: 1000, FeeCents: 200, NetCents: 800}, earnings[0])
+	assert.Equal(t, Earning{CreatorID: 11, TemplateID: 2, DeploymentID: "d2", GrossCents: 500, FeeCents: 100, NetCents: 400}, earnings[1])
+}
+
+func TestValidSchedule(t *testing.T) {
+	assert.True(t, ValidSchedule(ScheduleWeekly))
+	assert.True(t, ValidSchedule(ScheduleMonthly))
+	assert.False(t, ValidSchedule("daily"))
+}
+
+func TestIsDue(t *testing.T) {
+	last := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	assert.True(t, IsDue(time.Time{}, ScheduleMonthly, last), "never paid is due")
+
+	assert.False(t, IsDue(last, ScheduleWeekly, last.AddDate(0, 0, 6)))
+	assert.True(t, IsDue(last, ScheduleWeekly, last.AddDate(0, 0, 7)))
+
+	assert.False(t, IsDue(last, ScheduleMonthly, last.AddDate(0, 0, 27)))
+	assert.True(t, IsDue(last, ScheduleMonthly, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)))
+
+	assert.Equal(t, last.AddDate(0, 1, 0), NextPayoutAt(last, "unknown"))
+}
+
+func TestShouldPayout(t *testing.T) {
+	assert.True(t, ShouldPayout(2500, 2500))
+	assert.False(t, ShouldPayout(2499, 2500))
+	assert.True(t, ShouldPayout(1, 0))
+	assert.False(t, ShouldPayout(0, 0))
+}
+
+func TestSummarize(t *testing.T) {
+	entries := []LedgerEntry{
+		{TemplateID: 1, Month: "2026-02", GrossCents: 1000, FeeCents: 200, NetCents: 800, Status: LedgerPaidOut},
+		{TemplateID: 2, Month: "2026-01", GrossCents: 500, FeeCents: 100, NetCents: 400, Status: LedgerPending},
+		{TemplateID: 1, Month: "2026-01", GrossCents: 1000, FeeCents: 200, NetCents: 800, Status: LedgerInPayout},
+	}
+
+	r := Summarize(entries)
+
+	assert.Equal(t, int64(2500), r.GrossCents)
+	assert.Equal(t, int64(500), r.FeeCents)
+	assert.Equal(t, int64(2000), r.NetCents)
+	assert.Equal(t, int64(400), r.PendingCents)
+	assert.Equal(t, int64(800), r.InPayoutCents)
+	assert.Equal(t, int64(800), r.PaidOutCents)
+
+	require.Len(t, r.ByTemplate, 2)
+	assert.Equal(t, 1, r.ByTemplate[0].TemplateID)
+	assert.Equal(t, 2, r.ByTemplate[0].Entries)
+	assert.Equal(t, int64(1600), r.ByTemplate[0].NetCents)
+
+	require.Len(t, r.ByMonth, 2)
+	assert.Equal(t, "2026-01", r.ByMonth[0].Month)
+	assert.Equal(t, int64(1200), r.ByMonth[0].NetCents)
+}
+
+func TestSummarize_Empty(t *testing.T) {
+	r := Summarize(nil)
+	assert.Equal(t, int64(0), r.NetCents)
+	assert.NotNil(t, r.ByTemplate)
+	assert.NotNil(t, r.ByMonth)
+}
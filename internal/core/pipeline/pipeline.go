@@ -0,0 +1,160 @@
+// Package pipeline provides pure functions for deployment promotion
+// pipelines: deployments of one template grouped into ordered environments
+// (dev, staging, prod), where a promotion carries one environment's template
+// version, variables and configuration to the next. It covers stage
+// validation, planning a promotion and the promotion lifecycle.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// =============================================================================
+// Stages
+// =============================================================================
+
+// Stage is one environment of a pipeline.
+type Stage struct {
+	// Name names the environment, e.g. "staging".
+	Name string `json:"name"`
+	// Deployment is the reference ID of the environment's deployment.
+	Deployment string `json:"deployment"`
+	// Overrides are variable values that stay specific to this environment;
+	// a promotion into the stage sets them instead of the source's.
+	Overrides map[string]string `json:"overrides,omitempty"`
+	// RequiresApproval holds promotions into the stage until approved.
+	RequiresApproval bool `json:"requires_approval,omitempty"`
+}
+
+// Stage count bounds.
+const (
+	MinStages = 2
+	MaxStages = 10
+)
+
+var stageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// Validate checks a pipeline's stages, in promotion order: each has a
+// distinct name and a distinct deployment.
+func Validate(stages []Stage) error {
+	if len(stages) < MinStages || len(stages) > MaxStages {
+		return fmt.Errorf("stages: a pipeline has %d to %d stages, got %d", MinStages, MaxStages, len(stages))
+	}
+	names := make(map[string]bool, len(stages))
+	deployments := make(map[string]bool, len(stages))
+	for i, s := range stages {
+		if !stageNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("stages[%d]: name must be lowercase letters, digits and dashes, at most 32 characters", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("stages[%d]: duplicate stage %q", i, s.Name)
+		}
+		names[s.Name] = true
+		if s.Deployment == "" {
+			return fmt.Errorf("stages[%d]: deployment is required", i)
+		}
+		if deployments[s.Deployment] {
+			return fmt.Errorf("stages[%d]: deployment %s is already a stage of this pipeline", i, s.Deployment)
+		}
+		deployments[s.Deployment] = true
+		for name := range s.Overrides {
+			if name == "" {
+				return fmt.Errorf("stages[%d]: override with an empty variable name", i)
+			}
+		}
+	}
+	return nil
+}
+
+// Stage lookup errors.
+var (
+	ErrUnknownStage = errors.New("pipeline has no such stage")
+	ErrLastStage    = errors.New("the last stage cannot be promoted further")
+)
+
+// Next returns the stage named from and the stage it promotes into.
+func Next(stages []Stage, from string) (source, target Stage, err error) {
+	for i, s := range stages {
+		if s.Name != from {
+			continue
+		}
+		if i == len(stages)-1 {
+			return Stage{}, Stage{}, fmt.Errorf("%w: %s", ErrLastStage, from)
+		}
+		return s, stages[i+1], nil
+	}
+	return Stage{}, Stage{}, fmt.Errorf("%w: %s", ErrUnknownStage, from)
+}
+
+// =============================================================================
+// Planning
+// =============================================================================
+
+// ErrVersionUnavailable means the source runs a template version other than
+// the template's current one. Only the current version can be deployed, so
+// the source has to be upgraded before it is promoted.
+var ErrVersionUnavailable = errors.New("only the template's current version can be promoted")
+
+// CheckVersion checks that the source's version, which a promotion carries
+// forward, is the template's current version.
+func CheckVersion(source, current string) error {
+	if source != current {
+		return fmt.Errorf("%w: the source runs %q, the template is at %q", ErrVersionUnavailable, source, current)
+	}
+	return nil
+}
+
+// Variables returns the target's variables after a promotion: the target's
+// own, replaced by the source's, replaced by the target stage's overrides.
+// changed names the variables whose value differs from the target's, sorted.
+func Variables(source, target, overrides map[string]string) (values map[string]string, changed []string) {
+	values = make(map[string]string, len(target)+len(source))
+	for k, v := range target {
+		values[k] = v
+	}
+	for k, v := range source {
+		values[k] = v
+	}
+	for k, v := range overrides {
+		values[k] = v
+	}
+	for k, v := range values {
+		if old, ok := target[k]; !ok || old != v {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return values, changed
+}
+
+// =============================================================================
+// Promotions
+// =============================================================================
+
+// Status is where a promotion is in its lifecycle.
+type Status string
+
+const (
+	// StatusPendingApproval waits for the target's owner to approve it.
+	StatusPendingApproval Status = "pending_approval"
+	// StatusRunning is upgrading the target.
+	StatusRunning Status = "running"
+	// StatusSucceeded upgraded the target.
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed could not upgrade the target; the error says why.
+	StatusFailed Status = "failed"
+	// StatusRejected was turned down by the target's owner.
+	StatusRejected Status = "rejected"
+)
+
+// InitialStatus returns the status a new promotion into target starts in.
+func InitialStatus(target Stage) Status {
+	if target.RequiresApproval {
+		return StatusPendingApproval
+	}
+	return StatusRunning
+}
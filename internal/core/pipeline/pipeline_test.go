@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stages() []Stage {
+	return []Stage{
+		{Name: "dev", Deployment: "d-1"},
+		{Name: "staging", Deployment: "d-2", Overrides: map[string]string{"SITE_URL": "https://staging.example.com"}},
+		{Name: "prod", Deployment: "d-3", RequiresApproval: true},
+	}
+}
+
+// =============================================================================
+// Stage Tests
+// =============================================================================
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(stages()))
+
+	tests := []struct {
+		name   string
+		mutate func([]Stage) []Stage
+		want   string
+	}{
+		{"one stage", func(s []Stage) []Stage { return s[:1] }, "2 to 10 stages"},
+		{"bad name", func(s []Stage) []Stage { s[1].Name = "Staging"; return s }, "stages[1]: name"},
+		{"duplicate name", func(s []Stage) []Stage { s[2].Name = "dev"; return s }, `duplicate stage "dev"`},
+		{"no deployment", func(s []Stage) []Stage { s[0].Deployment = ""; return s }, "deployment is required"},
+		{"shared deployment", func(s []Stage) []Stage { s[2].Deployment = "d-1"; return s }, "already a stage"},
+		{"empty override", func(s []Stage) []Stage { s[0].Overrides = map[string]string{"": "x"}; return s }, "empty variable name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, Validate(tt.mutate(stages())), tt.want)
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	source, target, err := Next(stages(), "staging")
+	require.NoError(t, err)
+	assert.Equal(t, "staging", source.Name)
+	assert.Equal(t, "prod", target.Name)
+
+	_, _, err = Next(stages(), "prod")
+	assert.ErrorIs(t, err, ErrLastStage)
+	_, _, err = Next(stages(), "qa")
+	assert.ErrorIs(t, err, ErrUnknownStage)
+}
+
+// =============================================================================
+// Planning Tests
+// =============================================================================
+
+func TestCheckVersion(t *testing.T) {
+	assert.NoError(t, CheckVersion("1.2.0", "1.2.0"))
+	assert.ErrorIs(t, CheckVersion("1.1.0", "1.2.0"), ErrVersionUnavailable)
+}
+
+func TestVariables(t *testing.T) {
+	source := map[string]string{"SITE_NAME": "Shop", "SITE_URL": "https://dev.example.com", "CACHE": "on"}
+	target := map[string]string{"SITE_NAME": "Shop", "SITE_URL": "https://old.example.com", "SMTP_HOST": "mail"}
+	overrides := map[string]string{"SITE_URL": "https://example.com"}
+
+	values, changed := Variables(source, target, overrides)
+	assert.Equal(t, map[string]string{
+		"SITE_NAME": "Shop",
+		"SITE_URL":  "https://example.com",
+		"CACHE":     "on",
+		"SMTP_HOST": "mail",
+	}, values, "overrides win and the target's own variables are kept")
+	assert.Equal(t, []string{"CACHE", "SITE_URL"}, changed)
+}
+
+func TestVariables_NoChanges(t *testing.T) {
+	same := map[string]string{"A": "1"}
+	_, changed := Variables(same, same, nil)
+	assert.Empty(t, changed)
+}
+
+// =============================================================================
+// Promotion Tests
+// =============================================================================
+
+func TestInitialStatus(t *testing.T) {
+	s := stages()
+	assert.Equal(t, StatusRunning, InitialStatus(s[1]))
+	assert.Equal(t, StatusPendingApproval, InitialStatus(s[2]))
+}
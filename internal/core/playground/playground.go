@@ -0,0 +1,207 @@
+// Package playground provides pure functions for template playgrounds:
+// short-lived deployments of published templates that marketplace visitors
+// start without an account. It covers session timings, throwaway
+// credentials, resource caps, admission limits and conversion tracking.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package playground
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Timings
+// =============================================================================
+
+const (
+	// MinTTL and MaxTTL bound how long a playground runs.
+	MinTTL = 30 * time.Minute
+	MaxTTL = 60 * time.Minute
+	// DefaultTTL is how long a playground runs when not configured.
+	DefaultTTL = 30 * time.Minute
+	// ReapDelay is how long an expired playground stays stopped before it
+	// is deleted.
+	ReapDelay = time.Minute
+	// ConversionWindow is how long after a session its visitor's signup is
+	// still credited to it.
+	ConversionWindow = 7 * 24 * time.Hour
+)
+
+// ValidateTTL checks a playground's lifetime.
+func ValidateTTL(ttl time.Duration) error {
+	if ttl < MinTTL || ttl > MaxTTL {
+		return fmt.Errorf("must be between %s and %s, got %s", MinTTL, MaxTTL, ttl)
+	}
+	return nil
+}
+
+// Expiry returns the expiry of a playground deployment created at created:
+// it stops after ttl and is deleted ReapDelay later.
+func Expiry(created time.Time, ttl time.Duration) deployment.Expiry {
+	expires := created.Add(ttl)
+	return deployment.Expiry{
+		ExpiresAt: expires,
+		DeleteAt:  expires.Add(ReapDelay),
+		Source:    deployment.TrialFromPlayground,
+	}
+}
+
+// =============================================================================
+// Sessions
+// =============================================================================
+
+// SystemUser is the reference ID of the user that owns playground
+// deployments.
+const SystemUser = "hoster-playground"
+
+// RefPrefix prefixes the reference IDs of playground sessions.
+const RefPrefix = "play_"
+
+// DeploymentName returns the name of a session's deployment, which also
+// names its temporary subdomain.
+func DeploymentName(sessionRef string) string {
+	return "play-" + sessionRef[len(RefPrefix):]
+}
+
+// HashToken returns the stored form of a session token. The token itself is
+// only ever given to the visitor.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// =============================================================================
+// Credentials
+// =============================================================================
+
+// ErrNeedsInput means a template has a required variable with no default,
+// which a visitor would have to fill in.
+var ErrNeedsInput = errors.New("template needs input a playground cannot provide")
+
+// Variables returns a playground deployment's variable values: password
+// variables get a throwaway value from generate, the rest their defaults.
+// credentials names the generated variables, in template order.
+func Variables(vars []domain.Variable, generate func() string) (values map[string]string, credentials []string, err error) {
+	values = make(map[string]string, len(vars))
+	for _, v := range vars {
+		switch {
+		case v.Type == domain.VarTypePassword:
+			values[v.Name] = generate()
+			credentials = append(credentials, v.Name)
+		case v.Default != "":
+			values[v.Name] = v.Default
+		case v.Required:
+			return nil, nil, fmt.Errorf("%w: %s", ErrNeedsInput, v.Name)
+		}
+	}
+	return values, credentials, nil
+}
+
+// =============================================================================
+// Resource Caps
+// =============================================================================
+
+// Default caps on each service of a playground.
+const (
+	DefaultMemoryMB = 256
+	DefaultCPUCores = 0.5
+)
+
+// Caps bounds the memory and CPU of each service of a playground.
+type Caps struct {
+	MemoryMB int64
+	CPUCores float64
+}
+
+// CapServices returns the service overrides that hold every service to the
+// caps. limits are the services' own compose limits, zero for none; a
+// service limited below a cap keeps its limit.
+func CapServices(limits map[string]domain.ServiceOverride, caps Caps) map[string]domain.ServiceOverride {
+	out := make(map[string]domain.ServiceOverride, len(limits))
+	for name, l := range limits {
+		o := domain.ServiceOverride{MemoryMB: caps.MemoryMB, CPUCores: caps.CPUCores}
+		if l.MemoryMB > 0 && l.MemoryMB < o.MemoryMB {
+			o.MemoryMB = l.MemoryMB
+		}
+		if l.CPUCores > 0 && l.CPUCores < o.CPUCores {
+			o.CPUCores = l.CPUCores
+		}
+		out[name] = o
+	}
+	return out
+}
+
+// =============================================================================
+// Admission
+// =============================================================================
+
+// Default admission limits.
+const (
+	DefaultMaxSessions  = 20
+	DefaultMaxPerClient = 1
+)
+
+// Admission errors.
+var (
+	ErrFull        = errors.New("all playgrounds are in use; try again in a few minutes")
+	ErrClientLimit = errors.New("you already have a playground running; try again when it ends")
+)
+
+// Admit decides whether a new playground may start, given the active
+// sessions overall and from the visitor's address.
+func Admit(active, fromClient, maxSessions, maxPerClient int) error {
+	switch {
+	case active >= maxSessions:
+		return ErrFull
+	case fromClient >= maxPerClient:
+		return ErrClientLimit
+	}
+	return nil
+}
+
+// =============================================================================
+// Conversion
+// =============================================================================
+
+// Conversion errors.
+var (
+	ErrAlreadyConverted = errors.New("playground session was already converted")
+	ErrConversionClosed = errors.New("playground session is too old to convert")
+)
+
+// CanConvert decides whether a signup at now is credited to a session
+// created at created. A session is credited once.
+func CanConvert(created time.Time, converted bool, now time.Time) error {
+	if converted {
+		return ErrAlreadyConverted
+	}
+	if now.Sub(created) > ConversionWindow {
+		return ErrConversionClosed
+	}
+	return nil
+}
+
+// Stats is how often a template's playgrounds led to signups.
+type Stats struct {
+	TemplateID  string  `json:"template_id"`
+	Sessions    int     `json:"sessions"`
+	Conversions int     `json:"conversions"`
+	Rate        float64 `json:"conversion_rate"`
+}
+
+// WithRate returns the stats with the conversion rate filled in, between 0
+// and 1.
+func (s Stats) WithRate() Stats {
+	s.Rate = 0
+	if s.Sessions > 0 {
+		s.Rate = float64(s.Conversions) / float64(s.Sessions)
+	}
+	return s
+}
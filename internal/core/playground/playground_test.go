@@ -0,0 +1,130 @@
+package playground
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// =============================================================================
+// Timing Tests
+// =============================================================================
+
+func TestValidateTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{MinTTL, 45 * time.Minute, MaxTTL} {
+		assert.NoError(t, ValidateTTL(ttl), ttl)
+	}
+	for _, ttl := range []time.Duration{0, 10 * time.Minute, 2 * time.Hour} {
+		assert.Error(t, ValidateTTL(ttl), ttl)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	e := Expiry(t0, 30*time.Minute)
+	assert.Equal(t, t0.Add(30*time.Minute), e.ExpiresAt)
+	assert.Equal(t, t0.Add(31*time.Minute), e.DeleteAt)
+	assert.Equal(t, deployment.TrialFromPlayground, e.Source)
+
+	step, ok := deployment.NextExpiryStep(e, t0)
+	require.True(t, ok)
+	assert.Equal(t, deployment.ExpiryStop, step.Stage, "the trial warnings are skipped")
+}
+
+// =============================================================================
+// Session Tests
+// =============================================================================
+
+func TestDeploymentName(t *testing.T) {
+	assert.Equal(t, "play-1a2b3c4d", DeploymentName("play_1a2b3c4d"))
+}
+
+func TestHashToken(t *testing.T) {
+	h := HashToken("secret")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, HashToken("secret"))
+	assert.NotEqual(t, h, HashToken("other"))
+}
+
+// =============================================================================
+// Credential Tests
+// =============================================================================
+
+func TestVariables(t *testing.T) {
+	vars := []domain.Variable{
+		{Name: "ADMIN_PASSWORD", Type: domain.VarTypePassword, Required: true},
+		{Name: "SITE_NAME", Type: domain.VarTypeString, Default: "Demo"},
+		{Name: "SMTP_HOST", Type: domain.VarTypeString},
+		{Name: "DB_PASSWORD", Type: domain.VarTypePassword, Default: "changeme"},
+	}
+	n := 0
+	values, credentials, err := Variables(vars, func() string {
+		n++
+		return "generated" + string(rune('0'+n))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"ADMIN_PASSWORD": "generated1",
+		"SITE_NAME":      "Demo",
+		"DB_PASSWORD":    "generated2",
+	}, values, "passwords are generated even when they have a default")
+	assert.Equal(t, []string{"ADMIN_PASSWORD", "DB_PASSWORD"}, credentials)
+}
+
+func TestVariables_NeedsInput(t *testing.T) {
+	vars := []domain.Variable{{Name: "LICENSE_KEY", Type: domain.VarTypeString, Required: true}}
+	_, _, err := Variables(vars, func() string { return "x" })
+	assert.True(t, errors.Is(err, ErrNeedsInput))
+	assert.ErrorContains(t, err, "LICENSE_KEY")
+}
+
+// =============================================================================
+// Resource Cap Tests
+// =============================================================================
+
+func TestCapServices(t *testing.T) {
+	limits := map[string]domain.ServiceOverride{
+		"web":    {},
+		"db":     {MemoryMB: 1024, CPUCores: 2},
+		"worker": {MemoryMB: 128, CPUCores: 0.25},
+	}
+	got := CapServices(limits, Caps{MemoryMB: 256, CPUCores: 0.5})
+	assert.Equal(t, map[string]domain.ServiceOverride{
+		"web":    {MemoryMB: 256, CPUCores: 0.5},
+		"db":     {MemoryMB: 256, CPUCores: 0.5},
+		"worker": {MemoryMB: 128, CPUCores: 0.25},
+	}, got)
+}
+
+// =============================================================================
+// Admission Tests
+// =============================================================================
+
+func TestAdmit(t *testing.T) {
+	assert.NoError(t, Admit(0, 0, 20, 1))
+	assert.ErrorIs(t, Admit(20, 0, 20, 1), ErrFull)
+	assert.ErrorIs(t, Admit(5, 1, 20, 1), ErrClientLimit)
+	assert.ErrorIs(t, Admit(20, 1, 20, 1), ErrFull, "a full playground is reported first")
+}
+
+// =============================================================================
+// Conversion Tests
+// =============================================================================
+
+func TestCanConvert(t *testing.T) {
+	assert.NoError(t, CanConvert(t0, false, t0.Add(time.Hour)))
+	assert.NoError(t, CanConvert(t0, false, t0.Add(ConversionWindow)))
+	assert.ErrorIs(t, CanConvert(t0, true, t0.Add(time.Hour)), ErrAlreadyConverted)
+	assert.ErrorIs(t, CanConvert(t0, false, t0.Add(ConversionWindow+time.Second)), ErrConversionClosed)
+}
+
+func TestStats_WithRate(t *testing.T) {
+	assert.Zero(t, Stats{}.WithRate().Rate)
+	assert.InDelta(t, 0.25, Stats{Sessions: 8, Conversions: 2}.WithRate().Rate, 1e-9)
+}
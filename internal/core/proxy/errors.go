@@ -12,6 +12,7 @@ const (
 	ErrorUpstreamTimeout
 	ErrorUpstreamError
 	ErrorVerificationPending
+	ErrorBodyTooLarge
 )
 
 // ProxyError represents an error during proxying.
@@ -66,3 +67,14 @@ func NewVerificationPendingError(hostname string) ProxyError {
 		StatusCode: 403,
 	}
 }
+
+// NewBodyTooLargeError creates an error for a request body over the
+// deployment's limit.
+func NewBodyTooLargeError(hostname string, limit int64) ProxyError {
+	return ProxyError{
+		Type:       ErrorBodyTooLarge,
+		Hostname:   hostname,
+		Message:    fmt.Sprintf("request body exceeds the %d byte limit of %s", limit, hostname),
+		StatusCode: 413,
+	}
+}
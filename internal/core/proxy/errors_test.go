@@ -27,6 +27,11 @@ func TestProxyError_Error(t *testing.T) {
 			err:     NewUnavailableError("my-app.apps.hoster.io"),
 			wantMsg: "app unavailable: my-app.apps.hoster.io",
 		},
+		{
+			name:    "body too large error",
+			err:     NewBodyTooLargeError("my-app.apps.hoster.io", 1024),
+			wantMsg: "request body exceeds the 1024 byte limit of my-app.apps.hoster.io",
+		},
 	}
 
 	for _, tt := range tests {
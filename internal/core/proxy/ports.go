@@ -13,6 +13,13 @@ func DefaultPortRange() PortRange {
 	return PortRange{Start: 30000, End: 39999}
 }
 
+// DefaultStreamPortRange returns the default range of the host ports TCP and
+// UDP streams listen on. It is separate from the proxy port range, so the
+// entry points Traefik needs for it can be declared up front.
+func DefaultStreamPortRange() PortRange {
+	return PortRange{Start: 40000, End: 40999}
+}
+
 // AllocatePort finds the first available port in the range.
 // Pure function - takes used ports as input, returns allocated port.
 func AllocatePort(usedPorts []int, portRange PortRange) (int, error) {
@@ -32,6 +39,22 @@ func AllocatePort(usedPorts []int, portRange PortRange) (int, error) {
 	return 0, errors.New("no available ports in range")
 }
 
+// AllocatePorts finds the first n available ports in the range.
+// Pure function - takes used ports as input, returns allocated ports.
+func AllocatePorts(usedPorts []int, n int, portRange PortRange) ([]int, error) {
+	ports := make([]int, 0, n)
+	used := append([]int(nil), usedPorts...)
+	for range n {
+		port, err := AllocatePort(used, portRange)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+		used = append(used, port)
+	}
+	return ports, nil
+}
+
 // ValidatePort checks if a port is within the allowed range.
 func ValidatePort(port int, portRange PortRange) bool {
 	return port >= portRange.Start && port <= portRange.End
@@ -99,6 +99,28 @@ func TestAllocatePort(t *testing.T) {
 	}
 }
 
+func TestDefaultStreamPortRange(t *testing.T) {
+	pr := DefaultStreamPortRange()
+	assert.Equal(t, 40000, pr.Start)
+	assert.Equal(t, 40999, pr.End)
+	assert.Greater(t, pr.Start, DefaultPortRange().End, "does not overlap proxy ports")
+}
+
+func TestAllocatePorts(t *testing.T) {
+	portRange := PortRange{Start: 40000, End: 40004}
+
+	ports, err := AllocatePorts([]int{40001, 40003}, 2, portRange)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{40000, 40002}, ports)
+
+	ports, err = AllocatePorts(nil, 0, portRange)
+	assert.NoError(t, err)
+	assert.Empty(t, ports)
+
+	_, err = AllocatePorts([]int{40001, 40003}, 4, portRange)
+	assert.Error(t, err)
+}
+
 func TestValidatePort(t *testing.T) {
 	portRange := PortRange{Start: 30000, End: 39999}
 
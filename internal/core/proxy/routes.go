@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"sort"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// Route is the resolution of one hostname, as served to proxies by the
+// internal routes endpoint. This is a pure data type with no I/O.
+type Route struct {
+	Hostname     string `json:"hostname"`
+	DeploymentID string `json:"deployment_id"`
+	NodeID       string `json:"node_id,omitempty"`
+	NodeIP       string `json:"node_ip,omitempty"`
+	Port         int    `json:"port"`
+	Status       string `json:"status"`
+
+	// Verified is false for custom domains whose DNS is not yet verified;
+	// proxies must not serve them
+	Verified bool `json:"verified"`
+
+	// Routable reports whether the hostname can take traffic: verified, and
+	// the deployment running with a port on a reachable node
+	Routable bool `json:"routable"`
+
+	// Address is where to send the hostname's traffic, set when Routable
+	Address string `json:"address,omitempty"`
+
+	CanaryPort    int `json:"canary_port,omitempty"`
+	CanaryPercent int `json:"canary_percent,omitempty"`
+
+	// Routing options: the sticky session cookie, how long WebSocket
+	// connections may stay open and the request body limit
+	StickyCookie            string `json:"sticky_cookie,omitempty"`
+	WebSocketTimeoutSeconds int    `json:"websocket_timeout_seconds,omitempty"`
+	MaxBodyBytes            int64  `json:"max_body_bytes,omitempty"`
+}
+
+// RouteFor returns the route of one of a deployment's hostnames. nodeIP is
+// the address of the deployment's node, empty for the local node.
+func RouteFor(d *domain.Deployment, hostname, nodeIP string) Route {
+	target := ProxyTarget{
+		DeploymentID:  d.ReferenceID,
+		NodeID:        d.NodeID,
+		NodeIP:        nodeIP,
+		Port:          d.ProxyPort,
+		Status:        string(d.Status),
+		CanaryPort:    d.CanaryPort,
+		CanaryPercent: d.CanaryPercent,
+	}.WithRouting(d.RoutingOptions)
+	r := Route{
+		Hostname:      domain.NormalizeHostname(hostname),
+		DeploymentID:  target.DeploymentID,
+		NodeID:        target.NodeID,
+		NodeIP:        target.NodeIP,
+		Port:          target.Port,
+		Status:        target.Status,
+		Verified:      true,
+		CanaryPort:    target.CanaryPort,
+		CanaryPercent: target.CanaryPercent,
+
+		StickyCookie:            target.StickyCookie,
+		WebSocketTimeoutSeconds: int(target.WebSocketTimeout.Seconds()),
+		MaxBodyBytes:            target.MaxBodyBytes,
+	}
+	for _, dom := range d.Domains {
+		if domain.NormalizeHostname(dom.Hostname) == r.Hostname && dom.Type == domain.DomainTypeCustom {
+			r.Verified = dom.VerificationStatus == domain.DomainVerificationVerified
+			break
+		}
+	}
+	// A remote node without a known address can't be reached
+	r.Routable = r.Verified && target.CanRoute() && (target.IsLocal() || nodeIP != "")
+	if r.Routable {
+		if target.IsLocal() {
+			r.Address = target.LocalAddress()
+		} else {
+			r.Address = target.RemoteAddress()
+		}
+	}
+	return r
+}
+
+// RoutesFor returns the routes of all of a deployment's hostnames, sorted
+// by hostname.
+func RoutesFor(d *domain.Deployment, nodeIP string) []Route {
+	routes := make([]Route, 0, len(d.Domains))
+	seen := make(map[string]bool, len(d.Domains))
+	for _, dom := range d.Domains {
+		host := domain.NormalizeHostname(dom.Hostname)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		routes = append(routes, RouteFor(d, host, nodeIP))
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Hostname < routes[j].Hostname })
+	return routes
+}
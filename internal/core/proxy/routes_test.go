@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func routedDeployment() *domain.Deployment {
+	return &domain.Deployment{
+		ReferenceID: "depl_1",
+		NodeID:      "node_1",
+		Status:      domain.StatusRunning,
+		ProxyPort:   30001,
+		Domains: []domain.Domain{
+			{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto},
+			{Hostname: "Blog.Example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationVerified},
+			{Hostname: "new.example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationPending},
+		},
+	}
+}
+
+func TestRouteFor_Remote(t *testing.T) {
+	r := RouteFor(routedDeployment(), "blog.apps.hoster.io", "10.0.0.5")
+
+	assert.Equal(t, Route{
+		Hostname:     "blog.apps.hoster.io",
+		DeploymentID: "depl_1",
+		NodeID:       "node_1",
+		NodeIP:       "10.0.0.5",
+		Port:         30001,
+		Status:       "running",
+		Verified:     true,
+		Routable:     true,
+		Address:      "10.0.0.5:30001",
+	}, r)
+}
+
+func TestRouteFor_Local(t *testing.T) {
+	d := routedDeployment()
+	d.NodeID = ""
+	assert.Equal(t, "127.0.0.1:30001", RouteFor(d, "blog.apps.hoster.io", "").Address)
+}
+
+func TestRouteFor_UnverifiedCustomDomain(t *testing.T) {
+	r := RouteFor(routedDeployment(), "new.example.com", "10.0.0.5")
+	assert.False(t, r.Verified)
+	assert.False(t, r.Routable)
+	assert.Empty(t, r.Address)
+
+	assert.True(t, RouteFor(routedDeployment(), "BLOG.example.com.", "10.0.0.5").Verified)
+}
+
+func TestRouteFor_Stopped(t *testing.T) {
+	d := routedDeployment()
+	d.Status = domain.StatusStopped
+	r := RouteFor(d, "blog.apps.hoster.io", "10.0.0.5")
+	assert.True(t, r.Verified)
+	assert.False(t, r.Routable)
+	assert.Equal(t, "stopped", r.Status)
+}
+
+func TestRouteFor_UnknownNodeAddress(t *testing.T) {
+	r := RouteFor(routedDeployment(), "blog.apps.hoster.io", "")
+	assert.False(t, r.Routable)
+	assert.Empty(t, r.Address)
+}
+
+func TestRoutesFor(t *testing.T) {
+	d := routedDeployment()
+	d.Domains = append(d.Domains, domain.Domain{Hostname: "blog.example.com", Type: domain.DomainTypeCustom})
+
+	routes := RoutesFor(d, "10.0.0.5")
+	var hosts []string
+	for _, r := range routes {
+		hosts = append(hosts, r.Hostname)
+	}
+	assert.Equal(t, []string{"blog.apps.hoster.io", "blog.example.com", "new.example.com"}, hosts)
+	assert.True(t, routes[1].Routable, "the first entry for a hostname wins")
+}
+
+func TestRouteFor_RoutingOptions(t *testing.T) {
+	sticky := true
+	d := routedDeployment()
+	d.RoutingOptions = domain.RoutingOptions{StickySessions: &sticky, StickyCookie: "sid", WebSocketTimeoutSeconds: 3600, MaxBodyMB: 1}
+
+	r := RouteFor(d, "blog.apps.hoster.io", "10.0.0.5")
+	assert.Equal(t, "sid", r.StickyCookie)
+	assert.Equal(t, 3600, r.WebSocketTimeoutSeconds)
+	assert.Equal(t, int64(1<<20), r.MaxBodyBytes)
+}
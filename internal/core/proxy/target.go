@@ -2,7 +2,12 @@
 // This package has no I/O dependencies and is tested with values in/out.
 package proxy
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
 
 // ProxyTarget represents the destination for a proxied request.
 // This is a pure data type with no I/O.
@@ -24,6 +29,53 @@ type ProxyTarget struct {
 
 	// CustomerID is the owner of the deployment
 	CustomerID string
+
+	// CanaryPort is the host port of a canary upgrade's container (0 when
+	// no canary is running)
+	CanaryPort int
+
+	// CanaryPercent is the share of requests sent to CanaryPort, 0-100
+	CanaryPercent int
+
+	// Canary is true when Split chose the canary port
+	Canary bool
+
+	// StickyCookie names the cookie that pins a client to the stable or
+	// canary port ("" when sessions aren't sticky)
+	StickyCookie string
+
+	// WebSocketTimeout is how long an upgraded connection may stay open (0
+	// for the proxy's default)
+	WebSocketTimeout time.Duration
+
+	// MaxBodyBytes caps request bodies (0 for no limit)
+	MaxBodyBytes int64
+
+	// Replicas are the routed service's other backends, on the replica nodes
+	// of a spread deployment
+	Replicas []Backend
+}
+
+// Backend is a replica node's host port of a spread deployment's routed
+// service.
+type Backend struct {
+	NodeID string
+	NodeIP string
+	Port   int
+}
+
+// Backends a sticky session cookie pins a client to.
+const (
+	BackendStable = "stable"
+	BackendCanary = "canary"
+)
+
+// WithRouting returns the target with a deployment's routing options applied.
+func (t ProxyTarget) WithRouting(o domain.RoutingOptions) ProxyTarget {
+	t.StickyCookie = o.Cookie()
+	t.WebSocketTimeout = time.Duration(o.WebSocketTimeoutSeconds) * time.Second
+	t.MaxBodyBytes = o.MaxBodyBytes()
+	return t
 }
 
 // CanRoute returns true if the target can accept traffic.
@@ -46,3 +98,51 @@ func (t ProxyTarget) LocalAddress() string {
 func (t ProxyTarget) RemoteAddress() string {
 	return fmt.Sprintf("%s:%d", t.NodeIP, t.Port)
 }
+
+// Split picks the stable or canary port for one request. roll is a random
+// number in [0, 100); rolls below CanaryPercent go to the canary.
+func (t ProxyTarget) Split(roll int) ProxyTarget {
+	if t.CanaryPort > 0 && roll < t.CanaryPercent {
+		t.Port = t.CanaryPort
+		t.Canary = true
+	}
+	return t
+}
+
+// SplitSticky is Split for sticky sessions: a client whose cookie pins it to
+// a backend (BackendStable or BackendCanary) keeps it while a canary runs.
+// Other clients are split by roll.
+func (t ProxyTarget) SplitSticky(pinned string, roll int) ProxyTarget {
+	if t.StickyCookie == "" || t.CanaryPort == 0 {
+		return t.Split(roll)
+	}
+	switch pinned {
+	case BackendStable:
+		return t
+	case BackendCanary:
+		return t.Split(-1)
+	}
+	return t.Split(roll)
+}
+
+// Spread picks the backend for one request among the deployment's node and
+// its replicas. pick is any non-negative number, e.g. random or a hash of
+// the client's address; a canary is never spread.
+func (t ProxyTarget) Spread(pick int) ProxyTarget {
+	if t.Canary || len(t.Replicas) == 0 {
+		return t
+	}
+	if i := pick % (len(t.Replicas) + 1); i > 0 {
+		b := t.Replicas[i-1]
+		t.NodeID, t.NodeIP, t.Port = b.NodeID, b.NodeIP, b.Port
+	}
+	return t
+}
+
+// Backend returns the backend the target was split to.
+func (t ProxyTarget) Backend() string {
+	if t.Canary {
+		return BackendCanary
+	}
+	return BackendStable
+}
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"testing"
+	"time"
 
+	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -109,3 +111,89 @@ func TestProxyTarget_RemoteAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyTarget_Split(t *testing.T) {
+	target := ProxyTarget{Port: 30001, CanaryPort: 30002, CanaryPercent: 10}
+
+	tests := []struct {
+		name       string
+		target     ProxyTarget
+		roll       int
+		wantPort   int
+		wantCanary bool
+	}{
+		{"low roll to canary", target, 0, 30002, true},
+		{"last canary roll", target, 9, 30002, true},
+		{"high roll to stable", target, 10, 30001, false},
+		{"no canary", ProxyTarget{Port: 30001, CanaryPercent: 10}, 0, 30001, false},
+		{"promoted", ProxyTarget{Port: 30001, CanaryPort: 30002, CanaryPercent: 100}, 99, 30002, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.target.Split(tt.roll)
+			assert.Equal(t, tt.wantPort, got.Port)
+			assert.Equal(t, tt.wantCanary, got.Canary)
+		})
+	}
+}
+
+func TestProxyTarget_SplitSticky(t *testing.T) {
+	sticky := ProxyTarget{Port: 30001, CanaryPort: 30002, CanaryPercent: 10, StickyCookie: "sid"}
+
+	tests := []struct {
+		name       string
+		target     ProxyTarget
+		pinned     string
+		roll       int
+		wantCanary bool
+	}{
+		{"pinned to stable", sticky, BackendStable, 0, false},
+		{"pinned to canary", sticky, BackendCanary, 99, true},
+		{"unpinned splits", sticky, "", 0, true},
+		{"unknown pin splits", sticky, "other", 50, false},
+		{"not sticky ignores pin", ProxyTarget{Port: 30001, CanaryPort: 30002, CanaryPercent: 10}, BackendCanary, 50, false},
+		{"canary gone", ProxyTarget{Port: 30001, StickyCookie: "sid"}, BackendCanary, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.target.SplitSticky(tt.pinned, tt.roll)
+			assert.Equal(t, tt.wantCanary, got.Canary)
+			if tt.wantCanary {
+				assert.Equal(t, BackendCanary, got.Backend())
+			} else {
+				assert.Equal(t, BackendStable, got.Backend())
+			}
+		})
+	}
+}
+
+func TestProxyTarget_Spread(t *testing.T) {
+	target := ProxyTarget{NodeID: "node_a", NodeIP: "10.0.0.1", Port: 30001, Replicas: []Backend{
+		{NodeID: "node_b", NodeIP: "10.0.0.2", Port: 30101},
+		{NodeID: "node_c", NodeIP: "10.0.0.3", Port: 30201},
+	}}
+
+	assert.Equal(t, "10.0.0.1:30001", target.Spread(0).RemoteAddress())
+	assert.Equal(t, "10.0.0.2:30101", target.Spread(1).RemoteAddress())
+	assert.Equal(t, "node_c", target.Spread(5).NodeID)
+
+	canary := target
+	canary.CanaryPort = 30002
+	canary = canary.Split(-1)
+	assert.Equal(t, 30002, canary.Spread(1).Port, "a canary stays on the deployment's node")
+	assert.Equal(t, 30001, ProxyTarget{Port: 30001}.Spread(7).Port)
+}
+
+func TestProxyTarget_WithRouting(t *testing.T) {
+	sticky := true
+	got := ProxyTarget{Port: 30001}.WithRouting(domain.RoutingOptions{
+		StickySessions:          &sticky,
+		WebSocketTimeoutSeconds: 600,
+		MaxBodyMB:               2,
+	})
+	assert.Equal(t, domain.DefaultStickyCookie, got.StickyCookie)
+	assert.Equal(t, 10*time.Minute, got.WebSocketTimeout)
+	assert.Equal(t, int64(2<<20), got.MaxBodyBytes)
+}
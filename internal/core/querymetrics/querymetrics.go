@@ -0,0 +1,223 @@
+// Package querymetrics provides pure functions for store query metrics:
+// naming the operation a query performs, aggregating durations, row counts
+// and errors per operation, sanitizing parameters for the slow-query log,
+// and rendering the aggregates in the Prometheus text format.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package querymetrics
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Operations
+// =============================================================================
+
+// Operation names what a query does as "<verb>:<table>", e.g.
+// "select:deployments" or "insert:webhook_events". Statements without a
+// table, such as PRAGMA, are named by their verb alone.
+func Operation(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return "unknown"
+	}
+	verb := words[0]
+	if verb == "with" {
+		// Name a CTE by the statement it feeds, not by the CTE
+		for _, w := range words[1:] {
+			if w == "select" || w == "insert" || w == "update" || w == "delete" {
+				verb = w
+				break
+			}
+		}
+	}
+
+	var after string
+	switch verb {
+	case "select", "delete":
+		after = "from"
+	case "insert", "replace":
+		after = "into"
+	case "update":
+		return verb + ":" + tableName(words, "update")
+	default:
+		return verb
+	}
+	return verb + ":" + tableName(words, after)
+}
+
+// tableName returns the word after the first keyword, cleaned of
+// punctuation, or "unknown".
+func tableName(words []string, keyword string) string {
+	for i, w := range words {
+		if w != keyword || i+1 >= len(words) {
+			continue
+		}
+		name := words[i+1]
+		if name == "or" && i+3 < len(words) { // UPDATE OR REPLACE t
+			name = words[i+3]
+		}
+		name = strings.TrimFunc(name, func(r rune) bool {
+			return !(r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+		})
+		if name == "" || strings.HasPrefix(name, "select") {
+			return "subquery"
+		}
+		return name
+	}
+	return "unknown"
+}
+
+// =============================================================================
+// Slow Query Log
+// =============================================================================
+
+// MaxQueryLen bounds the query text written to the slow-query log.
+const MaxQueryLen = 500
+
+// Compact returns a query on one line, with runs of whitespace collapsed,
+// cut to MaxQueryLen.
+func Compact(query string) string {
+	q := strings.Join(strings.Fields(query), " ")
+	if len(q) > MaxQueryLen {
+		q = q[:MaxQueryLen] + "…"
+	}
+	return q
+}
+
+// SanitizeArgs renders query parameters for the log without their content
+// where it could be sensitive: numbers, booleans, times and NULL are shown,
+// strings and bytes only by their length.
+func SanitizeArgs(args []any) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = sanitize(a)
+	}
+	return out
+}
+
+func sanitize(a any) string {
+	switch v := a.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("string(%d)", len(v))
+	case []byte:
+		return fmt.Sprintf("bytes(%d)", len(v))
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%T", a)
+}
+
+// =============================================================================
+// Aggregates
+// =============================================================================
+
+// Buckets are the upper bounds of the query duration histogram.
+var Buckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Stats aggregates the queries of one operation.
+type Stats struct {
+	Count  int64
+	Errors int64
+	Rows   int64
+	Total  time.Duration
+	Max    time.Duration
+	// Buckets counts queries at or under each of Buckets' bounds, cumulatively.
+	Buckets []int64
+}
+
+// Record adds one query to the aggregate.
+func (s *Stats) Record(d time.Duration, rows int64, failed bool) {
+	if s.Buckets == nil {
+		s.Buckets = make([]int64, len(Buckets))
+	}
+	s.Count++
+	s.Rows += rows
+	s.Total += d
+	s.Max = max(s.Max, d)
+	if failed {
+		s.Errors++
+	}
+	for i, bound := range Buckets {
+		if d <= bound {
+			s.Buckets[i]++
+		}
+	}
+}
+
+// Clone returns a copy of the aggregate that shares nothing with it.
+func (s Stats) Clone() Stats {
+	s.Buckets = slices.Clone(s.Buckets)
+	return s
+}
+
+// =============================================================================
+// Prometheus Exposition
+// =============================================================================
+
+// Render returns the aggregates in the Prometheus text exposition format,
+// with one series per operation.
+func Render(stats map[string]Stats) string {
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+
+	var b strings.Builder
+	counter := func(name, help string, value func(Stats) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, op := range ops {
+			fmt.Fprintf(&b, "%s{operation=%q} %d\n", name, op, value(stats[op]))
+		}
+	}
+	counter("hoster_store_queries_total", "Store queries run.", func(s Stats) int64 { return s.Count })
+	counter("hoster_store_query_errors_total", "Store queries that failed.", func(s Stats) int64 { return s.Errors })
+	counter("hoster_store_query_rows_total", "Rows store queries returned or changed.", func(s Stats) int64 { return s.Rows })
+
+	const hist = "hoster_store_query_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Store query durations.\n# TYPE %s histogram\n", hist, hist)
+	for _, op := range ops {
+		s := stats[op]
+		for i, bound := range Buckets {
+			var n int64
+			if i < len(s.Buckets) {
+				n = s.Buckets[i]
+			}
+			fmt.Fprintf(&b, "%s_bucket{operation=%q,le=%q} %d\n", hist, op, seconds(bound), n)
+		}
+		fmt.Fprintf(&b, "%s_bucket{operation=%q,le=\"+Inf\"} %d\n", hist, op, s.Count)
+		fmt.Fprintf(&b, "%s_sum{operation=%q} %s\n", hist, op, seconds(s.Total))
+		fmt.Fprintf(&b, "%s_count{operation=%q} %d\n", hist, op, s.Count)
+	}
+
+	const slowest = "hoster_store_query_duration_max_seconds"
+	fmt.Fprintf(&b, "# HELP %s Slowest store query since start.\n# TYPE %s gauge\n", slowest, slowest)
+	for _, op := range ops {
+		fmt.Fprintf(&b, "%s{operation=%q} %s\n", slowest, op, seconds(stats[op].Max))
+	}
+	return b.String()
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,72 @@
+package querymetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperation(t *testing.T) {
+	tests := map[string]string{
+		"SELECT id, name FROM deployments WHERE id = ?":                      "select:deployments",
+		"\n\t\tSELECT COUNT(*) FROM webhook_deliveries d WHERE d.status = ?": "select:webhook_deliveries",
+		"INSERT INTO webhook_events (reference_id) VALUES (?)":               "insert:webhook_events",
+		"INSERT OR IGNORE INTO leases (name) VALUES (?)":                     "insert:leases",
+		"UPDATE deployments SET status = ? WHERE id = ?":                     "update:deployments",
+		"UPDATE OR REPLACE nodes SET name = ?":                               "update:nodes",
+		"DELETE FROM usage_events WHERE id = ?":                              "delete:usage_events",
+		"WITH recent AS (SELECT 1) SELECT * FROM templates":                  "select:templates",
+		"SELECT COUNT(*) FROM (SELECT 1)":                                    "select:subquery",
+		"PRAGMA foreign_keys = ON":                                           "pragma",
+		"   ":                                                                "unknown",
+	}
+	for query, want := range tests {
+		assert.Equal(t, want, Operation(query), query)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM nodes WHERE id = ?", Compact("SELECT *\n\t\tFROM nodes\n\t\tWHERE id = ?"))
+	assert.Len(t, Compact(strings.Repeat("x ", MaxQueryLen)), MaxQueryLen+len("…"))
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t,
+		[]string{"42", "string(7)", "bytes(3)", "NULL", "true", "2026-03-01T12:00:00Z", "1.5", "[]int"},
+		SanitizeArgs([]any{42, "hunter2", []byte{1, 2, 3}, nil, true, at, 1.5, []int{}}))
+}
+
+func TestStats_Record(t *testing.T) {
+	var s Stats
+	s.Record(3*time.Millisecond, 2, false)
+	s.Record(200*time.Millisecond, 0, true)
+
+	assert.Equal(t, int64(2), s.Count)
+	assert.Equal(t, int64(1), s.Errors)
+	assert.Equal(t, int64(2), s.Rows)
+	assert.Equal(t, 203*time.Millisecond, s.Total)
+	assert.Equal(t, 200*time.Millisecond, s.Max)
+	assert.Equal(t, []int64{0, 1, 1, 1, 1, 2, 2, 2}, s.Buckets)
+
+	c := s.Clone()
+	c.Buckets[0] = 9
+	assert.Zero(t, s.Buckets[0], "a clone shares no buckets")
+}
+
+func TestRender(t *testing.T) {
+	var s Stats
+	s.Record(20*time.Millisecond, 3, false)
+	out := Render(map[string]Stats{"select:nodes": s})
+
+	assert.Contains(t, out, "# TYPE hoster_store_queries_total counter\n")
+	assert.Contains(t, out, `hoster_store_queries_total{operation="select:nodes"} 1`)
+	assert.Contains(t, out, `hoster_store_query_rows_total{operation="select:nodes"} 3`)
+	assert.Contains(t, out, `hoster_store_query_duration_seconds_bucket{operation="select:nodes",le="0.01"} 0`)
+	assert.Contains(t, out, `hoster_store_query_duration_seconds_bucket{operation="select:nodes",le="0.05"} 1`)
+	assert.Contains(t, out, `hoster_store_query_duration_seconds_bucket{operation="select:nodes",le="+Inf"} 1`)
+	assert.Contains(t, out, `hoster_store_query_duration_seconds_sum{operation="select:nodes"} 0.02`)
+	assert.Contains(t, out, `hoster_store_query_duration_max_seconds{operation="select:nodes"} 0.02`)
+}
@@ -0,0 +1,315 @@
+// Package registry provides pure functions for moving templates between
+// hoster instances: signed template bundles, verifying their signatures and
+// provenance, and validating the assets they carry.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package registry
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Bundles
+// =============================================================================
+
+// Format identifies the bundle layout. Bundles of another format are refused.
+const Format = "hoster-template-bundle/v1"
+
+// Bundle is a template exported from one hoster instance for import into
+// another: the template's definition, its assets, where it came from, and
+// the exporting instance's signature over all three.
+type Bundle struct {
+	Format     string     `json:"format"`
+	Template   Template   `json:"template"`
+	Assets     []Asset    `json:"assets,omitempty"`
+	Provenance Provenance `json:"provenance"`
+	Signature  *Signature `json:"signature,omitempty"`
+}
+
+// Template is the portable part of a template. Pricing, trials and the
+// published flag stay with the instance; an imported template starts
+// unpublished and free until its new creator sets them.
+type Template struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description,omitempty"`
+	Version              string          `json:"version"`
+	Category             string          `json:"category,omitempty"`
+	ComposeSpec          string          `json:"compose_spec"`
+	Variables            json.RawMessage `json:"variables,omitempty"`
+	ConfigFiles          json.RawMessage `json:"config_files,omitempty"`
+	SetupFlow            json.RawMessage `json:"setup_flow,omitempty"`
+	Presets              json.RawMessage `json:"presets,omitempty"`
+	Tags                 json.RawMessage `json:"tags,omitempty"`
+	RequiredCapabilities json.RawMessage `json:"required_capabilities,omitempty"`
+	ResourceCeilings     json.RawMessage `json:"resource_ceilings,omitempty"`
+	ResourcesCPUCores    float64         `json:"resources_cpu_cores,omitempty"`
+	ResourcesMemoryMB    int64           `json:"resources_memory_mb,omitempty"`
+	ResourcesDiskMB      int64           `json:"resources_disk_mb,omitempty"`
+}
+
+// Provenance says where a bundle was exported from.
+type Provenance struct {
+	Origin     string    `json:"origin"`      // Base URL of the exporting instance
+	Publisher  string    `json:"publisher"`   // The creator's reference ID there
+	TemplateID string    `json:"template_id"` // The template's reference ID there
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Signature is an Ed25519 signature over a bundle's payload.
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"` // Base64
+	Digest    string `json:"digest"`     // sha256:<hex> of the payload
+	Value     string `json:"value"`      // Base64
+}
+
+// Bundle errors.
+var (
+	ErrUnsupportedFormat = errors.New("unsupported bundle format")
+	ErrUnsigned          = errors.New("bundle is not signed")
+	ErrBadSignature      = errors.New("bundle signature verification failed")
+	ErrUntrustedKey      = errors.New("bundle is signed by an untrusted key")
+	ErrSourceKeyChanged  = errors.New("bundle is signed by a different key than the template was imported with")
+	ErrNotNewer          = errors.New("bundle is not newer than the imported template")
+	ErrInvalidKey        = errors.New("invalid registry key")
+)
+
+// Payload returns the exact bytes that are signed: the bundle without its
+// signature. Raw JSON values are compacted, so a bundle decoded and encoded
+// again yields the same payload.
+func (b Bundle) Payload() []byte {
+	b.Signature = nil
+	data, _ := json.Marshal(b)
+	return data
+}
+
+// Digest returns the sha256 digest of the bundle's payload.
+func (b Bundle) Digest() string {
+	sum := sha256.Sum256(b.Payload())
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Validate checks a bundle's format and contents, ignoring its signature.
+func (b Bundle) Validate() error {
+	if b.Format != Format {
+		return fmt.Errorf("%w: %q (want %q)", ErrUnsupportedFormat, b.Format, Format)
+	}
+	if strings.TrimSpace(b.Template.Name) == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if err := domain.ValidateVersion(b.Template.Version); err != nil {
+		return fmt.Errorf("template version: %w", err)
+	}
+	if strings.TrimSpace(b.Template.ComposeSpec) == "" {
+		return fmt.Errorf("template compose_spec is required")
+	}
+	if err := ValidateAssets(b.Assets); err != nil {
+		return err
+	}
+	return b.Provenance.Validate()
+}
+
+// Validate checks that provenance names an http(s) origin, a publisher and
+// a template.
+func (p Provenance) Validate() error {
+	u, err := url.Parse(p.Origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("provenance origin must be an http(s) URL")
+	}
+	if p.Publisher == "" || p.TemplateID == "" {
+		return fmt.Errorf("provenance must name the publisher and template")
+	}
+	return nil
+}
+
+// =============================================================================
+// Signing
+// =============================================================================
+
+// Sign returns the bundle signed with key.
+func Sign(b Bundle, key ed25519.PrivateKey) Bundle {
+	pub := key.Public().(ed25519.PublicKey)
+	payload := b.Payload()
+	b.Signature = &Signature{
+		KeyID:     KeyID(pub),
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Digest:    b.Digest(),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}
+	return b
+}
+
+// Verify checks a bundle's contents and that it is signed by one of the
+// trusted keys.
+func Verify(b Bundle, trusted []ed25519.PublicKey) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	sig := b.Signature
+	if sig == nil || sig.Value == "" {
+		return ErrUnsigned
+	}
+	pub, err := ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return ErrBadSignature
+	}
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil || len(value) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	if sig.Digest != b.Digest() || !ed25519.Verify(pub, b.Payload(), value) {
+		return ErrBadSignature
+	}
+	for _, key := range trusted {
+		if key.Equal(pub) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUntrustedKey, KeyID(pub))
+}
+
+// KeyID returns a short fingerprint of a public key: the first 16 hex
+// digits of its sha256.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key must be %d base64-encoded bytes", ErrInvalidKey, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// ParsePrivateKey decodes a base64 Ed25519 private key seed (32 bytes) or
+// full key (64 bytes).
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("%w: private key must be a %d-byte seed or %d-byte key", ErrInvalidKey, ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// =============================================================================
+// Imports
+// =============================================================================
+
+// Source is recorded on an imported template: the provenance of the bundle
+// it was last imported from and the key that signed it.
+type Source struct {
+	Origin     string    `json:"origin"`
+	Publisher  string    `json:"publisher"`
+	TemplateID string    `json:"template_id"`
+	Version    string    `json:"version"`
+	KeyID      string    `json:"key_id"`
+	Digest     string    `json:"digest"`
+	ExportedAt time.Time `json:"exported_at"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// SourceOf returns the source to record for a verified bundle.
+func SourceOf(b Bundle, importedAt time.Time) Source {
+	s := Source{
+		Origin:     b.Provenance.Origin,
+		Publisher:  b.Provenance.Publisher,
+		TemplateID: b.Provenance.TemplateID,
+		Version:    b.Template.Version,
+		ExportedAt: b.Provenance.ExportedAt,
+		ImportedAt: importedAt,
+	}
+	if b.Signature != nil {
+		s.KeyID = b.Signature.KeyID
+		s.Digest = b.Signature.Digest
+	}
+	return s
+}
+
+// Same reports whether a bundle is of the template s was imported from.
+func (s Source) Same(b Bundle) bool {
+	return s.Origin == b.Provenance.Origin && s.TemplateID == b.Provenance.TemplateID
+}
+
+// CheckUpdate checks that a bundle may update a template imported from s:
+// it must be signed by the same key, and carry a newer version.
+func CheckUpdate(s Source, b Bundle) error {
+	if b.Signature == nil || b.Signature.KeyID != s.KeyID {
+		return ErrSourceKeyChanged
+	}
+	if domain.CompareVersions(b.Template.Version, s.Version) <= 0 {
+		return fmt.Errorf("%w: have %s, bundle has %s", ErrNotNewer, s.Version, b.Template.Version)
+	}
+	return nil
+}
+
+// =============================================================================
+// Assets
+// =============================================================================
+
+// Asset is a file shipped with a template, such as its logo or README.
+// Data is base64 in JSON.
+type Asset struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// Asset limits.
+const (
+	MaxAssets          = 10
+	MaxAssetBytes      = 256 << 10
+	MaxTotalAssetBytes = 1 << 20
+)
+
+var assetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// ValidateAssets checks asset names are unique plain file names, each has a
+// content type, and the assets fit the limits.
+func ValidateAssets(assets []Asset) error {
+	if len(assets) > MaxAssets {
+		return fmt.Errorf("at most %d assets are allowed", MaxAssets)
+	}
+	seen := make(map[string]bool, len(assets))
+	total := 0
+	for _, a := range assets {
+		if !assetNamePattern.MatchString(a.Name) {
+			return fmt.Errorf("invalid asset name %q", a.Name)
+		}
+		if seen[a.Name] {
+			return fmt.Errorf("duplicate asset %q", a.Name)
+		}
+		seen[a.Name] = true
+		if a.ContentType == "" {
+			return fmt.Errorf("asset %q needs a content_type", a.Name)
+		}
+		if len(a.Data) > MaxAssetBytes {
+			return fmt.Errorf("asset %q is larger than %d bytes", a.Name, MaxAssetBytes)
+		}
+		total += len(a.Data)
+	}
+	if total > MaxTotalAssetBytes {
+		return fmt.Errorf("assets are larger than %d bytes in total", MaxTotalAssetBytes)
+	}
+	return nil
+}
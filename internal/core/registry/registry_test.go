@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T, seed byte) ed25519.PrivateKey {
+	t.Helper()
+	return ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(rune(seed)), ed25519.SeedSize)))
+}
+
+func testBundle() Bundle {
+	return Bundle{
+		Format: Format,
+		Template: Template{
+			Name:        "Blog App",
+			Version:     "1.2.0",
+			ComposeSpec: "services:\n  web:\n    image: nginx\n",
+			Variables:   json.RawMessage(`[{"name": "TITLE", "default": "<blog>"}]`),
+		},
+		Assets: []Asset{{Name: "README.md", ContentType: "text/markdown", Data: []byte("# Blog")}},
+		Provenance: Provenance{
+			Origin:     "https://hoster.example.com",
+			Publisher:  "usr_abc",
+			TemplateID: "tmpl_123",
+			ExportedAt: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+// =============================================================================
+// Signing Tests
+// =============================================================================
+
+func TestSignVerify(t *testing.T) {
+	key := testKey(t, 'a')
+	b := Sign(testBundle(), key)
+	require.NotNil(t, b.Signature)
+	assert.Equal(t, KeyID(key.Public().(ed25519.PublicKey)), b.Signature.KeyID)
+	assert.True(t, strings.HasPrefix(b.Signature.Digest, "sha256:"))
+
+	assert.NoError(t, Verify(b, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}))
+}
+
+func TestVerify_SurvivesJSONRoundTrip(t *testing.T) {
+	key := testKey(t, 'a')
+	data, err := json.MarshalIndent(Sign(testBundle(), key), "", "  ")
+	require.NoError(t, err)
+
+	var b Bundle
+	require.NoError(t, json.Unmarshal(data, &b))
+	assert.NoError(t, Verify(b, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}))
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	key := testKey(t, 'a')
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+
+	b := Sign(testBundle(), key)
+	b.Template.ComposeSpec = "services:\n  web:\n    image: evil\n"
+	assert.ErrorIs(t, Verify(b, trusted), ErrBadSignature)
+
+	b = Sign(testBundle(), key)
+	b.Provenance.Publisher = "usr_other"
+	assert.ErrorIs(t, Verify(b, trusted), ErrBadSignature)
+
+	b = Sign(testBundle(), key)
+	b.Assets[0].Data = []byte("# Changed")
+	assert.ErrorIs(t, Verify(b, trusted), ErrBadSignature)
+}
+
+func TestVerify_ResignedByOtherKey(t *testing.T) {
+	trusted := []ed25519.PublicKey{testKey(t, 'a').Public().(ed25519.PublicKey)}
+	b := Sign(testBundle(), testKey(t, 'b'))
+	assert.ErrorIs(t, Verify(b, trusted), ErrUntrustedKey)
+}
+
+func TestVerify_Unsigned(t *testing.T) {
+	assert.ErrorIs(t, Verify(testBundle(), nil), ErrUnsigned)
+}
+
+func TestVerify_Format(t *testing.T) {
+	key := testKey(t, 'a')
+	b := testBundle()
+	b.Format = "hoster-template-bundle/v0"
+	assert.ErrorIs(t, Verify(Sign(b, key), []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}), ErrUnsupportedFormat)
+}
+
+func TestValidate(t *testing.T) {
+	b := testBundle()
+	b.Template.Version = "1.2"
+	assert.Error(t, b.Validate())
+
+	b = testBundle()
+	b.Template.ComposeSpec = ""
+	assert.Error(t, b.Validate())
+
+	b = testBundle()
+	b.Provenance.Origin = "ftp://hoster.example.com"
+	assert.Error(t, b.Validate())
+
+	b = testBundle()
+	b.Provenance.TemplateID = ""
+	assert.Error(t, b.Validate())
+}
+
+func TestParseKeys(t *testing.T) {
+	_, err := ParsePrivateKey("bm90IGEga2V5")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = ParsePublicKey("bm90IGEga2V5")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+// =============================================================================
+// Import Tests
+// =============================================================================
+
+func TestCheckUpdate(t *testing.T) {
+	key := testKey(t, 'a')
+	imported := SourceOf(Sign(testBundle(), key), time.Now())
+	assert.Equal(t, "1.2.0", imported.Version)
+
+	next := testBundle()
+	next.Template.Version = "1.3.0"
+	assert.True(t, imported.Same(next))
+	assert.NoError(t, CheckUpdate(imported, Sign(next, key)))
+
+	assert.ErrorIs(t, CheckUpdate(imported, Sign(testBundle(), key)), ErrNotNewer)
+	assert.ErrorIs(t, CheckUpdate(imported, Sign(next, testKey(t, 'b'))), ErrSourceKeyChanged)
+
+	other := testBundle()
+	other.Provenance.TemplateID = "tmpl_456"
+	assert.False(t, imported.Same(other))
+}
+
+// =============================================================================
+// Asset Tests
+// =============================================================================
+
+func TestValidateAssets(t *testing.T) {
+	assert.NoError(t, ValidateAssets(nil))
+	assert.NoError(t, ValidateAssets([]Asset{{Name: "logo.png", ContentType: "image/png", Data: []byte{1}}}))
+
+	assert.Error(t, ValidateAssets([]Asset{{Name: "../etc/passwd", ContentType: "text/plain"}}))
+	assert.Error(t, ValidateAssets([]Asset{{Name: "logo.png"}}))
+	assert.Error(t, ValidateAssets([]Asset{
+		{Name: "logo.png", ContentType: "image/png"},
+		{Name: "logo.png", ContentType: "image/png"},
+	}))
+	assert.Error(t, ValidateAssets([]Asset{{Name: "big.bin", ContentType: "application/octet-stream", Data: make([]byte, MaxAssetBytes+1)}}))
+
+	many := make([]Asset, MaxAssets+1)
+	for i := range many {
+		many[i] = Asset{Name: "a" + string(rune('a'+i)), ContentType: "text/plain"}
+	}
+	assert.Error(t, ValidateAssets(many))
+}
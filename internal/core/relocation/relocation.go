@@ -0,0 +1,111 @@
+// Package relocation provides pure functions for moving a deployment to
+// another node or region at its owner's request: the steps a relocation goes
+// through, how far along it is, which nodes are in a region, and what
+// rolling back a relocation that failed at a given step has to undo.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package relocation
+
+import (
+	"strings"
+)
+
+// =============================================================================
+// Status
+// =============================================================================
+
+// Status is the step a relocation is at.
+type Status string
+
+const (
+	// StatusPending is a relocation that has not started.
+	StatusPending Status = "pending"
+	// StatusStopping stops the deployment on its source node.
+	StatusStopping Status = "stopping"
+	// StatusTransferring copies the deployment's volumes to the target node
+	// and, once they are verified, moves the deployment there.
+	StatusTransferring Status = "transferring"
+	// StatusStarting re-creates the deployment's containers on the target node.
+	StatusStarting Status = "starting"
+	// StatusSwitchingRoutes points the deployment's routes at the target node
+	// and removes what is left on the source node.
+	StatusSwitchingRoutes Status = "switching_routes"
+	// StatusCompleted is a relocation that moved the deployment.
+	StatusCompleted Status = "completed"
+	// StatusRollingBack undoes a relocation that failed.
+	StatusRollingBack Status = "rolling_back"
+	// StatusRolledBack is a relocation that failed and left the deployment on
+	// its source node, as it was.
+	StatusRolledBack Status = "rolled_back"
+	// StatusFailed is a relocation whose rollback failed too.
+	StatusFailed Status = "failed"
+)
+
+// Active reports whether the relocation still owns the deployment.
+func (s Status) Active() bool {
+	switch s {
+	case StatusCompleted, StatusRolledBack, StatusFailed:
+		return false
+	}
+	return true
+}
+
+// Progress returns how far along a relocation is, in percent. While volumes
+// are copied, transferPercent is the volume migration's own progress.
+func Progress(s Status, transferPercent int) int {
+	switch s {
+	case StatusPending:
+		return 0
+	case StatusStopping:
+		return 5
+	case StatusTransferring:
+		return 10 + 70*min(max(transferPercent, 0), 100)/100
+	case StatusStarting:
+		return 85
+	case StatusSwitchingRoutes:
+		return 95
+	}
+	return 100
+}
+
+// =============================================================================
+// Targets
+// =============================================================================
+
+// InRegion reports whether a node at location is in region. Regions are
+// node locations, compared without regard to case or surrounding space.
+func InRegion(location, region string) bool {
+	region = strings.TrimSpace(region)
+	return region != "" && strings.EqualFold(strings.TrimSpace(location), region)
+}
+
+// =============================================================================
+// Rollback
+// =============================================================================
+
+// Rollback is what undoing a failed relocation involves.
+type Rollback struct {
+	// CleanTarget removes the containers and volumes the relocation created
+	// on the target node.
+	CleanTarget bool
+	// SwitchBack moves the deployment from the target node back to the
+	// source node, whose volumes were left untouched.
+	SwitchBack bool
+	// Restart starts the deployment on the source node again, because it was
+	// running when the relocation began.
+	Restart bool
+}
+
+// PlanRollback returns what has to be undone for a relocation that failed at
+// step failedAt, for a deployment that was running when it began or not.
+func PlanRollback(failedAt Status, wasRunning bool) Rollback {
+	switch failedAt {
+	case StatusPending, StatusStopping:
+		// Nothing has been copied; a partly stopped deployment starts again
+		return Rollback{Restart: wasRunning}
+	case StatusTransferring:
+		// The node is only switched once every volume is verified
+		return Rollback{CleanTarget: true, Restart: wasRunning}
+	default:
+		return Rollback{CleanTarget: true, SwitchBack: true, Restart: wasRunning}
+	}
+}
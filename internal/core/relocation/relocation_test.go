@@ -0,0 +1,56 @@
+package relocation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus_Active(t *testing.T) {
+	for _, s := range []Status{StatusPending, StatusStopping, StatusTransferring, StatusStarting, StatusSwitchingRoutes, StatusRollingBack} {
+		assert.True(t, s.Active(), s)
+	}
+	for _, s := range []Status{StatusCompleted, StatusRolledBack, StatusFailed} {
+		assert.False(t, s.Active(), s)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	assert.Equal(t, 0, Progress(StatusPending, 0))
+	assert.Equal(t, 5, Progress(StatusStopping, 0))
+	assert.Equal(t, 10, Progress(StatusTransferring, 0))
+	assert.Equal(t, 45, Progress(StatusTransferring, 50))
+	assert.Equal(t, 80, Progress(StatusTransferring, 100))
+	assert.Equal(t, 80, Progress(StatusTransferring, 250), "transfer progress is capped")
+	assert.Equal(t, 85, Progress(StatusStarting, 100))
+	assert.Equal(t, 95, Progress(StatusSwitchingRoutes, 100))
+	assert.Equal(t, 100, Progress(StatusCompleted, 0))
+	assert.Equal(t, 100, Progress(StatusRolledBack, 0))
+}
+
+func TestInRegion(t *testing.T) {
+	assert.True(t, InRegion("eu-west", "eu-west"))
+	assert.True(t, InRegion(" EU-West ", "eu-west"))
+	assert.False(t, InRegion("us-east", "eu-west"))
+	assert.False(t, InRegion("", ""), "an empty region matches no node")
+	assert.False(t, InRegion("eu-west", " "))
+}
+
+func TestPlanRollback(t *testing.T) {
+	tests := []struct {
+		failedAt   Status
+		wasRunning bool
+		want       Rollback
+	}{
+		{StatusPending, true, Rollback{Restart: true}},
+		{StatusStopping, true, Rollback{Restart: true}},
+		{StatusStopping, false, Rollback{}},
+		{StatusTransferring, true, Rollback{CleanTarget: true, Restart: true}},
+		{StatusTransferring, false, Rollback{CleanTarget: true}},
+		{StatusStarting, true, Rollback{CleanTarget: true, SwitchBack: true, Restart: true}},
+		{StatusSwitchingRoutes, false, Rollback{CleanTarget: true, SwitchBack: true}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, PlanRollback(tt.failedAt, tt.wasRunning), "%s running=%v", tt.failedAt, tt.wasRunning)
+	}
+}
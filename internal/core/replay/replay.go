@@ -0,0 +1,111 @@
+// Package replay provides pure functions for command execution history: the
+// lifecycle of a recorded execution, and the guards a failed execution must
+// pass before it is dispatched again with its original payload.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// Executions
+// =============================================================================
+
+// Status is where a command execution is in its lifecycle.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// ParseStatus parses a status name.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case StatusRunning, StatusSucceeded, StatusFailed:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid execution status %q", s)
+}
+
+// StaleAfter is how long an execution may run before it is taken to have
+// been interrupted, such as by a restart, and no longer blocks retries.
+const StaleAfter = time.Hour
+
+// Execution is a recorded run of a command against a resource. Seq is the
+// order executions were recorded in; it orders executions started within
+// the same second.
+type Execution struct {
+	ID        string
+	Seq       int64
+	Command   string
+	Status    Status
+	StartedAt time.Time
+}
+
+// Interrupted reports whether a running execution has gone stale.
+func (e Execution) Interrupted(now time.Time) bool {
+	return e.Status == StatusRunning && now.Sub(e.StartedAt) > StaleAfter
+}
+
+// After reports whether e started after o.
+func (e Execution) After(o Execution) bool {
+	if e.StartedAt.Equal(o.StartedAt) {
+		return e.Seq > o.Seq
+	}
+	return e.StartedAt.After(o.StartedAt)
+}
+
+// =============================================================================
+// Retry Guards
+// =============================================================================
+
+// Retry errors.
+var (
+	ErrNotRetryable = errors.New("command cannot be retried")
+	ErrNotFailed    = errors.New("only failed executions can be retried")
+	ErrInProgress   = errors.New("another command is running for this resource")
+	ErrSuperseded   = errors.New("the command has run again since; retry the latest execution")
+	ErrStateChanged = errors.New("the resource has moved on since the command failed")
+)
+
+// CheckRetry checks that target, a failed or interrupted execution, may be
+// dispatched again. history is every execution recorded for the same
+// resource. A retry is refused while another command runs for the
+// resource, and when the same command has run again since target.
+func CheckRetry(target Execution, history []Execution, now time.Time) error {
+	if target.Status != StatusFailed && !target.Interrupted(now) {
+		return fmt.Errorf("%w: execution is %s", ErrNotFailed, target.Status)
+	}
+	for _, e := range history {
+		if e.ID == target.ID {
+			continue
+		}
+		if e.Status == StatusRunning && !e.Interrupted(now) {
+			return fmt.Errorf("%w: %s", ErrInProgress, e.Command)
+		}
+		if e.Command == target.Command && e.After(target) {
+			return ErrSuperseded
+		}
+	}
+	return nil
+}
+
+// RetryState returns the state a resource must be moved to before a
+// command is retried: the state recorded in the original payload. It is ""
+// when the resource is already there or has no state. When the resource
+// has since moved to a state it cannot return from, ErrStateChanged is
+// returned.
+func RetryState(payloadState, currentState string, canTransition func(from, to string) bool) (string, error) {
+	if payloadState == "" || payloadState == currentState {
+		return "", nil
+	}
+	if canTransition(currentState, payloadState) {
+		return payloadState, nil
+	}
+	return "", fmt.Errorf("%w: %s, was %s", ErrStateChanged, currentState, payloadState)
+}
@@ -0,0 +1,103 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseStatus(t *testing.T) {
+	st, err := ParseStatus("failed")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, st)
+
+	_, err = ParseStatus("done")
+	assert.Error(t, err)
+}
+
+func TestAfter(t *testing.T) {
+	a := Execution{Seq: 1, StartedAt: now}
+	b := Execution{Seq: 2, StartedAt: now}
+	assert.True(t, b.After(a))
+	assert.False(t, a.After(b))
+	assert.True(t, Execution{Seq: 1, StartedAt: now.Add(time.Second)}.After(b))
+}
+
+func TestInterrupted(t *testing.T) {
+	assert.False(t, Execution{Status: StatusRunning, StartedAt: now.Add(-time.Minute)}.Interrupted(now))
+	assert.True(t, Execution{Status: StatusRunning, StartedAt: now.Add(-2 * time.Hour)}.Interrupted(now))
+	assert.False(t, Execution{Status: StatusFailed, StartedAt: now.Add(-2 * time.Hour)}.Interrupted(now))
+}
+
+// =============================================================================
+// Retry Guard Tests
+// =============================================================================
+
+func TestCheckRetry(t *testing.T) {
+	failed := Execution{ID: "a", Command: "StartDeployment", Status: StatusFailed, StartedAt: now.Add(-10 * time.Minute)}
+	older := Execution{ID: "b", Command: "StopDeployment", Status: StatusSucceeded, StartedAt: now.Add(-time.Hour)}
+
+	assert.NoError(t, CheckRetry(failed, []Execution{failed, older}, now))
+}
+
+func TestCheckRetry_NotFailed(t *testing.T) {
+	ok := Execution{ID: "a", Command: "StartDeployment", Status: StatusSucceeded, StartedAt: now}
+	assert.ErrorIs(t, CheckRetry(ok, nil, now), ErrNotFailed)
+
+	running := Execution{ID: "a", Command: "StartDeployment", Status: StatusRunning, StartedAt: now.Add(-time.Minute)}
+	assert.ErrorIs(t, CheckRetry(running, nil, now), ErrNotFailed)
+
+	stale := Execution{ID: "a", Command: "StartDeployment", Status: StatusRunning, StartedAt: now.Add(-2 * time.Hour)}
+	assert.NoError(t, CheckRetry(stale, nil, now), "an interrupted execution can be retried")
+}
+
+func TestCheckRetry_InProgress(t *testing.T) {
+	failed := Execution{ID: "a", Command: "StartDeployment", Status: StatusFailed, StartedAt: now.Add(-10 * time.Minute)}
+	running := Execution{ID: "b", Command: "StopDeployment", Status: StatusRunning, StartedAt: now.Add(-time.Minute)}
+	assert.ErrorIs(t, CheckRetry(failed, []Execution{failed, running}, now), ErrInProgress)
+
+	running.StartedAt = now.Add(-2 * time.Hour)
+	assert.NoError(t, CheckRetry(failed, []Execution{failed, running}, now), "a stale execution does not block")
+}
+
+func TestCheckRetry_Superseded(t *testing.T) {
+	failed := Execution{ID: "a", Command: "StartDeployment", Status: StatusFailed, StartedAt: now.Add(-10 * time.Minute)}
+	again := Execution{ID: "b", Command: "StartDeployment", Status: StatusFailed, StartedAt: now.Add(-5 * time.Minute)}
+	assert.ErrorIs(t, CheckRetry(failed, []Execution{failed, again}, now), ErrSuperseded)
+	assert.NoError(t, CheckRetry(again, []Execution{failed, again}, now))
+
+	sameSecond := Execution{ID: "c", Seq: 2, Command: "StartDeployment", Status: StatusSucceeded, StartedAt: now.Add(-10 * time.Minute)}
+	failed.Seq = 1
+	assert.ErrorIs(t, CheckRetry(failed, []Execution{failed, sameSecond}, now), ErrSuperseded)
+}
+
+func TestRetryState(t *testing.T) {
+	transitions := map[string][]string{"failed": {"starting"}, "running": {"stopping"}}
+	can := func(from, to string) bool {
+		for _, t := range transitions[from] {
+			if t == to {
+				return true
+			}
+		}
+		return false
+	}
+
+	to, err := RetryState("starting", "starting", can)
+	require.NoError(t, err)
+	assert.Empty(t, to)
+
+	to, err = RetryState("starting", "failed", can)
+	require.NoError(t, err)
+	assert.Equal(t, "starting", to)
+
+	_, err = RetryState("starting", "running", can)
+	assert.ErrorIs(t, err, ErrStateChanged)
+
+	to, err = RetryState("", "running", can)
+	require.NoError(t, err)
+	assert.Empty(t, to)
+}
@@ -0,0 +1,142 @@
+// Package replication provides pure functions for cold-standby replication
+// of the control plane: roles, the layout of snapshot bundles, where bundle
+// entries are restored to, and judging how far a standby lags its primary.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package replication
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/backup"
+)
+
+// =============================================================================
+// Roles
+// =============================================================================
+
+// Roles an instance plays, as set in replication.role. An instance with no
+// role does not replicate.
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+)
+
+// ValidateRole checks a replication role.
+func ValidateRole(role string) error {
+	switch role {
+	case "", RolePrimary, RoleStandby:
+		return nil
+	}
+	return fmt.Errorf("must be primary or standby, got %q", role)
+}
+
+// =============================================================================
+// Timings
+// =============================================================================
+
+const (
+	// DefaultInterval is how often a primary ships a snapshot.
+	DefaultInterval = time.Minute
+	// MinInterval guards against snapshotting continuously.
+	MinInterval = 10 * time.Second
+	// DefaultMaxLag is the snapshot age over which a standby is lagging.
+	DefaultMaxLag = 5 * time.Minute
+	// DefaultKeep is how many snapshots a standby keeps.
+	DefaultKeep = 3
+)
+
+// =============================================================================
+// Snapshot Bundles
+// =============================================================================
+//
+// A snapshot is a gzipped tar holding the database as DatabaseEntry and the
+// files of the config directory under ConfigPrefix. It is described by a
+// backup.Manifest stored next to it, so snapshots are named, listed and
+// verified like backups.
+
+const (
+	// DatabaseEntry is the bundle entry holding the database.
+	DatabaseEntry = "hoster.db"
+	// ConfigPrefix prefixes the bundle entries of config files.
+	ConfigPrefix = "configs/"
+	// PromotionFile records that a standby was promoted.
+	PromotionFile = "promoted.json"
+)
+
+// BundleFile returns the name of a snapshot's bundle.
+func BundleFile(id string) string {
+	return id + ".tar.gz"
+}
+
+// ConfigEntry returns the bundle entry of a config file, given its
+// slash-separated path relative to the config directory.
+func ConfigEntry(rel string) string {
+	return ConfigPrefix + rel
+}
+
+// ConfigPath returns the slash-separated path relative to the config
+// directory a bundle entry is restored to, or false for entries that are not
+// config files. Entries that would land outside the directory are errors.
+func ConfigPath(entry string) (string, bool, error) {
+	rel, ok := strings.CutPrefix(entry, ConfigPrefix)
+	if !ok {
+		return "", false, nil
+	}
+	clean := path.Clean(rel)
+	if rel == "" || clean != rel || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+		return "", false, fmt.Errorf("invalid config entry %q", entry)
+	}
+	return clean, true, nil
+}
+
+// =============================================================================
+// Standby Status
+// =============================================================================
+
+// Status is what a standby has received, reported by its health endpoint.
+type Status struct {
+	// Snapshot is the newest snapshot received.
+	Snapshot    *backup.Manifest `json:"snapshot,omitempty"`
+	ReceivedAt  time.Time        `json:"received_at,omitzero"`
+	LastError   string           `json:"last_error,omitempty"`
+	LastErrorAt time.Time        `json:"last_error_at,omitzero"`
+}
+
+// Lag returns how far the standby is behind its primary at now: the age of
+// the newest snapshot. It is false before the first snapshot.
+func (s Status) Lag(now time.Time) (time.Duration, bool) {
+	if s.Snapshot == nil {
+		return 0, false
+	}
+	return max(now.Sub(s.Snapshot.CreatedAt), 0), true
+}
+
+// Check returns "ok" while the newest snapshot is at most maxLag old, or
+// "lagging" with the last error, if any. A standby that has not received a
+// snapshot within maxLag of starting is "pending".
+func (s Status) Check(maxLag time.Duration, startedAt, now time.Time) string {
+	if lag, ok := s.Lag(now); ok && lag <= maxLag {
+		return "ok"
+	}
+	if s.Snapshot == nil && now.Sub(startedAt) <= maxLag {
+		return "pending"
+	}
+	if s.LastError != "" {
+		return "lagging: " + s.LastError
+	}
+	return "lagging"
+}
+
+// =============================================================================
+// Promotion
+// =============================================================================
+
+// Promotion records a standby's promotion to primary. A promoted standby
+// starts as a primary even though its config still names it a standby.
+type Promotion struct {
+	Snapshot   backup.Manifest `json:"snapshot"`
+	PromotedAt time.Time       `json:"promoted_at"`
+}
@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/backup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func snapshotAt(t time.Time) *backup.Manifest {
+	return &backup.Manifest{ID: backup.NewID(t), CreatedAt: t, Bytes: 100, SHA256: strings.Repeat("a", 64)}
+}
+
+func TestValidateRole(t *testing.T) {
+	for _, role := range []string{"", RolePrimary, RoleStandby} {
+		assert.NoError(t, ValidateRole(role), role)
+	}
+	assert.ErrorContains(t, ValidateRole("replica"), "must be primary or standby")
+}
+
+// =============================================================================
+// Bundle Tests
+// =============================================================================
+
+func TestBundleNames(t *testing.T) {
+	assert.Equal(t, "20250301T120000Z.tar.gz", BundleFile("20250301T120000Z"))
+	assert.Equal(t, "configs/depl_1/app.conf", ConfigEntry("depl_1/app.conf"))
+
+	_, ok := backup.IDFromManifestFile(PromotionFile)
+	assert.False(t, ok, "the promotion record is not listed as a snapshot")
+}
+
+func TestConfigPath(t *testing.T) {
+	rel, ok, err := ConfigPath("configs/depl_1/app.conf")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "depl_1/app.conf", rel)
+
+	_, ok, err = ConfigPath(DatabaseEntry)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	for _, entry := range []string{"configs/", "configs/../etc/passwd", "configs//abs", "configs/a/../../b", "configs/."} {
+		_, _, err := ConfigPath(entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+// =============================================================================
+// Status Tests
+// =============================================================================
+
+func TestStatus_Lag(t *testing.T) {
+	_, ok := Status{}.Lag(t0)
+	assert.False(t, ok)
+
+	lag, ok := Status{Snapshot: snapshotAt(t0)}.Lag(t0.Add(90 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, lag)
+
+	lag, _ = Status{Snapshot: snapshotAt(t0)}.Lag(t0.Add(-time.Second))
+	assert.Zero(t, lag, "clock skew does not make the lag negative")
+}
+
+func TestStatus_Check(t *testing.T) {
+	maxLag := 5 * time.Minute
+	tests := []struct {
+		name   string
+		status Status
+		now    time.Time
+		want   string
+	}{
+		{"fresh", Status{Snapshot: snapshotAt(t0)}, t0.Add(time.Minute), "ok"},
+		{"at max lag", Status{Snapshot: snapshotAt(t0)}, t0.Add(maxLag), "ok"},
+		{"behind", Status{Snapshot: snapshotAt(t0)}, t0.Add(maxLag + time.Second), "lagging"},
+		{"behind with error", Status{Snapshot: snapshotAt(t0), LastError: "checksum mismatch"}, t0.Add(time.Hour), "lagging: checksum mismatch"},
+		{"just started", Status{}, t0.Add(time.Minute), "pending"},
+		{"never received", Status{}, t0.Add(time.Hour), "lagging"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.status.Check(maxLag, t0, tt.now))
+		})
+	}
+}
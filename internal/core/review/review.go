@@ -0,0 +1,199 @@
+// Package review provides pure functions for template reviews: who may
+// review a template, rating and text validation, rating summaries shown on
+// template listings, abuse report reasons, and moderation.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package review
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// =============================================================================
+// Eligibility and Validation
+// =============================================================================
+
+// Eligibility errors.
+var (
+	ErrNotInstalled = errors.New("only customers who have deployed this template can review it")
+	ErrOwnTemplate  = errors.New("creators cannot review their own templates")
+	ErrUnpublished  = errors.New("only published templates can be reviewed")
+)
+
+// CheckEligibility decides whether a user may review a template.
+// deployments is how many deployments of the template the user has created.
+func CheckEligibility(published, isCreator bool, deployments int) error {
+	switch {
+	case !published:
+		return ErrUnpublished
+	case isCreator:
+		return ErrOwnTemplate
+	case deployments < 1:
+		return ErrNotInstalled
+	}
+	return nil
+}
+
+// Limits on review content.
+const (
+	MinRating         = 1
+	MaxRating         = 5
+	MaxTitleLength    = 120
+	MaxBodyLength     = 5000
+	MaxResponseLength = 2000
+)
+
+// ValidateRating checks that a rating is a whole number of stars.
+func ValidateRating(rating int) error {
+	if rating < MinRating || rating > MaxRating {
+		return fmt.Errorf("rating must be between %d and %d", MinRating, MaxRating)
+	}
+	return nil
+}
+
+// ValidateText checks a review's title and body lengths.
+func ValidateText(title, body string) error {
+	if len(title) > MaxTitleLength {
+		return fmt.Errorf("title must be at most %d characters", MaxTitleLength)
+	}
+	if len(body) > MaxBodyLength {
+		return fmt.Errorf("body must be at most %d characters", MaxBodyLength)
+	}
+	return nil
+}
+
+// NormalizeResponse trims and validates a creator's response to a review.
+func NormalizeResponse(response string) (string, error) {
+	response = strings.TrimSpace(response)
+	if response == "" {
+		return "", fmt.Errorf("response is required")
+	}
+	if len(response) > MaxResponseLength {
+		return "", fmt.Errorf("response must be at most %d characters", MaxResponseLength)
+	}
+	return response, nil
+}
+
+// =============================================================================
+// Rating Summary
+// =============================================================================
+
+// Summary aggregates a template's published review ratings.
+type Summary struct {
+	Average      float64        `json:"average"` // Rounded to one decimal, 0 without reviews
+	Count        int            `json:"count"`
+	Distribution map[string]int `json:"distribution"` // Reviews per star, "1" to "5"
+}
+
+// Summarize aggregates ratings. Ratings outside 1-5 are ignored.
+func Summarize(ratings []int) Summary {
+	s := Summary{Distribution: map[string]int{}}
+	for stars := MinRating; stars <= MaxRating; stars++ {
+		s.Distribution[fmt.Sprint(stars)] = 0
+	}
+	total := 0
+	for _, r := range ratings {
+		if ValidateRating(r) != nil {
+			continue
+		}
+		s.Distribution[fmt.Sprint(r)]++
+		s.Count++
+		total += r
+	}
+	if s.Count > 0 {
+		s.Average = math.Round(float64(total)/float64(s.Count)*10) / 10
+	}
+	return s
+}
+
+// =============================================================================
+// Reports and Moderation
+// =============================================================================
+
+// Status is a review's visibility.
+type Status string
+
+const (
+	// StatusPublished reviews are shown to everyone.
+	StatusPublished Status = "published"
+	// StatusHidden reviews were hidden by reports or a moderator. Only their
+	// author sees them.
+	StatusHidden Status = "hidden"
+)
+
+// ReportReason is why a user reported a review.
+type ReportReason string
+
+const (
+	ReasonSpam      ReportReason = "spam"
+	ReasonOffensive ReportReason = "offensive"
+	ReasonOffTopic  ReportReason = "off_topic"
+	ReasonFake      ReportReason = "fake"
+	ReasonOther     ReportReason = "other"
+)
+
+// ReportReasons lists the valid report reasons.
+var ReportReasons = []ReportReason{ReasonSpam, ReasonOffensive, ReasonOffTopic, ReasonFake, ReasonOther}
+
+// ParseReportReason returns the reason named s.
+func ParseReportReason(s string) (ReportReason, error) {
+	for _, r := range ReportReasons {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("invalid reason %q: must be one of spam, offensive, off_topic, fake, other", s)
+}
+
+// MaxReportDetailsLength bounds a report's free-text details.
+const MaxReportDetailsLength = 1000
+
+// AutoHideReports is how many open reports from different users hide a
+// review until a moderator looks at it.
+const AutoHideReports = 3
+
+// ShouldAutoHide reports whether a published review with this many open
+// reports is hidden pending moderation.
+func ShouldAutoHide(status Status, openReports int) bool {
+	return status == StatusPublished && openReports >= AutoHideReports
+}
+
+// ModerationAction is a moderator's decision on a review.
+type ModerationAction string
+
+const (
+	// ActionHide hides the review and closes its open reports as upheld.
+	ActionHide ModerationAction = "hide"
+	// ActionRestore publishes the review and dismisses its open reports.
+	ActionRestore ModerationAction = "restore"
+	// ActionDismiss dismisses the open reports without changing the review.
+	ActionDismiss ModerationAction = "dismiss"
+)
+
+// Report statuses.
+const (
+	ReportOpen      = "open"
+	ReportUpheld    = "upheld"
+	ReportDismissed = "dismissed"
+)
+
+// Moderation is the outcome of a moderation action.
+type Moderation struct {
+	Status       Status // The review's new status
+	ReportStatus string // What the open reports become
+}
+
+// Moderate applies a moderation action to a review with the given status.
+func Moderate(current Status, action string) (Moderation, error) {
+	switch ModerationAction(action) {
+	case ActionHide:
+		return Moderation{Status: StatusHidden, ReportStatus: ReportUpheld}, nil
+	case ActionRestore:
+		return Moderation{Status: StatusPublished, ReportStatus: ReportDismissed}, nil
+	case ActionDismiss:
+		return Moderation{Status: current, ReportStatus: ReportDismissed}, nil
+	}
+	return Moderation{}, fmt.Errorf("invalid action %q: must be one of hide, restore, dismiss", action)
+}
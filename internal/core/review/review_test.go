@@ -0,0 +1,84 @@
+package review
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEligibility(t *testing.T) {
+	assert.NoError(t, CheckEligibility(true, false, 1))
+	assert.ErrorIs(t, CheckEligibility(true, false, 0), ErrNotInstalled)
+	assert.ErrorIs(t, CheckEligibility(true, true, 3), ErrOwnTemplate)
+	assert.ErrorIs(t, CheckEligibility(false, false, 3), ErrUnpublished)
+}
+
+func TestValidateRatingAndText(t *testing.T) {
+	assert.NoError(t, ValidateRating(1))
+	assert.NoError(t, ValidateRating(5))
+	assert.Error(t, ValidateRating(0))
+	assert.Error(t, ValidateRating(6))
+
+	assert.NoError(t, ValidateText("Great", "Works well"))
+	assert.Error(t, ValidateText(strings.Repeat("a", MaxTitleLength+1), ""))
+	assert.Error(t, ValidateText("", strings.Repeat("a", MaxBodyLength+1)))
+}
+
+func TestNormalizeResponse(t *testing.T) {
+	r, err := NormalizeResponse("  Thanks, fixed in 1.2.0  ")
+	require.NoError(t, err)
+	assert.Equal(t, "Thanks, fixed in 1.2.0", r)
+
+	_, err = NormalizeResponse("  ")
+	assert.Error(t, err)
+	_, err = NormalizeResponse(strings.Repeat("a", MaxResponseLength+1))
+	assert.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	empty := Summarize(nil)
+	assert.Zero(t, empty.Count)
+	assert.Zero(t, empty.Average)
+	assert.Equal(t, 0, empty.Distribution["5"])
+
+	s := Summarize([]int{5, 4, 4, 1, 0, 9})
+	assert.Equal(t, 4, s.Count, "out of range ratings are ignored")
+	assert.Equal(t, 3.5, s.Average)
+	assert.Equal(t, 2, s.Distribution["4"])
+	assert.Equal(t, 1, s.Distribution["1"])
+
+	assert.Equal(t, 4.7, Summarize([]int{5, 5, 4}).Average)
+}
+
+func TestParseReportReason(t *testing.T) {
+	r, err := ParseReportReason("off_topic")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonOffTopic, r)
+	_, err = ParseReportReason("boring")
+	assert.Error(t, err)
+}
+
+func TestShouldAutoHide(t *testing.T) {
+	assert.False(t, ShouldAutoHide(StatusPublished, AutoHideReports-1))
+	assert.True(t, ShouldAutoHide(StatusPublished, AutoHideReports))
+	assert.False(t, ShouldAutoHide(StatusHidden, AutoHideReports+1))
+}
+
+func TestModerate(t *testing.T) {
+	m, err := Moderate(StatusPublished, "hide")
+	require.NoError(t, err)
+	assert.Equal(t, Moderation{Status: StatusHidden, ReportStatus: ReportUpheld}, m)
+
+	m, err = Moderate(StatusHidden, "restore")
+	require.NoError(t, err)
+	assert.Equal(t, Moderation{Status: StatusPublished, ReportStatus: ReportDismissed}, m)
+
+	m, err = Moderate(StatusHidden, "dismiss")
+	require.NoError(t, err)
+	assert.Equal(t, StatusHidden, m.Status)
+
+	_, err = Moderate(StatusPublished, "delete")
+	assert.Error(t, err)
+}
@@ -0,0 +1,272 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Placement Rules
+// =============================================================================
+
+// ErrPinUnsatisfiable is returned when a matching placement rule without
+// fallback cannot place the deployment on any of its nodes.
+var ErrPinUnsatisfiable = errors.New("placement rule cannot be satisfied")
+
+// PlacementRule is a template creator's rule for where deployments of their
+// templates go: a customer pinned to nodes, a template to a group of nodes,
+// or both. Rules are evaluated before generic scheduling.
+type PlacementRule struct {
+	// ID is the rule's reference_id
+	ID string
+
+	// CustomerID matches deployments of this customer, 0 for any customer
+	CustomerID int
+
+	// TemplateID matches deployments of this template, 0 for any of the
+	// creator's templates
+	TemplateID int
+
+	// Nodes are the reference_ids of the nodes the rule places deployments on
+	Nodes []string
+
+	// Priority orders rules of equal specificity, highest first
+	Priority int
+
+	// Fallback lets deployments continue to the next rule, then generic
+	// scheduling, when none of the nodes can take them
+	Fallback bool
+}
+
+// Rule kinds reported by PlacementRule.Kind.
+const (
+	RuleKindCustomerTemplate = "customer_template"
+	RuleKindCustomer         = "customer"
+	RuleKindTemplate         = "template"
+)
+
+// Kind describes what the rule matches on.
+func (r PlacementRule) Kind() string {
+	switch {
+	case r.CustomerID != 0 && r.TemplateID != 0:
+		return RuleKindCustomerTemplate
+	case r.CustomerID != 0:
+		return RuleKindCustomer
+	default:
+		return RuleKindTemplate
+	}
+}
+
+// Validate checks that the rule matches something and names its nodes.
+func (r PlacementRule) Validate() error {
+	if r.CustomerID == 0 && r.TemplateID == 0 {
+		return fmt.Errorf("a placement rule must match a customer, a template, or both")
+	}
+	if len(r.Nodes) == 0 {
+		return fmt.Errorf("a placement rule must name at least one node")
+	}
+	seen := make(map[string]bool, len(r.Nodes))
+	for _, n := range r.Nodes {
+		if strings.TrimSpace(n) == "" {
+			return fmt.Errorf("node IDs must not be empty")
+		}
+		if seen[n] {
+			return fmt.Errorf("node %s is listed twice", n)
+		}
+		seen[n] = true
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to a deployment of templateID by
+// customerID.
+func (r PlacementRule) Matches(customerID, templateID int) bool {
+	return (r.CustomerID == 0 || r.CustomerID == customerID) &&
+		(r.TemplateID == 0 || r.TemplateID == templateID)
+}
+
+// specificity ranks rules: customer and template, then customer, then template.
+func (r PlacementRule) specificity() int {
+	s := 0
+	if r.CustomerID != 0 {
+		s += 2
+	}
+	if r.TemplateID != 0 {
+		s++
+	}
+	return s
+}
+
+// MatchRules returns the rules that apply to a deployment, in evaluation
+// order: most specific first, then highest priority, then by ID.
+func MatchRules(rules []PlacementRule, customerID, templateID int) []PlacementRule {
+	var matched []PlacementRule
+	for _, r := range rules {
+		if r.Matches(customerID, templateID) {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.specificity() != b.specificity() {
+			return a.specificity() > b.specificity()
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.ID < b.ID
+	})
+	return matched
+}
+
+// =============================================================================
+// Rule Evaluation
+// =============================================================================
+
+// Placement is the outcome of evaluating placement rules.
+type Placement struct {
+	// Rule is the ID of the rule that placed the deployment, or of the
+	// rule that could not be satisfied; empty when no rule decided
+	Rule string
+
+	// Result is the scheduling result on the rule's nodes, nil when no rule
+	// placed the deployment
+	Result *ScheduleResult
+
+	// Reason explains why matching rules could not place the deployment
+	Reason string
+}
+
+// ApplyPlacementRules evaluates the matching rules in order against nodes,
+// keyed by reference_id. req carries everything but AvailableNodes. The
+// first rule with a node that can take the deployment places it. A rule
+// without fallback that cannot stops evaluation with ErrPinUnsatisfiable.
+// When no rule decides, Result is nil and generic scheduling applies.
+func ApplyPlacementRules(rules []PlacementRule, nodes map[string]domain.Node, req ScheduleRequest) (Placement, error) {
+	var reasons []string
+	for _, rule := range rules {
+		ruleReq := req
+		ruleReq.AvailableNodes = nil
+		for _, id := range rule.Nodes {
+			if n, ok := nodes[id]; ok {
+				ruleReq.AvailableNodes = append(ruleReq.AvailableNodes, n)
+			}
+		}
+		result, err := Schedule(ruleReq)
+		if err == nil {
+			return Placement{Rule: rule.ID, Result: result}, nil
+		}
+		reason := fmt.Sprintf("rule %s: %s", rule.ID, describeDiagnoses(DiagnoseNodes(rule, nodes, req)))
+		reasons = append(reasons, reason)
+		if !rule.Fallback {
+			return Placement{Rule: rule.ID, Reason: reason}, ErrPinUnsatisfiable
+		}
+	}
+	return Placement{Reason: strings.Join(reasons, "; ")}, nil
+}
+
+// =============================================================================
+// Diagnostics
+// =============================================================================
+
+// NodeDiagnosis is whether one of a rule's nodes can take a deployment.
+type NodeDiagnosis struct {
+	Node   string `json:"node"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// placementReasons explains each filter reason for a rule's node.
+var placementReasons = map[string]string{
+	"not_found":                     "does not exist or is not the rule owner's",
+	"not_online":                    "is not online",
+	"unschedulable":                 "is being drained",
+	"missing_required_capabilities": "lacks capabilities the template requires",
+	"plan_capabilities_mismatch":    "has no capability the customer's plan allows",
+	"insufficient_capacity":         "does not have enough free capacity",
+}
+
+// DiagnoseNodes checks each of a rule's nodes against a deployment's
+// requirements. req.AvailableNodes is ignored.
+func DiagnoseNodes(rule PlacementRule, nodes map[string]domain.Node, req ScheduleRequest) []NodeDiagnosis {
+	out := make([]NodeDiagnosis, 0, len(rule.Nodes))
+	for _, id := range rule.Nodes {
+		reason := "not_found"
+		if n, ok := nodes[id]; ok {
+			reason = filterNode(n, req)
+		}
+		d := NodeDiagnosis{Node: id, OK: reason == ""}
+		if !d.OK {
+			d.Reason = placementReasons[reason]
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func describeDiagnoses(diagnoses []NodeDiagnosis) string {
+	if len(diagnoses) == 0 {
+		return "no nodes"
+	}
+	parts := make([]string, 0, len(diagnoses))
+	for _, d := range diagnoses {
+		if d.OK {
+			parts = append(parts, "node "+d.Node+" can take it")
+			continue
+		}
+		parts = append(parts, "node "+d.Node+" "+d.Reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Conflict is a pair of rules that match the same deployments with equal
+// specificity and priority but different nodes; the first one wins.
+type Conflict struct {
+	Winner string `json:"winner"`
+	Loser  string `json:"loser"`
+	Reason string `json:"reason"`
+}
+
+// RuleConflicts finds the rules in rules that shadow one another.
+func RuleConflicts(rules []PlacementRule) []Conflict {
+	sorted := append([]PlacementRule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var conflicts []Conflict
+	for i, a := range sorted {
+		for _, b := range sorted[i+1:] {
+			if a.CustomerID != b.CustomerID || a.TemplateID != b.TemplateID || a.Priority != b.Priority {
+				continue
+			}
+			if sameNodes(a.Nodes, b.Nodes) {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{
+				Winner: a.ID,
+				Loser:  b.ID,
+				Reason: fmt.Sprintf("rules %s and %s match the same deployments with priority %d; %s is evaluated first", a.ID, b.ID, a.Priority, a.ID),
+			})
+		}
+	}
+	return conflicts
+}
+
+func sameNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, n := range a {
+		set[n] = true
+	}
+	for _, n := range b {
+		if !set[n] {
+			return false
+		}
+	}
+	return true
+}
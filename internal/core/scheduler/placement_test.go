@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Placement Rule Tests
+// =============================================================================
+
+func TestPlacementRule_Validate(t *testing.T) {
+	assert.Error(t, PlacementRule{Nodes: []string{"node_a"}}.Validate())
+	assert.Error(t, PlacementRule{CustomerID: 7}.Validate())
+	assert.Error(t, PlacementRule{CustomerID: 7, Nodes: []string{" "}}.Validate())
+	assert.Error(t, PlacementRule{CustomerID: 7, Nodes: []string{"node_a", "node_a"}}.Validate())
+	assert.NoError(t, PlacementRule{TemplateID: 3, Nodes: []string{"node_a", "node_b"}}.Validate())
+}
+
+func TestPlacementRule_Kind(t *testing.T) {
+	assert.Equal(t, RuleKindCustomerTemplate, PlacementRule{CustomerID: 7, TemplateID: 3}.Kind())
+	assert.Equal(t, RuleKindCustomer, PlacementRule{CustomerID: 7}.Kind())
+	assert.Equal(t, RuleKindTemplate, PlacementRule{TemplateID: 3}.Kind())
+}
+
+func TestMatchRules_Order(t *testing.T) {
+	rules := []PlacementRule{
+		{ID: "tmpl", TemplateID: 3},
+		{ID: "cust-low", CustomerID: 7},
+		{ID: "cust-high", CustomerID: 7, Priority: 10},
+		{ID: "both", CustomerID: 7, TemplateID: 3},
+		{ID: "other-customer", CustomerID: 8},
+		{ID: "other-template", TemplateID: 4},
+	}
+
+	var ids []string
+	for _, r := range MatchRules(rules, 7, 3) {
+		ids = append(ids, r.ID)
+	}
+	assert.Equal(t, []string{"both", "cust-high", "cust-low", "tmpl"}, ids)
+	assert.Empty(t, MatchRules(rules, 9, 9))
+}
+
+func TestApplyPlacementRules_PinPlaces(t *testing.T) {
+	nodes := map[string]domain.Node{
+		"node_a": makeNode("node_a", "A", domain.NodeStatusOnline, nil, 4, 8192, 51200),
+		"node_b": makeNode("node_b", "B", domain.NodeStatusOnline, nil, 16, 65536, 512000),
+	}
+	rules := []PlacementRule{{ID: "pin", CustomerID: 7, Nodes: []string{"node_a"}}}
+
+	p, err := ApplyPlacementRules(rules, nodes, ScheduleRequest{RequiredResources: domain.Resources{MemoryMB: 512}})
+	require.NoError(t, err)
+	assert.Equal(t, "pin", p.Rule)
+	require.NotNil(t, p.Result)
+	assert.Equal(t, "node_a", p.Result.SelectedNodeID, "the pin wins over the roomier node")
+}
+
+func TestApplyPlacementRules_StrictPinFails(t *testing.T) {
+	nodes := map[string]domain.Node{
+		"node_a": makeNode("node_a", "A", domain.NodeStatusOffline, nil, 4, 8192, 51200),
+	}
+	rules := []PlacementRule{
+		{ID: "pin", CustomerID: 7, Nodes: []string{"node_a", "node_gone"}},
+		{ID: "group", TemplateID: 3, Nodes: []string{"node_a"}},
+	}
+
+	p, err := ApplyPlacementRules(rules, nodes, ScheduleRequest{})
+	assert.ErrorIs(t, err, ErrPinUnsatisfiable)
+	assert.Equal(t, "pin", p.Rule)
+	assert.Nil(t, p.Result)
+	assert.Equal(t, "rule pin: node node_a is not online, node node_gone does not exist or is not the rule owner's", p.Reason)
+}
+
+func TestApplyPlacementRules_Fallback(t *testing.T) {
+	nodes := map[string]domain.Node{
+		"node_a": makeNode("node_a", "A", domain.NodeStatusOnline, nil, 1, 512, 1024),
+		"node_b": makeNode("node_b", "B", domain.NodeStatusOnline, nil, 8, 16384, 102400),
+	}
+	req := ScheduleRequest{RequiredResources: domain.Resources{MemoryMB: 4096}}
+
+	rules := []PlacementRule{
+		{ID: "pin", CustomerID: 7, Nodes: []string{"node_a"}, Fallback: true},
+		{ID: "group", TemplateID: 3, Nodes: []string{"node_b"}},
+	}
+	p, err := ApplyPlacementRules(rules, nodes, req)
+	require.NoError(t, err)
+	assert.Equal(t, "group", p.Rule)
+	assert.Equal(t, "node_b", p.Result.SelectedNodeID)
+
+	p, err = ApplyPlacementRules(rules[:1], nodes, req)
+	require.NoError(t, err)
+	assert.Empty(t, p.Rule)
+	assert.Nil(t, p.Result, "generic scheduling applies")
+	assert.Contains(t, p.Reason, "node node_a does not have enough free capacity")
+
+	p, err = ApplyPlacementRules(nil, nodes, req)
+	require.NoError(t, err)
+	assert.Equal(t, Placement{}, p)
+}
+
+func TestDiagnoseNodes(t *testing.T) {
+	nodes := map[string]domain.Node{
+		"node_a": makeNode("node_a", "A", domain.NodeStatusOnline, []string{"standard"}, 4, 8192, 51200),
+		"node_b": makeNode("node_b", "B", domain.NodeStatusOnline, []string{"standard"}, 4, 8192, 51200),
+	}
+	rule := PlacementRule{ID: "r", TemplateID: 3, Nodes: []string{"node_a", "node_x"}}
+
+	d := DiagnoseNodes(rule, nodes, ScheduleRequest{RequiredCapabilities: []string{"gpu"}})
+	require.Len(t, d, 2)
+	assert.Equal(t, NodeDiagnosis{Node: "node_a", Reason: "lacks capabilities the template requires"}, d[0])
+	assert.Equal(t, "does not exist or is not the rule owner's", d[1].Reason)
+
+	d = DiagnoseNodes(rule, nodes, ScheduleRequest{})
+	assert.True(t, d[0].OK)
+}
+
+func TestRuleConflicts(t *testing.T) {
+	rules := []PlacementRule{
+		{ID: "b", CustomerID: 7, Nodes: []string{"node_b"}},
+		{ID: "a", CustomerID: 7, Nodes: []string{"node_a"}},
+		{ID: "c", CustomerID: 7, Nodes: []string{"node_a"}},
+		{ID: "d", CustomerID: 7, Priority: 5, Nodes: []string{"node_c"}},
+		{ID: "e", TemplateID: 3, Nodes: []string{"node_a"}},
+	}
+
+	conflicts := RuleConflicts(rules)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "a", conflicts[0].Winner)
+	assert.Equal(t, "b", conflicts[0].Loser)
+	assert.Equal(t, "b", conflicts[1].Winner)
+	assert.Equal(t, "c", conflicts[1].Loser)
+}
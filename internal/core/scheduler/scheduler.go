@@ -4,7 +4,9 @@ package scheduler
 
 import (
 	"errors"
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/artpar/hoster/internal/core/domain"
 )
@@ -44,6 +46,65 @@ type ScheduleRequest struct {
 
 	// AllowedCapabilities are the node capabilities the user's plan permits (e.g., ["standard", "gpu"])
 	AllowedCapabilities []string
+
+	// Affinity asks for the node of another deployment, nil for none
+	Affinity *Affinity
+
+	// Location asks for a node in this location (matched ignoring case),
+	// empty for any. Like Affinity it is a preference: nodes elsewhere are
+	// chosen when none in the location can take the deployment.
+	Location string
+
+	// Strategy ranks the nodes that can take the deployment, empty for
+	// StrategySpread
+	Strategy Strategy
+
+	// Seed draws the order of StrategyRandom
+	Seed int64
+}
+
+// =============================================================================
+// Affinity
+// =============================================================================
+
+// Affinity is a co-location hint: place the deployment on the same node as a
+// peer deployment of the same customer. It is a preference, not a filter —
+// when the peer's node can't take the deployment, the best other node is
+// chosen and the result says why.
+type Affinity struct {
+	// PeerDeploymentID is the reference_id of the deployment to co-locate with
+	PeerDeploymentID string
+
+	// PeerNodeID is the node the peer is placed on, empty if it has none
+	PeerNodeID string
+}
+
+// Affinity outcomes reported in ScheduleResult.AffinityStatus.
+const (
+	AffinitySatisfied   = "satisfied"
+	AffinityUnsatisfied = "unsatisfied"
+)
+
+// affinityReasons explains each filter reason for the peer's node.
+var affinityReasons = map[string]string{
+	"not_online":                    "is not online",
+	"unschedulable":                 "is being drained",
+	"missing_required_capabilities": "lacks capabilities the template requires",
+	"plan_capabilities_mismatch":    "has no capability your plan allows",
+	"insufficient_capacity":         "does not have enough free capacity",
+}
+
+// CheckAffinity reports whether placing a deployment on nodeID satisfies a,
+// for deployments whose node was chosen explicitly rather than scheduled.
+func CheckAffinity(a Affinity, nodeID string) (status, reason string) {
+	switch {
+	case a.PeerNodeID == "":
+		return AffinityUnsatisfied, fmt.Sprintf("deployment %s is not placed on a node", a.PeerDeploymentID)
+	case a.PeerNodeID == nodeID:
+		return AffinitySatisfied, ""
+	default:
+		return AffinityUnsatisfied, fmt.Sprintf("node %s was selected explicitly; deployment %s runs on node %s", nodeID, a.PeerDeploymentID, a.PeerNodeID)
+	}
 }
 
 // =============================================================================
@@ -66,6 +127,17 @@ type ScheduleResult struct {
 
 	// FilteredOutReason tracks why nodes were filtered out
 	FilteredOutReasons map[string]int
+
+	// AffinityStatus is AffinitySatisfied or AffinityUnsatisfied, empty
+	// when the request had no affinity
+	AffinityStatus string
+
+	// AffinityReason explains an unsatisfied affinity
+	AffinityReason string
+
+	// LocationMatched reports whether the selected node is in the requested
+	// location; false when the request had none
+	LocationMatched bool
 }
 
 // =============================================================================
@@ -90,14 +162,22 @@ type nodeCandidate struct {
 // 2. Filter nodes that have ALL required capabilities (if any)
 // 3. Filter nodes that have AT LEAST ONE capability allowed by user's plan
 // 4. Filter nodes with sufficient capacity for the required resources
-// 5. Score remaining nodes by available resources (higher is better)
-// 6. Return highest-scoring node
+// 5. Score remaining nodes with the request's strategy (higher is better)
+// 6. Return the affinity peer's node if it remains, else the best node in the
+// requested location, else the highest-scoring node
 func Schedule(req ScheduleRequest) (*ScheduleResult, error) {
 	result := &ScheduleResult{
 		FilteredOutReasons: make(map[string]int),
 	}
 
+	// The peer's node is "not_considered" until it is seen in the list
+	peerReason := ""
+	if req.Affinity != nil && req.Affinity.PeerNodeID != "" {
+		peerReason = "not_considered"
+	}
+
 	if len(req.AvailableNodes) == 0 {
+		result.explainAffinity(req.Affinity, peerReason)
 		return result, ErrNoNodesAvailable
 	}
 
@@ -106,43 +186,25 @@ func Schedule(req ScheduleRequest) (*ScheduleResult, error) {
 	for _, node := range req.AvailableNodes {
 		result.ConsideredCount++
 
-		// Step 1: Must be online
-		if !node.IsAvailable() {
-			result.FilteredOutReasons["not_online"]++
-			continue
-		}
-
-		// Step 2: Must have all required capabilities (if any specified)
-		if len(req.RequiredCapabilities) > 0 {
-			if !node.HasAllCapabilities(req.RequiredCapabilities) {
-				result.FilteredOutReasons["missing_required_capabilities"]++
-				continue
-			}
+		reason := filterNode(node, req)
+		if req.Affinity != nil && node.ReferenceID == req.Affinity.PeerNodeID {
+			peerReason = reason
 		}
-
-		// Step 3: Must have at least one capability allowed by user's plan
-		// If no allowed capabilities specified, skip this check (allow all)
-		if len(req.AllowedCapabilities) > 0 {
-			if !node.HasAnyCapability(req.AllowedCapabilities) {
-				result.FilteredOutReasons["plan_capabilities_mismatch"]++
-				continue
-			}
-		}
-
-		// Step 4: Must have sufficient capacity
-		if !node.Capacity.CanHandle(req.RequiredResources) {
-			result.FilteredOutReasons["insufficient_capacity"]++
+		if reason != "" {
+			result.FilteredOutReasons[reason]++
 			continue
 		}
 
 		// Node passed all filters, calculate score
-		score := ScoreNode(node, req.RequiredResources)
+		score := req.Strategy.Score(node, req.RequiredResources, req.Seed)
 		candidates = append(candidates, nodeCandidate{
 			node:  node,
 			score: score,
 		})
 	}
 
+	result.explainAffinity(req.Affinity, peerReason)
+
 	if len(candidates) == 0 {
 		// Determine the most appropriate error based on filter reasons
 		if result.FilteredOutReasons["plan_capabilities_mismatch"] > 0 &&
@@ -158,20 +220,98 @@ func Schedule(req ScheduleRequest) (*ScheduleResult, error) {
 		return result, ErrNoNodesAvailable
 	}
 
-	// Sort by score descending (highest first)
+	// Sort by score descending (highest first), ties by ID so the choice is
+	// repeatable
 	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].score > candidates[j].score
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].node.ReferenceID < candidates[j].node.ReferenceID
 	})
 
-	// Select the best node
+	// Select the best node, or the peer's node when affinity is satisfied,
+	// or the best node in the requested location
 	best := candidates[0]
+	switch {
+	case result.AffinityStatus == AffinitySatisfied:
+		for _, c := range candidates {
+			if c.node.ReferenceID == req.Affinity.PeerNodeID {
+				best = c
+				break
+			}
+		}
+	case req.Location != "":
+		for _, c := range candidates {
+			if InLocation(c.node, req.Location) {
+				best = c
+				break
+			}
+		}
+	}
 	result.SelectedNodeID = best.node.ReferenceID
+	result.LocationMatched = req.Location != "" && InLocation(best.node, req.Location)
 	result.SelectedNode = &best.node
 	result.Score = best.score
 
 	return result, nil
 }
 
+// filterNode returns the reason node can't take the deployment, or "" if it can.
+func filterNode(node domain.Node, req ScheduleRequest) string {
+	// Step 1: Must be online and not draining
+	if node.Unschedulable {
+		return "unschedulable"
+	}
+	if !node.IsAvailable() {
+		return "not_online"
+	}
+
+	// Step 2: Must have all required capabilities (if any specified)
+	if len(req.RequiredCapabilities) > 0 && !node.HasAllCapabilities(req.RequiredCapabilities) {
+		return "missing_required_capabilities"
+	}
+
+	// Step 3: Must have at least one capability allowed by user's plan
+	// If no allowed capabilities specified, skip this check (allow all)
+	if len(req.AllowedCapabilities) > 0 && !node.HasAnyCapability(req.AllowedCapabilities) {
+		return "plan_capabilities_mismatch"
+	}
+
+	// Step 4: Must have sufficient capacity
+	if !node.Capacity.CanHandle(req.RequiredResources) {
+		return "insufficient_capacity"
+	}
+	return ""
+}
+
+// InLocation reports whether node is in location, ignoring case and
+// surrounding space.
+func InLocation(node domain.Node, location string) bool {
+	location = strings.TrimSpace(location)
+	return location != "" && strings.EqualFold(strings.TrimSpace(node.Location), location)
+}
+
+// explainAffinity records whether the affinity can be honored, given the
+// filter reason of the peer's node ("" if it passed every filter).
+func (r *ScheduleResult) explainAffinity(a *Affinity, peerReason string) {
+	if a == nil {
+		return
+	}
+	switch {
+	case a.PeerNodeID == "":
+		r.AffinityStatus = AffinityUnsatisfied
+		r.AffinityReason = fmt.Sprintf("deployment %s is not placed on a node", a.PeerDeploymentID)
+	case peerReason == "":
+		r.AffinityStatus = AffinitySatisfied
+	case peerReason == "not_considered":
+		r.AffinityStatus = AffinityUnsatisfied
+		r.AffinityReason = fmt.Sprintf("node %s of deployment %s is not available to this deployment", a.PeerNodeID, a.PeerDeploymentID)
+	default:
+		r.AffinityStatus = AffinityUnsatisfied
+		r.AffinityReason = fmt.Sprintf("node %s of deployment %s %s", a.PeerNodeID, a.PeerDeploymentID, affinityReasons[peerReason])
+	}
+}
+
 // =============================================================================
 // Scoring Algorithm
 // =============================================================================
@@ -226,6 +226,90 @@ func TestSchedule_MixedConditions(t *testing.T) {
 	assert.Equal(t, 4, result.ConsideredCount)
 }
 
+// =============================================================================
+// Affinity Tests
+// =============================================================================
+
+func TestSchedule_AffinityPrefersPeerNode(t *testing.T) {
+	nodes := []domain.Node{
+		makeNodeWithUsage("node_busy", []string{"standard"}, 8, 6, 16384, 12000, 102400, 80000),
+		makeNodeWithUsage("node_idle", []string{"standard"}, 8, 0, 16384, 0, 102400, 0),
+	}
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes:    nodes,
+		RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024, DiskMB: 5000},
+		Affinity:          &Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_busy"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_busy", result.SelectedNodeID)
+	assert.Equal(t, AffinitySatisfied, result.AffinityStatus)
+	assert.Empty(t, result.AffinityReason)
+}
+
+func TestSchedule_AffinityFallsBackWhenPeerNodeFull(t *testing.T) {
+	nodes := []domain.Node{
+		makeNodeWithUsage("node_full", []string{"standard"}, 8, 8, 16384, 16384, 102400, 102400),
+		makeNode("node_other", "Other", domain.NodeStatusOnline, []string{"standard"}, 8, 16384, 102400),
+	}
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes:    nodes,
+		RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024, DiskMB: 5000},
+		Affinity:          &Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_full"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_other", result.SelectedNodeID)
+	assert.Equal(t, AffinityUnsatisfied, result.AffinityStatus)
+	assert.Equal(t, "node node_full of deployment depl_db does not have enough free capacity", result.AffinityReason)
+}
+
+func TestSchedule_AffinityReasons(t *testing.T) {
+	online := makeNode("node_1", "Node 1", domain.NodeStatusOnline, []string{"standard"}, 8, 16384, 102400)
+	offline := makeNode("node_2", "Node 2", domain.NodeStatusOffline, []string{"standard"}, 8, 16384, 102400)
+
+	cases := []struct {
+		name     string
+		nodes    []domain.Node
+		affinity Affinity
+		reason   string
+	}{
+		{"peer not placed", []domain.Node{online}, Affinity{PeerDeploymentID: "depl_db"}, "deployment depl_db is not placed on a node"},
+		{"peer node offline", []domain.Node{online, offline}, Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_2"}, "node node_2 of deployment depl_db is not online"},
+		{"peer node not listed", []domain.Node{online}, Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_9"}, "node node_9 of deployment depl_db is not available to this deployment"},
+		{"no nodes at all", nil, Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_9"}, "node node_9 of deployment depl_db is not available to this deployment"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := tc.affinity
+			result, _ := Schedule(ScheduleRequest{AvailableNodes: tc.nodes, Affinity: &a})
+			assert.Equal(t, AffinityUnsatisfied, result.AffinityStatus)
+			assert.Equal(t, tc.reason, result.AffinityReason)
+		})
+	}
+}
+
+func TestSchedule_NoAffinity(t *testing.T) {
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes: []domain.Node{makeNode("node_1", "Node 1", domain.NodeStatusOnline, nil, 8, 16384, 102400)},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, result.AffinityStatus)
+}
+
+func TestCheckAffinity(t *testing.T) {
+	status, reason := CheckAffinity(Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_1"}, "node_1")
+	assert.Equal(t, AffinitySatisfied, status)
+	assert.Empty(t, reason)
+
+	status, reason = CheckAffinity(Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_1"}, "node_2")
+	assert.Equal(t, AffinityUnsatisfied, status)
+	assert.Equal(t, "node node_2 was selected explicitly; deployment depl_db runs on node node_1", reason)
+
+	status, _ = CheckAffinity(Affinity{PeerDeploymentID: "depl_db"}, "node_2")
+	assert.Equal(t, AffinityUnsatisfied, status)
+}
+
 // =============================================================================
 // ScoreNode Tests
 // =============================================================================
@@ -479,3 +563,23 @@ func TestSchedule_NoCapabilitiesRequired(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "special", result.SelectedNodeID)
 }
+
+func TestSchedule_SkipsUnschedulableNodes(t *testing.T) {
+	draining := makeNode("node_1", "Draining", domain.NodeStatusOnline, []string{"standard"}, 16, 32768, 204800)
+	draining.Unschedulable = true
+	nodes := []domain.Node{
+		draining,
+		makeNode("node_2", "Node 2", domain.NodeStatusOnline, []string{"standard"}, 4, 8192, 51200),
+	}
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes:    nodes,
+		RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024, DiskMB: 5000},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_2", result.SelectedNodeID)
+	assert.Equal(t, 1, result.FilteredOutReasons["unschedulable"])
+
+	_, err = Schedule(ScheduleRequest{AvailableNodes: nodes[:1], RequiredResources: domain.Resources{CPUCores: 1}})
+	assert.ErrorIs(t, err, ErrNoNodesAvailable)
+}
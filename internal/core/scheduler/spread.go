@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Replica Spreading
+// =============================================================================
+
+// SpreadNodes picks up to n nodes for the replicas of a deployment placed on
+// primaryID, which is never picked. Nodes are filtered as Schedule filters
+// them. Nodes in a location none of the picked nodes (nor the primary) is in
+// come first, so the replicas outlive the loss of a location; the rest
+// follow in the order of the request's strategy. Fewer than n nodes are
+// returned when fewer can take the deployment.
+func SpreadNodes(req ScheduleRequest, primaryID string, n int) []domain.Node {
+	if n <= 0 {
+		return nil
+	}
+
+	usedLocations := make(map[string]bool)
+	var candidates []nodeCandidate
+	for _, node := range req.AvailableNodes {
+		if node.ReferenceID == primaryID {
+			usedLocations[locationKey(node)] = true
+			continue
+		}
+		if filterNode(node, req) != "" {
+			continue
+		}
+		candidates = append(candidates, nodeCandidate{
+			node:  node,
+			score: req.Strategy.Score(node, req.RequiredResources, req.Seed),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].node.ReferenceID < candidates[j].node.ReferenceID
+	})
+
+	picked := make([]domain.Node, 0, n)
+	taken := make(map[string]bool, n)
+	for _, c := range candidates {
+		loc := locationKey(c.node)
+		if len(picked) == n || loc == "" || usedLocations[loc] {
+			continue
+		}
+		usedLocations[loc] = true
+		taken[c.node.ReferenceID] = true
+		picked = append(picked, c.node)
+	}
+	for _, c := range candidates {
+		if len(picked) == n {
+			break
+		}
+		if !taken[c.node.ReferenceID] {
+			picked = append(picked, c.node)
+		}
+	}
+	return picked
+}
+
+// locationKey is a node's location as compared by SpreadNodes, "" for none.
+func locationKey(node domain.Node) string {
+	return strings.ToLower(strings.TrimSpace(node.Location))
+}
@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func nodeIDs(nodes []domain.Node) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ReferenceID
+	}
+	return ids
+}
+
+func TestSpreadNodes(t *testing.T) {
+	at := func(n domain.Node, loc string) domain.Node {
+		n.Location = loc
+		return n
+	}
+	offline := makeNode("node_off", "Off", domain.NodeStatusOffline, nil, 64, 65536, 512000)
+	nodes := []domain.Node{
+		at(makeNode("primary", "P", domain.NodeStatusOnline, nil, 4, 8192, 51200), "fra"),
+		at(makeNode("big_fra", "B", domain.NodeStatusOnline, nil, 32, 65536, 512000), "FRA"),
+		at(makeNode("small_ams", "S", domain.NodeStatusOnline, nil, 2, 4096, 51200), "ams"),
+		at(makeNode("mid_fra", "M", domain.NodeStatusOnline, nil, 8, 16384, 102400), "fra"),
+		makeNode("tiny", "T", domain.NodeStatusOnline, nil, 0.5, 512, 1000),
+		offline,
+	}
+	req := ScheduleRequest{AvailableNodes: nodes, RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024}}
+
+	assert.Equal(t, []string{"small_ams", "big_fra"}, nodeIDs(SpreadNodes(req, "primary", 2)),
+		"a new location comes before a better node in the primary's")
+	assert.Equal(t, []string{"small_ams", "big_fra", "mid_fra"}, nodeIDs(SpreadNodes(req, "primary", 5)),
+		"nodes that can't take the deployment are left out")
+	assert.Empty(t, SpreadNodes(req, "primary", 0))
+}
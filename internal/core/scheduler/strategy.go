@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Scoring Strategies
+// =============================================================================
+
+// Strategy is how the scheduler ranks the nodes that can take a deployment.
+type Strategy string
+
+const (
+	// StrategySpread prefers the node with the most capacity left after
+	// placement, spreading deployments across nodes. It is the default.
+	StrategySpread Strategy = "spread"
+	// StrategyBinpack prefers the node with the least capacity left after
+	// placement, filling nodes before starting on the next.
+	StrategyBinpack Strategy = "binpack"
+	// StrategyRandom ranks the nodes in an order drawn from the request's
+	// seed.
+	StrategyRandom Strategy = "random"
+)
+
+// Strategies lists the valid strategies.
+var Strategies = []Strategy{StrategySpread, StrategyBinpack, StrategyRandom}
+
+// ParseStrategy parses a nodes.scheduling_strategy setting. Empty is spread.
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(strings.ToLower(strings.TrimSpace(s))); st {
+	case "":
+		return StrategySpread, nil
+	case StrategySpread, StrategyBinpack, StrategyRandom:
+		return st, nil
+	}
+	return StrategySpread, fmt.Errorf("invalid scheduling strategy %q: must be spread, binpack or random", s)
+}
+
+// Score rates a node that can take a deployment under the strategy, from 0
+// to 100; higher is better. seed only matters to StrategyRandom, which gives
+// each node a score fixed by the seed and the node's ID.
+func (s Strategy) Score(node domain.Node, required domain.Resources, seed int64) float64 {
+	switch s {
+	case StrategyBinpack:
+		return 100 - ScoreNode(node, required)
+	case StrategyRandom:
+		h := fnv.New64a()
+		h.Write([]byte(strconv.FormatInt(seed, 10)))
+		h.Write([]byte(node.ReferenceID))
+		return float64(h.Sum64()%10001) / 100
+	default:
+		return ScoreNode(node, required)
+	}
+}
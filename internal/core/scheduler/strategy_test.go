@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Strategy Tests
+// =============================================================================
+
+func TestParseStrategy(t *testing.T) {
+	for in, want := range map[string]Strategy{
+		"":         StrategySpread,
+		"spread":   StrategySpread,
+		" BinPack": StrategyBinpack,
+		"random":   StrategyRandom,
+	} {
+		got, err := ParseStrategy(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseStrategy("roundrobin")
+	assert.Error(t, err)
+}
+
+func TestSchedule_Strategies(t *testing.T) {
+	nodes := []domain.Node{
+		makeNodeWithUsage("node_busy", nil, 4, 3, 8192, 6144, 51200, 25600),
+		makeNodeWithUsage("node_idle", nil, 4, 0, 8192, 0, 51200, 0),
+	}
+	required := domain.Resources{CPUCores: 0.5, MemoryMB: 512, DiskMB: 1024}
+
+	result, err := Schedule(ScheduleRequest{AvailableNodes: nodes, RequiredResources: required})
+	require.NoError(t, err)
+	assert.Equal(t, "node_idle", result.SelectedNodeID, "spread is the default")
+
+	result, err = Schedule(ScheduleRequest{AvailableNodes: nodes, RequiredResources: required, Strategy: StrategyBinpack})
+	require.NoError(t, err)
+	assert.Equal(t, "node_busy", result.SelectedNodeID)
+
+	// Binpack never picks a node that can't take the deployment
+	full := makeNodeWithUsage("node_full", nil, 4, 3.9, 8192, 8000, 51200, 51000)
+	result, err = Schedule(ScheduleRequest{AvailableNodes: append(nodes, full), RequiredResources: required, Strategy: StrategyBinpack})
+	require.NoError(t, err)
+	assert.Equal(t, "node_busy", result.SelectedNodeID)
+}
+
+func TestSchedule_RandomStrategy(t *testing.T) {
+	nodes := []domain.Node{
+		makeNodeWithUsage("node_a", nil, 4, 0, 8192, 0, 51200, 0),
+		makeNodeWithUsage("node_b", nil, 4, 0, 8192, 0, 51200, 0),
+		makeNodeWithUsage("node_c", nil, 4, 0, 8192, 0, 51200, 0),
+	}
+	picked := map[string]bool{}
+	for seed := int64(0); seed < 50; seed++ {
+		req := ScheduleRequest{AvailableNodes: nodes, Strategy: StrategyRandom, Seed: seed}
+		first, err := Schedule(req)
+		require.NoError(t, err)
+		again, err := Schedule(req)
+		require.NoError(t, err)
+		assert.Equal(t, first.SelectedNodeID, again.SelectedNodeID, "a seed picks the same node")
+		picked[first.SelectedNodeID] = true
+	}
+	assert.Len(t, picked, 3)
+}
+
+// =============================================================================
+// Location Tests
+// =============================================================================
+
+func TestSchedule_LocationPreferred(t *testing.T) {
+	big := makeNodeWithUsage("node_big", nil, 16, 0, 65536, 0, 512000, 0)
+	big.Location = "us-east"
+	small := makeNodeWithUsage("node_small", nil, 2, 0, 4096, 0, 51200, 0)
+	small.Location = "EU-West"
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes:    []domain.Node{big, small},
+		RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024},
+		Location:          "eu-west",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_small", result.SelectedNodeID)
+	assert.True(t, result.LocationMatched)
+}
+
+func TestSchedule_LocationFallsBack(t *testing.T) {
+	other := makeNodeWithUsage("node_other", nil, 4, 0, 8192, 0, 51200, 0)
+	other.Location = "us-east"
+	full := makeNodeWithUsage("node_full", nil, 4, 4, 8192, 8192, 51200, 51200)
+	full.Location = "eu-west"
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes:    []domain.Node{other, full},
+		RequiredResources: domain.Resources{CPUCores: 1, MemoryMB: 1024},
+		Location:          "eu-west",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_other", result.SelectedNodeID)
+	assert.False(t, result.LocationMatched)
+}
+
+func TestSchedule_AffinityBeforeLocation(t *testing.T) {
+	peer := makeNodeWithUsage("node_peer", nil, 4, 0, 8192, 0, 51200, 0)
+	peer.Location = "us-east"
+	local := makeNodeWithUsage("node_local", nil, 4, 0, 8192, 0, 51200, 0)
+	local.Location = "eu-west"
+
+	result, err := Schedule(ScheduleRequest{
+		AvailableNodes: []domain.Node{peer, local},
+		Affinity:       &Affinity{PeerDeploymentID: "depl_db", PeerNodeID: "node_peer"},
+		Location:       "eu-west",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "node_peer", result.SelectedNodeID)
+	assert.Equal(t, AffinitySatisfied, result.AffinityStatus)
+	assert.False(t, result.LocationMatched)
+}
@@ -0,0 +1,174 @@
+// Package secrets provides pure functions for secret-reference variable values.
+// A variable value such as "vault://secret/data/db#password" is a reference
+// that the server resolves against an external secret manager when it builds
+// the container plan; the secret itself is never stored by Hoster. Templates
+// may also reference a customer's own stored secrets as ${secret:NAME}, which
+// are substituted at the same point.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// References
+// =============================================================================
+
+// Supported reference schemes.
+const (
+	// SchemeVault references a HashiCorp Vault secret: vault://<path>#<key>.
+	// The path is the full API path after /v1/ (e.g. secret/data/db for KV v2).
+	SchemeVault = "vault"
+	// SchemeAWS references an AWS Secrets Manager secret: awssm://<secret id>[#<key>].
+	// Without a key the whole SecretString is used; with a key it must be a JSON object.
+	SchemeAWS = "awssm"
+)
+
+// Schemes lists the supported reference schemes.
+var Schemes = []string{SchemeVault, SchemeAWS}
+
+// ErrInvalidReference is returned for malformed secret references.
+var ErrInvalidReference = errors.New("invalid secret reference")
+
+// ErrKeyNotFound is returned when a referenced key is missing from the secret.
+var ErrKeyNotFound = errors.New("secret key not found")
+
+// Reference points at a value in an external secret manager.
+type Reference struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+// String returns the reference in scheme://path#key form. It contains no secret
+// material and is safe to log, audit, and use as a cache key.
+func (r Reference) String() string {
+	s := r.Scheme + "://" + r.Path
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// IsReference reports whether a variable value uses a secret-reference scheme.
+func IsReference(value string) bool {
+	for _, scheme := range Schemes {
+		if strings.HasPrefix(value, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReference parses a secret-reference value.
+func ParseReference(value string) (Reference, error) {
+	scheme, rest, ok := strings.Cut(value, "://")
+	if !ok || !IsReference(value) {
+		return Reference{}, fmt.Errorf("%w: %q must start with one of %s", ErrInvalidReference, value, schemeList())
+	}
+
+	path, key, _ := strings.Cut(rest, "#")
+	path = strings.Trim(path, "/")
+	ref := Reference{Scheme: scheme, Path: path, Key: key}
+
+	if path == "" {
+		return Reference{}, fmt.Errorf("%w: %q has no path", ErrInvalidReference, value)
+	}
+	if strings.ContainsAny(path, " \t\n?") {
+		return Reference{}, fmt.Errorf("%w: %q has an invalid path", ErrInvalidReference, value)
+	}
+	if scheme == SchemeVault && key == "" {
+		return Reference{}, fmt.Errorf("%w: %q needs a #key", ErrInvalidReference, value)
+	}
+	return ref, nil
+}
+
+// FindReferences returns the secret references among variable values, keyed by
+// variable name. Values that are not references are ignored.
+func FindReferences(vars map[string]string) (map[string]Reference, error) {
+	refs := make(map[string]Reference)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := vars[name]
+		if !IsReference(value) {
+			continue
+		}
+		ref, err := ParseReference(value)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+		refs[name] = ref
+	}
+	return refs, nil
+}
+
+func schemeList() string {
+	parts := make([]string, len(Schemes))
+	for i, s := range Schemes {
+		parts[i] = s + "://"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// =============================================================================
+// Key Selection
+// =============================================================================
+
+// SelectKey returns the value of key in a secret's key/value data.
+// Non-string values are rendered as JSON.
+func SelectKey(data map[string]any, key string) (string, error) {
+	v, ok := data[key]
+	if !ok || v == nil {
+		return "", fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encode secret key %s: %w", key, err)
+	}
+	return string(b), nil
+}
+
+// SelectFromString returns a secret string, or the value of key when the
+// string is a JSON object and a key is given.
+func SelectFromString(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(secret), &data); err != nil {
+		return "", fmt.Errorf("%w: %s (secret is not a JSON object)", ErrKeyNotFound, key)
+	}
+	return SelectKey(data, key)
+}
+
+// =============================================================================
+// Caching
+// =============================================================================
+
+// DefaultCacheTTL is how long a resolved secret is reused before it is fetched again.
+const DefaultCacheTTL = 5 * time.Minute
+
+// CacheEntry is a resolved secret held in memory.
+type CacheEntry struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// Fresh reports whether the entry can still be used at now.
+func (e CacheEntry) Fresh(now time.Time) bool {
+	return now.Before(e.ExpiresAt)
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	ref, err := ParseReference("vault://secret/data/db#password")
+	require.NoError(t, err)
+	assert.Equal(t, Reference{Scheme: SchemeVault, Path: "secret/data/db", Key: "password"}, ref)
+	assert.Equal(t, "vault://secret/data/db#password", ref.String())
+
+	ref, err = ParseReference("awssm://prod/db")
+	require.NoError(t, err)
+	assert.Equal(t, Reference{Scheme: SchemeAWS, Path: "prod/db"}, ref)
+	assert.Equal(t, "awssm://prod/db", ref.String())
+
+	ref, err = ParseReference("awssm://arn:aws:secretsmanager:us-east-1:123:secret:db-AbC#user")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:secretsmanager:us-east-1:123:secret:db-AbC", ref.Path)
+	assert.Equal(t, "user", ref.Key)
+
+	for _, bad := range []string{
+		"plain-password",
+		"https://example.com",
+		"vault://#password",
+		"vault://secret/data/db",
+		"vault://secret/data db#password",
+	} {
+		_, err := ParseReference(bad)
+		assert.ErrorIs(t, err, ErrInvalidReference, bad)
+	}
+}
+
+func TestIsReference(t *testing.T) {
+	assert.True(t, IsReference("vault://a#b"))
+	assert.True(t, IsReference("awssm://a"))
+	assert.False(t, IsReference("hunter2"))
+	assert.False(t, IsReference("s3://bucket/key"))
+}
+
+func TestFindReferences(t *testing.T) {
+	refs, err := FindReferences(map[string]string{
+		"DB_PASSWORD": "vault://secret/data/db#password",
+		"API_KEY":     "awssm://prod/api",
+		"APP_NAME":    "shop",
+	})
+	require.NoError(t, err)
+	assert.Len(t, refs, 2)
+	assert.Equal(t, "password", refs["DB_PASSWORD"].Key)
+	assert.Equal(t, SchemeAWS, refs["API_KEY"].Scheme)
+
+	_, err = FindReferences(map[string]string{"DB_PASSWORD": "vault://secret/data/db"})
+	assert.ErrorIs(t, err, ErrInvalidReference)
+	assert.Contains(t, err.Error(), "DB_PASSWORD")
+}
+
+func TestSelectKey(t *testing.T) {
+	data := map[string]any{"password": "s3cret", "port": float64(5432), "empty": nil}
+
+	v, err := SelectKey(data, "password")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", v)
+
+	v, err = SelectKey(data, "port")
+	require.NoError(t, err)
+	assert.Equal(t, "5432", v)
+
+	_, err = SelectKey(data, "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = SelectKey(data, "empty")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestSelectFromString(t *testing.T) {
+	v, err := SelectFromString("plain", "")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", v)
+
+	v, err = SelectFromString(`{"user":"admin"}`, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", v)
+
+	_, err = SelectFromString("plain", "user")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestCacheEntryFresh(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := CacheEntry{Value: "x", ExpiresAt: now.Add(time.Minute)}
+	assert.True(t, e.Fresh(now))
+	assert.False(t, e.Fresh(now.Add(time.Minute)))
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// =============================================================================
+// Stored Secrets
+// =============================================================================
+
+// PlaceholderPrefix starts the name of a stored secret in a ${secret:NAME}
+// placeholder. The placeholder is substituted like a variable named
+// PlaceholderPrefix+NAME, which no deployment variable can be called.
+const PlaceholderPrefix = "secret:"
+
+// MaxNameLength is the longest stored secret name.
+const MaxNameLength = 64
+
+// MaxValueLength is the largest stored secret value, in bytes.
+const MaxValueLength = 64 << 10
+
+// ErrInvalidName is returned for stored secret names that cannot be used in
+// a placeholder.
+var ErrInvalidName = errors.New("invalid secret name")
+
+var (
+	nameRegex        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderRegex = regexp.MustCompile(`\$\{secret:([A-Za-z_][A-Za-z0-9_]*)(?::-[^}]*)?\}`)
+)
+
+// ValidateName checks a stored secret name: a letter or underscore, then
+// letters, digits and underscores, like a variable name.
+func ValidateName(name string) error {
+	if name == "" || len(name) > MaxNameLength {
+		return fmt.Errorf("%w: must be 1 to %d characters", ErrInvalidName, MaxNameLength)
+	}
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("%w: %q must start with a letter or underscore and contain only letters, digits and underscores", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// FindPlaceholders returns the names of the stored secrets a compose spec
+// references as ${secret:NAME}, sorted and without duplicates.
+func FindPlaceholders(spec string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range placeholderRegex.FindAllStringSubmatch(spec, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PlaceholderVariable returns the variable name a ${secret:NAME} placeholder
+// is substituted from.
+func PlaceholderVariable(name string) string {
+	return PlaceholderPrefix + name
+}
@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, ok := range []string{"DB_PASSWORD", "_token", "a1"} {
+		assert.NoError(t, ValidateName(ok), ok)
+	}
+	for _, bad := range []string{"", "1password", "db-password", "db password", "secret:x", strings.Repeat("a", MaxNameLength+1)} {
+		assert.ErrorIs(t, ValidateName(bad), ErrInvalidName, bad)
+	}
+}
+
+func TestFindPlaceholders(t *testing.T) {
+	spec := `
+services:
+  db:
+    environment:
+      POSTGRES_PASSWORD: ${secret:DB_PASSWORD}
+      POSTGRES_USER: ${DB_USER}
+  app:
+    environment:
+      DATABASE_URL: postgres://app:${secret:DB_PASSWORD}@db/app
+      API_KEY: ${secret:API_KEY:-none}
+      BROKEN: ${secret:}
+`
+	assert.Equal(t, []string{"API_KEY", "DB_PASSWORD"}, FindPlaceholders(spec))
+	assert.Empty(t, FindPlaceholders("services: {}"))
+}
+
+func TestPlaceholderVariable(t *testing.T) {
+	assert.Equal(t, "secret:DB_PASSWORD", PlaceholderVariable("DB_PASSWORD"))
+}
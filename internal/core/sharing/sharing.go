@@ -0,0 +1,178 @@
+// Package sharing provides pure functions for deployment collaborators: the
+// roles a deployment can be shared with, what each role may do, invitation
+// tokens, and rendering the invitation email.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Roles
+// =============================================================================
+
+// Role is a user's level of access to a deployment.
+type Role string
+
+const (
+	// RoleOwner is the customer who created the deployment.
+	RoleOwner Role = "owner"
+	// RoleOperator can view a deployment and start or stop it.
+	RoleOperator Role = "operator"
+	// RoleViewer can view a deployment's status, health, stats, logs and events.
+	RoleViewer Role = "viewer"
+	// RoleNone has no access.
+	RoleNone Role = ""
+)
+
+// Permission is an operation on a deployment that a role may be granted.
+type Permission string
+
+const (
+	// PermView reads a deployment and its monitoring data.
+	PermView Permission = "view"
+	// PermOperate starts and stops a deployment.
+	PermOperate Permission = "operate"
+	// PermManage changes configuration, domains, upgrades, storage and sharing.
+	PermManage Permission = "manage"
+)
+
+// rolePermissions lists what each role may do. Only the owner manages.
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:    {PermView, PermOperate, PermManage},
+	RoleOperator: {PermView, PermOperate},
+	RoleViewer:   {PermView},
+}
+
+// CollaboratorRoles are the roles a deployment can be shared with.
+var CollaboratorRoles = []Role{RoleViewer, RoleOperator}
+
+// ParseCollaboratorRole returns the role named s, which must be one a
+// deployment can be shared with.
+func ParseCollaboratorRole(s string) (Role, error) {
+	for _, r := range CollaboratorRoles {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("role must be one of viewer, operator")
+}
+
+// Can reports whether the role grants p.
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Invitations
+// =============================================================================
+
+// Invitation statuses.
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+)
+
+// InvitationTTL is how long an invitation can be accepted.
+const InvitationTTL = 7 * 24 * time.Hour
+
+// TokenBytes is how many random bytes an invitation token encodes.
+const TokenBytes = 32
+
+// NewToken encodes random bytes as an invitation token. Only its hash is
+// stored; the token itself is sent to the invitee.
+func NewToken(random []byte) (string, error) {
+	if len(random) < TokenBytes {
+		return "", fmt.Errorf("invitation token needs %d random bytes, got %d", TokenBytes, len(random))
+	}
+	return base64.RawURLEncoding.EncodeToString(random[:TokenBytes]), nil
+}
+
+// HashToken returns the stored form of an invitation token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether an invitation expiring at expiresAt has lapsed at now.
+func Expired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// NormalizeEmail checks an invitee address and returns it lowercased without
+// a display name.
+func NormalizeEmail(s string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || addr.Name != "" {
+		return "", fmt.Errorf("email must be a plain address like name@example.com")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// AcceptURL returns the web UI link that accepts an invitation, or "" without
+// an app URL.
+func AcceptURL(appURL, token string) string {
+	appURL = strings.TrimRight(appURL, "/")
+	if appURL == "" {
+		return ""
+	}
+	return appURL + "/invitations/accept?token=" + url.QueryEscape(token)
+}
+
+// =============================================================================
+// Email
+// =============================================================================
+
+// InvitationEmail is the message inviting someone to a deployment.
+type InvitationEmail struct {
+	Subject string
+	Body    string
+}
+
+// RenderInvitation renders the invitation email for a deployment.
+func RenderInvitation(deploymentName string, role Role, acceptURL, token string, expiresAt time.Time) InvitationEmail {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You have been invited to collaborate on the deployment %q as %s.\n\n", deploymentName, anArticle(string(role)))
+	b.WriteString(roleSummary(role))
+	b.WriteString("\n\n")
+	if acceptURL != "" {
+		fmt.Fprintf(&b, "Accept the invitation:\n%s\n\n", acceptURL)
+	} else {
+		fmt.Fprintf(&b, "Accept the invitation with this token:\n%s\n\n", token)
+	}
+	fmt.Fprintf(&b, "The invitation expires on %s. If you were not expecting it, you can ignore this email.\n",
+		expiresAt.UTC().Format("January 2, 2006 15:04 MST"))
+	return InvitationEmail{
+		Subject: fmt.Sprintf("Invitation to deployment %s", deploymentName),
+		Body:    b.String(),
+	}
+}
+
+func roleSummary(role Role) string {
+	switch role {
+	case RoleOperator:
+		return "Operators can view the deployment's health, stats, logs and events, and start or stop it."
+	default:
+		return "Viewers can see the deployment's health, stats, logs and events."
+	}
+}
+
+func anArticle(word string) string {
+	if word != "" && strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an " + word
+	}
+	return "a " + word
+}
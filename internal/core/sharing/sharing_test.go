@@ -0,0 +1,118 @@
+package sharing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Role Tests
+// =============================================================================
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		role    Role
+		view    bool
+		operate bool
+		manage  bool
+	}{
+		{RoleOwner, true, true, true},
+		{RoleOperator, true, true, false},
+		{RoleViewer, true, false, false},
+		{RoleNone, false, false, false},
+		{Role("admin"), false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			assert.Equal(t, tt.view, tt.role.Can(PermView))
+			assert.Equal(t, tt.operate, tt.role.Can(PermOperate))
+			assert.Equal(t, tt.manage, tt.role.Can(PermManage))
+		})
+	}
+}
+
+func TestParseCollaboratorRole(t *testing.T) {
+	r, err := ParseCollaboratorRole("viewer")
+	require.NoError(t, err)
+	assert.Equal(t, RoleViewer, r)
+
+	r, err = ParseCollaboratorRole("operator")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOperator, r)
+
+	for _, s := range []string{"owner", "", "Viewer", "admin"} {
+		_, err := ParseCollaboratorRole(s)
+		assert.Error(t, err, s)
+	}
+}
+
+// =============================================================================
+// Invitation Tests
+// =============================================================================
+
+func TestNewToken(t *testing.T) {
+	random := bytes.Repeat([]byte{0xab}, TokenBytes)
+	token, err := NewToken(random)
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+	assert.NotContains(t, token, "=")
+
+	_, err = NewToken(random[:TokenBytes-1])
+	assert.Error(t, err)
+}
+
+func TestHashToken(t *testing.T) {
+	h := HashToken("abc")
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", h)
+	assert.NotEqual(t, h, HashToken("abd"))
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.False(t, Expired(now.Add(time.Minute), now))
+	assert.True(t, Expired(now, now))
+	assert.True(t, Expired(now.Add(-time.Minute), now))
+	assert.False(t, Expired(time.Time{}, now), "no expiry")
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	got, err := NormalizeEmail("  Alice@Example.COM ")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", got)
+
+	for _, s := range []string{"", "alice", "Alice <alice@example.com>", "a@b@c"} {
+		_, err := NormalizeEmail(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAcceptURL(t *testing.T) {
+	assert.Equal(t, "https://app.example.com/invitations/accept?token=a%2Bb",
+		AcceptURL("https://app.example.com/", "a+b"))
+	assert.Empty(t, AcceptURL("", "abc"))
+}
+
+// =============================================================================
+// Email Tests
+// =============================================================================
+
+func TestRenderInvitation(t *testing.T) {
+	expires := time.Date(2025, 3, 8, 12, 0, 0, 0, time.UTC)
+
+	e := RenderInvitation("blog", RoleOperator, "https://app.example.com/invitations/accept?token=t0k", "t0k", expires)
+	assert.Equal(t, "Invitation to deployment blog", e.Subject)
+	assert.Contains(t, e.Body, `deployment "blog" as an operator`)
+	assert.Contains(t, e.Body, "start or stop it")
+	assert.Contains(t, e.Body, "https://app.example.com/invitations/accept?token=t0k")
+	assert.Contains(t, e.Body, "March 8, 2025 12:00 UTC")
+
+	e = RenderInvitation("blog", RoleViewer, "", "t0k", expires)
+	assert.Contains(t, e.Body, "as a viewer")
+	assert.NotContains(t, e.Body, "start or stop")
+	assert.True(t, strings.Contains(e.Body, "with this token:\nt0k\n"))
+}
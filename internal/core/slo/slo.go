@@ -0,0 +1,303 @@
+// Package slo provides pure functions for service level objectives that
+// templates declare for their deployments: an availability target and an
+// optional latency threshold on the routed endpoint. It covers the proxy's
+// response counts, compliance and error budgets over a window, and
+// burn-rate alerts.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package slo
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// =============================================================================
+// Objectives
+// =============================================================================
+
+// Objective is what a template promises about each of its deployments.
+type Objective struct {
+	// Availability is the target percentage of passed uptime checks and of
+	// requests served without a server error, e.g. 99.9.
+	Availability float64 `json:"availability"`
+	// LatencyThreshold is the time to first byte requests should stay
+	// within. Zero means the template has no latency objective.
+	LatencyThreshold time.Duration `json:"-"`
+	// LatencyTarget is the target percentage of requests within the
+	// threshold.
+	LatencyTarget float64 `json:"latency_target,omitempty"`
+	// WindowDays is the rolling window compliance is measured over.
+	WindowDays int `json:"window_days"`
+}
+
+// Window limits, in days.
+const (
+	DefaultWindowDays = 30
+	MaxWindowDays     = 90
+)
+
+// LatencyBuckets are the bounds the proxy counts response latencies in. A
+// latency threshold must be one of them.
+var LatencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ValidThreshold reports whether d is one of the LatencyBuckets.
+func ValidThreshold(d time.Duration) bool {
+	return slices.Contains(LatencyBuckets, d)
+}
+
+// Window returns the objective's compliance window.
+func (o Objective) Window() time.Duration {
+	days := o.WindowDays
+	if days == 0 {
+		days = DefaultWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// HasLatency reports whether the objective includes a latency target.
+func (o Objective) HasLatency() bool {
+	return o.LatencyThreshold > 0
+}
+
+// ValidateTarget checks a target percentage. 100 is rejected: it leaves no
+// error budget to track.
+func ValidateTarget(percent float64) error {
+	if percent <= 0 || percent >= 100 {
+		return fmt.Errorf("target must be above 0 and below 100, got %g", percent)
+	}
+	return nil
+}
+
+// =============================================================================
+// Traffic
+// =============================================================================
+
+// Traffic counts the responses the proxy served for one deployment.
+type Traffic struct {
+	Requests int64
+	// Errors counts server errors (5xx).
+	Errors int64
+	// Latency counts responses by time to first byte. Latency[i] counts the
+	// responses within LatencyBuckets[i] but slower than the bucket before;
+	// the last element counts those slower than every bucket.
+	Latency []int64
+}
+
+// Record counts a response with the given status and time to first byte.
+func (t *Traffic) Record(status int, latency time.Duration) {
+	if t.Latency == nil {
+		t.Latency = make([]int64, len(LatencyBuckets)+1)
+	}
+	t.Requests++
+	if status >= 500 {
+		t.Errors++
+	}
+	i, _ := slices.BinarySearch(LatencyBuckets, latency)
+	t.Latency[i]++
+}
+
+// SlowerThan returns how many responses took longer than threshold, which
+// must be one of the LatencyBuckets.
+func (t Traffic) SlowerThan(threshold time.Duration) int64 {
+	i, _ := slices.BinarySearch(LatencyBuckets, threshold)
+	var slow int64
+	for _, n := range t.Latency[min(i+1, len(t.Latency)):] {
+		slow += n
+	}
+	return slow
+}
+
+// =============================================================================
+// Compliance
+// =============================================================================
+
+// Counts are a deployment's measurements over a period.
+type Counts struct {
+	// Checks counts uptime checks of the routed service, and FailedChecks
+	// those that found it unhealthy or could not reach its node.
+	Checks       int64 `json:"checks" db:"checks"`
+	FailedChecks int64 `json:"failed_checks" db:"failed_checks"`
+	// Requests, Errors and Slow come from the proxy: responses, server
+	// errors, and responses slower than the latency threshold.
+	Requests int64 `json:"requests" db:"requests"`
+	Errors   int64 `json:"errors" db:"errors"`
+	Slow     int64 `json:"slow" db:"slow"`
+}
+
+// Add returns the sum of two sets of counts.
+func (c Counts) Add(o Counts) Counts {
+	c.Checks += o.Checks
+	c.FailedChecks += o.FailedChecks
+	c.Requests += o.Requests
+	c.Errors += o.Errors
+	c.Slow += o.Slow
+	return c
+}
+
+// Compliance is how an objective fared over a period.
+type Compliance struct {
+	// Target and Achieved are percentages. A period without measurements
+	// achieves 100.
+	Target   float64 `json:"target"`
+	Achieved float64 `json:"achieved"`
+	Met      bool    `json:"met"`
+	// BudgetRemaining is the fraction of the error budget (100 - Target)
+	// left. It is negative once the budget is overspent.
+	BudgetRemaining float64 `json:"error_budget_remaining"`
+}
+
+func compliance(target, achieved float64) Compliance {
+	return Compliance{
+		Target:          target,
+		Achieved:        achieved,
+		Met:             achieved >= target,
+		BudgetRemaining: 1 - BurnRate(target, achieved),
+	}
+}
+
+// Availability returns the availability achieved by the counts: the lower
+// of the share of passed uptime checks and the share of requests served
+// without a server error.
+func Availability(o Objective, c Counts) Compliance {
+	return compliance(o.Availability, min(percent(c.Checks-c.FailedChecks, c.Checks), percent(c.Requests-c.Errors, c.Requests)))
+}
+
+// Latency returns the share of requests within the latency threshold.
+func Latency(o Objective, c Counts) Compliance {
+	return compliance(o.LatencyTarget, percent(c.Requests-c.Slow, c.Requests))
+}
+
+func percent(good, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(good) / float64(total)
+}
+
+// BurnRate returns how fast the error budget is spent: 1 spends exactly the
+// budget over the window, 2 spends it in half the window.
+func BurnRate(target, achieved float64) float64 {
+	return (100 - achieved) / (100 - target)
+}
+
+// =============================================================================
+// Burn-Rate Alerts
+// =============================================================================
+
+// AlertLevel is how urgent a burn-rate alert is. The empty level means no
+// alert.
+type AlertLevel string
+
+const (
+	AlertNone     AlertLevel = ""
+	AlertWarning  AlertLevel = "warning"
+	AlertCritical AlertLevel = "critical"
+)
+
+// Burn-rate alert policy. A fast burn spends 2% of a 30-day budget in an
+// hour and is critical; a slow burn spends 5% in six hours and is a warning.
+const (
+	FastBurnWindow = time.Hour
+	FastBurnRate   = 14.4
+	SlowBurnWindow = 6 * time.Hour
+	SlowBurnRate   = 6.0
+
+	// MinAlertRequests is how many requests a window needs before its error
+	// and latency shares can raise an alert, so a handful of failed requests
+	// to an idle app does not.
+	MinAlertRequests = 20
+)
+
+// Burn is the burn rates of one objective over the alert windows.
+type Burn struct {
+	Fast float64 `json:"burn_rate_1h"`
+	Slow float64 `json:"burn_rate_6h"`
+}
+
+// Level returns the alert the burn rates call for.
+func (b Burn) Level() AlertLevel {
+	switch {
+	case b.Fast >= FastBurnRate:
+		return AlertCritical
+	case b.Slow >= SlowBurnRate:
+		return AlertWarning
+	default:
+		return AlertNone
+	}
+}
+
+// alertCounts drops the request counts of a window with too few requests.
+func alertCounts(c Counts) Counts {
+	if c.Requests < MinAlertRequests {
+		c.Requests, c.Errors, c.Slow = 0, 0, 0
+	}
+	return c
+}
+
+// AvailabilityBurn returns the availability burn rates given the counts of
+// the fast and slow alert windows.
+func AvailabilityBurn(o Objective, fast, slow Counts) Burn {
+	return Burn{
+		Fast: BurnRate(o.Availability, Availability(o, alertCounts(fast)).Achieved),
+		Slow: BurnRate(o.Availability, Availability(o, alertCounts(slow)).Achieved),
+	}
+}
+
+// LatencyBurn returns the latency burn rates given the counts of the fast
+// and slow alert windows.
+func LatencyBurn(o Objective, fast, slow Counts) Burn {
+	return Burn{
+		Fast: BurnRate(o.LatencyTarget, Latency(o, alertCounts(fast)).Achieved),
+		Slow: BurnRate(o.LatencyTarget, Latency(o, alertCounts(slow)).Achieved),
+	}
+}
+
+// Alerts is the alert level of each objective of a deployment, keyed by
+// "availability" and "latency".
+type Alerts map[string]AlertLevel
+
+// Raised returns the objectives whose level in next is above their level in
+// prev. These are the ones to notify about; a level that falls or stays is
+// not news.
+func Raised(prev, next Alerts) []string {
+	var raised []string
+	for _, name := range []string{"availability", "latency"} {
+		if rank(next[name]) > rank(prev[name]) {
+			raised = append(raised, name)
+		}
+	}
+	return raised
+}
+
+func rank(l AlertLevel) int {
+	switch l {
+	case AlertCritical:
+		return 2
+	case AlertWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// =============================================================================
+// Samples
+// =============================================================================
+
+// SamplePeriod is the span of time one stored sample of counts covers.
+const SamplePeriod = 5 * time.Minute
+
+// PeriodStart returns the start of the sample period t falls in.
+func PeriodStart(t time.Time) time.Time {
+	return t.UTC().Truncate(SamplePeriod)
+}
@@ -0,0 +1,124 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Objective Tests
+// =============================================================================
+
+func TestObjectiveWindow(t *testing.T) {
+	assert.Equal(t, 30*24*time.Hour, Objective{}.Window())
+	assert.Equal(t, 7*24*time.Hour, Objective{WindowDays: 7}.Window())
+}
+
+func TestValidateTarget(t *testing.T) {
+	assert.NoError(t, ValidateTarget(99.9))
+	for _, p := range []float64{0, -1, 100, 101} {
+		assert.Error(t, ValidateTarget(p), p)
+	}
+}
+
+func TestValidThreshold(t *testing.T) {
+	assert.True(t, ValidThreshold(500*time.Millisecond))
+	assert.False(t, ValidThreshold(300*time.Millisecond))
+}
+
+// =============================================================================
+// Traffic Tests
+// =============================================================================
+
+func TestTrafficRecord(t *testing.T) {
+	var tr Traffic
+	tr.Record(200, 40*time.Millisecond)
+	tr.Record(200, 500*time.Millisecond)
+	tr.Record(502, 700*time.Millisecond)
+	tr.Record(200, time.Minute)
+
+	assert.Equal(t, int64(4), tr.Requests)
+	assert.Equal(t, int64(1), tr.Errors)
+	assert.Equal(t, int64(2), tr.SlowerThan(500*time.Millisecond), "a latency on the bound is within it")
+	assert.Equal(t, int64(1), tr.SlowerThan(10*time.Second))
+	assert.Equal(t, int64(0), Traffic{}.SlowerThan(time.Second))
+}
+
+// =============================================================================
+// Compliance Tests
+// =============================================================================
+
+func TestAvailability(t *testing.T) {
+	o := Objective{Availability: 99}
+
+	c := Availability(o, Counts{})
+	assert.Equal(t, 100.0, c.Achieved, "no data meets the objective")
+	assert.True(t, c.Met)
+	assert.InDelta(t, 1, c.BudgetRemaining, 1e-9)
+
+	// 1% failed checks spends the whole budget; requests are fine
+	c = Availability(o, Counts{Checks: 100, FailedChecks: 1, Requests: 1000})
+	assert.InDelta(t, 99, c.Achieved, 1e-9)
+	assert.True(t, c.Met)
+	assert.InDelta(t, 0, c.BudgetRemaining, 1e-9)
+
+	// The worse of checks and requests counts
+	c = Availability(o, Counts{Checks: 100, Requests: 100, Errors: 2})
+	assert.InDelta(t, 98, c.Achieved, 1e-9)
+	assert.False(t, c.Met)
+	assert.InDelta(t, -1, c.BudgetRemaining, 1e-9)
+}
+
+func TestLatency(t *testing.T) {
+	o := Objective{LatencyThreshold: time.Second, LatencyTarget: 95}
+	c := Latency(o, Counts{Requests: 100, Slow: 2})
+	assert.InDelta(t, 98, c.Achieved, 1e-9)
+	assert.True(t, c.Met)
+	assert.InDelta(t, 0.6, c.BudgetRemaining, 1e-9)
+}
+
+// =============================================================================
+// Alert Tests
+// =============================================================================
+
+func TestBurnLevel(t *testing.T) {
+	assert.Equal(t, AlertCritical, Burn{Fast: 20, Slow: 3}.Level())
+	assert.Equal(t, AlertWarning, Burn{Fast: 10, Slow: 6}.Level())
+	assert.Equal(t, AlertNone, Burn{Fast: 10, Slow: 2}.Level())
+}
+
+func TestAvailabilityBurn(t *testing.T) {
+	o := Objective{Availability: 99.9}
+
+	// One failed check an hour burns 16x on a 99.9% target
+	b := AvailabilityBurn(o, Counts{Checks: 60, FailedChecks: 1}, Counts{Checks: 360, FailedChecks: 1})
+	assert.InDelta(t, 16.67, b.Fast, 0.01)
+	assert.Equal(t, AlertCritical, b.Level())
+
+	// A few errors from an idle app do not raise an alert
+	b = AvailabilityBurn(o, Counts{Checks: 60, Requests: 3, Errors: 3}, Counts{Checks: 360, Requests: 3, Errors: 3})
+	assert.Equal(t, AlertNone, b.Level())
+}
+
+func TestLatencyBurn(t *testing.T) {
+	o := Objective{LatencyThreshold: time.Second, LatencyTarget: 99}
+	b := LatencyBurn(o, Counts{Requests: 100, Slow: 5}, Counts{Requests: 600, Slow: 42})
+	assert.InDelta(t, 5, b.Fast, 1e-9)
+	assert.InDelta(t, 7, b.Slow, 1e-9)
+	assert.Equal(t, AlertWarning, b.Level())
+}
+
+func TestRaised(t *testing.T) {
+	prev := Alerts{"availability": AlertWarning}
+	assert.Equal(t, []string{"availability", "latency"},
+		Raised(prev, Alerts{"availability": AlertCritical, "latency": AlertWarning}))
+	assert.Empty(t, Raised(prev, Alerts{"availability": AlertWarning}))
+	assert.Empty(t, Raised(prev, Alerts{}))
+}
+
+func TestPeriodStart(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 7, 31, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC), PeriodStart(at))
+}
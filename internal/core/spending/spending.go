@@ -0,0 +1,171 @@
+// Package spending provides pure functions for account spending limits:
+// pricing an account's metered usage, comparing its month's spend with its
+// limit, deciding when to alert, and whether a hard stop blocks new metered
+// activity.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package spending
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Limits
+// =============================================================================
+
+// DefaultAlertPercent is the share of the limit an account is alerted at
+// unless it chooses another.
+const DefaultAlertPercent = 80
+
+// ErrLimitReached rejects new metered activity of an account whose hard stop
+// has been reached.
+var ErrLimitReached = errors.New("spending limit reached")
+
+// Limit is an account's monthly spending limit.
+type Limit struct {
+	MonthlyCents int64
+	AlertPercent int  // Share of MonthlyCents that raises an alert, 1-99
+	HardStop     bool // Block new metered activity once MonthlyCents is reached
+}
+
+// Validate checks a limit set by its account.
+func (l Limit) Validate() error {
+	if l.MonthlyCents <= 0 {
+		return errors.New("monthly_limit_cents must be positive")
+	}
+	if l.AlertPercent < 1 || l.AlertPercent > 99 {
+		return fmt.Errorf("alert_percent must be between 1 and 99, got %d", l.AlertPercent)
+	}
+	return nil
+}
+
+// =============================================================================
+// Prices
+// =============================================================================
+
+// Prices are the cents charged per unit of quantity of each metered usage
+// event type, such as "gpu.usage" (GPU-hours). Event types without a price
+// cost nothing.
+type Prices map[string]int64
+
+// ParsePrices parses "event_type=cents" entries.
+func ParsePrices(entries []string) (Prices, error) {
+	prices := Prices{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		typ, cents, ok := strings.Cut(e, "=")
+		typ = strings.TrimSpace(typ)
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("invalid usage price %q: want event_type=cents", e)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(cents), 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid usage price %q: cents must be a non-negative integer", e)
+		}
+		if _, dup := prices[typ]; dup {
+			return nil, fmt.Errorf("usage price for %s listed twice", typ)
+		}
+		prices[typ] = n
+	}
+	return prices, nil
+}
+
+// Usage is an account's total quantity of one usage event type.
+type Usage struct {
+	EventType string
+	Quantity  int64
+}
+
+// MeteredCents prices an account's usage.
+func MeteredCents(usage []Usage, prices Prices) int64 {
+	var total int64
+	for _, u := range usage {
+		total += u.Quantity * prices[u.EventType]
+	}
+	return total
+}
+
+// =============================================================================
+// Evaluation
+// =============================================================================
+
+// Level is how an account's spend compares with its limit.
+type Level string
+
+const (
+	LevelOK      Level = "ok"
+	LevelAlert   Level = "alert"   // At or over the alert share
+	LevelReached Level = "reached" // At or over the limit
+)
+
+// rank orders levels for comparison.
+var rank = map[Level]int{LevelOK: 0, LevelAlert: 1, LevelReached: 2}
+
+// Spend is an account's spend in one month.
+type Spend struct {
+	SubscriptionCents int64 // Monthly prices of its running deployments
+	MeteredCents      int64 // Priced metered usage
+}
+
+// TotalCents is the month's whole spend.
+func (s Spend) TotalCents() int64 {
+	return s.SubscriptionCents + s.MeteredCents
+}
+
+// Evaluate returns the level a spend is at.
+func Evaluate(spentCents int64, l Limit) Level {
+	switch {
+	case l.MonthlyCents <= 0:
+		return LevelOK
+	case spentCents >= l.MonthlyCents:
+		return LevelReached
+	case spentCents*100 >= l.MonthlyCents*int64(l.AlertPercent):
+		return LevelAlert
+	}
+	return LevelOK
+}
+
+// Percent returns the share of the limit spent, rounded down.
+func Percent(spentCents int64, l Limit) int {
+	if l.MonthlyCents <= 0 {
+		return 0
+	}
+	return int(spentCents * 100 / l.MonthlyCents)
+}
+
+// ShouldAlert reports whether reaching level needs an alert, given the
+// highest level already alerted in the month. Each level is alerted once a
+// month; a new month starts over.
+func ShouldAlert(level Level, alerted Level, alertedMonth, month string) bool {
+	if level == LevelOK {
+		return false
+	}
+	if alertedMonth != month {
+		return true
+	}
+	return rank[level] > rank[alerted]
+}
+
+// Override lets a trusted account past its hard stop, until a time or,
+// with a zero Until, indefinitely.
+type Override struct {
+	Active bool
+	Until  time.Time
+}
+
+// Covers reports whether the override is in force at now.
+func (o Override) Covers(now time.Time) bool {
+	return o.Active && (o.Until.IsZero() || now.Before(o.Until))
+}
+
+// Blocks reports whether an account's hard stop blocks new metered activity.
+func Blocks(level Level, l Limit, o Override, now time.Time) bool {
+	return level == LevelReached && l.HardStop && !o.Covers(now)
+}
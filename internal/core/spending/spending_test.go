@@ -0,0 +1,97 @@
+package spending
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Limit Tests
+// =============================================================================
+
+func TestLimitValidate(t *testing.T) {
+	assert.NoError(t, Limit{MonthlyCents: 5000, AlertPercent: DefaultAlertPercent}.Validate())
+	assert.Error(t, Limit{MonthlyCents: 0, AlertPercent: 80}.Validate())
+	assert.Error(t, Limit{MonthlyCents: 5000, AlertPercent: 0}.Validate())
+	assert.Error(t, Limit{MonthlyCents: 5000, AlertPercent: 100}.Validate())
+}
+
+// =============================================================================
+// Price Tests
+// =============================================================================
+
+func TestParsePrices(t *testing.T) {
+	prices, err := ParsePrices([]string{"gpu.usage=150", " storage.usage = 1 ", ""})
+	require.NoError(t, err)
+	assert.Equal(t, Prices{"gpu.usage": 150, "storage.usage": 1}, prices)
+
+	for _, bad := range [][]string{
+		{"gpu.usage"},
+		{"=5"},
+		{"gpu.usage=-1"},
+		{"gpu.usage=1.5"},
+		{"gpu.usage=1", "gpu.usage=2"},
+	} {
+		_, err := ParsePrices(bad)
+		assert.Error(t, err, "%v", bad)
+	}
+}
+
+func TestMeteredCents(t *testing.T) {
+	usage := []Usage{
+		{EventType: "gpu.usage", Quantity: 3},
+		{EventType: "storage.usage", Quantity: 200},
+		{EventType: "api.request", Quantity: 10000},
+	}
+	prices := Prices{"gpu.usage": 150, "storage.usage": 1}
+	assert.Equal(t, int64(650), MeteredCents(usage, prices), "unpriced events cost nothing")
+	assert.Zero(t, MeteredCents(nil, prices))
+}
+
+// =============================================================================
+// Evaluation Tests
+// =============================================================================
+
+func TestEvaluate(t *testing.T) {
+	l := Limit{MonthlyCents: 10000, AlertPercent: 80}
+	assert.Equal(t, LevelOK, Evaluate(7999, l))
+	assert.Equal(t, LevelAlert, Evaluate(8000, l))
+	assert.Equal(t, LevelAlert, Evaluate(9999, l))
+	assert.Equal(t, LevelReached, Evaluate(10000, l))
+	assert.Equal(t, LevelReached, Evaluate(25000, l))
+	assert.Equal(t, LevelOK, Evaluate(25000, Limit{}), "no limit")
+
+	assert.Equal(t, 80, Percent(8050, l))
+	assert.Equal(t, 250, Percent(25000, l))
+	assert.Zero(t, Percent(25000, Limit{}))
+}
+
+func TestSpendTotal(t *testing.T) {
+	assert.Equal(t, int64(1650), Spend{SubscriptionCents: 1000, MeteredCents: 650}.TotalCents())
+}
+
+func TestShouldAlert(t *testing.T) {
+	assert.False(t, ShouldAlert(LevelOK, "", "", "2026-10"))
+	assert.True(t, ShouldAlert(LevelAlert, "", "", "2026-10"))
+	assert.False(t, ShouldAlert(LevelAlert, LevelAlert, "2026-10", "2026-10"), "alerted once a month")
+	assert.True(t, ShouldAlert(LevelReached, LevelAlert, "2026-10", "2026-10"), "reaching the limit alerts again")
+	assert.False(t, ShouldAlert(LevelAlert, LevelReached, "2026-10", "2026-10"))
+	assert.True(t, ShouldAlert(LevelAlert, LevelReached, "2026-09", "2026-10"), "a new month starts over")
+}
+
+func TestBlocks(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	hard := Limit{MonthlyCents: 10000, AlertPercent: 80, HardStop: true}
+	soft := Limit{MonthlyCents: 10000, AlertPercent: 80}
+
+	assert.True(t, Blocks(LevelReached, hard, Override{}, now))
+	assert.False(t, Blocks(LevelAlert, hard, Override{}, now))
+	assert.False(t, Blocks(LevelReached, soft, Override{}, now), "alerts only without a hard stop")
+
+	assert.False(t, Blocks(LevelReached, hard, Override{Active: true}, now), "trusted indefinitely")
+	assert.False(t, Blocks(LevelReached, hard, Override{Active: true, Until: now.Add(time.Hour)}, now))
+	assert.True(t, Blocks(LevelReached, hard, Override{Active: true, Until: now}, now), "override expired")
+}
@@ -0,0 +1,224 @@
+// Package stats provides pure functions for daily statistics rollups: day
+// keys and date ranges, summarizing a day of node samples, and totalling
+// daily rows for dashboards.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// =============================================================================
+// Days
+// =============================================================================
+
+// DayLayout is the format of day keys (UTC).
+const DayLayout = "2006-01-02"
+
+const (
+	// DefaultRangeDays is the range a dashboard shows when none is given.
+	DefaultRangeDays = 30
+	// MaxRangeDays bounds the range of one query.
+	MaxRangeDays = 366
+	// MaxRollupDays bounds the range of one on-demand rollup.
+	MaxRollupDays = 92
+	// BackfillDays is how far back the rollup worker catches up after
+	// downtime.
+	BackfillDays = 7
+)
+
+// Day returns the day key of t.
+func Day(t time.Time) string {
+	return t.UTC().Format(DayLayout)
+}
+
+// ParseDay parses a day key.
+func ParseDay(s string) (time.Time, error) {
+	t, err := time.Parse(DayLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid day %q: want YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// ParseRange parses an inclusive from/to range of day keys. An empty to is
+// today; an empty from is DefaultRangeDays up to to. Ranges longer than
+// maxDays are rejected.
+func ParseRange(from, to string, now time.Time, maxDays int) (first, last string, err error) {
+	end := now.UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		if end, err = ParseDay(to); err != nil {
+			return "", "", err
+		}
+	}
+	start := end.AddDate(0, 0, -(DefaultRangeDays - 1))
+	if from != "" {
+		if start, err = ParseDay(from); err != nil {
+			return "", "", err
+		}
+	}
+	if start.After(end) {
+		return "", "", fmt.Errorf("from %s is after to %s", Day(start), Day(end))
+	}
+	if days := int(end.Sub(start)/(24*time.Hour)) + 1; days > maxDays {
+		return "", "", fmt.Errorf("range of %d days is longer than %d", days, maxDays)
+	}
+	return Day(start), Day(end), nil
+}
+
+// Days lists the day keys from first to last inclusive.
+func Days(first, last string) []string {
+	start, err := ParseDay(first)
+	if err != nil {
+		return nil
+	}
+	end, err := ParseDay(last)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		out = append(out, Day(d))
+	}
+	return out
+}
+
+// Final reports whether a rollup computed at computedAt covers the whole
+// day, so it never needs computing again.
+func Final(day string, computedAt time.Time) bool {
+	start, err := ParseDay(day)
+	if err != nil {
+		return false
+	}
+	return !computedAt.Before(start.AddDate(0, 0, 1))
+}
+
+// Snapshot reports whether a rollup of day at now may record point-in-time
+// counts such as deployments by status. Only today and yesterday qualify:
+// current counts say nothing about older days, so recomputing those keeps
+// the counts recorded at the time.
+func Snapshot(day string, now time.Time) bool {
+	today := Day(now)
+	return day == today || day == Day(now.UTC().AddDate(0, 0, -1))
+}
+
+// =============================================================================
+// Daily Rows
+// =============================================================================
+
+// UsageTotal is a day's usage events of one type.
+type UsageTotal struct {
+	Events   int64 `json:"events"`
+	Quantity int64 `json:"quantity"`
+}
+
+// DeploymentStats is one day of deployment statistics, platform-wide or for
+// one creator's templates.
+type DeploymentStats struct {
+	Day                 string                `json:"day"`
+	DeploymentsByStatus map[string]int64      `json:"deployments_by_status,omitempty"` // Snapshot; see Snapshot
+	DeploymentsCreated  int64                 `json:"deployments_created"`
+	NewCustomers        int64                 `json:"new_customers"`
+	Usage               map[string]UsageTotal `json:"usage,omitempty"` // By event type; platform only
+	GrossCents          int64                 `json:"gross_cents"`
+	NetCents            int64                 `json:"net_cents"`
+	ComputedAt          time.Time             `json:"computed_at"`
+}
+
+// NodeSample is the part of a node metrics sample kept in the rollup.
+type NodeSample struct {
+	CPUPercent     float64
+	MemoryUsedMB   int64
+	MemoryTotalMB  int64
+	DiskMaxPercent float64
+}
+
+// NodeStats is one day of a node's utilization.
+type NodeStats struct {
+	Day              string    `json:"day"`
+	NodeID           string    `json:"node_id"`
+	Samples          int64     `json:"samples"`
+	CPUAvgPercent    float64   `json:"cpu_avg_percent"`
+	CPUMaxPercent    float64   `json:"cpu_max_percent"`
+	MemoryAvgPercent float64   `json:"memory_avg_percent"`
+	MemoryMaxPercent float64   `json:"memory_max_percent"`
+	DiskMaxPercent   float64   `json:"disk_max_percent"`
+	Deployments      *int64    `json:"deployments,omitempty"` // Running deployments; snapshot
+	ComputedAt       time.Time `json:"computed_at"`
+}
+
+// SummarizeNode fills the utilization of a day from its samples. Samples
+// without a memory total count towards CPU and disk only.
+func SummarizeNode(day string, samples []NodeSample) NodeStats {
+	s := NodeStats{Day: day, Samples: int64(len(samples))}
+	if len(samples) == 0 {
+		return s
+	}
+	var cpuSum, memSum float64
+	var memSamples int
+	for _, x := range samples {
+		cpuSum += x.CPUPercent
+		s.CPUMaxPercent = math.Max(s.CPUMaxPercent, x.CPUPercent)
+		s.DiskMaxPercent = math.Max(s.DiskMaxPercent, x.DiskMaxPercent)
+		if x.MemoryTotalMB > 0 {
+			mem := float64(x.MemoryUsedMB) / float64(x.MemoryTotalMB) * 100
+			memSum += mem
+			memSamples++
+			s.MemoryMaxPercent = math.Max(s.MemoryMaxPercent, mem)
+		}
+	}
+	s.CPUAvgPercent = round1(cpuSum / float64(len(samples)))
+	if memSamples > 0 {
+		s.MemoryAvgPercent = round1(memSum / float64(memSamples))
+	}
+	s.CPUMaxPercent = round1(s.CPUMaxPercent)
+	s.MemoryMaxPercent = round1(s.MemoryMaxPercent)
+	s.DiskMaxPercent = round1(s.DiskMaxPercent)
+	return s
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// =============================================================================
+// Totals
+// =============================================================================
+
+// Totals sums a range of days. DeploymentsByStatus is the latest snapshot
+// in the range.
+type Totals struct {
+	Days                int                   `json:"days"`
+	DeploymentsByStatus map[string]int64      `json:"deployments_by_status,omitempty"`
+	DeploymentsCreated  int64                 `json:"deployments_created"`
+	NewCustomers        int64                 `json:"new_customers"`
+	Usage               map[string]UsageTotal `json:"usage,omitempty"`
+	GrossCents          int64                 `json:"gross_cents"`
+	NetCents            int64                 `json:"net_cents"`
+}
+
+// Sum totals days given in day order.
+func Sum(days []DeploymentStats) Totals {
+	t := Totals{Days: len(days)}
+	for _, d := range days {
+		t.DeploymentsCreated += d.DeploymentsCreated
+		t.NewCustomers += d.NewCustomers
+		t.GrossCents += d.GrossCents
+		t.NetCents += d.NetCents
+		if d.DeploymentsByStatus != nil {
+			t.DeploymentsByStatus = d.DeploymentsByStatus
+		}
+		for k, u := range d.Usage {
+			if t.Usage == nil {
+				t.Usage = map[string]UsageTotal{}
+			}
+			sum := t.Usage[k]
+			sum.Events += u.Events
+			sum.Quantity += u.Quantity
+			t.Usage[k] = sum
+		}
+	}
+	return t
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, 3, 10, 15, 30, 0, 0, time.UTC)
+
+// =============================================================================
+// Day Tests
+// =============================================================================
+
+func TestParseRange(t *testing.T) {
+	first, last, err := ParseRange("", "", now, MaxRangeDays)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-02-09", first)
+	assert.Equal(t, "2026-03-10", last)
+
+	first, last, err = ParseRange("2026-03-01", "2026-03-07", now, MaxRangeDays)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-03-01", first)
+	assert.Equal(t, "2026-03-07", last)
+
+	_, _, err = ParseRange("2026-03-08", "2026-03-07", now, MaxRangeDays)
+	assert.Error(t, err)
+	_, _, err = ParseRange("03/01/2026", "", now, MaxRangeDays)
+	assert.Error(t, err)
+	_, _, err = ParseRange("2025-01-01", "2026-03-07", now, MaxRangeDays)
+	assert.Error(t, err)
+}
+
+func TestDays(t *testing.T) {
+	assert.Equal(t, []string{"2026-02-27", "2026-02-28", "2026-03-01"}, Days("2026-02-27", "2026-03-01"))
+	assert.Nil(t, Days("2026-03-02", "2026-03-01"))
+}
+
+func TestFinal(t *testing.T) {
+	assert.False(t, Final("2026-03-10", now))
+	assert.True(t, Final("2026-03-09", now))
+	assert.True(t, Final("2026-03-09", time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestSnapshot(t *testing.T) {
+	assert.True(t, Snapshot("2026-03-10", now))
+	assert.True(t, Snapshot("2026-03-09", now))
+	assert.False(t, Snapshot("2026-03-08", now))
+}
+
+// =============================================================================
+// Node Tests
+// =============================================================================
+
+func TestSummarizeNode(t *testing.T) {
+	s := SummarizeNode("2026-03-10", []NodeSample{
+		{CPUPercent: 10, MemoryUsedMB: 512, MemoryTotalMB: 2048, DiskMaxPercent: 40},
+		{CPUPercent: 30, MemoryUsedMB: 1024, MemoryTotalMB: 2048, DiskMaxPercent: 41.25},
+		{CPUPercent: 20},
+	})
+	assert.Equal(t, int64(3), s.Samples)
+	assert.Equal(t, 20.0, s.CPUAvgPercent)
+	assert.Equal(t, 30.0, s.CPUMaxPercent)
+	assert.Equal(t, 37.5, s.MemoryAvgPercent)
+	assert.Equal(t, 50.0, s.MemoryMaxPercent)
+	assert.Equal(t, 41.3, s.DiskMaxPercent)
+
+	empty := SummarizeNode("2026-03-10", nil)
+	assert.Zero(t, empty.Samples)
+	assert.Zero(t, empty.CPUAvgPercent)
+}
+
+// =============================================================================
+// Totals Tests
+// =============================================================================
+
+func TestSum(t *testing.T) {
+	total := Sum([]DeploymentStats{
+		{Day: "2026-03-08", DeploymentsCreated: 2, NewCustomers: 1, GrossCents: 500,
+			DeploymentsByStatus: map[string]int64{"running": 3},
+			Usage:               map[string]UsageTotal{"deployment.created": {Events: 2, Quantity: 2}}},
+		{Day: "2026-03-09", DeploymentsCreated: 1, NetCents: 70,
+			DeploymentsByStatus: map[string]int64{"running": 4, "stopped": 1},
+			Usage:               map[string]UsageTotal{"deployment.created": {Events: 1, Quantity: 1}}},
+		{Day: "2026-03-10"},
+	})
+	assert.Equal(t, 3, total.Days)
+	assert.Equal(t, int64(3), total.DeploymentsCreated)
+	assert.Equal(t, int64(1), total.NewCustomers)
+	assert.Equal(t, int64(500), total.GrossCents)
+	assert.Equal(t, int64(70), total.NetCents)
+	assert.Equal(t, map[string]int64{"running": 4, "stopped": 1}, total.DeploymentsByStatus)
+	assert.Equal(t, UsageTotal{Events: 3, Quantity: 3}, total.Usage["deployment.created"])
+}
@@ -0,0 +1,201 @@
+// Package storage provides pure functions for managed object storage buckets:
+// bucket naming, credential variables, retention after deployment deletion,
+// and usage metering quantities.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Backends and Status
+// =============================================================================
+
+// Backend is where a bucket is hosted.
+type Backend string
+
+const (
+	// BackendNode runs a MinIO container for the bucket on the deployment's node.
+	BackendNode Backend = "node"
+	// BackendS3 creates the bucket on an external S3-compatible service.
+	BackendS3 Backend = "s3"
+)
+
+// Valid reports whether b is a known backend.
+func (b Backend) Valid() bool {
+	return b == BackendNode || b == BackendS3
+}
+
+// Status is the lifecycle state of a managed bucket.
+type Status string
+
+const (
+	StatusPending   Status = "pending"   // Waiting to be provisioned
+	StatusActive    Status = "active"    // Provisioned; credentials injected on start
+	StatusReleasing Status = "releasing" // Deployment deleted; backend access being revoked
+	StatusRetained  Status = "retained"  // Deployment deleted; data kept until PurgeAfter
+	StatusDeleted   Status = "deleted"   // Bucket and credentials removed
+	StatusFailed    Status = "failed"    // Provisioning failed; see error_message
+)
+
+// =============================================================================
+// Names
+// =============================================================================
+
+var (
+	ErrInvalidName           = errors.New("bucket name must be 3-40 characters of lowercase letters, digits and hyphens, starting and ending with a letter or digit")
+	ErrInvalidVariablePrefix = errors.New("variable prefix must be an uppercase environment variable name")
+	ErrInvalidRetention      = errors.New("invalid retention")
+)
+
+var (
+	nameRegex   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	prefixRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+const (
+	// DefaultVariablePrefix prefixes the injected credential variables (S3_ENDPOINT, ...).
+	DefaultVariablePrefix = "S3"
+	// DefaultBucketPrefix starts every provisioned bucket name.
+	DefaultBucketPrefix = "hoster"
+
+	minNameLength = 3
+	maxNameLength = 40
+	// maxBucketNameLength is the S3 limit for bucket names.
+	maxBucketNameLength = 63
+)
+
+// ValidateName checks the name a customer gives a bucket within a deployment.
+func ValidateName(name string) error {
+	if len(name) < minNameLength || len(name) > maxNameLength || !nameRegex.MatchString(name) || strings.Contains(name, "--") {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// ValidateVariablePrefix checks the prefix used for injected variables.
+func ValidateVariablePrefix(prefix string) error {
+	if !prefixRegex.MatchString(prefix) || strings.HasSuffix(prefix, "_") {
+		return ErrInvalidVariablePrefix
+	}
+	return nil
+}
+
+// BucketName returns the provisioned bucket name: the platform prefix, the
+// bucket's reference ID (which makes it globally unique) and its name,
+// truncated to the S3 limit of 63 characters.
+func BucketName(prefix, referenceID, name string) string {
+	if prefix == "" {
+		prefix = DefaultBucketPrefix
+	}
+	ref := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(referenceID))
+	full := prefix + "-" + ref + "-" + name
+	if len(full) > maxBucketNameLength {
+		full = strings.TrimRight(full[:maxBucketNameLength], "-")
+	}
+	return full
+}
+
+// =============================================================================
+// Credential Variables
+// =============================================================================
+
+// Credentials grant a deployment access to its bucket.
+type Credentials struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	PathStyle       bool   `json:"path_style"`
+}
+
+// Variable name suffixes for injected credentials.
+const (
+	VarEndpoint        = "_ENDPOINT"
+	VarRegion          = "_REGION"
+	VarBucket          = "_BUCKET"
+	VarAccessKeyID     = "_ACCESS_KEY_ID"
+	VarSecretAccessKey = "_SECRET_ACCESS_KEY"
+	VarUsePathStyle    = "_USE_PATH_STYLE"
+)
+
+// Variables returns the deployment variables that expose c under prefix.
+func Variables(prefix string, c Credentials) map[string]string {
+	if prefix == "" {
+		prefix = DefaultVariablePrefix
+	}
+	return map[string]string{
+		prefix + VarEndpoint:        c.Endpoint,
+		prefix + VarRegion:          c.Region,
+		prefix + VarBucket:          c.Bucket,
+		prefix + VarAccessKeyID:     c.AccessKeyID,
+		prefix + VarSecretAccessKey: c.SecretAccessKey,
+		prefix + VarUsePathStyle:    strconv.FormatBool(c.PathStyle),
+	}
+}
+
+// =============================================================================
+// Retention
+// =============================================================================
+
+// RetentionPolicy decides what happens to a bucket when its deployment is deleted.
+type RetentionPolicy string
+
+const (
+	// RetentionDelete removes the bucket with the deployment.
+	RetentionDelete RetentionPolicy = "delete"
+	// RetentionRetain keeps the bucket for RetentionDays (forever if 0).
+	RetentionRetain RetentionPolicy = "retain"
+)
+
+// MaxRetentionDays bounds how long a retained bucket may be kept on a schedule.
+const MaxRetentionDays = 3650
+
+// ValidateRetention checks a retention policy and its day count.
+func ValidateRetention(policy RetentionPolicy, days int) error {
+	switch policy {
+	case RetentionDelete:
+		if days != 0 {
+			return fmt.Errorf("%w: retention_days only applies to the retain policy", ErrInvalidRetention)
+		}
+	case RetentionRetain:
+		if days < 0 || days > MaxRetentionDays {
+			return fmt.Errorf("%w: retention_days must be between 0 and %d", ErrInvalidRetention, MaxRetentionDays)
+		}
+	default:
+		return fmt.Errorf("%w: policy must be %q or %q", ErrInvalidRetention, RetentionDelete, RetentionRetain)
+	}
+	return nil
+}
+
+// PurgeAfter returns when a bucket released at releasedAt is deleted.
+// ok is false for buckets retained indefinitely.
+func PurgeAfter(policy RetentionPolicy, days int, releasedAt time.Time) (at time.Time, ok bool) {
+	if policy == RetentionRetain {
+		if days == 0 {
+			return time.Time{}, false
+		}
+		return releasedAt.Add(time.Duration(days) * 24 * time.Hour), true
+	}
+	return releasedAt, true
+}
+
+// =============================================================================
+// Metering
+// =============================================================================
+
+// UsageMB converts stored bytes to the metered quantity in megabytes,
+// rounding up so any stored data is billed.
+func UsageMB(bytes int64) int64 {
+	if bytes <= 0 {
+		return 0
+	}
+	return (bytes + 1<<20 - 1) >> 20
+}
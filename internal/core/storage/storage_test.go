@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// =============================================================================
+// Name Tests
+// =============================================================================
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"uploads", "abc", "media-2024", strings.Repeat("a", 40)} {
+		assert.NoError(t, ValidateName(name), name)
+	}
+	for _, name := range []string{"", "ab", "Uploads", "-up", "up-", "up--loads", "up_loads", "up.loads", strings.Repeat("a", 41)} {
+		assert.ErrorIs(t, ValidateName(name), ErrInvalidName, name)
+	}
+}
+
+func TestValidateVariablePrefix(t *testing.T) {
+	for _, p := range []string{"S3", "MEDIA", "AWS_S3"} {
+		assert.NoError(t, ValidateVariablePrefix(p), p)
+	}
+	for _, p := range []string{"", "s3", "3S", "S3_", "S-3"} {
+		assert.ErrorIs(t, ValidateVariablePrefix(p), ErrInvalidVariablePrefix, p)
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	assert.Equal(t, "hoster-bkt1a2b3c4d-uploads", BucketName("", "bkt_1a2b3c4d", "uploads"))
+	assert.Equal(t, "acme-bkt1a2b3c4d-uploads", BucketName("acme", "bkt_1a2b3c4d", "uploads"))
+
+	long := BucketName("hoster", "bkt_1a2b3c4d", strings.Repeat("x", 39)+"-y")
+	assert.LessOrEqual(t, len(long), 63)
+	assert.False(t, strings.HasSuffix(long, "-"))
+}
+
+// =============================================================================
+// Variable Tests
+// =============================================================================
+
+func TestVariables(t *testing.T) {
+	vars := Variables("MEDIA", Credentials{
+		Endpoint: "http://storage-media:9000", Region: "us-east-1", Bucket: "b",
+		AccessKeyID: "ak", SecretAccessKey: "sk", PathStyle: true,
+	})
+	assert.Equal(t, map[string]string{
+		"MEDIA_ENDPOINT":          "http://storage-media:9000",
+		"MEDIA_REGION":            "us-east-1",
+		"MEDIA_BUCKET":            "b",
+		"MEDIA_ACCESS_KEY_ID":     "ak",
+		"MEDIA_SECRET_ACCESS_KEY": "sk",
+		"MEDIA_USE_PATH_STYLE":    "true",
+	}, vars)
+
+	assert.Contains(t, Variables("", Credentials{}), "S3_BUCKET")
+}
+
+// =============================================================================
+// Retention Tests
+// =============================================================================
+
+func TestValidateRetention(t *testing.T) {
+	assert.NoError(t, ValidateRetention(RetentionDelete, 0))
+	assert.NoError(t, ValidateRetention(RetentionRetain, 0))
+	assert.NoError(t, ValidateRetention(RetentionRetain, 30))
+
+	for _, tc := range []struct {
+		policy RetentionPolicy
+		days   int
+	}{
+		{RetentionDelete, 7},
+		{RetentionRetain, -1},
+		{RetentionRetain, MaxRetentionDays + 1},
+		{"archive", 0},
+	} {
+		err := ValidateRetention(tc.policy, tc.days)
+		assert.True(t, errors.Is(err, ErrInvalidRetention), "%v", tc)
+	}
+}
+
+func TestPurgeAfter(t *testing.T) {
+	released := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	at, ok := PurgeAfter(RetentionDelete, 0, released)
+	assert.True(t, ok)
+	assert.Equal(t, released, at)
+
+	at, ok = PurgeAfter(RetentionRetain, 30, released)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC), at)
+
+	_, ok = PurgeAfter(RetentionRetain, 0, released)
+	assert.False(t, ok, "kept indefinitely")
+}
+
+// =============================================================================
+// Metering Tests
+// =============================================================================
+
+func TestUsageMB(t *testing.T) {
+	assert.Equal(t, int64(0), UsageMB(0))
+	assert.Equal(t, int64(1), UsageMB(1))
+	assert.Equal(t, int64(1), UsageMB(1<<20))
+	assert.Equal(t, int64(2), UsageMB(1<<20+1))
+}
+
+func TestBackendValid(t *testing.T) {
+	assert.True(t, BackendNode.Valid())
+	assert.True(t, BackendS3.Valid())
+	assert.False(t, Backend("gcs").Valid())
+}
@@ -0,0 +1,80 @@
+// Package timezone provides pure functions for users' time zone
+// preferences: validating zone names and describing a zone for API
+// responses. Loading a zone's rules is left to the caller.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package timezone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Zone Names
+// =============================================================================
+
+// Default is the zone used when a user has not chosen one.
+const Default = "UTC"
+
+// ErrInvalidTimezone is returned for names that are not IANA zone names.
+var ErrInvalidTimezone = errors.New("invalid timezone")
+
+// namePattern matches IANA zone names such as "UTC", "Europe/Berlin",
+// "America/Argentina/Buenos_Aires" and "Etc/GMT+5".
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+){0,2}$`)
+
+// Normalize trims a zone name and checks its form. The empty name becomes
+// Default. "Local" is rejected because it means the server's zone, which is
+// the confusion a preference exists to avoid. Whether the zone exists is
+// checked by loading it.
+func Normalize(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Default, nil
+	}
+	if name == "Local" || len(name) > 64 || !namePattern.MatchString(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("%w: %q is not an IANA time zone name like \"Europe/Berlin\"", ErrInvalidTimezone, name)
+	}
+	return name, nil
+}
+
+// =============================================================================
+// Zone Description
+// =============================================================================
+
+// Zone describes a time zone at an instant, so clients can show schedule
+// times without their own zone database.
+type Zone struct {
+	Name         string `json:"name"`         // IANA name, e.g. "Europe/Berlin"
+	Abbreviation string `json:"abbreviation"` // e.g. "CEST"
+	UTCOffset    string `json:"utc_offset"`   // e.g. "+02:00"
+	DST          bool   `json:"dst"`          // Daylight saving time is in effect
+}
+
+// Describe returns loc's name, abbreviation and offset at t.
+func Describe(loc *time.Location, t time.Time) Zone {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	abbr, offset := t.Zone()
+	return Zone{
+		Name:         loc.String(),
+		Abbreviation: abbr,
+		UTCOffset:    FormatOffset(offset),
+		DST:          t.IsDST(),
+	}
+}
+
+// FormatOffset formats an offset in seconds east of UTC as "+hh:mm".
+func FormatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
@@ -0,0 +1,61 @@
+package timezone
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Normalize Tests
+// =============================================================================
+
+func TestNormalize(t *testing.T) {
+	valid := map[string]string{
+		"":                               Default,
+		"  ":                             Default,
+		"UTC":                            "UTC",
+		" Europe/Berlin ":                "Europe/Berlin",
+		"America/Argentina/Buenos_Aires": "America/Argentina/Buenos_Aires",
+		"Etc/GMT+5":                      "Etc/GMT+5",
+		"America/Port-au-Prince":         "America/Port-au-Prince",
+	}
+	for in, want := range valid {
+		got, err := Normalize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got)
+	}
+
+	for _, in := range []string{"Local", "../etc/passwd", "/UTC", "Europe/", "Europe//Berlin", "a/b/c/d", "+02:00", "Europe/Berlin time"} {
+		_, err := Normalize(in)
+		assert.True(t, errors.Is(err, ErrInvalidTimezone), in)
+	}
+}
+
+// =============================================================================
+// Describe Tests
+// =============================================================================
+
+func TestDescribe(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	summer := Describe(berlin, time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, Zone{Name: "Europe/Berlin", Abbreviation: "CEST", UTCOffset: "+02:00", DST: true}, summer)
+
+	winter := Describe(berlin, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, Zone{Name: "Europe/Berlin", Abbreviation: "CET", UTCOffset: "+01:00", DST: false}, winter)
+
+	assert.Equal(t, Zone{Name: "UTC", Abbreviation: "UTC", UTCOffset: "+00:00"}, Describe(nil, time.Now()))
+}
+
+func TestFormatOffset(t *testing.T) {
+	assert.Equal(t, "+00:00", FormatOffset(0))
+	assert.Equal(t, "+05:30", FormatOffset(5*3600+30*60))
+	assert.Equal(t, "-03:30", FormatOffset(-(3*3600 + 30*60)))
+	assert.Equal(t, "+12:45", FormatOffset(12*3600+45*60))
+}
@@ -0,0 +1,119 @@
+package topology
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// =============================================================================
+// STUN
+// =============================================================================
+//
+// A node behind NAT learns its public address by sending a STUN Binding
+// request (RFC 5389) to a public server, which answers with the source
+// address it saw. Only the Binding exchange is implemented.
+
+const (
+	stunMagicCookie    = 0x2112A442
+	stunBindingRequest = 0x0001
+	stunBindingSuccess = 0x0101
+	stunHeaderLength   = 20
+	stunAttrMapped     = 0x0001
+	stunAttrXORMapped  = 0x0020
+	stunFamilyIPv4     = 0x01
+	stunFamilyIPv6     = 0x02
+)
+
+// DefaultSTUNServers are queried when a node has no metadata service.
+var DefaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// ErrNoMappedAddress is returned for a STUN response without an address.
+var ErrNoMappedAddress = errors.New("stun response has no mapped address")
+
+// STUNBindingRequest returns a Binding request with the given transaction ID.
+func STUNBindingRequest(txID [12]byte) []byte {
+	msg := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(msg[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(msg[2:4], 0)
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], txID[:])
+	return msg
+}
+
+// ParseSTUNBindingResponse returns the address in a Binding success response
+// to the request with txID, preferring XOR-MAPPED-ADDRESS.
+func ParseSTUNBindingResponse(msg []byte, txID [12]byte) (net.IP, int, error) {
+	if len(msg) < stunHeaderLength {
+		return nil, 0, fmt.Errorf("stun response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingSuccess {
+		return nil, 0, fmt.Errorf("stun response is not a binding success")
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || string(msg[8:20]) != string(txID[:]) {
+		return nil, 0, fmt.Errorf("stun response does not match the request")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if len(msg) < stunHeaderLength+length {
+		return nil, 0, fmt.Errorf("stun response truncated")
+	}
+
+	var mappedIP net.IP
+	var mappedPort int
+	attrs := msg[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		n := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+n {
+			break
+		}
+		value := attrs[4 : 4+n]
+		switch typ {
+		case stunAttrXORMapped:
+			if ip, port, ok := decodeSTUNAddress(value, msg[4:20]); ok {
+				return ip, port, nil
+			}
+		case stunAttrMapped:
+			if ip, port, ok := decodeSTUNAddress(value, nil); ok {
+				mappedIP, mappedPort = ip, port
+			}
+		}
+		// Attributes are padded to 4 bytes
+		attrs = attrs[4+(n+3)&^3:]
+	}
+	if mappedIP != nil {
+		return mappedIP, mappedPort, nil
+	}
+	return nil, 0, ErrNoMappedAddress
+}
+
+// decodeSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value. xorKey is the magic
+// cookie followed by the transaction ID for XOR-MAPPED-ADDRESS, nil otherwise.
+func decodeSTUNAddress(v []byte, xorKey []byte) (net.IP, int, bool) {
+	if len(v) < 4 {
+		return nil, 0, false
+	}
+	var size int
+	switch v[1] {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, 0, false
+	}
+	if len(v) < 4+size {
+		return nil, 0, false
+	}
+	port := binary.BigEndian.Uint16(v[2:4])
+	ip := make(net.IP, size)
+	copy(ip, v[4:4+size])
+	if xorKey != nil {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+	return ip, int(port), true
+}
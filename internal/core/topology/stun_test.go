@@ -0,0 +1,85 @@
+package topology
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stunResponse builds a Binding success response carrying attrs.
+func stunResponse(txID [12]byte, attrs ...[]byte) []byte {
+	var body []byte
+	for _, a := range attrs {
+		body = append(body, a...)
+	}
+	msg := make([]byte, stunHeaderLength, stunHeaderLength+len(body))
+	binary.BigEndian.PutUint16(msg[0:2], stunBindingSuccess)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(body)))
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], txID[:])
+	return append(msg, body...)
+}
+
+func stunAttr(typ uint16, value []byte) []byte {
+	a := make([]byte, 4, 4+len(value)+3)
+	binary.BigEndian.PutUint16(a[0:2], typ)
+	binary.BigEndian.PutUint16(a[2:4], uint16(len(value)))
+	a = append(a, value...)
+	for len(a)%4 != 0 {
+		a = append(a, 0)
+	}
+	return a
+}
+
+func TestSTUNBindingRequest(t *testing.T) {
+	txID := [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	req := STUNBindingRequest(txID)
+	require.Len(t, req, 20)
+	assert.Equal(t, uint16(stunBindingRequest), binary.BigEndian.Uint16(req[0:2]))
+	assert.Equal(t, uint32(stunMagicCookie), binary.BigEndian.Uint32(req[4:8]))
+	assert.Equal(t, txID[:], req[8:20])
+}
+
+func TestParseSTUNBindingResponse_XORMapped(t *testing.T) {
+	txID := [12]byte{9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9}
+	// 203.0.113.7:54321 XORed with the magic cookie
+	ip := net.IPv4(203, 0, 113, 7).To4()
+	value := []byte{0, stunFamilyIPv4, 0, 0}
+	binary.BigEndian.PutUint16(value[2:4], 54321^uint16(stunMagicCookie>>16))
+	cookie := make([]byte, 4)
+	binary.BigEndian.PutUint32(cookie, stunMagicCookie)
+	for i := range ip {
+		value = append(value, ip[i]^cookie[i])
+	}
+	software := stunAttr(0x8022, []byte("test"))
+	msg := stunResponse(txID, software, stunAttr(stunAttrXORMapped, value))
+
+	got, port, err := ParseSTUNBindingResponse(msg, txID)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", got.String())
+	assert.Equal(t, 54321, port)
+}
+
+func TestParseSTUNBindingResponse_Mapped(t *testing.T) {
+	txID := [12]byte{7}
+	value := []byte{0, stunFamilyIPv4, 0x1f, 0x90, 198, 51, 100, 2}
+	got, port, err := ParseSTUNBindingResponse(stunResponse(txID, stunAttr(stunAttrMapped, value)), txID)
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.2", got.String())
+	assert.Equal(t, 8080, port)
+}
+
+func TestParseSTUNBindingResponse_Errors(t *testing.T) {
+	txID := [12]byte{7}
+	_, _, err := ParseSTUNBindingResponse([]byte{1, 2}, txID)
+	assert.Error(t, err)
+	_, _, err = ParseSTUNBindingResponse(stunResponse([12]byte{8}), txID)
+	assert.Error(t, err, "other transaction")
+	_, _, err = ParseSTUNBindingResponse(stunResponse(txID), txID)
+	assert.ErrorIs(t, err, ErrNoMappedAddress)
+	_, _, err = ParseSTUNBindingResponse(STUNBindingRequest(txID), txID)
+	assert.Error(t, err, "a request is not a response")
+}
@@ -0,0 +1,209 @@
+// Package topology provides pure functions for a node's network topology:
+// telling private from public addresses, choosing the address the internet
+// reaches a node at when it sits behind NAT, the warnings shown when it
+// cannot be reached, and the endpoints of ports its deployments publish.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package topology
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Addresses
+// =============================================================================
+
+// cgnat is the carrier-grade NAT range (RFC 6598), not covered by net.IP.IsPrivate.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPrivate reports whether ip is not routable from the internet: RFC 1918
+// and unique local ranges, carrier-grade NAT, loopback, link-local and
+// unspecified addresses.
+func IsPrivate(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// IsPublicIP reports whether s is an IP address routable from the internet.
+func IsPublicIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && !IsPrivate(ip)
+}
+
+// ValidateAddress checks a manually configured public address: a public IP
+// address or a hostname.
+func ValidateAddress(s string) error {
+	if ip := net.ParseIP(s); ip != nil {
+		if IsPrivate(ip) {
+			return fmt.Errorf("public address %s is a private address", s)
+		}
+		return nil
+	}
+	if len(s) == 0 || len(s) > 253 || strings.ContainsAny(s, " /:@") || !strings.Contains(s, ".") {
+		return fmt.Errorf("public address %q must be a public IP address or a hostname", s)
+	}
+	return nil
+}
+
+// =============================================================================
+// Topology
+// =============================================================================
+
+// Source is where a node's public address came from.
+type Source string
+
+const (
+	SourceManual   Source = "manual"   // Set by the node's owner
+	SourceMetadata Source = "metadata" // Cloud provider metadata service
+	SourceSTUN     Source = "stun"     // Mapped address reported by a STUN server
+	SourceSSHHost  Source = "ssh_host" // The SSH host, when it is a public IP
+)
+
+// Input is what is known about a node's addresses.
+type Input struct {
+	Manual   string                   // Owner-configured public address, if any
+	SSHHost  string                   // Address the backend connects to
+	Detected *minion.NetworkAddresses // Reported by the minion, nil before the first check
+	Probe    *Probe                   // Reachability of the public address, nil if not probed
+}
+
+// Probe is the outcome of connecting to a node's public address.
+type Probe struct {
+	Address   string // host:port that was dialled
+	Reachable bool
+	Error     string
+}
+
+// Topology is a node's resolved addressing.
+type Topology struct {
+	PublicAddress  string   `json:"public_address,omitempty"`
+	PrivateAddress string   `json:"private_address,omitempty"`
+	Source         Source   `json:"source,omitempty"`
+	BehindNAT      bool     `json:"behind_nat"`
+	Warnings       []string `json:"warnings,omitempty"`
+}
+
+// Resolve chooses a node's public address and explains what may stop the
+// internet from reaching it. A manual address wins, then the detected one,
+// then the SSH host if it is a public IP.
+func Resolve(in Input) Topology {
+	var t Topology
+	if in.Detected != nil {
+		t.PrivateAddress = in.Detected.PrivateAddress
+	}
+	switch {
+	case in.Manual != "":
+		t.PublicAddress, t.Source = in.Manual, SourceManual
+	case in.Detected != nil && in.Detected.PublicAddress != "":
+		t.PublicAddress, t.Source = in.Detected.PublicAddress, Source(in.Detected.Source)
+	case IsPublicIP(in.SSHHost):
+		t.PublicAddress, t.Source = in.SSHHost, SourceSSHHost
+	}
+
+	if t.PrivateAddress != "" && t.PublicAddress != "" && t.PrivateAddress != t.PublicAddress {
+		if ip := net.ParseIP(t.PrivateAddress); ip != nil && IsPrivate(ip) {
+			t.BehindNAT = true
+		}
+	}
+
+	if t.PublicAddress == "" {
+		t.Warnings = append(t.Warnings, "no public address is known: custom domain A records and published ports cannot be reached from the internet; set public_address")
+	} else if t.BehindNAT {
+		t.Warnings = append(t.Warnings, fmt.Sprintf(
+			"node is behind NAT (private %s, public %s): published ports must be forwarded to %s",
+			t.PrivateAddress, t.PublicAddress, t.PrivateAddress))
+	}
+	if in.Probe != nil && !in.Probe.Reachable {
+		msg := fmt.Sprintf("node appears unreachable from the internet at %s", in.Probe.Address)
+		if in.Probe.Error != "" {
+			msg += ": " + in.Probe.Error
+		}
+		t.Warnings = append(t.Warnings, msg)
+	}
+	return t
+}
+
+// =============================================================================
+// Endpoints
+// =============================================================================
+
+// Endpoint is a port a deployment publishes, as reached from the internet.
+type Endpoint struct {
+	Service       string `json:"service"`
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"`
+	Address       string `json:"address"` // public host:port
+}
+
+// Endpoints returns the published ports of a deployment's containers at the
+// node's public address, sorted by service and port. Ports in skip (e.g. the
+// App Proxy port, reached through the deployment's domains) are left out.
+// Without a public address there are none.
+func Endpoints(publicAddress string, containers []domain.ContainerInfo, skip ...int) []Endpoint {
+	if publicAddress == "" {
+		return nil
+	}
+	skipped := make(map[int]bool, len(skip))
+	for _, p := range skip {
+		skipped[p] = true
+	}
+
+	var out []Endpoint
+	seen := map[string]bool{}
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			if p.HostPort <= 0 || skipped[p.HostPort] {
+				continue
+			}
+			proto := p.Protocol
+			if proto == "" {
+				proto = "tcp"
+			}
+			addr := net.JoinHostPort(publicAddress, strconv.Itoa(p.HostPort))
+			if seen[addr+"/"+proto] {
+				continue
+			}
+			seen[addr+"/"+proto] = true
+			out = append(out, Endpoint{
+				Service:       c.ServiceName,
+				ContainerPort: p.ContainerPort,
+				Protocol:      proto,
+				Address:       addr,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].ContainerPort < out[j].ContainerPort
+	})
+	return out
+}
+
+// ProbePorts returns the published host ports worth dialling to check a node
+// is reachable from the internet: TCP ports published by its containers,
+// lowest first, or fallback when there are none.
+func ProbePorts(containers []domain.ContainerInfo, fallback int) []int {
+	seen := map[int]bool{}
+	var ports []int
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			if p.HostPort > 0 && (p.Protocol == "" || p.Protocol == "tcp") && !seen[p.HostPort] {
+				seen[p.HostPort] = true
+				ports = append(ports, p.HostPort)
+			}
+		}
+	}
+	sort.Ints(ports)
+	if len(ports) == 0 && fallback > 0 {
+		ports = []int{fallback}
+	}
+	return ports
+}
@@ -0,0 +1,103 @@
+package topology
+
+import (
+	"net"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPrivate(t *testing.T) {
+	for _, s := range []string{"10.0.0.5", "192.168.1.10", "172.16.4.4", "100.64.1.1", "127.0.0.1", "169.254.1.1", "fd00::1", "::"} {
+		assert.True(t, IsPrivate(net.ParseIP(s)), s)
+	}
+	for _, s := range []string{"203.0.113.7", "8.8.8.8", "100.128.0.1", "2001:db8::1"} {
+		assert.False(t, IsPrivate(net.ParseIP(s)), s)
+	}
+	assert.False(t, IsPublicIP("node.example.com"))
+	assert.True(t, IsPublicIP("203.0.113.7"))
+}
+
+func TestValidateAddress(t *testing.T) {
+	assert.NoError(t, ValidateAddress("203.0.113.7"))
+	assert.NoError(t, ValidateAddress("edge.example.com"))
+	assert.Error(t, ValidateAddress("10.0.0.5"))
+	assert.Error(t, ValidateAddress("localhost"))
+	assert.Error(t, ValidateAddress("http://example.com"))
+	assert.Error(t, ValidateAddress("203.0.113.7:22"))
+}
+
+func TestResolve(t *testing.T) {
+	natted := &minion.NetworkAddresses{PrivateAddress: "10.0.0.5", PublicAddress: "203.0.113.7", Source: "stun"}
+
+	t.Run("detected behind NAT", func(t *testing.T) {
+		top := Resolve(Input{SSHHost: "10.0.0.5", Detected: natted})
+		assert.Equal(t, "203.0.113.7", top.PublicAddress)
+		assert.Equal(t, SourceSTUN, top.Source)
+		assert.True(t, top.BehindNAT)
+		assert.Len(t, top.Warnings, 1)
+		assert.Contains(t, top.Warnings[0], "forwarded to 10.0.0.5")
+	})
+
+	t.Run("manual wins", func(t *testing.T) {
+		top := Resolve(Input{Manual: "edge.example.com", SSHHost: "10.0.0.5", Detected: natted})
+		assert.Equal(t, "edge.example.com", top.PublicAddress)
+		assert.Equal(t, SourceManual, top.Source)
+	})
+
+	t.Run("public ssh host without detection", func(t *testing.T) {
+		top := Resolve(Input{SSHHost: "198.51.100.2"})
+		assert.Equal(t, "198.51.100.2", top.PublicAddress)
+		assert.Equal(t, SourceSSHHost, top.Source)
+		assert.False(t, top.BehindNAT)
+		assert.Empty(t, top.Warnings)
+	})
+
+	t.Run("directly connected", func(t *testing.T) {
+		top := Resolve(Input{SSHHost: "198.51.100.2", Detected: &minion.NetworkAddresses{
+			PrivateAddress: "198.51.100.2", PublicAddress: "198.51.100.2", Source: "metadata",
+		}})
+		assert.False(t, top.BehindNAT)
+		assert.Empty(t, top.Warnings)
+	})
+
+	t.Run("no public address", func(t *testing.T) {
+		top := Resolve(Input{SSHHost: "10.0.0.5", Detected: &minion.NetworkAddresses{PrivateAddress: "10.0.0.5"}})
+		assert.Empty(t, top.PublicAddress)
+		assert.Len(t, top.Warnings, 1)
+		assert.Contains(t, top.Warnings[0], "no public address")
+	})
+
+	t.Run("unreachable probe", func(t *testing.T) {
+		top := Resolve(Input{SSHHost: "198.51.100.2", Probe: &Probe{Address: "198.51.100.2:30001", Error: "i/o timeout"}})
+		assert.Equal(t, []string{"node appears unreachable from the internet at 198.51.100.2:30001: i/o timeout"}, top.Warnings)
+	})
+}
+
+func TestEndpoints(t *testing.T) {
+	containers := []domain.ContainerInfo{
+		{ServiceName: "web", Ports: []domain.PortMapping{{ContainerPort: 80, HostPort: 30001, Protocol: "tcp"}}},
+		{ServiceName: "db", Ports: []domain.PortMapping{{ContainerPort: 5432, HostPort: 15432}, {ContainerPort: 9000}}},
+		{ServiceName: "dns", Ports: []domain.PortMapping{{ContainerPort: 53, HostPort: 5353, Protocol: "udp"}}},
+	}
+
+	eps := Endpoints("203.0.113.7", containers, 30001)
+	assert.Equal(t, []Endpoint{
+		{Service: "db", ContainerPort: 5432, Protocol: "tcp", Address: "203.0.113.7:15432"},
+		{Service: "dns", ContainerPort: 53, Protocol: "udp", Address: "203.0.113.7:5353"},
+	}, eps)
+	assert.Equal(t, "[2001:db8::1]:15432", Endpoints("2001:db8::1", containers[1:2])[0].Address)
+	assert.Nil(t, Endpoints("", containers))
+}
+
+func TestProbePorts(t *testing.T) {
+	containers := []domain.ContainerInfo{
+		{Ports: []domain.PortMapping{{HostPort: 30001, Protocol: "tcp"}, {HostPort: 5353, Protocol: "udp"}}},
+		{Ports: []domain.PortMapping{{HostPort: 8080}, {HostPort: 30001}}},
+	}
+	assert.Equal(t, []int{8080, 30001}, ProbePorts(containers, 22))
+	assert.Equal(t, []int{22}, ProbePorts(nil, 22))
+	assert.Empty(t, ProbePorts(nil, 0))
+}
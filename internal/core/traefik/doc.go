@@ -1,12 +1,18 @@
-// Package traefik provides pure functions for generating Traefik reverse proxy labels.
+// Package traefik provides pure functions for generating Traefik reverse proxy configuration.
 //
-// This package contains the functional core logic for generating Docker container
-// labels that configure Traefik routing. All functions are pure (no I/O, no side
-// effects) and comply with ADR-002 "Values as Boundaries".
+// This package contains the functional core logic for routing deployments through
+// Traefik on their nodes, either as Docker container labels (Docker provider) or as
+// a dynamic configuration file (file provider). All functions are pure (no I/O, no
+// side effects) and comply with ADR-002 "Values as Boundaries".
 //
 // # Functions
 //
-//   - GenerateLabels: Generate Traefik labels for HTTP/HTTPS routing
+//   - Routes: Routers, service and middlewares for one deployment's service
+//   - GenerateLabels: Routes flattened into Docker labels
+//   - NewDynamicConfig, Render: Routes of a node's deployments as a file provider config
+//   - DynamicConfig.WithCertificates, WithChallengeRoute: Issued certificates and ACME challenges
+//   - Hostnames, RouteOptions.Params: Routing parameters from a deployment's domains
+//   - LabelParams.WithRouting: A deployment's sticky sessions and body limit
 //
 // # Usage
 //
@@ -22,4 +28,9 @@
 //	for k, v := range labels {
 //	    containerPlan.Labels[k] = v
 //	}
+//
+// The file provider output covers every routed deployment on a node:
+//
+//	cfg := traefik.NewDynamicConfig(routes, traefik.DefaultUpstream)
+//	body, err := traefik.Render(cfg)
 package traefik
@@ -0,0 +1,196 @@
+package traefik
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/acme"
+	"gopkg.in/yaml.v3"
+)
+
+// =============================================================================
+// File Provider Configuration
+// =============================================================================
+
+// DefaultConfigPath is where the dynamic configuration file is written on a
+// node. Traefik's file provider should watch it, or its directory.
+const DefaultConfigPath = "/etc/traefik/dynamic/hoster.yml"
+
+// DefaultUpstream is the address Traefik reaches deployments' host ports at:
+// Traefik running on the node's host network.
+const DefaultUpstream = "127.0.0.1"
+
+// configHeader starts every generated file.
+const configHeader = "# Generated by hoster. Changes are overwritten.\n"
+
+// DynamicConfig is a file provider configuration.
+type DynamicConfig struct {
+	HTTP Routing     `yaml:"http"`
+	TCP  *TCPRouting `yaml:"tcp,omitempty"`
+	UDP  *UDPRouting `yaml:"udp,omitempty"`
+	TLS  *TLSConfig  `yaml:"tls,omitempty"`
+}
+
+// TLSConfig adds certificates to Traefik's default store. Routers whose
+// hostnames a stored certificate covers use it instead of asking their
+// resolver for one.
+type TLSConfig struct {
+	Certificates []TLSCertificate `yaml:"certificates"`
+}
+
+// TLSCertificate is a certificate and its key, inline as PEM.
+type TLSCertificate struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// NewDynamicConfig merges the routes of a node's deployments into one
+// configuration. Each service's servers point at upstream on the route's
+// port. Names are unique per deployment and service, so routes never clash.
+// TCP and UDP routes go to the tcp and udp sections.
+func NewDynamicConfig(routes []LabelParams, upstream string) DynamicConfig {
+	cfg := DynamicConfig{HTTP: Routing{
+		Routers:     map[string]Router{},
+		Services:    map[string]Service{},
+		Middlewares: map[string]Middleware{},
+	}}
+	for _, params := range routes {
+		switch params.Protocol {
+		case ProtocolTCP:
+			cfg.addTCP(TCPRoutes(params), upstream)
+			continue
+		case ProtocolUDP:
+			cfg.addUDP(UDPRoutes(params), upstream)
+			continue
+		}
+		r := Routes(params)
+		for name, router := range r.Routers {
+			cfg.HTTP.Routers[name] = router
+		}
+		for name, svc := range r.Services {
+			servers := make([]Server, len(svc.LoadBalancer.Servers))
+			for i, s := range svc.LoadBalancer.Servers {
+				servers[i] = Server{URL: fmt.Sprintf("http://%s:%d", upstream, s.Port), Port: s.Port}
+			}
+			svc.LoadBalancer.Servers = servers
+			cfg.HTTP.Services[name] = svc
+		}
+		for name, m := range r.Middlewares {
+			cfg.HTTP.Middlewares[name] = m
+		}
+	}
+	return cfg
+}
+
+func (cfg *DynamicConfig) addTCP(r TCPRouting, upstream string) {
+	if cfg.TCP == nil {
+		cfg.TCP = &TCPRouting{Routers: map[string]TCPRouter{}, Services: map[string]StreamService{}}
+	}
+	for name, router := range r.Routers {
+		cfg.TCP.Routers[name] = router
+	}
+	for name, svc := range r.Services {
+		cfg.TCP.Services[name] = streamUpstream(svc, upstream)
+	}
+}
+
+func (cfg *DynamicConfig) addUDP(r UDPRouting, upstream string) {
+	if cfg.UDP == nil {
+		cfg.UDP = &UDPRouting{Routers: map[string]UDPRouter{}, Services: map[string]StreamService{}}
+	}
+	for name, router := range r.Routers {
+		cfg.UDP.Routers[name] = router
+	}
+	for name, svc := range r.Services {
+		cfg.UDP.Services[name] = streamUpstream(svc, upstream)
+	}
+}
+
+// streamUpstream points a stream service's servers at upstream.
+func streamUpstream(svc StreamService, upstream string) StreamService {
+	servers := make([]StreamServer, len(svc.LoadBalancer.Servers))
+	for i, s := range svc.LoadBalancer.Servers {
+		servers[i] = StreamServer{Address: fmt.Sprintf("%s:%d", upstream, s.Port), Port: s.Port}
+	}
+	svc.LoadBalancer.Servers = servers
+	return svc
+}
+
+// Certificate is a certificate hoster issued for a hostname.
+type Certificate struct {
+	Hostname string
+	ChainPEM string
+	KeyPEM   string
+}
+
+// WithCertificates returns the configuration with the certificates in its
+// TLS store, in hostname order so the file renders the same bytes.
+func (cfg DynamicConfig) WithCertificates(certs []Certificate) DynamicConfig {
+	if len(certs) == 0 {
+		return cfg
+	}
+	certs = slices.Clone(certs)
+	slices.SortFunc(certs, func(a, b Certificate) int { return strings.Compare(a.Hostname, b.Hostname) })
+	cfg.TLS = &TLSConfig{}
+	for _, c := range certs {
+		cfg.TLS.Certificates = append(cfg.TLS.Certificates, TLSCertificate{CertFile: c.ChainPEM, KeyFile: c.KeyPEM})
+	}
+	return cfg
+}
+
+// ChallengeRouterName names the router and service answering ACME HTTP-01
+// challenges.
+const ChallengeRouterName = "hoster-acme-challenge"
+
+// challengePriority puts the challenge router ahead of deployments' routers,
+// including their HTTPS redirects.
+const challengePriority = 10000
+
+// WithChallengeRoute returns the configuration with a router sending the
+// hostnames' ACME HTTP-01 challenge requests to upstream, hoster's
+// challenge responder (e.g. "http://10.0.0.2:8080").
+func (cfg DynamicConfig) WithChallengeRoute(hostnames []string, upstream string) DynamicConfig {
+	if len(hostnames) == 0 || upstream == "" {
+		return cfg
+	}
+	hostnames = slices.Clone(hostnames)
+	slices.Sort(hostnames)
+	if cfg.HTTP.Routers == nil {
+		cfg.HTTP.Routers = map[string]Router{}
+	}
+	if cfg.HTTP.Services == nil {
+		cfg.HTTP.Services = map[string]Service{}
+	}
+	cfg.HTTP.Routers[ChallengeRouterName] = Router{
+		Rule:        fmt.Sprintf("(%s) && PathPrefix(`%s`)", Rule(hostnames...), acme.ChallengePathPrefix),
+		EntryPoints: []string{"web"},
+		Service:     ChallengeRouterName,
+		Priority:    challengePriority,
+	}
+	cfg.HTTP.Services[ChallengeRouterName] = Service{LoadBalancer: LoadBalancer{Servers: []Server{{URL: upstream}}}}
+	return cfg
+}
+
+// Render encodes a configuration as YAML. Keys are sorted, so the same
+// routes always render the same bytes.
+func Render(cfg DynamicConfig) ([]byte, error) {
+	body, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("render traefik config: %w", err)
+	}
+	return append([]byte(configHeader), body...), nil
+}
+
+// ValidateConfigPath checks where a dynamic configuration file may be
+// written: an absolute, clean path to a .yml or .yaml file.
+func ValidateConfigPath(p string) error {
+	if !path.IsAbs(p) || path.Clean(p) != p {
+		return fmt.Errorf("traefik config path %q must be absolute and clean", p)
+	}
+	if ext := strings.ToLower(path.Ext(p)); ext != ".yml" && ext != ".yaml" {
+		return fmt.Errorf("traefik config path %q must end in .yml or .yaml", p)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package traefik
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Dynamic Config Tests
+// =============================================================================
+
+func TestNewDynamicConfig(t *testing.T) {
+	cfg := NewDynamicConfig([]LabelParams{
+		{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 30001, EnableTLS: true, RedirectHTTP: true},
+		{DeploymentID: "d2", ServiceName: "api", Hostname: "b.test.com", Port: 30002},
+	}, "127.0.0.1")
+
+	assert.Len(t, cfg.HTTP.Routers, 3)
+	assert.Len(t, cfg.HTTP.Services, 2)
+	assert.Len(t, cfg.HTTP.Middlewares, 1)
+	assert.Equal(t, "http://127.0.0.1:30001", cfg.HTTP.Services["d1-web"].LoadBalancer.Servers[0].URL)
+	assert.Equal(t, "http://127.0.0.1:30002", cfg.HTTP.Services["d2-api"].LoadBalancer.Servers[0].URL)
+}
+
+func TestRender(t *testing.T) {
+	cfg := NewDynamicConfig([]LabelParams{
+		{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 30001, EnableTLS: true, RedirectHTTP: true},
+	}, "172.17.0.1")
+
+	out, err := Render(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, configHeader+`http:
+    routers:
+        d1-web:
+            rule: Host(`+"`a.test.com`"+`)
+            entryPoints:
+                - web
+            service: d1-web
+            middlewares:
+                - d1-web-redirect
+        d1-web-secure:
+            rule: Host(`+"`a.test.com`"+`)
+            entryPoints:
+                - websecure
+            service: d1-web
+            tls:
+                certResolver: letsencrypt
+    services:
+        d1-web:
+            loadBalancer:
+                servers:
+                    - url: http://172.17.0.1:30001
+    middlewares:
+        d1-web-redirect:
+            redirectScheme:
+                scheme: https
+                permanent: true
+`, string(out))
+
+	again, err := Render(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, out, again)
+}
+
+func TestRender_Empty(t *testing.T) {
+	out, err := Render(NewDynamicConfig(nil, DefaultUpstream))
+	require.NoError(t, err)
+	assert.Equal(t, configHeader+"http: {}\n", string(out))
+}
+
+func TestValidateConfigPath(t *testing.T) {
+	assert.NoError(t, ValidateConfigPath(DefaultConfigPath))
+	assert.NoError(t, ValidateConfigPath("/srv/traefik/hoster.yaml"))
+	assert.Error(t, ValidateConfigPath("dynamic/hoster.yml"))
+	assert.Error(t, ValidateConfigPath("/etc/traefik/../passwd.yml"))
+	assert.Error(t, ValidateConfigPath("/etc/traefik/hoster.toml"))
+}
+
+func TestWithCertificates(t *testing.T) {
+	cfg := NewDynamicConfig(nil, DefaultUpstream).WithCertificates([]Certificate{
+		{Hostname: "shop.example.com", ChainPEM: "chain-b", KeyPEM: "key-b"},
+		{Hostname: "blog.example.com", ChainPEM: "chain-a", KeyPEM: "key-a"},
+	})
+	require.NotNil(t, cfg.TLS)
+	assert.Equal(t, []TLSCertificate{
+		{CertFile: "chain-a", KeyFile: "key-a"},
+		{CertFile: "chain-b", KeyFile: "key-b"},
+	}, cfg.TLS.Certificates)
+
+	assert.Nil(t, NewDynamicConfig(nil, DefaultUpstream).WithCertificates(nil).TLS)
+}
+
+func TestWithChallengeRoute(t *testing.T) {
+	cfg := NewDynamicConfig(nil, DefaultUpstream).
+		WithChallengeRoute([]string{"shop.example.com", "blog.example.com"}, "http://10.0.0.2:8080")
+
+	out, err := Render(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, configHeader+`http:
+    routers:
+        hoster-acme-challenge:
+            rule: (Host(`+"`blog.example.com`"+`) || Host(`+"`shop.example.com`"+`)) && PathPrefix(`+"`/.well-known/acme-challenge/`"+`)
+            entryPoints:
+                - web
+            service: hoster-acme-challenge
+            priority: 10000
+    services:
+        hoster-acme-challenge:
+            loadBalancer:
+                servers:
+                    - url: http://10.0.0.2:8080
+`, string(out))
+
+	unchanged := NewDynamicConfig(nil, DefaultUpstream).WithChallengeRoute(nil, "http://10.0.0.2:8080")
+	assert.Empty(t, unchanged.HTTP.Routers)
+}
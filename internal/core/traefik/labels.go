@@ -1,21 +1,28 @@
 package traefik
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // =============================================================================
 // Traefik Label Generation Functions
 // =============================================================================
 
 // GenerateLabels generates Traefik reverse proxy labels for a service.
+// TCP and UDP routes get traefik.tcp and traefik.udp labels instead, built
+// from TCPRoutes and UDPRoutes.
 //
 // The generated labels configure Traefik to route HTTP(S) traffic to the container:
 //   - Enables Traefik for the container
 //   - Creates a router with Host rule for the specified hostname
 //   - Configures the service loadbalancer port
 //   - If TLS is enabled, creates an additional secure router
+//   - If RedirectHTTP is also set, redirects the HTTP router to HTTPS
 //
 // Router and service names follow the pattern: {deploymentID}-{serviceName}
-// This ensures uniqueness across all deployments.
+// This ensures uniqueness across all deployments. The labels describe the
+// same routers, services and middlewares as Routes.
 //
 // Example (HTTP only):
 //
@@ -34,29 +41,84 @@ import "fmt"
 //	//   "traefik.http.services.abc123-web.loadbalancer.server.port": "80",
 //	// }
 func GenerateLabels(params LabelParams) map[string]string {
-	// Router/service name: {deploymentID}-{serviceName}
-	name := fmt.Sprintf("%s-%s", params.DeploymentID, params.ServiceName)
+	switch params.Protocol {
+	case ProtocolTCP:
+		return tcpLabels(params)
+	case ProtocolUDP:
+		return udpLabels(params)
+	}
+	routing := Routes(params)
 
 	labels := map[string]string{
 		// Enable Traefik for this container
 		"traefik.enable": "true",
+	}
 
-		// HTTP router
-		fmt.Sprintf("traefik.http.routers.%s.rule", name):        fmt.Sprintf("Host(`%s`)", params.Hostname),
-		fmt.Sprintf("traefik.http.routers.%s.entrypoints", name): "web",
+	// Routers (the container's only service is implied)
+	for name, r := range routing.Routers {
+		labels[fmt.Sprintf("traefik.http.routers.%s.rule", name)] = r.Rule
+		labels[fmt.Sprintf("traefik.http.routers.%s.entrypoints", name)] = strings.Join(r.EntryPoints, ",")
+		if len(r.Middlewares) > 0 {
+			labels[fmt.Sprintf("traefik.http.routers.%s.middlewares", name)] = strings.Join(r.Middlewares, ",")
+		}
+		if r.TLS != nil {
+			labels[fmt.Sprintf("traefik.http.routers.%s.tls", name)] = "true"
+			labels[fmt.Sprintf("traefik.http.routers.%s.tls.certresolver", name)] = r.TLS.CertResolver
+		}
+	}
 
-		// Service (loadbalancer port)
-		fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", name): fmt.Sprintf("%d", params.Port),
+	// Service (loadbalancer port and sticky cookie)
+	name := RouterName(params.DeploymentID, params.ServiceName)
+	labels[fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", name)] = fmt.Sprintf("%d", params.Port)
+	if sticky := routing.Services[name].LoadBalancer.Sticky; sticky != nil {
+		prefix := fmt.Sprintf("traefik.http.services.%s.loadbalancer.sticky.cookie", name)
+		labels[prefix+".name"] = sticky.Cookie.Name
+		labels[prefix+".httponly"] = fmt.Sprintf("%t", sticky.Cookie.HTTPOnly)
+		labels[prefix+".secure"] = fmt.Sprintf("%t", sticky.Cookie.Secure)
+		labels[prefix+".samesite"] = sticky.Cookie.SameSite
 	}
 
-	// Add HTTPS router if TLS is enabled
-	if params.EnableTLS {
-		secureName := name + "-secure"
-		labels[fmt.Sprintf("traefik.http.routers.%s.rule", secureName)] = fmt.Sprintf("Host(`%s`)", params.Hostname)
-		labels[fmt.Sprintf("traefik.http.routers.%s.entrypoints", secureName)] = "websecure"
-		labels[fmt.Sprintf("traefik.http.routers.%s.tls", secureName)] = "true"
-		labels[fmt.Sprintf("traefik.http.routers.%s.tls.certresolver", secureName)] = "letsencrypt"
+	// Middlewares
+	for name, m := range routing.Middlewares {
+		if m.RedirectScheme != nil {
+			labels[fmt.Sprintf("traefik.http.middlewares.%s.redirectscheme.scheme", name)] = m.RedirectScheme.Scheme
+			labels[fmt.Sprintf("traefik.http.middlewares.%s.redirectscheme.permanent", name)] = fmt.Sprintf("%t", m.RedirectScheme.Permanent)
+		}
+		if m.Buffering != nil {
+			labels[fmt.Sprintf("traefik.http.middlewares.%s.buffering.maxrequestbodybytes", name)] = fmt.Sprintf("%d", m.Buffering.MaxRequestBodyBytes)
+		}
 	}
 
 	return labels
 }
+
+// tcpLabels flattens a TCP route into labels.
+func tcpLabels(params LabelParams) map[string]string {
+	routing := TCPRoutes(params)
+	labels := map[string]string{"traefik.enable": "true"}
+	for name, r := range routing.Routers {
+		labels[fmt.Sprintf("traefik.tcp.routers.%s.rule", name)] = r.Rule
+		labels[fmt.Sprintf("traefik.tcp.routers.%s.entrypoints", name)] = strings.Join(r.EntryPoints, ",")
+		if r.TLS != nil {
+			labels[fmt.Sprintf("traefik.tcp.routers.%s.tls", name)] = "true"
+			labels[fmt.Sprintf("traefik.tcp.routers.%s.tls.certresolver", name)] = r.TLS.CertResolver
+		}
+	}
+	for name := range routing.Services {
+		labels[fmt.Sprintf("traefik.tcp.services.%s.loadbalancer.server.port", name)] = fmt.Sprintf("%d", params.Port)
+	}
+	return labels
+}
+
+// udpLabels flattens a UDP route into labels.
+func udpLabels(params LabelParams) map[string]string {
+	routing := UDPRoutes(params)
+	labels := map[string]string{"traefik.enable": "true"}
+	for name, r := range routing.Routers {
+		labels[fmt.Sprintf("traefik.udp.routers.%s.entrypoints", name)] = strings.Join(r.EntryPoints, ",")
+	}
+	for name := range routing.Services {
+		labels[fmt.Sprintf("traefik.udp.services.%s.loadbalancer.server.port", name)] = fmt.Sprintf("%d", params.Port)
+	}
+	return labels
+}
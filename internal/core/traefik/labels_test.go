@@ -243,3 +243,42 @@ func TestGenerateLabels_LabelCount(t *testing.T) {
 	labelsWithTLS := GenerateLabels(paramsWithTLS)
 	assert.Len(t, labelsWithTLS, 8)
 }
+
+func TestGenerateLabels_AliasesAndRedirect(t *testing.T) {
+	labels := GenerateLabels(LabelParams{
+		DeploymentID: "d1",
+		ServiceName:  "web",
+		Hostname:     "a.test.com",
+		Aliases:      []string{"www.example.com"},
+		Port:         80,
+		EnableTLS:    true,
+		RedirectHTTP: true,
+	})
+
+	assert.Equal(t, "Host(`a.test.com`) || Host(`www.example.com`)", labels["traefik.http.routers.d1-web.rule"])
+	assert.Equal(t, "Host(`a.test.com`) || Host(`www.example.com`)", labels["traefik.http.routers.d1-web-secure.rule"])
+	assert.Equal(t, "d1-web-redirect", labels["traefik.http.routers.d1-web.middlewares"])
+	assert.Equal(t, "https", labels["traefik.http.middlewares.d1-web-redirect.redirectscheme.scheme"])
+	assert.Equal(t, "true", labels["traefik.http.middlewares.d1-web-redirect.redirectscheme.permanent"])
+	assert.Len(t, labels, 11)
+}
+
+func TestGenerateLabels_StickyAndBodyLimit(t *testing.T) {
+	labels := GenerateLabels(LabelParams{
+		DeploymentID: "d1",
+		ServiceName:  "web",
+		Hostname:     "a.test.com",
+		Port:         80,
+		EnableTLS:    true,
+		StickyCookie: "sid",
+		MaxBodyBytes: 1048576,
+	})
+
+	assert.Equal(t, "sid", labels["traefik.http.services.d1-web.loadbalancer.sticky.cookie.name"])
+	assert.Equal(t, "true", labels["traefik.http.services.d1-web.loadbalancer.sticky.cookie.httponly"])
+	assert.Equal(t, "true", labels["traefik.http.services.d1-web.loadbalancer.sticky.cookie.secure"])
+	assert.Equal(t, "lax", labels["traefik.http.services.d1-web.loadbalancer.sticky.cookie.samesite"])
+	assert.Equal(t, "d1-web-body", labels["traefik.http.routers.d1-web.middlewares"])
+	assert.Equal(t, "d1-web-body", labels["traefik.http.routers.d1-web-secure.middlewares"])
+	assert.Equal(t, "1048576", labels["traefik.http.middlewares.d1-web-body.buffering.maxrequestbodybytes"])
+}
@@ -0,0 +1,239 @@
+package traefik
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Routing Modes
+// =============================================================================
+
+// Mode selects how hoster tells Traefik on a node about deployments.
+type Mode string
+
+const (
+	// ModeOff leaves routing to the App Proxy.
+	ModeOff Mode = ""
+	// ModeLabels puts routing labels on each deployment's primary container,
+	// read by Traefik's Docker provider.
+	ModeLabels Mode = "labels"
+	// ModeFile writes one dynamic configuration file per node, read by
+	// Traefik's file provider.
+	ModeFile Mode = "file"
+)
+
+// ParseMode parses a proxy.traefik.mode setting.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case ModeOff, ModeLabels, ModeFile:
+		return m, nil
+	}
+	return ModeOff, fmt.Errorf("invalid traefik mode %q: must be labels or file", s)
+}
+
+// RouteOptions are the routing settings shared by every deployment.
+type RouteOptions struct {
+	EnableTLS    bool
+	RedirectHTTP bool
+}
+
+// Params returns the routing parameters of a deployment's service, reached
+// at port. ok is false when the deployment has no routable hostname.
+func (o RouteOptions) Params(deploymentID, serviceName string, domains []domain.Domain, port int) (params LabelParams, ok bool) {
+	hostnames := Hostnames(domains)
+	if len(hostnames) == 0 || port <= 0 {
+		return LabelParams{}, false
+	}
+	return LabelParams{
+		DeploymentID: deploymentID,
+		ServiceName:  serviceName,
+		Hostname:     hostnames[0],
+		Aliases:      hostnames[1:],
+		Port:         port,
+		EnableTLS:    o.EnableTLS,
+		RedirectHTTP: o.RedirectHTTP,
+	}, true
+}
+
+// WithRouting returns the parameters with a deployment's routing options
+// applied. WebSocket connections are bound by the timeouts of Traefik's
+// entry points, so WebSocketTimeoutSeconds has no Traefik equivalent.
+func (p LabelParams) WithRouting(o domain.RoutingOptions) LabelParams {
+	p.StickyCookie = o.Cookie()
+	p.MaxBodyBytes = o.MaxBodyBytes()
+	return p
+}
+
+// Hostnames returns the hostnames Traefik should route for a deployment, in
+// order: auto domains and verified custom domains, as the App Proxy would.
+func Hostnames(domains []domain.Domain) []string {
+	var hostnames []string
+	seen := make(map[string]bool)
+	for _, d := range domains {
+		if d.Type == domain.DomainTypeCustom && d.VerificationStatus != domain.DomainVerificationVerified {
+			continue
+		}
+		h := domain.NormalizeHostname(d.Hostname)
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		hostnames = append(hostnames, h)
+	}
+	return hostnames
+}
+
+// =============================================================================
+// Routers, Services and Middlewares
+// =============================================================================
+
+// Routing is Traefik's HTTP configuration: the shape of the file provider's
+// "http" section, which labels flatten into keys.
+type Routing struct {
+	Routers     map[string]Router     `yaml:"routers,omitempty"`
+	Services    map[string]Service    `yaml:"services,omitempty"`
+	Middlewares map[string]Middleware `yaml:"middlewares,omitempty"`
+}
+
+// Router matches requests and sends them to a service.
+type Router struct {
+	Rule        string     `yaml:"rule"`
+	EntryPoints []string   `yaml:"entryPoints"`
+	Service     string     `yaml:"service"`
+	Middlewares []string   `yaml:"middlewares,omitempty"`
+	TLS         *RouterTLS `yaml:"tls,omitempty"`
+	Priority    int        `yaml:"priority,omitempty"`
+}
+
+// RouterTLS terminates TLS with certificates from a resolver.
+type RouterTLS struct {
+	CertResolver string `yaml:"certResolver"`
+}
+
+// Service balances requests over servers.
+type Service struct {
+	LoadBalancer LoadBalancer `yaml:"loadBalancer"`
+}
+
+// LoadBalancer lists a service's servers.
+type LoadBalancer struct {
+	Servers []Server `yaml:"servers"`
+	Sticky  *Sticky  `yaml:"sticky,omitempty"`
+}
+
+// Sticky pins clients to a server.
+type Sticky struct {
+	Cookie StickyCookie `yaml:"cookie"`
+}
+
+// StickyCookie is the cookie that records a client's server.
+type StickyCookie struct {
+	Name     string `yaml:"name"`
+	HTTPOnly bool   `yaml:"httpOnly"`
+	Secure   bool   `yaml:"secure"`
+	SameSite string `yaml:"sameSite"`
+}
+
+// Server is one backend of a service. Labels give only the port, as the
+// Docker provider knows the container's address; the file provider needs
+// the URL.
+type Server struct {
+	URL  string `yaml:"url"`
+	Port int    `yaml:"-"`
+}
+
+// Middleware alters requests before they reach a service.
+type Middleware struct {
+	RedirectScheme *RedirectScheme `yaml:"redirectScheme,omitempty"`
+	Buffering      *Buffering      `yaml:"buffering,omitempty"`
+}
+
+// Buffering reads requests into memory first, rejecting bodies over
+// MaxRequestBodyBytes.
+type Buffering struct {
+	MaxRequestBodyBytes int64 `yaml:"maxRequestBodyBytes"`
+}
+
+// RedirectScheme redirects requests to another scheme.
+type RedirectScheme struct {
+	Scheme    string `yaml:"scheme"`
+	Permanent bool   `yaml:"permanent"`
+}
+
+// RouterName returns the router and service name of a deployment's service.
+func RouterName(deploymentID, serviceName string) string {
+	return fmt.Sprintf("%s-%s", deploymentID, serviceName)
+}
+
+// Rule returns a router rule matching any of the hostnames.
+func Rule(hostnames ...string) string {
+	rules := make([]string, len(hostnames))
+	for i, h := range hostnames {
+		rules[i] = fmt.Sprintf("Host(`%s`)", h)
+	}
+	return strings.Join(rules, " || ")
+}
+
+// Routes returns the routers, service and middlewares of one deployment's
+// service. Both GenerateLabels and DynamicConfig are built from it.
+func Routes(params LabelParams) Routing {
+	name := RouterName(params.DeploymentID, params.ServiceName)
+	rule := Rule(append([]string{params.Hostname}, params.Aliases...)...)
+
+	routing := Routing{
+		Routers: map[string]Router{
+			name: {Rule: rule, EntryPoints: []string{"web"}, Service: name},
+		},
+		Services: map[string]Service{
+			name: {LoadBalancer: LoadBalancer{Servers: []Server{{Port: params.Port}}}},
+		},
+		Middlewares: map[string]Middleware{},
+	}
+
+	if params.StickyCookie != "" {
+		svc := routing.Services[name]
+		svc.LoadBalancer.Sticky = &Sticky{Cookie: StickyCookie{
+			Name:     params.StickyCookie,
+			HTTPOnly: true,
+			Secure:   params.EnableTLS,
+			SameSite: "lax",
+		}}
+		routing.Services[name] = svc
+	}
+
+	// Both routers limit request bodies, unless the plain one only redirects
+	var limits []string
+	if params.MaxBodyBytes > 0 {
+		limit := name + "-body"
+		limits = []string{limit}
+		routing.Middlewares[limit] = Middleware{Buffering: &Buffering{MaxRequestBodyBytes: params.MaxBodyBytes}}
+		r := routing.Routers[name]
+		r.Middlewares = limits
+		routing.Routers[name] = r
+	}
+
+	if params.EnableTLS {
+		routing.Routers[name+"-secure"] = Router{
+			Rule:        rule,
+			EntryPoints: []string{"websecure"},
+			Service:     name,
+			Middlewares: limits,
+			TLS:         &RouterTLS{CertResolver: "letsencrypt"},
+		}
+		if params.RedirectHTTP {
+			redirect := name + "-redirect"
+			r := routing.Routers[name]
+			r.Middlewares = []string{redirect}
+			routing.Routers[name] = r
+			routing.Middlewares[redirect] = Middleware{RedirectScheme: &RedirectScheme{Scheme: "https", Permanent: true}}
+		}
+	}
+
+	if len(routing.Middlewares) == 0 {
+		routing.Middlewares = nil
+	}
+	return routing
+}
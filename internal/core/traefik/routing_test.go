@@ -0,0 +1,111 @@
+package traefik
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Mode Tests
+// =============================================================================
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeOff, "labels": ModeLabels, "File": ModeFile, " file ": ModeFile} {
+		got, err := ParseMode(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseMode("kubernetes")
+	assert.Error(t, err)
+}
+
+// =============================================================================
+// Hostname Tests
+// =============================================================================
+
+func TestHostnames(t *testing.T) {
+	domains := []domain.Domain{
+		{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto},
+		{Hostname: "Blog.Example.com.", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationVerified},
+		{Hostname: "pending.example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationPending},
+		{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto},
+	}
+	assert.Equal(t, []string{"blog.apps.hoster.io", "blog.example.com"}, Hostnames(domains))
+	assert.Empty(t, Hostnames(nil))
+}
+
+func TestRouteOptions_Params(t *testing.T) {
+	opts := RouteOptions{EnableTLS: true}
+	domains := []domain.Domain{
+		{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto},
+		{Hostname: "blog.example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationVerified},
+	}
+
+	params, ok := opts.Params("depl_1", "web", domains, 30001)
+	require.True(t, ok)
+	assert.Equal(t, "blog.apps.hoster.io", params.Hostname)
+	assert.Equal(t, []string{"blog.example.com"}, params.Aliases)
+	assert.Equal(t, 30001, params.Port)
+	assert.True(t, params.EnableTLS)
+
+	_, ok = opts.Params("depl_1", "web", nil, 30001)
+	assert.False(t, ok)
+	_, ok = opts.Params("depl_1", "web", domains, 0)
+	assert.False(t, ok)
+}
+
+// =============================================================================
+// Routes Tests
+// =============================================================================
+
+func TestRoutes(t *testing.T) {
+	r := Routes(LabelParams{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 80})
+	assert.Equal(t, map[string]Router{
+		"d1-web": {Rule: "Host(`a.test.com`)", EntryPoints: []string{"web"}, Service: "d1-web"},
+	}, r.Routers)
+	assert.Equal(t, 80, r.Services["d1-web"].LoadBalancer.Servers[0].Port)
+	assert.Empty(t, r.Middlewares)
+}
+
+func TestRoutes_TLSWithRedirect(t *testing.T) {
+	r := Routes(LabelParams{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 80, EnableTLS: true, RedirectHTTP: true})
+	require.Len(t, r.Routers, 2)
+	assert.Equal(t, []string{"d1-web-redirect"}, r.Routers["d1-web"].Middlewares)
+	assert.Equal(t, &RouterTLS{CertResolver: "letsencrypt"}, r.Routers["d1-web-secure"].TLS)
+	assert.Equal(t, "d1-web", r.Routers["d1-web-secure"].Service)
+	assert.Equal(t, "https", r.Middlewares["d1-web-redirect"].RedirectScheme.Scheme)
+
+	// Redirect needs TLS
+	r = Routes(LabelParams{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 80, RedirectHTTP: true})
+	assert.Empty(t, r.Routers["d1-web"].Middlewares)
+	assert.Empty(t, r.Middlewares)
+}
+
+func TestRoutes_StickyAndBodyLimit(t *testing.T) {
+	params := LabelParams{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 80, StickyCookie: "sid", MaxBodyBytes: 1 << 20}
+
+	r := Routes(params)
+	assert.Equal(t, &Sticky{Cookie: StickyCookie{Name: "sid", HTTPOnly: true, SameSite: "lax"}}, r.Services["d1-web"].LoadBalancer.Sticky)
+	assert.Equal(t, []string{"d1-web-body"}, r.Routers["d1-web"].Middlewares)
+	assert.Equal(t, int64(1<<20), r.Middlewares["d1-web-body"].Buffering.MaxRequestBodyBytes)
+
+	params.EnableTLS, params.RedirectHTTP = true, true
+	r = Routes(params)
+	assert.True(t, r.Services["d1-web"].LoadBalancer.Sticky.Cookie.Secure)
+	assert.Equal(t, []string{"d1-web-redirect"}, r.Routers["d1-web"].Middlewares)
+	assert.Equal(t, []string{"d1-web-body"}, r.Routers["d1-web-secure"].Middlewares)
+	assert.Len(t, r.Middlewares, 2)
+}
+
+func TestLabelParams_WithRouting(t *testing.T) {
+	sticky := true
+	params := LabelParams{DeploymentID: "d1"}.WithRouting(domain.RoutingOptions{StickySessions: &sticky, WebSocketTimeoutSeconds: 60, MaxBodyMB: 5})
+	assert.Equal(t, domain.DefaultStickyCookie, params.StickyCookie)
+	assert.Equal(t, int64(5<<20), params.MaxBodyBytes)
+
+	params = LabelParams{DeploymentID: "d1"}.WithRouting(domain.RoutingOptions{StickyCookie: "sid"})
+	assert.Empty(t, params.StickyCookie, "a cookie name alone doesn't make sessions sticky")
+}
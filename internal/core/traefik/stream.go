@@ -0,0 +1,151 @@
+package traefik
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// TCP and UDP Routes
+// =============================================================================
+//
+// Services that don't speak HTTP (Postgres, MQTT, game servers) are routed as
+// raw TCP or UDP connections. A TCP stream with TLS terminates TLS on the
+// shared SNIEntryPoint and is told apart from other deployments' streams by
+// the server name the client asks for, which must be one of the deployment's
+// hostnames. Every other stream has an entry point of its own, named by
+// EntryPointName after the host port hoster allocated it; Traefik's static
+// configuration must define those entry points, e.g. one per port of the
+// stream port range.
+
+// SNIEntryPoint is the entry point TLS TCP streams share.
+const SNIEntryPoint = "tcpsecure"
+
+// ParseProtocol parses a stream protocol: tcp or udp.
+func ParseProtocol(s string) (Protocol, error) {
+	switch p := Protocol(strings.ToLower(strings.TrimSpace(s))); p {
+	case ProtocolTCP, ProtocolUDP:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid stream protocol %q: must be tcp or udp", s)
+}
+
+// EntryPointName names the entry point of a stream on a host port, e.g.
+// "tcp-40000".
+func EntryPointName(protocol Protocol, port int) string {
+	return fmt.Sprintf("%s-%d", protocol, port)
+}
+
+// SNIRule returns a TCP router rule matching connections whose TLS server
+// name is one of the hostnames, or any connection without hostnames.
+func SNIRule(hostnames ...string) string {
+	if len(hostnames) == 0 {
+		return "HostSNI(`*`)"
+	}
+	rules := make([]string, len(hostnames))
+	for i, h := range hostnames {
+		rules[i] = fmt.Sprintf("HostSNI(`%s`)", h)
+	}
+	return strings.Join(rules, " || ")
+}
+
+// StreamParams returns the routing parameters of a deployment's stream. ok
+// is false for a TLS stream without a routable hostname, which no server
+// name could reach, and for a plain stream without an allocated host port.
+func (o RouteOptions) StreamParams(deploymentID string, domains []domain.Domain, s domain.StreamPort) (params LabelParams, ok bool) {
+	protocol, err := ParseProtocol(s.Protocol)
+	if err != nil || s.Port <= 0 {
+		return LabelParams{}, false
+	}
+	params = LabelParams{
+		DeploymentID: deploymentID,
+		ServiceName:  fmt.Sprintf("%s-%s-%d", s.Service, protocol, s.Port),
+		Port:         s.Port,
+		Protocol:     protocol,
+	}
+	if s.TLS && protocol == ProtocolTCP {
+		hostnames := Hostnames(domains)
+		if len(hostnames) == 0 {
+			return LabelParams{}, false
+		}
+		params.Hostname, params.Aliases = hostnames[0], hostnames[1:]
+		params.EnableTLS = true
+		params.EntryPoint = SNIEntryPoint
+		return params, true
+	}
+	if s.HostPort <= 0 {
+		return LabelParams{}, false
+	}
+	params.EntryPoint = EntryPointName(protocol, s.HostPort)
+	return params, true
+}
+
+// TCPRouting is Traefik's TCP configuration: the shape of the file provider's
+// "tcp" section.
+type TCPRouting struct {
+	Routers  map[string]TCPRouter     `yaml:"routers,omitempty"`
+	Services map[string]StreamService `yaml:"services,omitempty"`
+}
+
+// TCPRouter matches connections and sends them to a service.
+type TCPRouter struct {
+	Rule        string     `yaml:"rule"`
+	EntryPoints []string   `yaml:"entryPoints"`
+	Service     string     `yaml:"service"`
+	TLS         *RouterTLS `yaml:"tls,omitempty"`
+}
+
+// UDPRouting is Traefik's UDP configuration: the shape of the file
+// provider's "udp" section.
+type UDPRouting struct {
+	Routers  map[string]UDPRouter     `yaml:"routers,omitempty"`
+	Services map[string]StreamService `yaml:"services,omitempty"`
+}
+
+// UDPRouter sends every datagram of its entry points to a service.
+type UDPRouter struct {
+	EntryPoints []string `yaml:"entryPoints"`
+	Service     string   `yaml:"service"`
+}
+
+// StreamService balances TCP connections or UDP sessions over servers.
+type StreamService struct {
+	LoadBalancer StreamLoadBalancer `yaml:"loadBalancer"`
+}
+
+// StreamLoadBalancer lists a stream service's servers.
+type StreamLoadBalancer struct {
+	Servers []StreamServer `yaml:"servers"`
+}
+
+// StreamServer is one backend of a stream service. Labels give only the
+// port; the file provider needs the address.
+type StreamServer struct {
+	Address string `yaml:"address"`
+	Port    int    `yaml:"-"`
+}
+
+// TCPRoutes returns the router and service of a TCP stream.
+func TCPRoutes(params LabelParams) TCPRouting {
+	name := RouterName(params.DeploymentID, params.ServiceName)
+	router := TCPRouter{Rule: SNIRule(), EntryPoints: []string{params.EntryPoint}, Service: name}
+	if params.EnableTLS {
+		router.Rule = SNIRule(append([]string{params.Hostname}, params.Aliases...)...)
+		router.TLS = &RouterTLS{CertResolver: "letsencrypt"}
+	}
+	return TCPRouting{
+		Routers:  map[string]TCPRouter{name: router},
+		Services: map[string]StreamService{name: {LoadBalancer: StreamLoadBalancer{Servers: []StreamServer{{Port: params.Port}}}}},
+	}
+}
+
+// UDPRoutes returns the router and service of a UDP stream.
+func UDPRoutes(params LabelParams) UDPRouting {
+	name := RouterName(params.DeploymentID, params.ServiceName)
+	return UDPRouting{
+		Routers:  map[string]UDPRouter{name: {EntryPoints: []string{params.EntryPoint}, Service: name}},
+		Services: map[string]StreamService{name: {LoadBalancer: StreamLoadBalancer{Servers: []StreamServer{{Port: params.Port}}}}},
+	}
+}
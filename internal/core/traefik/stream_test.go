@@ -0,0 +1,144 @@
+package traefik
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Stream Params Tests
+// =============================================================================
+
+func TestParseProtocol(t *testing.T) {
+	for in, want := range map[string]Protocol{"tcp": ProtocolTCP, " UDP ": ProtocolUDP} {
+		got, err := ParseProtocol(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "http", "sctp"} {
+		_, err := ParseProtocol(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestSNIRule(t *testing.T) {
+	assert.Equal(t, "HostSNI(`*`)", SNIRule())
+	assert.Equal(t, "HostSNI(`a.test.com`) || HostSNI(`b.test.com`)", SNIRule("a.test.com", "b.test.com"))
+}
+
+func TestRouteOptions_StreamParams(t *testing.T) {
+	opts := RouteOptions{EnableTLS: true}
+	domains := []domain.Domain{
+		{Hostname: "db.apps.hoster.io", Type: domain.DomainTypeAuto},
+		{Hostname: "db.example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationVerified},
+	}
+
+	params, ok := opts.StreamParams("depl_1", domains, domain.StreamPort{Service: "db", Port: 5432, Protocol: "tcp", TLS: true})
+	require.True(t, ok)
+	assert.Equal(t, "db-tcp-5432", params.ServiceName)
+	assert.Equal(t, ProtocolTCP, params.Protocol)
+	assert.Equal(t, SNIEntryPoint, params.EntryPoint)
+	assert.Equal(t, "db.apps.hoster.io", params.Hostname)
+	assert.Equal(t, []string{"db.example.com"}, params.Aliases)
+	assert.True(t, params.EnableTLS)
+
+	params, ok = opts.StreamParams("depl_1", domains, domain.StreamPort{Service: "game", Port: 27015, Protocol: "udp", HostPort: 40001})
+	require.True(t, ok)
+	assert.Equal(t, "udp-40001", params.EntryPoint)
+	assert.False(t, params.EnableTLS)
+	assert.Empty(t, params.Hostname)
+
+	_, ok = opts.StreamParams("depl_1", nil, domain.StreamPort{Service: "db", Port: 5432, Protocol: "tcp", TLS: true})
+	assert.False(t, ok, "TLS stream without a hostname")
+	_, ok = opts.StreamParams("depl_1", domains, domain.StreamPort{Service: "mqtt", Port: 1883, Protocol: "tcp"})
+	assert.False(t, ok, "plain stream without a host port")
+	_, ok = opts.StreamParams("depl_1", domains, domain.StreamPort{Service: "db", Port: 5432, Protocol: "sctp", HostPort: 40000})
+	assert.False(t, ok, "unknown protocol")
+}
+
+// =============================================================================
+// Stream Labels Tests
+// =============================================================================
+
+func TestGenerateLabels_TCPWithSNI(t *testing.T) {
+	labels := GenerateLabels(LabelParams{
+		DeploymentID: "d1",
+		ServiceName:  "db-tcp-5432",
+		Hostname:     "db.test.com",
+		Aliases:      []string{"db.example.com"},
+		Port:         5432,
+		EnableTLS:    true,
+		Protocol:     ProtocolTCP,
+		EntryPoint:   SNIEntryPoint,
+	})
+
+	assert.Equal(t, map[string]string{
+		"traefik.enable": "true",
+		"traefik.tcp.routers.d1-db-tcp-5432.rule":                      "HostSNI(`db.test.com`) || HostSNI(`db.example.com`)",
+		"traefik.tcp.routers.d1-db-tcp-5432.entrypoints":               "tcpsecure",
+		"traefik.tcp.routers.d1-db-tcp-5432.tls":                       "true",
+		"traefik.tcp.routers.d1-db-tcp-5432.tls.certresolver":          "letsencrypt",
+		"traefik.tcp.services.d1-db-tcp-5432.loadbalancer.server.port": "5432",
+	}, labels)
+}
+
+func TestGenerateLabels_TCPPlain(t *testing.T) {
+	labels := GenerateLabels(LabelParams{
+		DeploymentID: "d1",
+		ServiceName:  "mqtt-tcp-1883",
+		Port:         1883,
+		Protocol:     ProtocolTCP,
+		EntryPoint:   "tcp-40000",
+	})
+
+	assert.Equal(t, "HostSNI(`*`)", labels["traefik.tcp.routers.d1-mqtt-tcp-1883.rule"])
+	assert.Equal(t, "tcp-40000", labels["traefik.tcp.routers.d1-mqtt-tcp-1883.entrypoints"])
+	assert.NotContains(t, labels, "traefik.tcp.routers.d1-mqtt-tcp-1883.tls")
+	for key := range labels {
+		assert.NotContains(t, key, "traefik.http.", "TCP routes carry no HTTP labels")
+	}
+}
+
+func TestGenerateLabels_UDP(t *testing.T) {
+	labels := GenerateLabels(LabelParams{
+		DeploymentID: "d1",
+		ServiceName:  "game-udp-27015",
+		Port:         27015,
+		Protocol:     ProtocolUDP,
+		EntryPoint:   "udp-40001",
+	})
+
+	assert.Equal(t, map[string]string{
+		"traefik.enable": "true",
+		"traefik.udp.routers.d1-game-udp-27015.entrypoints":               "udp-40001",
+		"traefik.udp.services.d1-game-udp-27015.loadbalancer.server.port": "27015",
+	}, labels)
+}
+
+// =============================================================================
+// Stream Dynamic Config Tests
+// =============================================================================
+
+func TestNewDynamicConfig_Streams(t *testing.T) {
+	cfg := NewDynamicConfig([]LabelParams{
+		{DeploymentID: "d1", ServiceName: "web", Hostname: "a.test.com", Port: 30001},
+		{DeploymentID: "d1", ServiceName: "db-tcp-5432", Hostname: "a.test.com", Port: 30002, EnableTLS: true, Protocol: ProtocolTCP, EntryPoint: SNIEntryPoint},
+		{DeploymentID: "d1", ServiceName: "game-udp-27015", Port: 30003, Protocol: ProtocolUDP, EntryPoint: "udp-40001"},
+	}, "127.0.0.1")
+
+	assert.Len(t, cfg.HTTP.Routers, 1)
+	require.NotNil(t, cfg.TCP)
+	require.NotNil(t, cfg.UDP)
+	assert.Equal(t, "HostSNI(`a.test.com`)", cfg.TCP.Routers["d1-db-tcp-5432"].Rule)
+	assert.Equal(t, "127.0.0.1:30002", cfg.TCP.Services["d1-db-tcp-5432"].LoadBalancer.Servers[0].Address)
+	assert.Equal(t, []string{"udp-40001"}, cfg.UDP.Routers["d1-game-udp-27015"].EntryPoints)
+	assert.Equal(t, "127.0.0.1:30003", cfg.UDP.Services["d1-game-udp-27015"].LoadBalancer.Servers[0].Address)
+
+	out, err := Render(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "tcp:\n")
+	assert.Contains(t, string(out), "address: 127.0.0.1:30002")
+}
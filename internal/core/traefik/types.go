@@ -15,9 +15,46 @@ type LabelParams struct {
 	// Hostname is the domain/hostname for routing (e.g., "myapp.apps.hoster.io").
 	Hostname string
 
-	// Port is the container port to route traffic to.
+	// Aliases are further hostnames routed to the same service (e.g., verified
+	// custom domains).
+	Aliases []string
+
+	// Port is the port to route traffic to: the container port for labels,
+	// the node's host port for the file provider.
 	Port int
 
 	// EnableTLS enables HTTPS routing with TLS termination.
 	EnableTLS bool
+
+	// RedirectHTTP redirects plain HTTP requests to HTTPS. Only applies
+	// with EnableTLS.
+	RedirectHTTP bool
+
+	// StickyCookie pins each client to one server with a cookie of this
+	// name. Empty disables sticky sessions.
+	StickyCookie string
+
+	// MaxBodyBytes rejects requests with larger bodies. Zero means no limit.
+	MaxBodyBytes int64
+
+	// Protocol is what Traefik routes: HTTP (the default) by hostname, or
+	// raw TCP or UDP connections on EntryPoint.
+	Protocol Protocol
+
+	// EntryPoint is the Traefik entry point a TCP or UDP route listens on.
+	// HTTP routes always use the web and websecure entry points.
+	EntryPoint string
 }
+
+// Protocol is the kind of traffic a route carries.
+type Protocol string
+
+const (
+	// ProtocolHTTP routes HTTP requests by Host header. The zero value is HTTP.
+	ProtocolHTTP Protocol = "http"
+	// ProtocolTCP routes TCP connections, by TLS server name (SNI) when
+	// EnableTLS is set, or every connection to the entry point otherwise.
+	ProtocolTCP Protocol = "tcp"
+	// ProtocolUDP routes every datagram to the entry point.
+	ProtocolUDP Protocol = "udp"
+)
@@ -0,0 +1,220 @@
+// Package transfer provides pure functions for moving volume data between
+// nodes: transfer identifiers, chunk planning, resume offsets, progress, the
+// volume file manifest digest, and integrity verification.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Migration Status
+// =============================================================================
+
+// Status is the state of a volume migration.
+type Status string
+
+const (
+	StatusPending      Status = "pending"      // Waiting for the migrator to pick it up
+	StatusTransferring Status = "transferring" // Snapshotting and copying chunks
+	StatusVerifying    Status = "verifying"    // Restoring on the target and comparing manifests
+	StatusCompleted    Status = "completed"    // All volumes verified (and deployment switched)
+	StatusFailed       Status = "failed"       // Stopped with an error; may be resumed
+)
+
+// Active reports whether a migration in this status still owns the deployment.
+func (s Status) Active() bool {
+	return s == StatusPending || s == StatusTransferring || s == StatusVerifying
+}
+
+// =============================================================================
+// Transfer Identifiers
+// =============================================================================
+
+// MaxTransferIDLength bounds transfer IDs, which name staging files on nodes.
+const MaxTransferIDLength = 200
+
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ErrInvalidTransferID is returned for IDs that are not safe as file names.
+var ErrInvalidTransferID = errors.New("invalid transfer id")
+
+// TransferID returns the ID of one volume's transfer within a migration.
+// The same migration and volume always map to the same ID, which is what lets
+// an interrupted transfer find its staged data again.
+func TransferID(migrationID, volume string) string {
+	return migrationID + "." + volume
+}
+
+// ValidateTransferID checks that id can be used as a staging file name.
+func ValidateTransferID(id string) error {
+	if id == "" || len(id) > MaxTransferIDLength || !transferIDPattern.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidTransferID, id)
+	}
+	return nil
+}
+
+// =============================================================================
+// Chunk Planning
+// =============================================================================
+
+const (
+	// DefaultChunkSize is the number of archive bytes copied per chunk.
+	DefaultChunkSize int64 = 64 << 20
+	// MinChunkSize and MaxChunkSize bound configured chunk sizes.
+	MinChunkSize int64 = 1 << 20
+	MaxChunkSize int64 = 1 << 30
+	// MaxChunkAttempts is how many times a chunk is tried before the transfer fails.
+	MaxChunkAttempts = 3
+)
+
+// NormalizeChunkSize returns n clamped to [MinChunkSize, MaxChunkSize], or
+// DefaultChunkSize when n is not set.
+func NormalizeChunkSize(n int64) int64 {
+	switch {
+	case n <= 0:
+		return DefaultChunkSize
+	case n < MinChunkSize:
+		return MinChunkSize
+	case n > MaxChunkSize:
+		return MaxChunkSize
+	}
+	return n
+}
+
+// ChunkLength returns the length of the chunk starting at offset, or 0 when
+// the archive has been fully copied.
+func ChunkLength(offset, total, chunkSize int64) int64 {
+	if offset >= total {
+		return 0
+	}
+	if remaining := total - offset; remaining < chunkSize {
+		return remaining
+	}
+	return chunkSize
+}
+
+// ResumeOffset returns where to continue a transfer given the number of bytes
+// already staged on the target. A stage larger than the archive cannot belong
+// to it and is restarted from zero.
+func ResumeOffset(staged, total int64) int64 {
+	if staged < 0 || staged > total {
+		return 0
+	}
+	return staged
+}
+
+// Progress is how far a migration has got across all of its volumes.
+type Progress struct {
+	TotalBytes       int64 `json:"total_bytes"`
+	TransferredBytes int64 `json:"transferred_bytes"`
+}
+
+// Percent returns progress as a percentage. An empty transfer is complete.
+func (p Progress) Percent() float64 {
+	if p.TotalBytes <= 0 {
+		return 100
+	}
+	pct := float64(p.TransferredBytes) / float64(p.TotalBytes) * 100
+	if pct > 100 {
+		return 100
+	}
+	return pct
+}
+
+// =============================================================================
+// Manifest
+// =============================================================================
+
+// Manifest entry types.
+const (
+	EntryFile    = "file"
+	EntryDir     = "dir"
+	EntrySymlink = "symlink"
+)
+
+// ManifestEntry describes one path in a volume. Path is relative to the volume
+// root with forward slashes. SHA256 is set for regular files only.
+type ManifestEntry struct {
+	Path   string
+	Type   string
+	Mode   uint32
+	Size   int64
+	SHA256 string
+	Link   string
+}
+
+// ManifestDigest returns a SHA-256 over the sorted manifest. Two volumes with
+// the same paths, types, permissions, sizes, contents and link targets have
+// the same digest regardless of walk order. Ownership and timestamps are
+// deliberately excluded.
+func ManifestDigest(entries []ManifestEntry) string {
+	sorted := make([]ManifestEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	h := sha256.New()
+	for _, e := range sorted {
+		h.Write([]byte(e.Path))
+		h.Write([]byte{0})
+		h.Write([]byte(e.Type))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatUint(uint64(e.Mode&0o7777), 8)))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatInt(e.Size, 10)))
+		h.Write([]byte{0})
+		h.Write([]byte(e.SHA256))
+		h.Write([]byte{0})
+		h.Write([]byte(e.Link))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// =============================================================================
+// Integrity Verification
+// =============================================================================
+
+var (
+	// ErrChunkMismatch means a chunk arrived with a different length or checksum.
+	ErrChunkMismatch = errors.New("chunk checksum mismatch")
+	// ErrManifestMismatch means the restored volume differs from the source.
+	ErrManifestMismatch = errors.New("restored volume does not match source")
+)
+
+// VerifyChunk checks what the target staged against what was read from the source.
+func VerifyChunk(offset, length int64, sha string, got minion.VolumeChunkResult) error {
+	if got.Offset != offset || got.Written != length {
+		return fmt.Errorf("%w: wrote %d bytes at %d, expected %d at %d",
+			ErrChunkMismatch, got.Written, got.Offset, length, offset)
+	}
+	if got.SHA256 != sha {
+		return fmt.Errorf("%w: at offset %d", ErrChunkMismatch, offset)
+	}
+	return nil
+}
+
+// VerifyRestore checks a restored volume against the source snapshot. The
+// archive checksum is verified by the target before extraction; this compares
+// what ended up on disk.
+func VerifyRestore(src minion.VolumeSnapshot, dst minion.VolumeRestoreResult) error {
+	if dst.SHA256 != src.SHA256 {
+		return fmt.Errorf("%w: archive checksum %s, expected %s", ErrManifestMismatch, dst.SHA256, src.SHA256)
+	}
+	if dst.Files != src.Files {
+		return fmt.Errorf("%w: %d entries, expected %d", ErrManifestMismatch, dst.Files, src.Files)
+	}
+	if dst.ManifestDigest != src.ManifestDigest {
+		return fmt.Errorf("%w: manifest digest differs", ErrManifestMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Status and Identifier Tests
+// =============================================================================
+
+func TestStatus_Active(t *testing.T) {
+	assert.True(t, StatusPending.Active())
+	assert.True(t, StatusTransferring.Active())
+	assert.True(t, StatusVerifying.Active())
+	assert.False(t, StatusCompleted.Active())
+	assert.False(t, StatusFailed.Active())
+}
+
+func TestTransferID(t *testing.T) {
+	id := TransferID("vm_abc123", "hoster_depl_xyz_data")
+	assert.Equal(t, "vm_abc123.hoster_depl_xyz_data", id)
+	assert.NoError(t, ValidateTransferID(id))
+}
+
+func TestValidateTransferID_Rejects(t *testing.T) {
+	for _, id := range []string{"", "../etc/passwd", "a/b", ".hidden", "-flag", "a b", string(make([]byte, MaxTransferIDLength+1))} {
+		err := ValidateTransferID(id)
+		assert.True(t, errors.Is(err, ErrInvalidTransferID), "id %q", id)
+	}
+}
+
+// =============================================================================
+// Chunk Planning Tests
+// =============================================================================
+
+func TestNormalizeChunkSize(t *testing.T) {
+	assert.Equal(t, DefaultChunkSize, NormalizeChunkSize(0))
+	assert.Equal(t, MinChunkSize, NormalizeChunkSize(10))
+	assert.Equal(t, MaxChunkSize, NormalizeChunkSize(MaxChunkSize*2))
+	assert.Equal(t, int64(8<<20), NormalizeChunkSize(8<<20))
+}
+
+func TestChunkLength(t *testing.T) {
+	assert.Equal(t, int64(100), ChunkLength(0, 250, 100))
+	assert.Equal(t, int64(50), ChunkLength(200, 250, 100))
+	assert.Equal(t, int64(0), ChunkLength(250, 250, 100))
+	assert.Equal(t, int64(0), ChunkLength(0, 0, 100))
+}
+
+func TestResumeOffset(t *testing.T) {
+	assert.Equal(t, int64(0), ResumeOffset(0, 1000))
+	assert.Equal(t, int64(400), ResumeOffset(400, 1000))
+	assert.Equal(t, int64(1000), ResumeOffset(1000, 1000))
+	assert.Equal(t, int64(0), ResumeOffset(1200, 1000), "oversized stage restarts")
+	assert.Equal(t, int64(0), ResumeOffset(-1, 1000))
+}
+
+func TestProgress_Percent(t *testing.T) {
+	assert.Equal(t, 100.0, Progress{}.Percent())
+	assert.Equal(t, 25.0, Progress{TotalBytes: 400, TransferredBytes: 100}.Percent())
+	assert.Equal(t, 100.0, Progress{TotalBytes: 400, TransferredBytes: 500}.Percent())
+}
+
+// =============================================================================
+// Manifest Tests
+// =============================================================================
+
+func TestManifestDigest_OrderIndependent(t *testing.T) {
+	a := []ManifestEntry{
+		{Path: "data", Type: EntryDir, Mode: 0o755},
+		{Path: "data/db.sqlite", Type: EntryFile, Mode: 0o644, Size: 10, SHA256: "aa"},
+		{Path: "current", Type: EntrySymlink, Mode: 0o777, Link: "data"},
+	}
+	b := []ManifestEntry{a[2], a[0], a[1]}
+	assert.Equal(t, ManifestDigest(a), ManifestDigest(b))
+	assert.Equal(t, "current", a[2].Path, "input is not reordered")
+}
+
+func TestManifestDigest_DetectsChanges(t *testing.T) {
+	base := []ManifestEntry{{Path: "f", Type: EntryFile, Mode: 0o644, Size: 3, SHA256: "aa"}}
+	digest := ManifestDigest(base)
+
+	changes := []ManifestEntry{
+		{Path: "g", Type: EntryFile, Mode: 0o644, Size: 3, SHA256: "aa"},
+		{Path: "f", Type: EntryFile, Mode: 0o600, Size: 3, SHA256: "aa"},
+		{Path: "f", Type: EntryFile, Mode: 0o644, Size: 4, SHA256: "aa"},
+		{Path: "f", Type: EntryFile, Mode: 0o644, Size: 3, SHA256: "bb"},
+		{Path: "f", Type: EntrySymlink, Mode: 0o644, Size: 3, SHA256: "aa"},
+	}
+	for _, c := range changes {
+		assert.NotEqual(t, digest, ManifestDigest([]ManifestEntry{c}), "%+v", c)
+	}
+	assert.NotEqual(t, ManifestDigest(nil), digest)
+}
+
+func TestManifestDigest_IgnoresFileTypeBits(t *testing.T) {
+	a := []ManifestEntry{{Path: "d", Type: EntryDir, Mode: 0o755}}
+	b := []ManifestEntry{{Path: "d", Type: EntryDir, Mode: 0o40755}}
+	assert.Equal(t, ManifestDigest(a), ManifestDigest(b))
+}
+
+// =============================================================================
+// Verification Tests
+// =============================================================================
+
+func TestVerifyChunk(t *testing.T) {
+	ok := minion.VolumeChunkResult{Offset: 100, Written: 50, SHA256: "abc", StagedSize: 150}
+	require.NoError(t, VerifyChunk(100, 50, "abc", ok))
+
+	short := ok
+	short.Written = 40
+	assert.ErrorIs(t, VerifyChunk(100, 50, "abc", short), ErrChunkMismatch)
+
+	wrongOffset := ok
+	wrongOffset.Offset = 0
+	assert.ErrorIs(t, VerifyChunk(100, 50, "abc", wrongOffset), ErrChunkMismatch)
+
+	assert.ErrorIs(t, VerifyChunk(100, 50, "def", ok), ErrChunkMismatch)
+}
+
+func TestVerifyRestore(t *testing.T) {
+	src := minion.VolumeSnapshot{Size: 1024, SHA256: "s", Files: 3, ManifestDigest: "m"}
+	dst := minion.VolumeRestoreResult{Size: 1024, SHA256: "s", Files: 3, ManifestDigest: "m"}
+	require.NoError(t, VerifyRestore(src, dst))
+
+	badSum := dst
+	badSum.SHA256 = "x"
+	assert.ErrorIs(t, VerifyRestore(src, badSum), ErrManifestMismatch)
+
+	badFiles := dst
+	badFiles.Files = 2
+	assert.ErrorIs(t, VerifyRestore(src, badFiles), ErrManifestMismatch)
+
+	badDigest := dst
+	badDigest.ManifestDigest = "other"
+	assert.ErrorIs(t, VerifyRestore(src, badDigest), ErrManifestMismatch)
+}
@@ -0,0 +1,104 @@
+// Package tunnel provides pure functions for deployment tunnels: sessions
+// that relay a client's TCP connection through the control plane to a port
+// of one of a deployment's containers, so services without public routing
+// can still be reached. It covers tunnel targets, session time limits and
+// bandwidth caps.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package tunnel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Targets
+// =============================================================================
+
+// Target is the container port a tunnel connects to.
+type Target struct {
+	// Service names the compose service whose container is reached.
+	Service string
+	// Port is the container port.
+	Port int
+}
+
+// String formats the target as "service:port".
+func (t Target) String() string {
+	return t.Service + ":" + strconv.Itoa(t.Port)
+}
+
+// ParseTarget parses a "service:port" target.
+func ParseTarget(s string) (Target, error) {
+	service, port, ok := strings.Cut(s, ":")
+	if !ok || service == "" {
+		return Target{}, fmt.Errorf("target must be service:port, got %q", s)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return Target{}, fmt.Errorf("target port must be between 1 and 65535, got %q", port)
+	}
+	return Target{Service: service, Port: n}, nil
+}
+
+// =============================================================================
+// Sessions
+// =============================================================================
+
+const (
+	// MinDuration is the shortest session a client may ask for, and the
+	// shortest limit an operator may configure.
+	MinDuration = time.Minute
+	// DefaultMaxDuration is how long a session may last when not configured.
+	DefaultMaxDuration = time.Hour
+)
+
+// SessionDuration returns how long a session may last: the requested
+// duration, or max when none was requested. A request over max is refused
+// rather than cut short, so the client knows when the tunnel will close.
+func SessionDuration(requested, max time.Duration) (time.Duration, error) {
+	if requested == 0 {
+		return max, nil
+	}
+	if requested < MinDuration || requested > max {
+		return 0, fmt.Errorf("duration must be between %s and %s, got %s", MinDuration, max, requested)
+	}
+	return requested, nil
+}
+
+// EndReason says why a session ended.
+type EndReason string
+
+const (
+	// EndClosed means the client or the container closed the connection.
+	EndClosed EndReason = "closed"
+	// EndExpired means the session reached its time limit.
+	EndExpired EndReason = "expired"
+	// EndFailed means the tunnel broke; the session's error says why.
+	EndFailed EndReason = "failed"
+)
+
+// =============================================================================
+// Bandwidth
+// =============================================================================
+
+// Throttle caps the bandwidth of one direction of a session.
+type Throttle struct {
+	// BytesPerSecond is the cap; 0 means unlimited.
+	BytesPerSecond int64
+}
+
+// Delay returns how long to wait before sending more, after sent bytes
+// went out in elapsed time, to keep the average rate under the cap.
+func (t Throttle) Delay(sent int64, elapsed time.Duration) time.Duration {
+	if t.BytesPerSecond <= 0 || sent <= 0 {
+		return 0
+	}
+	due := time.Duration(float64(sent) / float64(t.BytesPerSecond) * float64(time.Second))
+	if due <= elapsed {
+		return 0
+	}
+	return due - elapsed
+}
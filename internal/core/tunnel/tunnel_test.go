@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Target Tests
+// =============================================================================
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("db:5432")
+	require.NoError(t, err)
+	assert.Equal(t, Target{Service: "db", Port: 5432}, target)
+	assert.Equal(t, "db:5432", target.String())
+
+	for _, s := range []string{"", "db", ":5432", "db:", "db:http", "db:0", "db:70000"} {
+		_, err := ParseTarget(s)
+		assert.Error(t, err, s)
+	}
+}
+
+// =============================================================================
+// Session Tests
+// =============================================================================
+
+func TestSessionDuration(t *testing.T) {
+	d, err := SessionDuration(0, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, d, "no request gets the limit")
+
+	d, err = SessionDuration(15*time.Minute, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, d)
+
+	_, err = SessionDuration(2*time.Hour, time.Hour)
+	assert.Error(t, err, "over the limit")
+	_, err = SessionDuration(time.Second, time.Hour)
+	assert.Error(t, err, "under the minimum")
+}
+
+// =============================================================================
+// Bandwidth Tests
+// =============================================================================
+
+func TestThrottle_Delay(t *testing.T) {
+	assert.Zero(t, Throttle{}.Delay(1<<30, 0), "unlimited")
+
+	th := Throttle{BytesPerSecond: 1000}
+	assert.Equal(t, 2*time.Second, th.Delay(2000, 0))
+	assert.Equal(t, 500*time.Millisecond, th.Delay(2000, 1500*time.Millisecond))
+	assert.Zero(t, th.Delay(2000, 3*time.Second), "under the cap")
+}
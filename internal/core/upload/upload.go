@@ -0,0 +1,209 @@
+// Package upload provides pure functions for uploading template config
+// files and assets from a browser: the per-plan size caps, the checks that
+// keep executables and known malware out, and the byte ranges of chunked
+// uploads.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/artpar/hoster/internal/core/registry"
+)
+
+// =============================================================================
+// Kinds
+// =============================================================================
+
+// Kind is what an uploaded file becomes on its template.
+type Kind string
+
+const (
+	KindConfigFile Kind = "config_file" // Mounted into the template's containers
+	KindAsset      Kind = "asset"       // Shipped with the template, such as its logo
+)
+
+// ParseKind parses a kind name.
+func ParseKind(s string) (Kind, error) {
+	switch k := Kind(s); k {
+	case KindConfigFile, KindAsset:
+		return k, nil
+	}
+	return "", fmt.Errorf("invalid upload kind %q", s)
+}
+
+// =============================================================================
+// Size Caps
+// =============================================================================
+
+// MaxConfigFileBytes caps one config file whatever the plan.
+const MaxConfigFileBytes = 1 << 20
+
+// MaxBytes returns the size cap of one file of kind for a plan's upload
+// limit in KiB. The plan can lower the kind's own cap but not raise it; a
+// plan without a limit gets the kind's cap.
+func MaxBytes(kind Kind, planKB int64) int64 {
+	max := int64(MaxConfigFileBytes)
+	if kind == KindAsset {
+		max = registry.MaxAssetBytes
+	}
+	if planKB > 0 && planKB<<10 < max {
+		return planKB << 10
+	}
+	return max
+}
+
+// =============================================================================
+// Content Checks
+// =============================================================================
+
+// Content errors.
+var (
+	ErrBlockedType = errors.New("file type is not allowed")
+	ErrInfected    = errors.New("file contains malware")
+	ErrNotText     = errors.New("config files must be UTF-8 text")
+)
+
+// blockedExtensions are executable and installer formats, which have no
+// place in a template's config files or assets.
+var blockedExtensions = map[string]bool{
+	".apk": true, ".app": true, ".bat": true, ".bin": true, ".cmd": true,
+	".com": true, ".cpl": true, ".deb": true, ".dll": true, ".dmg": true,
+	".exe": true, ".iso": true, ".jar": true, ".msi": true, ".pif": true,
+	".ps1": true, ".rpm": true, ".scr": true, ".so": true, ".vbs": true,
+}
+
+// executableMagic are the leading bytes of native executables.
+var executableMagic = [][]byte{
+	[]byte("MZ"),             // Windows PE
+	[]byte("\x7fELF"),        // Linux ELF
+	{0xfe, 0xed, 0xfa, 0xce}, // Mach-O 32-bit
+	{0xfe, 0xed, 0xfa, 0xcf}, // Mach-O 64-bit
+	{0xcf, 0xfa, 0xed, 0xfe}, // Mach-O 64-bit, little endian
+	{0xca, 0xfe, 0xba, 0xbe}, // Mach-O universal
+}
+
+// eicar is the EICAR anti-virus test file, which scanners report as
+// malware; it is caught even without a scanner configured.
+var eicar = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// CheckName checks an uploaded file's name. Executable and installer
+// extensions are refused.
+func CheckName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	if ext := strings.ToLower(path.Ext(name)); blockedExtensions[ext] {
+		return fmt.Errorf("%w: %s", ErrBlockedType, ext)
+	}
+	return nil
+}
+
+// CheckContent checks an uploaded file's content. Native executables and
+// the EICAR test file are refused, and config files must be text.
+func CheckContent(kind Kind, data []byte) error {
+	for _, magic := range executableMagic {
+		if bytes.HasPrefix(data, magic) {
+			return fmt.Errorf("%w: executable", ErrBlockedType)
+		}
+	}
+	if bytes.Contains(data, eicar) {
+		return fmt.Errorf("%w: EICAR test file", ErrInfected)
+	}
+	if kind == KindConfigFile && !utf8.Valid(data) {
+		return ErrNotText
+	}
+	return nil
+}
+
+// CheckConfigPath checks the path a config file is mounted at.
+func CheckConfigPath(p string) error {
+	if !path.IsAbs(p) || path.Clean(p) != p || p == "/" {
+		return fmt.Errorf("config file path must be a clean absolute path, got %q", p)
+	}
+	return nil
+}
+
+// =============================================================================
+// Chunked Uploads
+// =============================================================================
+
+const (
+	// ChunkBytes is the chunk size clients are told to send. Any size up to
+	// the file's cap is accepted.
+	ChunkBytes = 64 << 10
+	// SessionTTL is how long a chunked upload may take to finish.
+	SessionTTL = 24 * time.Hour
+)
+
+// Status is where a chunked upload is.
+type Status string
+
+const (
+	StatusPending  Status = "pending"  // Waiting for more chunks
+	StatusComplete Status = "complete" // Every byte arrived and the file is on its template
+)
+
+// Chunk errors.
+var (
+	ErrOutOfOrder   = errors.New("chunk does not start where the upload left off")
+	ErrSizeMismatch = errors.New("chunk does not match the upload's size")
+)
+
+// Range is the span of a chunk within its file: bytes Start through End
+// inclusive, of Total.
+type Range struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+// Len returns the number of bytes in the chunk.
+func (r Range) Len() int64 {
+	return r.End - r.Start + 1
+}
+
+// ParseContentRange parses a Content-Range header such as
+// "bytes 0-65535/200000".
+func ParseContentRange(s string) (Range, error) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	start, end, ok := strings.Cut(span, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	var r Range
+	var err1, err2, err3 error
+	r.Start, err1 = strconv.ParseInt(start, 10, 64)
+	r.End, err2 = strconv.ParseInt(end, 10, 64)
+	r.Total, err3 = strconv.ParseInt(total, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || r.Start < 0 || r.End < r.Start || r.End >= r.Total {
+		return Range{}, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return r, nil
+}
+
+// Check checks that a chunk continues an upload of size bytes of which
+// received have arrived.
+func (r Range) Check(received, size int64) error {
+	if r.Total != size {
+		return fmt.Errorf("%w: total %d, upload is %d bytes", ErrSizeMismatch, r.Total, size)
+	}
+	if r.Start != received {
+		return fmt.Errorf("%w: starts at %d, expected %d", ErrOutOfOrder, r.Start, received)
+	}
+	return nil
+}
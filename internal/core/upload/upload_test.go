@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKind(t *testing.T) {
+	k, err := ParseKind("asset")
+	require.NoError(t, err)
+	assert.Equal(t, KindAsset, k)
+
+	_, err = ParseKind("binary")
+	assert.Error(t, err)
+}
+
+func TestMaxBytes(t *testing.T) {
+	assert.Equal(t, int64(MaxConfigFileBytes), MaxBytes(KindConfigFile, 0))
+	assert.Equal(t, int64(registry.MaxAssetBytes), MaxBytes(KindAsset, 0))
+	assert.Equal(t, int64(64<<10), MaxBytes(KindConfigFile, 64))
+	assert.Equal(t, int64(registry.MaxAssetBytes), MaxBytes(KindAsset, 4096), "the plan cannot raise the kind's cap")
+}
+
+// =============================================================================
+// Content Check Tests
+// =============================================================================
+
+func TestCheckName(t *testing.T) {
+	assert.NoError(t, CheckName("nginx.conf"))
+	assert.NoError(t, CheckName("entrypoint.sh"))
+	assert.ErrorIs(t, CheckName("setup.EXE"), ErrBlockedType)
+	assert.ErrorIs(t, CheckName("lib.so"), ErrBlockedType)
+	assert.Error(t, CheckName("../etc/passwd"))
+	assert.Error(t, CheckName(""))
+}
+
+func TestCheckContent(t *testing.T) {
+	assert.NoError(t, CheckContent(KindConfigFile, []byte("server { listen 80; }")))
+	assert.NoError(t, CheckContent(KindAsset, []byte{0x89, 'P', 'N', 'G', 0xff}))
+
+	assert.ErrorIs(t, CheckContent(KindAsset, []byte("\x7fELF\x02\x01")), ErrBlockedType)
+	assert.ErrorIs(t, CheckContent(KindAsset, []byte("MZ\x90\x00")), ErrBlockedType)
+	assert.ErrorIs(t, CheckContent(KindAsset, append([]byte("prefix "), eicar...)), ErrInfected)
+	assert.ErrorIs(t, CheckContent(KindConfigFile, []byte{0xff, 0xfe, 0x00}), ErrNotText)
+}
+
+func TestCheckConfigPath(t *testing.T) {
+	assert.NoError(t, CheckConfigPath("/etc/nginx/nginx.conf"))
+	assert.Error(t, CheckConfigPath("etc/nginx.conf"))
+	assert.Error(t, CheckConfigPath("/etc/../root/.ssh"))
+	assert.Error(t, CheckConfigPath("/"))
+}
+
+// =============================================================================
+// Chunked Upload Tests
+// =============================================================================
+
+func TestParseContentRange(t *testing.T) {
+	r, err := ParseContentRange("bytes 0-65535/200000")
+	require.NoError(t, err)
+	assert.Equal(t, Range{Start: 0, End: 65535, Total: 200000}, r)
+	assert.Equal(t, int64(65536), r.Len())
+
+	for _, s := range []string{"", "bytes */200", "bytes 5-4/10", "bytes 0-10/10", "items 0-1/2", "bytes a-b/c"} {
+		_, err := ParseContentRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRangeCheck(t *testing.T) {
+	r := Range{Start: 100, End: 199, Total: 300}
+	assert.NoError(t, r.Check(100, 300))
+	assert.ErrorIs(t, r.Check(50, 300), ErrOutOfOrder)
+	assert.ErrorIs(t, r.Check(100, 400), ErrSizeMismatch)
+}
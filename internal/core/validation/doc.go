@@ -9,6 +9,12 @@
 //   - ValidateCreateTemplateFields: Validate required fields for template creation
 //   - CanUpdateTemplate: Check if a template can be updated
 //   - CanCreateDeployment: Check if a deployment can be created from a template
+//   - ValidateSetupFlow: Validate a template's guided setup flow against its variables
+//   - CheckSetupComplete: Check a deployment supplies every variable the setup flow asks for
+//   - ValidateResourceCeilings: Validate the resource ceilings a creator sets on a template
+//   - ValidateServiceOverrides: Validate a customer's per-service resource overrides
+//   - CheckResourceCeilings: Check a deployment's requested resources against its template's ceilings
+//   - ValidateDeploymentLabels: Validate the labels a customer adds to a deployment's containers
 //
 // # Usage
 //
@@ -0,0 +1,59 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// =============================================================================
+// Deployment Label Validation Functions
+// =============================================================================
+
+const (
+	// MaxDeploymentLabels is how many labels a deployment may define.
+	MaxDeploymentLabels = 32
+	// MaxLabelKeyLength is the longest label key accepted, before the
+	// hoster.user. prefix is added.
+	MaxLabelKeyLength = 63
+	// MaxLabelValueLength is the longest label value accepted.
+	MaxLabelValueLength = 255
+)
+
+// labelKeyPattern accepts lowercase keys in the style of Docker's label
+// guidelines: alphanumerics separated by single dots, dashes or underscores.
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// ValidateDeploymentLabels validates the labels a customer defines on a
+// deployment, which are added to each of its containers.
+// Returns the field path and error message of the first problem found.
+// Returns empty strings if the labels are valid.
+//
+// Example:
+//
+//	if field, msg := ValidateDeploymentLabels(labels); field != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func ValidateDeploymentLabels(labels map[string]string) (field, message string) {
+	if len(labels) > MaxDeploymentLabels {
+		return "labels", fmt.Sprintf("at most %d labels are allowed, got %d", MaxDeploymentLabels, len(labels))
+	}
+	for _, key := range sortedKeys(labels) {
+		path := "labels." + key
+		if len(key) > MaxLabelKeyLength {
+			return path, fmt.Sprintf("label keys can be at most %d characters", MaxLabelKeyLength)
+		}
+		if !labelKeyPattern.MatchString(key) {
+			return path, "label keys must be lowercase letters and digits, separated by single '.', '-' or '_'"
+		}
+		value := labels[key]
+		if len(value) > MaxLabelValueLength {
+			return path, fmt.Sprintf("label values can be at most %d characters", MaxLabelValueLength)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return path, "label values cannot contain control characters"
+		}
+	}
+	return "", ""
+}
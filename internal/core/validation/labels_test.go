@@ -0,0 +1,49 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeploymentLabels(t *testing.T) {
+	field, _ := ValidateDeploymentLabels(map[string]string{"team": "payments", "app.tier": "web", "cost-center": "", "env_2": "prod"})
+	assert.Empty(t, field)
+
+	field, _ = ValidateDeploymentLabels(nil)
+	assert.Empty(t, field)
+
+	for _, key := range []string{"Team", "team..a", ".team", "team-", "te am", "team=x", "hoster/team", ""} {
+		field, msg := ValidateDeploymentLabels(map[string]string{key: "x"})
+		assert.Equal(t, "labels."+key, field, key)
+		assert.Contains(t, msg, "label keys must", key)
+	}
+
+	field, msg := ValidateDeploymentLabels(map[string]string{strings.Repeat("a", MaxLabelKeyLength+1): "x"})
+	assert.NotEmpty(t, field)
+	assert.Contains(t, msg, "at most 63")
+
+	field, msg = ValidateDeploymentLabels(map[string]string{"team": strings.Repeat("a", MaxLabelValueLength+1)})
+	assert.Equal(t, "labels.team", field)
+	assert.Contains(t, msg, "at most 255")
+
+	field, msg = ValidateDeploymentLabels(map[string]string{"team": "a\nb"})
+	assert.Equal(t, "labels.team", field)
+	assert.Contains(t, msg, "control characters")
+}
+
+func TestValidateDeploymentLabels_Count(t *testing.T) {
+	labels := make(map[string]string, MaxDeploymentLabels+1)
+	for i := 0; i < MaxDeploymentLabels; i++ {
+		labels[fmt.Sprintf("k%d", i)] = "v"
+	}
+	field, _ := ValidateDeploymentLabels(labels)
+	assert.Empty(t, field)
+
+	labels["one-more"] = "v"
+	field, msg := ValidateDeploymentLabels(labels)
+	assert.Equal(t, "labels", field)
+	assert.Contains(t, msg, "at most 32")
+}
@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Resource Ceiling Validation Functions
+// =============================================================================
+
+// ServiceRequest is the resources one service asks for once a customer's
+// overrides are applied to the compose spec. Replicas below one count as one.
+type ServiceRequest struct {
+	Replicas int
+	MemoryMB int64
+	CPUCores float64 // Per container
+}
+
+// ResourceRequest is what a deployment asks for when it is created, scaled
+// or resized.
+type ResourceRequest struct {
+	Services map[string]ServiceRequest
+	CPUCores float64 // The deployment's own CPU reservation (resources_cpu_cores)
+}
+
+// TotalCPUCores returns the CPU the request needs: the larger of the
+// deployment's reservation and the sum over every service's containers.
+func (r ResourceRequest) TotalCPUCores() float64 {
+	sum := 0.0
+	for _, svc := range r.Services {
+		sum += svc.CPUCores * float64(max(svc.Replicas, 1))
+	}
+	return max(sum, r.CPUCores)
+}
+
+// ValidateResourceCeilings validates the ceilings a creator sets on a template.
+// Returns the field path and error message of the first problem found.
+// Returns empty strings if the ceilings are valid.
+//
+// Example:
+//
+//	field, msg := ValidateResourceCeilings(template.ResourceCeilings)
+//	if field != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func ValidateResourceCeilings(c domain.ResourceCeilings) (field, message string) {
+	if c.MaxReplicas < 0 {
+		return "resource_ceilings.max_replicas", "max_replicas cannot be negative"
+	}
+	if c.MaxServiceMemoryMB < 0 {
+		return "resource_ceilings.max_service_memory_mb", "max_service_memory_mb cannot be negative"
+	}
+	if c.MaxTotalCPUCores < 0 {
+		return "resource_ceilings.max_total_cpu_cores", "max_total_cpu_cores cannot be negative"
+	}
+	return "", ""
+}
+
+// ValidateServiceOverrides validates a customer's per-service overrides
+// against the services of the template's compose spec.
+// Returns the field path and error message of the first problem found.
+// Returns empty strings if the overrides are valid.
+func ValidateServiceOverrides(overrides map[string]domain.ServiceOverride, services []string) (field, message string) {
+	known := make(map[string]bool, len(services))
+	for _, name := range services {
+		known[name] = true
+	}
+	for _, name := range sortedKeys(overrides) {
+		o := overrides[name]
+		path := fmt.Sprintf("service_overrides.%s", name)
+		if !known[name] {
+			return path, fmt.Sprintf("unknown service %q", name)
+		}
+		if o.MemoryMB < 0 {
+			return path + ".memory_mb", "memory_mb cannot be negative"
+		}
+		if o.CPUCores < 0 {
+			return path + ".cpu_cores", "cpu_cores cannot be negative"
+		}
+	}
+	return "", ""
+}
+
+// CheckResourceCeilings checks a deployment's requested resources against
+// its template's ceilings. Zero ceilings are not enforced.
+// Returns the field path and error message of the first ceiling exceeded.
+// Returns empty strings if the request fits.
+//
+// Example:
+//
+//	field, msg := CheckResourceCeilings(template.ResourceCeilings, req)
+//	if field != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func CheckResourceCeilings(c domain.ResourceCeilings, req ResourceRequest) (field, message string) {
+	for _, name := range sortedKeys(req.Services) {
+		svc := req.Services[name]
+		path := fmt.Sprintf("service_overrides.%s", name)
+		if c.MaxReplicas > 0 && svc.Replicas > c.MaxReplicas {
+			return path + ".replicas", fmt.Sprintf("service %q requests %d replicas; the template allows at most %d", name, svc.Replicas, c.MaxReplicas)
+		}
+		if c.MaxServiceMemoryMB > 0 && svc.MemoryMB > c.MaxServiceMemoryMB {
+			return path + ".memory_mb", fmt.Sprintf("service %q requests %d MB of memory; the template allows at most %d MB per service", name, svc.MemoryMB, c.MaxServiceMemoryMB)
+		}
+	}
+	if total := req.TotalCPUCores(); c.MaxTotalCPUCores > 0 && total > c.MaxTotalCPUCores {
+		return "resources_cpu_cores", fmt.Sprintf("deployment requests %g CPU cores; the template allows at most %g", total, c.MaxTotalCPUCores)
+	}
+	return "", ""
+}
+
+// sortedKeys returns a map's keys in order, so the first problem reported is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
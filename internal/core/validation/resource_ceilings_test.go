@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResourceCeilings(t *testing.T) {
+	field, _ := ValidateResourceCeilings(domain.ResourceCeilings{MaxReplicas: 3, MaxServiceMemoryMB: 512, MaxTotalCPUCores: 2})
+	assert.Empty(t, field)
+
+	field, _ = ValidateResourceCeilings(domain.ResourceCeilings{})
+	assert.Empty(t, field, "zero ceilings mean no limit")
+
+	field, _ = ValidateResourceCeilings(domain.ResourceCeilings{MaxReplicas: -1})
+	assert.Equal(t, "resource_ceilings.max_replicas", field)
+	field, _ = ValidateResourceCeilings(domain.ResourceCeilings{MaxServiceMemoryMB: -1})
+	assert.Equal(t, "resource_ceilings.max_service_memory_mb", field)
+	field, _ = ValidateResourceCeilings(domain.ResourceCeilings{MaxTotalCPUCores: -0.5})
+	assert.Equal(t, "resource_ceilings.max_total_cpu_cores", field)
+}
+
+func TestValidateServiceOverrides(t *testing.T) {
+	services := []string{"web", "db"}
+
+	field, _ := ValidateServiceOverrides(map[string]domain.ServiceOverride{"web": {MemoryMB: 256, CPUCores: 0.5}}, services)
+	assert.Empty(t, field)
+
+	field, msg := ValidateServiceOverrides(map[string]domain.ServiceOverride{"cache": {MemoryMB: 64}}, services)
+	assert.Equal(t, "service_overrides.cache", field)
+	assert.Contains(t, msg, "unknown service")
+
+	field, _ = ValidateServiceOverrides(map[string]domain.ServiceOverride{"db": {MemoryMB: -1}}, services)
+	assert.Equal(t, "service_overrides.db.memory_mb", field)
+	field, _ = ValidateServiceOverrides(map[string]domain.ServiceOverride{"db": {CPUCores: -1}}, services)
+	assert.Equal(t, "service_overrides.db.cpu_cores", field)
+}
+
+func TestCheckResourceCeilings(t *testing.T) {
+	ceilings := domain.ResourceCeilings{MaxReplicas: 2, MaxServiceMemoryMB: 512, MaxTotalCPUCores: 2}
+
+	tests := []struct {
+		name  string
+		req   ResourceRequest
+		field string
+	}{
+		{
+			name: "within ceilings",
+			req: ResourceRequest{Services: map[string]ServiceRequest{
+				"web": {Replicas: 2, MemoryMB: 512, CPUCores: 0.5},
+				"db":  {Replicas: 1, MemoryMB: 256, CPUCores: 1},
+			}},
+		},
+		{
+			name:  "too many replicas",
+			req:   ResourceRequest{Services: map[string]ServiceRequest{"web": {Replicas: 3}}},
+			field: "service_overrides.web.replicas",
+		},
+		{
+			name:  "too much memory",
+			req:   ResourceRequest{Services: map[string]ServiceRequest{"db": {Replicas: 1, MemoryMB: 1024}}},
+			field: "service_overrides.db.memory_mb",
+		},
+		{
+			name: "replicas multiply cpu",
+			req: ResourceRequest{Services: map[string]ServiceRequest{
+				"web": {Replicas: 2, CPUCores: 1.5},
+			}},
+			field: "resources_cpu_cores",
+		},
+		{
+			name:  "deployment resize",
+			req:   ResourceRequest{CPUCores: 4},
+			field: "resources_cpu_cores",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, msg := CheckResourceCeilings(ceilings, tt.req)
+			assert.Equal(t, tt.field, field, msg)
+		})
+	}
+}
+
+func TestCheckResourceCeilings_ZeroIsUnlimited(t *testing.T) {
+	req := ResourceRequest{
+		Services: map[string]ServiceRequest{"web": {Replicas: 50, MemoryMB: 65536, CPUCores: 8}},
+		CPUCores: 64,
+	}
+	field, _ := CheckResourceCeilings(domain.ResourceCeilings{}, req)
+	assert.Empty(t, field)
+}
+
+func TestResourceRequest_TotalCPUCores(t *testing.T) {
+	req := ResourceRequest{Services: map[string]ServiceRequest{
+		"web": {Replicas: 3, CPUCores: 0.5},
+		"db":  {CPUCores: 1}, // zero replicas counts as one container
+	}}
+	assert.InDelta(t, 2.5, req.TotalCPUCores(), 1e-9)
+
+	req.CPUCores = 4
+	assert.InDelta(t, 4.0, req.TotalCPUCores(), 1e-9)
+}
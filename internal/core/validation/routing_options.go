@@ -0,0 +1,45 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Routing Option Validation Functions
+// =============================================================================
+
+// Bounds on routing options.
+const (
+	MaxWebSocketTimeoutSeconds = 24 * 60 * 60 // One day
+	MaxRequestBodyMB           = 10 * 1024    // 10 GiB
+)
+
+// cookieNameRegex matches cookie names browsers and proxies accept unquoted.
+var cookieNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateRoutingOptions validates the routing options of a template or
+// deployment. Returns the field path and error message of the first problem
+// found. Returns empty strings if the options are valid.
+//
+// Example:
+//
+//	field, msg := ValidateRoutingOptions(deployment.RoutingOptions)
+//	if field != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func ValidateRoutingOptions(o domain.RoutingOptions) (field, message string) {
+	if o.StickyCookie != "" && !cookieNameRegex.MatchString(o.StickyCookie) {
+		return "routing_options.sticky_cookie", "sticky_cookie must be 1-64 letters, digits, hyphens or underscores"
+	}
+	if o.WebSocketTimeoutSeconds < 0 || o.WebSocketTimeoutSeconds > MaxWebSocketTimeoutSeconds {
+		return "routing_options.websocket_timeout_seconds",
+			fmt.Sprintf("websocket_timeout_seconds must be between 0 and %d", MaxWebSocketTimeoutSeconds)
+	}
+	if o.MaxBodyMB < 0 || o.MaxBodyMB > MaxRequestBodyMB {
+		return "routing_options.max_body_mb", fmt.Sprintf("max_body_mb must be between 0 and %d", MaxRequestBodyMB)
+	}
+	return "", ""
+}
@@ -0,0 +1,33 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRoutingOptions(t *testing.T) {
+	sticky := true
+	field, _ := ValidateRoutingOptions(domain.RoutingOptions{
+		StickySessions:          &sticky,
+		StickyCookie:            "app_session-1",
+		WebSocketTimeoutSeconds: 3600,
+		MaxBodyMB:               50,
+	})
+	assert.Empty(t, field)
+
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{})
+	assert.Empty(t, field, "zero options keep the proxy's defaults")
+
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{StickyCookie: "bad cookie;"})
+	assert.Equal(t, "routing_options.sticky_cookie", field)
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{WebSocketTimeoutSeconds: -1})
+	assert.Equal(t, "routing_options.websocket_timeout_seconds", field)
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{WebSocketTimeoutSeconds: MaxWebSocketTimeoutSeconds + 1})
+	assert.Equal(t, "routing_options.websocket_timeout_seconds", field)
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{MaxBodyMB: -1})
+	assert.Equal(t, "routing_options.max_body_mb", field)
+	field, _ = ValidateRoutingOptions(domain.RoutingOptions{MaxBodyMB: MaxRequestBodyMB + 1})
+	assert.Equal(t, "routing_options.max_body_mb", field)
+}
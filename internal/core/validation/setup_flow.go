@@ -0,0 +1,128 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Setup Flow Validation Functions
+// =============================================================================
+
+// ValidateSetupFlow validates a template's guided setup flow against its variables.
+// Returns the field path and error message of the first problem found.
+// Returns empty strings if the flow is valid.
+//
+// Rules:
+//   - every step has a unique, non-empty id and a title
+//   - every referenced variable is declared on the template
+//   - a variable belongs to at most one step
+//   - a condition references a variable collected in an earlier step
+//
+// Example:
+//
+//	field, msg := ValidateSetupFlow(flow, template.Variables)
+//	if field != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func ValidateSetupFlow(flow domain.SetupFlow, vars []domain.Variable) (field, message string) {
+	if len(flow.Steps) == 0 {
+		return "setup_flow.steps", "setup flow must have at least one step"
+	}
+
+	declared := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		declared[v.Name] = true
+	}
+
+	stepIDs := make(map[string]bool, len(flow.Steps))
+	stepOf := make(map[string]string) // variable → step id
+	for i, step := range flow.Steps {
+		path := fmt.Sprintf("setup_flow.steps[%d]", i)
+
+		if strings.TrimSpace(step.ID) == "" {
+			return path + ".id", "step id is required"
+		}
+		if stepIDs[step.ID] {
+			return path + ".id", fmt.Sprintf("duplicate step id %q", step.ID)
+		}
+		stepIDs[step.ID] = true
+
+		if strings.TrimSpace(step.Title) == "" {
+			return path + ".title", "step title is required"
+		}
+
+		// Conditions may only depend on variables from earlier steps
+		if c := step.Condition; c != nil {
+			if c.Variable == "" {
+				return path + ".condition.variable", "condition variable is required"
+			}
+			if !declared[c.Variable] {
+				return path + ".condition.variable", fmt.Sprintf("unknown variable %q", c.Variable)
+			}
+			if _, earlier := stepOf[c.Variable]; !earlier {
+				return path + ".condition.variable", fmt.Sprintf("variable %q must be collected in an earlier step", c.Variable)
+			}
+		}
+
+		for _, name := range step.Variables {
+			if !declared[name] {
+				return path + ".variables", fmt.Sprintf("unknown variable %q", name)
+			}
+			if owner, dup := stepOf[name]; dup {
+				return path + ".variables", fmt.Sprintf("variable %q already belongs to step %q", name, owner)
+			}
+			stepOf[name] = step.ID
+		}
+	}
+
+	return "", ""
+}
+
+// CheckSetupComplete checks that a deployment supplies every required variable
+// that the setup flow actually asks for. Variables in steps hidden by a condition
+// are not required; variables outside every step are required as usual.
+// A variable with a default is always satisfied.
+// Returns the variable name and error message of the first missing value.
+//
+// Example:
+//
+//	name, msg := CheckSetupComplete(flow, template.Variables, deploymentVars)
+//	if name != "" {
+//	    // Return 400 Bad Request with msg
+//	}
+func CheckSetupComplete(flow domain.SetupFlow, vars []domain.Variable, values map[string]string) (variable, message string) {
+	inStep := make(map[string]bool)
+	for _, step := range flow.Steps {
+		for _, name := range step.Variables {
+			inStep[name] = true
+		}
+	}
+	asked := make(map[string]string) // variable → step title
+	for _, step := range flow.ActiveSteps(vars, values) {
+		for _, name := range step.Variables {
+			asked[name] = step.Title
+		}
+	}
+
+	for _, v := range vars {
+		if !v.Required || v.Default != "" {
+			continue
+		}
+		stepTitle, isAsked := asked[v.Name]
+		if inStep[v.Name] && !isAsked {
+			continue // hidden by a condition
+		}
+		if strings.TrimSpace(values[v.Name]) != "" {
+			continue
+		}
+		if isAsked {
+			return v.Name, fmt.Sprintf("setup incomplete: %q is required in step %q", v.Name, stepTitle)
+		}
+		return v.Name, fmt.Sprintf("setup incomplete: %q is required", v.Name)
+	}
+
+	return "", ""
+}
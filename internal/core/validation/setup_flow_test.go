@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupFlowVars() []domain.Variable {
+	return []domain.Variable{
+		{Name: "DB_MODE", Type: domain.VarTypeSelect, Options: []string{"embedded", "external"}, Default: "embedded"},
+		{Name: "DB_URL", Type: domain.VarTypeString, Required: true},
+		{Name: "ADMIN_EMAIL", Type: domain.VarTypeString, Required: true},
+		{Name: "THEME", Type: domain.VarTypeString},
+	}
+}
+
+func setupFlow() domain.SetupFlow {
+	return domain.SetupFlow{Steps: []domain.SetupStep{
+		{ID: "database", Title: "Database", Help: "Choose **embedded** for testing.", Variables: []string{"DB_MODE"}},
+		{ID: "external-db", Title: "External database", Variables: []string{"DB_URL"},
+			Condition: &domain.StepCondition{Variable: "DB_MODE", Equals: "external"}},
+		{ID: "admin", Title: "Admin", Variables: []string{"ADMIN_EMAIL", "THEME"}},
+	}}
+}
+
+// =============================================================================
+// ValidateSetupFlow Tests
+// =============================================================================
+
+func TestValidateSetupFlow_Valid(t *testing.T) {
+	field, msg := ValidateSetupFlow(setupFlow(), setupFlowVars())
+	assert.Empty(t, field)
+	assert.Empty(t, msg)
+}
+
+func TestValidateSetupFlow_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(f *domain.SetupFlow)
+		field  string
+	}{
+		{"no steps", func(f *domain.SetupFlow) { f.Steps = nil }, "setup_flow.steps"},
+		{"missing id", func(f *domain.SetupFlow) { f.Steps[0].ID = "" }, "setup_flow.steps[0].id"},
+		{"duplicate id", func(f *domain.SetupFlow) { f.Steps[2].ID = "database" }, "setup_flow.steps[2].id"},
+		{"missing title", func(f *domain.SetupFlow) { f.Steps[1].Title = " " }, "setup_flow.steps[1].title"},
+		{"unknown variable", func(f *domain.SetupFlow) { f.Steps[2].Variables = []string{"NOPE"} }, "setup_flow.steps[2].variables"},
+		{"variable in two steps", func(f *domain.SetupFlow) { f.Steps[2].Variables = []string{"DB_MODE"} }, "setup_flow.steps[2].variables"},
+		{"condition on unknown variable", func(f *domain.SetupFlow) { f.Steps[1].Condition.Variable = "NOPE" }, "setup_flow.steps[1].condition.variable"},
+		{"condition on later step", func(f *domain.SetupFlow) { f.Steps[1].Condition.Variable = "ADMIN_EMAIL" }, "setup_flow.steps[1].condition.variable"},
+		{"condition on own step", func(f *domain.SetupFlow) { f.Steps[1].Condition.Variable = "DB_URL" }, "setup_flow.steps[1].condition.variable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := setupFlow()
+			tt.mutate(&flow)
+			field, msg := ValidateSetupFlow(flow, setupFlowVars())
+			assert.Equal(t, tt.field, field)
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
+
+// =============================================================================
+// CheckSetupComplete Tests
+// =============================================================================
+
+func TestCheckSetupComplete_HiddenStepNotRequired(t *testing.T) {
+	name, msg := CheckSetupComplete(setupFlow(), setupFlowVars(), map[string]string{
+		"ADMIN_EMAIL": "a@example.com",
+	})
+	assert.Empty(t, name)
+	assert.Empty(t, msg)
+}
+
+func TestCheckSetupComplete_ActiveStepRequired(t *testing.T) {
+	name, msg := CheckSetupComplete(setupFlow(), setupFlowVars(), map[string]string{
+		"DB_MODE":     "external",
+		"ADMIN_EMAIL": "a@example.com",
+	})
+	assert.Equal(t, "DB_URL", name)
+	assert.Contains(t, msg, "External database")
+}
+
+func TestCheckSetupComplete_UngroupedRequired(t *testing.T) {
+	vars := append(setupFlowVars(), domain.Variable{Name: "LICENSE", Required: true})
+	name, msg := CheckSetupComplete(setupFlow(), vars, map[string]string{
+		"ADMIN_EMAIL": "a@example.com",
+	})
+	assert.Equal(t, "LICENSE", name)
+	assert.Equal(t, `setup incomplete: "LICENSE" is required`, msg)
+}
+
+func TestCheckSetupComplete_BlankValueIsMissing(t *testing.T) {
+	name, _ := CheckSetupComplete(setupFlow(), setupFlowVars(), map[string]string{
+		"ADMIN_EMAIL": "  ",
+	})
+	assert.Equal(t, "ADMIN_EMAIL", name)
+}
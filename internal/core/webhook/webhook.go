@@ -0,0 +1,325 @@
+// Package webhook provides pure functions for outgoing webhooks: signing
+// payloads, the retry schedule of failed deliveries, validating endpoints and
+// replay requests, truncating recorded responses, and the redacted
+// deployment data sent to template webhooks.
+// Following ADR-002: Values as Boundaries - this package contains NO I/O.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/secrets"
+)
+
+// =============================================================================
+// Deliveries
+// =============================================================================
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "Hoster-Signature"
+	EventIDHeader   = "Hoster-Event-Id"
+	EventTypeHeader = "Hoster-Event-Type"
+)
+
+// DeliveryStatus is where a delivery stands.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is waiting for its next attempt, or for its webhook to
+	// be resumed.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded got a 2xx response.
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryFailed ran out of attempts.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Valid reports whether s is a delivery status.
+func (s DeliveryStatus) Valid() bool {
+	return s == DeliveryPending || s == DeliverySucceeded || s == DeliveryFailed
+}
+
+// RetrySchedule is how long after each failed attempt the next one is made.
+// A delivery is given up after len(RetrySchedule)+1 attempts.
+var RetrySchedule = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+}
+
+// MaxAttempts is how many times a delivery is attempted.
+var MaxAttempts = len(RetrySchedule) + 1
+
+// NextAttempt returns when to retry a delivery that has failed attempts
+// times, the last at last. ok is false once the delivery is out of attempts.
+func NextAttempt(attempts int, last time.Time) (time.Time, bool) {
+	if attempts < 1 || attempts > len(RetrySchedule) {
+		return time.Time{}, false
+	}
+	return last.Add(RetrySchedule[attempts-1]), true
+}
+
+// Succeeded reports whether a response status acknowledges a delivery.
+func Succeeded(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// MaxResponseBodyBytes bounds the response body kept with a delivery.
+const MaxResponseBodyBytes = 2048
+
+// TruncateBody returns a response body as text, cut to MaxResponseBodyBytes
+// at a character boundary.
+func TruncateBody(body []byte) string {
+	if len(body) <= MaxResponseBodyBytes {
+		return strings.ToValidUTF8(string(body), "�")
+	}
+	cut := MaxResponseBodyBytes
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return strings.ToValidUTF8(string(body[:cut]), "�") + "…"
+}
+
+// =============================================================================
+// Payloads and Signatures
+// =============================================================================
+
+// Payload is the JSON body of a delivery. Replays send the same ID, so
+// consumers can drop events they have already handled.
+type Payload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Body encodes a delivery's payload.
+func Body(p Payload) ([]byte, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("encode webhook payload: %w", err)
+	}
+	return b, nil
+}
+
+// Sign returns the SignatureHeader value for a body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + signature(secret, t, body)
+}
+
+func signature(secret []byte, t string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signature verification errors.
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Verify checks a SignatureHeader value against a body, as a consumer
+// would. Signatures older or newer than tolerance are refused, so a
+// captured delivery cannot be replayed against the consumer later.
+func Verify(secret []byte, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			v1 = v
+		}
+	}
+	sec, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || v1 == "" {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(v1), []byte(signature(secret, t, body))) {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// =============================================================================
+// Endpoints
+// =============================================================================
+
+// MinSecretLen is the shortest signing secret accepted.
+const MinSecretLen = 16
+
+// ValidateURL checks a webhook endpoint: an absolute https URL without
+// credentials.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("url is not a valid URL")
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url must be an absolute https URL")
+	}
+	if u.User != nil {
+		return fmt.Errorf("url must not contain credentials")
+	}
+	return nil
+}
+
+// ValidateSecret checks a signing secret.
+func ValidateSecret(secret string) error {
+	if len(secret) < MinSecretLen {
+		return fmt.Errorf("secret must be at least %d characters", MinSecretLen)
+	}
+	return nil
+}
+
+// =============================================================================
+// Replay
+// =============================================================================
+
+const (
+	// EventRetention is how long events are kept for replay.
+	EventRetention = 30 * 24 * time.Hour
+	// MaxReplayEventIDs bounds the event IDs in one replay request.
+	MaxReplayEventIDs = 100
+	// MaxReplayEvents bounds the events one replay re-enqueues.
+	MaxReplayEvents = 1000
+)
+
+// ReplayRequest selects events to deliver again: a time range, or a list of
+// event IDs.
+type ReplayRequest struct {
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	EventIDs []string   `json:"event_ids,omitempty"`
+}
+
+// Validate checks a replay request. A range needs from; to defaults to now.
+// Ranges must lie within EventRetention.
+func (r ReplayRequest) Validate(now time.Time) error {
+	hasRange := r.From != nil || r.To != nil
+	switch {
+	case hasRange && len(r.EventIDs) > 0:
+		return fmt.Errorf("give a time range or event_ids, not both")
+	case !hasRange && len(r.EventIDs) == 0:
+		return fmt.Errorf("give a time range (from, to) or event_ids")
+	}
+	if len(r.EventIDs) > 0 {
+		if len(r.EventIDs) > MaxReplayEventIDs {
+			return fmt.Errorf("at most %d event_ids can be replayed at once", MaxReplayEventIDs)
+		}
+		seen := make(map[string]bool, len(r.EventIDs))
+		for _, id := range r.EventIDs {
+			if id == "" {
+				return fmt.Errorf("event_ids must not be empty")
+			}
+			if seen[id] {
+				return fmt.Errorf("event %q listed twice", id)
+			}
+			seen[id] = true
+		}
+		return nil
+	}
+	if r.From == nil {
+		return fmt.Errorf("from is required with a time range")
+	}
+	from, to := r.Range(now)
+	if !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+	if from.Before(now.Add(-EventRetention)) {
+		return fmt.Errorf("events are kept for %d days; from is too early", int(EventRetention/(24*time.Hour)))
+	}
+	return nil
+}
+
+// Range returns the request's time range, with to defaulting to now.
+func (r ReplayRequest) Range(now time.Time) (from, to time.Time) {
+	to = now
+	if r.To != nil {
+		to = *r.To
+	}
+	if r.From != nil {
+		from = *r.From
+	}
+	return from, to
+}
+
+// =============================================================================
+// Template Events
+// =============================================================================
+
+// Redacted replaces a customer's secret values in template event data.
+const Redacted = "[redacted]"
+
+// TemplateDeployment is the data of a template event: what a template's
+// creator learns about a customer's deployment of it.
+type TemplateDeployment struct {
+	DeploymentID    string            `json:"deployment_id"`
+	Name            string            `json:"name"`
+	Status          string            `json:"status"`
+	TemplateID      string            `json:"template_id"`
+	TemplateVersion string            `json:"template_version,omitempty"`
+	CustomerID      string            `json:"customer_id,omitempty"`
+	NodeID          string            `json:"node_id,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+}
+
+// secretNameParts mark a variable name as holding a secret whatever its type.
+var secretNameParts = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE"}
+
+// RedactVariables returns a deployment's variable values with customer
+// secrets replaced by Redacted: values of password variables, of variables
+// whose name looks like a secret, of variables the template does not
+// declare, and secret references.
+func RedactVariables(declared []domain.Variable, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	types := make(map[string]domain.VariableType, len(declared))
+	for _, v := range declared {
+		types[v.Name] = v.Type
+	}
+	out := make(map[string]string, len(values))
+	for name, value := range values {
+		t, ok := types[name]
+		if !ok || t == domain.VarTypePassword || secretName(name) || secrets.IsReference(value) {
+			value = Redacted
+		}
+		out[name] = value
+	}
+	return out
+}
+
+func secretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, part := range secretNameParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Abuse Detection
+// =============================================================================
+//
+// After each collection, the container metrics collector checks a running
+// deployment's recent samples for usage anomalies and records its abuse
+// score. A deployment whose score reaches monitoring.AbuseFlagScore is
+// flagged: its node's creator and the administrators are notified, and when
+// the customer's plan policy says so its containers are throttled to
+// monitoring.ThrottleCPU. Administrators list flagged deployments at
+// /admin/abuse and dismiss false positives, which lifts the throttle and keeps
+// the deployment from being flagged again for abuseDismissPeriod.
+//
+// Plan policy comes from DefaultPlanLimits for the customer's plan ID, since
+// limits APIGate sends exist only for the duration of a request.
+
+const (
+	// abuseSampleWindow is how far back anomaly detection looks.
+	abuseSampleWindow = 24 * time.Hour
+	// abuseDismissPeriod is how long a dismissed deployment isn't flagged again.
+	abuseDismissPeriod = 7 * 24 * time.Hour
+)
+
+// usageSamples returns a deployment's samples collected since the cutoff,
+// grouped by service.
+func (s *Store) usageSamples(ctx context.Context, deploymentID int, since time.Time) (map[string][]monitoring.UsageSample, error) {
+	rows, err := s.db.QueryxContext(ctx,
+		`SELECT service, collected_at, cpu_percent, network_rx_bytes, network_tx_bytes FROM container_metrics
+		WHERE deployment_id = ? AND collected_at >= ? ORDER BY collected_at`,
+		deploymentID, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("query container metrics: %w", err)
+	}
+	defer rows.Close()
+
+	samples := map[string][]monitoring.UsageSample{}
+	for rows.Next() {
+		var service, at string
+		var sample monitoring.UsageSample
+		if err := rows.Scan(&service, &at, &sample.CPUPercent, &sample.RxBytes, &sample.TxBytes); err != nil {
+			return nil, fmt.Errorf("scan container metrics: %w", err)
+		}
+		sample.At, _ = time.Parse(time.RFC3339, at)
+		samples[service] = append(samples[service], sample)
+	}
+	return samples, rows.Err()
+}
+
+// userIDsByRef returns the IDs of the users with the given reference IDs.
+// Unknown reference IDs are skipped.
+func (s *Store) userIDsByRef(ctx context.Context, refs []string) ([]int, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`SELECT id FROM users WHERE reference_id IN (?) ORDER BY id`, refs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	if err := s.db.SelectContext(ctx, &ids, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("look up users: %w", err)
+	}
+	return ids, nil
+}
+
+// userAbuseAction returns the abuse action of a user's plan.
+func (s *Store) userAbuseAction(ctx context.Context, userID int) string {
+	var planID string
+	if err := s.db.GetContext(ctx, &planID, `SELECT COALESCE(plan_id, '') FROM users WHERE id = ?`, userID); err != nil {
+		return monitoring.AbuseActionFlag
+	}
+	if action := DefaultPlanLimits(planID).AbuseAction; action != "" {
+		return action
+	}
+	return monitoring.AbuseActionFlag
+}
+
+// serviceCPULimits returns the CPU limit, in cores, the deployment's
+// template declares for each service.
+func serviceCPULimits(ctx context.Context, store *Store, depl map[string]any) map[string]float64 {
+	cpu := map[string]float64{}
+	tmpl, err := store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+	if err != nil {
+		return cpu
+	}
+	limits, err := declaredLimits(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return cpu
+	}
+	for service, l := range limits {
+		cpu[service] = l.CPU
+	}
+	return cpu
+}
+
+// abuseFlaggable reports whether a deployment may be flagged: it isn't
+// already, and it wasn't dismissed recently.
+func abuseFlaggable(depl map[string]any, now time.Time) bool {
+	if _, flagged := parseTime(depl["abuse_flagged_at"]); flagged {
+		return false
+	}
+	dismissed, ok := parseTime(depl["abuse_dismissed_at"])
+	return !ok || now.Sub(dismissed) >= abuseDismissPeriod
+}
+
+// SetAbuseAlerts makes the collector notify node creators and the given
+// administrators (user reference IDs) of deployments flagged for abuse.
+func (c *ContainerMetricsCollector) SetAbuseAlerts(n *Notifier, admins []string) {
+	c.notifier = n
+	c.admins = admins
+}
+
+// detectAbuse scores a deployment's recent usage, flagging it and applying
+// its plan's abuse action when the score reaches the flag threshold.
+func (c *ContainerMetricsCollector) detectAbuse(depl map[string]any, now time.Time) {
+	refID := strVal(depl["reference_id"])
+	logger := c.logger.With("deployment", refID)
+
+	samples, err := c.store.usageSamples(c.ctx, toInt(depl["id"]), now.Add(-abuseSampleWindow))
+	if err != nil {
+		logger.Error("failed to load usage samples", "error", err)
+		return
+	}
+	limits := serviceCPULimits(c.ctx, c.store, depl)
+	anomalies := monitoring.DetectAnomalies(samples, limits, now, monitoring.DefaultAnomalyThresholds())
+	score := monitoring.AbuseScore(anomalies)
+	if score == 0 && toInt(depl["abuse_score"]) == 0 {
+		return
+	}
+	if anomalies == nil {
+		anomalies = []monitoring.Anomaly{}
+	}
+
+	update := map[string]any{"abuse_score": score, "abuse_anomalies": anomalies}
+	flag := score >= monitoring.AbuseFlagScore && abuseFlaggable(depl, now)
+	throttled := false
+	if flag {
+		update["abuse_flagged_at"] = now.UTC().Format(time.RFC3339)
+		logger.Warn("deployment flagged for possible abuse", "score", score, "anomalies", anomalies)
+		customerID, _ := toInt64(depl["customer_id"])
+		if c.store.userAbuseAction(c.ctx, int(customerID)) == monitoring.AbuseActionThrottle {
+			throttled = throttleDeployment(c.ctx, c.nodePool, depl, limits, logger)
+			if throttled {
+				update["abuse_throttled_at"] = now.UTC().Format(time.RFC3339)
+			}
+		}
+	}
+
+	row, err := c.store.Update(c.ctx, "deployments", refID, update)
+	if err != nil {
+		logger.Error("failed to record abuse score", "error", err)
+		return
+	}
+	if flag && c.notifier != nil {
+		c.notifier.NotifyDeploymentAbuse(row, c.abuseRecipients(row), anomalies, throttled)
+	}
+}
+
+// abuseRecipients returns the users told about a flagged deployment: its
+// node's creator and the administrators.
+func (c *ContainerMetricsCollector) abuseRecipients(depl map[string]any) []int {
+	ids, err := c.store.userIDsByRef(c.ctx, c.admins)
+	if err != nil {
+		c.logger.Error("failed to look up administrators", "error", err)
+	}
+	if node, err := c.store.Get(c.ctx, "nodes", strVal(depl["node_id"])); err == nil {
+		if creatorID, ok := toInt64(node["creator_id"]); ok {
+			ids = append(ids, int(creatorID))
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// throttleDeployment cuts the CPU limit of each of a deployment's containers
+// to monitoring.ThrottleCPU, or to the service's own limit when lower. It
+// reports whether any container was throttled.
+func throttleDeployment(ctx context.Context, nodePool *docker.NodePool, depl map[string]any, limits map[string]float64, logger *slog.Logger) bool {
+	var containers []domain.ContainerInfo
+	decodeJSONValue(depl["containers"], &containers)
+	throttled := false
+	for _, ctr := range containers {
+		cores := monitoring.ThrottleCPU
+		if l := limits[ctr.ServiceName]; l > 0 && l < cores {
+			cores = l
+		}
+		if err := nodePool.UpdateContainerCPU(ctx, strVal(depl["node_id"]), ctr.ID, cores); err != nil {
+			logger.Warn("failed to throttle container", "service", ctr.ServiceName, "error", err)
+			continue
+		}
+		throttled = true
+	}
+	return throttled
+}
+
+// =============================================================================
+// Lifting Throttles
+// =============================================================================
+
+// liftAbuseThrottle handles LiftAbuseThrottle: it gives a dismissed
+// deployment's containers back their declared CPU limit, or all of the node's
+// cores for services without one. Containers are recreated with their
+// declared limits on the next start anyway.
+func liftAbuseThrottle(ctx context.Context, deps *Deps, data map[string]any) error {
+	nodePool := getNodePool(deps)
+	if nodePool == nil {
+		return nil
+	}
+	refID := strVal(data["reference_id"])
+	depl, err := deps.Store.Get(ctx, "deployments", refID)
+	if err != nil {
+		return err
+	}
+	nodeCores := 0.0
+	if node, err := deps.Store.Get(ctx, "nodes", strVal(depl["node_id"])); err == nil {
+		nodeCores = floatVal(node["capacity_cpu_cores"])
+	}
+	limits := serviceCPULimits(ctx, deps.Store, depl)
+
+	var containers []domain.ContainerInfo
+	decodeJSONValue(depl["containers"], &containers)
+	for _, ctr := range containers {
+		cores := limits[ctr.ServiceName]
+		if cores <= 0 {
+			cores = nodeCores
+		}
+		if cores <= 0 {
+			deps.Logger.Warn("no CPU limit to restore; the throttle lifts when the deployment restarts", "deployment", refID, "service", ctr.ServiceName)
+			continue
+		}
+		if err := nodePool.UpdateContainerCPU(ctx, strVal(depl["node_id"]), ctr.ID, cores); err != nil {
+			deps.Logger.Warn("failed to lift container throttle", "deployment", refID, "service", ctr.ServiceName, "error", err)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// abuseHandler handles GET /admin/abuse: flagged deployments, highest score first.
+func abuseHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !requireAbuseAdmin(w, r, cfg, authCtx) {
+			return
+		}
+		ctx := r.Context()
+
+		var refs []string
+		if err := cfg.Store.db.SelectContext(ctx, &refs,
+			`SELECT reference_id FROM deployments WHERE abuse_flagged_at IS NOT NULL AND abuse_flagged_at != ''
+			ORDER BY abuse_score DESC, abuse_flagged_at DESC LIMIT 500`); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list flagged deployments")
+			return
+		}
+		res := cfg.Store.Resource("deployments")
+		data := []any{}
+		for _, ref := range refs {
+			depl, err := cfg.Store.Get(ctx, "deployments", ref)
+			if err != nil {
+				continue
+			}
+			stripFields(res, depl, cfg.Store, authCtx)
+			data = append(data, renderResource(r, cfg.Store, "deployments", depl))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
+
+// abuseDismissHandler handles DELETE /admin/abuse/{id}: it clears a
+// deployment's flag, lifts its throttle and keeps it from being flagged
+// again for abuseDismissPeriod.
+func abuseDismissHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !requireAbuseAdmin(w, r, cfg, authCtx) {
+			return
+		}
+		ctx := r.Context()
+		refID := mux.Vars(r)["id"]
+
+		depl, err := cfg.Store.Get(ctx, "deployments", refID)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if _, flagged := parseTime(depl["abuse_flagged_at"]); !flagged {
+			writeProblem(w, r, ProblemNotFound, "deployment is not flagged")
+			return
+		}
+		_, throttled := parseTime(depl["abuse_throttled_at"])
+
+		row, err := cfg.Store.Update(ctx, "deployments", refID, map[string]any{
+			"abuse_score":        0,
+			"abuse_anomalies":    []monitoring.Anomaly{},
+			"abuse_flagged_at":   nil,
+			"abuse_throttled_at": nil,
+			"abuse_dismissed_at": time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to dismiss abuse flag")
+			return
+		}
+		cfg.Logger.Info("abuse flag dismissed", "deployment", refID, "by", authCtx.ReferenceID)
+
+		if throttled && cfg.Bus != nil {
+			if _, err := cfg.Bus.Enqueue(ctx, "LiftAbuseThrottle", row); err != nil {
+				cfg.Logger.Error("command enqueue failed", "command", "LiftAbuseThrottle", "error", err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requireAbuseAdmin admits administrators.
+func requireAbuseAdmin(w http.ResponseWriter, r *http.Request, cfg SetupConfig, authCtx AuthContext) bool {
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return false
+	}
+	if !isAdmin(cfg, authCtx) {
+		writeProblem(w, r, ProblemForbidden, "administrator access required")
+		return false
+	}
+	return true
+}
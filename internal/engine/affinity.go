@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/scheduler"
+)
+
+// =============================================================================
+// Co-location Affinity
+// =============================================================================
+
+// A deployment's colocate_with names another deployment of the same customer
+// to share a node with (an app and its database, say), and its location the
+// node location it would rather run in. A deployment created without node_id
+// is placed by the scheduler, on the peer's node when it has room, else in
+// the location when a node there has room. The co-location outcome is kept
+// in affinity_status and affinity_reason, a missed location in
+// placement_reason.
+
+// validateColocation checks a colocate_with hint: it must name another of the
+// customer's deployments that isn't being deleted.
+func validateColocation(ctx context.Context, store *Store, customerID int, selfRefID string, v any) error {
+	peerRef := strVal(v)
+	if peerRef == "" {
+		return nil
+	}
+	if peerRef == selfRefID {
+		return fmt.Errorf("colocate_with cannot reference the deployment itself")
+	}
+	peer, err := store.Get(ctx, "deployments", peerRef)
+	if err != nil {
+		return fmt.Errorf("colocate_with: deployment %s not found", peerRef)
+	}
+	if ownerID, _ := toInt64(peer["customer_id"]); int(ownerID) != customerID {
+		return fmt.Errorf("colocate_with: deployment %s not found", peerRef)
+	}
+	if s := strVal(peer["status"]); s == "deleting" || s == "deleted" {
+		return fmt.Errorf("colocate_with: deployment %s is %s", peerRef, s)
+	}
+	return nil
+}
+
+// deploymentAffinity resolves a colocate_with hint to the peer's node.
+func deploymentAffinity(ctx context.Context, store *Store, peerRef string) scheduler.Affinity {
+	a := scheduler.Affinity{PeerDeploymentID: peerRef}
+	if peer, err := store.Get(ctx, "deployments", peerRef); err == nil {
+		if s := strVal(peer["status"]); s != "deleting" && s != "deleted" {
+			a.PeerNodeID = strVal(peer["node_id"])
+		}
+	}
+	return a
+}
+
+// placeDeployment picks a node for a deployment without one: the best of the
+// customer's own nodes and the public nodes under strategy, preferring the
+// affinity peer's, then the deployment's location.
+func placeDeployment(ctx context.Context, store *Store, depl map[string]any, affinity *scheduler.Affinity, strategy scheduler.Strategy) (*scheduler.ScheduleResult, error) {
+	nodes, err := candidateNodes(ctx, store, depl)
+	if err != nil {
+		return nil, err
+	}
+	req := schedulingRequest(ctx, store, depl, affinity)
+	req.AvailableNodes = nodes
+	req.Strategy = strategy
+	req.Seed = time.Now().UnixNano()
+	return scheduler.Schedule(req)
+}
+
+// schedulingStrategy returns the configured nodes.scheduling_strategy.
+func schedulingStrategy(deps *Deps) scheduler.Strategy {
+	if s, ok := deps.Extra["scheduling_strategy"].(scheduler.Strategy); ok {
+		return s
+	}
+	return scheduler.StrategySpread
+}
+
+// candidateNodes returns the nodes a deployment may be placed on: its
+// customer's own nodes and the public nodes.
+func candidateNodes(ctx context.Context, store *Store, depl map[string]any) ([]domain.Node, error) {
+	customerID, _ := toInt64(depl["customer_id"])
+
+	var rows []map[string]any
+	for _, f := range []Filter{{Field: "creator_id", Value: customerID}, {Field: "public", Value: true}} {
+		found, err := store.List(ctx, "nodes", []Filter{f}, Page{Limit: 1000})
+		if err != nil {
+			return nil, fmt.Errorf("list nodes: %w", err)
+		}
+		rows = append(rows, found...)
+	}
+
+	reserved, err := reservedNodeResources(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(rows))
+	var nodes []domain.Node
+	for _, row := range rows {
+		ref := strVal(row["reference_id"])
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		nodes = append(nodes, schedulingNode(row, reserved[ref]))
+	}
+	return nodes, nil
+}
+
+// schedulingRequest builds a deployment's scheduling requirements, without
+// the nodes to consider.
+func schedulingRequest(ctx context.Context, store *Store, depl map[string]any, affinity *scheduler.Affinity) scheduler.ScheduleRequest {
+	req := scheduler.ScheduleRequest{
+		RequiredResources: domain.Resources{
+			CPUCores: floatVal(depl["resources_cpu_cores"]),
+			MemoryMB: int64(toInt(depl["resources_memory_mb"])),
+			DiskMB:   int64(toInt(depl["resources_disk_mb"])),
+		},
+		Affinity: affinity,
+		Location: strVal(depl["location"]),
+	}
+	if tid, ok := toInt64(depl["template_id"]); ok && tid > 0 {
+		if tmpl, err := store.GetByID(ctx, "templates", int(tid)); err == nil {
+			decodeJSONValue(tmpl["required_capabilities"], &req.RequiredCapabilities)
+		}
+	}
+	return req
+}
+
+// reservedNodeResources sums the resources of the deployments placed on each
+// node, by node reference_id.
+func reservedNodeResources(ctx context.Context, store *Store) (map[string]domain.Resources, error) {
+	rows, err := store.RawQuery(ctx, `
+		SELECT node_id,
+		       COALESCE(SUM(resources_cpu_cores), 0) AS cpu,
+		       COALESCE(SUM(resources_memory_mb), 0) AS memory,
+		       COALESCE(SUM(resources_disk_mb), 0) AS disk
+		FROM deployments
+		WHERE node_id IS NOT NULL AND node_id != ''
+		  AND status IN ('scheduled', 'starting', 'running', 'stopping')
+		GROUP BY node_id`)
+	if err != nil {
+		return nil, fmt.Errorf("sum node reservations: %w", err)
+	}
+	out := make(map[string]domain.Resources, len(rows))
+	for _, row := range rows {
+		out[strVal(row["node_id"])] = domain.Resources{
+			CPUCores: floatVal(row["cpu"]),
+			MemoryMB: int64(toInt(row["memory"])),
+			DiskMB:   int64(toInt(row["disk"])),
+		}
+	}
+	return out, nil
+}
+
+// schedulingNode converts a node row to the scheduler's view of it. Usage is
+// the larger of the reported usage and the deployments placed on the node.
+func schedulingNode(row map[string]any, reserved domain.Resources) domain.Node {
+	n := *mapToNode(row)
+	decodeJSONValue(row["capabilities"], &n.Capabilities)
+	n.Capacity = domain.NodeCapacity{
+		CPUCores:     floatVal(row["capacity_cpu_cores"]),
+		MemoryMB:     int64(toInt(row["capacity_memory_mb"])),
+		DiskMB:       int64(toInt(row["capacity_disk_mb"])),
+		CPUUsed:      max(floatVal(row["capacity_cpu_used"]), reserved.CPUCores),
+		MemoryUsedMB: max(int64(toInt(row["capacity_memory_used_mb"])), reserved.MemoryMB),
+		DiskUsedMB:   max(int64(toInt(row["capacity_disk_used_mb"])), reserved.DiskMB),
+	}
+	return n
+}
+
+// floatVal converts a numeric column value to float64.
+func floatVal(v any) float64 {
+	switch val := v.(type) {
+	case float64:
+		return val
+	case int64:
+		return float64(val)
+	case int:
+		return float64(val)
+	}
+	return 0
+}
@@ -3,18 +3,23 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/artpar/hoster/internal/core/pagination"
 	"github.com/gorilla/mux"
 )
 
-// CommandBus dispatches commands emitted by state machine transitions.
+// CommandBus dispatches commands emitted by state machine transitions, or
+// queues them to run in the background.
 type CommandBus interface {
 	Dispatch(ctx context.Context, command string, row map[string]any) error
+	Enqueue(ctx context.Context, command string, row map[string]any) (*Job, error)
 }
 
 // noopBus is a CommandBus that does nothing.
@@ -22,6 +27,8 @@ type noopBus struct{}
 
 func (noopBus) Dispatch(_ context.Context, _ string, _ map[string]any) error { return nil }
 
+func (noopBus) Enqueue(_ context.Context, _ string, _ map[string]any) (*Job, error) { return nil, nil }
+
 // APIConfig configures the generic REST API.
 type APIConfig struct {
 	Store  *Store
@@ -34,7 +41,8 @@ type APIConfig struct {
 }
 
 // RegisterRoutes registers generic CRUD routes for all resources in the schema.
-// Routes follow JSON:API convention: /api/v1/{resource} and /api/v1/{resource}/{id}
+// Routes follow JSON:API convention: /api/{version}/{resource} and
+// /api/{version}/{resource}/{id}, for every supported version.
 func RegisterRoutes(router *mux.Router, cfg APIConfig) {
 	if cfg.Logger == nil {
 		cfg.Logger = slog.Default()
@@ -44,28 +52,28 @@ func RegisterRoutes(router *mux.Router, cfg APIConfig) {
 	}
 
 	for name, res := range cfg.Store.schema {
-		prefix := "/api/v1/" + name
+		prefix := "/" + name
 		r := res // capture for closures
 
-		// GET /api/v1/{resource}
-		router.HandleFunc(prefix, listHandler(cfg, r)).Methods("GET")
+		// GET /api/{version}/{resource}
+		handleVersioned(router, prefix, listHandler(cfg, r), "GET")
 
-		// POST /api/v1/{resource}
-		router.HandleFunc(prefix, createHandler(cfg, r)).Methods("POST")
+		// POST /api/{version}/{resource}
+		handleVersioned(router, prefix, createHandler(cfg, r), "POST")
 
-		// GET /api/v1/{resource}/{id}
-		router.HandleFunc(prefix+"/{id}", getHandler(cfg, r)).Methods("GET")
+		// GET /api/{version}/{resource}/{id}
+		handleVersioned(router, prefix+"/{id}", getHandler(cfg, r), "GET")
 
-		// PATCH /api/v1/{resource}/{id}
-		router.HandleFunc(prefix+"/{id}", updateHandler(cfg, r)).Methods("PATCH")
+		// PATCH /api/{version}/{resource}/{id}
+		handleVersioned(router, prefix+"/{id}", updateHandler(cfg, r), "PATCH")
 
-		// DELETE /api/v1/{resource}/{id}
-		router.HandleFunc(prefix+"/{id}", deleteHandler(cfg, r)).Methods("DELETE")
+		// DELETE /api/{version}/{resource}/{id}
+		handleVersioned(router, prefix+"/{id}", deleteHandler(cfg, r), "DELETE")
 
 		// State machine transition endpoints
 		if r.StateMachine != nil {
-			// POST /api/v1/{resource}/{id}/transition/{state}
-			router.HandleFunc(prefix+"/{id}/transition/{state}", transitionHandler(cfg, r)).Methods("POST")
+			// POST /api/{version}/{resource}/{id}/transition/{state}
+			handleVersioned(router, prefix+"/{id}/transition/{state}", transitionHandler(cfg, r), "POST")
 		}
 
 		// Custom action handlers
@@ -74,7 +82,7 @@ func RegisterRoutes(router *mux.Router, cfg APIConfig) {
 				key := name + ":" + action.Name
 				if handler, ok := cfg.ActionHandlers[key]; ok {
 					route := prefix + "/{id}/" + action.Name
-					router.HandleFunc(route, handler).Methods(action.Method)
+					handleVersioned(router, route, handler, action.Method)
 				}
 			}
 		}
@@ -89,170 +97,61 @@ func RegisterRoutes(router *mux.Router, cfg APIConfig) {
 
 func listHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		authCtx := getAuthContext(r)
-
-		page := parsePage(r)
-
-		// Build filters
-		var filters []Filter
-
-		// Owner scoping: if resource has an owner field and user is authenticated,
-		// filter by owner. For PublicRead resources, only scope when ?scope=mine.
-		scopeMine := r.URL.Query().Get("scope") == "mine"
-		if res.Owner != "" && authCtx.Authenticated && (!res.PublicRead || scopeMine) {
-			filters = append(filters, Filter{Field: res.Owner, Value: authCtx.UserID})
-		}
-
-		// Parse filter query params: filter[field]=value
-		for key, values := range r.URL.Query() {
-			if strings.HasPrefix(key, "filter[") && strings.HasSuffix(key, "]") {
-				fieldName := key[7 : len(key)-1]
-				if len(values) > 0 {
-					filters = append(filters, Filter{Field: fieldName, Value: values[0]})
-				}
-			}
-		}
-
-		rows, err := cfg.Store.List(ctx, res.Name, filters, page)
+		page, err := parsePage(r)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
 			return
 		}
 
-		// Apply visibility filter
-		if res.Visibility != nil {
-			var visible []map[string]any
-			for _, row := range rows {
-				if res.Visibility(ctx, authCtx, row) {
-					visible = append(visible, row)
-				}
-			}
-			rows = visible
-		}
-
-		// Strip write-only, owner-only, and internal fields from responses
-		for _, row := range rows {
-			stripFields(res, row, cfg.Store, authCtx)
+		rows, fetched, next, err := listRows(r.Context(), cfg, res, getAuthContext(r), r.URL.Query(), page)
+		if err != nil {
+			writeOpError(w, r, err)
+			return
 		}
 
-		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowsToJSONAPI(res.Name, rows),
-			"meta": map[string]any{
-				"total":  len(rows),
-				"limit":  page.Limit,
-				"offset": page.Offset,
-			},
-		})
+		writeJSON(w, http.StatusOK, renderCollection(r, cfg.Store, res.Name, rows, page, fetched, next))
 	}
 }
 
 func getHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		authCtx := getAuthContext(r)
-		id := mux.Vars(r)["id"]
-
-		row, err := cfg.Store.Get(ctx, res.Name, id)
+		row, err := getRow(r.Context(), cfg, res, getAuthContext(r), mux.Vars(r)["id"])
 		if err != nil {
-			if isNotFoundErr(err) {
-				writeError(w, http.StatusNotFound, res.Name+" not found")
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		// Check visibility
-		if res.Visibility != nil && !res.Visibility(ctx, authCtx, row) {
-			writeError(w, http.StatusNotFound, res.Name+" not found")
+			writeOpError(w, r, err)
 			return
 		}
 
-		// Check owner — fail closed: if owner field exists but can't be parsed, deny access
-		if res.Owner != "" && authCtx.Authenticated && !res.PublicRead {
-			ownerID, ok := toInt64(row[res.Owner])
-			if !ok {
-				cfg.Logger.Warn("ownership check failed: unparseable owner field",
-					"resource", res.Name, "field", res.Owner, "value", row[res.Owner])
-				writeError(w, http.StatusForbidden, "access denied")
-				return
-			}
-			if int(ownerID) != authCtx.UserID {
-				writeError(w, http.StatusNotFound, res.Name+" not found")
-				return
-			}
-		}
-
-		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI(res.Name, row),
+			"data": renderResource(r, cfg.Store, res.Name, row),
 		})
 	}
 }
 
 func createHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
 		authCtx := getAuthContext(r)
 
 		// Require authentication for create
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		// Parse request body (JSON:API format)
-		data, err := parseJSONAPIBody(r)
+		data, err := parseJSONAPIBody(r, cfg.Store, res.Name)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
-			return
-		}
-
-		// Set owner field from auth context
-		if res.Owner != "" {
-			data[res.Owner] = authCtx.UserID
-		}
-
-		// Remove internal fields that shouldn't be set by the client
-		// (except owner, which we just set)
-		for _, f := range res.Fields {
-			if f.Internal && f.Name != res.Owner {
-				delete(data, f.Name)
-			}
-		}
-
-		// Resolve RefField reference_ids to integer PKs
-		if err := resolveRefFields(res, data, cfg.Store); err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body: "+err.Error())
 			return
 		}
 
-		// BeforeCreate hook
-		if res.BeforeCreate != nil {
-			if err := res.BeforeCreate(ctx, authCtx, data); err != nil {
-				writeError(w, http.StatusBadRequest, err.Error())
-				return
-			}
-		}
-
-		row, err := cfg.Store.Create(ctx, res.Name, data)
+		row, err := createRow(r.Context(), cfg, res, authCtx, data)
 		if err != nil {
-			if strings.Contains(err.Error(), "validation error") {
-				writeError(w, http.StatusBadRequest, err.Error())
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeOpError(w, r, err)
 			return
 		}
 
-		if res.AfterCreate != nil {
-			res.AfterCreate(ctx, authCtx, row)
-		}
-
-		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusCreated, map[string]any{
-			"data": rowToJSONAPI(res.Name, row),
+			"data": renderResource(r, cfg.Store, res.Name, row),
 		})
 	}
 }
@@ -263,271 +162,432 @@ func updateHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
 		authCtx := getAuthContext(r)
 		id := mux.Vars(r)["id"]
 
-		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+		existing, err := ownedRow(ctx, cfg, res, authCtx, id, "not authorized to modify this "+res.Name)
+		if err != nil {
+			writeOpError(w, r, err)
 			return
 		}
 
-		// Check ownership
-		existing, err := cfg.Store.Get(ctx, res.Name, id)
+		// Parse update data
+		data, err := parseJSONAPIBody(r, cfg.Store, res.Name)
 		if err != nil {
-			if isNotFoundErr(err) {
-				writeError(w, http.StatusNotFound, res.Name+" not found")
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body: "+err.Error())
 			return
 		}
 
-		if res.Owner != "" {
-			ownerID, ok := toInt64(existing[res.Owner])
-			if !ok {
-				cfg.Logger.Warn("ownership check failed: unparseable owner field",
-					"resource", res.Name, "field", res.Owner, "value", existing[res.Owner])
-				writeError(w, http.StatusForbidden, "access denied")
-				return
-			}
-			if int(ownerID) != authCtx.UserID {
-				writeError(w, http.StatusForbidden, "not authorized to modify this "+res.Name)
-				return
-			}
-		}
-
-		// Parse update data
-		data, err := parseJSONAPIBody(r)
+		row, err := updateRow(ctx, cfg, res, authCtx, id, existing, data)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			writeOpError(w, r, err)
 			return
 		}
 
-		// Remove internal fields from update
-		for _, f := range res.Fields {
-			if f.Internal {
-				delete(data, f.Name)
-			}
-		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, res.Name, row),
+		})
+	}
+}
 
-		// Resolve RefField reference_ids to integer PKs
-		if err := resolveRefFields(res, data, cfg.Store); err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+func deleteHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		existing, err := ownedRow(ctx, cfg, res, authCtx, id, "not authorized to delete this "+res.Name)
+		if err != nil {
+			writeOpError(w, r, err)
 			return
 		}
 
-		row, err := cfg.Store.Update(ctx, res.Name, id, data)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err.Error())
+		if err := deleteRow(ctx, cfg, res, authCtx, id, existing); err != nil {
+			writeOpError(w, r, err)
 			return
 		}
 
-		stripFields(res, row, cfg.Store, authCtx)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI(res.Name, row),
-		})
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func deleteHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
+func transitionHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		authCtx := getAuthContext(r)
 		id := mux.Vars(r)["id"]
 
-		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+		if _, err := ownedRow(ctx, cfg, res, authCtx, id, "not authorized"); err != nil {
+			writeOpError(w, r, err)
 			return
 		}
 
-		// Check ownership
-		existing, err := cfg.Store.Get(ctx, res.Name, id)
+		row, err := transitionRow(ctx, cfg, res, authCtx, id, mux.Vars(r)["state"])
 		if err != nil {
-			if isNotFoundErr(err) {
-				writeError(w, http.StatusNotFound, res.Name+" not found")
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeOpError(w, r, err)
 			return
 		}
 
-		if res.Owner != "" {
-			ownerID, ok := toInt64(existing[res.Owner])
-			if !ok {
-				cfg.Logger.Warn("ownership check failed: unparseable owner field",
-					"resource", res.Name, "field", res.Owner, "value", existing[res.Owner])
-				writeError(w, http.StatusForbidden, "access denied")
-				return
-			}
-			if int(ownerID) != authCtx.UserID {
-				writeError(w, http.StatusForbidden, "not authorized to delete this "+res.Name)
-				return
-			}
-		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, res.Name, row),
+		})
+	}
+}
 
-		// BeforeDelete hook
-		if res.BeforeDelete != nil {
-			if err := res.BeforeDelete(ctx, authCtx, existing); err != nil {
-				writeError(w, http.StatusConflict, err.Error())
-				return
+// =============================================================================
+// Generic Operations
+// =============================================================================
+//
+// The operations behind the generic handlers, shared with the GraphQL API
+// (graphql.go) so both run the same checks, hooks and transitions. Rows they
+// return are stripped for the caller. They fail with an *opError naming the
+// problem to report; any other error is internal.
+
+// opError is a failed operation and the problem that reports it, with
+// optional extension members describing it further.
+type opError struct {
+	problem    ProblemType
+	detail     string
+	extensions map[string]any
+}
+
+func (e *opError) Error() string {
+	return e.detail
+}
+
+// Extensions describes the problem to GraphQL clients.
+func (e *opError) Extensions() map[string]any {
+	ext := map[string]any{"code": e.problem.Code, "status": e.problem.Status}
+	for k, v := range e.extensions {
+		ext[k] = v
+	}
+	return ext
+}
+
+func opFail(p ProblemType, detail string) error {
+	return &opError{problem: p, detail: detail}
+}
+
+// writeOpError writes an operation's error as a problem.
+func writeOpError(w http.ResponseWriter, r *http.Request, err error) {
+	var oe *opError
+	if errors.As(err, &oe) {
+		writeProblemWith(w, r, oe.problem, oe.detail, oe.extensions)
+		return
+	}
+	writeProblem(w, r, ProblemInternal, err.Error())
+}
+
+// listRows lists the rows of a resource the caller may see. query carries
+// scope=mine, filter[field]=value and the resource's own List parameters.
+// fetched is the row count before visibility filtering; next is the next
+// page's cursor, if any.
+func listRows(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, query url.Values, page Page) (rows []map[string]any, fetched int, next string, err error) {
+	// Build filters
+	var filters []Filter
+
+	// Owner scoping: if resource has an owner field and user is authenticated,
+	// filter by owner. For PublicRead resources, only scope when ?scope=mine.
+	scopeMine := query.Get("scope") == "mine"
+	if res.Owner != "" && authCtx.Authenticated && (!res.PublicRead || scopeMine) {
+		filters = append(filters, Filter{Field: res.Owner, Value: authCtx.UserID})
+	}
+
+	// Parse filter query params: filter[field]=value
+	for key, values := range query {
+		if strings.HasPrefix(key, "filter[") && strings.HasSuffix(key, "]") {
+			fieldName := key[7 : len(key)-1]
+			if len(values) > 0 {
+				filters = append(filters, Filter{Field: fieldName, Value: values[0]})
 			}
 		}
+	}
+	if err := resolveRefFilters(res, filters, cfg.Store); err != nil {
+		return nil, 0, "", opFail(ProblemValidationFailed, err.Error())
+	}
 
-		// If the resource has a state machine with a "deleting" or "destroying" state,
-		// transition to it so command handlers can clean up (e.g., remove containers, destroy instances).
-		handlerDispatched := false
-		if res.StateMachine != nil {
-			currentState, _ := existing[res.StateMachine.Field].(string)
-			if targets, ok := res.StateMachine.Transitions[currentState]; ok {
-				for _, t := range targets {
-					if t == "deleting" || t == "destroying" {
-						row, cmd, err := cfg.Store.Transition(ctx, res.Name, id, t)
-						if err != nil {
-							cfg.Logger.Warn("failed to transition for delete, falling through to direct delete",
-								"resource", res.Name, "id", id, "error", err)
-						} else if cmd != "" && cfg.Bus != nil {
-							handlerDispatched = true
-							if err := cfg.Bus.Dispatch(ctx, cmd, row); err != nil {
-								cfg.Logger.Error("command dispatch failed during delete",
-									"resource", res.Name, "command", cmd, "error", err)
-							}
-						}
-						break
-					}
-				}
+	ordered := true
+	if res.List != nil {
+		rows, ordered, err = res.List(ctx, cfg.Store, query, filters, page)
+	} else {
+		rows, err = cfg.Store.List(ctx, res.Name, filters, page)
+	}
+	if errors.Is(err, ErrValidation) {
+		return nil, 0, "", opFail(ProblemValidationFailed, err.Error())
+	}
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	fetched = len(rows)
+	if ordered {
+		next = nextCursor(rows, page, "created_at")
+	}
+
+	// Apply visibility filter
+	if res.Visibility != nil {
+		var visible []map[string]any
+		for _, row := range rows {
+			if res.Visibility(ctx, authCtx, row) {
+				visible = append(visible, row)
 			}
 		}
+		rows = visible
+	}
 
-		// If a destroy/delete handler ran, check the resulting state.
-		// If it transitioned to "failed" (e.g., cloud API call failed), do NOT delete the DB record —
-		// the cloud resource may still exist and the user needs to retry.
-		if handlerDispatched && res.StateMachine != nil {
-			updated, err := cfg.Store.Get(ctx, res.Name, id)
-			if err == nil {
-				newState, _ := updated[res.StateMachine.Field].(string)
-				if newState == "failed" {
-					errMsg, _ := updated["error_message"].(string)
-					detail := "destroy failed — cloud resource may still exist. Check error and retry."
-					if errMsg != "" {
-						detail = "destroy failed: " + errMsg
-					}
-					writeError(w, http.StatusConflict, detail)
-					return
-				}
-			}
+	if res.AfterRead != nil && len(rows) > 0 {
+		res.AfterRead(ctx, authCtx, rows)
+	}
+
+	// Strip write-only, owner-only, and internal fields from responses
+	for _, row := range rows {
+		stripFields(res, row, cfg.Store, authCtx)
+	}
+	return rows, fetched, next, nil
+}
+
+// getRow returns one row of a resource, if the caller may see it.
+func getRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, id string) (map[string]any, error) {
+	row, err := cfg.Store.Get(ctx, res.Name, id)
+	if err != nil {
+		if isNotFoundErr(err) {
+			return nil, opFail(ProblemNotFound, res.Name+" not found")
 		}
+		return nil, err
+	}
 
-		if err := cfg.Store.Delete(ctx, res.Name, id); err != nil {
-			if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
-				writeError(w, http.StatusConflict, "cannot delete: other resources depend on this "+res.Name)
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
-			return
+	// Check visibility
+	if res.Visibility != nil && !res.Visibility(ctx, authCtx, row) {
+		return nil, opFail(ProblemNotFound, res.Name+" not found")
+	}
+
+	// Check owner — fail closed: if owner field exists but can't be parsed, deny access
+	if res.Owner != "" && authCtx.Authenticated && !res.PublicRead {
+		ownerID, ok := toInt64(row[res.Owner])
+		if !ok {
+			cfg.Logger.Warn("ownership check failed: unparseable owner field",
+				"resource", res.Name, "field", res.Owner, "value", row[res.Owner])
+			return nil, opFail(ProblemForbidden, "access denied")
+		}
+		if int(ownerID) != authCtx.UserID {
+			return nil, opFail(ProblemNotFound, res.Name+" not found")
 		}
+	}
 
-		w.WriteHeader(http.StatusNoContent)
+	if res.AfterRead != nil {
+		res.AfterRead(ctx, authCtx, []map[string]any{row})
 	}
+
+	stripFields(res, row, cfg.Store, authCtx)
+	return row, nil
 }
 
-func transitionHandler(cfg APIConfig, res *Resource) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		authCtx := getAuthContext(r)
-		id := mux.Vars(r)["id"]
-		state := mux.Vars(r)["state"]
+// createRow creates a row owned by the caller from request attributes.
+func createRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, data map[string]any) (map[string]any, error) {
+	if !authCtx.Authenticated {
+		return nil, opFail(ProblemAuthenticationRequired, "authentication required")
+	}
 
-		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
-			return
+	// Set owner field from auth context
+	if res.Owner != "" {
+		data[res.Owner] = authCtx.UserID
+	}
+
+	// Remove internal fields that shouldn't be set by the client
+	// (except owner, which we just set)
+	for _, f := range res.Fields {
+		if f.Internal && f.Name != res.Owner {
+			delete(data, f.Name)
 		}
+	}
 
-		// Check ownership
-		existing, err := cfg.Store.Get(ctx, res.Name, id)
-		if err != nil {
-			if isNotFoundErr(err) {
-				writeError(w, http.StatusNotFound, res.Name+" not found")
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
-			return
+	// Resolve RefField reference_ids to integer PKs
+	if err := resolveRefFields(res, data, cfg.Store); err != nil {
+		return nil, opFail(ProblemValidationFailed, err.Error())
+	}
+
+	// BeforeCreate hook
+	if res.BeforeCreate != nil {
+		if err := res.BeforeCreate(ctx, authCtx, data); err != nil {
+			return nil, hookFail(err)
 		}
+	}
 
-		if res.Owner != "" {
-			ownerID, ok := toInt64(existing[res.Owner])
-			if !ok {
-				cfg.Logger.Warn("ownership check failed: unparseable owner field",
-					"resource", res.Name, "field", res.Owner, "value", existing[res.Owner])
-				writeError(w, http.StatusForbidden, "access denied")
-				return
-			}
-			if int(ownerID) != authCtx.UserID {
-				writeError(w, http.StatusForbidden, "not authorized")
-				return
-			}
+	row, err := cfg.Store.Create(ctx, res.Name, data)
+	if err != nil {
+		if strings.Contains(err.Error(), "validation error") {
+			return nil, opFail(ProblemValidationFailed, err.Error())
 		}
+		return nil, err
+	}
 
-		row, cmd, err := cfg.Store.Transition(ctx, res.Name, id, state)
-		if err != nil {
-			if strings.Contains(err.Error(), "invalid state transition") {
-				writeError(w, http.StatusConflict, err.Error())
-				return
-			}
-			if strings.Contains(err.Error(), "guard failed") {
-				writeError(w, http.StatusConflict, err.Error())
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err.Error())
-			return
+	if res.AfterCreate != nil {
+		res.AfterCreate(ctx, authCtx, row)
+	}
+
+	stripFields(res, row, cfg.Store, authCtx)
+	return row, nil
+}
+
+// ownedRow returns the row the caller is about to change, failing with
+// denied unless they own it.
+func ownedRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, id, denied string) (map[string]any, error) {
+	if !authCtx.Authenticated {
+		return nil, opFail(ProblemAuthenticationRequired, "authentication required")
+	}
+
+	existing, err := cfg.Store.Get(ctx, res.Name, id)
+	if err != nil {
+		if isNotFoundErr(err) {
+			return nil, opFail(ProblemNotFound, res.Name+" not found")
 		}
+		return nil, err
+	}
 
-		// Dispatch command if state machine triggers one
-		if cmd != "" && cfg.Bus != nil {
-			if err := cfg.Bus.Dispatch(ctx, cmd, row); err != nil {
-				cfg.Logger.Error("command dispatch failed", "command", cmd, "error", err)
-				// Don't fail the transition — the state was already saved
-			}
+	if res.Owner != "" {
+		ownerID, ok := toInt64(existing[res.Owner])
+		if !ok {
+			cfg.Logger.Warn("ownership check failed: unparseable owner field",
+				"resource", res.Name, "field", res.Owner, "value", existing[res.Owner])
+			return nil, opFail(ProblemForbidden, "access denied")
+		}
+		if int(ownerID) != authCtx.UserID {
+			return nil, opFail(ProblemForbidden, denied)
 		}
+	}
+	return existing, nil
+}
 
-		stripFields(res, row, cfg.Store, authCtx)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI(res.Name, row),
-		})
+// updateRow applies request attributes to an owned row.
+func updateRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, id string, existing, data map[string]any) (map[string]any, error) {
+	// Remove internal fields from update
+	for _, f := range res.Fields {
+		if f.Internal {
+			delete(data, f.Name)
+		}
+	}
+
+	// Resolve RefField reference_ids to integer PKs
+	if err := resolveRefFields(res, data, cfg.Store); err != nil {
+		return nil, opFail(ProblemValidationFailed, err.Error())
+	}
+
+	// BeforeUpdate hook
+	if res.BeforeUpdate != nil {
+		if err := res.BeforeUpdate(ctx, authCtx, existing, data); err != nil {
+			return nil, hookFail(err)
+		}
 	}
+
+	row, err := cfg.Store.Update(ctx, res.Name, id, data)
+	if err != nil {
+		return nil, err
+	}
+
+	stripFields(res, row, cfg.Store, authCtx)
+	return row, nil
 }
 
-// =============================================================================
-// JSON:API Response Helpers
-// =============================================================================
+// deleteRow deletes an owned row, through the resource's deleting or
+// destroying state when it has one.
+func deleteRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, id string, existing map[string]any) error {
+	// BeforeDelete hook
+	if res.BeforeDelete != nil {
+		if err := res.BeforeDelete(ctx, authCtx, existing); err != nil {
+			return opFail(ProblemInvalidState, err.Error())
+		}
+	}
 
-// rowToJSONAPI converts a map row to a JSON:API resource object.
-func rowToJSONAPI(resourceType string, row map[string]any) map[string]any {
-	refID, _ := row["reference_id"].(string)
+	// If the resource has a state machine with a "deleting" or "destroying" state,
+	// transition to it so command handlers can clean up (e.g., remove containers, destroy instances).
+	handlerDispatched := false
+	if res.StateMachine != nil {
+		currentState, _ := existing[res.StateMachine.Field].(string)
+		if targets, ok := res.StateMachine.Transitions[currentState]; ok {
+			for _, t := range targets {
+				if t == "deleting" || t == "destroying" {
+					row, cmd, err := cfg.Store.Transition(ctx, res.Name, id, t)
+					if err != nil {
+						cfg.Logger.Warn("failed to transition for delete, falling through to direct delete",
+							"resource", res.Name, "id", id, "error", err)
+					} else if cmd != "" && cfg.Bus != nil {
+						handlerDispatched = true
+						if err := cfg.Bus.Dispatch(ctx, cmd, row); err != nil {
+							cfg.Logger.Error("command dispatch failed during delete",
+								"resource", res.Name, "command", cmd, "error", err)
+						}
+					}
+					break
+				}
+			}
+		}
+	}
 
-	attrs := make(map[string]any)
-	for k, v := range row {
-		if k == "id" || k == "reference_id" {
-			continue
+	// If a destroy/delete handler ran, check the resulting state.
+	// If it transitioned to "failed" (e.g., cloud API call failed), do NOT delete the DB record —
+	// the cloud resource may still exist and the user needs to retry.
+	if handlerDispatched && res.StateMachine != nil {
+		updated, err := cfg.Store.Get(ctx, res.Name, id)
+		if err == nil {
+			newState, _ := updated[res.StateMachine.Field].(string)
+			if newState == "failed" {
+				errMsg, _ := updated["error_message"].(string)
+				detail := "destroy failed — cloud resource may still exist. Check error and retry."
+				if errMsg != "" {
+					detail = "destroy failed: " + errMsg
+				}
+				return opFail(ProblemInvalidState, detail)
+			}
 		}
-		attrs[k] = v
 	}
 
-	return map[string]any{
-		"type":       resourceType,
-		"id":         refID,
-		"attributes": attrs,
+	if err := cfg.Store.Delete(ctx, res.Name, id); err != nil {
+		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+			return opFail(ProblemInvalidState, "cannot delete: other resources depend on this "+res.Name)
+		}
+		return err
 	}
+	return nil
 }
 
-// rowsToJSONAPI converts multiple rows to JSON:API format.
-func rowsToJSONAPI(resourceType string, rows []map[string]any) []map[string]any {
-	if rows == nil {
-		return []map[string]any{}
+// transitionRow moves an owned row to state and dispatches the command the
+// state machine runs on entering it.
+func transitionRow(ctx context.Context, cfg APIConfig, res *Resource, authCtx AuthContext, id, state string) (map[string]any, error) {
+	// BeforeTransition hook
+	if res.BeforeTransition != nil {
+		existing, err := cfg.Store.Get(ctx, res.Name, id)
+		if err != nil {
+			return nil, opFail(ProblemNotFound, "not found")
+		}
+		if err := res.BeforeTransition(ctx, authCtx, existing, state); err != nil {
+			return nil, hookFail(err)
+		}
+	}
+
+	row, cmd, err := cfg.Store.Transition(ctx, res.Name, id, state)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid state transition") {
+			return nil, opFail(ProblemInvalidState, err.Error())
+		}
+		if strings.Contains(err.Error(), "guard failed") {
+			return nil, opFail(ProblemInvalidState, err.Error())
+		}
+		return nil, err
 	}
-	result := make([]map[string]any, len(rows))
-	for i, row := range rows {
-		result[i] = rowToJSONAPI(resourceType, row)
+
+	// Queue the command if the state machine triggers one
+	if cmd != "" && cfg.Bus != nil {
+		if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+			cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
+			// Don't fail the transition — the state was already saved
+		}
 	}
-	return result
+
+	stripFields(res, row, cfg.Store, authCtx)
+	return row, nil
 }
 
+// =============================================================================
+// JSON:API Response Helpers
+// =============================================================================
+
 // resolveRefFields converts reference_id strings in RefField columns to integer PKs.
 // API clients send reference_ids (e.g., "tmpl_c9fab67f"), but RefField DB columns store integer FKs.
 func resolveRefFields(res *Resource, data map[string]any, store *Store) error {
@@ -560,6 +620,33 @@ func resolveRefFields(res *Resource, data map[string]any, store *Store) error {
 	return nil
 }
 
+// resolveRefFilters converts reference_ids filtering RefField columns to
+// integer PKs, so filter[template_id]=tmpl_c9fab67f matches.
+func resolveRefFilters(res *Resource, filters []Filter, store *Store) error {
+	for i, flt := range filters {
+		f := res.FieldByName(flt.Field)
+		if f == nil || f.Type != TypeRef {
+			continue
+		}
+		refID, ok := flt.Value.(string)
+		if !ok || refID == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(refID); err == nil {
+			continue
+		}
+		var intID int
+		err := store.DB().QueryRow(
+			fmt.Sprintf("SELECT id FROM %s WHERE reference_id = ?", f.RefTable), refID,
+		).Scan(&intID)
+		if err != nil {
+			return fmt.Errorf("invalid filter[%s]: %s not found", f.Name, refID)
+		}
+		filters[i].Value = intID
+	}
+	return nil
+}
+
 // stripFields removes write-only fields, owner-only fields for non-owners,
 // and resolves ref field integer IDs to reference_ids for API responses.
 func stripFields(res *Resource, row map[string]any, store *Store, authCtx AuthContext) {
@@ -604,29 +691,6 @@ func stripFields(res *Resource, row map[string]any, store *Store, authCtx AuthCo
 	delete(row, "id")
 }
 
-// parseJSONAPIBody parses a JSON:API request body and returns the attributes map.
-func parseJSONAPIBody(r *http.Request) (map[string]any, error) {
-	var body struct {
-		Data struct {
-			Type       string         `json:"type"`
-			Attributes map[string]any `json:"attributes"`
-		} `json:"data"`
-	}
-
-	// Try JSON:API format first
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&body); err != nil {
-		return nil, err
-	}
-
-	if body.Data.Attributes != nil {
-		return body.Data.Attributes, nil
-	}
-
-	// If no attributes, try flat JSON (backward compat)
-	return body.Data.Attributes, fmt.Errorf("missing data.attributes in request body")
-}
-
 // getAuthContext extracts AuthContext from an HTTP request.
 // Uses the auth bridge (auth_bridge.go) which reads from the existing auth middleware.
 func getAuthContext(r *http.Request) AuthContext {
@@ -643,22 +707,9 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
-func writeError(w http.ResponseWriter, status int, detail string) {
-	w.Header().Set("Content-Type", "application/vnd.api+json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]any{
-		"errors": []map[string]any{
-			{
-				"status": strconv.Itoa(status),
-				"title":  http.StatusText(status),
-				"detail": detail,
-			},
-		},
-	})
-}
-
-// parsePage extracts pagination from query parameters.
-func parsePage(r *http.Request) Page {
+// parsePage extracts pagination from query parameters. page[cursor], a
+// next_cursor from an earlier response, takes precedence over offsets.
+func parsePage(r *http.Request) (Page, error) {
 	p := DefaultPage()
 	if v := r.URL.Query().Get("page[size]"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -675,7 +726,24 @@ func parsePage(r *http.Request) Page {
 			p.Offset = (pn - 1) * p.Limit
 		}
 	}
-	return p.Normalize()
+	if v := r.URL.Query().Get("page[cursor]"); v != "" {
+		c, err := pagination.Decode(v)
+		if err != nil {
+			return Page{}, err
+		}
+		p.Cursor = &c
+	}
+	return p.Normalize(), nil
+}
+
+// pageMeta returns the meta of a list page rendered outside the generic
+// serializers, with the next page's cursor when there is one.
+func pageMeta(page Page, next string) map[string]any {
+	meta := map[string]any{"limit": page.Limit, "offset": page.Offset}
+	if next != "" {
+		meta["next_cursor"] = next
+	}
+	return meta
 }
 
 func isNotFoundErr(err error) bool {
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	coreauth "github.com/artpar/hoster/internal/core/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// API Token Storage
+// =============================================================================
+//
+// api_tokens holds the tokens users create for scripts and CI to call the API
+// without going through APIGate. Only a token's hash is stored; the token
+// itself is shown once, when it is created. APITokenAuthenticator accepts
+// tokens that are neither revoked nor expired.
+
+// APIToken is a user's API token.
+type APIToken struct {
+	ID          int64          `db:"id"`
+	ReferenceID string         `db:"reference_id"`
+	UserID      int            `db:"user_id"`
+	Name        string         `db:"name"`
+	TokenHash   string         `db:"token_hash"`
+	Hint        string         `db:"hint"` // First characters of the token, to tell tokens apart
+	CreatedAt   string         `db:"created_at"`
+	ExpiresAt   sql.NullString `db:"expires_at"`
+	LastUsedAt  sql.NullString `db:"last_used_at"`
+	RevokedAt   sql.NullString `db:"revoked_at"`
+}
+
+const apiTokenColumns = `id, reference_id, user_id, name, token_hash, hint, created_at, expires_at, last_used_at, revoked_at`
+
+// apiTokenTouchInterval is how often a token's last use is written.
+const apiTokenTouchInterval = time.Minute
+
+// maxAPITokenNameLength caps token names.
+const maxAPITokenNameLength = 100
+
+// apiTokenOwner is the user an API token authenticates.
+type apiTokenOwner struct {
+	TokenID     int64          `db:"token_id"`
+	ExpiresAt   sql.NullString `db:"expires_at"`
+	RevokedAt   sql.NullString `db:"revoked_at"`
+	LastUsedAt  sql.NullString `db:"last_used_at"`
+	UserID      int            `db:"user_id"`
+	ReferenceID string         `db:"reference_id"`
+	PlanID      string         `db:"plan_id"`
+}
+
+// CreateAPIToken creates a token for a user and returns it with its plaintext.
+// A zero expiresAt never expires.
+func (s *Store) CreateAPIToken(ctx context.Context, userID int, name string, expiresAt time.Time) (*APIToken, string, error) {
+	random := make([]byte, coreauth.APITokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("generate api token: %w", err)
+	}
+	token, err := coreauth.NewAPIToken(random)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &APIToken{
+		ReferenceID: "tok_" + uuid.New().String()[:8],
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   coreauth.HashAPIToken(token),
+		Hint:        coreauth.APITokenHint(token),
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if !expiresAt.IsZero() {
+		t.ExpiresAt = sql.NullString{String: expiresAt.UTC().Format(time.RFC3339), Valid: true}
+	}
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO api_tokens (reference_id, user_id, name, token_hash, hint, created_at, expires_at)
+		VALUES (:reference_id, :user_id, :name, :token_hash, :hint, :created_at, :expires_at)`, t)
+	if err != nil {
+		return nil, "", fmt.Errorf("create api token: %w", err)
+	}
+	t.ID, _ = res.LastInsertId()
+	return t, token, nil
+}
+
+// ListAPITokens returns a user's tokens, newest first, revoked ones included.
+func (s *Store) ListAPITokens(ctx context.Context, userID int) ([]*APIToken, error) {
+	var out []*APIToken
+	if err := s.db.SelectContext(ctx, &out,
+		`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id DESC`, userID); err != nil {
+		return nil, fmt.Errorf("list api tokens: %w", err)
+	}
+	return out, nil
+}
+
+// RevokeAPIToken revokes one of a user's tokens. It reports whether the user
+// has a token with that ID.
+func (s *Store) RevokeAPIToken(ctx context.Context, userID int, referenceID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE reference_id = ? AND user_id = ?`,
+		time.Now().UTC().Format(time.RFC3339), referenceID, userID)
+	if err != nil {
+		return false, fmt.Errorf("revoke api token: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// AuthenticateAPIToken returns the user a token belongs to. Unknown, revoked
+// and expired tokens are rejected with ErrInvalidCredentials.
+func (s *Store) AuthenticateAPIToken(ctx context.Context, token string, now time.Time) (*apiTokenOwner, error) {
+	var owner apiTokenOwner
+	err := s.db.GetContext(ctx, &owner,
+		`SELECT t.id AS token_id, t.expires_at, t.revoked_at, t.last_used_at,
+			u.id AS user_id, u.reference_id, COALESCE(u.plan_id, '') AS plan_id
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, coreauth.HashAPIToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: unknown api token", ErrInvalidCredentials)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("authenticate api token: %w", err)
+	}
+	if owner.RevokedAt.Valid {
+		return nil, fmt.Errorf("%w: api token revoked", ErrInvalidCredentials)
+	}
+	if owner.ExpiresAt.Valid {
+		if exp, err := time.Parse(time.RFC3339, owner.ExpiresAt.String); err == nil && !now.Before(exp) {
+			return nil, fmt.Errorf("%w: api token expired", ErrInvalidCredentials)
+		}
+	}
+
+	// Record the last use, coarsely so busy tokens don't write on every request
+	if last, err := time.Parse(time.RFC3339, owner.LastUsedAt.String); err != nil || now.Sub(last) >= apiTokenTouchInterval {
+		if _, err := s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`,
+			now.UTC().Format(time.RFC3339), owner.TokenID); err != nil {
+			return nil, fmt.Errorf("touch api token: %w", err)
+		}
+	}
+	return &owner, nil
+}
+
+func apiTokenJSONAPI(t *APIToken) map[string]any {
+	return map[string]any{
+		"type": "api-tokens",
+		"id":   t.ReferenceID,
+		"attributes": map[string]any{
+			"name":         t.Name,
+			"hint":         t.Hint,
+			"created_at":   t.CreatedAt,
+			"expires_at":   t.ExpiresAt.String,
+			"last_used_at": t.LastUsedAt.String,
+			"revoked_at":   t.RevokedAt.String,
+		},
+	}
+}
+
+// =============================================================================
+// API Token Handlers
+// =============================================================================
+
+// apiTokensEnabled reports whether API tokens are among the configured
+// authenticators; without it, tokens could be created but never used.
+func apiTokensEnabled(cfg SetupConfig) bool {
+	for _, a := range cfg.Authenticators {
+		if _, ok := a.(*APITokenAuthenticator); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokensHandler handles GET and POST /api-tokens for the current user.
+func apiTokensHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !apiTokensEnabled(cfg) {
+			writeProblem(w, r, ProblemNotConfigured, "api tokens are not enabled; add api_token to auth.backends")
+			return
+		}
+		ctx := r.Context()
+
+		if r.Method == http.MethodGet {
+			tokens, err := cfg.Store.ListAPITokens(ctx, authCtx.UserID)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list api tokens")
+				return
+			}
+			data := make([]map[string]any, 0, len(tokens))
+			for _, t := range tokens {
+				data = append(data, apiTokenJSONAPI(t))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+			return
+		}
+
+		var req struct {
+			Name          string `json:"name"`
+			ExpiresInDays int    `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		if req.Name == "" || len(req.Name) > maxAPITokenNameLength {
+			writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("name is required and at most %d characters", maxAPITokenNameLength))
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			writeProblem(w, r, ProblemValidationFailed, "expires_in_days must not be negative")
+			return
+		}
+		var expiresAt time.Time
+		if req.ExpiresInDays > 0 {
+			expiresAt = time.Now().AddDate(0, 0, req.ExpiresInDays)
+		}
+
+		t, token, err := cfg.Store.CreateAPIToken(ctx, authCtx.UserID, req.Name, expiresAt)
+		if err != nil {
+			cfg.Logger.Error("failed to create api token", "user_id", authCtx.UserID, "error", err)
+			writeProblem(w, r, ProblemInternal, "failed to create api token")
+			return
+		}
+		data := apiTokenJSONAPI(t)
+		// The token is only ever shown here
+		data["attributes"].(map[string]any)["token"] = token
+		writeJSON(w, http.StatusCreated, map[string]any{"data": data})
+	}
+}
+
+// apiTokenRevokeHandler handles DELETE /api-tokens/{id}.
+func apiTokenRevokeHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		found, err := cfg.Store.RevokeAPIToken(r.Context(), authCtx.UserID, mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to revoke api token")
+			return
+		}
+		if !found {
+			writeProblem(w, r, ProblemNotFound, "api token not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
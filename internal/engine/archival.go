@@ -0,0 +1,341 @@
+package engine
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/archive"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Event Archival Storage
+// =============================================================================
+//
+// usage_events and container_events older than the retention cutoff are moved
+// out of SQLite in batches. Each batch is written as a gzipped NDJSON file
+// under the archive directory. Usage events are also rolled up into usage_daily
+// so totals stay queryable. An event_archives row records each file for audit.
+// The file is written and synced before the rows are deleted, and the delete,
+// rollup and audit record commit together.
+
+// EventArchive is the audit record of one archive file.
+type EventArchive struct {
+	ID             int64  `json:"id" db:"id"`
+	TableName      string `json:"table" db:"table_name"`
+	FilePath       string `json:"file_path" db:"file_path"`
+	RowCount       int64  `json:"row_count" db:"row_count"`
+	FirstID        int64  `json:"first_id" db:"first_id"`
+	LastID         int64  `json:"last_id" db:"last_id"`
+	FirstTimestamp string `json:"first_timestamp" db:"first_timestamp"`
+	LastTimestamp  string `json:"last_timestamp" db:"last_timestamp"`
+	Bytes          int64  `json:"bytes" db:"bytes"`
+	SHA256         string `json:"sha256" db:"sha256"`
+	CreatedAt      string `json:"created_at" db:"created_at"`
+}
+
+// ArchiveResult summarises an archival run for one table.
+type ArchiveResult struct {
+	Table string
+	Files int
+	Rows  int64
+}
+
+// archivableFilter restricts which rows of a table may be archived.
+// Unreported usage events are kept until the billing reporter has sent them.
+func archivableFilter(table string) string {
+	if table == archive.TableUsageEvents {
+		return "timestamp < ? AND reported_at IS NOT NULL"
+	}
+	return "timestamp < ?"
+}
+
+// ArchiveEvents archives rows of table older than cutoff into dir, batchSize
+// rows per file, until none are left or ctx is cancelled.
+func (s *Store) ArchiveEvents(ctx context.Context, dir, table string, cutoff time.Time, batchSize int) (ArchiveResult, error) {
+	result := ArchiveResult{Table: table}
+	if !archive.ValidTable(table) {
+		return result, fmt.Errorf("table %q is not archivable", table)
+	}
+	if batchSize <= 0 {
+		batchSize = archive.DefaultBatchSize
+	}
+
+	for ctx.Err() == nil {
+		n, err := s.archiveBatch(ctx, dir, table, cutoff, batchSize)
+		if err != nil {
+			return result, err
+		}
+		if n == 0 {
+			break
+		}
+		result.Files++
+		result.Rows += n
+	}
+	return result, ctx.Err()
+}
+
+// archiveBatch archives one batch and returns the number of rows archived.
+func (s *Store) archiveBatch(ctx context.Context, dir, table string, cutoff time.Time, batchSize int) (int64, error) {
+	rows, err := s.RawQuery(ctx,
+		fmt.Sprintf(`SELECT * FROM %s WHERE %s ORDER BY id LIMIT ?`, table, archivableFilter(table)),
+		cutoff.UTC().Format(time.RFC3339), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("select %s: %w", table, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		ids[i], _ = toInt64(row["id"])
+	}
+	first, last := rows[0], rows[len(rows)-1]
+	firstTS, lastTS := strVal(first["timestamp"]), strVal(last["timestamp"])
+	firstTime, _ := parseTime(firstTS)
+
+	rec := EventArchive{
+		TableName:      table,
+		FilePath:       archive.FileName(table, firstTime, ids[0], ids[len(ids)-1]),
+		RowCount:       int64(len(rows)),
+		FirstID:        ids[0],
+		LastID:         ids[len(ids)-1],
+		FirstTimestamp: firstTS,
+		LastTimestamp:  lastTS,
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	fullPath := filepath.Join(dir, filepath.FromSlash(rec.FilePath))
+	rec.Bytes, rec.SHA256, err = writeNDJSONGzip(fullPath, rows)
+	if err != nil {
+		return 0, fmt.Errorf("write archive %s: %w", rec.FilePath, err)
+	}
+
+	err = s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		if table == archive.TableUsageEvents {
+			if err := upsertDailyUsage(ctx, tx, rows); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.NamedExecContext(ctx,
+			`INSERT INTO event_archives (table_name, file_path, row_count, first_id, last_id,
+				first_timestamp, last_timestamp, bytes, sha256, created_at)
+			VALUES (:table_name, :file_path, :row_count, :first_id, :last_id,
+				:first_timestamp, :last_timestamp, :bytes, :sha256, :created_at)`, rec); err != nil {
+			return fmt.Errorf("record archive: %w", err)
+		}
+		query, args, err := sqlx.In(fmt.Sprintf(`DELETE FROM %s WHERE id IN (?)`, table), ids)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("delete archived rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		// Rows are still in the database; drop the orphaned file so the next
+		// run can write it again.
+		os.Remove(fullPath)
+		return 0, err
+	}
+	return rec.RowCount, nil
+}
+
+// upsertDailyUsage adds a batch of usage event rows to the usage_daily rollup.
+func upsertDailyUsage(ctx context.Context, tx *sqlx.Tx, rows []map[string]any) error {
+	records := make([]archive.UsageRecord, 0, len(rows))
+	for _, row := range rows {
+		userID, _ := toInt64(row["user_id"])
+		quantity, _ := toInt64(row["quantity"])
+		ts, _ := parseTime(row["timestamp"])
+		records = append(records, archive.UsageRecord{
+			UserID:       int(userID),
+			EventType:    strVal(row["event_type"]),
+			ResourceType: strVal(row["resource_type"]),
+			Quantity:     quantity,
+			Timestamp:    ts,
+		})
+	}
+
+	for _, d := range archive.RollupDaily(records) {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO usage_daily (day, user_id, event_type, resource_type, events, quantity)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day, user_id, event_type, resource_type)
+			DO UPDATE SET events = events + excluded.events, quantity = quantity + excluded.quantity`,
+			d.Day, d.UserID, d.EventType, d.ResourceType, d.Events, d.Quantity); err != nil {
+			return fmt.Errorf("roll up usage: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeNDJSONGzip writes rows as gzipped NDJSON via a temp file and rename,
+// returning the file size and SHA-256.
+func writeNDJSONGzip(path string, rows []map[string]any) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hash, counter))
+	enc := json.NewEncoder(gz)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			tmp.Close()
+			return 0, "", err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, "", err
+	}
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// ListEventArchives returns archive records, newest first. An empty table
+// lists every table.
+func (s *Store) ListEventArchives(ctx context.Context, table string, limit int) ([]EventArchive, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := `SELECT id, table_name, file_path, row_count, first_id, last_id,
+		first_timestamp, last_timestamp, bytes, sha256, created_at FROM event_archives`
+	args := []any{}
+	if table != "" {
+		query += ` WHERE table_name = ?`
+		args = append(args, table)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	var out []EventArchive
+	if err := s.db.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, fmt.Errorf("list event archives: %w", err)
+	}
+	return out, nil
+}
+
+// =============================================================================
+// Event Archiver Worker
+// =============================================================================
+
+// EventArchiver periodically moves old usage and container events out of the
+// database into compressed archive files.
+type EventArchiver struct {
+	store     *Store
+	dir       string
+	retention time.Duration
+	batchSize int
+	interval  time.Duration
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewEventArchiver(store *Store, dir string, retention time.Duration, batchSize int, interval time.Duration, logger *slog.Logger) *EventArchiver {
+	if interval == 0 {
+		interval = 24 * time.Hour
+	}
+	if retention == 0 {
+		retention = archive.DefaultRetention
+	}
+	if batchSize == 0 {
+		batchSize = archive.DefaultBatchSize
+	}
+	return &EventArchiver{
+		store:     store,
+		dir:       dir,
+		retention: retention,
+		batchSize: batchSize,
+		interval:  interval,
+		logger:    logger.With("component", "event_archiver"),
+	}
+}
+
+func (a *EventArchiver) Start() {
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	a.wg.Add(1)
+	go a.run()
+	a.logger.Info("event archiver started", "interval", a.interval, "retention", a.retention, "dir", a.dir)
+}
+
+func (a *EventArchiver) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
+
+func (a *EventArchiver) run() {
+	defer a.wg.Done()
+	a.archiveAll()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.archiveAll()
+		}
+	}
+}
+
+func (a *EventArchiver) archiveAll() {
+	defer a.store.LoopMetrics().Time("event_archiver")()
+	cutoff := archive.Cutoff(time.Now(), a.retention)
+	for _, table := range archive.Tables {
+		res, err := a.store.ArchiveEvents(a.ctx, a.dir, table, cutoff, a.batchSize)
+		if err != nil && a.ctx.Err() == nil {
+			a.logger.Error("event archival failed", "table", table, "archived_rows", res.Rows, "error", err)
+			continue
+		}
+		if res.Rows > 0 {
+			a.logger.Info("archived events", "table", table, "rows", res.Rows, "files", res.Files,
+				"before", cutoff.Format(time.DateOnly))
+		}
+	}
+}
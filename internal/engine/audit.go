@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/artpar/hoster/internal/core/audit"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/artpar/hoster/internal/shell/logging"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Audit Events
+// =============================================================================
+//
+// Every successful mutating request to a resource's routes, generic or
+// custom, is recorded in audit_events with the user who made it and the
+// fields it changed. auditMiddleware reads the resource's row before the
+// handler runs and again after it, and core/audit computes the difference.
+// Write-only and encrypted fields are redacted.
+
+// maxAuditBody caps how much of a create response is kept to find the new
+// resource's ID.
+const maxAuditBody = 1 << 20
+
+// auditIgnoredFields are bookkeeping fields left out of changes; the
+// reference ID is the event's resource_id.
+var auditIgnoredFields = map[string]bool{"id": true, "reference_id": true, "created_at": true, "updated_at": true}
+
+// AuditEvent is a recorded mutation.
+type AuditEvent struct {
+	ID           int64  `db:"id"`
+	ReferenceID  string `db:"reference_id"`
+	UserID       int    `db:"user_id"`
+	UserRef      string `db:"user_reference_id"`
+	Action       string `db:"action"`
+	ResourceType string `db:"resource_type"`
+	ResourceID   string `db:"resource_id"`
+	Method       string `db:"method"`
+	Path         string `db:"path"`
+	Status       int    `db:"status"`
+	ChangesJSON  string `db:"changes"`
+	RequestID    string `db:"request_id"`
+	CreatedAt    string `db:"created_at"`
+}
+
+const auditEventColumns = `id, reference_id, user_id, user_reference_id, action, resource_type, resource_id,
+	method, path, status, changes, request_id, created_at`
+
+// AuditFilter narrows a list of audit events. Zero fields match everything.
+type AuditFilter struct {
+	UserRef      string
+	ResourceType string
+	ResourceID   string
+	Since        time.Time
+	Until        time.Time
+}
+
+// RecordAuditEvent stores an audit event, assigning its reference ID and
+// time when they are unset.
+func (s *Store) RecordAuditEvent(ctx context.Context, e *AuditEvent) error {
+	if e.ReferenceID == "" {
+		e.ReferenceID = "aud_" + uuid.New().String()[:8]
+	}
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if e.ChangesJSON == "" {
+		e.ChangesJSON = "{}"
+	}
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO audit_events (reference_id, user_id, user_reference_id, action, resource_type, resource_id,
+			method, path, status, changes, request_id, created_at)
+		VALUES (:reference_id, :user_id, :user_reference_id, :action, :resource_type, :resource_id,
+			:method, :path, :status, :changes, :request_id, :created_at)`, e)
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	e.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// AuditEvents lists audit events matching the filter, newest first.
+func (s *Store) AuditEvents(ctx context.Context, f AuditFilter, page Page) ([]*AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE 1 = 1`
+	var args []any
+	if f.UserRef != "" {
+		query += ` AND user_reference_id = ?`
+		args = append(args, f.UserRef)
+	}
+	if f.ResourceType != "" {
+		query += ` AND resource_type = ?`
+		args = append(args, f.ResourceType)
+	}
+	if f.ResourceID != "" {
+		query += ` AND resource_id = ?`
+		args = append(args, f.ResourceID)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND datetime(created_at) >= datetime(?)`
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND datetime(created_at) < datetime(?)`
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+	if cond, condArgs := page.after("datetime(created_at)", "id", true); cond != "" {
+		query += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	args = append(args, page.Limit, page.Offset)
+
+	var out []*AuditEvent
+	if err := s.db.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	return out, nil
+}
+
+// =============================================================================
+// Audit Middleware
+// =============================================================================
+
+// auditMiddleware records successful mutating requests to resources' routes.
+// Requests the handler rejects are not recorded, and failing to record an
+// event never fails the request.
+func auditMiddleware(store *Store, logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !audit.Mutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			resType, action := auditRoute(store, r)
+			if action == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			id := mux.Vars(r)["id"]
+			var before map[string]any
+			if id != "" {
+				before, _ = store.Get(ctx, resType, id)
+			}
+
+			rec := &auditRecorder{ResponseWriter: w, keepBody: action == audit.ActionCreate}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status >= http.StatusBadRequest {
+				return
+			}
+
+			if action == audit.ActionCreate {
+				id = createdID(rec.body.Bytes())
+			}
+			recordAudit(ctx, store, logger, getAuthContext(r), action, resType, id, before, r.Method, r.URL.Path, rec.status)
+		})
+	}
+}
+
+// recordAudit records a successful change to a resource. before is the row
+// before it, nil for creates; the row after it is read back from the store.
+func recordAudit(ctx context.Context, store *Store, logger *slog.Logger, authCtx AuthContext, action, resType, id string, before map[string]any, method, path string, status int) {
+	var after map[string]any
+	if id != "" {
+		// Deleted rows are gone, or still there in a deleting state
+		after, _ = store.Get(context.WithoutCancel(ctx), resType, id)
+	}
+
+	e := &AuditEvent{
+		UserID:       authCtx.UserID,
+		UserRef:      authCtx.ReferenceID,
+		Action:       action,
+		ResourceType: resType,
+		ResourceID:   id,
+		Method:       method,
+		Path:         path,
+		Status:       status,
+	}
+	if reqID, ok := docker.RequestID(ctx); ok {
+		e.RequestID = reqID
+	}
+	changes := audit.Diff(before, after, audit.Options{Redact: auditRedacted(store, resType), Ignore: auditIgnoredFields})
+	if raw, err := json.Marshal(changes); err == nil {
+		e.ChangesJSON = string(raw)
+	}
+	if err := store.RecordAuditEvent(context.WithoutCancel(ctx), e); err != nil {
+		logging.FromContext(ctx, logger).Warn("failed to record audit event", "resource", resType, "action", action, "error", err)
+	}
+}
+
+// auditRoute returns the resource a request's route addresses and the
+// action it performs on it, or "" for routes outside the API's resources.
+func auditRoute(store *Store, r *http.Request) (string, string) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", ""
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return "", ""
+	}
+	v, ok := apiversion.FromPath(tmpl)
+	if !ok {
+		return "", ""
+	}
+	segs := strings.Split(strings.Trim(strings.TrimPrefix(tmpl, v.Prefix()), "/"), "/")
+	if store.Resource(segs[0]) == nil {
+		return "", ""
+	}
+	return segs[0], audit.ActionFor(r.Method, segs[1:])
+}
+
+// auditRedacted returns the resource's write-only and encrypted fields.
+func auditRedacted(store *Store, resType string) map[string]bool {
+	redact := map[string]bool{}
+	if res := store.Resource(resType); res != nil {
+		for _, f := range res.Fields {
+			if f.WriteOnly || f.Encrypted {
+				redact[f.Name] = true
+			}
+		}
+	}
+	return redact
+}
+
+// createdID returns the ID of the resource in a JSON:API create response.
+func createdID(body []byte) string {
+	var doc struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return ""
+	}
+	return doc.Data.ID
+}
+
+// auditRecorder passes the response through while capturing its status and,
+// for creates, its body.
+type auditRecorder struct {
+	http.ResponseWriter
+	status   int
+	keepBody bool
+	body     bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.keepBody && rec.body.Len()+len(b) <= maxAuditBody {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// =============================================================================
+// Audit Handlers
+// =============================================================================
+
+// auditEventsHandler serves GET /audit-events, newest first. Administrators
+// see every user's events and may filter by ?user=; others see their own.
+// ?resource_type=, ?resource_id=, ?since= and ?until= (RFC 3339) narrow the
+// list further.
+func auditEventsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		q := r.URL.Query()
+		filter := AuditFilter{
+			UserRef:      q.Get("user"),
+			ResourceType: q.Get("resource_type"),
+			ResourceID:   q.Get("resource_id"),
+		}
+		if !isAdmin(cfg, authCtx) {
+			if filter.UserRef != "" && filter.UserRef != authCtx.ReferenceID {
+				writeProblem(w, r, ProblemForbidden, "administrator access required to see other users' events")
+				return
+			}
+			filter.UserRef = authCtx.ReferenceID
+		}
+		for param, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := q.Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					writeProblem(w, r, ProblemValidationFailed, param+" must be an RFC 3339 time")
+					return
+				}
+				*dst = t
+			}
+		}
+		if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Until.After(filter.Since) {
+			writeProblem(w, r, ProblemValidationFailed, "until must be after since")
+			return
+		}
+
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		events, err := cfg.Store.AuditEvents(r.Context(), filter, page)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list audit events")
+			return
+		}
+		data := make([]map[string]any, 0, len(events))
+		for _, e := range events {
+			data = append(data, auditEventJSONAPI(e))
+		}
+		var next string
+		if n := len(events); n > 0 {
+			next = cursorAfter(page, n, events[n-1].CreatedAt, events[n-1].ID)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": data,
+			"meta": pageMeta(page, next),
+		})
+	}
+}
+
+// auditEventJSONAPI renders an audit event as a JSON:API resource object.
+func auditEventJSONAPI(e *AuditEvent) map[string]any {
+	var changes map[string]audit.Change
+	_ = json.Unmarshal([]byte(e.ChangesJSON), &changes)
+	if changes == nil {
+		changes = map[string]audit.Change{}
+	}
+	return map[string]any{
+		"type": "audit_events",
+		"id":   e.ReferenceID,
+		"attributes": map[string]any{
+			"user_id":       e.UserRef,
+			"action":        e.Action,
+			"resource_type": e.ResourceType,
+			"resource_id":   e.ResourceID,
+			"method":        e.Method,
+			"path":          e.Path,
+			"status":        e.Status,
+			"changes":       changes,
+			"request_id":    e.RequestID,
+			"created_at":    e.CreatedAt,
+		},
+	}
+}
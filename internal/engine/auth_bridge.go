@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/artpar/hoster/internal/core/monitoring"
 )
 
 // Auth header constants (injected by APIGate).
@@ -35,66 +37,43 @@ func WithAuth(ctx context.Context, ac AuthContext) context.Context {
 	return context.WithValue(ctx, authContextKey{}, ac)
 }
 
-// AuthMiddleware extracts auth from APIGate-injected headers,
-// resolves the user via the engine Store, and injects AuthContext.
-func AuthMiddleware(store *Store, sharedSecret string, logger *slog.Logger) func(http.Handler) http.Handler {
+// AuthMiddleware resolves the caller through the authenticators, in order,
+// and injects AuthContext. The first authenticator that recognizes the
+// request's credentials decides; a request none recognizes continues
+// unauthenticated.
+func AuthMiddleware(store *Store, authenticators []Authenticator, logger *slog.Logger) func(http.Handler) http.Handler {
 	if logger == nil {
 		logger = slog.Default()
 	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Validate shared secret if configured
-			if sharedSecret != "" {
-				if r.Header.Get(HeaderAPIGateSecret) != sharedSecret {
-					writeError(w, http.StatusForbidden, "invalid gateway secret")
+			var ac AuthContext
+			for _, a := range authenticators {
+				var err error
+				ac, err = a.ResolveUser(r.Context(), r)
+				if err != nil {
+					writeAuthError(w, r, logger, err)
 					return
 				}
-			}
-
-			referenceID := r.Header.Get(HeaderUserID)
-			planID := r.Header.Get(HeaderPlanID)
-
-			// Fallback: extract from JWT Bearer token when APIGate
-			// doesn't inject identity headers (no request_transform configured).
-			if referenceID == "" {
-				if claims := parseJWTClaims(r); claims != nil {
-					referenceID = claims.UserID
-					if planID == "" {
-						planID = claims.PlanID
-					}
+				if ac.Authenticated {
+					break
 				}
 			}
 
-			if referenceID == "" {
+			if !ac.Authenticated {
 				// Unauthenticated — continue with empty AuthContext
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Resolve integer user ID
-			userID, err := store.ResolveUser(r.Context(), referenceID, "", "", planID)
+			// Resolve feature flags once; handlers read them from AuthContext
+			flags, err := store.ResolveFeatures(r.Context(), ac)
 			if err != nil {
-				logger.Error("failed to resolve user", "reference_id", referenceID, "error", err)
-				writeError(w, http.StatusInternalServerError, "failed to resolve user identity")
+				logger.Error("failed to resolve feature flags", "reference_id", ac.ReferenceID, "error", err)
+				writeProblem(w, r, ProblemInternal, "failed to resolve plan features")
 				return
 			}
-
-			ac := AuthContext{
-				Authenticated: true,
-				UserID:        userID,
-				ReferenceID:   referenceID,
-				PlanID:        planID,
-			}
-
-			// Parse plan limits from header or derive from plan ID
-			if limitsJSON := r.Header.Get(HeaderPlanLimits); limitsJSON != "" {
-				var limits PlanLimits
-				if err := json.Unmarshal([]byte(limitsJSON), &limits); err == nil {
-					ac.PlanLimits = limits
-				}
-			} else if ac.PlanID != "" {
-				ac.PlanLimits = DefaultPlanLimits(ac.PlanID)
-			}
+			ac.Features = flags
 
 			r = r.WithContext(WithAuth(r.Context(), ac))
 			next.ServeHTTP(w, r)
@@ -141,13 +120,21 @@ func parseJWTClaims(r *http.Request) *jwtClaims {
 
 // PlanLimits defines resource limits for a user's subscription plan.
 type PlanLimits struct {
-	MaxDeployments      int      `json:"max_deployments"`
-	MaxCPUCores         float64  `json:"max_cpu_cores"`
-	MaxMemoryMB         int64    `json:"max_memory_mb"`
-	MaxDiskMB           int64    `json:"max_disk_mb"`
-	AllowedCapabilities []string `json:"allowed_capabilities"`
+	MaxDeployments      int            `json:"max_deployments"`
+	MaxCPUCores         float64        `json:"max_cpu_cores"`
+	MaxMemoryMB         int64          `json:"max_memory_mb"`
+	MaxDiskMB           int64          `json:"max_disk_mb"`
+	AllowedCapabilities []string       `json:"allowed_capabilities"`
+	MaxLogExportMB      int64          `json:"max_log_export_mb"`
+	MaxUploadKB         int64          `json:"max_upload_kb"`          // Largest template file upload; 0 for the built-in cap
+	TrialDays           int            `json:"trial_days,omitempty"`   // Deployments expire after this many days; 0 for none
+	AbuseAction         string         `json:"abuse_action,omitempty"` // "throttle" throttles deployments flagged for abuse; otherwise they are flagged only
+	Features            map[string]any `json:"features,omitempty"`     // Feature flag values; see features.Parse
 }
 
+// DefaultPlanIDs are the plans DefaultPlanLimits knows, from smallest to largest.
+var DefaultPlanIDs = []string{"free", "starter", "pro"}
+
 // DefaultPlanLimits returns the default limits for a plan ID when
 // the X-Plan-Limits header is not injected by APIGate.
 func DefaultPlanLimits(planID string) PlanLimits {
@@ -158,6 +145,16 @@ func DefaultPlanLimits(planID string) PlanLimits {
 			MaxCPUCores:    1,
 			MaxMemoryMB:    1024,
 			MaxDiskMB:      5120,
+			MaxLogExportMB: 10,
+			MaxUploadKB:    64,
+			AbuseAction:    monitoring.AbuseActionThrottle,
+			Features: map[string]any{
+				"custom_domains":       false,
+				"exec_access":          false,
+				"byo_compose":          false,
+				"autoscaling":          false,
+				"max_backup_retention": 1,
+			},
 		}
 	case "starter":
 		return PlanLimits{
@@ -165,6 +162,16 @@ func DefaultPlanLimits(planID string) PlanLimits {
 			MaxCPUCores:    4,
 			MaxMemoryMB:    4096,
 			MaxDiskMB:      20480,
+			MaxLogExportMB: 100,
+			MaxUploadKB:    256,
+			AbuseAction:    monitoring.AbuseActionThrottle,
+			Features: map[string]any{
+				"custom_domains":       true,
+				"exec_access":          false,
+				"byo_compose":          true,
+				"autoscaling":          false,
+				"max_backup_retention": 7,
+			},
 		}
 	case "pro":
 		return PlanLimits{
@@ -172,6 +179,15 @@ func DefaultPlanLimits(planID string) PlanLimits {
 			MaxCPUCores:    16,
 			MaxMemoryMB:    16384,
 			MaxDiskMB:      102400,
+			MaxLogExportMB: 500,
+			MaxUploadKB:    1024,
+			Features: map[string]any{
+				"custom_domains":       true,
+				"exec_access":          true,
+				"byo_compose":          true,
+				"autoscaling":          true,
+				"max_backup_retention": 30,
+			},
 		}
 	default:
 		return PlanLimits{}
@@ -0,0 +1,387 @@
+package engine
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	coreauth "github.com/artpar/hoster/internal/core/auth"
+)
+
+// =============================================================================
+// Authenticators
+// =============================================================================
+//
+// AuthMiddleware asks a chain of authenticators, in the order auth.backends
+// lists them, who a request comes from. Each authenticator looks for its own
+// kind of credentials: APIGate's headers and shared secret, hoster API
+// tokens, OIDC ID tokens or TLS client certificates. Enterprises can add
+// their own by implementing Authenticator and passing it in
+// SetupConfig.Authenticators.
+
+// Authenticator resolves the user a request comes from.
+//
+// ResolveUser returns an authenticated AuthContext, with UserID resolved
+// against the store, when it recognizes the request's credentials. It returns
+// an empty AuthContext and no error when the request carries none of its
+// credentials, so the next authenticator is asked. It returns an error
+// wrapping ErrInvalidCredentials or ErrInvalidGatewaySecret to reject the
+// request, and any other error when it could not check the credentials.
+type Authenticator interface {
+	ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, r *http.Request) (AuthContext, error)
+
+// ResolveUser calls f.
+func (f AuthenticatorFunc) ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error) {
+	return f(ctx, r)
+}
+
+var (
+	// ErrInvalidCredentials rejects a request whose credentials are present
+	// but invalid, expired or revoked.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrInvalidGatewaySecret rejects a request that did not come through
+	// APIGate when a shared secret is configured.
+	ErrInvalidGatewaySecret = errors.New("invalid gateway secret")
+)
+
+// writeAuthError writes the problem for an authenticator's error.
+func writeAuthError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidGatewaySecret):
+		writeProblem(w, r, ProblemForbidden, "invalid gateway secret")
+	case errors.Is(err, ErrInvalidCredentials):
+		writeProblem(w, r, ProblemAuthenticationRequired, err.Error())
+	default:
+		logger.Error("failed to resolve user", "error", err)
+		writeProblem(w, r, ProblemInternal, "failed to resolve user identity")
+	}
+}
+
+// authenticatedUser completes the AuthContext of a resolved user.
+func authenticatedUser(userID int, referenceID, planID string) AuthContext {
+	ac := AuthContext{
+		Authenticated: true,
+		UserID:        userID,
+		ReferenceID:   referenceID,
+		PlanID:        planID,
+	}
+	if planID != "" {
+		ac.PlanLimits = DefaultPlanLimits(planID)
+	}
+	return ac
+}
+
+// =============================================================================
+// Shared Secret (APIGate)
+// =============================================================================
+
+// SharedSecretAuthenticator trusts the identity headers APIGate injects,
+// falling back to the claims of its JWT. With a secret configured, every
+// request must carry it in X-APIGate-Secret, so this authenticator goes
+// last in a chain: requests that other authenticators recognize need not
+// come through APIGate.
+type SharedSecretAuthenticator struct {
+	store  *Store
+	secret string
+}
+
+// NewSharedSecretAuthenticator returns the APIGate authenticator. An empty
+// secret skips the secret check.
+func NewSharedSecretAuthenticator(store *Store, secret string) *SharedSecretAuthenticator {
+	return &SharedSecretAuthenticator{store: store, secret: secret}
+}
+
+func (a *SharedSecretAuthenticator) ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error) {
+	if a.secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(HeaderAPIGateSecret)), []byte(a.secret)) != 1 {
+		return AuthContext{}, ErrInvalidGatewaySecret
+	}
+
+	referenceID := r.Header.Get(HeaderUserID)
+	planID := r.Header.Get(HeaderPlanID)
+
+	// Fallback: extract from JWT Bearer token when APIGate
+	// doesn't inject identity headers (no request_transform configured).
+	if referenceID == "" {
+		if claims := parseJWTClaims(r); claims != nil {
+			referenceID = claims.UserID
+			if planID == "" {
+				planID = claims.PlanID
+			}
+		}
+	}
+	if referenceID == "" {
+		return AuthContext{}, nil
+	}
+	if err := coreauth.ValidateGatewayUser(referenceID); err != nil {
+		return AuthContext{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	userID, err := a.store.ResolveUser(ctx, referenceID, "", "", planID)
+	if err != nil {
+		return AuthContext{}, err
+	}
+	ac := authenticatedUser(userID, referenceID, planID)
+
+	// Plan limits from header take precedence over the plan's defaults
+	if limitsJSON := r.Header.Get(HeaderPlanLimits); limitsJSON != "" {
+		var limits PlanLimits
+		if err := json.Unmarshal([]byte(limitsJSON), &limits); err == nil {
+			ac.PlanLimits = limits
+		}
+	}
+	return ac, nil
+}
+
+// =============================================================================
+// API Tokens
+// =============================================================================
+
+// APITokenAuthenticator accepts hoster API tokens (hst_...) sent as Bearer
+// tokens. Tokens are created and revoked at /api-tokens.
+type APITokenAuthenticator struct {
+	store *Store
+}
+
+// NewAPITokenAuthenticator returns the API token authenticator.
+func NewAPITokenAuthenticator(store *Store) *APITokenAuthenticator {
+	return &APITokenAuthenticator{store: store}
+}
+
+func (a *APITokenAuthenticator) ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error) {
+	token := coreauth.BearerToken(r.Header.Get("Authorization"))
+	if !coreauth.IsAPIToken(token) {
+		return AuthContext{}, nil
+	}
+	owner, err := a.store.AuthenticateAPIToken(ctx, token, time.Now())
+	if err != nil {
+		return AuthContext{}, err
+	}
+	return authenticatedUser(owner.UserID, owner.ReferenceID, owner.PlanID), nil
+}
+
+// =============================================================================
+// OIDC Sessions
+// =============================================================================
+
+// OIDCConfig configures the OIDC authenticator.
+type OIDCConfig struct {
+	// Issuer is the provider's issuer URL; tokens must carry it in iss.
+	Issuer string
+	// Audience is the client ID tokens must be issued for.
+	Audience string
+	// JWKSURL is where the provider's keys are published. Empty discovers
+	// it from the issuer's /.well-known/openid-configuration.
+	JWKSURL string
+	// Cookie is the cookie sessions are kept in. Tokens are also accepted
+	// as Bearer tokens.
+	Cookie string
+	// UserClaim names the claim users are identified by (default "sub").
+	UserClaim string
+	// PlanClaim names the claim holding the user's plan, if any.
+	PlanClaim string
+	// HTTPClient fetches the provider's keys; nil uses a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// jwksRefreshInterval is how long the provider's keys are cached, and
+// jwksRetryInterval how soon a token with an unknown key may refetch them.
+const (
+	jwksRefreshInterval = time.Hour
+	jwksRetryInterval   = time.Minute
+)
+
+// maxDiscoveryBytes caps the provider documents read.
+const maxDiscoveryBytes = 1 << 20
+
+// OIDCAuthenticator accepts ID tokens issued by an OIDC provider, from the
+// session cookie or as Bearer tokens, verified against the provider's keys.
+// Users are created on first sign-in with the token's email and name, named
+// by the issuer and the subject.
+type OIDCAuthenticator struct {
+	store  *Store
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      coreauth.JWKSet
+	fetchedAt time.Time
+}
+
+// NewOIDCAuthenticator returns an OIDC authenticator.
+func NewOIDCAuthenticator(store *Store, cfg OIDCConfig) *OIDCAuthenticator {
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "sub"
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCAuthenticator{store: store, cfg: cfg, client: client}
+}
+
+func (a *OIDCAuthenticator) ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error) {
+	token := ""
+	if a.cfg.Cookie != "" {
+		if c, err := r.Cookie(a.cfg.Cookie); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		// Bearer JWTs from other issuers are left to the next authenticator
+		bearer := coreauth.BearerToken(r.Header.Get("Authorization"))
+		if !coreauth.IsJWT(bearer) || coreauth.PeekIssuer(bearer) != a.cfg.Issuer {
+			return AuthContext{}, nil
+		}
+		token = bearer
+	}
+
+	claims, err := a.verify(ctx, token)
+	if err != nil {
+		return AuthContext{}, err
+	}
+	subject := claims.String(a.cfg.UserClaim)
+	if subject == "" {
+		return AuthContext{}, fmt.Errorf("%w: token has no %s claim", ErrInvalidCredentials, a.cfg.UserClaim)
+	}
+	referenceID := coreauth.OIDCUser(a.cfg.Issuer, subject)
+	var planID string
+	if a.cfg.PlanClaim != "" {
+		planID = claims.String(a.cfg.PlanClaim)
+	}
+	userID, err := a.store.ResolveUser(ctx, referenceID, claims.String("email"), claims.String("name"), planID)
+	if err != nil {
+		return AuthContext{}, err
+	}
+	return authenticatedUser(userID, referenceID, planID), nil
+}
+
+// verify checks a token against the provider's keys, refetching them once
+// if the token was signed with a key they don't have.
+func (a *OIDCAuthenticator) verify(ctx context.Context, token string) (coreauth.TokenClaims, error) {
+	keys, err := a.jwks(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := coreauth.VerifyJWT(token, keys)
+	if errors.Is(err, coreauth.ErrUnknownKey) {
+		if keys, err = a.jwks(ctx, true); err != nil {
+			return nil, err
+		}
+		claims, err = coreauth.VerifyJWT(token, keys)
+	}
+	if err == nil {
+		err = coreauth.ValidateClaims(claims, a.cfg.Issuer, a.cfg.Audience, time.Now())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return claims, nil
+}
+
+// jwks returns the provider's keys, fetching them when they are stale or,
+// with refresh, when they were last fetched long enough ago.
+func (a *OIDCAuthenticator) jwks(ctx context.Context, refresh bool) (coreauth.JWKSet, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	age := time.Since(a.fetchedAt)
+	if !a.fetchedAt.IsZero() && age < jwksRefreshInterval && (!refresh || age < jwksRetryInterval) {
+		return a.keys, nil
+	}
+
+	jwksURL := a.cfg.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.getJSON(ctx, strings.TrimSuffix(a.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return a.staleKeys(fmt.Errorf("oidc discovery: %w", err))
+		}
+		if discovery.JWKSURI == "" {
+			return a.staleKeys(errors.New("oidc discovery: no jwks_uri"))
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var keys coreauth.JWKSet
+	if err := a.getJSON(ctx, jwksURL, &keys); err != nil {
+		return a.staleKeys(fmt.Errorf("fetch oidc keys: %w", err))
+	}
+	a.keys, a.fetchedAt = keys, time.Now()
+	return keys, nil
+}
+
+// staleKeys keeps serving previously fetched keys while the provider is
+// unreachable.
+func (a *OIDCAuthenticator) staleKeys(err error) (coreauth.JWKSet, error) {
+	if len(a.keys.Keys) > 0 {
+		return a.keys, nil
+	}
+	return coreauth.JWKSet{}, err
+}
+
+func (a *OIDCAuthenticator) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxDiscoveryBytes)).Decode(v)
+}
+
+// =============================================================================
+// TLS Client Certificates
+// =============================================================================
+
+// ClientCertAuthenticator accepts TLS client certificates the server
+// verified against its client CA. The user is named by the certificate's
+// common name, email or URI SAN, prefixed so it can't be another backend's
+// user.
+type ClientCertAuthenticator struct {
+	store    *Store
+	identity coreauth.CertIdentity
+}
+
+// NewClientCertAuthenticator returns the client certificate authenticator.
+func NewClientCertAuthenticator(store *Store, identity coreauth.CertIdentity) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{store: store, identity: identity}
+}
+
+func (a *ClientCertAuthenticator) ResolveUser(ctx context.Context, r *http.Request) (AuthContext, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return AuthContext{}, nil
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		return AuthContext{}, fmt.Errorf("%w: client certificate not verified", ErrInvalidCredentials)
+	}
+	cert := r.TLS.PeerCertificates[0]
+	value, err := coreauth.ClientCertUser(cert, a.identity)
+	if err != nil {
+		return AuthContext{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	referenceID := coreauth.CertUser(a.identity, value)
+	userID, err := a.store.ResolveUser(ctx, referenceID, coreauth.ClientCertEmail(cert), cert.Subject.CommonName, "")
+	if err != nil {
+		return AuthContext{}, err
+	}
+	return authenticatedUser(userID, referenceID, ""), nil
+}
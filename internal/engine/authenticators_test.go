@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	coreauth "github.com/artpar/hoster/internal/core/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Authenticator Tests
+// =============================================================================
+
+func newAuthTest(t *testing.T) (*Store, int) {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	// alice signed up through APIGate
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderUserID, "alice")
+	ac, err := NewSharedSecretAuthenticator(store, "").ResolveUser(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, ac.Authenticated)
+	return store, ac.UserID
+}
+
+// oidcProvider serves a key set and signs ID tokens with its key.
+type oidcProvider struct {
+	key *rsa.PrivateKey
+	srv *httptest.Server
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &oidcProvider{key: key}
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(coreauth.JWKSet{Keys: []coreauth.JWK{{
+			Kty: "RSA", Kid: "k1", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *oidcProvider) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		raw, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCAuthenticator_SubjectDoesNotCollide(t *testing.T) {
+	store, alice := newAuthTest(t)
+	provider := newOIDCProvider(t)
+	issuer := "https://idp.example"
+	a := NewOIDCAuthenticator(store, OIDCConfig{Issuer: issuer, Audience: "hoster", JWKSURL: provider.srv.URL})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+provider.token(t, map[string]any{
+		"iss": issuer, "aud": "hoster", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	ac, err := a.ResolveUser(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, ac.Authenticated)
+	assert.NotEqual(t, alice, ac.UserID, "an ID token for the subject alice is not APIGate's alice")
+	assert.Equal(t, coreauth.OIDCUser(issuer, "alice"), ac.ReferenceID)
+}
+
+func TestClientCertAuthenticator_SubjectDoesNotCollide(t *testing.T) {
+	store, alice := newAuthTest(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	ac, err := NewClientCertAuthenticator(store, coreauth.CertIdentityCN).ResolveUser(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, ac.Authenticated)
+	assert.NotEqual(t, alice, ac.UserID, "a certificate for the CN alice is not APIGate's alice")
+	assert.Equal(t, "cert:cn:alice", ac.ReferenceID)
+}
+
+func TestSharedSecretAuthenticator_RejectsReservedPrefix(t *testing.T) {
+	store, _ := newAuthTest(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderUserID, "cert:cn:alice")
+	_, err := NewSharedSecretAuthenticator(store, "").ResolveUser(context.Background(), req)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
@@ -0,0 +1,495 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/backup"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Database Backups
+// =============================================================================
+//
+// The control plane database is copied with VACUUM INTO, which takes a
+// consistent snapshot while the server keeps running. The copy is checked,
+// gzipped and written to the backup directory as <id>.db.gz next to an
+// <id>.json manifest holding its size and SHA-256. The manifest is written
+// last, so a directory listing only ever shows complete backups. With a remote
+// configured both files are uploaded, data file first.
+
+// BackupRemote stores backup files off the host, e.g. in an S3 bucket.
+type BackupRemote interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string, w io.Writer) error
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Backup writes a snapshot of the database into dir and returns its manifest.
+func (s *Store) Backup(ctx context.Context, dir, version string) (backup.Manifest, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	m := backup.Manifest{ID: backup.NewID(now), CreatedAt: now, Version: version}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return m, err
+	}
+	dataPath := filepath.Join(dir, backup.DataFile(m.ID))
+	if _, err := os.Stat(dataPath); err == nil {
+		return m, fmt.Errorf("backup %s already exists", m.ID)
+	}
+
+	snapshot := filepath.Join(dir, ".snapshot-"+m.ID+".db")
+	os.Remove(snapshot)
+	defer os.Remove(snapshot)
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, snapshot); err != nil {
+		return m, fmt.Errorf("snapshot database: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, snapshot); err != nil {
+		return m, err
+	}
+	info, err := os.Stat(snapshot)
+	if err != nil {
+		return m, err
+	}
+	m.DatabaseBytes = info.Size()
+
+	m.Bytes, m.SHA256, err = gzipFile(snapshot, dataPath)
+	if err != nil {
+		return m, fmt.Errorf("compress backup: %w", err)
+	}
+	if err := writeManifest(dir, m); err != nil {
+		os.Remove(dataPath)
+		return m, err
+	}
+	return m, nil
+}
+
+// ListBackups returns the manifests in dir, newest first. A missing
+// directory has no backups.
+func ListBackups(dir string) ([]backup.Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []backup.Manifest
+	for _, e := range entries {
+		if _, ok := backup.IDFromManifestFile(e.Name()); !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, err := decodeManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		out = append(out, m)
+	}
+	backup.SortNewestFirst(out)
+	return out, nil
+}
+
+// RemoveBackup deletes a backup's files from dir, manifest first.
+func RemoveBackup(dir, id string) error {
+	for _, name := range []string{backup.ManifestFile(id), backup.DataFile(id)} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadBackup copies a local backup to the remote.
+func UploadBackup(ctx context.Context, remote BackupRemote, dir string, m backup.Manifest) error {
+	for _, name := range []string{backup.DataFile(m.ID), backup.ManifestFile(m.ID)} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := remote.Put(ctx, name, data); err != nil {
+			return fmt.Errorf("upload backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListRemoteBackups returns the manifests on the remote, newest first.
+func ListRemoteBackups(ctx context.Context, remote BackupRemote) ([]backup.Manifest, error) {
+	names, err := remote.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []backup.Manifest
+	for _, name := range names {
+		if _, ok := backup.IDFromManifestFile(name); !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := remote.Get(ctx, name, &buf); err != nil {
+			return nil, err
+		}
+		m, err := decodeManifest(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		out = append(out, m)
+	}
+	backup.SortNewestFirst(out)
+	return out, nil
+}
+
+// FetchBackup downloads a remote backup into dir unless it is already there.
+func FetchBackup(ctx context.Context, remote BackupRemote, dir string, m backup.Manifest) error {
+	dataPath := filepath.Join(dir, backup.DataFile(m.ID))
+	if _, err := os.Stat(dataPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if err := remote.Get(ctx, backup.DataFile(m.ID), tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("download backup: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dataPath); err != nil {
+		return err
+	}
+	return writeManifest(dir, m)
+}
+
+// RestoreDatabase replaces the database file at dbPath with the backup m from
+// dir. The backup is verified against its manifest and checked before the
+// current database is moved aside; the path it was moved to is returned. The
+// server must not be running.
+func RestoreDatabase(ctx context.Context, dbPath, dir string, m backup.Manifest) (string, error) {
+	dataPath := filepath.Join(dir, backup.DataFile(m.ID))
+	sum, err := fileSHA256(dataPath)
+	if err != nil {
+		return "", err
+	}
+	if sum != m.SHA256 {
+		return "", fmt.Errorf("backup %s: checksum mismatch", m.ID)
+	}
+
+	restored := dbPath + ".restoring"
+	defer os.Remove(restored) // no-op after a successful rename
+	if err := gunzipFile(dataPath, restored); err != nil {
+		return "", fmt.Errorf("decompress backup: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, restored); err != nil {
+		return "", err
+	}
+
+	aside := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		aside = dbPath + ".pre-restore-" + backup.NewID(time.Now())
+		if err := os.Rename(dbPath, aside); err != nil {
+			return "", err
+		}
+	}
+	// A journal left by the replaced database must not be applied to the
+	// restored one.
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return aside, err
+		}
+	}
+	if err := os.Rename(restored, dbPath); err != nil {
+		return aside, err
+	}
+	return aside, nil
+}
+
+// checkDatabaseFile runs SQLite's quick integrity check on a database file.
+func checkDatabaseFile(ctx context.Context, path string) error {
+	db, err := sqlx.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.GetContext(ctx, &result, `PRAGMA quick_check`); err != nil {
+		return fmt.Errorf("check database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("check database: %s", result)
+	}
+	return nil
+}
+
+// writeManifest writes a backup manifest via a temp file and rename.
+func writeManifest(dir string, m backup.Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, backup.ManifestFile(m.ID))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func decodeManifest(data []byte) (backup.Manifest, error) {
+	var m backup.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, err
+	}
+	return m, m.Validate()
+}
+
+// gzipFile compresses src into dst via a temp file and rename, returning the
+// size and SHA-256 of dst.
+func gzipFile(src, dst string) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".backup-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hash, counter))
+	if _, err := io.Copy(gz, in); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, "", err
+	}
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func gunzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, gz); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// =============================================================================
+// Backup Scheduler Worker
+// =============================================================================
+
+// BackupScheduler periodically backs up the database, uploads the backup to
+// the remote if one is configured, and prunes old backups in both places.
+type BackupScheduler struct {
+	store     *Store
+	dir       string
+	interval  time.Duration
+	retention backup.Retention
+	remote    BackupRemote
+	version   string
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu        sync.Mutex
+	status    backup.Status
+	startedAt time.Time
+}
+
+func NewBackupScheduler(store *Store, dir string, interval time.Duration, retention backup.Retention, remote BackupRemote, version string, logger *slog.Logger) *BackupScheduler {
+	if interval == 0 {
+		interval = backup.DefaultInterval
+	}
+	if interval < backup.MinInterval {
+		interval = backup.MinInterval
+	}
+	return &BackupScheduler{
+		store:     store,
+		dir:       dir,
+		interval:  interval,
+		retention: retention,
+		remote:    remote,
+		version:   version,
+		logger:    logger.With("component", "backup_scheduler"),
+		startedAt: time.Now(),
+	}
+}
+
+func (b *BackupScheduler) Start() {
+	b.mu.Lock()
+	if ms, err := ListBackups(b.dir); err == nil && len(ms) > 0 {
+		b.status.LastSuccess = &ms[0]
+	}
+	b.mu.Unlock()
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.wg.Add(1)
+	go b.run()
+	b.logger.Info("backup scheduler started", "interval", b.interval, "dir", b.dir, "remote", b.remote != nil)
+}
+
+func (b *BackupScheduler) Stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// Check reports whether backups are current, for readiness checks.
+func (b *BackupScheduler) Check() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status.Check(b.interval, b.startedAt, time.Now())
+}
+
+// Status returns the outcome of recent backups.
+func (b *BackupScheduler) Status() backup.Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *BackupScheduler) run() {
+	defer b.wg.Done()
+	b.backupOnce()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			b.backupOnce()
+		}
+	}
+}
+
+func (b *BackupScheduler) backupOnce() {
+	defer b.store.LoopMetrics().Time("backups")()
+	m, err := b.store.Backup(b.ctx, b.dir, b.version)
+	if err == nil && b.remote != nil {
+		if err = UploadBackup(b.ctx, b.remote, b.dir, m); err != nil {
+			// Keep the local copy; the next run uploads a newer one.
+			err = fmt.Errorf("backup %s kept locally: %w", m.ID, err)
+		}
+	}
+	if b.ctx.Err() != nil {
+		return
+	}
+
+	b.mu.Lock()
+	if err != nil {
+		b.status.LastError, b.status.LastErrorAt = err.Error(), time.Now().UTC()
+	} else {
+		b.status.LastSuccess, b.status.LastError, b.status.LastErrorAt = &m, "", time.Time{}
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		b.logger.Error("database backup failed", "error", err)
+		return
+	}
+	b.logger.Info("database backed up", "id", m.ID, "bytes", m.Bytes, "database_bytes", m.DatabaseBytes)
+	b.prune()
+}
+
+// prune applies the retention policy to local and remote backups separately.
+func (b *BackupScheduler) prune() {
+	now := time.Now()
+	local, err := ListBackups(b.dir)
+	if err != nil {
+		b.logger.Error("list backups failed", "error", err)
+	}
+	for _, m := range b.retention.Prune(local, now) {
+		if err := RemoveBackup(b.dir, m.ID); err != nil {
+			b.logger.Error("remove backup failed", "id", m.ID, "error", err)
+		}
+	}
+
+	if b.remote == nil {
+		return
+	}
+	remote, err := ListRemoteBackups(b.ctx, b.remote)
+	if err != nil {
+		b.logger.Error("list remote backups failed", "error", err)
+		return
+	}
+	for _, m := range b.retention.Prune(remote, now) {
+		for _, name := range []string{backup.ManifestFile(m.ID), backup.DataFile(m.ID)} {
+			if err := b.remote.Delete(b.ctx, name); err != nil {
+				b.logger.Error("remove remote backup failed", "id", m.ID, "error", err)
+			}
+		}
+	}
+}
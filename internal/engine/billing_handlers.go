@@ -21,37 +21,37 @@ func invoicePayHandler(cfg SetupConfig) http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		if cfg.StripeKey == "" {
-			writeError(w, http.StatusServiceUnavailable, "payment not configured")
+			writeProblem(w, r, ProblemNotConfigured, "payment not configured")
 			return
 		}
 
 		invoice, err := cfg.Store.Get(ctx, "invoices", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "invoice not found")
+			writeProblem(w, r, ProblemNotFound, "invoice not found")
 			return
 		}
 
 		// Check ownership
 		ownerID, ok := toInt64(invoice["user_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
 		status, _ := invoice["status"].(string)
 		if status != "draft" && status != "failed" {
-			writeError(w, http.StatusConflict, "invoice is not payable in state: "+status)
+			writeProblem(w, r, ProblemInvalidState, "invoice is not payable in state: "+status)
 			return
 		}
 
 		totalCents, _ := toInt64(invoice["total_cents"])
 		if totalCents <= 0 {
-			writeError(w, http.StatusBadRequest, "invoice has no amount")
+			writeProblem(w, r, ProblemValidationFailed, "invoice has no amount")
 			return
 		}
 
@@ -92,7 +92,7 @@ func invoicePayHandler(cfg SetupConfig) http.HandlerFunc {
 		)
 		if err != nil {
 			cfg.Logger.Error("stripe checkout failed", "error", err, "invoice", refID)
-			writeError(w, http.StatusBadGateway, "payment provider error: "+err.Error())
+			writeProblem(w, r, ProblemUpstreamFailed, "payment provider error: "+err.Error())
 			return
 		}
 
@@ -121,25 +121,25 @@ func verifyPaymentHandler(cfg SetupConfig) http.HandlerFunc {
 		authCtx := getAuthContext(r)
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		if cfg.StripeKey == "" {
-			writeError(w, http.StatusServiceUnavailable, "payment not configured")
+			writeProblem(w, r, ProblemNotConfigured, "payment not configured")
 			return
 		}
 
 		sessionID := r.URL.Query().Get("session_id")
 		if sessionID == "" {
-			writeError(w, http.StatusBadRequest, "session_id required")
+			writeProblem(w, r, ProblemValidationFailed, "session_id required")
 			return
 		}
 
 		// Find invoice by stripe_session_id — query all user's invoices
 		allInvoices, err := cfg.Store.List(ctx, "invoices", nil, Page{Limit: 100})
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to query invoices")
+			writeProblem(w, r, ProblemInternal, "failed to query invoices")
 			return
 		}
 
@@ -155,7 +155,7 @@ func verifyPaymentHandler(cfg SetupConfig) http.HandlerFunc {
 		}
 
 		if invoice == nil {
-			writeError(w, http.StatusNotFound, "invoice not found for this session")
+			writeProblem(w, r, ProblemNotFound, "invoice not found for this session")
 			return
 		}
 
@@ -163,7 +163,7 @@ func verifyPaymentHandler(cfg SetupConfig) http.HandlerFunc {
 		paid, err := checkStripeSession(cfg.StripeKey, sessionID)
 		if err != nil {
 			cfg.Logger.Error("stripe session check failed", "error", err, "session", sessionID)
-			writeError(w, http.StatusBadGateway, "payment verification failed")
+			writeProblem(w, r, ProblemUpstreamFailed, "payment verification failed")
 			return
 		}
 
@@ -175,8 +175,15 @@ func verifyPaymentHandler(cfg SetupConfig) http.HandlerFunc {
 			cfg.Store.Update(ctx, "invoices", refID, map[string]any{
 				"paid_at": now,
 			})
-			cfg.Store.Transition(ctx, "invoices", refID, "paid")
+			row, cmd, err := cfg.Store.Transition(ctx, "invoices", refID, "paid")
 			cfg.Logger.Info("invoice paid", "invoice", refID, "session", sessionID)
+
+			// Record creator earnings for the paid invoice
+			if err == nil && cmd != "" && cfg.Bus != nil {
+				if err := cfg.Bus.Dispatch(ctx, cmd, row); err != nil {
+					cfg.Logger.Error("invoice paid command failed", "invoice", refID, "error", err)
+				}
+			}
 		}
 
 		resultStatus := status
@@ -206,7 +213,7 @@ func createStripeCheckout(stripeKey string, amountCents int64, currency, success
 	data.Set("success_url", successURL)
 	data.Set("cancel_url", cancelURL)
 
-	req, err := http.NewRequest("POST", "https://api.stripe.com/v1/checkout/sessions", strings.NewReader(data.Encode()))
+	req, err := http.NewRequest("POST", stripeAPI+"/checkout/sessions", strings.NewReader(data.Encode()))
 	if err != nil {
 		return "", "", fmt.Errorf("create request: %w", err)
 	}
@@ -238,7 +245,7 @@ func createStripeCheckout(stripeKey string, amountCents int64, currency, success
 
 // checkStripeSession checks if a Stripe Checkout Session has been paid.
 func checkStripeSession(stripeKey, sessionID string) (bool, error) {
-	req, err := http.NewRequest("GET", "https://api.stripe.com/v1/checkout/sessions/"+sessionID, nil)
+	req, err := http.NewRequest("GET", stripeAPI+"/checkout/sessions/"+sessionID, nil)
 	if err != nil {
 		return false, fmt.Errorf("create request: %w", err)
 	}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/artpar/hoster/internal/core/bootstrap"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Installation Bootstrap
+// =============================================================================
+//
+// A fresh database has only the system user and its starter templates.
+// `hoster init` creates the first admin and records it in the single-row
+// installation table; the server treats that admin like one listed in
+// auth.admins. A database counts as initialized once it has an installation
+// row or any user besides system, so init never runs over a live install.
+
+// ErrAlreadyInitialized is returned when bootstrapping an initialized database.
+var ErrAlreadyInitialized = errors.New("installation already initialized")
+
+// initializedQuery counts what makes a database initialized.
+const initializedQuery = `SELECT (SELECT COUNT(*) FROM installation) + (SELECT COUNT(*) FROM users WHERE reference_id != ?)`
+
+// Installation records how a database was bootstrapped.
+type Installation struct {
+	AdminReferenceID string `db:"admin_reference_id" json:"admin_reference_id"`
+	StarterTemplates bool   `db:"starter_templates" json:"starter_templates"`
+	InitializedAt    string `db:"initialized_at" json:"initialized_at"`
+}
+
+// Installation returns the bootstrap record, or ErrNotFound before init.
+func (s *Store) Installation(ctx context.Context) (*Installation, error) {
+	var inst Installation
+	err := s.db.GetContext(ctx, &inst, `SELECT admin_reference_id, starter_templates, initialized_at FROM installation WHERE id = 1`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("installation: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get installation: %w", err)
+	}
+	return &inst, nil
+}
+
+// Initialized reports whether the database already has an installation
+// record or any user other than the system user.
+func (s *Store) Initialized(ctx context.Context) (bool, error) {
+	var n int
+	err := s.db.GetContext(ctx, &n, initializedQuery, bootstrap.SystemUserID)
+	if err != nil {
+		return false, fmt.Errorf("check initialized: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Bootstrap creates the admin user and the installation record in one
+// transaction. Without starterTemplates the system user's templates are
+// removed. It fails with ErrAlreadyInitialized if Initialized would be true.
+func (s *Store) Bootstrap(ctx context.Context, admin bootstrap.Admin, starterTemplates bool) (*Installation, error) {
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var n int
+		if err := tx.GetContext(ctx, &n, initializedQuery, bootstrap.SystemUserID); err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrAlreadyInitialized
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO users (reference_id, email, name, plan_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, datetime('now'), datetime('now'))
+		`, admin.ReferenceID, admin.Email, admin.Name, admin.PlanID); err != nil {
+			return fmt.Errorf("create admin: %w", err)
+		}
+
+		if !starterTemplates {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM templates WHERE creator_id = (SELECT id FROM users WHERE reference_id = ?)
+			`, bootstrap.SystemUserID); err != nil {
+				return fmt.Errorf("remove starter templates: %w", err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO installation (id, admin_reference_id, starter_templates, initialized_at)
+			VALUES (1, ?, ?, datetime('now'))
+		`, admin.ReferenceID, starterTemplates)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlreadyInitialized) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("bootstrap: %w", err)
+	}
+	return s.Installation(ctx)
+}
+
+// BootstrapAdmins returns the admin created by `hoster init`, if any, for
+// merging into the configured admin list.
+func (s *Store) BootstrapAdmins(ctx context.Context) ([]string, error) {
+	inst, err := s.Installation(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []string{inst.AdminReferenceID}, nil
+}
@@ -0,0 +1,667 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/crypto"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/storage"
+	"github.com/artpar/hoster/internal/shell/billing"
+	shellstorage "github.com/artpar/hoster/internal/shell/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Managed Bucket Storage
+// =============================================================================
+//
+// deployment_buckets holds one row per object storage bucket a deployment
+// owns. The BucketManager provisions pending rows, meters stored data, and
+// releases and purges buckets once their deployment is deleted. Bucket
+// credentials are kept encrypted with the store's encryption key and are
+// injected into the deployment's variables each time it starts.
+
+// DeploymentBucket is a managed object storage bucket owned by a deployment.
+type DeploymentBucket struct {
+	ID              int64          `db:"id"`
+	ReferenceID     string         `db:"reference_id"`
+	DeploymentID    string         `db:"deployment_id"`
+	OwnerID         int64          `db:"owner_id"`
+	Name            string         `db:"name"`
+	BucketName      string         `db:"bucket_name"`
+	Backend         string         `db:"backend"`
+	NodeID          string         `db:"node_id"`
+	VariablePrefix  string         `db:"variable_prefix"`
+	RetentionPolicy string         `db:"retention_policy"`
+	RetentionDays   int            `db:"retention_days"`
+	Status          string         `db:"status"`
+	Credentials     string         `db:"credentials"` // Encrypted storage.Credentials JSON
+	Endpoint        string         `db:"endpoint"`
+	SizeBytes       int64          `db:"size_bytes"`
+	ObjectCount     int64          `db:"object_count"`
+	MeasuredAt      sql.NullString `db:"measured_at"`
+	ErrorMessage    string         `db:"error_message"`
+	CreatedAt       string         `db:"created_at"`
+	UpdatedAt       string         `db:"updated_at"`
+	ReleasedAt      sql.NullString `db:"released_at"`
+	PurgeAfter      sql.NullString `db:"purge_after"`
+}
+
+var (
+	ErrBucketNameInUse   = errors.New("deployment already has a bucket with this name")
+	ErrBucketPrefixInUse = errors.New("another bucket of this deployment uses this variable prefix")
+)
+
+const deploymentBucketColumns = `id, reference_id, deployment_id, owner_id, name, bucket_name, backend,
+	node_id, variable_prefix, retention_policy, retention_days, status, credentials, endpoint,
+	size_bytes, object_count, measured_at, error_message, created_at, updated_at, released_at, purge_after`
+
+// CreateDeploymentBucket inserts a pending bucket, naming it under bucketPrefix.
+// A failed bucket with the same name is reset to pending instead, so the
+// customer can retry provisioning.
+func (s *Store) CreateDeploymentBucket(ctx context.Context, b *DeploymentBucket, bucketPrefix string) error {
+	return s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var live []DeploymentBucket
+		if err := tx.SelectContext(ctx, &live, `SELECT `+deploymentBucketColumns+` FROM deployment_buckets
+			WHERE deployment_id = ? AND status NOT IN (?, ?)`, b.DeploymentID, storage.StatusDeleted, storage.StatusRetained); err != nil {
+			return fmt.Errorf("load deployment buckets: %w", err)
+		}
+		var retry *DeploymentBucket
+		for i := range live {
+			other := &live[i]
+			if other.Name == b.Name {
+				if other.Status != string(storage.StatusFailed) {
+					return ErrBucketNameInUse
+				}
+				retry = other
+				continue
+			}
+			if other.VariablePrefix == b.VariablePrefix {
+				return ErrBucketPrefixInUse
+			}
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		b.Status = string(storage.StatusPending)
+		b.UpdatedAt = now
+		if retry != nil {
+			b.ID, b.ReferenceID, b.BucketName, b.CreatedAt = retry.ID, retry.ReferenceID, retry.BucketName, retry.CreatedAt
+			b.Credentials = retry.Credentials
+			_, err := tx.NamedExecContext(ctx, `UPDATE deployment_buckets SET backend = :backend, node_id = :node_id,
+				variable_prefix = :variable_prefix, retention_policy = :retention_policy, retention_days = :retention_days,
+				status = :status, error_message = '', updated_at = :updated_at WHERE id = :id`, b)
+			if err != nil {
+				return fmt.Errorf("retry deployment bucket: %w", err)
+			}
+			return nil
+		}
+
+		b.ReferenceID = "bkt_" + uuid.New().String()[:8]
+		b.BucketName = storage.BucketName(bucketPrefix, b.ReferenceID, b.Name)
+		b.CreatedAt = now
+		res, err := tx.NamedExecContext(ctx, `INSERT INTO deployment_buckets (reference_id, deployment_id, owner_id,
+				name, bucket_name, backend, node_id, variable_prefix, retention_policy, retention_days, status,
+				created_at, updated_at)
+			VALUES (:reference_id, :deployment_id, :owner_id, :name, :bucket_name, :backend, :node_id,
+				:variable_prefix, :retention_policy, :retention_days, :status, :created_at, :updated_at)`, b)
+		if err != nil {
+			return fmt.Errorf("create deployment bucket: %w", err)
+		}
+		b.ID, _ = res.LastInsertId()
+		return nil
+	})
+}
+
+// SaveDeploymentBucket writes a bucket's lifecycle, credential and usage state.
+func (s *Store) SaveDeploymentBucket(ctx context.Context, b *DeploymentBucket) error {
+	b.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx, `UPDATE deployment_buckets SET status = :status,
+			credentials = :credentials, endpoint = :endpoint, size_bytes = :size_bytes,
+			object_count = :object_count, measured_at = :measured_at, error_message = :error_message,
+			updated_at = :updated_at, released_at = :released_at, purge_after = :purge_after
+		WHERE id = :id`, b)
+	if err != nil {
+		return fmt.Errorf("save deployment bucket: %w", err)
+	}
+	return nil
+}
+
+// ActivateDeploymentBucket stores a provisioned bucket's credentials and
+// marks it active, unless its deployment was deleted while it was being
+// provisioned; the credentials are kept either way so they can be revoked.
+func (s *Store) ActivateDeploymentBucket(ctx context.Context, b *DeploymentBucket, creds storage.Credentials) error {
+	if err := s.sealBucketCredentials(b, creds); err != nil {
+		return err
+	}
+	b.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.ExecContext(ctx, `UPDATE deployment_buckets SET credentials = ?, endpoint = ?, error_message = '',
+			status = CASE WHEN status = ? THEN ? ELSE status END, updated_at = ?
+		WHERE id = ?`,
+		b.Credentials, b.Endpoint, storage.StatusPending, storage.StatusActive, b.UpdatedAt, b.ID)
+	if err != nil {
+		return fmt.Errorf("activate deployment bucket: %w", err)
+	}
+	return s.db.GetContext(ctx, &b.Status, `SELECT status FROM deployment_buckets WHERE id = ?`, b.ID)
+}
+
+func (s *Store) selectDeploymentBuckets(ctx context.Context, query string, args ...any) ([]*DeploymentBucket, error) {
+	var out []*DeploymentBucket
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+deploymentBucketColumns+` FROM deployment_buckets `+query, args...); err != nil {
+		return nil, fmt.Errorf("query deployment buckets: %w", err)
+	}
+	return out, nil
+}
+
+// ListDeploymentBuckets returns a deployment's buckets, oldest first.
+func (s *Store) ListDeploymentBuckets(ctx context.Context, deploymentID string) ([]*DeploymentBucket, error) {
+	return s.selectDeploymentBuckets(ctx, `WHERE deployment_id = ? ORDER BY id`, deploymentID)
+}
+
+// ListDeploymentBucketsByStatus returns buckets in any of the given states, oldest first.
+func (s *Store) ListDeploymentBucketsByStatus(ctx context.Context, statuses ...storage.Status) ([]*DeploymentBucket, error) {
+	query, args, err := sqlx.In(`WHERE status IN (?) ORDER BY id`, statuses)
+	if err != nil {
+		return nil, err
+	}
+	return s.selectDeploymentBuckets(ctx, query, args...)
+}
+
+// ReleaseDeploymentBuckets marks the buckets of a deleted deployment for release.
+func (s *Store) ReleaseDeploymentBuckets(ctx context.Context, deploymentID string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.ExecContext(ctx, `UPDATE deployment_buckets SET status = ?, released_at = ?, updated_at = ?
+		WHERE deployment_id = ? AND status IN (?, ?, ?)`,
+		storage.StatusReleasing, now, now, deploymentID,
+		storage.StatusPending, storage.StatusActive, storage.StatusFailed)
+	if err != nil {
+		return fmt.Errorf("release deployment buckets: %w", err)
+	}
+	return nil
+}
+
+// sealBucketCredentials encrypts creds into the bucket row.
+func (s *Store) sealBucketCredentials(b *DeploymentBucket, creds storage.Credentials) error {
+	if len(s.encryptionKey) == 0 {
+		return errors.New("bucket credentials require an encryption key")
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("marshal bucket credentials: %w", err)
+	}
+	sealed, err := crypto.EncryptToBase64(plaintext, s.encryptionKey)
+	if err != nil {
+		return fmt.Errorf("encrypt bucket credentials: %w", err)
+	}
+	b.Credentials = sealed
+	b.Endpoint = creds.Endpoint
+	return nil
+}
+
+// BucketCredentials decrypts a bucket's credentials. A bucket that was never
+// provisioned has empty credentials.
+func (s *Store) BucketCredentials(b *DeploymentBucket) (storage.Credentials, error) {
+	var creds storage.Credentials
+	if b.Credentials == "" {
+		return creds, nil
+	}
+	plaintext, err := crypto.DecryptFromBase64(b.Credentials, s.encryptionKey)
+	if err != nil {
+		return creds, fmt.Errorf("decrypt bucket %s credentials: %w", b.ReferenceID, err)
+	}
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return creds, fmt.Errorf("decode bucket %s credentials: %w", b.ReferenceID, err)
+	}
+	return creds, nil
+}
+
+// injectBucketVariables adds the credentials of a deployment's buckets to its
+// variables. Bucket variables win over variables set on the deployment, since
+// their values change whenever a bucket is provisioned again.
+func injectBucketVariables(ctx context.Context, store *Store, depl *domain.Deployment) error {
+	buckets, err := store.ListDeploymentBuckets(ctx, depl.ReferenceID)
+	if err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		switch storage.Status(b.Status) {
+		case storage.StatusActive:
+		case storage.StatusPending:
+			return fmt.Errorf("storage bucket %q is still being provisioned", b.Name)
+		case storage.StatusFailed:
+			return fmt.Errorf("storage bucket %q failed to provision: %s", b.Name, b.ErrorMessage)
+		default:
+			continue
+		}
+		creds, err := store.BucketCredentials(b)
+		if err != nil {
+			return err
+		}
+		if depl.Variables == nil {
+			depl.Variables = make(map[string]string)
+		}
+		for k, v := range storage.Variables(b.VariablePrefix, creds) {
+			depl.Variables[k] = v
+		}
+	}
+	return nil
+}
+
+// deploymentBucketJSONAPI renders a bucket as a JSON:API resource object.
+// Credentials are never returned; only the names of the injected variables.
+func deploymentBucketJSONAPI(b *DeploymentBucket) map[string]any {
+	variables := make([]string, 0, 6)
+	for name := range storage.Variables(b.VariablePrefix, storage.Credentials{}) {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+	return map[string]any{
+		"type": "buckets",
+		"id":   b.ReferenceID,
+		"attributes": map[string]any{
+			"deployment_id":    b.DeploymentID,
+			"name":             b.Name,
+			"bucket_name":      b.BucketName,
+			"backend":          b.Backend,
+			"status":           b.Status,
+			"endpoint":         b.Endpoint,
+			"variable_prefix":  b.VariablePrefix,
+			"variables":        variables,
+			"retention_policy": b.RetentionPolicy,
+			"retention_days":   b.RetentionDays,
+			"size_bytes":       b.SizeBytes,
+			"object_count":     b.ObjectCount,
+			"measured_at":      b.MeasuredAt.String,
+			"error_message":    b.ErrorMessage,
+			"created_at":       b.CreatedAt,
+			"updated_at":       b.UpdatedAt,
+			"released_at":      b.ReleasedAt.String,
+			"purge_after":      b.PurgeAfter.String,
+		},
+	}
+}
+
+// =============================================================================
+// Bucket Handlers
+// =============================================================================
+
+// deploymentBucketsHandler handles GET and POST /deployments/{id}/buckets.
+func deploymentBucketsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if ownerID, ok := toInt64(depl["customer_id"]); !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		refID := strVal(depl["reference_id"])
+
+		if r.Method == http.MethodGet {
+			buckets, err := cfg.Store.ListDeploymentBuckets(ctx, refID)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list buckets")
+				return
+			}
+			data := make([]map[string]any, 0, len(buckets))
+			for _, b := range buckets {
+				data = append(data, deploymentBucketJSONAPI(b))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+			return
+		}
+
+		if cfg.Buckets == nil {
+			writeProblem(w, r, ProblemNotConfigured, "managed storage is not configured")
+			return
+		}
+
+		var req struct {
+			Name            string `json:"name"`
+			Backend         string `json:"backend"`
+			VariablePrefix  string `json:"variable_prefix"`
+			RetentionPolicy string `json:"retention_policy"`
+			RetentionDays   int    `json:"retention_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body")
+			return
+		}
+		if err := storage.ValidateName(req.Name); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		if req.VariablePrefix == "" {
+			req.VariablePrefix = storage.DefaultVariablePrefix
+		}
+		if err := storage.ValidateVariablePrefix(req.VariablePrefix); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		backend := storage.Backend(req.Backend)
+		if backend == "" {
+			backend = cfg.Buckets.DefaultBackend()
+		}
+		if !cfg.Buckets.Available(backend) {
+			writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("storage backend %q is not available", backend))
+			return
+		}
+		if req.RetentionPolicy == "" {
+			req.RetentionPolicy = string(storage.RetentionDelete)
+		}
+		if err := storage.ValidateRetention(storage.RetentionPolicy(req.RetentionPolicy), req.RetentionDays); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		switch strVal(depl["status"]) {
+		case "deleting", "deleted":
+			writeProblem(w, r, ProblemInvalidState, "deployment is being deleted")
+			return
+		}
+		if err := checkSpendingLimit(ctx, cfg.Store, cfg.UsagePrices, authCtx.UserID, time.Now()); err != nil {
+			writeSpendingProblem(w, r, err)
+			return
+		}
+		nodeID := strVal(depl["node_id"])
+		if backend == storage.BackendNode && nodeID == "" {
+			writeProblem(w, r, ProblemInvalidState, "deployment has not been placed on a node yet")
+			return
+		}
+
+		b := &DeploymentBucket{
+			DeploymentID:    refID,
+			OwnerID:         int64(authCtx.UserID),
+			Name:            req.Name,
+			Backend:         string(backend),
+			NodeID:          nodeID,
+			VariablePrefix:  req.VariablePrefix,
+			RetentionPolicy: req.RetentionPolicy,
+			RetentionDays:   req.RetentionDays,
+		}
+		if err := cfg.Store.CreateDeploymentBucket(ctx, b, cfg.Buckets.BucketPrefix()); err != nil {
+			if errors.Is(err, ErrBucketNameInUse) || errors.Is(err, ErrBucketPrefixInUse) {
+				writeProblem(w, r, ProblemAlreadyExists, err.Error())
+				return
+			}
+			writeProblem(w, r, ProblemInternal, "failed to create bucket")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": deploymentBucketJSONAPI(b)})
+	}
+}
+
+// =============================================================================
+// Bucket Manager Worker
+// =============================================================================
+
+// BucketManagerConfig configures the BucketManager.
+type BucketManagerConfig struct {
+	// Providers are the configured backends.
+	Providers map[storage.Backend]shellstorage.Provider
+	// DefaultBackend is used when a request names none.
+	DefaultBackend storage.Backend
+	// BucketPrefix starts every provisioned bucket name.
+	BucketPrefix string
+	// Interval is how often pending, released and expired buckets are processed.
+	Interval time.Duration
+	// UsageInterval is how often each bucket's stored data is metered.
+	UsageInterval time.Duration
+}
+
+// BucketManager provisions pending buckets, meters stored data as
+// storage.usage billing events, and releases and purges the buckets of
+// deleted deployments according to their retention policy.
+type BucketManager struct {
+	store  *Store
+	cfg    BucketManagerConfig
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewBucketManager(store *Store, cfg BucketManagerConfig, logger *slog.Logger) *BucketManager {
+	if cfg.Interval == 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.UsageInterval == 0 {
+		cfg.UsageInterval = time.Hour
+	}
+	if cfg.DefaultBackend == "" {
+		cfg.DefaultBackend = storage.BackendNode
+		if _, ok := cfg.Providers[storage.BackendNode]; !ok {
+			cfg.DefaultBackend = storage.BackendS3
+		}
+	}
+	return &BucketManager{
+		store:  store,
+		cfg:    cfg,
+		logger: logger.With("component", "bucket_manager"),
+	}
+}
+
+// Available reports whether a backend is configured.
+func (m *BucketManager) Available(backend storage.Backend) bool {
+	_, ok := m.cfg.Providers[backend]
+	return ok
+}
+
+// DefaultBackend returns the backend used when a request names none.
+func (m *BucketManager) DefaultBackend() storage.Backend {
+	return m.cfg.DefaultBackend
+}
+
+// BucketPrefix returns the prefix of provisioned bucket names.
+func (m *BucketManager) BucketPrefix() string {
+	return m.cfg.BucketPrefix
+}
+
+func (m *BucketManager) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("bucket manager started", "interval", m.cfg.Interval, "usage_interval", m.cfg.UsageInterval,
+		"default_backend", m.cfg.DefaultBackend)
+}
+
+func (m *BucketManager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *BucketManager) run() {
+	defer m.wg.Done()
+	m.runOnce(m.ctx, time.Now())
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.runOnce(m.ctx, now)
+		}
+	}
+}
+
+// runOnce provisions, releases, purges and meters buckets as of now.
+func (m *BucketManager) runOnce(ctx context.Context, now time.Time) {
+	m.forEach(ctx, "provision", m.provision, storage.StatusPending)
+	m.forEach(ctx, "release", func(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider) error {
+		return m.release(ctx, b, p, now)
+	}, storage.StatusReleasing)
+	m.forEach(ctx, "purge", func(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider) error {
+		if !b.PurgeAfter.Valid || b.PurgeAfter.String > now.UTC().Format(time.RFC3339) {
+			return nil
+		}
+		return m.purge(ctx, b, p)
+	}, storage.StatusRetained)
+	m.forEach(ctx, "meter", func(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider) error {
+		return m.meter(ctx, b, p, now)
+	}, storage.StatusActive, storage.StatusRetained)
+}
+
+// forEach runs fn for every bucket in the given states, recording failures
+// on the bucket so they are visible to its owner.
+func (m *BucketManager) forEach(ctx context.Context, op string, fn func(context.Context, *DeploymentBucket, shellstorage.Provider) error, statuses ...storage.Status) {
+	buckets, err := m.store.ListDeploymentBucketsByStatus(ctx, statuses...)
+	if err != nil {
+		m.logger.Error("failed to list buckets", "op", op, "error", err)
+		return
+	}
+	for _, b := range buckets {
+		if ctx.Err() != nil {
+			return
+		}
+		provider, ok := m.cfg.Providers[storage.Backend(b.Backend)]
+		if !ok {
+			err = fmt.Errorf("storage backend %q is not configured", b.Backend)
+		} else {
+			err = fn(ctx, b, provider)
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		m.logger.Error("bucket "+op+" failed", "bucket", b.ReferenceID, "deployment", b.DeploymentID, "error", err)
+		if op == "provision" {
+			b.Status = string(storage.StatusFailed)
+		}
+		b.ErrorMessage = err.Error()
+		if err := m.store.SaveDeploymentBucket(ctx, b); err != nil {
+			m.logger.Error("failed to record bucket error", "bucket", b.ReferenceID, "error", err)
+		}
+	}
+}
+
+func (m *BucketManager) provision(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider) error {
+	creds, err := p.Provision(ctx, providerBucket(b))
+	if err != nil {
+		return err
+	}
+	if err := m.store.ActivateDeploymentBucket(ctx, b, *creds); err != nil {
+		return err
+	}
+	m.logger.Info("bucket provisioned", "bucket", b.ReferenceID, "deployment", b.DeploymentID, "backend", b.Backend)
+	return nil
+}
+
+// release stops serving a deleted deployment's bucket and schedules its purge.
+func (m *BucketManager) release(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider, now time.Time) error {
+	creds, err := m.store.BucketCredentials(b)
+	if err != nil {
+		return err
+	}
+	if err := p.Release(ctx, providerBucket(b), creds); err != nil {
+		return err
+	}
+
+	releasedAt := now
+	if t, ok := parseTime(b.ReleasedAt.String); ok {
+		releasedAt = t
+	}
+	b.Status = string(storage.StatusRetained)
+	b.ErrorMessage = ""
+	b.PurgeAfter = sql.NullString{}
+	if at, ok := storage.PurgeAfter(storage.RetentionPolicy(b.RetentionPolicy), b.RetentionDays, releasedAt); ok {
+		b.PurgeAfter = sql.NullString{String: at.UTC().Format(time.RFC3339), Valid: true}
+	}
+	if err := m.store.SaveDeploymentBucket(ctx, b); err != nil {
+		return err
+	}
+	m.logger.Info("bucket released", "bucket", b.ReferenceID, "deployment", b.DeploymentID, "purge_after", b.PurgeAfter.String)
+
+	if b.PurgeAfter.Valid && b.PurgeAfter.String <= now.UTC().Format(time.RFC3339) {
+		return m.purge(ctx, b, p)
+	}
+	return nil
+}
+
+// purge deletes a released bucket's data and credentials.
+func (m *BucketManager) purge(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider) error {
+	creds, err := m.store.BucketCredentials(b)
+	if err != nil {
+		return err
+	}
+	if err := p.Delete(ctx, providerBucket(b), creds); err != nil {
+		return err
+	}
+	b.Status = string(storage.StatusDeleted)
+	b.Credentials = ""
+	b.ErrorMessage = ""
+	if err := m.store.SaveDeploymentBucket(ctx, b); err != nil {
+		return err
+	}
+	m.logger.Info("bucket deleted", "bucket", b.ReferenceID, "deployment", b.DeploymentID)
+	return nil
+}
+
+// meter measures a bucket once per usage interval and records the stored
+// megabytes as a billing event for its owner.
+func (m *BucketManager) meter(ctx context.Context, b *DeploymentBucket, p shellstorage.Provider, now time.Time) error {
+	if t, ok := parseTime(b.MeasuredAt.String); ok && now.Sub(t) < m.cfg.UsageInterval {
+		return nil
+	}
+	creds, err := m.store.BucketCredentials(b)
+	if err != nil {
+		return err
+	}
+	usage, err := p.Usage(ctx, providerBucket(b), creds)
+	if err != nil {
+		return fmt.Errorf("measure usage: %w", err)
+	}
+	b.SizeBytes, b.ObjectCount = usage.Bytes, usage.Objects
+	b.MeasuredAt = sql.NullString{String: now.UTC().Format(time.RFC3339), Valid: true}
+	b.ErrorMessage = ""
+	if err := m.store.SaveDeploymentBucket(ctx, b); err != nil {
+		return err
+	}
+
+	if err := billing.RecordMeteredEvent(ctx, m.store, int(b.OwnerID), domain.EventStorageUsage, b.ReferenceID, "bucket",
+		storage.UsageMB(usage.Bytes), map[string]string{
+			"deployment_id": b.DeploymentID,
+			"bucket":        b.Name,
+			"backend":       b.Backend,
+			"bytes":         strconv.FormatInt(usage.Bytes, 10),
+		}); err != nil {
+		m.logger.Warn("failed to record storage usage", "bucket", b.ReferenceID, "error", err)
+	}
+	return nil
+}
+
+// providerBucket describes a bucket row to a storage provider.
+func providerBucket(b *DeploymentBucket) shellstorage.Bucket {
+	return shellstorage.Bucket{
+		ReferenceID:  b.ReferenceID,
+		Name:         b.Name,
+		BucketName:   b.BucketName,
+		DeploymentID: b.DeploymentID,
+		NodeID:       b.NodeID,
+	}
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/proxy"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Traffic Meter
+// =============================================================================
+
+// TrafficMeter counts the embedded proxy's responses for deployments with a
+// canary, split between the stable and canary containers. Canary checks take
+// the counts and add them to the deployment's canary_stats, so a restart
+// loses at most one check interval of traffic.
+type TrafficMeter struct {
+	mu    sync.Mutex
+	stats map[string]*coredeployment.CanaryStats
+}
+
+func NewTrafficMeter() *TrafficMeter {
+	return &TrafficMeter{stats: make(map[string]*coredeployment.CanaryStats)}
+}
+
+// Record counts a response with the given status for a deployment.
+func (m *TrafficMeter) Record(deploymentID string, canary bool, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[deploymentID]
+	if !ok {
+		s = &coredeployment.CanaryStats{}
+		m.stats[deploymentID] = s
+	}
+	if canary {
+		s.Canary.Record(status)
+	} else {
+		s.Stable.Record(status)
+	}
+}
+
+// Take returns the counts recorded for a deployment since the last Take and
+// resets them.
+func (m *TrafficMeter) Take(deploymentID string) coredeployment.CanaryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[deploymentID]
+	if !ok {
+		return coredeployment.CanaryStats{}
+	}
+	delete(m.stats, deploymentID)
+	return *s
+}
+
+func getTrafficMeter(deps *Deps) *TrafficMeter {
+	if m, ok := deps.Extra["traffic_meter"].(*TrafficMeter); ok {
+		return m
+	}
+	return nil
+}
+
+// =============================================================================
+// Canary Upgrades
+// =============================================================================
+
+// canaryHealthTimeout bounds how long a new canary may take to become
+// healthy before the upgrade is aborted.
+const canaryHealthTimeout = 2 * time.Minute
+
+// startCanary starts the new version of the deployment's routed service on
+// its own proxy port and routes policy.Percent of requests to it. The stable
+// containers keep serving the rest until checkCanary promotes or aborts it.
+func startCanary(ctx context.Context, deps *Deps, u *preparedUpgrade, policy coredeployment.CanaryPolicy, meter *TrafficMeter) error {
+	store := deps.Store
+	refID := u.depl.ReferenceID
+
+	used, err := getUsedProxyPorts(ctx, store, u.depl.NodeID)
+	if err != nil {
+		return failUpgrade(ctx, store, refID, fmt.Sprintf("canary failed: load proxy ports: %v", err))
+	}
+	port, err := proxy.AllocatePort(used, proxy.DefaultPortRange())
+	if err != nil {
+		return failUpgrade(ctx, store, refID, fmt.Sprintf("canary failed: %v", err))
+	}
+	// Reserve the port before the container binds it
+	store.Update(ctx, "deployments", refID, map[string]any{"canary_port": port})
+
+	info, err := u.orchestrator.StartCanary(ctx, u.depl, u.composeSpec, templateConfigFiles(u.tmpl), port)
+	if err != nil {
+		return abortCanary(ctx, deps, u.orchestrator, u.depl, fmt.Sprintf("canary failed: %v", err))
+	}
+	canary := *u.depl
+	canary.Containers = []domain.ContainerInfo{info}
+	if err := u.orchestrator.WaitForHealthy(ctx, &canary, canaryHealthTimeout); err != nil {
+		return abortCanary(ctx, deps, u.orchestrator, u.depl, fmt.Sprintf("canary failed: %v", err))
+	}
+
+	meter.Take(refID)
+	store.Update(ctx, "deployments", refID, map[string]any{
+		"upgrade_status":    string(coredeployment.UpgradeStatusCanary),
+		"canary_percent":    policy.Percent,
+		"canary_started_at": time.Now().UTC().Format(time.RFC3339),
+		"canary_stats":      nil,
+		"error_message":     "",
+	})
+
+	deps.Logger.Info("canary started", "deployment", refID, "version", u.version,
+		"percent", policy.Percent, "port", port, "duration", policy.ObservationPeriod())
+	return nil
+}
+
+// checkCanary adds the traffic recorded since the last check to the
+// deployment's canary_stats and promotes, aborts or keeps the canary.
+func checkCanary(ctx context.Context, deps *Deps, data map[string]any) error {
+	store := deps.Store
+	refID := strVal(data["reference_id"])
+
+	var stats coredeployment.CanaryStats
+	if err := decodeJSONValue(data["canary_stats"], &stats); err != nil {
+		deps.Logger.Warn("invalid canary_stats, starting over", "deployment", refID, "error", err)
+	}
+	if meter := getTrafficMeter(deps); meter != nil {
+		stats = stats.Add(meter.Take(refID))
+	}
+	statsJSON, _ := json.Marshal(stats)
+
+	if status := strVal(data["status"]); status != "running" {
+		return abortCanary(ctx, deps, canaryOrchestrator(ctx, deps, data), mapToDeployment(data),
+			fmt.Sprintf("canary aborted: deployment is %s", status))
+	}
+
+	_, policy, _ := deploymentUpgradeStrategy(data)
+	startedAt, _ := parseTime(data["canary_started_at"])
+	decision := coredeployment.EvaluateCanary(policy, stats, startedAt, time.Now())
+
+	switch decision.Action {
+	case coredeployment.CanaryAbort:
+		store.Update(ctx, "deployments", refID, map[string]any{"canary_stats": string(statsJSON)})
+		return abortCanary(ctx, deps, canaryOrchestrator(ctx, deps, data), mapToDeployment(data),
+			"canary aborted: "+decision.Reason)
+	case coredeployment.CanaryPromote:
+		deps.Logger.Info("promoting canary", "deployment", refID, "reason", decision.Reason)
+		store.Update(ctx, "deployments", refID, map[string]any{
+			"canary_stats":   string(statsJSON),
+			"canary_percent": 100,
+		})
+		return promoteCanary(ctx, deps, data)
+	default:
+		store.Update(ctx, "deployments", refID, map[string]any{"canary_stats": string(statsJSON)})
+		return nil
+	}
+}
+
+// promoteCanary recreates the deployment's containers from the new version.
+// The canary serves all requests meanwhile and is removed afterwards.
+func promoteCanary(ctx context.Context, deps *Deps, data map[string]any) error {
+	refID := strVal(data["reference_id"])
+
+	u, err := prepareUpgrade(ctx, deps, data)
+	if err != nil {
+		return abortCanary(ctx, deps, canaryOrchestrator(ctx, deps, data), mapToDeployment(data),
+			fmt.Sprintf("canary promotion failed: %v", err))
+	}
+
+	err = recreateDeployment(ctx, deps, u)
+	clearCanary(ctx, deps, u.orchestrator, u.depl, map[string]any{})
+	if err != nil {
+		return err
+	}
+	deps.Logger.Info("canary promoted", "deployment", refID, "version", u.version)
+	return nil
+}
+
+// abortCanaryCommand aborts a deployment's canary on request.
+func abortCanaryCommand(ctx context.Context, deps *Deps, data map[string]any) error {
+	return abortCanary(ctx, deps, canaryOrchestrator(ctx, deps, data), mapToDeployment(data), "canary aborted on request")
+}
+
+// abortCanary removes the canary and marks the upgrade failed. The stable
+// containers were never touched, so the deployment keeps running.
+func abortCanary(ctx context.Context, deps *Deps, orchestrator *docker.Orchestrator, depl *domain.Deployment, reason string) error {
+	deps.Logger.Warn("aborting canary", "deployment", depl.ReferenceID, "reason", reason)
+	clearCanary(ctx, deps, orchestrator, depl, map[string]any{
+		"upgrade_status": string(coredeployment.UpgradeStatusFailed),
+		"error_message":  reason,
+	})
+	return fmt.Errorf("%s: %s", depl.ReferenceID, reason)
+}
+
+// clearCanary removes the deployment's canary container, when an
+// orchestrator is available, and stops routing to it.
+func clearCanary(ctx context.Context, deps *Deps, orchestrator *docker.Orchestrator, depl *domain.Deployment, updates map[string]any) {
+	if orchestrator != nil {
+		if err := orchestrator.RemoveCanary(ctx, depl); err != nil {
+			deps.Logger.Warn("failed to remove canary", "deployment", depl.ReferenceID, "error", err)
+		}
+	}
+	updates["canary_port"] = nil
+	updates["canary_percent"] = 0
+	updates["canary_started_at"] = nil
+	deps.Store.Update(ctx, "deployments", depl.ReferenceID, updates)
+}
+
+// canaryOrchestrator returns an orchestrator for the deployment's node, or
+// nil when the node is unreachable.
+func canaryOrchestrator(ctx context.Context, deps *Deps, data map[string]any) *docker.Orchestrator {
+	nodePool := getNodePool(deps)
+	if nodePool == nil {
+		return nil
+	}
+	client, err := nodePool.GetClient(ctx, strVal(data["node_id"]))
+	if err != nil {
+		deps.Logger.Warn("failed to get docker client for canary", "node_id", strVal(data["node_id"]), "error", err)
+		return nil
+	}
+	configDir, _ := deps.Extra["config_dir"].(string)
+	return docker.NewOrchestrator(client, deps.Logger, configDir, deps.Store)
+}
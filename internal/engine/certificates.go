@@ -0,0 +1,682 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	coreacme "github.com/artpar/hoster/internal/core/acme"
+	"github.com/artpar/hoster/internal/core/crypto"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/traefik"
+	"github.com/artpar/hoster/internal/shell/acme"
+	"github.com/artpar/hoster/internal/shell/dns"
+)
+
+// =============================================================================
+// Certificate Configuration
+// =============================================================================
+//
+// With ACME enabled, hoster issues and renews the certificates of verified
+// custom domains itself instead of leaving them to Traefik's resolver. A
+// domain proves control with HTTP-01 (the token is served by the API server
+// and the App Proxy, and Traefik routes challenge paths to hoster) or DNS-01
+// (the owner CNAMEs _acme-challenge.<domain> into hoster's DNS zone once).
+// Certificates and keys are kept in domain_certificates, keys encrypted with
+// the nodes encryption key, and are handed to Traefik in the nodes' dynamic
+// configuration files and to the App Proxy's TLS listener.
+
+// CertificateConfig configures certificate issuance.
+type CertificateConfig struct {
+	// DirectoryURL is the ACME directory (default Let's Encrypt production).
+	DirectoryURL string
+	// Email is the account's contact address for expiry notices.
+	Email string
+	// RenewBefore is how long before expiry certificates are renewed.
+	RenewBefore time.Duration
+	// DNS publishes DNS-01 challenge records; nil disables DNS-01.
+	DNS dns.TXTProvider
+	// MaxPerRun caps the certificates requested per run, so a burst of new
+	// domains doesn't run into the CA's rate limits.
+	MaxPerRun int
+}
+
+// certificateCacheTTL is how long the App Proxy keeps a loaded certificate
+// before reading it again, picking up renewals.
+const certificateCacheTTL = 5 * time.Minute
+
+// =============================================================================
+// Certificate Storage
+// =============================================================================
+
+// DomainCertificate is the certificate state of a custom domain.
+type DomainCertificate struct {
+	Hostname      string         `db:"hostname"`
+	DeploymentID  string         `db:"deployment_id"`
+	Challenge     string         `db:"challenge"`
+	Status        string         `db:"status"` // coreacme.Status*
+	ChainPEM      string         `db:"cert_pem"`
+	KeyEncrypted  string         `db:"key_encrypted"`
+	NotBefore     sql.NullString `db:"not_before"`
+	NotAfter      sql.NullString `db:"not_after"`
+	Attempts      int            `db:"attempts"` // Consecutive failures
+	LastError     string         `db:"last_error"`
+	NextAttemptAt sql.NullString `db:"next_attempt_at"`
+	IssuedAt      sql.NullString `db:"issued_at"`
+	CreatedAt     string         `db:"created_at"`
+	UpdatedAt     string         `db:"updated_at"`
+}
+
+const domainCertificateColumns = `hostname, deployment_id, challenge, status, cert_pem, key_encrypted,
+	not_before, not_after, attempts, last_error, next_attempt_at, issued_at, created_at, updated_at`
+
+// validity returns the certificate's validity period, zero before issuance.
+func (c *DomainCertificate) validity() (notBefore, notAfter time.Time) {
+	notBefore, _ = time.Parse(time.RFC3339, c.NotBefore.String)
+	notAfter, _ = time.Parse(time.RFC3339, c.NotAfter.String)
+	return notBefore, notAfter
+}
+
+// Usable reports whether the domain has a certificate valid at now.
+func (c *DomainCertificate) Usable(now time.Time) bool {
+	if c == nil || c.ChainPEM == "" {
+		return false
+	}
+	notBefore, notAfter := c.validity()
+	return !now.Before(notBefore) && now.Before(notAfter)
+}
+
+// GetDomainCertificate returns a hostname's certificate state, or
+// ErrNotFound.
+func (s *Store) GetDomainCertificate(ctx context.Context, hostname string) (*DomainCertificate, error) {
+	var c DomainCertificate
+	err := s.db.GetContext(ctx, &c,
+		`SELECT `+domainCertificateColumns+` FROM domain_certificates WHERE hostname = ?`, domain.NormalizeHostname(hostname))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("certificate %s: %w", hostname, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get domain certificate: %w", err)
+	}
+	return &c, nil
+}
+
+// ListDomainCertificates returns the certificate states of the hostnames,
+// or of every domain when none are given.
+func (s *Store) ListDomainCertificates(ctx context.Context, hostnames ...string) ([]*DomainCertificate, error) {
+	query := `SELECT ` + domainCertificateColumns + ` FROM domain_certificates`
+	var args []any
+	if len(hostnames) > 0 {
+		query += ` WHERE hostname IN (?` + strings.Repeat(", ?", len(hostnames)-1) + `)`
+		for _, h := range hostnames {
+			args = append(args, domain.NormalizeHostname(h))
+		}
+	}
+	var out []*DomainCertificate
+	if err := s.db.SelectContext(ctx, &out, query+` ORDER BY hostname`, args...); err != nil {
+		return nil, fmt.Errorf("list domain certificates: %w", err)
+	}
+	return out, nil
+}
+
+// SaveDomainCertificate creates or replaces a hostname's certificate state.
+func (s *Store) SaveDomainCertificate(ctx context.Context, c *DomainCertificate) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	c.Hostname = domain.NormalizeHostname(c.Hostname)
+	if c.CreatedAt == "" {
+		c.CreatedAt = now
+	}
+	c.UpdatedAt = now
+	_, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO domain_certificates (`+domainCertificateColumns+`)
+		VALUES (:hostname, :deployment_id, :challenge, :status, :cert_pem, :key_encrypted,
+			:not_before, :not_after, :attempts, :last_error, :next_attempt_at, :issued_at, :created_at, :updated_at)
+		ON CONFLICT(hostname) DO UPDATE SET deployment_id = excluded.deployment_id,
+			challenge = excluded.challenge, status = excluded.status, cert_pem = excluded.cert_pem,
+			key_encrypted = excluded.key_encrypted, not_before = excluded.not_before, not_after = excluded.not_after,
+			attempts = excluded.attempts, last_error = excluded.last_error,
+			next_attempt_at = excluded.next_attempt_at, issued_at = excluded.issued_at, updated_at = excluded.updated_at`, c)
+	if err != nil {
+		return fmt.Errorf("save domain certificate: %w", err)
+	}
+	return nil
+}
+
+// DeleteDomainCertificate forgets a hostname's certificate.
+func (s *Store) DeleteDomainCertificate(ctx context.Context, hostname string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM domain_certificates WHERE hostname = ?`, domain.NormalizeHostname(hostname)); err != nil {
+		return fmt.Errorf("delete domain certificate: %w", err)
+	}
+	return nil
+}
+
+// pruneDomainCertificates forgets the certificates of hostnames no
+// deployment claims any more.
+func (s *Store) pruneDomainCertificates(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM domain_certificates WHERE hostname NOT IN (SELECT hostname FROM deployment_domains)`)
+	if err != nil {
+		return 0, fmt.Errorf("prune domain certificates: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// certificateKeyPEM decrypts a certificate's private key.
+func (s *Store) certificateKeyPEM(c *DomainCertificate) ([]byte, error) {
+	key, err := crypto.DecryptFromBase64(c.KeyEncrypted, s.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt certificate key for %s: %w", c.Hostname, err)
+	}
+	return key, nil
+}
+
+// =============================================================================
+// HTTP-01 Challenges
+// =============================================================================
+
+// putACMEChallenge records the response to an HTTP-01 challenge token.
+func (s *Store) putACMEChallenge(ctx context.Context, hostname, token, keyAuthorization string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO acme_challenges (token, hostname, key_authorization, created_at) VALUES (?, ?, ?, ?)`,
+		token, domain.NormalizeHostname(hostname), keyAuthorization, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("put acme challenge: %w", err)
+	}
+	return nil
+}
+
+// deleteACMEChallenge removes an answered HTTP-01 challenge.
+func (s *Store) deleteACMEChallenge(ctx context.Context, token string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM acme_challenges WHERE token = ?`, token); err != nil {
+		return fmt.Errorf("delete acme challenge: %w", err)
+	}
+	return nil
+}
+
+// acmeChallengeResponse returns the key authorization for a token requested
+// on hostname.
+func (s *Store) acmeChallengeResponse(ctx context.Context, hostname, token string) (string, error) {
+	var keyAuth string
+	err := s.db.GetContext(ctx, &keyAuth,
+		`SELECT key_authorization FROM acme_challenges WHERE token = ? AND hostname = ?`,
+		token, domain.NormalizeHostname(hostname))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return keyAuth, err
+}
+
+// NewACMEChallengeHandler serves the responses to pending HTTP-01
+// challenges at /.well-known/acme-challenge/<token>. Unknown tokens are
+// 404s.
+func NewACMEChallengeHandler(store *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := coreacme.TokenFromPath(r.URL.Path)
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			http.NotFound(w, r)
+			return
+		}
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		keyAuth, err := store.acmeChallengeResponse(r.Context(), host, token)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+// httpChallengeSolver publishes HTTP-01 responses in the store, where every
+// replica's challenge handler finds them.
+type httpChallengeSolver struct {
+	store *Store
+}
+
+func (s httpChallengeSolver) Present(ctx context.Context, ch acme.Challenge) error {
+	return s.store.putACMEChallenge(ctx, ch.Hostname, ch.Token, ch.KeyAuthorization)
+}
+
+func (s httpChallengeSolver) CleanUp(ctx context.Context, ch acme.Challenge) error {
+	return s.store.deleteACMEChallenge(ctx, ch.Token)
+}
+
+// dnsChallengeSolver publishes DNS-01 records at the domain's delegation
+// target in hoster's zone.
+type dnsChallengeSolver struct {
+	provider dns.TXTProvider
+}
+
+func (s dnsChallengeSolver) Present(ctx context.Context, ch acme.Challenge) error {
+	return s.provider.SetTXT(ctx, coreacme.DelegationTarget(ch.Hostname, s.provider.Zone()), ch.DNSValue)
+}
+
+func (s dnsChallengeSolver) CleanUp(ctx context.Context, ch acme.Challenge) error {
+	return s.provider.DeleteTXT(ctx, coreacme.DelegationTarget(ch.Hostname, s.provider.Zone()), ch.DNSValue)
+}
+
+// =============================================================================
+// Certificate Manager Worker
+// =============================================================================
+
+// CertificateManager issues certificates for the verified custom domains of
+// running deployments and renews them ahead of expiry. Failed requests are
+// retried with backoff; a failed renewal keeps the current certificate. It
+// also serves stored certificates to the App Proxy's TLS listener.
+type CertificateManager struct {
+	store    *Store
+	cfg      CertificateConfig
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	// issuer is set once the ACME account is registered
+	issuer *acme.Issuer
+
+	mu    sync.Mutex
+	cache map[string]cachedCertificate
+}
+
+type cachedCertificate struct {
+	cert     *tls.Certificate
+	loadedAt time.Time
+}
+
+func NewCertificateManager(store *Store, cfg CertificateConfig, interval time.Duration, logger *slog.Logger) *CertificateManager {
+	if interval == 0 {
+		interval = 10 * time.Minute
+	}
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+	if cfg.RenewBefore == 0 {
+		cfg.RenewBefore = coreacme.DefaultRenewBefore
+	}
+	if cfg.MaxPerRun == 0 {
+		cfg.MaxPerRun = 10
+	}
+	return &CertificateManager{
+		store:    store,
+		cfg:      cfg,
+		interval: interval,
+		logger:   logger.With("component", "certificate_manager"),
+		cache:    make(map[string]cachedCertificate),
+	}
+}
+
+// SupportsChallenge reports whether domains can prove control with c.
+func (m *CertificateManager) SupportsChallenge(c coreacme.Challenge) bool {
+	return c != coreacme.ChallengeDNS01 || m.cfg.DNS != nil
+}
+
+// DelegationTarget returns where a DNS-01 domain's _acme-challenge name must
+// point, or "" without DNS-01.
+func (m *CertificateManager) DelegationTarget(hostname string) string {
+	if m.cfg.DNS == nil {
+		return ""
+	}
+	return coreacme.DelegationTarget(hostname, m.cfg.DNS.Zone())
+}
+
+func (m *CertificateManager) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("certificate manager started", "interval", m.interval, "directory", m.cfg.DirectoryURL)
+}
+
+func (m *CertificateManager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *CertificateManager) run() {
+	defer m.wg.Done()
+	m.renewAll()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.renewAll()
+		}
+	}
+}
+
+// register loads the ACME account key, creating it on first use, and
+// registers the account with the CA.
+func (m *CertificateManager) register() error {
+	key, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	issuer := acme.NewIssuer(m.cfg.DirectoryURL, key, m.cfg.Email)
+	if err := issuer.Register(m.ctx); err != nil {
+		return err
+	}
+	m.issuer = issuer
+	return nil
+}
+
+// accountKey returns the account key for the directory, stored encrypted in
+// acme_accounts.
+func (m *CertificateManager) accountKey() (*ecdsa.PrivateKey, error) {
+	var sealed string
+	err := m.store.db.GetContext(m.ctx, &sealed,
+		`SELECT key_encrypted FROM acme_accounts WHERE directory_url = ?`, m.cfg.DirectoryURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		key, err := acme.GenerateAccountKey()
+		if err != nil {
+			return nil, fmt.Errorf("generate acme account key: %w", err)
+		}
+		keyPEM, err := acme.EncodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if sealed, err = crypto.EncryptToBase64(keyPEM, m.store.encryptionKey); err != nil {
+			return nil, fmt.Errorf("encrypt acme account key: %w", err)
+		}
+		if _, err := m.store.db.ExecContext(m.ctx,
+			`INSERT INTO acme_accounts (directory_url, key_encrypted, created_at) VALUES (?, ?, ?)`,
+			m.cfg.DirectoryURL, sealed, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return nil, fmt.Errorf("save acme account key: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load acme account key: %w", err)
+	}
+	keyPEM, err := crypto.DecryptFromBase64(sealed, m.store.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt acme account key: %w", err)
+	}
+	return acme.DecodeKey(keyPEM)
+}
+
+// renewAll requests the certificates that are due, forgets those of
+// released hostnames and brings deployments' SSL state up to date.
+func (m *CertificateManager) renewAll() {
+	defer m.store.LoopMetrics().Time("certificate_manager")()
+	if m.issuer == nil {
+		if err := m.register(); err != nil {
+			// The CA may be unreachable; retry next run
+			m.logger.Warn("failed to register acme account", "error", err)
+			return
+		}
+	}
+
+	if n, err := m.store.pruneDomainCertificates(m.ctx); err != nil {
+		m.logger.Error("failed to prune certificates", "error", err)
+	} else if n > 0 {
+		m.logger.Info("forgot certificates of released domains", "count", n)
+	}
+
+	deployments, err := m.store.List(m.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		m.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+	records, err := m.store.ListDomainCertificates(m.ctx)
+	if err != nil {
+		m.logger.Error("failed to list certificates", "error", err)
+		return
+	}
+	byHost := make(map[string]*DomainCertificate, len(records))
+	for _, c := range records {
+		byHost[c.Hostname] = c
+	}
+
+	requested := 0
+	for _, depl := range deployments {
+		deplRef := strVal(depl["reference_id"])
+		domains := parseDomainsList(depl["domains"])
+		for _, d := range domains {
+			if d.Type != "custom" || d.VerificationStatus != "verified" {
+				continue
+			}
+			c := byHost[d.Hostname]
+			if !m.due(c, d, time.Now()) {
+				continue
+			}
+			if requested >= m.cfg.MaxPerRun {
+				continue
+			}
+			requested++
+			byHost[d.Hostname] = m.request(deplRef, d, c)
+		}
+		m.syncDomains(deplRef, domains, byHost)
+	}
+}
+
+// due reports whether a domain's certificate should be requested now: it
+// has none, its renewal is due, or its challenge changed; unless the last
+// failure is still backing off.
+func (m *CertificateManager) due(c *DomainCertificate, d DomainInfo, now time.Time) bool {
+	if c == nil {
+		return true
+	}
+	if c.NextAttemptAt.Valid {
+		if next, err := time.Parse(time.RFC3339, c.NextAttemptAt.String); err == nil && now.Before(next) {
+			return false
+		}
+	}
+	if c.Status != coreacme.StatusIssued || !c.Usable(now) {
+		return true
+	}
+	if challenge, _ := coreacme.ParseChallenge(d.Challenge); string(challenge) != c.Challenge {
+		return true
+	}
+	notBefore, notAfter := c.validity()
+	return coreacme.NeedsRenewal(notBefore, notAfter, now, m.cfg.RenewBefore)
+}
+
+// request asks the CA for a domain's certificate and records the outcome.
+func (m *CertificateManager) request(deploymentRef string, d DomainInfo, prev *DomainCertificate) *DomainCertificate {
+	c := &DomainCertificate{Hostname: d.Hostname, Status: coreacme.StatusPending}
+	if prev != nil {
+		copied := *prev
+		c = &copied
+	}
+	c.DeploymentID = deploymentRef
+
+	challenge, err := coreacme.ParseChallenge(d.Challenge)
+	if err == nil && !m.SupportsChallenge(challenge) {
+		err = fmt.Errorf("%s challenges need acme.dns_provider", challenge)
+	}
+	c.Challenge = string(challenge)
+	if err == nil {
+		err = m.issue(c, challenge)
+	}
+
+	now := time.Now().UTC()
+	if err != nil {
+		c.Attempts++
+		c.LastError = err.Error()
+		c.NextAttemptAt = sql.NullString{String: now.Add(coreacme.RetryAfter(c.Attempts)).Format(time.RFC3339), Valid: true}
+		if !c.Usable(now) {
+			c.Status = coreacme.StatusFailed
+		}
+		m.logger.Warn("certificate request failed", "hostname", c.Hostname, "challenge", challenge, "attempts", c.Attempts, "error", err)
+	} else {
+		c.Status = coreacme.StatusIssued
+		c.Attempts = 0
+		c.LastError = ""
+		c.NextAttemptAt = sql.NullString{}
+		c.IssuedAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+		m.logger.Info("certificate issued", "hostname", c.Hostname, "challenge", challenge, "expires", c.NotAfter.String)
+	}
+	if err := m.store.SaveDomainCertificate(m.ctx, c); err != nil {
+		m.logger.Error("failed to save certificate", "hostname", c.Hostname, "error", err)
+	}
+	return c
+}
+
+// issue obtains a certificate and stores it, with its key encrypted, in c.
+func (m *CertificateManager) issue(c *DomainCertificate, challenge coreacme.Challenge) error {
+	var solver acme.Solver = httpChallengeSolver{store: m.store}
+	if challenge == coreacme.ChallengeDNS01 {
+		solver = dnsChallengeSolver{provider: m.cfg.DNS}
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Minute)
+	defer cancel()
+
+	cert, err := m.issuer.Issue(ctx, c.Hostname, challenge, solver)
+	if err != nil {
+		return err
+	}
+	info, err := coreacme.ParseChain(cert.ChainPEM)
+	if err != nil {
+		return err
+	}
+	if !info.Covers(c.Hostname) {
+		return fmt.Errorf("issued certificate does not cover %s", c.Hostname)
+	}
+	sealed, err := crypto.EncryptToBase64(cert.KeyPEM, m.store.encryptionKey)
+	if err != nil {
+		return fmt.Errorf("encrypt certificate key: %w", err)
+	}
+	c.ChainPEM = string(cert.ChainPEM)
+	c.KeyEncrypted = sealed
+	c.NotBefore = sql.NullString{String: info.NotBefore.UTC().Format(time.RFC3339), Valid: true}
+	c.NotAfter = sql.NullString{String: info.NotAfter.UTC().Format(time.RFC3339), Valid: true}
+	return nil
+}
+
+// syncDomains records a deployment's custom domains' certificate state in
+// its domains: SSL is enabled while a usable certificate exists.
+func (m *CertificateManager) syncDomains(deploymentRef string, domains []DomainInfo, byHost map[string]*DomainCertificate) {
+	now := time.Now()
+	changed := false
+	for i, d := range domains {
+		if d.Type != "custom" {
+			continue
+		}
+		next := d
+		applyCertificateState(&next, byHost[d.Hostname], now)
+		if next.SSLEnabled != d.SSLEnabled || next.SSLExpiresAt != d.SSLExpiresAt ||
+			next.CertificateStatus != d.CertificateStatus || next.CertificateError != d.CertificateError {
+			domains[i] = next
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	domainsJSON, _ := json.Marshal(domains)
+	if _, err := m.store.Update(m.ctx, "deployments", deploymentRef, map[string]any{"domains": string(domainsJSON)}); err != nil {
+		m.logger.Error("failed to record certificate state", "deployment", deploymentRef, "error", err)
+	}
+}
+
+// applyCertificateState sets a custom domain's SSL fields from its
+// certificate; nil is a domain waiting for its first one.
+func applyCertificateState(d *DomainInfo, c *DomainCertificate, now time.Time) {
+	d.SSLEnabled = c.Usable(now)
+	d.SSLExpiresAt = ""
+	d.CertificateStatus = ""
+	d.CertificateError = ""
+	if d.VerificationStatus != "verified" {
+		return
+	}
+	if c == nil {
+		d.CertificateStatus = coreacme.StatusPending
+		return
+	}
+	d.CertificateStatus = c.Status
+	d.CertificateError = c.LastError
+	if d.SSLEnabled {
+		d.SSLExpiresAt = c.NotAfter.String
+	}
+}
+
+// =============================================================================
+// Serving Certificates
+// =============================================================================
+
+// NodeCertificates returns the usable certificates of the hostnames, for a
+// node's Traefik configuration.
+func (m *CertificateManager) NodeCertificates(ctx context.Context, hostnames []string) ([]traefik.Certificate, error) {
+	if len(hostnames) == 0 {
+		return nil, nil
+	}
+	records, err := m.store.ListDomainCertificates(ctx, hostnames...)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var out []traefik.Certificate
+	for _, c := range records {
+		if !c.Usable(now) {
+			continue
+		}
+		keyPEM, err := m.store.certificateKeyPEM(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, traefik.Certificate{Hostname: c.Hostname, ChainPEM: c.ChainPEM, KeyPEM: string(keyPEM)})
+	}
+	return out, nil
+}
+
+// GetCertificate returns the certificate for a TLS handshake's server name,
+// for tls.Config.GetCertificate.
+func (m *CertificateManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := domain.NormalizeHostname(hello.ServerName)
+	if host == "" {
+		return nil, errors.New("no server name")
+	}
+	now := time.Now()
+
+	m.mu.Lock()
+	cached, ok := m.cache[host]
+	m.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < certificateCacheTTL {
+		return cached.cert, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := m.store.GetDomainCertificate(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Usable(now) {
+		return nil, fmt.Errorf("no usable certificate for %s", host)
+	}
+	keyPEM, err := m.store.certificateKeyPEM(c)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(c.ChainPEM), keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate for %s: %w", host, err)
+	}
+
+	m.mu.Lock()
+	m.cache[host] = cachedCertificate{cert: &cert, loadedAt: now}
+	m.mu.Unlock()
+	return &cert, nil
+}
@@ -0,0 +1,257 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/checkpoint"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Checkpoint Migrations (experimental)
+// =============================================================================
+//
+// A checkpoint-mode migration moves a running deployment without losing its
+// containers' memory: each container is checkpointed with CRIU on the source
+// node (which stops it), the deployment is marked stopped, its volumes and
+// checkpoints are relayed to the target, and after the node switch the
+// deployment is started with restore_checkpoints set so its new containers
+// start from the checkpoints. A container whose restore fails starts fresh.
+// If the migration fails before the switch, the deployment is started again
+// on the source node.
+
+// EnableCheckpoints turns on checkpoint-mode migrations. Restored deployments
+// are started through bus.
+func (vm *VolumeMigrator) EnableCheckpoints(bus *Bus) {
+	vm.bus = bus
+}
+
+// checkpointDeployment checkpoints every container of a running deployment
+// on the source node and marks it stopped. Checkpointing a container twice
+// returns its existing stage, so a run interrupted midway is resumed by
+// running it again.
+func (vm *VolumeMigrator) checkpointDeployment(ctx context.Context, m *VolumeMigration, depl map[string]any, logger *slog.Logger) error {
+	if strVal(depl["status"]) != "running" {
+		if len(m.Checkpoints) > 0 {
+			return requireQuiescent(depl) // checkpointed by an earlier run
+		}
+		return fmt.Errorf("deployment is %s; checkpoint migrations need it running", strVal(depl["status"]))
+	}
+	if vm.bus == nil {
+		return errors.New("checkpoint migrations are not enabled")
+	}
+	if vm.nodePool == nil {
+		return errors.New("node pool not configured")
+	}
+	for role, nodeID := range map[string]string{"source": m.SourceNodeID, "target": m.TargetNodeID} {
+		support, err := vm.nodePool.CheckpointSupport(ctx, nodeID)
+		if err != nil {
+			return fmt.Errorf("%s node checkpoint support: %w", role, err)
+		}
+		if err := checkpoint.Require(role, *support); err != nil {
+			return err
+		}
+	}
+
+	var containers []domain.ContainerInfo
+	decodeJSONValue(depl["containers"], &containers)
+	plan, err := checkpoint.Plan(m.ReferenceID, containers)
+	if err != nil {
+		return err
+	}
+	src, err := vm.nodePool.CheckpointEndpoint(ctx, m.SourceNodeID)
+	if err != nil {
+		return fmt.Errorf("source node: %w", err)
+	}
+
+	// Dependents first, the reverse of start order, so no container is still
+	// talking to a dependency that is already frozen.
+	checkpoints := make([]MigrationCheckpoint, len(plan))
+	for i := len(plan) - 1; i >= 0; i-- {
+		c := plan[i]
+		snap, err := src.CheckpointContainer(ctx, c.TransferID, c.ContainerID)
+		if err != nil {
+			if ctx.Err() == nil {
+				vm.abortCheckpoints(context.WithoutCancel(ctx), m.SourceNodeID, src, checkpoints[i+1:], logger)
+			}
+			return fmt.Errorf("checkpoint %s: %w", c.Service, err)
+		}
+		logger.Info("container checkpointed", "service", c.Service, "bytes", snap.Size)
+		checkpoints[i] = MigrationCheckpoint{
+			Service:     c.Service,
+			ContainerID: c.ContainerID,
+			TransferID:  c.TransferID,
+			Size:        snap.Size,
+			SHA256:      snap.SHA256,
+		}
+	}
+	m.Checkpoints = checkpoints
+	updateTotals(m)
+	if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+		return err
+	}
+
+	// The containers have exited; record the deployment as stopped so the
+	// volume transfer sees it quiescent.
+	if _, _, err := vm.store.Transition(ctx, "deployments", m.DeploymentID, "stopping"); err != nil {
+		return fmt.Errorf("mark deployment stopping: %w", err)
+	}
+	vm.store.Update(ctx, "deployments", m.DeploymentID, map[string]any{
+		"stopped_at": time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, _, err := vm.store.Transition(ctx, "deployments", m.DeploymentID, "stopped"); err != nil {
+		return fmt.Errorf("mark deployment stopped: %w", err)
+	}
+	recordBillingEvent(ctx, vm.store, depl, domain.EventDeploymentStopped)
+	return nil
+}
+
+// abortCheckpoints starts containers that were checkpointed before another
+// container's checkpoint failed, leaving the deployment running on the
+// source node, and drops their stages.
+func (vm *VolumeMigrator) abortCheckpoints(ctx context.Context, nodeID string, src docker.CheckpointEndpoint, done []MigrationCheckpoint, logger *slog.Logger) {
+	client, err := vm.nodePool.GetClient(ctx, nodeID)
+	if err != nil {
+		logger.Error("cannot restart checkpointed containers", "error", err)
+		return
+	}
+	for _, c := range done {
+		if err := client.StartContainer(c.ContainerID); err != nil {
+			logger.Error("failed to restart checkpointed container", "service", c.Service, "error", err)
+		}
+		_ = src.DiscardCheckpoint(ctx, c.TransferID) // best effort
+	}
+}
+
+// transferCheckpoints relays every unverified checkpoint to the target node
+// and unpacks it there.
+func (vm *VolumeMigrator) transferCheckpoints(ctx context.Context, m *VolumeMigration, logger *slog.Logger) error {
+	src, err := vm.nodePool.CheckpointEndpoint(ctx, m.SourceNodeID)
+	if err != nil {
+		return fmt.Errorf("source node: %w", err)
+	}
+	dst, err := vm.nodePool.CheckpointEndpoint(ctx, m.TargetNodeID)
+	if err != nil {
+		return fmt.Errorf("target node: %w", err)
+	}
+
+	for i := range m.Checkpoints {
+		c := &m.Checkpoints[i]
+		if c.Verified {
+			continue
+		}
+		logger.Info("migrating checkpoint", "service", c.Service, "bytes", c.Size)
+
+		_, err := docker.TransferCheckpoint(ctx, src, dst, docker.VolumeTransfer{
+			TransferID: c.TransferID,
+			Source:     c.ContainerID,
+			Target:     docker.VolumeSpec{Name: "checkpoint of " + c.Service},
+			ChunkSize:  vm.chunkSize,
+		}, func(p docker.VolumeTransferProgress) {
+			c.Size, c.Transferred = p.TotalBytes, p.TransferredBytes
+			updateTotals(m)
+			if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+				logger.Warn("failed to save migration progress", "error", err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("checkpoint %s: %w", c.Service, err)
+		}
+
+		c.Verified = true
+		c.Transferred = c.Size
+		updateTotals(m)
+		if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreDeployment starts a switched deployment on the target node from its
+// checkpoints. Start failures are handled by StartDeployment itself.
+func (vm *VolumeMigrator) restoreDeployment(ctx context.Context, m *VolumeMigration, logger *slog.Logger) {
+	restores := make(map[string]string, len(m.Checkpoints))
+	for _, c := range m.Checkpoints {
+		restores[c.Service] = c.TransferID
+	}
+	if _, err := vm.store.Update(ctx, "deployments", m.DeploymentID, map[string]any{
+		"restore_checkpoints": restores,
+	}); err != nil {
+		logger.Error("failed to record checkpoints to restore", "error", err)
+	}
+
+	row, cmd, err := vm.store.Transition(ctx, "deployments", m.DeploymentID, "starting")
+	if err != nil {
+		logger.Error("failed to start migrated deployment", "error", err)
+		return
+	}
+	if cmd != "" && vm.bus != nil {
+		if err := vm.bus.Dispatch(ctx, cmd, row); err != nil {
+			logger.Error("migrated deployment failed to start", "error", err)
+		}
+	}
+}
+
+// recoverCheckpoints cleans up after a failed checkpoint migration. If the
+// deployment was checkpointed but is still on the source node, its
+// checkpoints are discarded and it is started again there, fresh.
+func (vm *VolumeMigrator) recoverCheckpoints(ctx context.Context, m *VolumeMigration, logger *slog.Logger) {
+	if len(m.Checkpoints) == 0 || vm.nodePool == nil {
+		return
+	}
+	for _, nodeID := range []string{m.SourceNodeID, m.TargetNodeID} {
+		endpoint, err := vm.nodePool.CheckpointEndpoint(ctx, nodeID)
+		if err != nil {
+			continue
+		}
+		for _, c := range m.Checkpoints {
+			_ = endpoint.DiscardCheckpoint(ctx, c.TransferID) // best effort
+		}
+	}
+
+	depl, err := vm.store.Get(ctx, "deployments", m.DeploymentID)
+	if err != nil || strVal(depl["node_id"]) != m.SourceNodeID || strVal(depl["status"]) != "stopped" {
+		return
+	}
+	logger.Info("restarting deployment on source node after failed checkpoint migration")
+	row, cmd, err := vm.store.Transition(ctx, "deployments", m.DeploymentID, "starting")
+	if err != nil {
+		logger.Error("failed to restart deployment on source node", "error", err)
+		return
+	}
+	if cmd != "" && vm.bus != nil {
+		if err := vm.bus.Dispatch(ctx, cmd, row); err != nil {
+			logger.Error("deployment failed to restart on source node", "error", err)
+		}
+	}
+}
+
+// takeRestoreCheckpoints returns the checkpoints a deployment's new
+// containers should start from (service -> transfer ID) and clears them, so
+// they are only tried once.
+func takeRestoreCheckpoints(ctx context.Context, store *Store, data map[string]any) map[string]string {
+	var restores map[string]string
+	if err := decodeJSONValue(data["restore_checkpoints"], &restores); err != nil || len(restores) == 0 {
+		return nil
+	}
+	store.Update(ctx, "deployments", strVal(data["reference_id"]), map[string]any{"restore_checkpoints": nil})
+	return restores
+}
+
+// discardRestoreCheckpoints removes checkpoints left unpacked on a node after
+// a start, e.g. those whose restore failed.
+func discardRestoreCheckpoints(ctx context.Context, client docker.Client, restores map[string]string) {
+	endpoint, ok := client.(docker.CheckpointEndpoint)
+	if !ok {
+		return
+	}
+	for _, transferID := range restores {
+		_ = endpoint.DiscardCheckpoint(ctx, transferID) // best effort
+	}
+}
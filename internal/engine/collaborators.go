@@ -0,0 +1,428 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/shell/mail"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Deployment Collaborators
+// =============================================================================
+
+// A deployment's owner shares it by inviting someone by email as a viewer or
+// operator. The invitation carries a random token of which only the hash is
+// stored; whoever accepts it with that token becomes the collaborator.
+// Collaborators act on the deployment only through the handlers that call
+// authorizeDeployment (monitoring for viewers, start/stop for operators);
+// everything else stays owner-only.
+
+// errUnparseableOwner is returned when a deployment's customer_id is unusable;
+// access checks fail closed on it.
+var errUnparseableOwner = errors.New("unparseable customer_id")
+
+// deploymentRole returns the requester's role on a deployment: owner, the role
+// of an accepted collaborator, or none.
+func deploymentRole(ctx context.Context, store *Store, depl map[string]any, authCtx AuthContext) (sharing.Role, error) {
+	ownerID, ok := toInt64(depl["customer_id"])
+	if !ok {
+		return sharing.RoleNone, errUnparseableOwner
+	}
+	if int(ownerID) == authCtx.UserID {
+		return sharing.RoleOwner, nil
+	}
+	rows, err := store.List(ctx, "deployment_collaborators", []Filter{
+		{Field: "deployment_id", Value: strVal(depl["reference_id"])},
+		{Field: "user_id", Value: authCtx.UserID},
+		{Field: "status", Value: sharing.StatusAccepted},
+	}, Page{Limit: 1})
+	if err != nil {
+		return sharing.RoleNone, err
+	}
+	if len(rows) == 0 {
+		return sharing.RoleNone, nil
+	}
+	return sharing.Role(strVal(rows[0]["role"])), nil
+}
+
+// authorizeDeployment checks that the requester may perform perm on depl,
+// writing the problem response when not.
+func authorizeDeployment(w http.ResponseWriter, r *http.Request, cfg SetupConfig, depl map[string]any, perm sharing.Permission) bool {
+	role, err := deploymentRole(r.Context(), cfg.Store, depl, getAuthContext(r))
+	if errors.Is(err, errUnparseableOwner) {
+		cfg.Logger.Warn("ownership check failed: unparseable customer_id",
+			"resource", "deployments", "value", depl["customer_id"])
+		writeProblem(w, r, ProblemForbidden, "access denied")
+		return false
+	}
+	if err != nil {
+		writeProblem(w, r, ProblemInternal, "failed to check deployment access")
+		return false
+	}
+	if !role.Can(perm) {
+		writeProblem(w, r, ProblemForbidden, "not authorized")
+		return false
+	}
+	return true
+}
+
+// DeleteDeploymentCollaborators revokes everyone's access to a deployment.
+func (s *Store) DeleteDeploymentCollaborators(ctx context.Context, deploymentRef string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM deployment_collaborators WHERE deployment_id = ?`, deploymentRef)
+	if err != nil {
+		return fmt.Errorf("delete deployment collaborators: %w", err)
+	}
+	return nil
+}
+
+// =============================================================================
+// Invitations
+// =============================================================================
+
+// newInvitationToken returns a fresh token and the fields that record it on a
+// collaborator row.
+func newInvitationToken(now time.Time) (string, map[string]any, error) {
+	random := make([]byte, sharing.TokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, fmt.Errorf("generate invitation token: %w", err)
+	}
+	token, err := sharing.NewToken(random)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, map[string]any{
+		"token_hash": sharing.HashToken(token),
+		"expires_at": now.Add(sharing.InvitationTTL).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// sendInvitation emails an invitation and records the outcome on its row. It
+// returns the meta for the response: with no mailer, or when delivery fails,
+// the owner gets the accept link and token to pass on themselves.
+func sendInvitation(ctx context.Context, cfg SetupConfig, collab, depl map[string]any, token string) map[string]any {
+	refID := strVal(collab["reference_id"])
+	expiresAt, _ := parseTime(collab["expires_at"])
+	acceptURL := sharing.AcceptURL(cfg.AppURL, token)
+
+	meta := map[string]any{"email_sent": false}
+	if cfg.Mailer != nil {
+		email := sharing.RenderInvitation(strVal(depl["name"]), sharing.Role(strVal(collab["role"])), acceptURL, token, expiresAt)
+		err := cfg.Mailer.Send(ctx, mail.Message{
+			To:      strVal(collab["email"]),
+			Subject: email.Subject,
+			Body:    email.Body,
+		})
+		updates := map[string]any{"email_error": nil}
+		if err == nil {
+			updates["email_sent_at"] = time.Now().UTC().Format(time.RFC3339)
+			meta["email_sent"] = true
+		} else {
+			cfg.Logger.Warn("invitation email failed", "collaborator", refID, "error", err)
+			updates["email_error"] = err.Error()
+		}
+		if row, uerr := cfg.Store.Update(ctx, "deployment_collaborators", refID, updates); uerr == nil {
+			for k, v := range row {
+				collab[k] = v
+			}
+		}
+		if err == nil {
+			return meta
+		}
+	}
+
+	meta["token"] = token
+	if acceptURL != "" {
+		meta["accept_url"] = acceptURL
+	}
+	return meta
+}
+
+// =============================================================================
+// Validation
+// =============================================================================
+
+// validateCollaboratorUpdate allows changing only a collaborator's role.
+func validateCollaboratorUpdate(data map[string]any) error {
+	for k := range data {
+		if k != "role" {
+			return fmt.Errorf("%s cannot be changed; only role can be updated", k)
+		}
+	}
+	if role, ok := data["role"]; ok {
+		if _, err := sharing.ParseCollaboratorRole(strVal(role)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// deploymentCollaboratorInviteHandler handles POST /deployments/{id}/collaborators:
+// the owner invites someone by email as a viewer or operator.
+func deploymentCollaboratorInviteHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+		switch strVal(depl["status"]) {
+		case "deleting", "deleted":
+			writeProblem(w, r, ProblemInvalidState, "deployment is being deleted")
+			return
+		}
+
+		var req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body")
+			return
+		}
+		email, err := sharing.NormalizeEmail(req.Email)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		role, err := sharing.ParseCollaboratorRole(req.Role)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		deplRef := strVal(depl["reference_id"])
+		existing, err := cfg.Store.List(ctx, "deployment_collaborators", []Filter{
+			{Field: "deployment_id", Value: deplRef},
+			{Field: "email", Value: email},
+		}, Page{Limit: 1})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to check collaborators")
+			return
+		}
+		if len(existing) > 0 {
+			writeProblem(w, r, ProblemAlreadyExists, email+" is already invited to this deployment")
+			return
+		}
+
+		token, tokenFields, err := newInvitationToken(time.Now())
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		data := map[string]any{
+			"deployment_id": deplRef,
+			"owner_id":      authCtx.UserID,
+			"email":         email,
+			"role":          string(role),
+			"status":        sharing.StatusPending,
+		}
+		for k, v := range tokenFields {
+			data[k] = v
+		}
+		collab, err := cfg.Store.Create(ctx, "deployment_collaborators", data)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to create invitation")
+			return
+		}
+
+		meta := sendInvitation(ctx, cfg, collab, depl, token)
+		stripFields(cfg.Store.Resource("deployment_collaborators"), collab, cfg.Store, authCtx)
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployment_collaborators", collab),
+			"meta": meta,
+		})
+	}
+}
+
+// collaboratorResendHandler handles POST /deployment_collaborators/{id}/resend:
+// a pending invitation gets a new token and expiry and is sent again. The old
+// token stops working.
+func collaboratorResendHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		collab, err := cfg.Store.Get(ctx, "deployment_collaborators", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "collaborator not found")
+			return
+		}
+		if ownerID, ok := toInt64(collab["owner_id"]); !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		if strVal(collab["status"]) != sharing.StatusPending {
+			writeProblem(w, r, ProblemInvalidState, "invitation has already been accepted")
+			return
+		}
+		depl, err := cfg.Store.Get(ctx, "deployments", strVal(collab["deployment_id"]))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+
+		token, tokenFields, err := newInvitationToken(time.Now())
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		collab, err = cfg.Store.Update(ctx, "deployment_collaborators", id, tokenFields)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to renew invitation")
+			return
+		}
+
+		meta := sendInvitation(ctx, cfg, collab, depl, token)
+		stripFields(cfg.Store.Resource("deployment_collaborators"), collab, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployment_collaborators", collab),
+			"meta": meta,
+		})
+	}
+}
+
+// invitationAcceptHandler handles POST /collaborator-invitations/accept: the
+// signed-in user holding an invitation's token becomes the collaborator.
+func invitationAcceptHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Token) == "" {
+			writeProblem(w, r, ProblemInvalidRequest, "token is required")
+			return
+		}
+
+		collab, err := cfg.Store.GetByField(ctx, "deployment_collaborators", "token_hash", sharing.HashToken(strings.TrimSpace(req.Token)))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "invitation not found")
+			return
+		}
+		if strVal(collab["status"]) != sharing.StatusPending {
+			writeProblem(w, r, ProblemInvalidState, "invitation has already been accepted")
+			return
+		}
+		if expiresAt, ok := parseTime(collab["expires_at"]); ok && sharing.Expired(expiresAt, time.Now()) {
+			writeProblem(w, r, ProblemInvalidState, "invitation has expired; ask the owner to resend it")
+			return
+		}
+
+		deplRef := strVal(collab["deployment_id"])
+		depl, err := cfg.Store.Get(ctx, "deployments", deplRef)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		role, err := deploymentRole(ctx, cfg.Store, depl, authCtx)
+		if err != nil && !errors.Is(err, errUnparseableOwner) {
+			writeProblem(w, r, ProblemInternal, "failed to check deployment access")
+			return
+		}
+		switch role {
+		case sharing.RoleOwner:
+			writeProblem(w, r, ProblemInvalidState, "you own this deployment")
+			return
+		case sharing.RoleNone:
+		default:
+			writeProblem(w, r, ProblemAlreadyExists, "you already collaborate on this deployment")
+			return
+		}
+
+		collab, err = cfg.Store.Update(ctx, "deployment_collaborators", strVal(collab["reference_id"]), map[string]any{
+			"user_id":     authCtx.UserID,
+			"status":      sharing.StatusAccepted,
+			"accepted_at": time.Now().UTC().Format(time.RFC3339),
+			"token_hash":  nil,
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to accept invitation")
+			return
+		}
+		cfg.Logger.Info("collaborator invitation accepted",
+			"deployment", deplRef, "collaborator", strVal(collab["reference_id"]), "role", strVal(collab["role"]))
+
+		stripFields(cfg.Store.Resource("deployment_collaborators"), collab, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployment_collaborators", collab),
+		})
+	}
+}
+
+// sharedDeploymentsHandler handles GET /shared-deployments: the deployments
+// the requester collaborates on, each with its role in meta.
+func sharedDeploymentsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		collabs, err := cfg.Store.List(ctx, "deployment_collaborators", []Filter{
+			{Field: "user_id", Value: authCtx.UserID},
+			{Field: "status", Value: sharing.StatusAccepted},
+		}, Page{Limit: 1000})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list shared deployments")
+			return
+		}
+
+		res := cfg.Store.Resource("deployments")
+		data := make([]map[string]any, 0, len(collabs))
+		for _, c := range collabs {
+			depl, err := cfg.Store.Get(ctx, "deployments", strVal(c["deployment_id"]))
+			if err != nil || strVal(depl["status"]) == "deleted" {
+				continue
+			}
+			if res.AfterRead != nil {
+				res.AfterRead(ctx, authCtx, []map[string]any{depl})
+			}
+			stripFields(res, depl, cfg.Store, authCtx)
+			item := renderResource(r, cfg.Store, "deployments", depl)
+			item["meta"] = map[string]any{"role": strVal(c["role"])}
+			data = append(data, item)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
@@ -0,0 +1,443 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/replay"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/shell/logging"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Command Executions
+// =============================================================================
+//
+// Every command the Bus runs, dispatched directly or from the job queue,
+// is recorded in command_executions with a snapshot of its payload, its
+// outcome and how long it took. A failed execution can be dispatched again
+// with the same payload through POST /command-executions/{id}/retry once it
+// passes the guards in core/replay.
+
+// Execution sources.
+const (
+	executionDispatch  = "dispatch"
+	executionJob       = "job"
+	executionScheduled = "scheduled"
+	executionRetry     = "retry"
+)
+
+// commandResources maps commands to the resource their payload is a row of.
+// Executions of other commands are recorded without a resource.
+var commandResources = map[string]string{
+	"ScheduleDeployment":     "deployments",
+	"StartDeployment":        "deployments",
+	"StopDeployment":         "deployments",
+	"DeleteDeployment":       "deployments",
+	"DeploymentRunning":      "deployments",
+	"DeploymentFailed":       "deployments",
+	"UpgradeDeployment":      "deployments",
+	"CanaryCheck":            "deployments",
+	"AbortCanary":            "deployments",
+	"UpdateDeploymentImages": "deployments",
+	"ExpireDeployment":       "deployments",
+	"ProvisionInstance":      "cloud_provisions",
+	"ConfigureInstance":      "cloud_provisions",
+	"ProvisionReady":         "cloud_provisions",
+	"DestroyInstance":        "cloud_provisions",
+	"InvoicePaid":            "invoices",
+}
+
+// retryableCommands are the commands that are safe to run again after a
+// failure: each picks up from the resource's current state.
+var retryableCommands = map[string]bool{
+	"ScheduleDeployment":     true,
+	"StartDeployment":        true,
+	"StopDeployment":         true,
+	"DeleteDeployment":       true,
+	"UpgradeDeployment":      true,
+	"UpdateDeploymentImages": true,
+	"DestroyInstance":        true,
+}
+
+// CommandExecution is a recorded run of a command.
+type CommandExecution struct {
+	ID           int64          `db:"id"`
+	ReferenceID  string         `db:"reference_id"`
+	Command      string         `db:"command"`
+	ResourceType string         `db:"resource_type"`
+	ResourceID   string         `db:"resource_id"`
+	Source       string         `db:"source"`
+	RetryOf      string         `db:"retry_of"`
+	TriggeredBy  string         `db:"triggered_by"`
+	DataJSON     string         `db:"data"`
+	Status       string         `db:"status"`
+	Error        string         `db:"error"`
+	StartedAt    string         `db:"started_at"`
+	FinishedAt   sql.NullString `db:"finished_at"`
+	DurationMS   int64          `db:"duration_ms"`
+}
+
+const commandExecutionColumns = `id, reference_id, command, resource_type, resource_id, source, retry_of,
+	triggered_by, data, status, error, started_at, finished_at, duration_ms`
+
+// replayExecution returns the fields the retry guards look at.
+func (e *CommandExecution) replayExecution() replay.Execution {
+	started, _ := time.Parse(time.RFC3339, e.StartedAt)
+	return replay.Execution{
+		ID:        e.ReferenceID,
+		Seq:       e.ID,
+		Command:   e.Command,
+		Status:    replay.Status(e.Status),
+		StartedAt: started,
+	}
+}
+
+// execute runs a command's handler and records the outcome on exec, the
+// execution begun for it. exec is nil when it could not be recorded;
+// recording failures never keep a command from running.
+func (b *Bus) execute(ctx context.Context, command string, handler Handler, data map[string]any, exec *CommandExecution) error {
+	b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	start := time.Now()
+	ctx, logger := b.commandLogger(ctx, command, data, exec)
+	deps := *b.deps
+	deps.Logger = logger
+	err := handler(ctx, &deps, data)
+	if exec != nil {
+		b.finishExecution(context.WithoutCancel(ctx), exec, start, err)
+	}
+	return err
+}
+
+// beginExecution records a command as running. It returns nil when the
+// execution cannot be recorded.
+func (b *Bus) beginExecution(ctx context.Context, command string, data map[string]any, source, retryOf, triggeredBy string) *CommandExecution {
+	store := b.deps.Store
+	if store == nil {
+		return nil
+	}
+	resType := commandResources[command]
+	raw, err := json.Marshal(executionSnapshot(store, resType, data))
+	if err != nil {
+		b.logger.Warn("failed to snapshot command payload", "command", command, "error", err)
+		raw = []byte("{}")
+	}
+	exec := &CommandExecution{
+		ReferenceID:  "cexec_" + uuid.New().String()[:8],
+		Command:      command,
+		ResourceType: resType,
+		ResourceID:   strVal(data["reference_id"]),
+		Source:       source,
+		RetryOf:      retryOf,
+		TriggeredBy:  triggeredBy,
+		DataJSON:     string(raw),
+		Status:       string(replay.StatusRunning),
+		StartedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	res, err := store.db.NamedExecContext(context.WithoutCancel(ctx),
+		`INSERT INTO command_executions (reference_id, command, resource_type, resource_id, source, retry_of, triggered_by, data, status, started_at)
+		VALUES (:reference_id, :command, :resource_type, :resource_id, :source, :retry_of, :triggered_by, :data, :status, :started_at)`, exec)
+	if err != nil {
+		b.logger.Warn("failed to record command execution", "command", command, "error", err)
+		return nil
+	}
+	exec.ID, _ = res.LastInsertId()
+	return exec
+}
+
+// finishExecution records a command's outcome.
+func (b *Bus) finishExecution(ctx context.Context, exec *CommandExecution, start time.Time, err error) {
+	now := time.Now()
+	exec.Status = string(replay.StatusSucceeded)
+	if err != nil {
+		exec.Status = string(replay.StatusFailed)
+		exec.Error = err.Error()
+	}
+	exec.FinishedAt = sql.NullString{String: now.UTC().Format(time.RFC3339), Valid: true}
+	exec.DurationMS = now.Sub(start).Milliseconds()
+	if _, err := b.deps.Store.db.NamedExecContext(ctx,
+		`UPDATE command_executions SET status = :status, error = :error, finished_at = :finished_at, duration_ms = :duration_ms
+		WHERE id = :id`, exec); err != nil {
+		b.logger.Warn("failed to record command outcome", "command", exec.Command, "error", err)
+	}
+}
+
+// executionSnapshot copies a payload for the history, leaving out the
+// resource's write-only and encrypted fields.
+func executionSnapshot(store *Store, resType string, data map[string]any) map[string]any {
+	snap := make(map[string]any, len(data))
+	for k, v := range data {
+		snap[k] = v
+	}
+	if res := store.Resource(resType); res != nil {
+		for _, f := range res.Fields {
+			if f.WriteOnly || f.Encrypted {
+				delete(snap, f.Name)
+			}
+		}
+	}
+	return snap
+}
+
+// CommandExecutions lists a resource's command executions, newest first.
+func (s *Store) CommandExecutions(ctx context.Context, resType, resID string, page Page) ([]*CommandExecution, error) {
+	query := `SELECT ` + commandExecutionColumns + ` FROM command_executions WHERE resource_type = ? AND resource_id = ?`
+	args := []any{resType, resID}
+	if cond, condArgs := page.after("datetime(started_at)", "id", true); cond != "" {
+		query += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	query += ` ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?`
+	args = append(args, page.Limit, page.Offset)
+
+	var out []*CommandExecution
+	if err := s.db.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, fmt.Errorf("list command executions: %w", err)
+	}
+	return out, nil
+}
+
+// CommandExecution returns an execution by reference ID.
+func (s *Store) CommandExecution(ctx context.Context, refID string) (*CommandExecution, error) {
+	var exec CommandExecution
+	if err := s.db.GetContext(ctx, &exec,
+		`SELECT `+commandExecutionColumns+` FROM command_executions WHERE reference_id = ?`, refID); err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+// =============================================================================
+// Retry
+// =============================================================================
+
+// Retry dispatches a failed execution again with its original payload, in
+// the background. The resource is first moved back to the state the
+// payload was taken in, when it has left it for one it may return from.
+// The new execution is returned.
+func (b *Bus) Retry(ctx context.Context, exec *CommandExecution, triggeredBy string) (*CommandExecution, error) {
+	if !retryableCommands[exec.Command] || exec.ResourceType == "" {
+		return nil, fmt.Errorf("%w: %s", replay.ErrNotRetryable, exec.Command)
+	}
+	b.mu.RLock()
+	handler, ok := b.handlers[exec.Command]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: no handler registered for %s", replay.ErrNotRetryable, exec.Command)
+	}
+
+	store := b.deps.Store
+	history, err := store.CommandExecutions(ctx, exec.ResourceType, exec.ResourceID, Page{Limit: 1000})
+	if err != nil {
+		return nil, err
+	}
+	past := make([]replay.Execution, 0, len(history))
+	for _, e := range history {
+		past = append(past, e.replayExecution())
+	}
+	if err := replay.CheckRetry(exec.replayExecution(), past, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(exec.DataJSON), &data); err != nil {
+		return nil, fmt.Errorf("invalid payload snapshot: %w", err)
+	}
+	if res := store.Resource(exec.ResourceType); res != nil && res.StateMachine != nil {
+		row, err := store.Get(ctx, exec.ResourceType, exec.ResourceID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s no longer exists", replay.ErrStateChanged, exec.ResourceID)
+		}
+		sm := res.StateMachine
+		to, err := replay.RetryState(strVal(data[sm.Field]), strVal(row[sm.Field]), sm.CanTransition)
+		if err != nil {
+			return nil, err
+		}
+		if to != "" {
+			if _, _, err := store.Transition(ctx, exec.ResourceType, exec.ResourceID, to); err != nil {
+				return nil, fmt.Errorf("%w: %v", replay.ErrStateChanged, err)
+			}
+		}
+	}
+
+	retry := b.beginExecution(ctx, exec.Command, data, executionRetry, exec.ReferenceID, triggeredBy)
+	if retry == nil {
+		return nil, fmt.Errorf("failed to record retry")
+	}
+	// The retry outlives the request but keeps logging under it
+	logger := logging.FromContext(ctx, b.logger)
+	go func() {
+		if err := b.execute(logging.WithLogger(context.Background(), logger), exec.Command, handler, data, retry); err != nil {
+			logger.Error("command retry failed", "command", exec.Command, "retry_of", exec.ReferenceID, "error", err)
+		}
+	}()
+	return retry, nil
+}
+
+// =============================================================================
+// Command History Handlers
+// =============================================================================
+
+// authorizeCommandResource checks the caller may see (or, with manage, retry)
+// commands run against a resource. Administrators may do both for any
+// resource.
+func authorizeCommandResource(w http.ResponseWriter, r *http.Request, cfg SetupConfig, resType string, row map[string]any, manage bool) bool {
+	authCtx := getAuthContext(r)
+	if isAdmin(cfg, authCtx) {
+		return true
+	}
+	if resType == "deployments" {
+		perm := sharing.PermView
+		if manage {
+			perm = sharing.PermManage
+		}
+		return authorizeDeployment(w, r, cfg, row, perm)
+	}
+	res := cfg.Store.Resource(resType)
+	if res == nil || res.Owner == "" {
+		writeProblem(w, r, ProblemForbidden, "not authorized")
+		return false
+	}
+	if ownerID, ok := toInt64(row[res.Owner]); !ok || int(ownerID) != authCtx.UserID {
+		writeProblem(w, r, ProblemForbidden, "not authorized")
+		return false
+	}
+	return true
+}
+
+// commandHistoryHandler serves GET /{resource}/{id}/commands: the commands
+// run against the resource, newest first.
+func commandHistoryHandler(cfg SetupConfig, resType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := mux.Vars(r)["id"]
+
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		row, err := cfg.Store.Get(ctx, resType, id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "resource not found")
+			return
+		}
+		if !authorizeCommandResource(w, r, cfg, resType, row, false) {
+			return
+		}
+
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		execs, err := cfg.Store.CommandExecutions(ctx, resType, strVal(row["reference_id"]), page)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list command executions")
+			return
+		}
+		data := make([]map[string]any, 0, len(execs))
+		for _, e := range execs {
+			data = append(data, commandExecutionJSONAPI(e))
+		}
+		var next string
+		if n := len(execs); n > 0 {
+			next = cursorAfter(page, n, execs[n-1].StartedAt, execs[n-1].ID)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": data,
+			"meta": pageMeta(page, next),
+		})
+	}
+}
+
+// commandRetryHandler serves POST /command-executions/{id}/retry: it
+// dispatches a failed command again with its original payload and returns
+// the new execution with 202.
+func commandRetryHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.Bus == nil {
+			writeProblem(w, r, ProblemNotConfigured, "command bus is not configured")
+			return
+		}
+		exec, err := cfg.Store.CommandExecution(ctx, mux.Vars(r)["id"])
+		if err != nil || exec.ResourceType == "" {
+			writeProblem(w, r, ProblemNotFound, "command execution not found")
+			return
+		}
+		row, err := cfg.Store.Get(ctx, exec.ResourceType, exec.ResourceID)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "command execution not found")
+			return
+		}
+		if !authorizeCommandResource(w, r, cfg, exec.ResourceType, row, true) {
+			return
+		}
+
+		retry, err := cfg.Bus.Retry(ctx, exec, authCtx.ReferenceID)
+		if err != nil {
+			switch {
+			case errors.Is(err, replay.ErrNotRetryable):
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+			case errors.Is(err, replay.ErrNotFailed), errors.Is(err, replay.ErrInProgress),
+				errors.Is(err, replay.ErrSuperseded), errors.Is(err, replay.ErrStateChanged):
+				writeProblem(w, r, ProblemInvalidState, err.Error())
+			default:
+				writeProblem(w, r, ProblemInternal, err.Error())
+			}
+			return
+		}
+		cfg.Logger.Info("command retried", "command", exec.Command, "resource", exec.ResourceID,
+			"retry_of", exec.ReferenceID, "by", authCtx.ReferenceID)
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"data": commandExecutionJSONAPI(retry),
+		})
+	}
+}
+
+func commandExecutionJSONAPI(e *CommandExecution) map[string]any {
+	var data map[string]any
+	json.Unmarshal([]byte(e.DataJSON), &data)
+	attrs := map[string]any{
+		"command":       e.Command,
+		"resource_type": e.ResourceType,
+		"resource_id":   e.ResourceID,
+		"source":        e.Source,
+		"data":          data,
+		"status":        e.Status,
+		"started_at":    e.StartedAt,
+		"retryable":     retryableCommands[e.Command] && e.Status == string(replay.StatusFailed),
+	}
+	if e.RetryOf != "" {
+		attrs["retry_of"] = e.RetryOf
+	}
+	if e.TriggeredBy != "" {
+		attrs["triggered_by"] = e.TriggeredBy
+	}
+	if e.Error != "" {
+		attrs["error"] = e.Error
+	}
+	if e.FinishedAt.Valid {
+		attrs["finished_at"] = e.FinishedAt.String
+		attrs["duration_ms"] = e.DurationMS
+	}
+	return map[string]any{
+		"type":       "command_executions",
+		"id":         e.ReferenceID,
+		"attributes": attrs,
+	}
+}
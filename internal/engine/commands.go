@@ -5,6 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/artpar/hoster/internal/shell/logging"
+	"github.com/google/uuid"
 )
 
 // Handler processes a command dispatched by the state machine.
@@ -19,11 +24,24 @@ type Deps struct {
 }
 
 // Bus implements CommandBus by dispatching to registered handlers.
+// Commands can also be queued to run on its worker pool (see Enqueue) or
+// scheduled to run later (see DispatchAt).
 type Bus struct {
 	handlers map[string]Handler
 	deps     *Deps
 	logger   *slog.Logger
 	mu       sync.RWMutex
+	inFlight atomic.Int64
+
+	// Job workers
+	workers      int
+	wake         chan struct{}
+	pollInterval time.Duration
+	owner        string        // Claimant recorded on the jobs this bus runs
+	claimTTL     time.Duration // How long a claim lasts without renewal
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
 }
 
 // NewBus creates a new command bus.
@@ -38,7 +56,12 @@ func NewBus(store *Store, logger *slog.Logger) *Bus {
 			Logger: logger,
 			Extra:  make(map[string]any),
 		},
-		logger: logger,
+		logger:       logger,
+		workers:      4,
+		wake:         make(chan struct{}, 1),
+		pollInterval: 5 * time.Second,
+		owner:        uuid.New().String(),
+		claimTTL:     time.Minute,
 	}
 }
 
@@ -47,6 +70,18 @@ func (b *Bus) SetExtra(key string, value any) {
 	b.deps.Extra[key] = value
 }
 
+// SetWorkers sets how many jobs run at once. It takes effect on Start.
+func (b *Bus) SetWorkers(n int) {
+	if n > 0 {
+		b.workers = n
+	}
+}
+
+// InFlight returns how many commands are running on this bus.
+func (b *Bus) InFlight() int64 {
+	return b.inFlight.Load()
+}
+
 // Register registers a handler for a command name.
 func (b *Bus) Register(command string, handler Handler) {
 	b.mu.Lock()
@@ -60,14 +95,16 @@ func (b *Bus) Dispatch(ctx context.Context, command string, data map[string]any)
 	handler, ok := b.handlers[command]
 	b.mu.RUnlock()
 
+	logger := logging.FromContext(ctx, b.logger)
 	if !ok {
-		b.logger.Warn("no handler registered for command", "command", command)
+		logger.Warn("no handler registered for command", "command", command)
 		return nil // Don't fail — just log
 	}
 
-	b.logger.Debug("dispatching command", "command", command)
-	if err := handler(ctx, b.deps, data); err != nil {
-		b.logger.Error("command failed", "command", command, "error", err)
+	logger.Debug("dispatching command", "command", command)
+	exec := b.beginExecution(ctx, command, data, executionDispatch, "", "")
+	if err := b.execute(ctx, command, handler, data, exec); err != nil {
+		logger.Error("command failed", "command", command, "error", err)
 		return fmt.Errorf("command %s: %w", command, err)
 	}
 
@@ -0,0 +1,398 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Container Metrics Storage
+// =============================================================================
+//
+// container_metrics holds one row per service container per collection. Rows
+// carry the deployment's template and template version, so capacity reports
+// compare usage with the limits of the version that produced it. collected_at
+// is RFC3339 UTC so string comparison orders by time.
+
+// ContainerMetricsSample is one stats reading of a deployment's service.
+type ContainerMetricsSample struct {
+	Service          string
+	CPUPercent       float64
+	MemoryUsageBytes int64
+	MemoryLimitBytes int64
+	NetworkRxBytes   int64
+	NetworkTxBytes   int64
+}
+
+// RecordContainerMetrics stores one collection of a deployment's containers.
+func (s *Store) RecordContainerMetrics(ctx context.Context, depl map[string]any, samples []ContainerMetricsSample, collectedAt time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("record container metrics: %w", err)
+	}
+	defer tx.Rollback()
+
+	at := collectedAt.UTC().Format(time.RFC3339)
+	for _, m := range samples {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO container_metrics (deployment_id, template_id, template_version, service,
+				collected_at, cpu_percent, memory_usage_bytes, memory_limit_bytes, network_rx_bytes, network_tx_bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			toInt(depl["id"]), toInt(depl["template_id"]), strVal(depl["template_version"]), m.Service,
+			at, m.CPUPercent, m.MemoryUsageBytes, m.MemoryLimitBytes, m.NetworkRxBytes, m.NetworkTxBytes); err != nil {
+			return fmt.Errorf("record container metrics: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// PruneContainerMetrics deletes samples collected before the cutoff.
+func (s *Store) PruneContainerMetrics(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM container_metrics WHERE collected_at < ?`, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("prune container metrics: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// capacitySamples returns the samples collected since the cutoff for one
+// template version, grouped by service. column is deployment_id or
+// template_id and id its value.
+func (s *Store) capacitySamples(ctx context.Context, column string, id int, version string, since time.Time) (map[string][]monitoring.CapacitySample, error) {
+	rows, err := s.db.QueryxContext(ctx,
+		`SELECT service, cpu_percent, memory_usage_bytes FROM container_metrics
+		WHERE `+column+` = ? AND template_version = ? AND collected_at >= ?`,
+		id, version, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("query container metrics: %w", err)
+	}
+	defer rows.Close()
+
+	samples := map[string][]monitoring.CapacitySample{}
+	for rows.Next() {
+		var service string
+		var sample monitoring.CapacitySample
+		if err := rows.Scan(&service, &sample.CPUPercent, &sample.MemoryBytes); err != nil {
+			return nil, fmt.Errorf("scan container metrics: %w", err)
+		}
+		samples[service] = append(samples[service], sample)
+	}
+	return samples, rows.Err()
+}
+
+// LastMemorySample returns a service's last memory sample collected at or
+// before t, or nil if there is none.
+func (s *Store) LastMemorySample(ctx context.Context, deploymentID int, service string, t time.Time) (*domain.MemorySnapshot, error) {
+	var row struct {
+		CollectedAt string `db:"collected_at"`
+		Usage       int64  `db:"memory_usage_bytes"`
+		Limit       int64  `db:"memory_limit_bytes"`
+	}
+	err := s.db.GetContext(ctx, &row,
+		`SELECT collected_at, memory_usage_bytes, memory_limit_bytes FROM container_metrics
+		WHERE deployment_id = ? AND service = ? AND collected_at <= ?
+		ORDER BY collected_at DESC LIMIT 1`,
+		deploymentID, service, t.UTC().Format(time.RFC3339))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query container metrics: %w", err)
+	}
+	at, _ := time.Parse(time.RFC3339, row.CollectedAt)
+	return monitoring.NewMemorySnapshot(row.Usage, row.Limit, at), nil
+}
+
+// =============================================================================
+// Container Metrics Collector
+// =============================================================================
+
+// ContainerMetricsCollector periodically samples CPU, memory and network
+// usage of running deployments' containers for capacity reports and abuse
+// detection, and prunes samples older than the retention period.
+type ContainerMetricsCollector struct {
+	store     *Store
+	nodePool  *docker.NodePool
+	interval  time.Duration
+	retention time.Duration
+	logger    *slog.Logger
+	notifier  *Notifier
+	admins    []string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewContainerMetricsCollector(store *Store, nodePool *docker.NodePool, interval, retention time.Duration, logger *slog.Logger) *ContainerMetricsCollector {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	if retention == 0 {
+		retention = 14 * 24 * time.Hour
+	}
+	return &ContainerMetricsCollector{
+		store:     store,
+		nodePool:  nodePool,
+		interval:  interval,
+		retention: retention,
+		logger:    logger.With("component", "container_metrics"),
+	}
+}
+
+func (c *ContainerMetricsCollector) Start() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.run()
+	c.logger.Info("container metrics collector started", "interval", c.interval, "retention", c.retention)
+}
+
+func (c *ContainerMetricsCollector) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *ContainerMetricsCollector) run() {
+	defer c.wg.Done()
+	c.collectAll()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.collectAll()
+		}
+	}
+}
+
+func (c *ContainerMetricsCollector) collectAll() {
+	defer c.store.LoopMetrics().Time("container_metrics")()
+	deployments, err := c.store.List(c.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		c.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+
+	for _, depl := range deployments {
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.collectDeployment(depl)
+	}
+
+	cutoff := monitoring.RetentionCutoff(time.Now(), c.retention)
+	if n, err := c.store.PruneContainerMetrics(c.ctx, cutoff); err != nil {
+		c.logger.Error("failed to prune container metrics", "error", err)
+	} else if n > 0 {
+		c.logger.Debug("pruned container metrics", "rows", n)
+	}
+}
+
+// collectDeployment samples each of a deployment's containers. Containers
+// whose stats cannot be read are skipped; an unreachable node skips the
+// deployment.
+func (c *ContainerMetricsCollector) collectDeployment(depl map[string]any) {
+	refID := strVal(depl["reference_id"])
+	nodeID := strVal(depl["node_id"])
+	var containers []domain.ContainerInfo
+	if err := decodeJSONValue(depl["containers"], &containers); err != nil || nodeID == "" || len(containers) == 0 {
+		return
+	}
+
+	client, err := c.nodePool.GetClient(c.ctx, nodeID)
+	if err != nil {
+		c.logger.Debug("node unavailable for container metrics", "deployment", refID, "node", nodeID, "error", err)
+		return
+	}
+
+	var samples []ContainerMetricsSample
+	for _, ctr := range containers {
+		stats, err := client.ContainerStats(ctr.ID)
+		if err != nil {
+			c.logger.Debug("container stats failed", "deployment", refID, "service", ctr.ServiceName, "error", err)
+			continue
+		}
+		samples = append(samples, ContainerMetricsSample{
+			Service:          ctr.ServiceName,
+			CPUPercent:       stats.CPUPercent,
+			MemoryUsageBytes: stats.MemoryUsageBytes,
+			MemoryLimitBytes: stats.MemoryLimitBytes,
+			NetworkRxBytes:   stats.NetworkRxBytes,
+			NetworkTxBytes:   stats.NetworkTxBytes,
+		})
+	}
+	if len(samples) == 0 {
+		return
+	}
+	now := time.Now()
+	if err := c.store.RecordContainerMetrics(c.ctx, depl, samples, now); err != nil {
+		c.logger.Error("failed to record container metrics", "deployment", refID, "error", err)
+		return
+	}
+	c.detectAbuse(depl, now)
+}
+
+// =============================================================================
+// Capacity Reports
+// =============================================================================
+
+// defaultCapacityWindow is how far back capacity reports look by default.
+const defaultCapacityWindow = 7 * 24 * time.Hour
+
+// declaredLimits returns the resource limits a compose spec declares per service.
+func declaredLimits(composeSpec string) (map[string]monitoring.ServiceLimits, error) {
+	spec, err := compose.ParseComposeSpec(composeSpec)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]monitoring.ServiceLimits, len(spec.Services))
+	for _, svc := range spec.Services {
+		limits[svc.Name] = monitoring.ServiceLimits{
+			CPU:         svc.Resources.CPULimit,
+			MemoryBytes: svc.Resources.MemoryLimit,
+		}
+	}
+	return limits, nil
+}
+
+// capacityWindow reads the report window from ?since=, defaulting to 7 days.
+func capacityWindow(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return defaultCapacityWindow, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("since must be a positive duration (e.g. 168h)")
+	}
+	return d, nil
+}
+
+// capacityAttributes builds a capacity report's attributes for samples of
+// one template version.
+func capacityAttributes(ctx context.Context, store *Store, tmpl map[string]any, column string, id int, version string, window time.Duration) (map[string]any, error) {
+	limits, err := declaredLimits(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return nil, fmt.Errorf("parse compose spec: %w", err)
+	}
+	// Usage of another version says little about this version's limits
+	if version != strVal(tmpl["version"]) {
+		limits = map[string]monitoring.ServiceLimits{}
+	}
+	since := time.Now().Add(-window)
+	samples, err := store.capacitySamples(ctx, column, id, version, since)
+	if err != nil {
+		return nil, err
+	}
+
+	t := monitoring.DefaultCapacityThresholds()
+	return map[string]any{
+		"template_version": version,
+		"since":            since.UTC().Format(time.RFC3339),
+		"services":         monitoring.CapacityReport(limits, samples, t),
+		"thresholds": map[string]any{
+			"over_provisioned":  t.OverProvisioned,
+			"under_provisioned": t.UnderProvisioned,
+			"headroom":          t.Headroom,
+			"min_samples":       t.MinSamples,
+		},
+	}, nil
+}
+
+// deploymentCapacityHandler serves GET /deployments/{id}/monitoring/capacity:
+// each service's declared limits against its P95 usage since ?since=
+// (default 7 days), with right-sizing recommendations.
+func deploymentCapacityHandler(cfg SetupConfig) http.HandlerFunc {
+	return monitoringHandler(cfg, "deployment-capacity", func(ctx context.Context, cfg SetupConfig, depl map[string]any, r *http.Request) map[string]any {
+		refID := strVal(depl["reference_id"])
+		attrs := map[string]any{"services": []monitoring.ServiceCapacity{}}
+
+		window, err := capacityWindow(r)
+		if err != nil {
+			window = defaultCapacityWindow
+		}
+		tmpl, err := cfg.Store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+		if err == nil {
+			a, err := capacityAttributes(ctx, cfg.Store, tmpl, "deployment_id", toInt(depl["id"]), strVal(depl["template_version"]), window)
+			if err != nil {
+				cfg.Logger.Warn("failed to build capacity report", "deployment", refID, "error", err)
+			} else {
+				attrs = a
+			}
+		}
+		return map[string]any{
+			"data": map[string]any{
+				"type":       "deployment-capacity",
+				"id":         refID,
+				"attributes": attrs,
+			},
+		}
+	})
+}
+
+// templateCapacityHandler serves GET /templates/{id}/capacity to the
+// template's creator: the capacity report of the current version across all
+// of its deployments, for tuning the template's limits.
+func templateCapacityHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		tmpl, err := cfg.Store.Get(ctx, "templates", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+
+		ownerID, ok := toInt64(tmpl["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		window, err := capacityWindow(r)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		attrs, err := capacityAttributes(ctx, cfg.Store, tmpl, "template_id", toInt(tmpl["id"]), strVal(tmpl["version"]), window)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "template-capacity",
+				"id":         strVal(tmpl["reference_id"]),
+				"attributes": attrs,
+			},
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/coordination"
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Lease Storage
+// =============================================================================
+//
+// Replicas sharing a database coordinate through rows in the leases table.
+// A lease is taken with one atomic upsert that succeeds only when the row is
+// free, expired, or already ours, so two replicas can never both hold it.
+// Times are stored with fixed millisecond precision in UTC, so they compare
+// correctly as text.
+
+const leaseTimeFormat = "2006-01-02T15:04:05.000Z"
+
+type leaseRow struct {
+	Name       string `db:"name"`
+	Holder     string `db:"holder"`
+	Token      int64  `db:"token"`
+	AcquiredAt string `db:"acquired_at"`
+	ExpiresAt  string `db:"expires_at"`
+}
+
+func (l leaseRow) lease() coordination.Lease {
+	acquired, _ := time.Parse(leaseTimeFormat, l.AcquiredAt)
+	expires, _ := time.Parse(leaseTimeFormat, l.ExpiresAt)
+	return coordination.Lease{
+		Name:       l.Name,
+		Holder:     l.Holder,
+		Token:      l.Token,
+		AcquiredAt: acquired,
+		ExpiresAt:  expires,
+	}
+}
+
+// AcquireLease takes or renews a lease for ttl. When another holder has it,
+// the current lease is returned with coordination.ErrLeaseHeld.
+func (s *Store) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (coordination.Lease, error) {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO leases (name, holder, token, acquired_at, expires_at) VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			token = CASE WHEN leases.holder = excluded.holder THEN leases.token ELSE leases.token + 1 END,
+			acquired_at = CASE WHEN leases.holder = excluded.holder THEN leases.acquired_at ELSE excluded.acquired_at END,
+			holder = excluded.holder,
+			expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= excluded.acquired_at`,
+		name, holder, now.Format(leaseTimeFormat), now.Add(ttl).Format(leaseTimeFormat))
+	if err != nil {
+		return coordination.Lease{}, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+
+	var row leaseRow
+	if err := s.db.GetContext(ctx, &row,
+		`SELECT name, holder, token, acquired_at, expires_at FROM leases WHERE name = ?`, name); err != nil {
+		return coordination.Lease{}, fmt.Errorf("read lease %s: %w", name, err)
+	}
+	lease := row.lease()
+	if lease.Holder != holder {
+		return lease, coordination.ErrLeaseHeld
+	}
+	return lease, nil
+}
+
+// ReleaseLease gives up a lease if holder has it, so another replica can
+// take it without waiting for it to expire.
+func (s *Store) ReleaseLease(ctx context.Context, name, holder string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		return fmt.Errorf("release lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReleaseAllLeases drops every lease, whoever holds it, and returns how many
+// there were. A promoted standby uses it to take over at once from a primary
+// that can no longer release its own.
+func (s *Store) ReleaseAllLeases(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM leases`)
+	if err != nil {
+		return 0, fmt.Errorf("release leases: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// =============================================================================
+// Coordinator
+// =============================================================================
+
+// Coordinator takes leases on behalf of one replica.
+type Coordinator struct {
+	store    *Store
+	holder   string
+	ttl      time.Duration
+	lockWait time.Duration
+	logger   *slog.Logger
+}
+
+// NewCoordinator creates a coordinator for the replica identified by holder.
+// ttl defaults to coordination.DefaultLeaseTTL.
+func NewCoordinator(store *Store, holder string, ttl time.Duration, logger *slog.Logger) *Coordinator {
+	if ttl == 0 {
+		ttl = coordination.DefaultLeaseTTL
+	}
+	return &Coordinator{
+		store:    store,
+		holder:   holder,
+		ttl:      ttl,
+		lockWait: 2 * time.Minute,
+		logger:   logger.With("component", "coordinator"),
+	}
+}
+
+// Holder returns the replica's holder ID.
+func (c *Coordinator) Holder() string {
+	return c.holder
+}
+
+// WithLock runs fn while holding the lock name, waiting up to two minutes
+// for another holder to release it. The lock is renewed while fn runs; if
+// it is lost, fn's context is cancelled. Each call is a separate holder, so
+// the lock also excludes other goroutines of this replica.
+func (c *Coordinator) WithLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	holder := c.holder + "/" + uuid.New().String()[:8]
+	if err := c.waitLease(ctx, name, holder); err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.renewLock(lockCtx, cancel, name, holder)
+	}()
+
+	err := fn(lockCtx)
+	cancel()
+	wg.Wait()
+
+	// Release even if the caller's context is done
+	if rerr := c.store.ReleaseLease(context.Background(), name, holder); rerr != nil {
+		c.logger.Warn("failed to release lock", "lock", name, "error", rerr)
+	}
+	return err
+}
+
+func (c *Coordinator) waitLease(ctx context.Context, name, holder string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, c.lockWait)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		lease, err := c.store.AcquireLease(waitCtx, name, holder, c.ttl)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, coordination.ErrLeaseHeld) {
+			return err
+		}
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("%s: %w (held by %s)", name, coordination.ErrLeaseHeld, lease.Holder)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Coordinator) renewLock(ctx context.Context, lost context.CancelFunc, name, holder string) {
+	ticker := time.NewTicker(coordination.RenewInterval(c.ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.store.AcquireLease(ctx, name, holder, c.ttl); err != nil && ctx.Err() == nil {
+				c.logger.Error("lost lock", "lock", name, "error", err)
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// =============================================================================
+// Leader Election
+// =============================================================================
+
+// Singleton is a background worker that must run on one replica at a time.
+// It is started when the replica becomes leader and stopped when it stops
+// being leader, so Start must work again after Stop.
+type Singleton interface {
+	Start()
+	Stop()
+}
+
+// loopSingleton adapts a loop that runs until its context is cancelled.
+type loopSingleton struct {
+	run    func(ctx context.Context)
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Loop returns a Singleton running fn until it is stopped.
+func Loop(fn func(ctx context.Context)) Singleton {
+	return &loopSingleton{run: fn}
+}
+
+func (l *loopSingleton) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.run(ctx)
+	}()
+}
+
+func (l *loopSingleton) Stop() {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.wg.Wait()
+}
+
+type namedSingleton struct {
+	name   string
+	worker Singleton
+}
+
+// LeaderElector holds the leader lease for its replica when it can, and runs
+// the registered singletons only while it does. A leader that has not
+// renewed its lease by the renew deadline stops them, from a timer that
+// doesn't wait on the renewal, so they are cancelled before the lease can
+// pass to another replica.
+type LeaderElector struct {
+	coord   *Coordinator
+	workers []namedSingleton
+	logger  *slog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	transition sync.Mutex // Held while the singletons start or stop
+
+	mu        sync.Mutex
+	leading   bool
+	renewedAt time.Time
+	deadline  *time.Timer // Steps down when the lease goes unrenewed
+}
+
+func NewLeaderElector(coord *Coordinator, logger *slog.Logger) *LeaderElector {
+	return &LeaderElector{
+		coord:  coord,
+		logger: logger.With("component", "leader_elector", "replica", coord.Holder()),
+	}
+}
+
+// Add registers a singleton. Singletons start in the order added and are
+// stopped all at once. Add must not be called after Start.
+func (le *LeaderElector) Add(name string, worker Singleton) {
+	le.workers = append(le.workers, namedSingleton{name: name, worker: worker})
+}
+
+// Start campaigns for leadership. The first attempt is made before Start
+// returns, so a lone replica starts its singletons right away.
+func (le *LeaderElector) Start() {
+	le.ctx, le.cancel = context.WithCancel(context.Background())
+	le.campaign()
+	le.wg.Add(1)
+	go le.run()
+	le.logger.Info("leader elector started", "ttl", le.coord.ttl, "singletons", len(le.workers))
+}
+
+// Stop stops the singletons if this replica leads, and releases the lease
+// so another replica takes over without waiting for it to expire.
+func (le *LeaderElector) Stop() {
+	if le.cancel != nil {
+		le.cancel()
+	}
+	le.wg.Wait()
+
+	if !le.stepDown("shutting down") {
+		return
+	}
+	if err := le.coord.store.ReleaseLease(context.Background(), coordination.LeaderLease, le.coord.holder); err != nil {
+		le.logger.Warn("failed to release leader lease", "error", err)
+	}
+}
+
+// IsLeader reports whether this replica runs the singletons.
+func (le *LeaderElector) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.leading
+}
+
+// Holder returns the replica's holder ID.
+func (le *LeaderElector) Holder() string {
+	return le.coord.Holder()
+}
+
+func (le *LeaderElector) run() {
+	defer le.wg.Done()
+
+	ticker := time.NewTicker(coordination.RenewInterval(le.coord.ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-le.ctx.Done():
+			return
+		case <-ticker.C:
+			le.campaign()
+		}
+	}
+}
+
+// campaign takes or renews the leader lease and starts or stops the
+// singletons to match.
+func (le *LeaderElector) campaign() {
+	lease, err := le.coord.store.AcquireLease(le.ctx, coordination.LeaderLease, le.coord.holder, le.coord.ttl)
+	now := time.Now()
+
+	switch {
+	case err == nil:
+		le.mu.Lock()
+		le.renewedAt = now
+		leading := le.leading
+		if leading {
+			le.deadline.Reset(coordination.RenewDeadline(le.coord.ttl))
+		}
+		le.mu.Unlock()
+		if !leading {
+			le.stepUp(lease.Token, now)
+		}
+	case errors.Is(err, coordination.ErrLeaseHeld):
+		le.stepDown("lease taken by " + lease.Holder)
+	default:
+		if le.ctx.Err() != nil {
+			return
+		}
+		// The deadline timer steps down if renewals keep failing
+		le.logger.Error("failed to renew leader lease", "error", err)
+	}
+}
+
+// stepUp starts the singletons under the lease renewed at renewedAt.
+func (le *LeaderElector) stepUp(token int64, renewedAt time.Time) {
+	le.transition.Lock()
+	defer le.transition.Unlock()
+
+	le.logger.Info("became leader, starting singletons", "token", token)
+	for _, w := range le.workers {
+		le.logger.Debug("starting singleton", "name", w.name)
+		w.worker.Start()
+	}
+	le.mu.Lock()
+	le.leading = true
+	// Renewals may have been attempted while the singletons started
+	le.deadline = time.AfterFunc(time.Until(renewedAt.Add(coordination.RenewDeadline(le.coord.ttl))), le.missedDeadline)
+	le.mu.Unlock()
+}
+
+// missedDeadline steps down when the lease was not renewed in time.
+func (le *LeaderElector) missedDeadline() {
+	le.mu.Lock()
+	late := time.Since(le.renewedAt) >= coordination.RenewDeadline(le.coord.ttl)
+	le.mu.Unlock()
+	if late {
+		le.stepDown("lease could not be renewed")
+	}
+}
+
+// stepDown stops the singletons if this replica leads, reporting whether it
+// did. Every singleton is told to stop at once, so one busy singleton does
+// not hold up cancelling the others.
+func (le *LeaderElector) stepDown(reason string) bool {
+	le.transition.Lock()
+	defer le.transition.Unlock()
+
+	le.mu.Lock()
+	leading := le.leading
+	le.leading = false
+	if le.deadline != nil {
+		le.deadline.Stop()
+	}
+	le.mu.Unlock()
+	if !leading {
+		return false
+	}
+
+	le.logger.Warn("stopping singletons", "reason", reason)
+	var wg sync.WaitGroup
+	for _, w := range le.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			le.logger.Debug("stopping singleton", "name", w.name)
+			w.worker.Stop()
+		}()
+	}
+	wg.Wait()
+	return true
+}
+
+// =============================================================================
+// Deployment Locks
+// =============================================================================
+
+// deploymentLocked runs a deployment command under the deployment's lock,
+// so replicas never operate on one deployment at once. Without a
+// coordinator the command runs unlocked.
+func deploymentLocked(h Handler) Handler {
+	return func(ctx context.Context, deps *Deps, data map[string]any) error {
+		coord := getCoordinator(deps)
+		refID := strVal(data["reference_id"])
+		if coord == nil || refID == "" {
+			return h(ctx, deps, data)
+		}
+		return coord.WithLock(ctx, coordination.LockName("deployments", refID), func(ctx context.Context) error {
+			return h(ctx, deps, data)
+		})
+	}
+}
+
+func getCoordinator(deps *Deps) *Coordinator {
+	if c, ok := deps.Extra["coordinator"].(*Coordinator); ok {
+		return c
+	}
+	return nil
+}
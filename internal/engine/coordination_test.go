@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Leader Election Tests
+// =============================================================================
+
+// busySingleton is slow to stop: Stop returns once release is closed.
+type busySingleton struct {
+	release chan struct{}
+}
+
+func (b *busySingleton) Start() {}
+func (b *busySingleton) Stop()  { <-b.release }
+
+type electionTest struct {
+	store     *Store
+	elector   *LeaderElector
+	busy      *busySingleton
+	cancelled chan time.Time
+}
+
+// newElectionTest starts a leader with a busy singleton and a loop that
+// reports when it is cancelled.
+func newElectionTest(t *testing.T) *electionTest {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	et := &electionTest{
+		store:     store,
+		elector:   NewLeaderElector(NewCoordinator(store, "replica-a", 600*time.Millisecond, logger), logger),
+		busy:      &busySingleton{release: make(chan struct{})},
+		cancelled: make(chan time.Time, 1),
+	}
+	// The busy singleton stops first, so it would hold up the loop if
+	// singletons were stopped one after another
+	et.elector.Add("busy", et.busy)
+	et.elector.Add("loop", Loop(func(ctx context.Context) {
+		<-ctx.Done()
+		et.cancelled <- time.Now()
+	}))
+	et.elector.Start()
+	t.Cleanup(func() {
+		select {
+		case <-et.busy.release:
+		default:
+			close(et.busy.release)
+		}
+		et.elector.Stop()
+	})
+	require.True(t, et.elector.IsLeader())
+	return et
+}
+
+// leaseExpiry returns when the leader lease expires.
+func (et *electionTest) leaseExpiry(t *testing.T) time.Time {
+	t.Helper()
+	var expires string
+	require.NoError(t, et.store.db.Get(&expires, `SELECT expires_at FROM leases WHERE name = ?`, coordination.LeaderLease))
+	at, err := time.Parse(leaseTimeFormat, expires)
+	require.NoError(t, err)
+	return at
+}
+
+func TestLeaderElector_CancelsBusySingletonsBeforeLeaseLapses(t *testing.T) {
+	et := newElectionTest(t)
+
+	// Renewals start failing; the lease runs out at its last expiry
+	expires := et.leaseExpiry(t)
+	_, err := et.store.db.Exec(`DROP TABLE leases`)
+	require.NoError(t, err)
+
+	select {
+	case at := <-et.cancelled:
+		assert.True(t, at.Before(expires), "singletons are cancelled %s after the lease lapsed", at.Sub(expires))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the singletons were not cancelled")
+	}
+	assert.False(t, et.elector.IsLeader())
+}
+
+func TestLeaderElector_LostLeaseWhileSingletonIsBusy(t *testing.T) {
+	et := newElectionTest(t)
+
+	// Another replica takes the lease
+	_, err := et.store.db.Exec(`UPDATE leases SET holder = 'replica-b', token = token + 1, expires_at = ? WHERE name = ?`,
+		time.Now().UTC().Add(time.Hour).Format(leaseTimeFormat), coordination.LeaderLease)
+	require.NoError(t, err)
+
+	select {
+	case <-et.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the singletons were not cancelled")
+	}
+	assert.False(t, et.elector.IsLeader(), "the replica stops leading while the busy singleton winds down")
+
+	close(et.busy.release)
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, et.elector.IsLeader(), "the replica does not lead again while another holds the lease")
+}
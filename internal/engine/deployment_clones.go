@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Deployment Clones
+// =============================================================================
+//
+// A clone is a new deployment of the same template version with a copy of
+// another deployment's configuration, e.g. a staging copy of a production
+// app. It is created through the deployments resource's hooks, so plan
+// limits and quotas apply as to any new deployment, and it is owned by the
+// caller. Domains, ports and history are not copied: the clone gets its own
+// auto domain when it is scheduled.
+//
+// With copy_volumes, the source's volumes are copied into the clone's by a
+// volume migration with a clone_deployment_id, on the same node or another,
+// and the clone is started once they are verified. The source must stay
+// stopped meanwhile, as for any volume migration.
+
+// clonedFields are the deployment fields a clone copies.
+var clonedFields = []string{
+	"template_id", "template_version", "variables", "service_overrides", "routing_options", "labels",
+	"resources_cpu_cores", "resources_memory_mb", "resources_disk_mb", "location",
+	"upgrade_policy", "maintenance_windows", "upgrade_strategy", "canary_policy", "schedule",
+}
+
+// deploymentCloneHandler handles POST /deployments/{id}/clone.
+func deploymentCloneHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		source, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		// The clone gets the source's variables, which may hold secrets
+		if !authorizeDeployment(w, r, cfg, source, sharing.PermManage) {
+			return
+		}
+		switch status := strVal(source["status"]); status {
+		case "deleting", "deleted":
+			writeProblem(w, r, ProblemInvalidState, "cannot clone a deployment that is "+status)
+			return
+		}
+
+		var req struct {
+			Name        string `json:"name"`
+			NodeID      string `json:"node_id"`
+			CopyVolumes bool   `json:"copy_volumes"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+		}
+		if req.Name == "" {
+			req.Name = strVal(source["name"]) + "-clone"
+		}
+		sourceNode := strVal(source["node_id"])
+		if req.NodeID == "" {
+			req.NodeID = sourceNode
+		}
+
+		if req.NodeID != "" {
+			node, err := cfg.Store.Get(ctx, "nodes", req.NodeID)
+			if err != nil || !nodeVisibility(ctx, authCtx, node) {
+				writeProblem(w, r, ProblemNotFound, "node not found")
+				return
+			}
+			if req.CopyVolumes {
+				if status := strVal(node["status"]); status != "online" {
+					writeProblem(w, r, ProblemInvalidState, "target node is "+status+", not online")
+					return
+				}
+			}
+		}
+
+		if req.CopyVolumes {
+			if sourceNode == "" {
+				writeProblem(w, r, ProblemInvalidState, "deployment has no node to copy volumes from")
+				return
+			}
+			// Volume data must be quiescent while it is archived
+			if requireQuiescent(source) != nil {
+				writeProblem(w, r, ProblemInvalidState, "stop the deployment before cloning its volumes")
+				return
+			}
+			if migrating, err := cfg.Store.HasActiveVolumeMigration(ctx, strVal(source["reference_id"])); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to check volume migrations")
+				return
+			} else if migrating {
+				writeProblem(w, r, ProblemOperationInProgress, "a volume migration of this deployment is in progress")
+				return
+			}
+			if !requireNoDeploymentMigration(w, r, cfg, source, "clone") {
+				return
+			}
+		}
+
+		data := map[string]any{"name": req.Name}
+		for _, f := range clonedFields {
+			if v, ok := source[f]; ok && v != nil {
+				data[f] = v
+			}
+		}
+		if req.NodeID != "" {
+			data["node_id"] = req.NodeID
+		}
+		res := cfg.Store.Resource("deployments")
+		clone, err := createRow(ctx, APIConfig{Store: cfg.Store, Logger: cfg.Logger}, res, authCtx, data)
+		if err != nil {
+			writeOpError(w, r, err)
+			return
+		}
+		cloneRef := strVal(clone["reference_id"])
+
+		if !req.CopyVolumes {
+			row, cmd, err := cfg.Store.Transition(ctx, "deployments", cloneRef, "scheduled")
+			if err != nil {
+				writeProblem(w, r, ProblemInvalidState, err.Error())
+				return
+			}
+			if cmd != "" && cfg.Bus != nil {
+				if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+					cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
+				}
+			}
+			stripFields(res, row, cfg.Store, authCtx)
+			writeJSON(w, http.StatusCreated, map[string]any{
+				"data": renderResource(r, cfg.Store, "deployments", row),
+			})
+			return
+		}
+
+		m := &VolumeMigration{
+			DeploymentID:      strVal(source["reference_id"]),
+			SourceNodeID:      sourceNode,
+			TargetNodeID:      req.NodeID,
+			RequestedBy:       int64(authCtx.UserID),
+			CloneDeploymentID: cloneRef,
+		}
+		if err := cfg.Store.CreateVolumeMigration(ctx, m); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to create volume migration")
+			return
+		}
+		if row, err := cfg.Store.Get(ctx, "deployments", cloneRef); err == nil {
+			stripFields(res, row, cfg.Store, authCtx)
+			clone = row
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"data":     renderResource(r, cfg.Store, "deployments", clone),
+			"included": []map[string]any{volumeMigrationJSONAPI(m)},
+		})
+	}
+}
+
+// EnableClones makes the migrator start clones through bus once their
+// volumes are copied.
+func (vm *VolumeMigrator) EnableClones(bus *Bus) {
+	vm.cloneBus = bus
+}
+
+// startClone schedules a clone whose volumes were copied.
+func (vm *VolumeMigrator) startClone(ctx context.Context, m *VolumeMigration, logger *slog.Logger) {
+	if vm.cloneBus == nil {
+		logger.Info("clone volumes copied; start the clone to run it", "clone", m.CloneDeploymentID)
+		return
+	}
+	row, cmd, err := vm.store.Transition(ctx, "deployments", m.CloneDeploymentID, "scheduled")
+	if err != nil {
+		logger.Error("failed to schedule clone", "clone", m.CloneDeploymentID, "error", err)
+		return
+	}
+	if cmd != "" {
+		if _, err := vm.cloneBus.Enqueue(ctx, cmd, row); err != nil {
+			logger.Error("command enqueue failed", "command", cmd, "clone", m.CloneDeploymentID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Deployment Clone Tests
+// =============================================================================
+
+type cloneTest struct {
+	store  *Store
+	cfg    SetupConfig
+	owner  int
+	other  int
+	source map[string]any
+	node   string
+}
+
+func newCloneTest(t *testing.T) *cloneTest {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	ct := &cloneTest{
+		store: store,
+		cfg:   SetupConfig{Store: store, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+	}
+	for i, email := range []string{"owner@example.com", "other@example.com"} {
+		res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES (?, ?)`, "user_"+email[:5], email)
+		require.NoError(t, err)
+		id, _ := res.LastInsertId()
+		if i == 0 {
+			ct.owner = int(id)
+		} else {
+			ct.other = int(id)
+		}
+	}
+
+	node, err := store.Create(ctx, "nodes", map[string]any{
+		"name": "edge-1", "creator_id": ct.owner, "ssh_host": "10.0.0.1", "ssh_user": "root", "status": "online",
+	})
+	require.NoError(t, err)
+	ct.node = strVal(node["reference_id"])
+
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Clone Test", "creator_id": ct.owner, "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n    volumes:\n      - data:/data\nvolumes:\n  data:\n",
+	})
+	require.NoError(t, err)
+	tmplID, _ := toInt64(tmpl["id"])
+	ct.source, err = store.Create(ctx, "deployments", map[string]any{
+		"name": "shop", "customer_id": ct.owner, "template_id": tmplID, "template_version": "1.0.0",
+		"node_id": ct.node, "status": "running",
+		"variables": map[string]any{"DB_PASSWORD": "secret"},
+		"labels":    map[string]string{"env": "production"},
+		"domains":   []map[string]any{{"hostname": "shop.example.com"}},
+	})
+	require.NoError(t, err)
+	return ct
+}
+
+// clone calls POST /deployments/{id}/clone as userID.
+func (ct *cloneTest) clone(userID int, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/deployments/"+strVal(ct.source["reference_id"])+"/clone", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": strVal(ct.source["reference_id"])})
+	req = req.WithContext(WithAuth(req.Context(), AuthContext{Authenticated: true, UserID: userID}))
+	rec := httptest.NewRecorder()
+	deploymentCloneHandler(ct.cfg)(rec, req)
+	return rec
+}
+
+func cloneRef(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var doc struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.NotEmpty(t, doc.Data.ID)
+	return doc.Data.ID
+}
+
+func TestDeploymentClone_RequiresManageAccess(t *testing.T) {
+	ct := newCloneTest(t)
+
+	rec := ct.clone(ct.other, `{}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var count int
+	require.NoError(t, ct.store.db.Get(&count, `SELECT COUNT(*) FROM deployments`))
+	assert.Equal(t, 1, count, "no clone is created")
+}
+
+func TestDeploymentClone_CopiesConfiguration(t *testing.T) {
+	ct := newCloneTest(t)
+	ctx := context.Background()
+
+	rec := ct.clone(ct.owner, `{}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	ref := cloneRef(t, rec)
+	assert.NotEqual(t, strVal(ct.source["reference_id"]), ref, "the clone is a new deployment")
+
+	source, err := ct.store.Get(ctx, "deployments", strVal(ct.source["reference_id"]))
+	require.NoError(t, err)
+	clone, err := ct.store.Get(ctx, "deployments", ref)
+	require.NoError(t, err)
+	assert.Equal(t, "shop-clone", clone["name"])
+	assert.Equal(t, "scheduled", clone["status"])
+	assert.Equal(t, ct.node, clone["node_id"])
+	for _, f := range []string{"template_id", "template_version", "variables", "labels"} {
+		assert.Equal(t, source[f], clone[f], f)
+	}
+	assert.Empty(t, clone["domains"], "the clone gets its own domains when scheduled")
+}
+
+func TestDeploymentClone_CopyVolumesRequiresStoppedSource(t *testing.T) {
+	ct := newCloneTest(t)
+
+	rec := ct.clone(ct.owner, `{"copy_volumes": true}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+}
+
+func TestDeploymentClone_VolumeCopyFailure(t *testing.T) {
+	ct := newCloneTest(t)
+	ctx := context.Background()
+	sourceRef := strVal(ct.source["reference_id"])
+	_, err := ct.store.Update(ctx, "deployments", sourceRef, map[string]any{"status": "stopped"})
+	require.NoError(t, err)
+
+	rec := ct.clone(ct.owner, `{"name": "shop-staging", "copy_volumes": true}`)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	ref := cloneRef(t, rec)
+
+	ms, err := ct.store.ListVolumeMigrations(ctx, sourceRef, 10)
+	require.NoError(t, err)
+	require.Len(t, ms, 1)
+	assert.Equal(t, ref, ms[0].CloneDeploymentID)
+
+	// The source is started again before its volumes are archived
+	_, err = ct.store.Update(ctx, "deployments", sourceRef, map[string]any{"status": "running"})
+	require.NoError(t, err)
+	vm := NewVolumeMigrator(ct.store, nil, 0, 0, ct.cfg.Logger)
+	vm.Migrate(ctx, ms[0])
+
+	ms, err = ct.store.ListVolumeMigrations(ctx, sourceRef, 10)
+	require.NoError(t, err)
+	assert.Equal(t, string(transfer.StatusFailed), ms[0].Status)
+	clone, err := ct.store.Get(ctx, "deployments", ref)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", clone["status"], "a clone whose volumes weren't copied isn't started")
+	assert.Contains(t, strVal(clone["error_message"]), "volume copy failed")
+}
@@ -0,0 +1,30 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/artpar/hoster/internal/core/validation"
+)
+
+// =============================================================================
+// Deployment Labels
+// =============================================================================
+
+// A deployment's labels are customer-defined key/value pairs, such as a team
+// or cost center, that operators aggregate container metrics by. They are
+// added to every container of the deployment under the hoster.user. prefix
+// when the containers are created, so changes apply on the next start or
+// upgrade.
+
+// validateDeploymentLabels checks a deployment's labels field: an object of
+// string values within the key, value and count limits.
+func validateDeploymentLabels(v any) error {
+	var labels map[string]string
+	if err := decodeJSONValue(v, &labels); err != nil {
+		return fmt.Errorf("labels must be an object of string values")
+	}
+	if field, msg := validation.ValidateDeploymentLabels(labels); field != "" {
+		return fmt.Errorf("%s: %s", field, msg)
+	}
+	return nil
+}
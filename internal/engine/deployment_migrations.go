@@ -0,0 +1,853 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/relocation"
+	"github.com/artpar/hoster/internal/core/scheduler"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Deployment Migration Storage
+// =============================================================================
+//
+// deployment_migrations holds one row per request by a deployment's owner to
+// move it to another node or region. Unlike a volume migration, which only
+// moves a stopped deployment's volumes, a deployment migration runs the whole
+// move: it stops the deployment, migrates its volumes through a volume
+// migration, starts it on the target node, switches its routes and removes
+// what is left on the source node. A step that fails is rolled back and the
+// deployment is started on its source node again. Each step is recorded on
+// the deployment's event timeline.
+
+// DeploymentMigration is a request to move a deployment to another node.
+type DeploymentMigration struct {
+	ID                int64          `db:"id"`
+	ReferenceID       string         `db:"reference_id"`
+	DeploymentID      string         `db:"deployment_id"`
+	SourceNodeID      string         `db:"source_node_id"`
+	TargetNodeID      string         `db:"target_node_id"`
+	Region            string         `db:"region"` // Requested region, empty for an explicit node
+	RequestedBy       int64          `db:"requested_by"`
+	Status            string         `db:"status"`      // relocation.Status
+	FailedStep        string         `db:"failed_step"` // Step being rolled back
+	WasRunning        bool           `db:"was_running"`
+	SourceProxyPort   sql.NullInt64  `db:"source_proxy_port"`
+	VolumeMigrationID string         `db:"volume_migration_id"`
+	TransferPercent   int            `db:"transfer_percent"`
+	ErrorMessage      string         `db:"error_message"`
+	CreatedAt         string         `db:"created_at"`
+	UpdatedAt         string         `db:"updated_at"`
+	StartedAt         sql.NullString `db:"started_at"`
+	CompletedAt       sql.NullString `db:"completed_at"`
+}
+
+const deploymentMigrationColumns = `id, reference_id, deployment_id, source_node_id, target_node_id, region,
+	requested_by, status, failed_step, was_running, source_proxy_port, volume_migration_id, transfer_percent,
+	error_message, created_at, updated_at, started_at, completed_at`
+
+// CreateDeploymentMigration inserts a pending migration and fills in its IDs.
+func (s *Store) CreateDeploymentMigration(ctx context.Context, m *DeploymentMigration) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	m.ReferenceID = "dmig_" + uuid.New().String()[:8]
+	m.Status = string(relocation.StatusPending)
+	m.CreatedAt, m.UpdatedAt = now, now
+
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO deployment_migrations (reference_id, deployment_id, source_node_id, target_node_id, region,
+			requested_by, status, created_at, updated_at)
+		VALUES (:reference_id, :deployment_id, :source_node_id, :target_node_id, :region,
+			:requested_by, :status, :created_at, :updated_at)`, m)
+	if err != nil {
+		return fmt.Errorf("create deployment migration: %w", err)
+	}
+	m.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// SaveDeploymentMigration writes a migration's step and progress.
+func (s *Store) SaveDeploymentMigration(ctx context.Context, m *DeploymentMigration) error {
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE deployment_migrations SET status = :status, failed_step = :failed_step, was_running = :was_running,
+			source_proxy_port = :source_proxy_port, volume_migration_id = :volume_migration_id,
+			transfer_percent = :transfer_percent, error_message = :error_message,
+			updated_at = :updated_at, started_at = :started_at, completed_at = :completed_at
+		WHERE id = :id`, m)
+	if err != nil {
+		return fmt.Errorf("save deployment migration: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectDeploymentMigrations(ctx context.Context, query string, args ...any) ([]*DeploymentMigration, error) {
+	var out []*DeploymentMigration
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+deploymentMigrationColumns+` FROM deployment_migrations `+query, args...); err != nil {
+		return nil, fmt.Errorf("query deployment migrations: %w", err)
+	}
+	return out, nil
+}
+
+// ListDeploymentMigrations returns a deployment's migrations, newest first.
+func (s *Store) ListDeploymentMigrations(ctx context.Context, deploymentID string, limit int) ([]*DeploymentMigration, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.selectDeploymentMigrations(ctx, `WHERE deployment_id = ? ORDER BY id DESC LIMIT ?`, deploymentID, limit)
+}
+
+// LatestDeploymentMigration returns a deployment's most recent migration, or nil.
+func (s *Store) LatestDeploymentMigration(ctx context.Context, deploymentID string) (*DeploymentMigration, error) {
+	ms, err := s.ListDeploymentMigrations(ctx, deploymentID, 1)
+	if err != nil || len(ms) == 0 {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// HasActiveDeploymentMigration reports whether a migration still owns the deployment.
+func (s *Store) HasActiveDeploymentMigration(ctx context.Context, deploymentID string) (bool, error) {
+	m, err := s.LatestDeploymentMigration(ctx, deploymentID)
+	if err != nil {
+		return false, err
+	}
+	return m != nil && relocation.Status(m.Status).Active(), nil
+}
+
+// ListActiveDeploymentMigrations returns the migrations that are not
+// finished, oldest first.
+func (s *Store) ListActiveDeploymentMigrations(ctx context.Context) ([]*DeploymentMigration, error) {
+	return s.selectDeploymentMigrations(ctx, `WHERE status NOT IN (?, ?, ?) ORDER BY id`,
+		relocation.StatusCompleted, relocation.StatusRolledBack, relocation.StatusFailed)
+}
+
+// getVolumeMigration returns a volume migration by reference ID.
+func (s *Store) getVolumeMigration(ctx context.Context, refID string) (*VolumeMigration, error) {
+	ms, err := s.selectVolumeMigrations(ctx, `WHERE reference_id = ?`, refID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("volume migration %s: %w", refID, ErrNotFound)
+	}
+	return ms[0], nil
+}
+
+// deploymentMigrationJSONAPI renders a migration as a JSON:API resource object.
+func deploymentMigrationJSONAPI(m *DeploymentMigration) map[string]any {
+	return map[string]any{
+		"type": "deployment-migrations",
+		"id":   m.ReferenceID,
+		"attributes": map[string]any{
+			"deployment_id":       m.DeploymentID,
+			"source_node_id":      m.SourceNodeID,
+			"target_node_id":      m.TargetNodeID,
+			"region":              m.Region,
+			"status":              m.Status,
+			"failed_step":         m.FailedStep,
+			"progress_percent":    relocation.Progress(relocation.Status(m.Status), m.TransferPercent),
+			"volume_migration_id": m.VolumeMigrationID,
+			"error_message":       m.ErrorMessage,
+			"created_at":          m.CreatedAt,
+			"updated_at":          m.UpdatedAt,
+			"started_at":          m.StartedAt.String,
+			"completed_at":        m.CompletedAt.String,
+		},
+	}
+}
+
+// =============================================================================
+// Deployment Migration Handler
+// =============================================================================
+
+// deploymentMigrationHandler handles POST and GET /deployments/{id}/migrate.
+// GET lists the deployment's migrations or, with Accept: text/event-stream,
+// streams the progress of the latest one.
+func deploymentMigrationHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		refID := strVal(depl["reference_id"])
+
+		if r.Method == http.MethodGet {
+			if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
+				return
+			}
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				streamDeploymentMigration(w, r, cfg, refID)
+				return
+			}
+			limit := 20
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+					limit = n
+				}
+			}
+			ms, err := cfg.Store.ListDeploymentMigrations(ctx, refID, limit)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list deployment migrations")
+				return
+			}
+			data := make([]map[string]any, 0, len(ms))
+			for _, m := range ms {
+				data = append(data, deploymentMigrationJSONAPI(m))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+			return
+		}
+
+		// Moving a deployment changes where it runs and what it costs
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+
+		var req struct {
+			TargetNodeID string `json:"target_node_id"`
+			Region       string `json:"region"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		req.Region = strings.TrimSpace(req.Region)
+		if (req.TargetNodeID == "") == (req.Region == "") {
+			writeProblem(w, r, ProblemValidationFailed, "exactly one of target_node_id and region is required")
+			return
+		}
+
+		switch status := strVal(depl["status"]); status {
+		case "running", "stopped", "failed":
+		default:
+			writeProblem(w, r, ProblemInvalidState, "cannot migrate deployment in state: "+status)
+			return
+		}
+		sourceNode := strVal(depl["node_id"])
+		if sourceNode == "" {
+			writeProblem(w, r, ProblemInvalidState, "deployment has no node to migrate from")
+			return
+		}
+		if req.TargetNodeID == sourceNode {
+			writeProblem(w, r, ProblemValidationFailed, "target node is the deployment's current node")
+			return
+		}
+
+		if !requireNoDeploymentMigration(w, r, cfg, depl, "migrate") {
+			return
+		}
+		if migrating, err := cfg.Store.HasActiveVolumeMigration(ctx, refID); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to check volume migrations")
+			return
+		} else if migrating {
+			writeProblem(w, r, ProblemOperationInProgress, "a volume migration of this deployment is in progress")
+			return
+		}
+
+		target, err := migrationTarget(ctx, cfg.Store, depl, req.TargetNodeID, req.Region)
+		if errors.Is(err, ErrNotFound) {
+			writeProblem(w, r, ProblemNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+
+		m := &DeploymentMigration{
+			DeploymentID: refID,
+			SourceNodeID: sourceNode,
+			TargetNodeID: target,
+			Region:       req.Region,
+			RequestedBy:  int64(authCtx.UserID),
+		}
+		if err := cfg.Store.CreateDeploymentMigration(ctx, m); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to create deployment migration")
+			return
+		}
+		recordMigrationEvent(ctx, cfg.Store, depl, domain.EventMigrationStep,
+			fmt.Sprintf("Migration %s to node %s requested", m.ReferenceID, target))
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": deploymentMigrationJSONAPI(m)})
+	}
+}
+
+// migrationTarget picks the node to move a deployment to: the named node,
+// the best node in the region, or with neither the best other node, among the
+// nodes the deployment may be placed on. The target must have room for the deployment and what it needs.
+func migrationTarget(ctx context.Context, store *Store, depl map[string]any, nodeID, region string) (string, error) {
+	nodes, err := candidateNodes(ctx, store, depl)
+	if err != nil {
+		return "", err
+	}
+	source := strVal(depl["node_id"])
+	var eligible []domain.Node
+	for _, n := range nodes {
+		if n.ReferenceID == source {
+			continue
+		}
+		if nodeID != "" && n.ReferenceID != nodeID {
+			continue
+		}
+		if region != "" && !relocation.InRegion(n.Location, region) {
+			continue
+		}
+		eligible = append(eligible, n)
+	}
+	if len(eligible) == 0 {
+		if nodeID != "" {
+			return "", fmt.Errorf("target node %s: %w", nodeID, ErrNotFound)
+		}
+		if region == "" {
+			return "", fmt.Errorf("no other node: %w", ErrNotFound)
+		}
+		return "", fmt.Errorf("no nodes in region %s: %w", region, ErrNotFound)
+	}
+
+	req := schedulingRequest(ctx, store, depl, nil)
+	req.AvailableNodes = eligible
+	result, err := scheduler.Schedule(req)
+	if err != nil {
+		if nodeID != "" {
+			return "", fmt.Errorf("cannot migrate to node %s: %w", nodeID, err)
+		}
+		if region == "" {
+			return "", fmt.Errorf("cannot migrate to another node: %w", err)
+		}
+		return "", fmt.Errorf("cannot migrate to region %s: %w", region, err)
+	}
+	return result.SelectedNodeID, nil
+}
+
+// streamDeploymentMigration streams the latest migration of a deployment as
+// server-sent events: a progress event whenever it changes and a done event
+// once it has finished.
+func streamDeploymentMigration(w http.ResponseWriter, r *http.Request, cfg SetupConfig, deploymentID string) {
+	ctx := r.Context()
+	m, err := cfg.Store.LatestDeploymentMigration(ctx, deploymentID)
+	if err != nil {
+		writeProblem(w, r, ProblemInternal, "failed to load deployment migrations")
+		return
+	}
+	if m == nil {
+		writeProblem(w, r, ProblemNotFound, "deployment has no migrations")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last string
+	for {
+		payload, _ := json.Marshal(deploymentMigrationJSONAPI(m))
+		done := !relocation.Status(m.Status).Active()
+		_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if string(payload) != last {
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", payload)
+			last = string(payload)
+		}
+		if done {
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", payload)
+		}
+		if err := rc.Flush(); err != nil || done {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ms, err := cfg.Store.selectDeploymentMigrations(ctx, `WHERE id = ?`, m.ID)
+		if err != nil || len(ms) == 0 {
+			return
+		}
+		m = ms[0]
+	}
+}
+
+// requireNoDeploymentMigration rejects an action on a deployment that a
+// deployment migration is moving, which starts and stops it itself.
+func requireNoDeploymentMigration(w http.ResponseWriter, r *http.Request, cfg SetupConfig, depl map[string]any, action string) bool {
+	migrating, err := cfg.Store.HasActiveDeploymentMigration(r.Context(), strVal(depl["reference_id"]))
+	if err != nil {
+		writeProblem(w, r, ProblemInternal, "failed to check deployment migrations")
+		return false
+	}
+	if migrating {
+		writeProblem(w, r, ProblemOperationInProgress, "cannot "+action+" deployment while it is being migrated")
+		return false
+	}
+	return true
+}
+
+// recordMigrationEvent adds a migration step to the deployment's event timeline.
+func recordMigrationEvent(ctx context.Context, store *Store, depl map[string]any, eventType domain.ContainerEventType, message string) {
+	deplID, _ := toInt64(depl["id"])
+	event := domain.NewContainerEvent("", int(deplID), eventType, "", message)
+	_ = store.CreateContainerEvent(ctx, &event) // best effort
+}
+
+// =============================================================================
+// Deployment Migrator Worker
+// =============================================================================
+
+// DeploymentMigrator runs deployment migrations step by step. Each run
+// advances every unfinished migration as far as it can without waiting;
+// the volume transfer is left to the VolumeMigrator and picked up again on
+// a later run. Migrations interrupted by a shutdown resume from their step.
+type DeploymentMigrator struct {
+	store    *Store
+	bus      *Bus
+	nodePool *docker.NodePool
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewDeploymentMigrator(store *Store, bus *Bus, nodePool *docker.NodePool, interval time.Duration, logger *slog.Logger) *DeploymentMigrator {
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	return &DeploymentMigrator{
+		store:    store,
+		bus:      bus,
+		nodePool: nodePool,
+		interval: interval,
+		logger:   logger.With("component", "deployment_migrator"),
+	}
+}
+
+func (dm *DeploymentMigrator) Start() {
+	dm.ctx, dm.cancel = context.WithCancel(context.Background())
+	dm.wg.Add(1)
+	go dm.run()
+	dm.logger.Info("deployment migrator started", "interval", dm.interval)
+}
+
+func (dm *DeploymentMigrator) Stop() {
+	if dm.cancel != nil {
+		dm.cancel()
+	}
+	dm.wg.Wait()
+}
+
+func (dm *DeploymentMigrator) run() {
+	defer dm.wg.Done()
+	dm.runActive()
+
+	ticker := time.NewTicker(dm.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+			dm.runActive()
+		}
+	}
+}
+
+func (dm *DeploymentMigrator) runActive() {
+	defer dm.store.LoopMetrics().Time("deployment_migrator")()
+	dm.advanceDrains(dm.ctx)
+	ms, err := dm.store.ListActiveDeploymentMigrations(dm.ctx)
+	if err != nil {
+		dm.logger.Error("failed to list deployment migrations", "error", err)
+		return
+	}
+	for _, m := range ms {
+		if dm.ctx.Err() != nil {
+			return
+		}
+		dm.Advance(dm.ctx, m)
+	}
+}
+
+// Advance moves a migration through its steps until it has to wait or has
+// finished. A step that fails starts the rollback. If ctx is cancelled the
+// migration is left at its step so the next run resumes it.
+func (dm *DeploymentMigrator) Advance(ctx context.Context, m *DeploymentMigration) {
+	logger := dm.logger.With("migration", m.ReferenceID, "deployment", m.DeploymentID,
+		"source_node", m.SourceNodeID, "target_node", m.TargetNodeID)
+
+	for relocation.Status(m.Status).Active() {
+		depl, err := dm.store.Get(ctx, "deployments", m.DeploymentID)
+		if err != nil {
+			if ctx.Err() == nil {
+				dm.finish(ctx, m, relocation.StatusFailed, "deployment not found", nil, logger)
+			}
+			return
+		}
+
+		if m.Status == string(relocation.StatusRollingBack) {
+			dm.rollback(ctx, m, depl, logger)
+			return
+		}
+
+		from := m.Status
+		next, err := dm.step(ctx, m, depl, logger)
+		if ctx.Err() != nil {
+			logger.Info("deployment migration interrupted, will resume", "status", m.Status)
+			return
+		}
+		if err != nil {
+			logger.Error("deployment migration failed", "step", from, "error", err)
+			m.FailedStep = from
+			m.ErrorMessage = err.Error()
+			m.Status = string(relocation.StatusRollingBack)
+			if err := dm.store.SaveDeploymentMigration(ctx, m); err != nil {
+				logger.Error("failed to record deployment migration failure", "error", err)
+				return
+			}
+			recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationFailed,
+				fmt.Sprintf("Migration %s failed while %s: %v; rolling back", m.ReferenceID, stepName(from), err))
+			continue
+		}
+		if next == "" {
+			return // waiting
+		}
+		if string(next) == from {
+			continue // the step ran a command; look at the deployment again
+		}
+
+		m.Status = string(next)
+		if next == relocation.StatusCompleted {
+			dm.finish(ctx, m, next, "", depl, logger)
+			return
+		}
+		if err := dm.store.SaveDeploymentMigration(ctx, m); err != nil {
+			logger.Error("failed to save deployment migration", "error", err)
+			return
+		}
+		recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationStep,
+			fmt.Sprintf("Migration %s: %s", m.ReferenceID, stepName(string(next))))
+	}
+}
+
+// step runs the migration's current step. It returns the next step, or ""
+// when the step has to wait for something else to finish.
+func (dm *DeploymentMigrator) step(ctx context.Context, m *DeploymentMigration, depl map[string]any, logger *slog.Logger) (relocation.Status, error) {
+	status := strVal(depl["status"])
+	switch relocation.Status(m.Status) {
+	case relocation.StatusPending:
+		if node := strVal(depl["node_id"]); node != m.SourceNodeID {
+			return "", fmt.Errorf("deployment moved to node %s before the migration started", node)
+		}
+		m.WasRunning = status == "running"
+		m.SourceProxyPort = sql.NullInt64{}
+		if port, ok := toInt64(depl["proxy_port"]); ok && port > 0 {
+			m.SourceProxyPort = sql.NullInt64{Int64: port, Valid: true}
+		}
+		m.StartedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+		return relocation.StatusStopping, nil
+
+	case relocation.StatusStopping:
+		switch status {
+		case "running":
+			if err := dm.transition(ctx, m.DeploymentID, "stopping"); err != nil {
+				return "", fmt.Errorf("stop deployment: %w", err)
+			}
+			return relocation.StatusStopping, nil
+		case "stopping":
+			return "", nil
+		}
+		if err := requireQuiescent(depl); err != nil {
+			return "", err
+		}
+		if m.VolumeMigrationID == "" {
+			vm := &VolumeMigration{
+				DeploymentID: m.DeploymentID,
+				SourceNodeID: m.SourceNodeID,
+				TargetNodeID: m.TargetNodeID,
+				RequestedBy:  m.RequestedBy,
+				SwitchNode:   true,
+			}
+			if err := dm.store.CreateVolumeMigration(ctx, vm); err != nil {
+				return "", err
+			}
+			m.VolumeMigrationID = vm.ReferenceID
+		}
+		return relocation.StatusTransferring, nil
+
+	case relocation.StatusTransferring:
+		vm, err := dm.store.getVolumeMigration(ctx, m.VolumeMigrationID)
+		if err != nil {
+			return "", err
+		}
+		progress := transfer.Progress{TotalBytes: vm.TotalBytes, TransferredBytes: vm.TransferredBytes}
+		switch transfer.Status(vm.Status) {
+		case transfer.StatusCompleted:
+			m.TransferPercent = 100
+			if m.WasRunning {
+				return relocation.StatusStarting, nil
+			}
+			return relocation.StatusSwitchingRoutes, nil
+		case transfer.StatusFailed:
+			return "", fmt.Errorf("volume migration %s failed: %s", vm.ReferenceID, vm.ErrorMessage)
+		}
+		if percent := int(progress.Percent()); percent != m.TransferPercent {
+			m.TransferPercent = percent
+			if err := dm.store.SaveDeploymentMigration(ctx, m); err != nil {
+				logger.Warn("failed to save migration progress", "error", err)
+			}
+		}
+		return "", nil
+
+	case relocation.StatusStarting:
+		if node := strVal(depl["node_id"]); node != m.TargetNodeID {
+			return "", fmt.Errorf("deployment is on node %s, not the target node", node)
+		}
+		switch status {
+		case "running":
+			return relocation.StatusSwitchingRoutes, nil
+		case "starting":
+			return "", nil
+		case "stopped":
+			if err := dm.transition(ctx, m.DeploymentID, "starting"); err != nil {
+				return "", fmt.Errorf("start deployment on target node: %w", err)
+			}
+			return relocation.StatusStarting, nil
+		}
+		if msg := strVal(depl["error_message"]); msg != "" {
+			return "", fmt.Errorf("deployment failed to start on target node: %s", msg)
+		}
+		return "", fmt.Errorf("deployment is %s on target node", status)
+
+	case relocation.StatusSwitchingRoutes:
+		// Routes follow the deployment's node; drop every cached one
+		dm.store.notifyChange("deployments", m.DeploymentID)
+		dm.cleanNode(ctx, m, depl, m.SourceNodeID, logger)
+		return relocation.StatusCompleted, nil
+	}
+	return "", fmt.Errorf("unknown migration step %q", m.Status)
+}
+
+// rollback undoes a failed migration: it removes what was created on the
+// target node, moves the deployment back to its source node and starts it
+// there again if it was running.
+func (dm *DeploymentMigrator) rollback(ctx context.Context, m *DeploymentMigration, depl map[string]any, logger *slog.Logger) {
+	plan := relocation.PlanRollback(relocation.Status(m.FailedStep), m.WasRunning)
+	reason := m.ErrorMessage
+
+	err := func() error {
+		if plan.SwitchBack && strVal(depl["node_id"]) == m.TargetNodeID {
+			switch strVal(depl["status"]) {
+			case "running":
+				if err := dm.transition(ctx, m.DeploymentID, "stopping"); err != nil {
+					return fmt.Errorf("stop deployment on target node: %w", err)
+				}
+			case "starting", "stopping":
+				return errWaiting
+			}
+		}
+		if plan.CleanTarget {
+			dm.cleanNode(ctx, m, depl, m.TargetNodeID, logger)
+		}
+		if plan.SwitchBack {
+			if err := dm.switchBack(ctx, m); err != nil {
+				return err
+			}
+		}
+
+		depl, err := dm.store.Get(ctx, "deployments", m.DeploymentID)
+		if err != nil {
+			return err
+		}
+		if node := strVal(depl["node_id"]); node != m.SourceNodeID {
+			return fmt.Errorf("deployment is on node %s, not its source node", node)
+		}
+		if !plan.Restart {
+			return nil
+		}
+		switch strVal(depl["status"]) {
+		case "running":
+			return nil
+		case "starting", "stopping":
+			return errWaiting
+		}
+		if err := dm.transition(ctx, m.DeploymentID, "starting"); err != nil {
+			return fmt.Errorf("restart deployment on source node: %w", err)
+		}
+		if depl, err = dm.store.Get(ctx, "deployments", m.DeploymentID); err != nil {
+			return err
+		}
+		if status := strVal(depl["status"]); status != "running" {
+			return fmt.Errorf("deployment is %s after restarting on source node", status)
+		}
+		return nil
+	}()
+	if errors.Is(err, errWaiting) || ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logger.Error("deployment migration rollback failed", "error", err)
+		dm.finish(ctx, m, relocation.StatusFailed, fmt.Sprintf("%s; rollback failed: %v", reason, err), depl, logger)
+		return
+	}
+	logger.Info("deployment migration rolled back", "failed_step", m.FailedStep)
+	dm.finish(ctx, m, relocation.StatusRolledBack, reason, depl, logger)
+}
+
+// errWaiting stops a rollback until the deployment settles.
+var errWaiting = errors.New("waiting for deployment")
+
+// switchBack points the deployment at its source node again, with the proxy
+// port it had there.
+func (dm *DeploymentMigrator) switchBack(ctx context.Context, m *DeploymentMigration) error {
+	err := dm.store.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var row struct {
+			Status string `db:"status"`
+			NodeID string `db:"node_id"`
+		}
+		if err := tx.GetContext(ctx, &row,
+			`SELECT status, COALESCE(node_id, '') AS node_id FROM deployments WHERE reference_id = ?`,
+			m.DeploymentID); err != nil {
+			return fmt.Errorf("load deployment: %w", err)
+		}
+		if row.NodeID != m.TargetNodeID {
+			return nil // never switched
+		}
+		if err := requireQuiescent(map[string]any{"status": row.Status}); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE deployments SET node_id = ?, proxy_port = ?, updated_at = ? WHERE reference_id = ?`,
+			m.SourceNodeID, m.SourceProxyPort, time.Now().UTC().Format(time.RFC3339), m.DeploymentID); err != nil {
+			return fmt.Errorf("switch deployment back to source node: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	dm.store.notifyChange("deployments", m.DeploymentID)
+	return nil
+}
+
+// cleanNode removes the deployment's containers, network and migrated
+// volumes from a node it no longer runs on. Failures are logged and
+// recorded on the timeline; they do not fail the migration.
+func (dm *DeploymentMigrator) cleanNode(ctx context.Context, m *DeploymentMigration, depl map[string]any, nodeID string, logger *slog.Logger) {
+	if dm.nodePool == nil {
+		return
+	}
+	client, err := dm.nodePool.GetClient(ctx, nodeID)
+	if err != nil {
+		logger.Warn("failed to clean up node", "node_id", nodeID, "error", err)
+		recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationStep,
+			fmt.Sprintf("Migration %s could not clean up node %s: %v", m.ReferenceID, nodeID, err))
+		return
+	}
+	var configDir string
+	if dm.bus != nil {
+		configDir, _ = dm.bus.deps.Extra["config_dir"].(string)
+	}
+	orchestrator := docker.NewOrchestrator(client, logger, configDir, nil)
+	if err := orchestrator.RemoveDeployment(ctx, mapToDeployment(depl)); err != nil {
+		logger.Warn("failed to remove deployment containers", "node_id", nodeID, "error", err)
+	}
+
+	if m.VolumeMigrationID == "" {
+		return
+	}
+	vm, err := dm.store.getVolumeMigration(ctx, m.VolumeMigrationID)
+	if err != nil {
+		logger.Warn("failed to load migrated volumes", "error", err)
+		return
+	}
+	for _, v := range vm.Volumes {
+		if err := client.RemoveVolume(v.Docker, true); err != nil && !errors.Is(err, docker.ErrVolumeNotFound) {
+			logger.Warn("failed to remove volume", "node_id", nodeID, "volume", v.Docker, "error", err)
+		}
+	}
+}
+
+// transition moves the deployment to state and runs the command it triggers
+// to completion.
+func (dm *DeploymentMigrator) transition(ctx context.Context, deploymentID, state string) error {
+	row, cmd, err := dm.store.Transition(ctx, "deployments", deploymentID, state)
+	if err != nil {
+		return err
+	}
+	if cmd != "" && dm.bus != nil {
+		return dm.bus.Dispatch(ctx, cmd, row)
+	}
+	return nil
+}
+
+// finish records a migration's final status.
+func (dm *DeploymentMigrator) finish(ctx context.Context, m *DeploymentMigration, status relocation.Status, message string, depl map[string]any, logger *slog.Logger) {
+	m.Status = string(status)
+	m.ErrorMessage = message
+	m.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	if err := dm.store.SaveDeploymentMigration(ctx, m); err != nil {
+		logger.Error("failed to save deployment migration", "error", err)
+	}
+	if depl == nil {
+		return
+	}
+	switch status {
+	case relocation.StatusCompleted:
+		logger.Info("deployment migration completed")
+		recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationCompleted,
+			fmt.Sprintf("Migration %s completed; deployment now runs on node %s", m.ReferenceID, m.TargetNodeID))
+	case relocation.StatusRolledBack:
+		recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationRolledBack,
+			fmt.Sprintf("Migration %s rolled back; deployment stays on node %s", m.ReferenceID, m.SourceNodeID))
+	default:
+		recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationFailed,
+			fmt.Sprintf("Migration %s failed: %s", m.ReferenceID, message))
+	}
+}
+
+// stepName describes a migration step for the timeline.
+func stepName(status string) string {
+	switch relocation.Status(status) {
+	case relocation.StatusPending:
+		return "preparing"
+	case relocation.StatusStopping:
+		return "stopping the deployment"
+	case relocation.StatusTransferring:
+		return "migrating volumes"
+	case relocation.StatusStarting:
+		return "starting on the target node"
+	case relocation.StatusSwitchingRoutes:
+		return "switching routes"
+	}
+	return strings.ReplaceAll(status, "_", " ")
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrDomainConflict is returned when a hostname is already claimed by another deployment.
+var ErrDomainConflict = errors.New("hostname already in use")
+
+// =============================================================================
+// Hostname Claims (deployment_domains)
+// =============================================================================
+//
+// deployments.domains (JSON) remains the source for display and DNS instructions.
+// deployment_domains is a normalized index with a UNIQUE hostname constraint so
+// two deployments can never claim the same hostname.
+
+// ClaimDomain records that a deployment owns a hostname.
+// Returns ErrDomainConflict if another deployment already owns it.
+// Claiming a hostname the deployment already owns is a no-op.
+func (s *Store) ClaimDomain(ctx context.Context, deploymentID int, hostname, domainType string) error {
+	host := domain.NormalizeHostname(hostname)
+	if host == "" {
+		return fmt.Errorf("%w: hostname is required", ErrValidation)
+	}
+
+	owner, err := s.DomainOwner(ctx, host)
+	if err == nil {
+		if owner == deploymentID {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrDomainConflict, host)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO deployment_domains (deployment_id, hostname, type) VALUES (?, ?, ?)`,
+		deploymentID, host, domainType)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("%w: %s", ErrDomainConflict, host)
+		}
+		return fmt.Errorf("claim domain: %w", err)
+	}
+	return nil
+}
+
+// ReleaseDomain removes a deployment's claim on a hostname.
+func (s *Store) ReleaseDomain(ctx context.Context, deploymentID int, hostname string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM deployment_domains WHERE deployment_id = ? AND hostname = ?`,
+		deploymentID, domain.NormalizeHostname(hostname))
+	return err
+}
+
+// ReleaseDeploymentDomains removes all hostname claims held by a deployment.
+func (s *Store) ReleaseDeploymentDomains(ctx context.Context, deploymentID int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM deployment_domains WHERE deployment_id = ?`, deploymentID)
+	return err
+}
+
+// DomainOwner returns the internal ID of the deployment that owns a hostname.
+func (s *Store) DomainOwner(ctx context.Context, hostname string) (int, error) {
+	var id int
+	err := s.db.GetContext(ctx, &id,
+		`SELECT deployment_id FROM deployment_domains WHERE hostname = ?`, domain.NormalizeHostname(hostname))
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return 0, fmt.Errorf("hostname %s: %w", hostname, ErrNotFound)
+		}
+		return 0, fmt.Errorf("get domain owner: %w", err)
+	}
+	return id, nil
+}
+
+// =============================================================================
+// Backfill Migration
+// =============================================================================
+
+// backfillDeploymentDomains populates deployment_domains from existing
+// deployments.domains JSON the first time the table is created. Older
+// deployments win conflicts; the losing deployment has the duplicate hostname
+// removed from its domains JSON so routing becomes deterministic.
+func backfillDeploymentDomains(db *sqlx.DB, logger *slog.Logger) error {
+	var existing int
+	if err := db.Get(&existing, `SELECT COUNT(*) FROM deployment_domains`); err != nil {
+		return fmt.Errorf("count deployment_domains: %w", err)
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	type deplRow struct {
+		ID      int            `db:"id"`
+		RefID   string         `db:"reference_id"`
+		Domains sql.NullString `db:"domains"`
+	}
+	var rows []deplRow
+	if err := db.Select(&rows,
+		`SELECT id, reference_id, domains FROM deployments WHERE status != 'deleted' ORDER BY id ASC`); err != nil {
+		return fmt.Errorf("list deployments: %w", err)
+	}
+
+	var claims []domain.DomainClaim
+	types := map[string]string{}
+	for _, r := range rows {
+		for _, d := range parseDomainsList(r.Domains.String) {
+			claims = append(claims, domain.DomainClaim{DeploymentID: r.ID, Hostname: d.Hostname})
+			types[domain.NormalizeHostname(d.Hostname)] = d.Type
+		}
+	}
+
+	kept, dropped := domain.ResolveDomainClaims(claims)
+	for _, c := range kept {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO deployment_domains (deployment_id, hostname, type) VALUES (?, ?, ?)`,
+			c.DeploymentID, c.Hostname, types[c.Hostname]); err != nil {
+			return fmt.Errorf("backfill %s: %w", c.Hostname, err)
+		}
+	}
+
+	// Strip duplicates from losing deployments' JSON
+	droppedByDepl := map[int]map[string]bool{}
+	for _, c := range dropped {
+		if droppedByDepl[c.DeploymentID] == nil {
+			droppedByDepl[c.DeploymentID] = map[string]bool{}
+		}
+		droppedByDepl[c.DeploymentID][c.Hostname] = true
+	}
+	for _, r := range rows {
+		hosts, ok := droppedByDepl[r.ID]
+		if !ok {
+			continue
+		}
+		var filtered []DomainInfo
+		for _, d := range parseDomainsList(r.Domains.String) {
+			if hosts[domain.NormalizeHostname(d.Hostname)] {
+				logger.Warn("removing duplicate hostname from deployment",
+					"deployment", r.RefID, "hostname", d.Hostname)
+				continue
+			}
+			filtered = append(filtered, d)
+		}
+		domainsJSON, _ := json.Marshal(filtered)
+		if _, err := db.Exec(`UPDATE deployments SET domains = ? WHERE id = ?`, string(domainsJSON), r.ID); err != nil {
+			return fmt.Errorf("dedupe domains for %s: %w", r.RefID, err)
+		}
+	}
+
+	if len(kept) > 0 {
+		logger.Info("backfilled deployment_domains", "hostnames", len(kept), "duplicates_removed", len(dropped))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/artpar/hoster/internal/core/compose"
+)
+
+// =============================================================================
+// Docker Engine Compatibility
+// =============================================================================
+//
+// The health checker records each node's Docker Engine and API version. When
+// a deployment is scheduled, the compose features its template uses are
+// checked against its node's API version, so a node too old for them fails the
+// deployment with an explanation instead of a daemon error when containers
+// are created.
+
+// checkNodeEngine returns a *compose.IncompatibleEngineError when the
+// deployment's template uses compose features the node's Docker engine doesn't
+// support. Nodes whose version hasn't been recorded yet, and specs that don't
+// parse, pass; the orchestrator reports the latter.
+func checkNodeEngine(ctx context.Context, store *Store, data, node map[string]any) error {
+	api, err := compose.ParseAPIVersion(strVal(node["docker_api_version"]))
+	if err != nil {
+		return nil
+	}
+	tmpl, err := store.GetByID(ctx, "templates", toInt(data["template_id"]))
+	if err != nil {
+		return nil
+	}
+	spec, err := compose.ParseComposeSpec(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return nil
+	}
+	return compose.CheckEngineCompatibility(spec, api, strVal(node["docker_version"]))
+}
@@ -0,0 +1,352 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/sharing"
+)
+
+// =============================================================================
+// Change Events
+// =============================================================================
+//
+// GET /events streams the store's writes to the rows a user can see as
+// server-sent events, so clients don't have to poll: their own rows, and
+// deployments shared with them. The hub only fans out which row changed;
+// each stream loads the row itself and drops rows its user can't see, so a
+// slow client never holds up a write.
+
+// DefaultEventResources are streamed when a client names none.
+var DefaultEventResources = []string{"deployments", "nodes"}
+
+const (
+	// eventBuffer is how many changes a stream can fall behind by before it
+	// asks its client to resync.
+	eventBuffer = 256
+	// eventKeepAlive is how often an idle stream sends a comment, so proxies
+	// don't close it.
+	eventKeepAlive = 25 * time.Second
+)
+
+// EventHub fans the store's change and transition hooks out to event streams.
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]struct{}
+}
+
+// storeEvent is a change to one row. From and To are set for transitions.
+type storeEvent struct {
+	Resource string
+	RefID    string
+	From, To string
+}
+
+type eventSubscriber struct {
+	resources []string
+	ch        chan storeEvent
+	// overflow is set when a change was dropped because ch was full.
+	overflow atomic.Bool
+}
+
+// NewEventHub creates a hub fed by the store's writes.
+func NewEventHub(store *Store) *EventHub {
+	h := &EventHub{subs: make(map[*eventSubscriber]struct{})}
+	store.OnChange(func(resource, refID string) {
+		h.publish(storeEvent{Resource: resource, RefID: refID})
+	})
+	store.OnTransition(func(_ context.Context, resource string, row map[string]any, from, to string) {
+		h.publish(storeEvent{Resource: resource, RefID: strVal(row["reference_id"]), From: from, To: to})
+	})
+	return h
+}
+
+// publish hands ev to every subscriber of its resource without blocking.
+func (h *EventHub) publish(ev storeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !slices.Contains(sub.resources, ev.Resource) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.overflow.Store(true)
+		}
+	}
+}
+
+// subscribe registers a subscriber of resources. Call unsubscribe when done.
+func (h *EventHub) subscribe(resources []string) *eventSubscriber {
+	sub := &eventSubscriber{resources: resources, ch: make(chan storeEvent, eventBuffer)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *EventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// parseEventResources parses the resources query parameter. Only resources
+// with an owner can be streamed, since events are filtered by owner.
+func parseEventResources(store *Store, param string) ([]string, error) {
+	if strings.TrimSpace(param) == "" {
+		return DefaultEventResources, nil
+	}
+	var resources []string
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(resources, name) {
+			continue
+		}
+		res := store.Resource(name)
+		if res == nil {
+			return nil, fmt.Errorf("unknown resource %q", name)
+		}
+		if res.Owner == "" {
+			return nil, fmt.Errorf("resource %q has no owner and cannot be streamed", name)
+		}
+		resources = append(resources, name)
+	}
+	if len(resources) == 0 {
+		return DefaultEventResources, nil
+	}
+	return resources, nil
+}
+
+// eventStream tracks which rows of a stream's resources its user can see.
+type eventStream struct {
+	cfg     SetupConfig
+	authCtx AuthContext
+	// visible holds the rows the client has been sent or had when the
+	// stream opened, by resource and reference_id, so deletions of rows
+	// that are gone can still be attributed.
+	visible map[string]map[string]bool
+	// access caches whether the user may see each row a change was seen
+	// for, so a row is authorized once rather than on every change. Owners
+	// don't change; who a deployment is shared with does, so the cached
+	// deployments are dropped when collaborators change (see forgetShared).
+	access map[string]map[string]bool
+}
+
+// loadVisible records the user's existing rows and the deployments shared
+// with them.
+func (s *eventStream) loadVisible(ctx context.Context, resources []string) error {
+	s.visible = make(map[string]map[string]bool, len(resources))
+	s.access = make(map[string]map[string]bool, len(resources))
+	for _, name := range resources {
+		res := s.cfg.Store.Resource(name)
+		var refIDs []string
+		query := fmt.Sprintf("SELECT reference_id FROM %s WHERE %s = ?", name, res.Owner)
+		if err := s.cfg.Store.DB().SelectContext(ctx, &refIDs, query, s.authCtx.UserID); err != nil {
+			return fmt.Errorf("load %s: %w", name, err)
+		}
+		if name == "deployments" {
+			var shared []struct {
+				DeploymentID string `db:"deployment_id"`
+				Role         string `db:"role"`
+			}
+			if err := s.cfg.Store.DB().SelectContext(ctx, &shared,
+				`SELECT deployment_id, role FROM deployment_collaborators WHERE user_id = ? AND status = ?`,
+				s.authCtx.UserID, sharing.StatusAccepted); err != nil {
+				return fmt.Errorf("load shared deployments: %w", err)
+			}
+			for _, c := range shared {
+				if sharing.Role(c.Role).Can(sharing.PermView) {
+					refIDs = append(refIDs, c.DeploymentID)
+				}
+			}
+		}
+		s.visible[name] = make(map[string]bool, len(refIDs))
+		s.access[name] = make(map[string]bool, len(refIDs))
+		for _, id := range refIDs {
+			s.visible[name][id] = true
+			s.access[name][id] = true
+		}
+	}
+	return nil
+}
+
+// forgetShared drops the cached access to deployments, after someone was
+// invited to, accepted or was removed from one.
+func (s *eventStream) forgetShared() {
+	if _, ok := s.access["deployments"]; ok {
+		s.access["deployments"] = map[string]bool{}
+	}
+}
+
+// canView reports whether the user may see row: they own it, or it is a
+// deployment shared with them. A failed lookup is not cached.
+func (s *eventStream) canView(ctx context.Context, res *Resource, row map[string]any) (allowed, cache bool) {
+	if res.Name != "deployments" {
+		ownerID, ok := toInt64(row[res.Owner])
+		return ok && int(ownerID) == s.authCtx.UserID, true
+	}
+	role, err := deploymentRole(ctx, s.cfg.Store, row, s.authCtx)
+	if err != nil && !errors.Is(err, errUnparseableOwner) {
+		s.cfg.Logger.Warn("event stream access check failed", "deployment", strVal(row["reference_id"]), "error", err)
+		return false, false
+	}
+	return role.Can(sharing.PermView), true
+}
+
+// render returns the event name and JSON:API document for ev, or false when
+// the user can't see the row.
+func (s *eventStream) render(ctx context.Context, r *http.Request, ev storeEvent) (string, map[string]any, bool) {
+	res := s.cfg.Store.Resource(ev.Resource)
+	visible := s.visible[ev.Resource]
+	access := s.access[ev.Resource]
+	meta := map[string]any{"resource": ev.Resource, "id": ev.RefID}
+
+	allowed, cached := access[ev.RefID]
+	if cached && !allowed {
+		return "", nil, false
+	}
+	row, err := s.cfg.Store.Get(ctx, ev.Resource, ev.RefID)
+	if err != nil {
+		if !isNotFoundErr(err) || !visible[ev.RefID] {
+			return "", nil, false
+		}
+		delete(visible, ev.RefID)
+		delete(access, ev.RefID)
+		meta["action"] = "deleted"
+		return "deleted", map[string]any{
+			"data": map[string]any{"type": ev.Resource, "id": ev.RefID},
+			"meta": meta,
+		}, true
+	}
+
+	if !cached {
+		var cache bool
+		allowed, cache = s.canView(ctx, res, row)
+		if cache {
+			access[ev.RefID] = allowed
+		}
+		if !allowed {
+			// A deployment no longer shared with the user is not theirs to follow
+			delete(visible, ev.RefID)
+			return "", nil, false
+		}
+	}
+
+	name := "updated"
+	switch {
+	case ev.To != "":
+		name = "transition"
+		meta["from"] = ev.From
+		meta["to"] = ev.To
+	case !visible[ev.RefID]:
+		name = "created"
+		visible[ev.RefID] = true
+	}
+	meta["action"] = name
+
+	if res.AfterRead != nil {
+		res.AfterRead(ctx, s.authCtx, []map[string]any{row})
+	}
+	stripFields(res, row, s.cfg.Store, s.authCtx)
+	return name, map[string]any{
+		"data": renderResource(r, s.cfg.Store, ev.Resource, row),
+		"meta": meta,
+	}, true
+}
+
+// eventsHandler handles GET /events?resources=deployments,nodes.
+func eventsHandler(cfg SetupConfig, hub *EventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		resources, err := parseEventResources(cfg.Store, r.URL.Query().Get("resources"))
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		// Subscribe before loading visible rows, so no change falls in
+		// between. A stream of deployments follows collaborators too, to
+		// learn when a deployment is shared with or unshared from its user.
+		subscribed := resources
+		if slices.Contains(resources, "deployments") && !slices.Contains(resources, "deployment_collaborators") {
+			subscribed = append(slices.Clone(resources), "deployment_collaborators")
+		}
+		sub := hub.subscribe(subscribed)
+		defer hub.unsubscribe(sub)
+		stream := &eventStream{cfg: cfg, authCtx: authCtx}
+		if err := stream.loadVisible(ctx, resources); err != nil {
+			cfg.Logger.Error("failed to load event stream rows", "error", err)
+			writeProblem(w, r, ProblemInternal, "failed to open event stream")
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		write := func(event string, doc any) error {
+			payload, _ := json.Marshal(doc)
+			_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			return rc.Flush()
+		}
+		if err := write("ready", map[string]any{"meta": map[string]any{"resources": resources}}); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-keepAlive.C:
+				_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case ev := <-sub.ch:
+				if sub.overflow.Swap(false) {
+					// Changes were dropped; the client must refetch what it shows
+					if err := stream.loadVisible(ctx, resources); err != nil {
+						return
+					}
+					if err := write("resync", map[string]any{"meta": map[string]any{"resources": resources}}); err != nil {
+						return
+					}
+				}
+				if ev.Resource == "deployment_collaborators" {
+					stream.forgetShared()
+					if !slices.Contains(resources, ev.Resource) {
+						continue
+					}
+				}
+				event, doc, ok := stream.render(ctx, r, ev)
+				if !ok {
+					continue
+				}
+				if err := write(event, doc); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
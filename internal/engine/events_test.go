@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Change Event Tests
+// =============================================================================
+
+func newEventTest(t *testing.T) (*Store, *EventHub, []int) {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	var users []int
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES (?, ?)`, "user_"+email[:3], email)
+		require.NoError(t, err)
+		id, _ := res.LastInsertId()
+		users = append(users, int(id))
+	}
+	return store, NewEventHub(store), users
+}
+
+func createEventNode(t *testing.T, store *Store, name string, creatorID int) string {
+	t.Helper()
+	node, err := store.Create(context.Background(), "nodes", map[string]any{
+		"name": name, "creator_id": creatorID, "ssh_host": "10.0.0.1", "ssh_user": "root",
+	})
+	require.NoError(t, err)
+	return strVal(node["reference_id"])
+}
+
+// sseEvent is one event read from a stream.
+type sseEvent struct {
+	Name string
+	Data map[string]any
+}
+
+// openEventStream opens GET /events as userID and returns its events. The
+// stream closes when cancel is called.
+func openEventStream(t *testing.T, store *Store, hub *EventHub, userID int, resources string) (<-chan sseEvent, context.CancelFunc) {
+	t.Helper()
+	cfg := SetupConfig{Store: store, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	handler := eventsHandler(cfg, hub)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(WithAuth(r.Context(), AuthContext{Authenticated: true, UserID: userID})))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events?resources="+resources, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.Name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.Data)
+			case line == "" && ev.Name != "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		require.True(t, ok, "stream closed")
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+		return sseEvent{}
+	}
+}
+
+func TestEventHub_FiltersByResource(t *testing.T) {
+	store, hub, users := newEventTest(t)
+	sub := hub.subscribe([]string{"nodes"})
+	defer hub.unsubscribe(sub)
+
+	_, err := store.Create(context.Background(), "templates", map[string]any{
+		"name": "Event Test", "creator_id": users[0], "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n",
+	})
+	require.NoError(t, err)
+	nodeRef := createEventNode(t, store, "edge-1", users[0])
+
+	require.Len(t, sub.ch, 1, "only changes to subscribed resources are delivered")
+	ev := <-sub.ch
+	assert.Equal(t, "nodes", ev.Resource)
+	assert.Equal(t, nodeRef, ev.RefID)
+}
+
+func TestEventHub_Overflow(t *testing.T) {
+	store, hub, users := newEventTest(t)
+	sub := hub.subscribe([]string{"nodes"})
+	defer hub.unsubscribe(sub)
+
+	nodeRef := createEventNode(t, store, "edge-1", users[0])
+	for range eventBuffer {
+		hub.publish(storeEvent{Resource: "nodes", RefID: nodeRef})
+	}
+	assert.Len(t, sub.ch, eventBuffer)
+	assert.True(t, sub.overflow.Load(), "a full subscriber is flagged instead of blocking the write")
+}
+
+func TestParseEventResources(t *testing.T) {
+	store, _, _ := newEventTest(t)
+
+	resources, err := parseEventResources(store, "")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultEventResources, resources)
+
+	resources, err = parseEventResources(store, "nodes, deployments,nodes")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nodes", "deployments"}, resources)
+
+	_, err = parseEventResources(store, "widgets")
+	assert.ErrorContains(t, err, "unknown resource")
+}
+
+func TestEventsHandler_OwnerScoped(t *testing.T) {
+	store, hub, users := newEventTest(t)
+	alice, bob := users[0], users[1]
+	events, _ := openEventStream(t, store, hub, alice, "nodes")
+	assert.Equal(t, "ready", nextEvent(t, events).Name)
+
+	// Bob's node changes first, but only Alice's reaches her stream
+	createEventNode(t, store, "bob-edge", bob)
+	aliceRef := createEventNode(t, store, "alice-edge", alice)
+
+	ev := nextEvent(t, events)
+	assert.Equal(t, "created", ev.Name)
+	meta := ev.Data["meta"].(map[string]any)
+	assert.Equal(t, aliceRef, meta["id"])
+
+	_, err := store.Update(context.Background(), "nodes", aliceRef, map[string]any{"name": "alice-edge-2"})
+	require.NoError(t, err)
+	ev = nextEvent(t, events)
+	assert.Equal(t, "updated", ev.Name)
+
+	require.NoError(t, store.Delete(context.Background(), "nodes", aliceRef))
+	ev = nextEvent(t, events)
+	assert.Equal(t, "deleted", ev.Name)
+	assert.Equal(t, aliceRef, ev.Data["meta"].(map[string]any)["id"])
+}
+
+func TestEventsHandler_Disconnect(t *testing.T) {
+	store, hub, users := newEventTest(t)
+	events, cancel := openEventStream(t, store, hub, users[0], "nodes")
+	assert.Equal(t, "ready", nextEvent(t, events).Name)
+
+	subscribers := func() int {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return len(hub.subs)
+	}
+	assert.Equal(t, 1, subscribers())
+
+	cancel()
+	assert.Eventually(t, func() bool { return subscribers() == 0 }, 5*time.Second, 10*time.Millisecond,
+		"a disconnected client is unsubscribed")
+}
+
+func TestEventsHandler_Collaborator(t *testing.T) {
+	store, hub, users := newEventTest(t)
+	alice, bob := users[0], users[1]
+	ctx := context.Background()
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Event Test", "creator_id": alice, "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n",
+	})
+	require.NoError(t, err)
+	createDeployment := func(name string, ownerID int) string {
+		depl, err := store.Create(ctx, "deployments", map[string]any{
+			"name": name, "customer_id": ownerID, "template_id": tmpl["id"], "template_version": "1.0.0",
+		})
+		require.NoError(t, err)
+		return strVal(depl["reference_id"])
+	}
+	share := func(deplRef string) string {
+		collab, err := store.Create(ctx, "deployment_collaborators", map[string]any{
+			"deployment_id": deplRef, "owner_id": alice, "email": "bob@example.com",
+			"role": "viewer", "user_id": bob, "status": "accepted",
+		})
+		require.NoError(t, err)
+		return strVal(collab["reference_id"])
+	}
+	rename := func(deplRef, name string) {
+		_, err := store.Update(ctx, "deployments", deplRef, map[string]any{"name": name})
+		require.NoError(t, err)
+	}
+	eventID := func(ev sseEvent) any { return ev.Data["meta"].(map[string]any)["id"] }
+
+	// Bob can view Alice's shop, not her blog
+	shop := createDeployment("shop", alice)
+	blog := createDeployment("blog", alice)
+	collab := share(shop)
+	events, _ := openEventStream(t, store, hub, bob, "deployments")
+	assert.Equal(t, "ready", nextEvent(t, events).Name)
+
+	rename(blog, "blog-2")
+	rename(shop, "shop-2")
+	ev := nextEvent(t, events)
+	assert.Equal(t, "updated", ev.Name, "a deployment shared when the stream opened is not new")
+	assert.Equal(t, shop, eventID(ev))
+
+	// Once access is revoked, the shop's changes stop
+	require.NoError(t, store.Delete(ctx, "deployment_collaborators", collab))
+	rename(shop, "shop-3")
+	own := createDeployment("bob-app", bob)
+	ev = nextEvent(t, events)
+	assert.Equal(t, "created", ev.Name)
+	assert.Equal(t, own, eventID(ev))
+
+	// Shared again, the shop is new to the stream
+	share(shop)
+	rename(shop, "shop-4")
+	ev = nextEvent(t, events)
+	assert.Equal(t, "created", ev.Name)
+	assert.Equal(t, shop, eventID(ev))
+}
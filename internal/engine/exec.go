@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/containerexec"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Exec Configuration
+// =============================================================================
+//
+// Exec runs a one-off command, such as a database migration, in one of a
+// running deployment's service containers and returns its exit code and
+// output. POST /deployments/{id}/exec sends the command through the node's
+// minion ("exec-container" command). Operators choose which commands may be
+// run with an allowlist; see containerexec.Allowlist.
+
+// ContainerExecutor runs commands in containers on a node.
+// *docker.NodePool implements it via the node's minion.
+type ContainerExecutor interface {
+	ExecContainer(ctx context.Context, nodeID, containerID string, spec minion.ExecSpec) (*minion.ExecResult, error)
+}
+
+// ExecConfig configures deployment exec.
+type ExecConfig struct {
+	// Nodes runs commands on deployments' nodes.
+	Nodes ContainerExecutor
+	// Allowlist is the commands that may be run.
+	Allowlist containerexec.Allowlist
+	// MaxTimeout is the longest a command may run; see containerexec.Check.
+	MaxTimeout time.Duration
+	// MaxOutput caps each of stdout and stderr, in bytes; 0 leaves it to
+	// the minion's limit.
+	MaxOutput int
+}
+
+// execGrace is how much longer than the command's timeout the control
+// plane waits for the minion to report it.
+const execGrace = 30 * time.Second
+
+// =============================================================================
+// Exec Handler
+// =============================================================================
+
+// deploymentExecHandler serves POST /deployments/{id}/exec with body
+// {"service", "command": [...], "stdin", "user", "working_dir", "timeout"}.
+// Only the deployment's owner may run commands. A command that ran answers
+// 200 whatever its exit code; one the allowlist refuses is forbidden.
+func deploymentExecHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.Exec == nil {
+			writeProblem(w, r, ProblemNotConfigured, "deployment exec is disabled")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+
+		var body struct {
+			Service    string   `json:"service"`
+			Command    []string `json:"command"`
+			Stdin      string   `json:"stdin"`
+			User       string   `json:"user"`
+			WorkingDir string   `json:"working_dir"`
+			Timeout    string   `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		req := containerexec.Request{
+			Service:    body.Service,
+			Command:    body.Command,
+			Stdin:      body.Stdin,
+			User:       body.User,
+			WorkingDir: body.WorkingDir,
+		}
+		if body.Timeout != "" {
+			if req.Timeout, err = time.ParseDuration(body.Timeout); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, "timeout: "+err.Error())
+				return
+			}
+		}
+		timeout, err := containerexec.Check(req, cfg.Exec.Allowlist, cfg.Exec.MaxTimeout)
+		if errors.Is(err, containerexec.ErrNotAllowed) {
+			writeProblem(w, r, ProblemForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		if status := strVal(depl["status"]); status != "running" {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("deployment is %s, not running", status))
+			return
+		}
+		nodeID := strVal(depl["node_id"])
+		var containers []domain.ContainerInfo
+		decodeJSONValue(depl["containers"], &containers)
+		var containerID string
+		for _, c := range containers {
+			if c.ServiceName == req.Service {
+				containerID = c.ID
+				break
+			}
+		}
+		if containerID == "" {
+			writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("deployment has no running service %q", req.Service))
+			return
+		}
+		if nodeID == "" {
+			writeProblem(w, r, ProblemInvalidState, "deployment is not on a remote node")
+			return
+		}
+
+		cfg.Logger.Info("deployment exec", "deployment", id, "user_id", authCtx.UserID,
+			"service", req.Service, "command", req.Command, "timeout", timeout)
+
+		execCtx, cancel := context.WithTimeout(ctx, timeout+execGrace)
+		defer cancel()
+		result, err := cfg.Exec.Nodes.ExecContainer(execCtx, nodeID, containerID, minion.ExecSpec{
+			Command:    req.Command,
+			Stdin:      req.Stdin,
+			User:       req.User,
+			WorkingDir: req.WorkingDir,
+			Timeout:    timeout,
+			MaxOutput:  cfg.Exec.MaxOutput,
+		})
+		switch {
+		case errors.Is(err, docker.ErrContainerNotRunning), errors.Is(err, docker.ErrContainerNotFound):
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("service %q is not running: %v", req.Service, err))
+			return
+		case err != nil:
+			cfg.Logger.Error("deployment exec failed", "deployment", id, "service", req.Service, "error", err)
+			writeProblem(w, r, ProblemUpstreamFailed, "exec failed: "+err.Error())
+			return
+		}
+
+		cfg.Logger.Info("deployment exec finished", "deployment", id, "service", req.Service,
+			"exit_code", result.ExitCode, "timed_out", result.TimedOut, "duration", result.Duration)
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+			"type": "exec_results",
+			"attributes": map[string]any{
+				"deployment_id": id,
+				"service":       req.Service,
+				"command":       req.Command,
+				"exit_code":     result.ExitCode,
+				"stdout":        result.Stdout,
+				"stderr":        result.Stderr,
+				"duration_ms":   result.Duration.Milliseconds(),
+				"timed_out":     result.TimedOut,
+				"truncated":     result.Truncated,
+			},
+		}})
+	}
+}
@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Deployment Expiry
+// =============================================================================
+//
+// Trial deployments carry expires_at and delete_at, set at creation from the
+// customer's plan (trial_days) or the template's trial offer. A deployment's
+// expiry runs as a chain of ExpireDeployment commands scheduled under the key
+// "expire:<deployment>": warnings ahead of expiry, a stop at expires_at and a
+// delete at delete_at. Each step schedules the next, so the chain survives
+// restarts. Converting the deployment clears the expiry and cancels the chain.
+
+// expiryRetryDelay is how long a step waits for a deployment that is
+// starting or stopping to settle.
+const expiryRetryDelay = time.Minute
+
+func expiryKey(refID string) string {
+	return "expire:" + refID
+}
+
+// deploymentExpiry reads a deployment row's expiry. ok is false when the
+// deployment is not a trial.
+func deploymentExpiry(row map[string]any) (coredeployment.Expiry, bool) {
+	expires, ok := parseTime(row["expires_at"])
+	if !ok {
+		return coredeployment.Expiry{}, false
+	}
+	e := coredeployment.Expiry{
+		ExpiresAt: expires,
+		Source:    coredeployment.TrialSource(strVal(row["trial_source"])),
+	}
+	if e.DeleteAt, ok = parseTime(row["delete_at"]); !ok {
+		e.DeleteAt = expires.AddDate(0, 0, coredeployment.DefaultTrialGraceDays)
+	}
+	return e, true
+}
+
+// templateTrial reads a template's trial offer; nil when it has none.
+func templateTrial(v any) (*coredeployment.TrialConfig, error) {
+	var c coredeployment.TrialConfig
+	if err := decodeJSONValue(v, &c); err != nil {
+		return nil, fmt.Errorf("invalid trial: %w", err)
+	}
+	if c == (coredeployment.TrialConfig{}) {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// validateTemplateTrial checks a template's trial offer.
+func validateTemplateTrial(v any) error {
+	c, err := templateTrial(v)
+	if err != nil || c == nil {
+		return err
+	}
+	return coredeployment.ValidateTrialConfig(*c)
+}
+
+// applyTrialExpiry sets a new deployment's expiry from the customer's plan
+// and the template's trial offer.
+func applyTrialExpiry(ctx context.Context, store *Store, authCtx AuthContext, data map[string]any, now time.Time) error {
+	var trial *coredeployment.TrialConfig
+	if tid, ok := toInt64(data["template_id"]); ok && tid > 0 {
+		if tmpl, err := store.GetByID(ctx, "templates", int(tid)); err == nil {
+			if trial, err = templateTrial(tmpl["trial"]); err != nil {
+				return err
+			}
+		}
+	}
+	e, ok := coredeployment.TrialExpiry(now.UTC(), authCtx.PlanLimits.TrialDays, trial)
+	if !ok {
+		return nil
+	}
+	data["expires_at"] = e.ExpiresAt.Format(time.RFC3339)
+	data["delete_at"] = e.DeleteAt.Format(time.RFC3339)
+	data["trial_source"] = string(e.Source)
+	return nil
+}
+
+// scheduleExpiry schedules the first expiry step after the given time. It
+// does nothing for deployments that are not trials.
+func scheduleExpiry(ctx context.Context, bus *Bus, depl map[string]any, after time.Time) error {
+	e, ok := deploymentExpiry(depl)
+	if !ok {
+		return nil
+	}
+	step, ok := coredeployment.NextExpiryStep(e, after)
+	if !ok {
+		return nil
+	}
+	return dispatchExpiryStep(ctx, bus, depl, e, step)
+}
+
+func dispatchExpiryStep(ctx context.Context, bus *Bus, depl map[string]any, e coredeployment.Expiry, step coredeployment.ExpiryStep) error {
+	refID := strVal(depl["reference_id"])
+	_, err := bus.DispatchAt(ctx, expiryKey(refID), step.At, "ExpireDeployment", map[string]any{
+		"reference_id": refID,
+		"stage":        string(step.Stage),
+		"at":           step.At.UTC().Format(time.RFC3339),
+		"expires_at":   e.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+	return err
+}
+
+// expireDeployment returns the ExpireDeployment handler: it runs one expiry
+// step and schedules the next. A step for an expiry that has since been
+// converted or changed does nothing.
+func expireDeployment(bus *Bus) Handler {
+	return func(ctx context.Context, deps *Deps, data map[string]any) error {
+		store := deps.Store
+		refID := strVal(data["reference_id"])
+		stage := coredeployment.ExpiryStage(strVal(data["stage"]))
+
+		depl, err := store.Get(ctx, "deployments", refID)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e, ok := deploymentExpiry(depl)
+		if !ok || e.ExpiresAt.UTC().Format(time.RFC3339) != strVal(data["expires_at"]) {
+			deps.Logger.Debug("expiry no longer applies", "deployment", refID, "stage", stage)
+			return nil
+		}
+		status := strVal(depl["status"])
+		if status == "deleting" || status == "deleted" {
+			return nil
+		}
+		now := time.Now()
+
+		switch stage {
+		case coredeployment.ExpiryWarn:
+			if !e.Expired(now) {
+				if n := getNotifier(deps); n != nil {
+					n.NotifyDeploymentExpiry(ctx, depl, e, now)
+				}
+			}
+		case coredeployment.ExpiryStop:
+			switch status {
+			case "running":
+				if _, err := transitionAndDispatch(ctx, bus, refID, "stopping"); err != nil {
+					return err
+				}
+			case "scheduled", "starting", "stopping":
+				return retryExpiryStep(ctx, bus, depl, e, stage, now)
+			}
+			deps.Logger.Info("trial deployment expired", "deployment", refID, "delete_at", e.DeleteAt)
+			if n := getNotifier(deps); n != nil {
+				n.NotifyDeploymentExpiry(ctx, depl, e, now)
+			}
+		case coredeployment.ExpiryDelete:
+			switch status {
+			case "running":
+				if _, err := transitionAndDispatch(ctx, bus, refID, "stopping"); err != nil {
+					return err
+				}
+				return retryExpiryStep(ctx, bus, depl, e, stage, now)
+			case "stopped", "failed":
+				if _, err := transitionAndDispatch(ctx, bus, refID, "deleting"); err != nil {
+					return err
+				}
+			case "pending":
+				// Never placed, so there is nothing to clean up
+				if err := store.Delete(ctx, "deployments", refID); err != nil {
+					return err
+				}
+			default:
+				return retryExpiryStep(ctx, bus, depl, e, stage, now)
+			}
+			deps.Logger.Info("expired trial deployment deleted", "deployment", refID)
+			return nil
+		default:
+			return fmt.Errorf("unknown expiry stage %q", stage)
+		}
+
+		at, _ := parseTime(data["at"])
+		return scheduleExpiry(ctx, bus, depl, at)
+	}
+}
+
+// retryExpiryStep runs a step again shortly, for a deployment that is
+// between states.
+func retryExpiryStep(ctx context.Context, bus *Bus, depl map[string]any, e coredeployment.Expiry, stage coredeployment.ExpiryStage, now time.Time) error {
+	return dispatchExpiryStep(ctx, bus, depl, e, coredeployment.ExpiryStep{Stage: stage, At: now.Add(expiryRetryDelay)})
+}
+
+// transitionAndDispatch transitions a deployment and runs the command the
+// new state triggers.
+func transitionAndDispatch(ctx context.Context, bus *Bus, refID, to string) (map[string]any, error) {
+	row, cmd, err := bus.deps.Store.Transition(ctx, "deployments", refID, to)
+	if err != nil {
+		return nil, err
+	}
+	if cmd != "" {
+		if err := bus.Dispatch(ctx, cmd, row); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// =============================================================================
+// Conversion Handler
+// =============================================================================
+
+// deploymentConvertHandler handles POST /deployments/{id}/convert: the owner
+// keeps a trial deployment as a paid one. The expiry is cleared and the
+// deployment is billed from then on; an expired deployment stays stopped
+// until it is started again. Customers on a trial plan must upgrade first.
+func deploymentConvertHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+		if s := strVal(depl["status"]); s == "deleting" || s == "deleted" {
+			writeProblem(w, r, ProblemInvalidState, "cannot convert deployment in state: "+s)
+			return
+		}
+		if _, ok := deploymentExpiry(depl); !ok {
+			writeProblem(w, r, ProblemInvalidState, "deployment is not a trial")
+			return
+		}
+		if authCtx.PlanLimits.TrialDays > 0 {
+			writeProblem(w, r, ProblemFeatureNotInPlan, "your plan is a trial; upgrade to a paid plan to keep deployments")
+			return
+		}
+
+		row, err := cfg.Store.Update(ctx, "deployments", id, map[string]any{
+			"expires_at":   nil,
+			"delete_at":    nil,
+			"trial_source": "",
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to convert deployment")
+			return
+		}
+		if cfg.Bus != nil {
+			if _, err := cfg.Bus.Cancel(ctx, expiryKey(strVal(row["reference_id"]))); err != nil {
+				cfg.Logger.Warn("failed to cancel deployment expiry", "deployment", id, "error", err)
+			}
+		}
+		cfg.Logger.Info("trial deployment converted", "deployment", id)
+
+		res := cfg.Store.Resource("deployments")
+		stripFields(res, row, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployments", row),
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/faults"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Fault Injection
+// =============================================================================
+//
+// For chaos testing, a FaultInjector adds latency, timeouts and typed errors
+// to minion commands and store operations by rules administrators manage at
+// /admin/faults. It exists only in binaries built with -tags chaos and when
+// chaos.enabled is set, so production builds can't be made flaky by
+// configuration alone. Rules live in memory and are lost on restart.
+
+// ErrFaultInjected marks the errors injected faults return, so logs can tell
+// them from real failures.
+var ErrFaultInjected = errors.New("injected fault")
+
+// FaultInjector holds the active fault rules and applies them.
+type FaultInjector struct {
+	logger *slog.Logger
+	roll   func() float64
+	sleep  func(ctx context.Context, d time.Duration) error
+
+	mu     sync.Mutex
+	rules  []faults.Rule
+	nextID int
+}
+
+// NewFaultInjector creates a fault injector with no rules.
+func NewFaultInjector(logger *slog.Logger) *FaultInjector {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &FaultInjector{
+		logger: logger.With("component", "fault_injector"),
+		roll:   rand.Float64,
+		sleep:  sleepContext,
+	}
+}
+
+// Rules returns the active rules in the order they are tried.
+func (f *FaultInjector) Rules() []faults.Rule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.rules)
+}
+
+// Add validates a rule, gives it an ID and makes it active after the
+// existing rules.
+func (f *FaultInjector) Add(rule faults.Rule) (faults.Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return faults.Rule{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	rule.ID = "fault_" + strconv.Itoa(f.nextID)
+	rule.Hits = 0
+	f.rules = append(f.rules, rule)
+	f.logger.Warn("fault rule added", "rule", rule.ID, "target", rule.Target, "effect", rule.Effect, "percent", rule.Percent)
+	return rule, nil
+}
+
+// Remove deactivates a rule. It reports whether the rule was active.
+func (f *FaultInjector) Remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.rules)
+	f.rules = slices.DeleteFunc(f.rules, func(r faults.Rule) bool { return r.ID == id })
+	return len(f.rules) < n
+}
+
+// Clear deactivates every rule.
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// fire picks the rule that injects a fault into a call, counting the hit and
+// retiring the rule once it has used all of them.
+func (f *FaultInjector) fire(call faults.Call) (faults.Rule, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule, ok := faults.Pick(f.rules, call, f.roll)
+	if !ok {
+		return faults.Rule{}, false
+	}
+	i := slices.IndexFunc(f.rules, func(r faults.Rule) bool { return r.ID == rule.ID })
+	f.rules[i].Hits++
+	rule = f.rules[i]
+	if rule.Exhausted() {
+		f.rules = slices.Delete(f.rules, i, i+1)
+	}
+	return rule, true
+}
+
+// delay applies a latency or timeout rule. A timeout returns an error once
+// it has hung for the rule's time or the call's deadline passes.
+func (f *FaultInjector) delay(ctx context.Context, rule faults.Rule) error {
+	switch rule.Effect {
+	case faults.EffectLatency:
+		return f.sleep(ctx, rule.Latency())
+	case faults.EffectTimeout:
+		if err := f.sleep(ctx, rule.TimeoutAfter()); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: timed out after %v", ErrFaultInjected, rule.TimeoutAfter())
+	}
+	return nil
+}
+
+// MinionFault implements docker.FaultInjector.
+func (f *FaultInjector) MinionFault(ctx context.Context, nodeID, command, subject string) (*minion.ErrorInfo, error) {
+	rule, ok := f.fire(faults.Call{Target: faults.TargetMinion, Operation: command, Node: nodeID, Subject: subject})
+	if !ok {
+		return nil, nil
+	}
+	f.logger.Info("injecting minion fault", "rule", rule.ID, "node", nodeID, "command", command, "effect", rule.Effect)
+	if rule.Effect == faults.EffectError {
+		return &minion.ErrorInfo{Command: command, Code: rule.Error, Message: "injected fault (" + rule.ID + ")"}, nil
+	}
+	return nil, f.delay(ctx, rule)
+}
+
+// storeFault applies the rules to a store operation on a resource row.
+func (f *FaultInjector) storeFault(ctx context.Context, op, resource, refID string) error {
+	call := faults.Call{Target: faults.TargetStore, Operation: op, Resource: resource}
+	switch resource {
+	case "deployments":
+		call.Deployment = refID
+	case "nodes":
+		call.Node = refID
+	}
+	rule, ok := f.fire(call)
+	if !ok {
+		return nil
+	}
+	f.logger.Info("injecting store fault", "rule", rule.ID, "operation", op, "resource", resource, "id", refID, "effect", rule.Effect)
+	if rule.Effect != faults.EffectError {
+		return f.delay(ctx, rule)
+	}
+	switch rule.Error {
+	case faults.StoreErrNotFound:
+		return fmt.Errorf("%s %s: %w (%w)", resource, refID, ErrNotFound, ErrFaultInjected)
+	case faults.StoreErrValidation:
+		return fmt.Errorf("%w: %w", ErrValidation, ErrFaultInjected)
+	default:
+		return fmt.Errorf("%s %s: database unavailable: %w", op, resource, ErrFaultInjected)
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// SetFaultInjector makes store operations subject to a fault injector's
+// rules. It must be set before the store is used.
+func (s *Store) SetFaultInjector(f *FaultInjector) {
+	s.faults = f
+}
+
+// injectFault applies the store's fault injector, if any, to an operation.
+func (s *Store) injectFault(ctx context.Context, op, resource, refID string) error {
+	if s.faults == nil {
+		return nil
+	}
+	return s.faults.storeFault(ctx, op, resource, refID)
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// faultsHandler lists the active fault rules (GET), adds one (POST) or
+// removes them all (DELETE).
+func faultsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireFaultAdmin(w, r, cfg) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			data := []map[string]any{}
+			for _, rule := range cfg.Faults.Rules() {
+				data = append(data, faultRuleJSONAPI(rule))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+		case http.MethodPost:
+			var rule faults.Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid request body")
+				return
+			}
+			rule, err := cfg.Faults.Add(rule)
+			if err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"data": faultRuleJSONAPI(rule)})
+		case http.MethodDelete:
+			cfg.Faults.Clear()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// faultHandler removes a fault rule.
+func faultHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireFaultAdmin(w, r, cfg) {
+			return
+		}
+		if !cfg.Faults.Remove(mux.Vars(r)["id"]) {
+			writeProblem(w, r, ProblemNotFound, "no active fault rule with this id")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requireFaultAdmin admits administrators. The fault routes exist only when
+// fault injection is enabled.
+func requireFaultAdmin(w http.ResponseWriter, r *http.Request, cfg SetupConfig) bool {
+	authCtx := getAuthContext(r)
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return false
+	}
+	if !isAdmin(cfg, authCtx) {
+		writeProblem(w, r, ProblemForbidden, "administrator access required")
+		return false
+	}
+	return true
+}
+
+func faultRuleJSONAPI(rule faults.Rule) map[string]any {
+	var attrs map[string]any
+	b, _ := json.Marshal(rule)
+	json.Unmarshal(b, &attrs)
+	delete(attrs, "id")
+	return map[string]any{
+		"type":       "faults",
+		"id":         rule.ID,
+		"attributes": attrs,
+	}
+}
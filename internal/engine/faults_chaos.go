@@ -0,0 +1,7 @@
+//go:build chaos
+
+package engine
+
+// FaultInjectionBuilt reports whether this binary was built with -tags chaos,
+// without which chaos.enabled is refused.
+const FaultInjectionBuilt = true
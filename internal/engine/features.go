@@ -0,0 +1,302 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/features"
+	"github.com/artpar/hoster/internal/core/limits"
+	"github.com/artpar/hoster/internal/core/spending"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Feature Flags
+// =============================================================================
+//
+// A plan's feature flags come from the plan catalog: the "features" member of
+// X-Plan-Limits, or DefaultPlanLimits for the plan ID when APIGate does not
+// send them. max_deployments falls back to the plan's MaxDeployments.
+// Administrators can override flags for a single user (feature_overrides).
+// AuthMiddleware resolves the effective set once per request into
+// AuthContext.Features, and every check reads it from there.
+
+// planFeatures returns the flags of the caller's plan. Malformed flags from
+// the header fall back to the defaults of the plan ID.
+func planFeatures(ac AuthContext) features.Set {
+	raw := ac.PlanLimits.Features
+	if raw == nil && ac.PlanID != "" {
+		raw = DefaultPlanLimits(ac.PlanID).Features
+	}
+	plan, err := features.Parse(raw)
+	if err != nil {
+		plan, _ = features.Parse(DefaultPlanLimits(ac.PlanID).Features)
+	}
+	if plan == nil {
+		plan = features.Set{}
+	}
+	if _, ok := plan[features.MaxDeployments]; !ok && ac.PlanLimits.MaxDeployments > 0 {
+		plan[features.MaxDeployments] = int64(ac.PlanLimits.MaxDeployments)
+	}
+	return plan
+}
+
+// ResolveFeatures returns the caller's effective feature flags.
+func (s *Store) ResolveFeatures(ctx context.Context, ac AuthContext) (features.Set, error) {
+	overrides, err := s.FeatureOverrides(ctx, ac.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return features.Resolve(planFeatures(ac), overrides), nil
+}
+
+// FeatureOverride is a flag value set for one user by an administrator.
+type FeatureOverride struct {
+	Flag      string `db:"flag"`
+	Value     int64  `db:"value"`
+	Reason    string `db:"reason"`
+	UpdatedBy string `db:"updated_by"`
+	UpdatedAt string `db:"updated_at"`
+}
+
+// ListFeatureOverrides returns a user's overrides by flag name.
+func (s *Store) ListFeatureOverrides(ctx context.Context, userID int) ([]FeatureOverride, error) {
+	var rows []FeatureOverride
+	if err := s.db.SelectContext(ctx, &rows,
+		`SELECT flag, value, reason, updated_by, updated_at FROM feature_overrides WHERE user_id = ? ORDER BY flag`,
+		userID); err != nil {
+		return nil, fmt.Errorf("list feature overrides: %w", err)
+	}
+	return rows, nil
+}
+
+// FeatureOverrides returns a user's overrides as a set. Overrides of flags
+// no longer in the catalog are ignored.
+func (s *Store) FeatureOverrides(ctx context.Context, userID int) (features.Set, error) {
+	rows, err := s.ListFeatureOverrides(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	set := features.Set{}
+	for _, o := range rows {
+		if _, ok := features.Lookup(features.Flag(o.Flag)); ok {
+			set[features.Flag(o.Flag)] = o.Value
+		}
+	}
+	return set, nil
+}
+
+// UpdateFeatureOverrides sets and removes overrides of a user in one
+// transaction.
+func (s *Store) UpdateFeatureOverrides(ctx context.Context, userID int, set features.Set, remove []features.Flag, reason, updatedBy string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("update feature overrides: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, f := range remove {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM feature_overrides WHERE user_id = ? AND flag = ?`, userID, string(f)); err != nil {
+			return fmt.Errorf("update feature overrides: %w", err)
+		}
+	}
+	for _, f := range set.Flags() {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO feature_overrides (user_id, flag, value, reason, updated_by, updated_at)
+			VALUES (?, ?, ?, ?, ?, datetime('now'))
+			ON CONFLICT(user_id, flag) DO UPDATE SET value = excluded.value, reason = excluded.reason,
+				updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+			userID, string(f), set[f], reason, updatedBy); err != nil {
+			return fmt.Errorf("update feature overrides: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// requireFeature writes a problem and returns false unless the caller's plan
+// includes the flag.
+func requireFeature(w http.ResponseWriter, r *http.Request, f features.Flag) bool {
+	if err := getAuthContext(r).Features.Require(f); err != nil {
+		writeProblem(w, r, ProblemFeatureNotInPlan, err.Error())
+		return false
+	}
+	return true
+}
+
+// hookProblem is the problem for an error returned by a BeforeCreate,
+// BeforeUpdate or BeforeTransition hook.
+func hookProblem(err error) ProblemType {
+	if errors.Is(err, features.ErrNotInPlan) {
+		return ProblemFeatureNotInPlan
+	}
+	if errors.Is(err, spending.ErrLimitReached) {
+		return ProblemSpendingLimitReached
+	}
+	var qe *limits.QuotaError
+	if errors.As(err, &qe) {
+		return ProblemQuotaExceeded
+	}
+	return ProblemValidationFailed
+}
+
+// hookFail is the failed operation for an error returned by a hook. Quota
+// errors carry the quota and usage behind them.
+func hookFail(err error) error {
+	oe := &opError{problem: hookProblem(err), detail: err.Error()}
+	var qe *limits.QuotaError
+	if errors.As(err, &qe) {
+		oe.extensions = quotaExtensions(qe)
+	}
+	return oe
+}
+
+// checkTemplateFeatures checks a template's compose file against the
+// creator's plan: command probes run inside containers.
+func checkTemplateFeatures(flags features.Set, spec any) error {
+	ext, err := compose.ParseExtension(strVal(spec))
+	if err != nil || ext == nil {
+		return nil
+	}
+	for service, p := range ext.Probes {
+		if len(p.Command) > 0 {
+			if err := flags.Require(features.ExecAccess); err != nil {
+				return fmt.Errorf("%w (command probe of service %s)", err, service)
+			}
+		}
+	}
+	return nil
+}
+
+// featureAttributes renders a flag set with the catalog and the overrides
+// behind it for API responses.
+func featureAttributes(planID string, flags features.Set, overrides []FeatureOverride) map[string]any {
+	byFlag := make(map[string]any, len(overrides))
+	for _, o := range overrides {
+		f := features.Flag(o.Flag)
+		byFlag[o.Flag] = map[string]any{
+			"value":      features.Set{f: o.Value}.Attributes()[o.Flag],
+			"reason":     o.Reason,
+			"updated_by": o.UpdatedBy,
+			"updated_at": o.UpdatedAt,
+		}
+	}
+	return map[string]any{
+		"plan_id":   planID,
+		"flags":     flags.Attributes(),
+		"catalog":   features.Catalog,
+		"overrides": byFlag,
+	}
+}
+
+// featuresHandler handles GET /features: the caller's effective flags, for
+// the UI to show or hide what the plan includes.
+func featuresHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		overrides, err := cfg.Store.ListFeatureOverrides(r.Context(), authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load feature overrides")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "features",
+				"id":         "current",
+				"attributes": featureAttributes(authCtx.PlanID, authCtx.Features, overrides),
+			},
+		})
+	}
+}
+
+// userFeaturesHandler handles GET and PATCH /admin/users/{id}/features: a
+// user's overrides and the flags they resolve to with the defaults of the
+// user's stored plan. PATCH takes {"flags": {...}, "remove": [...], "reason": "..."}.
+func userFeaturesHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !isAdmin(cfg, authCtx) {
+			writeProblem(w, r, ProblemForbidden, "administrator access required")
+			return
+		}
+		ctx := r.Context()
+		refID := mux.Vars(r)["id"]
+
+		var user struct {
+			ID     int    `db:"id"`
+			PlanID string `db:"plan_id"`
+		}
+		err := cfg.Store.db.GetContext(ctx, &user, `SELECT id, COALESCE(plan_id, '') AS plan_id FROM users WHERE reference_id = ?`, refID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, r, ProblemNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load user")
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			var req struct {
+				Flags  map[string]any `json:"flags"`
+				Remove []string       `json:"remove"`
+				Reason string         `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+			set, err := features.Parse(req.Flags)
+			if err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			var remove []features.Flag
+			for _, name := range req.Remove {
+				if _, ok := features.Lookup(features.Flag(name)); !ok {
+					writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("%v: unknown flag %q", features.ErrInvalidFlag, name))
+					return
+				}
+				remove = append(remove, features.Flag(name))
+			}
+			if err := cfg.Store.UpdateFeatureOverrides(ctx, user.ID, set, remove, req.Reason, authCtx.ReferenceID); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to save feature overrides")
+				return
+			}
+		}
+
+		overrides, err := cfg.Store.ListFeatureOverrides(ctx, user.ID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load feature overrides")
+			return
+		}
+		flags, err := cfg.Store.ResolveFeatures(ctx, AuthContext{
+			UserID:     user.ID,
+			PlanID:     user.PlanID,
+			PlanLimits: DefaultPlanLimits(user.PlanID),
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to resolve feature flags")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "user-features",
+				"id":         refID,
+				"attributes": featureAttributes(user.PlanID, flags, overrides),
+			},
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/integrity"
+)
+
+// =============================================================================
+// Data Integrity Checker
+// =============================================================================
+
+// fsckBackupTable holds original values of columns nulled out by a repair,
+// so a bad repair can always be undone by hand.
+const fsckBackupTable = `CREATE TABLE IF NOT EXISTS fsck_backups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	resource TEXT NOT NULL,
+	reference_id TEXT NOT NULL,
+	field TEXT NOT NULL,
+	value TEXT,
+	created_at TEXT NOT NULL DEFAULT (datetime('now'))
+)`
+
+// CheckIntegrity scans every row of every schema resource and reports
+// invalid JSON columns, JSON columns that don't have their field's declared
+// shape, dangling foreign keys, and unknown state machine states.
+// If repair is true, JSON columns that don't parse are backed up to
+// fsck_backups and set to NULL.
+// Misshapen JSON, dangling references and invalid states are reported only —
+// the value may still hold data, so they need a human decision.
+func (s *Store) CheckIntegrity(ctx context.Context, repair bool) (*integrity.Report, error) {
+	report := &integrity.Report{}
+
+	if repair {
+		if _, err := s.db.ExecContext(ctx, fsckBackupTable); err != nil {
+			return nil, fmt.Errorf("create fsck backup table: %w", err)
+		}
+	}
+
+	for _, res := range s.ordered {
+		if err := s.checkResource(ctx, &res, repair, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Store) checkResource(ctx context.Context, res *Resource, repair bool, report *integrity.Report) error {
+	// Read raw rows — decodeRow would silently keep unparseable JSON as strings.
+	rows, err := s.RawQuery(ctx, fmt.Sprintf("SELECT %s FROM %s ORDER BY id", s.selectColumns(res), res.Name))
+	if err != nil {
+		return fmt.Errorf("scan %s: %w", res.Name, err)
+	}
+
+	var knownStates []string
+	if res.StateMachine != nil {
+		knownStates = res.StateMachine.AllStates()
+	}
+
+	for _, row := range rows {
+		report.RowsScanned++
+		refID := strVal(row["reference_id"])
+		if refID == "" {
+			report.Add(integrity.Issue{
+				Kind:     integrity.IssueMissingRefID,
+				Resource: res.Name,
+				Field:    "reference_id",
+				Message:  fmt.Sprintf("row id=%v has no reference_id", row["id"]),
+			})
+		}
+
+		for _, f := range res.Fields {
+			switch f.Type {
+			case TypeJSON:
+				raw := strVal(row[f.Name])
+				kind := integrity.IssueInvalidJSON
+				err := integrity.CheckJSON(raw)
+				if err == nil {
+					kind = integrity.IssueJSONShape
+					err = integrity.CheckJSONShape(raw, f.JSONShape)
+				}
+				if err != nil {
+					issue := integrity.Issue{
+						Kind:        kind,
+						Resource:    res.Name,
+						ReferenceID: refID,
+						Field:       f.Name,
+						Value:       integrity.Truncate(raw, 80),
+						Message:     err.Error(),
+						Repairable:  refID != "" && kind == integrity.IssueInvalidJSON,
+					}
+					report.Add(issue)
+					if repair && issue.Repairable {
+						if err := s.repairJSON(ctx, res.Name, refID, f.Name, raw); err != nil {
+							return err
+						}
+						report.Repaired++
+					}
+				}
+
+			case TypeRef:
+				id, ok := toInt64(row[f.Name])
+				if !ok || id == 0 {
+					continue
+				}
+				exists, err := s.rowExists(ctx, f.RefTable, "id", id)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					report.Add(integrity.Issue{
+						Kind:        integrity.IssueDanglingRef,
+						Resource:    res.Name,
+						ReferenceID: refID,
+						Field:       f.Name,
+						Value:       fmt.Sprintf("%d", id),
+						Message:     fmt.Sprintf("%s id=%d does not exist", f.RefTable, id),
+					})
+				}
+
+			case TypeSoftRef:
+				ref := strVal(row[f.Name])
+				if ref == "" {
+					continue
+				}
+				exists, err := s.rowExists(ctx, f.RefTable, "reference_id", ref)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					report.Add(integrity.Issue{
+						Kind:        integrity.IssueDanglingRef,
+						Resource:    res.Name,
+						ReferenceID: refID,
+						Field:       f.Name,
+						Value:       ref,
+						Message:     fmt.Sprintf("%s %s does not exist", f.RefTable, ref),
+					})
+				}
+			}
+		}
+
+		if res.StateMachine != nil {
+			state := strVal(row[res.StateMachine.Field])
+			if err := integrity.CheckState(state, knownStates); err != nil {
+				report.Add(integrity.Issue{
+					Kind:        integrity.IssueInvalidState,
+					Resource:    res.Name,
+					ReferenceID: refID,
+					Field:       res.StateMachine.Field,
+					Value:       state,
+					Message:     err.Error(),
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// repairJSON backs up an unparseable JSON column and sets it to NULL in one transaction.
+func (s *Store) repairJSON(ctx context.Context, resource, refID, field, raw string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin repair: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO fsck_backups (resource, reference_id, field, value, created_at) VALUES (?, ?, ?, ?, ?)`,
+		resource, refID, field, raw, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("backup %s.%s: %w", resource, field, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET %s = NULL WHERE reference_id = ?", resource, field), refID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("repair %s.%s: %w", resource, field, err)
+	}
+	return tx.Commit()
+}
+
+// rowExists reports whether table has a row whose column holds value. A
+// failed lookup is an error, not a dangling reference.
+func (s *Store) rowExists(ctx context.Context, table, column string, value any) (bool, error) {
+	var n int
+	if err := s.db.GetContext(ctx, &n, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?", table, column), value); err != nil {
+		return false, fmt.Errorf("look up %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/integrity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Integrity Check Tests
+// =============================================================================
+
+func widgetResource() Resource {
+	return Resource{
+		Name:      "widgets",
+		RefPrefix: "wid_",
+		Fields: []Field{
+			StringField("name"),
+			JSONField("labels").WithShape(integrity.ShapeStringMap),
+			JSONField("extra"),
+			SoftRefField("gadget_id", "gadgets"),
+		},
+	}
+}
+
+// openIntegrityStore opens a store with the engine schema plus widgets, which
+// the scan covers like any other resource.
+func openIntegrityStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	widgets := widgetResource()
+	_, err = store.db.Exec(widgets.GenerateCreateSQL())
+	require.NoError(t, err)
+	store.ordered = append(store.ordered, widgets)
+	return store
+}
+
+func TestCheckIntegrity_JSONShape(t *testing.T) {
+	store := openIntegrityStore(t)
+	ctx := context.Background()
+	_, err := store.db.Exec(`INSERT INTO widgets (reference_id, name, labels, extra) VALUES
+		('wid_ok', 'ok', '{"tier":"gold"}', '[1,2]'),
+		('wid_shape', 'shape', '["gold"]', '"anything"'),
+		('wid_corrupt', 'corrupt', '{"tier":', NULL)`)
+	require.NoError(t, err)
+
+	report, err := store.CheckIntegrity(ctx, false)
+	require.NoError(t, err)
+	counts := report.CountByKind()
+	assert.Equal(t, 1, counts[integrity.IssueJSONShape], "the undeclared extra column takes any JSON")
+	assert.Equal(t, 1, counts[integrity.IssueInvalidJSON])
+	for _, issue := range report.Issues {
+		if issue.Kind == integrity.IssueJSONShape {
+			assert.Equal(t, "wid_shape", issue.ReferenceID)
+			assert.Equal(t, "labels", issue.Field)
+		}
+	}
+
+	// Only the value that doesn't parse is repaired; the misshapen one may
+	// still hold data
+	report, err = store.CheckIntegrity(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Repaired)
+	var backups []string
+	require.NoError(t, store.db.Select(&backups, `SELECT value FROM fsck_backups ORDER BY id`))
+	assert.Equal(t, []string{`{"tier":`}, backups)
+	var labels string
+	require.NoError(t, store.db.Get(&labels, `SELECT labels FROM widgets WHERE reference_id = 'wid_shape'`))
+	assert.Equal(t, `["gold"]`, labels)
+
+	report, err = store.CheckIntegrity(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[integrity.IssueKind]int{integrity.IssueJSONShape: 1}, report.CountByKind())
+}
+
+func TestOpenExistingDB_DoesNotMigrate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	store, err := OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	version, latest, dirty, err := store.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+	assert.False(t, dirty)
+	_, err = store.db.Exec(`UPDATE schema_migrations SET version = ?`, latest-1)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = OpenExistingDB(dsn, Schema())
+	require.NoError(t, err)
+	defer store.Close()
+	version, _, _, err = store.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, latest-1, version, "the pending migration is not run")
+}
+
+func TestSchemaVersion_Unmigrated(t *testing.T) {
+	store, err := OpenExistingDB(filepath.Join(t.TempDir(), "hoster.db"), Schema())
+	require.NoError(t, err)
+	defer store.Close()
+
+	version, latest, dirty, err := store.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, version)
+	assert.False(t, dirty)
+	assert.Error(t, integrity.CheckSchemaVersion(version, latest, dirty))
+}
+
+func TestCheckIntegrity_LookupErrorIsNotDangling(t *testing.T) {
+	// widgets refer to a gadgets table that doesn't exist, so the lookup fails
+	store := openIntegrityStore(t)
+	_, err := store.db.Exec(`INSERT INTO widgets (reference_id, name, gadget_id) VALUES ('wid_1', 'one', 'gad_1')`)
+	require.NoError(t, err)
+
+	report, err := store.CheckIntegrity(context.Background(), false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "look up gadgets.reference_id")
+	assert.Zero(t, report.CountByKind()[integrity.IssueDanglingRef])
+}
+
+func TestCheckIntegrity_Schema(t *testing.T) {
+	// Rows written by the engine fit the shapes their fields declare
+	store := openIntegrityStore(t)
+	ctx := context.Background()
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_1', 'creator@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Shape Test", "creator_id": userID, "version": "1.0.0",
+		"compose_spec":    "services:\n  web:\n    image: nginx\n",
+		"variables":       []map[string]any{{"name": "PORT", "default": "80"}},
+		"tags":            []string{"web"},
+		"routing_options": map[string]any{"timeout_seconds": 30},
+	})
+	require.NoError(t, err)
+	tmplID, _ := toInt64(tmpl["id"])
+	_, err = store.Create(ctx, "deployments", map[string]any{
+		"name": "shape-test", "customer_id": userID, "template_id": tmplID,
+		"variables": map[string]any{"PORT": "8080"},
+		"labels":    map[string]string{"team": "web"},
+		"domains":   []map[string]any{{"hostname": "shape.example.com"}},
+	})
+	require.NoError(t, err)
+
+	report, err := store.CheckIntegrity(ctx, false)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Issues)
+}
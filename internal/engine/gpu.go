@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/coordination"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/gpu"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/shell/billing"
+)
+
+// =============================================================================
+// GPU Inventory
+// =============================================================================
+//
+// Nodes with the "gpu" capability report their NVIDIA devices through the
+// minion's gpu-info command. The health checker records them in the node's
+// gpu_inventory; deployments whose compose services reserve GPUs are handed
+// devices from it when they start, and accrue GPU-hours while running.
+
+// gpuCheckInterval is how often a GPU node's inventory is refreshed.
+const gpuCheckInterval = 15 * time.Minute
+
+// nodeGPUInventory decodes a node row's gpu_inventory.
+func nodeGPUInventory(node map[string]any) minion.GPUInfo {
+	var info minion.GPUInfo
+	decodeJSONValue(node["gpu_inventory"], &info)
+	return info
+}
+
+// gpuCheckDue reports whether a node's GPU inventory should be refreshed:
+// it has the "gpu" capability and was not checked within the interval.
+func gpuCheckDue(node map[string]any, now time.Time) bool {
+	var caps []string
+	decodeJSONValue(node["capabilities"], &caps)
+	if !slices.Contains(caps, "gpu") {
+		return false
+	}
+	checked, ok := parseTime(node["gpu_checked_at"])
+	return !ok || now.Sub(checked) >= gpuCheckInterval
+}
+
+// checkGPUs refreshes a node's GPU inventory through its minion. A minion
+// that cannot report GPUs (e.g. an older protocol) leaves the inventory as
+// it was.
+func (h *HealthChecker) checkGPUs(ctx context.Context, node map[string]any) {
+	refID := strVal(node["reference_id"])
+	logger := h.logger.With("node", refID)
+
+	update := map[string]any{"gpu_checked_at": time.Now().UTC().Format(time.RFC3339)}
+	info, err := h.nodePool.GPUInfo(ctx, refID)
+	if err != nil {
+		logger.Debug("gpu inventory failed", "error", err)
+	} else {
+		update["gpu_inventory"] = info
+		if info.Error != "" {
+			logger.Warn("node has the gpu capability but reports no GPUs", "error", info.Error)
+		}
+	}
+	if _, err := h.store.Update(ctx, "nodes", refID, update); err != nil {
+		logger.Error("failed to record node gpu inventory", "error", err)
+	}
+}
+
+// nodeGPUAllocation adds "gpus_allocated" to nodes with a GPU inventory: how
+// many of their devices deployments hold.
+func nodeGPUAllocation(store *Store) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		for _, row := range rows {
+			if len(nodeGPUInventory(row).Devices) == 0 {
+				continue
+			}
+			held, err := store.heldGPUs(ctx, strVal(row["reference_id"]), "")
+			if err != nil {
+				continue
+			}
+			row["gpus_allocated"] = len(held)
+		}
+	}
+}
+
+// =============================================================================
+// Assignment
+// =============================================================================
+
+// gpuAssignMu serializes GPU assignment without a coordinator.
+var gpuAssignMu sync.Mutex
+
+// withGPULock runs fn holding the node's GPU lock, so two deployments
+// starting on one node never pick the same free device.
+func withGPULock(ctx context.Context, deps *Deps, nodeRef string, fn func(ctx context.Context) error) error {
+	if coord := getCoordinator(deps); coord != nil {
+		return coord.WithLock(ctx, coordination.LockName("node_gpus", nodeRef), fn)
+	}
+	gpuAssignMu.Lock()
+	defer gpuAssignMu.Unlock()
+	return fn(ctx)
+}
+
+// assignGPUs hands the deployment's GPU-reserving services devices from its
+// node's inventory that no other deployment holds, and records them in the
+// deployment's gpu_devices (service -> device UUIDs). A deployment whose
+// containers exist keeps the devices they were created with.
+func assignGPUs(ctx context.Context, deps *Deps, data map[string]any, composeSpec string) (map[string][]string, error) {
+	store := deps.Store
+	refID := strVal(data["reference_id"])
+	nodeRef := strVal(data["node_id"])
+
+	// The orchestrator reports specs that don't parse
+	spec, err := compose.ParseComposeSpec(composeSpec)
+	if err != nil {
+		return nil, nil
+	}
+	var prev map[string][]string
+	decodeJSONValue(data["gpu_devices"], &prev)
+	demand := gpu.Demand(spec)
+	if len(demand) == 0 {
+		if len(prev) > 0 {
+			store.Update(ctx, "deployments", refID, map[string]any{"gpu_devices": nil})
+		}
+		return nil, nil
+	}
+
+	var keep map[string][]string
+	var containers []domain.ContainerInfo
+	if decodeJSONValue(data["containers"], &containers); len(containers) > 0 {
+		keep = prev
+	}
+
+	var assigned map[string][]string
+	err = withGPULock(ctx, deps, nodeRef, func(ctx context.Context) error {
+		node, err := store.Get(ctx, "nodes", nodeRef)
+		if err != nil {
+			return fmt.Errorf("node %s not found", nodeRef)
+		}
+		held, err := store.heldGPUs(ctx, nodeRef, refID)
+		if err != nil {
+			return err
+		}
+		assigned, err = gpu.Assign(nodeGPUInventory(node).Devices, held, demand, keep)
+		if err != nil {
+			return err
+		}
+		_, err = store.Update(ctx, "deployments", refID, map[string]any{"gpu_devices": assigned})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("assign GPUs: %w", err)
+	}
+	return assigned, nil
+}
+
+// heldGPUs returns the GPUs deployments on a node hold (device UUID ->
+// deployment), leaving out the deployment except. Deployments hold their
+// devices from starting until they stop.
+func (s *Store) heldGPUs(ctx context.Context, nodeRef, except string) (map[string]string, error) {
+	var rows []gpuHolder
+	err := s.db.SelectContext(ctx, &rows, `SELECT `+gpuHolderColumns+` FROM deployments
+		WHERE node_id = ? AND reference_id != ? AND status IN ('starting', 'running', 'stopping')
+		AND gpu_devices IS NOT NULL AND gpu_devices != ''`, nodeRef, except)
+	if err != nil {
+		return nil, fmt.Errorf("list gpu holders: %w", err)
+	}
+	held := map[string]string{}
+	for _, row := range rows {
+		for _, ids := range row.devices() {
+			for _, id := range ids {
+				held[id] = row.ReferenceID
+			}
+		}
+	}
+	return held, nil
+}
+
+// =============================================================================
+// Metering
+// =============================================================================
+
+// gpuHolder is a deployment's GPU assignment and metering state.
+type gpuHolder struct {
+	ReferenceID string         `db:"reference_id"`
+	CustomerID  int            `db:"customer_id"`
+	NodeID      string         `db:"node_id"`
+	GPUDevices  sql.NullString `db:"gpu_devices"`
+	MeteredAt   sql.NullString `db:"gpu_metered_at"`
+}
+
+const gpuHolderColumns = `reference_id, COALESCE(customer_id, 0) AS customer_id, COALESCE(node_id, '') AS node_id,
+	gpu_devices, gpu_metered_at`
+
+func (h gpuHolder) devices() map[string][]string {
+	var devices map[string][]string
+	decodeJSONValue(h.GPUDevices.String, &devices)
+	return devices
+}
+
+// gpuHolder loads a deployment's GPU metering state.
+func (s *Store) gpuHolder(ctx context.Context, refID string) (*gpuHolder, error) {
+	var h gpuHolder
+	err := s.db.GetContext(ctx, &h, `SELECT `+gpuHolderColumns+` FROM deployments WHERE reference_id = ?`, refID)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// meteredGPUHolders lists running deployments accruing GPU-hours.
+func (s *Store) meteredGPUHolders(ctx context.Context) ([]gpuHolder, error) {
+	var rows []gpuHolder
+	err := s.db.SelectContext(ctx, &rows, `SELECT `+gpuHolderColumns+` FROM deployments
+		WHERE status = 'running' AND gpu_metered_at IS NOT NULL`)
+	return rows, err
+}
+
+// advanceGPUMeter moves a deployment's gpu_metered_at from from to to (nil
+// ends metering). It reports false when another metering moved it first,
+// so no hour is billed twice.
+func (s *Store) advanceGPUMeter(ctx context.Context, refID, from string, to *string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE deployments SET gpu_metered_at = ? WHERE reference_id = ? AND gpu_metered_at = ?`,
+		to, refID, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// meterGPUs records the GPU-hours h accrued up to now as a usage event for
+// its owner. Periodic metering bills whole hours; the final metering, when
+// the deployment stops or fails, bills the started hour and ends metering.
+func (s *Store) meterGPUs(ctx context.Context, h gpuHolder, now time.Time, final bool) error {
+	since, ok := parseTime(h.MeteredAt.String)
+	if !ok {
+		return nil
+	}
+	devices := gpu.Count(h.devices())
+	hours, next := gpu.Hours(devices, since, now, final)
+	var to *string
+	if !final {
+		if hours == 0 {
+			return nil
+		}
+		t := next.UTC().Format(time.RFC3339)
+		to = &t
+	}
+	moved, err := s.advanceGPUMeter(ctx, h.ReferenceID, h.MeteredAt.String, to)
+	if err != nil || !moved || hours == 0 || h.CustomerID == 0 {
+		return err
+	}
+	return billing.RecordMeteredEvent(ctx, s, h.CustomerID, domain.EventGPUUsage, h.ReferenceID, "deployment",
+		hours, map[string]string{
+			"node_id": h.NodeID,
+			"devices": strconv.Itoa(devices),
+			"from":    since.UTC().Format(time.RFC3339),
+			"to":      next.UTC().Format(time.RFC3339),
+		})
+}
+
+// settleGPUUsage bills a deployment's GPU-hours up to now and ends its
+// metering, when it stops holding its devices.
+func settleGPUUsage(ctx context.Context, store *Store, refID string, now time.Time) error {
+	h, err := store.gpuHolder(ctx, refID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return store.meterGPUs(ctx, *h, now, true)
+}
+
+// meterGPUUsage bills the whole GPU-hours running deployments accrued.
+func (h *HealthChecker) meterGPUUsage(ctx context.Context, now time.Time) {
+	holders, err := h.store.meteredGPUHolders(ctx)
+	if err != nil {
+		h.logger.Error("failed to list gpu deployments", "error", err)
+		return
+	}
+	for _, holder := range holders {
+		if err := h.store.meterGPUs(ctx, holder, now, false); err != nil {
+			h.logger.Warn("failed to record gpu usage", "deployment", holder.ReferenceID, "error", err)
+		}
+	}
+}
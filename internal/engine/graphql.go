@@ -0,0 +1,559 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/audit"
+	coregraphql "github.com/artpar/hoster/internal/core/graphql"
+	"github.com/artpar/hoster/internal/core/pagination"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// =============================================================================
+// GraphQL API
+// =============================================================================
+//
+// POST /api/graphql serves a schema generated from the resource registry,
+// alongside the JSON:API routes. Each resource has an object type with its
+// fields, a field for each reference it holds (deployment.template) and a
+// list for each resource referencing it (template.deployments). Queries and
+// mutations run the generic operations (api.go), so they see and change
+// exactly what the REST routes would, through the same hooks and
+// transitions.
+
+// graphqlPath is the GraphQL endpoint.
+const graphqlPath = "/api/graphql"
+
+// jsonScalar carries JSON fields, attribute maps and filters as they are.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:         "JSON",
+	Description:  "Any JSON value.",
+	Serialize:    func(v any) any { return v },
+	ParseValue:   func(v any) any { return v },
+	ParseLiteral: parseJSONLiteral,
+})
+
+func parseJSONLiteral(v ast.Value) any {
+	switch val := v.(type) {
+	case *ast.StringValue:
+		return val.Value
+	case *ast.EnumValue:
+		return val.Value
+	case *ast.BooleanValue:
+		return val.Value
+	case *ast.IntValue:
+		if n, err := strconv.ParseInt(val.Value, 10, 64); err == nil {
+			return n
+		}
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(val.Value, 64); err == nil {
+			return f
+		}
+	case *ast.ListValue:
+		list := make([]any, len(val.Values))
+		for i, item := range val.Values {
+			list[i] = parseJSONLiteral(item)
+		}
+		return list
+	case *ast.ObjectValue:
+		obj := make(map[string]any, len(val.Fields))
+		for _, f := range val.Fields {
+			obj[f.Name.Value] = parseJSONLiteral(f.Value)
+		}
+		return obj
+	}
+	return nil
+}
+
+// =============================================================================
+// Schema
+// =============================================================================
+
+type graphqlBuilder struct {
+	api     APIConfig
+	objects map[string]*graphql.Object
+	lists   map[string]*graphql.Object
+}
+
+// newGraphQLSchema generates the GraphQL schema of the store's resources.
+func newGraphQLSchema(api APIConfig) (graphql.Schema, error) {
+	if api.Logger == nil {
+		api.Logger = slog.Default()
+	}
+	if api.Bus == nil {
+		api.Bus = noopBus{}
+	}
+	b := &graphqlBuilder{
+		api:     api,
+		objects: map[string]*graphql.Object{},
+		lists:   map[string]*graphql.Object{},
+	}
+
+	names := slices.Sorted(maps.Keys(api.Store.schema))
+	for _, name := range names {
+		res := api.Store.schema[name]
+		b.objects[name] = graphql.NewObject(graphql.ObjectConfig{
+			Name:   coregraphql.TypeName(name),
+			Fields: graphql.FieldsThunk(func() graphql.Fields { return b.objectFields(res) }),
+		})
+		b.lists[name] = graphql.NewObject(graphql.ObjectConfig{
+			Name: coregraphql.TypeName(name) + "List",
+			Fields: graphql.Fields{
+				"items":       &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(b.objects[name])))},
+				"next_cursor": &graphql.Field{Type: graphql.String, Description: "Pass as after for the next page; null on the last page."},
+			},
+		})
+	}
+
+	query := graphql.Fields{}
+	mutation := graphql.Fields{}
+	for _, name := range names {
+		res := api.Store.schema[name]
+		single := coregraphql.Singular(name)
+		query[name] = &graphql.Field{
+			Type:    graphql.NewNonNull(b.lists[name]),
+			Args:    listArgs(true),
+			Resolve: b.resolveList(res, ""),
+		}
+		query[single] = &graphql.Field{
+			Type:    b.objects[name],
+			Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: b.resolveGet(res),
+		}
+
+		mutation["create_"+single] = &graphql.Field{
+			Type:    b.objects[name],
+			Args:    graphql.FieldConfigArgument{"attributes": {Type: graphql.NewNonNull(jsonScalar)}},
+			Resolve: b.resolveCreate(res),
+		}
+		mutation["update_"+single] = &graphql.Field{
+			Type: b.objects[name],
+			Args: graphql.FieldConfigArgument{
+				"id":         {Type: graphql.NewNonNull(graphql.ID)},
+				"attributes": {Type: graphql.NewNonNull(jsonScalar)},
+			},
+			Resolve: b.resolveUpdate(res),
+		}
+		mutation["delete_"+single] = &graphql.Field{
+			Type:    graphql.ID,
+			Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: b.resolveDelete(res),
+		}
+		if res.StateMachine != nil {
+			mutation["transition_"+single] = &graphql.Field{
+				Type: b.objects[name],
+				Args: graphql.FieldConfigArgument{
+					"id":    {Type: graphql.NewNonNull(graphql.ID)},
+					"state": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: b.resolveTransition(res),
+			}
+		}
+	}
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutation}),
+	})
+}
+
+// objectFields returns the fields of a resource's object type: its id, its
+// fields, its timestamps, every attribute as JSON, and its relations.
+func (b *graphqlBuilder) objectFields(res *Resource) graphql.Fields {
+	fields := graphql.Fields{
+		"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: rowValue("reference_id")},
+		"created_at": &graphql.Field{Type: graphql.String, Resolve: rowValue("created_at")},
+		"updated_at": &graphql.Field{Type: graphql.String, Resolve: rowValue("updated_at")},
+		"attributes": &graphql.Field{
+			Type:        jsonScalar,
+			Description: "Every attribute the JSON:API representation has, including those computed on read.",
+			Resolve:     rowAttributes,
+		},
+	}
+	for _, f := range res.Fields {
+		if f.WriteOnly {
+			continue
+		}
+		if _, taken := fields[f.Name]; !taken {
+			fields[f.Name] = &graphql.Field{Type: graphqlFieldType(f.Type), Resolve: rowValue(f.Name)}
+		}
+	}
+
+	// References to other resources, followed to the row
+	for _, f := range res.Fields {
+		target, ok := b.api.Store.schema[f.RefTable]
+		if f.WriteOnly || !ok {
+			continue
+		}
+		name := coregraphql.RelationName(f.Name, f.RefTable)
+		if _, taken := fields[name]; !taken {
+			fields[name] = &graphql.Field{Type: b.objects[target.Name], Resolve: b.resolveRelation(target, f.Name)}
+		}
+	}
+
+	// Resources referencing this one, listed
+	for _, name := range slices.Sorted(maps.Keys(b.api.Store.schema)) {
+		other := b.api.Store.schema[name]
+		var refs []string
+		for _, f := range other.Fields {
+			if f.RefTable == res.Name {
+				refs = append(refs, f.Name)
+			}
+		}
+		for _, ref := range refs {
+			field := coregraphql.InverseName(other.Name, ref, res.Name, len(refs) > 1)
+			if _, taken := fields[field]; !taken {
+				fields[field] = &graphql.Field{
+					Type:    graphql.NewNonNull(b.lists[other.Name]),
+					Args:    listArgs(false),
+					Resolve: b.resolveList(other, ref),
+				}
+			}
+		}
+	}
+	return fields
+}
+
+func graphqlFieldType(t FieldType) graphql.Output {
+	switch t {
+	case TypeInt:
+		return graphql.Int
+	case TypeFloat:
+		return graphql.Float
+	case TypeBool:
+		return graphql.Boolean
+	case TypeJSON:
+		return jsonScalar
+	default:
+		return graphql.String
+	}
+}
+
+// listArgs are the arguments of a list field. Top-level lists also take
+// scope and the resource's own list parameters.
+func listArgs(top bool) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{
+		"first":  {Type: graphql.Int, Description: "Page size (default 100, at most 1000)."},
+		"after":  {Type: graphql.String, Description: "next_cursor of the previous page."},
+		"filter": {Type: jsonScalar, Description: "Field values rows must have, e.g. {\"status\": \"running\"}."},
+	}
+	if top {
+		args["scope"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "\"mine\" lists only the caller's rows of public resources."}
+		args["params"] = &graphql.ArgumentConfig{Type: jsonScalar, Description: "The resource's own list parameters, e.g. a template search's {\"q\": \"postgres\"}."}
+	}
+	return args
+}
+
+// rowValue resolves a field of a row, rendering timestamps as JSON does.
+func rowValue(name string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		row, _ := p.Source.(map[string]any)
+		if t, ok := row[name].(time.Time); ok {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return row[name], nil
+	}
+}
+
+func rowAttributes(p graphql.ResolveParams) (any, error) {
+	row, _ := p.Source.(map[string]any)
+	attrs := make(map[string]any, len(row))
+	for k, v := range row {
+		if k == "reference_id" {
+			continue
+		}
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		attrs[k] = v
+	}
+	return attrs, nil
+}
+
+// =============================================================================
+// Resolvers
+// =============================================================================
+
+// graphqlRequest is the state of one GraphQL request.
+type graphqlRequest struct {
+	auth     AuthContext
+	resolves atomic.Int64
+}
+
+type graphqlRequestKey struct{}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	if req, ok := ctx.Value(graphqlRequestKey{}).(*graphqlRequest); ok {
+		return req
+	}
+	return &graphqlRequest{}
+}
+
+// spend counts one resource or list the request reads or writes, failing
+// once it has done too many.
+func (req *graphqlRequest) spend() error {
+	if req.resolves.Add(1) > coregraphql.MaxResolves {
+		return opFail(ProblemRateLimited, fmt.Sprintf("query reads more than %d resources and lists; split it up", coregraphql.MaxResolves))
+	}
+	return nil
+}
+
+// resolveList lists a resource's rows. For the list of rows referencing a
+// parent, ref is the referencing field.
+func (b *graphqlBuilder) resolveList(res *Resource, ref string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		req := graphqlRequestFrom(p.Context)
+		if err := req.spend(); err != nil {
+			return nil, err
+		}
+
+		query := url.Values{}
+		if params, ok := p.Args["params"].(map[string]any); ok {
+			for k, v := range params {
+				query.Set(k, graphqlQueryValue(v))
+			}
+		}
+		if scope, ok := p.Args["scope"].(string); ok {
+			query.Set("scope", scope)
+		}
+		if filter, ok := p.Args["filter"].(map[string]any); ok {
+			for k, v := range filter {
+				if k != "reference_id" && res.FieldByName(k) == nil {
+					return nil, opFail(ProblemValidationFailed, fmt.Sprintf("unknown filter field %q", k))
+				}
+				query.Set("filter["+k+"]", graphqlQueryValue(v))
+			}
+		}
+		if ref != "" {
+			parent, _ := p.Source.(map[string]any)
+			query.Set("filter["+ref+"]", strVal(parent["reference_id"]))
+		}
+
+		page := DefaultPage()
+		if first, ok := p.Args["first"].(int); ok {
+			page.Limit = first
+		}
+		if after, ok := p.Args["after"].(string); ok && after != "" {
+			c, err := pagination.Decode(after)
+			if err != nil {
+				return nil, opFail(ProblemInvalidRequest, err.Error())
+			}
+			page.Cursor = &c
+		}
+		page = page.Normalize()
+
+		rows, _, next, err := listRows(p.Context, b.api, res, req.auth, query, page)
+		if err != nil {
+			return nil, err
+		}
+		if rows == nil {
+			rows = []map[string]any{}
+		}
+		out := map[string]any{"items": rows, "next_cursor": nil}
+		if next != "" {
+			out["next_cursor"] = next
+		}
+		return out, nil
+	}
+}
+
+// graphqlQueryValue renders an argument value as a query parameter.
+func graphqlQueryValue(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case bool:
+		if val {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// resolveGet returns one row by id, or null when the caller can't see it.
+func (b *graphqlBuilder) resolveGet(res *Resource) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		id, _ := p.Args["id"].(string)
+		return b.get(p.Context, res, id)
+	}
+}
+
+// resolveRelation follows a reference field to the row it references.
+func (b *graphqlBuilder) resolveRelation(target *Resource, field string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		row, _ := p.Source.(map[string]any)
+		id := strVal(row[field])
+		if id == "" {
+			return nil, nil
+		}
+		return b.get(p.Context, target, id)
+	}
+}
+
+func (b *graphqlBuilder) get(ctx context.Context, res *Resource, id string) (any, error) {
+	req := graphqlRequestFrom(ctx)
+	if err := req.spend(); err != nil {
+		return nil, err
+	}
+	row, err := getRow(ctx, b.api, res, req.auth, id)
+	var oe *opError
+	if errors.As(err, &oe) && oe.problem == ProblemNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (b *graphqlBuilder) resolveCreate(res *Resource) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		req := graphqlRequestFrom(p.Context)
+		data, err := graphqlAttributes(p.Args["attributes"])
+		if err != nil {
+			return nil, err
+		}
+		row, err := createRow(p.Context, b.api, res, req.auth, data)
+		if err != nil {
+			return nil, err
+		}
+		b.audit(p.Context, req, audit.ActionCreate, res, strVal(row["reference_id"]), nil)
+		return row, nil
+	}
+}
+
+func (b *graphqlBuilder) resolveUpdate(res *Resource) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		req := graphqlRequestFrom(p.Context)
+		id, _ := p.Args["id"].(string)
+		existing, err := ownedRow(p.Context, b.api, res, req.auth, id, "not authorized to modify this "+res.Name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := graphqlAttributes(p.Args["attributes"])
+		if err != nil {
+			return nil, err
+		}
+		before := maps.Clone(existing)
+		row, err := updateRow(p.Context, b.api, res, req.auth, id, existing, data)
+		if err != nil {
+			return nil, err
+		}
+		b.audit(p.Context, req, audit.ActionUpdate, res, id, before)
+		return row, nil
+	}
+}
+
+func (b *graphqlBuilder) resolveDelete(res *Resource) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		req := graphqlRequestFrom(p.Context)
+		id, _ := p.Args["id"].(string)
+		existing, err := ownedRow(p.Context, b.api, res, req.auth, id, "not authorized to delete this "+res.Name)
+		if err != nil {
+			return nil, err
+		}
+		before := maps.Clone(existing)
+		if err := deleteRow(p.Context, b.api, res, req.auth, id, existing); err != nil {
+			return nil, err
+		}
+		b.audit(p.Context, req, audit.ActionDelete, res, id, before)
+		return id, nil
+	}
+}
+
+func (b *graphqlBuilder) resolveTransition(res *Resource) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		req := graphqlRequestFrom(p.Context)
+		id, _ := p.Args["id"].(string)
+		state, _ := p.Args["state"].(string)
+		existing, err := ownedRow(p.Context, b.api, res, req.auth, id, "not authorized")
+		if err != nil {
+			return nil, err
+		}
+		before := maps.Clone(existing)
+		row, err := transitionRow(p.Context, b.api, res, req.auth, id, state)
+		if err != nil {
+			return nil, err
+		}
+		b.audit(p.Context, req, audit.ActionTransition, res, id, before)
+		return row, nil
+	}
+}
+
+// graphqlAttributes copies a mutation's attributes argument, which must be
+// an object.
+func graphqlAttributes(v any) (map[string]any, error) {
+	attrs, ok := v.(map[string]any)
+	if !ok {
+		return nil, opFail(ProblemInvalidRequest, "attributes must be an object")
+	}
+	return maps.Clone(attrs), nil
+}
+
+// audit records a mutation in the audit log, as the audit middleware does
+// for the REST routes.
+func (b *graphqlBuilder) audit(ctx context.Context, req *graphqlRequest, action string, res *Resource, id string, before map[string]any) {
+	recordAudit(ctx, b.api.Store, b.api.Logger, req.auth, action, res.Name, id, before, http.MethodPost, graphqlPath, http.StatusOK)
+}
+
+// =============================================================================
+// Handler
+// =============================================================================
+
+// graphqlHandler handles POST /api/graphql: {"query", "operationName",
+// "variables"}. Results and field errors are returned with 200, as GraphQL
+// clients expect; a field's error carries its problem code and status in
+// its extensions.
+func graphqlHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if body.Query == "" {
+			writeProblem(w, r, ProblemInvalidRequest, "query is required")
+			return
+		}
+		// Syntax errors are left for the executor to report
+		if doc, err := parser.Parse(parser.ParseParams{Source: body.Query}); err == nil {
+			if depth := coregraphql.Depth(doc); depth > coregraphql.MaxDepth {
+				writeProblem(w, r, ProblemInvalidRequest,
+					fmt.Sprintf("query nests %d levels deep; at most %d are allowed", depth, coregraphql.MaxDepth))
+				return
+			}
+		}
+
+		req := &graphqlRequest{auth: getAuthContext(r)}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  body.Query,
+			OperationName:  body.OperationName,
+			VariableValues: body.Variables,
+			Context:        context.WithValue(r.Context(), graphqlRequestKey{}, req),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -3,12 +3,16 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"time"
 
 	"github.com/artpar/hoster/internal/core/crypto"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
 	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/artpar/hoster/internal/core/proxy"
+	"github.com/artpar/hoster/internal/core/scheduler"
 	"github.com/artpar/hoster/internal/shell/billing"
 	"github.com/artpar/hoster/internal/shell/docker"
 	"github.com/artpar/hoster/internal/shell/provider"
@@ -16,16 +20,25 @@ import (
 
 // RegisterHandlers registers all command handlers on the bus.
 func RegisterHandlers(bus *Bus) {
-	// Deployment lifecycle
-	bus.Register("ScheduleDeployment", scheduleDeployment)
-	bus.Register("StartDeployment", startDeployment)
-	bus.Register("StopDeployment", stopDeployment)
-	bus.Register("DeleteDeployment", deleteDeployment)
+	// Deployment lifecycle (commands touching containers hold the deployment's lock)
+	bus.Register("ScheduleDeployment", deploymentLocked(scheduleDeployment))
+	bus.Register("StartDeployment", deploymentLocked(startDeployment))
+	bus.Register("StopDeployment", deploymentLocked(stopDeployment))
+	bus.Register("DeleteDeployment", deploymentLocked(deleteDeployment))
 	bus.Register("DeploymentRunning", deploymentRunning)
 	bus.Register("DeploymentFailed", deploymentFailed)
+	bus.Register("UpgradeDeployment", deploymentLocked(upgradeDeployment))
+	bus.Register("CanaryCheck", deploymentLocked(checkCanary))
+	bus.Register("AbortCanary", deploymentLocked(abortCanaryCommand))
+	bus.Register("UpdateDeploymentImages", deploymentLocked(updateDeploymentImages))
+	bus.Register("ExpireDeployment", expireDeployment(bus))
+	bus.Register("LiftAbuseThrottle", deploymentLocked(liftAbuseThrottle))
 
 	// Cloud provision lifecycle
 	bus.Register("DestroyInstance", destroyProvision)
+
+	// Billing
+	bus.Register("InvoicePaid", recordCreatorEarnings)
 }
 
 // =============================================================================
@@ -33,17 +46,89 @@ func RegisterHandlers(bus *Bus) {
 // =============================================================================
 
 // scheduleDeployment validates the deployer's selected node and transitions to starting.
+// Deployments without a selected node are placed by the template creator's
+// placement rules, or else by the scheduler with nodes.scheduling_strategy.
 func scheduleDeployment(ctx context.Context, deps *Deps, data map[string]any) error {
 	store := deps.Store
 	logger := deps.Logger
 	nodePool := getNodePool(deps)
 
 	refID, _ := data["reference_id"].(string)
-
-	// The deployer must have selected a node at deploy time
 	selectedNodeRef, _ := data["node_id"].(string)
+
+	peerRef := strVal(data["colocate_with"])
+	var affinity *scheduler.Affinity
+	if peerRef != "" {
+		a := deploymentAffinity(ctx, store, peerRef)
+		affinity = &a
+	}
+
+	// The template creator's placement rules come before co-location and
+	// generic scheduling for deployments without a selected node
+	strategy := schedulingStrategy(deps)
+	var placed *scheduler.ScheduleResult
+	placementReason := ""
 	if selectedNodeRef == "" {
-		return failDeployment(ctx, store, refID, "no node selected — please select a node when deploying")
+		placement, err := creatorPlacement(ctx, store, data, affinity, strategy)
+		if err != nil && !errors.Is(err, scheduler.ErrPinUnsatisfiable) {
+			return fmt.Errorf("apply placement rules: %w", err)
+		}
+		if placement.Rule != "" || placement.Reason != "" {
+			store.Update(ctx, "deployments", refID, map[string]any{
+				"placement_rule":   placement.Rule,
+				"placement_reason": placement.Reason,
+			})
+		}
+		if err != nil {
+			logger.Warn("placement rule not satisfiable", "deployment", refID, "rule", placement.Rule, "reason", placement.Reason)
+			return failDeployment(ctx, store, refID, fmt.Sprintf("no node could be scheduled: %s", placement.Reason))
+		}
+		if placement.Result != nil {
+			placed = placement.Result
+			selectedNodeRef = placed.SelectedNodeID
+		}
+		placementReason = placement.Reason
+	}
+
+	// Otherwise the scheduler picks the node, before the node checks, so a
+	// failure still records why the peer's node wasn't used
+	if selectedNodeRef == "" {
+		result, err := placeDeployment(ctx, store, data, affinity, strategy)
+		if err != nil {
+			if affinity != nil && result != nil {
+				store.Update(ctx, "deployments", refID, map[string]any{"affinity_status": result.AffinityStatus, "affinity_reason": result.AffinityReason})
+			}
+			return failDeployment(ctx, store, refID, fmt.Sprintf("no node could be scheduled: %v", err))
+		}
+		placed = result
+		selectedNodeRef = result.SelectedNodeID
+		logger.Info("deployment scheduled", "deployment", refID, "node_id", selectedNodeRef, "strategy", strategy, "score", result.Score)
+	}
+
+	placementUpdates := map[string]any{}
+	if affinity != nil {
+		var status, reason string
+		if placed != nil {
+			status, reason = placed.AffinityStatus, placed.AffinityReason
+		} else {
+			status, reason = scheduler.CheckAffinity(*affinity, selectedNodeRef)
+		}
+		placementUpdates["affinity_status"] = status
+		placementUpdates["affinity_reason"] = reason
+		if status == scheduler.AffinityUnsatisfied {
+			logger.Warn("co-location affinity not satisfied", "deployment", refID, "colocate_with", peerRef, "reason", reason)
+		}
+	}
+
+	// A location the scheduler couldn't honor is explained with the
+	// placement reason
+	if loc := strVal(data["location"]); loc != "" && placed != nil && !placed.LocationMatched {
+		reason := fmt.Sprintf("no node in location %s could take the deployment", loc)
+		if placementReason != "" {
+			reason = placementReason + "; " + reason
+		}
+		placementUpdates["placement_reason"] = reason
+		logger.Warn("deployment location not satisfied", "deployment", refID, "location", loc, "node_id", selectedNodeRef)
 	}
 
 	// Look up the selected node and verify it's online
@@ -57,6 +142,12 @@ func scheduleDeployment(ctx context.Context, deps *Deps, data map[string]any) er
 		return failDeployment(ctx, store, refID, fmt.Sprintf("selected node %s is %s, not online", selectedNodeRef, nodeStatus))
 	}
 
+	// The template's compose features must be supported by the node's Docker engine
+	if err := checkNodeEngine(ctx, store, data, selectedNode); err != nil {
+		logger.Warn("node docker engine too old for template", "deployment", refID, "node_id", selectedNodeRef, "error", err)
+		return failDeployment(ctx, store, refID, fmt.Sprintf("selected node %s cannot run this template: %v", selectedNodeRef, err))
+	}
+
 	// Allocate proxy port if needed
 	proxyPort := toInt(data["proxy_port"])
 	if proxyPort == 0 {
@@ -71,6 +162,38 @@ func scheduleDeployment(ctx context.Context, deps *Deps, data map[string]any) er
 		proxyPort = port
 	}
 
+	// Allocate host ports for the template's TCP and UDP streams
+	var streams []domain.StreamPort
+	decodeJSONValue(data["stream_ports"], &streams)
+	if len(streams) == 0 {
+		if streams, err = templateStreams(ctx, store, data); err != nil {
+			return fmt.Errorf("load streams: %w", err)
+		}
+		if len(streams) > 0 {
+			usedPorts, err := getUsedProxyPorts(ctx, store, selectedNodeRef)
+			if err != nil {
+				logger.Warn("failed to get used stream ports", "error", err)
+			}
+			if _, err := assignStreamPorts(streams, usedPorts); err != nil {
+				return failDeployment(ctx, store, refID, err.Error())
+			}
+		}
+	}
+
+	// Spread the template's replicas over other nodes
+	var replicaUpdates map[string]any
+	var planned []domain.ReplicaNode
+	decodeJSONValue(data["replica_nodes"], &planned)
+	if len(planned) == 0 {
+		reserved := []int{proxyPort}
+		for _, s := range streams {
+			reserved = append(reserved, s.HostPort)
+		}
+		if replicaUpdates, err = planReplicas(ctx, deps, data, selectedNodeRef, reserved); err != nil {
+			return failDeployment(ctx, store, refID, fmt.Sprintf("no nodes could be scheduled for replicas: %v", err))
+		}
+	}
+
 	// Generate auto domain if none set
 	var domains any
 	if d, ok := data["domains"]; ok {
@@ -84,6 +207,17 @@ func scheduleDeployment(ctx context.Context, deps *Deps, data map[string]any) er
 		domains = string(domainsJSON)
 	}
 
+	// Claim every hostname globally before routing is set up
+	deplID := toInt(data["id"])
+	for _, d := range parseDomainsList(domains) {
+		if err := store.ClaimDomain(ctx, deplID, d.Hostname, d.Type); err != nil {
+			if errors.Is(err, ErrDomainConflict) {
+				return failDeployment(ctx, store, refID, fmt.Sprintf("hostname %s is already in use by another deployment", d.Hostname))
+			}
+			return fmt.Errorf("claim domain %s: %w", d.Hostname, err)
+		}
+	}
+
 	// Update deployment with node assignment, proxy port, domains
 	updates := map[string]any{
 		"node_id":    selectedNodeRef,
@@ -92,6 +226,11 @@ func scheduleDeployment(ctx context.Context, deps *Deps, data map[string]any) er
 	if domains != nil {
 		updates["domains"] = domains
 	}
+	if len(streams) > 0 {
+		updates["stream_ports"] = encodeStreams(streams)
+	}
+	maps.Copy(updates, placementUpdates)
+	maps.Copy(updates, replicaUpdates)
 	store.Update(ctx, "deployments", refID, updates)
 
 	// Verify node pool connectivity
@@ -152,32 +291,57 @@ func startDeployment(ctx context.Context, deps *Deps, data map[string]any) error
 
 	// Build domain.Deployment for orchestrator
 	depl := mapToDeployment(data)
+	applyTemplateRouting(depl, tmpl, data)
+	if err := resolveDeploymentSecrets(ctx, deps, depl, composeSpec); err != nil {
+		return failDeployment(ctx, store, refID, err.Error())
+	}
+	if err := injectBucketVariables(ctx, store, depl); err != nil {
+		return failDeployment(ctx, store, refID, err.Error())
+	}
 
 	// Parse config files from template
-	var configFiles []domain.ConfigFile
-	if cfRaw, ok := tmpl["config_files"]; ok {
-		if cfStr, ok := cfRaw.(string); ok && cfStr != "" {
-			json.Unmarshal([]byte(cfStr), &configFiles)
-		} else if cfParsed, ok := cfRaw.([]any); ok {
-			b, _ := json.Marshal(cfParsed)
-			json.Unmarshal(b, &configFiles)
-		}
-	}
+	configFiles := templateConfigFiles(tmpl)
 
 	// Start via orchestrator
 	orchestrator := docker.NewOrchestrator(client, logger, configDir, store)
+	if err := configureImageFetch(ctx, deps, orchestrator, nodeID, client); err != nil {
+		return failDeployment(ctx, store, refID, err.Error())
+	}
+	configureTraefikLabels(deps, orchestrator)
+	configureStart(deps, orchestrator)
+	if len(depl.Bridges) > 0 {
+		orchestrator.SetBridgeAddress(bridgeAddress(ctx, store, nodeID))
+	}
+	gpuDevices, err := assignGPUs(ctx, deps, data, composeSpec)
+	if err != nil {
+		return failDeployment(ctx, store, refID, err.Error())
+	}
+	orchestrator.SetGPUDevices(gpuDevices)
+	// After a checkpoint migration, new containers start from their checkpoints
+	restores := takeRestoreCheckpoints(ctx, store, data)
+	if len(restores) > 0 {
+		orchestrator.SetCheckpoints(restores)
+		defer discardRestoreCheckpoints(context.WithoutCancel(ctx), client, restores)
+	}
 	containers, err := orchestrator.StartDeployment(ctx, depl, composeSpec, configFiles)
 	if err != nil {
 		return failDeployment(ctx, store, refID, fmt.Sprintf("failed to start containers: %v", err))
 	}
+	startReplicas(ctx, deps, depl, composeSpec, configFiles)
 
 	// Transition to running
 	containersJSON, _ := json.Marshal(containers)
 	now := time.Now().UTC().Format(time.RFC3339)
-	store.Update(ctx, "deployments", refID, map[string]any{
-		"containers": string(containersJSON),
-		"started_at": now,
-	})
+	updates := map[string]any{
+		"containers":   string(containersJSON),
+		"running_spec": encodeRunningSpec(strVal(tmpl["version"]), composeSpec, configFiles),
+		"started_at":   now,
+		"health":       nil,
+	}
+	if len(gpuDevices) > 0 {
+		updates["gpu_metered_at"] = now
+	}
+	store.Update(ctx, "deployments", refID, updates)
 
 	_, _, err = store.Transition(ctx, "deployments", refID, "running")
 	if err != nil {
@@ -209,13 +373,23 @@ func stopDeployment(ctx context.Context, deps *Deps, data map[string]any) error
 		} else {
 			depl := mapToDeployment(data)
 			orchestrator := docker.NewOrchestrator(client, logger, configDir, nil)
+			if depl.CanaryPort > 0 {
+				// Stopping ends a canary upgrade; it is retried once running again
+				clearCanary(ctx, deps, orchestrator, depl, map[string]any{
+					"upgrade_status": string(coredeployment.UpgradeStatusNone),
+				})
+			}
 			if err := orchestrator.StopDeployment(ctx, depl); err != nil {
 				logger.Error("failed to stop containers", "deployment", refID, "error", err)
 			}
 		}
 	}
+	stopReplicas(ctx, deps, mapToDeployment(data), false)
 
-	// Transition to stopped
+	// Transition to stopped, releasing the deployment's GPUs
+	if err := settleGPUUsage(ctx, store, refID, time.Now()); err != nil {
+		logger.Warn("failed to record gpu usage", "deployment", refID, "error", err)
+	}
 	now := time.Now().UTC().Format(time.RFC3339)
 	store.Update(ctx, "deployments", refID, map[string]any{
 		"stopped_at": now,
@@ -248,11 +422,15 @@ func deleteDeployment(ctx context.Context, deps *Deps, data map[string]any) erro
 		} else {
 			depl := mapToDeployment(data)
 			orchestrator := docker.NewOrchestrator(client, logger, configDir, nil)
+			if depl.CanaryPort > 0 {
+				clearCanary(ctx, deps, orchestrator, depl, map[string]any{})
+			}
 			if err := orchestrator.RemoveDeployment(ctx, depl); err != nil {
 				logger.Warn("failed to remove deployment containers", "deployment", refID, "error", err)
 			}
 		}
 	}
+	stopReplicas(ctx, deps, mapToDeployment(data), true)
 
 	// Transition to deleted
 	_, _, err := store.Transition(ctx, "deployments", refID, "deleted")
@@ -262,6 +440,21 @@ func deleteDeployment(ctx context.Context, deps *Deps, data map[string]any) erro
 		recordBillingEvent(ctx, store, data, domain.EventDeploymentDeleted)
 	}
 
+	// Free hostnames so other deployments can claim them
+	if err := store.ReleaseDeploymentDomains(ctx, toInt(data["id"])); err != nil {
+		logger.Warn("failed to release domain claims", "deployment", refID, "error", err)
+	}
+
+	// Revoke collaborator access
+	if err := store.DeleteDeploymentCollaborators(ctx, refID); err != nil {
+		logger.Warn("failed to revoke collaborators", "deployment", refID, "error", err)
+	}
+
+	// Hand managed buckets to the bucket manager for release and retention
+	if err := store.ReleaseDeploymentBuckets(ctx, refID); err != nil {
+		logger.Warn("failed to release storage buckets", "deployment", refID, "error", err)
+	}
+
 	logger.Info("deployment deleted", "deployment", refID)
 	return nil
 }
@@ -371,10 +564,35 @@ func failDeployment(ctx context.Context, store *Store, refID, reason string) err
 	store.Update(ctx, "deployments", refID, map[string]any{
 		"error_message": reason,
 	})
+	settleGPUUsage(ctx, store, refID, time.Now())
 	store.Transition(ctx, "deployments", refID, "failed")
 	return fmt.Errorf("%s: %s", refID, reason)
 }
 
+// templateConfigFiles parses a template row's config_files JSON.
+func templateConfigFiles(tmpl map[string]any) []domain.ConfigFile {
+	var configFiles []domain.ConfigFile
+	if cfRaw, ok := tmpl["config_files"]; ok {
+		if cfStr, ok := cfRaw.(string); ok && cfStr != "" {
+			json.Unmarshal([]byte(cfStr), &configFiles)
+		} else if cfParsed, ok := cfRaw.([]any); ok {
+			b, _ := json.Marshal(cfParsed)
+			json.Unmarshal(b, &configFiles)
+		}
+	}
+	return configFiles
+}
+
+// validateTemplateConfigFiles checks that a template's config files marked
+// as templates parse.
+func validateTemplateConfigFiles(v any) error {
+	var files []domain.ConfigFile
+	if err := decodeJSONValue(v, &files); err != nil {
+		return fmt.Errorf("invalid config_files: %w", err)
+	}
+	return coredeployment.ValidateConfigTemplates(files)
+}
+
 func failProvision(ctx context.Context, store *Store, refID, reason string) error {
 	store.Update(ctx, "cloud_provisions", refID, map[string]any{
 		"error_message": reason,
@@ -390,10 +608,13 @@ func getNodePool(deps *Deps) *docker.NodePool {
 	return nil
 }
 
+// getUsedProxyPorts returns the host ports the node's live deployments hold:
+// proxy, canary, stream, replica proxy and bridge ports.
 func getUsedProxyPorts(ctx context.Context, store *Store, nodeID string) ([]int, error) {
 	rows, err := store.RawQuery(ctx,
-		"SELECT proxy_port FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND proxy_port IS NOT NULL",
-		nodeID)
+		"SELECT proxy_port FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND proxy_port IS NOT NULL "+
+			"UNION ALL SELECT canary_port FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND canary_port IS NOT NULL",
+		nodeID, nodeID)
 	if err != nil {
 		return nil, err
 	}
@@ -403,6 +624,27 @@ func getUsedProxyPorts(ctx context.Context, store *Store, nodeID string) ([]int,
 			ports = append(ports, p)
 		}
 	}
+
+	// Stream host ports are taken on the node too
+	rows, err = store.RawQuery(ctx,
+		"SELECT stream_ports FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND stream_ports IS NOT NULL",
+		nodeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		ports = append(ports, streamHostPorts(row["stream_ports"])...)
+	}
+
+	// So are the ports of spread deployments' replicas and bridges
+	rows, err = store.RawQuery(ctx,
+		"SELECT node_id, replica_nodes, replica_bridges FROM deployments WHERE status NOT IN ('deleted', 'stopped') AND replica_nodes IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		ports = append(ports, replicaHostPorts(row, nodeID)...)
+	}
 	return ports, nil
 }
 
@@ -0,0 +1,523 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/housekeeping"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/timezone"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Housekeeping Storage
+// =============================================================================
+//
+// A node's housekeeping policy lives in its housekeeping JSON column and is
+// edited like any other node field. node_housekeeping_runs holds one row per
+// run: scheduled runs and real manual runs are queued as pending and executed
+// by the HousekeepingScheduler; manual dry runs execute inline so the creator
+// gets the preview in the response. The minion's report is kept as JSON.
+
+// HousekeepingRun is one execution (or preview) of a node's housekeeping tasks.
+type HousekeepingRun struct {
+	ID             int64          `db:"id"`
+	ReferenceID    string         `db:"reference_id"`
+	NodeID         string         `db:"node_id"`
+	RequestedBy    int64          `db:"requested_by"` // 0 for scheduled runs
+	TriggeredBy    string         `db:"triggered_by"`
+	DryRun         bool           `db:"dry_run"`
+	Status         string         `db:"status"`
+	TasksJSON      string         `db:"tasks"`
+	ReportJSON     string         `db:"report"`
+	ReclaimedBytes int64          `db:"reclaimed_bytes"`
+	ErrorMessage   string         `db:"error_message"`
+	CreatedAt      string         `db:"created_at"`
+	UpdatedAt      string         `db:"updated_at"`
+	StartedAt      sql.NullString `db:"started_at"`
+	CompletedAt    sql.NullString `db:"completed_at"`
+
+	Tasks  []housekeeping.Task        `db:"-"`
+	Report *minion.HousekeepingReport `db:"-"`
+}
+
+const housekeepingRunColumns = `id, reference_id, node_id, requested_by, triggered_by, dry_run,
+	status, tasks, report, reclaimed_bytes, error_message, created_at, updated_at, started_at, completed_at`
+
+// CreateHousekeepingRun inserts a pending run and fills in its IDs.
+func (s *Store) CreateHousekeepingRun(ctx context.Context, run *HousekeepingRun) error {
+	tasks, err := json.Marshal(run.Tasks)
+	if err != nil {
+		return fmt.Errorf("marshal housekeeping tasks: %w", err)
+	}
+	if run.Tasks == nil {
+		tasks = []byte("[]")
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	run.ReferenceID = "hk_" + uuid.New().String()[:8]
+	run.Status = string(housekeeping.RunPending)
+	run.TasksJSON = string(tasks)
+	run.CreatedAt, run.UpdatedAt = now, now
+
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO node_housekeeping_runs (reference_id, node_id, requested_by, triggered_by, dry_run,
+			status, tasks, created_at, updated_at)
+		VALUES (:reference_id, :node_id, :requested_by, :triggered_by, :dry_run,
+			:status, :tasks, :created_at, :updated_at)`, run)
+	if err != nil {
+		return fmt.Errorf("create housekeeping run: %w", err)
+	}
+	run.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// SaveHousekeepingRun writes a run's status and report.
+func (s *Store) SaveHousekeepingRun(ctx context.Context, run *HousekeepingRun) error {
+	run.ReportJSON = ""
+	if run.Report != nil {
+		report, err := json.Marshal(run.Report)
+		if err != nil {
+			return fmt.Errorf("marshal housekeeping report: %w", err)
+		}
+		run.ReportJSON = string(report)
+		run.ReclaimedBytes = run.Report.ReclaimedBytes
+	}
+	run.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE node_housekeeping_runs SET status = :status, report = :report, reclaimed_bytes = :reclaimed_bytes,
+			error_message = :error_message, updated_at = :updated_at, started_at = :started_at, completed_at = :completed_at
+		WHERE id = :id`, run)
+	if err != nil {
+		return fmt.Errorf("save housekeeping run: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectHousekeepingRuns(ctx context.Context, query string, args ...any) ([]*HousekeepingRun, error) {
+	var out []*HousekeepingRun
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+housekeepingRunColumns+` FROM node_housekeeping_runs `+query, args...); err != nil {
+		return nil, fmt.Errorf("query housekeeping runs: %w", err)
+	}
+	for _, run := range out {
+		if err := json.Unmarshal([]byte(run.TasksJSON), &run.Tasks); err != nil {
+			return nil, fmt.Errorf("housekeeping run %s: decode tasks: %w", run.ReferenceID, err)
+		}
+		if run.ReportJSON != "" {
+			run.Report = &minion.HousekeepingReport{}
+			if err := json.Unmarshal([]byte(run.ReportJSON), run.Report); err != nil {
+				return nil, fmt.Errorf("housekeeping run %s: decode report: %w", run.ReferenceID, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ListHousekeepingRuns returns a node's runs, newest first.
+func (s *Store) ListHousekeepingRuns(ctx context.Context, nodeID string, limit int) ([]*HousekeepingRun, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.selectHousekeepingRuns(ctx, `WHERE node_id = ? ORDER BY id DESC LIMIT ?`, nodeID, limit)
+}
+
+// HasActiveHousekeepingRun reports whether a node has a real run pending or
+// running. Dry runs change nothing, so they never block another run.
+func (s *Store) HasActiveHousekeepingRun(ctx context.Context, nodeID string) (bool, error) {
+	runs, err := s.selectHousekeepingRuns(ctx, `WHERE node_id = ? AND dry_run = 0 AND status IN (?, ?) LIMIT 1`,
+		nodeID, housekeeping.RunPending, housekeeping.RunRunning)
+	if err != nil {
+		return false, err
+	}
+	return len(runs) > 0, nil
+}
+
+// LastScheduledHousekeepingAt returns when the node's most recent scheduled
+// run was queued. ok is false if none has been.
+func (s *Store) LastScheduledHousekeepingAt(ctx context.Context, nodeID string) (time.Time, bool, error) {
+	runs, err := s.selectHousekeepingRuns(ctx, `WHERE node_id = ? AND triggered_by = ? ORDER BY id DESC LIMIT 1`,
+		nodeID, housekeeping.TriggerSchedule)
+	if err != nil || len(runs) == 0 {
+		return time.Time{}, false, err
+	}
+	t, ok := parseTime(runs[0].CreatedAt)
+	return t, ok, nil
+}
+
+// ListRunnableHousekeepingRuns returns pending real runs and ones interrupted
+// by a restart, oldest first. Every task is safe to repeat. Dry runs execute
+// inline in the request that created them and are never queued.
+func (s *Store) ListRunnableHousekeepingRuns(ctx context.Context) ([]*HousekeepingRun, error) {
+	return s.selectHousekeepingRuns(ctx, `WHERE dry_run = 0 AND status IN (?, ?) ORDER BY id`,
+		housekeeping.RunPending, housekeeping.RunRunning)
+}
+
+// nodeHousekeepingPolicy decodes a node row's housekeeping policy.
+func nodeHousekeepingPolicy(node map[string]any) (housekeeping.Policy, error) {
+	var p housekeeping.Policy
+	if err := decodeJSONValue(node["housekeeping"], &p); err != nil {
+		return p, fmt.Errorf("%w: %v", housekeeping.ErrInvalidPolicy, err)
+	}
+	return p, nil
+}
+
+// validateNodeHousekeeping checks the housekeeping policy in node data.
+func validateNodeHousekeeping(data map[string]any) error {
+	p, err := nodeHousekeepingPolicy(data)
+	if err != nil {
+		return err
+	}
+	return housekeeping.Validate(p)
+}
+
+// housekeepingRunJSONAPI renders a run as a JSON:API resource object.
+func housekeepingRunJSONAPI(run *HousekeepingRun) map[string]any {
+	tasks := run.Tasks
+	if tasks == nil {
+		tasks = []housekeeping.Task{}
+	}
+	return map[string]any{
+		"type": "housekeeping-runs",
+		"id":   run.ReferenceID,
+		"attributes": map[string]any{
+			"node_id":         run.NodeID,
+			"triggered_by":    run.TriggeredBy,
+			"dry_run":         run.DryRun,
+			"status":          run.Status,
+			"tasks":           tasks,
+			"report":          run.Report,
+			"reclaimed_bytes": run.ReclaimedBytes,
+			"error_message":   run.ErrorMessage,
+			"created_at":      run.CreatedAt,
+			"started_at":      run.StartedAt.String,
+			"completed_at":    run.CompletedAt.String,
+		},
+	}
+}
+
+// =============================================================================
+// Housekeeping Handler
+// =============================================================================
+
+// nodeHousekeepingHandler handles GET and POST /nodes/{id}/housekeeping.
+// GET returns the effective policy, the next scheduled run, and recent runs.
+// POST runs the tasks now: a dry run (the default) executes inline and returns
+// the preview; a real run is queued and returned with 202. Creator only.
+func nodeHousekeepingHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		node, err := cfg.Store.Get(ctx, "nodes", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "node not found")
+			return
+		}
+		ownerID, ok := toInt64(node["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		refID := strVal(node["reference_id"])
+
+		policy, err := nodeHousekeepingPolicy(node)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			limit := 20
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+					limit = n
+				}
+			}
+			runs, err := cfg.Store.ListHousekeepingRuns(ctx, refID, limit)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list housekeeping runs")
+				return
+			}
+			data := make([]map[string]any, 0, len(runs))
+			for _, run := range runs {
+				data = append(data, housekeepingRunJSONAPI(run))
+			}
+			// The schedule is read in the creator's zone; next_run_at carries its offset
+			loc := cfg.Store.UserLocation(ctx, authCtx.UserID)
+			now := time.Now()
+			var nextRun string
+			if next, ok := housekeeping.NextRun(policy, now, loc); ok {
+				nextRun = next.Format(time.RFC3339)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"data": data,
+				"meta": map[string]any{
+					"policy":      policy.WithDefaults(),
+					"next_run_at": nextRun,
+					"timezone":    timezone.Describe(loc, now),
+				},
+			})
+			return
+		}
+
+		if cfg.Housekeeping == nil {
+			writeProblem(w, r, ProblemNotConfigured, "node housekeeping is not available")
+			return
+		}
+
+		var req struct {
+			DryRun *bool               `json:"dry_run"`
+			Tasks  []housekeeping.Task `json:"tasks"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+		}
+		if len(req.Tasks) > 0 {
+			if _, err := housekeeping.ParseTasks(req.Tasks); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+		}
+		dryRun := req.DryRun == nil || *req.DryRun
+
+		if status := strVal(node["status"]); status != "online" {
+			writeProblem(w, r, ProblemInvalidState, "node is "+status+", not online")
+			return
+		}
+		active, err := cfg.Store.HasActiveHousekeepingRun(ctx, refID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load housekeeping runs")
+			return
+		}
+		if active {
+			writeProblem(w, r, ProblemOperationInProgress, "a housekeeping run is already in progress")
+			return
+		}
+
+		run := &HousekeepingRun{
+			NodeID:      refID,
+			RequestedBy: int64(authCtx.UserID),
+			TriggeredBy: string(housekeeping.TriggerManual),
+			DryRun:      dryRun,
+			Tasks:       req.Tasks,
+		}
+		if err := cfg.Store.CreateHousekeepingRun(ctx, run); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to create housekeeping run")
+			return
+		}
+		if !dryRun {
+			writeJSON(w, http.StatusAccepted, map[string]any{"data": housekeepingRunJSONAPI(run)})
+			return
+		}
+
+		cfg.Housekeeping.Execute(ctx, run)
+		if run.Status == string(housekeeping.RunFailed) {
+			writeProblem(w, r, ProblemUpstreamFailed, "housekeeping preview failed: "+run.ErrorMessage)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": housekeepingRunJSONAPI(run)})
+	}
+}
+
+// =============================================================================
+// Housekeeping Scheduler Worker
+// =============================================================================
+
+// HousekeepingRunner executes housekeeping on a node. *docker.NodePool
+// implements it via the node's minion.
+type HousekeepingRunner interface {
+	Housekeeping(ctx context.Context, nodeID string, opts minion.HousekeepingOptions) (*minion.HousekeepingReport, error)
+}
+
+// HousekeepingScheduler queues a run for every online node whose housekeeping
+// schedule has come due, then executes pending runs one at a time. A node's
+// first scheduled run is the first match after the scheduler started, so a
+// restart never triggers a burst of missed runs.
+type HousekeepingScheduler struct {
+	store     *Store
+	runner    HousekeepingRunner
+	interval  time.Duration
+	timeout   time.Duration
+	startedAt time.Time
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewHousekeepingScheduler(store *Store, runner HousekeepingRunner, interval time.Duration, logger *slog.Logger) *HousekeepingScheduler {
+	if interval == 0 {
+		interval = time.Minute
+	}
+	return &HousekeepingScheduler{
+		store:     store,
+		runner:    runner,
+		interval:  interval,
+		timeout:   30 * time.Minute,
+		startedAt: time.Now(),
+		logger:    logger.With("component", "housekeeping"),
+	}
+}
+
+func (h *HousekeepingScheduler) Start() {
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.startedAt = time.Now()
+	h.wg.Add(1)
+	go h.run()
+	h.logger.Info("housekeeping scheduler started", "interval", h.interval)
+}
+
+func (h *HousekeepingScheduler) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.wg.Wait()
+}
+
+func (h *HousekeepingScheduler) run() {
+	defer h.wg.Done()
+	h.runOnce(h.ctx, time.Now())
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.runOnce(h.ctx, now)
+		}
+	}
+}
+
+func (h *HousekeepingScheduler) runOnce(ctx context.Context, now time.Time) {
+	defer h.store.LoopMetrics().Time("housekeeping")()
+	h.queueDue(ctx, now)
+
+	runs, err := h.store.ListRunnableHousekeepingRuns(ctx)
+	if err != nil {
+		h.logger.Error("failed to list housekeeping runs", "error", err)
+		return
+	}
+	for _, run := range runs {
+		if ctx.Err() != nil {
+			return
+		}
+		h.Execute(ctx, run)
+	}
+}
+
+// queueDue creates a scheduled run for each online node whose schedule, read
+// in the node creator's time zone, has matched since its last scheduled run.
+func (h *HousekeepingScheduler) queueDue(ctx context.Context, now time.Time) {
+	nodes, err := h.store.List(ctx, "nodes", []Filter{
+		{Field: "status", Value: "online"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		h.logger.Error("failed to list nodes", "error", err)
+		return
+	}
+
+	locs := newUserLocations(h.store)
+	for _, node := range nodes {
+		refID := strVal(node["reference_id"])
+		policy, err := nodeHousekeepingPolicy(node)
+		if err != nil || !policy.Enabled {
+			continue
+		}
+		last, ok, err := h.store.LastScheduledHousekeepingAt(ctx, refID)
+		if err != nil {
+			h.logger.Error("failed to load housekeeping history", "node", refID, "error", err)
+			continue
+		}
+		if !ok || last.Before(h.startedAt) {
+			last = h.startedAt
+		}
+		if !housekeeping.Due(policy, last, now, locs.get(ctx, toInt(node["creator_id"]))) {
+			continue
+		}
+		if active, err := h.store.HasActiveHousekeepingRun(ctx, refID); err != nil || active {
+			continue
+		}
+		run := &HousekeepingRun{NodeID: refID, TriggeredBy: string(housekeeping.TriggerSchedule)}
+		if err := h.store.CreateHousekeepingRun(ctx, run); err != nil {
+			h.logger.Error("failed to queue housekeeping run", "node", refID, "error", err)
+			continue
+		}
+		h.logger.Debug("queued scheduled housekeeping", "node", refID, "run", run.ReferenceID)
+	}
+}
+
+// Execute runs one housekeeping run on its node and records the report.
+// The run's tasks default to the node policy's; the policy also supplies
+// the thresholds. The run ends completed or failed.
+func (h *HousekeepingScheduler) Execute(ctx context.Context, run *HousekeepingRun) {
+	logger := h.logger.With("run", run.ReferenceID, "node", run.NodeID, "dry_run", run.DryRun)
+	save := func() {
+		if err := h.store.SaveHousekeepingRun(context.WithoutCancel(ctx), run); err != nil {
+			logger.Error("failed to save housekeeping run", "error", err)
+		}
+	}
+	fail := func(err error) {
+		logger.Warn("housekeeping run failed", "error", err)
+		run.Status = string(housekeeping.RunFailed)
+		run.ErrorMessage = err.Error()
+		run.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+		save()
+	}
+
+	node, err := h.store.Get(ctx, "nodes", run.NodeID)
+	if err != nil {
+		fail(fmt.Errorf("node not found"))
+		return
+	}
+	policy, err := nodeHousekeepingPolicy(node)
+	if err == nil {
+		err = housekeeping.Validate(policy)
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	run.Status = string(housekeeping.RunRunning)
+	run.StartedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	save()
+
+	runCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	report, err := h.runner.Housekeeping(runCtx, run.NodeID, housekeeping.Options(policy, run.Tasks, run.DryRun))
+	if err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: leave the run running so the next start repeats it
+			return
+		}
+		fail(err)
+		return
+	}
+
+	run.Report = report
+	run.Status = string(housekeeping.RunCompleted)
+	run.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	save()
+	logger.Info("housekeeping run completed", "reclaimed_bytes", report.ReclaimedBytes)
+}
@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/artpar/hoster/internal/core/idempotency"
+)
+
+// maxIdempotentBody bounds the request and response bodies kept for replay.
+const maxIdempotentBody = 1 << 20
+
+// =============================================================================
+// Idempotency Key Storage
+// =============================================================================
+//
+// idempotency_keys holds one row per (user, key). A row with status_code 0 is
+// a lock held by the request currently running; once it finishes the row
+// carries the response to replay. Timestamps are RFC3339Nano UTC.
+
+// idempotentResponse is a stored response served on replay.
+type idempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// AcquireIdempotencyKey claims a key for a request. It returns OutcomeProceed
+// when the caller now holds the key and must run the request, OutcomeReplay
+// with the stored response, or OutcomeMismatch/OutcomeInProgress to reject.
+func (s *Store) AcquireIdempotencyKey(ctx context.Context, userID int, key, method, path, fingerprint string, ttl time.Duration, now time.Time) (idempotency.Outcome, *idempotentResponse, error) {
+	nowStr := now.UTC().Format(time.RFC3339Nano)
+
+	// Clear an expired or abandoned row so the key can be claimed again.
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?
+			AND (expires_at <= ? OR (status_code = 0 AND created_at <= ?))`,
+		userID, key, nowStr, now.Add(-idempotency.LockTimeout).UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return "", nil, fmt.Errorf("clear idempotency key: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO idempotency_keys (user_id, key, method, path, fingerprint, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, key, method, path, fingerprint, nowStr, now.Add(ttl).UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return "", nil, fmt.Errorf("claim idempotency key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return idempotency.OutcomeProceed, nil, nil
+	}
+
+	var row struct {
+		Fingerprint string `db:"fingerprint"`
+		StatusCode  int    `db:"status_code"`
+		ContentType string `db:"content_type"`
+		Body        []byte `db:"response_body"`
+		CreatedAt   string `db:"created_at"`
+		ExpiresAt   string `db:"expires_at"`
+	}
+	err = s.db.GetContext(ctx, &row,
+		`SELECT fingerprint, status_code, content_type, response_body, created_at, expires_at
+		FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the read; treat as still running.
+		return idempotency.OutcomeInProgress, nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("load idempotency key: %w", err)
+	}
+
+	rec := &idempotency.Record{Fingerprint: row.Fingerprint, StatusCode: row.StatusCode}
+	rec.CreatedAt, _ = time.Parse(time.RFC3339Nano, row.CreatedAt)
+	rec.ExpiresAt, _ = time.Parse(time.RFC3339Nano, row.ExpiresAt)
+
+	outcome := idempotency.Evaluate(rec, fingerprint, now)
+	if outcome == idempotency.OutcomeProceed {
+		// The row was cleared above; another request claimed it since.
+		outcome = idempotency.OutcomeInProgress
+	}
+	if outcome != idempotency.OutcomeReplay {
+		return outcome, nil, nil
+	}
+	return outcome, &idempotentResponse{StatusCode: row.StatusCode, ContentType: row.ContentType, Body: row.Body}, nil
+}
+
+// CompleteIdempotencyKey stores the response for a key claimed by AcquireIdempotencyKey.
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, userID int, key string, resp idempotentResponse) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ?
+		WHERE user_id = ? AND key = ?`,
+		resp.StatusCode, resp.ContentType, resp.Body, userID, key)
+	if err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a claimed key so the request can be retried.
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key)
+	if err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PruneIdempotencyKeys deletes keys that expired before now.
+func (s *Store) PruneIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, fmt.Errorf("prune idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// =============================================================================
+// Idempotency Middleware
+// =============================================================================
+
+// IdempotencyMiddleware makes authenticated POST requests under /api/{version}/
+// that carry an Idempotency-Key header safe to retry. The first request runs
+// and its response is stored for ttl; repeats with the same key and body get
+// the stored response back. Keys are scoped per user. Server errors are not
+// stored so they can be retried. Must run after AuthMiddleware.
+func IdempotencyMiddleware(store *Store, ttl time.Duration, logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if ttl <= 0 {
+		ttl = idempotency.DefaultTTL
+	}
+	logger = logger.With("component", "idempotency")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotency.HeaderKey)
+			if _, versioned := apiversion.FromPath(r.URL.Path); key == "" || r.Method != http.MethodPost || !versioned {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authCtx := getAuthContext(r)
+			if !authCtx.Authenticated {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err := idempotency.ValidateKey(key); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, err.Error())
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+			if err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "failed to read request body")
+				return
+			}
+			if len(body) > maxIdempotentBody {
+				writeProblem(w, r, ProblemPayloadTooLarge, "request body too large for idempotent request")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			fingerprint := idempotency.Fingerprint(r.Method, r.URL.Path, body)
+			outcome, stored, err := store.AcquireIdempotencyKey(ctx, authCtx.UserID, key, r.Method, r.URL.Path, fingerprint, ttl, time.Now())
+			if err != nil {
+				logger.Error("failed to acquire idempotency key", "user_id", authCtx.UserID, "error", err)
+				writeProblem(w, r, ProblemInternal, "failed to process idempotency key")
+				return
+			}
+
+			switch outcome {
+			case idempotency.OutcomeReplay:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set(idempotency.HeaderReplayed, "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			case idempotency.OutcomeMismatch:
+				writeProblem(w, r, ProblemIdempotencyKeyReused, "idempotency key was already used for a different request")
+				return
+			case idempotency.OutcomeInProgress:
+				writeProblem(w, r, ProblemOperationInProgress, "a request with this idempotency key is still in progress")
+				return
+			}
+
+			rec := &idempotencyRecorder{ResponseWriter: w}
+			completed := false
+			defer func() {
+				// Release the key if the handler panicked so retries can run.
+				if !completed {
+					if err := store.ReleaseIdempotencyKey(context.WithoutCancel(ctx), authCtx.UserID, key); err != nil {
+						logger.Error("failed to release idempotency key", "user_id", authCtx.UserID, "error", err)
+					}
+				}
+			}()
+			next.ServeHTTP(rec, r)
+
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if !idempotency.ShouldStore(status) || rec.overflow {
+				return
+			}
+			resp := idempotentResponse{StatusCode: status, ContentType: rec.Header().Get("Content-Type"), Body: rec.body.Bytes()}
+			if err := store.CompleteIdempotencyKey(context.WithoutCancel(ctx), authCtx.UserID, key, resp); err != nil {
+				logger.Error("failed to store idempotent response", "user_id", authCtx.UserID, "error", err)
+				return
+			}
+			completed = true
+			if _, err := store.PruneIdempotencyKeys(context.WithoutCancel(ctx), time.Now()); err != nil {
+				logger.Warn("failed to prune idempotency keys", "error", err)
+			}
+		})
+	}
+}
+
+// idempotencyRecorder passes the response through while capturing it for replay.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > maxIdempotentBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
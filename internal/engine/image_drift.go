@@ -0,0 +1,301 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Image Drift Checker
+// =============================================================================
+
+// ImageDriftChecker periodically asks the registry, through each running
+// deployment's node, which digest its pinned image tags point at now, and
+// records the services whose tag has moved on in the deployment's
+// image_drift. Containers keep running their pinned digest until the
+// customer updates the deployment's images.
+type ImageDriftChecker struct {
+	store    *Store
+	nodePool *docker.NodePool
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewImageDriftChecker(store *Store, nodePool *docker.NodePool, interval time.Duration, logger *slog.Logger) *ImageDriftChecker {
+	if interval == 0 {
+		interval = 6 * time.Hour
+	}
+	return &ImageDriftChecker{
+		store:    store,
+		nodePool: nodePool,
+		interval: interval,
+		logger:   logger.With("component", "image_drift"),
+	}
+}
+
+func (c *ImageDriftChecker) Start() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.run()
+	c.logger.Info("image drift checker started", "interval", c.interval)
+}
+
+func (c *ImageDriftChecker) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *ImageDriftChecker) run() {
+	defer c.wg.Done()
+	c.checkAll()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkAll()
+		}
+	}
+}
+
+func (c *ImageDriftChecker) checkAll() {
+	defer c.store.LoopMetrics().Time("image_drift")()
+	deployments, err := c.store.List(c.ctx, "deployments", []Filter{{Field: "status", Value: "running"}}, Page{Limit: 10000})
+	if err != nil {
+		c.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+	for _, depl := range deployments {
+		if c.ctx.Err() != nil {
+			return
+		}
+		refID := strVal(depl["reference_id"])
+		drift, err := c.Check(c.ctx, depl)
+		if err != nil {
+			c.logger.Debug("image drift check failed", "deployment", refID, "error", err)
+			continue
+		}
+		if len(drift) > 0 {
+			c.logger.Info("deployment images drifted", "deployment", refID, "services", len(drift))
+		}
+	}
+}
+
+// Check compares the deployment's pinned digests with the ones their tags
+// point at now and records the result. Deployments without pinned images,
+// such as ones on air-gapped nodes, never drift.
+func (c *ImageDriftChecker) Check(ctx context.Context, depl map[string]any) ([]coredeployment.ImageDrift, error) {
+	var containers []domain.ContainerInfo
+	if err := decodeJSONValue(depl["containers"], &containers); err != nil {
+		return nil, fmt.Errorf("invalid containers: %w", err)
+	}
+
+	var drift []coredeployment.ImageDrift
+	if tags := coredeployment.PinnedTags(containers); len(tags) > 0 {
+		nodeID := strVal(depl["node_id"])
+		client, err := c.nodePool.GetClient(ctx, nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("docker client for node %s: %w", nodeID, err)
+		}
+		resolver, ok := client.(docker.ImageResolver)
+		if !ok {
+			return nil, fmt.Errorf("node %s client cannot resolve image digests", nodeID)
+		}
+		latest := make(map[string]string, len(tags))
+		for _, tag := range tags {
+			digest, err := resolver.RegistryDigest(ctx, tag)
+			if err != nil {
+				return nil, fmt.Errorf("resolve %s: %w", tag, err)
+			}
+			latest[tag] = digest
+		}
+		drift = coredeployment.DetectImageDrift(containers, latest)
+	}
+
+	updates := map[string]any{
+		"image_drift":            nil,
+		"image_drift_checked_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(drift) > 0 {
+		driftJSON, _ := json.Marshal(drift)
+		updates["image_drift"] = string(driftJSON)
+	}
+	if _, err := c.store.Update(ctx, "deployments", strVal(depl["reference_id"]), updates); err != nil {
+		return nil, err
+	}
+	return drift, nil
+}
+
+// =============================================================================
+// Update Images Command
+// =============================================================================
+
+// updateDeploymentImages recreates a running deployment's containers from
+// the digests their image tags point at now, replacing the old pins.
+// Volumes are kept. If the old containers were already removed when this
+// fails, the deployment is marked failed.
+func updateDeploymentImages(ctx context.Context, deps *Deps, data map[string]any) error {
+	store := deps.Store
+	refID := strVal(data["reference_id"])
+
+	u, err := prepareUpgrade(ctx, deps, data)
+	if err != nil {
+		store.Update(ctx, "deployments", refID, map[string]any{"error_message": fmt.Sprintf("image update failed: %v", err)})
+		return fmt.Errorf("%s: image update failed: %w", refID, err)
+	}
+	for i := range u.depl.Containers {
+		u.depl.Containers[i].Digest = ""
+	}
+	u.orchestrator.SetPullLatest()
+
+	if err := u.orchestrator.RemoveDeployment(ctx, u.depl); err != nil {
+		store.Update(ctx, "deployments", refID, map[string]any{"error_message": fmt.Sprintf("image update failed: remove old containers: %v", err)})
+		return fmt.Errorf("%s: image update failed: %w", refID, err)
+	}
+	containers, err := u.orchestrator.StartDeployment(ctx, u.depl, u.composeSpec, templateConfigFiles(u.tmpl))
+	if err != nil {
+		return failDeployment(ctx, store, refID, fmt.Sprintf("image update failed: %v", err))
+	}
+
+	containersJSON, _ := json.Marshal(containers)
+	store.Update(ctx, "deployments", refID, map[string]any{
+		"containers":             string(containersJSON),
+		"health":                 nil,
+		"image_drift":            nil,
+		"image_drift_checked_at": time.Now().UTC().Format(time.RFC3339),
+		"error_message":          "",
+	})
+
+	deps.Logger.Info("deployment images updated", "deployment", refID, "containers", len(containers))
+	return nil
+}
+
+// =============================================================================
+// Image Handlers
+// =============================================================================
+
+// imageDriftHandler serves GET /deployments/{id}/images/drift: it checks
+// the deployment's pinned images against their registries now and reports
+// the services whose tag points at a different digest.
+func imageDriftHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := mux.Vars(r)["id"]
+
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
+			return
+		}
+		if cfg.ImageDrift == nil {
+			writeProblem(w, r, ProblemNotConfigured, "image drift checks need remote nodes")
+			return
+		}
+
+		drift, err := cfg.ImageDrift.Check(ctx, depl)
+		if err != nil {
+			writeProblem(w, r, ProblemUpstreamFailed, err.Error())
+			return
+		}
+		if drift == nil {
+			drift = []coredeployment.ImageDrift{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "image-drift",
+				"id":   strVal(depl["reference_id"]),
+				"attributes": map[string]any{
+					"drifted":  len(drift) > 0,
+					"services": drift,
+				},
+			},
+		})
+	}
+}
+
+// imageUpdateHandler serves POST /deployments/{id}/images/update. It
+// recreates a running deployment's containers from the digests their tags
+// point at now, in the background. A deployment with a pending template
+// upgrade must be upgraded instead, so an image update never upgrades it
+// by the way.
+func imageUpdateHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+		if strVal(depl["status"]) != "running" {
+			writeProblem(w, r, ProblemInvalidState, "cannot update images of a deployment in state: "+strVal(depl["status"]))
+			return
+		}
+		if coredeployment.UpgradeStatus(strVal(depl["upgrade_status"])) == coredeployment.UpgradeStatusCanary {
+			writeProblem(w, r, ProblemInvalidState, "deployment has a canary running")
+			return
+		}
+		tmpl, err := cfg.Store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		if coredeployment.NeedsUpgrade(strVal(depl["template_version"]), strVal(tmpl["version"])) {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("deployment has a pending upgrade to %s; upgrade it instead", strVal(tmpl["version"])))
+			return
+		}
+		if cfg.Bus == nil {
+			writeProblem(w, r, ProblemInternal, "command bus not configured")
+			return
+		}
+
+		if _, err := cfg.Bus.Enqueue(ctx, "UpdateDeploymentImages", depl); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to queue the image update")
+			return
+		}
+
+		res := cfg.Store.Resource("deployments")
+		stripFields(res, depl, cfg.Store, authCtx)
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployments", depl),
+		})
+	}
+}
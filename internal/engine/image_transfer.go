@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Air-gapped Nodes
+// =============================================================================
+
+// An air_gapped node has no registry access. Its images are pulled by its
+// image_relay_id node (or, without one, by the hoster host) and streamed to it
+// over SSH with docker.TransferImage, which verifies the loaded image ID
+// against the relay's before containers are created.
+
+// validateImageRelay checks a node's air-gap settings: the relay must be
+// another of the owner's nodes, with registry access of its own, and a node
+// that relays for others cannot become air-gapped.
+func validateImageRelay(ctx context.Context, store *Store, ownerID int, selfRefID string, airGapped bool, relayRef string) error {
+	if airGapped && selfRefID != "" {
+		rows, err := store.RawQuery(ctx, `SELECT reference_id FROM nodes WHERE image_relay_id = ? AND air_gapped = 1 LIMIT 1`, selfRefID)
+		if err == nil && len(rows) > 0 {
+			return fmt.Errorf("node relays images for %s and must keep registry access", strVal(rows[0]["reference_id"]))
+		}
+	}
+	if relayRef == "" {
+		return nil
+	}
+	if relayRef == selfRefID {
+		return fmt.Errorf("image_relay_id cannot reference the node itself")
+	}
+	relay, err := store.Get(ctx, "nodes", relayRef)
+	if err != nil {
+		return fmt.Errorf("image_relay_id: node %s not found", relayRef)
+	}
+	if creatorID, _ := toInt64(relay["creator_id"]); int(creatorID) != ownerID {
+		return fmt.Errorf("image_relay_id: node %s not found", relayRef)
+	}
+	if boolVal(relay["air_gapped"]) {
+		return fmt.Errorf("image_relay_id: node %s is air-gapped", relayRef)
+	}
+	return nil
+}
+
+// configureImageFetch makes the orchestrator of an air-gapped node fetch
+// missing images through its relay instead of a registry.
+func configureImageFetch(ctx context.Context, deps *Deps, o *docker.Orchestrator, nodeID string, target docker.Client) error {
+	node, err := deps.Store.Get(ctx, "nodes", nodeID)
+	if err != nil || !boolVal(node["air_gapped"]) {
+		return nil
+	}
+	sink, ok := target.(docker.ImageSink)
+	if !ok {
+		return fmt.Errorf("node %s is air-gapped but its client cannot load images", nodeID)
+	}
+	relayRef := strVal(node["image_relay_id"])
+	logger := deps.Logger
+
+	o.SetImageFetcher(func(ctx context.Context, image string) error {
+		src, closeSrc, err := imageRelaySource(ctx, deps, relayRef)
+		if err != nil {
+			return err
+		}
+		defer closeSrc()
+
+		res, err := docker.TransferImage(ctx, src, sink, image)
+		if err != nil {
+			return err
+		}
+		logger.Info("transferred image to air-gapped node", "node", nodeID, "relay", relayOrHost(relayRef),
+			"image", image, "image_id", res.ImageID, "bytes", res.Size, "skipped", res.Skipped)
+		return nil
+	})
+	return nil
+}
+
+// imageRelaySource returns the Docker host that pulls images for an
+// air-gapped node: its relay node, or the hoster host when none is set.
+func imageRelaySource(ctx context.Context, deps *Deps, relayRef string) (docker.ImageSource, func(), error) {
+	if relayRef == "" {
+		local, err := docker.NewDockerClient("")
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to local docker: %w", err)
+		}
+		return local, func() { local.Close() }, nil
+	}
+	nodePool := getNodePool(deps)
+	if nodePool == nil {
+		return nil, nil, fmt.Errorf("node pool not configured")
+	}
+	client, err := nodePool.GetClient(ctx, relayRef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("image relay %s: %w", relayRef, err)
+	}
+	src, ok := client.(docker.ImageSource)
+	if !ok {
+		return nil, nil, fmt.Errorf("image relay %s cannot export images", relayRef)
+	}
+	return src, func() {}, nil
+}
+
+func relayOrHost(relayRef string) string {
+	if relayRef == "" {
+		return "hoster"
+	}
+	return relayRef
+}
+
+// boolVal converts a boolean column value, stored as an integer, to bool.
+func boolVal(v any) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case int64:
+		return val != 0
+	case int:
+		return val != 0
+	case float64:
+		return val != 0
+	}
+	return false
+}
@@ -0,0 +1,495 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/incident"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Incident Rows
+// =============================================================================
+//
+// An incident's operator is the node creator who reported it. Its scope names
+// the operator's own nodes and the deployments running on them; locations
+// stand for every node of the operator there, so nodes added to a location
+// during the incident are covered too.
+
+// incidentScope decodes an incident's affected nodes, deployments and locations.
+func incidentScope(row map[string]any) incident.Scope {
+	var s incident.Scope
+	_ = decodeJSONValue(row["affected_nodes"], &s.Nodes)
+	_ = decodeJSONValue(row["affected_deployments"], &s.Deployments)
+	_ = decodeJSONValue(row["affected_locations"], &s.Locations)
+	return s
+}
+
+func incidentUpdates(row map[string]any) []incident.Update {
+	var updates []incident.Update
+	_ = decodeJSONValue(row["updates"], &updates)
+	return updates
+}
+
+func incidentFromRow(row map[string]any) incident.Incident {
+	startedAt, _ := parseTime(row["started_at"])
+	return incident.Incident{
+		ID:        strVal(row["reference_id"]),
+		Title:     strVal(row["title"]),
+		Severity:  incident.Severity(strVal(row["severity"])),
+		Status:    incident.Status(strVal(row["status"])),
+		StartedAt: startedAt,
+		Updates:   incidentUpdates(row),
+	}
+}
+
+// operatorNodes returns the reference IDs and locations of a user's nodes.
+func operatorNodes(ctx context.Context, store *Store, ownerID int) ([]incident.Node, error) {
+	rows, err := store.List(ctx, "nodes", []Filter{{Field: "creator_id", Value: ownerID}}, Page{Limit: 1000})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]incident.Node, 0, len(rows))
+	for _, row := range rows {
+		nodes = append(nodes, incident.Node{ID: strVal(row["reference_id"]), Location: strVal(row["location"])})
+	}
+	return nodes, nil
+}
+
+// validateIncidentScope checks that an incident only names the operator's own
+// nodes and deployments running on them.
+func validateIncidentScope(ctx context.Context, store *Store, ownerID int, scope incident.Scope) error {
+	if err := scope.Validate(); err != nil {
+		return err
+	}
+	nodes, err := operatorNodes(ctx, store, ownerID)
+	if err != nil {
+		return fmt.Errorf("load nodes: %w", err)
+	}
+	owned := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		owned[n.ID] = true
+	}
+	for _, ref := range scope.Nodes {
+		if !owned[ref] {
+			return fmt.Errorf("affected node %s not found", ref)
+		}
+	}
+	for _, ref := range scope.Deployments {
+		depl, err := store.Get(ctx, "deployments", ref)
+		if err != nil || !owned[strVal(depl["node_id"])] {
+			return fmt.Errorf("affected deployment %s not found on your nodes", ref)
+		}
+	}
+	return nil
+}
+
+// incidentBeforeCreate validates a new incident and starts its timeline with
+// the description, if any.
+func incidentBeforeCreate(store *Store) BeforeCreateFunc {
+	return func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+		if err := validateIncidentScope(ctx, store, authCtx.UserID, incidentScope(data)); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		data["status"] = string(incident.StatusInvestigating)
+		data["started_at"] = now.Format(time.RFC3339)
+		updates := []incident.Update{}
+		if desc := strVal(data["description"]); desc != "" {
+			u, err := incident.NewUpdate(incident.StatusInvestigating, desc, now)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, u)
+		}
+		data["updates"] = updates
+		return nil
+	}
+}
+
+// incidentBeforeUpdate re-validates the scope when it changes. Resolved
+// incidents are part of the record and cannot be edited.
+func incidentBeforeUpdate(store *Store) BeforeUpdateFunc {
+	return func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+		if !incident.Status(strVal(existing["status"])).Open() {
+			return fmt.Errorf("resolved incidents cannot be edited")
+		}
+		_, nodes := data["affected_nodes"]
+		_, depls := data["affected_deployments"]
+		_, locs := data["affected_locations"]
+		if !nodes && !depls && !locs {
+			return nil
+		}
+		merged := make(map[string]any, 3)
+		for _, k := range []string{"affected_nodes", "affected_deployments", "affected_locations"} {
+			merged[k] = existing[k]
+			if v, ok := data[k]; ok {
+				merged[k] = v
+			}
+		}
+		return validateIncidentScope(ctx, store, authCtx.UserID, incidentScope(merged))
+	}
+}
+
+// openIncidents returns the incidents that are not resolved.
+func openIncidents(ctx context.Context, store *Store) ([]map[string]any, error) {
+	rows, err := store.List(ctx, "incidents", nil, Page{Limit: 1000})
+	if err != nil {
+		return nil, err
+	}
+	open := rows[:0]
+	for _, row := range rows {
+		if incident.Status(strVal(row["status"])).Open() {
+			open = append(open, row)
+		}
+	}
+	return open, nil
+}
+
+// expandedScopes returns each incident's scope with its operator's nodes in
+// the affected locations added, loading each operator's nodes once.
+func expandedScopes(ctx context.Context, store *Store, incidents []map[string]any) []incident.Scope {
+	nodesByOwner := map[int][]incident.Node{}
+	scopes := make([]incident.Scope, len(incidents))
+	for i, row := range incidents {
+		scope := incidentScope(row)
+		if len(scope.Locations) > 0 {
+			owner := toInt(row["creator_id"])
+			nodes, ok := nodesByOwner[owner]
+			if !ok {
+				nodes, _ = operatorNodes(ctx, store, owner)
+				nodesByOwner[owner] = nodes
+			}
+			scope = scope.Expand(nodes)
+		}
+		scopes[i] = scope
+	}
+	return scopes
+}
+
+// =============================================================================
+// Deployment Banners
+// =============================================================================
+
+// incidentBanners adds an "incidents" list to deployments affected by an open
+// incident, most severe first. Unaffected deployments are left unchanged.
+func incidentBanners(store *Store, logger *slog.Logger) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		incidents, err := openIncidents(ctx, store)
+		if err != nil {
+			logger.Warn("failed to load incidents for banners", "error", err)
+			return
+		}
+		if len(incidents) == 0 {
+			return
+		}
+		scopes := expandedScopes(ctx, store, incidents)
+
+		for _, row := range rows {
+			var banners []incident.Banner
+			for i, inc := range incidents {
+				if scopes[i].Affects(strVal(row["reference_id"]), strVal(row["node_id"])) {
+					banners = append(banners, incident.BannerFor(incidentFromRow(inc)))
+				}
+			}
+			if len(banners) > 0 {
+				incident.SortBanners(banners)
+				row["incidents"] = banners
+			}
+		}
+	}
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// incidentUpdateHandler handles POST /incidents/{id}/updates: the operator
+// appends a message to the timeline and optionally moves the incident to a
+// new status. Resolving it records resolved_at.
+func incidentUpdateHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "incidents", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "incident not found")
+			return
+		}
+		ownerID, ok := toInt64(row["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		current := incident.Status(strVal(row["status"]))
+		if !current.Open() {
+			writeProblem(w, r, ProblemInvalidState, "incident is resolved")
+			return
+		}
+
+		var req struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		status := current
+		if req.Status != "" {
+			if status, err = incident.ParseStatus(req.Status); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+		}
+		now := time.Now().UTC()
+		update, err := incident.NewUpdate(status, req.Message, now)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		updated, err := cfg.Store.Update(ctx, "incidents", id, resolveIncidentChanges(row, update, now))
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to update incident")
+			return
+		}
+
+		res := cfg.Store.Resource("incidents")
+		stripFields(res, updated, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "incidents", updated),
+		})
+	}
+}
+
+// resolveIncidentChanges returns the column changes that append update to an
+// incident's timeline.
+func resolveIncidentChanges(row map[string]any, update incident.Update, now time.Time) map[string]any {
+	changes := map[string]any{
+		"status":  string(update.Status),
+		"updates": append(incidentUpdates(row), update),
+	}
+	if !update.Status.Open() {
+		changes["resolved_at"] = now.Format(time.RFC3339)
+		changes["recovered_since"] = nil
+	}
+	return changes
+}
+
+// statusRecentWindow is how long resolved incidents stay on the status API.
+const statusRecentWindow = 7 * 24 * time.Hour
+
+// statusHandler serves the public GET /status: open incidents, incidents
+// resolved in the last 7 days, and an indicator naming the most severe open
+// incident. It never reveals which nodes or deployments are affected.
+func statusHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := cfg.Store.List(r.Context(), "incidents", nil, Page{Limit: 1000})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list incidents")
+			return
+		}
+
+		cutoff := time.Now().Add(-statusRecentWindow)
+		indicator := "none"
+		open := []map[string]any{}
+		recent := []map[string]any{}
+		for _, row := range rows {
+			inc := incidentFromRow(row)
+			if inc.Status.Open() {
+				open = append(open, publicIncident(row, inc))
+				if inc.Severity.Rank() > incident.Severity(indicator).Rank() {
+					indicator = string(inc.Severity)
+				}
+				continue
+			}
+			if resolvedAt, ok := parseTime(row["resolved_at"]); ok && resolvedAt.After(cutoff) {
+				recent = append(recent, publicIncident(row, inc))
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "status",
+				"id":   "current",
+				"attributes": map[string]any{
+					"indicator": indicator,
+					"incidents": open,
+					"recent":    recent,
+				},
+			},
+		})
+	}
+}
+
+func publicIncident(row map[string]any, inc incident.Incident) map[string]any {
+	var locations []string
+	_ = decodeJSONValue(row["affected_locations"], &locations)
+	out := map[string]any{
+		"id":         inc.ID,
+		"title":      inc.Title,
+		"severity":   inc.Severity,
+		"status":     inc.Status,
+		"locations":  locations,
+		"started_at": inc.StartedAt,
+		"updates":    inc.Updates,
+	}
+	if resolvedAt, ok := parseTime(row["resolved_at"]); ok {
+		out["resolved_at"] = resolvedAt
+	}
+	return out
+}
+
+// =============================================================================
+// Incident Monitor
+// =============================================================================
+
+// IncidentMonitor resolves open incidents with auto_resolve once every node
+// and deployment they affect has stayed healthy for the recovery window.
+// Nodes are healthy when online; deployments when running and not unhealthy.
+// Deleted nodes and deployments are not checked.
+type IncidentMonitor struct {
+	store    *Store
+	interval time.Duration
+	window   time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewIncidentMonitor(store *Store, interval, window time.Duration, logger *slog.Logger) *IncidentMonitor {
+	if interval == 0 {
+		interval = time.Minute
+	}
+	if window == 0 {
+		window = incident.DefaultRecoveryWindow
+	}
+	return &IncidentMonitor{
+		store:    store,
+		interval: interval,
+		window:   window,
+		logger:   logger.With("component", "incident_monitor"),
+	}
+}
+
+func (m *IncidentMonitor) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("incident monitor started", "interval", m.interval, "recovery_window", m.window)
+}
+
+func (m *IncidentMonitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *IncidentMonitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkAll()
+		}
+	}
+}
+
+func (m *IncidentMonitor) checkAll() {
+	defer m.store.LoopMetrics().Time("incident_monitor")()
+	incidents, err := openIncidents(m.ctx, m.store)
+	if err != nil {
+		m.logger.Error("failed to list incidents", "error", err)
+		return
+	}
+	var auto []map[string]any
+	for _, row := range incidents {
+		if boolVal(row["auto_resolve"]) {
+			auto = append(auto, row)
+		}
+	}
+	scopes := expandedScopes(m.ctx, m.store, auto)
+	for i, row := range auto {
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.check(row, scopes[i])
+	}
+}
+
+// check evaluates one incident and records its recovery or resolves it.
+func (m *IncidentMonitor) check(row map[string]any, scope incident.Scope) {
+	refID := strVal(row["reference_id"])
+	now := time.Now().UTC()
+	recoveredSince, _ := parseTime(row["recovered_since"])
+
+	rec := incident.EvaluateRecovery(m.targets(scope), recoveredSince, now, m.window)
+	if !rec.Resolve {
+		var since any
+		if !rec.RecoveredSince.IsZero() {
+			since = rec.RecoveredSince.Format(time.RFC3339)
+		}
+		if !rec.RecoveredSince.Equal(recoveredSince) {
+			m.store.Update(m.ctx, "incidents", refID, map[string]any{"recovered_since": since})
+		}
+		return
+	}
+
+	update := incident.Update{
+		Status:    incident.StatusResolved,
+		Message:   "Automatically resolved: " + rec.Reason + ".",
+		At:        now,
+		Automatic: true,
+	}
+	if _, err := m.store.Update(m.ctx, "incidents", refID, resolveIncidentChanges(row, update, now)); err != nil {
+		m.logger.Error("failed to resolve incident", "incident", refID, "error", err)
+		return
+	}
+	m.logger.Info("incident resolved automatically", "incident", refID, "reason", rec.Reason)
+}
+
+// targets returns the health of the nodes and deployments in a scope.
+func (m *IncidentMonitor) targets(scope incident.Scope) []incident.Target {
+	var targets []incident.Target
+	for _, ref := range scope.Nodes {
+		node, err := m.store.Get(m.ctx, "nodes", ref)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, incident.Target{Kind: "node", ID: ref, Healthy: strVal(node["status"]) == "online"})
+	}
+	for _, ref := range scope.Deployments {
+		depl, err := m.store.Get(m.ctx, "deployments", ref)
+		if err != nil || strVal(depl["status"]) == "deleted" {
+			continue
+		}
+		var health domain.DeploymentHealth
+		_ = decodeJSONValue(depl["health"], &health)
+		healthy := strVal(depl["status"]) == "running" && health.Status != domain.HealthStatusUnhealthy
+		targets = append(targets, incident.Target{Kind: "deployment", ID: ref, Healthy: healthy})
+	}
+	return targets
+}
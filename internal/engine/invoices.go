@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/costs"
+	"github.com/artpar/hoster/internal/core/invoice"
+	"github.com/artpar/hoster/internal/core/spending"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Invoices
+// =============================================================================
+//
+// The invoice generator bills each customer monthly: a line per running,
+// non-trial deployment at its template's monthly price, and a line per
+// metered usage event type priced with billing.usage_prices, overridden by
+// billing.plan_usage_prices for the customer's plan. The current month's
+// draft is kept up to date; the previous month's draft has its usage lines
+// finished with what was metered after its last update.
+
+// MonthUsage returns each customer's metered usage in the UTC month starting
+// at start, from usage_events and, once rolled up, usage_daily.
+func (s *Store) MonthUsage(ctx context.Context, start time.Time) (map[int][]spending.Usage, error) {
+	start, end := costs.MonthBounds(start)
+	var rows []struct {
+		UserID    int    `db:"user_id"`
+		EventType string `db:"event_type"`
+		Quantity  int64  `db:"quantity"`
+	}
+	if err := s.db.SelectContext(ctx, &rows,
+		`SELECT user_id, event_type, COALESCE(SUM(quantity), 0) AS quantity
+		FROM usage_events WHERE substr(timestamp, 1, 7) = ? GROUP BY user_id, event_type
+		UNION ALL
+		SELECT user_id, event_type, SUM(quantity) AS quantity
+		FROM usage_daily WHERE day >= ? AND day < ? GROUP BY user_id, event_type`,
+		start.Format(costs.MonthLayout), start.Format(time.DateOnly), end.Format(time.DateOnly)); err != nil {
+		return nil, fmt.Errorf("sum usage: %w", err)
+	}
+	usage := make(map[int][]spending.Usage)
+	for _, r := range rows {
+		if r.UserID == 0 {
+			continue
+		}
+		usage[r.UserID] = append(usage[r.UserID], spending.Usage{EventType: r.EventType, Quantity: r.Quantity})
+	}
+	return usage, nil
+}
+
+// userPlans returns the plan ID of every user that has one.
+func (s *Store) userPlans(ctx context.Context) (map[int]string, error) {
+	var rows []struct {
+		ID     int    `db:"id"`
+		PlanID string `db:"plan_id"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, `SELECT id, COALESCE(plan_id, '') AS plan_id FROM users WHERE COALESCE(plan_id, '') != ''`); err != nil {
+		return nil, fmt.Errorf("list user plans: %w", err)
+	}
+	plans := make(map[int]string, len(rows))
+	for _, r := range rows {
+		plans[r.ID] = r.PlanID
+	}
+	return plans, nil
+}
+
+// invoiceDocument returns an invoice row as exported.
+func invoiceDocument(ctx context.Context, store *Store, row map[string]any) invoice.Document {
+	var lines []invoice.Line
+	decodeJSONValue(row["items"], &lines)
+	ownerID, _ := toInt64(row["user_id"])
+	var customer string
+	store.db.GetContext(ctx, &customer, `SELECT COALESCE(NULLIF(email, ''), reference_id) FROM users WHERE id = ?`, ownerID)
+
+	subtotal, _ := toInt64(row["subtotal_cents"])
+	tax, _ := toInt64(row["tax_cents"])
+	total, _ := toInt64(row["total_cents"])
+	periodStart, _ := parseTime(row["period_start"])
+	periodEnd, _ := parseTime(row["period_end"])
+	return invoice.Document{
+		Number:        strVal(row["reference_id"]),
+		Customer:      customer,
+		Status:        strVal(row["status"]),
+		Currency:      strVal(row["currency"]),
+		PeriodStart:   periodStart,
+		PeriodEnd:     periodEnd,
+		Lines:         lines,
+		SubtotalCents: subtotal,
+		TaxCents:      tax,
+		TotalCents:    total,
+	}
+}
+
+// invoiceExportHandler downloads an invoice as PDF or JSON.
+// GET /api/v1/invoices/{id}/export?format=pdf|json
+func invoiceExportHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		if format == "" {
+			format = "pdf"
+		}
+		if format != "pdf" && format != "json" {
+			writeProblem(w, r, ProblemValidationFailed, "format must be pdf or json")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "invoices", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "invoice not found")
+			return
+		}
+		ownerID, ok := toInt64(row["user_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		doc := invoiceDocument(ctx, cfg.Store, row)
+		filename := fmt.Sprintf("%s-%s.%s", doc.Number, doc.PeriodStart.UTC().Format(costs.MonthLayout), format)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if format == "json" {
+			writeJSON(w, http.StatusOK, doc)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.WriteHeader(http.StatusOK)
+		w.Write(invoice.RenderPDF(doc))
+	}
+}
@@ -0,0 +1,768 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/jobs"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Jobs
+// =============================================================================
+//
+// jobs is the persistent queue behind Enqueue and delayed dispatch. A
+// command enqueued by an API request is stored before the request returns
+// and run by the Bus's worker pool, so a restart doesn't lose it. A running
+// job is claimed by its bus until claimed_until, and the claim is renewed
+// while the job runs; a job whose claim lapsed, because its bus crashed or
+// lost leadership, is queued again, so handlers must tolerate being called
+// twice. A failing job is retried with
+// exponential backoff until its attempts run out; it is then dead, and waits
+// for an administrator to requeue it. The jobs of one resource run one at a
+// time, in the order they were enqueued.
+//
+// A delayed job is queued with a future run_at and a caller-chosen key, so
+// it can be rescheduled or cancelled without keeping its ID. Delayed jobs
+// run at their time, apart from the order of their resource's other jobs.
+
+// Job is a command queued to run on the worker pool.
+type Job struct {
+	ID           int64          `db:"id"`
+	ReferenceID  string         `db:"reference_id"`
+	Command      string         `db:"command"`
+	ResourceType string         `db:"resource_type"`
+	ResourceID   string         `db:"resource_id"`
+	Key          string         `db:"key"` // Set for delayed jobs
+	DataJSON     string         `db:"data"`
+	Status       string         `db:"status"`
+	RunAt        string         `db:"run_at"`
+	Attempts     int            `db:"attempts"`
+	MaxAttempts  int            `db:"max_attempts"`
+	LastError    string         `db:"last_error"`
+	ExecutionID  string         `db:"execution_id"`
+	ClaimedBy    string         `db:"claimed_by"`
+	ClaimedUntil sql.NullString `db:"claimed_until"`
+	CreatedAt    string         `db:"created_at"`
+	UpdatedAt    string         `db:"updated_at"`
+	FinishedAt   sql.NullString `db:"finished_at"`
+}
+
+const jobColumns = `id, reference_id, command, resource_type, resource_id, key, data, status, run_at, attempts,
+	max_attempts, last_error, execution_id, claimed_by, claimed_until, created_at, updated_at, finished_at`
+
+const insertJob = `INSERT INTO jobs (reference_id, command, resource_type, resource_id, key, data, status, run_at, max_attempts, created_at, updated_at)
+	VALUES (:reference_id, :command, :resource_type, :resource_id, :key, :data, :status, :run_at, :max_attempts, :created_at, :updated_at)`
+
+// newJob builds a queued job of a registered command.
+func (b *Bus) newJob(command string, data map[string]any, runAt time.Time) (*Job, error) {
+	b.mu.RLock()
+	_, ok := b.handlers[command]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for command %s", command)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal command data: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	return &Job{
+		ReferenceID:  "job_" + uuid.New().String()[:8],
+		Command:      command,
+		ResourceType: commandResources[command],
+		ResourceID:   strVal(data["reference_id"]),
+		DataJSON:     string(raw),
+		Status:       string(jobs.StatusQueued),
+		RunAt:        runAt.UTC().Format(time.RFC3339),
+		MaxAttempts:  jobs.DefaultMaxAttempts,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// Enqueue stores command to run with data on the worker pool and returns
+// the job. Unlike Dispatch it returns as soon as the job is stored.
+func (b *Bus) Enqueue(ctx context.Context, command string, data map[string]any) (*Job, error) {
+	job, err := b.newJob(command, data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.deps.Store.db.NamedExecContext(context.WithoutCancel(ctx), insertJob, job)
+	if err != nil {
+		return nil, fmt.Errorf("enqueue command: %w", err)
+	}
+	job.ID, _ = res.LastInsertId()
+	b.wakeWorkers()
+	b.logger.Debug("command enqueued", "command", command, "job", job.ReferenceID, "resource", job.ResourceID)
+	return job, nil
+}
+
+// DispatchAt queues command to run with data at runAt. A queued job with
+// the same key is cancelled, so callers can move a deadline by scheduling
+// it again.
+func (b *Bus) DispatchAt(ctx context.Context, key string, runAt time.Time, command string, data map[string]any) (*Job, error) {
+	if err := jobs.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	job, err := b.newJob(command, data, runAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Key = key
+
+	tx, err := b.deps.Store.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("schedule command: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE jobs SET status = ?, updated_at = ?, finished_at = ? WHERE key = ? AND status = ?`,
+		jobs.StatusCancelled, job.CreatedAt, job.CreatedAt, key, jobs.StatusQueued); err != nil {
+		return nil, fmt.Errorf("replace scheduled command: %w", err)
+	}
+	res, err := tx.NamedExecContext(ctx, insertJob, job)
+	if err != nil {
+		return nil, fmt.Errorf("schedule command: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("schedule command: %w", err)
+	}
+	job.ID, _ = res.LastInsertId()
+	b.logger.Debug("command scheduled", "command", command, "key", key, "run_at", job.RunAt)
+	return job, nil
+}
+
+// DispatchAfter queues command to run with data after delay.
+func (b *Bus) DispatchAfter(ctx context.Context, key string, delay time.Duration, command string, data map[string]any) (*Job, error) {
+	return b.DispatchAt(ctx, key, time.Now().Add(delay), command, data)
+}
+
+// Cancel cancels the queued job with the given key. It reports false when
+// there is none; a job already running is not interrupted.
+func (b *Bus) Cancel(ctx context.Context, key string) (bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := b.deps.Store.db.ExecContext(ctx,
+		`UPDATE jobs SET status = ?, updated_at = ?, finished_at = ? WHERE key = ? AND status = ?`,
+		jobs.StatusCancelled, now, now, key, jobs.StatusQueued)
+	if err != nil {
+		return false, fmt.Errorf("cancel scheduled command: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// wakeWorkers tells an idle worker there may be a job for it. Jobs enqueued
+// on a replica that isn't the leader are found on the leader's next poll.
+func (b *Bus) wakeWorkers() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Jobs lists jobs in the given statuses, newest first. An empty command
+// matches every command.
+func (b *Bus) Jobs(ctx context.Context, statuses []jobs.Status, command string, page Page) ([]*Job, error) {
+	return b.listJobs(ctx, statuses, command, false, page)
+}
+
+// ScheduledJobs lists delayed jobs in the given statuses, soonest first.
+// Page cursors are positions in run_at order.
+func (b *Bus) ScheduledJobs(ctx context.Context, statuses []jobs.Status, command string, page Page) ([]*Job, error) {
+	return b.listJobs(ctx, statuses, command, true, page)
+}
+
+func (b *Bus) listJobs(ctx context.Context, statuses []jobs.Status, command string, scheduled bool, page Page) ([]*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)`
+	var args []any
+	for _, st := range statuses {
+		args = append(args, st)
+	}
+	if command != "" {
+		query += ` AND command = ?`
+		args = append(args, command)
+	}
+	order, desc := "datetime(created_at)", true
+	if scheduled {
+		query += ` AND key != ''`
+		order, desc = "datetime(run_at)", false
+	}
+	if cond, condArgs := page.after(order, "id", desc); cond != "" {
+		query += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	if desc {
+		query += ` ORDER BY ` + order + ` DESC, id DESC LIMIT ? OFFSET ?`
+	} else {
+		query += ` ORDER BY ` + order + `, id LIMIT ? OFFSET ?`
+	}
+	args = append(args, page.Limit, page.Offset)
+
+	var out []*Job
+	if err := b.deps.Store.db.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	return out, nil
+}
+
+// Job returns a job by reference ID.
+func (b *Bus) Job(ctx context.Context, refID string) (*Job, error) {
+	var job Job
+	if err := b.deps.Store.db.GetContext(ctx, &job,
+		`SELECT `+jobColumns+` FROM jobs WHERE reference_id = ?`, refID); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Requeue queues a dead job to run again now, with a fresh set of attempts.
+func (b *Bus) Requeue(ctx context.Context, refID string) (*Job, error) {
+	job, err := b.Job(ctx, refID)
+	if err != nil {
+		return nil, err
+	}
+	if err := jobs.CheckRequeue(jobs.Status(job.Status)); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := b.deps.Store.db.ExecContext(ctx,
+		`UPDATE jobs SET status = ?, run_at = ?, attempts = 0, last_error = '', updated_at = ?, finished_at = NULL
+		WHERE id = ? AND status = ?`,
+		jobs.StatusQueued, now, now, job.ID, jobs.StatusDead)
+	if err != nil {
+		return nil, fmt.Errorf("requeue job: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("%w: job was requeued meanwhile", jobs.ErrNotDead)
+	}
+	job.Status = string(jobs.StatusQueued)
+	job.RunAt = now
+	job.Attempts = 0
+	job.LastError = ""
+	job.UpdatedAt = now
+	job.FinishedAt = sql.NullString{}
+	b.wakeWorkers()
+	return job, nil
+}
+
+// =============================================================================
+// Worker Pool
+// =============================================================================
+
+// Start starts the worker pool.
+func (b *Bus) Start() {
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	for range b.workers {
+		b.wg.Add(1)
+		go b.work()
+	}
+	b.logger.Info("job workers started", "workers", b.workers, "poll_interval", b.pollInterval)
+}
+
+// Stop stops the workers, waiting for running jobs to finish.
+func (b *Bus) Stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// work runs jobs until none is due, then waits to be woken or for the next
+// poll.
+func (b *Bus) work() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		b.requeueLapsed()
+		for b.ctx.Err() == nil && b.runNextJob() {
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-b.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims and runs one job. It reports false when there was none
+// to run.
+func (b *Bus) runNextJob() bool {
+	job, err := b.claimJob()
+	if err != nil {
+		b.logger.Error("failed to claim job", "error", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+	// There may be more; let an idle worker look while this one runs
+	b.wakeWorkers()
+	b.runJob(job)
+	return true
+}
+
+// requeueLapsed queues again the running jobs whose claim lapsed. Jobs
+// running before claims were recorded have no claimed_until and are queued
+// too.
+func (b *Bus) requeueLapsed() {
+	now := time.Now().UTC()
+	res, err := b.deps.Store.db.ExecContext(b.ctx,
+		`UPDATE jobs SET status = ?, claimed_by = '', claimed_until = NULL, updated_at = ?
+		WHERE status = ? AND (claimed_until IS NULL OR claimed_until < ?)`,
+		jobs.StatusQueued, now.Format(time.RFC3339), jobs.StatusRunning, now.Format(leaseTimeFormat))
+	if err != nil {
+		if b.ctx.Err() == nil {
+			b.logger.Error("failed to requeue jobs with lapsed claims", "error", err)
+		}
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		b.logger.Warn("requeued jobs whose claim lapsed", "count", n)
+	}
+}
+
+// claimJob claims the oldest due job whose resource has no running job and
+// no earlier queued one; delayed jobs are not held back and hold nothing
+// back. It returns nil when there is none.
+func (b *Bus) claimJob() (*Job, error) {
+	db := b.deps.Store.db
+	for {
+		now := time.Now().UTC().Format(time.RFC3339)
+		var job Job
+		err := db.GetContext(b.ctx, &job,
+			`SELECT `+jobColumns+` FROM jobs j
+			WHERE status = ? AND run_at <= ?
+			AND NOT EXISTS (
+				SELECT 1 FROM jobs o
+				WHERE j.resource_id != '' AND j.key = '' AND o.key = ''
+				AND o.resource_type = j.resource_type AND o.resource_id = j.resource_id
+				AND o.id != j.id AND (o.status = ? OR (o.status = ? AND o.id < j.id))
+			)
+			ORDER BY id LIMIT 1`,
+			jobs.StatusQueued, now, jobs.StatusRunning, jobs.StatusQueued)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		until := time.Now().UTC().Add(b.claimTTL).Format(leaseTimeFormat)
+		res, err := db.ExecContext(b.ctx,
+			`UPDATE jobs SET status = ?, attempts = attempts + 1, claimed_by = ?, claimed_until = ?, updated_at = ?
+			WHERE id = ? AND status = ?`,
+			jobs.StatusRunning, b.owner, until, now, job.ID, jobs.StatusQueued)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			job.Status = string(jobs.StatusRunning)
+			job.Attempts++
+			job.ClaimedBy = b.owner
+			job.ClaimedUntil = sql.NullString{String: until, Valid: true}
+			return &job, nil
+		}
+		// Another worker claimed it first; look again
+	}
+}
+
+// renewClaim extends the claim on a running job every third of the claim
+// TTL until done is closed. When the claim is lost to another bus, the job's
+// context is cancelled.
+func (b *Bus) renewClaim(job *Job, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(b.claimTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		res, err := b.deps.Store.db.ExecContext(b.ctx,
+			`UPDATE jobs SET claimed_until = ? WHERE id = ? AND status = ? AND claimed_by = ?`,
+			time.Now().UTC().Add(b.claimTTL).Format(leaseTimeFormat), job.ID, jobs.StatusRunning, b.owner)
+		if err != nil {
+			b.logger.Warn("failed to renew job claim", "job", job.ReferenceID, "error", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			b.logger.Warn("job claim lost, stopping it", "job", job.ReferenceID)
+			cancel()
+			return
+		}
+	}
+}
+
+// runJob runs a claimed job once and records its outcome.
+func (b *Bus) runJob(job *Job) {
+	logger := b.logger.With("command", job.Command, "job", job.ReferenceID)
+
+	ctx, cancel := context.WithCancel(b.ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go b.renewClaim(job, cancel, done)
+	defer close(done)
+
+	var data map[string]any
+	err := json.Unmarshal([]byte(job.DataJSON), &data)
+	if err == nil {
+		b.mu.RLock()
+		handler, ok := b.handlers[job.Command]
+		b.mu.RUnlock()
+		if ok {
+			source := executionJob
+			if job.Key != "" {
+				source = executionScheduled
+			}
+			exec := b.beginExecution(ctx, job.Command, data, source, "", "")
+			if exec != nil {
+				job.ExecutionID = exec.ReferenceID
+			}
+			err = b.execute(ctx, job.Command, handler, data, exec)
+		} else {
+			err = fmt.Errorf("no handler registered for command %s", job.Command)
+		}
+	}
+	store := b.deps.Store
+	if err != nil && ctx.Err() != nil {
+		if b.ctx.Err() != nil {
+			// Interrupted by shutdown; give the claim up so the next leader
+			// runs it without waiting for the claim to lapse
+			if _, err := store.db.ExecContext(context.WithoutCancel(b.ctx),
+				`UPDATE jobs SET status = ?, claimed_by = '', claimed_until = NULL WHERE id = ? AND claimed_by = ?`,
+				jobs.StatusQueued, job.ID, b.owner); err != nil {
+				logger.Error("failed to release job claim", "error", err)
+			}
+		}
+		// Otherwise the claim was lost and the job belongs to another bus
+		return
+	}
+
+	now := time.Now().UTC()
+	outcome := jobs.AfterAttempt(job.Attempts, job.MaxAttempts, err, now)
+	job.Status = string(outcome.Status)
+	job.LastError = ""
+	if err != nil {
+		job.LastError = err.Error()
+	}
+	switch outcome.Status {
+	case jobs.StatusQueued:
+		job.RunAt = outcome.RunAt.Format(time.RFC3339)
+		logger.Warn("job failed, will retry", "attempt", job.Attempts, "retry_at", job.RunAt, "error", err)
+	case jobs.StatusDead:
+		job.FinishedAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+		logger.Error("job failed, moved to the dead-letter queue", "attempts", job.Attempts, "error", err)
+	default:
+		job.FinishedAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+	}
+	job.UpdatedAt = now.Format(time.RFC3339)
+
+	// Only the claimant records the outcome
+	res, err := store.db.NamedExecContext(context.WithoutCancel(b.ctx),
+		`UPDATE jobs SET status = :status, run_at = :run_at, last_error = :last_error, execution_id = :execution_id,
+			claimed_by = '', claimed_until = NULL, updated_at = :updated_at, finished_at = :finished_at
+		WHERE id = :id AND claimed_by = :claimed_by`, job)
+	if err != nil {
+		logger.Error("failed to record job outcome", "error", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		logger.Warn("job claim lapsed before it finished; its outcome is not recorded")
+	}
+	job.ClaimedBy, job.ClaimedUntil = "", sql.NullString{}
+}
+
+// foldScheduledCommands moves the commands still waiting in the retired
+// scheduled_commands queue into jobs and drops the table.
+func foldScheduledCommands(db *sqlx.DB, logger *slog.Logger) error {
+	var exists int
+	if err := db.Get(&exists,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'scheduled_commands'`); err != nil {
+		return fmt.Errorf("check scheduled_commands: %w", err)
+	}
+	if exists == 0 {
+		return nil
+	}
+
+	var waiting []*Job
+	if err := db.Select(&waiting,
+		`SELECT key, command, data, run_at, attempts, max_attempts, last_error, created_at, updated_at
+		FROM scheduled_commands WHERE status IN ('pending', 'running')`); err != nil {
+		return fmt.Errorf("list scheduled commands: %w", err)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("fold scheduled commands: %w", err)
+	}
+	defer tx.Rollback()
+	for _, job := range waiting {
+		var data map[string]any
+		json.Unmarshal([]byte(job.DataJSON), &data)
+		job.ReferenceID = "job_" + uuid.New().String()[:8]
+		job.ResourceType = commandResources[job.Command]
+		job.ResourceID = strVal(data["reference_id"])
+		job.Status = string(jobs.StatusQueued)
+		if _, err := tx.NamedExec(`INSERT INTO jobs (reference_id, command, resource_type, resource_id, key, data,
+			status, run_at, attempts, max_attempts, last_error, created_at, updated_at)
+			VALUES (:reference_id, :command, :resource_type, :resource_id, :key, :data,
+			:status, :run_at, :attempts, :max_attempts, :last_error, :created_at, :updated_at)`, job); err != nil {
+			return fmt.Errorf("fold scheduled command %s: %w", job.Key, err)
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE scheduled_commands`); err != nil {
+		return fmt.Errorf("drop scheduled_commands: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("fold scheduled commands: %w", err)
+	}
+	logger.Info("scheduled commands moved to the jobs queue", "count", len(waiting))
+	return nil
+}
+
+// =============================================================================
+// Admin Handlers
+// =============================================================================
+
+// isAdmin reports whether the user is a platform administrator.
+func isAdmin(cfg SetupConfig, authCtx AuthContext) bool {
+	for _, ref := range cfg.Admins {
+		if ref != "" && ref == authCtx.ReferenceID {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin writes a problem and returns false unless the request is from
+// an administrator and the bus is available.
+func requireAdmin(w http.ResponseWriter, r *http.Request, cfg SetupConfig) bool {
+	authCtx := getAuthContext(r)
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return false
+	}
+	if !isAdmin(cfg, authCtx) {
+		writeProblem(w, r, ProblemForbidden, "administrator access required")
+		return false
+	}
+	if cfg.Bus == nil {
+		writeProblem(w, r, ProblemNotConfigured, "command bus is not configured")
+		return false
+	}
+	return true
+}
+
+// parseJobStatuses parses ?status=, a comma-separated list, falling back to
+// def when it is absent.
+func parseJobStatuses(r *http.Request, def ...jobs.Status) ([]jobs.Status, error) {
+	v := r.URL.Query().Get("status")
+	if v == "" {
+		return def, nil
+	}
+	var statuses []jobs.Status
+	for _, s := range strings.Split(v, ",") {
+		st, err := jobs.ParseStatus(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// jobsHandler handles GET /admin/jobs: jobs, newest first. ?status= takes a
+// comma-separated list and defaults to the dead-letter queue; ?command=
+// filters by command.
+func jobsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, cfg) {
+			return
+		}
+
+		statuses, err := parseJobStatuses(r, jobs.StatusDead)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		list, err := cfg.Bus.Jobs(r.Context(), statuses, r.URL.Query().Get("command"), page)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list jobs")
+			return
+		}
+		data := make([]map[string]any, 0, len(list))
+		for _, job := range list {
+			data = append(data, jobJSONAPI(cfg.Store, job))
+		}
+		var next string
+		if n := len(list); n > 0 {
+			next = cursorAfter(page, n, list[n-1].CreatedAt, list[n-1].ID)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": data,
+			"meta": pageMeta(page, next),
+		})
+	}
+}
+
+// scheduledJobsHandler handles GET /admin/scheduled-commands: delayed jobs,
+// soonest first. ?status= takes a comma-separated list and defaults to
+// queued and running; ?command= filters by command.
+func scheduledJobsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, cfg) {
+			return
+		}
+		statuses, err := parseJobStatuses(r, jobs.StatusQueued, jobs.StatusRunning)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		list, err := cfg.Bus.ScheduledJobs(r.Context(), statuses, r.URL.Query().Get("command"), page)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list scheduled commands")
+			return
+		}
+		data := make([]map[string]any, 0, len(list))
+		for _, job := range list {
+			data = append(data, jobJSONAPI(cfg.Store, job))
+		}
+		// The list is ordered by run time, so its cursors carry run_at
+		var next string
+		if n := len(list); n > 0 {
+			next = cursorAfter(page, n, list[n-1].RunAt, list[n-1].ID)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": data,
+			"meta": pageMeta(page, next),
+		})
+	}
+}
+
+// scheduledJobCancelHandler handles DELETE /admin/scheduled-commands/{key}:
+// cancels the queued job with the key.
+func scheduledJobCancelHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, cfg) {
+			return
+		}
+		cancelled, err := cfg.Bus.Cancel(r.Context(), mux.Vars(r)["key"])
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to cancel scheduled command")
+			return
+		}
+		if !cancelled {
+			writeProblem(w, r, ProblemNotFound, "no queued command with this key")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// jobHandler handles GET /admin/jobs/{id}.
+func jobHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, cfg) {
+			return
+		}
+		job, err := cfg.Bus.Job(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "job not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": jobJSONAPI(cfg.Store, job)})
+	}
+}
+
+// jobRequeueHandler handles POST /admin/jobs/{id}/requeue: a dead job is
+// queued to run again now.
+func jobRequeueHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, cfg) {
+			return
+		}
+		job, err := cfg.Bus.Requeue(r.Context(), mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeProblem(w, r, ProblemNotFound, "job not found")
+			return
+		case errors.Is(err, jobs.ErrNotDead):
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		case err != nil:
+			writeProblem(w, r, ProblemInternal, "failed to requeue job")
+			return
+		}
+		cfg.Logger.Info("job requeued", "job", job.ReferenceID, "command", job.Command,
+			"by", getAuthContext(r).ReferenceID)
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": jobJSONAPI(cfg.Store, job)})
+	}
+}
+
+// jobJSONAPI renders a job, leaving its resource's write-only and encrypted
+// fields out of the payload.
+func jobJSONAPI(store *Store, job *Job) map[string]any {
+	var data map[string]any
+	json.Unmarshal([]byte(job.DataJSON), &data)
+	attrs := map[string]any{
+		"command":      job.Command,
+		"data":         executionSnapshot(store, job.ResourceType, data),
+		"status":       job.Status,
+		"run_at":       job.RunAt,
+		"attempts":     job.Attempts,
+		"max_attempts": job.MaxAttempts,
+		"created_at":   job.CreatedAt,
+		"updated_at":   job.UpdatedAt,
+	}
+	if job.Key != "" {
+		attrs["key"] = job.Key
+	}
+	if job.ResourceType != "" {
+		attrs["resource_type"] = job.ResourceType
+		attrs["resource_id"] = job.ResourceID
+	}
+	if job.LastError != "" {
+		attrs["last_error"] = job.LastError
+	}
+	if job.ExecutionID != "" {
+		attrs["execution_id"] = job.ExecutionID
+	}
+	if job.ClaimedBy != "" {
+		attrs["claimed_by"] = job.ClaimedBy
+		attrs["claimed_until"] = job.ClaimedUntil.String
+	}
+	if job.FinishedAt.Valid {
+		attrs["finished_at"] = job.FinishedAt.String
+	}
+	return map[string]any{
+		"type":       "jobs",
+		"id":         job.ReferenceID,
+		"attributes": attrs,
+	}
+}
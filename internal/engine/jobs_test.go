@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/jobs"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Job Queue Tests
+// =============================================================================
+
+func newJobTest(t *testing.T) (*Store, *Bus) {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	bus := NewBus(store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	bus.pollInterval = 20 * time.Millisecond
+	return store, bus
+}
+
+func jobStatuses(t *testing.T, store *Store, key string) []string {
+	t.Helper()
+	var statuses []string
+	require.NoError(t, store.db.Select(&statuses, `SELECT status FROM jobs WHERE key = ? ORDER BY id`, key))
+	return statuses
+}
+
+func TestDispatchAt_ReplacesQueuedKey(t *testing.T) {
+	store, bus := newJobTest(t)
+	bus.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error { return nil })
+	ctx := context.Background()
+
+	_, err := bus.DispatchAt(ctx, "noop:1", time.Now().Add(time.Hour), "Noop", nil)
+	require.NoError(t, err)
+	job, err := bus.DispatchAt(ctx, "noop:1", time.Now().Add(2*time.Hour), "Noop", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "noop:1", job.Key)
+	assert.Equal(t, []string{"cancelled", "queued"}, jobStatuses(t, store, "noop:1"))
+
+	cancelled, err := bus.Cancel(ctx, "noop:1")
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+	cancelled, err = bus.Cancel(ctx, "noop:1")
+	require.NoError(t, err)
+	assert.False(t, cancelled, "nothing is left to cancel")
+
+	_, err = bus.DispatchAt(ctx, "", time.Now(), "Noop", nil)
+	assert.ErrorIs(t, err, jobs.ErrInvalidKey)
+}
+
+func TestBus_RunsDelayedJobsOnWorkers(t *testing.T) {
+	store, bus := newJobTest(t)
+	ran := make(chan string, 2)
+	bus.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error {
+		ran <- strVal(data["name"])
+		return nil
+	})
+	ctx := context.Background()
+
+	_, err := bus.DispatchAt(ctx, "noop:later", time.Now().Add(time.Hour), "Noop", map[string]any{"name": "later"})
+	require.NoError(t, err)
+	_, err = bus.DispatchAt(ctx, "noop:due", time.Now().Add(-time.Second), "Noop", map[string]any{"name": "due"})
+	require.NoError(t, err)
+
+	bus.Start()
+	defer bus.Stop()
+	select {
+	case name := <-ran:
+		assert.Equal(t, "due", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the due job did not run")
+	}
+	assert.Eventually(t, func() bool {
+		return len(jobStatuses(t, store, "noop:due")) == 1 && jobStatuses(t, store, "noop:due")[0] == "succeeded"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"queued"}, jobStatuses(t, store, "noop:later"), "a job isn't run before its time")
+
+	var source string
+	require.NoError(t, store.db.Get(&source, `SELECT source FROM command_executions WHERE command = 'Noop'`))
+	assert.Equal(t, executionScheduled, source)
+}
+
+func TestOpenDB_FoldsScheduledCommands(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	store, err := OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// A database from before delayed commands moved into jobs
+	db, err := sqlx.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE scheduled_commands (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		reference_id TEXT NOT NULL UNIQUE,
+		key TEXT NOT NULL,
+		command TEXT NOT NULL,
+		data TEXT NOT NULL DEFAULT '{}',
+		run_at TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 3,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		finished_at TEXT
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO scheduled_commands (reference_id, key, command, data, run_at, status, created_at, updated_at) VALUES
+		('scmd_1', 'expire:depl_1', 'ExpireDeployment', '{"reference_id":"depl_1"}', '2030-01-01T00:00:00Z', 'pending', '2029-01-01T00:00:00Z', '2029-01-01T00:00:00Z'),
+		('scmd_2', 'expire:depl_2', 'ExpireDeployment', '{}', '2029-01-01T00:00:00Z', 'done', '2029-01-01T00:00:00Z', '2029-01-01T00:00:00Z')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err = OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	var folded []Job
+	require.NoError(t, store.db.Select(&folded, `SELECT `+jobColumns+` FROM jobs`))
+	require.Len(t, folded, 1, "only waiting commands are moved")
+	assert.Equal(t, "expire:depl_1", folded[0].Key)
+	assert.Equal(t, "queued", folded[0].Status)
+	assert.Equal(t, "2030-01-01T00:00:00Z", folded[0].RunAt)
+	assert.Equal(t, "depl_1", folded[0].ResourceID)
+
+	var tables int
+	require.NoError(t, store.db.Get(&tables, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'scheduled_commands'`))
+	assert.Zero(t, tables, "the old queue is dropped")
+}
+
+// runningJob stores a job claimed by claimant until the given time.
+func runningJob(t *testing.T, store *Store, name, claimant string, until time.Time) {
+	t.Helper()
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := store.db.Exec(`INSERT INTO jobs (reference_id, command, data, status, run_at, attempts, claimed_by, claimed_until, created_at, updated_at)
+		VALUES (?, 'Noop', ?, 'running', ?, 1, ?, ?, ?, ?)`,
+		"job_"+name, `{"name":"`+name+`"}`, now, claimant, until.UTC().Format(leaseTimeFormat), now, now)
+	require.NoError(t, err)
+}
+
+func jobClaim(t *testing.T, store *Store, ref string) (status, claimant string) {
+	t.Helper()
+	var row struct {
+		Status    string `db:"status"`
+		ClaimedBy string `db:"claimed_by"`
+	}
+	require.NoError(t, store.db.Get(&row, `SELECT status, claimed_by FROM jobs WHERE reference_id = ?`, ref))
+	return row.Status, row.ClaimedBy
+}
+
+func TestBus_RequeuesOnlyLapsedClaims(t *testing.T) {
+	store, bus := newJobTest(t)
+	ran := make(chan string, 2)
+	bus.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error {
+		ran <- strVal(data["name"])
+		return nil
+	})
+	// One job is held by a bus that is still renewing it, the other by a
+	// bus that went away
+	runningJob(t, store, "held", "other-bus", time.Now().Add(time.Hour))
+	runningJob(t, store, "lapsed", "gone-bus", time.Now().Add(-time.Second))
+
+	bus.Start()
+	defer bus.Stop()
+	select {
+	case name := <-ran:
+		assert.Equal(t, "lapsed", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job with a lapsed claim was not run again")
+	}
+	assert.Eventually(t, func() bool {
+		status, _ := jobClaim(t, store, "job_lapsed")
+		return status == "succeeded"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status, claimant := jobClaim(t, store, "job_held")
+	assert.Equal(t, "running", status, "a job claimed by a live bus is left alone")
+	assert.Equal(t, "other-bus", claimant)
+	assert.Empty(t, ran)
+}
+
+func TestBus_RenewsClaimWhileRunning(t *testing.T) {
+	store, bus := newJobTest(t)
+	bus.claimTTL = 150 * time.Millisecond
+	runs := make(chan struct{}, 2)
+	release := make(chan struct{})
+	bus.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error {
+		runs <- struct{}{}
+		<-release
+		return nil
+	})
+	_, err := bus.Enqueue(context.Background(), "Noop", nil)
+	require.NoError(t, err)
+
+	bus.Start()
+	defer bus.Stop()
+	<-runs
+
+	// A second bus, as on a new leader, starts while the job runs for
+	// several claim TTLs
+	next := NewBus(store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	next.pollInterval = 20 * time.Millisecond
+	next.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error {
+		runs <- struct{}{}
+		return nil
+	})
+	next.Start()
+	defer next.Stop()
+	time.Sleep(5 * bus.claimTTL)
+	close(release)
+
+	var status string
+	assert.Eventually(t, func() bool {
+		require.NoError(t, store.db.Get(&status, `SELECT status FROM jobs`))
+		return status == "succeeded"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, runs, "a job whose claim is renewed is not run twice")
+}
+
+func TestBus_StopsJobWhenClaimIsLost(t *testing.T) {
+	store, bus := newJobTest(t)
+	bus.claimTTL = 150 * time.Millisecond
+	stopped := make(chan error, 1)
+	started := make(chan struct{})
+	bus.Register("Noop", func(ctx context.Context, deps *Deps, data map[string]any) error {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return ctx.Err()
+	})
+	job, err := bus.Enqueue(context.Background(), "Noop", nil)
+	require.NoError(t, err)
+
+	bus.Start()
+	defer bus.Stop()
+	<-started
+	_, err = store.db.Exec(`UPDATE jobs SET claimed_by = 'other-bus', claimed_until = ? WHERE id = ?`,
+		time.Now().UTC().Add(time.Hour).Format(leaseTimeFormat), job.ID)
+	require.NoError(t, err)
+
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job kept running after its claim was lost")
+	}
+	time.Sleep(50 * time.Millisecond)
+	status, claimant := jobClaim(t, store, job.ReferenceID)
+	assert.Equal(t, "running", status, "the outcome is left to the new claimant")
+	assert.Equal(t, "other-bus", claimant)
+}
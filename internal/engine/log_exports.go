@@ -0,0 +1,581 @@
+package engine
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/logexport"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Log Export Storage
+// =============================================================================
+//
+// log_exports holds one row per request to export a deployment's container
+// logs. The LogExporter works through pending rows, writing a tar.gz archive
+// with one <service>.log entry per service to its directory, and deletes
+// archives once their download link expires. Rows left running by a restart
+// are started again.
+
+// LogExport is a request to export a deployment's container logs.
+type LogExport struct {
+	ID           int64          `db:"id"`
+	ReferenceID  string         `db:"reference_id"`
+	DeploymentID string         `db:"deployment_id"`
+	RequestedBy  int64          `db:"requested_by"`
+	ServicesJSON string         `db:"services"`
+	Since        string         `db:"since"`
+	Until        string         `db:"until"`
+	Status       string         `db:"status"`
+	CapBytes     int64          `db:"cap_bytes"`
+	LogBytes     int64          `db:"log_bytes"`
+	SizeBytes    int64          `db:"size_bytes"`
+	Truncated    bool           `db:"truncated"`
+	ErrorMessage string         `db:"error_message"`
+	CreatedAt    string         `db:"created_at"`
+	UpdatedAt    string         `db:"updated_at"`
+	CompletedAt  sql.NullString `db:"completed_at"`
+	ExpiresAt    sql.NullString `db:"expires_at"`
+
+	Services []string `db:"-"`
+}
+
+const logExportColumns = `id, reference_id, deployment_id, requested_by, services, since, until,
+	status, cap_bytes, log_bytes, size_bytes, truncated, error_message, created_at, updated_at,
+	completed_at, expires_at`
+
+// CreateLogExport inserts a pending export and fills in its IDs.
+func (s *Store) CreateLogExport(ctx context.Context, e *LogExport) error {
+	services, err := json.Marshal(e.Services)
+	if err != nil {
+		return fmt.Errorf("marshal log export services: %w", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	e.ReferenceID = "lexp_" + uuid.New().String()[:8]
+	e.ServicesJSON = string(services)
+	e.Status = string(logexport.StatusPending)
+	e.CreatedAt, e.UpdatedAt = now, now
+
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO log_exports (reference_id, deployment_id, requested_by, services, since, until,
+			status, cap_bytes, created_at, updated_at)
+		VALUES (:reference_id, :deployment_id, :requested_by, :services, :since, :until,
+			:status, :cap_bytes, :created_at, :updated_at)`, e)
+	if err != nil {
+		return fmt.Errorf("create log export: %w", err)
+	}
+	e.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// SaveLogExport writes an export's status and result.
+func (s *Store) SaveLogExport(ctx context.Context, e *LogExport) error {
+	e.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE log_exports SET status = :status, log_bytes = :log_bytes, size_bytes = :size_bytes,
+			truncated = :truncated, error_message = :error_message, updated_at = :updated_at,
+			completed_at = :completed_at, expires_at = :expires_at
+		WHERE id = :id`, e)
+	if err != nil {
+		return fmt.Errorf("save log export: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectLogExports(ctx context.Context, query string, args ...any) ([]*LogExport, error) {
+	var out []*LogExport
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+logExportColumns+` FROM log_exports `+query, args...); err != nil {
+		return nil, fmt.Errorf("query log exports: %w", err)
+	}
+	for _, e := range out {
+		if err := json.Unmarshal([]byte(e.ServicesJSON), &e.Services); err != nil {
+			return nil, fmt.Errorf("log export %s: decode services: %w", e.ReferenceID, err)
+		}
+	}
+	return out, nil
+}
+
+// ListLogExports returns a deployment's exports, newest first.
+func (s *Store) ListLogExports(ctx context.Context, deploymentID string, limit int) ([]*LogExport, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.selectLogExports(ctx, `WHERE deployment_id = ? ORDER BY id DESC LIMIT ?`, deploymentID, limit)
+}
+
+// GetLogExport returns the export with the given reference ID.
+func (s *Store) GetLogExport(ctx context.Context, refID string) (*LogExport, error) {
+	es, err := s.selectLogExports(ctx, `WHERE reference_id = ?`, refID)
+	if err != nil {
+		return nil, err
+	}
+	if len(es) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return es[0], nil
+}
+
+// ListRunnableLogExports returns pending exports and ones interrupted
+// mid-run, oldest first.
+func (s *Store) ListRunnableLogExports(ctx context.Context) ([]*LogExport, error) {
+	return s.selectLogExports(ctx, `WHERE status IN (?, ?) ORDER BY id`,
+		logexport.StatusPending, logexport.StatusRunning)
+}
+
+// ListExpiredLogExports returns completed exports whose link expired before now.
+func (s *Store) ListExpiredLogExports(ctx context.Context, now time.Time) ([]*LogExport, error) {
+	return s.selectLogExports(ctx, `WHERE status = ? AND expires_at < ? ORDER BY id`,
+		logexport.StatusCompleted, now.UTC().Format(time.RFC3339))
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// logExportJSONAPI renders an export. Completed exports carry their signed
+// download link under links.download.
+func (x *LogExporter) logExportJSONAPI(r *http.Request, e *LogExport) map[string]any {
+	attrs := map[string]any{
+		"deployment_id": e.DeploymentID,
+		"services":      e.Services,
+		"since":         e.Since,
+		"until":         e.Until,
+		"status":        e.Status,
+		"cap_bytes":     e.CapBytes,
+		"log_bytes":     e.LogBytes,
+		"size_bytes":    e.SizeBytes,
+		"truncated":     e.Truncated,
+		"created_at":    e.CreatedAt,
+		"updated_at":    e.UpdatedAt,
+	}
+	if e.ErrorMessage != "" {
+		attrs["error_message"] = e.ErrorMessage
+	}
+	if e.CompletedAt.Valid {
+		attrs["completed_at"] = e.CompletedAt.String
+	}
+	if e.ExpiresAt.Valid {
+		attrs["expires_at"] = e.ExpiresAt.String
+	}
+	out := map[string]any{
+		"type":       "log_exports",
+		"id":         e.ReferenceID,
+		"attributes": attrs,
+	}
+	if expires, ok := parseTime(e.ExpiresAt.String); ok && e.Status == string(logexport.StatusCompleted) {
+		q := url.Values{}
+		q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+		q.Set("signature", logexport.Sign(x.key, e.ReferenceID, expires))
+		out["links"] = map[string]any{
+			"download": requestVersion(r).Prefix() + "/log-exports/" + e.ReferenceID + "/download?" + q.Encode(),
+		}
+	}
+	return out
+}
+
+// logExportHandler handles POST and GET /deployments/{id}/logs/export. POST
+// queues an export of the given time range and services, capped by the
+// requester's plan; GET lists the deployment's exports.
+func logExportHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.LogExports == nil {
+			writeProblem(w, r, ProblemNotConfigured, "log export requires remote nodes to be enabled")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
+			return
+		}
+		refID := strVal(depl["reference_id"])
+
+		if r.Method == http.MethodGet {
+			es, err := cfg.Store.ListLogExports(ctx, refID, 20)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list log exports")
+				return
+			}
+			data := make([]map[string]any, 0, len(es))
+			for _, e := range es {
+				data = append(data, cfg.LogExports.logExportJSONAPI(r, e))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+			return
+		}
+
+		var req logexport.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		req, err = req.Normalize(time.Now(), deploymentServices(depl))
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		e := &LogExport{
+			DeploymentID: refID,
+			RequestedBy:  int64(authCtx.UserID),
+			Services:     req.Services,
+			Since:        req.Since.Format(time.RFC3339),
+			Until:        req.Until.Format(time.RFC3339),
+			CapBytes:     logexport.CapBytes(authCtx.PlanLimits.MaxLogExportMB),
+		}
+		if err := cfg.Store.CreateLogExport(ctx, e); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to queue log export")
+			return
+		}
+		cfg.LogExports.Trigger()
+
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": cfg.LogExports.logExportJSONAPI(r, e)})
+	}
+}
+
+// logExportDownloadHandler handles GET /log-exports/{id}/download. The
+// signed link is the only credential, so it can be handed to support staff.
+func logExportDownloadHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if cfg.LogExports == nil {
+			writeProblem(w, r, ProblemNotConfigured, "log export requires remote nodes to be enabled")
+			return
+		}
+		q := r.URL.Query()
+		if err := logexport.Verify(cfg.LogExports.key, id, q.Get("expires"), q.Get("signature"), time.Now()); err != nil {
+			writeProblem(w, r, ProblemForbidden, err.Error())
+			return
+		}
+
+		e, err := cfg.Store.GetLogExport(r.Context(), id)
+		if err != nil || e.Status != string(logexport.StatusCompleted) {
+			writeProblem(w, r, ProblemNotFound, "log export not found")
+			return
+		}
+		f, err := os.Open(cfg.LogExports.archivePath(e.ReferenceID))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "log export archive not found")
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="%s-logs-%s.tar.gz"`, e.DeploymentID, e.ReferenceID))
+		w.Header().Set("Content-Length", strconv.FormatInt(e.SizeBytes, 10))
+		io.Copy(w, f)
+	}
+}
+
+// deploymentServices returns the services of a deployment's containers.
+func deploymentServices(depl map[string]any) []string {
+	var containers []domain.ContainerInfo
+	decodeJSONValue(depl["containers"], &containers)
+	seen := map[string]bool{}
+	var services []string
+	for _, c := range containers {
+		if c.ServiceName != "" && !seen[c.ServiceName] {
+			seen[c.ServiceName] = true
+			services = append(services, c.ServiceName)
+		}
+	}
+	return services
+}
+
+// =============================================================================
+// Log Exporter Worker
+// =============================================================================
+
+// LogExporter produces queued log export archives and deletes expired ones.
+type LogExporter struct {
+	store    *Store
+	nodePool *docker.NodePool
+	dir      string
+	key      []byte
+	ttl      time.Duration
+	interval time.Duration
+	trigger  chan struct{}
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewLogExporter creates a log exporter writing archives to dir. key signs
+// download links, which stay valid for ttl after an archive is ready.
+func NewLogExporter(store *Store, nodePool *docker.NodePool, dir string, key []byte, ttl, interval time.Duration, logger *slog.Logger) *LogExporter {
+	if ttl == 0 {
+		ttl = logexport.DefaultLinkTTL
+	}
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	return &LogExporter{
+		store:    store,
+		nodePool: nodePool,
+		dir:      dir,
+		key:      key,
+		ttl:      ttl,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		logger:   logger.With("component", "log_exporter"),
+	}
+}
+
+func (x *LogExporter) Start() {
+	x.ctx, x.cancel = context.WithCancel(context.Background())
+	x.wg.Add(1)
+	go x.run()
+	x.logger.Info("log exporter started", "dir", x.dir, "link_ttl", x.ttl)
+}
+
+func (x *LogExporter) Stop() {
+	if x.cancel != nil {
+		x.cancel()
+	}
+	x.wg.Wait()
+}
+
+// Trigger wakes the exporter to pick up a newly queued export.
+func (x *LogExporter) Trigger() {
+	select {
+	case x.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (x *LogExporter) run() {
+	defer x.wg.Done()
+	x.runPending()
+
+	ticker := time.NewTicker(x.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-x.trigger:
+		}
+		x.runPending()
+	}
+}
+
+func (x *LogExporter) runPending() {
+	defer x.store.LoopMetrics().Time("log_exporter")()
+	x.expire()
+
+	es, err := x.store.ListRunnableLogExports(x.ctx)
+	if err != nil {
+		x.logger.Error("failed to list log exports", "error", err)
+		return
+	}
+	for _, e := range es {
+		if x.ctx.Err() != nil {
+			return
+		}
+		x.Export(x.ctx, e)
+	}
+}
+
+// expire deletes the archives of exports whose link has expired.
+func (x *LogExporter) expire() {
+	es, err := x.store.ListExpiredLogExports(x.ctx, time.Now())
+	if err != nil {
+		x.logger.Error("failed to list expired log exports", "error", err)
+		return
+	}
+	for _, e := range es {
+		if err := os.Remove(x.archivePath(e.ReferenceID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			x.logger.Warn("failed to delete expired log export", "export", e.ReferenceID, "error", err)
+			continue
+		}
+		e.Status = string(logexport.StatusExpired)
+		if err := x.store.SaveLogExport(x.ctx, e); err != nil {
+			x.logger.Error("failed to mark log export expired", "export", e.ReferenceID, "error", err)
+		}
+	}
+}
+
+func (x *LogExporter) archivePath(refID string) string {
+	return filepath.Join(x.dir, refID+".tar.gz")
+}
+
+// Export produces one export's archive, recording completion or failure. If
+// ctx is cancelled the export is left running so the next run starts it again.
+func (x *LogExporter) Export(ctx context.Context, e *LogExport) {
+	logger := x.logger.With("export", e.ReferenceID, "deployment", e.DeploymentID)
+
+	e.Status = string(logexport.StatusRunning)
+	if err := x.store.SaveLogExport(ctx, e); err != nil {
+		logger.Error("failed to mark log export running", "error", err)
+		return
+	}
+
+	err := x.export(ctx, e)
+	if err == nil {
+		now := time.Now().UTC()
+		e.Status = string(logexport.StatusCompleted)
+		e.ErrorMessage = ""
+		e.CompletedAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+		e.ExpiresAt = sql.NullString{String: now.Add(x.ttl).Format(time.RFC3339), Valid: true}
+		if err := x.store.SaveLogExport(ctx, e); err != nil {
+			logger.Error("failed to record log export", "error", err)
+			return
+		}
+		logger.Info("log export completed", "log_bytes", e.LogBytes, "size_bytes", e.SizeBytes, "truncated", e.Truncated)
+		return
+	}
+	os.Remove(x.archivePath(e.ReferenceID) + ".part")
+	if ctx.Err() != nil {
+		logger.Info("log export interrupted, will restart")
+		return
+	}
+
+	logger.Error("log export failed", "error", err)
+	e.Status = string(logexport.StatusFailed)
+	e.ErrorMessage = err.Error()
+	if err := x.store.SaveLogExport(context.WithoutCancel(ctx), e); err != nil {
+		logger.Error("failed to record log export failure", "error", err)
+	}
+}
+
+func (x *LogExporter) export(ctx context.Context, e *LogExport) error {
+	depl, err := x.store.Get(ctx, "deployments", e.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("deployment not found")
+	}
+	nodeID := strVal(depl["node_id"])
+	if nodeID == "" {
+		return fmt.Errorf("deployment is not placed on a node")
+	}
+	client, err := x.nodePool.GetClient(ctx, nodeID)
+	if err != nil {
+		return fmt.Errorf("node %s unavailable: %w", nodeID, err)
+	}
+	var containers []domain.ContainerInfo
+	decodeJSONValue(depl["containers"], &containers)
+	since, _ := parseTime(e.Since)
+	until, _ := parseTime(e.Until)
+
+	if err := os.MkdirAll(x.dir, 0o750); err != nil {
+		return fmt.Errorf("create log export dir: %w", err)
+	}
+	part := x.archivePath(e.ReferenceID) + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	e.LogBytes, e.Truncated = 0, false
+	for _, service := range e.Services {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logs := x.serviceLogs(client, containers, service, since, until, e.CapBytes-e.LogBytes)
+		if int64(len(logs)) > e.CapBytes-e.LogBytes {
+			logs = logs[:e.CapBytes-e.LogBytes]
+			e.Truncated = true
+		}
+		e.LogBytes += int64(len(logs))
+		if e.Truncated {
+			logs = append(logs, logexport.TruncationNotice(e.CapBytes)...)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    logexport.EntryName(service),
+			Mode:    0o644,
+			Size:    int64(len(logs)),
+			ModTime: until,
+		}); err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		if _, err := tw.Write(logs); err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		if e.Truncated {
+			break
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	info, err := os.Stat(part)
+	if err != nil {
+		return fmt.Errorf("stat archive: %w", err)
+	}
+	e.SizeBytes = info.Size()
+	return os.Rename(part, x.archivePath(e.ReferenceID))
+}
+
+// serviceLogs reads up to limit+1 bytes of a service's logs in the range.
+// A service whose containers are gone or unreadable gets a note instead, so
+// one bad container doesn't fail the whole export.
+func (x *LogExporter) serviceLogs(client docker.Client, containers []domain.ContainerInfo, service string, since, until time.Time, limit int64) []byte {
+	var out []byte
+	found := false
+	for _, c := range containers {
+		if c.ServiceName != service {
+			continue
+		}
+		found = true
+		rc, err := client.ContainerLogs(c.ID, docker.LogOptions{Since: since, Until: until, Timestamps: true})
+		if err != nil {
+			out = append(out, fmt.Sprintf("[hoster] could not read logs of container %s: %v\n", c.ID, err)...)
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, limit+1-int64(len(out))))
+		rc.Close()
+		out = append(out, data...)
+		if err != nil {
+			out = append(out, fmt.Sprintf("\n[hoster] reading logs of container %s failed: %v\n", c.ID, err)...)
+		}
+		if int64(len(out)) > limit {
+			break
+		}
+	}
+	if !found {
+		out = append(out, "[hoster] service has no containers\n"...)
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/artpar/hoster/internal/shell/logging"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Request-Scoped Logging
+// =============================================================================
+//
+// Each request gets a logger carrying its correlation fields, kept in the
+// request context (see logging.FromContext). Bus commands dispatched while
+// serving it log through it with their own fields added, and minion calls
+// made with its context log through it with the node's, so a request can be
+// followed from the API to the node.
+
+// requestLoggerMiddleware puts a logger with the request's ID, user and
+// addressed resource in the request context. It runs after authentication.
+func requestLoggerMiddleware(logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, _ := logging.With(r.Context(), logger, requestLogFields(r)...)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestLogFields returns the correlation fields of a request: its request
+// ID, the authenticated user, and the resource type and reference ID its
+// route addresses, with node_id when the resource is a node.
+func requestLogFields(r *http.Request) []any {
+	var fields []any
+	if id, ok := docker.RequestID(r.Context()); ok {
+		fields = append(fields, "request_id", id)
+	}
+	if authCtx := getAuthContext(r); authCtx.Authenticated {
+		user := authCtx.ReferenceID
+		if user == "" {
+			user = strconv.Itoa(authCtx.UserID)
+		}
+		fields = append(fields, "user_id", user)
+	}
+
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return fields
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return fields
+	}
+	if v, ok := apiversion.FromPath(tmpl); ok {
+		tmpl = strings.TrimPrefix(tmpl, v.Prefix())
+	}
+	segs := strings.Split(strings.Trim(tmpl, "/"), "/")
+	if segs[0] == "admin" && len(segs) > 1 {
+		segs = segs[1:]
+	}
+	if segs[0] == "" || strings.HasPrefix(segs[0], "{") {
+		return fields
+	}
+	fields = append(fields, "resource", segs[0])
+	if id := mux.Vars(r)["id"]; id != "" && len(segs) > 1 && segs[1] == "{id}" {
+		fields = append(fields, "resource_id", id)
+		if segs[0] == "nodes" {
+			fields = append(fields, "node_id", id)
+		}
+	}
+	return fields
+}
+
+// commandLogger returns the context and logger a bus command runs with: the
+// context's logger, or the bus's outside a request, with the command, its
+// execution, its resource and the node the payload names. Fields the
+// request's logger already has, such as the resource it addresses, are kept.
+func (b *Bus) commandLogger(ctx context.Context, command string, data map[string]any, exec *CommandExecution) (context.Context, *slog.Logger) {
+	fields := []any{"command", command}
+	if exec != nil {
+		fields = append(fields, "execution_id", exec.ReferenceID)
+	}
+	if resType, refID := commandResources[command], strVal(data["reference_id"]); resType != "" && refID != "" {
+		fields = append(fields, "resource", resType, "resource_id", refID)
+	}
+	if nodeID, _ := data["node_id"].(string); nodeID != "" {
+		fields = append(fields, "node_id", nodeID)
+	}
+	return logging.With(ctx, b.logger, fields...)
+}
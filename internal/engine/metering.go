@@ -0,0 +1,349 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/metering"
+	"github.com/artpar/hoster/internal/shell/billing"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Usage Metering
+// =============================================================================
+//
+// UsageMeter samples running deployments' containers through the node pool
+// and accrues their CPU-seconds, memory megabyte-hours and egress bytes per
+// billing hour in usage_hours. usage_meters keeps each deployment's last
+// reading, so a new leader carries on where the last one stopped. Once an
+// hour has passed, its usage is recorded as usage events for the
+// deployment's customer, which the billing reporter sends to APIGate and
+// spending limits price with billing.usage_prices.
+
+// usageHoursRetention is how long recorded usage hours are kept.
+const usageHoursRetention = 7 * 24 * time.Hour
+
+// usageMeter is a deployment's last reading.
+type usageMeter struct {
+	DeploymentID string  `db:"deployment_id"`
+	SampledAt    string  `db:"sampled_at"`
+	CPUPercent   float64 `db:"cpu_percent"`
+	MemoryBytes  int64   `db:"memory_bytes"`
+	TxBytes      string  `db:"tx_bytes"`
+}
+
+func (m *usageMeter) reading() metering.Reading {
+	at, _ := time.Parse(time.RFC3339, m.SampledAt)
+	var tx map[string]int64
+	decodeJSONValue(m.TxBytes, &tx)
+	return metering.Reading{At: at, CPUPercent: m.CPUPercent, MemoryBytes: m.MemoryBytes, TxBytes: tx}
+}
+
+// usageHour is a deployment's usage accrued in one billing hour.
+type usageHour struct {
+	DeploymentID    string         `db:"deployment_id"`
+	Hour            string         `db:"hour"`
+	CustomerID      int            `db:"customer_id"`
+	NodeID          string         `db:"node_id"`
+	CPUSeconds      float64        `db:"cpu_seconds"`
+	MemoryMBSeconds float64        `db:"memory_mb_seconds"`
+	EgressBytes     int64          `db:"egress_bytes"`
+	RecordedAt      sql.NullString `db:"recorded_at"`
+}
+
+const usageHourColumns = `deployment_id, hour, customer_id, node_id, cpu_seconds, memory_mb_seconds, egress_bytes, recorded_at`
+
+func (h usageHour) usage() metering.Usage {
+	return metering.Usage{CPUSeconds: h.CPUSeconds, MemoryMBSeconds: h.MemoryMBSeconds, EgressBytes: h.EgressBytes}
+}
+
+// usageMeter returns a deployment's last reading, or nil before its first.
+func (s *Store) usageMeter(ctx context.Context, deploymentID string) (*usageMeter, error) {
+	var m usageMeter
+	err := s.db.GetContext(ctx, &m,
+		`SELECT deployment_id, sampled_at, cpu_percent, memory_bytes, tx_bytes FROM usage_meters WHERE deployment_id = ?`, deploymentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get usage meter: %w", err)
+	}
+	return &m, nil
+}
+
+// accrueUsage adds a deployment's usage by hour and saves its reading, in
+// one transaction.
+func (s *Store) accrueUsage(ctx context.Context, m *usageMeter, customerID int, nodeID string, usage map[time.Time]metering.Usage) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("accrue usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	for hour, u := range usage {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO usage_hours (deployment_id, hour, customer_id, node_id, cpu_seconds, memory_mb_seconds, egress_bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(deployment_id, hour) DO UPDATE SET
+				cpu_seconds = cpu_seconds + excluded.cpu_seconds,
+				memory_mb_seconds = memory_mb_seconds + excluded.memory_mb_seconds,
+				egress_bytes = egress_bytes + excluded.egress_bytes,
+				node_id = excluded.node_id
+			WHERE recorded_at IS NULL`,
+			m.DeploymentID, hour.Format(time.RFC3339), customerID, nodeID, u.CPUSeconds, u.MemoryMBSeconds, u.EgressBytes); err != nil {
+			return fmt.Errorf("accrue usage: %w", err)
+		}
+	}
+	if _, err := tx.NamedExecContext(ctx,
+		`INSERT INTO usage_meters (deployment_id, sampled_at, cpu_percent, memory_bytes, tx_bytes)
+		VALUES (:deployment_id, :sampled_at, :cpu_percent, :memory_bytes, :tx_bytes)
+		ON CONFLICT(deployment_id) DO UPDATE SET
+			sampled_at = excluded.sampled_at, cpu_percent = excluded.cpu_percent,
+			memory_bytes = excluded.memory_bytes, tx_bytes = excluded.tx_bytes`, m); err != nil {
+		return fmt.Errorf("save usage meter: %w", err)
+	}
+	return tx.Commit()
+}
+
+// forgetStoppedMeters deletes the readings of deployments that are not
+// running, so a restarted deployment's time stopped isn't metered.
+func (s *Store) forgetStoppedMeters(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM usage_meters WHERE deployment_id NOT IN
+		(SELECT reference_id FROM deployments WHERE status = 'running')`)
+	if err != nil {
+		return fmt.Errorf("forget usage meters: %w", err)
+	}
+	return nil
+}
+
+// pendingUsageHours lists unrecorded usage of billing hours before the one
+// starting at current.
+func (s *Store) pendingUsageHours(ctx context.Context, current time.Time) ([]usageHour, error) {
+	var rows []usageHour
+	err := s.db.SelectContext(ctx, &rows, `SELECT `+usageHourColumns+` FROM usage_hours
+		WHERE recorded_at IS NULL AND hour < ? ORDER BY hour`, current.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("list usage hours: %w", err)
+	}
+	return rows, nil
+}
+
+// markUsageHourRecorded marks an hour's usage as recorded. It reports false
+// when another meter marked it first, so no hour is billed twice.
+func (s *Store) markUsageHourRecorded(ctx context.Context, h usageHour, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE usage_hours SET recorded_at = ? WHERE deployment_id = ? AND hour = ? AND recorded_at IS NULL`,
+		now.UTC().Format(time.RFC3339), h.DeploymentID, h.Hour)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// pruneUsageHours deletes recorded usage hours before the cutoff.
+func (s *Store) pruneUsageHours(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM usage_hours WHERE recorded_at IS NOT NULL AND hour < ?`, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("prune usage hours: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// UsageMeter periodically meters running deployments' resource usage.
+type UsageMeter struct {
+	store    *Store
+	nodePool *docker.NodePool
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewUsageMeter(store *Store, nodePool *docker.NodePool, interval time.Duration, logger *slog.Logger) *UsageMeter {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	return &UsageMeter{
+		store:    store,
+		nodePool: nodePool,
+		interval: interval,
+		logger:   logger.With("component", "usage_meter"),
+	}
+}
+
+func (m *UsageMeter) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("usage meter started", "interval", m.interval)
+}
+
+func (m *UsageMeter) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *UsageMeter) run() {
+	defer m.wg.Done()
+	m.meterAll()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.meterAll()
+		}
+	}
+}
+
+// meterAll samples every running deployment, then records the usage of
+// the billing hours that have passed.
+func (m *UsageMeter) meterAll() {
+	defer m.store.LoopMetrics().Time("usage_meter")()
+	deployments, err := m.store.List(m.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		m.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+	for _, depl := range deployments {
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.meterDeployment(depl)
+	}
+	if err := m.store.forgetStoppedMeters(m.ctx); err != nil {
+		m.logger.Error("failed to forget stopped deployments' meters", "error", err)
+	}
+	m.recordHours(time.Now())
+}
+
+// meterDeployment samples a deployment's containers and accrues its usage
+// since the last reading. The first reading only starts the meter.
+// Containers whose stats cannot be read are left out; an unreachable node
+// skips the deployment, and the gap is not metered in full.
+func (m *UsageMeter) meterDeployment(depl map[string]any) {
+	refID := strVal(depl["reference_id"])
+	nodeID := strVal(depl["node_id"])
+	var containers []domain.ContainerInfo
+	if err := decodeJSONValue(depl["containers"], &containers); err != nil || nodeID == "" || len(containers) == 0 {
+		return
+	}
+
+	client, err := m.nodePool.GetClient(m.ctx, nodeID)
+	if err != nil {
+		m.logger.Debug("node unavailable for usage metering", "deployment", refID, "node", nodeID, "error", err)
+		return
+	}
+
+	cur := metering.Reading{At: time.Now(), TxBytes: map[string]int64{}}
+	for _, ctr := range containers {
+		stats, err := client.ContainerStats(ctr.ID)
+		if err != nil {
+			m.logger.Debug("container stats failed", "deployment", refID, "service", ctr.ServiceName, "error", err)
+			continue
+		}
+		cur.CPUPercent += stats.CPUPercent
+		cur.MemoryBytes += stats.MemoryUsageBytes
+		cur.TxBytes[ctr.ID] = stats.NetworkTxBytes
+	}
+	if len(cur.TxBytes) == 0 {
+		return
+	}
+
+	prev, err := m.store.usageMeter(m.ctx, refID)
+	if err != nil {
+		m.logger.Error("failed to read usage meter", "deployment", refID, "error", err)
+		return
+	}
+	var usage map[time.Time]metering.Usage
+	if prev != nil {
+		usage = metering.Accrue(prev.reading(), cur, 2*m.interval)
+	}
+
+	tx, err := json.Marshal(cur.TxBytes)
+	if err != nil {
+		m.logger.Error("failed to encode usage meter", "deployment", refID, "error", err)
+		return
+	}
+	customerID, _ := toInt64(depl["customer_id"])
+	meter := &usageMeter{
+		DeploymentID: refID,
+		SampledAt:    cur.At.UTC().Format(time.RFC3339),
+		CPUPercent:   cur.CPUPercent,
+		MemoryBytes:  cur.MemoryBytes,
+		TxBytes:      string(tx),
+	}
+	if err := m.store.accrueUsage(m.ctx, meter, int(customerID), nodeID, usage); err != nil {
+		m.logger.Error("failed to accrue usage", "deployment", refID, "error", err)
+	}
+}
+
+// recordHours records the usage of past billing hours as usage events and
+// prunes hours recorded long ago.
+func (m *UsageMeter) recordHours(now time.Time) {
+	hours, err := m.store.pendingUsageHours(m.ctx, metering.Hour(now))
+	if err != nil {
+		m.logger.Error("failed to list usage hours", "error", err)
+		return
+	}
+	for _, h := range hours {
+		if err := m.recordHour(h, now); err != nil {
+			m.logger.Warn("failed to record usage", "deployment", h.DeploymentID, "hour", h.Hour, "error", err)
+		}
+	}
+	if _, err := m.store.pruneUsageHours(m.ctx, now.Add(-usageHoursRetention)); err != nil {
+		m.logger.Error("failed to prune usage hours", "error", err)
+	}
+}
+
+// recordHour records one deployment's usage in one billing hour: an event
+// for each of CPU, memory and egress that is not zero. Usage of deployments
+// without a customer is marked recorded without events.
+func (m *UsageMeter) recordHour(h usageHour, now time.Time) error {
+	moved, err := m.store.markUsageHourRecorded(m.ctx, h, now)
+	if err != nil || !moved || h.CustomerID == 0 {
+		return err
+	}
+	hour, _ := time.Parse(time.RFC3339, h.Hour)
+	metadata := map[string]string{
+		"node_id": h.NodeID,
+		"from":    hour.UTC().Format(time.RFC3339),
+		"to":      hour.Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+	u := h.usage()
+	for _, q := range []struct {
+		event    domain.EventType
+		quantity int64
+	}{
+		{domain.EventCPUUsage, u.CPUSecondsQuantity()},
+		{domain.EventMemoryUsage, u.MemoryMBHoursQuantity()},
+		{domain.EventEgressUsage, u.EgressBytes},
+	} {
+		if q.quantity <= 0 {
+			continue
+		}
+		if err := billing.RecordMeteredEvent(m.ctx, m.store, h.CustomerID, q.event, h.DeploymentID, "deployment", q.quantity, metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
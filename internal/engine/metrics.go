@@ -0,0 +1,305 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/jobs"
+	"github.com/artpar/hoster/internal/core/metrics"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Prometheus Metrics
+// =============================================================================
+//
+// GET /metrics exposes hoster's own health in the Prometheus text format:
+// store query timings (store_metrics.go), deployment starts
+// (start_metrics.go), API request latencies and background worker loop
+// durations (aggregated here as they happen), and gauges read from the
+// database when scraped: deployments by status, node capacity and usage,
+// and the command queues.
+
+// =============================================================================
+// Request Metrics
+// =============================================================================
+
+type requestKey struct {
+	method, route string
+}
+
+type responseKey struct {
+	method, route string
+	status        int
+}
+
+// RequestMetrics aggregates API request durations per route template, so
+// /deployments/{id} is one series however many deployments there are.
+type RequestMetrics struct {
+	mu        sync.Mutex
+	durations map[requestKey]*metrics.Histogram
+	responses map[responseKey]int64
+}
+
+func newRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{
+		durations: map[requestKey]*metrics.Histogram{},
+		responses: map[responseKey]int64{},
+	}
+}
+
+// Observe records one request.
+func (m *RequestMetrics) Observe(method, route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := requestKey{method, route}
+	h := m.durations[k]
+	if h == nil {
+		h = metrics.NewHistogram(metrics.RequestBuckets)
+		m.durations[k] = h
+	}
+	h.Observe(d)
+	m.responses[responseKey{method, route, status}]++
+}
+
+// Render returns the aggregates in the Prometheus text exposition format.
+func (m *RequestMetrics) Render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var w metrics.Writer
+	w.Family("hoster_http_requests_total", "counter", "API requests served, by route and status.")
+	responses := make([]responseKey, 0, len(m.responses))
+	for k := range m.responses {
+		responses = append(responses, k)
+	}
+	slices.SortFunc(responses, func(a, b responseKey) int {
+		return strings.Compare(a.route+" "+a.method+" "+strconv.Itoa(a.status), b.route+" "+b.method+" "+strconv.Itoa(b.status))
+	})
+	for _, k := range responses {
+		w.Sample("hoster_http_requests_total",
+			metrics.Labels("method", k.method, "route", k.route, "status", strconv.Itoa(k.status)), float64(m.responses[k]))
+	}
+
+	const hist = "hoster_http_request_duration_seconds"
+	w.Family(hist, "histogram", "API request durations, by route.")
+	requests := make([]requestKey, 0, len(m.durations))
+	for k := range m.durations {
+		requests = append(requests, k)
+	}
+	slices.SortFunc(requests, func(a, b requestKey) int {
+		return strings.Compare(a.route+" "+a.method, b.route+" "+b.method)
+	})
+	for _, k := range requests {
+		w.Histogram(hist, metrics.Labels("method", k.method, "route", k.route), m.durations[k].Clone())
+	}
+	return w.String()
+}
+
+// requestMetricsMiddleware records the duration and status of every routed
+// request.
+func requestMetricsMiddleware(m *RequestMetrics) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := "unknown"
+			if cur := mux.CurrentRoute(r); cur != nil {
+				if tmpl, err := cur.GetPathTemplate(); err == nil {
+					route = tmpl
+				} else if prefix, err := cur.GetPathRegexp(); err == nil {
+					route = prefix
+				}
+			}
+			start := time.Now()
+			rec := &metricsRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			m.Observe(r.Method, route, rec.status, time.Since(start))
+		})
+	}
+}
+
+// metricsRecorder captures a response's status. Unwrap lets handlers reach
+// the connection through http.ResponseController, to flush event streams
+// and hijack WebSocket upgrades.
+type metricsRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *metricsRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *metricsRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *metricsRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// =============================================================================
+// Worker Loop Metrics
+// =============================================================================
+
+// LoopMetrics aggregates the durations of background workers' passes, by
+// worker.
+type LoopMetrics struct {
+	mu    sync.Mutex
+	loops map[string]*metrics.Histogram
+}
+
+func newLoopMetrics() *LoopMetrics {
+	return &LoopMetrics{loops: map[string]*metrics.Histogram{}}
+}
+
+// LoopMetrics returns the store's worker loop aggregates.
+func (s *Store) LoopMetrics() *LoopMetrics {
+	return s.loops
+}
+
+// Time starts timing one pass of the worker and returns the function that
+// records it: defer m.Time("health_checker")().
+func (m *LoopMetrics) Time(worker string) func() {
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		h := m.loops[worker]
+		if h == nil {
+			h = metrics.NewHistogram(metrics.LoopBuckets)
+			m.loops[worker] = h
+		}
+		h.Observe(d)
+	}
+}
+
+// Render returns the aggregates in the Prometheus text exposition format.
+func (m *LoopMetrics) Render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workers := make([]string, 0, len(m.loops))
+	for name := range m.loops {
+		workers = append(workers, name)
+	}
+	slices.Sort(workers)
+
+	var w metrics.Writer
+	const hist = "hoster_worker_loop_duration_seconds"
+	w.Family(hist, "histogram", "Durations of background workers' passes, by worker.")
+	for _, name := range workers {
+		w.Histogram(hist, metrics.Labels("worker", name), m.loops[name].Clone())
+	}
+	w.Family("hoster_worker_loop_last_duration_seconds", "gauge", "Duration of each background worker's last pass.")
+	for _, name := range workers {
+		w.Sample("hoster_worker_loop_last_duration_seconds", metrics.Labels("worker", name), m.loops[name].Last.Seconds())
+	}
+	return w.String()
+}
+
+// =============================================================================
+// State Gauges
+// =============================================================================
+
+// renderStateMetrics reads the gauges of the platform's current state. A
+// gauge whose query fails is left out; the rest are still served.
+func renderStateMetrics(ctx context.Context, store *Store, bus *Bus) string {
+	var w metrics.Writer
+
+	var byStatus []struct {
+		Status string `db:"status"`
+		Count  int64  `db:"n"`
+	}
+	if err := store.db.SelectContext(ctx, &byStatus,
+		`SELECT status, COUNT(*) AS n FROM deployments GROUP BY status ORDER BY status`); err == nil {
+		w.Family("hoster_deployments", "gauge", "Deployments, by status.")
+		for _, row := range byStatus {
+			w.Sample("hoster_deployments", metrics.Labels("status", row.Status), float64(row.Count))
+		}
+	}
+
+	var nodes []struct {
+		ReferenceID  string  `db:"reference_id"`
+		Status       string  `db:"status"`
+		CPUCores     float64 `db:"capacity_cpu_cores"`
+		CPUUsed      float64 `db:"capacity_cpu_used"`
+		MemoryMB     int64   `db:"capacity_memory_mb"`
+		MemoryUsedMB int64   `db:"capacity_memory_used_mb"`
+		DiskMB       int64   `db:"capacity_disk_mb"`
+		DiskUsedMB   int64   `db:"capacity_disk_used_mb"`
+	}
+	if err := store.db.SelectContext(ctx, &nodes,
+		`SELECT reference_id, COALESCE(status, '') AS status,
+			COALESCE(capacity_cpu_cores, 0) AS capacity_cpu_cores, COALESCE(capacity_cpu_used, 0) AS capacity_cpu_used,
+			COALESCE(capacity_memory_mb, 0) AS capacity_memory_mb, COALESCE(capacity_memory_used_mb, 0) AS capacity_memory_used_mb,
+			COALESCE(capacity_disk_mb, 0) AS capacity_disk_mb, COALESCE(capacity_disk_used_mb, 0) AS capacity_disk_used_mb
+		FROM nodes ORDER BY reference_id`); err == nil {
+		gauge := func(name, help string, value func(i int) float64) {
+			w.Family(name, "gauge", help)
+			for i, n := range nodes {
+				w.Sample(name, metrics.Labels("node", n.ReferenceID, "status", n.Status), value(i))
+			}
+		}
+		gauge("hoster_node_cpu_cores", "Nodes' CPU capacity, in cores.", func(i int) float64 { return nodes[i].CPUCores })
+		gauge("hoster_node_cpu_used_cores", "Nodes' CPU allocated to deployments, in cores.", func(i int) float64 { return nodes[i].CPUUsed })
+		gauge("hoster_node_memory_bytes", "Nodes' memory capacity.", func(i int) float64 { return float64(nodes[i].MemoryMB << 20) })
+		gauge("hoster_node_memory_used_bytes", "Nodes' memory allocated to deployments.", func(i int) float64 { return float64(nodes[i].MemoryUsedMB << 20) })
+		gauge("hoster_node_disk_bytes", "Nodes' disk capacity.", func(i int) float64 { return float64(nodes[i].DiskMB << 20) })
+		gauge("hoster_node_disk_used_bytes", "Nodes' disk allocated to deployments.", func(i int) float64 { return float64(nodes[i].DiskUsedMB << 20) })
+	}
+
+	var queue struct {
+		Pending int64 `db:"pending"`
+		Due     int64 `db:"due"`
+		Running int64 `db:"running"`
+	}
+	if err := store.db.GetContext(ctx, &queue,
+		`SELECT COALESCE(SUM(status = ?), 0) AS pending,
+			COALESCE(SUM(status = ? AND run_at <= ?), 0) AS due,
+			COALESCE(SUM(status = ?), 0) AS running
+		FROM jobs WHERE key != ''`,
+		jobs.StatusQueued, jobs.StatusQueued, time.Now().UTC().Format(time.RFC3339), jobs.StatusRunning); err == nil {
+		w.Family("hoster_scheduled_commands", "gauge", "Delayed jobs waiting or running, by state; due jobs are pending ones whose time has come.")
+		w.Sample("hoster_scheduled_commands", metrics.Labels("state", "pending"), float64(queue.Pending))
+		w.Sample("hoster_scheduled_commands", metrics.Labels("state", "due"), float64(queue.Due))
+		w.Sample("hoster_scheduled_commands", metrics.Labels("state", "running"), float64(queue.Running))
+	}
+
+	var jobQueue struct {
+		Queued  int64 `db:"queued"`
+		Running int64 `db:"running"`
+		Dead    int64 `db:"dead"`
+	}
+	if err := store.db.GetContext(ctx, &jobQueue,
+		`SELECT COALESCE(SUM(status = ?), 0) AS queued,
+			COALESCE(SUM(status = ?), 0) AS running,
+			COALESCE(SUM(status = ?), 0) AS dead
+		FROM jobs`,
+		jobs.StatusQueued, jobs.StatusRunning, jobs.StatusDead); err == nil {
+		w.Family("hoster_jobs", "gauge", "Command jobs queued, running and dead-lettered.")
+		w.Sample("hoster_jobs", metrics.Labels("status", "queued"), float64(jobQueue.Queued))
+		w.Sample("hoster_jobs", metrics.Labels("status", "running"), float64(jobQueue.Running))
+		w.Sample("hoster_jobs", metrics.Labels("status", "dead"), float64(jobQueue.Dead))
+	}
+
+	if bus != nil {
+		w.Family("hoster_commands_in_flight", "gauge", "Commands the command bus is running on this replica.")
+		w.Sample("hoster_commands_in_flight", nil, float64(bus.InFlight()))
+	}
+	return w.String()
+}
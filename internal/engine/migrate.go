@@ -1,13 +1,17 @@
 package engine
 
 import (
+	"context"
+	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
@@ -22,14 +26,17 @@ func OpenDB(dsn string, resources []Resource, logger *slog.Logger) (*Store, erro
 		logger = slog.Default()
 	}
 
-	db, err := sqlx.Open("sqlite3", dsn+"?_foreign_keys=on")
+	db, err := openSQLite(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("open database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	// Run schema-based migrations (CREATE TABLE IF NOT EXISTS for each resource).
+	// These go first so the seed data below has its tables on a fresh database;
+	// SQLite accepts the references to users before that table exists.
+	if err := runSchemaMigrations(db, resources, logger); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("ping database: %w", err)
+		return nil, fmt.Errorf("schema migrations: %w", err)
 	}
 
 	// Run file-based migrations (for the users table and seed data that predates the engine)
@@ -38,21 +45,76 @@ func OpenDB(dsn string, resources []Resource, logger *slog.Logger) (*Store, erro
 		return nil, fmt.Errorf("run migrations: %w", err)
 	}
 
-	// Run schema-based migrations (CREATE TABLE IF NOT EXISTS for each resource)
-	if err := runSchemaMigrations(db, resources, logger); err != nil {
+	store, err := NewStore(db, resources)
+	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("schema migrations: %w", err)
+		return nil, err
 	}
 
+	return store, nil
+}
+
+// OpenExistingDB opens a SQLite database as it is, without running
+// migrations, for tools that inspect a database rather than serve it.
+// Callers check SchemaVersion before relying on the schema.
+func OpenExistingDB(dsn string, resources []Resource) (*Store, error) {
+	db, err := openSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
 	store, err := NewStore(db, resources)
 	if err != nil {
 		db.Close()
 		return nil, err
 	}
-
 	return store, nil
 }
 
+func openSQLite(dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("sqlite3", dsn+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+	return db, nil
+}
+
+// SchemaVersion returns the file migration version the database is at,
+// whether a migration to it failed partway, and the newest version this
+// build has. A database no migration has run on is at version 0.
+func (s *Store) SchemaVersion(ctx context.Context) (version, latest uint, dirty bool, err error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("create migration source: %w", err)
+	}
+	if latest, err = latestMigration(src); err != nil {
+		return 0, 0, false, fmt.Errorf("read migrations: %w", err)
+	}
+
+	var n int
+	if err := s.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`); err != nil {
+		return 0, latest, false, fmt.Errorf("inspect schema: %w", err)
+	}
+	if n == 0 {
+		return 0, latest, false, nil
+	}
+	var row struct {
+		Version uint `db:"version"`
+		Dirty   bool `db:"dirty"`
+	}
+	err = s.db.GetContext(ctx, &row, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, latest, false, nil
+	}
+	if err != nil {
+		return 0, latest, false, fmt.Errorf("read schema version: %w", err)
+	}
+	return row.Version, latest, row.Dirty, nil
+}
+
 func runFileMigrations(db *sqlx.DB) error {
 	driver, err := sqlite3.WithInstance(db.DB, &sqlite3.Config{NoTxWrap: true})
 	if err != nil {
@@ -73,12 +135,20 @@ func runFileMigrations(db *sqlx.DB) error {
 	// new migration numbering takes over. Schema is now engine-driven
 	// (CREATE TABLE IF NOT EXISTS). Then fall through to m.Up() to apply
 	// any new file migrations (v2+).
+	latest, err := latestMigration(source)
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
 	version, dirty, err := m.Version()
-	if err == nil && !dirty && version > 2 && version < 100 {
-		// Old migrations used versions 2-11 for a different schema.
-		// Force to 1 so our new numbering (001, 002, ...) takes over.
-		if err := m.Force(1); err != nil {
-			return fmt.Errorf("force migration version: %w", err)
+	if err == nil && !dirty {
+		legacy, err := legacyVersion(db, version, latest)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			if err := m.Force(1); err != nil {
+				return fmt.Errorf("force migration version: %w", err)
+			}
 		}
 	}
 
@@ -90,6 +160,44 @@ func runFileMigrations(db *sqlx.DB) error {
 	return nil
 }
 
+// legacyVersion reports whether a database at version was left by the old
+// migrations, which used versions 2-11 for a different schema. Version 2
+// means the same in both numberings, and old versions past the newest file
+// migration can only be old ones. Versions both numberings use are told
+// apart by users.timezone, which the new 003 adds and the old schema never
+// had.
+func legacyVersion(db *sqlx.DB, version, latest uint) (bool, error) {
+	switch {
+	case version <= 2 || version >= 100:
+		return false, nil
+	case version > latest:
+		return true, nil
+	}
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'timezone'`); err != nil {
+		return false, fmt.Errorf("inspect users table: %w", err)
+	}
+	return n == 0, nil
+}
+
+// latestMigration returns the newest version in a migration source.
+func latestMigration(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
 func runSchemaMigrations(db *sqlx.DB, resources []Resource, logger *slog.Logger) error {
 	for _, res := range resources {
 		sql := res.GenerateCreateSQL()
@@ -120,6 +228,82 @@ func runSchemaMigrations(db *sqlx.DB, resources []Resource, logger *slog.Logger)
 		`ALTER TABLE ssh_keys RENAME COLUMN private_key_encrypted TO private_key`,
 		`ALTER TABLE ssh_keys ADD COLUMN public_key TEXT`,
 		`ALTER TABLE cloud_credentials RENAME COLUMN credentials_encrypted TO credentials`,
+		`ALTER TABLE templates ADD COLUMN setup_flow TEXT`,
+		`ALTER TABLE templates ADD COLUMN presets TEXT`,
+		`ALTER TABLE nodes ADD COLUMN alerts TEXT`,
+		`ALTER TABLE deployments ADD COLUMN upgrade_policy TEXT DEFAULT 'auto'`,
+		`ALTER TABLE deployments ADD COLUMN maintenance_windows TEXT`,
+		`ALTER TABLE deployments ADD COLUMN upgrade_status TEXT DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN pending_version TEXT`,
+		`ALTER TABLE deployments ADD COLUMN upgrade_scheduled_at DATETIME`,
+		`ALTER TABLE deployments ADD COLUMN last_upgraded_at DATETIME`,
+		`ALTER TABLE nodes ADD COLUMN housekeeping TEXT`,
+		`ALTER TABLE deployments ADD COLUMN colocate_with TEXT`,
+		`ALTER TABLE deployments ADD COLUMN affinity_status TEXT DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN affinity_reason TEXT`,
+		`ALTER TABLE nodes ADD COLUMN air_gapped INTEGER DEFAULT 0`,
+		`ALTER TABLE nodes ADD COLUMN image_relay_id TEXT`,
+		`ALTER TABLE deployments ADD COLUMN health TEXT`,
+		`ALTER TABLE deployments ADD COLUMN upgrade_strategy TEXT DEFAULT 'recreate'`,
+		`ALTER TABLE deployments ADD COLUMN canary_policy TEXT`,
+		`ALTER TABLE deployments ADD COLUMN canary_port INTEGER`,
+		`ALTER TABLE deployments ADD COLUMN canary_percent INTEGER DEFAULT 0`,
+		`ALTER TABLE deployments ADD COLUMN canary_started_at DATETIME`,
+		`ALTER TABLE deployments ADD COLUMN canary_stats TEXT`,
+		`ALTER TABLE deployments ADD COLUMN placement_rule TEXT DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN placement_reason TEXT`,
+		`ALTER TABLE nodes ADD COLUMN public_address TEXT`,
+		`ALTER TABLE nodes ADD COLUMN private_address TEXT`,
+		`ALTER TABLE nodes ADD COLUMN detected_public_address TEXT`,
+		`ALTER TABLE nodes ADD COLUMN address_source TEXT`,
+		`ALTER TABLE nodes ADD COLUMN network_warnings TEXT`,
+		`ALTER TABLE nodes ADD COLUMN network_checked_at TEXT`,
+		`ALTER TABLE deployments ADD COLUMN restore_checkpoints TEXT`,
+		`ALTER TABLE templates ADD COLUMN trial TEXT`,
+		`ALTER TABLE deployments ADD COLUMN expires_at DATETIME`,
+		`ALTER TABLE deployments ADD COLUMN delete_at DATETIME`,
+		`ALTER TABLE deployments ADD COLUMN trial_source TEXT DEFAULT ''`,
+		`ALTER TABLE volume_migrations ADD COLUMN mode TEXT NOT NULL DEFAULT 'cold'`,
+		`ALTER TABLE volume_migrations ADD COLUMN checkpoints TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE volume_migrations ADD COLUMN clone_deployment_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE templates ADD COLUMN resource_ceilings TEXT`,
+		`ALTER TABLE deployments ADD COLUMN service_overrides TEXT`,
+		`ALTER TABLE deployments ADD COLUMN labels TEXT`,
+		`ALTER TABLE deployments ADD COLUMN image_drift TEXT`,
+		`ALTER TABLE deployments ADD COLUMN image_drift_checked_at DATETIME`,
+		`ALTER TABLE templates ADD COLUMN assets TEXT`,
+		`ALTER TABLE templates ADD COLUMN registry_source TEXT`,
+		`ALTER TABLE templates ADD COLUMN compose_warnings TEXT`,
+		`ALTER TABLE nodes ADD COLUMN gpu_inventory TEXT`,
+		`ALTER TABLE nodes ADD COLUMN gpu_checked_at TEXT`,
+		`ALTER TABLE deployments ADD COLUMN gpu_devices TEXT`,
+		`ALTER TABLE deployments ADD COLUMN gpu_metered_at TEXT`,
+		`ALTER TABLE webhooks ADD COLUMN template_id INTEGER`,
+		`ALTER TABLE nodes ADD COLUMN docker_version TEXT`,
+		`ALTER TABLE nodes ADD COLUMN docker_api_version TEXT`,
+		`ALTER TABLE nodes ADD COLUMN wildcard_dns_status TEXT`,
+		`ALTER TABLE nodes ADD COLUMN wildcard_dns_error TEXT`,
+		`ALTER TABLE nodes ADD COLUMN wildcard_dns_checked_at TEXT`,
+		`ALTER TABLE deployments ADD COLUMN abuse_score INTEGER DEFAULT 0`,
+		`ALTER TABLE deployments ADD COLUMN abuse_anomalies TEXT`,
+		`ALTER TABLE deployments ADD COLUMN abuse_flagged_at TEXT`,
+		`ALTER TABLE deployments ADD COLUMN abuse_throttled_at TEXT`,
+		`ALTER TABLE deployments ADD COLUMN abuse_dismissed_at TEXT`,
+		`ALTER TABLE templates ADD COLUMN routing_options TEXT`,
+		`ALTER TABLE deployments ADD COLUMN routing_options TEXT`,
+		`ALTER TABLE deployments ADD COLUMN slo_alerts TEXT`,
+		`ALTER TABLE deployments ADD COLUMN location TEXT`,
+		`ALTER TABLE nodes ADD COLUMN unschedulable INTEGER DEFAULT 0`,
+		`ALTER TABLE deployments ADD COLUMN stream_ports TEXT`,
+		`ALTER TABLE deployments ADD COLUMN schedule TEXT`,
+		`ALTER TABLE deployments ADD COLUMN schedule_next_at DATETIME`,
+		`ALTER TABLE deployments ADD COLUMN schedule_next_action TEXT`,
+		`ALTER TABLE deployments ADD COLUMN schedule_error TEXT`,
+		`ALTER TABLE nodes ADD COLUMN timezone TEXT`,
+		`ALTER TABLE deployments ADD COLUMN replica_nodes TEXT`,
+		`ALTER TABLE deployments ADD COLUMN replica_bridges TEXT`,
+		`ALTER TABLE deployments ADD COLUMN replica_status TEXT`,
+		`ALTER TABLE deployments ADD COLUMN running_spec TEXT`,
 	)
 
 	for _, sql := range alterStatements {
@@ -129,6 +313,15 @@ func runSchemaMigrations(db *sqlx.DB, resources []Resource, logger *slog.Logger)
 		}
 	}
 
+	// Index reference columns now that every column exists
+	for _, res := range resources {
+		for _, sql := range res.GenerateIndexSQL() {
+			if _, err := db.Exec(sql); err != nil {
+				return fmt.Errorf("create index on %s: %w", res.Name, err)
+			}
+		}
+	}
+
 	// Ensure ancillary tables exist (not schema-driven entities)
 	ancillaryTables := []string{
 		`CREATE TABLE IF NOT EXISTS usage_events (
@@ -156,6 +349,549 @@ func runSchemaMigrations(db *sqlx.DB, resources []Resource, logger *slog.Logger)
 			timestamp TEXT NOT NULL DEFAULT (datetime('now'))
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_container_events_deployment_time ON container_events(deployment_id, timestamp DESC)`,
+		`CREATE TABLE IF NOT EXISTS deployment_domains (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			hostname TEXT NOT NULL,
+			type TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT (datetime('now'))
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_deployment_domains_hostname ON deployment_domains(hostname)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_domains_deployment ON deployment_domains(deployment_id)`,
+		`CREATE TABLE IF NOT EXISTS node_metrics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			collected_at TEXT NOT NULL,
+			load_avg_1 REAL NOT NULL DEFAULT 0,
+			load_avg_5 REAL NOT NULL DEFAULT 0,
+			load_avg_15 REAL NOT NULL DEFAULT 0,
+			cpu_used_percent REAL NOT NULL DEFAULT 0,
+			memory_used_mb INTEGER NOT NULL DEFAULT 0,
+			memory_total_mb INTEGER NOT NULL DEFAULT 0,
+			disk_max_percent REAL NOT NULL DEFAULT 0,
+			dockerd_status TEXT NOT NULL DEFAULT '',
+			journal_errors INTEGER NOT NULL DEFAULT 0,
+			details TEXT,
+			alerts TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_metrics_node_time ON node_metrics(node_id, collected_at DESC)`,
+		`CREATE TABLE IF NOT EXISTS container_metrics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			template_id INTEGER NOT NULL DEFAULT 0,
+			template_version TEXT NOT NULL DEFAULT '',
+			service TEXT NOT NULL,
+			collected_at TEXT NOT NULL,
+			cpu_percent REAL NOT NULL DEFAULT 0,
+			memory_usage_bytes INTEGER NOT NULL DEFAULT 0,
+			memory_limit_bytes INTEGER NOT NULL DEFAULT 0,
+			network_rx_bytes INTEGER NOT NULL DEFAULT 0,
+			network_tx_bytes INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_metrics_deployment_time ON container_metrics(deployment_id, collected_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_metrics_template_time ON container_metrics(template_id, collected_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_template_reviews_template_user ON template_reviews(template_id, user_id)`,
+		`CREATE TABLE IF NOT EXISTS review_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			review_id INTEGER NOT NULL REFERENCES template_reviews(id) ON DELETE CASCADE,
+			reporter_id INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at TEXT NOT NULL,
+			resolved_at TEXT,
+			UNIQUE(review_id, reporter_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_review_reports_status ON review_reports(status, created_at)`,
+		`CREATE TABLE IF NOT EXISTS log_exports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT NOT NULL UNIQUE,
+			deployment_id TEXT NOT NULL,
+			requested_by INTEGER NOT NULL,
+			services TEXT NOT NULL DEFAULT '[]',
+			since TEXT NOT NULL,
+			until TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			cap_bytes INTEGER NOT NULL DEFAULT 0,
+			log_bytes INTEGER NOT NULL DEFAULT 0,
+			size_bytes INTEGER NOT NULL DEFAULT 0,
+			truncated INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			completed_at TEXT,
+			expires_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_log_exports_deployment ON log_exports(deployment_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_log_exports_status ON log_exports(status, expires_at)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT NOT NULL UNIQUE,
+			command TEXT NOT NULL,
+			resource_type TEXT NOT NULL DEFAULT '',
+			resource_id TEXT NOT NULL DEFAULT '',
+			key TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL DEFAULT '{}',
+			status TEXT NOT NULL DEFAULT 'queued',
+			run_at TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 5,
+			last_error TEXT NOT NULL DEFAULT '',
+			execution_id TEXT NOT NULL DEFAULT '',
+			claimed_by TEXT NOT NULL DEFAULT '',
+			claimed_until TEXT,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			finished_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(status, run_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_resource ON jobs(resource_type, resource_id, status)`,
+		`CREATE TABLE IF NOT EXISTS command_executions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT NOT NULL UNIQUE,
+			command TEXT NOT NULL,
+			resource_type TEXT NOT NULL DEFAULT '',
+			resource_id TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT 'dispatch',
+			retry_of TEXT NOT NULL DEFAULT '',
+			triggered_by TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL DEFAULT '{}',
+			status TEXT NOT NULL DEFAULT 'running',
+			error TEXT NOT NULL DEFAULT '',
+			started_at TEXT NOT NULL,
+			finished_at TEXT,
+			duration_ms INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_command_executions_resource ON command_executions(resource_type, resource_id, started_at)`,
+		`CREATE TABLE IF NOT EXISTS template_uploads (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT NOT NULL UNIQUE,
+			template_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			mode TEXT NOT NULL DEFAULT '',
+			content_type TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL,
+			received INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_template_uploads_expires ON template_uploads(status, expires_at)`,
+		`CREATE TABLE IF NOT EXISTS installation (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			admin_reference_id TEXT NOT NULL,
+			starter_templates INTEGER NOT NULL DEFAULT 1,
+			initialized_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			content_type TEXT NOT NULL DEFAULT '',
+			response_body BLOB,
+			created_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL,
+			UNIQUE(user_id, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at)`,
+		`CREATE TABLE IF NOT EXISTS secret_resolutions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id TEXT NOT NULL,
+			variable TEXT NOT NULL,
+			reference TEXT NOT NULL,
+			source TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			resolved_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_secret_resolutions_deployment ON secret_resolutions(deployment_id, resolved_at DESC)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_secrets_customer_name ON secrets(customer_id, name)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_events_timestamp ON usage_events(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_events_timestamp ON container_events(timestamp)`,
+		`CREATE TABLE IF NOT EXISTS usage_daily (
+			day TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			resource_type TEXT NOT NULL DEFAULT '',
+			events INTEGER NOT NULL DEFAULT 0,
+			quantity INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, user_id, event_type, resource_type)
+		)`,
+		`CREATE TABLE IF NOT EXISTS event_archives (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			table_name TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			row_count INTEGER NOT NULL,
+			first_id INTEGER NOT NULL,
+			last_id INTEGER NOT NULL,
+			first_timestamp TEXT NOT NULL DEFAULT '',
+			last_timestamp TEXT NOT NULL DEFAULT '',
+			bytes INTEGER NOT NULL DEFAULT 0,
+			sha256 TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS volume_migrations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			deployment_id TEXT NOT NULL,
+			source_node_id TEXT NOT NULL,
+			target_node_id TEXT NOT NULL,
+			requested_by INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			switch_node INTEGER NOT NULL DEFAULT 1,
+			mode TEXT NOT NULL DEFAULT 'cold',
+			clone_deployment_id TEXT NOT NULL DEFAULT '',
+			volumes TEXT NOT NULL DEFAULT '[]',
+			checkpoints TEXT NOT NULL DEFAULT '[]',
+			total_bytes INTEGER NOT NULL DEFAULT 0,
+			transferred_bytes INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			started_at TEXT,
+			completed_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_volume_migrations_deployment ON volume_migrations(deployment_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_volume_migrations_status ON volume_migrations(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_volume_migrations_clone ON volume_migrations(clone_deployment_id)`,
+		`CREATE TABLE IF NOT EXISTS deployment_migrations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			deployment_id TEXT NOT NULL,
+			source_node_id TEXT NOT NULL,
+			target_node_id TEXT NOT NULL,
+			region TEXT NOT NULL DEFAULT '',
+			requested_by INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			failed_step TEXT NOT NULL DEFAULT '',
+			was_running INTEGER NOT NULL DEFAULT 0,
+			source_proxy_port INTEGER,
+			volume_migration_id TEXT NOT NULL DEFAULT '',
+			transfer_percent INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			started_at TEXT,
+			completed_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_migrations_deployment ON deployment_migrations(deployment_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_migrations_status ON deployment_migrations(status)`,
+		`CREATE TABLE IF NOT EXISTS node_drains (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			node_id TEXT NOT NULL,
+			requested_by INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'running',
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			completed_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_drains_node ON node_drains(node_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_drains_status ON node_drains(status)`,
+		`CREATE TABLE IF NOT EXISTS node_drain_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			drain_id INTEGER NOT NULL,
+			deployment_id TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			target_node_id TEXT NOT NULL DEFAULT '',
+			migration_id TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_drain_items_drain ON node_drain_items(drain_id, id)`,
+		`CREATE TABLE IF NOT EXISTS deployment_buckets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			deployment_id TEXT NOT NULL,
+			owner_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			bucket_name TEXT NOT NULL,
+			backend TEXT NOT NULL,
+			node_id TEXT NOT NULL DEFAULT '',
+			variable_prefix TEXT NOT NULL,
+			retention_policy TEXT NOT NULL DEFAULT 'delete',
+			retention_days INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'pending',
+			credentials TEXT NOT NULL DEFAULT '',
+			endpoint TEXT NOT NULL DEFAULT '',
+			size_bytes INTEGER NOT NULL DEFAULT 0,
+			object_count INTEGER NOT NULL DEFAULT 0,
+			measured_at TEXT,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			released_at TEXT,
+			purge_after TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_buckets_deployment ON deployment_buckets(deployment_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_buckets_status ON deployment_buckets(status)`,
+		`CREATE TABLE IF NOT EXISTS node_housekeeping_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			node_id TEXT NOT NULL,
+			requested_by INTEGER NOT NULL DEFAULT 0,
+			triggered_by TEXT NOT NULL,
+			dry_run INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'pending',
+			tasks TEXT NOT NULL DEFAULT '[]',
+			report TEXT NOT NULL DEFAULT '',
+			reclaimed_bytes INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			started_at TEXT,
+			completed_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_housekeeping_runs_node ON node_housekeeping_runs(node_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_housekeeping_runs_status ON node_housekeeping_runs(status)`,
+		`CREATE TABLE IF NOT EXISTS daily_stats (
+			day TEXT NOT NULL,
+			creator_id INTEGER NOT NULL DEFAULT 0,
+			deployments_by_status TEXT,
+			deployments_created INTEGER NOT NULL DEFAULT 0,
+			new_customers INTEGER NOT NULL DEFAULT 0,
+			usage TEXT,
+			gross_cents INTEGER NOT NULL DEFAULT 0,
+			net_cents INTEGER NOT NULL DEFAULT 0,
+			computed_at TEXT NOT NULL,
+			PRIMARY KEY (day, creator_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS daily_node_stats (
+			day TEXT NOT NULL,
+			node_id INTEGER NOT NULL,
+			samples INTEGER NOT NULL DEFAULT 0,
+			cpu_avg_percent REAL NOT NULL DEFAULT 0,
+			cpu_max_percent REAL NOT NULL DEFAULT 0,
+			memory_avg_percent REAL NOT NULL DEFAULT 0,
+			memory_max_percent REAL NOT NULL DEFAULT 0,
+			disk_max_percent REAL NOT NULL DEFAULT 0,
+			deployments INTEGER,
+			computed_at TEXT NOT NULL,
+			PRIMARY KEY (day, node_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL,
+			template_id INTEGER,
+			type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_events_user_time ON webhook_events(user_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event_id INTEGER NOT NULL REFERENCES webhook_events(id) ON DELETE CASCADE,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TEXT,
+			response_code INTEGER,
+			response_body TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			replay INTEGER NOT NULL DEFAULT 0,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			delivered_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id DESC)`,
+		`CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			token INTEGER NOT NULL DEFAULT 1,
+			acquired_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			hint TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			expires_at TEXT,
+			last_used_at TEXT,
+			revoked_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id, id DESC)`,
+		`CREATE TABLE IF NOT EXISTS node_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			node_id TEXT NOT NULL,
+			creator_id INTEGER NOT NULL,
+			ssh_key_id INTEGER NOT NULL DEFAULT 0,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			retire_at TEXT,
+			last_used_at TEXT,
+			revoked_at TEXT,
+			removed_at TEXT,
+			reminded_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_keys_node ON node_keys(node_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_node_keys_status ON node_keys(status)`,
+		`CREATE TABLE IF NOT EXISTS node_key_policies (
+			user_id INTEGER PRIMARY KEY,
+			max_age_days INTEGER NOT NULL DEFAULT 0,
+			auto_rotate INTEGER NOT NULL DEFAULT 0,
+			window_hours INTEGER NOT NULL DEFAULT 0,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS spending_limits (
+			user_id INTEGER PRIMARY KEY,
+			monthly_limit_cents INTEGER NOT NULL DEFAULT 0,
+			alert_percent INTEGER NOT NULL DEFAULT 80,
+			hard_stop INTEGER NOT NULL DEFAULT 0,
+			override_active INTEGER NOT NULL DEFAULT 0,
+			override_until TEXT,
+			override_reason TEXT NOT NULL DEFAULT '',
+			override_by TEXT NOT NULL DEFAULT '',
+			alerted_month TEXT NOT NULL DEFAULT '',
+			alerted_level TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS playground_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			template_id INTEGER NOT NULL,
+			deployment_id TEXT NOT NULL,
+			client_address TEXT NOT NULL DEFAULT '',
+			credentials TEXT NOT NULL DEFAULT '[]',
+			created_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL,
+			user_id INTEGER,
+			converted_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_playground_sessions_expires ON playground_sessions(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_playground_sessions_template ON playground_sessions(template_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS pipeline_promotions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			pipeline_id INTEGER NOT NULL,
+			from_stage TEXT NOT NULL,
+			to_stage TEXT NOT NULL,
+			source_deployment TEXT NOT NULL,
+			target_deployment TEXT NOT NULL,
+			version TEXT NOT NULL DEFAULT '',
+			previous_version TEXT NOT NULL DEFAULT '',
+			changed_variables TEXT NOT NULL DEFAULT '[]',
+			status TEXT NOT NULL,
+			requested_by INTEGER NOT NULL,
+			decided_by INTEGER,
+			error_message TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			decided_at TEXT,
+			finished_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_pipeline_promotions_pipeline ON pipeline_promotions(pipeline_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_pipeline_promotions_target ON pipeline_promotions(target_deployment, status)`,
+		`CREATE TABLE IF NOT EXISTS tunnel_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			deployment_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			service TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			client_address TEXT NOT NULL DEFAULT '',
+			started_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL,
+			ended_at TEXT,
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
+			end_reason TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tunnel_sessions_deployment ON tunnel_sessions(deployment_id, id DESC)`,
+		`CREATE TABLE IF NOT EXISTS slo_samples (
+			deployment_id TEXT NOT NULL,
+			period_start TEXT NOT NULL,
+			checks INTEGER NOT NULL DEFAULT 0,
+			failed_checks INTEGER NOT NULL DEFAULT 0,
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			slow INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (deployment_id, period_start)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_slo_samples_period ON slo_samples(period_start)`,
+		`CREATE TABLE IF NOT EXISTS domain_certificates (
+			hostname TEXT PRIMARY KEY,
+			deployment_id TEXT NOT NULL,
+			challenge TEXT NOT NULL DEFAULT 'http-01',
+			status TEXT NOT NULL,
+			cert_pem TEXT NOT NULL DEFAULT '',
+			key_encrypted TEXT NOT NULL DEFAULT '',
+			not_before TEXT,
+			not_after TEXT,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TEXT,
+			issued_at TEXT,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS acme_challenges (
+			token TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL,
+			key_authorization TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS acme_accounts (
+			directory_url TEXT PRIMARY KEY,
+			key_encrypted TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			user_reference_id TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			resource_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL DEFAULT '',
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			changes TEXT NOT NULL DEFAULT '{}',
+			request_id TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_user ON audit_events(user_reference_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_resource ON audit_events(resource_type, resource_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS usage_meters (
+			deployment_id TEXT PRIMARY KEY,
+			sampled_at TEXT NOT NULL,
+			cpu_percent REAL NOT NULL DEFAULT 0,
+			memory_bytes INTEGER NOT NULL DEFAULT 0,
+			tx_bytes TEXT NOT NULL DEFAULT '{}'
+		)`,
+		`CREATE TABLE IF NOT EXISTS usage_hours (
+			deployment_id TEXT NOT NULL,
+			hour TEXT NOT NULL,
+			customer_id INTEGER NOT NULL DEFAULT 0,
+			node_id TEXT NOT NULL DEFAULT '',
+			cpu_seconds REAL NOT NULL DEFAULT 0,
+			memory_mb_seconds REAL NOT NULL DEFAULT 0,
+			egress_bytes INTEGER NOT NULL DEFAULT 0,
+			recorded_at TEXT,
+			PRIMARY KEY (deployment_id, hour)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_hours_pending ON usage_hours(hour) WHERE recorded_at IS NULL`,
 	}
 	for _, sql := range ancillaryTables {
 		if _, err := db.Exec(sql); err != nil {
@@ -171,6 +907,45 @@ func runSchemaMigrations(db *sqlx.DB, resources []Resource, logger *slog.Logger)
 	if _, err := db.Exec(`ALTER TABLE container_events ADD COLUMN reference_id TEXT`); err != nil {
 		// Ignore error — column may already exist
 	}
+	// Template events record their template (older schema recorded user events only)
+	if _, err := db.Exec(`ALTER TABLE webhook_events ADD COLUMN template_id INTEGER`); err != nil {
+		// Ignore error — column may already exist
+	}
+	// Network counters feed abuse detection (older samples have none)
+	if _, err := db.Exec(`ALTER TABLE container_metrics ADD COLUMN network_rx_bytes INTEGER NOT NULL DEFAULT 0`); err != nil {
+		// Ignore error — column may already exist
+	}
+	if _, err := db.Exec(`ALTER TABLE container_metrics ADD COLUMN network_tx_bytes INTEGER NOT NULL DEFAULT 0`); err != nil {
+		// Ignore error — column may already exist
+	}
+	// Delayed jobs are keyed (older job queues had none)
+	if _, err := db.Exec(`ALTER TABLE jobs ADD COLUMN key TEXT NOT NULL DEFAULT ''`); err != nil {
+		// Ignore error — column may already exist
+	}
+	// Running jobs are claimed by the bus running them (older queues weren't)
+	if _, err := db.Exec(`ALTER TABLE jobs ADD COLUMN claimed_by TEXT NOT NULL DEFAULT ''`); err != nil {
+		// Ignore error — column may already exist
+	}
+	if _, err := db.Exec(`ALTER TABLE jobs ADD COLUMN claimed_until TEXT`); err != nil {
+		// Ignore error — column may already exist
+	}
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_queued_key ON jobs(key) WHERE status = 'queued' AND key != ''`); err != nil {
+		logger.Warn("jobs key index creation", "error", err)
+	}
+	// Delayed commands used to have their own queue
+	if err := foldScheduledCommands(db, logger); err != nil {
+		return err
+	}
+
+	// Full-text search of the template catalog (needs FTS5 compiled in)
+	if err := createTemplateSearchIndex(db); err != nil {
+		logger.Info("template search index unavailable, search uses LIKE matching", "error", err)
+	}
+
+	// Populate hostname claims from existing deployments (dedupes conflicts)
+	if err := backfillDeploymentDomains(db, logger); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Migration Tests
+// =============================================================================
+
+func latestFileMigration(t *testing.T) uint {
+	t.Helper()
+	src, err := iofs.New(migrationsFS, "migrations")
+	require.NoError(t, err)
+	latest, err := latestMigration(src)
+	require.NoError(t, err)
+	return latest
+}
+
+// migrationVersion opens dsn, returning its file migration version.
+func migrationVersion(t *testing.T, dsn string) (uint, bool) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	var row struct {
+		Version uint `db:"version"`
+		Dirty   bool `db:"dirty"`
+	}
+	require.NoError(t, db.Get(&row, `SELECT version, dirty FROM schema_migrations`))
+	return row.Version, row.Dirty
+}
+
+func TestOpenDB_BootsTwice(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	latest := latestFileMigration(t)
+
+	for boot := 1; boot <= 2; boot++ {
+		store, err := OpenDB(dsn, Schema(), nil)
+		require.NoError(t, err, "boot %d", boot)
+		require.NoError(t, store.Close())
+
+		version, dirty := migrationVersion(t, dsn)
+		assert.Equal(t, latest, version, "boot %d", boot)
+		assert.False(t, dirty, "boot %d", boot)
+	}
+}
+
+func TestOpenDB_LegacyVersion(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	db, err := sqlx.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	// A database the old migrations left at their version 3: its users
+	// table predates the new 003's timezone column
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			email TEXT DEFAULT '',
+			name TEXT DEFAULT '',
+			plan_id TEXT DEFAULT 'free',
+			created_at TEXT NOT NULL DEFAULT (datetime('now')),
+			updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+		);
+		CREATE TABLE schema_migrations (version uint64, dirty bool);
+		INSERT INTO schema_migrations (version, dirty) VALUES (3, false);`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err := OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	var n int
+	require.NoError(t, store.db.Get(&n, `SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'timezone'`))
+	assert.Equal(t, 1, n, "the new 003 ran")
+	require.NoError(t, store.Close())
+
+	version, _ := migrationVersion(t, dsn)
+	assert.Equal(t, latestFileMigration(t), version)
+}
+
+func TestLegacyVersion(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, timezone TEXT)`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		version uint
+		legacy  bool
+	}{
+		{1, false}, {2, false}, {3, false}, {11, true}, {100, false},
+	} {
+		legacy, err := legacyVersion(db, tc.version, 3)
+		require.NoError(t, err)
+		assert.Equal(t, tc.legacy, legacy, "version %d", tc.version)
+	}
+}
+
+func TestOpenDB_BaselineSchema(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	baseline, err := os.ReadFile(filepath.Join("testdata", "baseline_schema.sql"))
+	require.NoError(t, err)
+	db, err := sqlx.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(string(baseline))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err := OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, res := range Schema() {
+		var columns []string
+		require.NoError(t, store.db.Select(&columns, `SELECT name FROM pragma_table_info(?)`, res.Name))
+		for _, f := range res.Fields {
+			assert.Contains(t, columns, f.Name, "%s.%s", res.Name, f.Name)
+		}
+	}
+
+	// Indexes on reference columns the baseline tables gain through an ALTER
+	for _, name := range []string{"idx_deployments_colocate_with", "idx_nodes_image_relay_id"} {
+		var n int
+		require.NoError(t, store.db.Get(&n, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?`, name))
+		assert.Equal(t, 1, n, "index %s", name)
+	}
+}
+
+func TestOpenDB_ManualUpgradeDefault(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "hoster.db")
+	store, err := OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_customer', 'customer@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Policy Test", "creator_id": userID, "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n",
+	})
+	require.NoError(t, err)
+	// Deployments from before 005, given the earlier auto default
+	for _, policy := range []string{"auto", "windowed"} {
+		_, err := store.Create(ctx, "deployments", map[string]any{
+			"name": policy, "customer_id": userID, "template_id": tmpl["id"], "template_version": "1.0.0",
+			"upgrade_policy": policy,
+		})
+		require.NoError(t, err)
+	}
+	_, err = store.db.Exec(`UPDATE schema_migrations SET version = 4`)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = OpenDB(dsn, Schema(), nil)
+	require.NoError(t, err)
+	defer store.Close()
+	policies := map[string]string{}
+	rows, err := store.List(ctx, "deployments", nil, Page{Limit: 10})
+	require.NoError(t, err)
+	for _, row := range rows {
+		policies[strVal(row["name"])] = strVal(row["upgrade_policy"])
+	}
+	assert.Equal(t, map[string]string{"auto": "manual", "windowed": "windowed"}, policies)
+}
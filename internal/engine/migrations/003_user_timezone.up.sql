@@ -0,0 +1,3 @@
+-- Time zone preference: user-defined schedules (maintenance windows, node
+-- housekeeping) are evaluated in it. Empty means UTC.
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- Per-user feature flag overrides: value is 0/1 for bool flags, a maximum
+-- or -1 (unlimited) for limit flags. Overrides win over the user's plan.
+CREATE TABLE IF NOT EXISTS feature_overrides (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    flag TEXT NOT NULL,
+    value INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    updated_by TEXT NOT NULL DEFAULT '',
+    updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (user_id, flag)
+);
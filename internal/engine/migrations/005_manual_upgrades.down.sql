@@ -0,0 +1 @@
+UPDATE deployments SET upgrade_policy = 'auto' WHERE upgrade_policy = 'manual';
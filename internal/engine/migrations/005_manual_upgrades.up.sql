@@ -0,0 +1,4 @@
+-- Upgrades restart a deployment's containers, so they now wait for the
+-- customer's approval unless the customer opts in. Deployments that were
+-- given the earlier auto default move to manual.
+UPDATE deployments SET upgrade_policy = 'manual' WHERE upgrade_policy = 'auto' OR upgrade_policy IS NULL;
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/gorilla/mux"
+)
+
+// NodeAuditReader reads a node's minion command audit log. *docker.NodePool
+// implements it via the node's minion.
+type NodeAuditReader interface {
+	AuditLog(ctx context.Context, nodeID string, opts minion.AuditLogOptions) (*minion.AuditLog, error)
+}
+
+// nodeAuditLogHandler serves GET /nodes/{id}/audit-log to the node's creator:
+// the commands the node's minion executed or refused after ?since= (RFC3339),
+// oldest first, up to ?limit= (default 500, max 1000). When meta.truncated is
+// set, the next page starts at meta.next_since.
+func nodeAuditLogHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		node, err := cfg.Store.Get(ctx, "nodes", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "node not found")
+			return
+		}
+		ownerID, ok := toInt64(node["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		if cfg.NodeAudit == nil {
+			writeProblem(w, r, ProblemNotConfigured, "node audit logs are not available")
+			return
+		}
+
+		var opts minion.AuditLogOptions
+		if v := r.URL.Query().Get("since"); v != "" {
+			since, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				writeProblem(w, r, ProblemValidationFailed, "since must be an RFC3339 timestamp")
+				return
+			}
+			opts.Since = since
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeProblem(w, r, ProblemValidationFailed, "limit must be between 1 and 1000")
+				return
+			}
+			opts.Limit = n
+		}
+
+		refID := strVal(node["reference_id"])
+		auditLog, err := cfg.NodeAudit.AuditLog(ctx, refID, opts)
+		if err != nil {
+			cfg.Logger.Warn("failed to read node audit log", "node", refID, "error", err)
+			writeProblem(w, r, ProblemUpstreamFailed, "failed to read audit log: "+err.Error())
+			return
+		}
+
+		meta := map[string]any{"truncated": auditLog.Truncated}
+		if n := len(auditLog.Entries); n > 0 {
+			meta["next_since"] = auditLog.Entries[n-1].Time.Format(time.RFC3339Nano)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "node-audit-log",
+				"id":         refID,
+				"attributes": map[string]any{"entries": auditLog.Entries},
+			},
+			"meta": meta,
+		})
+	}
+}
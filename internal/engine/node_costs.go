@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/costs"
+	"github.com/artpar/hoster/internal/core/provider"
+)
+
+// =============================================================================
+// Node Cost Hooks
+// =============================================================================
+
+// nodeCostBeforeCreate checks a cost entry names one of the creator's nodes.
+// Entries without effective_from take effect now.
+func nodeCostBeforeCreate(store *Store) BeforeCreateFunc {
+	return func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+		if err := validateCostNode(ctx, store, authCtx.UserID, strVal(data["node_id"])); err != nil {
+			return err
+		}
+		if _, ok := parseTime(data["effective_from"]); !ok {
+			data["effective_from"] = time.Now().UTC().Format(time.RFC3339)
+		}
+		return nil
+	}
+}
+
+// nodeCostBeforeUpdate checks a cost entry moved to another node still
+// names one of the creator's nodes.
+func nodeCostBeforeUpdate(store *Store) BeforeUpdateFunc {
+	return func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+		nodeRef, ok := data["node_id"]
+		if !ok || strVal(nodeRef) == strVal(existing["node_id"]) {
+			return nil
+		}
+		return validateCostNode(ctx, store, authCtx.UserID, strVal(nodeRef))
+	}
+}
+
+func validateCostNode(ctx context.Context, store *Store, creatorID int, nodeRef string) error {
+	if nodeRef == "" {
+		return fmt.Errorf("node_id is required")
+	}
+	node, err := store.Get(ctx, "nodes", nodeRef)
+	if err != nil {
+		return fmt.Errorf("node %s not found", nodeRef)
+	}
+	if ownerID, _ := toInt64(node["creator_id"]); int(ownerID) != creatorID {
+		return fmt.Errorf("node %s not found", nodeRef)
+	}
+	return nil
+}
+
+// =============================================================================
+// Margin Report
+// =============================================================================
+
+// nodeMonthCost is a node's cost for the report month and how much of it
+// was allocated to deployments.
+type nodeMonthCost struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	MonthlyCents     int64        `json:"monthly_cents"`
+	Source           costs.Source `json:"source,omitempty"`
+	AllocatedCents   int64        `json:"allocated_cents"`
+	UnallocatedCents int64        `json:"unallocated_cents"`
+}
+
+// nodeCost returns a node's cost for the month: the creator's latest entry
+// in effect, or for a provisioned node without one, its size's catalog price.
+func nodeCost(ctx context.Context, store *Store, node map[string]any, entries []costs.Entry, start time.Time) (int64, costs.Source) {
+	if e, ok := costs.CostForMonth(entries, start); ok {
+		return e.MonthlyCents, costs.SourceManual
+	}
+	provRef := strVal(node["provision_id"])
+	if provRef == "" {
+		return 0, ""
+	}
+	prov, err := store.Get(ctx, "cloud_provisions", provRef)
+	if err != nil {
+		return 0, ""
+	}
+	size := provider.LookupSize(strVal(prov["provider"]), strVal(prov["size"]))
+	if size == nil {
+		return 0, ""
+	}
+	return costs.CatalogMonthlyCents(size.PriceHourly), costs.SourceCatalog
+}
+
+// deploymentUsage returns a deployment's reservations and the days of the
+// month [start, end) it spent on its node.
+func deploymentUsage(depl map[string]any, start, end, now time.Time) costs.Usage {
+	from, ok := parseTime(depl["started_at"])
+	if !ok {
+		from, _ = parseTime(depl["created_at"])
+	}
+	var until time.Time
+	switch strVal(depl["status"]) {
+	case "running", "starting", "stopping":
+	case "pending", "scheduled":
+		until = from // Not placed yet
+	default:
+		if until, ok = parseTime(depl["stopped_at"]); !ok {
+			until, _ = parseTime(depl["updated_at"])
+		}
+	}
+	memoryMB, _ := toInt64(depl["resources_memory_mb"])
+	return costs.Usage{
+		DeploymentID: strVal(depl["reference_id"]),
+		CPUCores:     floatVal(depl["resources_cpu_cores"]),
+		MemoryMB:     memoryMB,
+		Days:         costs.ActiveDays(from, until, start, end, now),
+	}
+}
+
+// creatorMargins builds a creator's margin report for the month starting at
+// start. The cost of each of the creator's nodes is allocated to the
+// deployments it ran; the revenue is what the creator's templates earned
+// from invoices paid during the month.
+func creatorMargins(ctx context.Context, store *Store, creatorID int, start, now time.Time) (map[string]any, error) {
+	_, end := costs.MonthBounds(start)
+	month := start.Format(costs.MonthLayout)
+
+	nodes, err := store.List(ctx, "nodes", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 1000})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	entryRows, err := store.List(ctx, "node_costs", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 10000})
+	if err != nil {
+		return nil, fmt.Errorf("list node costs: %w", err)
+	}
+	entries := make(map[string][]costs.Entry)
+	for _, row := range entryRows {
+		from, _ := parseTime(row["effective_from"])
+		cents, _ := toInt64(row["monthly_cents"])
+		ref := strVal(row["node_id"])
+		entries[ref] = append(entries[ref], costs.Entry{MonthlyCents: cents, EffectiveFrom: from})
+	}
+
+	lines := make(map[string]*costs.Line)
+	line := func(deplRef string) *costs.Line {
+		if l, ok := lines[deplRef]; ok {
+			return l
+		}
+		l := &costs.Line{DeploymentID: deplRef}
+		lines[deplRef] = l
+		return l
+	}
+
+	nodeCosts := make([]nodeMonthCost, 0, len(nodes))
+	var unallocated int64
+	for _, node := range nodes {
+		ref := strVal(node["reference_id"])
+		nc := nodeMonthCost{ID: ref, Name: strVal(node["name"])}
+		if created, ok := parseTime(node["created_at"]); !ok || created.Before(end) {
+			nc.MonthlyCents, nc.Source = nodeCost(ctx, store, node, entries[ref], start)
+		}
+		if nc.MonthlyCents > 0 {
+			deployments, err := store.List(ctx, "deployments", []Filter{{Field: "node_id", Value: ref}}, Page{Limit: 10000})
+			if err != nil {
+				return nil, fmt.Errorf("list deployments on %s: %w", ref, err)
+			}
+			usages := make([]costs.Usage, 0, len(deployments))
+			for _, depl := range deployments {
+				usages = append(usages, deploymentUsage(depl, start, end, now))
+			}
+			for deplRef, cents := range costs.Allocate(nc.MonthlyCents, usages) {
+				line(deplRef).CostCents += cents
+				nc.AllocatedCents += cents
+			}
+			nc.UnallocatedCents = nc.MonthlyCents - nc.AllocatedCents
+			unallocated += nc.UnallocatedCents
+		}
+		nodeCosts = append(nodeCosts, nc)
+	}
+
+	earnings, err := store.List(ctx, "creator_earnings", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 100000})
+	if err != nil {
+		return nil, fmt.Errorf("list earnings: %w", err)
+	}
+	earningTemplates := make(map[string]int)
+	for _, row := range earnings {
+		if timeToYearMonth(row["created_at"]) != month {
+			continue
+		}
+		gross, _ := toInt64(row["gross_cents"])
+		net, _ := toInt64(row["net_cents"])
+		deplRef := strVal(row["deployment_id"])
+		l := line(deplRef)
+		l.GrossCents += gross
+		l.NetCents += net
+		tmplID, _ := toInt64(row["template_id"])
+		earningTemplates[deplRef] = int(tmplID)
+	}
+
+	// Name each line's template and customer by reference ID
+	templates := make(map[int]map[string]any)
+	customers := make(map[int]string)
+	templateNames := make(map[string]string)
+	out := make([]costs.Line, 0, len(lines))
+	for deplRef, l := range lines {
+		tmplID, customerID := earningTemplates[deplRef], 0
+		if depl, err := store.Get(ctx, "deployments", deplRef); err == nil {
+			tmplID = toInt(depl["template_id"])
+			customerID = toInt(depl["customer_id"])
+		}
+		if tmplID != 0 {
+			tmpl, ok := templates[tmplID]
+			if !ok {
+				tmpl, _ = store.GetByID(ctx, "templates", tmplID)
+				templates[tmplID] = tmpl
+			}
+			l.TemplateID = strVal(tmpl["reference_id"])
+			templateNames[l.TemplateID] = strVal(tmpl["name"])
+		}
+		if customerID != 0 {
+			ref, ok := customers[customerID]
+			if !ok {
+				ref, _ = store.GetRefIDByIntID("users", customerID)
+				customers[customerID] = ref
+			}
+			l.CustomerID = ref
+		}
+		out = append(out, *l)
+	}
+
+	byTemplate := costs.ByTemplate(out)
+	for i := range byTemplate {
+		byTemplate[i].Name = templateNames[byTemplate[i].ID]
+	}
+	return map[string]any{
+		"month":             month,
+		"currency":          "USD",
+		"totals":            costs.Total(out),
+		"unallocated_cents": unallocated,
+		"by_template":       byTemplate,
+		"by_customer":       costs.ByCustomer(out),
+		"nodes":             nodeCosts,
+	}, nil
+}
+
+// creatorMarginsHandler serves GET /creator/margins?month=YYYY-MM (default
+// the current month): the creator's node costs allocated to deployments,
+// against their template revenue, per template and per customer.
+func creatorMarginsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		now := time.Now().UTC()
+		start, _ := costs.MonthBounds(now)
+		if m := r.URL.Query().Get("month"); m != "" {
+			var err error
+			if start, err = costs.ParseMonth(m); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, err.Error())
+				return
+			}
+		}
+
+		report, err := creatorMargins(r.Context(), cfg.Store, authCtx.UserID, start, now)
+		if err != nil {
+			cfg.Logger.Error("margin report", "error", err)
+			writeProblem(w, r, ProblemInternal, "failed to build margin report")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "creator-margins",
+				"id":         authCtx.ReferenceID,
+				"attributes": report,
+			},
+		})
+	}
+}
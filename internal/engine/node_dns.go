@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"time"
+
+	coredns "github.com/artpar/hoster/internal/core/dns"
+	"github.com/artpar/hoster/internal/core/monitoring"
+)
+
+// =============================================================================
+// Node Wildcard DNS
+// =============================================================================
+//
+// Deployments on a node with a base_domain get <name>.<base_domain>, so a
+// missing or stale *.<base_domain> record breaks every one of them, usually
+// noticed only when a customer's app 404s. The record is checked when the
+// node's base_domain or public address is set, and again by the health
+// checker, which alerts the node's creator when a verified wildcard breaks.
+
+const (
+	// wildcardCheckInterval is how often a node's wildcard DNS is re-checked.
+	wildcardCheckInterval = 30 * time.Minute
+	// wildcardLookupTimeout bounds the lookups of one check.
+	wildcardLookupTimeout = 5 * time.Second
+)
+
+// nodeWildcardDNS checks a node row's wildcard DNS and returns the fields to
+// store. A node without a base_domain has none of them.
+func nodeWildcardDNS(ctx context.Context, node map[string]any) map[string]any {
+	baseDomain := strVal(node["base_domain"])
+	if baseDomain == "" {
+		return map[string]any{"wildcard_dns_status": nil, "wildcard_dns_error": nil, "wildcard_dns_checked_at": nil}
+	}
+	result := checkWildcardDNS(ctx, baseDomain, nodeTopology(node, nil).PublicAddress)
+	update := map[string]any{
+		"wildcard_dns_status":     string(result.Status),
+		"wildcard_dns_error":      nil,
+		"wildcard_dns_checked_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if result.Error != "" {
+		update["wildcard_dns_error"] = result.Error
+	}
+	return update
+}
+
+// checkWildcardDNS resolves a random name under baseDomain and compares it
+// with the node's public address, resolving that too when it is a hostname.
+func checkWildcardDNS(ctx context.Context, baseDomain, publicAddr string) coredns.WildcardResult {
+	ctx, cancel := context.WithTimeout(ctx, wildcardLookupTimeout)
+	defer cancel()
+
+	var expected []string
+	if ip := net.ParseIP(publicAddr); ip != nil {
+		expected = []string{ip.String()}
+	} else if publicAddr != "" {
+		addrs, _ := net.DefaultResolver.LookupIPAddr(ctx, publicAddr)
+		for _, a := range addrs {
+			expected = append(expected, a.IP.String())
+		}
+	}
+
+	input := coredns.VerificationInput{Hostname: coredns.WildcardProbeName(baseDomain, randomString(12))}
+	if len(expected) > 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, input.Hostname)
+		if err != nil {
+			input.LookupError = err.Error()
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) {
+				input.LookupError = dnsErr.Err
+			}
+		}
+		for _, a := range addrs {
+			input.ARecords = append(input.ARecords, a.IP)
+		}
+	}
+	return coredns.VerifyWildcard(input, expected)
+}
+
+// verifyNodeWildcardOnCreate checks a new node's wildcard DNS, recording the
+// result in data.
+func verifyNodeWildcardOnCreate(ctx context.Context, data map[string]any) {
+	if strVal(data["base_domain"]) == "" {
+		return
+	}
+	maps.Copy(data, nodeWildcardDNS(ctx, data))
+}
+
+// verifyNodeWildcardOnUpdate re-checks a node's wildcard DNS when an update
+// changes its base_domain or public address, recording the result in data.
+func verifyNodeWildcardOnUpdate(ctx context.Context, existing, data map[string]any) {
+	_, baseChanged := data["base_domain"]
+	_, addrChanged := data["public_address"]
+	if !baseChanged && !addrChanged {
+		return
+	}
+	node := maps.Clone(existing)
+	maps.Copy(node, data)
+	maps.Copy(data, nodeWildcardDNS(ctx, node))
+}
+
+// wildcardCheckDue reports whether a node's wildcard DNS should be
+// re-checked: it has a base_domain and was not checked within the interval.
+func wildcardCheckDue(node map[string]any, now time.Time) bool {
+	if strVal(node["base_domain"]) == "" {
+		return false
+	}
+	checked, ok := parseTime(node["wildcard_dns_checked_at"])
+	return !ok || now.Sub(checked) >= wildcardCheckInterval
+}
+
+// checkWildcard re-checks a node's wildcard DNS and records the result,
+// alerting the node's creator when a verified wildcard no longer resolves
+// to the node.
+func (h *HealthChecker) checkWildcard(ctx context.Context, node map[string]any) {
+	refID := strVal(node["reference_id"])
+	logger := h.logger.With("node", refID)
+
+	update := nodeWildcardDNS(ctx, node)
+	previous := coredns.WildcardStatus(strVal(node["wildcard_dns_status"]))
+	current := coredns.WildcardStatus(strVal(update["wildcard_dns_status"]))
+	if coredns.WildcardRegressed(previous, current) {
+		msg := strVal(update["wildcard_dns_error"])
+		logger.Warn("node wildcard DNS regressed", "base_domain", strVal(node["base_domain"]), "status", current, "error", msg)
+		if h.notifier != nil {
+			h.notifier.NotifyNodeAlert(node, monitoring.NodeAlert{
+				Kind:     monitoring.NodeAlertWildcardDNS,
+				Severity: monitoring.SeverityCritical,
+				Subject:  strVal(node["base_domain"]),
+				Message:  msg + "; deployments on this node are unreachable at their domains",
+			})
+		}
+	}
+	for k, v := range update {
+		node[k] = v
+	}
+	if _, err := h.store.Update(ctx, "nodes", refID, update); err != nil {
+		logger.Error("failed to record node wildcard DNS", "error", err)
+	}
+}
@@ -0,0 +1,500 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/drain"
+	"github.com/artpar/hoster/internal/core/relocation"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Node Drain Storage
+// =============================================================================
+//
+// A node drain moves every deployment off a node so its owner can service or
+// retire it. Starting a drain marks the node unschedulable, so the scheduler
+// places nothing new on it, and snapshots the deployments on it as drain
+// items. The DeploymentMigrator then moves them one at a time: each item
+// becomes a deployment migration to the best other node the deployment may
+// be placed on, which stops it, moves its volumes, starts it on the target
+// and switches its routes. The next item starts once that migration has
+// finished. The node stays unschedulable after the drain until its owner
+// cancels or clears it.
+
+// NodeDrain is a request to move every deployment off a node.
+type NodeDrain struct {
+	ID           int64          `db:"id"`
+	ReferenceID  string         `db:"reference_id"`
+	NodeID       string         `db:"node_id"`
+	RequestedBy  int64          `db:"requested_by"`
+	Status       string         `db:"status"` // drain.Status
+	ErrorMessage string         `db:"error_message"`
+	CreatedAt    string         `db:"created_at"`
+	UpdatedAt    string         `db:"updated_at"`
+	CompletedAt  sql.NullString `db:"completed_at"`
+}
+
+// NodeDrainItem is one deployment a drain moves.
+type NodeDrainItem struct {
+	ID           int64  `db:"id"`
+	DrainID      int64  `db:"drain_id"`
+	DeploymentID string `db:"deployment_id"`
+	Status       string `db:"status"` // drain.ItemStatus
+	TargetNodeID string `db:"target_node_id"`
+	MigrationID  string `db:"migration_id"` // Deployment migration moving it
+	ErrorMessage string `db:"error_message"`
+	UpdatedAt    string `db:"updated_at"`
+}
+
+const nodeDrainColumns = `id, reference_id, node_id, requested_by, status, error_message, created_at, updated_at, completed_at`
+
+const nodeDrainItemColumns = `id, drain_id, deployment_id, status, target_node_id, migration_id, error_message, updated_at`
+
+// CreateNodeDrain inserts a running drain with a pending item per deployment
+// and fills in its IDs.
+func (s *Store) CreateNodeDrain(ctx context.Context, d *NodeDrain, deploymentIDs []string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	d.ReferenceID = "drain_" + uuid.New().String()[:8]
+	d.Status = string(drain.StatusRunning)
+	d.CreatedAt, d.UpdatedAt = now, now
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("create node drain: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExecContext(ctx,
+		`INSERT INTO node_drains (reference_id, node_id, requested_by, status, created_at, updated_at)
+		VALUES (:reference_id, :node_id, :requested_by, :status, :created_at, :updated_at)`, d)
+	if err != nil {
+		return fmt.Errorf("create node drain: %w", err)
+	}
+	d.ID, _ = res.LastInsertId()
+	for _, deplID := range deploymentIDs {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO node_drain_items (drain_id, deployment_id, status, updated_at) VALUES (?, ?, ?, ?)`,
+			d.ID, deplID, drain.ItemPending, now); err != nil {
+			return fmt.Errorf("create node drain item: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveNodeDrain writes a drain's status.
+func (s *Store) SaveNodeDrain(ctx context.Context, d *NodeDrain) error {
+	d.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE node_drains SET status = :status, error_message = :error_message,
+			updated_at = :updated_at, completed_at = :completed_at
+		WHERE id = :id`, d)
+	if err != nil {
+		return fmt.Errorf("save node drain: %w", err)
+	}
+	return nil
+}
+
+// SaveNodeDrainItem writes an item's status and migration.
+func (s *Store) SaveNodeDrainItem(ctx context.Context, it *NodeDrainItem) error {
+	it.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE node_drain_items SET status = :status, target_node_id = :target_node_id,
+			migration_id = :migration_id, error_message = :error_message, updated_at = :updated_at
+		WHERE id = :id`, it)
+	if err != nil {
+		return fmt.Errorf("save node drain item: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectNodeDrains(ctx context.Context, query string, args ...any) ([]*NodeDrain, error) {
+	var out []*NodeDrain
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+nodeDrainColumns+` FROM node_drains `+query, args...); err != nil {
+		return nil, fmt.Errorf("query node drains: %w", err)
+	}
+	return out, nil
+}
+
+func (s *Store) selectNodeDrainItems(ctx context.Context, query string, args ...any) ([]*NodeDrainItem, error) {
+	var out []*NodeDrainItem
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+nodeDrainItemColumns+` FROM node_drain_items `+query, args...); err != nil {
+		return nil, fmt.Errorf("query node drain items: %w", err)
+	}
+	return out, nil
+}
+
+// LatestNodeDrain returns a node's most recent drain, or nil.
+func (s *Store) LatestNodeDrain(ctx context.Context, nodeID string) (*NodeDrain, error) {
+	ds, err := s.selectNodeDrains(ctx, `WHERE node_id = ? ORDER BY id DESC LIMIT 1`, nodeID)
+	if err != nil || len(ds) == 0 {
+		return nil, err
+	}
+	return ds[0], nil
+}
+
+// ListRunningNodeDrains returns the drains still moving deployments, oldest
+// first.
+func (s *Store) ListRunningNodeDrains(ctx context.Context) ([]*NodeDrain, error) {
+	return s.selectNodeDrains(ctx, `WHERE status = ? ORDER BY id`, drain.StatusRunning)
+}
+
+// ListNodeDrainItems returns a drain's items in the order they are moved.
+func (s *Store) ListNodeDrainItems(ctx context.Context, drainID int64) ([]*NodeDrainItem, error) {
+	return s.selectNodeDrainItems(ctx, `WHERE drain_id = ? ORDER BY id`, drainID)
+}
+
+// ListMigratingNodeDrainItems returns the items whose migration is running,
+// cancelled drains included.
+func (s *Store) ListMigratingNodeDrainItems(ctx context.Context) ([]*NodeDrainItem, error) {
+	return s.selectNodeDrainItems(ctx, `WHERE status = ? ORDER BY id`, drain.ItemMigrating)
+}
+
+// SkipPendingNodeDrainItems marks the items of a drain that have not started
+// as skipped.
+func (s *Store) SkipPendingNodeDrainItems(ctx context.Context, drainID int64, reason string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE node_drain_items SET status = ?, error_message = ?, updated_at = ? WHERE drain_id = ? AND status = ?`,
+		drain.ItemSkipped, reason, time.Now().UTC().Format(time.RFC3339), drainID, drain.ItemPending)
+	if err != nil {
+		return fmt.Errorf("skip node drain items: %w", err)
+	}
+	return nil
+}
+
+// nodeDrainJSONAPI renders a drain and its items as a JSON:API resource
+// object. migrations holds the items' deployment migrations by reference ID.
+func nodeDrainJSONAPI(d *NodeDrain, items []*NodeDrainItem, migrations map[string]*DeploymentMigration) map[string]any {
+	progress := make([]drain.Item, 0, len(items))
+	rendered := make([]map[string]any, 0, len(items))
+	counts := map[string]int{}
+	for _, it := range items {
+		p := drain.Item{Status: drain.ItemStatus(it.Status)}
+		out := map[string]any{
+			"deployment_id":  it.DeploymentID,
+			"status":         it.Status,
+			"target_node_id": it.TargetNodeID,
+			"migration_id":   it.MigrationID,
+			"error_message":  it.ErrorMessage,
+			"updated_at":     it.UpdatedAt,
+		}
+		if m := migrations[it.MigrationID]; m != nil {
+			p.MigrationPercent = relocation.Progress(relocation.Status(m.Status), m.TransferPercent)
+			out["migration_status"] = m.Status
+			out["migration_progress_percent"] = p.MigrationPercent
+		}
+		progress = append(progress, p)
+		rendered = append(rendered, out)
+		counts[it.Status]++
+	}
+	return map[string]any{
+		"type": "node-drains",
+		"id":   d.ReferenceID,
+		"attributes": map[string]any{
+			"node_id":          d.NodeID,
+			"status":           d.Status,
+			"progress_percent": drain.Progress(progress),
+			"counts":           counts,
+			"deployments":      rendered,
+			"error_message":    d.ErrorMessage,
+			"created_at":       d.CreatedAt,
+			"updated_at":       d.UpdatedAt,
+			"completed_at":     d.CompletedAt.String,
+		},
+	}
+}
+
+// renderNodeDrain loads a drain's items and their migrations and renders it.
+func renderNodeDrain(ctx context.Context, store *Store, d *NodeDrain) (map[string]any, error) {
+	items, err := store.ListNodeDrainItems(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	migrations := make(map[string]*DeploymentMigration)
+	for _, it := range items {
+		if it.MigrationID == "" {
+			continue
+		}
+		ms, err := store.selectDeploymentMigrations(ctx, `WHERE reference_id = ?`, it.MigrationID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ms) > 0 {
+			migrations[it.MigrationID] = ms[0]
+		}
+	}
+	return nodeDrainJSONAPI(d, items, migrations), nil
+}
+
+// =============================================================================
+// Node Drain Handler
+// =============================================================================
+
+// nodeDrainHandler handles /nodes/{id}/drain for the node's owner. POST
+// starts a drain, GET reports the latest drain's progress per deployment and
+// DELETE cancels a running drain and makes the node schedulable again. A
+// deployment already being moved when the drain is cancelled finishes its
+// migration.
+func nodeDrainHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		node, err := cfg.Store.Get(ctx, "nodes", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "node not found")
+			return
+		}
+
+		ownerID, ok := toInt64(node["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		nodeRef := strVal(node["reference_id"])
+
+		latest, err := cfg.Store.LatestNodeDrain(ctx, nodeRef)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load node drain")
+			return
+		}
+		running := latest != nil && drain.Status(latest.Status).Active()
+
+		switch r.Method {
+		case http.MethodGet:
+			if latest == nil {
+				writeProblem(w, r, ProblemNotFound, "node has not been drained")
+				return
+			}
+
+		case http.MethodPost:
+			if running {
+				writeProblem(w, r, ProblemOperationInProgress, "node is already being drained")
+				return
+			}
+			if status := strVal(node["status"]); status != string(domain.NodeStatusOnline) {
+				writeProblem(w, r, ProblemInvalidState, "cannot drain node in state: "+status)
+				return
+			}
+			rows, err := cfg.Store.RawQuery(ctx,
+				`SELECT reference_id FROM deployments WHERE node_id = ? AND status NOT IN ('deleting', 'deleted') ORDER BY id`, nodeRef)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list node deployments")
+				return
+			}
+			deplIDs := make([]string, 0, len(rows))
+			for _, row := range rows {
+				deplIDs = append(deplIDs, strVal(row["reference_id"]))
+			}
+			if _, err := cfg.Store.Update(ctx, "nodes", nodeRef, map[string]any{"unschedulable": true}); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to mark node unschedulable")
+				return
+			}
+			latest = &NodeDrain{NodeID: nodeRef, RequestedBy: int64(authCtx.UserID)}
+			if err := cfg.Store.CreateNodeDrain(ctx, latest, deplIDs); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to create node drain")
+				return
+			}
+
+		case http.MethodDelete:
+			if !running && !boolVal(node["unschedulable"]) {
+				writeProblem(w, r, ProblemInvalidState, "node is not drained")
+				return
+			}
+			if running {
+				if err := cfg.Store.SkipPendingNodeDrainItems(ctx, latest.ID, "drain cancelled"); err != nil {
+					writeProblem(w, r, ProblemInternal, err.Error())
+					return
+				}
+				latest.Status = string(drain.StatusCancelled)
+				latest.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+				if err := cfg.Store.SaveNodeDrain(ctx, latest); err != nil {
+					writeProblem(w, r, ProblemInternal, err.Error())
+					return
+				}
+			}
+			if _, err := cfg.Store.Update(ctx, "nodes", nodeRef, map[string]any{"unschedulable": false}); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to mark node schedulable")
+				return
+			}
+			if latest == nil {
+				writeJSON(w, http.StatusOK, map[string]any{"data": nil})
+				return
+			}
+		}
+
+		data, err := renderNodeDrain(ctx, cfg.Store, latest)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load node drain")
+			return
+		}
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, map[string]any{"data": data})
+	}
+}
+
+// =============================================================================
+// Node Drain Worker
+// =============================================================================
+
+// advanceDrains moves running drains along, from the DeploymentMigrator's
+// loop: items whose migration has finished are settled, and each drain with
+// nothing in flight hands its next deployment to a new migration. A drain
+// whose items are all done finishes.
+func (dm *DeploymentMigrator) advanceDrains(ctx context.Context) {
+	migrating, err := dm.store.ListMigratingNodeDrainItems(ctx)
+	if err != nil {
+		dm.logger.Error("failed to list migrating drain items", "error", err)
+		return
+	}
+	for _, it := range migrating {
+		dm.settleDrainItem(ctx, it)
+	}
+
+	drains, err := dm.store.ListRunningNodeDrains(ctx)
+	if err != nil {
+		dm.logger.Error("failed to list node drains", "error", err)
+		return
+	}
+	for _, d := range drains {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := dm.advanceDrain(ctx, d); err != nil {
+			dm.logger.Error("failed to advance node drain", "drain", d.ReferenceID, "node", d.NodeID, "error", err)
+		}
+	}
+}
+
+// settleDrainItem records the outcome of a migrating item's migration once
+// it has finished.
+func (dm *DeploymentMigrator) settleDrainItem(ctx context.Context, it *NodeDrainItem) {
+	ms, err := dm.store.selectDeploymentMigrations(ctx, `WHERE reference_id = ?`, it.MigrationID)
+	if err != nil {
+		dm.logger.Error("failed to load drain migration", "migration", it.MigrationID, "error", err)
+		return
+	}
+	if len(ms) == 0 {
+		it.Status, it.ErrorMessage = string(drain.ItemFailed), "migration "+it.MigrationID+" not found"
+	} else {
+		status, done := drain.ItemForMigration(relocation.Status(ms[0].Status))
+		if !done {
+			return
+		}
+		it.Status, it.ErrorMessage = string(status), ms[0].ErrorMessage
+	}
+	if err := dm.store.SaveNodeDrainItem(ctx, it); err != nil {
+		dm.logger.Error("failed to save drain item", "deployment", it.DeploymentID, "error", err)
+	}
+}
+
+// advanceDrain starts the next migration of a drain, skipping or failing
+// the items that cannot be moved, or finishes the drain.
+func (dm *DeploymentMigrator) advanceDrain(ctx context.Context, d *NodeDrain) error {
+	items, err := dm.store.ListNodeDrainItems(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		switch drain.ItemStatus(it.Status) {
+		case drain.ItemMigrating:
+			// One deployment at a time
+			return nil
+		case drain.ItemPending:
+			started, err := dm.startDrainItem(ctx, d, it)
+			if err != nil || started {
+				return err
+			}
+			if !drain.ItemStatus(it.Status).Done() {
+				// Waiting for the deployment to settle
+				return nil
+			}
+		}
+	}
+
+	statuses := make([]drain.ItemStatus, 0, len(items))
+	for _, it := range items {
+		statuses = append(statuses, drain.ItemStatus(it.Status))
+	}
+	d.Status = string(drain.Outcome(statuses))
+	if d.Status == string(drain.StatusFailed) {
+		d.ErrorMessage = "some deployments could not be moved off the node"
+	}
+	d.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	dm.logger.Info("node drain finished", "drain", d.ReferenceID, "node", d.NodeID, "status", d.Status)
+	return dm.store.SaveNodeDrain(ctx, d)
+}
+
+// startDrainItem hands a pending item's deployment to a new migration.
+// started reports whether it did; otherwise the item was settled as skipped
+// or failed, or is left pending until the deployment can be moved.
+func (dm *DeploymentMigrator) startDrainItem(ctx context.Context, d *NodeDrain, it *NodeDrainItem) (started bool, err error) {
+	depl, err := dm.store.Get(ctx, "deployments", it.DeploymentID)
+	if errors.Is(err, ErrNotFound) {
+		return false, dm.settlePendingItem(ctx, it, drain.ItemSkipped, "deployment was deleted")
+	}
+	if err != nil {
+		return false, err
+	}
+	if strVal(depl["node_id"]) != d.NodeID {
+		return false, dm.settlePendingItem(ctx, it, drain.ItemSkipped, "deployment is no longer on the node")
+	}
+	switch drain.Classify(strVal(depl["status"])) {
+	case drain.Skip:
+		return false, dm.settlePendingItem(ctx, it, drain.ItemSkipped, "deployment is "+strVal(depl["status"]))
+	case drain.Wait:
+		return false, nil
+	}
+
+	// A move the owner started, or a volume migration, goes first
+	if active, err := dm.store.HasActiveDeploymentMigration(ctx, it.DeploymentID); err != nil || active {
+		return false, err
+	}
+	if active, err := dm.store.HasActiveVolumeMigration(ctx, it.DeploymentID); err != nil || active {
+		return false, err
+	}
+
+	target, err := migrationTarget(ctx, dm.store, depl, "", "")
+	if err != nil {
+		return false, dm.settlePendingItem(ctx, it, drain.ItemFailed, err.Error())
+	}
+	m := &DeploymentMigration{
+		DeploymentID: it.DeploymentID,
+		SourceNodeID: d.NodeID,
+		TargetNodeID: target,
+		RequestedBy:  d.RequestedBy,
+	}
+	if err := dm.store.CreateDeploymentMigration(ctx, m); err != nil {
+		return false, err
+	}
+	it.Status, it.TargetNodeID, it.MigrationID = string(drain.ItemMigrating), target, m.ReferenceID
+	if err := dm.store.SaveNodeDrainItem(ctx, it); err != nil {
+		return false, err
+	}
+	recordMigrationEvent(ctx, dm.store, depl, domain.EventMigrationStep,
+		fmt.Sprintf("Node %s is draining: migration %s to node %s requested", d.NodeID, m.ReferenceID, target))
+	dm.logger.Info("node drain moving deployment", "drain", d.ReferenceID, "deployment", it.DeploymentID,
+		"migration", m.ReferenceID, "target_node", target)
+	return true, nil
+}
+
+func (dm *DeploymentMigrator) settlePendingItem(ctx context.Context, it *NodeDrainItem, status drain.ItemStatus, message string) error {
+	it.Status, it.ErrorMessage = string(status), message
+	return dm.store.SaveNodeDrainItem(ctx, it)
+}
@@ -0,0 +1,677 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/crypto"
+	"github.com/artpar/hoster/internal/core/nodekey"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Node Key Storage
+// =============================================================================
+//
+// node_keys holds the SSH keys hoster issues to nodes, which act as the
+// nodes' access tokens. The private key lives in an ssh_keys row that the
+// node points at while the key is active; the public key is kept here so the
+// key can be removed from the node after its private key is gone. Keys a
+// creator uploads themselves are never tracked, rotated or removed.
+// node_key_policies holds each creator's rotation policy.
+
+// NodeKey is an SSH key hoster issued to a node.
+type NodeKey struct {
+	ID          int64          `db:"id"`
+	ReferenceID string         `db:"reference_id"`
+	NodeID      string         `db:"node_id"`
+	CreatorID   int            `db:"creator_id"`
+	SSHKeyID    int64          `db:"ssh_key_id"` // 0 once revoked
+	PublicKey   string         `db:"public_key"`
+	Fingerprint string         `db:"fingerprint"`
+	Status      string         `db:"status"`
+	CreatedAt   string         `db:"created_at"`
+	RetireAt    sql.NullString `db:"retire_at"`    // End of a retiring key's dual-valid window
+	LastUsedAt  sql.NullString `db:"last_used_at"` // Last connection made with the key
+	RevokedAt   sql.NullString `db:"revoked_at"`
+	RemovedAt   sql.NullString `db:"removed_at"` // When it left the node's authorized_keys
+	RemindedAt  sql.NullString `db:"reminded_at"`
+}
+
+const nodeKeyColumns = `id, reference_id, node_id, creator_id, ssh_key_id, public_key, fingerprint, status,
+	created_at, retire_at, last_used_at, revoked_at, removed_at, reminded_at`
+
+// createNodeKey inserts an active node key and fills in its ID.
+func (s *Store) createNodeKey(ctx context.Context, k *NodeKey) error {
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO node_keys (reference_id, node_id, creator_id, ssh_key_id, public_key, fingerprint, status, created_at)
+		VALUES (:reference_id, :node_id, :creator_id, :ssh_key_id, :public_key, :fingerprint, :status, :created_at)`, k)
+	if err != nil {
+		return fmt.Errorf("create node key: %w", err)
+	}
+	k.ID, _ = res.LastInsertId()
+	return nil
+}
+
+func (s *Store) selectNodeKeys(ctx context.Context, query string, args ...any) ([]*NodeKey, error) {
+	var out []*NodeKey
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+nodeKeyColumns+` FROM node_keys `+query, args...); err != nil {
+		return nil, fmt.Errorf("list node keys: %w", err)
+	}
+	return out, nil
+}
+
+// ListNodeKeys returns a node's keys, newest first, revoked ones included.
+func (s *Store) ListNodeKeys(ctx context.Context, nodeID string) ([]*NodeKey, error) {
+	return s.selectNodeKeys(ctx, `WHERE node_id = ? ORDER BY id DESC`, nodeID)
+}
+
+// GetNodeKey returns one of a node's keys, or nil if it has no such key.
+func (s *Store) GetNodeKey(ctx context.Context, nodeID, referenceID string) (*NodeKey, error) {
+	keys, err := s.selectNodeKeys(ctx, `WHERE node_id = ? AND reference_id = ?`, nodeID, referenceID)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// activeNodeKey returns the key hoster connects to a node with, or nil when
+// the node uses a key its creator uploaded.
+func (s *Store) activeNodeKey(ctx context.Context, nodeID string) (*NodeKey, error) {
+	keys, err := s.selectNodeKeys(ctx, `WHERE node_id = ? AND status = ? ORDER BY id DESC LIMIT 1`, nodeID, nodekey.StatusActive)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// retireNodeKey starts a superseded key's dual-valid window.
+func (s *Store) retireNodeKey(ctx context.Context, id int64, retireAt time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE node_keys SET status = ?, retire_at = ? WHERE id = ?`,
+		nodekey.StatusRetiring, retireAt.UTC().Format(time.RFC3339), id); err != nil {
+		return fmt.Errorf("retire node key: %w", err)
+	}
+	return nil
+}
+
+// revokeNodeKey marks a key revoked and forgets its private key's row.
+func (s *Store) revokeNodeKey(ctx context.Context, id int64, now time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE node_keys SET status = ?, revoked_at = ?, ssh_key_id = 0 WHERE id = ?`,
+		nodekey.StatusRevoked, now.UTC().Format(time.RFC3339), id); err != nil {
+		return fmt.Errorf("revoke node key: %w", err)
+	}
+	return nil
+}
+
+// markNodeKeyRemoved records that a revoked key left the node.
+func (s *Store) markNodeKeyRemoved(ctx context.Context, id int64, now time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE node_keys SET removed_at = ? WHERE id = ?`,
+		now.UTC().Format(time.RFC3339), id); err != nil {
+		return fmt.Errorf("mark node key removed: %w", err)
+	}
+	return nil
+}
+
+// markNodeKeyReminded records that the creator was reminded to rotate a key.
+func (s *Store) markNodeKeyReminded(ctx context.Context, id int64, now time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE node_keys SET reminded_at = ? WHERE id = ?`,
+		now.UTC().Format(time.RFC3339), id); err != nil {
+		return fmt.Errorf("mark node key reminded: %w", err)
+	}
+	return nil
+}
+
+// touchNodeKey records a connection made with the node key whose private key
+// is the ssh_keys row sshKeyID. Keys hoster didn't issue match nothing.
+func (s *Store) touchNodeKey(ctx context.Context, sshKeyID int) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE node_keys SET last_used_at = ? WHERE ssh_key_id = ? AND status != ?`,
+		time.Now().UTC().Format(time.RFC3339), sshKeyID, nodekey.StatusRevoked); err != nil {
+		return fmt.Errorf("touch node key: %w", err)
+	}
+	return nil
+}
+
+// NodeKeyPolicy is a creator's node key rotation policy.
+type NodeKeyPolicy struct {
+	UserID      int    `db:"user_id"`
+	MaxAgeDays  int    `db:"max_age_days"`
+	AutoRotate  bool   `db:"auto_rotate"`
+	WindowHours int    `db:"window_hours"`
+	UpdatedAt   string `db:"updated_at"`
+}
+
+// Policy returns the policy in core form.
+func (p *NodeKeyPolicy) Policy() nodekey.Policy {
+	return nodekey.Policy{MaxAgeDays: p.MaxAgeDays, AutoRotate: p.AutoRotate, WindowHours: p.WindowHours}
+}
+
+// GetNodeKeyPolicy returns a creator's rotation policy; creators without one
+// get the zero policy, which never rotates.
+func (s *Store) GetNodeKeyPolicy(ctx context.Context, userID int) (*NodeKeyPolicy, error) {
+	p := NodeKeyPolicy{UserID: userID}
+	err := s.db.GetContext(ctx, &p,
+		`SELECT user_id, max_age_days, auto_rotate, window_hours, updated_at FROM node_key_policies WHERE user_id = ?`, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("get node key policy: %w", err)
+	}
+	return &p, nil
+}
+
+// SetNodeKeyPolicy sets a creator's rotation policy.
+func (s *Store) SetNodeKeyPolicy(ctx context.Context, userID int, p nodekey.Policy) error {
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO node_key_policies (user_id, max_age_days, auto_rotate, window_hours, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET max_age_days = excluded.max_age_days, auto_rotate = excluded.auto_rotate,
+			window_hours = excluded.window_hours, updated_at = excluded.updated_at`,
+		userID, p.MaxAgeDays, p.AutoRotate, p.WindowHours, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("set node key policy: %w", err)
+	}
+	return nil
+}
+
+// ClearNodeKeyPolicy removes a creator's rotation policy.
+func (s *Store) ClearNodeKeyPolicy(ctx context.Context, userID int) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM node_key_policies WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("clear node key policy: %w", err)
+	}
+	return nil
+}
+
+func nodeKeyJSONAPI(k *NodeKey) map[string]any {
+	return map[string]any{
+		"type": "node-keys",
+		"id":   k.ReferenceID,
+		"attributes": map[string]any{
+			"node_id":      k.NodeID,
+			"public_key":   k.PublicKey,
+			"fingerprint":  k.Fingerprint,
+			"status":       k.Status,
+			"created_at":   k.CreatedAt,
+			"retire_at":    k.RetireAt.String,
+			"last_used_at": k.LastUsedAt.String,
+			"revoked_at":   k.RevokedAt.String,
+			"removed_at":   k.RemovedAt.String,
+		},
+	}
+}
+
+func nodeKeyPolicyJSONAPI(p *NodeKeyPolicy) map[string]any {
+	return map[string]any{
+		"type": "node-key-policies",
+		"id":   "current",
+		"attributes": map[string]any{
+			"max_age_days": p.MaxAgeDays,
+			"auto_rotate":  p.AutoRotate,
+			"window_hours": p.WindowHours,
+			"window":       p.Policy().Window().String(),
+		},
+	}
+}
+
+// =============================================================================
+// Node Key Manager
+// =============================================================================
+
+// NodeKeyAgent installs and removes keys on nodes. *docker.NodePool
+// implements it over the node's SSH connection.
+type NodeKeyAgent interface {
+	AuthorizeKey(ctx context.Context, nodeID, publicKey, keyRefID string) error
+	RemoveKey(ctx context.Context, nodeID, publicKey string) error
+	CheckKey(ctx context.Context, nodeID string, privateKey []byte) error
+	// RemoveClient closes the node's pooled connection, ending every session
+	// on it; the next command reconnects with the node's active key.
+	RemoveClient(nodeID string) error
+}
+
+// NodeKeyManager issues, rotates and revokes node keys. Its worker ends the
+// dual-valid window of retiring keys, retries removing revoked keys from
+// nodes that were unreachable, and applies creators' rotation policies:
+// due keys are rotated when the policy says so, otherwise the creator is
+// reminded once per key.
+type NodeKeyManager struct {
+	store    *Store
+	agent    NodeKeyAgent
+	notifier *Notifier
+	interval time.Duration
+	logger   *slog.Logger
+	mu       sync.Mutex // Serializes key changes
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewNodeKeyManager creates a manager.
+func NewNodeKeyManager(store *Store, agent NodeKeyAgent, interval time.Duration, logger *slog.Logger) *NodeKeyManager {
+	if interval == 0 {
+		interval = 15 * time.Minute
+	}
+	return &NodeKeyManager{
+		store:    store,
+		agent:    agent,
+		interval: interval,
+		logger:   logger.With("component", "node_keys"),
+	}
+}
+
+// SetNotifier enables rotation reminders.
+func (m *NodeKeyManager) SetNotifier(n *Notifier) {
+	m.notifier = n
+}
+
+// Rotate issues a new key to a node and switches the node to it. The node
+// must accept the new key before anything is stored. A previous key hoster
+// issued stays valid for window, then is removed; a zero window revokes it
+// at once. The first rotation of a node issues its first key and leaves the
+// creator's own key alone.
+func (m *NodeKeyManager) Rotate(ctx context.Context, node map[string]any, window time.Duration) (*NodeKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodeRef := strVal(node["reference_id"])
+	previous, err := m.store.activeNodeKey(ctx, nodeRef)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, publicKey, err := crypto.GenerateSSHKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := crypto.GetSSHPublicKeyFingerprint(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	key := &NodeKey{
+		ReferenceID: "nkey_" + uuid.New().String()[:8],
+		NodeID:      nodeRef,
+		CreatorID:   toInt(node["creator_id"]),
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+		Status:      string(nodekey.StatusActive),
+		CreatedAt:   now.UTC().Format(time.RFC3339),
+	}
+
+	if err := m.agent.AuthorizeKey(ctx, nodeRef, publicKey, key.ReferenceID); err != nil {
+		return nil, fmt.Errorf("install key on node: %w", err)
+	}
+	// Undo the installation if the switch doesn't complete
+	abandon := func() {
+		if err := m.agent.RemoveKey(context.WithoutCancel(ctx), nodeRef, publicKey); err != nil {
+			m.logger.Warn("failed to remove abandoned node key", "node", nodeRef, "key", key.ReferenceID, "error", err)
+		}
+	}
+	if err := m.agent.CheckKey(ctx, nodeRef, privateKey); err != nil {
+		abandon()
+		return nil, fmt.Errorf("node does not accept the new key: %w", err)
+	}
+
+	sshKey, err := m.store.Create(ctx, "ssh_keys", map[string]any{
+		"creator_id":  key.CreatorID,
+		"name":        "node-" + strVal(node["name"]) + "-" + key.ReferenceID,
+		"private_key": string(privateKey),
+		"public_key":  publicKey,
+		"fingerprint": fingerprint,
+	})
+	if err != nil {
+		abandon()
+		return nil, fmt.Errorf("store node key: %w", err)
+	}
+	key.SSHKeyID, _ = toInt64(sshKey["id"])
+	if _, err := m.store.Update(ctx, "nodes", nodeRef, map[string]any{"ssh_key_id": key.SSHKeyID}); err != nil {
+		m.store.Delete(ctx, "ssh_keys", strVal(sshKey["reference_id"]))
+		abandon()
+		return nil, fmt.Errorf("switch node to new key: %w", err)
+	}
+	if err := m.store.createNodeKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		if window == 0 {
+			err = m.revoke(ctx, previous, now)
+		} else {
+			err = m.store.retireNodeKey(ctx, previous.ID, now.Add(window))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.logger.Info("rotated node key", "node", nodeRef, "key", key.ReferenceID, "window", window)
+	return key, nil
+}
+
+// Revoke revokes a node key at once: the key is removed from the node, its
+// private key is deleted, and the node's pooled connection is closed so no
+// session made with it survives. A node that can't be reached has the key
+// removed by the worker once it can be.
+func (m *NodeKeyManager) Revoke(ctx context.Context, key *NodeKey) error {
+	if key.Status == string(nodekey.StatusActive) {
+		return nodekey.ErrActiveKey
+	}
+	if key.Status == string(nodekey.StatusRevoked) {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revoke(ctx, key, time.Now())
+}
+
+func (m *NodeKeyManager) revoke(ctx context.Context, key *NodeKey, now time.Time) error {
+	if err := m.store.revokeNodeKey(ctx, key.ID, now); err != nil {
+		return err
+	}
+	if key.SSHKeyID > 0 {
+		if row, err := m.store.GetByID(ctx, "ssh_keys", int(key.SSHKeyID)); err == nil {
+			if err := m.store.Delete(ctx, "ssh_keys", strVal(row["reference_id"])); err != nil {
+				m.logger.Error("failed to delete revoked node key", "key", key.ReferenceID, "error", err)
+			}
+		}
+	}
+	m.removeFromNode(ctx, key, now)
+	// Removing the key leaves sessions made with it open; end them
+	if err := m.agent.RemoveClient(key.NodeID); err != nil {
+		m.logger.Warn("failed to close node connection", "node", key.NodeID, "error", err)
+	}
+	m.logger.Info("revoked node key", "node", key.NodeID, "key", key.ReferenceID)
+	return nil
+}
+
+// removeFromNode removes a revoked key from its node, leaving it to be
+// retried when the node can't be reached.
+func (m *NodeKeyManager) removeFromNode(ctx context.Context, key *NodeKey, now time.Time) {
+	if err := m.agent.RemoveKey(ctx, key.NodeID, key.PublicKey); err != nil {
+		m.logger.Warn("failed to remove revoked key from node, will retry", "node", key.NodeID, "key", key.ReferenceID, "error", err)
+		return
+	}
+	if err := m.store.markNodeKeyRemoved(ctx, key.ID, now); err != nil {
+		m.logger.Error("failed to record node key removal", "key", key.ReferenceID, "error", err)
+	}
+}
+
+func (m *NodeKeyManager) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("node key manager started", "interval", m.interval)
+}
+
+func (m *NodeKeyManager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *NodeKeyManager) run() {
+	defer m.wg.Done()
+	m.runOnce(m.ctx, time.Now())
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.runOnce(m.ctx, now)
+		}
+	}
+}
+
+func (m *NodeKeyManager) runOnce(ctx context.Context, now time.Time) {
+	defer m.store.LoopMetrics().Time("node_keys")()
+
+	retiring, err := m.store.selectNodeKeys(ctx, `WHERE status = ? AND retire_at <= ?`,
+		nodekey.StatusRetiring, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		m.logger.Error("failed to list retiring node keys", "error", err)
+		return
+	}
+	for _, key := range retiring {
+		m.mu.Lock()
+		err := m.revoke(ctx, key, now)
+		m.mu.Unlock()
+		if err != nil {
+			m.logger.Error("failed to retire node key", "key", key.ReferenceID, "error", err)
+		}
+	}
+
+	unremoved, err := m.store.selectNodeKeys(ctx, `WHERE status = ? AND removed_at IS NULL`, nodekey.StatusRevoked)
+	if err != nil {
+		m.logger.Error("failed to list revoked node keys", "error", err)
+		return
+	}
+	for _, key := range unremoved {
+		m.removeFromNode(ctx, key, now)
+	}
+
+	m.applyPolicies(ctx, now)
+}
+
+// applyPolicies rotates or reminds about every active key its creator's
+// policy says is due.
+func (m *NodeKeyManager) applyPolicies(ctx context.Context, now time.Time) {
+	active, err := m.store.selectNodeKeys(ctx, `WHERE status = ? AND creator_id IN (SELECT user_id FROM node_key_policies WHERE max_age_days > 0)`,
+		nodekey.StatusActive)
+	if err != nil {
+		m.logger.Error("failed to list active node keys", "error", err)
+		return
+	}
+	policies := map[int]*NodeKeyPolicy{}
+	for _, key := range active {
+		p, ok := policies[key.CreatorID]
+		if !ok {
+			if p, err = m.store.GetNodeKeyPolicy(ctx, key.CreatorID); err != nil {
+				m.logger.Error("failed to load node key policy", "user_id", key.CreatorID, "error", err)
+				continue
+			}
+			policies[key.CreatorID] = p
+		}
+		issued, ok := parseTime(key.CreatedAt)
+		if !ok || !nodekey.RotationDue(p.Policy(), issued, now) {
+			continue
+		}
+
+		node, err := m.store.Get(ctx, "nodes", key.NodeID)
+		if err != nil {
+			continue
+		}
+		if p.AutoRotate {
+			if _, err := m.Rotate(ctx, node, p.Policy().Window()); err != nil {
+				m.logger.Warn("automatic node key rotation failed", "node", key.NodeID, "error", err)
+			}
+			continue
+		}
+		if key.RemindedAt.Valid {
+			continue
+		}
+		m.remind(node, key, p)
+		if err := m.store.markNodeKeyReminded(ctx, key.ID, now); err != nil {
+			m.logger.Error("failed to record node key reminder", "key", key.ReferenceID, "error", err)
+		}
+	}
+}
+
+// remind tells a creator one of their node keys is due for rotation.
+func (m *NodeKeyManager) remind(node map[string]any, key *NodeKey, p *NodeKeyPolicy) {
+	m.logger.Info("node key due for rotation", "node", key.NodeID, "key", key.ReferenceID)
+	if m.notifier == nil {
+		return
+	}
+	m.notifier.Notify(key.CreatorID, corenotify.Event{
+		Type:         corenotify.EventNodeKeyRotationDue,
+		Severity:     corenotify.SeverityWarning,
+		Title:        fmt.Sprintf("Node %s: SSH key due for rotation", strVal(node["name"])),
+		Message:      fmt.Sprintf("Key %s is older than your %d-day rotation policy.", key.ReferenceID, p.MaxAgeDays),
+		ResourceType: "nodes",
+		ResourceID:   key.NodeID,
+		URL:          m.notifier.link("nodes", key.NodeID),
+		Data:         map[string]any{"key_id": key.ReferenceID, "fingerprint": key.Fingerprint, "issued_at": key.CreatedAt},
+	})
+}
+
+// =============================================================================
+// Node Key Handlers
+// =============================================================================
+
+// nodeKeysHandler handles /nodes/{id}/keys. GET lists the node's keys; POST
+// issues a new key and switches the node to it, taking an optional
+// {"window_hours": 24} that overrides the creator policy's dual-valid
+// window; DELETE ?key=nkey_... revokes a superseded key at once.
+func nodeKeysHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.NodeKeys == nil {
+			writeProblem(w, r, ProblemNotConfigured, "remote nodes are not configured")
+			return
+		}
+
+		node, err := cfg.Store.Get(ctx, "nodes", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "node not found")
+			return
+		}
+		ownerID, ok := toInt64(node["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		nodeRef := strVal(node["reference_id"])
+
+		switch r.Method {
+		case http.MethodPost:
+			var req struct {
+				WindowHours *int `json:"window_hours"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+					return
+				}
+			}
+			policy, err := cfg.Store.GetNodeKeyPolicy(ctx, authCtx.UserID)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to load node key policy")
+				return
+			}
+			window := policy.Policy().Window()
+			if req.WindowHours != nil {
+				if err := nodekey.ValidateWindowHours(*req.WindowHours); err != nil {
+					writeProblem(w, r, ProblemValidationFailed, err.Error())
+					return
+				}
+				window = time.Duration(*req.WindowHours) * time.Hour
+			}
+			key, err := cfg.NodeKeys.Rotate(ctx, node, window)
+			if err != nil {
+				cfg.Logger.Warn("node key rotation failed", "node", nodeRef, "error", err)
+				writeProblem(w, r, ProblemUpstreamFailed, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"data": nodeKeyJSONAPI(key)})
+			return
+
+		case http.MethodDelete:
+			key, err := cfg.Store.GetNodeKey(ctx, nodeRef, r.URL.Query().Get("key"))
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to load node key")
+				return
+			}
+			if key == nil {
+				writeProblem(w, r, ProblemNotFound, "node key not found")
+				return
+			}
+			if err := cfg.NodeKeys.Revoke(ctx, key); err != nil {
+				if errors.Is(err, nodekey.ErrActiveKey) {
+					writeProblem(w, r, ProblemInvalidState, err.Error())
+					return
+				}
+				writeProblem(w, r, ProblemInternal, "failed to revoke node key")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		keys, err := cfg.Store.ListNodeKeys(ctx, nodeRef)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list node keys")
+			return
+		}
+		data := make([]map[string]any, 0, len(keys))
+		for _, k := range keys {
+			data = append(data, nodeKeyJSONAPI(k))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
+
+// nodeKeyPolicyHandler handles GET, PUT and DELETE /node-key-policy for the
+// current user. PUT takes {"max_age_days": 90, "auto_rotate": false,
+// "window_hours": 24}.
+func nodeKeyPolicyHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		ctx := r.Context()
+
+		switch r.Method {
+		case http.MethodPut:
+			var req struct {
+				MaxAgeDays  int  `json:"max_age_days"`
+				AutoRotate  bool `json:"auto_rotate"`
+				WindowHours int  `json:"window_hours"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+			p := nodekey.Policy{MaxAgeDays: req.MaxAgeDays, AutoRotate: req.AutoRotate, WindowHours: req.WindowHours}
+			if err := p.Validate(); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			if err := cfg.Store.SetNodeKeyPolicy(ctx, authCtx.UserID, p); err != nil {
+				cfg.Logger.Error("failed to set node key policy", "user_id", authCtx.UserID, "error", err)
+				writeProblem(w, r, ProblemInternal, "failed to set node key policy")
+				return
+			}
+		case http.MethodDelete:
+			if err := cfg.Store.ClearNodeKeyPolicy(ctx, authCtx.UserID); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to remove node key policy")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		p, err := cfg.Store.GetNodeKeyPolicy(ctx, authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load node key policy")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": nodeKeyPolicyJSONAPI(p)})
+	}
+}
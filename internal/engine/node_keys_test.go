@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/nodekey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Node Key Tests
+// =============================================================================
+
+// fakeKeyAgent records the keys installed on each node.
+type fakeKeyAgent struct {
+	authorized  map[string][]string // nodeID -> public keys
+	closed      []string
+	unreachable bool
+}
+
+func (a *fakeKeyAgent) AuthorizeKey(_ context.Context, nodeID, publicKey, _ string) error {
+	if a.unreachable {
+		return errors.New("node unreachable")
+	}
+	a.authorized[nodeID] = append(a.authorized[nodeID], publicKey)
+	return nil
+}
+
+func (a *fakeKeyAgent) RemoveKey(_ context.Context, nodeID, publicKey string) error {
+	if a.unreachable {
+		return errors.New("node unreachable")
+	}
+	var kept []string
+	for _, k := range a.authorized[nodeID] {
+		if k != publicKey {
+			kept = append(kept, k)
+		}
+	}
+	a.authorized[nodeID] = kept
+	return nil
+}
+
+func (a *fakeKeyAgent) CheckKey(context.Context, string, []byte) error { return nil }
+
+func (a *fakeKeyAgent) RemoveClient(nodeID string) error {
+	a.closed = append(a.closed, nodeID)
+	return nil
+}
+
+func newNodeKeyTest(t *testing.T) (*Store, *NodeKeyManager, *fakeKeyAgent, map[string]any) {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_1', 'creator@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+	node, err := store.Create(ctx, "nodes", map[string]any{
+		"name": "edge-1", "creator_id": userID, "ssh_host": "10.0.0.1", "ssh_user": "root", "status": "online",
+	})
+	require.NoError(t, err)
+
+	agent := &fakeKeyAgent{authorized: map[string][]string{}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return store, NewNodeKeyManager(store, agent, 0, logger), agent, node
+}
+
+func TestNodeKeyManager_RotateKeepsPreviousKeyForWindow(t *testing.T) {
+	store, m, agent, node := newNodeKeyTest(t)
+	ctx := context.Background()
+	nodeRef := strVal(node["reference_id"])
+
+	first, err := m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+	second, err := m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+
+	updated, err := store.Get(ctx, "nodes", nodeRef)
+	require.NoError(t, err)
+	sshKeyID, _ := toInt64(updated["ssh_key_id"])
+	assert.Equal(t, second.SSHKeyID, sshKeyID, "the node connects with the new key")
+	assert.ElementsMatch(t, []string{first.PublicKey, second.PublicKey}, agent.authorized[nodeRef], "both keys are valid during the window")
+
+	previous, err := store.GetNodeKey(ctx, nodeRef, first.ReferenceID)
+	require.NoError(t, err)
+	assert.Equal(t, string(nodekey.StatusRetiring), previous.Status)
+
+	// The window ends: the previous key is removed and its sessions closed
+	m.runOnce(ctx, time.Now().Add(2*time.Hour))
+	previous, err = store.GetNodeKey(ctx, nodeRef, first.ReferenceID)
+	require.NoError(t, err)
+	assert.Equal(t, string(nodekey.StatusRevoked), previous.Status)
+	assert.True(t, previous.RemovedAt.Valid)
+	assert.Zero(t, previous.SSHKeyID)
+	assert.Equal(t, []string{second.PublicKey}, agent.authorized[nodeRef])
+	assert.Equal(t, []string{nodeRef}, agent.closed)
+	_, err = store.GetByID(ctx, "ssh_keys", int(first.SSHKeyID))
+	assert.Error(t, err, "the revoked private key is deleted")
+}
+
+func TestNodeKeyManager_Revoke(t *testing.T) {
+	store, m, agent, node := newNodeKeyTest(t)
+	ctx := context.Background()
+	nodeRef := strVal(node["reference_id"])
+
+	first, err := m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.Revoke(ctx, first), nodekey.ErrActiveKey)
+
+	_, err = m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+	first, err = store.GetNodeKey(ctx, nodeRef, first.ReferenceID)
+	require.NoError(t, err)
+
+	// An unreachable node has the key removed once it answers again
+	agent.unreachable = true
+	require.NoError(t, m.Revoke(ctx, first))
+	first, err = store.GetNodeKey(ctx, nodeRef, first.ReferenceID)
+	require.NoError(t, err)
+	assert.Equal(t, string(nodekey.StatusRevoked), first.Status)
+	assert.False(t, first.RemovedAt.Valid)
+	assert.Equal(t, []string{nodeRef}, agent.closed, "sessions are closed at once")
+
+	agent.unreachable = false
+	m.runOnce(ctx, time.Now())
+	first, err = store.GetNodeKey(ctx, nodeRef, first.ReferenceID)
+	require.NoError(t, err)
+	assert.True(t, first.RemovedAt.Valid)
+	assert.Len(t, agent.authorized[nodeRef], 1)
+}
+
+func TestNodeKeyManager_LastUsed(t *testing.T) {
+	store, m, _, node := newNodeKeyTest(t)
+	ctx := context.Background()
+	nodeRef := strVal(node["reference_id"])
+
+	key, err := m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+	n, err := store.GetNode(ctx, nodeRef)
+	require.NoError(t, err)
+	_, err = store.GetSSHKey(ctx, n.SSHKeyRefID)
+	require.NoError(t, err)
+
+	key, err = store.GetNodeKey(ctx, nodeRef, key.ReferenceID)
+	require.NoError(t, err)
+	assert.True(t, key.LastUsedAt.Valid)
+}
+
+func TestNodeKeyManager_Policy(t *testing.T) {
+	store, m, _, node := newNodeKeyTest(t)
+	ctx := context.Background()
+	nodeRef := strVal(node["reference_id"])
+	creatorID := toInt(node["creator_id"])
+
+	key, err := m.Rotate(ctx, node, time.Hour)
+	require.NoError(t, err)
+	later := time.Now().AddDate(0, 0, 31)
+
+	// Reminders are sent once per key
+	require.NoError(t, store.SetNodeKeyPolicy(ctx, creatorID, nodekey.Policy{MaxAgeDays: 30}))
+	m.runOnce(ctx, later)
+	key, err = store.GetNodeKey(ctx, nodeRef, key.ReferenceID)
+	require.NoError(t, err)
+	assert.True(t, key.RemindedAt.Valid)
+	assert.Equal(t, string(nodekey.StatusActive), key.Status)
+
+	// Auto-rotation replaces the due key
+	require.NoError(t, store.SetNodeKeyPolicy(ctx, creatorID, nodekey.Policy{MaxAgeDays: 30, AutoRotate: true}))
+	m.runOnce(ctx, later)
+	key, err = store.GetNodeKey(ctx, nodeRef, key.ReferenceID)
+	require.NoError(t, err)
+	assert.Equal(t, string(nodekey.StatusRetiring), key.Status)
+	active, err := store.activeNodeKey(ctx, nodeRef)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.ReferenceID, active.ReferenceID)
+}
@@ -0,0 +1,309 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Node Metrics Storage
+// =============================================================================
+//
+// node_metrics holds one row per collected sample. Summary columns back the
+// dashboard charts; the full minion payload is kept in details for the latest
+// sample view. collected_at is RFC3339 UTC so string comparison orders by time.
+
+// RecordNodeMetrics stores a metrics sample and the alerts it raised.
+func (s *Store) RecordNodeMetrics(ctx context.Context, nodeID int, m minion.NodeMetrics, alerts []monitoring.NodeAlert) error {
+	details, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal node metrics: %w", err)
+	}
+	alertsJSON, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("marshal node alerts: %w", err)
+	}
+
+	collectedAt := m.CollectedAt
+	if collectedAt.IsZero() {
+		collectedAt = time.Now()
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO node_metrics (node_id, collected_at, load_avg_1, load_avg_5, load_avg_15,
+			cpu_used_percent, memory_used_mb, memory_total_mb, disk_max_percent,
+			dockerd_status, journal_errors, details, alerts)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nodeID, collectedAt.UTC().Format(time.RFC3339), m.LoadAvg1, m.LoadAvg5, m.LoadAvg15,
+		m.CPUUsedPct, m.MemoryUsedMB, m.MemoryTotalMB, monitoring.MaxDiskPercent(m.Disks),
+		m.DockerdStatus, len(m.JournalErrors), string(details), string(alertsJSON))
+	if err != nil {
+		return fmt.Errorf("record node metrics: %w", err)
+	}
+	return nil
+}
+
+// PruneNodeMetrics deletes samples collected before the cutoff.
+func (s *Store) PruneNodeMetrics(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM node_metrics WHERE collected_at < ?`, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("prune node metrics: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// =============================================================================
+// Node Metrics Collector
+// =============================================================================
+
+// NodeMetricsCollector periodically collects node-level metrics (load, disk,
+// dockerd, journal errors) from online nodes, stores them as history, raises
+// threshold alerts, and prunes samples older than the retention period.
+type NodeMetricsCollector struct {
+	store      *Store
+	nodePool   *docker.NodePool
+	interval   time.Duration
+	retention  time.Duration
+	thresholds monitoring.NodeThresholds
+	notifier   *Notifier
+	logger     *slog.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func NewNodeMetricsCollector(store *Store, nodePool *docker.NodePool, interval, retention time.Duration, logger *slog.Logger) *NodeMetricsCollector {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	if retention == 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	return &NodeMetricsCollector{
+		store:      store,
+		nodePool:   nodePool,
+		interval:   interval,
+		retention:  retention,
+		thresholds: monitoring.DefaultNodeThresholds(),
+		logger:     logger.With("component", "node_metrics"),
+	}
+}
+
+// SetNotifier makes the collector notify node creators of new alerts.
+func (c *NodeMetricsCollector) SetNotifier(n *Notifier) {
+	c.notifier = n
+}
+
+func (c *NodeMetricsCollector) Start() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.run()
+	c.logger.Info("node metrics collector started", "interval", c.interval, "retention", c.retention)
+}
+
+func (c *NodeMetricsCollector) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *NodeMetricsCollector) run() {
+	defer c.wg.Done()
+	c.collectAll()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.collectAll()
+		}
+	}
+}
+
+func (c *NodeMetricsCollector) collectAll() {
+	defer c.store.LoopMetrics().Time("node_metrics")()
+	nodes, err := c.store.List(c.ctx, "nodes", []Filter{
+		{Field: "status", Value: "online"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		c.logger.Error("failed to list nodes", "error", err)
+		return
+	}
+
+	for _, node := range nodes {
+		c.collectNode(node)
+	}
+
+	cutoff := monitoring.RetentionCutoff(time.Now(), c.retention)
+	if n, err := c.store.PruneNodeMetrics(c.ctx, cutoff); err != nil {
+		c.logger.Error("failed to prune node metrics", "error", err)
+	} else if n > 0 {
+		c.logger.Debug("pruned node metrics", "rows", n)
+	}
+}
+
+func (c *NodeMetricsCollector) collectNode(node map[string]any) {
+	refID := strVal(node["reference_id"])
+	nodeID, _ := toInt64(node["id"])
+
+	m, err := c.nodePool.NodeMetrics(c.ctx, refID, minion.NodeMetricsOptions{})
+	if err != nil {
+		// Reachability is the health checker's job; just skip this sample
+		c.logger.Debug("node metrics collection failed", "node", refID, "error", err)
+		return
+	}
+
+	alerts := monitoring.EvaluateNodeAlerts(*m, c.thresholds)
+
+	var previous []monitoring.NodeAlert
+	_ = decodeJSONValue(node["alerts"], &previous)
+	for _, a := range monitoring.NewNodeAlerts(previous, alerts) {
+		c.logger.Warn("node alert", "node", refID, "kind", a.Kind, "severity", a.Severity, "message", a.Message)
+		if c.notifier != nil {
+			c.notifier.NotifyNodeAlert(node, a)
+		}
+	}
+
+	if err := c.store.RecordNodeMetrics(c.ctx, int(nodeID), *m, alerts); err != nil {
+		c.logger.Error("failed to record node metrics", "node", refID, "error", err)
+		return
+	}
+
+	if alerts == nil {
+		alerts = []monitoring.NodeAlert{}
+	}
+	if _, err := c.store.Update(c.ctx, "nodes", refID, map[string]any{"alerts": alerts}); err != nil {
+		c.logger.Error("failed to update node alerts", "node", refID, "error", err)
+	}
+}
+
+// =============================================================================
+// Node Monitoring Handler
+// =============================================================================
+
+// nodeMonitoringHandler serves GET /nodes/{id}/monitoring for the node detail
+// dashboard: the latest sample, history since ?since= (default 24h), current
+// alerts, and the thresholds they were evaluated against. Owner only.
+func nodeMonitoringHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		node, err := cfg.Store.Get(ctx, "nodes", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "node not found")
+			return
+		}
+
+		ownerID, ok := toInt64(node["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		nodeID, _ := toInt64(node["id"])
+
+		window := 24 * time.Hour
+		if v := r.URL.Query().Get("since"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeProblem(w, r, ProblemValidationFailed, "since must be a positive duration (e.g. 24h)")
+				return
+			}
+			window = d
+		}
+		limit := 500
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 5000 {
+				limit = n
+			}
+		}
+
+		rows, err := cfg.Store.RawQuery(ctx,
+			`SELECT collected_at, load_avg_1, load_avg_5, load_avg_15, cpu_used_percent,
+				memory_used_mb, memory_total_mb, disk_max_percent, dockerd_status, journal_errors
+			FROM node_metrics WHERE node_id = ? AND collected_at >= ?
+			ORDER BY collected_at DESC LIMIT ?`,
+			nodeID, time.Now().Add(-window).UTC().Format(time.RFC3339), limit)
+		if err != nil {
+			cfg.Logger.Warn("failed to query node metrics", "node", id, "error", err)
+			rows = nil
+		}
+
+		// Oldest first for charting
+		history := make([]map[string]any, 0, len(rows))
+		for i := len(rows) - 1; i >= 0; i-- {
+			row := rows[i]
+			history = append(history, map[string]any{
+				"collected_at":     strVal(row["collected_at"]),
+				"load_avg_1":       row["load_avg_1"],
+				"load_avg_5":       row["load_avg_5"],
+				"load_avg_15":      row["load_avg_15"],
+				"cpu_used_percent": row["cpu_used_percent"],
+				"memory_used_mb":   row["memory_used_mb"],
+				"memory_total_mb":  row["memory_total_mb"],
+				"disk_max_percent": row["disk_max_percent"],
+				"dockerd_status":   strVal(row["dockerd_status"]),
+				"journal_errors":   row["journal_errors"],
+			})
+		}
+
+		var latest *minion.NodeMetrics
+		latestRows, err := cfg.Store.RawQuery(ctx,
+			`SELECT details FROM node_metrics WHERE node_id = ? ORDER BY collected_at DESC LIMIT 1`, nodeID)
+		if err == nil && len(latestRows) == 1 {
+			var m minion.NodeMetrics
+			if err := decodeJSONValue(latestRows[0]["details"], &m); err == nil {
+				latest = &m
+			}
+		}
+
+		alerts := []monitoring.NodeAlert{}
+		_ = decodeJSONValue(node["alerts"], &alerts)
+		if alerts == nil {
+			alerts = []monitoring.NodeAlert{}
+		}
+
+		t := monitoring.DefaultNodeThresholds()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "node-monitoring",
+				"id":   strVal(node["reference_id"]),
+				"attributes": map[string]any{
+					"status":  strVal(node["status"]),
+					"latest":  latest,
+					"history": history,
+					"alerts":  alerts,
+					"thresholds": map[string]any{
+						"disk_percent":   t.DiskPercent,
+						"memory_percent": t.MemoryPercent,
+						"load_per_core":  t.LoadPerCore,
+						"journal_errors": t.JournalErrors,
+					},
+				},
+			},
+		})
+	}
+}
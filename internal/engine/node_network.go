@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/topology"
+)
+
+// =============================================================================
+// Node Network Topology
+// =============================================================================
+//
+// A node's ssh_host is how the backend reaches it, which for nodes behind NAT
+// or on a VPN is a private address. The address the internet reaches the node
+// at is the owner's public_address if set, else the one the minion detects
+// (cloud metadata or STUN), else ssh_host when it is a public IP. That address
+// is used in custom domain DNS instructions and deployment endpoints; the
+// health checker refreshes detection and probes reachability, recording
+// warnings on the node.
+
+const (
+	// networkCheckInterval is how often a node's addresses are re-detected.
+	networkCheckInterval = 15 * time.Minute
+	// reachabilityTimeout bounds each dial of a node's public address.
+	reachabilityTimeout = 3 * time.Second
+	// maxProbePorts bounds the ports dialled per check.
+	maxProbePorts = 3
+)
+
+// nodeTopology resolves a node row's addressing, with the outcome of a
+// reachability probe if there was one.
+func nodeTopology(node map[string]any, probe *topology.Probe) topology.Topology {
+	in := topology.Input{
+		Manual:  strVal(node["public_address"]),
+		SSHHost: strVal(node["ssh_host"]),
+		Probe:   probe,
+	}
+	if priv, pub := strVal(node["private_address"]), strVal(node["detected_public_address"]); priv != "" || pub != "" {
+		in.Detected = &minion.NetworkAddresses{
+			PrivateAddress: priv,
+			PublicAddress:  pub,
+			Source:         strVal(node["address_source"]),
+		}
+	}
+	return topology.Resolve(in)
+}
+
+// nodePublicAddress returns the address the internet reaches a node at, or
+// "" when it is unknown.
+func nodePublicAddress(ctx context.Context, store *Store, nodeRef string) string {
+	if nodeRef == "" {
+		return ""
+	}
+	node, err := store.Get(ctx, "nodes", nodeRef)
+	if err != nil {
+		return ""
+	}
+	return nodeTopology(node, nil).PublicAddress
+}
+
+// validateNodePublicAddress checks an owner-set public_address.
+func validateNodePublicAddress(data map[string]any) error {
+	addr, ok := data["public_address"]
+	if !ok || addr == nil || strVal(addr) == "" {
+		return nil
+	}
+	return topology.ValidateAddress(strVal(addr))
+}
+
+// =============================================================================
+// Detection
+// =============================================================================
+
+// networkCheckDue reports whether a node's addresses should be re-detected.
+func networkCheckDue(node map[string]any, now time.Time) bool {
+	checked, ok := parseTime(strVal(node["network_checked_at"]))
+	return !ok || now.Sub(checked) >= networkCheckInterval
+}
+
+// checkNetwork detects a node's addresses through its minion, probes the
+// resulting public address, and records both on the node. A minion that
+// cannot detect addresses (e.g. an older protocol) leaves detection as it
+// was but still refreshes the warnings.
+func (h *HealthChecker) checkNetwork(ctx context.Context, node map[string]any) {
+	refID := strVal(node["reference_id"])
+	logger := h.logger.With("node", refID)
+
+	update := map[string]any{"network_checked_at": time.Now().UTC().Format(time.RFC3339)}
+	addrs, err := h.nodePool.NetworkAddresses(ctx, refID, minion.NetworkAddressOptions{})
+	if err != nil {
+		logger.Debug("network address detection failed", "error", err)
+	} else {
+		update["private_address"] = addrs.PrivateAddress
+		update["detected_public_address"] = addrs.PublicAddress
+		update["address_source"] = addrs.Source
+		if addrs.Error != "" {
+			logger.Debug("node has no detected public address", "error", addrs.Error)
+		}
+		for k, v := range update {
+			node[k] = v
+		}
+	}
+
+	top := nodeTopology(node, nil)
+	if top.PublicAddress != "" {
+		probe := h.probeReachability(ctx, node, top.PublicAddress)
+		top = nodeTopology(node, &probe)
+	}
+	warnings := top.Warnings
+	if warnings == nil {
+		warnings = []string{}
+	}
+	update["network_warnings"] = warnings
+	if len(top.Warnings) > 0 {
+		logger.Warn("node network warnings", "public_address", top.PublicAddress, "warnings", top.Warnings)
+	}
+
+	if _, err := h.store.Update(ctx, "nodes", refID, update); err != nil {
+		logger.Error("failed to record node network topology", "error", err)
+	}
+}
+
+// probeReachability dials the node's public address on ports its running
+// deployments publish, or its SSH port when there are none. One successful
+// connection is enough.
+func (h *HealthChecker) probeReachability(ctx context.Context, node map[string]any, publicAddr string) topology.Probe {
+	var containers []domain.ContainerInfo
+	depls, err := h.store.List(ctx, "deployments", []Filter{
+		{Field: "node_id", Value: strVal(node["reference_id"])},
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 100})
+	if err == nil {
+		for _, d := range depls {
+			var cs []domain.ContainerInfo
+			decodeJSONValue(d["containers"], &cs)
+			containers = append(containers, cs...)
+		}
+	}
+	sshPort := toInt(node["ssh_port"])
+	if sshPort == 0 {
+		sshPort = 22
+	}
+
+	ports := topology.ProbePorts(containers, sshPort)
+	if len(ports) > maxProbePorts {
+		ports = ports[:maxProbePorts]
+	}
+	var probe topology.Probe
+	dialer := net.Dialer{Timeout: reachabilityTimeout}
+	for _, port := range ports {
+		probe = topology.Probe{Address: net.JoinHostPort(publicAddr, strconv.Itoa(port))}
+		conn, err := dialer.DialContext(ctx, "tcp", probe.Address)
+		if err != nil {
+			probe.Error = err.Error()
+			continue
+		}
+		conn.Close()
+		probe.Reachable = true
+		break
+	}
+	return probe
+}
+
+// =============================================================================
+// Deployment Endpoints
+// =============================================================================
+
+// deploymentEndpoints adds an "endpoints" list to deployments whose
+// containers publish ports: each port at the node's public address. The App
+// Proxy and canary ports are left out; they are reached through the
+// deployment's domains.
+func deploymentEndpoints(store *Store, logger *slog.Logger) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		addrs := map[string]string{}
+		for _, row := range rows {
+			var containers []domain.ContainerInfo
+			decodeJSONValue(row["containers"], &containers)
+			if len(containers) == 0 {
+				continue
+			}
+			nodeRef := strVal(row["node_id"])
+			addr, ok := addrs[nodeRef]
+			if !ok {
+				addr = nodePublicAddress(ctx, store, nodeRef)
+				addrs[nodeRef] = addr
+				if addr == "" && nodeRef != "" {
+					logger.Debug("node has no public address for endpoints", "node", nodeRef)
+				}
+			}
+			if eps := topology.Endpoints(addr, containers, toInt(row["proxy_port"]), toInt(row["canary_port"])); len(eps) > 0 {
+				row["endpoints"] = eps
+			}
+		}
+	}
+}
+
+// chainAfterRead runs AfterRead hooks in order.
+func chainAfterRead(hooks ...AfterReadFunc) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		for _, hook := range hooks {
+			hook(ctx, authCtx, rows)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/crypto"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	"github.com/artpar/hoster/internal/core/slo"
+	"github.com/artpar/hoster/internal/shell/notify"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Notification Channels
+// =============================================================================
+
+// Each user configures notification_channels (Slack, Web Push). A channel
+// receives the event types listed in its events field, or every event when
+// the list is empty. Deployment state changes and new node alerts are routed
+// to the owner's enabled channels; a channel whose destination is gone is
+// disabled with the reason in last_error.
+
+// NotifierConfig configures a Notifier.
+type NotifierConfig struct {
+	// Channels builds channels by type; types without a factory are rejected.
+	Channels *notify.Registry
+	// VAPIDPublicKey is handed to browsers subscribing to Web Push.
+	VAPIDPublicKey string
+	// AppURL is the web UI base URL used for links in notifications.
+	AppURL string
+	// Timeout bounds one delivery (default 15s).
+	Timeout time.Duration
+}
+
+// Notifier delivers events to users' notification channels.
+type Notifier struct {
+	store          *Store
+	channels       *notify.Registry
+	vapidPublicKey string
+	appURL         string
+	timeout        time.Duration
+	logger         *slog.Logger
+	wg             sync.WaitGroup
+}
+
+// NewNotifier creates a notifier.
+func NewNotifier(store *Store, cfg NotifierConfig, logger *slog.Logger) *Notifier {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.Channels == nil {
+		cfg.Channels = notify.NewRegistry()
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+	return &Notifier{
+		store:          store,
+		channels:       cfg.Channels,
+		vapidPublicKey: cfg.VAPIDPublicKey,
+		appURL:         strings.TrimRight(cfg.AppURL, "/"),
+		timeout:        cfg.Timeout,
+		logger:         logger.With("component", "notifier"),
+	}
+}
+
+// Supports reports whether channels of type t are enabled on this server.
+func (n *Notifier) Supports(t corenotify.ChannelType) bool {
+	return n.channels.Supports(t)
+}
+
+// Notify delivers ev to the user's enabled channels that route it and queues
+// it for their webhooks, in the background.
+func (n *Notifier) Notify(userID int, ev corenotify.Event) {
+	if ev.OccurredAt.IsZero() {
+		ev.OccurredAt = time.Now().UTC()
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx := context.Background()
+		n.enqueueWebhooks(ctx, userID, 0, ev)
+		rows, err := n.store.List(ctx, "notification_channels", []Filter{
+			{Field: "user_id", Value: userID},
+			{Field: "enabled", Value: true},
+		}, Page{Limit: 100})
+		if err != nil {
+			n.logger.Error("failed to list notification channels", "user", userID, "error", err)
+			return
+		}
+		for _, row := range rows {
+			var events []string
+			_ = decodeJSONValue(row["events"], &events)
+			if !corenotify.Routes(events, ev.Type) {
+				continue
+			}
+			if err := n.Send(ctx, row, ev); err != nil {
+				n.logger.Warn("notification delivery failed", "channel", strVal(row["reference_id"]), "event", ev.Type, "error", err)
+			}
+		}
+	}()
+}
+
+// Wait blocks until background deliveries have finished.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// Send delivers ev to one channel and records the outcome on it.
+func (n *Notifier) Send(ctx context.Context, row map[string]any, ev corenotify.Event) error {
+	refID := strVal(row["reference_id"])
+	err := n.send(ctx, row, ev)
+
+	updates := map[string]any{"last_error": nil}
+	if err == nil {
+		updates["last_sent_at"] = time.Now().UTC().Format(time.RFC3339)
+	} else {
+		updates["last_error"] = err.Error()
+		if errors.Is(err, notify.ErrChannelGone) {
+			updates["enabled"] = false
+		}
+	}
+	if _, uerr := n.store.Update(ctx, "notification_channels", refID, updates); uerr != nil {
+		n.logger.Error("failed to record notification outcome", "channel", refID, "error", uerr)
+	}
+	return err
+}
+
+func (n *Notifier) send(ctx context.Context, row map[string]any, ev corenotify.Event) error {
+	config, err := n.store.notificationChannelConfig(row)
+	if err != nil {
+		return err
+	}
+	ch, err := n.channels.New(corenotify.ChannelType(strVal(row["type"])), config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	return ch.Send(ctx, ev)
+}
+
+// link returns the web UI URL of a resource, or "" without an app URL.
+func (n *Notifier) link(resource, refID string) string {
+	if n.appURL == "" || refID == "" {
+		return ""
+	}
+	return n.appURL + "/" + resource + "/" + refID
+}
+
+// notificationChannelConfig decrypts a channel's config.
+func (s *Store) notificationChannelConfig(row map[string]any) ([]byte, error) {
+	config, err := s.decryptField(row["config"])
+	if err != nil {
+		return nil, fmt.Errorf("decrypt channel config: %w", err)
+	}
+	return config, nil
+}
+
+// decryptField decrypts the value of a WithEncrypted field. Without an
+// encryption key values are stored as-is.
+func (s *Store) decryptField(v any) ([]byte, error) {
+	var raw []byte
+	switch val := v.(type) {
+	case []byte:
+		raw = val
+	case string:
+		raw = []byte(val)
+	}
+	if len(s.encryptionKey) == 0 {
+		return raw, nil
+	}
+	return crypto.Decrypt(raw, s.encryptionKey)
+}
+
+// =============================================================================
+// Event Sources
+// =============================================================================
+
+// deploymentEvents maps deployment states to the events entering them raises.
+var deploymentEvents = map[string]struct {
+	Type     corenotify.EventType
+	Severity corenotify.Severity
+	Verb     string
+}{
+	"running": {corenotify.EventDeploymentRunning, corenotify.SeverityInfo, "is running"},
+	"failed":  {corenotify.EventDeploymentFailed, corenotify.SeverityCritical, "failed"},
+	"stopped": {corenotify.EventDeploymentStopped, corenotify.SeverityInfo, "stopped"},
+}
+
+// onTransition is the store transition hook that notifies deployment owners,
+// and the template's creator of the deployment starting or being deleted, and
+// creators of their cloud provisions completing.
+func (n *Notifier) onTransition(_ context.Context, resource string, row map[string]any, _, to string) {
+	if resource == "cloud_provisions" && to == "ready" {
+		n.notifyProvisionCompleted(row)
+	}
+	if resource != "deployments" {
+		return
+	}
+	if t, ok := templateEvents[to]; ok {
+		n.NotifyTemplate(row, t)
+	}
+	e, ok := deploymentEvents[to]
+	if !ok {
+		return
+	}
+	customerID, ok := toInt64(row["customer_id"])
+	if !ok {
+		return
+	}
+	refID := strVal(row["reference_id"])
+	ev := corenotify.Event{
+		Type:         e.Type,
+		Severity:     e.Severity,
+		Title:        fmt.Sprintf("Deployment %s %s", strVal(row["name"]), e.Verb),
+		ResourceType: "deployments",
+		ResourceID:   refID,
+		URL:          n.link("deployments", refID),
+	}
+	if to == "failed" {
+		ev.Message = strVal(row["error_message"])
+	}
+	n.Notify(int(customerID), ev)
+}
+
+// NotifyNodeAlert notifies a node's creator of a newly raised alert.
+func (n *Notifier) NotifyNodeAlert(node map[string]any, a monitoring.NodeAlert) {
+	creatorID, ok := toInt64(node["creator_id"])
+	if !ok {
+		return
+	}
+	severity := corenotify.SeverityWarning
+	if a.Severity == monitoring.SeverityCritical {
+		severity = corenotify.SeverityCritical
+	}
+	refID := strVal(node["reference_id"])
+	n.Notify(int(creatorID), corenotify.Event{
+		Type:         corenotify.EventNodeAlert,
+		Severity:     severity,
+		Title:        fmt.Sprintf("Node %s: %s", strVal(node["name"]), strings.ReplaceAll(string(a.Kind), "_", " ")),
+		Message:      a.Message,
+		ResourceType: "nodes",
+		ResourceID:   refID,
+		URL:          n.link("nodes", refID),
+	})
+}
+
+// NotifyNodeOffline notifies a node's creator that the node went offline.
+func (n *Notifier) NotifyNodeOffline(node map[string]any, cause error) {
+	creatorID, ok := toInt64(node["creator_id"])
+	if !ok {
+		return
+	}
+	refID := strVal(node["reference_id"])
+	n.Notify(int(creatorID), corenotify.Event{
+		Type:         corenotify.EventNodeOffline,
+		Severity:     corenotify.SeverityCritical,
+		Title:        fmt.Sprintf("Node %s is offline", strVal(node["name"])),
+		Message:      cause.Error(),
+		ResourceType: "nodes",
+		ResourceID:   refID,
+		URL:          n.link("nodes", refID),
+		Data:         map[string]any{"ssh_host": strVal(node["ssh_host"]), "error": cause.Error()},
+	})
+}
+
+// notifyProvisionCompleted notifies a cloud provision's creator that its
+// instance is ready and its node created.
+func (n *Notifier) notifyProvisionCompleted(prov map[string]any) {
+	creatorID, ok := toInt64(prov["creator_id"])
+	if !ok {
+		return
+	}
+	refID, nodeID := strVal(prov["reference_id"]), strVal(prov["node_id"])
+	n.Notify(int(creatorID), corenotify.Event{
+		Type:         corenotify.EventProvisionCompleted,
+		Severity:     corenotify.SeverityInfo,
+		Title:        fmt.Sprintf("Instance %s is ready", strVal(prov["instance_name"])),
+		Message:      fmt.Sprintf("Provisioned on %s in %s; node %s is online.", strVal(prov["provider"]), strVal(prov["region"]), nodeID),
+		ResourceType: "nodes",
+		ResourceID:   nodeID,
+		URL:          n.link("nodes", nodeID),
+		Data: map[string]any{
+			"provision_id": refID,
+			"node_id":      nodeID,
+			"provider":     strVal(prov["provider"]),
+			"region":       strVal(prov["region"]),
+			"size":         strVal(prov["size"]),
+			"public_ip":    strVal(prov["public_ip"]),
+		},
+	})
+}
+
+// NotifyDeploymentUnhealthy tells a deployment's customer that one of its
+// services failed its health check.
+func (n *Notifier) NotifyDeploymentUnhealthy(depl map[string]any, service, message string) {
+	customerID, ok := toInt64(depl["customer_id"])
+	if !ok {
+		return
+	}
+	refID := strVal(depl["reference_id"])
+	n.Notify(int(customerID), corenotify.Event{
+		Type:         corenotify.EventDeploymentUnhealthy,
+		Severity:     corenotify.SeverityWarning,
+		Title:        fmt.Sprintf("Deployment %s: %s is unhealthy", strVal(depl["name"]), service),
+		Message:      message,
+		ResourceType: "deployments",
+		ResourceID:   refID,
+		URL:          n.link("deployments", refID),
+		Data:         map[string]any{"service": service, "node_id": strVal(depl["node_id"])},
+	})
+}
+
+// NotifyDeploymentAbuse tells recipients, its node's creator and the
+// administrators, that a deployment was flagged for possible abuse.
+func (n *Notifier) NotifyDeploymentAbuse(depl map[string]any, recipients []int, anomalies []monitoring.Anomaly, throttled bool) {
+	refID := strVal(depl["reference_id"])
+	findings := make([]string, len(anomalies))
+	for i, a := range anomalies {
+		findings[i] = fmt.Sprintf("%s: %s", a.Service, a.Message)
+	}
+	ev := corenotify.Event{
+		Type:         corenotify.EventDeploymentAbuse,
+		Severity:     corenotify.SeverityCritical,
+		Title:        fmt.Sprintf("Deployment %s flagged for possible abuse", strVal(depl["name"])),
+		Message:      strings.Join(findings, "; ") + ".",
+		ResourceType: "deployments",
+		ResourceID:   refID,
+		URL:          n.link("deployments", refID),
+		Data: map[string]any{
+			"abuse_score": toInt(depl["abuse_score"]),
+			"anomalies":   anomalies,
+			"node_id":     strVal(depl["node_id"]),
+			"throttled":   throttled,
+		},
+	}
+	if throttled {
+		ev.Message += fmt.Sprintf(" Its containers were throttled to %g CPU.", monitoring.ThrottleCPU)
+	}
+	for _, userID := range recipients {
+		n.Notify(userID, ev)
+	}
+}
+
+// NotifyDeploymentSLOBurn tells a deployment's customer and its template's
+// creator that the deployment spends the error budget of an objective too
+// fast.
+func (n *Notifier) NotifyDeploymentSLOBurn(ctx context.Context, depl map[string]any, objective string, level slo.AlertLevel, c slo.Compliance, b slo.Burn) {
+	refID := strVal(depl["reference_id"])
+	severity := corenotify.SeverityWarning
+	if level == slo.AlertCritical {
+		severity = corenotify.SeverityCritical
+	}
+	ev := corenotify.Event{
+		Type:     corenotify.EventDeploymentSLOBurn,
+		Severity: severity,
+		Title:    fmt.Sprintf("Deployment %s is burning its %s error budget", strVal(depl["name"]), objective),
+		Message: fmt.Sprintf("Burn rate %.1fx over the last hour and %.1fx over 6 hours; %.3g%% achieved against a %g%% target, %.0f%% of the budget left.",
+			b.Fast, b.Slow, c.Achieved, c.Target, 100*c.BudgetRemaining),
+		ResourceType: "deployments",
+		ResourceID:   refID,
+		URL:          n.link("deployments", refID),
+		Data: map[string]any{
+			"objective":              objective,
+			"level":                  level,
+			"burn_rate_1h":           b.Fast,
+			"burn_rate_6h":           b.Slow,
+			"achieved":               c.Achieved,
+			"target":                 c.Target,
+			"error_budget_remaining": c.BudgetRemaining,
+		},
+	}
+	var recipients []int
+	if customerID, ok := toInt64(depl["customer_id"]); ok {
+		recipients = append(recipients, int(customerID))
+	}
+	if tmpl, err := n.store.GetByID(ctx, "templates", toInt(depl["template_id"])); err == nil {
+		if creatorID, ok := toInt64(tmpl["creator_id"]); ok && !slices.Contains(recipients, int(creatorID)) {
+			recipients = append(recipients, int(creatorID))
+		}
+	}
+	for _, userID := range recipients {
+		n.Notify(userID, ev)
+	}
+}
+
+// NotifyDeploymentExpiry tells a trial deployment's customer when it expires,
+// or that it has expired and when it will be deleted. Times are shown in the
+// customer's time zone.
+func (n *Notifier) NotifyDeploymentExpiry(ctx context.Context, depl map[string]any, e coredeployment.Expiry, now time.Time) {
+	customerID, ok := toInt64(depl["customer_id"])
+	if !ok {
+		return
+	}
+	loc := n.store.UserLocation(ctx, int(customerID))
+	name := strVal(depl["name"])
+	refID := strVal(depl["reference_id"])
+	ev := corenotify.Event{
+		Type:         corenotify.EventDeploymentExpiring,
+		Severity:     corenotify.SeverityWarning,
+		Title:        fmt.Sprintf("Deployment %s expires in %s", name, expiresIn(e.ExpiresAt.Sub(now))),
+		Message:      fmt.Sprintf("It will be stopped on %s. Convert it to a paid deployment to keep it.", e.ExpiresAt.In(loc).Format("Jan 2 15:04 MST")),
+		ResourceType: "deployments",
+		ResourceID:   refID,
+		URL:          n.link("deployments", refID),
+	}
+	if e.Expired(now) {
+		ev.Type = corenotify.EventDeploymentExpired
+		ev.Severity = corenotify.SeverityCritical
+		ev.Title = fmt.Sprintf("Deployment %s expired", name)
+		ev.Message = fmt.Sprintf("It has been stopped and will be deleted on %s. Convert it to a paid deployment to keep it.", e.DeleteAt.In(loc).Format("Jan 2 15:04 MST"))
+	}
+	n.Notify(int(customerID), ev)
+}
+
+// expiresIn renders the time left before an expiry in days, rounded, or
+// in hours when less than half a day is left.
+func expiresIn(d time.Duration) string {
+	days := int((d + 12*time.Hour) / (24 * time.Hour))
+	hours := int((d + 30*time.Minute) / time.Hour)
+	switch {
+	case days == 1:
+		return "1 day"
+	case days > 1:
+		return fmt.Sprintf("%d days", days)
+	case hours <= 1:
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
+
+func getNotifier(deps *Deps) *Notifier {
+	if n, ok := deps.Extra["notifier"].(*Notifier); ok {
+		return n
+	}
+	return nil
+}
+
+// =============================================================================
+// Validation
+// =============================================================================
+
+// validateNotificationChannel checks a new channel's type, config and events.
+// A config given as a JSON object is stored as its JSON text.
+func validateNotificationChannel(notifier *Notifier, data map[string]any) error {
+	t := corenotify.ChannelType(strVal(data["type"]))
+	if notifier != nil && !notifier.Supports(t) {
+		return fmt.Errorf("channel type %q is not enabled on this server", t)
+	}
+	config, err := normalizeChannelConfig(data)
+	if err != nil {
+		return err
+	}
+	if err := corenotify.ValidateConfig(t, config); err != nil {
+		return err
+	}
+	return validateNotificationEvents(data["events"])
+}
+
+func normalizeChannelConfig(data map[string]any) ([]byte, error) {
+	switch v := data["config"].(type) {
+	case string:
+		return []byte(v), nil
+	case map[string]any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		data["config"] = string(b)
+		return b, nil
+	case nil:
+		return nil, fmt.Errorf("config is required")
+	}
+	return nil, fmt.Errorf("config must be a JSON object")
+}
+
+func validateNotificationEvents(v any) error {
+	if v == nil {
+		return nil
+	}
+	var events []string
+	if err := decodeJSONValue(v, &events); err != nil {
+		return fmt.Errorf("events must be a list of event types")
+	}
+	return corenotify.ValidateEvents(events)
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// notificationChannelTestHandler handles POST /notification_channels/{id}/test,
+// sending a test notification and reporting the delivery error, if any.
+func notificationChannelTestHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		if cfg.Notifier == nil {
+			writeProblem(w, r, ProblemNotConfigured, "notifications not configured")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "notification_channels", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "notification channel not found")
+			return
+		}
+
+		ownerID, ok := toInt64(row["user_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		err = cfg.Notifier.Send(ctx, row, corenotify.Event{
+			Type:       corenotify.EventTest,
+			Severity:   corenotify.SeverityInfo,
+			Title:      "Test notification",
+			Message:    fmt.Sprintf("Notifications from Hoster reach %s.", strVal(row["name"])),
+			OccurredAt: time.Now().UTC(),
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemUpstreamFailed, "test notification failed: "+err.Error())
+			return
+		}
+
+		row, err = cfg.Store.Get(ctx, "notification_channels", id)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load notification channel")
+			return
+		}
+		stripFields(cfg.Store.Resource("notification_channels"), row, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "notification_channels", row),
+		})
+	}
+}
+
+// notificationSettingsHandler handles GET /notifications/settings: the channel
+// types enabled on this server, the routable event types, and the VAPID key
+// browsers subscribe to Web Push with.
+func notificationSettingsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		channelTypes := []corenotify.ChannelType{}
+		var vapidKey any
+		if cfg.Notifier != nil {
+			channelTypes = cfg.Notifier.channels.Types()
+			if cfg.Notifier.vapidPublicKey != "" {
+				vapidKey = cfg.Notifier.vapidPublicKey
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "notification_settings",
+				"id":   "current",
+				"attributes": map[string]any{
+					"channel_types":    channelTypes,
+					"event_types":      corenotify.AllEventTypes,
+					"vapid_public_key": vapidKey,
+				},
+			},
+		})
+	}
+}
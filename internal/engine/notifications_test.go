@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Notification Event Tests
+// =============================================================================
+
+type notifyTest struct {
+	store    *Store
+	notifier *Notifier
+	logger   *slog.Logger
+	user     int
+}
+
+// newNotifyTest opens a store with a user whose webhook takes every event,
+// so each event the notifier raises is recorded in webhook_events.
+func newNotifyTest(t *testing.T) *notifyTest {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_ops', 'ops@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+	_, err = store.db.Exec(`INSERT INTO webhooks (reference_id, user_id, url, secret, events, created_at, updated_at)
+		VALUES ('whk_ops', ?, 'https://hooks.example.com/hoster', 'secret', '[]', datetime('now'), datetime('now'))`, userID)
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return &notifyTest{
+		store:    store,
+		notifier: NewNotifier(store, NotifierConfig{AppURL: "https://hoster.example.com"}, logger),
+		logger:   logger,
+		user:     int(userID),
+	}
+}
+
+// events waits for background deliveries and returns the events of type t.
+func (nt *notifyTest) events(t *testing.T, typ corenotify.EventType) []map[string]any {
+	t.Helper()
+	nt.notifier.Wait()
+	var payloads []string
+	require.NoError(t, nt.store.db.Select(&payloads,
+		`SELECT payload FROM webhook_events WHERE user_id = ? AND type = ? ORDER BY id`, nt.user, typ))
+	out := make([]map[string]any, len(payloads))
+	for i, p := range payloads {
+		require.NoError(t, json.Unmarshal([]byte(p), &out[i]))
+	}
+	return out
+}
+
+// unreachableNodes fails every lookup, so every ping fails.
+type unreachableNodes struct{}
+
+func (unreachableNodes) GetNode(context.Context, string) (*domain.Node, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (unreachableNodes) GetSSHKey(context.Context, string) (*domain.SSHKey, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHealthChecker_NotifiesOfflineOnce(t *testing.T) {
+	nt := newNotifyTest(t)
+	ctx := context.Background()
+	node, err := nt.store.Create(ctx, "nodes", map[string]any{
+		"name": "edge-1", "creator_id": nt.user, "ssh_host": "10.0.0.1", "ssh_user": "root", "status": "online",
+	})
+	require.NoError(t, err)
+	nodeRef := strVal(node["reference_id"])
+
+	hc := NewHealthChecker(nt.store, docker.NewNodePool(unreachableNodes{}, nil, docker.NodePoolConfig{}), nil, 0, nt.logger)
+	hc.SetNotifier(nt.notifier)
+	for range 3 {
+		hc.CheckNode(ctx, nodeRef)
+	}
+
+	events := nt.events(t, corenotify.EventNodeOffline)
+	require.Len(t, events, 1, "only the online to offline transition notifies, not every failed ping")
+	ev := events[0]
+	assert.Equal(t, "critical", ev["severity"])
+	assert.Equal(t, "nodes", ev["resource_type"])
+	assert.Equal(t, nodeRef, ev["resource_id"])
+	assert.Equal(t, "https://hoster.example.com/nodes/"+nodeRef, ev["url"])
+	data := ev["data"].(map[string]any)
+	assert.Equal(t, "10.0.0.1", data["ssh_host"])
+	assert.Contains(t, data["error"], "connection refused")
+
+	// Back online and down again notifies again
+	_, err = nt.store.Update(ctx, "nodes", nodeRef, map[string]any{"status": "online"})
+	require.NoError(t, err)
+	hc.CheckNode(ctx, nodeRef)
+	assert.Len(t, nt.events(t, corenotify.EventNodeOffline), 2)
+}
+
+func TestServiceHealthMonitor_NotifiesUnhealthy(t *testing.T) {
+	nt := newNotifyTest(t)
+	ctx := context.Background()
+	tmpl, err := nt.store.Create(ctx, "templates", map[string]any{
+		"name": "Notify Test", "creator_id": nt.user, "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n",
+	})
+	require.NoError(t, err)
+	tmplID, _ := toInt64(tmpl["id"])
+	depl, err := nt.store.Create(ctx, "deployments", map[string]any{
+		"name": "shop", "customer_id": nt.user, "template_id": tmplID, "template_version": "1.0.0",
+		"node_id": "node_edge", "status": "running",
+		"health": domain.DeploymentHealth{Containers: []domain.ContainerHealth{{Name: "web", Health: domain.HealthStatusHealthy}}},
+	})
+	require.NoError(t, err)
+	refID := strVal(depl["reference_id"])
+
+	m := NewServiceHealthMonitor(nt.store, nil, 0, nt.logger)
+	m.SetNotifier(nt.notifier)
+	m.ctx = ctx
+	// The web container is gone, so the service is unhealthy on every check
+	containers := []domain.ContainerInfo{{ID: "ctr_web", ServiceName: "web"}}
+	for range 2 {
+		depl, err = nt.store.Get(ctx, "deployments", refID)
+		require.NoError(t, err)
+		m.checkServices(depl, containers, docker.NewSandboxClient())
+	}
+
+	events := nt.events(t, corenotify.EventDeploymentUnhealthy)
+	require.Len(t, events, 1, "only the healthy to unhealthy transition notifies")
+	ev := events[0]
+	assert.Equal(t, "warning", ev["severity"])
+	assert.Equal(t, "Deployment shop: web is unhealthy", ev["title"])
+	assert.Equal(t, "deployments", ev["resource_type"])
+	assert.Equal(t, refID, ev["resource_id"])
+	assert.Equal(t, map[string]any{"service": "web", "node_id": "node_edge"}, ev["data"])
+}
+
+func TestNotifier_ProvisionCompleted(t *testing.T) {
+	nt := newNotifyTest(t)
+	ctx := context.Background()
+	nt.store.OnTransition(nt.notifier.onTransition)
+	res, err := nt.store.db.Exec(`INSERT INTO cloud_credentials (reference_id, creator_id, name, provider, credentials, created_at, updated_at)
+		VALUES ('cred_1', ?, 'hetzner-main', 'hetzner', '', datetime('now'), datetime('now'))`, nt.user)
+	require.NoError(t, err)
+	credID, _ := res.LastInsertId()
+	_, err = nt.store.db.Exec(`INSERT INTO cloud_provisions
+		(reference_id, creator_id, credential_id, provider, status, instance_name, region, size, public_ip, node_id, created_at, updated_at)
+		VALUES ('prov_1', ?, ?, 'hetzner', 'configuring', 'edge-2', 'fsn1', 'cx22', '203.0.113.7', 'node_edge2', datetime('now'), datetime('now'))`, nt.user, credID)
+	require.NoError(t, err)
+
+	_, _, err = nt.store.Transition(ctx, "cloud_provisions", "prov_1", "ready")
+	require.NoError(t, err)
+
+	events := nt.events(t, corenotify.EventProvisionCompleted)
+	require.Len(t, events, 1)
+	ev := events[0]
+	assert.Equal(t, "info", ev["severity"])
+	assert.Equal(t, "Instance edge-2 is ready", ev["title"])
+	assert.Equal(t, "nodes", ev["resource_type"])
+	assert.Equal(t, "node_edge2", ev["resource_id"])
+	assert.Equal(t, map[string]any{
+		"provision_id": "prov_1",
+		"node_id":      "node_edge2",
+		"provider":     "hetzner",
+		"region":       "fsn1",
+		"size":         "cx22",
+		"public_ip":    "203.0.113.7",
+	}, ev["data"])
+
+	// Other transitions don't notify
+	_, _, err = nt.store.Transition(ctx, "cloud_provisions", "prov_1", "destroying")
+	require.NoError(t, err)
+	assert.Len(t, nt.events(t, corenotify.EventProvisionCompleted), 1)
+}
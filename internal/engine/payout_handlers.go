@@ -0,0 +1,392 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/payout"
+	"github.com/gorilla/mux"
+)
+
+// payoutAccountOnboardHandler creates (if needed) a Stripe Connect Express
+// account for the creator and returns an onboarding link.
+// POST /api/v1/payout_accounts/{id}/onboard
+func payoutAccountOnboardHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		if cfg.StripeKey == "" {
+			writeProblem(w, r, ProblemNotConfigured, "payouts not configured")
+			return
+		}
+
+		acct, err := cfg.Store.Get(ctx, "payout_accounts", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "payout account not found")
+			return
+		}
+
+		ownerID, ok := toInt64(acct["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		var body struct {
+			ReturnURL  string `json:"return_url"`
+			RefreshURL string `json:"refresh_url"`
+		}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+		if body.ReturnURL == "" {
+			body.ReturnURL = "http://localhost:3000/creator/earnings?onboarding=complete"
+		}
+		if body.RefreshURL == "" {
+			body.RefreshURL = "http://localhost:3000/creator/earnings?onboarding=refresh"
+		}
+
+		stripeAccountID := strVal(acct["stripe_account_id"])
+		if stripeAccountID == "" {
+			stripeAccountID, err = createStripeConnectAccount(cfg.StripeKey, strVal(acct["reference_id"]))
+			if err != nil {
+				cfg.Logger.Error("stripe connect account creation failed", "error", err, "account", id)
+				writeProblem(w, r, ProblemUpstreamFailed, "payment provider error: "+err.Error())
+				return
+			}
+			cfg.Store.Update(ctx, "payout_accounts", id, map[string]any{"stripe_account_id": stripeAccountID})
+		}
+
+		onboardingURL, err := createStripeAccountLink(cfg.StripeKey, stripeAccountID, body.RefreshURL, body.ReturnURL)
+		if err != nil {
+			cfg.Logger.Error("stripe account link failed", "error", err, "account", id)
+			writeProblem(w, r, ProblemUpstreamFailed, "payment provider error: "+err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"onboarding_url":    onboardingURL,
+				"payout_account_id": strVal(acct["reference_id"]),
+			},
+		})
+	}
+}
+
+// payoutAccountRefreshHandler checks the connected Stripe account and
+// activates the payout account once Stripe has enabled payouts.
+// POST /api/v1/payout_accounts/{id}/refresh
+func payoutAccountRefreshHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		if cfg.StripeKey == "" {
+			writeProblem(w, r, ProblemNotConfigured, "payouts not configured")
+			return
+		}
+
+		acct, err := cfg.Store.Get(ctx, "payout_accounts", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "payout account not found")
+			return
+		}
+
+		ownerID, ok := toInt64(acct["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		stripeAccountID := strVal(acct["stripe_account_id"])
+		if stripeAccountID == "" {
+			writeProblem(w, r, ProblemInvalidState, "payout account has not started onboarding")
+			return
+		}
+
+		payoutsEnabled, err := checkStripeAccount(cfg.StripeKey, stripeAccountID)
+		if err != nil {
+			cfg.Logger.Error("stripe account check failed", "error", err, "account", id)
+			writeProblem(w, r, ProblemUpstreamFailed, "payment provider error")
+			return
+		}
+
+		status := "pending"
+		if payoutsEnabled {
+			status = "active"
+		}
+		row, err := cfg.Store.Update(ctx, "payout_accounts", id, map[string]any{"status": status})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to update payout account")
+			return
+		}
+
+		res := cfg.Store.Resource("payout_accounts")
+		stripFields(res, row, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "payout_accounts", row),
+		})
+	}
+}
+
+// creatorEarningsHandler returns the authenticated creator's earnings report.
+// GET /api/v1/creator/earnings
+func creatorEarningsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		rows, err := cfg.Store.List(ctx, "creator_earnings", []Filter{
+			{Field: "creator_id", Value: authCtx.UserID},
+		}, Page{Limit: 10000})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to query earnings")
+			return
+		}
+
+		entries := make([]payout.LedgerEntry, 0, len(rows))
+		for _, row := range rows {
+			tmplID, _ := toInt64(row["template_id"])
+			gross, _ := toInt64(row["gross_cents"])
+			fee, _ := toInt64(row["fee_cents"])
+			net, _ := toInt64(row["net_cents"])
+			entries = append(entries, payout.LedgerEntry{
+				TemplateID: int(tmplID),
+				Month:      timeToYearMonth(row["created_at"]),
+				GrossCents: gross,
+				FeeCents:   fee,
+				NetCents:   net,
+				Status:     payout.LedgerStatus(strVal(row["status"])),
+			})
+		}
+
+		report := payout.Summarize(entries)
+		for i := range report.ByTemplate {
+			if tmpl, err := cfg.Store.GetByID(ctx, "templates", report.ByTemplate[i].TemplateID); err == nil {
+				report.ByTemplate[i].TemplateName = strVal(tmpl["name"])
+				report.ByTemplate[i].TemplateRef = strVal(tmpl["reference_id"])
+			}
+		}
+
+		result := map[string]any{
+			"report": report,
+		}
+
+		// Include payout account status and next scheduled payout
+		accts, err := cfg.Store.List(ctx, "payout_accounts", []Filter{
+			{Field: "creator_id", Value: authCtx.UserID},
+		}, Page{Limit: 1})
+		if err == nil && len(accts) > 0 {
+			acct := accts[0]
+			last, _ := parseTime(acct["last_payout_at"])
+			next := payout.NextPayoutAt(last, payout.Schedule(strVal(acct["schedule"])))
+			if next.IsZero() {
+				next = time.Now().UTC()
+			}
+			result["payout_account"] = map[string]any{
+				"id":                   strVal(acct["reference_id"]),
+				"status":               strVal(acct["status"]),
+				"schedule":             strVal(acct["schedule"]),
+				"minimum_payout_cents": acct["minimum_payout_cents"],
+				"next_payout_at":       next.Format(time.RFC3339),
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{"data": result})
+	}
+}
+
+// stripeAPI is the base URL of the Stripe API. Tests point it at a fake.
+var stripeAPI = "https://api.stripe.com/v1"
+
+// createStripeConnectAccount creates a Stripe Connect Express account.
+// Returns the Stripe account ID.
+func createStripeConnectAccount(stripeKey, payoutAccountRef string) (string, error) {
+	data := url.Values{}
+	data.Set("type", "express")
+	data.Set("capabilities[transfers][requested]", "true")
+	data.Set("metadata[payout_account_id]", payoutAccountRef)
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := stripePost(stripeKey, stripeAPI+"/accounts", data, "", &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// createStripeAccountLink creates a hosted onboarding link for a Connect account.
+func createStripeAccountLink(stripeKey, accountID, refreshURL, returnURL string) (string, error) {
+	data := url.Values{}
+	data.Set("account", accountID)
+	data.Set("refresh_url", refreshURL)
+	data.Set("return_url", returnURL)
+	data.Set("type", "account_onboarding")
+
+	var result struct {
+		URL string `json:"url"`
+	}
+	if err := stripePost(stripeKey, stripeAPI+"/account_links", data, "", &result); err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// checkStripeAccount reports whether a Connect account can receive payouts.
+func checkStripeAccount(stripeKey, accountID string) (bool, error) {
+	req, err := http.NewRequest("GET", stripeAPI+"/accounts/"+accountID, nil)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	req.SetBasicAuth(stripeKey, "")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("stripe request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("stripe error (%d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		PayoutsEnabled bool `json:"payouts_enabled"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return false, fmt.Errorf("parse response: %w", err)
+	}
+
+	return result.PayoutsEnabled, nil
+}
+
+// createStripeTransfer transfers funds to a connected account.
+// Returns the Stripe transfer ID. The request's idempotency key is derived
+// from payoutRef, so sending the same payout again cannot pay it twice.
+func createStripeTransfer(stripeKey, accountID string, amountCents int64, currency, payoutRef string) (string, error) {
+	if stripeKey == "" {
+		return "", fmt.Errorf("payouts not configured")
+	}
+	if accountID == "" {
+		return "", fmt.Errorf("payout account has no connected Stripe account")
+	}
+
+	data := url.Values{}
+	data.Set("amount", fmt.Sprintf("%d", amountCents))
+	data.Set("currency", strings.ToLower(currency))
+	data.Set("destination", accountID)
+	data.Set("transfer_group", payoutRef)
+	data.Set("metadata[payout_id]", payoutRef)
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := stripePost(stripeKey, stripeAPI+"/transfers", data, "payout-"+payoutRef, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// findStripeTransfer returns the ID of the transfer made for payoutRef, or
+// "" if Stripe has none.
+func findStripeTransfer(stripeKey, payoutRef string) (string, error) {
+	req, err := http.NewRequest("GET", stripeAPI+"/transfers?"+url.Values{"transfer_group": {payoutRef}}.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.SetBasicAuth(stripeKey, "")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("stripe request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("stripe error (%d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("parse response: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return "", nil
+	}
+	return result.Data[0].ID, nil
+}
+
+// errStripeOutcomeUnknown marks a Stripe request that may have taken effect
+// even though it failed: it failed in transit, Stripe answered 5xx, or the
+// response could not be read.
+var errStripeOutcomeUnknown = errors.New("stripe outcome unknown")
+
+// stripePost sends a form-encoded POST to the Stripe API and decodes the
+// response. A non-empty idempotencyKey is sent as the Idempotency-Key header.
+func stripePost(stripeKey, endpoint string, data url.Values, idempotencyKey string, out any) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.SetBasicAuth(stripeKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: stripe request: %v", errStripeOutcomeUnknown, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: read response: %v", errStripeOutcomeUnknown, err)
+	}
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: stripe error (%d): %s", errStripeOutcomeUnknown, resp.StatusCode, string(respBody))
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("stripe error (%d): %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("%w: parse response: %v", errStripeOutcomeUnknown, err)
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/payout"
+)
+
+// =============================================================================
+// Creator Earnings (InvoicePaid command)
+// =============================================================================
+
+// recordCreatorEarnings writes creator earnings ledger entries for a paid invoice.
+// Each billed deployment is attributed to its template's creator, and the
+// platform fee is deducted using the configured revenue share.
+// Idempotent: an invoice that already has ledger entries is skipped.
+func recordCreatorEarnings(ctx context.Context, deps *Deps, data map[string]any) error {
+	store := deps.Store
+	logger := deps.Logger
+
+	invoiceRef := strVal(data["reference_id"])
+	payerID, _ := toInt64(data["user_id"])
+	currency := strVal(data["currency"])
+
+	existing, err := store.List(ctx, "creator_earnings", []Filter{
+		{Field: "invoice_id", Value: invoiceRef},
+	}, Page{Limit: 1})
+	if err != nil {
+		return fmt.Errorf("check existing earnings: %w", err)
+	}
+	if len(existing) > 0 {
+		logger.Debug("earnings already recorded", "invoice", invoiceRef)
+		return nil
+	}
+
+	lines := parseInvoiceLines(data["items"])
+	attributions := map[string]payout.Attribution{}
+	for _, line := range lines {
+		if line.DeploymentID == "" {
+			continue // Metered usage isn't template revenue
+		}
+		depl, err := store.Get(ctx, "deployments", line.DeploymentID)
+		if err != nil {
+			logger.Warn("invoice line references unknown deployment", "invoice", invoiceRef, "deployment", line.DeploymentID)
+			continue
+		}
+		tmplID, ok := toInt64(depl["template_id"])
+		if !ok || tmplID == 0 {
+			continue
+		}
+		tmpl, err := store.GetByID(ctx, "templates", int(tmplID))
+		if err != nil {
+			continue
+		}
+		creatorID, _ := toInt64(tmpl["creator_id"])
+		attributions[line.DeploymentID] = payout.Attribution{CreatorID: int(creatorID), TemplateID: int(tmplID)}
+	}
+
+	feeBps := platformFeeBps(deps)
+	earnings := payout.ComputeEarnings(lines, attributions, int(payerID), feeBps)
+	for _, e := range earnings {
+		if _, err := store.Create(ctx, "creator_earnings", map[string]any{
+			"creator_id":    e.CreatorID,
+			"invoice_id":    invoiceRef,
+			"deployment_id": e.DeploymentID,
+			"template_id":   e.TemplateID,
+			"gross_cents":   e.GrossCents,
+			"fee_cents":     e.FeeCents,
+			"net_cents":     e.NetCents,
+			"currency":      currency,
+			"status":        string(payout.LedgerPending),
+		}); err != nil {
+			return fmt.Errorf("record earning for %s: %w", e.DeploymentID, err)
+		}
+	}
+
+	logger.Info("creator earnings recorded", "invoice", invoiceRef, "entries", len(earnings), "fee_bps", feeBps)
+	return nil
+}
+
+// platformFeeBps returns the configured platform fee, falling back to the default.
+func platformFeeBps(deps *Deps) int {
+	if bps, ok := deps.Extra["platform_fee_bps"].(int); ok && payout.ValidateFeeBps(bps) == nil {
+		return bps
+	}
+	return payout.DefaultPlatformFeeBps
+}
+
+// parseInvoiceLines decodes invoice items (raw JSON or already-parsed) into lines.
+func parseInvoiceLines(v any) []payout.InvoiceLine {
+	var raw []byte
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		raw = []byte(val)
+	case []byte:
+		raw = val
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil
+		}
+		raw = b
+	}
+	var lines []payout.InvoiceLine
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return nil
+	}
+	return lines
+}
+
+// =============================================================================
+// Payout Scheduler
+// =============================================================================
+
+// PayoutScheduler periodically pays out pending creator earnings to
+// creators with an active payout account, respecting each account's
+// schedule and minimum payout threshold.
+type PayoutScheduler struct {
+	store     *Store
+	stripeKey string
+	interval  time.Duration
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewPayoutScheduler(store *Store, stripeKey string, interval time.Duration, logger *slog.Logger) *PayoutScheduler {
+	if interval == 0 {
+		interval = 6 * time.Hour
+	}
+	return &PayoutScheduler{
+		store:     store,
+		stripeKey: stripeKey,
+		interval:  interval,
+		logger:    logger.With("component", "payout_scheduler"),
+	}
+}
+
+func (ps *PayoutScheduler) Start() {
+	ps.ctx, ps.cancel = context.WithCancel(context.Background())
+	ps.wg.Add(1)
+	go ps.run()
+	ps.logger.Info("payout scheduler started", "interval", ps.interval)
+}
+
+func (ps *PayoutScheduler) Stop() {
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+	ps.wg.Wait()
+}
+
+func (ps *PayoutScheduler) run() {
+	defer ps.wg.Done()
+	ps.payoutAll()
+
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ps.ctx.Done():
+			return
+		case <-ticker.C:
+			ps.payoutAll()
+		}
+	}
+}
+
+func (ps *PayoutScheduler) payoutAll() {
+	defer ps.store.LoopMetrics().Time("payout_scheduler")()
+	if ps.stripeKey == "" {
+		ps.logger.Debug("payouts not configured, skipping")
+		return
+	}
+
+	accounts, err := ps.store.List(ps.ctx, "payout_accounts", []Filter{
+		{Field: "status", Value: "active"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		ps.logger.Error("failed to list payout accounts", "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	ps.reconcilePayouts(now)
+	for _, acct := range accounts {
+		last, _ := parseTime(acct["last_payout_at"])
+		if !payout.IsDue(last, payout.Schedule(strVal(acct["schedule"])), now) {
+			continue
+		}
+		if err := ps.payoutAccount(acct, now); err != nil {
+			ps.logger.Error("payout failed", "account", strVal(acct["reference_id"]), "error", err)
+		}
+	}
+}
+
+// payoutAccount reserves a creator's pending earnings into a payout and
+// transfers the total to their connected Stripe account.
+func (ps *PayoutScheduler) payoutAccount(acct map[string]any, now time.Time) error {
+	ctx := ps.ctx
+	acctRef := strVal(acct["reference_id"])
+	creatorID, _ := toInt64(acct["creator_id"])
+	currency := strVal(acct["currency"])
+
+	pending, err := ps.store.List(ctx, "creator_earnings", []Filter{
+		{Field: "creator_id", Value: creatorID},
+		{Field: "status", Value: string(payout.LedgerPending)},
+	}, Page{Limit: 10000})
+	if err != nil {
+		return fmt.Errorf("list pending earnings: %w", err)
+	}
+
+	var balance int64
+	var earningRefs []string
+	for _, e := range pending {
+		if c := strVal(e["currency"]); c != "" && c != currency {
+			continue
+		}
+		net, _ := toInt64(e["net_cents"])
+		balance += net
+		earningRefs = append(earningRefs, strVal(e["reference_id"]))
+	}
+
+	minimum, _ := toInt64(acct["minimum_payout_cents"])
+	if !payout.ShouldPayout(balance, minimum) {
+		return nil
+	}
+
+	row, err := ps.store.Create(ctx, "payouts", map[string]any{
+		"creator_id":        creatorID,
+		"payout_account_id": acctRef,
+		"amount_cents":      balance,
+		"currency":          currency,
+		"earnings_count":    len(earningRefs),
+	})
+	if err != nil {
+		return fmt.Errorf("create payout: %w", err)
+	}
+	payoutRef := strVal(row["reference_id"])
+
+	// Reserve earnings so the next run doesn't count them again
+	for _, ref := range earningRefs {
+		if _, err := ps.store.Update(ctx, "creator_earnings", ref, map[string]any{
+			"status":    string(payout.LedgerInPayout),
+			"payout_id": payoutRef,
+		}); err != nil {
+			ps.failPayout(payoutRef, fmt.Sprintf("reserve earnings: %v", err))
+			return fmt.Errorf("reserve earnings for %s: %w", payoutRef, err)
+		}
+	}
+
+	return ps.settlePayout(row, acct, now)
+}
+
+// settlePayout transfers a reserved payout to the creator's Stripe account.
+// When the transfer's outcome is unknown, the payout stays pending with its
+// earnings reserved, and reconcilePayouts settles it on a later run.
+func (ps *PayoutScheduler) settlePayout(p, acct map[string]any, now time.Time) error {
+	payoutRef := strVal(p["reference_id"])
+	amount, _ := toInt64(p["amount_cents"])
+
+	transferID, err := createStripeTransfer(ps.stripeKey, strVal(acct["stripe_account_id"]), amount, strVal(p["currency"]), payoutRef)
+	switch {
+	case errors.Is(err, errStripeOutcomeUnknown):
+		ps.store.Update(ps.ctx, "payouts", payoutRef, map[string]any{"error_message": err.Error()})
+		return fmt.Errorf("transfer %s: %w", payoutRef, err)
+	case err != nil:
+		ps.failPayout(payoutRef, err.Error())
+		return fmt.Errorf("transfer %s: %w", payoutRef, err)
+	}
+	return ps.completePayout(payoutRef, strVal(acct["reference_id"]), transferID, amount, now)
+}
+
+// completePayout records a payout's transfer and marks its earnings paid out.
+func (ps *PayoutScheduler) completePayout(payoutRef, acctRef, transferID string, amount int64, now time.Time) error {
+	ctx := ps.ctx
+	nowStr := now.Format(time.RFC3339)
+	ps.store.Update(ctx, "payouts", payoutRef, map[string]any{
+		"stripe_transfer_id": transferID,
+		"paid_at":            nowStr,
+		"error_message":      nil,
+	})
+	if _, _, err := ps.store.Transition(ctx, "payouts", payoutRef, "paid"); err != nil {
+		return fmt.Errorf("mark %s paid: %w", payoutRef, err)
+	}
+	for _, ref := range ps.payoutEarnings(payoutRef) {
+		ps.store.Update(ctx, "creator_earnings", ref, map[string]any{"status": string(payout.LedgerPaidOut)})
+	}
+	ps.store.Update(ctx, "payout_accounts", acctRef, map[string]any{"last_payout_at": nowStr})
+
+	ps.logger.Info("payout completed", "payout", payoutRef, "account", acctRef, "amount_cents", amount)
+	return nil
+}
+
+// failPayout marks a payout failed and releases its earnings back to
+// pending for the next run.
+func (ps *PayoutScheduler) failPayout(payoutRef, reason string) {
+	ctx := ps.ctx
+	ps.store.Update(ctx, "payouts", payoutRef, map[string]any{"error_message": reason})
+	ps.store.Transition(ctx, "payouts", payoutRef, "failed")
+	for _, ref := range ps.payoutEarnings(payoutRef) {
+		ps.store.Update(ctx, "creator_earnings", ref, map[string]any{
+			"status":    string(payout.LedgerPending),
+			"payout_id": nil,
+		})
+	}
+}
+
+// payoutEarnings returns the reference IDs of the earnings reserved by a payout.
+func (ps *PayoutScheduler) payoutEarnings(payoutRef string) []string {
+	rows, err := ps.store.List(ps.ctx, "creator_earnings", []Filter{
+		{Field: "payout_id", Value: payoutRef},
+		{Field: "status", Value: string(payout.LedgerInPayout)},
+	}, Page{Limit: 10000})
+	if err != nil {
+		ps.logger.Error("failed to list payout earnings", "payout", payoutRef, "error", err)
+		return nil
+	}
+	refs := make([]string, len(rows))
+	for i, e := range rows {
+		refs[i] = strVal(e["reference_id"])
+	}
+	return refs
+}
+
+// reconcilePayouts settles payouts an earlier run left pending because the
+// transfer's outcome was unknown. A transfer Stripe already made for the
+// payout (found by its transfer group) completes it; otherwise the transfer
+// is sent again under the same idempotency key.
+func (ps *PayoutScheduler) reconcilePayouts(now time.Time) {
+	pending, err := ps.store.List(ps.ctx, "payouts", []Filter{
+		{Field: "status", Value: "pending"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		ps.logger.Error("failed to list pending payouts", "error", err)
+		return
+	}
+	for _, p := range pending {
+		payoutRef := strVal(p["reference_id"])
+		acct, err := ps.store.Get(ps.ctx, "payout_accounts", strVal(p["payout_account_id"]))
+		if err != nil {
+			ps.logger.Error("payout account not found", "payout", payoutRef, "error", err)
+			continue
+		}
+		transferID, err := findStripeTransfer(ps.stripeKey, payoutRef)
+		if err != nil {
+			ps.logger.Warn("cannot look up payout transfer", "payout", payoutRef, "error", err)
+			continue
+		}
+		if transferID != "" {
+			amount, _ := toInt64(p["amount_cents"])
+			err = ps.completePayout(payoutRef, strVal(acct["reference_id"]), transferID, amount, now)
+		} else {
+			err = ps.settlePayout(p, acct, now)
+		}
+		if err != nil {
+			ps.logger.Error("payout reconciliation failed", "payout", payoutRef, "error", err)
+		}
+	}
+}
+
+// parseTime parses a DB timestamp that may be a string or time.Time.
+// Returns the zero time for NULL or unparseable values.
+func parseTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, !t.IsZero()
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05Z"} {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
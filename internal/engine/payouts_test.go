@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/payout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Payout Scheduler Tests
+// =============================================================================
+
+// fakeStripe serves the transfer endpoints of the Stripe API. When failWith
+// is set, POST /transfers makes the transfer and then answers with that
+// status, the way a response lost after Stripe acted looks to the caller.
+type fakeStripe struct {
+	mu        sync.Mutex
+	transfers map[string]string // transfer_group -> transfer ID
+	keys      []string          // Idempotency-Key of each POST
+	failWith  int
+}
+
+func newFakeStripe(t *testing.T) (*fakeStripe, *httptest.Server) {
+	t.Helper()
+	fs := &fakeStripe{transfers: map[string]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(srv.Close)
+
+	prev := stripeAPI
+	stripeAPI = srv.URL
+	t.Cleanup(func() { stripeAPI = prev })
+	return fs, srv
+}
+
+func (fs *fakeStripe) serve(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r.URL.Path != "/transfers" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "GET" {
+		data := []map[string]string{}
+		if id, ok := fs.transfers[r.URL.Query().Get("transfer_group")]; ok {
+			data = append(data, map[string]string{"id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+		return
+	}
+
+	fs.keys = append(fs.keys, r.Header.Get("Idempotency-Key"))
+	group := r.FormValue("transfer_group")
+	id, ok := fs.transfers[group]
+	if !ok {
+		id = fmt.Sprintf("tr_%d", len(fs.transfers)+1)
+		fs.transfers[group] = id
+	}
+	if fs.failWith != 0 {
+		w.WriteHeader(fs.failWith)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id": id})
+}
+
+func (fs *fakeStripe) posts() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.keys...)
+}
+
+type payoutTest struct {
+	store *Store
+	ps    *PayoutScheduler
+	acct  map[string]any
+}
+
+// newPayoutTest opens a store with a creator who has a connected payout
+// account and 30 USD of pending earnings.
+func newPayoutTest(t *testing.T) *payoutTest {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_creator', 'creator@example.com')`)
+	require.NoError(t, err)
+	creatorID, _ := res.LastInsertId()
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Payout Test", "creator_id": creatorID, "version": "1.0.0",
+		"compose_spec": "services:\n  web:\n    image: nginx\n",
+	})
+	require.NoError(t, err)
+	acct, err := store.Create(ctx, "payout_accounts", map[string]any{
+		"creator_id": creatorID, "stripe_account_id": "acct_creator", "status": "active",
+		"minimum_payout_cents": 1000,
+	})
+	require.NoError(t, err)
+	for _, net := range []int64{1000, 2000} {
+		_, err := store.Create(ctx, "creator_earnings", map[string]any{
+			"creator_id": creatorID, "template_id": tmpl["id"], "net_cents": net,
+		})
+		require.NoError(t, err)
+	}
+
+	ps := NewPayoutScheduler(store, "sk_test", 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps.ctx = ctx
+	return &payoutTest{store: store, ps: ps, acct: acct}
+}
+
+// payout returns the creator's only payout.
+func (pt *payoutTest) payout(t *testing.T) map[string]any {
+	t.Helper()
+	rows, err := pt.store.List(pt.ps.ctx, "payouts", nil, Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	return rows[0]
+}
+
+// earnings returns the status of each of the creator's earnings.
+func (pt *payoutTest) earnings(t *testing.T) []string {
+	t.Helper()
+	var statuses []string
+	require.NoError(t, pt.store.db.Select(&statuses, `SELECT status FROM creator_earnings ORDER BY id`))
+	return statuses
+}
+
+func TestPayoutScheduler_UnknownOutcomeLeavesPayoutPending(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(fs *fakeStripe, srv *httptest.Server)
+	}{
+		{"server error", func(fs *fakeStripe, _ *httptest.Server) { fs.failWith = http.StatusBadGateway }},
+		{"transport error", func(_ *fakeStripe, srv *httptest.Server) { srv.Close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := newPayoutTest(t)
+			fs, srv := newFakeStripe(t)
+			tt.setup(fs, srv)
+
+			err := pt.ps.payoutAccount(pt.acct, time.Now())
+			assert.ErrorIs(t, err, errStripeOutcomeUnknown)
+
+			p := pt.payout(t)
+			assert.Equal(t, "pending", p["status"], "the transfer may have been made, so the payout is not failed")
+			assert.NotEmpty(t, p["error_message"])
+			assert.Empty(t, p["stripe_transfer_id"])
+			ledger := string(payout.LedgerInPayout)
+			assert.Equal(t, []string{ledger, ledger}, pt.earnings(t), "the earnings stay reserved")
+		})
+	}
+}
+
+func TestPayoutScheduler_ReconcileFindsExistingTransfer(t *testing.T) {
+	pt := newPayoutTest(t)
+	fs, _ := newFakeStripe(t)
+
+	// Stripe makes the transfer but the response is lost
+	fs.failWith = http.StatusBadGateway
+	require.Error(t, pt.ps.payoutAccount(pt.acct, time.Now()))
+	payoutRef := strVal(pt.payout(t)["reference_id"])
+	require.Equal(t, []string{"payout-" + payoutRef}, fs.posts())
+
+	fs.failWith = 0
+	pt.ps.reconcilePayouts(time.Now())
+
+	p := pt.payout(t)
+	assert.Equal(t, "paid", p["status"])
+	assert.Equal(t, "tr_1", p["stripe_transfer_id"])
+	assert.Nil(t, p["error_message"])
+	assert.EqualValues(t, 3000, p["amount_cents"])
+	ledger := string(payout.LedgerPaidOut)
+	assert.Equal(t, []string{ledger, ledger}, pt.earnings(t))
+	assert.Len(t, fs.posts(), 1, "the transfer found by its group is not sent again")
+}
+
+func TestPayoutScheduler_ReconcileResendsUnderSameKey(t *testing.T) {
+	pt := newPayoutTest(t)
+	fs, srv := newFakeStripe(t)
+
+	// The first attempt never reaches Stripe
+	srv.Close()
+	require.Error(t, pt.ps.payoutAccount(pt.acct, time.Now()))
+	payoutRef := strVal(pt.payout(t)["reference_id"])
+
+	srv = httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(srv.Close)
+	stripeAPI = srv.URL
+	pt.ps.reconcilePayouts(time.Now())
+
+	assert.Equal(t, []string{"payout-" + payoutRef}, fs.posts())
+	assert.Equal(t, map[string]string{payoutRef: "tr_1"}, fs.transfers)
+	p := pt.payout(t)
+	assert.Equal(t, "paid", p["status"])
+	assert.Equal(t, "tr_1", p["stripe_transfer_id"])
+}
@@ -0,0 +1,559 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/pipeline"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Deployment Pipelines
+// =============================================================================
+//
+// A pipeline orders a customer's deployments of one template into
+// environments. Promoting a stage copies its deployment's template version,
+// variables (with the next stage's overrides on top) and promotedConfig to
+// the next stage's deployment and upgrades it there with UpgradeDeployment.
+// Promotions into a stage that requires approval wait for the deployment's
+// owner. pipeline_promotions keeps the history.
+
+// promotedConfig are the deployment fields a promotion copies besides
+// variables. Sizing (service_overrides, resources_*) and domains stay
+// specific to each environment.
+var promotedConfig = []string{"routing_options", "upgrade_strategy", "canary_policy"}
+
+// pipelineStages reads a pipeline's stages.
+func pipelineStages(row map[string]any) ([]pipeline.Stage, error) {
+	var stages []pipeline.Stage
+	if err := decodeJSONValue(row["stages"], &stages); err != nil {
+		return nil, fmt.Errorf("invalid stages: %w", err)
+	}
+	return stages, nil
+}
+
+// validatePipeline checks a pipeline's stages and that each names a
+// deployment of the pipeline's template belonging to ownerID.
+func validatePipeline(ctx context.Context, store *Store, ownerID int, row map[string]any) error {
+	stages, err := pipelineStages(row)
+	if err != nil {
+		return err
+	}
+	if err := pipeline.Validate(stages); err != nil {
+		return err
+	}
+	templateID := toInt(row["template_id"])
+	for _, s := range stages {
+		depl, err := store.Get(ctx, "deployments", s.Deployment)
+		if err != nil {
+			return fmt.Errorf("stage %s: deployment %s not found", s.Name, s.Deployment)
+		}
+		if customerID, _ := toInt64(depl["customer_id"]); int(customerID) != ownerID {
+			return fmt.Errorf("stage %s: deployment %s not found", s.Name, s.Deployment)
+		}
+		if toInt(depl["template_id"]) != templateID {
+			return fmt.Errorf("stage %s: deployment %s is not a deployment of the pipeline's template", s.Name, s.Deployment)
+		}
+	}
+	return nil
+}
+
+// pipelineBeforeCreate validates a new pipeline.
+func pipelineBeforeCreate(store *Store) BeforeCreateFunc {
+	return func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+		return validatePipeline(ctx, store, authCtx.UserID, data)
+	}
+}
+
+// pipelineBeforeUpdate validates a pipeline with the changes applied. The
+// template cannot change, since every stage deploys it.
+func pipelineBeforeUpdate(store *Store) BeforeUpdateFunc {
+	return func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+		if _, ok := data["template_id"]; ok {
+			return fmt.Errorf("template_id cannot be changed; create a new pipeline")
+		}
+		merged := maps.Clone(existing)
+		maps.Copy(merged, data)
+		return validatePipeline(ctx, store, authCtx.UserID, merged)
+	}
+}
+
+// =============================================================================
+// Promotion Storage
+// =============================================================================
+
+// PipelinePromotion is a request to carry one stage of a pipeline to the
+// next.
+type PipelinePromotion struct {
+	ID               int64          `db:"id"`
+	ReferenceID      string         `db:"reference_id"`
+	PipelineID       int64          `db:"pipeline_id"`
+	FromStage        string         `db:"from_stage"`
+	ToStage          string         `db:"to_stage"`
+	SourceDeployment string         `db:"source_deployment"`
+	TargetDeployment string         `db:"target_deployment"`
+	Version          string         `db:"version"`           // Template version promoted
+	PreviousVersion  string         `db:"previous_version"`  // Target's version when requested
+	ChangedVariables string         `db:"changed_variables"` // JSON list of names; values may be secrets
+	Status           string         `db:"status"`            // pipeline.Status
+	RequestedBy      int64          `db:"requested_by"`
+	DecidedBy        sql.NullInt64  `db:"decided_by"`
+	ErrorMessage     string         `db:"error_message"`
+	CreatedAt        string         `db:"created_at"`
+	DecidedAt        sql.NullString `db:"decided_at"`
+	FinishedAt       sql.NullString `db:"finished_at"`
+}
+
+const pipelinePromotionColumns = `id, reference_id, pipeline_id, from_stage, to_stage, source_deployment,
+	target_deployment, version, previous_version, changed_variables, status, requested_by, decided_by,
+	error_message, created_at, decided_at, finished_at`
+
+// CreatePipelinePromotion records a promotion and fills in its IDs.
+func (s *Store) CreatePipelinePromotion(ctx context.Context, p *PipelinePromotion) error {
+	p.ReferenceID = "promo_" + uuid.New().String()[:8]
+	p.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	if p.ChangedVariables == "" {
+		p.ChangedVariables = "[]"
+	}
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO pipeline_promotions (reference_id, pipeline_id, from_stage, to_stage, source_deployment,
+			target_deployment, version, previous_version, changed_variables, status, requested_by, created_at)
+		VALUES (:reference_id, :pipeline_id, :from_stage, :to_stage, :source_deployment,
+			:target_deployment, :version, :previous_version, :changed_variables, :status, :requested_by, :created_at)`, p)
+	if err != nil {
+		return fmt.Errorf("create pipeline promotion: %w", err)
+	}
+	p.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// GetPipelinePromotion returns a pipeline's promotion, or ErrNotFound.
+func (s *Store) GetPipelinePromotion(ctx context.Context, pipelineID int64, refID string) (*PipelinePromotion, error) {
+	var p PipelinePromotion
+	err := s.db.GetContext(ctx, &p,
+		`SELECT `+pipelinePromotionColumns+` FROM pipeline_promotions WHERE pipeline_id = ? AND reference_id = ?`,
+		pipelineID, refID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("pipeline promotion %s: %w", refID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get pipeline promotion: %w", err)
+	}
+	return &p, nil
+}
+
+// ListPipelinePromotions returns a pipeline's promotions, newest first.
+func (s *Store) ListPipelinePromotions(ctx context.Context, pipelineID int64, limit int) ([]*PipelinePromotion, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	var out []*PipelinePromotion
+	if err := s.db.SelectContext(ctx, &out,
+		`SELECT `+pipelinePromotionColumns+` FROM pipeline_promotions WHERE pipeline_id = ? ORDER BY id DESC LIMIT ?`,
+		pipelineID, limit); err != nil {
+		return nil, fmt.Errorf("list pipeline promotions: %w", err)
+	}
+	return out, nil
+}
+
+// HasActivePromotion reports whether a promotion into a deployment awaits
+// approval or is running.
+func (s *Store) HasActivePromotion(ctx context.Context, deploymentID string) (bool, error) {
+	var n int
+	if err := s.db.GetContext(ctx, &n,
+		`SELECT COUNT(*) FROM pipeline_promotions WHERE target_deployment = ? AND status IN (?, ?)`,
+		deploymentID, pipeline.StatusPendingApproval, pipeline.StatusRunning); err != nil {
+		return false, fmt.Errorf("check active promotions: %w", err)
+	}
+	return n > 0, nil
+}
+
+// DecidePipelinePromotion approves (status running) or rejects a promotion
+// awaiting approval. It reports false when the promotion was no longer
+// awaiting approval.
+func (s *Store) DecidePipelinePromotion(ctx context.Context, id int64, status pipeline.Status, userID int, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE pipeline_promotions SET status = ?, decided_by = ?, decided_at = ? WHERE id = ? AND status = ?`,
+		status, userID, now.UTC().Format(time.RFC3339), id, pipeline.StatusPendingApproval)
+	if err != nil {
+		return false, fmt.Errorf("decide pipeline promotion: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// FinishPipelinePromotion records how a promotion ended.
+func (s *Store) FinishPipelinePromotion(ctx context.Context, id int64, status pipeline.Status, errorMessage string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE pipeline_promotions SET status = ?, error_message = ?, finished_at = ? WHERE id = ?`,
+		status, errorMessage, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("finish pipeline promotion: %w", err)
+	}
+	return nil
+}
+
+// pipelinePromotionJSONAPI renders a promotion as a JSON:API resource object.
+func pipelinePromotionJSONAPI(store *Store, p *PipelinePromotion) map[string]any {
+	changed := []string{}
+	json.Unmarshal([]byte(p.ChangedVariables), &changed)
+	attrs := map[string]any{
+		"from_stage":        p.FromStage,
+		"to_stage":          p.ToStage,
+		"source_deployment": p.SourceDeployment,
+		"target_deployment": p.TargetDeployment,
+		"version":           p.Version,
+		"previous_version":  p.PreviousVersion,
+		"changed_variables": changed,
+		"status":            p.Status,
+		"error_message":     p.ErrorMessage,
+		"created_at":        p.CreatedAt,
+		"decided_at":        p.DecidedAt.String,
+		"finished_at":       p.FinishedAt.String,
+	}
+	if ref, err := store.GetRefIDByIntID("users", int(p.RequestedBy)); err == nil {
+		attrs["requested_by"] = ref
+	}
+	if p.DecidedBy.Valid {
+		if ref, err := store.GetRefIDByIntID("users", int(p.DecidedBy.Int64)); err == nil {
+			attrs["decided_by"] = ref
+		}
+	}
+	return map[string]any{
+		"type":       "pipeline_promotions",
+		"id":         p.ReferenceID,
+		"attributes": attrs,
+	}
+}
+
+// =============================================================================
+// Promotion
+// =============================================================================
+
+// promotionPlan is what promoting one stage into the next changes on the
+// next stage's deployment.
+type promotionPlan struct {
+	source    map[string]any
+	target    map[string]any
+	version   string
+	variables map[string]string
+	changed   []string
+}
+
+// planPromotion works out a promotion from source to target. Both
+// deployments must be running and the source must run the template's
+// current version, the only one that can be deployed. issues lists the
+// variables the target would still be missing or have invalid.
+func planPromotion(ctx context.Context, store *Store, pipe map[string]any, source, target pipeline.Stage) (*promotionPlan, []coredeployment.VariableIssue, error) {
+	plan := &promotionPlan{}
+	for _, s := range []struct {
+		stage pipeline.Stage
+		row   *map[string]any
+	}{{source, &plan.source}, {target, &plan.target}} {
+		depl, err := store.Get(ctx, "deployments", s.stage.Deployment)
+		if err != nil {
+			return nil, nil, fmt.Errorf("stage %s: deployment %s not found", s.stage.Name, s.stage.Deployment)
+		}
+		if status := strVal(depl["status"]); status != "running" {
+			return nil, nil, fmt.Errorf("stage %s: deployment %s is %s, not running", s.stage.Name, s.stage.Deployment, status)
+		}
+		*s.row = depl
+	}
+
+	tmpl, err := store.GetByID(ctx, "templates", toInt(pipe["template_id"]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("template not found")
+	}
+	plan.version = strVal(plan.source["template_version"])
+	if err := pipeline.CheckVersion(plan.version, strVal(tmpl["version"])); err != nil {
+		return nil, nil, fmt.Errorf("stage %s: %w", source.Name, err)
+	}
+
+	sourceVars, err := variableValues(plan.source["variables"])
+	if err != nil {
+		return nil, nil, err
+	}
+	targetVars, err := variableValues(plan.target["variables"])
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.variables, plan.changed = pipeline.Variables(sourceVars, targetVars, target.Overrides)
+
+	promoted := maps.Clone(plan.target)
+	promoted["variables"] = plan.variables
+	issues, err := upgradeVariableIssues(promoted, tmpl)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, issues, nil
+}
+
+// runPromotion applies a promotion's variables and configuration to the
+// target deployment and upgrades it in the background, recording the
+// outcome on the promotion. With the canary strategy the promotion is done
+// once the canary starts; the canary then promotes or aborts the upgrade.
+func runPromotion(ctx context.Context, cfg SetupConfig, p *PipelinePromotion, plan *promotionPlan) error {
+	updates := map[string]any{
+		"variables":       plan.variables,
+		"pending_version": plan.version,
+	}
+	for _, field := range promotedConfig {
+		updates[field] = plan.source[field]
+	}
+	row, err := cfg.Store.Update(ctx, "deployments", p.TargetDeployment, updates)
+	if err != nil {
+		cfg.Store.FinishPipelinePromotion(ctx, p.ID, pipeline.StatusFailed, err.Error())
+		return fmt.Errorf("update deployment: %w", err)
+	}
+
+	cmdRow := maps.Clone(row)
+	go func() {
+		status, message := pipeline.StatusSucceeded, ""
+		if err := cfg.Bus.Dispatch(context.Background(), "UpgradeDeployment", cmdRow); err != nil {
+			status, message = pipeline.StatusFailed, err.Error()
+		}
+		if err := cfg.Store.FinishPipelinePromotion(context.Background(), p.ID, status, message); err != nil {
+			cfg.Logger.Error("failed to record promotion", "promotion", p.ReferenceID, "error", err)
+		}
+	}()
+	return nil
+}
+
+// =============================================================================
+// Promotion Handlers
+// =============================================================================
+
+// loadPipeline loads the pipeline in the request path, writing the problem
+// response when it does not exist.
+func loadPipeline(w http.ResponseWriter, r *http.Request, cfg SetupConfig) (map[string]any, []pipeline.Stage, bool) {
+	if !getAuthContext(r).Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return nil, nil, false
+	}
+	pipe, err := cfg.Store.Get(r.Context(), "deployment_pipelines", mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, ProblemNotFound, "pipeline not found")
+		return nil, nil, false
+	}
+	stages, err := pipelineStages(pipe)
+	if err != nil {
+		writeProblem(w, r, ProblemInternal, err.Error())
+		return nil, nil, false
+	}
+	return pipe, stages, true
+}
+
+// writePlanProblem writes the problem response for a promotion that cannot
+// run.
+func writePlanProblem(w http.ResponseWriter, r *http.Request, issues []coredeployment.VariableIssue, err error) {
+	if err != nil {
+		writeProblem(w, r, ProblemInvalidState, err.Error())
+		return
+	}
+	writeProblemWith(w, r, ProblemValidationFailed,
+		fmt.Sprintf("the promoted deployment would need %d variable(s) supplied or fixed; set them as overrides of the stage", len(issues)),
+		map[string]any{"issues": issues})
+}
+
+// pipelinePromoteHandler serves POST /deployment_pipelines/{id}/promote with
+// {"from": "<stage>"}. Whoever may operate the next stage's deployment can
+// promote into it. The promotion runs at once, or waits for approval when
+// the next stage requires it; either way it is returned with 202.
+func pipelinePromoteHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		pipe, stages, ok := loadPipeline(w, r, cfg)
+		if !ok {
+			return
+		}
+		var req struct {
+			From string `json:"from"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+			writeProblem(w, r, ProblemValidationFailed, "from is required")
+			return
+		}
+		source, target, err := pipeline.Next(stages, req.From)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		targetDepl, err := cfg.Store.Get(ctx, "deployments", target.Deployment)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("stage %s: deployment %s not found", target.Name, target.Deployment))
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, targetDepl, sharing.PermOperate) {
+			return
+		}
+		if active, err := cfg.Store.HasActivePromotion(ctx, target.Deployment); err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		} else if active {
+			writeProblem(w, r, ProblemOperationInProgress, fmt.Sprintf("a promotion into %s is already pending or running", target.Name))
+			return
+		}
+
+		plan, issues, err := planPromotion(ctx, cfg.Store, pipe, source, target)
+		if err != nil || len(issues) > 0 {
+			writePlanProblem(w, r, issues, err)
+			return
+		}
+
+		changed, _ := json.Marshal(plan.changed)
+		if plan.changed == nil {
+			changed = []byte("[]")
+		}
+		p := &PipelinePromotion{
+			PipelineID:       int64(toInt(pipe["id"])),
+			FromStage:        source.Name,
+			ToStage:          target.Name,
+			SourceDeployment: source.Deployment,
+			TargetDeployment: target.Deployment,
+			Version:          plan.version,
+			PreviousVersion:  strVal(plan.target["template_version"]),
+			ChangedVariables: string(changed),
+			Status:           string(pipeline.InitialStatus(target)),
+			RequestedBy:      int64(authCtx.UserID),
+		}
+		if err := cfg.Store.CreatePipelinePromotion(ctx, p); err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		if pipeline.Status(p.Status) == pipeline.StatusRunning {
+			if err := runPromotion(ctx, cfg, p, plan); err != nil {
+				writeProblem(w, r, ProblemInternal, err.Error())
+				return
+			}
+		}
+		cfg.Logger.Info("pipeline promotion requested", "pipeline", strVal(pipe["reference_id"]),
+			"promotion", p.ReferenceID, "from", source.Name, "to", target.Name, "version", p.Version, "status", p.Status)
+
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": pipelinePromotionJSONAPI(cfg.Store, p)})
+	}
+}
+
+// pipelinePromotionsHandler serves GET /deployment_pipelines/{id}/promotions:
+// the pipeline's promotions, newest first, to its owner.
+func pipelinePromotionsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pipe, _, ok := loadPipeline(w, r, cfg)
+		if !ok {
+			return
+		}
+		if ownerID, _ := toInt64(pipe["customer_id"]); int(ownerID) != getAuthContext(r).UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+				limit = n
+			}
+		}
+		ps, err := cfg.Store.ListPipelinePromotions(r.Context(), int64(toInt(pipe["id"])), limit)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list promotions")
+			return
+		}
+		data := make([]map[string]any, 0, len(ps))
+		for _, p := range ps {
+			data = append(data, pipelinePromotionJSONAPI(cfg.Store, p))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
+
+// pipelineDecisionHandler serves
+// POST /deployment_pipelines/{id}/promotions/{promotion}/approve and
+// .../reject. Only whoever may manage the target deployment decides. An
+// approved promotion is planned again, so it carries the source's current
+// variables; it is refused while the source no longer runs the version
+// that was requested.
+func pipelineDecisionHandler(cfg SetupConfig, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		pipe, stages, ok := loadPipeline(w, r, cfg)
+		if !ok {
+			return
+		}
+		p, err := cfg.Store.GetPipelinePromotion(ctx, int64(toInt(pipe["id"])), mux.Vars(r)["promotion"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "promotion not found")
+			return
+		}
+		targetDepl, err := cfg.Store.Get(ctx, "deployments", p.TargetDeployment)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("deployment %s not found", p.TargetDeployment))
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, targetDepl, sharing.PermManage) {
+			return
+		}
+		if pipeline.Status(p.Status) != pipeline.StatusPendingApproval {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("promotion is %s, not awaiting approval", p.Status))
+			return
+		}
+
+		var plan *promotionPlan
+		status := pipeline.StatusRejected
+		if approve {
+			source, target, err := pipeline.Next(stages, p.FromStage)
+			if err != nil || target.Deployment != p.TargetDeployment {
+				writeProblem(w, r, ProblemInvalidState, "the pipeline's stages changed since the promotion was requested; reject it and promote again")
+				return
+			}
+			var issues []coredeployment.VariableIssue
+			plan, issues, err = planPromotion(ctx, cfg.Store, pipe, source, target)
+			if err != nil || len(issues) > 0 {
+				writePlanProblem(w, r, issues, err)
+				return
+			}
+			if plan.version != p.Version {
+				writeProblem(w, r, ProblemInvalidState,
+					fmt.Sprintf("stage %s now runs %s, not the requested %s; reject this promotion and promote again", p.FromStage, plan.version, p.Version))
+				return
+			}
+			status = pipeline.StatusRunning
+		}
+
+		now := time.Now().UTC()
+		decided, err := cfg.Store.DecidePipelinePromotion(ctx, p.ID, status, authCtx.UserID, now)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		if !decided {
+			writeProblem(w, r, ProblemInvalidState, "promotion was already decided")
+			return
+		}
+		p.Status = string(status)
+		p.DecidedBy = sql.NullInt64{Int64: int64(authCtx.UserID), Valid: true}
+		p.DecidedAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+		if approve {
+			if err := runPromotion(ctx, cfg, p, plan); err != nil {
+				writeProblem(w, r, ProblemInternal, err.Error())
+				return
+			}
+		}
+		cfg.Logger.Info("pipeline promotion decided", "promotion", p.ReferenceID, "status", p.Status)
+
+		writeJSON(w, http.StatusOK, map[string]any{"data": pipelinePromotionJSONAPI(cfg.Store, p)})
+	}
+}
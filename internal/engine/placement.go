@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Creator Placement Rules
+// =============================================================================
+//
+// A template creator reselling their own capacity can pin customers to nodes
+// and templates to groups of nodes. Rules only name the creator's own nodes
+// and templates, and only apply to deployments of the creator's templates
+// created without node_id. They are evaluated before co-location and generic
+// scheduling; the rule that decided is kept in placement_rule and, when rules
+// could not place a deployment, the reason in placement_reason.
+
+// placementRuleFromRow converts a placement_rules row to the scheduler's rule.
+func placementRuleFromRow(row map[string]any) scheduler.PlacementRule {
+	r := scheduler.PlacementRule{
+		ID:         strVal(row["reference_id"]),
+		CustomerID: toInt(row["customer_id"]),
+		TemplateID: toInt(row["template_id"]),
+		Priority:   toInt(row["priority"]),
+		Fallback:   boolVal(row["fallback"]),
+	}
+	decodeJSONValue(row["nodes"], &r.Nodes)
+	return r
+}
+
+// validatePlacementRule checks a rule and that its template and nodes belong
+// to its owner.
+func validatePlacementRule(ctx context.Context, store *Store, ownerID int, rule scheduler.PlacementRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if rule.TemplateID != 0 {
+		tmpl, err := store.GetByID(ctx, "templates", rule.TemplateID)
+		if err != nil {
+			return fmt.Errorf("template not found")
+		}
+		if creatorID, _ := toInt64(tmpl["creator_id"]); int(creatorID) != ownerID {
+			return fmt.Errorf("template %s is not yours", strVal(tmpl["reference_id"]))
+		}
+	}
+	for _, ref := range rule.Nodes {
+		node, err := store.Get(ctx, "nodes", ref)
+		if err != nil {
+			return fmt.Errorf("node %s not found", ref)
+		}
+		if creatorID, _ := toInt64(node["creator_id"]); int(creatorID) != ownerID {
+			return fmt.Errorf("node %s not found", ref)
+		}
+	}
+	return nil
+}
+
+// placementRuleBeforeCreate validates a new rule.
+func placementRuleBeforeCreate(store *Store) BeforeCreateFunc {
+	return func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+		return validatePlacementRule(ctx, store, authCtx.UserID, placementRuleFromRow(data))
+	}
+}
+
+// placementRuleBeforeUpdate validates a rule with the changes applied.
+func placementRuleBeforeUpdate(store *Store) BeforeUpdateFunc {
+	return func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+		merged := map[string]any{}
+		for k, v := range existing {
+			merged[k] = v
+		}
+		for k, v := range data {
+			merged[k] = v
+		}
+		return validatePlacementRule(ctx, store, authCtx.UserID, placementRuleFromRow(merged))
+	}
+}
+
+// creatorRules returns a creator's placement rules.
+func creatorRules(ctx context.Context, store *Store, creatorID int) ([]scheduler.PlacementRule, error) {
+	rows, err := store.List(ctx, "placement_rules", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 1000})
+	if err != nil {
+		return nil, fmt.Errorf("list placement rules: %w", err)
+	}
+	rules := make([]scheduler.PlacementRule, 0, len(rows))
+	for _, row := range rows {
+		rules = append(rules, placementRuleFromRow(row))
+	}
+	return rules, nil
+}
+
+// creatorNodes returns a creator's nodes as the scheduler sees them, by
+// reference_id.
+func creatorNodes(ctx context.Context, store *Store, creatorID int) (map[string]domain.Node, error) {
+	rows, err := store.List(ctx, "nodes", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 1000})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	reserved, err := reservedNodeResources(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]domain.Node, len(rows))
+	for _, row := range rows {
+		ref := strVal(row["reference_id"])
+		nodes[ref] = schedulingNode(row, reserved[ref])
+	}
+	return nodes, nil
+}
+
+// creatorPlacement applies the template creator's placement rules to a
+// deployment, choosing among a rule's nodes with strategy. It returns
+// scheduler.ErrPinUnsatisfiable when a rule without fallback cannot place
+// it, and a zero Placement when no rule matches.
+func creatorPlacement(ctx context.Context, store *Store, depl map[string]any, affinity *scheduler.Affinity, strategy scheduler.Strategy) (scheduler.Placement, error) {
+	tid, ok := toInt64(depl["template_id"])
+	if !ok || tid == 0 {
+		return scheduler.Placement{}, nil
+	}
+	tmpl, err := store.GetByID(ctx, "templates", int(tid))
+	if err != nil {
+		return scheduler.Placement{}, nil
+	}
+	creatorID := toInt(tmpl["creator_id"])
+
+	rules, err := creatorRules(ctx, store, creatorID)
+	if err != nil {
+		return scheduler.Placement{}, err
+	}
+	matched := scheduler.MatchRules(rules, toInt(depl["customer_id"]), int(tid))
+	if len(matched) == 0 {
+		return scheduler.Placement{}, nil
+	}
+	nodes, err := creatorNodes(ctx, store, creatorID)
+	if err != nil {
+		return scheduler.Placement{}, err
+	}
+	req := schedulingRequest(ctx, store, depl, affinity)
+	req.Strategy = strategy
+	req.Seed = time.Now().UnixNano()
+	return scheduler.ApplyPlacementRules(matched, nodes, req)
+}
+
+// =============================================================================
+// Diagnostics Handler
+// =============================================================================
+
+// placementRuleCheckHandler handles GET /placement_rules/{id}/check: whether
+// each of the rule's nodes can take a deployment now, and which of the
+// owner's rules it conflicts with. Requirements come from the rule's
+// template, or from ?template= for customer pins.
+func placementRuleCheckHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "placement_rules", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "placement rule not found")
+			return
+		}
+		ownerID, ok := toInt64(row["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		rule := placementRuleFromRow(row)
+
+		// The requirements of a deployment of the template, at its defaults
+		probe := map[string]any{}
+		templateID := rule.TemplateID
+		if ref := r.URL.Query().Get("template"); ref != "" && templateID == 0 {
+			tmpl, err := cfg.Store.Get(ctx, "templates", ref)
+			if err != nil || !templateVisibility(ctx, authCtx, tmpl) {
+				writeProblem(w, r, ProblemNotFound, "template not found")
+				return
+			}
+			templateID = toInt(tmpl["id"])
+		}
+		if templateID != 0 {
+			if tmpl, err := cfg.Store.GetByID(ctx, "templates", templateID); err == nil {
+				probe["template_id"] = templateID
+				probe["resources_cpu_cores"] = tmpl["resources_cpu_cores"]
+				probe["resources_memory_mb"] = tmpl["resources_memory_mb"]
+				probe["resources_disk_mb"] = tmpl["resources_disk_mb"]
+			}
+		}
+
+		nodes, err := creatorNodes(ctx, cfg.Store, authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load nodes")
+			return
+		}
+		diagnoses := scheduler.DiagnoseNodes(rule, nodes, schedulingRequest(ctx, cfg.Store, probe, nil))
+		satisfiable := false
+		for _, d := range diagnoses {
+			satisfiable = satisfiable || d.OK
+		}
+
+		rules, err := creatorRules(ctx, cfg.Store, authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load placement rules")
+			return
+		}
+		conflicts := []scheduler.Conflict{}
+		for _, c := range scheduler.RuleConflicts(rules) {
+			if c.Winner == rule.ID || c.Loser == rule.ID {
+				conflicts = append(conflicts, c)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "placement_rule_checks",
+				"id":   rule.ID,
+				"attributes": map[string]any{
+					"kind":        rule.Kind(),
+					"satisfiable": satisfiable,
+					"fallback":    rule.Fallback,
+					"nodes":       diagnoses,
+					"conflicts":   conflicts,
+				},
+			},
+		})
+	}
+}
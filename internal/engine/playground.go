@@ -0,0 +1,468 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/playground"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Playground Configuration
+// =============================================================================
+//
+// A playground is a short-lived deployment of a published template that a
+// marketplace visitor starts without an account. It runs on the operator's
+// sandbox node, owned by the playground system user, with generated
+// credentials, a temporary subdomain and every service held to the caps. Its
+// expiry is a trial expiry (see expiry.go), so the ExpireDeployment chain
+// stops and deletes it when the TTL ends. playground_sessions records each
+// session, so a visitor who signs up afterwards is credited to it.
+
+// PlaygroundConfig configures template playgrounds.
+type PlaygroundConfig struct {
+	// NodeID is the reference ID of the sandbox node playgrounds run on.
+	NodeID string
+	// TTL is how long a playground runs; see playground.ValidateTTL.
+	TTL time.Duration
+	// MaxSessions caps the playgrounds running at once.
+	MaxSessions int
+	// MaxPerClient caps the playgrounds running at once from one address.
+	MaxPerClient int
+	// Caps bound each service's memory and CPU.
+	Caps playground.Caps
+}
+
+// playgroundPasswordLength is the length of generated credentials.
+const playgroundPasswordLength = 20
+
+// playgroundTokenLength is the length of session tokens.
+const playgroundTokenLength = 32
+
+// playgroundStatsPeriod is the default period of the conversion report.
+const playgroundStatsPeriod = 30 * 24 * time.Hour
+
+// =============================================================================
+// Playground Session Storage
+// =============================================================================
+
+// PlaygroundSession is a visitor's playground.
+type PlaygroundSession struct {
+	ID            int64          `db:"id"`
+	ReferenceID   string         `db:"reference_id"`
+	TokenHash     string         `db:"token_hash"`
+	TemplateID    int            `db:"template_id"`
+	DeploymentID  string         `db:"deployment_id"` // Deployment reference ID; the deployment is gone once reaped
+	ClientAddress string         `db:"client_address"`
+	Credentials   string         `db:"credentials"` // JSON list of the generated variables
+	CreatedAt     string         `db:"created_at"`
+	ExpiresAt     string         `db:"expires_at"`
+	UserID        sql.NullInt64  `db:"user_id"` // The user the session converted to
+	ConvertedAt   sql.NullString `db:"converted_at"`
+}
+
+const playgroundSessionColumns = `id, reference_id, token_hash, template_id, deployment_id, client_address,
+	credentials, created_at, expires_at, user_id, converted_at`
+
+// CreatePlaygroundSession records a new session.
+func (s *Store) CreatePlaygroundSession(ctx context.Context, ps *PlaygroundSession) error {
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO playground_sessions (reference_id, token_hash, template_id, deployment_id, client_address,
+			credentials, created_at, expires_at)
+		VALUES (:reference_id, :token_hash, :template_id, :deployment_id, :client_address,
+			:credentials, :created_at, :expires_at)`, ps)
+	if err != nil {
+		return fmt.Errorf("create playground session: %w", err)
+	}
+	ps.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// GetPlaygroundSession returns the session a token belongs to, or
+// ErrNotFound.
+func (s *Store) GetPlaygroundSession(ctx context.Context, token string) (*PlaygroundSession, error) {
+	var ps PlaygroundSession
+	err := s.db.GetContext(ctx, &ps,
+		`SELECT `+playgroundSessionColumns+` FROM playground_sessions WHERE token_hash = ?`, playground.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("playground session: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get playground session: %w", err)
+	}
+	return &ps, nil
+}
+
+// CountPlaygroundSessions returns the sessions running at now, overall and
+// from one client address.
+func (s *Store) CountPlaygroundSessions(ctx context.Context, clientAddress string, now time.Time) (active, fromClient int, err error) {
+	var counts struct {
+		Active     int `db:"active"`
+		FromClient int `db:"from_client"`
+	}
+	if err := s.db.GetContext(ctx, &counts,
+		`SELECT COUNT(*) AS active, COALESCE(SUM(client_address = ?), 0) AS from_client
+		FROM playground_sessions WHERE expires_at > ?`,
+		clientAddress, now.UTC().Format(time.RFC3339)); err != nil {
+		return 0, 0, fmt.Errorf("count playground sessions: %w", err)
+	}
+	return counts.Active, counts.FromClient, nil
+}
+
+// ConvertPlaygroundSession credits a session to the user who signed up
+// after it. It reports false when the session was already converted.
+func (s *Store) ConvertPlaygroundSession(ctx context.Context, id int64, userID int, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE playground_sessions SET user_id = ?, converted_at = ? WHERE id = ? AND converted_at IS NULL`,
+		userID, now.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return false, fmt.Errorf("convert playground session: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// PlaygroundStats returns each template's sessions created since a time and
+// how many of them converted, most sessions first.
+func (s *Store) PlaygroundStats(ctx context.Context, since time.Time) ([]playground.Stats, error) {
+	var rows []struct {
+		TemplateID  string `db:"template_id"`
+		Sessions    int    `db:"sessions"`
+		Conversions int    `db:"conversions"`
+	}
+	if err := s.db.SelectContext(ctx, &rows,
+		`SELECT t.reference_id AS template_id, COUNT(*) AS sessions, COUNT(p.converted_at) AS conversions
+		FROM playground_sessions p JOIN templates t ON t.id = p.template_id
+		WHERE p.created_at >= ?
+		GROUP BY p.template_id ORDER BY sessions DESC, t.reference_id`,
+		since.UTC().Format(time.RFC3339)); err != nil {
+		return nil, fmt.Errorf("playground stats: %w", err)
+	}
+	out := make([]playground.Stats, len(rows))
+	for i, row := range rows {
+		out[i] = playground.Stats{TemplateID: row.TemplateID, Sessions: row.Sessions, Conversions: row.Conversions}.WithRate()
+	}
+	return out, nil
+}
+
+// =============================================================================
+// Playground Deployments
+// =============================================================================
+
+// startPlayground creates a playground deployment of a template on the
+// sandbox node, records its session and schedules it. It returns the
+// session, the token that reads it and the deployment.
+func startPlayground(ctx context.Context, cfg SetupConfig, tmpl map[string]any, clientAddress string, now time.Time) (*PlaygroundSession, string, map[string]any, error) {
+	pc := cfg.Playground
+	store := cfg.Store
+
+	var vars []domain.Variable
+	if err := decodeJSONValue(tmpl["variables"], &vars); err != nil {
+		return nil, "", nil, fmt.Errorf("invalid template variables: %w", err)
+	}
+	values, credentials, err := playground.Variables(vars, func() string {
+		return randomString(playgroundPasswordLength)
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := checkDeploymentSetup(tmpl, values); err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", playground.ErrNeedsInput, err)
+	}
+	spec, err := compose.ParseComposeSpec(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("invalid compose spec: %w", err)
+	}
+	limits := make(map[string]domain.ServiceOverride, len(spec.Services))
+	for _, svc := range spec.Services {
+		limits[svc.Name] = domain.ServiceOverride{
+			MemoryMB: (svc.Resources.MemoryLimit + 1024*1024 - 1) / (1024 * 1024),
+			CPUCores: svc.Resources.CPULimit,
+		}
+	}
+	overrides := playground.CapServices(limits, pc.Caps)
+
+	node, err := store.Get(ctx, "nodes", pc.NodeID)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("playground node %s: %w", pc.NodeID, err)
+	}
+	if status := strVal(node["status"]); status != "online" {
+		return nil, "", nil, fmt.Errorf("playground node %s is %s", pc.NodeID, status)
+	}
+	baseDomain := strVal(node["base_domain"])
+	if baseDomain == "" {
+		baseDomain = cfg.BaseDomain
+	}
+	if baseDomain == "" {
+		return nil, "", nil, fmt.Errorf("no base domain for playground subdomains")
+	}
+	ownerID, err := store.ResolveUser(ctx, playground.SystemUser, "", "Playground", "")
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ref := playground.RefPrefix + randomString(8)
+	name := playground.DeploymentName(ref)
+	e := playground.Expiry(now.UTC(), pc.TTL)
+	depl, err := store.Create(ctx, "deployments", map[string]any{
+		"name":              name,
+		"template_id":       toInt(tmpl["id"]),
+		"template_version":  strVal(tmpl["version"]),
+		"customer_id":       ownerID,
+		"node_id":           pc.NodeID,
+		"variables":         values,
+		"domains":           []domain.Domain{domain.GenerateDomain(name, baseDomain)},
+		"service_overrides": overrides,
+		"expires_at":        e.ExpiresAt.Format(time.RFC3339),
+		"delete_at":         e.DeleteAt.Format(time.RFC3339),
+		"trial_source":      string(e.Source),
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("create playground deployment: %w", err)
+	}
+	deplRef := strVal(depl["reference_id"])
+	if cfg.Bus != nil {
+		if err := scheduleExpiry(ctx, cfg.Bus, depl, now); err != nil {
+			// Without its expiry the deployment would outlive the session
+			store.Delete(ctx, "deployments", deplRef)
+			return nil, "", nil, fmt.Errorf("schedule playground expiry: %w", err)
+		}
+	}
+
+	credsJSON, _ := json.Marshal(credentials)
+	token := randomString(playgroundTokenLength)
+	ps := &PlaygroundSession{
+		ReferenceID:   ref,
+		TokenHash:     playground.HashToken(token),
+		TemplateID:    toInt(tmpl["id"]),
+		DeploymentID:  deplRef,
+		ClientAddress: clientAddress,
+		Credentials:   string(credsJSON),
+		CreatedAt:     now.UTC().Format(time.RFC3339),
+		ExpiresAt:     e.ExpiresAt.Format(time.RFC3339),
+	}
+	if err := store.CreatePlaygroundSession(ctx, ps); err != nil {
+		return nil, "", nil, err
+	}
+
+	row, cmd, err := store.Transition(ctx, "deployments", deplRef, "scheduled")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("schedule playground deployment: %w", err)
+	}
+	if cmd != "" && cfg.Bus != nil {
+		if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+			cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
+		}
+	}
+	return ps, token, row, nil
+}
+
+// playgroundView renders a session with its deployment; depl is nil once the
+// deployment is reaped. The generated credentials are read from the
+// deployment's variables, so they are gone with it.
+func playgroundView(ctx context.Context, store *Store, ps *PlaygroundSession, depl map[string]any) map[string]any {
+	view := map[string]any{
+		"id":         ps.ReferenceID,
+		"status":     "ended",
+		"created_at": ps.CreatedAt,
+		"expires_at": ps.ExpiresAt,
+		"converted":  ps.ConvertedAt.Valid,
+	}
+	if ref, err := store.GetRefIDByIntID("templates", ps.TemplateID); err == nil {
+		view["template_id"] = ref
+	}
+	if depl == nil {
+		return view
+	}
+	view["status"] = strVal(depl["status"])
+	if msg := strVal(depl["error_message"]); msg != "" {
+		view["error_message"] = msg
+	}
+	if domains := parseDomainsList(depl["domains"]); len(domains) > 0 {
+		view["url"] = "http://" + domains[0].Hostname
+	}
+	var names []string
+	json.Unmarshal([]byte(ps.Credentials), &names)
+	values, _ := variableValues(depl["variables"])
+	creds := make(map[string]string, len(names))
+	for _, name := range names {
+		creds[name] = values[name]
+	}
+	view["credentials"] = creds
+	return view
+}
+
+// clientAddress returns the address a request came from, as reported by
+// the gateway in front of the control plane.
+func clientAddress(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(first)
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// =============================================================================
+// Playground Handlers
+// =============================================================================
+
+// templatePlaygroundHandler handles POST /templates/{id}/playground: anyone
+// can start a playground of a published template. The response carries the
+// session token, which is shown only once.
+func templatePlaygroundHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Playground == nil {
+			writeProblem(w, r, ProblemNotConfigured, "playgrounds are not enabled")
+			return
+		}
+		ctx := r.Context()
+		now := time.Now()
+
+		tmpl, err := cfg.Store.Get(ctx, "templates", mux.Vars(r)["id"])
+		if err != nil || !boolVal(tmpl["published"]) {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+
+		addr := clientAddress(r)
+		active, fromClient, err := cfg.Store.CountPlaygroundSessions(ctx, addr, now)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to count playground sessions")
+			return
+		}
+		if err := playground.Admit(active, fromClient, cfg.Playground.MaxSessions, cfg.Playground.MaxPerClient); err != nil {
+			writeProblem(w, r, ProblemRateLimited, err.Error())
+			return
+		}
+
+		ps, token, depl, err := startPlayground(ctx, cfg, tmpl, addr, now)
+		if errors.Is(err, playground.ErrNeedsInput) {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+		if err != nil {
+			cfg.Logger.Error("failed to start playground", "template", strVal(tmpl["reference_id"]), "error", err)
+			writeProblem(w, r, ProblemUpstreamFailed, "failed to start playground")
+			return
+		}
+		cfg.Logger.Info("playground started", "session", ps.ReferenceID, "template", strVal(tmpl["reference_id"]),
+			"deployment", ps.DeploymentID, "expires_at", ps.ExpiresAt)
+
+		view := playgroundView(ctx, cfg.Store, ps, depl)
+		view["token"] = token
+		writeJSON(w, http.StatusCreated, map[string]any{"data": view})
+	}
+}
+
+// playgroundSessionHandler handles GET /playground/{token}: the session's
+// status, URL and credentials, for the visitor polling while it starts.
+func playgroundSessionHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ps, err := cfg.Store.GetPlaygroundSession(ctx, mux.Vars(r)["token"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "playground session not found")
+			return
+		}
+		depl, err := cfg.Store.Get(ctx, "deployments", ps.DeploymentID)
+		if err != nil {
+			depl = nil
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": playgroundView(ctx, cfg.Store, ps, depl)})
+	}
+}
+
+// playgroundConvertHandler handles POST /playground/{token}/convert: a
+// visitor who signed up credits their playground session to their account.
+func playgroundConvertHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		ctx := r.Context()
+		now := time.Now()
+
+		ps, err := cfg.Store.GetPlaygroundSession(ctx, mux.Vars(r)["token"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "playground session not found")
+			return
+		}
+		created, _ := parseTime(ps.CreatedAt)
+		if err := playground.CanConvert(created, ps.ConvertedAt.Valid, now); err != nil {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+		converted, err := cfg.Store.ConvertPlaygroundSession(ctx, ps.ID, authCtx.UserID, now)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to convert playground session")
+			return
+		}
+		if !converted {
+			writeProblem(w, r, ProblemInvalidState, playground.ErrAlreadyConverted.Error())
+			return
+		}
+		ps.UserID = sql.NullInt64{Int64: int64(authCtx.UserID), Valid: true}
+		ps.ConvertedAt = sql.NullString{String: now.UTC().Format(time.RFC3339), Valid: true}
+		cfg.Logger.Info("playground session converted", "session", ps.ReferenceID, "user", authCtx.ReferenceID)
+
+		depl, err := cfg.Store.Get(ctx, "deployments", ps.DeploymentID)
+		if err != nil {
+			depl = nil
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": playgroundView(ctx, cfg.Store, ps, depl)})
+	}
+}
+
+// adminPlaygroundHandler handles GET /admin/playground: each template's
+// playground sessions and conversions since ?since= (RFC 3339), 30 days by
+// default.
+func adminPlaygroundHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !isAdmin(cfg, authCtx) {
+			writeProblem(w, r, ProblemForbidden, "administrator access required")
+			return
+		}
+		since := time.Now().Add(-playgroundStatsPeriod)
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "since must be an RFC 3339 time")
+				return
+			}
+			since = t
+		}
+		stats, err := cfg.Store.PlaygroundStats(r.Context(), since)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to read playground stats")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": stats,
+			"meta": map[string]any{"since": since.UTC().Format(time.RFC3339)},
+		})
+	}
+}
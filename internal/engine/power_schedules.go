@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/spending"
+)
+
+// =============================================================================
+// Power Schedules
+// =============================================================================
+//
+// A deployment's schedule starts and stops it at cron times, so that e.g. a
+// dev environment only runs (and costs) in working hours. Times are read in
+// the zone of the deployment's node: the node's own timezone, else its
+// creator's preference. A deployment without a node uses its owner's.
+//
+// The next action is kept in schedule_next_at. When the scheduler finds it
+// due, it applies the last action due since then, so a scheduler that was
+// down over a stop and the next start leaves the deployment started.
+
+// deploymentPowerSchedule decodes a deployment's schedule. It returns nil
+// when the deployment has none.
+func deploymentPowerSchedule(v any) (*coredeployment.PowerSchedule, error) {
+	var s coredeployment.PowerSchedule
+	if err := decodeJSONValue(v, &s); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	if s == (coredeployment.PowerSchedule{}) {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// validateDeploymentSchedule checks the schedule of a deployment being
+// created or updated. A null schedule turns scheduling off.
+func validateDeploymentSchedule(v any) error {
+	s, err := deploymentPowerSchedule(v)
+	if err != nil || s == nil {
+		return err
+	}
+	return coredeployment.ValidatePowerSchedule(*s)
+}
+
+// validateNodeTimezone checks a node's timezone, storing its normalized name.
+func validateNodeTimezone(data map[string]any) error {
+	v, ok := data["timezone"]
+	if !ok || v == nil || strVal(v) == "" {
+		return nil
+	}
+	name, _, err := loadTimezone(strVal(v))
+	if err != nil {
+		return err
+	}
+	data["timezone"] = name
+	return nil
+}
+
+// deploymentLocation returns the zone a deployment's schedule is read in.
+func deploymentLocation(ctx context.Context, store *Store, locs *userLocations, depl map[string]any) *time.Location {
+	if nodeRef := strVal(depl["node_id"]); nodeRef != "" {
+		if node, err := store.Get(ctx, "nodes", nodeRef); err == nil {
+			if name := strVal(node["timezone"]); name != "" {
+				if _, loc, err := loadTimezone(name); err == nil {
+					return loc
+				}
+			}
+			return locs.get(ctx, toInt(node["creator_id"]))
+		}
+	}
+	return locs.get(ctx, toInt(depl["customer_id"]))
+}
+
+// PowerScheduler starts and stops deployments on their schedules.
+type PowerScheduler struct {
+	store    *Store
+	bus      *Bus
+	prices   spending.Prices
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewPowerScheduler(store *Store, bus *Bus, prices spending.Prices, interval time.Duration, logger *slog.Logger) *PowerScheduler {
+	if interval == 0 {
+		interval = time.Minute
+	}
+	return &PowerScheduler{
+		store:    store,
+		bus:      bus,
+		prices:   prices,
+		interval: interval,
+		logger:   logger.With("component", "power_scheduler"),
+	}
+}
+
+func (ps *PowerScheduler) Start() {
+	ps.ctx, ps.cancel = context.WithCancel(context.Background())
+	ps.wg.Add(1)
+	go ps.run()
+	ps.logger.Info("power scheduler started", "interval", ps.interval)
+}
+
+func (ps *PowerScheduler) Stop() {
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+	ps.wg.Wait()
+}
+
+func (ps *PowerScheduler) run() {
+	defer ps.wg.Done()
+	ps.checkAll()
+
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ps.ctx.Done():
+			return
+		case <-ticker.C:
+			ps.checkAll()
+		}
+	}
+}
+
+func (ps *PowerScheduler) checkAll() {
+	defer ps.store.LoopMetrics().Time("power_scheduler")()
+	rows, err := ps.store.RawQuery(ps.ctx, `SELECT reference_id FROM deployments
+		WHERE schedule IS NOT NULL AND schedule NOT IN ('', 'null', '{}')
+		AND status NOT IN ('deleting', 'deleted')`)
+	if err != nil {
+		ps.logger.Error("failed to list scheduled deployments", "error", err)
+		return
+	}
+	locs := newUserLocations(ps.store)
+	now := time.Now().UTC()
+	for _, row := range rows {
+		depl, err := ps.store.Get(ps.ctx, "deployments", strVal(row["reference_id"]))
+		if err != nil {
+			continue
+		}
+		ps.checkDeployment(depl, now, deploymentLocation(ps.ctx, ps.store, locs, depl))
+	}
+}
+
+// checkDeployment applies a deployment's due action and records its next one.
+func (ps *PowerScheduler) checkDeployment(depl map[string]any, now time.Time, loc *time.Location) {
+	refID := strVal(depl["reference_id"])
+	sched, err := deploymentPowerSchedule(depl["schedule"])
+	if err != nil || sched == nil {
+		return
+	}
+
+	updates := map[string]any{}
+	if due, ok := parseTime(depl["schedule_next_at"]); ok && !due.After(now) {
+		if action, ok := coredeployment.LastPowerAction(*sched, due.Add(-time.Second), now, loc); ok {
+			if err := ps.apply(depl, action, now); err != nil {
+				ps.logger.Warn("scheduled action not applied", "deployment", refID, "action", action, "error", err)
+				updates["schedule_error"] = fmt.Sprintf("scheduled %s at %s: %s", action, due.In(loc).Format(time.RFC3339), err)
+			} else if strVal(depl["schedule_error"]) != "" {
+				updates["schedule_error"] = nil
+			}
+		}
+	}
+
+	action, next, ok := coredeployment.NextPowerAction(*sched, now, loc)
+	switch {
+	case !ok && strVal(depl["schedule_next_at"]) != "":
+		updates["schedule_next_at"] = nil
+		updates["schedule_next_action"] = nil
+	case ok:
+		if prev, _ := parseTime(depl["schedule_next_at"]); !prev.Equal(next) || strVal(depl["schedule_next_action"]) != string(action) {
+			updates["schedule_next_at"] = next.UTC().Format(time.RFC3339)
+			updates["schedule_next_action"] = string(action)
+		}
+	}
+	if len(updates) > 0 {
+		if _, err := ps.store.Update(ps.ctx, "deployments", refID, updates); err != nil {
+			ps.logger.Error("failed to record schedule", "deployment", refID, "error", err)
+		}
+	}
+}
+
+// apply starts or stops a deployment. A deployment already in the state the
+// action leads to is left alone, as is one that is busy, e.g. starting.
+func (ps *PowerScheduler) apply(depl map[string]any, action coredeployment.PowerAction, now time.Time) error {
+	ctx := ps.ctx
+	refID := strVal(depl["reference_id"])
+	status := strVal(depl["status"])
+
+	var target string
+	switch action {
+	case coredeployment.PowerStart:
+		if status != "stopped" {
+			return nil
+		}
+		if err := ps.checkStart(ctx, depl, now); err != nil {
+			return err
+		}
+		target = "starting"
+	case coredeployment.PowerStop:
+		if status != "running" {
+			return nil
+		}
+		target = "stopping"
+	}
+	if migrating, err := ps.store.HasActiveDeploymentMigration(ctx, refID); err != nil {
+		return err
+	} else if migrating {
+		return fmt.Errorf("deployment is being migrated")
+	}
+
+	row, cmd, err := ps.store.Transition(ctx, "deployments", refID, target)
+	if err != nil {
+		return err
+	}
+	ps.logger.Info("scheduled action applied", "deployment", refID, "action", action)
+	if cmd != "" && ps.bus != nil {
+		if _, err := ps.bus.Enqueue(ctx, cmd, row); err != nil {
+			ps.logger.Error("command enqueue failed", "command", cmd, "deployment", refID, "error", err)
+		}
+	}
+	return nil
+}
+
+// checkStart applies the checks of a start request to a scheduled start.
+// The customer's plan defaults give the quota.
+func (ps *PowerScheduler) checkStart(ctx context.Context, depl map[string]any, now time.Time) error {
+	if migrating, err := ps.store.HasActiveVolumeMigration(ctx, strVal(depl["reference_id"])); err != nil {
+		return err
+	} else if migrating {
+		return fmt.Errorf("volumes are being migrated")
+	}
+	if e, ok := deploymentExpiry(depl); ok && e.Expired(now) {
+		return fmt.Errorf("trial expired")
+	}
+	customerID := toInt(depl["customer_id"])
+	if err := checkSpendingLimit(ctx, ps.store, ps.prices, customerID, now); err != nil {
+		return err
+	}
+	return checkDeploymentQuota(ctx, ps.store, AuthContext{}, customerID, depl)
+}
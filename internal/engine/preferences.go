@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/timezone"
+)
+
+// =============================================================================
+// User Preferences
+// =============================================================================
+//
+// Users choose the time zone their schedules are written in. Maintenance
+// windows are evaluated in the deployment owner's zone and node housekeeping
+// in the node creator's, so a schedule means the same thing to everyone who
+// reads it. Users without a preference get UTC.
+
+// loadTimezone validates a zone name and loads its rules.
+func loadTimezone(name string) (string, *time.Location, error) {
+	name, err := timezone.Normalize(name)
+	if err != nil {
+		return "", nil, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: unknown zone %q", timezone.ErrInvalidTimezone, name)
+	}
+	return name, loc, nil
+}
+
+// UserTimezone returns a user's time zone preference, or "" if unset.
+func (s *Store) UserTimezone(ctx context.Context, userID int) (string, error) {
+	var name string
+	if err := s.db.GetContext(ctx, &name, `SELECT timezone FROM users WHERE id = ?`, userID); err != nil {
+		return "", fmt.Errorf("get user timezone: %w", err)
+	}
+	return name, nil
+}
+
+// SetUserTimezone stores a user's time zone preference.
+func (s *Store) SetUserTimezone(ctx context.Context, userID int, name string) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE users SET timezone = ?, updated_at = datetime('now') WHERE id = ?`, name, userID); err != nil {
+		return fmt.Errorf("set user timezone: %w", err)
+	}
+	return nil
+}
+
+// UserLocation returns the location a user's schedules are evaluated in.
+// Users without a usable preference get UTC.
+func (s *Store) UserLocation(ctx context.Context, userID int) *time.Location {
+	name, err := s.UserTimezone(ctx, userID)
+	if err != nil || name == "" {
+		return time.UTC
+	}
+	_, loc, err := loadTimezone(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// userLocations caches users' locations for one pass over many rows.
+type userLocations struct {
+	store *Store
+	locs  map[int]*time.Location
+}
+
+func newUserLocations(store *Store) *userLocations {
+	return &userLocations{store: store, locs: map[int]*time.Location{}}
+}
+
+func (u *userLocations) get(ctx context.Context, userID int) *time.Location {
+	loc, ok := u.locs[userID]
+	if !ok {
+		loc = u.store.UserLocation(ctx, userID)
+		u.locs[userID] = loc
+	}
+	return loc
+}
+
+// preferencesHandler handles GET and PATCH /preferences for the current user.
+func preferencesHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		ctx := r.Context()
+
+		if r.Method == http.MethodPatch {
+			var req struct {
+				Timezone *string `json:"timezone"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+			if req.Timezone != nil {
+				name, _, err := loadTimezone(*req.Timezone)
+				if err != nil {
+					writeProblem(w, r, ProblemValidationFailed, err.Error())
+					return
+				}
+				if err := cfg.Store.SetUserTimezone(ctx, authCtx.UserID, name); err != nil {
+					writeProblem(w, r, ProblemInternal, "failed to save preferences")
+					return
+				}
+			}
+		}
+
+		name, err := cfg.Store.UserTimezone(ctx, authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load preferences")
+			return
+		}
+		if name == "" {
+			name = timezone.Default
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "preferences",
+				"id":   "current",
+				"attributes": map[string]any{
+					"timezone": name,
+					"zone":     timezone.Describe(cfg.Store.UserLocation(ctx, authCtx.UserID), time.Now()),
+				},
+			},
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Problem Details (RFC 7807)
+// =============================================================================
+//
+// Every API error is an application/problem+json document whose type is one
+// of the entries in problemCatalog. The type URI and code of an entry never
+// change, so clients can branch on them; the title is its human summary and
+// detail explains the specific occurrence. Retryable tells clients whether the
+// same request may succeed later without changes.
+//
+// For JSON:API clients the document also carries the error as an "errors"
+// member, in the shape every endpoint returned before problem details.
+
+// problemTypeBase prefixes every problem type URI. The URIs resolve to the
+// catalog entry served by problemHandler.
+const problemTypeBase = "/api/v1/problems/"
+
+// ProblemType is an entry of the error catalog.
+type ProblemType struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Retryable   bool   `json:"retryable"`
+	Description string `json:"description"`
+}
+
+// URI returns the stable type URI of the problem.
+func (p ProblemType) URI() string {
+	return problemTypeBase + p.Code
+}
+
+var (
+	ProblemInvalidRequest = ProblemType{
+		Code: "invalid_request", Status: http.StatusBadRequest, Title: "Invalid request",
+		Description: "The request body or parameters could not be parsed.",
+	}
+	ProblemValidationFailed = ProblemType{
+		Code: "validation_failed", Status: http.StatusBadRequest, Title: "Validation failed",
+		Description: "The request was well-formed but a field is missing or has an invalid value; detail names it.",
+	}
+	ProblemAuthenticationRequired = ProblemType{
+		Code: "authentication_required", Status: http.StatusUnauthorized, Title: "Authentication required",
+		Description: "The request has no valid credentials.",
+	}
+	ProblemForbidden = ProblemType{
+		Code: "forbidden", Status: http.StatusForbidden, Title: "Forbidden",
+		Description: "The caller is authenticated but may not access this resource or perform this action.",
+	}
+	ProblemFeatureNotInPlan = ProblemType{
+		Code: "feature_not_in_plan", Status: http.StatusForbidden, Title: "Feature not in plan",
+		Description: "The caller's plan does not include the feature; detail names the flag. GET /features lists what the plan includes.",
+	}
+	ProblemSpendingLimitReached = ProblemType{
+		Code: "spending_limit_reached", Status: http.StatusPaymentRequired, Title: "Spending limit reached",
+		Description: "The account has spent its monthly limit and its hard stop pauses new metered activity. Raise the limit at /spending-limit or wait until next month.",
+	}
+	ProblemQuotaExceeded = ProblemType{
+		Code: "quota_exceeded", Status: http.StatusConflict, Title: "Quota exceeded",
+		Description: "The customer's active deployments would use more CPU, memory or disk than their plan allows; the quota member has the quota, current usage and the request. Stop or delete deployments, or upgrade the plan.",
+	}
+	ProblemNotFound = ProblemType{
+		Code: "not_found", Status: http.StatusNotFound, Title: "Not found",
+		Description: "The resource does not exist or is not visible to the caller.",
+	}
+	ProblemAlreadyExists = ProblemType{
+		Code: "already_exists", Status: http.StatusConflict, Title: "Already exists",
+		Description: "A resource with the same unique value (name, hostname, ...) already exists.",
+	}
+	ProblemInvalidState = ProblemType{
+		Code: "invalid_state", Status: http.StatusConflict, Title: "Invalid state",
+		Description: "The resource's current state does not allow this action; detail names the state.",
+	}
+	ProblemOperationInProgress = ProblemType{
+		Code: "operation_in_progress", Status: http.StatusConflict, Title: "Operation in progress",
+		Retryable:   true,
+		Description: "Another operation on the resource has not finished; retry once it completes.",
+	}
+	ProblemPayloadTooLarge = ProblemType{
+		Code: "payload_too_large", Status: http.StatusRequestEntityTooLarge, Title: "Payload too large",
+		Description: "The request body exceeds the size limit.",
+	}
+	ProblemRateLimited = ProblemType{
+		Code: "rate_limited", Status: http.StatusTooManyRequests, Title: "Too many requests",
+		Retryable:   true,
+		Description: "The caller or the installation is at a limit on concurrent use; detail names it. Retry later.",
+	}
+	ProblemIdempotencyKeyReused = ProblemType{
+		Code: "idempotency_key_reused", Status: http.StatusUnprocessableEntity, Title: "Idempotency key reused",
+		Description: "The Idempotency-Key was already used for a request with a different method, path or body.",
+	}
+	ProblemInternal = ProblemType{
+		Code: "internal_error", Status: http.StatusInternalServerError, Title: "Internal error",
+		Retryable:   true,
+		Description: "The server failed to complete the request. Retrying is safe for idempotent requests.",
+	}
+	ProblemUpstreamFailed = ProblemType{
+		Code: "upstream_failed", Status: http.StatusBadGateway, Title: "Upstream service failed",
+		Retryable:   true,
+		Description: "A service the request depends on (payment provider, node) returned an error.",
+	}
+	ProblemNotConfigured = ProblemType{
+		Code: "not_configured", Status: http.StatusServiceUnavailable, Title: "Feature not configured",
+		Description: "The feature is not enabled on this installation.",
+	}
+	ProblemReadOnly = ProblemType{
+		Code: "read_only", Status: http.StatusServiceUnavailable, Title: "Read-only replica",
+		Description: "The server is a read-only replica serving public endpoints; send writes and authenticated requests to the control plane.",
+	}
+	ProblemStandby = ProblemType{
+		Code: "standby", Status: http.StatusServiceUnavailable, Title: "Cold standby",
+		Description: "The server is a cold standby receiving the primary's snapshots; it serves requests only once promoted.",
+	}
+	ProblemVersionSunset = ProblemType{
+		Code: "version_sunset", Status: http.StatusGone, Title: "API version sunset",
+		Description: "The API version in the path is no longer served; the Link header names its successor.",
+	}
+)
+
+// problemCatalog lists every problem type the API returns.
+var problemCatalog = []ProblemType{
+	ProblemInvalidRequest,
+	ProblemValidationFailed,
+	ProblemAuthenticationRequired,
+	ProblemForbidden,
+	ProblemFeatureNotInPlan,
+	ProblemSpendingLimitReached,
+	ProblemQuotaExceeded,
+	ProblemNotFound,
+	ProblemAlreadyExists,
+	ProblemInvalidState,
+	ProblemOperationInProgress,
+	ProblemPayloadTooLarge,
+	ProblemRateLimited,
+	ProblemIdempotencyKeyReused,
+	ProblemInternal,
+	ProblemUpstreamFailed,
+	ProblemNotConfigured,
+	ProblemReadOnly,
+	ProblemStandby,
+	ProblemVersionSunset,
+}
+
+// problemByCode looks up a catalog entry.
+func problemByCode(code string) (ProblemType, bool) {
+	for _, p := range problemCatalog {
+		if p.Code == code {
+			return p, true
+		}
+	}
+	return ProblemType{}, false
+}
+
+// writeProblem writes p as an application/problem+json response. detail
+// describes this occurrence; instance is the request path.
+func writeProblem(w http.ResponseWriter, r *http.Request, p ProblemType, detail string) {
+	writeProblemWith(w, r, p, detail, nil)
+}
+
+// writeProblemWith writes p like writeProblem, adding extension members that
+// describe the occurrence further (e.g. which fields to fix).
+func writeProblemWith(w http.ResponseWriter, r *http.Request, p ProblemType, detail string, extensions map[string]any) {
+	body := map[string]any{
+		"type":      p.URI(),
+		"title":     p.Title,
+		"status":    p.Status,
+		"detail":    detail,
+		"code":      p.Code,
+		"retryable": p.Retryable,
+		"errors": []map[string]any{{
+			"status": strconv.Itoa(p.Status),
+			"code":   p.Code,
+			"title":  p.Title,
+			"detail": detail,
+		}},
+	}
+	if r != nil {
+		body["instance"] = r.URL.Path
+	}
+	if reqID := w.Header().Get("X-Request-ID"); reqID != "" {
+		body["request_id"] = reqID
+	}
+	for k, v := range extensions {
+		if _, taken := body[k]; !taken {
+			body[k] = v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// problemsHandler handles GET /api/v1/problems, the error catalog.
+func problemsHandler(w http.ResponseWriter, _ *http.Request) {
+	data := make([]map[string]any, 0, len(problemCatalog))
+	for _, p := range problemCatalog {
+		data = append(data, problemJSONAPI(p))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
+
+// problemHandler handles GET /api/v1/problems/{code}, the target of type URIs.
+func problemHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := problemByCode(mux.Vars(r)["code"])
+	if !ok {
+		writeProblem(w, r, ProblemNotFound, "unknown problem type")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": problemJSONAPI(p)})
+}
+
+// problemJSONAPI renders a catalog entry as a JSON:API resource object.
+func problemJSONAPI(p ProblemType) map[string]any {
+	return map[string]any{
+		"type": "problems",
+		"id":   p.Code,
+		"attributes": map[string]any{
+			"type":        p.URI(),
+			"status":      p.Status,
+			"title":       p.Title,
+			"retryable":   p.Retryable,
+			"description": p.Description,
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Problem Details Tests
+// =============================================================================
+
+func TestProblemCatalog(t *testing.T) {
+	// Codes, statuses and type URIs are part of the API contract
+	want := map[string]int{
+		"invalid_request":         http.StatusBadRequest,
+		"validation_failed":       http.StatusBadRequest,
+		"authentication_required": http.StatusUnauthorized,
+		"forbidden":               http.StatusForbidden,
+		"feature_not_in_plan":     http.StatusForbidden,
+		"spending_limit_reached":  http.StatusPaymentRequired,
+		"quota_exceeded":          http.StatusConflict,
+		"not_found":               http.StatusNotFound,
+		"already_exists":          http.StatusConflict,
+		"invalid_state":           http.StatusConflict,
+		"operation_in_progress":   http.StatusConflict,
+		"payload_too_large":       http.StatusRequestEntityTooLarge,
+		"rate_limited":            http.StatusTooManyRequests,
+		"idempotency_key_reused":  http.StatusUnprocessableEntity,
+		"internal_error":          http.StatusInternalServerError,
+		"upstream_failed":         http.StatusBadGateway,
+		"not_configured":          http.StatusServiceUnavailable,
+		"read_only":               http.StatusServiceUnavailable,
+		"standby":                 http.StatusServiceUnavailable,
+		"version_sunset":          http.StatusGone,
+	}
+	require.Len(t, problemCatalog, len(want))
+	for _, p := range problemCatalog {
+		status, ok := want[p.Code]
+		require.True(t, ok, "unexpected problem type %q", p.Code)
+		assert.Equal(t, status, p.Status, p.Code)
+		assert.Equal(t, "/api/v1/problems/"+p.Code, p.URI())
+		assert.NotEmpty(t, p.Title, p.Code)
+		assert.NotEmpty(t, p.Description, p.Code)
+
+		found, ok := problemByCode(p.Code)
+		assert.True(t, ok)
+		assert.Equal(t, p, found)
+	}
+	_, ok := problemByCode("no_such_problem")
+	assert.False(t, ok)
+}
+
+func TestWriteProblem(t *testing.T) {
+	for _, p := range problemCatalog {
+		t.Run(p.Code, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/deployments/depl_1", nil)
+			w := httptest.NewRecorder()
+			w.Header().Set("X-Request-ID", "req_1")
+			writeProblem(w, r, p, "something happened")
+
+			assert.Equal(t, p.Status, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, p.URI(), body["type"])
+			assert.Equal(t, p.Title, body["title"])
+			assert.Equal(t, float64(p.Status), body["status"])
+			assert.Equal(t, "something happened", body["detail"])
+			assert.Equal(t, p.Code, body["code"])
+			assert.Equal(t, p.Retryable, body["retryable"])
+			assert.Equal(t, "/api/v1/deployments/depl_1", body["instance"])
+			assert.Equal(t, "req_1", body["request_id"])
+
+			errs := body["errors"].([]any)
+			require.Len(t, errs, 1, "JSON:API clients get the error too")
+			assert.Equal(t, p.Code, errs[0].(map[string]any)["code"])
+		})
+	}
+}
+
+func TestWriteProblemWith_KeepsStandardMembers(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/v1/deployments", nil)
+	w := httptest.NewRecorder()
+	writeProblemWith(w, r, ProblemQuotaExceeded, "over quota", map[string]any{
+		"quota":  map[string]any{"cpu_cores": 2},
+		"status": 200,
+	})
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, float64(http.StatusConflict), body["status"], "extensions can't replace standard members")
+	assert.Equal(t, map[string]any{"cpu_cores": float64(2)}, body["quota"])
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/artpar/hoster/internal/core/limits"
+)
+
+// =============================================================================
+// Resource Quotas
+// =============================================================================
+//
+// A plan's max_cpu_cores, max_memory_mb and max_disk_mb bound what a
+// customer's active deployments (see limits.IsActive) may reserve together.
+// A deployment reserves its own resources_* values, or its template's where
+// those are unset. Creating a deployment and starting a stopped or failed
+// one are refused with quota_exceeded when they would go over.
+
+// planQuota returns the resource quota of plan limits.
+func planQuota(pl PlanLimits) limits.Resources {
+	return limits.Resources{CPUCores: pl.MaxCPUCores, MemoryMB: pl.MaxMemoryMB, DiskMB: pl.MaxDiskMB}
+}
+
+// customerQuota returns the quota of a deployment's customer: the caller's
+// plan limits when the caller is the customer, otherwise the defaults of the
+// customer's plan (an operator starting a shared deployment spends the
+// owner's quota, not their own).
+func customerQuota(ctx context.Context, store *Store, authCtx AuthContext, customerID int) limits.Resources {
+	if customerID == authCtx.UserID {
+		return planQuota(authCtx.PlanLimits)
+	}
+	var planID string
+	if err := store.db.GetContext(ctx, &planID, `SELECT COALESCE(plan_id, '') FROM users WHERE id = ?`, customerID); err != nil {
+		return limits.Resources{}
+	}
+	return planQuota(DefaultPlanLimits(planID))
+}
+
+// customerUsage sums the resources of a customer's active deployments,
+// leaving out the deployment with reference ID exclude.
+func customerUsage(ctx context.Context, store *Store, customerID int, exclude string) (limits.CurrentUsage, error) {
+	var rows []struct {
+		Status   string  `db:"status"`
+		CPUCores float64 `db:"cpu"`
+		MemoryMB int64   `db:"memory"`
+		DiskMB   int64   `db:"disk"`
+	}
+	err := store.db.SelectContext(ctx, &rows, `
+		SELECT d.status,
+		       COALESCE(NULLIF(d.resources_cpu_cores, 0), t.resources_cpu_cores, 0) AS cpu,
+		       COALESCE(NULLIF(d.resources_memory_mb, 0), t.resources_memory_mb, 0) AS memory,
+		       COALESCE(NULLIF(d.resources_disk_mb, 0), t.resources_disk_mb, 0) AS disk
+		FROM deployments d
+		LEFT JOIN templates t ON t.id = d.template_id
+		WHERE d.customer_id = ? AND d.reference_id != ?`, customerID, exclude)
+	if err != nil {
+		return limits.CurrentUsage{}, fmt.Errorf("sum deployment resources: %w", err)
+	}
+	deployments := make([]limits.DeploymentUsage, len(rows))
+	for i, r := range rows {
+		deployments[i] = limits.DeploymentUsage{
+			Status:    r.Status,
+			Resources: limits.Resources{CPUCores: r.CPUCores, MemoryMB: r.MemoryMB, DiskMB: r.DiskMB},
+		}
+	}
+	return limits.SumUsage(deployments), nil
+}
+
+// deploymentResources returns what a deployment, or the data of one being
+// created, reserves: its own resources_* values, or its template's.
+func deploymentResources(ctx context.Context, store *Store, depl map[string]any) limits.Resources {
+	res := limits.Resources{
+		CPUCores: floatVal(depl["resources_cpu_cores"]),
+		MemoryMB: int64(toInt(depl["resources_memory_mb"])),
+		DiskMB:   int64(toInt(depl["resources_disk_mb"])),
+	}
+	if res.CPUCores > 0 && res.MemoryMB > 0 && res.DiskMB > 0 {
+		return res
+	}
+	tid, ok := toInt64(depl["template_id"])
+	if !ok || tid <= 0 {
+		return res
+	}
+	tmpl, err := store.GetByID(ctx, "templates", int(tid))
+	if err != nil {
+		return res
+	}
+	if res.CPUCores == 0 {
+		res.CPUCores = floatVal(tmpl["resources_cpu_cores"])
+	}
+	if res.MemoryMB == 0 {
+		res.MemoryMB = int64(toInt(tmpl["resources_memory_mb"]))
+	}
+	if res.DiskMB == 0 {
+		res.DiskMB = int64(toInt(tmpl["resources_disk_mb"]))
+	}
+	return res
+}
+
+// checkDeploymentQuota checks that depl fits in its customer's quota
+// alongside their other active deployments. It returns a
+// *limits.QuotaError when it doesn't.
+func checkDeploymentQuota(ctx context.Context, store *Store, authCtx AuthContext, customerID int, depl map[string]any) error {
+	quota := customerQuota(ctx, store, authCtx, customerID)
+	if quota == (limits.Resources{}) {
+		return nil
+	}
+	usage, err := customerUsage(ctx, store, customerID, strVal(depl["reference_id"]))
+	if err != nil {
+		return err
+	}
+	return limits.CheckQuota(quota, usage, deploymentResources(ctx, store, depl))
+}
+
+// quotaExtensions renders a quota error's numbers as a problem's extension
+// members.
+func quotaExtensions(qe *limits.QuotaError) map[string]any {
+	return map[string]any{"quota": map[string]any{
+		"resource": qe.Resource,
+		"limit": map[string]any{
+			limits.QuotaCPU:    qe.Quota.CPUCores,
+			limits.QuotaMemory: qe.Quota.MemoryMB,
+			limits.QuotaDisk:   qe.Quota.DiskMB,
+		},
+		"usage": map[string]any{
+			"deployments":      qe.Usage.DeploymentCount,
+			limits.QuotaCPU:    qe.Usage.TotalCPUCores,
+			limits.QuotaMemory: qe.Usage.TotalMemoryMB,
+			limits.QuotaDisk:   qe.Usage.TotalDiskMB,
+		},
+		"requested": map[string]any{
+			limits.QuotaCPU:    qe.Requested.CPUCores,
+			limits.QuotaMemory: qe.Requested.MemoryMB,
+			limits.QuotaDisk:   qe.Requested.DiskMB,
+		},
+	}}
+}
+
+// writeQuotaProblem writes the problem for a failed quota check.
+func writeQuotaProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var qe *limits.QuotaError
+	if errors.As(err, &qe) {
+		writeProblemWith(w, r, ProblemQuotaExceeded, err.Error(), quotaExtensions(qe))
+		return
+	}
+	writeProblem(w, r, ProblemInternal, "failed to check resource quota")
+}
@@ -0,0 +1,354 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/artpar/hoster/internal/core/backup"
+	"github.com/jmoiron/sqlx"
+	gosqlite "github.com/mattn/go-sqlite3"
+)
+
+// =============================================================================
+// Read-Only Replicas
+// =============================================================================
+//
+// A read-only replica serves public marketplace traffic apart from the control
+// plane, so it can be scaled out behind a CDN. It serves GET requests for
+// public resources and a few public endpoints, as an anonymous caller, and
+// refuses everything else with 503. It runs no workers and never writes to its
+// database, which is either kept current by an external replicator or, with a
+// ReplicaSyncer, by copying in the control plane's newest backup.
+
+// readOnlyEndpoints are the public endpoints, besides public resources, a
+// read-only replica serves, relative to the version prefix.
+var readOnlyEndpoints = []string{"/status", "/problems"}
+
+// uncachedPaths are probed for the replica's own state and must not be
+// answered from a cache.
+var uncachedPaths = map[string]bool{"/health": true, "/ready": true, "/metrics": true}
+
+// OpenReadOnlyDB opens a replica database without running migrations; its
+// schema is the control plane's.
+func OpenReadOnlyDB(dsn string, resources []Resource, logger *slog.Logger) (*Store, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	db, err := sqlx.Open("sqlite3", "file:"+dsn+"?mode=ro&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+	logger.Info("database opened read-only", "dsn", dsn)
+	return NewStore(db, resources)
+}
+
+// readOnlyMiddleware refuses requests a read-only replica does not serve and
+// marks the responses it does serve as cacheable for maxAge.
+func readOnlyMiddleware(store *Store, maxAge time.Duration) func(http.Handler) http.Handler {
+	public := map[string]bool{}
+	for name, res := range store.schema {
+		if res.PublicRead {
+			public[name] = true
+		}
+	}
+	cacheControl := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if maxAge <= 0 {
+		cacheControl = "no-cache"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				writeProblem(w, r, ProblemReadOnly, "this server is a read-only replica; send "+r.Method+" requests to the control plane")
+				return
+			}
+			if v, ok := apiversion.FromPath(r.URL.Path); ok {
+				if !readOnlyServes(strings.TrimPrefix(r.URL.Path, v.Prefix()), public) {
+					writeProblem(w, r, ProblemReadOnly, "this server is a read-only replica serving public endpoints only")
+					return
+				}
+			}
+			if !uncachedPaths[r.URL.Path] {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readOnlyServes reports whether a read-only replica serves a path relative to
+// the version prefix: a public resource's collection or member, or a public
+// endpoint.
+func readOnlyServes(path string, public map[string]bool) bool {
+	for _, p := range readOnlyEndpoints {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return len(segments) <= 2 && public[segments[0]]
+}
+
+// =============================================================================
+// Replica Syncer
+// =============================================================================
+
+// replicaStaleAfter is how many sync intervals may pass without a successful
+// sync before readiness reports the replica stale.
+const replicaStaleAfter = 3
+
+// ReplicaSyncer keeps a read-only replica's database at the control plane's
+// newest backup. Backups are read from a directory the control plane writes
+// to, or downloaded from its backup remote into that directory. A newer
+// backup is verified, decompressed and checked, then copied into the replica
+// database with SQLite's backup API, so open readers see it once the copy
+// commits.
+type ReplicaSyncer struct {
+	dbPath   string
+	dir      string
+	remote   BackupRemote // nil reads backups from dir
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	applied  string // ID of the backup the database holds
+	syncedAt time.Time
+	lastErr  error
+}
+
+// NewReplicaSyncer creates a syncer for the replica database at dbPath.
+func NewReplicaSyncer(dbPath, dir string, remote BackupRemote, interval time.Duration, logger *slog.Logger) *ReplicaSyncer {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	return &ReplicaSyncer{
+		dbPath:   dbPath,
+		dir:      dir,
+		remote:   remote,
+		interval: interval,
+		logger:   logger.With("component", "replica_syncer"),
+	}
+}
+
+func (s *ReplicaSyncer) Start() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.run()
+	s.logger.Info("replica syncer started", "interval", s.interval)
+}
+
+func (s *ReplicaSyncer) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *ReplicaSyncer) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Sync(s.ctx); err != nil {
+				s.logger.Error("replica sync failed", "error", err)
+			}
+		}
+	}
+}
+
+// Check returns the replica's readiness: "ok", "pending" before its first
+// sync, "stale" when syncs have failed for several intervals, or the last
+// error.
+func (s *ReplicaSyncer) Check() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.syncedAt.IsZero() && s.lastErr == nil:
+		return "pending"
+	case s.syncedAt.IsZero():
+		return s.lastErr.Error()
+	case time.Since(s.syncedAt) > replicaStaleAfter*s.interval:
+		return "stale"
+	}
+	return "ok"
+}
+
+// Sync copies the newest backup into the replica database unless it already
+// holds it. It reports whether the database changed.
+func (s *ReplicaSyncer) Sync(ctx context.Context) (bool, error) {
+	changed, err := s.sync(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	if err == nil {
+		s.syncedAt = time.Now()
+	}
+	return changed, err
+}
+
+func (s *ReplicaSyncer) sync(ctx context.Context) (bool, error) {
+	m, err := s.newest(ctx)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	applied := s.applied
+	s.mu.Unlock()
+	if m.ID == applied {
+		return false, nil
+	}
+
+	dataPath := filepath.Join(s.dir, backup.DataFile(m.ID))
+	sum, err := fileSHA256(dataPath)
+	if err != nil {
+		return false, err
+	}
+	if sum != m.SHA256 {
+		return false, fmt.Errorf("backup %s: checksum mismatch", m.ID)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.dbPath), 0o750); err != nil {
+		return false, err
+	}
+	snapshot := s.dbPath + ".sync"
+	defer os.Remove(snapshot) // no-op after the first snapshot is renamed
+	if err := gunzipFile(dataPath, snapshot); err != nil {
+		return false, fmt.Errorf("decompress backup: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, snapshot); err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(s.dbPath); errors.Is(err, os.ErrNotExist) {
+		// The first snapshot becomes the database
+		if err := os.Rename(snapshot, s.dbPath); err != nil {
+			return false, err
+		}
+	} else if err := copyDatabase(ctx, snapshot, s.dbPath); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.applied = m.ID
+	s.mu.Unlock()
+	if s.remote != nil {
+		s.pruneFetched(m.ID)
+	}
+	s.logger.Info("replica synced", "backup", m.ID, "created_at", m.CreatedAt)
+	return true, nil
+}
+
+// newest returns the newest backup, downloading it from the remote if there
+// is one.
+func (s *ReplicaSyncer) newest(ctx context.Context) (backup.Manifest, error) {
+	var ms []backup.Manifest
+	var err error
+	if s.remote != nil {
+		ms, err = ListRemoteBackups(ctx, s.remote)
+	} else {
+		ms, err = ListBackups(s.dir)
+	}
+	if err != nil {
+		return backup.Manifest{}, fmt.Errorf("list backups: %w", err)
+	}
+	if len(ms) == 0 {
+		return backup.Manifest{}, errors.New("no backups to sync from")
+	}
+	if s.remote != nil {
+		if err := FetchBackup(ctx, s.remote, s.dir, ms[0]); err != nil {
+			return ms[0], err
+		}
+	}
+	return ms[0], nil
+}
+
+// pruneFetched removes downloaded backups other than the one applied.
+func (s *ReplicaSyncer) pruneFetched(keep string) {
+	ms, err := ListBackups(s.dir)
+	if err != nil {
+		s.logger.Warn("failed to list fetched backups", "error", err)
+		return
+	}
+	for _, m := range ms {
+		if m.ID == keep {
+			continue
+		}
+		if err := RemoveBackup(s.dir, m.ID); err != nil {
+			s.logger.Warn("failed to remove fetched backup", "backup", m.ID, "error", err)
+		}
+	}
+}
+
+// copyDatabase replaces the contents of the database at dst with the database
+// at src in one transaction, waiting for dst's readers to let go.
+func copyDatabase(ctx context.Context, src, dst string) error {
+	srcDB, err := sqlx.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+	dstDB, err := sqlx.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+	defer dstDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	defer srcConn.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("open replica database: %w", err)
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			b, err := dc.(*gosqlite.SQLiteConn).Backup("main", sc.(*gosqlite.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("copy snapshot: %w", err)
+			}
+			for {
+				done, err := b.Step(-1)
+				if err != nil {
+					b.Finish()
+					return fmt.Errorf("copy snapshot: %w", err)
+				}
+				if done {
+					return b.Finish()
+				}
+				// The replica's readers hold the database; try again shortly
+				select {
+				case <-ctx.Done():
+					b.Finish()
+					return ctx.Err()
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		})
+	})
+}
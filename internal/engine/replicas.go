@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/proxy"
+	"github.com/artpar/hoster/internal/core/scheduler"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Replica Spreading
+// =============================================================================
+//
+// A template's x-hoster replicas spread a deployment over its node and
+// extra replica nodes (replica_nodes), each running a share of the
+// replicated services. The replica nodes reach the services they don't run
+// through bridge ports the deployment's node publishes on its private
+// address (replica_bridges). A replica node that fails to start degrades the
+// deployment instead of failing it; replica_status counts the replicas of
+// each service that are running.
+
+// planReplicas chooses the replica nodes of a deployment placed on nodeRef
+// and allocates their proxy ports and the bridge ports, returning the
+// deployment updates. reserved are host ports already taken on nodeRef by
+// the deployment itself. Templates without replicas return no updates.
+func planReplicas(ctx context.Context, deps *Deps, data map[string]any, nodeRef string, reserved []int) (map[string]any, error) {
+	store := deps.Store
+	// A missing template or a spec that doesn't parse fails the start
+	tmpl, err := store.GetByID(ctx, "templates", toInt(data["template_id"]))
+	if err != nil {
+		return nil, nil
+	}
+	spec, err := compose.ParseComposeSpec(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return nil, nil
+	}
+	shares := coredeployment.ReplicaServices(spec)
+	if len(shares) == 0 {
+		return nil, nil
+	}
+
+	candidates, err := candidateNodes(ctx, store, data)
+	if err != nil {
+		return nil, err
+	}
+	req := schedulingRequest(ctx, store, data, nil)
+	req.AvailableNodes = candidates
+	req.Strategy = schedulingStrategy(deps)
+	req.Seed = time.Now().UnixNano()
+	nodes := scheduler.SpreadNodes(req, nodeRef, len(shares))
+	if len(nodes) < len(shares) {
+		return nil, fmt.Errorf("replicas need %d more nodes, but %d can take the deployment", len(shares), len(nodes))
+	}
+
+	routed, _, _ := compose.ProxyRoute(spec, coredeployment.TopologicalSort(spec.Services))
+	replicas := make([]domain.ReplicaNode, len(shares))
+	for i, node := range nodes {
+		replicas[i] = domain.ReplicaNode{NodeID: node.ReferenceID, Services: shares[i], Status: domain.ReplicaStopped}
+		if !slices.Contains(shares[i], routed) {
+			continue
+		}
+		used, err := getUsedProxyPorts(ctx, store, node.ReferenceID)
+		if err != nil {
+			return nil, fmt.Errorf("load proxy ports of node %s: %w", node.ReferenceID, err)
+		}
+		if replicas[i].ProxyPort, err = proxy.AllocatePort(used, proxy.DefaultPortRange()); err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.ReferenceID, err)
+		}
+	}
+
+	bridges := coredeployment.BridgePorts(spec)
+	if len(bridges) > 0 {
+		used, err := getUsedProxyPorts(ctx, store, nodeRef)
+		if err != nil {
+			return nil, fmt.Errorf("load proxy ports: %w", err)
+		}
+		used = append(used, reserved...)
+		for i := range bridges {
+			port, err := proxy.AllocatePort(used, proxy.DefaultPortRange())
+			if err != nil {
+				return nil, err
+			}
+			bridges[i].HostPort = port
+			used = append(used, port)
+		}
+	}
+
+	replicasJSON, _ := json.Marshal(replicas)
+	bridgesJSON, _ := json.Marshal(bridges)
+	return map[string]any{
+		"replica_nodes":   string(replicasJSON),
+		"replica_bridges": string(bridgesJSON),
+	}, nil
+}
+
+// bridgeAddress returns the address a node's bridge ports are reached at
+// from other nodes: its detected private address, else its ssh_host.
+func bridgeAddress(ctx context.Context, store *Store, nodeRef string) string {
+	node, err := store.Get(ctx, "nodes", nodeRef)
+	if err != nil {
+		return ""
+	}
+	if addr := strVal(node["private_address"]); addr != "" {
+		return addr
+	}
+	return strVal(node["ssh_host"])
+}
+
+// startReplicas starts the deployment's replica nodes after its own node's
+// containers, recording how each went. A replica that fails is left for the
+// next start; the deployment keeps running on its other nodes.
+func startReplicas(ctx context.Context, deps *Deps, depl *domain.Deployment, composeSpec string, configFiles []domain.ConfigFile) {
+	if len(depl.Replicas) == 0 {
+		return
+	}
+	nodePool := getNodePool(deps)
+	configDir, _ := deps.Extra["config_dir"].(string)
+	address := bridgeAddress(ctx, deps.Store, depl.NodeID)
+
+	for i := range depl.Replicas {
+		r := &depl.Replicas[i]
+		r.Containers, r.Error = nil, ""
+		client, err := nodePool.GetClient(ctx, r.NodeID)
+		if err == nil {
+			orchestrator := docker.NewOrchestrator(client, deps.Logger, configDir, deps.Store)
+			if err = configureImageFetch(ctx, deps, orchestrator, r.NodeID, client); err == nil {
+				configureStart(deps, orchestrator)
+				r.Containers, err = orchestrator.StartReplica(ctx, depl, composeSpec, configFiles, *r, address)
+			}
+		}
+		if err != nil {
+			r.Status, r.Error = domain.ReplicaFailed, err.Error()
+			deps.Logger.Warn("failed to start replica", "deployment", depl.ReferenceID, "node_id", r.NodeID, "error", err)
+			continue
+		}
+		r.Status = domain.ReplicaRunning
+	}
+	saveReplicas(ctx, deps.Store, depl.ReferenceID, true, depl.Replicas)
+}
+
+// stopReplicas stops the containers on the deployment's replica nodes, or
+// removes them when remove is set.
+func stopReplicas(ctx context.Context, deps *Deps, depl *domain.Deployment, remove bool) {
+	if len(depl.Replicas) == 0 {
+		return
+	}
+	nodePool := getNodePool(deps)
+	configDir, _ := deps.Extra["config_dir"].(string)
+
+	for i := range depl.Replicas {
+		r := &depl.Replicas[i]
+		r.Status, r.Containers = domain.ReplicaStopped, nil
+		if nodePool == nil {
+			continue
+		}
+		client, err := nodePool.GetClient(ctx, r.NodeID)
+		if err != nil {
+			deps.Logger.Warn("failed to get docker client for replica", "deployment", depl.ReferenceID, "node_id", r.NodeID, "error", err)
+			continue
+		}
+		orchestrator := docker.NewOrchestrator(client, deps.Logger, configDir, nil)
+		if remove {
+			err = orchestrator.RemoveDeployment(ctx, depl)
+		} else {
+			err = orchestrator.StopDeployment(ctx, depl)
+		}
+		if err != nil {
+			deps.Logger.Warn("failed to stop replica", "deployment", depl.ReferenceID, "node_id", r.NodeID, "error", err)
+		}
+	}
+	saveReplicas(ctx, deps.Store, depl.ReferenceID, false, depl.Replicas)
+}
+
+// saveReplicas records the replica nodes and the replica counts they add up
+// to.
+func saveReplicas(ctx context.Context, store *Store, refID string, primaryRunning bool, replicas []domain.ReplicaNode) {
+	replicasJSON, _ := json.Marshal(replicas)
+	statusJSON, _ := json.Marshal(coredeployment.ReplicaStatus(primaryRunning, replicas))
+	store.Update(ctx, "deployments", refID, map[string]any{
+		"replica_nodes":  string(replicasJSON),
+		"replica_status": string(statusJSON),
+	})
+}
+
+// replicaHostPorts returns the host ports a deployment's replicas hold on
+// nodeRef: proxy ports of the replica nodes, and bridge ports when nodeRef
+// is the deployment's own node.
+func replicaHostPorts(row map[string]any, nodeRef string) []int {
+	var ports []int
+	var replicas []domain.ReplicaNode
+	decodeJSONValue(row["replica_nodes"], &replicas)
+	for _, r := range replicas {
+		if r.NodeID == nodeRef && r.ProxyPort > 0 {
+			ports = append(ports, r.ProxyPort)
+		}
+	}
+	if strVal(row["node_id"]) == nodeRef {
+		var bridges []domain.BridgePort
+		decodeJSONValue(row["replica_bridges"], &bridges)
+		for _, b := range bridges {
+			ports = append(ports, b.HostPort)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,777 @@
+package engine
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/backup"
+	"github.com/artpar/hoster/internal/core/replication"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Cold-Standby Replication
+// =============================================================================
+//
+// A primary periodically ships a snapshot of its database and config
+// directory to a standby instance. The standby keeps the newest few and
+// serves nothing but health endpoints until `hoster promote` restores the
+// newest one and records the promotion, after which it starts as a primary.
+// Snapshots are taken with VACUUM INTO like backups, bundled with the config
+// files (see core/replication), and described by backup manifests, so they
+// are named, listed and verified like backups.
+
+// Headers carrying a shipped snapshot's manifest. Its ID is in the path and
+// its size is the body's.
+const (
+	headerSnapshotCreatedAt     = "X-Hoster-Snapshot-Created-At"
+	headerSnapshotSHA256        = "X-Hoster-Snapshot-Sha256"
+	headerSnapshotDatabaseBytes = "X-Hoster-Snapshot-Database-Bytes"
+	headerSnapshotVersion       = "X-Hoster-Snapshot-Version"
+)
+
+// snapshotPath is where a standby receives snapshots.
+const snapshotPath = "/internal/replication/snapshots/"
+
+// errSnapshotRejected marks snapshots a standby refuses to keep.
+var errSnapshotRejected = errors.New("snapshot rejected")
+
+// Snapshot writes a replication snapshot of the database and the files in
+// configDir into dir and returns its manifest.
+func (s *Store) Snapshot(ctx context.Context, dir, configDir, version string) (backup.Manifest, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	m := backup.Manifest{ID: backup.NewID(now), CreatedAt: now, Version: version}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return m, err
+	}
+	bundlePath := filepath.Join(dir, replication.BundleFile(m.ID))
+	if _, err := os.Stat(bundlePath); err == nil {
+		return m, fmt.Errorf("snapshot %s already exists", m.ID)
+	}
+
+	dbSnapshot := filepath.Join(dir, ".snapshot-"+m.ID+".db")
+	os.Remove(dbSnapshot)
+	defer os.Remove(dbSnapshot)
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, dbSnapshot); err != nil {
+		return m, fmt.Errorf("snapshot database: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, dbSnapshot); err != nil {
+		return m, err
+	}
+	info, err := os.Stat(dbSnapshot)
+	if err != nil {
+		return m, err
+	}
+	m.DatabaseBytes = info.Size()
+
+	m.Bytes, m.SHA256, err = writeBundle(bundlePath, dbSnapshot, configDir)
+	if err != nil {
+		return m, fmt.Errorf("bundle snapshot: %w", err)
+	}
+	if err := writeManifest(dir, m); err != nil {
+		os.Remove(bundlePath)
+		return m, err
+	}
+	return m, nil
+}
+
+// removeSnapshot deletes a snapshot's files from dir, manifest first.
+func removeSnapshot(dir, id string) error {
+	for _, name := range []string{backup.ManifestFile(id), replication.BundleFile(id)} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBundle writes the database file at dbPath and the regular files in
+// configDir as a gzipped tar via a temp file and rename, returning the size
+// and SHA-256 of dst. A missing config directory contributes no files.
+func writeBundle(dst, dbPath, configDir string) (int64, string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".bundle-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hash, counter))
+	tw := tar.NewWriter(gz)
+
+	err = tarFile(tw, replication.DatabaseEntry, dbPath)
+	if err == nil {
+		err = filepath.WalkDir(configDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == configDir && errors.Is(err, fs.ErrNotExist) {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(configDir, path)
+			if err != nil {
+				return err
+			}
+			return tarFile(tw, replication.ConfigEntry(filepath.ToSlash(rel)), path)
+		})
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, "", err
+	}
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// tarFile adds the file at path to tw as name. A file that grows while it is
+// read is cut at the size it had when opened.
+func tarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// extractBundle writes a bundle's database to dbPath and, unless configDir
+// is empty, its config files under configDir.
+func extractBundle(bundlePath, dbPath, configDir string) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	foundDB := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == replication.DatabaseEntry {
+			if err := writeFileFrom(dbPath, tr, 0o640); err != nil {
+				return err
+			}
+			foundDB = true
+			continue
+		}
+		rel, ok, err := replication.ConfigPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if !ok || configDir == "" {
+			continue
+		}
+		path := filepath.Join(configDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return err
+		}
+		if err := writeFileFrom(path, tr, fs.FileMode(hdr.Mode).Perm()|0o600); err != nil {
+			return err
+		}
+	}
+	if !foundDB {
+		return errors.New("snapshot has no database")
+	}
+	return nil
+}
+
+func writeFileFrom(path string, r io.Reader, perm fs.FileMode) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// =============================================================================
+// Replication Shipper Worker
+// =============================================================================
+
+// ReplicationShipperConfig configures a ReplicationShipper.
+type ReplicationShipperConfig struct {
+	// StandbyURL is the standby's base URL.
+	StandbyURL string
+	// Secret is sent to the standby's internal endpoint.
+	Secret string
+	// Dir is where snapshots are staged until they are shipped.
+	Dir string
+	// ConfigDir is the config directory shipped with the database.
+	ConfigDir string
+	// Interval is how often a snapshot is shipped.
+	Interval time.Duration
+	// Timeout bounds one shipment.
+	Timeout time.Duration
+	// Version is recorded in snapshot manifests.
+	Version string
+}
+
+// ReplicationShipper periodically snapshots the database and config
+// directory and ships the snapshot to the standby. Snapshots are staged in
+// Dir and removed once shipped, or when shipping fails; the next run ships a
+// newer one.
+type ReplicationShipper struct {
+	store  *Store
+	cfg    ReplicationShipperConfig
+	client *http.Client
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	status    backup.Status
+	startedAt time.Time
+}
+
+func NewReplicationShipper(store *Store, cfg ReplicationShipperConfig, logger *slog.Logger) *ReplicationShipper {
+	if cfg.Interval == 0 {
+		cfg.Interval = replication.DefaultInterval
+	}
+	if cfg.Interval < replication.MinInterval {
+		cfg.Interval = replication.MinInterval
+	}
+	cfg.StandbyURL = strings.TrimRight(cfg.StandbyURL, "/")
+	return &ReplicationShipper{
+		store:     store,
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		logger:    logger.With("component", "replication_shipper"),
+		startedAt: time.Now(),
+	}
+}
+
+func (s *ReplicationShipper) Start() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.run()
+	s.logger.Info("replication shipper started", "interval", s.cfg.Interval, "standby", s.cfg.StandbyURL)
+}
+
+func (s *ReplicationShipper) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Check reports whether the standby is current, for readiness checks.
+func (s *ReplicationShipper) Check() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Check(s.cfg.Interval, s.startedAt, time.Now())
+}
+
+// Status returns the outcome of recent shipments.
+func (s *ReplicationShipper) Status() backup.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *ReplicationShipper) run() {
+	defer s.wg.Done()
+	s.shipOnce()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.shipOnce()
+		}
+	}
+}
+
+func (s *ReplicationShipper) shipOnce() {
+	defer s.store.LoopMetrics().Time("replication")()
+	start := time.Now()
+	m, err := s.store.Snapshot(s.ctx, s.cfg.Dir, s.cfg.ConfigDir, s.cfg.Version)
+	if err == nil {
+		err = s.ship(s.ctx, m)
+	}
+	if removeErr := removeSnapshot(s.cfg.Dir, m.ID); removeErr != nil {
+		s.logger.Warn("failed to remove shipped snapshot", "snapshot", m.ID, "error", removeErr)
+	}
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	if err != nil {
+		s.status.LastError, s.status.LastErrorAt = err.Error(), time.Now().UTC()
+	} else {
+		s.status.LastSuccess, s.status.LastError, s.status.LastErrorAt = &m, "", time.Time{}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Error("replication snapshot failed", "snapshot", m.ID, "error", err)
+		return
+	}
+	s.logger.Debug("replication snapshot shipped", "snapshot", m.ID, "bytes", m.Bytes, "duration", time.Since(start))
+}
+
+// ship uploads a staged snapshot to the standby.
+func (s *ReplicationShipper) ship(ctx context.Context, m backup.Manifest) error {
+	f, err := os.Open(filepath.Join(s.cfg.Dir, replication.BundleFile(m.ID)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.cfg.StandbyURL+snapshotPath+m.ID, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = m.Bytes
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set(HeaderInternalSecret, s.cfg.Secret)
+	req.Header.Set(headerSnapshotCreatedAt, m.CreatedAt.Format(time.RFC3339))
+	req.Header.Set(headerSnapshotSHA256, m.SHA256)
+	req.Header.Set(headerSnapshotDatabaseBytes, strconv.FormatInt(m.DatabaseBytes, 10))
+	req.Header.Set(headerSnapshotVersion, m.Version)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ship snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var problem struct {
+			Detail string `json:"detail"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&problem)
+		if problem.Detail == "" {
+			return fmt.Errorf("ship snapshot: standby answered %s", resp.Status)
+		}
+		return fmt.Errorf("ship snapshot: standby answered %s: %s", resp.Status, problem.Detail)
+	}
+	return nil
+}
+
+// =============================================================================
+// Standby
+// =============================================================================
+
+// StandbyReceiver keeps the snapshots a primary ships to a standby. Each is
+// verified against its manifest and integrity-checked before it is kept; the
+// newest keep are kept.
+type StandbyReceiver struct {
+	dir       string
+	keep      int
+	maxLag    time.Duration
+	logger    *slog.Logger
+	startedAt time.Time
+
+	receiving sync.Mutex // one snapshot is received at a time
+
+	mu     sync.Mutex
+	status replication.Status
+}
+
+// NewStandbyReceiver creates a receiver keeping snapshots in dir, picking up
+// those received by an earlier run.
+func NewStandbyReceiver(dir string, keep int, maxLag time.Duration, logger *slog.Logger) (*StandbyReceiver, error) {
+	if keep <= 0 {
+		keep = replication.DefaultKeep
+	}
+	if maxLag == 0 {
+		maxLag = replication.DefaultMaxLag
+	}
+	r := &StandbyReceiver{
+		dir:       dir,
+		keep:      keep,
+		maxLag:    maxLag,
+		logger:    logger.With("component", "standby_receiver"),
+		startedAt: time.Now(),
+	}
+	ms, err := ListBackups(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	if len(ms) > 0 {
+		r.status.Snapshot = &ms[0]
+	}
+	return r, nil
+}
+
+// Check returns the standby's replication state: "ok", "pending" before its
+// first snapshot, or "lagging".
+func (r *StandbyReceiver) Check() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Check(r.maxLag, r.startedAt, time.Now())
+}
+
+// Status returns what the standby has received.
+func (r *StandbyReceiver) Status() replication.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Receive stores the snapshot m read from body. A snapshot the standby
+// already has is accepted again; an older one is rejected.
+func (r *StandbyReceiver) Receive(ctx context.Context, m backup.Manifest, body io.Reader) error {
+	r.receiving.Lock()
+	defer r.receiving.Unlock()
+
+	err := r.receive(ctx, m, body)
+	r.mu.Lock()
+	if err != nil {
+		r.status.LastError, r.status.LastErrorAt = err.Error(), time.Now().UTC()
+	} else {
+		r.status.Snapshot, r.status.ReceivedAt = &m, time.Now().UTC()
+		r.status.LastError, r.status.LastErrorAt = "", time.Time{}
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		r.logger.Error("snapshot receipt failed", "snapshot", m.ID, "error", err)
+		return err
+	}
+	r.logger.Debug("snapshot received", "snapshot", m.ID, "created_at", m.CreatedAt, "bytes", m.Bytes)
+	r.prune()
+	return nil
+}
+
+func (r *StandbyReceiver) receive(ctx context.Context, m backup.Manifest, body io.Reader) error {
+	if err := m.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errSnapshotRejected, err)
+	}
+	r.mu.Lock()
+	newest := r.status.Snapshot
+	r.mu.Unlock()
+	if newest != nil && m.ID == newest.ID {
+		return nil
+	}
+	if newest != nil && m.ID < newest.ID {
+		return fmt.Errorf("%w: %s is older than snapshot %s", errSnapshotRejected, m.ID, newest.ID)
+	}
+
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.dir, ".receive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	if n != m.Bytes || hex.EncodeToString(hash.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("%w: %s: checksum mismatch", errSnapshotRejected, m.ID)
+	}
+
+	db := filepath.Join(r.dir, ".verify-"+m.ID+".db")
+	defer os.Remove(db)
+	if err := extractBundle(tmp.Name(), db, ""); err != nil {
+		return fmt.Errorf("%w: %s: %v", errSnapshotRejected, m.ID, err)
+	}
+	if err := checkDatabaseFile(ctx, db); err != nil {
+		return fmt.Errorf("%w: %s: %v", errSnapshotRejected, m.ID, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(r.dir, replication.BundleFile(m.ID))); err != nil {
+		return err
+	}
+	return writeManifest(r.dir, m)
+}
+
+// prune removes all but the newest keep snapshots.
+func (r *StandbyReceiver) prune() {
+	ms, err := ListBackups(r.dir)
+	if err != nil {
+		r.logger.Warn("failed to list snapshots", "error", err)
+		return
+	}
+	for i := r.keep; i < len(ms); i++ {
+		if err := removeSnapshot(r.dir, ms[i].ID); err != nil {
+			r.logger.Warn("failed to remove snapshot", "snapshot", ms[i].ID, "error", err)
+		}
+	}
+}
+
+// StandbyConfig configures the handler of a standby.
+type StandbyConfig struct {
+	Receiver *StandbyReceiver
+	// Secret admits the primary's shipments; without it only local
+	// requests are admitted.
+	Secret  string
+	Version string
+	Logger  *slog.Logger
+}
+
+// StandbyHandler serves a standby: /health, /ready, the /standby status
+// with its replication lag, and the primary's shipments. Everything else
+// gets 503.
+func StandbyHandler(cfg StandbyConfig) http.Handler {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.Use(recoveryMiddleware(cfg.Logger))
+
+	router.HandleFunc("/health", healthHandler(cfg.Version)).Methods("GET")
+	router.HandleFunc("/ready", standbyReadyHandler).Methods("GET")
+	router.HandleFunc("/standby", standbyStatusHandler(cfg.Receiver)).Methods("GET")
+	router.Handle(snapshotPath+"{id}", internalOnly(cfg.Secret, standbySnapshotHandler(cfg.Receiver))).Methods("PUT")
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, ProblemStandby, "this server is a cold standby; send requests to the primary")
+	})
+	return router
+}
+
+// standbyReadyHandler reports a standby as not ready, so load balancers
+// keep traffic on the primary.
+func standbyReadyHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "standby"})
+}
+
+// standbyStatusHandler handles GET /standby. It answers 503 while the
+// standby is lagging, so monitors can alert on the status code.
+func standbyStatusHandler(recv *StandbyReceiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		status := recv.Status()
+		check := recv.Check()
+		body := map[string]any{
+			"role":            replication.RoleStandby,
+			"status":          check,
+			"max_lag_seconds": int64(recv.maxLag.Seconds()),
+			"replication":     status,
+		}
+		if lag, ok := status.Lag(time.Now()); ok {
+			body["lag_seconds"] = int64(lag.Seconds())
+		}
+		code := http.StatusOK
+		if check != "ok" && check != "pending" {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, body)
+	}
+}
+
+// standbySnapshotHandler handles PUT /internal/replication/snapshots/{id}.
+func standbySnapshotHandler(recv *StandbyReceiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := shippedManifest(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		if err := recv.Receive(r.Context(), m, r.Body); err != nil {
+			if errors.Is(err, errSnapshotRejected) {
+				writeProblem(w, r, ProblemInvalidRequest, err.Error())
+				return
+			}
+			writeProblem(w, r, ProblemInternal, "failed to store snapshot")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// shippedManifest reads the manifest of a shipped snapshot from its request.
+func shippedManifest(r *http.Request) (backup.Manifest, error) {
+	m := backup.Manifest{
+		ID:      mux.Vars(r)["id"],
+		Bytes:   r.ContentLength,
+		SHA256:  r.Header.Get(headerSnapshotSHA256),
+		Version: r.Header.Get(headerSnapshotVersion),
+	}
+	if m.Bytes < 0 {
+		return m, errors.New("a Content-Length header is required")
+	}
+	createdAt, err := time.Parse(time.RFC3339, r.Header.Get(headerSnapshotCreatedAt))
+	if err != nil {
+		return m, fmt.Errorf("invalid %s header", headerSnapshotCreatedAt)
+	}
+	m.CreatedAt = createdAt.UTC()
+	if m.DatabaseBytes, err = strconv.ParseInt(r.Header.Get(headerSnapshotDatabaseBytes), 10, 64); err != nil {
+		return m, fmt.Errorf("invalid %s header", headerSnapshotDatabaseBytes)
+	}
+	return m, m.Validate()
+}
+
+// =============================================================================
+// Promotion
+// =============================================================================
+
+// PromoteStandby restores the newest snapshot in dir: its database to dbPath
+// and its config files into configDir. The snapshot is verified and staged
+// before anything is replaced. The database and config directory it replaces
+// are moved aside; the paths they were moved to are returned. The server
+// must not be running.
+func PromoteStandby(ctx context.Context, dir, dbPath, configDir string) (backup.Manifest, []string, error) {
+	ms, err := ListBackups(dir)
+	if err != nil {
+		return backup.Manifest{}, nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	if len(ms) == 0 {
+		return backup.Manifest{}, nil, fmt.Errorf("no snapshots in %s", dir)
+	}
+	m := ms[0]
+	bundlePath := filepath.Join(dir, replication.BundleFile(m.ID))
+	sum, err := fileSHA256(bundlePath)
+	if err != nil {
+		return m, nil, err
+	}
+	if sum != m.SHA256 {
+		return m, nil, fmt.Errorf("snapshot %s: checksum mismatch", m.ID)
+	}
+
+	stagedDB := dbPath + ".promoting"
+	stagedConfig := configDir + ".promoting"
+	defer os.Remove(stagedDB)        // no-op after a successful rename
+	defer os.RemoveAll(stagedConfig) // likewise
+	os.RemoveAll(stagedConfig)
+	if err := os.MkdirAll(stagedConfig, 0o750); err != nil {
+		return m, nil, err
+	}
+	if err := extractBundle(bundlePath, stagedDB, stagedConfig); err != nil {
+		return m, nil, fmt.Errorf("extract snapshot: %w", err)
+	}
+	if err := checkDatabaseFile(ctx, stagedDB); err != nil {
+		return m, nil, err
+	}
+
+	var aside []string
+	suffix := ".pre-promote-" + backup.NewID(time.Now())
+	for _, p := range []struct{ staged, target string }{{stagedDB, dbPath}, {stagedConfig, configDir}} {
+		if _, err := os.Stat(p.target); err == nil {
+			if err := os.Rename(p.target, p.target+suffix); err != nil {
+				return m, aside, err
+			}
+			aside = append(aside, p.target+suffix)
+		}
+		if p.target == dbPath {
+			// A journal left by the replaced database must not be applied
+			// to the restored one.
+			for _, journal := range []string{"-journal", "-wal", "-shm"} {
+				if err := os.Remove(dbPath + journal); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return m, aside, err
+				}
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(p.target), 0o750); err != nil {
+			return m, aside, err
+		}
+		if err := os.Rename(p.staged, p.target); err != nil {
+			return m, aside, err
+		}
+	}
+	return m, aside, nil
+}
+
+// MarkPromoted records in dir that the standby was promoted from snapshot m.
+func MarkPromoted(dir string, m backup.Manifest) error {
+	data, err := json.MarshalIndent(replication.Promotion{Snapshot: m, PromotedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, replication.PromotionFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadPromotion returns the promotion recorded in dir, or nil when the
+// standby has not been promoted.
+func ReadPromotion(dir string) (*replication.Promotion, error) {
+	data, err := os.ReadFile(filepath.Join(dir, replication.PromotionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p replication.Promotion
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", replication.PromotionFile, err)
+	}
+	return &p, nil
+}
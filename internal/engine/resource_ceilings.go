@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/validation"
+)
+
+// =============================================================================
+// Template Resource Ceilings
+// =============================================================================
+//
+// A creator bounds what customers may request for a template's deployments
+// with resource_ceilings. Customers request resources through the
+// deployment's service_overrides (per-service memory and CPU, applied over
+// the compose limits at the next start) and resources_cpu_cores. Both are
+// checked against the template's ceilings whenever they are set.
+
+// validateTemplateCeilings checks a template's ceilings, and that its own
+// compose limits fit them so deployments without overrides always do.
+func validateTemplateCeilings(ceilingsVal, composeSpec any) error {
+	var ceilings domain.ResourceCeilings
+	if err := decodeJSONValue(ceilingsVal, &ceilings); err != nil {
+		return fmt.Errorf("invalid resource_ceilings: %w", err)
+	}
+	if field, msg := validation.ValidateResourceCeilings(ceilings); field != "" {
+		return fmt.Errorf("%s: %s", field, msg)
+	}
+	spec, err := compose.ParseComposeSpec(strVal(composeSpec))
+	if err != nil {
+		return nil // reported when the spec is parsed for deployment
+	}
+	if field, msg := validation.CheckResourceCeilings(ceilings, requestedResources(spec, nil, 0)); field != "" {
+		return fmt.Errorf("compose_spec exceeds resource_ceilings: %s", msg)
+	}
+	return nil
+}
+
+// checkDeploymentCeilings validates a deployment's service overrides against
+// its template's services and checks the resources they and cpuCores
+// request against the template's ceilings.
+func checkDeploymentCeilings(tmpl map[string]any, overridesVal, cpuCores any) error {
+	var overrides map[string]domain.ServiceOverride
+	if err := decodeJSONValue(overridesVal, &overrides); err != nil {
+		return fmt.Errorf("invalid service_overrides: %w", err)
+	}
+	var ceilings domain.ResourceCeilings
+	if err := decodeJSONValue(tmpl["resource_ceilings"], &ceilings); err != nil {
+		return nil // malformed template data is reported by fsck, not at deploy time
+	}
+
+	spec, err := compose.ParseComposeSpec(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		spec = &compose.ParsedSpec{} // reported when the spec is parsed for deployment
+	}
+	if len(overrides) > 0 {
+		services := make([]string, len(spec.Services))
+		for i, svc := range spec.Services {
+			services[i] = svc.Name
+		}
+		if field, msg := validation.ValidateServiceOverrides(overrides, services); field != "" {
+			return fmt.Errorf("%s: %s", field, msg)
+		}
+	}
+
+	if field, msg := validation.CheckResourceCeilings(ceilings, requestedResources(spec, overrides, floatVal(cpuCores))); field != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// requestedResources builds the resource request of a deployment: each
+// service's compose limits with its override applied, and its x-hoster
+// replicas.
+func requestedResources(spec *compose.ParsedSpec, overrides map[string]domain.ServiceOverride, cpuCores float64) validation.ResourceRequest {
+	req := validation.ResourceRequest{
+		Services: make(map[string]validation.ServiceRequest, len(spec.Services)),
+		CPUCores: cpuCores,
+	}
+	for _, svc := range spec.Services {
+		s := validation.ServiceRequest{
+			Replicas: spec.Replicas(svc.Name),
+			MemoryMB: (svc.Resources.MemoryLimit + 1024*1024 - 1) / (1024 * 1024),
+			CPUCores: svc.Resources.CPULimit,
+		}
+		if o, ok := overrides[svc.Name]; ok {
+			if o.MemoryMB > 0 {
+				s.MemoryMB = o.MemoryMB
+			}
+			if o.CPUCores > 0 {
+				s.CPUCores = o.CPUCores
+			}
+		}
+		req.Services[svc.Name] = s
+	}
+	return req
+}
@@ -3,6 +3,8 @@ package engine
 import (
 	"context"
 	"strings"
+
+	"github.com/artpar/hoster/internal/core/integrity"
 )
 
 // Schema returns all resource definitions for the Hoster application.
@@ -16,6 +18,18 @@ func Schema() []Resource {
 		CloudCredentialResource(),
 		CloudProvisionResource(),
 		InvoiceResource(),
+		PayoutAccountResource(),
+		CreatorEarningResource(),
+		PayoutResource(),
+		NotificationChannelResource(),
+		DeploymentCollaboratorResource(),
+		IncidentResource(),
+		TemplateReviewResource(),
+		PlacementRuleResource(),
+		WebhookResource(),
+		NodeCostResource(),
+		DeploymentPipelineResource(),
+		SecretResource(),
 	}
 }
 
@@ -36,22 +50,41 @@ func TemplateResource() Resource {
 			StringField("description").WithNullable(),
 			StringField("version").WithRequired().WithPattern(`^\d+\.\d+\.\d+$`),
 			TextField("compose_spec").WithRequired(),
-			JSONField("variables"),
-			JSONField("config_files"),
-			JSONField("tags"),
-			JSONField("required_capabilities"),
+			JSONField("variables").WithShape(integrity.ShapeObjectArray),
+			JSONField("config_files").WithShape(integrity.ShapeObjectArray),
+			JSONField("setup_flow").WithShape(integrity.ShapeObject),
+			JSONField("presets").WithShape(integrity.ShapeObjectArray),
+			JSONField("tags").WithShape(integrity.ShapeStringArray),
+			JSONField("required_capabilities").WithShape(integrity.ShapeStringArray),
 			StringField("category").WithNullable(),
 			FloatField("resources_cpu_cores").WithDefault(0),
 			IntField("resources_memory_mb").WithDefault(0),
 			IntField("resources_disk_mb").WithDefault(0),
+			JSONField("resource_ceilings").WithShape(integrity.ShapeObject),
+			JSONField("routing_options").WithShape(integrity.ShapeObject),
+			JSONField("trial").WithShape(integrity.ShapeObject),
+			JSONField("assets").WithShape(integrity.ShapeObjectArray),
+			JSONField("registry_source").WithShape(integrity.ShapeObject).WithInternal(),
+			JSONField("compose_warnings").WithShape(integrity.ShapeStringArray).WithInternal().WithOwnerOnly(),
 			IntField("price_monthly_cents").WithMin(0).WithDefault(0),
 			BoolField("published").WithDefault(false),
 			RefField("creator_id", "users").WithInternal(),
 		},
 		Actions: []CustomAction{
 			{Name: "publish", Method: "POST"},
+			{Name: "setup", Method: "GET"},
+			{Name: "capacity", Method: "GET"},
+			{Name: "reviews", Method: "GET"},
+			{Name: "export", Method: "POST"},
+			{Name: "config-files", Method: "POST"},
+			{Name: "assets", Method: "POST"},
+			{Name: "uploads", Method: "POST"},
+			{Name: "webhook-deliveries", Method: "GET"},
+			{Name: "playground", Method: "POST"},
+			{Name: "slo", Method: "GET"},
 		},
 		Visibility: templateVisibility,
+		List:       listTemplates,
 	}
 }
 
@@ -66,17 +99,62 @@ func DeploymentResource() Resource {
 			StringField("template_version").WithNullable(),
 			RefField("customer_id", "users").WithInternal(),
 			SoftRefField("node_id", "nodes"),
+			SoftRefField("colocate_with", "deployments"),
+			StringField("location").WithNullable(),
+			StringField("affinity_status").WithDefault("").WithInternal(),
+			StringField("affinity_reason").WithNullable().WithInternal(),
 			StringField("status").WithDefault("pending"),
-			JSONField("variables"),
-			JSONField("domains"),
-			JSONField("containers"),
+			JSONField("variables").WithShape(integrity.ShapeObject),
+			JSONField("domains").WithShape(integrity.ShapeObjectArray),
+			JSONField("containers").WithShape(integrity.ShapeObjectArray),
+			JSONField("health").WithShape(integrity.ShapeObject).WithInternal(),
 			FloatField("resources_cpu_cores").WithDefault(0),
 			IntField("resources_memory_mb").WithDefault(0),
 			IntField("resources_disk_mb").WithDefault(0),
+			JSONField("service_overrides").WithShape(integrity.ShapeObject),
+			JSONField("routing_options").WithShape(integrity.ShapeObject),
+			JSONField("labels").WithShape(integrity.ShapeStringMap),
 			IntField("proxy_port").WithNullable(),
 			StringField("error_message").WithNullable(),
 			TimestampField("started_at"),
 			TimestampField("stopped_at"),
+			StringField("upgrade_policy").WithDefault("manual"),
+			JSONField("maintenance_windows").WithShape(integrity.ShapeObjectArray),
+			StringField("upgrade_status").WithDefault("").WithInternal(),
+			StringField("pending_version").WithNullable().WithInternal(),
+			TimestampField("upgrade_scheduled_at").WithInternal(),
+			TimestampField("last_upgraded_at").WithInternal(),
+			StringField("upgrade_strategy").WithDefault("recreate"),
+			JSONField("canary_policy").WithShape(integrity.ShapeObject),
+			JSONField("schedule").WithShape(integrity.ShapeObject),
+			TimestampField("schedule_next_at").WithInternal(),
+			StringField("schedule_next_action").WithNullable().WithInternal(),
+			StringField("schedule_error").WithNullable().WithInternal(),
+			IntField("canary_port").WithNullable().WithInternal(),
+			IntField("canary_percent").WithDefault(0).WithInternal(),
+			TimestampField("canary_started_at").WithInternal(),
+			JSONField("canary_stats").WithShape(integrity.ShapeObject).WithInternal(),
+			JSONField("image_drift").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			TimestampField("image_drift_checked_at").WithInternal(),
+			StringField("placement_rule").WithDefault("").WithInternal(),
+			StringField("placement_reason").WithNullable().WithInternal(),
+			JSONField("restore_checkpoints").WithShape(integrity.ShapeStringMap).WithInternal(),
+			TimestampField("expires_at").WithInternal(),
+			TimestampField("delete_at").WithInternal(),
+			StringField("trial_source").WithDefault("").WithInternal(),
+			JSONField("gpu_devices").WithShape(integrity.ShapeObject).WithInternal(),
+			TimestampField("gpu_metered_at").WithInternal(),
+			IntField("abuse_score").WithDefault(0).WithInternal(),
+			JSONField("abuse_anomalies").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			TimestampField("abuse_flagged_at").WithInternal(),
+			TimestampField("abuse_throttled_at").WithInternal(),
+			TimestampField("abuse_dismissed_at").WithInternal(),
+			JSONField("slo_alerts").WithShape(integrity.ShapeStringMap).WithInternal(),
+			JSONField("stream_ports").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			JSONField("replica_nodes").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			JSONField("replica_bridges").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			JSONField("replica_status").WithShape(integrity.ShapeObject).WithInternal(),
+			JSONField("running_spec").WithShape(integrity.ShapeObject).WithInternal(),
 		},
 		StateMachine: &StateMachine{
 			Field:   "status",
@@ -111,8 +189,32 @@ func DeploymentResource() Resource {
 			{Name: "monitoring/stats", Method: "GET"},
 			{Name: "monitoring/logs", Method: "GET"},
 			{Name: "monitoring/events", Method: "GET"},
+			{Name: "monitoring/capacity", Method: "GET"},
+			{Name: "support-bundle", Method: "GET"},
+			{Name: "convert", Method: "POST"},
 			{Name: "domains", Method: "GET"},
 			{Name: "domains", Method: "POST"},
+			{Name: "upgrade/check", Method: "GET"},
+			{Name: "upgrade/approve", Method: "POST"},
+			{Name: "upgrade/abort", Method: "POST"},
+			{Name: "images/drift", Method: "GET"},
+			{Name: "images/update", Method: "POST"},
+			{Name: "secret-resolutions", Method: "GET"},
+			{Name: "volume-migrations", Method: "GET"},
+			{Name: "volume-migrations", Method: "POST"},
+			{Name: "migrate", Method: "GET"},
+			{Name: "migrate", Method: "POST"},
+			{Name: "clone", Method: "POST"},
+			{Name: "buckets", Method: "GET"},
+			{Name: "buckets", Method: "POST"},
+			{Name: "collaborators", Method: "POST"},
+			{Name: "logs/export", Method: "GET"},
+			{Name: "logs/export", Method: "POST"},
+			{Name: "commands", Method: "GET"},
+			{Name: "tunnel", Method: "GET"},
+			{Name: "tunnels", Method: "GET"},
+			{Name: "exec", Method: "POST"},
+			{Name: "slo", Method: "GET"},
 		},
 	}
 }
@@ -133,7 +235,7 @@ func NodeResource() Resource {
 			StringField("docker_socket").WithDefault("/var/run/docker.sock").WithOwnerOnly(),
 			StringField("status").WithDefault("offline"),
 			BoolField("public").WithDefault(false),
-			JSONField("capabilities"),
+			JSONField("capabilities").WithShape(integrity.ShapeStringArray),
 			FloatField("capacity_cpu_cores").WithDefault(0),
 			IntField("capacity_memory_mb").WithDefault(0),
 			IntField("capacity_disk_mb").WithDefault(0),
@@ -141,15 +243,44 @@ func NodeResource() Resource {
 			IntField("capacity_memory_used_mb").WithDefault(0),
 			IntField("capacity_disk_used_mb").WithDefault(0),
 			StringField("location").WithNullable(),
+			StringField("timezone").WithNullable(),
 			TimestampField("last_health_check"),
 			StringField("error_message").WithNullable(),
 			StringField("provider_type").WithDefault("manual"),
 			SoftRefField("provision_id", "cloud_provisions"),
 			StringField("base_domain").WithNullable(),
+			JSONField("alerts").WithShape(integrity.ShapeObjectArray).WithInternal().WithOwnerOnly(),
+			JSONField("housekeeping").WithShape(integrity.ShapeObject).WithOwnerOnly(),
+			BoolField("air_gapped").WithDefault(false).WithOwnerOnly(),
+			SoftRefField("image_relay_id", "nodes").WithOwnerOnly(),
+			StringField("public_address").WithNullable().WithOwnerOnly(),
+			StringField("private_address").WithNullable().WithInternal().WithOwnerOnly(),
+			StringField("detected_public_address").WithNullable().WithInternal().WithOwnerOnly(),
+			StringField("address_source").WithNullable().WithInternal().WithOwnerOnly(),
+			JSONField("network_warnings").WithShape(integrity.ShapeStringArray).WithInternal().WithOwnerOnly(),
+			TimestampField("network_checked_at").WithInternal().WithOwnerOnly(),
+			JSONField("gpu_inventory").WithShape(integrity.ShapeObject).WithInternal(),
+			TimestampField("gpu_checked_at").WithInternal().WithOwnerOnly(),
+			StringField("docker_version").WithNullable().WithInternal(),
+			StringField("docker_api_version").WithNullable().WithInternal(),
+			StringField("wildcard_dns_status").WithNullable().WithInternal().WithOwnerOnly(),
+			StringField("wildcard_dns_error").WithNullable().WithInternal().WithOwnerOnly(),
+			TimestampField("wildcard_dns_checked_at").WithInternal().WithOwnerOnly(),
+			BoolField("unschedulable").WithDefault(false).WithInternal(),
 		},
 		Actions: []CustomAction{
 			{Name: "maintenance", Method: "POST"},
 			{Name: "maintenance", Method: "DELETE"},
+			{Name: "drain", Method: "POST"},
+			{Name: "drain", Method: "GET"},
+			{Name: "drain", Method: "DELETE"},
+			{Name: "monitoring", Method: "GET"},
+			{Name: "housekeeping", Method: "GET"},
+			{Name: "housekeeping", Method: "POST"},
+			{Name: "audit-log", Method: "GET"},
+			{Name: "keys", Method: "GET"},
+			{Name: "keys", Method: "POST"},
+			{Name: "keys", Method: "DELETE"},
 		},
 		Visibility: nodeVisibility,
 	}
@@ -231,6 +362,7 @@ func CloudProvisionResource() Resource {
 		},
 		Actions: []CustomAction{
 			{Name: "retry", Method: "POST"},
+			{Name: "commands", Method: "GET"},
 		},
 	}
 }
@@ -244,7 +376,7 @@ func InvoiceResource() Resource {
 			RefField("user_id", "users").WithInternal(),
 			TimestampField("period_start").WithRequired(),
 			TimestampField("period_end").WithRequired(),
-			JSONField("items"),
+			JSONField("items").WithShape(integrity.ShapeObjectArray),
 			IntField("subtotal_cents").WithDefault(0),
 			IntField("tax_cents").WithDefault(0),
 			IntField("total_cents").WithDefault(0),
@@ -262,9 +394,278 @@ func InvoiceResource() Resource {
 				"pending": {"paid", "failed"},
 				"failed":  {"pending"},
 			},
+			OnEnter: map[string]string{
+				"paid": "InvoicePaid",
+			},
 		},
 		Actions: []CustomAction{
 			{Name: "pay", Method: "POST"},
+			{Name: "export", Method: "GET"},
+		},
+	}
+}
+
+// PayoutAccountResource links a creator to a Stripe Connect account and
+// holds their payout preferences. One account per creator.
+func PayoutAccountResource() Resource {
+	return Resource{
+		Name:      "payout_accounts",
+		Owner:     "creator_id",
+		RefPrefix: "payacct_",
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal().WithUnique(),
+			StringField("stripe_account_id").WithNullable().WithInternal(),
+			StringField("status").WithDefault("pending").WithInternal(),
+			StringField("schedule").WithDefault("monthly").WithPattern(`^(weekly|monthly)$`),
+			IntField("minimum_payout_cents").WithMin(0).WithDefault(2500),
+			StringField("currency").WithDefault("USD"),
+			TimestampField("last_payout_at").WithInternal(),
+		},
+		Actions: []CustomAction{
+			{Name: "onboard", Method: "POST"},
+			{Name: "refresh", Method: "POST"},
+		},
+	}
+}
+
+// NotificationChannelResource is a user's notification destination (Slack,
+// Web Push). config is set at creation and never returned.
+func NotificationChannelResource() Resource {
+	return Resource{
+		Name:      "notification_channels",
+		Owner:     "user_id",
+		RefPrefix: "nch_",
+		Fields: []Field{
+			RefField("user_id", "users").WithInternal(),
+			StringField("name").WithRequired().WithMinLen(1).WithMaxLen(100),
+			StringField("type").WithRequired().WithPattern(`^(slack|webpush)$`),
+			TextField("config").WithRequired().WithWriteOnly().WithEncrypted(),
+			JSONField("events").WithShape(integrity.ShapeStringArray),
+			BoolField("enabled").WithDefault(true),
+			TimestampField("last_sent_at").WithInternal(),
+			StringField("last_error").WithNullable().WithInternal(),
+		},
+		Actions: []CustomAction{
+			{Name: "test", Method: "POST"},
+		},
+	}
+}
+
+// DeploymentCollaboratorResource is a deployment shared with another user as a
+// viewer or operator. Rows are created by inviting through
+// POST /deployments/{id}/collaborators; only the role can be changed, and
+// deleting a row revokes access.
+func DeploymentCollaboratorResource() Resource {
+	return Resource{
+		Name:      "deployment_collaborators",
+		Owner:     "owner_id",
+		RefPrefix: "collab_",
+		Fields: []Field{
+			SoftRefField("deployment_id", "deployments").WithInternal(),
+			RefField("owner_id", "users").WithInternal(),
+			StringField("email").WithInternal(),
+			StringField("role").WithRequired().WithPattern(`^(viewer|operator)$`),
+			RefField("user_id", "users").WithNullable().WithInternal(),
+			StringField("status").WithDefault("pending").WithInternal(),
+			StringField("token_hash").WithNullable().WithWriteOnly().WithInternal(),
+			TimestampField("expires_at").WithInternal(),
+			TimestampField("accepted_at").WithInternal(),
+			TimestampField("email_sent_at").WithInternal(),
+			StringField("email_error").WithNullable().WithInternal(),
+		},
+		Actions: []CustomAction{
+			{Name: "resend", Method: "POST"},
+		},
+	}
+}
+
+// IncidentResource is an outage reported by a node operator. It is public so
+// the status API and affected customers can see it, but which nodes and
+// deployments it affects is only shown to the operator. The timeline is
+// appended through POST /incidents/{id}/updates.
+func IncidentResource() Resource {
+	return Resource{
+		Name:       "incidents",
+		Owner:      "creator_id",
+		RefPrefix:  "inc_",
+		PublicRead: true,
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal(),
+			StringField("title").WithRequired().WithMinLen(3).WithMaxLen(200),
+			StringField("description").WithNullable().WithMaxLen(2000),
+			StringField("severity").WithRequired().WithPattern(`^(minor|major|critical)$`),
+			StringField("status").WithDefault("investigating").WithInternal(),
+			JSONField("affected_nodes").WithShape(integrity.ShapeStringArray).WithOwnerOnly(),
+			JSONField("affected_deployments").WithShape(integrity.ShapeStringArray).WithOwnerOnly(),
+			JSONField("affected_locations").WithShape(integrity.ShapeStringArray),
+			BoolField("auto_resolve").WithDefault(true),
+			JSONField("updates").WithShape(integrity.ShapeObjectArray).WithInternal(),
+			TimestampField("started_at").WithInternal(),
+			TimestampField("resolved_at").WithInternal(),
+			TimestampField("recovered_since").WithInternal().WithOwnerOnly(),
+		},
+		Actions: []CustomAction{
+			{Name: "updates", Method: "POST"},
+		},
+	}
+}
+
+// TemplateReviewResource is a customer's rating and review of a template they
+// have deployed. Hidden reviews are only visible to their author. Creators
+// answer through POST /template_reviews/{id}/response; anyone signed in can
+// report a review, and moderators act on reports through
+// POST /template_reviews/{id}/moderate.
+func TemplateReviewResource() Resource {
+	return Resource{
+		Name:       "template_reviews",
+		Owner:      "user_id",
+		RefPrefix:  "rev_",
+		PublicRead: true,
+		Fields: []Field{
+			RefField("template_id", "templates").WithRequired(),
+			RefField("user_id", "users").WithInternal(),
+			IntField("rating").WithRequired().WithMin(1).WithMax(5),
+			StringField("title").WithNullable().WithMaxLen(120),
+			TextField("body").WithNullable(),
+			TextField("creator_response").WithNullable().WithInternal(),
+			TimestampField("creator_responded_at").WithInternal(),
+			StringField("status").WithDefault("published").WithInternal(),
+			IntField("report_count").WithDefault(0).WithInternal(),
+			StringField("moderation_note").WithNullable().WithInternal().WithOwnerOnly(),
+		},
+		Actions: []CustomAction{
+			{Name: "response", Method: "POST"},
+			{Name: "report", Method: "POST"},
+			{Name: "moderate", Method: "POST"},
+		},
+		Visibility: reviewVisibility,
+	}
+}
+
+// PlacementRuleResource is a creator's rule for placing deployments of their
+// templates on their own nodes: a customer pinned to nodes, a template to a
+// node group, or both. GET /placement_rules/{id}/check reports whether the
+// rule can currently be satisfied and which rules it conflicts with.
+func PlacementRuleResource() Resource {
+	return Resource{
+		Name:      "placement_rules",
+		Owner:     "creator_id",
+		RefPrefix: "prule_",
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal(),
+			StringField("name").WithNullable().WithMaxLen(100),
+			RefField("customer_id", "users").WithNullable(),
+			RefField("template_id", "templates").WithNullable(),
+			JSONField("nodes").WithShape(integrity.ShapeStringArray).WithRequired(),
+			IntField("priority").WithDefault(0),
+			BoolField("fallback").WithDefault(false),
+		},
+		Actions: []CustomAction{
+			{Name: "check", Method: "GET"},
+		},
+	}
+}
+
+// DeploymentPipelineResource groups a customer's deployments of one template
+// into ordered environments. POST /deployment_pipelines/{id}/promote carries
+// a stage's template version, variables and configuration to the next stage;
+// GET /deployment_pipelines/{id}/promotions lists the promotion history.
+func DeploymentPipelineResource() Resource {
+	return Resource{
+		Name:      "deployment_pipelines",
+		Owner:     "customer_id",
+		RefPrefix: "pipe_",
+		Fields: []Field{
+			RefField("customer_id", "users").WithInternal(),
+			StringField("name").WithRequired().WithMinLen(1).WithMaxLen(100),
+			RefField("template_id", "templates").WithRequired(),
+			JSONField("stages").WithShape(integrity.ShapeObjectArray).WithRequired(),
+		},
+		Actions: []CustomAction{
+			{Name: "promote", Method: "POST"},
+			{Name: "promotions", Method: "GET"},
+		},
+	}
+}
+
+// WebhookResource is a user's endpoint for signed event deliveries. The
+// secret is set at creation and never returned. Deliveries are listed
+// through GET /webhooks/{id}/deliveries and past events re-sent through
+// POST /webhooks/{id}/replay; a paused webhook holds its deliveries until
+// POST /webhooks/{id}/resume.
+func WebhookResource() Resource {
+	return Resource{
+		Name:      "webhooks",
+		Owner:     "user_id",
+		RefPrefix: "whk_",
+		Fields: []Field{
+			RefField("user_id", "users").WithInternal(),
+			RefField("template_id", "templates").WithNullable(),
+			StringField("name").WithNullable().WithMaxLen(100),
+			StringField("url").WithRequired().WithMaxLen(2048),
+			TextField("secret").WithRequired().WithWriteOnly().WithEncrypted(),
+			JSONField("events").WithShape(integrity.ShapeStringArray),
+			BoolField("paused").WithDefault(false).WithInternal(),
+			TimestampField("paused_at").WithInternal(),
+			TimestampField("last_delivery_at").WithInternal(),
+			StringField("last_error").WithNullable().WithInternal(),
+		},
+		Actions: []CustomAction{
+			{Name: "deliveries", Method: "GET"},
+			{Name: "replay", Method: "POST"},
+			{Name: "pause", Method: "POST"},
+			{Name: "resume", Method: "POST"},
+		},
+	}
+}
+
+// CreatorEarningResource is the per-creator earnings ledger.
+// Rows are written when invoices are paid and are read-only via the API.
+func CreatorEarningResource() Resource {
+	return Resource{
+		Name:      "creator_earnings",
+		Owner:     "creator_id",
+		RefPrefix: "earn_",
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal(),
+			SoftRefField("invoice_id", "invoices").WithInternal(),
+			SoftRefField("deployment_id", "deployments").WithInternal(),
+			RefField("template_id", "templates").WithInternal(),
+			IntField("gross_cents").WithDefault(0).WithInternal(),
+			IntField("fee_cents").WithDefault(0).WithInternal(),
+			IntField("net_cents").WithDefault(0).WithInternal(),
+			StringField("currency").WithDefault("USD").WithInternal(),
+			StringField("status").WithDefault("pending").WithInternal(),
+			SoftRefField("payout_id", "payouts").WithInternal(),
+		},
+	}
+}
+
+// PayoutResource is a transfer of accumulated earnings to a creator.
+// Rows are created by the payout scheduler and are read-only via the API.
+func PayoutResource() Resource {
+	return Resource{
+		Name:      "payouts",
+		Owner:     "creator_id",
+		RefPrefix: "payout_",
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal(),
+			SoftRefField("payout_account_id", "payout_accounts").WithInternal(),
+			IntField("amount_cents").WithDefault(0).WithInternal(),
+			StringField("currency").WithDefault("USD").WithInternal(),
+			IntField("earnings_count").WithDefault(0).WithInternal(),
+			StringField("status").WithDefault("pending").WithInternal(),
+			StringField("stripe_transfer_id").WithNullable().WithInternal(),
+			StringField("error_message").WithNullable().WithInternal(),
+			TimestampField("paid_at").WithInternal(),
+		},
+		StateMachine: &StateMachine{
+			Field:   "status",
+			Initial: "pending",
+			Transitions: map[string][]string{
+				"pending": {"paid", "failed"},
+				"failed":  {"pending"},
+			},
 		},
 	}
 }
@@ -307,6 +708,19 @@ func templateVisibility(ctx context.Context, authCtx AuthContext, row map[string
 	return false
 }
 
+// reviewVisibility allows published reviews to be seen by anyone,
+// but hidden ones only by their author.
+func reviewVisibility(ctx context.Context, authCtx AuthContext, row map[string]any) bool {
+	if status, _ := row["status"].(string); status == "published" {
+		return true
+	}
+	if !authCtx.Authenticated {
+		return false
+	}
+	authorID, ok := toInt64(row["user_id"])
+	return ok && int(authorID) == authCtx.UserID
+}
+
 // nodeVisibility allows public nodes to be seen by anyone,
 // but private nodes only by their creator.
 func nodeVisibility(ctx context.Context, authCtx AuthContext, row map[string]any) bool {
@@ -351,3 +765,41 @@ func slugify(name string) string {
 	}
 	return slug
 }
+
+// NodeCostResource is a creator's monthly cost for one of their nodes, in
+// effect from effective_from until a later entry for the node. Provisioned
+// nodes without an entry are priced from the provider catalog. Costs are
+// allocated to deployments in GET /creator/margins.
+func NodeCostResource() Resource {
+	return Resource{
+		Name:      "node_costs",
+		Owner:     "creator_id",
+		RefPrefix: "ncost_",
+		Fields: []Field{
+			RefField("creator_id", "users").WithInternal(),
+			SoftRefField("node_id", "nodes").WithRequired(),
+			IntField("monthly_cents").WithRequired().WithMin(0),
+			TimestampField("effective_from"),
+			StringField("note").WithNullable().WithMaxLen(200),
+		},
+	}
+}
+
+// SecretResource is a customer's secret, such as a database password, kept
+// apart from deployment variables. Templates reference it as ${secret:NAME};
+// the value is encrypted at rest, substituted only when a container plan is
+// built, and never returned.
+func SecretResource() Resource {
+	return Resource{
+		Name:      "secrets",
+		Owner:     "customer_id",
+		RefPrefix: "sec_",
+		Fields: []Field{
+			RefField("customer_id", "users").WithInternal(),
+			StringField("name").WithRequired().WithMaxLen(64).WithPattern(`^[A-Za-z_][A-Za-z0-9_]*$`),
+			TextField("value").WithRequired().WithWriteOnly().WithEncrypted(),
+			StringField("description").WithNullable().WithMaxLen(200),
+			TimestampField("last_used_at").WithInternal(),
+		},
+	}
+}
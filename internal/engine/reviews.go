@@ -0,0 +1,508 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/review"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Review Hooks
+// =============================================================================
+//
+// A review belongs to the customer who wrote it. Only customers with at least
+// one deployment of a published template may review it, once. Moderators are
+// the users listed in auth.moderators; there is no other moderation role.
+
+// reviewBeforeCreate checks eligibility and content before a review is stored.
+func reviewBeforeCreate(store *Store) BeforeCreateFunc {
+	return func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+		tmplID, ok := toInt64(data["template_id"])
+		if !ok || tmplID == 0 {
+			return fmt.Errorf("template_id is required")
+		}
+		tmpl, err := store.GetByID(ctx, "templates", int(tmplID))
+		if err != nil {
+			return fmt.Errorf("template not found")
+		}
+		creatorID, _ := toInt64(tmpl["creator_id"])
+		installs, err := store.RawQuery(ctx,
+			`SELECT COUNT(*) AS n FROM deployments WHERE template_id = ? AND customer_id = ?`,
+			tmplID, authCtx.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to check deployments: %w", err)
+		}
+		deployments := 0
+		if len(installs) > 0 {
+			deployments = toInt(installs[0]["n"])
+		}
+		if err := review.CheckEligibility(boolVal(tmpl["published"]), int(creatorID) == authCtx.UserID, deployments); err != nil {
+			return err
+		}
+
+		existing, err := store.List(ctx, "template_reviews", []Filter{
+			{Field: "template_id", Value: tmplID},
+			{Field: "user_id", Value: authCtx.UserID},
+		}, Page{Limit: 1})
+		if err == nil && len(existing) > 0 {
+			return fmt.Errorf("you have already reviewed this template; update your review instead")
+		}
+		return validateReviewContent(data, nil)
+	}
+}
+
+// reviewBeforeUpdate lets authors edit their rating, title and body only.
+func reviewBeforeUpdate() BeforeUpdateFunc {
+	return func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+		if tid, ok := data["template_id"]; ok && toInt(tid) != toInt(existing["template_id"]) {
+			return fmt.Errorf("template_id cannot be changed")
+		}
+		return validateReviewContent(data, existing)
+	}
+}
+
+// validateReviewContent validates a review's rating and text after applying
+// data to existing (nil on create).
+func validateReviewContent(data, existing map[string]any) error {
+	merged := map[string]any{}
+	for _, field := range []string{"rating", "title", "body"} {
+		merged[field] = existing[field]
+		if v, ok := data[field]; ok {
+			merged[field] = v
+		}
+	}
+	rating, ok := toInt64(merged["rating"])
+	if !ok {
+		return fmt.Errorf("rating must be a whole number")
+	}
+	if err := review.ValidateRating(int(rating)); err != nil {
+		return err
+	}
+	return review.ValidateText(strVal(merged["title"]), strVal(merged["body"]))
+}
+
+// =============================================================================
+// Template Aggregates
+// =============================================================================
+
+// templateStats returns install counts and published review ratings for the
+// given template IDs.
+func templateStats(ctx context.Context, store *Store, ids []int) (map[int]int, map[int][]int, error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	installs := map[int]int{}
+	rows, err := store.RawQuery(ctx,
+		`SELECT template_id, COUNT(*) AS n FROM deployments
+		WHERE template_id IN (`+placeholders+`) GROUP BY template_id`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		installs[toInt(row["template_id"])] = toInt(row["n"])
+	}
+
+	ratings := map[int][]int{}
+	rows, err = store.RawQuery(ctx,
+		`SELECT template_id, rating, COUNT(*) AS n FROM template_reviews
+		WHERE status = 'published' AND template_id IN (`+placeholders+`)
+		GROUP BY template_id, rating`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		id, rating := toInt(row["template_id"]), toInt(row["rating"])
+		for n := toInt(row["n"]); n > 0; n-- {
+			ratings[id] = append(ratings[id], rating)
+		}
+	}
+	return installs, ratings, nil
+}
+
+// templateRatings adds install_count, rating_average and rating_count to
+// templates.
+func templateRatings(store *Store, logger *slog.Logger) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		ids := make([]int, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, toInt(row["id"]))
+		}
+		installs, ratings, err := templateStats(ctx, store, ids)
+		if err != nil {
+			logger.Warn("failed to load template ratings", "error", err)
+			return
+		}
+		for _, row := range rows {
+			id := toInt(row["id"])
+			summary := review.Summarize(ratings[id])
+			row["install_count"] = installs[id]
+			row["rating_average"] = summary.Average
+			row["rating_count"] = summary.Count
+		}
+	}
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// templateReviewsHandler handles GET /templates/{id}/reviews: the template's
+// published reviews, newest first, with the rating summary in meta.
+func templateReviewsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		tmpl, err := cfg.Store.Get(ctx, "templates", id)
+		if err != nil || !templateVisibility(ctx, authCtx, tmpl) {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		tmplID := toInt(tmpl["id"])
+
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		query, args := `SELECT id, reference_id, created_at FROM template_reviews
+			WHERE template_id = ? AND status = 'published'`, []any{tmplID}
+		if cond, condArgs := page.after("datetime(created_at)", "id", true); cond != "" {
+			query += ` AND ` + cond
+			args = append(args, condArgs...)
+		}
+		rows, err := cfg.Store.RawQuery(ctx,
+			query+` ORDER BY datetime(created_at) DESC, id DESC LIMIT ? OFFSET ?`,
+			append(args, page.Limit, page.Offset)...)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list reviews")
+			return
+		}
+		reviews := make([]map[string]any, 0, len(rows))
+		res := cfg.Store.Resource("template_reviews")
+		for _, ref := range rows {
+			row, err := cfg.Store.Get(ctx, "template_reviews", strVal(ref["reference_id"]))
+			if err != nil {
+				continue
+			}
+			stripFields(res, row, cfg.Store, authCtx)
+			reviews = append(reviews, row)
+		}
+
+		_, ratings, err := templateStats(ctx, cfg.Store, []int{tmplID})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to summarize ratings")
+			return
+		}
+		out := renderCollection(r, cfg.Store, "template_reviews", reviews, page, len(rows), nextCursor(rows, page, "created_at"))
+		if meta, ok := out["meta"].(map[string]any); ok {
+			meta["rating"] = review.Summarize(ratings[tmplID])
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// reviewResponseHandler handles POST /template_reviews/{id}/response: the
+// template's creator answers a review publicly. Posting again replaces the
+// response.
+func reviewResponseHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "template_reviews", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "review not found")
+			return
+		}
+		tmpl, err := cfg.Store.GetByID(ctx, "templates", toInt(row["template_id"]))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		creatorID, ok := toInt64(tmpl["creator_id"])
+		if !ok || int(creatorID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "only the template's creator can respond to its reviews")
+			return
+		}
+
+		var req struct {
+			Response string `json:"response"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		response, err := review.NormalizeResponse(req.Response)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		updated, err := cfg.Store.Update(ctx, "template_reviews", id, map[string]any{
+			"creator_response":     response,
+			"creator_responded_at": time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to save response")
+			return
+		}
+
+		res := cfg.Store.Resource("template_reviews")
+		stripFields(res, updated, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "template_reviews", updated),
+		})
+	}
+}
+
+// reviewReportHandler handles POST /template_reviews/{id}/report: a signed-in
+// user other than the author reports abuse, once per review. A published
+// review is hidden once review.AutoHideReports users have open reports on it.
+func reviewReportHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "template_reviews", id)
+		if err != nil || !reviewVisibility(ctx, authCtx, row) {
+			writeProblem(w, r, ProblemNotFound, "review not found")
+			return
+		}
+		if authorID, _ := toInt64(row["user_id"]); int(authorID) == authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "you cannot report your own review")
+			return
+		}
+
+		var req struct {
+			Reason  string `json:"reason"`
+			Details string `json:"details"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		reason, err := review.ParseReportReason(req.Reason)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		details := strings.TrimSpace(req.Details)
+		if len(details) > review.MaxReportDetailsLength {
+			writeProblem(w, r, ProblemValidationFailed,
+				fmt.Sprintf("details must be at most %d characters", review.MaxReportDetailsLength))
+			return
+		}
+
+		reviewID := toInt(row["id"])
+		if _, err := cfg.Store.DB().ExecContext(ctx,
+			`INSERT INTO review_reports (review_id, reporter_id, reason, details, status, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			reviewID, authCtx.UserID, string(reason), details, review.ReportOpen,
+			time.Now().UTC().Format(time.RFC3339)); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				writeProblem(w, r, ProblemInvalidState, "you have already reported this review")
+				return
+			}
+			writeProblem(w, r, ProblemInternal, "failed to save report")
+			return
+		}
+
+		open, err := openReportCount(ctx, cfg.Store, reviewID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to count reports")
+			return
+		}
+		changes := map[string]any{"report_count": open}
+		if review.ShouldAutoHide(review.Status(strVal(row["status"])), open) {
+			changes["status"] = string(review.StatusHidden)
+			cfg.Logger.Info("review hidden pending moderation", "review", id, "reports", open)
+		}
+		if _, err := cfg.Store.Update(ctx, "template_reviews", id, changes); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to update review")
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"data": map[string]any{
+				"type": "review_reports",
+				"attributes": map[string]any{
+					"review": id,
+					"reason": string(reason),
+					"status": review.ReportOpen,
+				},
+			},
+		})
+	}
+}
+
+// reviewModerateHandler handles POST /template_reviews/{id}/moderate: a
+// moderator hides or restores a review, or dismisses its open reports.
+func reviewModerateHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !isModerator(cfg, authCtx) {
+			writeProblem(w, r, ProblemForbidden, "moderator access required")
+			return
+		}
+
+		row, err := cfg.Store.Get(ctx, "template_reviews", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "review not found")
+			return
+		}
+
+		var req struct {
+			Action string `json:"action"`
+			Note   string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		outcome, err := review.Moderate(review.Status(strVal(row["status"])), req.Action)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		if _, err := cfg.Store.DB().ExecContext(ctx,
+			`UPDATE review_reports SET status = ?, resolved_at = ? WHERE review_id = ? AND status = ?`,
+			outcome.ReportStatus, time.Now().UTC().Format(time.RFC3339), toInt(row["id"]), review.ReportOpen); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to resolve reports")
+			return
+		}
+		changes := map[string]any{
+			"status":       string(outcome.Status),
+			"report_count": 0,
+		}
+		if note := strings.TrimSpace(req.Note); note != "" {
+			changes["moderation_note"] = note
+		}
+		updated, err := cfg.Store.Update(ctx, "template_reviews", id, changes)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to update review")
+			return
+		}
+		cfg.Logger.Info("review moderated", "review", id, "action", req.Action, "moderator", authCtx.ReferenceID)
+
+		res := cfg.Store.Resource("template_reviews")
+		stripFields(res, updated, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "template_reviews", updated),
+		})
+	}
+}
+
+// reviewReportsHandler handles GET /review-reports: the moderation queue of
+// open reports, oldest first.
+func reviewReportsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !isModerator(cfg, authCtx) {
+			writeProblem(w, r, ProblemForbidden, "moderator access required")
+			return
+		}
+
+		page, err := parsePage(r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		query, args := `SELECT rr.id, rr.reason, rr.details, rr.created_at,
+				tr.reference_id AS review, tr.status AS review_status, tr.report_count
+			FROM review_reports rr JOIN template_reviews tr ON tr.id = rr.review_id
+			WHERE rr.status = ?`, []any{review.ReportOpen}
+		if cond, condArgs := page.after("datetime(rr.created_at)", "rr.id", false); cond != "" {
+			query += ` AND ` + cond
+			args = append(args, condArgs...)
+		}
+		rows, err := cfg.Store.RawQuery(ctx,
+			query+` ORDER BY datetime(rr.created_at), rr.id LIMIT ? OFFSET ?`,
+			append(args, page.Limit, page.Offset)...)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list reports")
+			return
+		}
+
+		data := make([]map[string]any, 0, len(rows))
+		for _, row := range rows {
+			data = append(data, map[string]any{
+				"type": "review_reports",
+				"id":   fmt.Sprint(toInt(row["id"])),
+				"attributes": map[string]any{
+					"review":        strVal(row["review"]),
+					"review_status": strVal(row["review_status"]),
+					"report_count":  toInt(row["report_count"]),
+					"reason":        strVal(row["reason"]),
+					"details":       strVal(row["details"]),
+					"created_at":    strVal(row["created_at"]),
+				},
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": data,
+			"meta": pageMeta(page, nextCursor(rows, page, "created_at")),
+		})
+	}
+}
+
+// openReportCount returns how many open reports a review has.
+func openReportCount(ctx context.Context, store *Store, reviewID int) (int, error) {
+	rows, err := store.RawQuery(ctx,
+		`SELECT COUNT(*) AS n FROM review_reports WHERE review_id = ? AND status = ?`,
+		reviewID, review.ReportOpen)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return toInt(rows[0]["n"]), nil
+}
+
+// isModerator reports whether the user is listed in SetupConfig.Moderators.
+func isModerator(cfg SetupConfig, authCtx AuthContext) bool {
+	for _, ref := range cfg.Moderators {
+		if ref != "" && ref == authCtx.ReferenceID {
+			return true
+		}
+	}
+	return false
+}
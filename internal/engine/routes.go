@@ -0,0 +1,271 @@
+package engine
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/proxy"
+)
+
+// =============================================================================
+// Route Cache
+// =============================================================================
+
+// DefaultRouteCacheTTL bounds how long a cached route can outlive a change
+// the cache was not told about, such as one made by another replica.
+const DefaultRouteCacheTTL = 30 * time.Second
+
+// maxCachedRoutes caps the hostnames cached at once, so lookups of random
+// hostnames can't grow the cache without bound.
+const maxCachedRoutes = 10000
+
+// RouteCache resolves hostnames to proxy routes for the internal routes
+// endpoint. Routes are kept in memory until a deployment or node changes
+// through the store, or the TTL passes.
+type RouteCache struct {
+	store *Store
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	gen   uint64 // bumped by Invalidate, so stale lookups aren't cached
+	hosts map[string]cachedRoute
+	all   []proxy.Route
+	allAt time.Time
+}
+
+// cachedRoute is a cached resolution. A nil route records that no
+// deployment has the hostname.
+type cachedRoute struct {
+	route   *proxy.Route
+	expires time.Time
+}
+
+// NewRouteCache creates a route cache that is invalidated by the store's
+// writes to deployments, nodes and templates (routing options).
+func NewRouteCache(store *Store, ttl time.Duration) *RouteCache {
+	if ttl <= 0 {
+		ttl = DefaultRouteCacheTTL
+	}
+	rc := &RouteCache{
+		store: store,
+		ttl:   ttl,
+		hosts: make(map[string]cachedRoute),
+	}
+	store.OnChange(func(resource, _ string) {
+		if resource == "deployments" || resource == "nodes" || resource == "templates" {
+			rc.Invalidate()
+		}
+	})
+	return rc
+}
+
+// Invalidate drops every cached route.
+func (rc *RouteCache) Invalidate() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	clear(rc.hosts)
+	rc.all = nil
+}
+
+// Resolve returns the route of a hostname, with or without a port. Returns
+// ErrNotFound when no deployment has the hostname.
+func (rc *RouteCache) Resolve(ctx context.Context, hostname string) (proxy.Route, error) {
+	host := normalizeRouteHost(hostname)
+	now := time.Now()
+
+	rc.mu.Lock()
+	entry, ok := rc.hosts[host]
+	gen := rc.gen
+	rc.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.route == nil {
+			return proxy.Route{}, ErrNotFound
+		}
+		return *entry.route, nil
+	}
+
+	route, err := rc.lookup(ctx, host)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return proxy.Route{}, err
+	}
+
+	rc.mu.Lock()
+	if rc.gen == gen {
+		if len(rc.hosts) >= maxCachedRoutes {
+			clear(rc.hosts)
+		}
+		entry := cachedRoute{expires: now.Add(rc.ttl)}
+		if err == nil {
+			entry.route = &route
+		}
+		rc.hosts[host] = entry
+	}
+	rc.mu.Unlock()
+	return route, err
+}
+
+func (rc *RouteCache) lookup(ctx context.Context, host string) (proxy.Route, error) {
+	depl, err := rc.store.GetDeploymentByDomain(ctx, host)
+	if err != nil {
+		return proxy.Route{}, err
+	}
+	var nodeIP string
+	if !isLocalNode(depl.NodeID) {
+		if nodeIP, err = rc.store.GetNodeSSHHost(ctx, depl.NodeID); err != nil && !errors.Is(err, ErrNotFound) {
+			return proxy.Route{}, err
+		}
+	}
+	return proxy.RouteFor(depl, host, nodeIP), nil
+}
+
+// All returns the route of every hostname, sorted by hostname. Where two
+// deployments claim a hostname, the older one's route is kept, as Resolve
+// would find it.
+func (rc *RouteCache) All(ctx context.Context) ([]proxy.Route, error) {
+	now := time.Now()
+
+	rc.mu.Lock()
+	all, fresh := rc.all, now.Before(rc.allAt)
+	gen := rc.gen
+	rc.mu.Unlock()
+	if all != nil && fresh {
+		return all, nil
+	}
+
+	deployments, err := rc.store.ListDeploymentsWithDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodeIPs, err := rc.store.NodeSSHHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	all = make([]proxy.Route, 0, len(deployments))
+	for _, depl := range deployments {
+		var nodeIP string
+		if !isLocalNode(depl.NodeID) {
+			nodeIP = nodeIPs[depl.NodeID]
+		}
+		for _, route := range proxy.RoutesFor(depl, nodeIP) {
+			if !seen[route.Hostname] {
+				seen[route.Hostname] = true
+				all = append(all, route)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Hostname < all[j].Hostname })
+
+	// The dump also warms the per-hostname cache
+	rc.mu.Lock()
+	if rc.gen == gen {
+		rc.all, rc.allAt = all, now.Add(rc.ttl)
+		if len(all) < maxCachedRoutes {
+			for i := range all {
+				rc.hosts[all[i].Hostname] = cachedRoute{route: &all[i], expires: now.Add(rc.ttl)}
+			}
+		}
+	}
+	rc.mu.Unlock()
+	return all, nil
+}
+
+func isLocalNode(nodeID string) bool {
+	return nodeID == "" || nodeID == "local"
+}
+
+// normalizeRouteHost strips any port from a Host header value and
+// normalizes the hostname.
+func normalizeRouteHost(hostname string) string {
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	return domain.NormalizeHostname(hostname)
+}
+
+// =============================================================================
+// Internal Endpoints
+// =============================================================================
+
+// HeaderInternalSecret carries the shared secret that authorizes requests
+// to internal endpoints from other hosts.
+const HeaderInternalSecret = "X-Hoster-Internal-Secret"
+
+// internalOnly admits requests that carry the shared secret, or, without
+// one, come straight from this host. Requests relayed by a local reverse
+// proxy carry forwarding headers and are not treated as local.
+func internalOnly(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(HeaderInternalSecret); got != "" {
+			if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+				writeProblem(w, r, ProblemForbidden, "invalid internal secret")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isDirectLoopback(r) {
+			writeProblem(w, r, ProblemForbidden, "internal endpoint")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isDirectLoopback(r *http.Request) bool {
+	for _, h := range []string{"X-Forwarded-For", "X-Real-IP", "Forwarded"} {
+		if r.Header.Get(h) != "" {
+			return false
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// internalRoutesHandler handles GET /internal/routes. With ?host= it
+// resolves one hostname; without, it returns every route, for proxies
+// warming up.
+func internalRoutesHandler(routes *RouteCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		host := r.URL.Query().Get("host")
+		if host == "" {
+			all, err := routes.All(r.Context())
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list routes")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"data": all,
+				"meta": map[string]any{"count": len(all)},
+			})
+			return
+		}
+
+		route, err := routes.Resolve(r.Context(), host)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeProblem(w, r, ProblemNotFound, "no deployment for host "+normalizeRouteHost(host))
+				return
+			}
+			writeProblem(w, r, ProblemInternal, "failed to resolve route")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": route})
+	}
+}
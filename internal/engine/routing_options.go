@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/validation"
+)
+
+// =============================================================================
+// Routing Options
+// =============================================================================
+//
+// A template's routing_options (sticky sessions, WebSocket timeout, request
+// body limit) are the defaults for its deployments; a deployment's own
+// routing_options override them field by field. The App Proxy and
+// /internal/routes read them on every lookup. Traefik labels are set when a
+// container is created, so label routing picks up changes at the next
+// recreate; file routing at the next sync.
+
+// validateRoutingOptions checks a template's or deployment's routing options.
+func validateRoutingOptions(v any) error {
+	var opts domain.RoutingOptions
+	if err := decodeJSONValue(v, &opts); err != nil {
+		return fmt.Errorf("invalid routing_options: %w", err)
+	}
+	if field, msg := validation.ValidateRoutingOptions(opts); field != "" {
+		return fmt.Errorf("%s: %s", field, msg)
+	}
+	return nil
+}
+
+// deploymentRoutingOptions returns a deployment's routing options: its
+// template's with its own applied over them. Malformed values count as
+// unset.
+func deploymentRoutingOptions(templateVal, deploymentVal any) domain.RoutingOptions {
+	var tmpl, own domain.RoutingOptions
+	decodeJSONValue(templateVal, &tmpl)
+	decodeJSONValue(deploymentVal, &own)
+	return tmpl.Override(own)
+}
+
+// applyTemplateRouting sets a deployment's routing options from its template
+// row and its own row.
+func applyTemplateRouting(depl *domain.Deployment, tmpl, row map[string]any) {
+	depl.RoutingOptions = deploymentRoutingOptions(tmpl["routing_options"], row["routing_options"])
+}
@@ -6,8 +6,12 @@ package engine
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/artpar/hoster/internal/core/features"
+	"github.com/artpar/hoster/internal/core/integrity"
 )
 
 // FieldType represents the SQL/Go type of a field.
@@ -40,10 +44,11 @@ type Field struct {
 	Pattern      *regexp.Regexp
 	RefTable     string // For TypeRef/TypeSoftRef: target table name
 	Computed     func(row map[string]interface{}) interface{}
-	WriteOnly    bool // If true, never included in GET responses (e.g., private_key)
-	Encrypted    bool // If true, value is encrypted at rest
-	Internal     bool // If true, not settable via API (e.g., creator_id set from auth)
-	OwnerOnly    bool // If true, only visible to the resource owner (stripped for non-owners)
+	WriteOnly    bool            // If true, never included in GET responses (e.g., private_key)
+	Encrypted    bool            // If true, value is encrypted at rest
+	Internal     bool            // If true, not settable via API (e.g., creator_id set from auth)
+	OwnerOnly    bool            // If true, only visible to the resource owner (stripped for non-owners)
+	JSONShape    integrity.Shape // For TypeJSON: the structure the column holds, checked by fsck
 }
 
 // GuardFunc checks whether a state transition is allowed given the current row.
@@ -105,12 +110,31 @@ type VisibilityFunc func(ctx context.Context, authCtx AuthContext, row map[strin
 // BeforeCreateFunc is called before creating a row. It can modify the data.
 type BeforeCreateFunc func(ctx context.Context, authCtx AuthContext, data map[string]interface{}) error
 
+// BeforeUpdateFunc is called before updating a row with the existing row and the
+// incoming changes. It can modify the changes or return an error to reject them.
+type BeforeUpdateFunc func(ctx context.Context, authCtx AuthContext, existing, data map[string]interface{}) error
+
 // BeforeDeleteFunc is called before deleting a row. It can return an error to prevent deletion.
 type BeforeDeleteFunc func(ctx context.Context, authCtx AuthContext, row map[string]interface{}) error
 
+// BeforeTransitionFunc is called before a request moves a row to state. It
+// can return an error to refuse the transition.
+type BeforeTransitionFunc func(ctx context.Context, authCtx AuthContext, row map[string]interface{}, state string) error
+
 // AfterCreateFunc is called after a row is successfully created.
 type AfterCreateFunc func(ctx context.Context, authCtx AuthContext, row map[string]interface{})
 
+// AfterReadFunc is called with the rows a list or get request returns, after
+// visibility checks and before fields are stripped. It can add fields to them.
+type AfterReadFunc func(ctx context.Context, authCtx AuthContext, rows []map[string]interface{})
+
+// ListFunc lists a resource's rows for GET /{resource} in place of
+// Store.List, so the resource can take query parameters of its own, such as
+// a search. ordered reports whether the rows are newest first, the order
+// page cursors follow; other orders page by offset only. An error wrapping
+// ErrValidation is the request's fault.
+type ListFunc func(ctx context.Context, store *Store, query url.Values, filters []Filter, page Page) (rows []map[string]any, ordered bool, err error)
+
 // Resource defines a complete entity.
 type Resource struct {
 	Name         string // table name, e.g., "templates"
@@ -124,7 +148,15 @@ type Resource struct {
 	Visibility   VisibilityFunc
 	BeforeCreate BeforeCreateFunc
 	AfterCreate  AfterCreateFunc
+	BeforeUpdate BeforeUpdateFunc
 	BeforeDelete BeforeDeleteFunc
+	AfterRead    AfterReadFunc
+
+	// BeforeTransition runs before transition requests (REST and GraphQL)
+	BeforeTransition BeforeTransitionFunc
+
+	// List replaces Store.List for the list endpoint (nil uses Store.List)
+	List ListFunc
 
 	// If true, list without auth returns all rows (e.g., published templates)
 	PublicRead bool
@@ -137,6 +169,7 @@ type AuthContext struct {
 	ReferenceID   string
 	PlanID        string
 	PlanLimits    PlanLimits
+	Features      features.Set // Effective feature flags, resolved by AuthMiddleware
 }
 
 // FieldByName returns a field by name, or nil if not found.
@@ -237,6 +270,9 @@ func (f Field) WithInternal() Field { f.Internal = true; return f }
 // WithOwnerOnly marks the field as visible only to the resource owner.
 func (f Field) WithOwnerOnly() Field { f.OwnerOnly = true; return f }
 
+// WithShape declares the structure a JSON field holds.
+func (f Field) WithShape(shape integrity.Shape) Field { f.JSONShape = shape; return f }
+
 // =============================================================================
 // Guard helpers
 // =============================================================================
@@ -311,21 +347,20 @@ func (r *Resource) GenerateCreateSQL() string {
 		}
 	}
 
-	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", r.Name, strings.Join(cols, ",\n  "))
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", r.Name, strings.Join(cols, ",\n  "))
+}
 
-	// Indexes
+// GenerateIndexSQL generates a CREATE INDEX statement for each reference field.
+// They run after the column migrations, since an existing table may only gain
+// a reference column through an ALTER.
+func (r *Resource) GenerateIndexSQL() []string {
 	var indexes []string
 	for _, f := range r.Fields {
 		if f.Type == TypeRef || f.Type == TypeSoftRef {
 			indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s(%s)", r.Name, f.Name, r.Name, f.Name))
 		}
 	}
-
-	if len(indexes) > 0 {
-		sql += ";\n" + strings.Join(indexes, ";\n")
-	}
-
-	return sql
+	return indexes
 }
 
 func sqlDefault(v interface{}) string {
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+	"github.com/artpar/hoster/internal/shell/secrets"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Secret References
+// =============================================================================
+//
+// Deployment variables may hold references such as vault://secret/data/db#password
+// instead of values. The reference is what gets stored; the value is fetched
+// from the secret manager each time a container plan is built and only ever
+// lands in the container environment.
+
+func getSecretManager(deps *Deps) *secrets.Manager {
+	if m, ok := deps.Extra["secret_manager"].(*secrets.Manager); ok {
+		return m
+	}
+	return nil
+}
+
+// validateDeploymentSecretRefs rejects malformed secret references in deployment variables.
+func validateDeploymentSecretRefs(variables any) error {
+	if variables == nil {
+		return nil
+	}
+	var raw map[string]any
+	if err := decodeJSONValue(variables, &raw); err != nil {
+		return nil // shape errors are reported by the variables validation
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			values[k] = s
+		}
+	}
+	_, err := coresecrets.FindReferences(values)
+	return err
+}
+
+// resolveDeploymentSecrets replaces secret references in depl.Variables with
+// their values and adds the stored secrets composeSpec references. depl must
+// be a working copy that is never persisted.
+func resolveDeploymentSecrets(ctx context.Context, deps *Deps, depl *domain.Deployment, composeSpec string) error {
+	if err := resolveSecretReferences(ctx, deps, depl); err != nil {
+		return err
+	}
+	return resolveStoredSecrets(ctx, deps.Store, depl, composeSpec)
+}
+
+// resolveSecretReferences replaces secret references in depl.Variables with
+// their values from the secret manager.
+func resolveSecretReferences(ctx context.Context, deps *Deps, depl *domain.Deployment) error {
+	refs, err := coresecrets.FindReferences(depl.Variables)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	manager := getSecretManager(deps)
+	if manager == nil {
+		return fmt.Errorf("deployment uses secret references but no secret manager is configured")
+	}
+	resolved, err := manager.ResolveVariables(ctx, depl.ReferenceID, depl.Variables)
+	if err != nil {
+		return fmt.Errorf("resolve secrets: %w", err)
+	}
+	depl.Variables = resolved
+	return nil
+}
+
+// =============================================================================
+// Stored Secrets
+// =============================================================================
+//
+// A customer's secrets resource holds values encrypted with the engine's
+// EncryptionKey. A template references one as ${secret:NAME}; when a
+// container plan is built the customer's secrets of those names are
+// decrypted into the working copy of the deployment's variables, under
+// secrets.PlaceholderVariable(NAME), and substituted like any variable.
+
+// errSecretsNeedKey refuses secrets while the store has no encryption key,
+// since their values would be stored in plaintext.
+var errSecretsNeedKey = errors.New("secrets are unavailable: no encryption key is configured (nodes.encryption_key)")
+
+// validateSecret checks a new secret: a name the customer has not used yet
+// and a value of acceptable size.
+func validateSecret(ctx context.Context, store *Store, customerID int, data map[string]any) error {
+	if !store.Encrypting() {
+		return errSecretsNeedKey
+	}
+	name := strVal(data["name"])
+	if err := coresecrets.ValidateName(name); err != nil {
+		return err
+	}
+	existing, err := store.List(ctx, "secrets", []Filter{
+		{Field: "customer_id", Value: customerID},
+		{Field: "name", Value: name},
+	}, Page{Limit: 1})
+	if err != nil {
+		return fmt.Errorf("check secret name: %w", err)
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("a secret named %s already exists", name)
+	}
+	return validateSecretValue(data["value"])
+}
+
+// validateSecretValue checks a secret value's size.
+func validateSecretValue(v any) error {
+	value := strVal(v)
+	if value == "" {
+		return fmt.Errorf("value is required")
+	}
+	if len(value) > coresecrets.MaxValueLength {
+		return fmt.Errorf("value must be at most %d bytes", coresecrets.MaxValueLength)
+	}
+	return nil
+}
+
+// resolveStoredSecrets adds the customer's secrets that composeSpec
+// references to depl.Variables. depl must be a working copy that is never
+// persisted. A referenced secret the customer does not have is an error.
+func resolveStoredSecrets(ctx context.Context, store *Store, depl *domain.Deployment, composeSpec string) error {
+	names := coresecrets.FindPlaceholders(composeSpec)
+	if len(names) == 0 {
+		return nil
+	}
+	if depl.Variables == nil {
+		depl.Variables = make(map[string]string, len(names))
+	}
+	now := time.Now().UTC()
+	for _, name := range names {
+		res := secrets.Resolution{
+			DeploymentID: depl.ReferenceID,
+			Variable:     coresecrets.PlaceholderVariable(name),
+			Reference:    "${" + coresecrets.PlaceholderVariable(name) + "}",
+			Source:       secrets.SourceStore,
+			ResolvedAt:   now,
+		}
+		value, refID, err := store.storedSecretValue(ctx, depl.CustomerID, name)
+		if err != nil {
+			res.Error = err.Error()
+		}
+		_ = store.RecordSecretResolution(ctx, res) // best effort
+		if err != nil {
+			return fmt.Errorf("resolve secrets: %w", err)
+		}
+		depl.Variables[res.Variable] = value
+		_, _ = store.db.ExecContext(ctx, `UPDATE secrets SET last_used_at = ? WHERE reference_id = ?`, now.Format(time.RFC3339), refID) // best effort
+	}
+	return nil
+}
+
+// storedSecretValue decrypts a customer's secret by name.
+func (s *Store) storedSecretValue(ctx context.Context, customerID int, name string) (value, refID string, err error) {
+	rows, err := s.RawQuery(ctx, `SELECT reference_id, value FROM secrets WHERE customer_id = ? AND name = ?`, customerID, name)
+	if err != nil {
+		return "", "", err
+	}
+	if len(rows) == 0 {
+		return "", "", fmt.Errorf("no secret named %s: %w", name, ErrNotFound)
+	}
+	plaintext, err := s.decryptField(rows[0]["value"])
+	if err != nil {
+		return "", "", fmt.Errorf("decrypt secret %s: %w", name, err)
+	}
+	return string(plaintext), strVal(rows[0]["reference_id"]), nil
+}
+
+// =============================================================================
+// Resolution Audit
+// =============================================================================
+
+// RecordSecretResolution stores an audit record of a secret reference being
+// resolved. It implements secrets.AuditSink.
+func (s *Store) RecordSecretResolution(ctx context.Context, res secrets.Resolution) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO secret_resolutions (deployment_id, variable, reference, source, error, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		res.DeploymentID, res.Variable, res.Reference, res.Source, res.Error,
+		res.ResolvedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("record secret resolution: %w", err)
+	}
+	return nil
+}
+
+// secretResolutionsHandler handles GET /deployments/{id}/secret-resolutions.
+// It returns the most recent resolutions of the deployment's secret references.
+func secretResolutionsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+
+		ownerID, ok := toInt64(depl["customer_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+				limit = n
+			}
+		}
+
+		rows, err := cfg.Store.RawQuery(ctx,
+			`SELECT id, variable, reference, source, error, resolved_at
+			FROM secret_resolutions WHERE deployment_id = ?
+			ORDER BY resolved_at DESC, id DESC LIMIT ?`,
+			strVal(depl["reference_id"]), limit)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to query secret resolutions")
+			return
+		}
+
+		data := make([]map[string]any, 0, len(rows))
+		for _, row := range rows {
+			id, _ := toInt64(row["id"])
+			data = append(data, map[string]any{
+				"type": "secret-resolutions",
+				"id":   strconv.FormatInt(id, 10),
+				"attributes": map[string]any{
+					"variable":    strVal(row["variable"]),
+					"reference":   strVal(row["reference"]),
+					"source":      strVal(row["source"]),
+					"error":       strVal(row["error"]),
+					"resolved_at": strVal(row["resolved_at"]),
+				},
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
@@ -0,0 +1,381 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/monitoring"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Service Health Monitor
+// =============================================================================
+//
+// Each running deployment's services are checked on every tick: container
+// state and Docker health check status, plus the x-hoster probe of services
+// that have one (a TCP connect or a command run in the container, for images
+// like redis or postgres that ship without a health check). The result is
+// stored in the deployment's health field as a domain.DeploymentHealth and
+// served by GET /deployments/{id}/monitoring/health. Probes run no more often
+// than their interval; the tick bounds how promptly they run.
+//
+// Each tick also reads the containers' die and oom events from the node since
+// the previous tick. A death is recorded as a container_died or container_oom
+// event whose forensics (exit code, OOM kill, restart count, last log lines
+// and the last memory sample) are kept in the event's details.
+//
+// Probes are bounded by monitoring.ProbeLimits: a capped timeout and output
+// size, a per-node rate, and a per-deployment failure budget. A deployment
+// that spends its budget has its probes suspended until its window ends.
+
+// ServiceHealthMonitor periodically checks the services of running deployments.
+type ServiceHealthMonitor struct {
+	store    *Store
+	nodePool *docker.NodePool
+	interval time.Duration
+	logger   *slog.Logger
+	notifier *Notifier
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	// eventsSince is where each deployment's next events read starts.
+	eventsSince map[string]time.Time
+
+	limits monitoring.ProbeLimits
+	// nodeProbes counts probes per node in the current minute, and
+	// probeFailures failed probes per deployment in its budget window.
+	nodeProbes    map[string]monitoring.ProbeWindow
+	probeFailures map[string]monitoring.ProbeWindow
+}
+
+// NewServiceHealthMonitor creates a service health monitor.
+func NewServiceHealthMonitor(store *Store, nodePool *docker.NodePool, interval time.Duration, logger *slog.Logger) *ServiceHealthMonitor {
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	return &ServiceHealthMonitor{
+		store:         store,
+		nodePool:      nodePool,
+		interval:      interval,
+		logger:        logger.With("component", "service_health"),
+		eventsSince:   map[string]time.Time{},
+		limits:        monitoring.DefaultProbeLimits(),
+		nodeProbes:    map[string]monitoring.ProbeWindow{},
+		probeFailures: map[string]monitoring.ProbeWindow{},
+	}
+}
+
+// SetNotifier makes the monitor notify deployments' customers when a service
+// fails its health check.
+func (m *ServiceHealthMonitor) SetNotifier(n *Notifier) {
+	m.notifier = n
+}
+
+// SetProbeLimits replaces the default probe limits. Call before Start.
+func (m *ServiceHealthMonitor) SetProbeLimits(limits monitoring.ProbeLimits) {
+	m.limits = limits
+}
+
+func (m *ServiceHealthMonitor) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("service health monitor started", "interval", m.interval)
+}
+
+func (m *ServiceHealthMonitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *ServiceHealthMonitor) run() {
+	defer m.wg.Done()
+	m.checkAll()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkAll()
+		}
+	}
+}
+
+func (m *ServiceHealthMonitor) checkAll() {
+	defer m.store.LoopMetrics().Time("service_health")()
+	deployments, err := m.store.List(m.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		m.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+
+	running := make(map[string]bool, len(deployments))
+	for _, depl := range deployments {
+		if m.ctx.Err() != nil {
+			return
+		}
+		running[strVal(depl["reference_id"])] = true
+		m.checkDeployment(depl)
+	}
+	for refID := range m.eventsSince {
+		if !running[refID] {
+			delete(m.eventsSince, refID)
+		}
+	}
+	for refID := range m.probeFailures {
+		if !running[refID] {
+			delete(m.probeFailures, refID)
+		}
+	}
+}
+
+// checkDeployment checks every service of a deployment and stores the result.
+// An unreachable node leaves the previous result in place.
+func (m *ServiceHealthMonitor) checkDeployment(depl map[string]any) {
+	refID := strVal(depl["reference_id"])
+	nodeID := strVal(depl["node_id"])
+	var containers []domain.ContainerInfo
+	if err := decodeJSONValue(depl["containers"], &containers); err != nil || nodeID == "" || len(containers) == 0 {
+		return
+	}
+
+	client, err := m.nodePool.GetClient(m.ctx, nodeID)
+	if err != nil {
+		m.logger.Debug("node unavailable for health check", "deployment", refID, "node", nodeID, "error", err)
+		return
+	}
+	m.checkServices(depl, containers, client)
+	m.captureDeaths(depl, containers, client)
+}
+
+// checkServices checks the deployment's containers on its node's client.
+func (m *ServiceHealthMonitor) checkServices(depl map[string]any, containers []domain.ContainerInfo, client docker.Client) {
+	refID := strVal(depl["reference_id"])
+	prober, _ := client.(docker.ContainerProber)
+	probes := m.templateProbes(depl)
+
+	var previous domain.DeploymentHealth
+	_ = decodeJSONValue(depl["health"], &previous)
+	before := make(map[string]domain.ContainerHealth, len(previous.Containers))
+	for _, c := range previous.Containers {
+		before[c.Name] = c
+	}
+
+	now := time.Now().UTC()
+	health := domain.DeploymentHealth{CheckedAt: now}
+	for _, ctr := range containers {
+		old, seen := before[ctr.ServiceName]
+		current := domain.ContainerHealth{Name: ctr.ServiceName}
+
+		info, err := client.InspectContainer(ctr.ID)
+		switch {
+		case errors.Is(err, docker.ErrContainerNotFound):
+			current.Status = "missing"
+			current.Health = domain.HealthStatusUnhealthy
+		case err != nil:
+			m.logger.Debug("container inspect failed", "deployment", refID, "service", ctr.ServiceName, "error", err)
+			return
+		default:
+			current.Status = string(info.Status)
+			current.StartedAt = info.StartedAt
+			var check *string
+			if info.Health != "" {
+				check = &info.Health
+			}
+			if p, ok := probes[ctr.ServiceName]; ok && prober != nil {
+				current.Probe = m.probe(prober, refID, strVal(depl["node_id"]), ctr.ID, p, old.Probe, now)
+			}
+			current.Health = monitoring.DetermineServiceHealth(current.Status, check, 0, current.Probe)
+		}
+
+		if seen && old.Health != current.Health {
+			m.recordTransition(depl, ctr.ServiceName, old.Health, current)
+		}
+		health.Containers = append(health.Containers, current)
+	}
+	health.Status = monitoring.AggregateHealth(health.Containers)
+
+	if _, err := m.store.Update(m.ctx, "deployments", refID, map[string]any{"health": health}); err != nil {
+		m.logger.Error("failed to store deployment health", "deployment", refID, "error", err)
+	}
+}
+
+// probe runs a service's probe when it is due and folds in the result. A
+// probe that could not be run (e.g. the SSH session failed) says nothing about
+// the service, so the previous status is kept, as it is when the node has run
+// its share of probes this minute.
+func (m *ServiceHealthMonitor) probe(prober docker.ContainerProber, refID, nodeID, containerID string, p compose.Probe, prev *domain.ProbeStatus, now time.Time) *domain.ProbeStatus {
+	interval, timeout, retries := p.Settings()
+	timeout = m.limits.CapTimeout(timeout)
+	if !monitoring.ProbeDue(prev, interval, now) {
+		return prev
+	}
+
+	kind, spec := "tcp", minion.ProbeSpec{TCPPort: int(p.TCP), Timeout: timeout}
+	if len(p.Command) > 0 {
+		kind, spec = "command", minion.ProbeSpec{Command: p.Command, Timeout: timeout, User: p.ExecUser(), MaxOutput: m.limits.MaxOutput}
+	}
+
+	failures := m.probeFailures[refID]
+	if m.limits.FailureBudget > 0 && failures.Full(m.limits.FailureBudget, now, m.limits.BudgetWindow) {
+		return monitoring.SuspendProbe(prev, kind, failures.End(m.limits.BudgetWindow))
+	}
+	if m.limits.NodeRate > 0 {
+		if m.nodeProbes[nodeID].Full(m.limits.NodeRate, now, time.Minute) {
+			m.logger.Debug("node probe rate reached", "node", nodeID, "deployment", refID)
+			return prev
+		}
+		m.nodeProbes[nodeID] = m.nodeProbes[nodeID].Add(now, time.Minute)
+	}
+
+	// Allow for the SSH round trip on top of the probe's own timeout.
+	ctx, cancel := context.WithTimeout(m.ctx, timeout+30*time.Second)
+	defer cancel()
+	result, err := prober.ProbeContainer(ctx, containerID, spec)
+	if err != nil {
+		m.logger.Debug("probe could not run", "container", containerID, "error", err)
+		return prev
+	}
+	if !result.Passed {
+		m.probeFailures[refID] = failures.Add(now, m.limits.BudgetWindow)
+		if m.limits.FailureBudget > 0 && m.probeFailures[refID].Full(m.limits.FailureBudget, now, m.limits.BudgetWindow) {
+			m.logger.Warn("probe failure budget spent, suspending probes", "deployment", refID,
+				"until", m.probeFailures[refID].End(m.limits.BudgetWindow))
+		}
+	}
+	return monitoring.RecordProbe(prev, kind, result.Passed, result.Output, retries, now)
+}
+
+// templateProbes returns the x-hoster probes of a deployment's template.
+func (m *ServiceHealthMonitor) templateProbes(depl map[string]any) map[string]compose.Probe {
+	tmpl, err := m.store.GetByID(m.ctx, "templates", toInt(depl["template_id"]))
+	if err != nil {
+		return nil
+	}
+	ext, err := compose.ParseExtension(strVal(tmpl["compose_spec"]))
+	if err != nil || ext == nil {
+		return nil
+	}
+	return ext.Probes
+}
+
+// recordTransition records a container event when a service becomes
+// unhealthy or recovers from it.
+func (m *ServiceHealthMonitor) recordTransition(depl map[string]any, service string, from domain.HealthStatus, current domain.ContainerHealth) {
+	var eventType domain.ContainerEventType
+	switch {
+	case current.Health == domain.HealthStatusUnhealthy:
+		eventType = domain.EventHealthUnhealthy
+	case from == domain.HealthStatusUnhealthy && current.Health == domain.HealthStatusHealthy:
+		eventType = domain.EventHealthHealthy
+	default:
+		return
+	}
+
+	message := monitoring.ContainerEventMessage(eventType, service)
+	if current.Probe != nil && current.Probe.LastOutput != "" && eventType == domain.EventHealthUnhealthy {
+		message = fmt.Sprintf("%s: %s", message, current.Probe.LastOutput)
+	}
+	deplID, _ := toInt64(depl["id"])
+	event := domain.NewContainerEvent("", int(deplID), eventType, service, message)
+	if err := m.store.CreateContainerEvent(m.ctx, &event); err != nil {
+		m.logger.Error("failed to record health event", "deployment", strVal(depl["reference_id"]), "error", err)
+	}
+	if eventType == domain.EventHealthUnhealthy && m.notifier != nil {
+		m.notifier.NotifyDeploymentUnhealthy(depl, service, message)
+	}
+}
+
+// forensicsLogTail is how many log lines are read back from a dead
+// container. More than are kept, since lines written after a restart are
+// dropped by the until filter.
+const forensicsLogTail = "200"
+
+// captureDeaths records the deaths of the deployment's containers since the
+// previous read. A failed read is retried from the same point next tick.
+func (m *ServiceHealthMonitor) captureDeaths(depl map[string]any, containers []domain.ContainerInfo, client docker.Client) {
+	source, ok := client.(docker.ContainerEventSource)
+	if !ok {
+		return
+	}
+	refID := strVal(depl["reference_id"])
+	now := time.Now().UTC()
+	since, seen := m.eventsSince[refID]
+	if !seen {
+		since = now.Add(-m.interval)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+	events, err := source.ContainerEvents(ctx, since, now, map[string]string{
+		"label": docker.LabelDeployment + "=" + refID,
+	})
+	if err != nil {
+		m.logger.Debug("container events read failed", "deployment", refID, "error", err)
+		return
+	}
+	m.eventsSince[refID] = now
+
+	services := make(map[string]string, len(containers))
+	for _, c := range containers {
+		services[c.ID] = c.ServiceName
+	}
+	deplID, _ := toInt64(depl["id"])
+	for _, d := range monitoring.CollectDeaths(events) {
+		service := services[d.ContainerID]
+		if service == "" {
+			service = d.Name
+		}
+		f := m.forensics(int(deplID), service, d, client)
+		event := domain.NewContainerEvent("", int(deplID), monitoring.DeathEventType(f), service, monitoring.DeathMessage(service, f))
+		event.Forensics = &f
+		event.Timestamp = d.At
+		if err := m.store.CreateContainerEvent(m.ctx, &event); err != nil {
+			m.logger.Error("failed to record container death", "deployment", refID, "service", service, "error", err)
+		}
+	}
+}
+
+// forensics gathers what is still known about a dead container. Each part is
+// best effort: the container may already be gone or restarted.
+func (m *ServiceHealthMonitor) forensics(deploymentID int, service string, d monitoring.Death, client docker.Client) domain.ContainerForensics {
+	restarts := 0
+	if info, err := client.InspectContainer(d.ContainerID); err == nil {
+		restarts = info.RestartCount
+		// Docker clears OOMKilled on restart, so only a stopped container's flag belongs to this death
+		if info.State != string(docker.ContainerStatusRunning) && info.OOMKilled {
+			d.OOMKilled = true
+		}
+	}
+
+	var logs string
+	if rc, err := client.ContainerLogs(d.ContainerID, docker.LogOptions{Tail: forensicsLogTail, Until: d.At.Add(time.Second), Timestamps: true}); err == nil {
+		data, _ := io.ReadAll(io.LimitReader(rc, 64*1024))
+		rc.Close()
+		logs = string(data)
+	}
+
+	memory, err := m.store.LastMemorySample(m.ctx, deploymentID, service, d.At)
+	if err != nil {
+		m.logger.Debug("memory sample lookup failed", "service", service, "error", err)
+	}
+	return monitoring.NewForensics(d, restarts, logs, memory)
+}
@@ -5,21 +5,35 @@ import (
 	"crypto/rand"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
-	"maps"
 	"math/big"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	coreacme "github.com/artpar/hoster/internal/core/acme"
+	"github.com/artpar/hoster/internal/core/apiversion"
 	"github.com/artpar/hoster/internal/core/crypto"
+	coredns "github.com/artpar/hoster/internal/core/dns"
 	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/features"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
 	coreprovider "github.com/artpar/hoster/internal/core/provider"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/core/spending"
+	"github.com/artpar/hoster/internal/core/topology"
+	"github.com/artpar/hoster/internal/core/upload"
+	corewebhook "github.com/artpar/hoster/internal/core/webhook"
 	"github.com/artpar/hoster/internal/shell/billing"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/artpar/hoster/internal/shell/mail"
 	"github.com/gorilla/mux"
 )
 
@@ -37,6 +51,91 @@ type SetupConfig struct {
 	EncryptionKey []byte
 	Version       string
 	StripeKey     string
+	// Authenticators resolve callers, in order; empty uses the shared-secret
+	// authenticator alone.
+	Authenticators []Authenticator
+	// IdempotencyTTL is how long Idempotency-Key responses are kept (default 24h).
+	IdempotencyTTL time.Duration
+	// Buckets provisions managed object storage; nil when storage is disabled.
+	Buckets *BucketManager
+	// Housekeeping runs node cleanup previews inline; nil without a node pool.
+	Housekeeping *HousekeepingScheduler
+	// NodeAudit reads nodes' minion audit logs; nil without a node pool.
+	NodeAudit NodeAuditReader
+	// NodeKeys issues, rotates and revokes node keys; nil without a node pool.
+	NodeKeys *NodeKeyManager
+	// APILifecycles schedules deprecation and sunset of API versions; versions
+	// without an entry are current.
+	APILifecycles map[apiversion.Version]apiversion.Lifecycle
+	// Notifier delivers notifications to users' channels; nil disables them.
+	Notifier *Notifier
+	// Mailer sends collaborator invitations; nil hands the accept link to the
+	// inviting owner instead.
+	Mailer mail.Mailer
+	// AppURL is the web UI base URL used for invitation links.
+	AppURL string
+	// Backups takes scheduled database backups; nil when backups are disabled.
+	Backups *BackupScheduler
+	// Replication ships snapshots to a cold standby; nil unless this is a
+	// replication primary.
+	Replication *ReplicationShipper
+	// Leader reports whether this replica runs the background workers; nil
+	// when replicas are not coordinated.
+	Leader *LeaderElector
+	// Moderators are the reference IDs of users who act on review reports.
+	Moderators []string
+	// Admins are the reference IDs of platform administrators, who can see
+	// and cancel scheduled commands.
+	Admins []string
+	// LogExports produces deployment log archives; nil when remote nodes are
+	// not configured.
+	LogExports *LogExporter
+	// ExperimentalCheckpoints accepts checkpoint-mode volume migrations,
+	// which live-migrate running deployments with CRIU.
+	ExperimentalCheckpoints bool
+	// ImageDrift checks deployments' pinned images against their registries;
+	// nil without a node pool.
+	ImageDrift *ImageDriftChecker
+	// Routes serves GET /internal/routes to proxies; nil disables it.
+	Routes *RouteCache
+	// InternalSecret admits requests to internal endpoints from other hosts.
+	// Without it only local requests are admitted.
+	InternalSecret string
+	// MetricsToken admits scrapers to GET /metrics as a bearer token.
+	// Without it only administrators can read the metrics.
+	MetricsToken string
+	// Registry signs exported template bundles and verifies imported ones;
+	// nil disables template export and import.
+	Registry *TemplateRegistry
+	// Uploads checks template files uploaded from a browser and keeps
+	// chunked uploads until they finish; nil disables uploads.
+	Uploads *TemplateUploads
+	// UsagePrices price metered usage for spending limits; without them only
+	// deployments' monthly prices count.
+	UsagePrices spending.Prices
+	// ReadOnly serves public GET endpoints only, to anonymous callers, and
+	// refuses everything else; for replicas serving marketplace traffic.
+	ReadOnly bool
+	// CacheMaxAge is the max-age read-only replicas put on their responses.
+	CacheMaxAge time.Duration
+	// Replica keeps a read-only replica's database current; nil when it is
+	// replicated externally.
+	Replica *ReplicaSyncer
+	// Faults injects chaos testing faults and enables /admin/faults; nil
+	// disables fault injection.
+	Faults *FaultInjector
+	// Playground lets marketplace visitors try published templates on a
+	// sandbox node; nil disables playgrounds.
+	Playground *PlaygroundConfig
+	// Tunnels relays WebSocket tunnels to deployments' container ports;
+	// nil disables tunnels.
+	Tunnels *TunnelConfig
+	// Exec runs one-off commands in deployments' containers; nil disables
+	// exec.
+	Exec *ExecConfig
+	// Certificates issues custom domains' certificates over ACME; nil leaves
+	// them to Traefik's resolver.
+	Certificates *CertificateManager
 }
 
 // Setup creates the complete HTTP handler using the engine.
@@ -44,6 +143,9 @@ func Setup(cfg SetupConfig) http.Handler {
 	if cfg.Logger == nil {
 		cfg.Logger = slog.Default()
 	}
+	if len(cfg.Authenticators) == 0 {
+		cfg.Authenticators = []Authenticator{NewSharedSecretAuthenticator(cfg.Store, cfg.SharedSecret)}
+	}
 
 	// Wire encryption key to store for encrypted fields
 	if len(cfg.EncryptionKey) > 0 {
@@ -51,15 +153,33 @@ func Setup(cfg SetupConfig) http.Handler {
 	}
 
 	router := mux.NewRouter()
+	requests := newRequestMetrics()
+	events := NewEventHub(cfg.Store)
 
 	// Middleware
 	router.Use(requestIDMiddleware)
+	router.Use(requestMetricsMiddleware(requests))
 	router.Use(recoveryMiddleware(cfg.Logger))
-	router.Use(AuthMiddleware(cfg.Store, cfg.SharedSecret, cfg.Logger))
+	router.Use(apiVersionMiddleware(cfg.APILifecycles))
+	if cfg.ReadOnly {
+		// Replicas serve everyone as anonymous, so responses can be cached
+		router.Use(readOnlyMiddleware(cfg.Store, cfg.CacheMaxAge))
+	} else {
+		router.Use(AuthMiddleware(cfg.Store, cfg.Authenticators, cfg.Logger))
+		router.Use(IdempotencyMiddleware(cfg.Store, cfg.IdempotencyTTL, cfg.Logger))
+	}
+	router.Use(requestLoggerMiddleware(cfg.Logger))
+	if !cfg.ReadOnly {
+		router.Use(auditMiddleware(cfg.Store, cfg.Logger))
+	}
 
 	// Health endpoints
 	router.HandleFunc("/health", healthHandler(cfg.Version)).Methods("GET")
-	router.HandleFunc("/ready", readyHandler).Methods("GET")
+	router.HandleFunc("/ready", readyHandler(cfg.Backups, cfg.Replication, cfg.Leader, cfg.Replica)).Methods("GET")
+	router.HandleFunc("/metrics", metricsHandler(cfg.Store, cfg, requests)).Methods("GET")
+	if cfg.Certificates != nil {
+		router.PathPrefix(coreacme.ChallengePathPrefix).Handler(NewACMEChallengeHandler(cfg.Store))
+	}
 
 	// Wire SSH key BeforeCreate: compute fingerprint + public_key from private key
 	if sshRes := cfg.Store.Resource("ssh_keys"); sshRes != nil {
@@ -82,9 +202,149 @@ func Setup(cfg SetupConfig) http.Handler {
 		}
 	}
 
+	// Wire node hooks: validate the housekeeping policy, timezone, image relay + public address; check wildcard DNS; count allocated GPUs
+	if nodeRes := cfg.Store.Resource("nodes"); nodeRes != nil {
+		store := cfg.Store
+		nodeRes.AfterRead = nodeGPUAllocation(store)
+		nodeRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			if err := validateImageRelay(ctx, store, authCtx.UserID, "", boolVal(data["air_gapped"]), strVal(data["image_relay_id"])); err != nil {
+				return err
+			}
+			if err := validateNodePublicAddress(data); err != nil {
+				return err
+			}
+			if err := validateNodeHousekeeping(data); err != nil {
+				return err
+			}
+			if err := validateNodeTimezone(data); err != nil {
+				return err
+			}
+			verifyNodeWildcardOnCreate(ctx, data)
+			return nil
+		}
+		nodeRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			_, gapChanged := data["air_gapped"]
+			_, relayChanged := data["image_relay_id"]
+			if gapChanged || relayChanged {
+				airGapped, relayRef := boolVal(existing["air_gapped"]), strVal(existing["image_relay_id"])
+				if gapChanged {
+					airGapped = boolVal(data["air_gapped"])
+				}
+				if relayChanged {
+					relayRef = strVal(data["image_relay_id"])
+				}
+				ownerID, _ := toInt64(existing["creator_id"])
+				if err := validateImageRelay(ctx, store, int(ownerID), strVal(existing["reference_id"]), airGapped, relayRef); err != nil {
+					return err
+				}
+			}
+			if err := validateNodePublicAddress(data); err != nil {
+				return err
+			}
+			if _, ok := data["housekeeping"]; ok {
+				if err := validateNodeHousekeeping(data); err != nil {
+					return err
+				}
+			}
+			if err := validateNodeTimezone(data); err != nil {
+				return err
+			}
+			verifyNodeWildcardOnUpdate(ctx, existing, data)
+			return nil
+		}
+	}
+
 	// Wire template BeforeDelete: prevent deleting templates with active deployments
+	// Wire template BeforeCreate/BeforeUpdate: check the plan's features, merge the compose
+	// x-hoster extension, validate resource_ceilings, routing_options, trial, assets and config file templates, then validate setup_flow against variables
 	if tmplRes := cfg.Store.Resource("templates"); tmplRes != nil {
 		store := cfg.Store
+		tmplRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			if err := authCtx.Features.Require(features.BYOCompose); err != nil {
+				return err
+			}
+			if err := checkTemplateFeatures(authCtx.Features, data["compose_spec"]); err != nil {
+				return err
+			}
+			if err := mergeComposeExtension(data, nil); err != nil {
+				return err
+			}
+			lintComposeSpec(data)
+			if err := validateTemplateCeilings(data["resource_ceilings"], data["compose_spec"]); err != nil {
+				return err
+			}
+			if err := validateRoutingOptions(data["routing_options"]); err != nil {
+				return err
+			}
+			if err := validateTemplateTrial(data["trial"]); err != nil {
+				return err
+			}
+			if err := validateTemplateAssets(data["assets"]); err != nil {
+				return err
+			}
+			if err := validateTemplateConfigFiles(data["config_files"]); err != nil {
+				return err
+			}
+			return validateTemplateSetupFlow(data["variables"], data["setup_flow"])
+		}
+		tmplRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			if spec, ok := data["compose_spec"]; ok {
+				if err := checkTemplateFeatures(authCtx.Features, spec); err != nil {
+					return err
+				}
+			}
+			if err := mergeComposeExtension(data, existing); err != nil {
+				return err
+			}
+			lintComposeSpec(data)
+			_, ceilingsChanged := data["resource_ceilings"]
+			_, specChanged := data["compose_spec"]
+			if ceilingsChanged || specChanged {
+				ceilings, spec := existing["resource_ceilings"], existing["compose_spec"]
+				if ceilingsChanged {
+					ceilings = data["resource_ceilings"]
+				}
+				if specChanged {
+					spec = data["compose_spec"]
+				}
+				if err := validateTemplateCeilings(ceilings, spec); err != nil {
+					return err
+				}
+			}
+			if routing, ok := data["routing_options"]; ok {
+				if err := validateRoutingOptions(routing); err != nil {
+					return err
+				}
+			}
+			if trial, ok := data["trial"]; ok {
+				if err := validateTemplateTrial(trial); err != nil {
+					return err
+				}
+			}
+			if assets, ok := data["assets"]; ok {
+				if err := validateTemplateAssets(assets); err != nil {
+					return err
+				}
+			}
+			if files, ok := data["config_files"]; ok {
+				if err := validateTemplateConfigFiles(files); err != nil {
+					return err
+				}
+			}
+			_, varsChanged := data["variables"]
+			_, flowChanged := data["setup_flow"]
+			if !varsChanged && !flowChanged {
+				return nil
+			}
+			variables, setupFlow := existing["variables"], existing["setup_flow"]
+			if varsChanged {
+				variables = data["variables"]
+			}
+			if flowChanged {
+				setupFlow = data["setup_flow"]
+			}
+			return validateTemplateSetupFlow(variables, setupFlow)
+		}
 		tmplRes.BeforeDelete = func(ctx context.Context, authCtx AuthContext, row map[string]any) error {
 			tmplID, ok := toInt64(row["id"])
 			if !ok {
@@ -96,17 +356,30 @@ func Setup(cfg SetupConfig) http.Handler {
 			if err == nil && len(depls) > 0 {
 				return fmt.Errorf("cannot delete template: it has active deployments")
 			}
+			// The template's webhooks go with it
+			hooks, err := store.List(ctx, "webhooks", []Filter{{Field: "template_id", Value: tmplID}}, Page{Limit: 100})
+			if err != nil {
+				return fmt.Errorf("cannot delete template: %w", err)
+			}
+			for _, hook := range hooks {
+				if err := store.Delete(ctx, "webhooks", strVal(hook["reference_id"])); err != nil {
+					return fmt.Errorf("cannot delete template: %w", err)
+				}
+			}
 			return nil
 		}
 	}
 
-	// Wire deployment BeforeCreate: plan limit check + resolve template_version from template
-	// Wire deployment AfterCreate: record billing event
+	// Wire deployment BeforeCreate: plan limit check + spending limit + resource quota + trial expiry + resolve template_version from template
+	// Wire deployment BeforeTransition: resource quota on scheduling and starting
+	// Wire deployment BeforeUpdate: validate upgrade policy + maintenance windows + schedule + affinity + resource ceilings
+	// Wire deployment AfterCreate: record billing event + schedule trial expiry + template event
+	// Wire deployment AfterRead: banners for open incidents, endpoints, maintenance preview
 	if deplRes := cfg.Store.Resource("deployments"); deplRes != nil {
 		store := cfg.Store
 		deplRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
 			// Check plan limits
-			if authCtx.PlanLimits.MaxDeployments > 0 {
+			if maxDeployments, limited := authCtx.Features.Limit(features.MaxDeployments); limited {
 				existing, err := store.List(ctx, "deployments", []Filter{
 					{Field: "customer_id", Value: authCtx.UserID},
 				}, Page{Limit: 1000, Offset: 0})
@@ -118,11 +391,50 @@ func Setup(cfg SetupConfig) http.Handler {
 							active++
 						}
 					}
-					if active >= authCtx.PlanLimits.MaxDeployments {
-						return fmt.Errorf("plan limit reached: maximum %d deployments allowed", authCtx.PlanLimits.MaxDeployments)
+					if int64(active) >= maxDeployments {
+						return fmt.Errorf("plan limit reached: maximum %d deployments allowed", maxDeployments)
+					}
+				}
+			}
+			if err := checkSpendingLimit(ctx, store, cfg.UsagePrices, authCtx.UserID, time.Now()); err != nil {
+				return err
+			}
+			if err := checkDeploymentQuota(ctx, store, authCtx, authCtx.UserID, data); err != nil {
+				return err
+			}
+			if err := validateDeploymentUpgradePolicy(data); err != nil {
+				return err
+			}
+			if err := validateDeploymentSchedule(data["schedule"]); err != nil {
+				return err
+			}
+			if err := validateDeploymentSecretRefs(data["variables"]); err != nil {
+				return err
+			}
+			if err := validateColocation(ctx, store, authCtx.UserID, "", data["colocate_with"]); err != nil {
+				return err
+			}
+			if err := validateDeploymentLabels(data["labels"]); err != nil {
+				return err
+			}
+			if err := validateRoutingOptions(data["routing_options"]); err != nil {
+				return err
+			}
+			// Enforce the template's guided setup flow and resource ceilings
+			if tid, ok := toInt64(data["template_id"]); ok && tid > 0 {
+				if tmpl, err := store.GetByID(ctx, "templates", int(tid)); err == nil {
+					if err := checkDeploymentSetup(tmpl, data["variables"]); err != nil {
+						return err
+					}
+					if err := checkDeploymentCeilings(tmpl, data["service_overrides"], data["resources_cpu_cores"]); err != nil {
+						return err
 					}
 				}
 			}
+			// Trials expire after the plan's or the template's trial length
+			if err := applyTrialExpiry(ctx, store, authCtx, data, time.Now()); err != nil {
+				return err
+			}
 			// If template_version not set, copy from template
 			if _, ok := data["template_version"]; !ok || data["template_version"] == nil || data["template_version"] == "" {
 				if tid, ok := toInt64(data["template_id"]); ok && tid > 0 {
@@ -134,12 +446,133 @@ func Setup(cfg SetupConfig) http.Handler {
 			}
 			return nil
 		}
+		deplRes.BeforeTransition = func(ctx context.Context, authCtx AuthContext, row map[string]any, state string) error {
+			if state != "scheduled" && state != "starting" {
+				return nil
+			}
+			customerID, _ := toInt64(row["customer_id"])
+			return checkDeploymentQuota(ctx, store, authCtx, int(customerID), row)
+		}
+		deplRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			if vars, ok := data["variables"]; ok {
+				if err := validateDeploymentSecretRefs(vars); err != nil {
+					return err
+				}
+			}
+			if labels, ok := data["labels"]; ok {
+				if err := validateDeploymentLabels(labels); err != nil {
+					return err
+				}
+			}
+			if routing, ok := data["routing_options"]; ok {
+				if err := validateRoutingOptions(routing); err != nil {
+					return err
+				}
+			}
+			// Overrides and resizes stay within the template's resource ceilings
+			_, overridesChanged := data["service_overrides"]
+			_, cpuChanged := data["resources_cpu_cores"]
+			if overridesChanged || cpuChanged {
+				overrides, cpu := existing["service_overrides"], existing["resources_cpu_cores"]
+				if overridesChanged {
+					overrides = data["service_overrides"]
+				}
+				if cpuChanged {
+					cpu = data["resources_cpu_cores"]
+				}
+				if tid, ok := toInt64(existing["template_id"]); ok && tid > 0 {
+					if tmpl, err := store.GetByID(ctx, "templates", int(tid)); err == nil {
+						if err := checkDeploymentCeilings(tmpl, overrides, cpu); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			// Affinity is a placement hint, so it can only change before placement
+			if peer, ok := data["colocate_with"]; ok && strVal(peer) != strVal(existing["colocate_with"]) {
+				if s := strVal(existing["status"]); s != "pending" {
+					return fmt.Errorf("colocate_with can only be changed while the deployment is pending, not %s", s)
+				}
+				if err := validateColocation(ctx, store, authCtx.UserID, strVal(existing["reference_id"]), peer); err != nil {
+					return err
+				}
+			}
+			// A new schedule starts from now, not from the old one's next action
+			if sched, ok := data["schedule"]; ok {
+				if err := validateDeploymentSchedule(sched); err != nil {
+					return err
+				}
+				data["schedule_next_at"] = nil
+				data["schedule_next_action"] = nil
+				data["schedule_error"] = nil
+			}
+			merged := map[string]any{}
+			changed := false
+			for _, field := range []string{"upgrade_policy", "maintenance_windows", "upgrade_strategy", "canary_policy"} {
+				merged[field] = existing[field]
+				if v, ok := data[field]; ok {
+					merged[field] = v
+					changed = true
+				}
+			}
+			if !changed {
+				return nil
+			}
+			return validateDeploymentUpgradePolicy(merged)
+		}
 		deplRes.AfterCreate = func(ctx context.Context, authCtx AuthContext, row map[string]any) {
 			refID, _ := row["reference_id"].(string)
 			if refID != "" && authCtx.UserID > 0 {
 				billing.RecordEvent(ctx, store, authCtx.UserID, domain.EventDeploymentCreated, refID, "deployment", nil)
 			}
+			if cfg.Bus != nil {
+				if err := scheduleExpiry(ctx, cfg.Bus, row, time.Now()); err != nil {
+					cfg.Logger.Error("failed to schedule deployment expiry", "deployment", refID, "error", err)
+				}
+			}
+			if cfg.Notifier != nil {
+				cfg.Notifier.NotifyTemplate(row, corenotify.EventTemplateDeploymentCreated)
+			}
 		}
+		// Show banners for open incidents affecting the deployment, published
+		// ports at the node's public address, and the next maintenance window
+		deplRes.AfterRead = chainAfterRead(incidentBanners(store, cfg.Logger), deploymentEndpoints(store, cfg.Logger), maintenanceSchedule(store))
+	}
+
+	// Wire template AfterRead: install count and rating summary
+	if tmplRes := cfg.Store.Resource("templates"); tmplRes != nil {
+		tmplRes.AfterRead = templateRatings(cfg.Store, cfg.Logger)
+	}
+
+	// Wire review hooks: only customers who deployed the template, once each
+	if revRes := cfg.Store.Resource("template_reviews"); revRes != nil {
+		revRes.BeforeCreate = reviewBeforeCreate(cfg.Store)
+		revRes.BeforeUpdate = reviewBeforeUpdate()
+	}
+
+	// Wire placement rule hooks: rules name the creator's own nodes and templates
+	if ruleRes := cfg.Store.Resource("placement_rules"); ruleRes != nil {
+		ruleRes.BeforeCreate = placementRuleBeforeCreate(cfg.Store)
+		ruleRes.BeforeUpdate = placementRuleBeforeUpdate(cfg.Store)
+	}
+
+	// Wire deployment pipeline hooks: stages name the customer's own
+	// deployments of the pipeline's template
+	if pipeRes := cfg.Store.Resource("deployment_pipelines"); pipeRes != nil {
+		pipeRes.BeforeCreate = pipelineBeforeCreate(cfg.Store)
+		pipeRes.BeforeUpdate = pipelineBeforeUpdate(cfg.Store)
+	}
+
+	// Wire node cost hooks: entries name the creator's own nodes
+	if costRes := cfg.Store.Resource("node_costs"); costRes != nil {
+		costRes.BeforeCreate = nodeCostBeforeCreate(cfg.Store)
+		costRes.BeforeUpdate = nodeCostBeforeUpdate(cfg.Store)
+	}
+
+	// Wire incident hooks: scope must name the operator's own nodes and deployments
+	if incRes := cfg.Store.Resource("incidents"); incRes != nil {
+		incRes.BeforeCreate = incidentBeforeCreate(cfg.Store)
+		incRes.BeforeUpdate = incidentBeforeUpdate(cfg.Store)
 	}
 
 	// Wire cloud provision BeforeCreate: resolve provider from credential + verify ownership + auto-generate SSH key
@@ -201,25 +634,257 @@ func Setup(cfg SetupConfig) http.Handler {
 		}
 	}
 
+	// Wire earnings ledger + payouts as system-managed (read-only via API)
+	for _, name := range []string{"creator_earnings", "payouts"} {
+		if res := cfg.Store.Resource(name); res != nil {
+			res.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+				return fmt.Errorf("%s are recorded automatically and cannot be created", name)
+			}
+			res.BeforeDelete = func(ctx context.Context, authCtx AuthContext, row map[string]any) error {
+				return fmt.Errorf("%s cannot be deleted", name)
+			}
+		}
+	}
+
+	// Wire payout account BeforeDelete: keep accounts with unpaid earnings
+	if acctRes := cfg.Store.Resource("payout_accounts"); acctRes != nil {
+		store := cfg.Store
+		acctRes.BeforeDelete = func(ctx context.Context, authCtx AuthContext, row map[string]any) error {
+			creatorID, _ := toInt64(row["creator_id"])
+			inFlight, err := store.List(ctx, "creator_earnings", []Filter{
+				{Field: "creator_id", Value: creatorID},
+				{Field: "status", Value: "in_payout"},
+			}, Page{Limit: 1})
+			if err == nil && len(inFlight) > 0 {
+				return fmt.Errorf("cannot delete payout account: a payout is in progress")
+			}
+			return nil
+		}
+	}
+
+	// Wire notification channel BeforeCreate/BeforeUpdate: validate type, config and
+	// event routing; config is encrypted at creation, so it cannot be changed later
+	if chRes := cfg.Store.Resource("notification_channels"); chRes != nil {
+		chRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			return validateNotificationChannel(cfg.Notifier, data)
+		}
+		chRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			if t, ok := data["type"]; ok && strVal(t) != strVal(existing["type"]) {
+				return fmt.Errorf("type cannot be changed; create a new channel")
+			}
+			if _, ok := data["config"]; ok {
+				return fmt.Errorf("config cannot be changed; create a new channel")
+			}
+			if events, ok := data["events"]; ok {
+				return validateNotificationEvents(events)
+			}
+			return nil
+		}
+	}
+
+	// Wire webhook BeforeCreate/BeforeUpdate: validate endpoint, secret and event
+	// routing; the secret is encrypted at creation, so it cannot be changed later,
+	// and a webhook stays a template's webhook or the user's own
+	if whRes := cfg.Store.Resource("webhooks"); whRes != nil {
+		whRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			return validateWebhook(ctx, cfg.Store, authCtx, data)
+		}
+		whRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			if _, ok := data["secret"]; ok {
+				return fmt.Errorf("secret cannot be changed; create a new webhook")
+			}
+			if _, ok := data["template_id"]; ok {
+				return fmt.Errorf("template_id cannot be changed; create a new webhook")
+			}
+			if u, ok := data["url"]; ok {
+				if err := corewebhook.ValidateURL(strVal(u)); err != nil {
+					return err
+				}
+			}
+			if events, ok := data["events"]; ok {
+				return validateWebhookEvents(events, webhookTemplate(existing))
+			}
+			return nil
+		}
+	}
+	if cfg.Notifier != nil {
+		cfg.Store.OnTransition(cfg.Notifier.onTransition)
+	}
+
+	// Wire secret BeforeCreate/BeforeUpdate: names are unique per customer and
+	// fixed once created, since templates reference them
+	if secRes := cfg.Store.Resource("secrets"); secRes != nil {
+		secRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			return validateSecret(ctx, cfg.Store, authCtx.UserID, data)
+		}
+		secRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			if !cfg.Store.Encrypting() {
+				return errSecretsNeedKey
+			}
+			if n, ok := data["name"]; ok && strVal(n) != strVal(existing["name"]) {
+				return fmt.Errorf("name cannot be changed; create a new secret")
+			}
+			if v, ok := data["value"]; ok {
+				return validateSecretValue(v)
+			}
+			return nil
+		}
+	}
+
+	// Wire deployment collaborator BeforeCreate/BeforeUpdate: collaborators are
+	// invited through the deployment, and only their role can change
+	if collabRes := cfg.Store.Resource("deployment_collaborators"); collabRes != nil {
+		collabRes.BeforeCreate = func(ctx context.Context, authCtx AuthContext, data map[string]any) error {
+			return fmt.Errorf("invite collaborators with POST /deployments/{id}/collaborators")
+		}
+		collabRes.BeforeUpdate = func(ctx context.Context, authCtx AuthContext, existing, data map[string]any) error {
+			return validateCollaboratorUpdate(data)
+		}
+	}
+
 	// Register generic CRUD + state machine routes for all resources
-	RegisterRoutes(router, APIConfig{
+	api := APIConfig{
 		Store:          cfg.Store,
-		Bus:            cfg.Bus,
 		Logger:         cfg.Logger,
 		ActionHandlers: buildActionHandlers(cfg),
-	})
+	}
+	if cfg.Bus != nil {
+		api.Bus = cfg.Bus
+	}
+	RegisterRoutes(router, api)
+
+	// GraphQL API over the same resources and operations
+	if !cfg.ReadOnly {
+		if schema, err := newGraphQLSchema(api); err != nil {
+			cfg.Logger.Error("graphql schema build failed", "error", err)
+		} else {
+			router.HandleFunc(graphqlPath, graphqlHandler(schema)).Methods("POST")
+		}
+	}
 
 	// Domain sub-resource routes (require hostname in path, can't use action pattern)
-	router.HandleFunc("/api/v1/deployments/{id}/domains/{hostname}", domainRemoveHandler(cfg)).Methods("DELETE")
-	router.HandleFunc("/api/v1/deployments/{id}/domains/{hostname}/verify", domainVerifyHandler(cfg)).Methods("POST")
+	handleVersioned(router, "/deployments/{id}/domains/{hostname}", domainRemoveHandler(cfg), "DELETE")
+	handleVersioned(router, "/deployments/{id}/domains/{hostname}/verify", domainVerifyHandler(cfg), "POST")
+	handleVersioned(router, "/deployments/{id}/domains/{hostname}/cutover", domainCutoverHandler(cfg), "POST")
+
+	// Deployment pipelines: approve or reject a promotion awaiting approval
+	handleVersioned(router, "/deployment_pipelines/{id}/promotions/{promotion}/approve", pipelineDecisionHandler(cfg, true), "POST")
+	handleVersioned(router, "/deployment_pipelines/{id}/promotions/{promotion}/reject", pipelineDecisionHandler(cfg, false), "POST")
+
+	// Template registry: import a signed bundle from another instance
+	handleVersioned(router, "/templates/import", templateImportHandler(cfg), "POST")
+
+	// Template validation: lint a compose spec with line positions
+	handleVersioned(router, "/templates/validate", templateValidateHandler(cfg), "POST")
+
+	// Template uploads: chunks of a file uploaded in pieces
+	handleVersioned(router, "/template-uploads/{id}", templateUploadHandler(cfg), "GET", "PUT", "DELETE")
+
+	// Command history: retry a failed command with its original payload
+	handleVersioned(router, "/command-executions/{id}/retry", commandRetryHandler(cfg), "POST")
 
 	// Billing endpoints
-	router.HandleFunc("/api/v1/billing/verify-payment", verifyPaymentHandler(cfg)).Methods("GET")
+	handleVersioned(router, "/billing/verify-payment", verifyPaymentHandler(cfg), "GET")
+
+	// Creator earnings report
+	handleVersioned(router, "/creator/earnings", creatorEarningsHandler(cfg), "GET")
+	handleVersioned(router, "/creator/margins", creatorMarginsHandler(cfg), "GET")
+
+	// Daily stats: creator dashboard; platform and node dashboards, on-demand rollup (admin)
+	handleVersioned(router, "/creator/stats", creatorStatsHandler(cfg), "GET")
+	handleVersioned(router, "/admin/stats", adminStatsHandler(cfg), "GET")
+	handleVersioned(router, "/admin/stats/nodes", adminNodeStatsHandler(cfg), "GET")
+	handleVersioned(router, "/admin/stats/rollup", adminStatsRollupHandler(cfg), "POST")
+
+	// User preferences: time zone for schedules
+	handleVersioned(router, "/preferences", preferencesHandler(cfg), "GET", "PATCH")
+
+	// API tokens: created and revoked by their owner
+	handleVersioned(router, "/api-tokens", apiTokensHandler(cfg), "GET", "POST")
+	handleVersioned(router, "/api-tokens/{id}", apiTokenRevokeHandler(cfg), "DELETE")
+
+	// Spending limit: the caller's limit and month spend; overrides for trusted accounts (admin)
+	handleVersioned(router, "/spending-limit", spendingLimitHandler(cfg), "GET", "PUT", "DELETE")
+	handleVersioned(router, "/admin/users/{id}/spending-override", spendingOverrideHandler(cfg), "POST", "DELETE")
+
+	// Node key rotation policy: the caller's max key age, auto-rotation and dual-valid window
+	handleVersioned(router, "/node-key-policy", nodeKeyPolicyHandler(cfg), "GET", "PUT", "DELETE")
+
+	// Feature flags: the caller's effective flags; per-user overrides (admin)
+	handleVersioned(router, "/features", featuresHandler(cfg), "GET")
+	handleVersioned(router, "/admin/users/{id}/features", userFeaturesHandler(cfg), "GET", "PATCH")
+
+	// Notification settings: enabled channel types, event types, VAPID key
+	handleVersioned(router, "/notifications/settings", notificationSettingsHandler(cfg), "GET")
+
+	// Deployment sharing: accept an invitation, list deployments shared with me
+	handleVersioned(router, "/collaborator-invitations/accept", invitationAcceptHandler(cfg), "POST")
+	handleVersioned(router, "/shared-deployments", sharedDeploymentsHandler(cfg), "GET")
+
+	// Public status: open and recently resolved incidents
+	handleVersioned(router, "/status", statusHandler(cfg), "GET")
+
+	// Change events for the user's own rows (server-sent events)
+	handleVersioned(router, "/events", eventsHandler(cfg, events), "GET")
+
+	// Review moderation queue: open abuse reports
+	handleVersioned(router, "/review-reports", reviewReportsHandler(cfg), "GET")
+
+	// Audit log: mutating API requests, filtered by user, resource and time
+	handleVersioned(router, "/audit-events", auditEventsHandler(cfg), "GET")
+
+	// Admin: scheduled commands (list, cancel by key)
+	handleVersioned(router, "/admin/scheduled-commands", scheduledJobsHandler(cfg), "GET")
+	handleVersioned(router, "/admin/scheduled-commands/{key}", scheduledJobCancelHandler(cfg), "DELETE")
+
+	// Admin: command jobs (list, dead-letter queue, requeue)
+	handleVersioned(router, "/admin/jobs", jobsHandler(cfg), "GET")
+	handleVersioned(router, "/admin/jobs/{id}", jobHandler(cfg), "GET")
+	handleVersioned(router, "/admin/jobs/{id}/requeue", jobRequeueHandler(cfg), "POST")
+
+	// Abuse flags: deployments flagged by usage anomaly detection
+	handleVersioned(router, "/admin/abuse", abuseHandler(cfg), "GET")
+	handleVersioned(router, "/admin/abuse/{id}", abuseDismissHandler(cfg), "DELETE")
+
+	// Template playgrounds: session status, signup conversion, conversion report
+	handleVersioned(router, "/playground/{token}", playgroundSessionHandler(cfg), "GET")
+	handleVersioned(router, "/playground/{token}/convert", playgroundConvertHandler(cfg), "POST")
+	handleVersioned(router, "/admin/playground", adminPlaygroundHandler(cfg), "GET")
+
+	// Admin: chaos testing fault rules, only with fault injection enabled
+	if cfg.Faults != nil {
+		handleVersioned(router, "/admin/faults", faultsHandler(cfg), "GET", "POST", "DELETE")
+		handleVersioned(router, "/admin/faults/{id}", faultHandler(cfg), "DELETE")
+	}
+
+	// Log export archive download, authorized by its signed link
+	handleVersioned(router, "/log-exports/{id}/download", logExportDownloadHandler(cfg), "GET")
+
+	// Error catalog (targets of problem type URIs)
+	handleVersioned(router, "/problems", problemsHandler, "GET")
+	handleVersioned(router, "/problems/{code}", problemHandler, "GET")
+
+	// API version discovery
+	router.HandleFunc("/api/versions", apiVersionsHandler(cfg.APILifecycles)).Methods("GET")
+
+	// Unknown API paths get a problem response rather than the Web UI
+	router.PathPrefix("/api/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, ProblemNotFound, "no such endpoint: "+r.Method+" "+r.URL.Path)
+	})
 
 	// Serve embedded Web UI for all other paths (SPA pattern)
 	router.PathPrefix("/").Handler(spaHandler())
 
-	return router
+	if cfg.Routes == nil {
+		return router
+	}
+
+	// Internal endpoints skip the API middleware: they serve hoster's own
+	// proxies, sit on their request path, and are guarded by internalOnly
+	root := mux.NewRouter()
+	root.Handle("/internal/routes", internalOnly(cfg.InternalSecret, internalRoutesHandler(cfg.Routes))).Methods("GET")
+	root.PathPrefix("/").Handler(router)
+	return root
 }
 
 // buildActionHandlers creates custom action handlers beyond standard CRUD.
@@ -233,13 +898,13 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		tmpl, err := cfg.Store.Get(ctx, "templates", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "template not found")
+			writeProblem(w, r, ProblemNotFound, "template not found")
 			return
 		}
 
@@ -248,24 +913,24 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		if !ok {
 			cfg.Logger.Warn("ownership check failed: unparseable creator_id",
 				"resource", "templates", "value", tmpl["creator_id"])
-			writeError(w, http.StatusForbidden, "access denied")
+			writeProblem(w, r, ProblemForbidden, "access denied")
 			return
 		}
 		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
 		row, err := cfg.Store.Update(ctx, "templates", id, map[string]any{"published": 1})
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeProblem(w, r, ProblemInternal, err.Error())
 			return
 		}
 
 		res := cfg.Store.Resource("templates")
 		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI("templates", row),
+			"data": renderResource(r, cfg.Store, "templates", row),
 		})
 	}
 
@@ -276,29 +941,46 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		existing, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
-		// Check ownership — fail closed
-		ownerID, ok := toInt64(existing["customer_id"])
-		if !ok {
-			cfg.Logger.Warn("ownership check failed: unparseable customer_id",
-				"resource", "deployments", "value", existing["customer_id"])
-			writeError(w, http.StatusForbidden, "access denied")
+		// Owners and operators may start — fail closed
+		if !authorizeDeployment(w, r, cfg, existing, sharing.PermOperate) {
 			return
 		}
-		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+
+		// Volumes must not change while they are being migrated
+		if migrating, err := cfg.Store.HasActiveVolumeMigration(ctx, strVal(existing["reference_id"])); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to check volume migrations")
+			return
+		} else if migrating {
+			writeProblem(w, r, ProblemOperationInProgress, "cannot start deployment while its volumes are migrating")
+			return
+		}
+		if !requireNoDeploymentMigration(w, r, cfg, existing, "start") {
 			return
 		}
 
+		// Expired trials stay stopped until converted
+		if e, ok := deploymentExpiry(existing); ok && e.Expired(time.Now()) {
+			writeProblem(w, r, ProblemInvalidState, "trial deployment expired; convert it to start it again")
+			return
+		}
+		// The customer's hard stop pauses starts once their limit is spent
+		if customerID, ok := toInt64(existing["customer_id"]); ok {
+			if err := checkSpendingLimit(ctx, cfg.Store, cfg.UsagePrices, int(customerID), time.Now()); err != nil {
+				writeSpendingProblem(w, r, err)
+				return
+			}
+		}
+
 		status, _ := existing["status"].(string)
 
 		// Determine target state based on current status
@@ -311,33 +993,36 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		case "stopped", "failed":
 			targetState = "starting"
 		default:
-			writeError(w, http.StatusConflict, "cannot start deployment in state: "+status)
+			writeProblem(w, r, ProblemInvalidState, "cannot start deployment in state: "+status)
+			return
+		}
+
+		// Starting reserves the deployment's resources against the customer's quota
+		customerID, _ := toInt64(existing["customer_id"])
+		if err := checkDeploymentQuota(ctx, cfg.Store, authCtx, int(customerID), existing); err != nil {
+			writeQuotaProblem(w, r, err)
 			return
 		}
 
 		row, cmd, err := cfg.Store.Transition(ctx, "deployments", id, targetState)
 		if err != nil {
-			writeError(w, http.StatusConflict, err.Error())
+			writeProblem(w, r, ProblemInvalidState, err.Error())
 			return
 		}
 
-		// Dispatch command in background so the HTTP response returns immediately.
-		// Long-running commands (like StartDeployment) would otherwise block the
-		// response and risk context cancellation when the client disconnects.
+		// Queue the command so the HTTP response returns immediately.
+		// Long-running commands (like StartDeployment) would otherwise block
+		// the response, and the queued job survives a restart.
 		if cmd != "" && cfg.Bus != nil {
-			cmdRow := maps.Clone(row)
-			go func() {
-				bgCtx := context.Background()
-				if err := cfg.Bus.Dispatch(bgCtx, cmd, cmdRow); err != nil {
-					cfg.Logger.Error("command dispatch failed", "command", cmd, "error", err)
-				}
-			}()
+			if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+				cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
+			}
 		}
 
 		res := cfg.Store.Resource("deployments")
 		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI("deployments", row),
+			"data": renderResource(r, cfg.Store, "deployments", row),
 		})
 	}
 
@@ -348,63 +1033,69 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		existing, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
-		ownerID, ok := toInt64(existing["customer_id"])
-		if !ok {
-			cfg.Logger.Warn("ownership check failed: unparseable customer_id",
-				"resource", "deployments", "value", existing["customer_id"])
-			writeError(w, http.StatusForbidden, "access denied")
+		// Owners and operators may stop — fail closed
+		if !authorizeDeployment(w, r, cfg, existing, sharing.PermOperate) {
 			return
 		}
-		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+		if !requireNoDeploymentMigration(w, r, cfg, existing, "stop") {
 			return
 		}
 
 		row, cmd, err := cfg.Store.Transition(ctx, "deployments", id, "stopping")
 		if err != nil {
-			writeError(w, http.StatusConflict, err.Error())
+			writeProblem(w, r, ProblemInvalidState, err.Error())
 			return
 		}
 
 		if cmd != "" && cfg.Bus != nil {
-			cmdRow := maps.Clone(row)
-			go func() {
-				bgCtx := context.Background()
-				if err := cfg.Bus.Dispatch(bgCtx, cmd, cmdRow); err != nil {
-					cfg.Logger.Error("command dispatch failed", "command", cmd, "error", err)
-				}
-			}()
+			if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+				cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
+			}
 		}
 
 		res := cfg.Store.Resource("deployments")
 		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI("deployments", row),
+			"data": renderResource(r, cfg.Store, "deployments", row),
 		})
 	}
 
 	// Deployment: monitoring/health
+	// (per-service health recorded by the service health monitor; unknown
+	// until a running deployment has been checked)
 	handlers["deployments:monitoring/health"] = monitoringHandler(cfg, "deployment-health", func(ctx context.Context, cfg SetupConfig, depl map[string]any, r *http.Request) map[string]any {
 		refID, _ := depl["reference_id"].(string)
-		now := time.Now().UTC().Format(time.RFC3339)
+		health := domain.DeploymentHealth{Status: domain.HealthStatusUnknown}
+		if strVal(depl["status"]) == "running" {
+			if err := decodeJSONValue(depl["health"], &health); err != nil {
+				cfg.Logger.Warn("invalid stored deployment health", "deployment", refID, "error", err)
+			}
+		}
+		if health.Containers == nil {
+			health.Containers = []domain.ContainerHealth{}
+		}
+		var checkedAt any
+		if !health.CheckedAt.IsZero() {
+			checkedAt = health.CheckedAt.UTC().Format(time.RFC3339)
+		}
 		return map[string]any{
 			"data": map[string]any{
 				"type": "deployment-health",
 				"id":   refID,
 				"attributes": map[string]any{
-					"status":     "unknown",
-					"containers": []any{},
-					"checked_at": now,
+					"status":     health.Status,
+					"containers": health.Containers,
+					"checked_at": checkedAt,
 				},
 			},
 		}
@@ -443,9 +1134,72 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 	// Deployment: domains (list + add, dispatched by HTTP method)
 	handlers["deployments:domains"] = domainHandler(cfg)
 
+	// Deployment: check the variables for, approve, or abort a pending upgrade
+	handlers["deployments:upgrade/check"] = upgradeCheckHandler(cfg)
+	handlers["deployments:upgrade/approve"] = upgradeApproveHandler(cfg)
+	handlers["deployments:upgrade/abort"] = upgradeAbortHandler(cfg)
+
+	// Deployment: check pinned images for drift, or update them to their tags' latest digests
+	handlers["deployments:images/drift"] = imageDriftHandler(cfg)
+	handlers["deployments:images/update"] = imageUpdateHandler(cfg)
+
+	// Deployment: audit of secret reference resolutions
+	handlers["deployments:secret-resolutions"] = secretResolutionsHandler(cfg)
+
+	// Deployment: volume migrations (move volumes to another node; GET lists progress)
+	handlers["deployments:volume-migrations"] = volumeMigrationHandler(cfg)
+
+	// Deployment: migrate to another node or region (GET lists or streams progress)
+	handlers["deployments:migrate"] = deploymentMigrationHandler(cfg)
+
+	// Deployment: clone into a new deployment, optionally with its volume data
+	handlers["deployments:clone"] = deploymentCloneHandler(cfg)
+
+	// Deployment: managed object storage buckets (create via POST; GET lists usage)
+	handlers["deployments:buckets"] = deploymentBucketsHandler(cfg)
+
+	// Deployment: invite a collaborator (viewer or operator) by email
+	handlers["deployments:collaborators"] = deploymentCollaboratorInviteHandler(cfg)
+
+	// Deployment collaborator: renew and resend a pending invitation
+	handlers["deployment_collaborators:resend"] = collaboratorResendHandler(cfg)
+
 	// Node: maintenance (enter via POST, exit via DELETE)
 	handlers["nodes:maintenance"] = nodeMaintenanceHandler(cfg)
 
+	// Node: drain (POST starts, GET reports progress, DELETE cancels)
+	handlers["nodes:drain"] = nodeDrainHandler(cfg)
+
+	// Node: monitoring (load, disk, dockerd, journal history + alerts)
+	handlers["nodes:monitoring"] = nodeMonitoringHandler(cfg)
+
+	// Node: housekeeping (GET policy + run history; POST dry-run preview or queued run)
+	handlers["nodes:housekeeping"] = nodeHousekeepingHandler(cfg)
+
+	// Incident: append to the timeline
+	handlers["incidents:updates"] = incidentUpdateHandler(cfg)
+
+	// Template: published reviews with the rating summary
+	handlers["templates:reviews"] = templateReviewsHandler(cfg)
+
+	// Review: creator response, abuse report, moderation
+	handlers["template_reviews:response"] = reviewResponseHandler(cfg)
+	handlers["template_reviews:report"] = reviewReportHandler(cfg)
+	handlers["template_reviews:moderate"] = reviewModerateHandler(cfg)
+
+	// Placement rule: node diagnostics and conflicting rules
+	handlers["placement_rules:check"] = placementRuleCheckHandler(cfg)
+
+	// Deployment pipeline: promote a stage to the next, promotion history
+	handlers["deployment_pipelines:promote"] = pipelinePromoteHandler(cfg)
+	handlers["deployment_pipelines:promotions"] = pipelinePromotionsHandler(cfg)
+
+	// Node: minion command audit log
+	handlers["nodes:audit-log"] = nodeAuditLogHandler(cfg)
+
+	// Node: issue, rotate and revoke the SSH keys hoster connects with
+	handlers["nodes:keys"] = nodeKeysHandler(cfg)
+
 	// Cloud Credentials: regions catalog
 	handlers["cloud_credentials:regions"] = cloudCatalogHandler(cfg, func(provider string) any {
 		return coreprovider.StaticRegions(provider)
@@ -458,13 +1212,56 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 
 	// Invoice: pay (create Stripe Checkout session)
 	handlers["invoices:pay"] = invoicePayHandler(cfg)
+	handlers["invoices:export"] = invoiceExportHandler(cfg)
+
+	// Template: setup (guided variable wizard)
+	handlers["templates:setup"] = templateSetupHandler(cfg)
+
+	// Capacity reports: declared limits vs P95 usage
+	handlers["templates:capacity"] = templateCapacityHandler(cfg)
+	handlers["deployments:monitoring/capacity"] = deploymentCapacityHandler(cfg)
+
+	// Template: start a playground for an anonymous visitor
+	handlers["templates:playground"] = templatePlaygroundHandler(cfg)
+
+	// Template: export a signed bundle for another instance
+	handlers["templates:export"] = templateExportHandler(cfg)
+
+	// Deployment: log export archives (GET list, POST queue an export)
+	handlers["deployments:logs/export"] = logExportHandler(cfg)
+
+	// Deployment: tunnel to a container port (WebSocket upgrade), session history
+	handlers["deployments:tunnel"] = deploymentTunnelHandler(cfg)
+	handlers["deployments:tunnels"] = deploymentTunnelsHandler(cfg)
+
+	// Deployment: run a one-off command in a service container
+	handlers["deployments:exec"] = deploymentExecHandler(cfg)
+
+	// SLO dashboards: a deployment's compliance, a template's deployments
+	handlers["deployments:slo"] = deploymentSLOHandler(cfg)
+	handlers["templates:slo"] = templateSLOHandler(cfg)
+
+	// Payout account: Stripe Connect onboarding + status refresh
+	handlers["payout_accounts:onboard"] = payoutAccountOnboardHandler(cfg)
+	handlers["payout_accounts:refresh"] = payoutAccountRefreshHandler(cfg)
+
+	// Notification channel: send a test notification
+	handlers["notification_channels:test"] = notificationChannelTestHandler(cfg)
+
+	// Webhook: delivery log, replay of past events, pause/resume
+	handlers["webhooks:deliveries"] = webhookDeliveriesHandler(cfg)
+	handlers["webhooks:replay"] = webhookReplayHandler(cfg)
+	handlers["webhooks:pause"] = webhookPauseHandler(cfg, true)
+	handlers["webhooks:resume"] = webhookPauseHandler(cfg, false)
+
+	// Template: delivery history of the template's webhooks
+	handlers["templates:webhook-deliveries"] = templateWebhookDeliveriesHandler(cfg)
 
 	// Deployment: monitoring/events
 	handlers["deployments:monitoring/events"] = monitoringHandler(cfg, "deployment-events", func(ctx context.Context, cfg SetupConfig, depl map[string]any, r *http.Request) map[string]any {
 		refID, _ := depl["reference_id"].(string)
 		deplID, _ := toInt64(depl["id"])
 
-		// Query persisted container_events
 		limit := 50
 		if v := r.URL.Query().Get("limit"); v != "" {
 			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
@@ -472,29 +1269,10 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 			}
 		}
 
-		query := "SELECT id, type, container, message, timestamp FROM container_events WHERE deployment_id = ? ORDER BY timestamp DESC LIMIT ?"
-		args := []any{deplID, limit}
-
-		if eventType := r.URL.Query().Get("type"); eventType != "" {
-			query = "SELECT id, type, container, message, timestamp FROM container_events WHERE deployment_id = ? AND type = ? ORDER BY timestamp DESC LIMIT ?"
-			args = []any{deplID, eventType, limit}
-		}
-
-		rows, err := cfg.Store.RawQuery(ctx, query, args...)
+		events, err := containerEvents(ctx, cfg, deplID, r.URL.Query().Get("type"), limit)
 		if err != nil {
 			cfg.Logger.Warn("failed to query container events", "deployment", refID, "error", err)
-			rows = nil
-		}
-
-		events := make([]map[string]any, 0, len(rows))
-		for _, row := range rows {
-			events = append(events, map[string]any{
-				"id":        strVal(row["id"]),
-				"type":      strVal(row["type"]),
-				"container": strVal(row["container"]),
-				"message":   strVal(row["message"]),
-				"timestamp": strVal(row["timestamp"]),
-			})
+			events = []map[string]any{}
 		}
 
 		return map[string]any{
@@ -508,6 +1286,12 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		}
 	})
 
+	// Deployment: support bundle (status, health, timeline and crash forensics)
+	handlers["deployments:support-bundle"] = monitoringHandler(cfg, "deployment-support-bundles", supportBundle)
+
+	// Deployment: convert (keep a trial deployment as a paid one)
+	handlers["deployments:convert"] = deploymentConvertHandler(cfg)
+
 	// Cloud Provision: retry (transition failed → pending or failed → destroying)
 	handlers["cloud_provisions:retry"] = func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -515,13 +1299,13 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		prov, err := cfg.Store.Get(ctx, "cloud_provisions", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "provision not found")
+			writeProblem(w, r, ProblemNotFound, "provision not found")
 			return
 		}
 
@@ -529,17 +1313,17 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 		if !ok {
 			cfg.Logger.Warn("ownership check failed: unparseable creator_id",
 				"resource", "cloud_provisions", "value", prov["creator_id"])
-			writeError(w, http.StatusForbidden, "access denied")
+			writeProblem(w, r, ProblemForbidden, "access denied")
 			return
 		}
 		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
 		status, _ := prov["status"].(string)
 		if status != "failed" {
-			writeError(w, http.StatusConflict, "can only retry failed provisions")
+			writeProblem(w, r, ProblemInvalidState, "can only retry failed provisions")
 			return
 		}
 
@@ -554,23 +1338,32 @@ func buildActionHandlers(cfg SetupConfig) map[string]http.HandlerFunc {
 
 		row, cmd, err := cfg.Store.Transition(ctx, "cloud_provisions", id, targetState)
 		if err != nil {
-			writeError(w, http.StatusConflict, err.Error())
+			writeProblem(w, r, ProblemInvalidState, err.Error())
 			return
 		}
 
 		if cmd != "" && cfg.Bus != nil {
-			if err := cfg.Bus.Dispatch(ctx, cmd, row); err != nil {
-				cfg.Logger.Error("command dispatch failed", "command", cmd, "error", err)
+			if _, err := cfg.Bus.Enqueue(ctx, cmd, row); err != nil {
+				cfg.Logger.Error("command enqueue failed", "command", cmd, "error", err)
 			}
 		}
 
 		res := cfg.Store.Resource("cloud_provisions")
 		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI("cloud_provisions", row),
+			"data": renderResource(r, cfg.Store, "cloud_provisions", row),
 		})
 	}
 
+	// Template: upload config files and assets as multipart/form-data or in chunks
+	handlers["templates:config-files"] = templateFileUploadHandler(cfg, upload.KindConfigFile)
+	handlers["templates:assets"] = templateFileUploadHandler(cfg, upload.KindAsset)
+	handlers["templates:uploads"] = templateUploadCreateHandler(cfg)
+
+	// Command history: the commands run against a deployment or provision
+	handlers["deployments:commands"] = commandHistoryHandler(cfg, "deployments")
+	handlers["cloud_provisions:commands"] = commandHistoryHandler(cfg, "cloud_provisions")
+
 	return handlers
 }
 
@@ -583,23 +1376,23 @@ func cloudCatalogHandler(cfg SetupConfig, catalogFn func(provider string) any) h
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		cred, err := cfg.Store.Get(ctx, "cloud_credentials", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "credential not found")
+			writeProblem(w, r, ProblemNotFound, "credential not found")
 			return
 		}
 
 		ownerID, ok := toInt64(cred["creator_id"])
 		if !ok {
-			writeError(w, http.StatusForbidden, "access denied")
+			writeProblem(w, r, ProblemForbidden, "access denied")
 			return
 		}
 		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
@@ -626,19 +1419,19 @@ func nodeMaintenanceHandler(cfg SetupConfig) http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		node, err := cfg.Store.Get(ctx, "nodes", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "node not found")
+			writeProblem(w, r, ProblemNotFound, "node not found")
 			return
 		}
 
 		ownerID, ok := toInt64(node["creator_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
@@ -651,14 +1444,14 @@ func nodeMaintenanceHandler(cfg SetupConfig) http.HandlerFunc {
 
 		row, err := cfg.Store.Update(ctx, "nodes", id, map[string]any{"status": newStatus})
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err.Error())
+			writeProblem(w, r, ProblemInternal, err.Error())
 			return
 		}
 
 		res := cfg.Store.Resource("nodes")
 		stripFields(res, row, cfg.Store, authCtx)
 		writeJSON(w, http.StatusOK, map[string]any{
-			"data": rowToJSONAPI("nodes", row),
+			"data": renderResource(r, cfg.Store, "nodes", row),
 		})
 	}
 }
@@ -686,19 +1479,19 @@ func domainListHandler(cfg SetupConfig) http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		depl, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
 		ownerID, ok := toInt64(depl["customer_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
@@ -737,63 +1530,105 @@ func domainAddHandler(cfg SetupConfig) http.HandlerFunc {
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		depl, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
 		ownerID, ok := toInt64(depl["customer_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		if !requireFeature(w, r, features.CustomDomains) {
 			return
 		}
 
 		var body struct {
-			Hostname string `json:"hostname"`
+			Hostname  string `json:"hostname"`
+			Challenge string `json:"challenge"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Hostname == "" {
-			writeError(w, http.StatusBadRequest, "hostname is required")
+			writeProblem(w, r, ProblemValidationFailed, "hostname is required")
+			return
+		}
+
+		// With hoster issuing certificates, the domain picks its challenge
+		var challenge coreacme.Challenge
+		if cfg.Certificates != nil {
+			challenge, err = coreacme.ParseChallenge(body.Challenge)
+			if err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			if !cfg.Certificates.SupportsChallenge(challenge) {
+				writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("%s challenges are not available", challenge))
+				return
+			}
+		} else if body.Challenge != "" {
+			writeProblem(w, r, ProblemValidationFailed, "challenge is only accepted when hoster issues certificates")
 			return
 		}
 
+		body.Hostname = domain.NormalizeHostname(body.Hostname)
 		domains := parseDomainsList(depl["domains"])
 
 		// Check for duplicates
 		for _, d := range domains {
-			if d.Hostname == body.Hostname {
-				writeError(w, http.StatusConflict, "domain already exists")
+			if domain.NormalizeHostname(d.Hostname) == body.Hostname {
+				writeProblem(w, r, ProblemAlreadyExists, "domain already exists")
+				return
+			}
+		}
+
+		// Claim the hostname globally — another deployment may already own it
+		deplID, _ := toInt64(depl["id"])
+		if err := cfg.Store.ClaimDomain(ctx, int(deplID), body.Hostname, "custom"); err != nil {
+			if errors.Is(err, ErrDomainConflict) {
+				writeProblem(w, r, ProblemAlreadyExists, "hostname already in use by another deployment")
 				return
 			}
+			writeProblem(w, r, ProblemInternal, "failed to claim domain")
+			return
 		}
 
-		// Use stored auto domain as CNAME target, or generate from name
+		// Use stored auto domain as CNAME target, or generate from name;
+		// an A record to the node's public address is the alternative.
+		// A TXT record with the domain's token proves ownership ahead of both.
 		name, _ := depl["name"].(string)
 		cnameTarget := domain.Slugify(name) + "." + cfg.BaseDomain
+		token := randomString(32)
 		newDomain := DomainInfo{
 			Hostname:           body.Hostname,
 			Type:               "custom",
 			SSLEnabled:         false,
 			VerificationStatus: "pending",
 			VerificationMethod: "cname",
-			Instructions: []DNSInstruction{
-				{
-					Type:     "CNAME",
-					Name:     body.Hostname,
-					Value:    cnameTarget,
-					Priority: "required",
-				},
-			},
+			VerificationToken:  token,
+			Instructions: append(domainInstructions(body.Hostname, cnameTarget, nodePublicAddress(ctx, cfg.Store, strVal(depl["node_id"]))),
+				DNSInstruction(coredns.OwnershipInstruction(body.Hostname, token))),
+			Challenge: string(challenge),
+		}
+		if challenge == coreacme.ChallengeDNS01 {
+			// Delegating the challenge name lets hoster answer every renewal
+			newDomain.Instructions = append(newDomain.Instructions, DNSInstruction{
+				Type:     "CNAME",
+				Name:     coreacme.DNSChallengeName(body.Hostname),
+				Value:    cfg.Certificates.DelegationTarget(body.Hostname),
+				Priority: "required",
+			})
 		}
 		domains = append(domains, newDomain)
 
 		domainsJSON, _ := json.Marshal(domains)
 		if _, err := cfg.Store.Update(ctx, "deployments", id, map[string]any{"domains": string(domainsJSON)}); err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to update domains")
+			cfg.Store.ReleaseDomain(ctx, int(deplID), body.Hostname)
+			writeProblem(w, r, ProblemInternal, "failed to update domains")
 			return
 		}
 
@@ -811,19 +1646,19 @@ func domainRemoveHandler(cfg SetupConfig) http.HandlerFunc {
 		hostname := vars["hostname"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		depl, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
 		ownerID, ok := toInt64(depl["customer_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
@@ -839,21 +1674,30 @@ func domainRemoveHandler(cfg SetupConfig) http.HandlerFunc {
 		}
 
 		if !found {
-			writeError(w, http.StatusNotFound, "domain not found")
+			writeProblem(w, r, ProblemNotFound, "domain not found")
 			return
 		}
 
 		domainsJSON, _ := json.Marshal(filtered)
 		if _, err := cfg.Store.Update(ctx, "deployments", id, map[string]any{"domains": string(domainsJSON)}); err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to update domains")
+			writeProblem(w, r, ProblemInternal, "failed to update domains")
 			return
 		}
 
+		deplID, _ := toInt64(depl["id"])
+		if err := cfg.Store.ReleaseDomain(ctx, int(deplID), hostname); err != nil {
+			cfg.Logger.Warn("failed to release domain claim", "deployment", id, "hostname", hostname, "error", err)
+		}
+		if err := cfg.Store.DeleteDomainCertificate(ctx, hostname); err != nil {
+			cfg.Logger.Warn("failed to delete domain certificate", "deployment", id, "hostname", hostname, "error", err)
+		}
+
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// domainVerifyHandler checks DNS configuration for a custom domain.
+// domainVerifyHandler checks DNS configuration for a custom domain: whether
+// it points at the deployment, and whether its TXT record proves ownership.
 func domainVerifyHandler(cfg SetupConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -863,24 +1707,23 @@ func domainVerifyHandler(cfg SetupConfig) http.HandlerFunc {
 		hostname := vars["hostname"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		depl, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
 		ownerID, ok := toInt64(depl["customer_id"])
 		if !ok || int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+			writeProblem(w, r, ProblemForbidden, "not authorized")
 			return
 		}
 
-		name, _ := depl["name"].(string)
-		expectedTarget := domain.Slugify(name) + "." + cfg.BaseDomain
+		expectedTarget, expectedIPs := domainTargets(ctx, cfg.Store, cfg.BaseDomain, depl)
 
 		domains := parseDomainsList(depl["domains"])
 		found := false
@@ -890,38 +1733,20 @@ func domainVerifyHandler(cfg SetupConfig) http.HandlerFunc {
 			}
 			found = true
 
-			// Check DNS CNAME
-			verified := false
-			checkErr := ""
-			cnames, err := lookupCNAME(hostname)
-			if err != nil {
-				checkErr = err.Error()
-			} else {
-				for _, cname := range cnames {
-					if strings.TrimSuffix(cname, ".") == expectedTarget {
-						verified = true
-						break
-					}
-				}
-				if !verified {
-					checkErr = "CNAME not pointing to " + expectedTarget
-				}
+			// Domains added before ownership tokens get one now
+			if d.Type == "custom" && d.VerificationToken == "" {
+				domains[i].VerificationToken = randomString(32)
+				domains[i].Instructions = append(domains[i].Instructions,
+					DNSInstruction(coredns.OwnershipInstruction(hostname, domains[i].VerificationToken)))
 			}
 
-			if verified {
-				domains[i].VerificationStatus = "verified"
-				domains[i].SSLEnabled = true
-				now := time.Now().UTC().Format(time.RFC3339)
-				domains[i].VerifiedAt = now
-				domains[i].LastCheckError = ""
-			} else {
-				domains[i].VerificationStatus = "failed"
-				domains[i].LastCheckError = checkErr
-			}
+			// Check DNS CNAME or an A record to the node's public address,
+			// then the ownership TXT record
+			verifyDomain(ctx, &domains[i], expectedTarget, expectedIPs, time.Now())
 
 			domainsJSON, _ := json.Marshal(domains)
 			if _, err := cfg.Store.Update(ctx, "deployments", id, map[string]any{"domains": string(domainsJSON)}); err != nil {
-				writeError(w, http.StatusInternalServerError, "failed to update domains")
+				writeProblem(w, r, ProblemInternal, "failed to update domains")
 				return
 			}
 
@@ -930,11 +1755,98 @@ func domainVerifyHandler(cfg SetupConfig) http.HandlerFunc {
 		}
 
 		if !found {
-			writeError(w, http.StatusNotFound, "domain not found")
+			writeProblem(w, r, ProblemNotFound, "domain not found")
 		}
 	}
 }
 
+// domainCutoverHandler switches routing to a custom domain whose ownership a
+// TXT record proved. Routing switches at once when the domain already points
+// at the deployment, or with "immediate" so it is served the moment its DNS
+// changes; otherwise the DNS verifier switches it once the CNAME or A record
+// is changed.
+func domainCutoverHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		vars := mux.Vars(r)
+		id := vars["id"]
+		hostname := vars["hostname"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+
+		ownerID, ok := toInt64(depl["customer_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		var body struct {
+			Immediate bool `json:"immediate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body")
+			return
+		}
+
+		domains := parseDomainsList(depl["domains"])
+		i := slices.IndexFunc(domains, func(d DomainInfo) bool { return d.Hostname == hostname })
+		if i < 0 {
+			writeProblem(w, r, ProblemNotFound, "domain not found")
+			return
+		}
+		d := &domains[i]
+		if d.Type != "custom" {
+			writeProblem(w, r, ProblemInvalidState, "only custom domains are cut over")
+			return
+		}
+		if d.VerificationStatus == "verified" {
+			writeJSON(w, http.StatusOK, d)
+			return
+		}
+
+		now := time.Now()
+		if d.OwnershipVerifiedAt == "" {
+			if !verifyDomainOwnership(ctx, hostname, d.VerificationToken) {
+				writeProblem(w, r, ProblemInvalidState, fmt.Sprintf(
+					"ownership not verified: TXT record %s does not hold the domain's token", coredns.ChallengeName(hostname)))
+				return
+			}
+			d.OwnershipVerifiedAt = now.UTC().Format(time.RFC3339)
+		}
+
+		status := http.StatusOK
+		expectedTarget, expectedIPs := domainTargets(ctx, cfg.Store, cfg.BaseDomain, depl)
+		verified, method, checkErr := verifyDomainDNS(hostname, expectedTarget, expectedIPs)
+		switch {
+		case verified:
+			routeDomain(d, method, now)
+		case body.Immediate:
+			routeDomain(d, string(domain.DomainVerificationMethodTXT), now)
+		default:
+			d.CutoverRequestedAt = now.UTC().Format(time.RFC3339)
+			d.LastCheckError = checkErr
+			status = http.StatusAccepted
+		}
+
+		domainsJSON, _ := json.Marshal(domains)
+		if _, err := cfg.Store.Update(ctx, "deployments", id, map[string]any{"domains": string(domainsJSON)}); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to update domains")
+			return
+		}
+		writeJSON(w, status, d)
+	}
+}
+
 // Domain types matching the frontend
 type DomainInfo struct {
 	Hostname           string           `json:"hostname"`
@@ -945,6 +1857,23 @@ type DomainInfo struct {
 	VerifiedAt         string           `json:"verified_at,omitempty"`
 	LastCheckError     string           `json:"last_check_error,omitempty"`
 	Instructions       []DNSInstruction `json:"instructions,omitempty"`
+
+	// VerificationToken is the value of the TXT record that proves
+	// ownership of a custom domain; OwnershipVerifiedAt is when it did.
+	VerificationToken   string `json:"verification_token,omitempty"`
+	OwnershipVerifiedAt string `json:"ownership_verified_at,omitempty"`
+
+	// CutoverRequestedAt is set while the domain waits for its CNAME or A
+	// record to change before routing switches to it.
+	CutoverRequestedAt string `json:"cutover_requested_at,omitempty"`
+
+	// Challenge is how a custom domain proves control for its certificate
+	// when hoster issues certificates (acme.Challenge). CertificateStatus,
+	// CertificateError and SSLExpiresAt follow the certificate manager.
+	Challenge         string `json:"challenge,omitempty"`
+	CertificateStatus string `json:"certificate_status,omitempty"`
+	CertificateError  string `json:"certificate_error,omitempty"`
+	SSLExpiresAt      string `json:"ssl_expires_at,omitempty"`
 }
 
 type DNSInstruction struct {
@@ -985,6 +1914,112 @@ func parseDomainsList(v any) []DomainInfo {
 	return domains
 }
 
+// domainInstructions returns the DNS records that point hostname at a
+// deployment: a CNAME to its auto domain, or alternatively an A record to
+// its node's public IPv4 address when that is known.
+func domainInstructions(hostname, cnameTarget, publicAddr string) []DNSInstruction {
+	if !isPublicIPv4(publicAddr) {
+		return []DNSInstruction{{Type: "CNAME", Name: hostname, Value: cnameTarget, Priority: "required"}}
+	}
+	var out []DNSInstruction
+	for _, in := range coredns.GenerateInstructions(hostname, cnameTarget, publicAddr) {
+		out = append(out, DNSInstruction(in))
+	}
+	return out
+}
+
+// isPublicIPv4 reports whether addr is a public IPv4 address, usable as an
+// A record value.
+func isPublicIPv4(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() != nil && topology.IsPublicIP(addr)
+}
+
+// domainTargets returns what a deployment's custom domains must point at: a
+// CNAME target, and the node's public IPv4 address when it has one.
+func domainTargets(ctx context.Context, store *Store, baseDomain string, depl map[string]any) (string, []string) {
+	name, _ := depl["name"].(string)
+	var ips []string
+	if addr := nodePublicAddress(ctx, store, strVal(depl["node_id"])); isPublicIPv4(addr) {
+		ips = append(ips, addr)
+	}
+	return domain.Slugify(name) + "." + baseDomain, ips
+}
+
+// verifyDomain checks a custom domain's DNS. It is routed once its CNAME or
+// A record points at the deployment, and its ownership is recorded once its
+// TXT record holds its token. A domain routed ahead of its DNS by a cutover
+// stays routed; others that don't point at the deployment stop being
+// routed, and are pending while their ownership is proven.
+func verifyDomain(ctx context.Context, d *DomainInfo, cnameTarget string, expectedIPs []string, now time.Time) {
+	verified, method, checkErr := verifyDomainDNS(d.Hostname, cnameTarget, expectedIPs)
+	if verified {
+		routeDomain(d, method, now)
+		return
+	}
+	if d.OwnershipVerifiedAt == "" && verifyDomainOwnership(ctx, d.Hostname, d.VerificationToken) {
+		d.OwnershipVerifiedAt = now.UTC().Format(time.RFC3339)
+	}
+	d.LastCheckError = checkErr
+	switch {
+	case d.VerificationStatus == "verified" && d.VerificationMethod == string(domain.DomainVerificationMethodTXT):
+	case d.OwnershipVerifiedAt != "":
+		d.VerificationStatus = "pending"
+	default:
+		d.VerificationStatus = "failed"
+	}
+}
+
+// routeDomain marks a custom domain verified, which routes it.
+func routeDomain(d *DomainInfo, method string, now time.Time) {
+	d.VerificationStatus = "verified"
+	d.VerificationMethod = method
+	// Domains with a challenge get SSL once hoster issues their certificate;
+	// otherwise Traefik's resolver issues it on the first request
+	d.SSLEnabled = d.Challenge == ""
+	d.VerifiedAt = now.UTC().Format(time.RFC3339)
+	d.LastCheckError = ""
+	d.CutoverRequestedAt = ""
+}
+
+// verifyDomainOwnership reports whether hostname's challenge TXT record
+// holds token.
+func verifyDomainOwnership(ctx context.Context, hostname, token string) bool {
+	if token == "" {
+		return false
+	}
+	records, err := net.DefaultResolver.LookupTXT(ctx, coredns.ChallengeName(hostname))
+	if err != nil {
+		return false
+	}
+	return coredns.VerifyOwnership(records, token)
+}
+
+// verifyDomainDNS checks that hostname has a CNAME to cnameTarget or an A
+// record for one of expectedIPs. It returns the verification method used,
+// or why verification failed.
+func verifyDomainDNS(hostname, cnameTarget string, expectedIPs []string) (bool, string, string) {
+	input := coredns.VerificationInput{Hostname: hostname}
+	cnames, cnameErr := lookupCNAME(hostname)
+	input.CNAMERecords = cnames
+	if len(expectedIPs) > 0 {
+		input.ARecords, _ = net.LookupIP(hostname)
+	}
+	if cnameErr != nil && len(input.ARecords) == 0 {
+		return false, "", cnameErr.Error()
+	}
+
+	result := coredns.Verify(input, cnameTarget, expectedIPs)
+	if !result.Verified {
+		msg := "CNAME not pointing to " + cnameTarget
+		if len(expectedIPs) > 0 {
+			msg += " and no A record for " + strings.Join(expectedIPs, ", ")
+		}
+		return false, "", msg
+	}
+	return true, string(result.Method), ""
+}
+
 // lookupCNAME performs a DNS CNAME lookup.
 func lookupCNAME(hostname string) ([]string, error) {
 	cname, err := net.LookupCNAME(hostname)
@@ -1004,26 +2039,18 @@ func monitoringHandler(cfg SetupConfig, _ string, builder monitoringBuilderFunc)
 		id := mux.Vars(r)["id"]
 
 		if !authCtx.Authenticated {
-			writeError(w, http.StatusUnauthorized, "authentication required")
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
 			return
 		}
 
 		depl, err := cfg.Store.Get(ctx, "deployments", id)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "deployment not found")
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
 			return
 		}
 
-		// Check ownership — fail closed
-		ownerID, ok := toInt64(depl["customer_id"])
-		if !ok {
-			cfg.Logger.Warn("ownership check failed: unparseable customer_id",
-				"resource", "deployments", "value", depl["customer_id"])
-			writeError(w, http.StatusForbidden, "access denied")
-			return
-		}
-		if int(ownerID) != authCtx.UserID {
-			writeError(w, http.StatusForbidden, "not authorized")
+		// Owners and collaborators of any role may view — fail closed
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
 			return
 		}
 
@@ -1043,7 +2070,7 @@ func requestIDMiddleware(next http.Handler) http.Handler {
 			reqID = "req_" + randomString(12)
 		}
 		w.Header().Set("X-Request-ID", reqID)
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(docker.WithRequestID(r.Context(), reqID)))
 	})
 }
 
@@ -1053,7 +2080,7 @@ func recoveryMiddleware(logger *slog.Logger) mux.MiddlewareFunc {
 			defer func() {
 				if err := recover(); err != nil {
 					logger.Error("panic recovered", "error", err)
-					writeError(w, http.StatusInternalServerError, "an unexpected error occurred")
+					writeProblem(w, r, ProblemInternal, "an unexpected error occurred")
 				}
 			}()
 			next.ServeHTTP(w, r)
@@ -1072,12 +2099,43 @@ func healthHandler(version string) http.HandlerFunc {
 	}
 }
 
-func readyHandler(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"status": "ready",
-		"checks": map[string]string{"database": "ok"},
-	})
+// readyHandler reports readiness. Stale backups or snapshots shipped to the
+// standby mark the server "degraded" without failing the check, since
+// serving traffic does not depend on them. Every replica serves traffic;
+// "leader" tells which runs the workers.
+func readyHandler(backups *BackupScheduler, shipper *ReplicationShipper, leader *LeaderElector, replica *ReplicaSyncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		status := "ready"
+		checks := map[string]string{"database": "ok"}
+		if backups != nil {
+			checks["backup"] = backups.Check()
+			if checks["backup"] != "ok" && checks["backup"] != "pending" {
+				status = "degraded"
+			}
+		}
+		if shipper != nil {
+			checks["replication"] = shipper.Check()
+			if checks["replication"] != "ok" && checks["replication"] != "pending" {
+				status = "degraded"
+			}
+		}
+		if replica != nil {
+			checks["snapshot"] = replica.Check()
+			if checks["snapshot"] != "ok" {
+				status = "degraded"
+			}
+		}
+		body := map[string]any{
+			"status": status,
+			"checks": checks,
+		}
+		if leader != nil {
+			body["replica"] = leader.Holder()
+			body["leader"] = leader.IsLeader()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
 }
 
 // =============================================================================
@@ -1099,7 +2157,7 @@ func spaHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// For API paths that weren't matched, return 404
 		if len(r.URL.Path) > 4 && r.URL.Path[:5] == "/api/" {
-			writeError(w, http.StatusNotFound, "not found")
+			writeProblem(w, r, ProblemNotFound, "not found")
 			return
 		}
 
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/validation"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Template Setup Flows (guided variable wizards)
+// =============================================================================
+
+// decodeJSONValue decodes a JSON column value (raw string or already-parsed) into out.
+// NULL and empty values leave out unchanged.
+func decodeJSONValue(v any, out any) error {
+	var raw []byte
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if val == "" {
+			return nil
+		}
+		raw = []byte(val)
+	case []byte:
+		if len(val) == 0 {
+			return nil
+		}
+		raw = val
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// templateSetup decodes a template row's variables and setup flow.
+// Returns a nil flow if the template has none.
+func templateSetup(variables, setupFlow any) ([]domain.Variable, *domain.SetupFlow, error) {
+	var vars []domain.Variable
+	if err := decodeJSONValue(variables, &vars); err != nil {
+		return nil, nil, fmt.Errorf("invalid variables: %w", err)
+	}
+	var flow *domain.SetupFlow
+	if err := decodeJSONValue(setupFlow, &flow); err != nil {
+		return nil, nil, fmt.Errorf("invalid setup_flow: %w", err)
+	}
+	return vars, flow, nil
+}
+
+// validateTemplateSetupFlow checks a template's setup flow against its variables.
+func validateTemplateSetupFlow(variables, setupFlow any) error {
+	vars, flow, err := templateSetup(variables, setupFlow)
+	if err != nil {
+		return err
+	}
+	if flow == nil {
+		return nil
+	}
+	if field, msg := validation.ValidateSetupFlow(*flow, vars); field != "" {
+		return fmt.Errorf("%s: %s", field, msg)
+	}
+	return nil
+}
+
+// checkDeploymentSetup verifies a deployment supplies every variable its
+// template's setup flow asks for. Templates without a setup flow always pass.
+func checkDeploymentSetup(tmpl map[string]any, deploymentVars any) error {
+	vars, flow, err := templateSetup(tmpl["variables"], tmpl["setup_flow"])
+	if err != nil || flow == nil {
+		return nil // malformed template data is reported by fsck, not at deploy time
+	}
+
+	values, err := variableValues(deploymentVars)
+	if err != nil {
+		return err
+	}
+
+	if name, msg := validation.CheckSetupComplete(*flow, vars, values); name != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// setupStepView is a setup step with its variable definitions resolved for the UI.
+type setupStepView struct {
+	ID        string                `json:"id"`
+	Title     string                `json:"title"`
+	Help      string                `json:"help,omitempty"`
+	Variables []domain.Variable     `json:"variables"`
+	Condition *domain.StepCondition `json:"condition,omitempty"`
+}
+
+// templateSetupHandler returns a template's setup wizard with variable
+// definitions resolved per step. Variables not assigned to any step are
+// returned as a trailing "other" list so the UI can still collect them.
+// The template's presets are included for pre-filling values.
+// GET /api/v1/templates/{id}/setup
+func templateSetupHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		tmpl, err := cfg.Store.Get(ctx, "templates", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		if res := cfg.Store.Resource("templates"); res != nil && res.Visibility != nil && !res.Visibility(ctx, authCtx, tmpl) {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+
+		vars, flow, err := templateSetup(tmpl["variables"], tmpl["setup_flow"])
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+
+		byName := make(map[string]domain.Variable, len(vars))
+		for _, v := range vars {
+			byName[v.Name] = v
+		}
+
+		steps := []setupStepView{}
+		assigned := map[string]bool{}
+		if flow != nil {
+			for _, step := range flow.Steps {
+				view := setupStepView{
+					ID:        step.ID,
+					Title:     step.Title,
+					Help:      step.Help,
+					Variables: []domain.Variable{},
+					Condition: step.Condition,
+				}
+				for _, name := range step.Variables {
+					if v, ok := byName[name]; ok {
+						view.Variables = append(view.Variables, v)
+						assigned[name] = true
+					}
+				}
+				steps = append(steps, view)
+			}
+		}
+
+		other := []domain.Variable{}
+		for _, v := range vars {
+			if !assigned[v.Name] {
+				other = append(other, v)
+			}
+		}
+
+		presets := []domain.Preset{}
+		if err := decodeJSONValue(tmpl["presets"], &presets); err != nil {
+			writeProblem(w, r, ProblemInternal, "invalid presets")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"template_id": strVal(tmpl["reference_id"]),
+				"guided":      flow != nil,
+				"steps":       steps,
+				"other":       other,
+				"presets":     presets,
+			},
+		})
+	}
+}
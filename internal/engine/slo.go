@@ -0,0 +1,573 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/core/slo"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Request Meter
+// =============================================================================
+
+// RequestMeter counts the embedded proxy's responses per deployment: status
+// and time to first byte. The SLO tracker takes the counts every tick and
+// adds those of deployments with an SLO to their samples.
+type RequestMeter struct {
+	mu      sync.Mutex
+	traffic map[string]*slo.Traffic
+}
+
+func NewRequestMeter() *RequestMeter {
+	return &RequestMeter{traffic: make(map[string]*slo.Traffic)}
+}
+
+// Record counts a response for a deployment.
+func (m *RequestMeter) Record(deploymentID string, status int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.traffic[deploymentID]
+	if !ok {
+		t = &slo.Traffic{}
+		m.traffic[deploymentID] = t
+	}
+	t.Record(status, latency)
+}
+
+// TakeAll returns the counts recorded since the last TakeAll, by deployment,
+// and resets them.
+func (m *RequestMeter) TakeAll() map[string]slo.Traffic {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]slo.Traffic, len(m.traffic))
+	for id, t := range m.traffic {
+		out[id] = *t
+	}
+	clear(m.traffic)
+	return out
+}
+
+// =============================================================================
+// Samples
+// =============================================================================
+
+// AddSLOSample adds counts to a deployment's sample for the period starting
+// at period. Replicas add their own counts to the same sample.
+func (s *Store) AddSLOSample(ctx context.Context, deploymentID string, period time.Time, c slo.Counts) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO slo_samples (deployment_id, period_start, checks, failed_checks, requests, errors, slow)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(deployment_id, period_start) DO UPDATE SET
+			checks = checks + excluded.checks,
+			failed_checks = failed_checks + excluded.failed_checks,
+			requests = requests + excluded.requests,
+			errors = errors + excluded.errors,
+			slow = slow + excluded.slow`,
+		deploymentID, period.UTC().Format(time.RFC3339), c.Checks, c.FailedChecks, c.Requests, c.Errors, c.Slow)
+	if err != nil {
+		return fmt.Errorf("add slo sample: %w", err)
+	}
+	return nil
+}
+
+// SLOCounts returns the sum of a deployment's samples since a time.
+func (s *Store) SLOCounts(ctx context.Context, deploymentID string, since time.Time) (slo.Counts, error) {
+	var c slo.Counts
+	err := s.db.GetContext(ctx, &c,
+		`SELECT COALESCE(SUM(checks), 0) AS checks, COALESCE(SUM(failed_checks), 0) AS failed_checks,
+			COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(errors), 0) AS errors, COALESCE(SUM(slow), 0) AS slow
+		FROM slo_samples WHERE deployment_id = ? AND period_start >= ?`,
+		deploymentID, slo.PeriodStart(since).Format(time.RFC3339))
+	if err != nil {
+		return slo.Counts{}, fmt.Errorf("sum slo samples: %w", err)
+	}
+	return c, nil
+}
+
+// SLODay is a deployment's counts for one UTC day.
+type SLODay struct {
+	Date string `db:"day" json:"date"`
+	slo.Counts
+}
+
+// ListSLODays returns a deployment's counts per day since a time, oldest
+// first. Days without samples are left out.
+func (s *Store) ListSLODays(ctx context.Context, deploymentID string, since time.Time) ([]SLODay, error) {
+	var out []SLODay
+	err := s.db.SelectContext(ctx, &out,
+		`SELECT substr(period_start, 1, 10) AS day, SUM(checks) AS checks, SUM(failed_checks) AS failed_checks,
+			SUM(requests) AS requests, SUM(errors) AS errors, SUM(slow) AS slow
+		FROM slo_samples WHERE deployment_id = ? AND period_start >= ?
+		GROUP BY day ORDER BY day`,
+		deploymentID, slo.PeriodStart(since).Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("list slo days: %w", err)
+	}
+	return out, nil
+}
+
+// PruneSLOSamples deletes samples older than a time.
+func (s *Store) PruneSLOSamples(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM slo_samples WHERE period_start < ?`, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("prune slo samples: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// =============================================================================
+// Objectives
+// =============================================================================
+
+// deploymentSLO returns the SLO declared by a deployment's template and the
+// routed service it is measured on. ok is false if the template declares
+// none.
+func deploymentSLO(ctx context.Context, store *Store, depl map[string]any) (o slo.Objective, service string, ok bool) {
+	tmpl, err := store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+	if err != nil {
+		return slo.Objective{}, "", false
+	}
+	return templateSLO(tmpl)
+}
+
+// templateSLO returns the SLO a template declares and the routed service it
+// is measured on.
+func templateSLO(tmpl map[string]any) (o slo.Objective, service string, ok bool) {
+	composeSpec := strVal(tmpl["compose_spec"])
+	// Most templates have no SLO; only parse the whole spec for those that do.
+	if ext, err := compose.ParseExtension(composeSpec); err != nil || ext == nil || ext.SLO == nil {
+		return slo.Objective{}, "", false
+	}
+	spec, err := compose.ParseComposeSpec(composeSpec)
+	if err != nil {
+		return slo.Objective{}, "", false
+	}
+	service, _, ok = compose.ProxyRoute(spec, coredeployment.TopologicalSort(spec.Services))
+	if !ok {
+		return slo.Objective{}, "", false
+	}
+	return spec.Extension.SLO.Objective(), service, true
+}
+
+// sloStaleHealth is how old a deployment's stored health may be before an
+// uptime check counts it as down: the node has not been reachable since.
+const sloStaleHealth = 5 * time.Minute
+
+// uptimeCheck reads one uptime check of the routed service from the health
+// the service health monitor stored. ok is false before the first health
+// check.
+func uptimeCheck(depl map[string]any, service string, now time.Time) (c slo.Counts, ok bool) {
+	var health domain.DeploymentHealth
+	if err := decodeJSONValue(depl["health"], &health); err != nil || health.CheckedAt.IsZero() {
+		return slo.Counts{}, false
+	}
+	c.Checks = 1
+	if now.Sub(health.CheckedAt) > sloStaleHealth {
+		c.FailedChecks = 1
+		return c, true
+	}
+	for _, ctr := range health.Containers {
+		if ctr.Name == service {
+			if ctr.Health == domain.HealthStatusUnhealthy {
+				c.FailedChecks = 1
+			}
+			return c, true
+		}
+	}
+	c.FailedChecks = 1 // The routed service has no container
+	return c, true
+}
+
+// =============================================================================
+// SLO Tracker
+// =============================================================================
+//
+// Deployments of templates that declare an x-hoster slo are measured on
+// their routed endpoint. Every tick the tracker adds a sample of counts per
+// deployment: one uptime check read from the routed service's health, and
+// the responses the App Proxy served since the last tick, with their server
+// errors and those slower than the latency threshold. Samples cover
+// slo.SamplePeriod each and are kept for slo.MaxWindowDays.
+//
+// Each tick also evaluates burn rates over the last hour and six hours, and
+// notifies the deployment's customer and the template's creator when an
+// objective's alert level rises. The levels are kept in the deployment's
+// slo_alerts field.
+
+// SLOTracker records SLO samples and raises burn-rate alerts.
+type SLOTracker struct {
+	store    *Store
+	meter    *RequestMeter
+	notifier *Notifier
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewSLOTracker creates a tracker. A nil meter records uptime checks only; a
+// nil notifier only logs alerts.
+func NewSLOTracker(store *Store, meter *RequestMeter, notifier *Notifier, interval time.Duration, logger *slog.Logger) *SLOTracker {
+	if interval == 0 {
+		interval = time.Minute
+	}
+	return &SLOTracker{
+		store:    store,
+		meter:    meter,
+		notifier: notifier,
+		interval: interval,
+		logger:   logger.With("component", "slo_tracker"),
+	}
+}
+
+// Start runs the uptime checks and alerts. They run on the leader only.
+func (t *SLOTracker) Start() {
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.wg.Add(1)
+	go t.run()
+	t.logger.Info("slo tracker started", "interval", t.interval)
+}
+
+func (t *SLOTracker) Stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+}
+
+func (t *SLOTracker) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.checkAll(now)
+		}
+	}
+}
+
+// RecordTraffic adds the proxy's counts to the samples every tick until ctx
+// is done, then once more. Every replica serving proxy traffic runs it.
+func (t *SLOTracker) RecordTraffic(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.flushTraffic(context.Background(), time.Now())
+			return
+		case now := <-ticker.C:
+			t.flushTraffic(ctx, now)
+		}
+	}
+}
+
+// flushTraffic takes the meter's counts and adds those of deployments with
+// an SLO to their current sample.
+func (t *SLOTracker) flushTraffic(ctx context.Context, now time.Time) {
+	if t.meter == nil {
+		return
+	}
+	for refID, traffic := range t.meter.TakeAll() {
+		depl, err := t.store.Get(ctx, "deployments", refID)
+		if err != nil {
+			continue
+		}
+		o, _, ok := deploymentSLO(ctx, t.store, depl)
+		if !ok {
+			continue
+		}
+		c := slo.Counts{Requests: traffic.Requests, Errors: traffic.Errors}
+		if o.HasLatency() {
+			c.Slow = traffic.SlowerThan(o.LatencyThreshold)
+		}
+		if err := t.store.AddSLOSample(ctx, refID, slo.PeriodStart(now), c); err != nil {
+			t.logger.Error("failed to record slo traffic", "deployment", refID, "error", err)
+		}
+	}
+}
+
+// checkAll records an uptime check of every running deployment with an SLO,
+// evaluates its burn rates and prunes expired samples.
+func (t *SLOTracker) checkAll(now time.Time) {
+	defer t.store.LoopMetrics().Time("slo_tracker")()
+	deployments, err := t.store.List(t.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		t.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+	for _, depl := range deployments {
+		if t.ctx.Err() != nil {
+			return
+		}
+		t.checkDeployment(depl, now)
+	}
+	if _, err := t.store.PruneSLOSamples(t.ctx, now.AddDate(0, 0, -slo.MaxWindowDays)); err != nil {
+		t.logger.Error("failed to prune slo samples", "error", err)
+	}
+}
+
+func (t *SLOTracker) checkDeployment(depl map[string]any, now time.Time) {
+	o, service, ok := deploymentSLO(t.ctx, t.store, depl)
+	if !ok {
+		return
+	}
+	refID := strVal(depl["reference_id"])
+	if c, ok := uptimeCheck(depl, service, now); ok {
+		if err := t.store.AddSLOSample(t.ctx, refID, slo.PeriodStart(now), c); err != nil {
+			t.logger.Error("failed to record uptime check", "deployment", refID, "error", err)
+			return
+		}
+	}
+
+	status, err := sloStatus(t.ctx, t.store, refID, o, now)
+	if err != nil {
+		t.logger.Error("failed to evaluate slo", "deployment", refID, "error", err)
+		return
+	}
+	next := slo.Alerts{}
+	for name, s := range status {
+		if level := s.Burn.Level(); level != slo.AlertNone {
+			next[name] = level
+		}
+	}
+	var prev slo.Alerts
+	_ = decodeJSONValue(depl["slo_alerts"], &prev)
+	if maps.Equal(prev, next) {
+		return
+	}
+	if _, err := t.store.Update(t.ctx, "deployments", refID, map[string]any{"slo_alerts": next}); err != nil {
+		t.logger.Error("failed to store slo alerts", "deployment", refID, "error", err)
+		return
+	}
+	for _, name := range slo.Raised(prev, next) {
+		s := status[name]
+		t.logger.Warn("slo burning", "deployment", refID, "objective", name, "level", next[name],
+			"burn_rate_1h", s.Fast, "burn_rate_6h", s.Slow)
+		if t.notifier != nil {
+			t.notifier.NotifyDeploymentSLOBurn(t.ctx, depl, name, next[name], s.Compliance, s.Burn)
+		}
+	}
+}
+
+// objectiveStatus is an objective's compliance over the SLO window and its
+// burn rates over the alert windows.
+type objectiveStatus struct {
+	slo.Compliance
+	slo.Burn
+	// ThresholdMS is the latency threshold of the latency objective.
+	ThresholdMS int64 `json:"threshold_ms,omitempty"`
+}
+
+// sloStatus evaluates a deployment's objectives, keyed by "availability"
+// and "latency".
+func sloStatus(ctx context.Context, store *Store, refID string, o slo.Objective, now time.Time) (map[string]objectiveStatus, error) {
+	window, err := store.SLOCounts(ctx, refID, now.Add(-o.Window()))
+	if err != nil {
+		return nil, err
+	}
+	fast, err := store.SLOCounts(ctx, refID, now.Add(-slo.FastBurnWindow))
+	if err != nil {
+		return nil, err
+	}
+	slow, err := store.SLOCounts(ctx, refID, now.Add(-slo.SlowBurnWindow))
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]objectiveStatus{
+		"availability": {Compliance: slo.Availability(o, window), Burn: slo.AvailabilityBurn(o, fast, slow)},
+	}
+	if o.HasLatency() {
+		status["latency"] = objectiveStatus{
+			Compliance:  slo.Latency(o, window),
+			Burn:        slo.LatencyBurn(o, fast, slow),
+			ThresholdMS: o.LatencyThreshold.Milliseconds(),
+		}
+	}
+	return status, nil
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// sloAttributes builds the SLO report of a deployment.
+func sloAttributes(ctx context.Context, store *Store, depl map[string]any, o slo.Objective, days bool, now time.Time) (map[string]any, error) {
+	refID := strVal(depl["reference_id"])
+	status, err := sloStatus(ctx, store, refID, o, now)
+	if err != nil {
+		return nil, err
+	}
+	window, err := store.SLOCounts(ctx, refID, now.Add(-o.Window()))
+	if err != nil {
+		return nil, err
+	}
+	alerts := slo.Alerts{}
+	_ = decodeJSONValue(depl["slo_alerts"], &alerts)
+
+	attrs := map[string]any{
+		"deployment_id": refID,
+		"window_days":   o.WindowDays,
+		"measurements":  window,
+		"alerts":        alerts,
+	}
+	for name, s := range status {
+		attrs[name] = s
+	}
+	if !days {
+		return attrs, nil
+	}
+	series, err := store.ListSLODays(ctx, refID, now.Add(-o.Window()))
+	if err != nil {
+		return nil, err
+	}
+	daily := make([]map[string]any, 0, len(series))
+	for _, d := range series {
+		day := map[string]any{
+			"date":         d.Date,
+			"measurements": d.Counts,
+			"availability": slo.Availability(o, d.Counts).Achieved,
+		}
+		if o.HasLatency() {
+			day["latency"] = slo.Latency(o, d.Counts).Achieved
+		}
+		daily = append(daily, day)
+	}
+	attrs["daily"] = daily
+	return attrs, nil
+}
+
+// deploymentSLOHandler serves a deployment's SLO dashboard: compliance and
+// error budgets over the window, burn rates, alerts and daily compliance.
+func deploymentSLOHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		depl, err := cfg.Store.Get(ctx, "deployments", mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
+			return
+		}
+		o, _, ok := deploymentSLO(ctx, cfg.Store, depl)
+		if !ok {
+			writeProblem(w, r, ProblemNotFound, "the deployment's template declares no SLO")
+			return
+		}
+		attrs, err := sloAttributes(ctx, cfg.Store, depl, o, true, time.Now())
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to evaluate slo")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":       "deployment-slo",
+				"id":         strVal(depl["reference_id"]),
+				"attributes": attrs,
+			},
+		})
+	}
+}
+
+// templateSLOHandler serves a template creator the SLO compliance of every
+// running deployment of the template.
+func templateSLOHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		tmpl, err := cfg.Store.Get(ctx, "templates", mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		ownerID, ok := toInt64(tmpl["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		o, _, ok := templateSLO(tmpl)
+		if !ok {
+			writeProblem(w, r, ProblemNotFound, "the template declares no SLO")
+			return
+		}
+
+		deployments, err := cfg.Store.List(ctx, "deployments", []Filter{
+			{Field: "template_id", Value: toInt(tmpl["id"])},
+			{Field: "status", Value: "running"},
+		}, Page{Limit: 1000})
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list deployments")
+			return
+		}
+		now := time.Now()
+		reports := make([]map[string]any, 0, len(deployments))
+		met := 0
+		for _, depl := range deployments {
+			attrs, err := sloAttributes(ctx, cfg.Store, depl, o, false, now)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to evaluate slo")
+				return
+			}
+			attrs["name"] = strVal(depl["name"])
+			if sloMet(attrs) {
+				met++
+			}
+			reports = append(reports, attrs)
+		}
+		objective := map[string]any{"availability": o.Availability, "window_days": o.WindowDays}
+		if o.HasLatency() {
+			objective["latency_threshold_ms"] = o.LatencyThreshold.Milliseconds()
+			objective["latency_target"] = o.LatencyTarget
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "template-slo",
+				"id":   strVal(tmpl["reference_id"]),
+				"attributes": map[string]any{
+					"objective":   objective,
+					"deployments": reports,
+					"met":         met,
+					"total":       len(reports),
+				},
+			},
+		})
+	}
+}
+
+// sloMet reports whether a deployment's report meets all its objectives.
+func sloMet(attrs map[string]any) bool {
+	for _, name := range []string{"availability", "latency"} {
+		if s, ok := attrs[name].(objectiveStatus); ok && !s.Met {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,512 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/costs"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	"github.com/artpar/hoster/internal/core/spending"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Spending Limit Storage
+// =============================================================================
+//
+// spending_limits holds the monthly limit an account sets on its spend: the
+// monthly prices of its running deployments plus its metered usage, priced
+// with billing.usage_prices. Usage comes from usage_events and, once
+// archived, from the usage_daily rollup. Administrators can override the
+// hard stop of trusted accounts.
+
+// SpendingLimit is an account's spending limit and override.
+type SpendingLimit struct {
+	UserID         int            `db:"user_id"`
+	MonthlyCents   int64          `db:"monthly_limit_cents"` // 0 when the account has no limit
+	AlertPercent   int            `db:"alert_percent"`
+	HardStop       bool           `db:"hard_stop"`
+	OverrideActive bool           `db:"override_active"`
+	OverrideUntil  sql.NullString `db:"override_until"` // Null overrides indefinitely
+	OverrideReason string         `db:"override_reason"`
+	OverrideBy     string         `db:"override_by"`
+	AlertedMonth   string         `db:"alerted_month"`
+	AlertedLevel   string         `db:"alerted_level"`
+	UpdatedAt      string         `db:"updated_at"`
+}
+
+const spendingLimitColumns = `user_id, monthly_limit_cents, alert_percent, hard_stop, override_active, override_until,
+	override_reason, override_by, alerted_month, alerted_level, updated_at`
+
+// Limit returns the limit in core form.
+func (l *SpendingLimit) Limit() spending.Limit {
+	return spending.Limit{MonthlyCents: l.MonthlyCents, AlertPercent: l.AlertPercent, HardStop: l.HardStop}
+}
+
+// Override returns the override in core form.
+func (l *SpendingLimit) Override() spending.Override {
+	o := spending.Override{Active: l.OverrideActive}
+	if t, ok := parseTime(l.OverrideUntil.String); ok {
+		o.Until = t
+	}
+	return o
+}
+
+// GetSpendingLimit returns a user's spending limit, or nil when none is set.
+func (s *Store) GetSpendingLimit(ctx context.Context, userID int) (*SpendingLimit, error) {
+	var l SpendingLimit
+	err := s.db.GetContext(ctx, &l, `SELECT `+spendingLimitColumns+` FROM spending_limits WHERE user_id = ?`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get spending limit: %w", err)
+	}
+	return &l, nil
+}
+
+// ListSpendingLimits returns the accounts with a limit set.
+func (s *Store) ListSpendingLimits(ctx context.Context) ([]*SpendingLimit, error) {
+	var out []*SpendingLimit
+	if err := s.db.SelectContext(ctx, &out,
+		`SELECT `+spendingLimitColumns+` FROM spending_limits WHERE monthly_limit_cents > 0 ORDER BY user_id`); err != nil {
+		return nil, fmt.Errorf("list spending limits: %w", err)
+	}
+	return out, nil
+}
+
+// SetSpendingLimit sets a user's limit, keeping any override. A changed limit
+// is evaluated afresh, so its alerts are sent again.
+func (s *Store) SetSpendingLimit(ctx context.Context, userID int, l spending.Limit) error {
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO spending_limits (user_id, monthly_limit_cents, alert_percent, hard_stop, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET monthly_limit_cents = excluded.monthly_limit_cents,
+			alert_percent = excluded.alert_percent, hard_stop = excluded.hard_stop,
+			alerted_month = '', alerted_level = '', updated_at = excluded.updated_at`,
+		userID, l.MonthlyCents, l.AlertPercent, l.HardStop, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("set spending limit: %w", err)
+	}
+	return nil
+}
+
+// ClearSpendingLimit removes a user's limit, keeping any override.
+func (s *Store) ClearSpendingLimit(ctx context.Context, userID int) error {
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE spending_limits SET monthly_limit_cents = 0, hard_stop = 0, alerted_month = '', alerted_level = '', updated_at = ?
+		WHERE user_id = ?`, time.Now().UTC().Format(time.RFC3339), userID); err != nil {
+		return fmt.Errorf("clear spending limit: %w", err)
+	}
+	return nil
+}
+
+// SetSpendingOverride lets a trusted user past their hard stop until a time,
+// or indefinitely with a zero until. by is the administrator's reference ID.
+func (s *Store) SetSpendingOverride(ctx context.Context, userID int, until time.Time, reason, by string) error {
+	var untilVal sql.NullString
+	if !until.IsZero() {
+		untilVal = sql.NullString{String: until.UTC().Format(time.RFC3339), Valid: true}
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO spending_limits (user_id, override_active, override_until, override_reason, override_by, updated_at)
+		VALUES (?, 1, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET override_active = 1, override_until = excluded.override_until,
+			override_reason = excluded.override_reason, override_by = excluded.override_by, updated_at = excluded.updated_at`,
+		userID, untilVal, reason, by, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("set spending override: %w", err)
+	}
+	return nil
+}
+
+// ClearSpendingOverride ends a user's override.
+func (s *Store) ClearSpendingOverride(ctx context.Context, userID int) error {
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE spending_limits SET override_active = 0, override_until = NULL, override_reason = '', override_by = '', updated_at = ?
+		WHERE user_id = ?`, time.Now().UTC().Format(time.RFC3339), userID); err != nil {
+		return fmt.Errorf("clear spending override: %w", err)
+	}
+	return nil
+}
+
+// markSpendingAlerted records the highest level alerted in a month.
+func (s *Store) markSpendingAlerted(ctx context.Context, userID int, month string, level spending.Level) error {
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE spending_limits SET alerted_month = ?, alerted_level = ? WHERE user_id = ?`,
+		month, string(level), userID); err != nil {
+		return fmt.Errorf("mark spending alerted: %w", err)
+	}
+	return nil
+}
+
+// MonthSpend returns a user's spend in the UTC month containing now: the
+// monthly prices of their running, non-trial deployments, as invoiced, and
+// their metered usage in the month, priced with prices.
+func (s *Store) MonthSpend(ctx context.Context, userID int, prices spending.Prices, now time.Time) (spending.Spend, error) {
+	var spend spending.Spend
+	if err := s.db.GetContext(ctx, &spend.SubscriptionCents,
+		`SELECT COALESCE(SUM(t.price_monthly_cents), 0)
+		FROM deployments d JOIN templates t ON t.id = d.template_id
+		WHERE d.customer_id = ? AND d.status = 'running' AND COALESCE(d.expires_at, '') = ''`, userID); err != nil {
+		return spend, fmt.Errorf("sum subscriptions: %w", err)
+	}
+	if len(prices) == 0 {
+		return spend, nil
+	}
+
+	start, end := costs.MonthBounds(now)
+	var rows []struct {
+		EventType string `db:"event_type"`
+		Quantity  int64  `db:"quantity"`
+	}
+	if err := s.db.SelectContext(ctx, &rows,
+		`SELECT event_type, COALESCE(SUM(quantity), 0) AS quantity
+		FROM usage_events WHERE user_id = ? AND substr(timestamp, 1, 7) = ? GROUP BY event_type
+		UNION ALL
+		SELECT event_type, SUM(quantity) AS quantity
+		FROM usage_daily WHERE user_id = ? AND day >= ? AND day < ? GROUP BY event_type`,
+		userID, start.Format(costs.MonthLayout),
+		userID, start.Format(time.DateOnly), end.Format(time.DateOnly)); err != nil {
+		return spend, fmt.Errorf("sum usage: %w", err)
+	}
+	usage := make([]spending.Usage, len(rows))
+	for i, r := range rows {
+		usage[i] = spending.Usage{EventType: r.EventType, Quantity: r.Quantity}
+	}
+	spend.MeteredCents = spending.MeteredCents(usage, prices)
+	return spend, nil
+}
+
+// checkSpendingLimit rejects new metered activity of a user whose hard stop
+// has been reached, unless an administrator has overridden it.
+func checkSpendingLimit(ctx context.Context, store *Store, prices spending.Prices, userID int, now time.Time) error {
+	l, err := store.GetSpendingLimit(ctx, userID)
+	if err != nil || l == nil || !l.HardStop || l.MonthlyCents <= 0 {
+		return err
+	}
+	spend, err := store.MonthSpend(ctx, userID, prices, now)
+	if err != nil {
+		return err
+	}
+	total := spend.TotalCents()
+	if spending.Blocks(spending.Evaluate(total, l.Limit()), l.Limit(), l.Override(), now) {
+		return fmt.Errorf("%w: %s of the %s monthly limit spent; raise the limit or wait until next month",
+			spending.ErrLimitReached, formatCents(total), formatCents(l.MonthlyCents))
+	}
+	return nil
+}
+
+// writeSpendingProblem writes the problem for a failed spending check.
+func writeSpendingProblem(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, spending.ErrLimitReached) {
+		writeProblem(w, r, ProblemSpendingLimitReached, err.Error())
+		return
+	}
+	writeProblem(w, r, ProblemInternal, "failed to check spending limit")
+}
+
+// formatCents renders cents as dollars.
+func formatCents(cents int64) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
+func spendingLimitJSONAPI(l *SpendingLimit, spend spending.Spend, now time.Time) map[string]any {
+	total := spend.TotalCents()
+	level := spending.Evaluate(total, l.Limit())
+	return map[string]any{
+		"type": "spending-limits",
+		"id":   now.UTC().Format(costs.MonthLayout),
+		"attributes": map[string]any{
+			"monthly_limit_cents": l.MonthlyCents,
+			"alert_percent":       l.AlertPercent,
+			"hard_stop":           l.HardStop,
+			"subscription_cents":  spend.SubscriptionCents,
+			"metered_cents":       spend.MeteredCents,
+			"spent_cents":         total,
+			"percent":             spending.Percent(total, l.Limit()),
+			"level":               level,
+			"blocked":             spending.Blocks(level, l.Limit(), l.Override(), now),
+			"override_active":     l.Override().Covers(now),
+			"override_until":      l.OverrideUntil.String,
+			"override_reason":     l.OverrideReason,
+		},
+	}
+}
+
+// =============================================================================
+// Spending Limit Handlers
+// =============================================================================
+
+// spendingLimitHandler handles GET, PUT and DELETE /spending-limit for the
+// current user. GET shows the month's spend against the limit; PUT takes
+// {"monthly_limit_cents": 5000, "alert_percent": 80, "hard_stop": true}.
+func spendingLimitHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		ctx := r.Context()
+
+		switch r.Method {
+		case http.MethodPut:
+			var req struct {
+				MonthlyCents int64 `json:"monthly_limit_cents"`
+				AlertPercent *int  `json:"alert_percent"`
+				HardStop     bool  `json:"hard_stop"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+			l := spending.Limit{MonthlyCents: req.MonthlyCents, AlertPercent: spending.DefaultAlertPercent, HardStop: req.HardStop}
+			if req.AlertPercent != nil {
+				l.AlertPercent = *req.AlertPercent
+			}
+			if err := l.Validate(); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			if err := cfg.Store.SetSpendingLimit(ctx, authCtx.UserID, l); err != nil {
+				cfg.Logger.Error("failed to set spending limit", "user_id", authCtx.UserID, "error", err)
+				writeProblem(w, r, ProblemInternal, "failed to set spending limit")
+				return
+			}
+		case http.MethodDelete:
+			if err := cfg.Store.ClearSpendingLimit(ctx, authCtx.UserID); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to remove spending limit")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		l, err := cfg.Store.GetSpendingLimit(ctx, authCtx.UserID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load spending limit")
+			return
+		}
+		if l == nil {
+			l = &SpendingLimit{UserID: authCtx.UserID, AlertPercent: spending.DefaultAlertPercent}
+		}
+		now := time.Now()
+		spend, err := cfg.Store.MonthSpend(ctx, authCtx.UserID, cfg.UsagePrices, now)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to compute spend")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": spendingLimitJSONAPI(l, spend, now)})
+	}
+}
+
+// spendingOverrideHandler handles POST and DELETE
+// /admin/users/{id}/spending-override. POST takes {"until": RFC3339,
+// "reason": "..."}; without until the user is trusted indefinitely.
+func spendingOverrideHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if !isAdmin(cfg, authCtx) {
+			writeProblem(w, r, ProblemForbidden, "administrator access required")
+			return
+		}
+		ctx := r.Context()
+
+		var userID int
+		err := cfg.Store.db.GetContext(ctx, &userID, `SELECT id FROM users WHERE reference_id = ?`, mux.Vars(r)["id"])
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, r, ProblemNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load user")
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err := cfg.Store.ClearSpendingOverride(ctx, userID); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to remove spending override")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var req struct {
+			Until  string `json:"until"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		if req.Reason == "" {
+			writeProblem(w, r, ProblemValidationFailed, "reason is required")
+			return
+		}
+		now := time.Now()
+		var until time.Time
+		if req.Until != "" {
+			if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, "until must be an RFC 3339 time")
+				return
+			}
+			if !until.After(now) {
+				writeProblem(w, r, ProblemValidationFailed, "until must be in the future")
+				return
+			}
+		}
+		if err := cfg.Store.SetSpendingOverride(ctx, userID, until, req.Reason, authCtx.ReferenceID); err != nil {
+			cfg.Logger.Error("failed to set spending override", "user_id", userID, "error", err)
+			writeProblem(w, r, ProblemInternal, "failed to set spending override")
+			return
+		}
+		cfg.Logger.Info("spending override set", "user_id", userID, "until", req.Until, "reason", req.Reason, "by", authCtx.ReferenceID)
+
+		l, err := cfg.Store.GetSpendingLimit(ctx, userID)
+		if err != nil || l == nil {
+			writeProblem(w, r, ProblemInternal, "failed to load spending limit")
+			return
+		}
+		spend, err := cfg.Store.MonthSpend(ctx, userID, cfg.UsagePrices, now)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to compute spend")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": spendingLimitJSONAPI(l, spend, now)})
+	}
+}
+
+// =============================================================================
+// Spending Monitor
+// =============================================================================
+
+// SpendingMonitor periodically compares accounts' month spend with their
+// limits and notifies them when it reaches the alert share and the limit,
+// once each a month.
+type SpendingMonitor struct {
+	store    *Store
+	notifier *Notifier
+	prices   spending.Prices
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewSpendingMonitor creates a monitor. A nil notifier only logs alerts.
+func NewSpendingMonitor(store *Store, notifier *Notifier, prices spending.Prices, interval time.Duration, logger *slog.Logger) *SpendingMonitor {
+	if interval == 0 {
+		interval = 15 * time.Minute
+	}
+	return &SpendingMonitor{
+		store:    store,
+		notifier: notifier,
+		prices:   prices,
+		interval: interval,
+		logger:   logger.With("component", "spending_monitor"),
+	}
+}
+
+func (m *SpendingMonitor) Start() {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("spending monitor started", "interval", m.interval)
+}
+
+func (m *SpendingMonitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+func (m *SpendingMonitor) run() {
+	defer m.wg.Done()
+	m.checkAll(time.Now())
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case t := <-ticker.C:
+			m.checkAll(t)
+		}
+	}
+}
+
+// checkAll alerts every account with a limit whose spend has reached a
+// level not yet alerted this month.
+func (m *SpendingMonitor) checkAll(now time.Time) {
+	defer m.store.LoopMetrics().Time("spending_monitor")()
+	limits, err := m.store.ListSpendingLimits(m.ctx)
+	if err != nil {
+		m.logger.Error("failed to list spending limits", "error", err)
+		return
+	}
+	month := now.UTC().Format(costs.MonthLayout)
+	for _, l := range limits {
+		spend, err := m.store.MonthSpend(m.ctx, l.UserID, m.prices, now)
+		if err != nil {
+			m.logger.Error("failed to compute spend", "user_id", l.UserID, "error", err)
+			continue
+		}
+		level := spending.Evaluate(spend.TotalCents(), l.Limit())
+		if !spending.ShouldAlert(level, spending.Level(l.AlertedLevel), l.AlertedMonth, month) {
+			continue
+		}
+		m.alert(l, spend, level, now)
+		if err := m.store.markSpendingAlerted(m.ctx, l.UserID, month, level); err != nil {
+			m.logger.Error("failed to record spending alert", "user_id", l.UserID, "error", err)
+		}
+	}
+}
+
+// alert notifies an account that its spend reached a level.
+func (m *SpendingMonitor) alert(l *SpendingLimit, spend spending.Spend, level spending.Level, now time.Time) {
+	total := spend.TotalCents()
+	percent := spending.Percent(total, l.Limit())
+	m.logger.Info("spending alert", "user_id", l.UserID, "level", level, "percent", percent)
+	if m.notifier == nil {
+		return
+	}
+
+	ev := corenotify.Event{
+		Type:     corenotify.EventSpendingAlert,
+		Severity: corenotify.SeverityWarning,
+		Title:    fmt.Sprintf("You have spent %d%% of your monthly limit", percent),
+		Message:  fmt.Sprintf("%s of your %s limit is spent this month.", formatCents(total), formatCents(l.MonthlyCents)),
+		Data: map[string]any{
+			"level":               level,
+			"month":               now.UTC().Format(costs.MonthLayout),
+			"spent_cents":         total,
+			"subscription_cents":  spend.SubscriptionCents,
+			"metered_cents":       spend.MeteredCents,
+			"monthly_limit_cents": l.MonthlyCents,
+			"hard_stop":           l.HardStop,
+		},
+	}
+	if level == spending.LevelReached {
+		ev.Severity = corenotify.SeverityCritical
+		ev.Title = "You have reached your monthly spending limit"
+		if spending.Blocks(level, l.Limit(), l.Override(), now) {
+			ev.Message += " New deployments, starts and buckets are paused until next month or until you raise the limit."
+		}
+	}
+	m.notifier.Notify(l.UserID, ev)
+}
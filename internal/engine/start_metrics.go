@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Deployment Start Metrics
+// =============================================================================
+//
+// The orchestrator starts a deployment's services level by level, starting
+// the services of a dependency level concurrently. Start durations are
+// aggregated here and exposed on /metrics, so the speedup on big stacks,
+// and the effect of nodes.start_concurrency, can be watched.
+
+// configureStart bounds how many services of a level the orchestrator
+// starts at once and records its starts into the store's start metrics.
+func configureStart(deps *Deps, o *docker.Orchestrator) {
+	if n, ok := deps.Extra["start_concurrency"].(int); ok {
+		o.SetStartConcurrency(n)
+	}
+	o.SetStartObserver(deps.Store.StartMetrics().Record)
+}
+
+// startDurationBuckets are the upper bounds of the start duration histogram.
+var startDurationBuckets = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// DeploymentStartMetrics aggregates deployment starts.
+type DeploymentStartMetrics struct {
+	mu      sync.Mutex
+	count   int64
+	failed  int64
+	levels  int64
+	total   time.Duration
+	buckets []int64
+}
+
+func newDeploymentStartMetrics() *DeploymentStartMetrics {
+	return &DeploymentStartMetrics{buckets: make([]int64, len(startDurationBuckets))}
+}
+
+// StartMetrics returns the store's deployment start aggregates.
+func (s *Store) StartMetrics() *DeploymentStartMetrics {
+	return s.starts
+}
+
+// Record adds one start to the aggregate.
+func (m *DeploymentStartMetrics) Record(r docker.StartReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	m.levels += int64(r.Levels)
+	m.total += r.Duration
+	if r.Err != nil {
+		m.failed++
+	}
+	for i, bound := range startDurationBuckets {
+		if r.Duration <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// Render returns the aggregate in the Prometheus text exposition format.
+func (m *DeploymentStartMetrics) Render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	const hist = "hoster_deployment_start_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time to start a deployment's containers.\n# TYPE %s histogram\n", hist, hist)
+	for i, bound := range startDurationBuckets {
+		fmt.Fprintf(&b, "%s_bucket{le=%q} %d\n", hist, formatSeconds(bound), m.buckets[i])
+	}
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n", hist, m.count)
+	fmt.Fprintf(&b, "%s_sum %s\n", hist, formatSeconds(m.total))
+	fmt.Fprintf(&b, "%s_count %d\n", hist, m.count)
+
+	fmt.Fprintf(&b, "# HELP hoster_deployment_start_failures_total Deployment starts that failed.\n# TYPE hoster_deployment_start_failures_total counter\n")
+	fmt.Fprintf(&b, "hoster_deployment_start_failures_total %d\n", m.failed)
+	fmt.Fprintf(&b, "# HELP hoster_deployment_start_levels_total Dependency levels deployment starts went through.\n# TYPE hoster_deployment_start_levels_total counter\n")
+	fmt.Fprintf(&b, "hoster_deployment_start_levels_total %d\n", m.levels)
+	return b.String()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
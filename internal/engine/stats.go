@@ -0,0 +1,673 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/stats"
+)
+
+// =============================================================================
+// Daily Statistics
+// =============================================================================
+//
+// daily_stats and daily_node_stats hold one row per day so dashboards never
+// count over raw tables. daily_stats has a platform-wide row (creator_id 0)
+// and a row per creator whose templates have deployments; daily_node_stats
+// has a row per node. The StatsRollup worker recomputes today and catches up
+// on days not yet final; administrators can recompute a range on demand.
+// Point-in-time counts (deployments by status, running deployments per node)
+// are only recorded for today and yesterday, see stats.Snapshot.
+
+type dailyStatsRow struct {
+	Day          string         `db:"day"`
+	CreatorID    int            `db:"creator_id"`
+	ByStatus     sql.NullString `db:"deployments_by_status"`
+	Created      int64          `db:"deployments_created"`
+	NewCustomers int64          `db:"new_customers"`
+	Usage        sql.NullString `db:"usage"`
+	GrossCents   int64          `db:"gross_cents"`
+	NetCents     int64          `db:"net_cents"`
+	ComputedAt   string         `db:"computed_at"`
+}
+
+type dailyNodeStatsRow struct {
+	Day              string        `db:"day"`
+	NodeID           int           `db:"node_id"`
+	NodeRef          string        `db:"node_ref"`
+	Samples          int64         `db:"samples"`
+	CPUAvgPercent    float64       `db:"cpu_avg_percent"`
+	CPUMaxPercent    float64       `db:"cpu_max_percent"`
+	MemoryAvgPercent float64       `db:"memory_avg_percent"`
+	MemoryMaxPercent float64       `db:"memory_max_percent"`
+	DiskMaxPercent   float64       `db:"disk_max_percent"`
+	Deployments      sql.NullInt64 `db:"deployments"`
+	ComputedAt       string        `db:"computed_at"`
+}
+
+// RollupDailyStats computes the statistics of one day and stores them,
+// replacing an earlier rollup of the day.
+func (s *Store) RollupDailyStats(ctx context.Context, day string, now time.Time) error {
+	start, err := stats.ParseDay(day)
+	if err != nil {
+		return err
+	}
+	snapshot := stats.Snapshot(day, now)
+
+	platform := &stats.DeploymentStats{Day: day}
+	creators := map[int]*stats.DeploymentStats{}
+	creator := func(id int) *stats.DeploymentStats {
+		c, ok := creators[id]
+		if !ok {
+			c = &stats.DeploymentStats{Day: day}
+			creators[id] = c
+		}
+		return c
+	}
+
+	// Deployments created, by the template's creator
+	var created []struct {
+		CreatorID int   `db:"creator_id"`
+		N         int64 `db:"n"`
+	}
+	if err := s.db.SelectContext(ctx, &created,
+		`SELECT COALESCE(t.creator_id, 0) AS creator_id, COUNT(*) AS n
+		FROM deployments d LEFT JOIN templates t ON t.id = d.template_id
+		WHERE substr(d.created_at, 1, 10) = ? GROUP BY 1`, day); err != nil {
+		return fmt.Errorf("roll up deployments: %w", err)
+	}
+	for _, c := range created {
+		platform.DeploymentsCreated += c.N
+		if c.CreatorID > 0 {
+			creator(c.CreatorID).DeploymentsCreated = c.N
+		}
+	}
+
+	// Deployments by status, as of now
+	if snapshot {
+		var statuses []struct {
+			CreatorID int    `db:"creator_id"`
+			Status    string `db:"status"`
+			N         int64  `db:"n"`
+		}
+		if err := s.db.SelectContext(ctx, &statuses,
+			`SELECT COALESCE(t.creator_id, 0) AS creator_id, d.status AS status, COUNT(*) AS n
+			FROM deployments d LEFT JOIN templates t ON t.id = d.template_id GROUP BY 1, 2`); err != nil {
+			return fmt.Errorf("roll up deployment statuses: %w", err)
+		}
+		platform.DeploymentsByStatus = map[string]int64{}
+		for _, st := range statuses {
+			platform.DeploymentsByStatus[st.Status] += st.N
+			if st.CreatorID > 0 {
+				c := creator(st.CreatorID)
+				if c.DeploymentsByStatus == nil {
+					c.DeploymentsByStatus = map[string]int64{}
+				}
+				c.DeploymentsByStatus[st.Status] = st.N
+			}
+		}
+	}
+
+	// New customers: users who signed up that day; for a creator, customers
+	// whose first deployment of the creator's templates was that day
+	if err := s.db.GetContext(ctx, &platform.NewCustomers,
+		`SELECT COUNT(*) FROM users WHERE substr(created_at, 1, 10) = ?`, day); err != nil {
+		return fmt.Errorf("roll up new customers: %w", err)
+	}
+	var firsts []struct {
+		CreatorID int   `db:"creator_id"`
+		N         int64 `db:"n"`
+	}
+	if err := s.db.SelectContext(ctx, &firsts,
+		`SELECT creator_id, COUNT(*) AS n FROM (
+			SELECT t.creator_id AS creator_id, d.customer_id, MIN(substr(d.created_at, 1, 10)) AS first_day
+			FROM deployments d JOIN templates t ON t.id = d.template_id
+			GROUP BY t.creator_id, d.customer_id)
+		WHERE first_day = ? GROUP BY creator_id`, day); err != nil {
+		return fmt.Errorf("roll up new customers: %w", err)
+	}
+	for _, f := range firsts {
+		creator(f.CreatorID).NewCustomers = f.N
+	}
+
+	// Usage: events still in usage_events plus archived ones in usage_daily
+	var usage []struct {
+		EventType string `db:"event_type"`
+		Events    int64  `db:"events"`
+		Quantity  int64  `db:"quantity"`
+	}
+	if err := s.db.SelectContext(ctx, &usage,
+		`SELECT event_type, COUNT(*) AS events, COALESCE(SUM(quantity), 0) AS quantity
+		FROM usage_events WHERE substr(timestamp, 1, 10) = ? GROUP BY event_type
+		UNION ALL
+		SELECT event_type, SUM(events) AS events, SUM(quantity) AS quantity
+		FROM usage_daily WHERE day = ? GROUP BY event_type`, day, day); err != nil {
+		return fmt.Errorf("roll up usage: %w", err)
+	}
+	for _, u := range usage {
+		if platform.Usage == nil {
+			platform.Usage = map[string]stats.UsageTotal{}
+		}
+		t := platform.Usage[u.EventType]
+		t.Events += u.Events
+		t.Quantity += u.Quantity
+		platform.Usage[u.EventType] = t
+	}
+
+	// Creator earnings recorded that day
+	var earnings []struct {
+		CreatorID int   `db:"creator_id"`
+		Gross     int64 `db:"gross"`
+		Net       int64 `db:"net"`
+	}
+	if err := s.db.SelectContext(ctx, &earnings,
+		`SELECT creator_id, COALESCE(SUM(gross_cents), 0) AS gross, COALESCE(SUM(net_cents), 0) AS net
+		FROM creator_earnings WHERE substr(created_at, 1, 10) = ? GROUP BY creator_id`, day); err != nil {
+		return fmt.Errorf("roll up earnings: %w", err)
+	}
+	for _, e := range earnings {
+		platform.GrossCents += e.Gross
+		platform.NetCents += e.Net
+		c := creator(e.CreatorID)
+		c.GrossCents, c.NetCents = e.Gross, e.Net
+	}
+
+	nodes, err := s.rollupNodeStats(ctx, day, start, snapshot)
+	if err != nil {
+		return err
+	}
+
+	computedAt := now.UTC().Format(time.RFC3339)
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store daily stats: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Rows of the day not recomputed below are zeroed, keeping old snapshots
+	reset := `UPDATE daily_stats SET deployments_created = 0, new_customers = 0, usage = NULL,
+		gross_cents = 0, net_cents = 0, computed_at = ?`
+	nodeReset := `UPDATE daily_node_stats SET samples = 0, cpu_avg_percent = 0, cpu_max_percent = 0,
+		memory_avg_percent = 0, memory_max_percent = 0, disk_max_percent = 0, computed_at = ?`
+	if snapshot {
+		reset += `, deployments_by_status = NULL`
+		nodeReset += `, deployments = NULL`
+	}
+	if _, err := tx.ExecContext(ctx, reset+` WHERE day = ?`, computedAt, day); err != nil {
+		return fmt.Errorf("store daily stats: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, nodeReset+` WHERE day = ?`, computedAt, day); err != nil {
+		return fmt.Errorf("store daily stats: %w", err)
+	}
+
+	creators[0] = platform
+	for id, d := range creators {
+		byStatus, usage, err := encodeDailyStats(d)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO daily_stats (day, creator_id, deployments_by_status, deployments_created, new_customers,
+				usage, gross_cents, net_cents, computed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day, creator_id) DO UPDATE SET
+				deployments_by_status = COALESCE(excluded.deployments_by_status, deployments_by_status),
+				deployments_created = excluded.deployments_created, new_customers = excluded.new_customers,
+				usage = excluded.usage, gross_cents = excluded.gross_cents, net_cents = excluded.net_cents,
+				computed_at = excluded.computed_at`,
+			day, id, byStatus, d.DeploymentsCreated, d.NewCustomers, usage, d.GrossCents, d.NetCents, computedAt); err != nil {
+			return fmt.Errorf("store daily stats: %w", err)
+		}
+	}
+	for id, n := range nodes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO daily_node_stats (day, node_id, samples, cpu_avg_percent, cpu_max_percent,
+				memory_avg_percent, memory_max_percent, disk_max_percent, deployments, computed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day, node_id) DO UPDATE SET
+				samples = excluded.samples, cpu_avg_percent = excluded.cpu_avg_percent,
+				cpu_max_percent = excluded.cpu_max_percent, memory_avg_percent = excluded.memory_avg_percent,
+				memory_max_percent = excluded.memory_max_percent, disk_max_percent = excluded.disk_max_percent,
+				deployments = COALESCE(excluded.deployments, deployments), computed_at = excluded.computed_at`,
+			day, id, n.Samples, n.CPUAvgPercent, n.CPUMaxPercent, n.MemoryAvgPercent, n.MemoryMaxPercent,
+			n.DiskMaxPercent, n.Deployments, computedAt); err != nil {
+			return fmt.Errorf("store daily node stats: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// rollupNodeStats summarizes a day of node metrics samples per node ID, with
+// the running deployments on each node when snapshot is set.
+func (s *Store) rollupNodeStats(ctx context.Context, day string, start time.Time, snapshot bool) (map[int]stats.NodeStats, error) {
+	var samples []struct {
+		NodeID         int     `db:"node_id"`
+		CPUPercent     float64 `db:"cpu_used_percent"`
+		MemoryUsedMB   int64   `db:"memory_used_mb"`
+		MemoryTotalMB  int64   `db:"memory_total_mb"`
+		DiskMaxPercent float64 `db:"disk_max_percent"`
+	}
+	if err := s.db.SelectContext(ctx, &samples,
+		`SELECT node_id, cpu_used_percent, memory_used_mb, memory_total_mb, disk_max_percent
+		FROM node_metrics WHERE collected_at >= ? AND collected_at < ?`,
+		start.Format(time.RFC3339), start.AddDate(0, 0, 1).Format(time.RFC3339)); err != nil {
+		return nil, fmt.Errorf("roll up node metrics: %w", err)
+	}
+	byNode := map[int][]stats.NodeSample{}
+	for _, x := range samples {
+		byNode[x.NodeID] = append(byNode[x.NodeID], stats.NodeSample{
+			CPUPercent:     x.CPUPercent,
+			MemoryUsedMB:   x.MemoryUsedMB,
+			MemoryTotalMB:  x.MemoryTotalMB,
+			DiskMaxPercent: x.DiskMaxPercent,
+		})
+	}
+
+	out := make(map[int]stats.NodeStats, len(byNode))
+	for id, xs := range byNode {
+		out[id] = stats.SummarizeNode(day, xs)
+	}
+	if !snapshot {
+		return out, nil
+	}
+
+	var running []struct {
+		NodeID int   `db:"node_id"`
+		N      int64 `db:"n"`
+	}
+	if err := s.db.SelectContext(ctx, &running,
+		`SELECT n.id AS node_id, COUNT(d.id) AS n
+		FROM nodes n LEFT JOIN deployments d ON d.node_id = n.reference_id AND d.status = 'running'
+		GROUP BY n.id`); err != nil {
+		return nil, fmt.Errorf("roll up node deployments: %w", err)
+	}
+	for _, r := range running {
+		n, ok := out[r.NodeID]
+		if !ok {
+			n = stats.NodeStats{Day: day}
+		}
+		count := r.N
+		n.Deployments = &count
+		out[r.NodeID] = n
+	}
+	return out, nil
+}
+
+func encodeDailyStats(d *stats.DeploymentStats) (byStatus, usage any, err error) {
+	if d.DeploymentsByStatus != nil {
+		b, err := json.Marshal(d.DeploymentsByStatus)
+		if err != nil {
+			return nil, nil, err
+		}
+		byStatus = string(b)
+	}
+	if d.Usage != nil {
+		b, err := json.Marshal(d.Usage)
+		if err != nil {
+			return nil, nil, err
+		}
+		usage = string(b)
+	}
+	return byStatus, usage, nil
+}
+
+// DailyStats returns the daily statistics of a creator's templates, or the
+// platform's for creator 0, from first to last. Days without a rollup are
+// left out.
+func (s *Store) DailyStats(ctx context.Context, creatorID int, first, last string) ([]stats.DeploymentStats, error) {
+	var rows []dailyStatsRow
+	if err := s.db.SelectContext(ctx, &rows,
+		`SELECT day, creator_id, deployments_by_status, deployments_created, new_customers, usage,
+			gross_cents, net_cents, computed_at
+		FROM daily_stats WHERE creator_id = ? AND day >= ? AND day <= ? ORDER BY day`,
+		creatorID, first, last); err != nil {
+		return nil, fmt.Errorf("list daily stats: %w", err)
+	}
+	out := make([]stats.DeploymentStats, 0, len(rows))
+	for _, r := range rows {
+		d := stats.DeploymentStats{
+			Day:                r.Day,
+			DeploymentsCreated: r.Created,
+			NewCustomers:       r.NewCustomers,
+			GrossCents:         r.GrossCents,
+			NetCents:           r.NetCents,
+		}
+		d.ComputedAt, _ = parseTime(r.ComputedAt)
+		if r.ByStatus.Valid {
+			if err := json.Unmarshal([]byte(r.ByStatus.String), &d.DeploymentsByStatus); err != nil {
+				return nil, fmt.Errorf("decode daily stats of %s: %w", r.Day, err)
+			}
+		}
+		if r.Usage.Valid {
+			if err := json.Unmarshal([]byte(r.Usage.String), &d.Usage); err != nil {
+				return nil, fmt.Errorf("decode daily stats of %s: %w", r.Day, err)
+			}
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// DailyNodeStats returns the daily utilization of a creator's nodes, or of
+// every node for creator 0, from first to last. A non-empty nodeRef limits
+// it to one node.
+func (s *Store) DailyNodeStats(ctx context.Context, creatorID int, nodeRef, first, last string) ([]stats.NodeStats, error) {
+	query := `SELECT s.day, s.node_id, n.reference_id AS node_ref, s.samples, s.cpu_avg_percent, s.cpu_max_percent,
+			s.memory_avg_percent, s.memory_max_percent, s.disk_max_percent, s.deployments, s.computed_at
+		FROM daily_node_stats s JOIN nodes n ON n.id = s.node_id
+		WHERE s.day >= ? AND s.day <= ?`
+	args := []any{first, last}
+	if creatorID > 0 {
+		query += ` AND n.creator_id = ?`
+		args = append(args, creatorID)
+	}
+	if nodeRef != "" {
+		query += ` AND n.reference_id = ?`
+		args = append(args, nodeRef)
+	}
+	query += ` ORDER BY n.reference_id, s.day`
+
+	var rows []dailyNodeStatsRow
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("list daily node stats: %w", err)
+	}
+	out := make([]stats.NodeStats, 0, len(rows))
+	for _, r := range rows {
+		n := stats.NodeStats{
+			Day:              r.Day,
+			NodeID:           r.NodeRef,
+			Samples:          r.Samples,
+			CPUAvgPercent:    r.CPUAvgPercent,
+			CPUMaxPercent:    r.CPUMaxPercent,
+			MemoryAvgPercent: r.MemoryAvgPercent,
+			MemoryMaxPercent: r.MemoryMaxPercent,
+			DiskMaxPercent:   r.DiskMaxPercent,
+		}
+		if r.Deployments.Valid {
+			count := r.Deployments.Int64
+			n.Deployments = &count
+		}
+		n.ComputedAt, _ = parseTime(r.ComputedAt)
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// LastFinalStatsDay returns the latest day whose platform rollup was
+// computed after the day ended, or "" if there is none.
+func (s *Store) LastFinalStatsDay(ctx context.Context) (string, error) {
+	var day sql.NullString
+	if err := s.db.GetContext(ctx, &day,
+		`SELECT MAX(day) FROM daily_stats WHERE creator_id = 0 AND computed_at >= date(day, '+1 day')`); err != nil {
+		return "", fmt.Errorf("last final stats day: %w", err)
+	}
+	return day.String, nil
+}
+
+// RollupStatsRange rolls up every day from first to last and returns the
+// number of days rolled up.
+func (s *Store) RollupStatsRange(ctx context.Context, first, last string, now time.Time) (int, error) {
+	n := 0
+	for _, day := range stats.Days(first, last) {
+		if err := s.RollupDailyStats(ctx, day, now); err != nil {
+			return n, fmt.Errorf("roll up %s: %w", day, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// =============================================================================
+// Stats Rollup Worker
+// =============================================================================
+
+// StatsRollup keeps the daily statistics current: each run recomputes today
+// and every earlier day not yet final, up to stats.BackfillDays back.
+type StatsRollup struct {
+	store    *Store
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewStatsRollup(store *Store, interval time.Duration, logger *slog.Logger) *StatsRollup {
+	if interval == 0 {
+		interval = time.Hour
+	}
+	return &StatsRollup{
+		store:    store,
+		interval: interval,
+		logger:   logger.With("component", "stats_rollup"),
+	}
+}
+
+func (sr *StatsRollup) Start() {
+	sr.ctx, sr.cancel = context.WithCancel(context.Background())
+	sr.wg.Add(1)
+	go sr.run()
+	sr.logger.Info("stats rollup started", "interval", sr.interval)
+}
+
+func (sr *StatsRollup) Stop() {
+	if sr.cancel != nil {
+		sr.cancel()
+	}
+	sr.wg.Wait()
+}
+
+func (sr *StatsRollup) run() {
+	defer sr.wg.Done()
+	sr.rollupDue()
+
+	ticker := time.NewTicker(sr.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sr.ctx.Done():
+			return
+		case <-ticker.C:
+			sr.rollupDue()
+		}
+	}
+}
+
+func (sr *StatsRollup) rollupDue() {
+	defer sr.store.LoopMetrics().Time("stats_rollup")()
+	now := time.Now().UTC()
+	first := stats.Day(now.AddDate(0, 0, -stats.BackfillDays))
+	last, err := sr.store.LastFinalStatsDay(sr.ctx)
+	if err != nil {
+		sr.logger.Error("failed to find last rollup", "error", err)
+		return
+	}
+	if last != "" {
+		if t, err := stats.ParseDay(last); err == nil {
+			if next := stats.Day(t.AddDate(0, 0, 1)); next > first {
+				first = next
+			}
+		}
+	}
+	n, err := sr.store.RollupStatsRange(sr.ctx, first, stats.Day(now), now)
+	if err != nil && sr.ctx.Err() == nil {
+		sr.logger.Error("stats rollup failed", "error", err)
+		return
+	}
+	sr.logger.Debug("stats rolled up", "from", first, "days", n)
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// statsRange reads ?from= and ?to= of a stats request, writing a problem
+// and returning false when they are invalid.
+func statsRange(w http.ResponseWriter, r *http.Request, maxDays int) (first, last string, ok bool) {
+	q := r.URL.Query()
+	first, last, err := stats.ParseRange(q.Get("from"), q.Get("to"), time.Now(), maxDays)
+	if err != nil {
+		writeProblem(w, r, ProblemValidationFailed, err.Error())
+		return "", "", false
+	}
+	return first, last, true
+}
+
+func statsDocument(typ, id, first, last string, days []stats.DeploymentStats) map[string]any {
+	return map[string]any{
+		"type": typ,
+		"id":   id,
+		"attributes": map[string]any{
+			"from":   first,
+			"to":     last,
+			"days":   days,
+			"totals": stats.Sum(days),
+		},
+	}
+}
+
+// adminStatsHandler handles GET /admin/stats: platform-wide daily statistics
+// and their totals. ?from= and ?to= default to the last 30 days.
+func adminStatsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireStatsAdmin(w, r, cfg) {
+			return
+		}
+		first, last, ok := statsRange(w, r, stats.MaxRangeDays)
+		if !ok {
+			return
+		}
+		days, err := cfg.Store.DailyStats(r.Context(), 0, first, last)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load daily stats")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": statsDocument("daily-stats", "platform", first, last, days),
+		})
+	}
+}
+
+// adminNodeStatsHandler handles GET /admin/stats/nodes: daily utilization of
+// every node, or of one with ?node_id=.
+func adminNodeStatsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireStatsAdmin(w, r, cfg) {
+			return
+		}
+		first, last, ok := statsRange(w, r, stats.MaxRangeDays)
+		if !ok {
+			return
+		}
+		nodes, err := cfg.Store.DailyNodeStats(r.Context(), 0, r.URL.Query().Get("node_id"), first, last)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load daily node stats")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "daily-node-stats",
+				"id":   "nodes",
+				"attributes": map[string]any{
+					"from":  first,
+					"to":    last,
+					"nodes": nodes,
+				},
+			},
+		})
+	}
+}
+
+// adminStatsRollupHandler handles POST /admin/stats/rollup: recomputes the
+// days from ?from= to ?to= now. Both default to today, and one call covers
+// at most stats.MaxRollupDays.
+func adminStatsRollupHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireStatsAdmin(w, r, cfg) {
+			return
+		}
+		now := time.Now()
+		q := r.URL.Query()
+		from, to := q.Get("from"), q.Get("to")
+		if to == "" {
+			to = stats.Day(now)
+		}
+		if from == "" {
+			from = to
+		}
+		first, last, err := stats.ParseRange(from, to, now, stats.MaxRollupDays)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		n, err := cfg.Store.RollupStatsRange(r.Context(), first, last, now)
+		if err != nil {
+			cfg.Logger.Error("on-demand stats rollup failed", "from", first, "to", last, "error", err)
+			writeProblem(w, r, ProblemInternal, "failed to roll up stats")
+			return
+		}
+		cfg.Logger.Info("stats rolled up on demand", "from", first, "to", last, "days", n)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "stats-rollups",
+				"id":   first + ".." + last,
+				"attributes": map[string]any{
+					"from": first,
+					"to":   last,
+					"days": n,
+				},
+			},
+		})
+	}
+}
+
+// creatorStatsHandler handles GET /creator/stats: daily statistics of the
+// caller's templates and the utilization of the caller's nodes.
+func creatorStatsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		first, last, ok := statsRange(w, r, stats.MaxRangeDays)
+		if !ok {
+			return
+		}
+		days, err := cfg.Store.DailyStats(r.Context(), authCtx.UserID, first, last)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load daily stats")
+			return
+		}
+		nodes, err := cfg.Store.DailyNodeStats(r.Context(), authCtx.UserID, "", first, last)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load daily node stats")
+			return
+		}
+		doc := statsDocument("creator-stats", authCtx.ReferenceID, first, last, days)
+		doc["attributes"].(map[string]any)["nodes"] = nodes
+		writeJSON(w, http.StatusOK, map[string]any{"data": doc})
+	}
+}
+
+// requireStatsAdmin writes a problem and returns false unless the request
+// is from an administrator.
+func requireStatsAdmin(w http.ResponseWriter, r *http.Request, cfg SetupConfig) bool {
+	authCtx := getAuthContext(r)
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return false
+	}
+	if !isAdmin(cfg, authCtx) {
+		writeProblem(w, r, ProblemForbidden, "administrator access required")
+		return false
+	}
+	return true
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/artpar/hoster/internal/core/crypto"
 	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/pagination"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,12 +27,23 @@ var (
 
 // Store provides generic CRUD operations for all resources defined in the schema.
 type Store struct {
-	db            *sqlx.DB
+	db            *observedDB
 	schema        map[string]*Resource
 	ordered       []Resource // ordered list for migrations
 	encryptionKey []byte
+	onTransition  []TransitionHook
+	onChange      []ChangeHook
+	starts        *DeploymentStartMetrics
+	loops         *LoopMetrics
+	faults        *FaultInjector // nil injects no faults
 }
 
+// TransitionHook observes a completed state transition. row is the updated row.
+type TransitionHook func(ctx context.Context, resource string, row map[string]any, from, to string)
+
+// ChangeHook observes a row created, updated or deleted through the store.
+type ChangeHook func(resource, refID string)
+
 // NewStore creates a new generic store, runs migrations, and prepares for queries.
 func NewStore(db *sqlx.DB, resources []Resource) (*Store, error) {
 	schema := make(map[string]*Resource, len(resources))
@@ -42,9 +54,11 @@ func NewStore(db *sqlx.DB, resources []Resource) (*Store, error) {
 		ordered[i] = r
 	}
 	s := &Store{
-		db:      db,
+		db:      &observedDB{DB: db, metrics: newQueryMetrics()},
 		schema:  schema,
 		ordered: ordered,
+		starts:  newDeploymentStartMetrics(),
+		loops:   newLoopMetrics(),
 	}
 	return s, nil
 }
@@ -54,9 +68,35 @@ func (s *Store) SetEncryptionKey(key []byte) {
 	s.encryptionKey = key
 }
 
+// Encrypting reports whether an encryption key is set. Without one, fields
+// marked WithEncrypted() are stored as given.
+func (s *Store) Encrypting() bool {
+	return len(s.encryptionKey) > 0
+}
+
+// OnTransition registers a hook run after every successful Transition.
+// Hooks must be registered before the store is used.
+func (s *Store) OnTransition(hook TransitionHook) {
+	s.onTransition = append(s.onTransition, hook)
+}
+
+// OnChange registers a hook run after every successful Create, Update and
+// Delete, transitions included. Writes made with raw SQL call notifyChange
+// themselves. Hooks must be registered before the store is used.
+func (s *Store) OnChange(hook ChangeHook) {
+	s.onChange = append(s.onChange, hook)
+}
+
+func (s *Store) notifyChange(resource, refID string) {
+	for _, hook := range s.onChange {
+		hook(resource, refID)
+	}
+}
+
 // DB returns the underlying sqlx.DB for use by legacy code during migration.
+// Queries run through it bypass the store's query metrics.
 func (s *Store) DB() *sqlx.DB {
-	return s.db
+	return s.db.DB
 }
 
 // Resource returns the resource definition by name.
@@ -73,9 +113,13 @@ func (s *Store) Close() error {
 // Pagination
 // =============================================================================
 
+// Page selects a slice of a list, by offset or, when Cursor is set, by
+// keyset: the rows after Cursor in the list's order. Keyset pages stay
+// consistent while rows are inserted between requests; offsets shift.
 type Page struct {
 	Limit  int
 	Offset int
+	Cursor *pagination.Cursor
 }
 
 func DefaultPage() Page {
@@ -89,12 +133,52 @@ func (p Page) Normalize() Page {
 	if p.Limit > 1000 {
 		p.Limit = 1000
 	}
-	if p.Offset < 0 {
+	if p.Offset < 0 || p.Cursor != nil {
 		p.Offset = 0
 	}
 	return p
 }
 
+// after returns the condition selecting the rows after p.Cursor in a list
+// ordered by (at, id), and its arguments, or "" without a cursor. at should
+// be wrapped in datetime() so timestamps compare whatever their layout.
+func (p Page) after(at, id string, desc bool) (string, []any) {
+	if p.Cursor == nil {
+		return "", nil
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, %s) %s (?, ?)", at, id, op), []any{p.Cursor.Key(), p.Cursor.ID}
+}
+
+// nextCursor returns the cursor of the page after rows, which a list
+// ordered by (sortField, id) returned for page, or "" when the page was
+// not full and so is the last.
+func nextCursor(rows []map[string]any, page Page, sortField string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	last := rows[len(rows)-1]
+	id, _ := toInt64(last["id"])
+	return cursorAfter(page, len(rows), last[sortField], id)
+}
+
+// cursorAfter returns the cursor of the page after one of count rows whose
+// last row has the sort timestamp at and the ID id, or "" when the page was
+// not full.
+func cursorAfter(page Page, count int, at any, id int64) string {
+	if count == 0 || count < page.Limit || id <= 0 {
+		return ""
+	}
+	t, ok := pagination.ParseTime(at)
+	if !ok {
+		return ""
+	}
+	return pagination.After(t, id).Encode()
+}
+
 // =============================================================================
 // Filters
 // =============================================================================
@@ -115,6 +199,9 @@ func (s *Store) Create(ctx context.Context, resource string, data map[string]any
 	if !ok {
 		return nil, fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "create", resource, ""); err != nil {
+		return nil, err
+	}
 
 	// Generate reference_id
 	refID := res.RefPrefix + uuid.New().String()[:8]
@@ -166,26 +253,8 @@ func (s *Store) Create(ctx context.Context, resource string, data map[string]any
 	cols = append(cols, "created_at", "updated_at")
 	placeholders = append(placeholders, ":created_at", ":updated_at")
 
-	// Encrypt fields marked WithEncrypted()
-	for _, f := range res.Fields {
-		if f.Encrypted {
-			if v, ok := data[f.Name]; ok && v != nil && len(s.encryptionKey) > 0 {
-				var plaintext []byte
-				switch val := v.(type) {
-				case string:
-					plaintext = []byte(val)
-				case []byte:
-					plaintext = val
-				}
-				if len(plaintext) > 0 {
-					encrypted, err := crypto.Encrypt(plaintext, s.encryptionKey)
-					if err != nil {
-						return nil, fmt.Errorf("encrypt %s: %w", f.Name, err)
-					}
-					data[f.Name] = encrypted
-				}
-			}
-		}
+	if err := s.encryptFields(res, data); err != nil {
+		return nil, err
 	}
 
 	// JSON-encode JSON fields
@@ -218,6 +287,7 @@ func (s *Store) Create(ctx context.Context, resource string, data map[string]any
 
 	id, _ := result.LastInsertId()
 	data["id"] = id
+	s.notifyChange(resource, refID)
 
 	return data, nil
 }
@@ -228,6 +298,9 @@ func (s *Store) Get(ctx context.Context, resource string, refID string) (map[str
 	if !ok {
 		return nil, fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "get", resource, refID); err != nil {
+		return nil, err
+	}
 
 	cols := s.selectColumns(res)
 	query := fmt.Sprintf("SELECT %s FROM %s WHERE reference_id = ?", cols, resource)
@@ -251,6 +324,9 @@ func (s *Store) GetByID(ctx context.Context, resource string, id int) (map[strin
 	if !ok {
 		return nil, fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "get", resource, ""); err != nil {
+		return nil, err
+	}
 
 	cols := s.selectColumns(res)
 	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", cols, resource)
@@ -281,6 +357,9 @@ func (s *Store) List(ctx context.Context, resource string, filters []Filter, pag
 	if !ok {
 		return nil, fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "list", resource, ""); err != nil {
+		return nil, err
+	}
 
 	page = page.Normalize()
 	cols := s.selectColumns(res)
@@ -291,12 +370,18 @@ func (s *Store) List(ctx context.Context, resource string, filters []Filter, pag
 		where = append(where, fmt.Sprintf("%s = ?", f.Field))
 		args = append(args, f.Value)
 	}
+	if cond, condArgs := page.after("datetime(created_at)", "id", true); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
 
+	// Newest first. datetime() orders RFC 3339 and SQLite timestamps alike;
+	// id breaks ties within a second, so the order is total and stable.
 	query := fmt.Sprintf("SELECT %s FROM %s", cols, resource)
 	if len(where) > 0 {
 		query += " WHERE " + strings.Join(where, " AND ")
 	}
-	query += " ORDER BY id DESC"
+	query += " ORDER BY datetime(created_at) DESC, id DESC"
 	query += fmt.Sprintf(" LIMIT %d OFFSET %d", page.Limit, page.Offset)
 
 	rows, err := s.db.QueryxContext(ctx, query, args...)
@@ -325,6 +410,9 @@ func (s *Store) Update(ctx context.Context, resource string, refID string, data
 	if !ok {
 		return nil, fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "update", resource, refID); err != nil {
+		return nil, err
+	}
 
 	// Don't allow updating reference_id, id, created_at
 	delete(data, "reference_id")
@@ -334,6 +422,10 @@ func (s *Store) Update(ctx context.Context, resource string, refID string, data
 	// Set updated_at
 	data["updated_at"] = time.Now().UTC().Format(time.RFC3339)
 
+	if err := s.encryptFields(res, data); err != nil {
+		return nil, err
+	}
+
 	// JSON-encode JSON fields
 	for _, f := range res.Fields {
 		if f.Type == TypeJSON {
@@ -379,15 +471,47 @@ func (s *Store) Update(ctx context.Context, resource string, refID string, data
 	if affected == 0 {
 		return nil, fmt.Errorf("%s %s: %w", resource, refID, ErrNotFound)
 	}
+	s.notifyChange(resource, refID)
 
 	return s.Get(ctx, resource, refID)
 }
 
+// encryptFields encrypts the values of fields marked WithEncrypted() in data.
+// Without an encryption key values are stored as-is.
+func (s *Store) encryptFields(res *Resource, data map[string]any) error {
+	if len(s.encryptionKey) == 0 {
+		return nil
+	}
+	for _, f := range res.Fields {
+		if !f.Encrypted {
+			continue
+		}
+		var plaintext []byte
+		switch val := data[f.Name].(type) {
+		case string:
+			plaintext = []byte(val)
+		case []byte:
+			plaintext = val
+		}
+		if len(plaintext) > 0 {
+			encrypted, err := crypto.Encrypt(plaintext, s.encryptionKey)
+			if err != nil {
+				return fmt.Errorf("encrypt %s: %w", f.Name, err)
+			}
+			data[f.Name] = encrypted
+		}
+	}
+	return nil
+}
+
 // Delete removes a row by reference_id.
 func (s *Store) Delete(ctx context.Context, resource string, refID string) error {
 	if _, ok := s.schema[resource]; !ok {
 		return fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "delete", resource, refID); err != nil {
+		return err
+	}
 
 	result, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE reference_id = ?", resource), refID)
 	if err != nil {
@@ -398,6 +522,7 @@ func (s *Store) Delete(ctx context.Context, resource string, refID string) error
 	if affected == 0 {
 		return fmt.Errorf("%s %s: %w", resource, refID, ErrNotFound)
 	}
+	s.notifyChange(resource, refID)
 
 	return nil
 }
@@ -413,6 +538,9 @@ func (s *Store) Transition(ctx context.Context, resource string, refID string, t
 	if !ok {
 		return nil, "", fmt.Errorf("unknown resource: %s", resource)
 	}
+	if err := s.injectFault(ctx, "transition", resource, refID); err != nil {
+		return nil, "", err
+	}
 
 	if res.StateMachine == nil {
 		return nil, "", fmt.Errorf("resource %s has no state machine", resource)
@@ -452,6 +580,10 @@ func (s *Store) Transition(ctx context.Context, resource string, refID string, t
 		return nil, "", err
 	}
 
+	for _, hook := range s.onTransition {
+		hook(ctx, resource, updated, fromState, toState)
+	}
+
 	// Return command to dispatch
 	cmd := sm.OnEnter[toState]
 	return updated, cmd, nil
@@ -598,7 +730,12 @@ func (s *Store) GetSSHKey(ctx context.Context, sshKeyRefID string) (*domain.SSHK
 	if err != nil {
 		return nil, err
 	}
-	return mapToSSHKey(row), nil
+	key := mapToSSHKey(row)
+	// The pool fetches a key to connect with it
+	if err := s.touchNodeKey(ctx, key.ID); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
 func mapToNode(row map[string]any) *domain.Node {
@@ -609,15 +746,17 @@ func mapToNode(row map[string]any) *domain.Node {
 		sshPort = 22
 	}
 	n := &domain.Node{
-		ID:           int(intID),
-		ReferenceID:  strVal(row["reference_id"]),
-		Name:         strVal(row["name"]),
-		SSHHost:      strVal(row["ssh_host"]),
-		SSHPort:      int(sshPort),
-		SSHUser:      strVal(row["ssh_user"]),
-		SSHKeyID:     int(sshKeyID),
-		DockerSocket: strVal(row["docker_socket"]),
-		Status:       domain.NodeStatus(strVal(row["status"])),
+		ID:            int(intID),
+		ReferenceID:   strVal(row["reference_id"]),
+		Name:          strVal(row["name"]),
+		SSHHost:       strVal(row["ssh_host"]),
+		SSHPort:       int(sshPort),
+		SSHUser:       strVal(row["ssh_user"]),
+		SSHKeyID:      int(sshKeyID),
+		DockerSocket:  strVal(row["docker_socket"]),
+		Status:        domain.NodeStatus(strVal(row["status"])),
+		Location:      strVal(row["location"]),
+		Unschedulable: boolVal(row["unschedulable"]),
 	}
 	return n
 }
@@ -647,11 +786,7 @@ func mapToSSHKey(row map[string]any) *domain.SSHKey {
 // GetDeploymentByDomain finds a deployment where any domain in the JSON array matches the hostname.
 func (s *Store) GetDeploymentByDomain(ctx context.Context, hostname string) (*domain.Deployment, error) {
 	query := `
-		SELECT id, reference_id, name, template_id, template_version, customer_id,
-		       node_id, status, variables, domains, containers,
-		       resources_cpu_cores, resources_memory_mb, resources_disk_mb,
-		       proxy_port, error_message, started_at, stopped_at,
-		       created_at, updated_at
+		SELECT ` + proxyDeploymentColumns + `
 		FROM deployments
 		WHERE EXISTS (
 			SELECT 1 FROM json_each(deployments.domains) AS je
@@ -677,6 +812,56 @@ func (s *Store) GetDeploymentByDomain(ctx context.Context, hostname string) (*do
 	return mapToDeployment(result), nil
 }
 
+// proxyDeploymentColumns are the deployment columns routing reads.
+const proxyDeploymentColumns = `id, reference_id, name, template_id, template_version, customer_id,
+		       node_id, status, variables, domains, containers,
+		       resources_cpu_cores, resources_memory_mb, resources_disk_mb,
+		       proxy_port, canary_port, canary_percent, error_message, started_at, stopped_at,
+		       created_at, updated_at, routing_options, replica_nodes,
+		       (SELECT t.routing_options FROM templates t WHERE t.id = deployments.template_id) AS template_routing_options`
+
+// ListDeploymentsWithDomains returns every deployment that is not deleted
+// and has domains, for building the full routing table.
+func (s *Store) ListDeploymentsWithDomains(ctx context.Context) ([]*domain.Deployment, error) {
+	rows, err := s.db.QueryxContext(ctx, `SELECT `+proxyDeploymentColumns+`
+		FROM deployments
+		WHERE status != 'deleted' AND domains IS NOT NULL AND domains != '' AND domains != '[]'
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("list deployments with domains: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*domain.Deployment
+	for rows.Next() {
+		row := make(map[string]any)
+		if err := rows.MapScan(row); err != nil {
+			return nil, fmt.Errorf("scan deployment: %w", err)
+		}
+		if res := s.schema["deployments"]; res != nil {
+			s.decodeRow(res, row)
+		}
+		out = append(out, mapToDeployment(row))
+	}
+	return out, rows.Err()
+}
+
+// NodeSSHHosts returns the ssh_host of every node, by reference_id.
+func (s *Store) NodeSSHHosts(ctx context.Context) (map[string]string, error) {
+	var rows []struct {
+		RefID   string `db:"reference_id"`
+		SSHHost string `db:"ssh_host"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, "SELECT reference_id, COALESCE(ssh_host, '') AS ssh_host FROM nodes"); err != nil {
+		return nil, fmt.Errorf("list node ssh_hosts: %w", err)
+	}
+	out := make(map[string]string, len(rows))
+	for _, r := range rows {
+		out[r.RefID] = r.SSHHost
+	}
+	return out, nil
+}
+
 // GetNodeSSHHost returns the ssh_host for a node by reference_id.
 func (s *Store) GetNodeSSHHost(ctx context.Context, nodeRefID string) (string, error) {
 	var sshHost string
@@ -722,6 +907,12 @@ func mapToDeployment(data map[string]any) *domain.Deployment {
 	if p, ok := toInt64(data["proxy_port"]); ok {
 		d.ProxyPort = int(p)
 	}
+	if p, ok := toInt64(data["canary_port"]); ok {
+		d.CanaryPort = int(p)
+	}
+	if p, ok := toInt64(data["canary_percent"]); ok {
+		d.CanaryPercent = int(p)
+	}
 
 	// Parse domains JSON
 	if dom, ok := data["domains"]; ok {
@@ -745,6 +936,22 @@ func mapToDeployment(data map[string]any) *domain.Deployment {
 		}
 	}
 
+	// Parse service overrides JSON
+	decodeJSONValue(data["service_overrides"], &d.ServiceOverrides)
+
+	// Parse routing options JSON, over the template's when the row has them
+	d.RoutingOptions = deploymentRoutingOptions(data["template_routing_options"], data["routing_options"])
+
+	// Parse customer labels JSON
+	decodeJSONValue(data["labels"], &d.Labels)
+
+	// Parse TCP and UDP streams JSON
+	decodeJSONValue(data["stream_ports"], &d.Streams)
+
+	// Parse replica nodes and bridge ports JSON
+	decodeJSONValue(data["replica_nodes"], &d.Replicas)
+	decodeJSONValue(data["replica_bridges"], &d.Bridges)
+
 	// Parse variables JSON
 	if v, ok := data["variables"]; ok {
 		switch val := v.(type) {
@@ -770,11 +977,20 @@ func (s *Store) CreateContainerEvent(ctx context.Context, event *domain.Containe
 	if event.ReferenceID == "" {
 		event.ReferenceID = "evt_" + uuid.New().String()[:8]
 	}
+	var details *string
+	if event.Forensics != nil {
+		data, err := json.Marshal(event.Forensics)
+		if err != nil {
+			return fmt.Errorf("marshal forensics: %w", err)
+		}
+		str := string(data)
+		details = &str
+	}
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO container_events (reference_id, deployment_id, type, container, message, timestamp)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO container_events (reference_id, deployment_id, type, container, message, details, timestamp)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		event.ReferenceID, event.DeploymentID, string(event.Type),
-		event.Container, event.Message, event.Timestamp.Format(time.RFC3339))
+		event.Container, event.Message, details, event.Timestamp.Format(time.RFC3339))
 	return err
 }
 
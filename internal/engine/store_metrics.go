@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/querymetrics"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Query Metrics
+// =============================================================================
+
+// QueryMetrics aggregates the store's queries per operation and logs those
+// slower than a threshold.
+type QueryMetrics struct {
+	mu     sync.Mutex
+	stats  map[string]*querymetrics.Stats
+	slow   time.Duration
+	logger *slog.Logger
+}
+
+func newQueryMetrics() *QueryMetrics {
+	return &QueryMetrics{stats: map[string]*querymetrics.Stats{}}
+}
+
+func (m *QueryMetrics) record(query string, args []any, start time.Time, rows int64, err error) {
+	d := time.Since(start)
+	failed := err != nil && !errors.Is(err, sql.ErrNoRows)
+	op := querymetrics.Operation(query)
+
+	m.mu.Lock()
+	st := m.stats[op]
+	if st == nil {
+		st = &querymetrics.Stats{}
+		m.stats[op] = st
+	}
+	st.Record(d, rows, failed)
+	slow, logger := m.slow, m.logger
+	m.mu.Unlock()
+
+	if slow > 0 && d >= slow && logger != nil {
+		logger.Warn("slow query",
+			"operation", op,
+			"duration", d,
+			"rows", rows,
+			"failed", failed,
+			"query", querymetrics.Compact(query),
+			"args", querymetrics.SanitizeArgs(args))
+	}
+}
+
+// Snapshot returns a copy of the aggregates, keyed by operation.
+func (m *QueryMetrics) Snapshot() map[string]querymetrics.Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]querymetrics.Stats, len(m.stats))
+	for op, st := range m.stats {
+		out[op] = st.Clone()
+	}
+	return out
+}
+
+// SetSlowQueryLog logs store queries that take threshold or longer, with
+// their parameters sanitized. A zero threshold turns the log off.
+func (s *Store) SetSlowQueryLog(threshold time.Duration, logger *slog.Logger) {
+	s.db.metrics.mu.Lock()
+	defer s.db.metrics.mu.Unlock()
+	s.db.metrics.slow = threshold
+	s.db.metrics.logger = logger.With("component", "store")
+}
+
+// QueryMetrics returns the store's query aggregates. Queries run inside
+// transactions or through DB() are not counted.
+func (s *Store) QueryMetrics() map[string]querymetrics.Stats {
+	return s.db.metrics.Snapshot()
+}
+
+// =============================================================================
+// Instrumented Executor
+// =============================================================================
+
+// observedDB is the store's executor: a sqlx.DB whose queries are timed and
+// counted into metrics.
+type observedDB struct {
+	*sqlx.DB
+	metrics *QueryMetrics
+}
+
+func (db *observedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	db.metrics.record(query, args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+func (db *observedDB) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	start := time.Now()
+	res, err := db.DB.NamedExecContext(ctx, query, arg)
+	db.metrics.record(query, nil, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+func (db *observedDB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	start := time.Now()
+	err := db.DB.GetContext(ctx, dest, query, args...)
+	var rows int64
+	if err == nil {
+		rows = 1
+	}
+	db.metrics.record(query, args, start, rows, err)
+	return err
+}
+
+func (db *observedDB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	start := time.Now()
+	err := db.DB.SelectContext(ctx, dest, query, args...)
+	var rows int64
+	if v := reflect.ValueOf(dest); err == nil && v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Slice {
+		rows = int64(v.Elem().Len())
+	}
+	db.metrics.record(query, args, start, rows, err)
+	return err
+}
+
+func (db *observedDB) QueryxContext(ctx context.Context, query string, args ...any) (*observedRows, error) {
+	start := time.Now()
+	rows, err := db.DB.QueryxContext(ctx, query, args...)
+	if err != nil {
+		db.metrics.record(query, args, start, 0, err)
+		return nil, err
+	}
+	return &observedRows{Rows: rows, metrics: db.metrics, query: query, args: args, start: start}, nil
+}
+
+func (db *observedDB) QueryRowxContext(ctx context.Context, query string, args ...any) *observedRow {
+	start := time.Now()
+	return &observedRow{Row: db.DB.QueryRowxContext(ctx, query, args...), metrics: db.metrics, query: query, args: args, start: start}
+}
+
+func (db *observedDB) QueryRow(query string, args ...any) *observedRow {
+	start := time.Now()
+	return &observedRow{Row: db.DB.QueryRowx(query, args...), metrics: db.metrics, query: query, args: args, start: start}
+}
+
+func rowsAffected(res sql.Result, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// observedRows counts the rows read and records the query when closed.
+type observedRows struct {
+	*sqlx.Rows
+	metrics *QueryMetrics
+	query   string
+	args    []any
+	start   time.Time
+	n       int64
+	once    sync.Once
+}
+
+func (r *observedRows) Next() bool {
+	if r.Rows.Next() {
+		r.n++
+		return true
+	}
+	return false
+}
+
+func (r *observedRows) Close() error {
+	err := r.Rows.Close()
+	r.once.Do(func() {
+		failed := r.Rows.Err()
+		if failed == nil {
+			failed = err
+		}
+		r.metrics.record(r.query, r.args, r.start, r.n, failed)
+	})
+	return err
+}
+
+// observedRow records the query when scanned. A query that finds no row is
+// not an error.
+type observedRow struct {
+	*sqlx.Row
+	metrics *QueryMetrics
+	query   string
+	args    []any
+	start   time.Time
+}
+
+func (r *observedRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	r.record(err)
+	return err
+}
+
+func (r *observedRow) StructScan(dest any) error {
+	err := r.Row.StructScan(dest)
+	r.record(err)
+	return err
+}
+
+func (r *observedRow) MapScan(dest map[string]any) error {
+	err := r.Row.MapScan(dest)
+	r.record(err)
+	return err
+}
+
+func (r *observedRow) record(err error) {
+	var rows int64
+	if err == nil {
+		rows = 1
+	}
+	r.metrics.record(r.query, r.args, r.start, rows, err)
+}
+
+// =============================================================================
+// Endpoint
+// =============================================================================
+
+// metricsHandler serves the store's query aggregates in the Prometheus text
+// format. With a metrics token configured, scrapers present it as a bearer
+// token; without one, only administrators can read the metrics.
+func metricsHandler(store *Store, cfg SetupConfig, requests *RequestMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.MetricsToken != "" {
+			if !validMetricsToken(r, cfg.MetricsToken) {
+				writeProblem(w, r, ProblemAuthenticationRequired, "metrics token required")
+				return
+			}
+		} else if !requireStatsAdmin(w, r, cfg) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(querymetrics.Render(store.QueryMetrics())))
+		w.Write([]byte(store.StartMetrics().Render()))
+		w.Write([]byte(requests.Render()))
+		w.Write([]byte(store.LoopMetrics().Render()))
+		w.Write([]byte(renderStateMetrics(r.Context(), store, cfg.Bus)))
+	}
+}
+
+func validMetricsToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
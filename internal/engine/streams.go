@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/proxy"
+)
+
+// =============================================================================
+// TCP and UDP Streams
+// =============================================================================
+
+// templateStreams returns the TCP and UDP streams the deployment's template
+// declares under x-hoster streams, without host ports.
+func templateStreams(ctx context.Context, store *Store, data map[string]any) ([]domain.StreamPort, error) {
+	tmpl, err := store.GetByID(ctx, "templates", toInt(data["template_id"]))
+	if err != nil {
+		return nil, fmt.Errorf("load template: %w", err)
+	}
+	ext, err := compose.ParseExtension(strVal(tmpl["compose_spec"]))
+	if err != nil || ext == nil {
+		return nil, nil
+	}
+	streams := make([]domain.StreamPort, len(ext.Streams))
+	for i, s := range ext.Streams {
+		streams[i] = domain.StreamPort{Service: s.Service, Port: int(s.Port), Protocol: s.Protocol, TLS: s.TLS}
+	}
+	return streams, nil
+}
+
+// assignStreamPorts gives every stream that needs a host port one from the
+// stream port range that isn't in used. Streams keep a host port nothing
+// else uses; TLS streams share the SNI entry point and need none. It reports
+// whether any stream changed.
+func assignStreamPorts(streams []domain.StreamPort, used []int) (bool, error) {
+	taken := append([]int(nil), used...)
+	changed := false
+	for i := range streams {
+		s := &streams[i]
+		if s.TLS {
+			continue
+		}
+		if s.HostPort > 0 && !slices.Contains(taken, s.HostPort) {
+			taken = append(taken, s.HostPort)
+			continue
+		}
+		port, err := proxy.AllocatePort(taken, proxy.DefaultStreamPortRange())
+		if err != nil {
+			return changed, fmt.Errorf("allocate %s stream port for %s: %w", s.Protocol, s.Service, err)
+		}
+		s.HostPort = port
+		taken = append(taken, port)
+		changed = true
+	}
+	return changed, nil
+}
+
+// streamHostPorts returns the host ports of a deployment's stream_ports.
+func streamHostPorts(v any) []int {
+	var streams []domain.StreamPort
+	decodeJSONValue(v, &streams)
+	var ports []int
+	for _, s := range streams {
+		if s.HostPort > 0 {
+			ports = append(ports, s.HostPort)
+		}
+	}
+	return ports
+}
+
+// encodeStreams returns streams as a stream_ports value.
+func encodeStreams(streams []domain.StreamPort) string {
+	b, _ := json.Marshal(streams)
+	return string(b)
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Container Events Timeline & Support Bundle
+// =============================================================================
+//
+// GET /deployments/{id}/monitoring/events is the deployment's event timeline.
+// GET /deployments/{id}/support-bundle collects what support needs to look at
+// a misbehaving deployment in one document: its status, health and
+// containers, the recent timeline, and the forensics of recent crashes.
+
+// supportBundleEvents is how many recent events a support bundle carries.
+const supportBundleEvents = 200
+
+// containerEvents returns a deployment's most recent container events, newest
+// first, optionally of one type. Forensics kept in details are decoded.
+func containerEvents(ctx context.Context, cfg SetupConfig, deploymentID int64, eventType string, limit int) ([]map[string]any, error) {
+	query := "SELECT reference_id, type, container, message, details, timestamp FROM container_events WHERE deployment_id = ? ORDER BY timestamp DESC LIMIT ?"
+	args := []any{deploymentID, limit}
+	if eventType != "" {
+		query = "SELECT reference_id, type, container, message, details, timestamp FROM container_events WHERE deployment_id = ? AND type = ? ORDER BY timestamp DESC LIMIT ?"
+		args = []any{deploymentID, eventType, limit}
+	}
+
+	rows, err := cfg.Store.RawQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		event := map[string]any{
+			"id":        strVal(row["reference_id"]),
+			"type":      strVal(row["type"]),
+			"container": strVal(row["container"]),
+			"message":   strVal(row["message"]),
+			"timestamp": strVal(row["timestamp"]),
+		}
+		var f domain.ContainerForensics
+		if details := strVal(row["details"]); details != "" && decodeJSONValue(details, &f) == nil {
+			event["forensics"] = f
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// supportBundle builds a deployment's support bundle.
+func supportBundle(ctx context.Context, cfg SetupConfig, depl map[string]any, _ *http.Request) map[string]any {
+	refID := strVal(depl["reference_id"])
+	deplID, _ := toInt64(depl["id"])
+
+	events, err := containerEvents(ctx, cfg, deplID, "", supportBundleEvents)
+	if err != nil {
+		cfg.Logger.Warn("failed to query container events", "deployment", refID, "error", err)
+		events = []map[string]any{}
+	}
+	crashes := []map[string]any{}
+	for _, e := range events {
+		if _, ok := e["forensics"]; ok {
+			crashes = append(crashes, e)
+		}
+	}
+
+	var health domain.DeploymentHealth
+	_ = decodeJSONValue(depl["health"], &health)
+	var containers []domain.ContainerInfo
+	_ = decodeJSONValue(depl["containers"], &containers)
+
+	return map[string]any{
+		"data": map[string]any{
+			"type": "deployment-support-bundles",
+			"id":   refID,
+			"attributes": map[string]any{
+				"generated_at": time.Now().UTC().Format(time.RFC3339),
+				"deployment": map[string]any{
+					"id":               refID,
+					"name":             strVal(depl["name"]),
+					"status":           strVal(depl["status"]),
+					"error_message":    strVal(depl["error_message"]),
+					"node_id":          strVal(depl["node_id"]),
+					"template_version": strVal(depl["template_version"]),
+					"created_at":       strVal(depl["created_at"]),
+					"updated_at":       strVal(depl["updated_at"]),
+				},
+				"health":     health,
+				"containers": containers,
+				"crashes":    crashes,
+				"events":     events,
+			},
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Compose x-hoster Extension
+// =============================================================================
+
+// mergeComposeExtension merges the variables and presets declared in the
+// x-hoster block of data["compose_spec"] into data. Routing and health check
+// overrides stay in the compose spec and are applied when it is parsed at
+// deploy time.
+//
+// Variables and presets sent in the same request win over the extension's by
+// name. Otherwise the extension's win over those already stored (existing),
+// so editing the compose file updates them; stored ones it does not mention
+// are kept.
+func mergeComposeExtension(data, existing map[string]any) error {
+	spec, ok := data["compose_spec"].(string)
+	if !ok {
+		return nil
+	}
+	ext, err := compose.ParseExtension(spec)
+	if errors.Is(err, compose.ErrInvalidYAML) {
+		return nil // reported when the spec is parsed for deployment
+	}
+	if err != nil {
+		return fmt.Errorf("compose_spec: %w", err)
+	}
+	if ext == nil {
+		return nil
+	}
+	// Validate the extension against the services it refers to.
+	if _, err := compose.ParseComposeSpec(spec); err != nil {
+		return fmt.Errorf("compose_spec: %w", err)
+	}
+
+	vars, err := mergeByName(data, existing, "variables", ext.Variables, func(v domain.Variable) string { return v.Name })
+	if err != nil {
+		return err
+	}
+	presets, err := mergeByName(data, existing, "presets", ext.Presets, func(p domain.Preset) string { return p.Name })
+	if err != nil {
+		return err
+	}
+	if len(ext.Variables) > 0 {
+		data["variables"] = vars
+	}
+	if len(ext.Presets) > 0 {
+		data["presets"] = presets
+	}
+	return nil
+}
+
+// lintComposeSpec records in compose_warnings the features of
+// data["compose_spec"] that deployments will reject, such as extending a
+// service from another file. The template is saved regardless.
+func lintComposeSpec(data map[string]any) {
+	if spec, ok := data["compose_spec"].(string); ok {
+		data["compose_warnings"] = compose.Lint(spec)
+	}
+}
+
+// mergeByName merges extension items into the items of a JSON field, keyed
+// by name. Items in data take precedence; stored items are replaced.
+func mergeByName[T any](data, existing map[string]any, field string, fromExt []T, name func(T) string) ([]T, error) {
+	base, explicit := data[field]
+	if !explicit && existing != nil {
+		base = existing[field]
+	}
+	var items []T
+	if err := decodeJSONValue(base, &items); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+
+	index := make(map[string]int, len(items))
+	for i, item := range items {
+		index[name(item)] = i
+	}
+	for _, item := range fromExt {
+		i, found := index[name(item)]
+		switch {
+		case !found:
+			index[name(item)] = len(items)
+			items = append(items, item)
+		case !explicit:
+			items[i] = item
+		}
+	}
+	return items, nil
+}
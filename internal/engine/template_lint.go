@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/artpar/hoster/internal/core/compose"
+	"github.com/artpar/hoster/internal/core/domain"
+)
+
+// =============================================================================
+// Template Validation
+// =============================================================================
+
+// templateValidateHandler handles POST /templates/validate. It lints a
+// compose spec without saving anything and returns its errors and warnings
+// with their line positions. With template_id, the template's variables,
+// published state and (unless given) compose spec are linted.
+func templateValidateHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		var req struct {
+			ComposeSpec *string           `json:"compose_spec"`
+			Variables   []domain.Variable `json:"variables"`
+			Published   *bool             `json:"published"`
+			TemplateID  string            `json:"template_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+
+		var opts compose.LintOptions
+		var spec string
+		if req.TemplateID != "" {
+			tmpl, err := cfg.Store.Get(ctx, "templates", req.TemplateID)
+			if err != nil {
+				writeProblem(w, r, ProblemNotFound, "template not found")
+				return
+			}
+			if ownerID, ok := toInt64(tmpl["creator_id"]); !ok || int(ownerID) != authCtx.UserID {
+				writeProblem(w, r, ProblemForbidden, "not authorized")
+				return
+			}
+			spec = strVal(tmpl["compose_spec"])
+			opts.Published = boolVal(tmpl["published"])
+			if req.Variables == nil {
+				decodeJSONValue(tmpl["variables"], &req.Variables)
+			}
+		}
+		if req.ComposeSpec != nil {
+			spec = *req.ComposeSpec
+		}
+		if req.ComposeSpec == nil && req.TemplateID == "" {
+			writeProblem(w, r, ProblemValidationFailed, "give compose_spec or template_id")
+			return
+		}
+		if req.Published != nil {
+			opts.Published = *req.Published
+		}
+		for _, v := range req.Variables {
+			opts.Variables = append(opts.Variables, v.Name)
+		}
+
+		errs, warnings := []compose.Issue{}, []compose.Issue{}
+		for _, issue := range compose.LintSpec(spec, opts) {
+			if issue.Severity == compose.SeverityError {
+				errs = append(errs, issue)
+			} else {
+				warnings = append(warnings, issue)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "template-validations",
+				"attributes": map[string]any{
+					"valid":     len(errs) == 0,
+					"published": opts.Published,
+					"errors":    errs,
+					"warnings":  warnings,
+				},
+			},
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package engine
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/registry"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Template Registry
+// =============================================================================
+
+// maxBundleBytes bounds a bundle fetched or posted for import.
+const maxBundleBytes = 4 << 20
+
+// TemplateRegistry signs the template bundles this instance exports and
+// verifies the ones it imports. Bundles signed with this instance's own key
+// are always trusted.
+type TemplateRegistry struct {
+	key     ed25519.PrivateKey
+	trusted []ed25519.PublicKey
+	origin  string
+	client  *http.Client
+}
+
+// NewTemplateRegistry creates a registry. Without a key templates can be
+// imported but not exported. origin is this instance's public base URL.
+func NewTemplateRegistry(key ed25519.PrivateKey, trusted []ed25519.PublicKey, origin string) *TemplateRegistry {
+	if key != nil {
+		trusted = append(trusted, key.Public().(ed25519.PublicKey))
+	}
+	return &TemplateRegistry{
+		key:     key,
+		trusted: trusted,
+		origin:  strings.TrimRight(origin, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Export builds a signed bundle of a template.
+func (g *TemplateRegistry) Export(tmpl map[string]any, publisher string, now time.Time) (registry.Bundle, error) {
+	var assets []registry.Asset
+	if err := decodeJSONValue(tmpl["assets"], &assets); err != nil {
+		return registry.Bundle{}, fmt.Errorf("invalid assets: %w", err)
+	}
+	memoryMB, _ := toInt64(tmpl["resources_memory_mb"])
+	diskMB, _ := toInt64(tmpl["resources_disk_mb"])
+	b := registry.Bundle{
+		Format: registry.Format,
+		Template: registry.Template{
+			Name:                 strVal(tmpl["name"]),
+			Description:          strVal(tmpl["description"]),
+			Version:              strVal(tmpl["version"]),
+			Category:             strVal(tmpl["category"]),
+			ComposeSpec:          strVal(tmpl["compose_spec"]),
+			Variables:            rawJSON(tmpl["variables"]),
+			ConfigFiles:          rawJSON(tmpl["config_files"]),
+			SetupFlow:            rawJSON(tmpl["setup_flow"]),
+			Presets:              rawJSON(tmpl["presets"]),
+			Tags:                 rawJSON(tmpl["tags"]),
+			RequiredCapabilities: rawJSON(tmpl["required_capabilities"]),
+			ResourceCeilings:     rawJSON(tmpl["resource_ceilings"]),
+			ResourcesCPUCores:    floatVal(tmpl["resources_cpu_cores"]),
+			ResourcesMemoryMB:    memoryMB,
+			ResourcesDiskMB:      diskMB,
+		},
+		Assets: assets,
+		Provenance: registry.Provenance{
+			Origin:     g.origin,
+			Publisher:  publisher,
+			TemplateID: strVal(tmpl["reference_id"]),
+			ExportedAt: now.UTC().Truncate(time.Second),
+		},
+	}
+	if err := b.Validate(); err != nil {
+		return registry.Bundle{}, err
+	}
+	return registry.Sign(b, g.key), nil
+}
+
+// Fetch downloads a bundle from another instance or wherever it is hosted.
+func (g *TemplateRegistry) Fetch(ctx context.Context, rawURL string) (registry.Bundle, error) {
+	var b registry.Bundle
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return b, fmt.Errorf("bundle url must be an http(s) URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return b, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return b, fmt.Errorf("fetch bundle: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return b, fmt.Errorf("fetch bundle: %s returned %s", u.Host, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes+1))
+	if err != nil {
+		return b, fmt.Errorf("fetch bundle: %w", err)
+	}
+	if len(data) > maxBundleBytes {
+		return b, fmt.Errorf("bundle is larger than %d bytes", maxBundleBytes)
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("invalid bundle: %w", err)
+	}
+	return b, nil
+}
+
+// rawJSON encodes a decoded JSON column for a bundle; empty columns are
+// left out.
+func rawJSON(v any) json.RawMessage {
+	if v == nil || v == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// decodeRaw decodes a bundle's JSON value as the API would have received it.
+func decodeRaw(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// validateTemplateAssets checks a template's assets field.
+func validateTemplateAssets(v any) error {
+	var assets []registry.Asset
+	if err := decodeJSONValue(v, &assets); err != nil {
+		return fmt.Errorf("invalid assets: %w", err)
+	}
+	return registry.ValidateAssets(assets)
+}
+
+// templateSource returns the registry source a template was imported from,
+// or nil for a template made on this instance.
+func templateSource(tmpl map[string]any) *registry.Source {
+	var s registry.Source
+	if err := decodeJSONValue(tmpl["registry_source"], &s); err != nil || s.Origin == "" {
+		return nil
+	}
+	return &s
+}
+
+// =============================================================================
+// Template Registry Handlers
+// =============================================================================
+
+// templateExportHandler serves POST /templates/{id}/export: a signed bundle
+// of the template for import into another instance, as a file download.
+func templateExportHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		tmpl, err := cfg.Store.Get(ctx, "templates", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		if ownerID, ok := toInt64(tmpl["creator_id"]); !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		if cfg.Registry == nil || cfg.Registry.key == nil {
+			writeProblem(w, r, ProblemNotConfigured, "template export needs a registry signing key")
+			return
+		}
+
+		bundle, err := cfg.Registry.Export(tmpl, authCtx.ReferenceID, time.Now())
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		filename := fmt.Sprintf("%s-%s.hoster.json", strVal(tmpl["slug"]), bundle.Template.Version)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		writeJSON(w, http.StatusOK, bundle)
+	}
+}
+
+// templateImportHandler serves POST /templates/import. The body carries a
+// bundle, or the URL of one:
+//
+//	{"bundle": {...}}
+//	{"url": "https://other.example.com/bundles/blog-1.2.0.hoster.json"}
+//
+// The bundle must be signed by a trusted key. It becomes a new unpublished
+// template of the caller's, or, when the caller already imported the same
+// template from the same origin, updates it to the bundle's newer version.
+func templateImportHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.Registry == nil {
+			writeProblem(w, r, ProblemNotConfigured, "template import needs registry keys")
+			return
+		}
+
+		var req struct {
+			Bundle *registry.Bundle `json:"bundle"`
+			URL    string           `json:"url"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleBytes)).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid request body")
+			return
+		}
+		if (req.Bundle == nil) == (req.URL == "") {
+			writeProblem(w, r, ProblemValidationFailed, "give either bundle or url")
+			return
+		}
+		var bundle registry.Bundle
+		if req.Bundle != nil {
+			bundle = *req.Bundle
+		} else {
+			var err error
+			if bundle, err = cfg.Registry.Fetch(ctx, req.URL); err != nil {
+				writeProblem(w, r, ProblemUpstreamFailed, err.Error())
+				return
+			}
+		}
+		if err := registry.Verify(bundle, cfg.Registry.trusted); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		existing, err := importedTemplate(ctx, cfg.Store, authCtx.UserID, bundle)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		res := cfg.Store.Resource("templates")
+		data := bundleTemplateData(bundle, time.Now())
+
+		var row map[string]any
+		status := http.StatusCreated
+		if existing != nil {
+			if err := registry.CheckUpdate(*templateSource(existing), bundle); err != nil {
+				writeProblem(w, r, ProblemInvalidState, err.Error())
+				return
+			}
+			if res.BeforeUpdate != nil {
+				if err := res.BeforeUpdate(ctx, authCtx, existing, data); err != nil {
+					writeProblem(w, r, hookProblem(err), err.Error())
+					return
+				}
+			}
+			row, err = cfg.Store.Update(ctx, "templates", strVal(existing["reference_id"]), data)
+			status = http.StatusOK
+		} else {
+			data["creator_id"] = authCtx.UserID
+			data["published"] = false
+			if res.BeforeCreate != nil {
+				if err := res.BeforeCreate(ctx, authCtx, data); err != nil {
+					writeProblem(w, r, hookProblem(err), err.Error())
+					return
+				}
+			}
+			row, err = cfg.Store.Create(ctx, "templates", data)
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				writeProblem(w, r, ProblemAlreadyExists, fmt.Sprintf("a template named %q already exists", bundle.Template.Name))
+				return
+			}
+			if strings.Contains(err.Error(), "validation error") {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+
+		cfg.Logger.Info("template imported",
+			"template", strVal(row["reference_id"]),
+			"origin", bundle.Provenance.Origin,
+			"source_template", bundle.Provenance.TemplateID,
+			"version", bundle.Template.Version,
+			"key_id", bundle.Signature.KeyID)
+
+		stripFields(res, row, cfg.Store, authCtx)
+		writeJSON(w, status, map[string]any{
+			"data": renderResource(r, cfg.Store, "templates", row),
+		})
+	}
+}
+
+// importedTemplate returns the creator's template imported earlier from the
+// bundle's source template, or nil.
+func importedTemplate(ctx context.Context, store *Store, creatorID int, b registry.Bundle) (map[string]any, error) {
+	rows, err := store.List(ctx, "templates", []Filter{{Field: "creator_id", Value: creatorID}}, Page{Limit: 10000})
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	for _, row := range rows {
+		if s := templateSource(row); s != nil && s.Same(b) {
+			return row, nil
+		}
+	}
+	return nil, nil
+}
+
+// bundleTemplateData returns the template fields a verified bundle sets.
+func bundleTemplateData(b registry.Bundle, now time.Time) map[string]any {
+	t := b.Template
+	data := map[string]any{
+		"name":                  t.Name,
+		"description":           t.Description,
+		"version":               t.Version,
+		"category":              t.Category,
+		"compose_spec":          t.ComposeSpec,
+		"variables":             decodeRaw(t.Variables),
+		"config_files":          decodeRaw(t.ConfigFiles),
+		"setup_flow":            decodeRaw(t.SetupFlow),
+		"presets":               decodeRaw(t.Presets),
+		"tags":                  decodeRaw(t.Tags),
+		"required_capabilities": decodeRaw(t.RequiredCapabilities),
+		"resource_ceilings":     decodeRaw(t.ResourceCeilings),
+		"resources_cpu_cores":   t.ResourcesCPUCores,
+		"resources_memory_mb":   t.ResourcesMemoryMB,
+		"resources_disk_mb":     t.ResourcesDiskMB,
+		"assets":                b.Assets,
+		"registry_source":       registry.SourceOf(b, now.UTC().Truncate(time.Second)),
+	}
+	if t.Category == "" {
+		data["category"] = nil
+	}
+	if t.Description == "" {
+		data["description"] = nil
+	}
+	return data
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/artpar/hoster/internal/core/catalog"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Template Search
+// =============================================================================
+//
+// GET /templates takes q, category, tags and published to search the
+// catalog. Text is matched with the templates_fts full-text index over
+// names, descriptions, categories and tags, and results are ranked by
+// relevance (bm25, names weighing most). The index is SQLite FTS5, which
+// needs the sqlite_fts5 build tag; builds without it match text with LIKE
+// and keep the newest-first order.
+
+// createTemplateSearchIndex creates the full-text index of templates and the
+// triggers keeping it in step, and rebuilds it from the templates table.
+func createTemplateSearchIndex(db *sqlx.DB) error {
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS templates_fts USING fts5(
+			name, description, category, tags,
+			content='templates', content_rowid='id', tokenize='porter unicode61'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS templates_fts_insert AFTER INSERT ON templates BEGIN
+			INSERT INTO templates_fts(rowid, name, description, category, tags)
+			VALUES (new.id, new.name, new.description, new.category, new.tags);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS templates_fts_delete AFTER DELETE ON templates BEGIN
+			INSERT INTO templates_fts(templates_fts, rowid, name, description, category, tags)
+			VALUES ('delete', old.id, old.name, old.description, old.category, old.tags);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS templates_fts_update AFTER UPDATE ON templates BEGIN
+			INSERT INTO templates_fts(templates_fts, rowid, name, description, category, tags)
+			VALUES ('delete', old.id, old.name, old.description, old.category, old.tags);
+			INSERT INTO templates_fts(rowid, name, description, category, tags)
+			VALUES (new.id, new.name, new.description, new.category, new.tags);
+		END`,
+		// Index templates written before the index existed
+		`INSERT INTO templates_fts(templates_fts) VALUES ('rebuild')`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasTemplateSearchIndex reports whether the full-text index exists.
+func (s *Store) hasTemplateSearchIndex(ctx context.Context) bool {
+	var n int
+	err := s.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'templates_fts'`)
+	return err == nil && n > 0
+}
+
+// listTemplates is the templates resource's ListFunc: a search when the
+// request has search parameters, a plain list otherwise.
+func listTemplates(ctx context.Context, store *Store, query url.Values, filters []Filter, page Page) ([]map[string]any, bool, error) {
+	q, ok, err := catalog.ParseQuery(query)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrValidation, err)
+	}
+	if !ok {
+		rows, err := store.List(ctx, "templates", filters, page)
+		return rows, true, err
+	}
+	return store.SearchTemplates(ctx, q, filters, page)
+}
+
+// SearchTemplates returns the templates matching a catalog query and the
+// filters. With query text and the full-text index, they are ranked by
+// relevance and ordered is false; otherwise they are newest first.
+func (s *Store) SearchTemplates(ctx context.Context, q catalog.Query, filters []Filter, page Page) (rows []map[string]any, ordered bool, err error) {
+	res := s.schema["templates"]
+	if err := s.injectFault(ctx, "list", "templates", ""); err != nil {
+		return nil, false, err
+	}
+	page = page.Normalize()
+
+	var where []string
+	var args []any
+	for _, f := range filters {
+		where = append(where, fmt.Sprintf("%s = ?", f.Field))
+		args = append(args, f.Value)
+	}
+	if q.Category != "" {
+		where = append(where, "lower(category) = lower(?)")
+		args = append(args, q.Category)
+	}
+	for _, tag := range q.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(tags) THEN tags ELSE '[]' END) WHERE lower(json_each.value) = ?)")
+		args = append(args, tag)
+	}
+	if q.Published != nil {
+		where = append(where, "published = ?")
+		args = append(args, *q.Published)
+	}
+
+	from := "templates"
+	orderBy := "datetime(created_at) DESC, id DESC"
+	ordered = true
+	switch match := q.MatchExpression(); {
+	case match == "":
+	case s.hasTemplateSearchIndex(ctx):
+		// Names weigh most, then tags, descriptions and categories
+		from = `templates JOIN (
+			SELECT rowid AS match_id, bm25(templates_fts, 10.0, 2.0, 1.0, 5.0) AS match_rank
+			FROM templates_fts WHERE templates_fts MATCH ?
+		) ON match_id = templates.id`
+		args = append([]any{match}, args...)
+		orderBy = "match_rank, " + orderBy
+		ordered = false
+	default:
+		for _, term := range q.Terms() {
+			where = append(where, `(lower(name) LIKE ? OR lower(COALESCE(description, '')) LIKE ?
+				OR lower(COALESCE(category, '')) LIKE ? OR lower(COALESCE(tags, '')) LIKE ?)`)
+			like := "%" + term + "%"
+			args = append(args, like, like, like, like)
+		}
+	}
+	if ordered {
+		if cond, condArgs := page.after("datetime(created_at)", "id", true); cond != "" {
+			where = append(where, cond)
+			args = append(args, condArgs...)
+		}
+	} else {
+		if page.Cursor != nil {
+			return nil, false, fmt.Errorf("%w: page[cursor] cannot be used with q; use page[offset]", ErrValidation)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", s.selectColumns(res), from)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d OFFSET %d", orderBy, page.Limit, page.Offset)
+
+	result, err := s.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("search templates: %w", err)
+	}
+	defer result.Close()
+	for result.Next() {
+		row := make(map[string]any)
+		if err := result.MapScan(row); err != nil {
+			return nil, false, fmt.Errorf("scan templates row: %w", err)
+		}
+		s.decodeRow(res, row)
+		rows = append(rows, row)
+	}
+	return rows, ordered, result.Err()
+}
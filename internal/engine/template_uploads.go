@@ -0,0 +1,680 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/registry"
+	"github.com/artpar/hoster/internal/core/upload"
+	"github.com/artpar/hoster/internal/shell/scan"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Template Uploads
+// =============================================================================
+//
+// Config files and assets can be uploaded to a template from a browser
+// instead of embedded in its JSON. Small files are posted as
+// multipart/form-data to /templates/{id}/config-files or
+// /templates/{id}/assets. Larger ones go in chunks: POST
+// /templates/{id}/uploads opens an upload, each PUT
+// /template-uploads/{id} appends a chunk named by its Content-Range, and GET
+// reports how much has arrived so an interrupted upload can resume. Chunks
+// are written to the upload directory; once the last one arrives the file
+// is checked and added to its template.
+
+// UploadScanner checks uploaded files for malware.
+type UploadScanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// errScanFailed is returned when a file could not be scanned.
+var errScanFailed = errors.New("malware scan failed")
+
+// TemplateUploads checks uploaded template files and keeps chunked uploads
+// in its directory until they complete.
+type TemplateUploads struct {
+	store   *Store
+	dir     string
+	scanner UploadScanner
+	logger  *slog.Logger
+	locks   sync.Map // upload reference ID -> *sync.Mutex
+}
+
+// NewTemplateUploads creates the upload handler state. scanner may be nil,
+// leaving the built-in executable and EICAR checks.
+func NewTemplateUploads(store *Store, dir string, scanner UploadScanner, logger *slog.Logger) *TemplateUploads {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &TemplateUploads{store: store, dir: dir, scanner: scanner, logger: logger}
+}
+
+// templateFile is an uploaded file on its way to a template.
+type templateFile struct {
+	Kind        upload.Kind
+	Name        string
+	Path        string
+	Mode        string
+	ContentType string
+	Data        []byte
+}
+
+// check refuses files that are executables or malware, and config files
+// that aren't text.
+func (u *TemplateUploads) check(ctx context.Context, f templateFile) error {
+	if err := upload.CheckName(f.Name); err != nil {
+		return err
+	}
+	if f.Kind == upload.KindConfigFile {
+		if err := upload.CheckConfigPath(f.Path); err != nil {
+			return err
+		}
+	}
+	if err := upload.CheckContent(f.Kind, f.Data); err != nil {
+		return err
+	}
+	if u.scanner != nil {
+		if err := u.scanner.Scan(ctx, bytes.NewReader(f.Data)); err != nil {
+			if errors.Is(err, scan.ErrInfected) {
+				return err
+			}
+			u.logger.Error("upload scan failed", "file", f.Name, "error", err)
+			return errScanFailed
+		}
+	}
+	return nil
+}
+
+// uploadProblem maps an upload error to its problem type.
+func uploadProblem(err error) ProblemType {
+	if errors.Is(err, errScanFailed) {
+		return ProblemUpstreamFailed
+	}
+	return hookProblem(err)
+}
+
+// attachTemplateFile adds f to the template, replacing the config file at
+// the same path (keeping whether it is a template) or the asset of the same
+// name, through the template update hook.
+func attachTemplateFile(ctx context.Context, cfg SetupConfig, authCtx AuthContext, tmpl map[string]any, f templateFile) (map[string]any, error) {
+	data := map[string]any{}
+	switch f.Kind {
+	case upload.KindConfigFile:
+		files := templateConfigFiles(tmpl)
+		cf := domain.ConfigFile{Name: f.Name, Path: f.Path, Content: string(f.Data), Mode: f.Mode}
+		replaced := false
+		for i := range files {
+			if files[i].Path == f.Path {
+				cf.Template = files[i].Template
+				files[i], replaced = cf, true
+			}
+		}
+		if !replaced {
+			files = append(files, cf)
+		}
+		data["config_files"] = files
+	case upload.KindAsset:
+		var assets []registry.Asset
+		if err := decodeJSONValue(tmpl["assets"], &assets); err != nil {
+			return nil, fmt.Errorf("invalid assets: %w", err)
+		}
+		a := registry.Asset{Name: f.Name, ContentType: f.ContentType, Data: f.Data}
+		replaced := false
+		for i := range assets {
+			if assets[i].Name == f.Name {
+				assets[i], replaced = a, true
+			}
+		}
+		if !replaced {
+			assets = append(assets, a)
+		}
+		data["assets"] = assets
+	}
+
+	if res := cfg.Store.Resource("templates"); res != nil && res.BeforeUpdate != nil {
+		if err := res.BeforeUpdate(ctx, authCtx, tmpl, data); err != nil {
+			return nil, err
+		}
+	}
+	return cfg.Store.Update(ctx, "templates", strVal(tmpl["reference_id"]), data)
+}
+
+// ownTemplate loads the template named in the path and checks the caller
+// created it, writing the problem when not.
+func ownTemplate(w http.ResponseWriter, r *http.Request, cfg SetupConfig) (map[string]any, bool) {
+	authCtx := getAuthContext(r)
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return nil, false
+	}
+	tmpl, err := cfg.Store.Get(r.Context(), "templates", mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, ProblemNotFound, "template not found")
+		return nil, false
+	}
+	if ownerID, ok := toInt64(tmpl["creator_id"]); !ok || int(ownerID) != authCtx.UserID {
+		writeProblem(w, r, ProblemForbidden, "not authorized")
+		return nil, false
+	}
+	return tmpl, true
+}
+
+// writeTemplate responds with the template after a file was added.
+func writeTemplate(w http.ResponseWriter, r *http.Request, cfg SetupConfig, row map[string]any) {
+	stripFields(cfg.Store.Resource("templates"), row, cfg.Store, getAuthContext(r))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": renderResource(r, cfg.Store, "templates", row),
+	})
+}
+
+// =============================================================================
+// Multipart Uploads
+// =============================================================================
+
+// maxFormFieldBytes caps the text fields sent alongside an uploaded file.
+const maxFormFieldBytes = 4 << 10
+
+// templateFileUploadHandler serves POST /templates/{id}/config-files and
+// POST /templates/{id}/assets. The multipart/form-data body carries the
+// file in its "file" field, and for config files the mount "path" and an
+// optional "mode". "name" overrides the file's name, and for assets
+// "content_type" its type. The body is read part by part and never held
+// beyond the plan's size cap.
+func templateFileUploadHandler(cfg SetupConfig, kind upload.Kind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		tmpl, ok := ownTemplate(w, r, cfg)
+		if !ok {
+			return
+		}
+		if cfg.Uploads == nil {
+			writeProblem(w, r, ProblemNotConfigured, "template uploads are not configured")
+			return
+		}
+
+		max := upload.MaxBytes(kind, authCtx.PlanLimits.MaxUploadKB)
+		r.Body = http.MaxBytesReader(w, r.Body, max+64<<10)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "expected a multipart/form-data body")
+			return
+		}
+
+		f := templateFile{Kind: kind}
+		var name string
+		var gotFile bool
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeUploadReadProblem(w, r, err)
+				return
+			}
+			if part.FormName() == "file" {
+				f.Data, err = io.ReadAll(io.LimitReader(part, max+1))
+				if err != nil {
+					writeUploadReadProblem(w, r, err)
+					return
+				}
+				if int64(len(f.Data)) > max {
+					writeProblem(w, r, ProblemPayloadTooLarge, fmt.Sprintf("file is larger than %d bytes", max))
+					return
+				}
+				f.Name = filepath.Base(part.FileName())
+				f.ContentType = part.Header.Get("Content-Type")
+				gotFile = true
+				continue
+			}
+			value, err := readFormField(part)
+			if err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, err.Error())
+				return
+			}
+			switch part.FormName() {
+			case "name":
+				name = value
+			case "path":
+				f.Path = value
+			case "mode":
+				f.Mode = value
+			case "content_type":
+				f.ContentType = value
+			}
+		}
+		if !gotFile {
+			writeProblem(w, r, ProblemValidationFailed, "file is required")
+			return
+		}
+		if name != "" {
+			f.Name = name
+		}
+		if kind == upload.KindAsset && (f.ContentType == "" || f.ContentType == "application/octet-stream") {
+			f.ContentType = http.DetectContentType(f.Data)
+		}
+
+		if err := cfg.Uploads.check(ctx, f); err != nil {
+			writeProblem(w, r, uploadProblem(err), err.Error())
+			return
+		}
+		row, err := attachTemplateFile(ctx, cfg, authCtx, tmpl, f)
+		if err != nil {
+			writeProblem(w, r, hookProblem(err), err.Error())
+			return
+		}
+		cfg.Logger.Info("template file uploaded", "template", strVal(tmpl["reference_id"]),
+			"kind", kind, "name", f.Name, "bytes", len(f.Data))
+		writeTemplate(w, r, cfg, row)
+	}
+}
+
+// readFormField reads a multipart text field.
+func readFormField(part *multipart.Part) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("read field %s: %w", part.FormName(), err)
+	}
+	if len(b) > maxFormFieldBytes {
+		return "", fmt.Errorf("field %s is too long", part.FormName())
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeUploadReadProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, ProblemPayloadTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	writeProblem(w, r, ProblemInvalidRequest, "failed to read upload")
+}
+
+// =============================================================================
+// Chunked Upload Storage
+// =============================================================================
+
+// TemplateUpload is a chunked upload of a template file.
+type TemplateUpload struct {
+	ID          int64  `db:"id"`
+	ReferenceID string `db:"reference_id"`
+	TemplateID  string `db:"template_id"`
+	UserID      int64  `db:"user_id"`
+	Kind        string `db:"kind"`
+	Name        string `db:"name"`
+	Path        string `db:"path"`
+	Mode        string `db:"mode"`
+	ContentType string `db:"content_type"`
+	Size        int64  `db:"size"`
+	Received    int64  `db:"received"`
+	Status      string `db:"status"`
+	CreatedAt   string `db:"created_at"`
+	UpdatedAt   string `db:"updated_at"`
+	ExpiresAt   string `db:"expires_at"`
+}
+
+const templateUploadColumns = `id, reference_id, template_id, user_id, kind, name, path, mode,
+	content_type, size, received, status, created_at, updated_at, expires_at`
+
+// CreateTemplateUpload inserts a pending upload and fills in its IDs.
+func (s *Store) CreateTemplateUpload(ctx context.Context, u *TemplateUpload) error {
+	now := time.Now().UTC()
+	u.ReferenceID = "tupl_" + uuid.New().String()[:8]
+	u.Status = string(upload.StatusPending)
+	u.CreatedAt = now.Format(time.RFC3339)
+	u.UpdatedAt = u.CreatedAt
+	u.ExpiresAt = now.Add(upload.SessionTTL).Format(time.RFC3339)
+
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO template_uploads (reference_id, template_id, user_id, kind, name, path, mode,
+			content_type, size, received, status, created_at, updated_at, expires_at)
+		VALUES (:reference_id, :template_id, :user_id, :kind, :name, :path, :mode,
+			:content_type, :size, :received, :status, :created_at, :updated_at, :expires_at)`, u)
+	if err != nil {
+		return fmt.Errorf("create template upload: %w", err)
+	}
+	u.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// GetTemplateUpload returns an upload by reference ID.
+func (s *Store) GetTemplateUpload(ctx context.Context, refID string) (*TemplateUpload, error) {
+	var u TemplateUpload
+	if err := s.db.GetContext(ctx, &u,
+		`SELECT `+templateUploadColumns+` FROM template_uploads WHERE reference_id = ?`, refID); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SaveTemplateUpload writes an upload's progress and status.
+func (s *Store) SaveTemplateUpload(ctx context.Context, u *TemplateUpload) error {
+	u.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if _, err := s.db.NamedExecContext(ctx,
+		`UPDATE template_uploads SET received = :received, status = :status, updated_at = :updated_at
+		WHERE id = :id`, u); err != nil {
+		return fmt.Errorf("save template upload: %w", err)
+	}
+	return nil
+}
+
+// DeleteTemplateUpload removes an upload's row.
+func (s *Store) DeleteTemplateUpload(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM template_uploads WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete template upload: %w", err)
+	}
+	return nil
+}
+
+// ExpiredTemplateUploads returns pending uploads past their expiry.
+func (s *Store) ExpiredTemplateUploads(ctx context.Context, now time.Time) ([]*TemplateUpload, error) {
+	var out []*TemplateUpload
+	if err := s.db.SelectContext(ctx, &out,
+		`SELECT `+templateUploadColumns+` FROM template_uploads WHERE status = ? AND expires_at < ?`,
+		string(upload.StatusPending), now.UTC().Format(time.RFC3339)); err != nil {
+		return nil, fmt.Errorf("list expired template uploads: %w", err)
+	}
+	return out, nil
+}
+
+// partPath is where an upload's chunks are written.
+func (u *TemplateUploads) partPath(up *TemplateUpload) string {
+	return filepath.Join(u.dir, up.ReferenceID+".part")
+}
+
+// lock serializes the chunks of one upload.
+func (u *TemplateUploads) lock(refID string) func() {
+	m, _ := u.locks.LoadOrStore(refID, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// discard deletes an upload and its chunks.
+func (u *TemplateUploads) discard(ctx context.Context, up *TemplateUpload) {
+	if err := os.Remove(u.partPath(up)); err != nil && !os.IsNotExist(err) {
+		u.logger.Warn("failed to remove upload chunks", "upload", up.ReferenceID, "error", err)
+	}
+	if err := u.store.DeleteTemplateUpload(ctx, up.ID); err != nil {
+		u.logger.Warn("failed to delete upload", "upload", up.ReferenceID, "error", err)
+	}
+	u.locks.Delete(up.ReferenceID)
+}
+
+// purgeExpired discards uploads that were never finished.
+func (u *TemplateUploads) purgeExpired(ctx context.Context) {
+	ups, err := u.store.ExpiredTemplateUploads(ctx, time.Now())
+	if err != nil {
+		u.logger.Warn("failed to list expired uploads", "error", err)
+		return
+	}
+	for _, up := range ups {
+		u.discard(ctx, up)
+	}
+}
+
+// writeChunk appends a chunk read from body to the upload's file. A chunk
+// that arrives short is rolled back so the client can send it again.
+func (u *TemplateUploads) writeChunk(up *TemplateUpload, rng upload.Range, body io.Reader) error {
+	if err := os.MkdirAll(u.dir, 0o700); err != nil {
+		return fmt.Errorf("create upload directory: %w", err)
+	}
+	f, err := os.OpenFile(u.partPath(up), os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open upload: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(rng.Start, io.SeekStart); err != nil {
+		return fmt.Errorf("seek upload: %w", err)
+	}
+	n, err := io.Copy(f, io.LimitReader(body, rng.Len()))
+	if err == nil && n != rng.Len() {
+		err = fmt.Errorf("chunk has %d bytes, Content-Range names %d", n, rng.Len())
+	}
+	if err != nil {
+		f.Truncate(rng.Start)
+		return err
+	}
+	return nil
+}
+
+// =============================================================================
+// Chunked Upload Handlers
+// =============================================================================
+
+// templateUploadCreateHandler serves POST /templates/{id}/uploads, opening
+// a chunked upload:
+//
+//	{"kind": "asset", "name": "screenshot.png", "content_type": "image/png", "size": 200000}
+//	{"kind": "config_file", "name": "nginx.conf", "path": "/etc/nginx/nginx.conf", "size": 90000}
+func templateUploadCreateHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		tmpl, ok := ownTemplate(w, r, cfg)
+		if !ok {
+			return
+		}
+		if cfg.Uploads == nil || cfg.Uploads.dir == "" {
+			writeProblem(w, r, ProblemNotConfigured, "chunked uploads are not configured")
+			return
+		}
+
+		var req struct {
+			Kind        string `json:"kind"`
+			Name        string `json:"name"`
+			Path        string `json:"path"`
+			Mode        string `json:"mode"`
+			ContentType string `json:"content_type"`
+			Size        int64  `json:"size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		kind, err := upload.ParseKind(req.Kind)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		if err := upload.CheckName(req.Name); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		if kind == upload.KindConfigFile {
+			if err := upload.CheckConfigPath(req.Path); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+		}
+		if max := upload.MaxBytes(kind, authCtx.PlanLimits.MaxUploadKB); req.Size <= 0 || req.Size > max {
+			writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("size must be between 1 and %d bytes", max))
+			return
+		}
+
+		cfg.Uploads.purgeExpired(ctx)
+		up := &TemplateUpload{
+			TemplateID:  strVal(tmpl["reference_id"]),
+			UserID:      int64(authCtx.UserID),
+			Kind:        string(kind),
+			Name:        req.Name,
+			Path:        req.Path,
+			Mode:        req.Mode,
+			ContentType: req.ContentType,
+			Size:        req.Size,
+		}
+		if err := cfg.Store.CreateTemplateUpload(ctx, up); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to open upload")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"data": templateUploadJSONAPI(up)})
+	}
+}
+
+// templateUploadHandler serves /template-uploads/{id}. GET reports the
+// upload's progress, PUT appends the chunk named by the Content-Range
+// header, and DELETE abandons the upload. The chunk that completes the
+// upload adds the file to its template.
+func templateUploadHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.Uploads == nil {
+			writeProblem(w, r, ProblemNotConfigured, "chunked uploads are not configured")
+			return
+		}
+		refID := mux.Vars(r)["id"]
+		unlock := cfg.Uploads.lock(refID)
+		defer unlock()
+
+		up, err := cfg.Store.GetTemplateUpload(ctx, refID)
+		if err != nil || up.UserID != int64(authCtx.UserID) {
+			writeProblem(w, r, ProblemNotFound, "upload not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"data": templateUploadJSONAPI(up)})
+			return
+		case http.MethodDelete:
+			if up.Status == string(upload.StatusPending) {
+				cfg.Uploads.discard(ctx, up)
+			} else {
+				cfg.Store.DeleteTemplateUpload(ctx, up.ID)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if up.Status != string(upload.StatusPending) {
+			writeProblem(w, r, ProblemInvalidState, "upload is "+up.Status)
+			return
+		}
+		rng, err := upload.ParseContentRange(r.Header.Get("Content-Range"))
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		if err := rng.Check(up.Received, up.Size); err != nil {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+		if err := cfg.Uploads.writeChunk(up, rng, r.Body); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, err.Error())
+			return
+		}
+		up.Received = rng.End + 1
+		if up.Received < up.Size {
+			if err := cfg.Store.SaveTemplateUpload(ctx, up); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to record chunk")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": templateUploadJSONAPI(up)})
+			return
+		}
+
+		// Last chunk: check the file and add it to the template. A file
+		// that fails is discarded with its upload.
+		data, err := os.ReadFile(cfg.Uploads.partPath(up))
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to read upload")
+			return
+		}
+		f := templateFile{
+			Kind:        upload.Kind(up.Kind),
+			Name:        up.Name,
+			Path:        up.Path,
+			Mode:        up.Mode,
+			ContentType: up.ContentType,
+			Data:        data,
+		}
+		if f.Kind == upload.KindAsset && f.ContentType == "" {
+			f.ContentType = http.DetectContentType(data)
+		}
+		if err := cfg.Uploads.check(ctx, f); err != nil {
+			if !errors.Is(err, errScanFailed) {
+				cfg.Uploads.discard(ctx, up)
+			} else {
+				// Keep the chunks so the last one can be sent again
+				os.Truncate(cfg.Uploads.partPath(up), rng.Start)
+			}
+			writeProblem(w, r, uploadProblem(err), err.Error())
+			return
+		}
+		tmpl, err := cfg.Store.Get(ctx, "templates", up.TemplateID)
+		if err != nil {
+			cfg.Uploads.discard(ctx, up)
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		row, err := attachTemplateFile(ctx, cfg, authCtx, tmpl, f)
+		if err != nil {
+			cfg.Uploads.discard(ctx, up)
+			writeProblem(w, r, hookProblem(err), err.Error())
+			return
+		}
+
+		os.Remove(cfg.Uploads.partPath(up))
+		cfg.Uploads.locks.Delete(up.ReferenceID)
+		up.Status = string(upload.StatusComplete)
+		if err := cfg.Store.SaveTemplateUpload(ctx, up); err != nil {
+			cfg.Logger.Warn("failed to record finished upload", "upload", up.ReferenceID, "error", err)
+		}
+		cfg.Logger.Info("template file uploaded", "template", up.TemplateID,
+			"kind", up.Kind, "name", up.Name, "bytes", up.Size, "upload", up.ReferenceID)
+		writeTemplate(w, r, cfg, row)
+	}
+}
+
+func templateUploadJSONAPI(u *TemplateUpload) map[string]any {
+	attrs := map[string]any{
+		"template_id": u.TemplateID,
+		"kind":        u.Kind,
+		"name":        u.Name,
+		"size":        u.Size,
+		"received":    u.Received,
+		"status":      u.Status,
+		"chunk_bytes": upload.ChunkBytes,
+		"created_at":  u.CreatedAt,
+		"expires_at":  u.ExpiresAt,
+	}
+	if u.Path != "" {
+		attrs["path"] = u.Path
+	}
+	if u.Mode != "" {
+		attrs["mode"] = u.Mode
+	}
+	if u.ContentType != "" {
+		attrs["content_type"] = u.ContentType
+	}
+	return map[string]any{
+		"type":       "template_uploads",
+		"id":         u.ReferenceID,
+		"attributes": attrs,
+	}
+}
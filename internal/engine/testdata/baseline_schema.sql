@@ -0,0 +1,225 @@
+-- Schema of a database left by the baseline release (file migration 2)
+
+CREATE TABLE templates (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  slug TEXT NOT NULL UNIQUE,
+  description TEXT,
+  version TEXT NOT NULL,
+  compose_spec TEXT NOT NULL,
+  variables TEXT,
+  config_files TEXT,
+  tags TEXT,
+  required_capabilities TEXT,
+  category TEXT,
+  resources_cpu_cores REAL DEFAULT 0,
+  resources_memory_mb INTEGER DEFAULT 0,
+  resources_disk_mb INTEGER DEFAULT 0,
+  price_monthly_cents INTEGER DEFAULT 0,
+  published INTEGER DEFAULT 0,
+  creator_id INTEGER NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+CREATE TABLE deployments (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  template_id INTEGER NOT NULL,
+  template_version TEXT,
+  customer_id INTEGER NOT NULL,
+  node_id TEXT,
+  status TEXT DEFAULT 'pending',
+  variables TEXT,
+  domains TEXT,
+  containers TEXT,
+  resources_cpu_cores REAL DEFAULT 0,
+  resources_memory_mb INTEGER DEFAULT 0,
+  resources_disk_mb INTEGER DEFAULT 0,
+  proxy_port INTEGER,
+  error_message TEXT,
+  started_at DATETIME,
+  stopped_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (template_id) REFERENCES templates(id),
+  FOREIGN KEY (customer_id) REFERENCES users(id)
+);
+
+CREATE TABLE nodes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  creator_id INTEGER NOT NULL,
+  ssh_host TEXT NOT NULL,
+  ssh_port INTEGER DEFAULT 22,
+  ssh_user TEXT NOT NULL,
+  ssh_key_id INTEGER,
+  docker_socket TEXT DEFAULT '/var/run/docker.sock',
+  status TEXT DEFAULT 'offline',
+  public INTEGER DEFAULT 0,
+  capabilities TEXT,
+  capacity_cpu_cores REAL DEFAULT 0,
+  capacity_memory_mb INTEGER DEFAULT 0,
+  capacity_disk_mb INTEGER DEFAULT 0,
+  capacity_cpu_used REAL DEFAULT 0,
+  capacity_memory_used_mb INTEGER DEFAULT 0,
+  capacity_disk_used_mb INTEGER DEFAULT 0,
+  location TEXT,
+  last_health_check DATETIME,
+  error_message TEXT,
+  provider_type TEXT DEFAULT 'manual',
+  provision_id TEXT,
+  base_domain TEXT,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (creator_id) REFERENCES users(id),
+  FOREIGN KEY (ssh_key_id) REFERENCES ssh_keys(id)
+);
+
+CREATE TABLE ssh_keys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  creator_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  private_key TEXT NOT NULL,
+  public_key TEXT,
+  fingerprint TEXT,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+CREATE TABLE cloud_credentials (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  creator_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  credentials TEXT NOT NULL,
+  default_region TEXT,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+CREATE TABLE cloud_provisions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  creator_id INTEGER NOT NULL,
+  credential_id INTEGER NOT NULL,
+  provider TEXT NOT NULL,
+  status TEXT DEFAULT 'pending',
+  instance_name TEXT NOT NULL,
+  region TEXT NOT NULL,
+  size TEXT NOT NULL,
+  provider_instance_id TEXT,
+  public_ip TEXT,
+  node_id TEXT,
+  ssh_key_id TEXT,
+  current_step TEXT,
+  error_message TEXT,
+  completed_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (creator_id) REFERENCES users(id),
+  FOREIGN KEY (credential_id) REFERENCES cloud_credentials(id)
+);
+
+CREATE TABLE invoices (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  reference_id TEXT UNIQUE NOT NULL,
+  user_id INTEGER NOT NULL,
+  period_start DATETIME,
+  period_end DATETIME,
+  items TEXT,
+  subtotal_cents INTEGER DEFAULT 0,
+  tax_cents INTEGER DEFAULT 0,
+  total_cents INTEGER DEFAULT 0,
+  currency TEXT DEFAULT 'USD',
+  status TEXT DEFAULT 'draft',
+  stripe_session_id TEXT,
+  stripe_payment_url TEXT,
+  paid_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE usage_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL DEFAULT '',
+			resource_type TEXT NOT NULL DEFAULT '',
+			quantity INTEGER NOT NULL DEFAULT 1,
+			metadata TEXT,
+			timestamp TEXT NOT NULL,
+			reported_at TEXT,
+			created_at TEXT NOT NULL DEFAULT (datetime('now'))
+		);
+
+CREATE TABLE container_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reference_id TEXT UNIQUE NOT NULL,
+			deployment_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			container TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			details TEXT,
+			timestamp TEXT NOT NULL DEFAULT (datetime('now'))
+		);
+
+CREATE TABLE schema_migrations (version uint64,dirty bool);
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reference_id TEXT UNIQUE NOT NULL,
+    email TEXT DEFAULT '',
+    name TEXT DEFAULT '',
+    plan_id TEXT DEFAULT 'free',
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX idx_templates_creator_id ON templates(creator_id);
+
+CREATE INDEX idx_deployments_template_id ON deployments(template_id);
+
+CREATE INDEX idx_deployments_customer_id ON deployments(customer_id);
+
+CREATE INDEX idx_deployments_node_id ON deployments(node_id);
+
+CREATE INDEX idx_nodes_creator_id ON nodes(creator_id);
+
+CREATE INDEX idx_nodes_ssh_key_id ON nodes(ssh_key_id);
+
+CREATE INDEX idx_nodes_provision_id ON nodes(provision_id);
+
+CREATE INDEX idx_ssh_keys_creator_id ON ssh_keys(creator_id);
+
+CREATE INDEX idx_cloud_credentials_creator_id ON cloud_credentials(creator_id);
+
+CREATE INDEX idx_cloud_provisions_creator_id ON cloud_provisions(creator_id);
+
+CREATE INDEX idx_cloud_provisions_credential_id ON cloud_provisions(credential_id);
+
+CREATE INDEX idx_cloud_provisions_node_id ON cloud_provisions(node_id);
+
+CREATE INDEX idx_cloud_provisions_ssh_key_id ON cloud_provisions(ssh_key_id);
+
+CREATE INDEX idx_invoices_user_id ON invoices(user_id);
+
+CREATE INDEX idx_usage_events_unreported ON usage_events(reported_at) WHERE reported_at IS NULL;
+
+CREATE INDEX idx_container_events_deployment_time ON container_events(deployment_id, timestamp DESC);
+
+CREATE UNIQUE INDEX version_unique ON schema_migrations (version);
+
+CREATE INDEX idx_users_reference_id ON users(reference_id);
+
+INSERT INTO schema_migrations (version, dirty) VALUES (2, 0);
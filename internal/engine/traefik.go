@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/traefik"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// =============================================================================
+// Traefik Labels
+// =============================================================================
+
+// configureTraefikLabels makes the orchestrator label primary containers
+// with their Traefik routes when proxy.traefik.mode is "labels".
+func configureTraefikLabels(deps *Deps, o *docker.Orchestrator) {
+	if opts, ok := deps.Extra["traefik_labels"].(traefik.RouteOptions); ok {
+		o.SetTraefikLabels(opts)
+	}
+}
+
+// =============================================================================
+// Traefik File Sync Worker
+// =============================================================================
+
+// TraefikFileConfig configures the file provider output.
+type TraefikFileConfig struct {
+	// Path is where the dynamic configuration file is written on each node.
+	Path string
+	// Upstream is the address Traefik reaches deployments' proxy ports at.
+	Upstream string
+	Options  traefik.RouteOptions
+
+	// Certificates adds the certificates hoster issued for the node's custom
+	// domains and routes their HTTP-01 challenges to ChallengeUpstream; nil
+	// leaves certificates to Traefik's resolver.
+	Certificates      *CertificateManager
+	ChallengeUpstream string
+	// RoutesFromLabels leaves deployments' routes to container labels, so
+	// the file carries only certificates and challenge routes.
+	RoutesFromLabels bool
+}
+
+// TraefikFileSync keeps a Traefik dynamic configuration file on every online
+// node, routing the hostnames of the node's running deployments to their
+// proxy ports. A node's file is rewritten only when its routes change.
+type TraefikFileSync struct {
+	store    *Store
+	nodePool *docker.NodePool
+	cfg      TraefikFileConfig
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	// Hash of the file last written to each node
+	synced map[string][sha256.Size]byte
+}
+
+func NewTraefikFileSync(store *Store, nodePool *docker.NodePool, cfg TraefikFileConfig, interval time.Duration, logger *slog.Logger) *TraefikFileSync {
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	if cfg.Path == "" {
+		cfg.Path = traefik.DefaultConfigPath
+	}
+	if cfg.Upstream == "" {
+		cfg.Upstream = traefik.DefaultUpstream
+	}
+	return &TraefikFileSync{
+		store:    store,
+		nodePool: nodePool,
+		cfg:      cfg,
+		interval: interval,
+		logger:   logger.With("component", "traefik_file_sync"),
+	}
+}
+
+func (ts *TraefikFileSync) Start() {
+	ts.ctx, ts.cancel = context.WithCancel(context.Background())
+	ts.synced = make(map[string][sha256.Size]byte)
+	ts.wg.Add(1)
+	go ts.run()
+	ts.logger.Info("traefik file sync started", "interval", ts.interval, "path", ts.cfg.Path)
+}
+
+func (ts *TraefikFileSync) Stop() {
+	if ts.cancel != nil {
+		ts.cancel()
+	}
+	ts.wg.Wait()
+}
+
+func (ts *TraefikFileSync) run() {
+	defer ts.wg.Done()
+	ts.syncAll()
+
+	ticker := time.NewTicker(ts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ts.ctx.Done():
+			return
+		case <-ticker.C:
+			ts.syncAll()
+		}
+	}
+}
+
+func (ts *TraefikFileSync) syncAll() {
+	defer ts.store.LoopMetrics().Time("traefik_file_sync")()
+	nodes, err := ts.store.List(ts.ctx, "nodes", []Filter{
+		{Field: "status", Value: "online"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		ts.logger.Error("failed to list nodes", "error", err)
+		return
+	}
+
+	for _, node := range nodes {
+		ts.syncNode(strVal(node["reference_id"]))
+	}
+}
+
+func (ts *TraefikFileSync) syncNode(nodeRef string) {
+	content, err := ts.render(nodeRef)
+	if err != nil {
+		ts.logger.Error("failed to render traefik config", "node", nodeRef, "error", err)
+		return
+	}
+	sum := sha256.Sum256(content)
+	if last, ok := ts.synced[nodeRef]; ok && last == sum {
+		return
+	}
+
+	res, err := ts.nodePool.WriteTraefikConfig(ts.ctx, nodeRef, minion.TraefikConfigInput{
+		Path:    ts.cfg.Path,
+		Content: string(content),
+	})
+	if err != nil {
+		// Reachability is the health checker's job; retry next run
+		ts.logger.Warn("failed to write traefik config", "node", nodeRef, "error", err)
+		return
+	}
+	ts.synced[nodeRef] = sum
+	if res.Changed {
+		ts.logger.Info("updated traefik config", "node", nodeRef, "path", res.Path, "bytes", res.Bytes)
+	}
+}
+
+// render builds a node's file from its running deployments, with the
+// certificates of their custom domains.
+func (ts *TraefikFileSync) render(nodeRef string) ([]byte, error) {
+	rows, err := ts.store.List(ts.ctx, "deployments", []Filter{
+		{Field: "node_id", Value: nodeRef},
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []traefik.LabelParams
+	var custom []string
+	templates := map[int]map[string]any{}
+	for _, row := range rows {
+		depl := mapToDeployment(row)
+		tmpl, ok := templates[depl.TemplateID]
+		if !ok {
+			tmpl, _ = ts.store.GetByID(ts.ctx, "templates", depl.TemplateID)
+			templates[depl.TemplateID] = tmpl
+		}
+		applyTemplateRouting(depl, tmpl, row)
+		if params, ok := ts.cfg.Options.Params(depl.ReferenceID, "proxy", depl.Domains, depl.ProxyPort); ok && !ts.cfg.RoutesFromLabels {
+			routes = append(routes, params.WithRouting(depl.RoutingOptions))
+		}
+		for _, d := range depl.Domains {
+			if d.Type == domain.DomainTypeCustom && d.VerificationStatus == domain.DomainVerificationVerified {
+				custom = append(custom, domain.NormalizeHostname(d.Hostname))
+			}
+		}
+	}
+
+	cfg := traefik.NewDynamicConfig(routes, ts.cfg.Upstream)
+	if ts.cfg.Certificates != nil {
+		certs, err := ts.cfg.Certificates.NodeCertificates(ts.ctx, custom)
+		if err != nil {
+			return nil, err
+		}
+		cfg = cfg.WithCertificates(certs).WithChallengeRoute(custom, ts.cfg.ChallengeUpstream)
+	}
+	return traefik.Render(cfg)
+}
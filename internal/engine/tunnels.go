@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/core/tunnel"
+	"github.com/artpar/hoster/internal/shell/websocket"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Tunnel Configuration
+// =============================================================================
+//
+// A tunnel relays a client's TCP connection to a port of one of a
+// deployment's containers, so services without public routing (databases,
+// admin consoles) can still be reached. The client opens a WebSocket at
+// GET /deployments/{id}/tunnel; the control plane relays its frames through
+// the node's minion ("tunnel" command) to the container's address. Each
+// session is limited in time and bandwidth and recorded in tunnel_sessions.
+
+// TunnelOpener relays a byte stream to a container port on a node.
+// *docker.NodePool implements it via the node's minion.
+type TunnelOpener interface {
+	OpenTunnel(ctx context.Context, nodeID, containerID string, port int, in io.Reader, out io.Writer) error
+}
+
+// TunnelConfig configures deployment tunnels.
+type TunnelConfig struct {
+	// Nodes opens tunnels on deployments' nodes.
+	Nodes TunnelOpener
+	// MaxDuration is the longest a session may last; see tunnel.SessionDuration.
+	MaxDuration time.Duration
+	// Throttle caps each direction of a session.
+	Throttle tunnel.Throttle
+}
+
+// tunnelDrainTimeout is how long a session stays open after the client
+// finished sending, for the container's last replies.
+const tunnelDrainTimeout = 10 * time.Second
+
+// =============================================================================
+// Tunnel Session Storage
+// =============================================================================
+
+// TunnelSession is one tunnel into a deployment.
+type TunnelSession struct {
+	ID            int64          `db:"id"`
+	ReferenceID   string         `db:"reference_id"`
+	DeploymentID  string         `db:"deployment_id"`
+	UserID        int64          `db:"user_id"`
+	Service       string         `db:"service"`
+	Port          int            `db:"port"`
+	ClientAddress string         `db:"client_address"`
+	StartedAt     string         `db:"started_at"`
+	ExpiresAt     string         `db:"expires_at"`
+	EndedAt       sql.NullString `db:"ended_at"`   // NULL while open, or when the control plane stopped mid-session
+	BytesIn       int64          `db:"bytes_in"`   // Client to container
+	BytesOut      int64          `db:"bytes_out"`  // Container to client
+	EndReason     string         `db:"end_reason"` // tunnel.EndReason
+	ErrorMessage  string         `db:"error_message"`
+}
+
+const tunnelSessionColumns = `id, reference_id, deployment_id, user_id, service, port, client_address,
+	started_at, expires_at, ended_at, bytes_in, bytes_out, end_reason, error_message`
+
+// CreateTunnelSession records an opened session and fills in its IDs.
+func (s *Store) CreateTunnelSession(ctx context.Context, ts *TunnelSession) error {
+	ts.ReferenceID = "tun_" + uuid.New().String()[:8]
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO tunnel_sessions (reference_id, deployment_id, user_id, service, port, client_address,
+			started_at, expires_at)
+		VALUES (:reference_id, :deployment_id, :user_id, :service, :port, :client_address,
+			:started_at, :expires_at)`, ts)
+	if err != nil {
+		return fmt.Errorf("create tunnel session: %w", err)
+	}
+	ts.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// EndTunnelSession records how a session ended and what it carried.
+func (s *Store) EndTunnelSession(ctx context.Context, ts *TunnelSession) error {
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE tunnel_sessions SET ended_at = :ended_at, bytes_in = :bytes_in, bytes_out = :bytes_out,
+			end_reason = :end_reason, error_message = :error_message
+		WHERE id = :id`, ts)
+	if err != nil {
+		return fmt.Errorf("end tunnel session: %w", err)
+	}
+	return nil
+}
+
+// ListTunnelSessions returns a deployment's sessions, newest first.
+func (s *Store) ListTunnelSessions(ctx context.Context, deploymentID string, limit int) ([]*TunnelSession, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	var out []*TunnelSession
+	if err := s.db.SelectContext(ctx, &out,
+		`SELECT `+tunnelSessionColumns+` FROM tunnel_sessions WHERE deployment_id = ? ORDER BY id DESC LIMIT ?`,
+		deploymentID, limit); err != nil {
+		return nil, fmt.Errorf("list tunnel sessions: %w", err)
+	}
+	return out, nil
+}
+
+// tunnelSessionJSONAPI renders a session as a JSON:API resource object.
+func tunnelSessionJSONAPI(store *Store, ts *TunnelSession) map[string]any {
+	attrs := map[string]any{
+		"deployment_id":  ts.DeploymentID,
+		"service":        ts.Service,
+		"port":           ts.Port,
+		"client_address": ts.ClientAddress,
+		"started_at":     ts.StartedAt,
+		"expires_at":     ts.ExpiresAt,
+		"ended_at":       ts.EndedAt.String,
+		"bytes_in":       ts.BytesIn,
+		"bytes_out":      ts.BytesOut,
+		"end_reason":     ts.EndReason,
+		"error_message":  ts.ErrorMessage,
+	}
+	if ref, err := store.GetRefIDByIntID("users", int(ts.UserID)); err == nil {
+		attrs["user_id"] = ref
+	}
+	return map[string]any{
+		"type":       "tunnel_sessions",
+		"id":         ts.ReferenceID,
+		"attributes": attrs,
+	}
+}
+
+// =============================================================================
+// Relay
+// =============================================================================
+
+// meteredStream counts the bytes of one direction of a session and holds
+// them to the throttle.
+type meteredStream struct {
+	ctx      context.Context
+	throttle tunnel.Throttle
+	start    time.Time
+	n        atomic.Int64
+}
+
+// pace counts n more bytes and waits as long as the throttle asks.
+func (m *meteredStream) pace(n int) {
+	total := m.n.Add(int64(n))
+	delay := m.throttle.Delay(total, time.Since(m.start))
+	if delay <= 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-m.ctx.Done():
+	}
+}
+
+// tunnelReader reads what the client sends. When the client stops sending
+// it calls done with the read error: io.EOF for a clean close.
+type tunnelReader struct {
+	meteredStream
+	r    io.Reader
+	done func(error)
+}
+
+func (t *tunnelReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.pace(n)
+	if err != nil {
+		t.done(err)
+	}
+	return n, err
+}
+
+// tunnelWriter writes the container's replies to the client.
+type tunnelWriter struct {
+	meteredStream
+	w io.Writer
+}
+
+func (t *tunnelWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.pace(n)
+	return n, err
+}
+
+// relayTunnel relays ws to the container until either side closes or the
+// session expires, and records how it ended.
+func relayTunnel(ctx context.Context, cfg SetupConfig, ws *websocket.Conn, ts *TunnelSession, nodeID, containerID string, duration time.Duration) {
+	ctx, cancelTimeout := context.WithTimeout(ctx, duration)
+	defer cancelTimeout()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	in := &tunnelReader{
+		meteredStream: meteredStream{ctx: ctx, throttle: cfg.Tunnels.Throttle, start: now},
+		r:             ws,
+		done: func(err error) {
+			if errors.Is(err, io.EOF) {
+				// Give the container time to answer what the client sent last
+				time.AfterFunc(tunnelDrainTimeout, cancel)
+				return
+			}
+			cancel()
+		},
+	}
+	out := &tunnelWriter{
+		meteredStream: meteredStream{ctx: ctx, throttle: cfg.Tunnels.Throttle, start: now},
+		w:             ws,
+	}
+
+	err := cfg.Tunnels.Nodes.OpenTunnel(ctx, nodeID, containerID, ts.Port, in, out)
+
+	reason := tunnel.EndClosed
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		reason = tunnel.EndExpired
+		ws.CloseWithStatus(websocket.StatusPolicy, "session time limit reached")
+	case err != nil && ctx.Err() == nil:
+		reason = tunnel.EndFailed
+		ts.ErrorMessage = err.Error()
+		ws.CloseWithStatus(websocket.StatusInternalError, err.Error())
+	default:
+		// The client left, or the container closed the connection
+		ws.Close()
+	}
+
+	ts.EndReason = string(reason)
+	ts.BytesIn, ts.BytesOut = in.n.Load(), out.n.Load()
+	ts.EndedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	if err := cfg.Store.EndTunnelSession(context.WithoutCancel(ctx), ts); err != nil {
+		cfg.Logger.Error("failed to record tunnel session", "session", ts.ReferenceID, "error", err)
+	}
+	cfg.Logger.Info("tunnel closed", "session", ts.ReferenceID, "deployment", ts.DeploymentID,
+		"reason", reason, "bytes_in", ts.BytesIn, "bytes_out", ts.BytesOut, "error", ts.ErrorMessage)
+}
+
+// =============================================================================
+// Tunnel Handlers
+// =============================================================================
+
+// deploymentTunnelHandler serves GET /deployments/{id}/tunnel: a WebSocket
+// upgrade relaying binary frames to ?target=service:port of the running
+// deployment, for ?duration= (default and at most the configured limit).
+// Only the deployment's owner may open tunnels. Failures before the upgrade
+// are problem responses; later ones close the WebSocket with a reason.
+func deploymentTunnelHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		if cfg.Tunnels == nil {
+			writeProblem(w, r, ProblemNotConfigured, "tunnels are disabled")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+
+		q := r.URL.Query()
+		target, err := tunnel.ParseTarget(q.Get("target"))
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		var requested time.Duration
+		if v := q.Get("duration"); v != "" {
+			if requested, err = time.ParseDuration(v); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, "duration: "+err.Error())
+				return
+			}
+		}
+		duration, err := tunnel.SessionDuration(requested, cfg.Tunnels.MaxDuration)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		if status := strVal(depl["status"]); status != "running" {
+			writeProblem(w, r, ProblemInvalidState, fmt.Sprintf("deployment is %s, not running", status))
+			return
+		}
+		nodeID := strVal(depl["node_id"])
+		var containers []domain.ContainerInfo
+		decodeJSONValue(depl["containers"], &containers)
+		var containerID string
+		for _, c := range containers {
+			if c.ServiceName == target.Service {
+				containerID = c.ID
+				break
+			}
+		}
+		if containerID == "" {
+			writeProblem(w, r, ProblemValidationFailed, fmt.Sprintf("deployment has no running service %q", target.Service))
+			return
+		}
+		if nodeID == "" {
+			writeProblem(w, r, ProblemInvalidState, "deployment is not on a remote node")
+			return
+		}
+
+		ws, err := websocket.Accept(w, r)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "tunnels need a WebSocket upgrade: "+err.Error())
+			return
+		}
+
+		now := time.Now().UTC()
+		ts := &TunnelSession{
+			DeploymentID:  strVal(depl["reference_id"]),
+			UserID:        int64(authCtx.UserID),
+			Service:       target.Service,
+			Port:          target.Port,
+			ClientAddress: clientAddress(r),
+			StartedAt:     now.Format(time.RFC3339),
+			ExpiresAt:     now.Add(duration).Format(time.RFC3339),
+		}
+		// No session goes unrecorded
+		if err := cfg.Store.CreateTunnelSession(ctx, ts); err != nil {
+			cfg.Logger.Error("failed to record tunnel session", "deployment", ts.DeploymentID, "error", err)
+			ws.CloseWithStatus(websocket.StatusInternalError, "failed to record session")
+			return
+		}
+		cfg.Logger.Info("tunnel opened", "session", ts.ReferenceID, "deployment", ts.DeploymentID,
+			"target", target.String(), "client", ts.ClientAddress, "expires_at", ts.ExpiresAt)
+
+		relayTunnel(ctx, cfg, ws, ts, nodeID, containerID, duration)
+	}
+}
+
+// deploymentTunnelsHandler serves GET /deployments/{id}/tunnels: the
+// deployment's tunnel sessions, newest first, to its owner. It takes
+// ?limit= (default 20, max 100).
+func deploymentTunnelsHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		depl, err := cfg.Store.Get(ctx, "deployments", mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+				limit = n
+			}
+		}
+		sessions, err := cfg.Store.ListTunnelSessions(ctx, strVal(depl["reference_id"]), limit)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to list tunnel sessions")
+			return
+		}
+		data := make([]map[string]any, 0, len(sessions))
+		for _, ts := range sessions {
+			data = append(data, tunnelSessionJSONAPI(cfg.Store, ts))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
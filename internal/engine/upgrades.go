@@ -0,0 +1,751 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/sharing"
+	"github.com/artpar/hoster/internal/core/timezone"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// Upgrade Policy
+// =============================================================================
+
+// deploymentUpgradePolicy reads a deployment row's upgrade policy and windows.
+// A missing policy means manual, matching the column default: an upgrade
+// restarts the containers, so it only runs unattended when the customer
+// opts in.
+func deploymentUpgradePolicy(row map[string]any) (coredeployment.UpgradePolicy, []coredeployment.MaintenanceWindow, error) {
+	policy := coredeployment.UpgradePolicy(strVal(row["upgrade_policy"]))
+	if policy == "" {
+		policy = coredeployment.UpgradeManual
+	}
+	var windows []coredeployment.MaintenanceWindow
+	if err := decodeJSONValue(row["maintenance_windows"], &windows); err != nil {
+		return policy, nil, fmt.Errorf("invalid maintenance_windows: %w", err)
+	}
+	return policy, windows, nil
+}
+
+// maintenanceSchedule previews each windowed deployment's maintenance
+// windows in its owner's time zone: whether one is open and when the next
+// starts, with the zone in effect then.
+func maintenanceSchedule(store *Store) AfterReadFunc {
+	return func(ctx context.Context, authCtx AuthContext, rows []map[string]any) {
+		locs := newUserLocations(store)
+		now := time.Now()
+		for _, row := range rows {
+			_, windows, err := deploymentUpgradePolicy(row)
+			if err != nil || len(windows) == 0 {
+				continue
+			}
+			loc := locs.get(ctx, toInt(row["customer_id"]))
+			preview := map[string]any{
+				"open":     coredeployment.InMaintenanceWindow(windows, now, loc),
+				"timezone": timezone.Describe(loc, now),
+			}
+			if next, ok := coredeployment.NextMaintenanceWindow(windows, now, loc); ok {
+				preview["next_start_at"] = next.Format(time.RFC3339)
+				preview["timezone"] = timezone.Describe(loc, next)
+			}
+			row["maintenance_schedule"] = preview
+		}
+	}
+}
+
+// deploymentUpgradeStrategy reads a deployment row's upgrade strategy and
+// canary policy. A missing strategy means recreate, matching the column default.
+func deploymentUpgradeStrategy(row map[string]any) (coredeployment.UpgradeStrategy, coredeployment.CanaryPolicy, error) {
+	strategy := coredeployment.UpgradeStrategy(strVal(row["upgrade_strategy"]))
+	if strategy == "" {
+		strategy = coredeployment.StrategyRecreate
+	}
+	var policy coredeployment.CanaryPolicy
+	if err := decodeJSONValue(row["canary_policy"], &policy); err != nil {
+		return strategy, policy, fmt.Errorf("invalid canary_policy: %w", err)
+	}
+	return strategy, policy, nil
+}
+
+// validateDeploymentUpgradePolicy checks upgrade_policy, maintenance_windows,
+// upgrade_strategy and canary_policy.
+func validateDeploymentUpgradePolicy(row map[string]any) error {
+	policy, windows, err := deploymentUpgradePolicy(row)
+	if err != nil {
+		return err
+	}
+	if err := coredeployment.ValidateUpgradePolicy(policy, windows); err != nil {
+		return err
+	}
+	strategy, canary, err := deploymentUpgradeStrategy(row)
+	if err != nil {
+		return err
+	}
+	return coredeployment.ValidateUpgradeStrategy(strategy, canary)
+}
+
+// =============================================================================
+// Upgrade Variables
+// =============================================================================
+
+// variableValues reads a deployment's variables as strings.
+func variableValues(v any) (map[string]string, error) {
+	var raw map[string]any
+	if err := decodeJSONValue(v, &raw); err != nil {
+		return nil, fmt.Errorf("invalid variables: %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if v != nil {
+			values[k] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// upgradeVariableIssues checks a deployment's variables against the
+// template's current variable definitions, so an upgrade that would start
+// without a required value is held back instead of failing mid-flight.
+func upgradeVariableIssues(depl, tmpl map[string]any) ([]coredeployment.VariableIssue, error) {
+	var vars []domain.Variable
+	if err := decodeJSONValue(tmpl["variables"], &vars); err != nil {
+		return nil, fmt.Errorf("invalid template variables: %w", err)
+	}
+	values, err := variableValues(depl["variables"])
+	if err != nil {
+		return nil, err
+	}
+	return coredeployment.CheckVariables(vars, values), nil
+}
+
+// blockedUpgrade returns the updates marking an upgrade to version blocked
+// on the variables in issues.
+func blockedUpgrade(version string, issues []coredeployment.VariableIssue) map[string]any {
+	names := make([]string, len(issues))
+	for i, issue := range issues {
+		names[i] = fmt.Sprintf("%s (%s)", issue.Name, issue.Problem)
+	}
+	return map[string]any{
+		"upgrade_status":  string(coredeployment.UpgradeStatusBlocked),
+		"pending_version": version,
+		"error_message":   fmt.Sprintf("upgrade to %s needs variables: %s", version, strings.Join(names, ", ")),
+	}
+}
+
+// =============================================================================
+// Upgrade Command
+// =============================================================================
+
+// upgradeDeployment moves a running deployment to the template's current
+// version. With the recreate strategy the containers are recreated right
+// away; with the canary strategy the routed service's new version first
+// takes a share of the traffic (see startCanary). Volumes are kept.
+func upgradeDeployment(ctx context.Context, deps *Deps, data map[string]any) error {
+	refID := strVal(data["reference_id"])
+
+	u, err := prepareUpgrade(ctx, deps, data)
+	if err != nil {
+		return failUpgrade(ctx, deps.Store, refID, fmt.Sprintf("upgrade failed: %v", err))
+	}
+
+	// Variables may have changed since the scheduler checked them
+	issues, err := upgradeVariableIssues(data, u.tmpl)
+	if err != nil {
+		return failUpgrade(ctx, deps.Store, refID, fmt.Sprintf("upgrade failed: %v", err))
+	}
+	if len(issues) > 0 {
+		updates := blockedUpgrade(u.version, issues)
+		deps.Store.Update(ctx, "deployments", refID, updates)
+		return fmt.Errorf("%s: %s", refID, updates["error_message"])
+	}
+
+	deps.Logger.Info("upgrading deployment", "deployment", refID,
+		"from", strVal(data["template_version"]), "to", u.version)
+
+	if strategy, policy, _ := deploymentUpgradeStrategy(data); strategy == coredeployment.StrategyCanary {
+		if meter := getTrafficMeter(deps); meter != nil && u.depl.ProxyPort > 0 {
+			return startCanary(ctx, deps, u, policy.WithDefaults(), meter)
+		}
+		deps.Logger.Warn("canary upgrade needs the embedded proxy and a proxy port, recreating instead", "deployment", refID)
+	}
+	return recreateDeployment(ctx, deps, u)
+}
+
+// preparedUpgrade holds what an upgrade needs to start containers from the
+// template's current version, and to restart the previous one.
+type preparedUpgrade struct {
+	depl         *domain.Deployment
+	tmpl         map[string]any
+	composeSpec  string
+	version      string
+	previous     runningSpec
+	orchestrator *docker.Orchestrator
+}
+
+// runningSpec is what a deployment's containers were last started from.
+// It is kept on the deployment so a failed upgrade can restart the
+// previous version after the template has moved on.
+type runningSpec struct {
+	Version     string              `json:"version"`
+	ComposeSpec string              `json:"compose_spec"`
+	ConfigFiles []domain.ConfigFile `json:"config_files,omitempty"`
+}
+
+// encodeRunningSpec returns the running_spec column value for containers
+// started from spec and configFiles at version.
+func encodeRunningSpec(version, spec string, configFiles []domain.ConfigFile) string {
+	b, _ := json.Marshal(runningSpec{Version: version, ComposeSpec: spec, ConfigFiles: configFiles})
+	return string(b)
+}
+
+// prepareUpgrade loads the template and sets up an orchestrator for the
+// deployment's node.
+func prepareUpgrade(ctx context.Context, deps *Deps, data map[string]any) (*preparedUpgrade, error) {
+	nodePool := getNodePool(deps)
+	if nodePool == nil {
+		return nil, fmt.Errorf("node pool not configured")
+	}
+	nodeID := strVal(data["node_id"])
+	configDir, _ := deps.Extra["config_dir"].(string)
+
+	tmpl, err := deps.Store.GetByID(ctx, "templates", toInt(data["template_id"]))
+	if err != nil {
+		return nil, fmt.Errorf("template not found: %v", err)
+	}
+	composeSpec := strVal(tmpl["compose_spec"])
+	if composeSpec == "" {
+		return nil, fmt.Errorf("template has no compose spec")
+	}
+
+	client, err := nodePool.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("docker client for node %s: %v", nodeID, err)
+	}
+
+	depl := mapToDeployment(data)
+	applyTemplateRouting(depl, tmpl, data)
+	if err := resolveDeploymentSecrets(ctx, deps, depl, composeSpec); err != nil {
+		return nil, err
+	}
+	orchestrator := docker.NewOrchestrator(client, deps.Logger, configDir, deps.Store)
+	if err := configureImageFetch(ctx, deps, orchestrator, nodeID, client); err != nil {
+		return nil, err
+	}
+	configureTraefikLabels(deps, orchestrator)
+	configureStart(deps, orchestrator)
+	if len(depl.Bridges) > 0 {
+		orchestrator.SetBridgeAddress(bridgeAddress(ctx, deps.Store, nodeID))
+	}
+	gpuDevices, err := assignGPUs(ctx, deps, data, composeSpec)
+	if err != nil {
+		return nil, err
+	}
+	orchestrator.SetGPUDevices(gpuDevices)
+	var previous runningSpec
+	if err := decodeJSONValue(data["running_spec"], &previous); err != nil {
+		deps.Logger.Warn("invalid running spec, upgrade cannot roll back", "deployment", depl.ReferenceID, "error", err)
+	}
+	return &preparedUpgrade{
+		depl:         depl,
+		tmpl:         tmpl,
+		composeSpec:  composeSpec,
+		version:      strVal(tmpl["version"]),
+		previous:     previous,
+		orchestrator: orchestrator,
+	}, nil
+}
+
+// failUpgrade marks the upgrade failed, leaving the deployment running.
+func failUpgrade(ctx context.Context, store *Store, refID, reason string) error {
+	store.Update(ctx, "deployments", refID, map[string]any{
+		"upgrade_status": string(coredeployment.UpgradeStatusFailed),
+		"error_message":  reason,
+	})
+	return fmt.Errorf("%s: %s", refID, reason)
+}
+
+// recreateDeployment replaces the deployment's containers, and those of its
+// replica nodes, with the new version's. If the new version fails to start,
+// the previous version is started again and the upgrade marked failed; only
+// when that fails too is the deployment marked failed.
+func recreateDeployment(ctx context.Context, deps *Deps, u *preparedUpgrade) error {
+	store := deps.Store
+	refID := u.depl.ReferenceID
+
+	if err := u.orchestrator.RemoveDeployment(ctx, u.depl); err != nil {
+		return failUpgrade(ctx, store, refID, fmt.Sprintf("upgrade failed: remove old containers: %v", err))
+	}
+
+	configFiles := templateConfigFiles(u.tmpl)
+	containers, err := u.orchestrator.StartDeployment(ctx, u.depl, u.composeSpec, configFiles)
+	if err != nil {
+		reason := fmt.Sprintf("upgrade to %s failed: %v", u.version, err)
+		if rollbackUpgrade(ctx, deps, u) {
+			return failUpgrade(ctx, store, refID, fmt.Sprintf("%s; restarted %s", reason, u.previous.Version))
+		}
+		store.Update(ctx, "deployments", refID, map[string]any{
+			"upgrade_status": string(coredeployment.UpgradeStatusFailed),
+		})
+		return failDeployment(ctx, store, refID, reason)
+	}
+	if len(u.depl.Replicas) > 0 {
+		stopReplicas(ctx, deps, u.depl, true)
+		startReplicas(ctx, deps, u.depl, u.composeSpec, templateConfigFiles(u.tmpl))
+	}
+
+	containersJSON, _ := json.Marshal(containers)
+	now := time.Now().UTC().Format(time.RFC3339)
+	store.Update(ctx, "deployments", refID, map[string]any{
+		"containers":           string(containersJSON),
+		"running_spec":         encodeRunningSpec(u.version, u.composeSpec, configFiles),
+		"health":               nil,
+		"template_version":     u.version,
+		"upgrade_status":       string(coredeployment.UpgradeStatusNone),
+		"pending_version":      nil,
+		"upgrade_scheduled_at": nil,
+		"last_upgraded_at":     now,
+		"error_message":        "",
+	})
+
+	deps.Logger.Info("deployment upgraded", "deployment", refID, "version", u.version, "containers", len(containers))
+	return nil
+}
+
+// rollbackUpgrade clears what the new version left behind and starts the
+// previous version's containers again. It reports false when there is no
+// previous spec to start from (deployments started before it was recorded)
+// or it fails to start.
+func rollbackUpgrade(ctx context.Context, deps *Deps, u *preparedUpgrade) bool {
+	refID := u.depl.ReferenceID
+	if u.previous.ComposeSpec == "" {
+		deps.Logger.Warn("no previous version to roll back to", "deployment", refID)
+		return false
+	}
+	if err := u.orchestrator.RemoveDeployment(ctx, u.depl); err != nil {
+		deps.Logger.Error("rollback failed: remove new containers", "deployment", refID, "error", err)
+		return false
+	}
+	containers, err := u.orchestrator.StartDeployment(ctx, u.depl, u.previous.ComposeSpec, u.previous.ConfigFiles)
+	if err != nil {
+		deps.Logger.Error("rollback failed", "deployment", refID, "version", u.previous.Version, "error", err)
+		return false
+	}
+	containersJSON, _ := json.Marshal(containers)
+	deps.Store.Update(ctx, "deployments", refID, map[string]any{
+		"containers": string(containersJSON),
+		"health":     nil,
+	})
+	deps.Logger.Warn("upgrade rolled back", "deployment", refID, "version", u.previous.Version)
+	return true
+}
+
+// =============================================================================
+// Upgrade Scheduler
+// =============================================================================
+
+// UpgradeScheduler periodically finds running deployments whose template has a
+// newer version and upgrades them according to each deployment's policy:
+// manual (the default) waits for the customer to approve, windowed waits
+// for a maintenance window, and auto upgrades immediately. Running canaries are checked
+// more often, so a regression is aborted quickly.
+type UpgradeScheduler struct {
+	store          *Store
+	bus            *Bus
+	interval       time.Duration
+	canaryInterval time.Duration
+	logger         *slog.Logger
+	ctx            context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+}
+
+func NewUpgradeScheduler(store *Store, bus *Bus, interval time.Duration, logger *slog.Logger) *UpgradeScheduler {
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	return &UpgradeScheduler{
+		store:          store,
+		bus:            bus,
+		interval:       interval,
+		canaryInterval: 30 * time.Second,
+		logger:         logger.With("component", "upgrade_scheduler"),
+	}
+}
+
+func (us *UpgradeScheduler) Start() {
+	us.ctx, us.cancel = context.WithCancel(context.Background())
+	us.wg.Add(1)
+	go us.run()
+	us.logger.Info("upgrade scheduler started", "interval", us.interval)
+}
+
+func (us *UpgradeScheduler) Stop() {
+	if us.cancel != nil {
+		us.cancel()
+	}
+	us.wg.Wait()
+}
+
+func (us *UpgradeScheduler) run() {
+	defer us.wg.Done()
+	us.checkAll()
+
+	ticker := time.NewTicker(us.interval)
+	defer ticker.Stop()
+	canaryTicker := time.NewTicker(us.canaryInterval)
+	defer canaryTicker.Stop()
+
+	for {
+		select {
+		case <-us.ctx.Done():
+			return
+		case <-ticker.C:
+			us.checkAll()
+		case <-canaryTicker.C:
+			us.checkCanaries()
+		}
+	}
+}
+
+// checkCanaries evaluates every running canary, promoting or aborting it.
+func (us *UpgradeScheduler) checkCanaries() {
+	depls, err := us.store.List(us.ctx, "deployments", []Filter{
+		{Field: "upgrade_status", Value: string(coredeployment.UpgradeStatusCanary)},
+	}, Page{Limit: 1000})
+	if err != nil {
+		us.logger.Error("failed to list canaries", "error", err)
+		return
+	}
+	for _, depl := range depls {
+		if err := us.bus.Dispatch(us.ctx, "CanaryCheck", depl); err != nil {
+			us.logger.Error("canary check failed", "deployment", strVal(depl["reference_id"]), "error", err)
+		}
+	}
+}
+
+func (us *UpgradeScheduler) checkAll() {
+	defer us.store.LoopMetrics().Time("upgrade_scheduler")()
+	depls, err := us.store.List(us.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		us.logger.Error("failed to list deployments", "error", err)
+		return
+	}
+
+	templates := map[int]map[string]any{}
+	locs := newUserLocations(us.store)
+	now := time.Now().UTC()
+	for _, depl := range depls {
+		tmplID := toInt(depl["template_id"])
+		tmpl, ok := templates[tmplID]
+		if !ok {
+			tmpl, _ = us.store.GetByID(us.ctx, "templates", tmplID)
+			templates[tmplID] = tmpl
+		}
+		us.checkDeployment(depl, tmpl, now, locs.get(us.ctx, toInt(depl["customer_id"])))
+	}
+}
+
+func (us *UpgradeScheduler) checkDeployment(depl, tmpl map[string]any, now time.Time, loc *time.Location) {
+	refID := strVal(depl["reference_id"])
+	latest := strVal(tmpl["version"])
+	status := coredeployment.UpgradeStatus(strVal(depl["upgrade_status"]))
+	pending := strVal(depl["pending_version"])
+
+	// A running canary is promoted or aborted by checkCanaries
+	if status == coredeployment.UpgradeStatusCanary {
+		return
+	}
+
+	if !coredeployment.NeedsUpgrade(strVal(depl["template_version"]), latest) {
+		if status != coredeployment.UpgradeStatusNone {
+			us.store.Update(us.ctx, "deployments", refID, map[string]any{
+				"upgrade_status":       string(coredeployment.UpgradeStatusNone),
+				"pending_version":      nil,
+				"upgrade_scheduled_at": nil,
+			})
+		}
+		return
+	}
+
+	// A failed upgrade is not retried until the customer approves it again
+	// or the template publishes another version.
+	if status == coredeployment.UpgradeStatusFailed && pending == latest {
+		return
+	}
+	if pending != latest && status != coredeployment.UpgradeStatusApproved {
+		status = coredeployment.UpgradeStatusNone
+	}
+
+	// A version needing variables the deployment lacks waits until the
+	// customer supplies them
+	issues, err := upgradeVariableIssues(depl, tmpl)
+	if err != nil {
+		us.logger.Warn("cannot check upgrade variables", "deployment", refID, "error", err)
+		return
+	}
+	if len(issues) > 0 {
+		if status != coredeployment.UpgradeStatusBlocked || pending != latest {
+			us.logger.Info("upgrade blocked on variables", "deployment", refID, "version", latest, "issues", len(issues))
+			us.store.Update(us.ctx, "deployments", refID, blockedUpgrade(latest, issues))
+		}
+		return
+	}
+	if status == coredeployment.UpgradeStatusBlocked {
+		us.store.Update(us.ctx, "deployments", refID, map[string]any{
+			"upgrade_status": string(coredeployment.UpgradeStatusNone),
+			"error_message":  "",
+		})
+		status = coredeployment.UpgradeStatusNone
+	}
+
+	policy, windows, err := deploymentUpgradePolicy(depl)
+	if err != nil {
+		us.logger.Warn("invalid upgrade policy", "deployment", refID, "error", err)
+		return
+	}
+
+	decision := coredeployment.DecideUpgrade(policy, windows, status, now, loc)
+	switch decision.Action {
+	case coredeployment.UpgradeActionNow:
+		us.store.Update(us.ctx, "deployments", refID, map[string]any{"pending_version": latest})
+		if err := us.bus.Dispatch(us.ctx, "UpgradeDeployment", depl); err != nil {
+			us.logger.Error("upgrade failed", "deployment", refID, "error", err)
+		}
+	case coredeployment.UpgradeActionWaitForWindow:
+		updates := map[string]any{
+			"upgrade_status":  string(decision.Status),
+			"pending_version": latest,
+		}
+		if !decision.ScheduledAt.IsZero() {
+			updates["upgrade_scheduled_at"] = decision.ScheduledAt.Format(time.RFC3339)
+		}
+		us.store.Update(us.ctx, "deployments", refID, updates)
+	case coredeployment.UpgradeActionAwaitApproval:
+		if status != decision.Status || pending != latest {
+			us.logger.Info("upgrade awaiting approval", "deployment", refID, "version", latest)
+			us.store.Update(us.ctx, "deployments", refID, map[string]any{
+				"upgrade_status":  string(decision.Status),
+				"pending_version": latest,
+			})
+		}
+	}
+}
+
+// =============================================================================
+// Upgrade Approval Handler
+// =============================================================================
+
+// upgradeApproveHandler serves POST /deployments/{id}/upgrade/approve.
+// It approves the pending upgrade (or retries a failed one); the upgrade
+// scheduler runs it on its next cycle regardless of policy. The body may
+// supply {"variables": {...}}, merged into the deployment's variables, to
+// unblock an upgrade whose version needs new ones. An upgrade that would
+// still be missing or have invalid variables is refused with the issues.
+func upgradeApproveHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+
+		ownerID, ok := toInt64(depl["customer_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+
+		switch coredeployment.UpgradeStatus(strVal(depl["upgrade_status"])) {
+		case coredeployment.UpgradeStatusPendingApproval, coredeployment.UpgradeStatusScheduled,
+			coredeployment.UpgradeStatusFailed, coredeployment.UpgradeStatusBlocked:
+		case coredeployment.UpgradeStatusApproved:
+			writeProblem(w, r, ProblemInvalidState, "upgrade already approved")
+			return
+		default:
+			writeProblem(w, r, ProblemInvalidState, "deployment has no pending upgrade")
+			return
+		}
+
+		var req struct {
+			Variables map[string]any `json:"variables"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+				return
+			}
+		}
+		updates := map[string]any{
+			"upgrade_status": string(coredeployment.UpgradeStatusApproved),
+		}
+		if len(req.Variables) > 0 {
+			var vars map[string]any
+			if err := decodeJSONValue(depl["variables"], &vars); err != nil || vars == nil {
+				vars = map[string]any{}
+			}
+			for k, v := range req.Variables {
+				vars[k] = v
+			}
+			if err := validateDeploymentSecretRefs(vars); err != nil {
+				writeProblem(w, r, ProblemValidationFailed, err.Error())
+				return
+			}
+			depl["variables"] = vars
+			updates["variables"] = vars
+		}
+
+		tmpl, err := cfg.Store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load template")
+			return
+		}
+		issues, err := upgradeVariableIssues(depl, tmpl)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+		if len(issues) > 0 {
+			writeProblemWith(w, r, ProblemValidationFailed,
+				fmt.Sprintf("version %s needs %d variable(s) supplied or fixed before upgrading", strVal(tmpl["version"]), len(issues)),
+				map[string]any{"issues": issues})
+			return
+		}
+		if coredeployment.UpgradeStatus(strVal(depl["upgrade_status"])) == coredeployment.UpgradeStatusBlocked {
+			updates["error_message"] = ""
+		}
+
+		row, err := cfg.Store.Update(ctx, "deployments", id, updates)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+
+		res := cfg.Store.Resource("deployments")
+		stripFields(res, row, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployments", row),
+		})
+	}
+}
+
+// upgradeCheckHandler serves GET /deployments/{id}/upgrade/check: whether
+// the deployment's variables suit the template's current version, and the
+// variables to supply or fix if not, with their definitions.
+func upgradeCheckHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := mux.Vars(r)["id"]
+
+		if !getAuthContext(r).Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermView) {
+			return
+		}
+
+		tmpl, err := cfg.Store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		issues, err := upgradeVariableIssues(depl, tmpl)
+		if err != nil {
+			writeProblem(w, r, ProblemInvalidState, err.Error())
+			return
+		}
+		if issues == nil {
+			issues = []coredeployment.VariableIssue{}
+		}
+		current, target := strVal(depl["template_version"]), strVal(tmpl["version"])
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type": "upgrade-checks",
+				"id":   strVal(depl["reference_id"]),
+				"attributes": map[string]any{
+					"current_version":   current,
+					"target_version":    target,
+					"upgrade_available": coredeployment.NeedsUpgrade(current, target),
+					"ready":             len(issues) == 0,
+					"issues":            issues,
+				},
+			},
+		})
+	}
+}
+
+// upgradeAbortHandler serves POST /deployments/{id}/upgrade/abort.
+// It aborts a running canary: the canary is removed and the upgrade is
+// marked failed, so it is retried only when approved again.
+func upgradeAbortHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeDeployment(w, r, cfg, depl, sharing.PermManage) {
+			return
+		}
+		if coredeployment.UpgradeStatus(strVal(depl["upgrade_status"])) != coredeployment.UpgradeStatusCanary {
+			writeProblem(w, r, ProblemInvalidState, "deployment has no canary running")
+			return
+		}
+		if cfg.Bus == nil {
+			writeProblem(w, r, ProblemInternal, "command bus not configured")
+			return
+		}
+
+		if err := cfg.Bus.Dispatch(ctx, "AbortCanary", depl); err != nil {
+			cfg.Logger.Warn("canary abort", "deployment", id, "error", err)
+		}
+
+		row, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, err.Error())
+			return
+		}
+		res := cfg.Store.Resource("deployments")
+		stripFields(res, row, cfg.Store, authCtx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "deployments", row),
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Upgrade Tests
+// =============================================================================
+
+const (
+	upgradeOldSpec = "services:\n  web:\n    image: nginx:1.25\n"
+	// The new version publishes a port another container holds, so it
+	// fails to start
+	upgradeNewSpec = "services:\n  web:\n    image: nginx:1.27\n    ports:\n      - \"8081:80\"\n"
+)
+
+type upgradeTest struct {
+	deps    *Deps
+	sandbox *docker.SandboxClient
+	refID   string
+	u       *preparedUpgrade
+}
+
+// newUpgradeTest starts a deployment at 1.0.0 on a sandbox node and prepares
+// its upgrade to 2.0.0, which cannot start. spec is the deployment's
+// running_spec, empty for deployments started before it was recorded.
+func newUpgradeTest(t *testing.T, spec string) *upgradeTest {
+	t.Helper()
+	store, err := OpenDB(filepath.Join(t.TempDir(), "hoster.db"), Schema(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	deps := &Deps{Store: store, Logger: logger}
+
+	ctx := context.Background()
+	res, err := store.db.Exec(`INSERT INTO users (reference_id, email) VALUES ('user_customer', 'customer@example.com')`)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+	tmpl, err := store.Create(ctx, "templates", map[string]any{
+		"name": "Upgrade Test", "creator_id": userID, "version": "2.0.0", "compose_spec": upgradeNewSpec,
+	})
+	require.NoError(t, err)
+	depl, err := store.Create(ctx, "deployments", map[string]any{
+		"name": "shop", "customer_id": userID, "template_id": tmpl["id"], "template_version": "1.0.0",
+		"node_id": "node_sandbox", "status": "running", "running_spec": spec,
+	})
+	require.NoError(t, err)
+
+	sandbox := docker.NewSandboxClient()
+	require.NoError(t, sandbox.PullImage("busybox", docker.PullOptions{}))
+	blocker, err := sandbox.CreateContainer(docker.ContainerSpec{
+		Name: "blocker", Image: "busybox",
+		Ports: []docker.PortBinding{{ContainerPort: 80, HostPort: 8081, Protocol: "tcp"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sandbox.StartContainer(blocker))
+
+	orchestrator := docker.NewOrchestrator(sandbox, logger, t.TempDir(), store)
+	d := mapToDeployment(depl)
+	_, err = orchestrator.StartDeployment(ctx, d, upgradeOldSpec, nil)
+	require.NoError(t, err)
+
+	var previous runningSpec
+	require.NoError(t, decodeJSONValue(depl["running_spec"], &previous))
+	return &upgradeTest{
+		deps:    deps,
+		sandbox: sandbox,
+		refID:   strVal(depl["reference_id"]),
+		u: &preparedUpgrade{
+			depl:         d,
+			tmpl:         tmpl,
+			composeSpec:  upgradeNewSpec,
+			version:      "2.0.0",
+			previous:     previous,
+			orchestrator: orchestrator,
+		},
+	}
+}
+
+// images returns the images of the sandbox's running containers, other
+// than the blocker's.
+func (ut *upgradeTest) images(t *testing.T) []string {
+	t.Helper()
+	containers, err := ut.sandbox.ListContainers(docker.ListOptions{All: true})
+	require.NoError(t, err)
+	var images []string
+	for _, c := range containers {
+		if c.Status == docker.ContainerStatusRunning && c.Image != "busybox" {
+			images = append(images, c.Image)
+		}
+	}
+	return images
+}
+
+func TestRecreateDeployment_FailedUpgradeRestartsRunningSpec(t *testing.T) {
+	ut := newUpgradeTest(t, encodeRunningSpec("1.0.0", upgradeOldSpec, nil))
+	ctx := context.Background()
+
+	err := recreateDeployment(ctx, ut.deps, ut.u)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "restarted 1.0.0")
+
+	row, err := ut.deps.Store.Get(ctx, "deployments", ut.refID)
+	require.NoError(t, err)
+	assert.Equal(t, "running", row["status"])
+	assert.Equal(t, "1.0.0", row["template_version"], "the deployment still runs the old version")
+	assert.Equal(t, string(coredeployment.UpgradeStatusFailed), row["upgrade_status"])
+	var spec runningSpec
+	require.NoError(t, decodeJSONValue(row["running_spec"], &spec))
+	assert.Equal(t, runningSpec{Version: "1.0.0", ComposeSpec: upgradeOldSpec}, spec)
+	assert.Equal(t, []string{"nginx:1.25"}, ut.images(t))
+}
+
+func TestRecreateDeployment_FailedUpgradeWithoutRunningSpec(t *testing.T) {
+	ut := newUpgradeTest(t, "")
+	ctx := context.Background()
+
+	require.Error(t, recreateDeployment(ctx, ut.deps, ut.u))
+
+	row, err := ut.deps.Store.Get(ctx, "deployments", ut.refID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", row["status"], "there is no previous version to restart")
+	assert.Equal(t, "1.0.0", row["template_version"])
+	assert.Equal(t, string(coredeployment.UpgradeStatusFailed), row["upgrade_status"])
+	assert.Empty(t, ut.images(t))
+}
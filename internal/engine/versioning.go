@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/apiversion"
+	"github.com/gorilla/mux"
+)
+
+// =============================================================================
+// API Versioning
+// =============================================================================
+
+// Every API route is served under each supported version prefix (/api/v1,
+// /api/v2). Handlers are shared; only the serializer picked from the request
+// path differs, so v1 keeps its original shapes while v2 evolves. Deprecated
+// versions announce their lifecycle in Deprecation, Sunset and Link headers
+// and answer 410 once sunset.
+
+// handleVersioned registers handler at path under every version prefix.
+// path is relative to the prefix, e.g. "/creator/earnings".
+func handleVersioned(router *mux.Router, path string, handler http.HandlerFunc, methods ...string) {
+	for _, v := range apiversion.Supported {
+		router.HandleFunc(v.Prefix()+path, handler).Methods(methods...)
+	}
+}
+
+// requestVersion returns the API version a request addresses. Paths outside
+// a version prefix get v1, the shape every internal caller expects.
+func requestVersion(r *http.Request) apiversion.Version {
+	if v, ok := apiversion.FromPath(r.URL.Path); ok {
+		return v
+	}
+	return apiversion.V1
+}
+
+// apiVersionMiddleware adds lifecycle headers to responses of deprecated
+// versions and rejects requests to sunset versions. Problem type URIs keep
+// resolving after a sunset, since existing error documents point at them.
+func apiVersionMiddleware(lifecycles map[apiversion.Version]apiversion.Lifecycle) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v, ok := apiversion.FromPath(r.URL.Path)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			l := lifecycles[v]
+			for k, val := range apiversion.Headers(l, r.URL.Path) {
+				w.Header().Set(k, val)
+			}
+			if l.Sunset(time.Now()) && !strings.HasPrefix(r.URL.Path, v.Prefix()+"/problems") {
+				writeProblem(w, r, ProblemVersionSunset,
+					fmt.Sprintf("API %s was retired on %s; use %s", v, l.SunsetAt.UTC().Format("2006-01-02"), apiversion.SuccessorPath(r.URL.Path)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiVersionsHandler handles GET /api/versions, listing every version with
+// its lifecycle so clients can plan migrations.
+func apiVersionsHandler(lifecycles map[apiversion.Version]apiversion.Lifecycle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		data := make([]map[string]any, 0, len(apiversion.Supported))
+		for _, v := range apiversion.Supported {
+			l := lifecycles[v]
+			attrs := map[string]any{
+				"prefix":        v.Prefix(),
+				"status":        l.Status(now),
+				"latest":        v == apiversion.Latest,
+				"deprecated_at": nil,
+				"sunset_at":     nil,
+			}
+			if !l.DeprecatedAt.IsZero() {
+				attrs["deprecated_at"] = l.DeprecatedAt.UTC().Format(time.RFC3339)
+			}
+			if !l.SunsetAt.IsZero() {
+				attrs["sunset_at"] = l.SunsetAt.UTC().Format(time.RFC3339)
+			}
+			data = append(data, map[string]any{"type": "api_versions", "id": string(v), "attributes": attrs})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	}
+}
+
+// =============================================================================
+// Versioned Serialization
+// =============================================================================
+
+// resourceInfo describes a schema resource to the serializers. Every field
+// with a RefTable is a reference; responses carry it as a reference_id.
+func resourceInfo(store *Store, name string) apiversion.ResourceInfo {
+	info := apiversion.ResourceInfo{Type: name, Refs: map[string]string{}}
+	if res := store.Resource(name); res != nil {
+		for _, f := range res.Fields {
+			if f.RefTable != "" && !f.WriteOnly {
+				info.Refs[f.Name] = f.RefTable
+			}
+		}
+	}
+	return info
+}
+
+// renderResource renders a stripped row as a JSON:API resource object in the
+// request's API version.
+func renderResource(r *http.Request, store *Store, resourceType string, row map[string]any) map[string]any {
+	return apiversion.For(requestVersion(r)).Resource(resourceInfo(store, resourceType), row)
+}
+
+// renderCollection renders stripped rows as a complete list document in the
+// request's API version. fetched is the row count before visibility filtering;
+// next is the page's next cursor, if any.
+func renderCollection(r *http.Request, store *Store, resourceType string, rows []map[string]any, page Page, fetched int, next string) map[string]any {
+	out := apiversion.Page{
+		Limit:      page.Limit,
+		Offset:     page.Offset,
+		Path:       r.URL.Path,
+		More:       page.Limit > 0 && fetched >= page.Limit,
+		NextCursor: next,
+	}
+	if page.Cursor != nil {
+		out.Cursor = page.Cursor.Encode()
+	}
+	return apiversion.For(requestVersion(r)).Collection(resourceInfo(store, resourceType), rows, out)
+}
+
+// parseJSONAPIBody parses a JSON:API request body and returns the attributes
+// map, reading the document the way the request's API version defines it.
+func parseJSONAPIBody(r *http.Request, store *Store, resourceType string) (map[string]any, error) {
+	var body struct {
+		Data apiversion.RequestData `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return apiversion.For(requestVersion(r)).Attributes(resourceInfo(store, resourceType), body.Data)
+}
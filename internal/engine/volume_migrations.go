@@ -0,0 +1,812 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/checkpoint"
+	"github.com/artpar/hoster/internal/core/compose"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/proxy"
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Volume Migration Storage
+// =============================================================================
+//
+// volume_migrations holds one row per request to move a stopped deployment's
+// volumes to another node. The VolumeMigrator works through pending rows and
+// resumes rows left transferring by a restart. Per-volume state (size, bytes
+// copied, checksums) is kept as JSON in volumes so a resumed run skips volumes
+// that were already verified. Experimental checkpoint-mode migrations keep the
+// same state for their container checkpoints in checkpoints. A migration with
+// a clone_deployment_id copies the volumes into a clone of the deployment
+// instead, and starts the clone once they are verified.
+
+// VolumeMigration is a request to move a deployment's volumes to another node.
+type VolumeMigration struct {
+	ID                int64          `db:"id"`
+	ReferenceID       string         `db:"reference_id"`
+	DeploymentID      string         `db:"deployment_id"`
+	SourceNodeID      string         `db:"source_node_id"`
+	TargetNodeID      string         `db:"target_node_id"`
+	RequestedBy       int64          `db:"requested_by"`
+	Status            string         `db:"status"`
+	SwitchNode        bool           `db:"switch_node"`
+	Mode              string         `db:"mode"`                // checkpoint.Mode
+	CloneDeploymentID string         `db:"clone_deployment_id"` // Deployment the volumes are copied into; empty for moves
+	VolumesJSON       string         `db:"volumes"`
+	CheckpointsJSON   string         `db:"checkpoints"`
+	TotalBytes        int64          `db:"total_bytes"`
+	TransferredBytes  int64          `db:"transferred_bytes"`
+	ErrorMessage      string         `db:"error_message"`
+	CreatedAt         string         `db:"created_at"`
+	UpdatedAt         string         `db:"updated_at"`
+	StartedAt         sql.NullString `db:"started_at"`
+	CompletedAt       sql.NullString `db:"completed_at"`
+
+	Volumes     []MigrationVolume     `db:"-"`
+	Checkpoints []MigrationCheckpoint `db:"-"`
+}
+
+// MigrationVolume is the state of one volume within a migration.
+type MigrationVolume struct {
+	Name           string `json:"name"`             // Volume name in the compose spec
+	Docker         string `json:"docker"`           // Docker volume name on the source node
+	Target         string `json:"target,omitempty"` // Docker volume name on the target node, if not Docker
+	TransferID     string `json:"transfer_id"`
+	Size           int64  `json:"size"` // Archive bytes, known after the snapshot
+	Transferred    int64  `json:"transferred"`
+	Files          int    `json:"files,omitempty"`
+	SHA256         string `json:"sha256,omitempty"`
+	ManifestDigest string `json:"manifest_digest,omitempty"`
+	Verified       bool   `json:"verified"`
+}
+
+// MigrationCheckpoint is the state of one container checkpoint within a
+// checkpoint-mode migration.
+type MigrationCheckpoint struct {
+	Service     string `json:"service"`
+	ContainerID string `json:"container_id"` // Checkpointed container on the source node
+	TransferID  string `json:"transfer_id"`
+	Size        int64  `json:"size"`
+	Transferred int64  `json:"transferred"`
+	SHA256      string `json:"sha256,omitempty"`
+	Verified    bool   `json:"verified"` // Unpacked on the target node
+}
+
+// TargetVolume returns the Docker volume name on the target node.
+func (v MigrationVolume) TargetVolume() string {
+	if v.Target != "" {
+		return v.Target
+	}
+	return v.Docker
+}
+
+const volumeMigrationColumns = `id, reference_id, deployment_id, source_node_id, target_node_id,
+	requested_by, status, switch_node, mode, clone_deployment_id, volumes, checkpoints, total_bytes,
+	transferred_bytes, error_message, created_at, updated_at, started_at, completed_at`
+
+// CreateVolumeMigration inserts a pending migration and fills in its IDs.
+func (s *Store) CreateVolumeMigration(ctx context.Context, m *VolumeMigration) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	m.ReferenceID = "vm_" + uuid.New().String()[:8]
+	m.Status = string(transfer.StatusPending)
+	m.CreatedAt, m.UpdatedAt = now, now
+	if m.VolumesJSON == "" {
+		m.VolumesJSON = "[]"
+	}
+	if m.CheckpointsJSON == "" {
+		m.CheckpointsJSON = "[]"
+	}
+	if m.Mode == "" {
+		m.Mode = string(checkpoint.ModeCold)
+	}
+
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO volume_migrations (reference_id, deployment_id, source_node_id, target_node_id,
+			requested_by, status, switch_node, mode, clone_deployment_id, volumes, checkpoints, created_at, updated_at)
+		VALUES (:reference_id, :deployment_id, :source_node_id, :target_node_id,
+			:requested_by, :status, :switch_node, :mode, :clone_deployment_id, :volumes, :checkpoints, :created_at, :updated_at)`, m)
+	if err != nil {
+		return fmt.Errorf("create volume migration: %w", err)
+	}
+	m.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// SaveVolumeMigration writes a migration's status, progress and volume state.
+func (s *Store) SaveVolumeMigration(ctx context.Context, m *VolumeMigration) error {
+	volumes, err := json.Marshal(m.Volumes)
+	if err != nil {
+		return fmt.Errorf("marshal migration volumes: %w", err)
+	}
+	if m.Volumes == nil {
+		volumes = []byte("[]")
+	}
+	m.VolumesJSON = string(volumes)
+	checkpoints, err := json.Marshal(m.Checkpoints)
+	if err != nil {
+		return fmt.Errorf("marshal migration checkpoints: %w", err)
+	}
+	if m.Checkpoints == nil {
+		checkpoints = []byte("[]")
+	}
+	m.CheckpointsJSON = string(checkpoints)
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	_, err = s.db.NamedExecContext(ctx,
+		`UPDATE volume_migrations SET status = :status, switch_node = :switch_node, volumes = :volumes, checkpoints = :checkpoints,
+			total_bytes = :total_bytes, transferred_bytes = :transferred_bytes, error_message = :error_message,
+			updated_at = :updated_at, started_at = :started_at, completed_at = :completed_at
+		WHERE id = :id`, m)
+	if err != nil {
+		return fmt.Errorf("save volume migration: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectVolumeMigrations(ctx context.Context, query string, args ...any) ([]*VolumeMigration, error) {
+	var out []*VolumeMigration
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+volumeMigrationColumns+` FROM volume_migrations `+query, args...); err != nil {
+		return nil, fmt.Errorf("query volume migrations: %w", err)
+	}
+	for _, m := range out {
+		if err := json.Unmarshal([]byte(m.VolumesJSON), &m.Volumes); err != nil {
+			return nil, fmt.Errorf("volume migration %s: decode volumes: %w", m.ReferenceID, err)
+		}
+		if err := json.Unmarshal([]byte(m.CheckpointsJSON), &m.Checkpoints); err != nil {
+			return nil, fmt.Errorf("volume migration %s: decode checkpoints: %w", m.ReferenceID, err)
+		}
+	}
+	return out, nil
+}
+
+// ListVolumeMigrations returns a deployment's migrations, newest first.
+func (s *Store) ListVolumeMigrations(ctx context.Context, deploymentID string, limit int) ([]*VolumeMigration, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.selectVolumeMigrations(ctx, `WHERE deployment_id = ? ORDER BY id DESC LIMIT ?`, deploymentID, limit)
+}
+
+// LatestVolumeMigration returns a deployment's most recent migration, or nil.
+func (s *Store) LatestVolumeMigration(ctx context.Context, deploymentID string) (*VolumeMigration, error) {
+	ms, err := s.ListVolumeMigrations(ctx, deploymentID, 1)
+	if err != nil || len(ms) == 0 {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// HasActiveVolumeMigration reports whether a migration still owns the
+// deployment, either moving its volumes or copying volumes into it as a clone.
+func (s *Store) HasActiveVolumeMigration(ctx context.Context, deploymentID string) (bool, error) {
+	m, err := s.LatestVolumeMigration(ctx, deploymentID)
+	if err != nil {
+		return false, err
+	}
+	if m != nil && transfer.Status(m.Status).Active() {
+		return true, nil
+	}
+	clones, err := s.selectVolumeMigrations(ctx, `WHERE clone_deployment_id = ? AND status IN (?, ?, ?) LIMIT 1`,
+		deploymentID, transfer.StatusPending, transfer.StatusTransferring, transfer.StatusVerifying)
+	if err != nil {
+		return false, err
+	}
+	return len(clones) > 0, nil
+}
+
+// ListRunnableVolumeMigrations returns pending migrations and ones interrupted
+// mid-run, oldest first.
+func (s *Store) ListRunnableVolumeMigrations(ctx context.Context) ([]*VolumeMigration, error) {
+	return s.selectVolumeMigrations(ctx, `WHERE status IN (?, ?, ?) ORDER BY id`,
+		transfer.StatusPending, transfer.StatusTransferring, transfer.StatusVerifying)
+}
+
+// volumeMigrationJSONAPI renders a migration as a JSON:API resource object.
+func volumeMigrationJSONAPI(m *VolumeMigration) map[string]any {
+	progress := transfer.Progress{TotalBytes: m.TotalBytes, TransferredBytes: m.TransferredBytes}
+	volumes := m.Volumes
+	if volumes == nil {
+		volumes = []MigrationVolume{}
+	}
+	checkpoints := m.Checkpoints
+	if checkpoints == nil {
+		checkpoints = []MigrationCheckpoint{}
+	}
+	return map[string]any{
+		"type": "volume-migrations",
+		"id":   m.ReferenceID,
+		"attributes": map[string]any{
+			"deployment_id":       m.DeploymentID,
+			"source_node_id":      m.SourceNodeID,
+			"target_node_id":      m.TargetNodeID,
+			"status":              m.Status,
+			"switch_node":         m.SwitchNode,
+			"mode":                m.Mode,
+			"clone_deployment_id": m.CloneDeploymentID,
+			"volumes":             volumes,
+			"checkpoints":         checkpoints,
+			"total_bytes":         m.TotalBytes,
+			"transferred_bytes":   m.TransferredBytes,
+			"progress_percent":    progress.Percent(),
+			"error_message":       m.ErrorMessage,
+			"created_at":          m.CreatedAt,
+			"updated_at":          m.UpdatedAt,
+			"started_at":          m.StartedAt.String,
+			"completed_at":        m.CompletedAt.String,
+		},
+	}
+}
+
+// =============================================================================
+// Volume Migration Handlers
+// =============================================================================
+
+// authorizeVolumeMigration allows the deployment owner and the creator of the
+// node the deployment runs on, who may need to drain it.
+func authorizeVolumeMigration(ctx context.Context, store *Store, depl map[string]any, userID int) bool {
+	if ownerID, ok := toInt64(depl["customer_id"]); ok && int(ownerID) == userID {
+		return true
+	}
+	node, err := store.Get(ctx, "nodes", strVal(depl["node_id"]))
+	if err != nil {
+		return false
+	}
+	creatorID, ok := toInt64(node["creator_id"])
+	return ok && int(creatorID) == userID
+}
+
+// volumeMigrationHandler handles POST and GET /deployments/{id}/volume-migrations.
+func volumeMigrationHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		authCtx := getAuthContext(r)
+		id := mux.Vars(r)["id"]
+
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+
+		depl, err := cfg.Store.Get(ctx, "deployments", id)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "deployment not found")
+			return
+		}
+		if !authorizeVolumeMigration(ctx, cfg.Store, depl, authCtx.UserID) {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		refID := strVal(depl["reference_id"])
+
+		if r.Method == http.MethodGet {
+			limit := 20
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+					limit = n
+				}
+			}
+			ms, err := cfg.Store.ListVolumeMigrations(ctx, refID, limit)
+			if err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to list volume migrations")
+				return
+			}
+			data := make([]map[string]any, 0, len(ms))
+			for _, m := range ms {
+				data = append(data, volumeMigrationJSONAPI(m))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+			return
+		}
+
+		var req struct {
+			TargetNodeID string `json:"target_node_id"`
+			SwitchNode   *bool  `json:"switch_node"`
+			Mode         string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetNodeID == "" {
+			writeProblem(w, r, ProblemValidationFailed, "target_node_id is required")
+			return
+		}
+		switchNode := req.SwitchNode == nil || *req.SwitchNode
+		mode, err := checkpoint.ParseMode(req.Mode)
+		if err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		if mode == checkpoint.ModeCheckpoint {
+			// Checkpoints carry the containers' memory, so the deployment keeps
+			// running until the migrator checkpoints it.
+			if !cfg.ExperimentalCheckpoints {
+				writeProblem(w, r, ProblemValidationFailed, "checkpoint migrations are not enabled on this server")
+				return
+			}
+			if !switchNode {
+				writeProblem(w, r, ProblemValidationFailed, "checkpoint migrations always switch the deployment to the target node")
+				return
+			}
+			if status := strVal(depl["status"]); status != "running" {
+				writeProblem(w, r, ProblemInvalidState, "deployment is "+status+"; checkpoint migrations need it running")
+				return
+			}
+		} else if requireQuiescent(depl) != nil {
+			// Volume data must be quiescent while it is archived.
+			writeProblem(w, r, ProblemInvalidState, "stop the deployment before migrating its volumes")
+			return
+		}
+
+		sourceNode := strVal(depl["node_id"])
+		if sourceNode == "" {
+			writeProblem(w, r, ProblemInvalidState, "deployment has no node to migrate from")
+			return
+		}
+		if req.TargetNodeID == sourceNode {
+			writeProblem(w, r, ProblemValidationFailed, "target node is the deployment's current node")
+			return
+		}
+		target, err := cfg.Store.Get(ctx, "nodes", req.TargetNodeID)
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "target node not found")
+			return
+		}
+		if status := strVal(target["status"]); status != "online" {
+			writeProblem(w, r, ProblemInvalidState, "target node is "+status+", not online")
+			return
+		}
+
+		latest, err := cfg.Store.LatestVolumeMigration(ctx, refID)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load volume migrations")
+			return
+		}
+		if latest != nil && transfer.Status(latest.Status).Active() {
+			writeProblem(w, r, ProblemOperationInProgress, "a volume migration is already in progress")
+			return
+		}
+		if !requireNoDeploymentMigration(w, r, cfg, depl, "migrate volumes of") {
+			return
+		}
+
+		// Resume a failed migration to the same node as long as the deployment
+		// has not run since, so its staged snapshots still match the volumes.
+		// Failed checkpoint migrations restart the deployment, so they never resume.
+		if latest != nil && latest.Status == string(transfer.StatusFailed) && mode == checkpoint.ModeCold &&
+			latest.Mode == string(checkpoint.ModeCold) && latest.CloneDeploymentID == "" &&
+			latest.TargetNodeID == req.TargetNodeID && latest.SourceNodeID == sourceNode &&
+			strVal(depl["started_at"]) <= latest.CreatedAt {
+			latest.Status = string(transfer.StatusPending)
+			latest.ErrorMessage = ""
+			latest.SwitchNode = switchNode
+			if err := cfg.Store.SaveVolumeMigration(ctx, latest); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to resume volume migration")
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]any{"data": volumeMigrationJSONAPI(latest)})
+			return
+		}
+
+		m := &VolumeMigration{
+			DeploymentID: refID,
+			SourceNodeID: sourceNode,
+			TargetNodeID: req.TargetNodeID,
+			RequestedBy:  int64(authCtx.UserID),
+			SwitchNode:   switchNode,
+			Mode:         string(mode),
+		}
+		if err := cfg.Store.CreateVolumeMigration(ctx, m); err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to create volume migration")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"data": volumeMigrationJSONAPI(m)})
+	}
+}
+
+// =============================================================================
+// Volume Migrator Worker
+// =============================================================================
+
+// VolumeMigrator runs pending volume migrations one at a time: it snapshots
+// each volume on the source node, relays the archive to the target node in
+// checksummed chunks, verifies the restored volume, and then moves the
+// deployment to the target node. Migrations interrupted by a shutdown are
+// resumed from the target's staged data on the next run.
+type VolumeMigrator struct {
+	store     *Store
+	nodePool  *docker.NodePool
+	chunkSize int64
+	interval  time.Duration
+	bus       *Bus // Restarts checkpoint-migrated deployments; nil disables checkpoint mode
+	cloneBus  *Bus // Starts clones once their volumes are copied; nil leaves them pending
+	logger    *slog.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewVolumeMigrator(store *Store, nodePool *docker.NodePool, chunkSize int64, interval time.Duration, logger *slog.Logger) *VolumeMigrator {
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	return &VolumeMigrator{
+		store:     store,
+		nodePool:  nodePool,
+		chunkSize: transfer.NormalizeChunkSize(chunkSize),
+		interval:  interval,
+		logger:    logger.With("component", "volume_migrator"),
+	}
+}
+
+func (vm *VolumeMigrator) Start() {
+	vm.ctx, vm.cancel = context.WithCancel(context.Background())
+	vm.wg.Add(1)
+	go vm.run()
+	vm.logger.Info("volume migrator started", "interval", vm.interval, "chunk_size", vm.chunkSize)
+}
+
+func (vm *VolumeMigrator) Stop() {
+	if vm.cancel != nil {
+		vm.cancel()
+	}
+	vm.wg.Wait()
+}
+
+func (vm *VolumeMigrator) run() {
+	defer vm.wg.Done()
+	vm.runPending()
+
+	ticker := time.NewTicker(vm.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-vm.ctx.Done():
+			return
+		case <-ticker.C:
+			vm.runPending()
+		}
+	}
+}
+
+func (vm *VolumeMigrator) runPending() {
+	defer vm.store.LoopMetrics().Time("volume_migrator")()
+	ms, err := vm.store.ListRunnableVolumeMigrations(vm.ctx)
+	if err != nil {
+		vm.logger.Error("failed to list volume migrations", "error", err)
+		return
+	}
+	for _, m := range ms {
+		if vm.ctx.Err() != nil {
+			return
+		}
+		vm.Migrate(vm.ctx, m)
+	}
+}
+
+// Migrate runs one migration to completion or failure. If ctx is cancelled
+// the migration is left as is so the next run resumes it.
+func (vm *VolumeMigrator) Migrate(ctx context.Context, m *VolumeMigration) {
+	logger := vm.logger.With("migration", m.ReferenceID, "deployment", m.DeploymentID,
+		"source_node", m.SourceNodeID, "target_node", m.TargetNodeID)
+
+	err := vm.migrate(ctx, m, logger)
+	if err == nil {
+		logger.Info("volume migration completed", "volumes", len(m.Volumes), "bytes", m.TotalBytes)
+		return
+	}
+	if ctx.Err() != nil {
+		logger.Info("volume migration interrupted, will resume", "transferred_bytes", m.TransferredBytes)
+		return
+	}
+
+	logger.Error("volume migration failed", "error", err)
+	m.Status = string(transfer.StatusFailed)
+	m.ErrorMessage = err.Error()
+	if err := vm.store.SaveVolumeMigration(context.WithoutCancel(ctx), m); err != nil {
+		logger.Error("failed to record volume migration failure", "error", err)
+	}
+	if m.CloneDeploymentID != "" {
+		vm.store.Update(context.WithoutCancel(ctx), "deployments", m.CloneDeploymentID, map[string]any{
+			"error_message": "volume copy failed: " + err.Error(),
+		})
+	}
+	if m.Mode == string(checkpoint.ModeCheckpoint) {
+		vm.recoverCheckpoints(context.WithoutCancel(ctx), m, logger)
+	}
+}
+
+func (vm *VolumeMigrator) migrate(ctx context.Context, m *VolumeMigration, logger *slog.Logger) error {
+	depl, err := vm.store.Get(ctx, "deployments", m.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("deployment not found: %w", err)
+	}
+	checkpointMode := m.Mode == string(checkpoint.ModeCheckpoint)
+	if checkpointMode {
+		if err := vm.checkpointDeployment(ctx, m, depl, logger); err != nil {
+			return err
+		}
+	} else if err := requireQuiescent(depl); err != nil {
+		return err
+	}
+
+	if len(m.Volumes) == 0 {
+		if m.Volumes, err = vm.deploymentVolumes(ctx, depl, m); err != nil {
+			return err
+		}
+	}
+	m.Status = string(transfer.StatusTransferring)
+	if !m.StartedAt.Valid {
+		m.StartedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	}
+	if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+		return err
+	}
+
+	if len(m.Volumes) > 0 {
+		if vm.nodePool == nil {
+			return errors.New("node pool not configured")
+		}
+		src, err := vm.nodePool.VolumeEndpoint(ctx, m.SourceNodeID)
+		if err != nil {
+			return fmt.Errorf("source node: %w", err)
+		}
+		dst, err := vm.nodePool.VolumeEndpoint(ctx, m.TargetNodeID)
+		if err != nil {
+			return fmt.Errorf("target node: %w", err)
+		}
+		if err := vm.transferVolumes(ctx, m, src, dst, logger); err != nil {
+			return err
+		}
+	}
+	if checkpointMode {
+		if err := vm.transferCheckpoints(ctx, m, logger); err != nil {
+			return err
+		}
+	}
+
+	if m.SwitchNode {
+		if err := vm.switchNode(ctx, m); err != nil {
+			return err
+		}
+		logger.Info("deployment moved to target node")
+	}
+
+	m.Status = string(transfer.StatusCompleted)
+	m.CompletedAt = sql.NullString{String: time.Now().UTC().Format(time.RFC3339), Valid: true}
+	if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+		return err
+	}
+	if checkpointMode {
+		vm.restoreDeployment(ctx, m, logger)
+	}
+	if m.CloneDeploymentID != "" {
+		vm.startClone(ctx, m, logger)
+	}
+	return nil
+}
+
+// deploymentVolumes lists the named, non-external volumes of the deployment's
+// template. A clone's copies are named after the clone.
+func (vm *VolumeMigrator) deploymentVolumes(ctx context.Context, depl map[string]any, m *VolumeMigration) ([]MigrationVolume, error) {
+	tmpl, err := vm.store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+	if err != nil {
+		return nil, fmt.Errorf("template not found: %w", err)
+	}
+	spec, err := compose.ParseComposeSpec(strVal(tmpl["compose_spec"]))
+	if err != nil {
+		return nil, fmt.Errorf("parse compose spec: %w", err)
+	}
+
+	refID := strVal(depl["reference_id"])
+	volumes := []MigrationVolume{}
+	for _, v := range spec.Volumes {
+		if v.External {
+			continue // not managed by hoster
+		}
+		name := coredeployment.VolumeName(refID, v.Name)
+		mv := MigrationVolume{
+			Name:       v.Name,
+			Docker:     name,
+			TransferID: transfer.TransferID(m.ReferenceID, name),
+		}
+		if m.CloneDeploymentID != "" {
+			mv.Target = coredeployment.VolumeName(m.CloneDeploymentID, v.Name)
+		}
+		volumes = append(volumes, mv)
+	}
+	return volumes, nil
+}
+
+// transferVolumes snapshots every unverified volume to learn the total size,
+// then copies and verifies them one by one.
+func (vm *VolumeMigrator) transferVolumes(ctx context.Context, m *VolumeMigration, src, dst docker.VolumeEndpoint, logger *slog.Logger) error {
+	for i := range m.Volumes {
+		v := &m.Volumes[i]
+		if v.Verified {
+			continue
+		}
+		snap, err := src.SnapshotVolume(ctx, v.TransferID, v.Docker)
+		if err != nil {
+			return fmt.Errorf("snapshot volume %s: %w", v.Name, err)
+		}
+		v.Size = snap.Size
+	}
+	updateTotals(m)
+	if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+		return err
+	}
+
+	for i := range m.Volumes {
+		v := &m.Volumes[i]
+		if v.Verified {
+			continue
+		}
+		logger.Info("migrating volume", "volume", v.Docker, "bytes", v.Size)
+
+		res, err := docker.TransferVolume(ctx, src, dst, docker.VolumeTransfer{
+			TransferID: v.TransferID,
+			Source:     v.Docker,
+			Target: docker.VolumeSpec{
+				Name: v.TargetVolume(),
+				Labels: map[string]string{
+					docker.LabelManaged:    "true",
+					docker.LabelDeployment: m.targetDeployment(),
+				},
+			},
+			ChunkSize: vm.chunkSize,
+		}, func(p docker.VolumeTransferProgress) {
+			v.Size, v.Transferred = p.TotalBytes, p.TransferredBytes
+			m.Status = string(transfer.StatusTransferring)
+			if p.Phase == "verify" {
+				m.Status = string(transfer.StatusVerifying)
+			}
+			updateTotals(m)
+			if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+				logger.Warn("failed to save migration progress", "error", err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("volume %s: %w", v.Name, err)
+		}
+		if res.Resumed > 0 {
+			logger.Info("volume transfer resumed", "volume", v.Docker, "resumed_bytes", res.Resumed)
+		}
+
+		v.Verified = true
+		v.Transferred = v.Size
+		v.Files = res.Restore.Files
+		v.SHA256 = res.Restore.SHA256
+		v.ManifestDigest = res.Restore.ManifestDigest
+		m.Status = string(transfer.StatusTransferring)
+		updateTotals(m)
+		if err := vm.store.SaveVolumeMigration(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetDeployment returns the deployment the target volumes belong to.
+func (m *VolumeMigration) targetDeployment() string {
+	if m.CloneDeploymentID != "" {
+		return m.CloneDeploymentID
+	}
+	return m.DeploymentID
+}
+
+// updateTotals recomputes migration progress from its volumes and checkpoints.
+func updateTotals(m *VolumeMigration) {
+	m.TotalBytes, m.TransferredBytes = 0, 0
+	for _, v := range m.Volumes {
+		m.TotalBytes += v.Size
+		m.TransferredBytes += v.Transferred
+	}
+	for _, c := range m.Checkpoints {
+		m.TotalBytes += c.Size
+		m.TransferredBytes += c.Transferred
+	}
+}
+
+// switchNode points the deployment at the target node, moving its proxy and
+// stream ports if they are already taken there. It only runs once every volume is verified.
+func (vm *VolumeMigrator) switchNode(ctx context.Context, m *VolumeMigration) error {
+	err := vm.store.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var row struct {
+			Status      string         `db:"status"`
+			NodeID      string         `db:"node_id"`
+			ProxyPort   sql.NullInt64  `db:"proxy_port"`
+			StreamPorts sql.NullString `db:"stream_ports"`
+		}
+		if err := tx.GetContext(ctx, &row,
+			`SELECT status, COALESCE(node_id, '') AS node_id, proxy_port, stream_ports FROM deployments WHERE reference_id = ?`,
+			m.DeploymentID); err != nil {
+			return fmt.Errorf("load deployment: %w", err)
+		}
+		if err := requireQuiescent(map[string]any{"status": row.Status}); err != nil {
+			return err
+		}
+		if row.NodeID != m.SourceNodeID {
+			return fmt.Errorf("deployment moved to node %s during migration", row.NodeID)
+		}
+
+		var used []int
+		if err := tx.SelectContext(ctx, &used,
+			`SELECT proxy_port FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND proxy_port IS NOT NULL
+			 UNION ALL SELECT canary_port FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND canary_port IS NOT NULL`,
+			m.TargetNodeID, m.TargetNodeID); err != nil {
+			return fmt.Errorf("load target proxy ports: %w", err)
+		}
+		port := row.ProxyPort
+		for _, p := range used {
+			if port.Valid && int64(p) == port.Int64 {
+				newPort, err := proxy.AllocatePort(used, proxy.DefaultPortRange())
+				if err != nil {
+					return fmt.Errorf("allocate proxy port on target: %w", err)
+				}
+				port = sql.NullInt64{Int64: int64(newPort), Valid: true}
+				break
+			}
+		}
+
+		streamPorts := row.StreamPorts
+		var streams []domain.StreamPort
+		decodeJSONValue(row.StreamPorts.String, &streams)
+		if len(streams) > 0 {
+			var targetStreams []string
+			if err := tx.SelectContext(ctx, &targetStreams,
+				`SELECT stream_ports FROM deployments WHERE node_id = ? AND status NOT IN ('deleted', 'stopped') AND stream_ports IS NOT NULL`,
+				m.TargetNodeID); err != nil {
+				return fmt.Errorf("load target stream ports: %w", err)
+			}
+			var usedStreams []int
+			for _, v := range targetStreams {
+				usedStreams = append(usedStreams, streamHostPorts(v)...)
+			}
+			changed, err := assignStreamPorts(streams, usedStreams)
+			if err != nil {
+				return fmt.Errorf("allocate stream ports on target: %w", err)
+			}
+			if changed {
+				streamPorts = sql.NullString{String: encodeStreams(streams), Valid: true}
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE deployments SET node_id = ?, proxy_port = ?, stream_ports = ?, updated_at = ? WHERE reference_id = ?`,
+			m.TargetNodeID, port, streamPorts, time.Now().UTC().Format(time.RFC3339), m.DeploymentID); err != nil {
+			return fmt.Errorf("switch deployment node: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	vm.store.notifyChange("deployments", m.DeploymentID)
+	return nil
+}
+
+// requireQuiescent rejects deployments whose containers may be writing to their volumes.
+func requireQuiescent(depl map[string]any) error {
+	switch status := strVal(depl["status"]); status {
+	case "stopped", "failed":
+		return nil
+	default:
+		return fmt.Errorf("deployment is %s; it must stay stopped while its volumes migrate", status)
+	}
+}
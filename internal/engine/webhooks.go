@@ -0,0 +1,838 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	corewebhook "github.com/artpar/hoster/internal/core/webhook"
+	"github.com/artpar/hoster/internal/shell/notify"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// =============================================================================
+// Webhook Storage
+// =============================================================================
+//
+// Every event raised for a user with webhooks is recorded in webhook_events
+// and kept for corewebhook.EventRetention, so it can be replayed. Each
+// webhook routing the event gets a row in webhook_deliveries, attempted by
+// the WebhookDispatcher until the endpoint answers 2xx or the retry schedule
+// runs out. Deliveries of a paused webhook stay pending and go out when it
+// is resumed. Replays add new deliveries of the same events, which carry
+// their original event IDs.
+//
+// A webhook with a template_id is a template webhook: it belongs to the
+// template's creator and receives only the template events of customers'
+// deployments of that template, whose data has customer secrets redacted.
+// Other webhooks receive only their user's own events.
+
+// WebhookEvent is an event recorded for delivery to a user's webhooks.
+type WebhookEvent struct {
+	ID          int64         `db:"id"`
+	ReferenceID string        `db:"reference_id"`
+	UserID      int64         `db:"user_id"`
+	TemplateID  sql.NullInt64 `db:"template_id"` // Set for template events
+	Type        string        `db:"type"`
+	Payload     string        `db:"payload"` // JSON of the corenotify.Event
+	CreatedAt   string        `db:"created_at"`
+}
+
+const webhookEventColumns = `id, reference_id, user_id, template_id, type, payload, created_at`
+
+// WebhookDelivery is one event's delivery to one webhook, with the outcome
+// of its latest attempt.
+type WebhookDelivery struct {
+	ID            int64          `db:"id"`
+	ReferenceID   string         `db:"reference_id"`
+	WebhookID     int64          `db:"webhook_id"`
+	WebhookRef    string         `db:"webhook_ref"`
+	EventID       int64          `db:"event_id"`
+	EventRef      string         `db:"event_ref"`
+	EventType     string         `db:"event_type"`
+	EventPayload  string         `db:"event_payload"`
+	EventTime     string         `db:"event_created_at"`
+	Status        string         `db:"status"`
+	Attempts      int            `db:"attempts"`
+	NextAttemptAt sql.NullString `db:"next_attempt_at"`
+	ResponseCode  sql.NullInt64  `db:"response_code"`
+	ResponseBody  string         `db:"response_body"`
+	ErrorMessage  string         `db:"error_message"`
+	DurationMS    int64          `db:"duration_ms"`
+	Replay        bool           `db:"replay"`
+	CreatedAt     string         `db:"created_at"`
+	UpdatedAt     string         `db:"updated_at"`
+	DeliveredAt   sql.NullString `db:"delivered_at"`
+}
+
+const webhookDeliveryColumns = `d.id, d.reference_id, d.webhook_id,
+	(SELECT reference_id FROM webhooks WHERE id = d.webhook_id) AS webhook_ref, d.event_id,
+	e.reference_id AS event_ref, e.type AS event_type, e.payload AS event_payload, e.created_at AS event_created_at,
+	d.status, d.attempts, d.next_attempt_at, d.response_code, d.response_body, d.error_message,
+	d.duration_ms, d.replay, d.created_at, d.updated_at, d.delivered_at`
+
+// RecordWebhookEvent stores an event for delivery and replay. templateID is
+// the template of a template event, and 0 for any other event.
+func (s *Store) RecordWebhookEvent(ctx context.Context, userID int, templateID int64, ev corenotify.Event) (*WebhookEvent, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook event: %w", err)
+	}
+	e := &WebhookEvent{
+		ReferenceID: "evt_" + uuid.New().String()[:8],
+		UserID:      int64(userID),
+		TemplateID:  sql.NullInt64{Int64: templateID, Valid: templateID != 0},
+		Type:        string(ev.Type),
+		Payload:     string(payload),
+		CreatedAt:   ev.OccurredAt.UTC().Format(time.RFC3339),
+	}
+	res, err := s.db.NamedExecContext(ctx,
+		`INSERT INTO webhook_events (reference_id, user_id, template_id, type, payload, created_at)
+		VALUES (:reference_id, :user_id, :template_id, :type, :payload, :created_at)`, e)
+	if err != nil {
+		return nil, fmt.Errorf("record webhook event: %w", err)
+	}
+	e.ID, _ = res.LastInsertId()
+	return e, nil
+}
+
+// CreateWebhookDelivery queues an event's delivery to a webhook, due now.
+func (s *Store) CreateWebhookDelivery(ctx context.Context, webhookID, eventID int64, replay bool) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (reference_id, webhook_id, event_id, status, next_attempt_at, replay, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		"whd_"+uuid.New().String()[:8], webhookID, eventID, corewebhook.DeliveryPending, now, replay, now, now)
+	if err != nil {
+		return fmt.Errorf("create webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// SaveWebhookDelivery writes the outcome of a delivery attempt.
+func (s *Store) SaveWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	d.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.NamedExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = :status, attempts = :attempts, next_attempt_at = :next_attempt_at,
+			response_code = :response_code, response_body = :response_body, error_message = :error_message,
+			duration_ms = :duration_ms, updated_at = :updated_at, delivered_at = :delivered_at
+		WHERE id = :id`, d)
+	if err != nil {
+		return fmt.Errorf("save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) selectWebhookDeliveries(ctx context.Context, query string, args ...any) ([]*WebhookDelivery, error) {
+	var out []*WebhookDelivery
+	if err := s.db.SelectContext(ctx, &out, `SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d JOIN webhook_events e ON e.id = d.event_id `+query, args...); err != nil {
+		return nil, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	return out, nil
+}
+
+// ListWebhookDeliveries returns a webhook's deliveries, newest first, and how
+// many there are in total. status filters when set; total ignores the page's
+// cursor.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID int64, status string, page Page) ([]*WebhookDelivery, int, error) {
+	return s.listWebhookDeliveries(ctx, `WHERE d.webhook_id = ?`, []any{webhookID}, status, page)
+}
+
+// ListTemplateWebhookDeliveries returns the deliveries of all of a
+// template's webhooks, like ListWebhookDeliveries.
+func (s *Store) ListTemplateWebhookDeliveries(ctx context.Context, templateID int64, status string, page Page) ([]*WebhookDelivery, int, error) {
+	return s.listWebhookDeliveries(ctx, `WHERE d.webhook_id IN (SELECT id FROM webhooks WHERE template_id = ?)`, []any{templateID}, status, page)
+}
+
+func (s *Store) listWebhookDeliveries(ctx context.Context, where string, args []any, status string, page Page) ([]*WebhookDelivery, int, error) {
+	if status != "" {
+		where += ` AND d.status = ?`
+		args = append(args, status)
+	}
+	var total int
+	if err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM webhook_deliveries d `+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count webhook deliveries: %w", err)
+	}
+	if cond, condArgs := page.after("datetime(d.created_at)", "d.id", true); cond != "" {
+		where += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	out, err := s.selectWebhookDeliveries(ctx, where+` ORDER BY datetime(d.created_at) DESC, d.id DESC LIMIT ? OFFSET ?`,
+		append(args, page.Limit, page.Offset)...)
+	return out, total, err
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due,
+// oldest first. Deliveries of paused webhooks are held.
+func (s *Store) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	return s.selectWebhookDeliveries(ctx,
+		`JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND w.paused = 0
+		ORDER BY d.next_attempt_at, d.id LIMIT ?`,
+		corewebhook.DeliveryPending, now.UTC().Format(time.RFC3339), limit)
+}
+
+// PendingWebhookEventIDs returns the events with a delivery to the webhook
+// still pending.
+func (s *Store) PendingWebhookEventIDs(ctx context.Context, webhookID int64) (map[int64]bool, error) {
+	var ids []int64
+	if err := s.db.SelectContext(ctx, &ids,
+		`SELECT event_id FROM webhook_deliveries WHERE webhook_id = ? AND status = ?`,
+		webhookID, corewebhook.DeliveryPending); err != nil {
+		return nil, fmt.Errorf("query pending webhook deliveries: %w", err)
+	}
+	out := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out, nil
+}
+
+// WebhookEventsInRange returns a user's events recorded in [from, to),
+// oldest first, at most limit of them. templateID selects the events of a
+// template, and 0 the user's own events.
+func (s *Store) WebhookEventsInRange(ctx context.Context, userID int, templateID int64, from, to time.Time, limit int) ([]*WebhookEvent, error) {
+	var out []*WebhookEvent
+	err := s.db.SelectContext(ctx, &out,
+		`SELECT `+webhookEventColumns+` FROM webhook_events
+		WHERE user_id = ? AND IFNULL(template_id, 0) = ? AND created_at >= ? AND created_at < ? ORDER BY created_at, id LIMIT ?`,
+		userID, templateID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook events: %w", err)
+	}
+	return out, nil
+}
+
+// WebhookEventsByRef returns a user's events with the given reference IDs,
+// of the template templateID or, with 0, the user's own. Other IDs are left
+// out.
+func (s *Store) WebhookEventsByRef(ctx context.Context, userID int, templateID int64, refs []string) ([]*WebhookEvent, error) {
+	query, args, err := sqlx.In(
+		`SELECT `+webhookEventColumns+` FROM webhook_events
+		WHERE user_id = ? AND IFNULL(template_id, 0) = ? AND reference_id IN (?) ORDER BY created_at, id`, userID, templateID, refs)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook events: %w", err)
+	}
+	var out []*WebhookEvent
+	if err := s.db.SelectContext(ctx, &out, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("query webhook events: %w", err)
+	}
+	return out, nil
+}
+
+// PurgeWebhookEvents deletes events recorded before cutoff, with their
+// deliveries.
+func (s *Store) PurgeWebhookEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	before := cutoff.UTC().Format(time.RFC3339)
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE event_id IN (SELECT id FROM webhook_events WHERE created_at < ?)`, before); err != nil {
+		return 0, fmt.Errorf("purge webhook deliveries: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_events WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, fmt.Errorf("purge webhook events: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// webhookDeliveryJSONAPI renders a delivery as a JSON:API resource object.
+func webhookDeliveryJSONAPI(d *WebhookDelivery) map[string]any {
+	var code any
+	if d.ResponseCode.Valid {
+		code = d.ResponseCode.Int64
+	}
+	return map[string]any{
+		"type": "webhook-deliveries",
+		"id":   d.ReferenceID,
+		"attributes": map[string]any{
+			"webhook_id":      d.WebhookRef,
+			"event_id":        d.EventRef,
+			"event_type":      d.EventType,
+			"status":          d.Status,
+			"attempts":        d.Attempts,
+			"next_attempt_at": d.NextAttemptAt.String,
+			"response_code":   code,
+			"response_body":   d.ResponseBody,
+			"error_message":   d.ErrorMessage,
+			"duration_ms":     d.DurationMS,
+			"replay":          d.Replay,
+			"created_at":      d.CreatedAt,
+			"delivered_at":    d.DeliveredAt.String,
+		},
+	}
+}
+
+// webhookRoutes reports whether a webhook row receives events of type t:
+// a template webhook only the events of its template (templateID), and
+// any other webhook only events without a template (templateID 0).
+func webhookRoutes(row map[string]any, t string, templateID int64) bool {
+	if webhookTemplate(row) != templateID {
+		return false
+	}
+	var events []string
+	_ = decodeJSONValue(row["events"], &events)
+	return corenotify.Routes(events, corenotify.EventType(t))
+}
+
+// webhookTemplate returns the template of a template webhook, or 0.
+func webhookTemplate(row map[string]any) int64 {
+	id, _ := toInt64(row["template_id"])
+	return id
+}
+
+// =============================================================================
+// Event Recording
+// =============================================================================
+
+// enqueueWebhooks records ev and queues its delivery to the user's webhooks
+// that route it. templateID is the template of a template event, and 0
+// otherwise. Events are only recorded for users with webhooks that could
+// receive them, so there is nothing to replay from before the first one was
+// created.
+func (n *Notifier) enqueueWebhooks(ctx context.Context, userID int, templateID int64, ev corenotify.Event) {
+	if ev.Type == corenotify.EventTest {
+		return
+	}
+	rows, err := n.store.List(ctx, "webhooks", []Filter{{Field: "user_id", Value: userID}}, Page{Limit: 100})
+	if err != nil {
+		n.logger.Error("failed to list webhooks", "user", userID, "error", err)
+		return
+	}
+	var hooks []map[string]any
+	for _, row := range rows {
+		if webhookTemplate(row) == templateID {
+			hooks = append(hooks, row)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	e, err := n.store.RecordWebhookEvent(ctx, userID, templateID, ev)
+	if err != nil {
+		n.logger.Error("failed to record webhook event", "user", userID, "event", ev.Type, "error", err)
+		return
+	}
+	for _, row := range hooks {
+		if !webhookRoutes(row, e.Type, templateID) {
+			continue
+		}
+		id, _ := toInt64(row["id"])
+		if err := n.store.CreateWebhookDelivery(ctx, id, e.ID, false); err != nil {
+			n.logger.Error("failed to queue webhook delivery", "webhook", strVal(row["reference_id"]), "error", err)
+		}
+	}
+}
+
+// templateEvents maps deployment states to the template events entering
+// them raises.
+var templateEvents = map[string]corenotify.EventType{
+	"running": corenotify.EventTemplateDeploymentStarted,
+	"deleted": corenotify.EventTemplateDeploymentDeleted,
+}
+
+// NotifyTemplate queues a template event about a deployment for its
+// template's webhooks, in the background. The event carries the deployment
+// with its customer's secrets redacted. It never reaches notification
+// channels.
+func (n *Notifier) NotifyTemplate(depl map[string]any, t corenotify.EventType) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx := context.Background()
+		tmpl, err := n.store.GetByID(ctx, "templates", toInt(depl["template_id"]))
+		if err != nil {
+			n.logger.Debug("template event for unknown template", "deployment", strVal(depl["reference_id"]), "error", err)
+			return
+		}
+		creatorID, ok := toInt64(tmpl["creator_id"])
+		if !ok {
+			return
+		}
+		templateID, _ := toInt64(tmpl["id"])
+		data := n.templateDeployment(depl, tmpl)
+		verb := strings.TrimPrefix(string(t), "template.deployment.")
+		n.enqueueWebhooks(ctx, int(creatorID), templateID, corenotify.Event{
+			Type:         t,
+			Severity:     corenotify.SeverityInfo,
+			Title:        fmt.Sprintf("Deployment %s of %s %s", data.Name, strVal(tmpl["name"]), verb),
+			ResourceType: "deployments",
+			ResourceID:   data.DeploymentID,
+			OccurredAt:   time.Now().UTC(),
+			Data:         data,
+		})
+	}()
+}
+
+// templateDeployment is the data of a template event about depl.
+func (n *Notifier) templateDeployment(depl, tmpl map[string]any) corewebhook.TemplateDeployment {
+	data := corewebhook.TemplateDeployment{
+		DeploymentID:    strVal(depl["reference_id"]),
+		Name:            strVal(depl["name"]),
+		Status:          strVal(depl["status"]),
+		TemplateID:      strVal(tmpl["reference_id"]),
+		TemplateVersion: strVal(depl["template_version"]),
+	}
+	if customerID, ok := toInt64(depl["customer_id"]); ok {
+		data.CustomerID, _ = n.store.GetRefIDByIntID("users", int(customerID))
+	}
+	if nodeID, ok := toInt64(depl["node_id"]); ok {
+		data.NodeID, _ = n.store.GetRefIDByIntID("nodes", int(nodeID))
+	}
+	var declared []domain.Variable
+	_ = decodeJSONValue(tmpl["variables"], &declared)
+	if values, err := variableValues(depl["variables"]); err == nil {
+		data.Variables = corewebhook.RedactVariables(declared, values)
+	}
+	return data
+}
+
+// =============================================================================
+// Validation
+// =============================================================================
+
+// validateWebhook checks a new webhook's endpoint, secret and events. A
+// template webhook can only be created by the template's creator.
+func validateWebhook(ctx context.Context, store *Store, authCtx AuthContext, data map[string]any) error {
+	if err := corewebhook.ValidateURL(strVal(data["url"])); err != nil {
+		return err
+	}
+	if err := corewebhook.ValidateSecret(strVal(data["secret"])); err != nil {
+		return err
+	}
+	templateID, ok := toInt64(data["template_id"])
+	if !ok {
+		return validateNotificationEvents(data["events"])
+	}
+	tmpl, err := store.GetByID(ctx, "templates", int(templateID))
+	if err != nil {
+		return fmt.Errorf("template_id: template not found")
+	}
+	if creatorID, _ := toInt64(tmpl["creator_id"]); int(creatorID) != authCtx.UserID {
+		return fmt.Errorf("template_id: only the template's creator can add webhooks to it")
+	}
+	return validateWebhookEvents(data["events"], templateID)
+}
+
+// validateWebhookEvents checks a webhook's event routing: template events
+// for a template webhook, the user's own events for any other.
+func validateWebhookEvents(v any, templateID int64) error {
+	if templateID == 0 {
+		return validateNotificationEvents(v)
+	}
+	if v == nil {
+		return nil
+	}
+	var events []string
+	if err := decodeJSONValue(v, &events); err != nil {
+		return fmt.Errorf("events must be a list of event types")
+	}
+	return corenotify.ValidateTemplateEvents(events)
+}
+
+// =============================================================================
+// Handlers
+// =============================================================================
+
+// ownedWebhook loads the webhook named in the path and checks the caller
+// owns it, writing the problem and returning nil otherwise.
+func ownedWebhook(cfg SetupConfig, w http.ResponseWriter, r *http.Request) map[string]any {
+	authCtx := getAuthContext(r)
+	if !authCtx.Authenticated {
+		writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+		return nil
+	}
+	row, err := cfg.Store.Get(r.Context(), "webhooks", mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, ProblemNotFound, "webhook not found")
+		return nil
+	}
+	ownerID, ok := toInt64(row["user_id"])
+	if !ok || int(ownerID) != authCtx.UserID {
+		writeProblem(w, r, ProblemForbidden, "not authorized")
+		return nil
+	}
+	return row
+}
+
+// webhookDeliveriesHandler handles GET /webhooks/{id}/deliveries: the
+// webhook's deliveries, newest first, with response codes and (truncated)
+// bodies. ?status= filters by pending, succeeded or failed.
+func webhookDeliveriesHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		row := ownedWebhook(cfg, w, r)
+		if row == nil {
+			return
+		}
+		id, _ := toInt64(row["id"])
+		writeWebhookDeliveries(w, r, func(status string, page Page) ([]*WebhookDelivery, int, error) {
+			return cfg.Store.ListWebhookDeliveries(r.Context(), id, status, page)
+		})
+	}
+}
+
+// templateWebhookDeliveriesHandler handles GET
+// /templates/{id}/webhook-deliveries: the deliveries of all of the
+// template's webhooks, for its creator, like webhookDeliveriesHandler.
+func templateWebhookDeliveriesHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := getAuthContext(r)
+		if !authCtx.Authenticated {
+			writeProblem(w, r, ProblemAuthenticationRequired, "authentication required")
+			return
+		}
+		tmpl, err := cfg.Store.Get(r.Context(), "templates", mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, r, ProblemNotFound, "template not found")
+			return
+		}
+		ownerID, ok := toInt64(tmpl["creator_id"])
+		if !ok || int(ownerID) != authCtx.UserID {
+			writeProblem(w, r, ProblemForbidden, "not authorized")
+			return
+		}
+		id, _ := toInt64(tmpl["id"])
+		writeWebhookDeliveries(w, r, func(status string, page Page) ([]*WebhookDelivery, int, error) {
+			return cfg.Store.ListTemplateWebhookDeliveries(r.Context(), id, status, page)
+		})
+	}
+}
+
+// writeWebhookDeliveries writes a page of deliveries from list, filtered by
+// ?status=, with the total in meta.
+func writeWebhookDeliveries(w http.ResponseWriter, r *http.Request, list func(status string, page Page) ([]*WebhookDelivery, int, error)) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !corewebhook.DeliveryStatus(status).Valid() {
+		writeProblem(w, r, ProblemValidationFailed, "status must be pending, succeeded or failed")
+		return
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		writeProblem(w, r, ProblemInvalidRequest, err.Error())
+		return
+	}
+	deliveries, total, err := list(status, page)
+	if err != nil {
+		writeProblem(w, r, ProblemInternal, "failed to list webhook deliveries")
+		return
+	}
+	data := make([]map[string]any, 0, len(deliveries))
+	for _, d := range deliveries {
+		data = append(data, webhookDeliveryJSONAPI(d))
+	}
+	var next string
+	if n := len(deliveries); n > 0 {
+		next = cursorAfter(page, n, deliveries[n-1].CreatedAt, deliveries[n-1].ID)
+	}
+	meta := pageMeta(page, next)
+	meta["total"] = total
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": data,
+		"meta": meta,
+	})
+}
+
+// webhookReplayHandler handles POST /webhooks/{id}/replay, queueing the
+// delivery of past events again: those recorded in a time range
+// ({"from", "to"}) or listed by ID ({"event_ids"}). Events the webhook does
+// not route, and ones with a delivery still pending, are skipped. Replays of
+// a paused webhook go out when it is resumed.
+func webhookReplayHandler(cfg SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		row := ownedWebhook(cfg, w, r)
+		if row == nil {
+			return
+		}
+
+		var req corewebhook.ReplayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, ProblemInvalidRequest, "invalid JSON body")
+			return
+		}
+		now := time.Now().UTC()
+		if err := req.Validate(now); err != nil {
+			writeProblem(w, r, ProblemValidationFailed, err.Error())
+			return
+		}
+
+		userID := getAuthContext(r).UserID
+		templateID := webhookTemplate(row)
+		var events []*WebhookEvent
+		var err error
+		if len(req.EventIDs) > 0 {
+			events, err = cfg.Store.WebhookEventsByRef(ctx, userID, templateID, req.EventIDs)
+			if err == nil && len(events) < len(req.EventIDs) {
+				found := make(map[string]bool, len(events))
+				for _, e := range events {
+					found[e.ReferenceID] = true
+				}
+				var missing []string
+				for _, ref := range req.EventIDs {
+					if !found[ref] {
+						missing = append(missing, ref)
+					}
+				}
+				writeProblem(w, r, ProblemValidationFailed, "unknown or expired events: "+strings.Join(missing, ", "))
+				return
+			}
+		} else {
+			from, to := req.Range(now)
+			events, err = cfg.Store.WebhookEventsInRange(ctx, userID, templateID, from, to, corewebhook.MaxReplayEvents+1)
+			if err == nil && len(events) > corewebhook.MaxReplayEvents {
+				writeProblem(w, r, ProblemValidationFailed,
+					fmt.Sprintf("the range holds more than %d events; replay a shorter range", corewebhook.MaxReplayEvents))
+				return
+			}
+		}
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load webhook events")
+			return
+		}
+
+		id, _ := toInt64(row["id"])
+		pending, err := cfg.Store.PendingWebhookEventIDs(ctx, id)
+		if err != nil {
+			writeProblem(w, r, ProblemInternal, "failed to load webhook deliveries")
+			return
+		}
+		queued, notRouted, alreadyPending := 0, 0, 0
+		for _, e := range events {
+			switch {
+			case !webhookRoutes(row, e.Type, templateID):
+				notRouted++
+			case pending[e.ID]:
+				alreadyPending++
+			default:
+				if err := cfg.Store.CreateWebhookDelivery(ctx, id, e.ID, true); err != nil {
+					writeProblem(w, r, ProblemInternal, "failed to queue webhook deliveries")
+					return
+				}
+				queued++
+			}
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"meta": map[string]any{
+				"matched":         len(events),
+				"queued":          queued,
+				"not_routed":      notRouted,
+				"already_pending": alreadyPending,
+				"paused":          row["paused"] == true,
+			},
+		})
+	}
+}
+
+// webhookPauseHandler handles POST /webhooks/{id}/pause and /resume. A paused
+// webhook keeps queueing deliveries but sends none until resumed.
+func webhookPauseHandler(cfg SetupConfig, paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		row := ownedWebhook(cfg, w, r)
+		if row == nil {
+			return
+		}
+		refID := strVal(row["reference_id"])
+
+		updates := map[string]any{"paused": paused, "paused_at": nil}
+		if paused {
+			updates["paused_at"] = time.Now().UTC().Format(time.RFC3339)
+		}
+		if row["paused"] == paused {
+			updates = nil
+		}
+		if updates != nil {
+			var err error
+			if row, err = cfg.Store.Update(ctx, "webhooks", refID, updates); err != nil {
+				writeProblem(w, r, ProblemInternal, "failed to update webhook")
+				return
+			}
+		}
+		stripFields(cfg.Store.Resource("webhooks"), row, cfg.Store, getAuthContext(r))
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": renderResource(r, cfg.Store, "webhooks", row),
+		})
+	}
+}
+
+// =============================================================================
+// Webhook Dispatcher Worker
+// =============================================================================
+
+// WebhookDispatcher sends due webhook deliveries, retrying failures on the
+// corewebhook.RetrySchedule, and purges events past their retention.
+type WebhookDispatcher struct {
+	store    *Store
+	sender   *notify.WebhookSender
+	interval time.Duration
+	timeout  time.Duration
+	batch    int
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewWebhookDispatcher(store *Store, sender *notify.WebhookSender, interval time.Duration, logger *slog.Logger) *WebhookDispatcher {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	if sender == nil {
+		sender = notify.NewWebhookSender(nil)
+	}
+	return &WebhookDispatcher{
+		store:    store,
+		sender:   sender,
+		interval: interval,
+		timeout:  10 * time.Second,
+		batch:    50,
+		logger:   logger.With("component", "webhooks"),
+	}
+}
+
+func (d *WebhookDispatcher) Start() {
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.wg.Add(1)
+	go d.run()
+	d.logger.Info("webhook dispatcher started", "interval", d.interval)
+}
+
+func (d *WebhookDispatcher) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			d.dispatch(d.ctx, now)
+			if now.Sub(lastPurge) >= time.Hour {
+				d.purge(d.ctx, now)
+				lastPurge = now
+			}
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context, now time.Time) {
+	defer d.store.LoopMetrics().Time("webhooks")()
+	due, err := d.store.DueWebhookDeliveries(ctx, now, d.batch)
+	if err != nil {
+		d.logger.Error("failed to list due webhook deliveries", "error", err)
+		return
+	}
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, delivery)
+	}
+}
+
+func (d *WebhookDispatcher) purge(ctx context.Context, now time.Time) {
+	n, err := d.store.PurgeWebhookEvents(ctx, now.Add(-corewebhook.EventRetention))
+	if err != nil {
+		d.logger.Error("failed to purge webhook events", "error", err)
+		return
+	}
+	if n > 0 {
+		d.logger.Info("purged expired webhook events", "count", n)
+	}
+}
+
+// deliver makes one attempt at a delivery and records its outcome on the
+// delivery and the webhook.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *WebhookDelivery) {
+	hook, err := d.store.GetByID(ctx, "webhooks", int(delivery.WebhookID))
+	if err != nil {
+		d.logger.Error("failed to load webhook", "delivery", delivery.ReferenceID, "error", err)
+		return
+	}
+	refID := strVal(hook["reference_id"])
+
+	attemptErr := d.attempt(ctx, hook, delivery)
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.NextAttemptAt = sql.NullString{}
+	if attemptErr == nil {
+		delivery.Status = string(corewebhook.DeliverySucceeded)
+		delivery.ErrorMessage = ""
+		delivery.DeliveredAt = sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+	} else {
+		delivery.ErrorMessage = attemptErr.Error()
+		if next, ok := corewebhook.NextAttempt(delivery.Attempts, now); ok {
+			delivery.NextAttemptAt = sql.NullString{String: next.Format(time.RFC3339), Valid: true}
+		} else {
+			delivery.Status = string(corewebhook.DeliveryFailed)
+		}
+	}
+	if err := d.store.SaveWebhookDelivery(ctx, delivery); err != nil {
+		d.logger.Error("failed to record webhook delivery", "delivery", delivery.ReferenceID, "error", err)
+	}
+
+	updates := map[string]any{"last_delivery_at": now.Format(time.RFC3339), "last_error": nil}
+	if attemptErr != nil {
+		updates["last_error"] = attemptErr.Error()
+		d.logger.Warn("webhook delivery failed", "webhook", refID, "delivery", delivery.ReferenceID,
+			"attempt", delivery.Attempts, "error", attemptErr)
+	}
+	if _, err := d.store.Update(ctx, "webhooks", refID, updates); err != nil {
+		d.logger.Error("failed to record webhook outcome", "webhook", refID, "error", err)
+	}
+}
+
+// attempt posts the delivery's event and fills in the response. It returns
+// why the attempt failed, if it did.
+func (d *WebhookDispatcher) attempt(ctx context.Context, hook map[string]any, delivery *WebhookDelivery) error {
+	delivery.ResponseCode = sql.NullInt64{}
+	delivery.ResponseBody = ""
+	delivery.DurationMS = 0
+
+	secret, err := d.store.decryptField(hook["secret"])
+	if err != nil {
+		return err
+	}
+	createdAt, _ := parseTime(delivery.EventTime)
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	resp, err := d.sender.Post(ctx, strVal(hook["url"]), secret, corewebhook.Payload{
+		ID:        delivery.EventRef,
+		Type:      delivery.EventType,
+		CreatedAt: createdAt,
+		Data:      json.RawMessage(delivery.EventPayload),
+	})
+	if err != nil {
+		return err
+	}
+	delivery.ResponseCode = sql.NullInt64{Int64: int64(resp.StatusCode), Valid: true}
+	delivery.ResponseBody = corewebhook.TruncateBody(resp.Body)
+	delivery.DurationMS = resp.Duration.Milliseconds()
+	if !corewebhook.Succeeded(resp.StatusCode) {
+		return fmt.Errorf("endpoint answered %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
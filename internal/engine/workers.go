@@ -3,13 +3,15 @@ package engine
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/artpar/hoster/internal/core/costs"
 	"github.com/artpar/hoster/internal/core/crypto"
+	"github.com/artpar/hoster/internal/core/invoice"
 	coreprovider "github.com/artpar/hoster/internal/core/provider"
 	"github.com/artpar/hoster/internal/shell/docker"
 	"github.com/artpar/hoster/internal/shell/provider"
@@ -26,6 +28,7 @@ type HealthChecker struct {
 	encryptionKey []byte
 	interval      time.Duration
 	logger        *slog.Logger
+	notifier      *Notifier
 	ctx           context.Context
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
@@ -45,6 +48,12 @@ func NewHealthChecker(store *Store, nodePool *docker.NodePool, encryptionKey []b
 	}
 }
 
+// SetNotifier makes the checker notify node creators when their node goes
+// offline or its wildcard DNS breaks.
+func (h *HealthChecker) SetNotifier(n *Notifier) {
+	h.notifier = n
+}
+
 func (h *HealthChecker) Start() {
 	h.ctx, h.cancel = context.WithCancel(context.Background())
 	h.wg.Add(1)
@@ -77,6 +86,7 @@ func (h *HealthChecker) run() {
 }
 
 func (h *HealthChecker) checkAll() {
+	defer h.store.LoopMetrics().Time("health_checker")()
 	nodes, err := h.store.List(h.ctx, "nodes", []Filter{}, Page{Limit: 1000})
 	if err != nil {
 		h.logger.Error("failed to list nodes", "error", err)
@@ -95,23 +105,26 @@ func (h *HealthChecker) checkAll() {
 		}
 
 		err := h.nodePool.PingNode(h.ctx, refID)
-		now := time.Now().UTC().Format(time.RFC3339)
-
 		if err != nil {
 			h.logger.Debug("node health check failed", "node", refID, "error", err)
-			h.store.Update(h.ctx, "nodes", refID, map[string]any{
-				"status":            "offline",
-				"last_health_check": now,
-				"error_message":     err.Error(),
-			})
-		} else {
-			h.store.Update(h.ctx, "nodes", refID, map[string]any{
-				"status":            "online",
-				"last_health_check": now,
-				"error_message":     "",
-			})
+			if errors.Is(err, docker.ErrMinionUnverified) && status != "unverified" {
+				h.logger.Warn("node minion failed verification, refusing to dispatch", "node", refID, "error", err)
+			}
+		}
+		update := h.healthUpdate(refID, err)
+		h.store.Update(h.ctx, "nodes", refID, update)
+		h.notifyOffline(node, update, err)
+		if err == nil && networkCheckDue(node, time.Now()) {
+			h.checkNetwork(h.ctx, node)
+		}
+		if err == nil && gpuCheckDue(node, time.Now()) {
+			h.checkGPUs(h.ctx, node)
+		}
+		if wildcardCheckDue(node, time.Now()) {
+			h.checkWildcard(h.ctx, node)
 		}
 	}
+	h.meterGPUUsage(h.ctx, time.Now())
 }
 
 // CheckNode triggers an immediate health check for a single node.
@@ -119,20 +132,62 @@ func (h *HealthChecker) CheckNode(ctx context.Context, nodeRefID string) {
 	if h.nodePool == nil {
 		return
 	}
+	before, _ := h.store.Get(ctx, "nodes", nodeRefID)
 	err := h.nodePool.PingNode(ctx, nodeRefID)
-	now := time.Now().UTC().Format(time.RFC3339)
+	update := h.healthUpdate(nodeRefID, err)
+	h.store.Update(ctx, "nodes", nodeRefID, update)
 	if err != nil {
-		h.store.Update(ctx, "nodes", nodeRefID, map[string]any{
-			"status":            "offline",
-			"last_health_check": now,
-			"error_message":     err.Error(),
-		})
-	} else {
-		h.store.Update(ctx, "nodes", nodeRefID, map[string]any{
-			"status":            "online",
-			"last_health_check": now,
-			"error_message":     "",
-		})
+		if before != nil {
+			h.notifyOffline(before, update, err)
+		}
+		return
+	}
+	if node, err := h.store.Get(ctx, "nodes", nodeRefID); err == nil {
+		h.checkNetwork(ctx, node)
+		if gpuCheckDue(node, time.Now()) {
+			h.checkGPUs(ctx, node)
+		}
+		if strVal(node["base_domain"]) != "" {
+			h.checkWildcard(ctx, node)
+		}
+	}
+}
+
+// healthUpdate returns the node fields to store after a ping, with the
+// Docker versions a successful ping reported.
+func (h *HealthChecker) healthUpdate(refID string, pingErr error) map[string]any {
+	update := nodeHealthUpdate(pingErr)
+	if pingErr != nil {
+		return update
+	}
+	if info := h.nodePool.DockerVersion(refID); info != nil && info.APIVersion != "" {
+		update["docker_version"] = info.DockerVersion
+		update["docker_api_version"] = info.APIVersion
+	}
+	return update
+}
+
+// notifyOffline notifies a node's creator when a failed ping took the node
+// from online to offline. node is the node as it was before the ping.
+func (h *HealthChecker) notifyOffline(node, update map[string]any, pingErr error) {
+	if h.notifier == nil || pingErr == nil || strVal(node["status"]) != "online" || update["status"] != "offline" {
+		return
+	}
+	h.notifier.NotifyNodeOffline(node, pingErr)
+}
+
+// nodeHealthUpdate returns the node fields to store after a ping.
+// A minion that fails signature/protocol verification is flagged "unverified"
+// rather than offline so operators can tell a tampered node from a down one.
+func nodeHealthUpdate(pingErr error) map[string]any {
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch {
+	case pingErr == nil:
+		return map[string]any{"status": "online", "last_health_check": now, "error_message": ""}
+	case errors.Is(pingErr, docker.ErrMinionUnverified):
+		return map[string]any{"status": "unverified", "last_health_check": now, "error_message": pingErr.Error()}
+	default:
+		return map[string]any{"status": "offline", "last_health_check": now, "error_message": pingErr.Error()}
 	}
 }
 
@@ -200,6 +255,7 @@ func (p *Provisioner) run() {
 }
 
 func (p *Provisioner) runCycle() {
+	defer p.store.LoopMetrics().Time("provisioner")()
 	// Query for active provisions
 	rows, err := p.store.RawQuery(p.ctx,
 		`SELECT cp.*, cc.credentials, cc.provider as cred_provider
@@ -460,7 +516,9 @@ func (p *Provisioner) failProvision(ctx context.Context, refID, reason string) {
 // DNS Verifier
 // =============================================================================
 
-// DNSVerifier periodically checks custom domain DNS records.
+// DNSVerifier periodically checks custom domain DNS records, switching
+// routing to domains waiting for a cutover once they point at their
+// deployment.
 type DNSVerifier struct {
 	store      *Store
 	baseDomain string
@@ -514,6 +572,7 @@ func (v *DNSVerifier) run() {
 }
 
 func (v *DNSVerifier) checkDomains() {
+	defer v.store.LoopMetrics().Time("dns_verifier")()
 	// Find deployments with custom domains that need verification
 	deployments, err := v.store.List(v.ctx, "deployments", []Filter{
 		{Field: "status", Value: "running"},
@@ -524,8 +583,35 @@ func (v *DNSVerifier) checkDomains() {
 	}
 
 	for _, depl := range deployments {
-		_ = depl // DNS verification logic would go here
-		// For now, auto domains work without verification
+		v.checkCutovers(depl)
+	}
+}
+
+// checkCutovers routes a deployment's custom domains that wait for a
+// cutover and whose CNAME or A record now points at it.
+func (v *DNSVerifier) checkCutovers(depl map[string]any) {
+	domains := parseDomainsList(depl["domains"])
+	changed := false
+	for i, d := range domains {
+		if d.CutoverRequestedAt == "" || d.VerificationStatus == "verified" {
+			continue
+		}
+		target, ips := domainTargets(v.ctx, v.store, v.baseDomain, depl)
+		verified, method, checkErr := verifyDomainDNS(d.Hostname, target, ips)
+		if !verified {
+			domains[i].LastCheckError = checkErr
+			continue
+		}
+		routeDomain(&domains[i], method, time.Now())
+		changed = true
+		v.logger.Info("custom domain cut over", "deployment", depl["reference_id"], "hostname", d.Hostname, "method", method)
+	}
+	if !changed {
+		return
+	}
+	domainsJSON, _ := json.Marshal(domains)
+	if _, err := v.store.Update(v.ctx, "deployments", strVal(depl["reference_id"]), map[string]any{"domains": string(domainsJSON)}); err != nil {
+		v.logger.Error("failed to record domain cutover", "deployment", depl["reference_id"], "error", err)
 	}
 }
 
@@ -533,9 +619,11 @@ func (v *DNSVerifier) checkDomains() {
 // Invoice Generator
 // =============================================================================
 
-// InvoiceGenerator periodically creates/updates invoices for users with running deployments.
+// InvoiceGenerator periodically creates/updates invoices for users with
+// running deployments or metered usage.
 type InvoiceGenerator struct {
 	store    *Store
+	pricing  invoice.Pricing
 	interval time.Duration
 	logger   *slog.Logger
 	ctx      context.Context
@@ -543,12 +631,13 @@ type InvoiceGenerator struct {
 	wg       sync.WaitGroup
 }
 
-func NewInvoiceGenerator(store *Store, interval time.Duration, logger *slog.Logger) *InvoiceGenerator {
+func NewInvoiceGenerator(store *Store, pricing invoice.Pricing, interval time.Duration, logger *slog.Logger) *InvoiceGenerator {
 	if interval == 0 {
 		interval = 24 * time.Hour
 	}
 	return &InvoiceGenerator{
 		store:    store,
+		pricing:  pricing,
 		interval: interval,
 		logger:   logger.With("component", "invoice_generator"),
 	}
@@ -603,73 +692,80 @@ func timeToYearMonth(v any) string {
 }
 
 func (ig *InvoiceGenerator) generateAll() {
-	now := time.Now().UTC()
-	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-	periodEnd := periodStart.AddDate(0, 1, 0).Add(-time.Second)
-	periodStartStr := periodStart.Format(time.RFC3339)
-	currentYM := periodStart.Format("2006-01") // "2026-02"
+	defer ig.store.LoopMetrics().Time("invoice_generator")()
+	start, _ := costs.MonthBounds(time.Now())
 
-	// Get all running deployments
-	deployments, err := ig.store.List(ig.ctx, "deployments", []Filter{
-		{Field: "status", Value: "running"},
-	}, Page{Limit: 1000})
+	plans, err := ig.store.userPlans(ig.ctx)
 	if err != nil {
-		ig.logger.Error("failed to list deployments", "error", err)
+		ig.logger.Error("failed to list user plans", "error", err)
 		return
 	}
-
-	if len(deployments) == 0 {
+	subs, err := ig.subscriptions()
+	if err != nil {
+		ig.logger.Error("failed to list deployments", "error", err)
 		return
 	}
 
-	// Group running deployments by owner (customer_id)
-	type lineItem struct {
-		DeploymentID   string `json:"deployment_id"`
-		DeploymentName string `json:"deployment_name"`
-		TemplateName   string `json:"template_name"`
-		MonthlyCents   int    `json:"monthly_cents"`
-		Description    string `json:"description"`
-	}
+	// The month before keeps the deployments it was invoiced for; only the
+	// usage metered since its last update is added
+	ig.invoiceMonth(start.AddDate(0, -1, 0), nil, plans)
+	ig.invoiceMonth(start, subs, plans)
+}
 
-	type userBill struct {
-		items      []lineItem
-		totalCents int
+// subscriptions returns the running, non-trial deployments of each customer
+// at their templates' monthly prices.
+func (ig *InvoiceGenerator) subscriptions() (map[int][]invoice.Subscription, error) {
+	deployments, err := ig.store.List(ig.ctx, "deployments", []Filter{
+		{Field: "status", Value: "running"},
+	}, Page{Limit: 1000})
+	if err != nil {
+		return nil, err
 	}
 
-	bills := map[int]*userBill{}
-
+	subs := map[int][]invoice.Subscription{}
 	for _, d := range deployments {
 		ownerID, _ := toInt64(d["customer_id"])
 		if ownerID == 0 {
 			continue
 		}
+		// Trial deployments are not billed until converted
+		if _, trial := deploymentExpiry(d); trial {
+			continue
+		}
 
-		var priceCents int
+		var priceCents int64
 		var templateName string
 		if tmplID, ok := toInt64(d["template_id"]); ok && tmplID > 0 {
 			tmpl, err := ig.store.GetByID(ig.ctx, "templates", int(tmplID))
 			if err == nil {
-				if p, ok := toInt64(tmpl["price_monthly_cents"]); ok {
-					priceCents = int(p)
-				}
+				priceCents, _ = toInt64(tmpl["price_monthly_cents"])
 				templateName = strVal(tmpl["name"])
 			}
 		}
 
 		uid := int(ownerID)
-		if bills[uid] == nil {
-			bills[uid] = &userBill{}
-		}
-
-		deplName := strVal(d["name"])
-		bills[uid].items = append(bills[uid].items, lineItem{
+		subs[uid] = append(subs[uid], invoice.Subscription{
 			DeploymentID:   strVal(d["reference_id"]),
-			DeploymentName: deplName,
+			DeploymentName: strVal(d["name"]),
 			TemplateName:   templateName,
 			MonthlyCents:   priceCents,
-			Description:    fmt.Sprintf("%s (%s) — %s", deplName, templateName, periodStart.Format("Jan 2006")),
 		})
-		bills[uid].totalCents += priceCents
+	}
+	return subs, nil
+}
+
+// invoiceMonth creates or updates the invoices of the month starting at
+// start for customers with subscriptions in subs or metered usage. A nil subs
+// refreshes only the usage lines of the month's drafts, for a month that has
+// ended. Paid invoices and those in the payment flow are left alone.
+func (ig *InvoiceGenerator) invoiceMonth(start time.Time, subs map[int][]invoice.Subscription, plans map[int]string) {
+	usage, err := ig.store.MonthUsage(ig.ctx, start)
+	if err != nil {
+		ig.logger.Error("failed to load usage", "error", err, "month", start.Format(costs.MonthLayout))
+		return
+	}
+	if len(subs) == 0 && len(usage) == 0 {
+		return
 	}
 
 	// Get existing invoices for this period (match by year-month to avoid format issues)
@@ -679,9 +775,10 @@ func (ig *InvoiceGenerator) generateAll() {
 		return
 	}
 
+	month := start.Format(costs.MonthLayout)
 	existingByUser := map[int]map[string]any{}
 	for _, inv := range allInvoices {
-		if timeToYearMonth(inv["period_start"]) == currentYM {
+		if timeToYearMonth(inv["period_start"]) == month {
 			ownerID, _ := toInt64(inv["user_id"])
 			uid := int(ownerID)
 			// Prefer paid/pending over draft (don't overwrite settled invoices)
@@ -695,45 +792,65 @@ func (ig *InvoiceGenerator) generateAll() {
 		}
 	}
 
-	// Create or update invoices per user
-	for userID, bill := range bills {
-		if bill.totalCents == 0 {
-			continue
-		}
+	customers := map[int]bool{}
+	for uid := range subs {
+		customers[uid] = true
+	}
+	for uid := range usage {
+		customers[uid] = true
+	}
 
-		itemsJSON, _ := json.Marshal(bill.items)
+	// Create or update invoices per user
+	for userID := range customers {
 		existing := existingByUser[userID]
-
 		if existing != nil {
 			status, _ := existing["status"].(string)
 			if status == "paid" || status == "pending" {
 				continue // already paid or in payment flow
 			}
+		}
+
+		prices := ig.pricing.For(plans[userID])
+		var lines []invoice.Line
+		if subs == nil && existing != nil {
+			decodeJSONValue(existing["items"], &lines)
+			lines = invoice.Refresh(lines, usage[userID], prices)
+		} else {
+			lines = invoice.Build(start, subs[userID], usage[userID], prices)
+		}
+		total := invoice.Total(lines)
+		if total == 0 {
+			continue
+		}
+		itemsJSON, _ := json.Marshal(lines)
+
+		if existing != nil {
 			// Update draft with latest costs
 			refID := strVal(existing["reference_id"])
 			ig.store.Update(ig.ctx, "invoices", refID, map[string]any{
 				"items":          string(itemsJSON),
-				"subtotal_cents": bill.totalCents,
-				"total_cents":    bill.totalCents,
+				"subtotal_cents": total,
+				"total_cents":    total,
 			})
-			ig.logger.Debug("updated invoice", "invoice", refID, "user_id", userID, "total_cents", bill.totalCents)
+			ig.logger.Debug("updated invoice", "invoice", refID, "user_id", userID, "total_cents", total)
 		} else {
 			// Create new invoice
+			_, end := costs.MonthBounds(start)
 			row, err := ig.store.Create(ig.ctx, "invoices", map[string]any{
 				"user_id":        userID,
-				"period_start":   periodStartStr,
-				"period_end":     periodEnd.Format(time.RFC3339),
+				"period_start":   start.Format(time.RFC3339),
+				"period_end":     end.Add(-time.Second).Format(time.RFC3339),
 				"items":          string(itemsJSON),
-				"subtotal_cents": bill.totalCents,
+				"subtotal_cents": total,
 				"tax_cents":      0,
-				"total_cents":    bill.totalCents,
+				"total_cents":    total,
 				"currency":       "USD",
 			})
 			if err != nil {
 				ig.logger.Error("failed to create invoice", "error", err, "user_id", userID)
 				continue
 			}
-			ig.logger.Info("created invoice", "invoice", strVal(row["reference_id"]), "user_id", userID, "total_cents", bill.totalCents)
+			ig.logger.Info("created invoice", "invoice", strVal(row["reference_id"]), "user_id", userID, "total_cents", total)
 		}
 	}
 }
@@ -0,0 +1,209 @@
+// Package acme obtains certificates from an ACME certificate authority such
+// as Let's Encrypt.
+// This is part of the Imperative Shell - handles I/O (ACME API calls).
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	xacme "golang.org/x/crypto/acme"
+
+	coreacme "github.com/artpar/hoster/internal/core/acme"
+)
+
+// LetsEncryptURL is Let's Encrypt's production directory.
+const LetsEncryptURL = xacme.LetsEncryptURL
+
+// Challenge is one challenge to answer for a hostname.
+type Challenge struct {
+	Type     coreacme.Challenge
+	Hostname string
+	// Token and KeyAuthorization are the HTTP-01 response: KeyAuthorization
+	// is served at /.well-known/acme-challenge/<Token>.
+	Token            string
+	KeyAuthorization string
+	// DNSValue is the DNS-01 TXT record value for
+	// coreacme.DNSChallengeName(Hostname).
+	DNSValue string
+}
+
+// Solver publishes challenge responses where the CA will look for them.
+type Solver interface {
+	Present(ctx context.Context, ch Challenge) error
+	CleanUp(ctx context.Context, ch Challenge) error
+}
+
+// Certificate is an issued certificate.
+type Certificate struct {
+	ChainPEM []byte // Leaf first, then intermediates
+	KeyPEM   []byte
+}
+
+// Issuer obtains certificates for single hostnames with one ACME account.
+type Issuer struct {
+	client *xacme.Client
+	email  string
+}
+
+// NewIssuer creates an issuer for the directory at directoryURL with the
+// account key. Register must succeed before certificates are issued.
+func NewIssuer(directoryURL string, accountKey crypto.Signer, email string) *Issuer {
+	if directoryURL == "" {
+		directoryURL = LetsEncryptURL
+	}
+	return &Issuer{
+		client: &xacme.Client{Key: accountKey, DirectoryURL: directoryURL, UserAgent: "hoster"},
+		email:  email,
+	}
+}
+
+// Register creates the ACME account, agreeing to the CA's terms of service.
+// An account that already exists for the key is fine.
+func (i *Issuer) Register(ctx context.Context) error {
+	acct := &xacme.Account{}
+	if i.email != "" {
+		acct.Contact = []string{"mailto:" + i.email}
+	}
+	if _, err := i.client.Register(ctx, acct, xacme.AcceptTOS); err != nil && !errors.Is(err, xacme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("register acme account: %w", err)
+	}
+	return nil
+}
+
+// Issue obtains a certificate for hostname, proving control of it with the
+// given challenge type through solver. The certificate's key is a new ECDSA
+// P-256 key.
+func (i *Issuer) Issue(ctx context.Context, hostname string, challenge coreacme.Challenge, solver Solver) (*Certificate, error) {
+	order, err := i.client.AuthorizeOrder(ctx, xacme.DomainIDs(hostname))
+	if err != nil {
+		return nil, fmt.Errorf("create order: %w", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err := i.authorize(ctx, authzURL, hostname, challenge, solver); err != nil {
+			return nil, err
+		}
+	}
+	order, err = i.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("wait for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostname},
+		DNSNames: []string{hostname},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate request: %w", err)
+	}
+	der, _, err := i.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalize order: %w", err)
+	}
+
+	var chain []byte
+	for _, block := range der {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block})...)
+	}
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{ChainPEM: chain, KeyPEM: keyPEM}, nil
+}
+
+// authorize answers one authorization of an order, unless it is already
+// valid from an earlier order.
+func (i *Issuer) authorize(ctx context.Context, authzURL, hostname string, challenge coreacme.Challenge, solver Solver) error {
+	authz, err := i.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+
+	var chal *xacme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == string(challenge) {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("certificate authority offers no %s challenge for %s", challenge, hostname)
+	}
+
+	ch := Challenge{Type: challenge, Hostname: hostname, Token: chal.Token}
+	switch challenge {
+	case coreacme.ChallengeDNS01:
+		ch.DNSValue, err = i.client.DNS01ChallengeRecord(chal.Token)
+	default:
+		ch.KeyAuthorization, err = i.client.HTTP01ChallengeResponse(chal.Token)
+	}
+	if err != nil {
+		return fmt.Errorf("compute challenge response: %w", err)
+	}
+	if err := solver.Present(ctx, ch); err != nil {
+		return fmt.Errorf("present %s challenge: %w", challenge, err)
+	}
+	defer func() {
+		// The order's outcome doesn't depend on cleanup; use a fresh context
+		// so a cancelled issuance still removes its records
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_ = solver.CleanUp(cleanupCtx, ch)
+	}()
+
+	if _, err := i.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept %s challenge: %w", challenge, err)
+	}
+	if _, err := i.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s challenge failed: %w", challenge, err)
+	}
+	return nil
+}
+
+// =============================================================================
+// Keys
+// =============================================================================
+
+// GenerateAccountKey creates a new ACME account key.
+func GenerateAccountKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// EncodeKey encodes an ECDSA private key as PEM.
+func EncodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodeKey decodes a PEM ECDSA private key written by EncodeKey.
+func DecodeKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no EC private key in PEM")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	return key, nil
+}
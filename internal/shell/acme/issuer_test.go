@@ -0,0 +1,28 @@
+package acme
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeKey(t *testing.T) {
+	key, err := GenerateAccountKey()
+	require.NoError(t, err)
+
+	keyPEM, err := EncodeKey(key)
+	require.NoError(t, err)
+	decoded, err := DecodeKey(keyPEM)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(decoded))
+
+	_, err = DecodeKey([]byte("-----BEGIN CERTIFICATE-----\nAA==\n-----END CERTIFICATE-----\n"))
+	assert.Error(t, err)
+}
+
+func TestNewIssuer_DefaultDirectory(t *testing.T) {
+	key, err := GenerateAccountKey()
+	require.NoError(t, err)
+	assert.Equal(t, LetsEncryptURL, NewIssuer("", key, "").client.DirectoryURL)
+}
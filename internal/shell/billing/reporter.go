@@ -68,6 +68,24 @@ func NewReporter(cfg ReporterConfig) *Reporter {
 // Start begins the background reporting loop.
 // It runs until Stop() is called or the context is cancelled.
 func (r *Reporter) Start(ctx context.Context) {
+	defer close(r.doneCh)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	r.Run(ctx)
+}
+
+// Run reports pending events, then every interval, until the context is
+// cancelled. Unlike Start it can be called again after it returns.
+func (r *Reporter) Run(ctx context.Context) {
 	r.logger.Info("starting billing reporter",
 		"interval", r.interval,
 		"batch_size", r.batchSize,
@@ -75,7 +93,6 @@ func (r *Reporter) Start(ctx context.Context) {
 
 	ticker := time.NewTicker(r.interval)
 	defer ticker.Stop()
-	defer close(r.doneCh)
 
 	// Report any pending events on startup
 	r.reportBatch(ctx)
@@ -83,9 +100,6 @@ func (r *Reporter) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			r.logger.Info("billing reporter stopped due to context cancellation")
-			return
-		case <-r.stopCh:
 			r.logger.Info("billing reporter stopped")
 			return
 		case <-ticker.C:
@@ -166,6 +180,24 @@ func RecordEvent(ctx context.Context, s BillingStore, userID int, eventType doma
 	return s.CreateUsageEvent(ctx, &event)
 }
 
+// RecordMeteredEvent records a usage event carrying a quantity, such as the
+// megabytes stored by a bucket.
+func RecordMeteredEvent(ctx context.Context, s BillingStore, userID int, eventType domain.EventType, resourceID, resourceType string, quantity int64, metadata map[string]string) error {
+	event := domain.NewMeterEvent(
+		generateEventID(),
+		userID,
+		eventType,
+		resourceID,
+		resourceType,
+	).WithQuantity(quantity)
+
+	if metadata != nil {
+		event.Metadata = metadata
+	}
+
+	return s.CreateUsageEvent(ctx, &event)
+}
+
 // generateEventID generates a unique event ID.
 func generateEventID() string {
 	return "evt_" + time.Now().Format("20060102150405") + "_" + randomSuffix()
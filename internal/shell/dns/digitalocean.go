@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// TXTProvider publishes TXT records in a DNS zone hoster controls, for
+// DNS-01 challenges delegated to it.
+type TXTProvider interface {
+	// Zone is the zone records are published in.
+	Zone() string
+	// SetTXT adds a TXT record with value at fqdn, a name in the zone.
+	SetTXT(ctx context.Context, fqdn, value string) error
+	// DeleteTXT removes the TXT records with value at fqdn.
+	DeleteTXT(ctx context.Context, fqdn, value string) error
+}
+
+// challengeTTL keeps challenge records from being cached past a renewal.
+const challengeTTL = 60
+
+// DigitalOceanTXT publishes TXT records in a DigitalOcean DNS zone.
+type DigitalOceanTXT struct {
+	client *godo.Client
+	zone   string
+}
+
+// NewDigitalOceanTXT creates a provider for zone with an API token.
+func NewDigitalOceanTXT(apiToken, zone string) *DigitalOceanTXT {
+	return newDigitalOceanTXT(godo.NewFromToken(apiToken), zone)
+}
+
+func newDigitalOceanTXT(client *godo.Client, zone string) *DigitalOceanTXT {
+	return &DigitalOceanTXT{client: client, zone: strings.TrimSuffix(strings.ToLower(zone), ".")}
+}
+
+// Zone returns the zone records are published in.
+func (p *DigitalOceanTXT) Zone() string {
+	return p.zone
+}
+
+// SetTXT adds a TXT record with value at fqdn.
+func (p *DigitalOceanTXT) SetTXT(ctx context.Context, fqdn, value string) error {
+	name, err := p.relativeName(fqdn)
+	if err != nil {
+		return err
+	}
+	if _, _, err := p.client.Domains.CreateRecord(ctx, p.zone, &godo.DomainRecordEditRequest{
+		Type: "TXT",
+		Name: name,
+		Data: value,
+		TTL:  challengeTTL,
+	}); err != nil {
+		return fmt.Errorf("create TXT record %s: %w", fqdn, err)
+	}
+	return nil
+}
+
+// DeleteTXT removes the TXT records with value at fqdn. Records with other
+// values are kept, so concurrent challenges for one name don't clash.
+func (p *DigitalOceanTXT) DeleteTXT(ctx context.Context, fqdn, value string) error {
+	if _, err := p.relativeName(fqdn); err != nil {
+		return err
+	}
+	records, _, err := p.client.Domains.RecordsByTypeAndName(ctx, p.zone, "TXT", fqdn, &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return fmt.Errorf("list TXT records %s: %w", fqdn, err)
+	}
+	for _, r := range records {
+		if r.Data != value {
+			continue
+		}
+		if _, err := p.client.Domains.DeleteRecord(ctx, p.zone, r.ID); err != nil {
+			return fmt.Errorf("delete TXT record %s: %w", fqdn, err)
+		}
+	}
+	return nil
+}
+
+// relativeName returns fqdn's name within the zone.
+func (p *DigitalOceanTXT) relativeName(fqdn string) (string, error) {
+	fqdn = strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	name, ok := strings.CutSuffix(fqdn, "."+p.zone)
+	if !ok || name == "" {
+		return "", fmt.Errorf("%s is not a name in zone %s", fqdn, p.zone)
+	}
+	return name, nil
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDigitalOcean serves the domain records API for one zone.
+type fakeDigitalOcean struct {
+	mu      sync.Mutex
+	nextID  int
+	records map[int]godo.DomainRecord
+}
+
+func (f *fakeDigitalOcean) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const prefix = "/v2/domains/acme.hoster.io/records"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == prefix:
+		var req godo.DomainRecordEditRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.nextID++
+		rec := godo.DomainRecord{ID: f.nextID, Type: req.Type, Name: req.Name, Data: req.Data, TTL: req.TTL}
+		f.records[rec.ID] = rec
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"domain_record": rec})
+	case r.Method == http.MethodGet && r.URL.Path == prefix:
+		name := strings.TrimSuffix(r.URL.Query().Get("name"), ".acme.hoster.io")
+		var out []godo.DomainRecord
+		for _, rec := range f.records {
+			if rec.Type == r.URL.Query().Get("type") && rec.Name == name {
+				out = append(out, rec)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"domain_records": out, "meta": map[string]any{"total": len(out)}})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, prefix+"/"):
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, prefix+"/"), "%d", &id)
+		delete(f.records, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDigitalOceanTXT(t *testing.T) {
+	fake := &fakeDigitalOcean{records: map[int]godo.DomainRecord{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client, err := godo.New(srv.Client(), godo.SetBaseURL(srv.URL+"/"))
+	require.NoError(t, err)
+	p := newDigitalOceanTXT(client, "ACME.hoster.io.")
+	ctx := t.Context()
+
+	assert.Equal(t, "acme.hoster.io", p.Zone())
+	require.NoError(t, p.SetTXT(ctx, "abc.acme.hoster.io", "one"))
+	require.NoError(t, p.SetTXT(ctx, "abc.acme.hoster.io", "two"))
+	require.Len(t, fake.records, 2)
+	assert.Equal(t, "abc", fake.records[1].Name)
+	assert.Equal(t, challengeTTL, fake.records[1].TTL)
+
+	require.NoError(t, p.DeleteTXT(ctx, "abc.acme.hoster.io", "one"))
+	require.Len(t, fake.records, 1)
+	assert.Equal(t, "two", fake.records[2].Data)
+
+	assert.Error(t, p.SetTXT(ctx, "abc.example.com", "one"))
+	assert.Error(t, p.SetTXT(ctx, "acme.hoster.io", "one"))
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/artpar/hoster/internal/core/minion"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
@@ -170,6 +171,13 @@ func (d *DockerClient) CreateContainer(spec ContainerSpec) (string, error) {
 	if spec.Resources.MemoryLimit > 0 {
 		hostConfig.Memory = spec.Resources.MemoryLimit
 	}
+	if len(spec.Resources.GPUDeviceIDs) > 0 {
+		hostConfig.DeviceRequests = []container.DeviceRequest{{
+			Driver:       "nvidia",
+			DeviceIDs:    spec.Resources.GPUDeviceIDs,
+			Capabilities: [][]string{{"gpu"}},
+		}}
+	}
 
 	// Restart policy
 	if spec.RestartPolicy.Name != "" {
@@ -179,6 +187,14 @@ func (d *DockerClient) CreateContainer(spec ContainerSpec) (string, error) {
 		}
 	}
 
+	// Init process, ulimits and device cgroup rules
+	hostConfig.Init = spec.Init
+	for _, u := range spec.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	hostConfig.DeviceCgroupRules = spec.DeviceCgroupRules
+	hostConfig.ExtraHosts = spec.ExtraHosts
+
 	// Health check
 	if spec.HealthCheck != nil {
 		config.Healthcheck = &container.HealthConfig{
@@ -330,18 +346,20 @@ func (d *DockerClient) InspectContainer(containerID string) (*ContainerInfo, err
 	}
 
 	return &ContainerInfo{
-		ID:         resp.ID,
-		Name:       strings.TrimPrefix(resp.Name, "/"),
-		Image:      resp.Config.Image,
-		Status:     ContainerStatus(resp.State.Status),
-		State:      resp.State.Status,
-		Health:     health,
-		CreatedAt:  createdAt,
-		StartedAt:  startedAt,
-		FinishedAt: finishedAt,
-		Ports:      ports,
-		Labels:     resp.Config.Labels,
-		ExitCode:   resp.State.ExitCode,
+		ID:           resp.ID,
+		Name:         strings.TrimPrefix(resp.Name, "/"),
+		Image:        resp.Config.Image,
+		Status:       ContainerStatus(resp.State.Status),
+		State:        resp.State.Status,
+		Health:       health,
+		CreatedAt:    createdAt,
+		StartedAt:    startedAt,
+		FinishedAt:   finishedAt,
+		Ports:        ports,
+		Labels:       resp.Config.Labels,
+		ExitCode:     resp.State.ExitCode,
+		OOMKilled:    resp.State.OOMKilled,
+		RestartCount: resp.RestartCount,
 	}, nil
 }
 
@@ -598,6 +616,48 @@ func (d *DockerClient) ImageExists(imageName string) (bool, error) {
 	return true, nil
 }
 
+// InspectImage reports whether an image exists locally and its ID.
+func (d *DockerClient) InspectImage(ctx context.Context, imageName string) (*minion.ImageExistsResult, error) {
+	inspect, err := d.cli.ImageInspect(ctx, imageName)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return &minion.ImageExistsResult{Exists: false}, nil
+		}
+		return nil, NewDockerError("InspectImage", "image", imageName, err.Error(), err)
+	}
+	return &minion.ImageExistsResult{Exists: true, ID: inspect.ID, RepoDigests: inspect.RepoDigests}, nil
+}
+
+// RegistryDigest returns the digest an image tag points at in its registry
+// now, without pulling the image.
+func (d *DockerClient) RegistryDigest(ctx context.Context, imageName string) (string, error) {
+	dist, err := d.cli.DistributionInspect(ctx, imageName, "")
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return "", NewDockerError("RegistryDigest", "image", imageName, "image not found", ErrImageNotFound)
+		}
+		return "", NewDockerError("RegistryDigest", "image", imageName, err.Error(), err)
+	}
+	return dist.Descriptor.Digest.String(), nil
+}
+
+// SaveImage writes a docker save archive of a local image into w.
+func (d *DockerClient) SaveImage(ctx context.Context, imageName string, w io.Writer) error {
+	reader, err := d.cli.ImageSave(ctx, []string{imageName})
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return NewDockerError("SaveImage", "image", imageName, "image not found", ErrImageNotFound)
+		}
+		return NewDockerError("SaveImage", "image", imageName, err.Error(), err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(w, reader); err != nil {
+		return NewDockerError("SaveImage", "image", imageName, err.Error(), err)
+	}
+	return nil
+}
+
 // =============================================================================
 // Container Stats (F010: Monitoring)
 // =============================================================================
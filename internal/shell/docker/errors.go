@@ -3,6 +3,8 @@ package docker
 import (
 	"errors"
 	"fmt"
+
+	"github.com/artpar/hoster/internal/core/minion"
 )
 
 // =============================================================================
@@ -22,17 +24,25 @@ var (
 	ErrNetworkInUse         = errors.New("network has active endpoints")
 
 	// Volume errors
-	ErrVolumeNotFound = errors.New("volume not found")
-	ErrVolumeInUse    = errors.New("volume is in use")
+	ErrVolumeNotFound   = errors.New("volume not found")
+	ErrVolumeInUse      = errors.New("volume is in use")
+	ErrChecksumMismatch = errors.New("volume archive checksum mismatch")
 
 	// Image errors
 	ErrImageNotFound   = errors.New("image not found")
 	ErrImagePullFailed = errors.New("image pull failed")
+	ErrImageMismatch   = errors.New("transferred image does not match its source")
 
 	// Connection errors
 	ErrPortAlreadyAllocated = errors.New("port is already allocated")
 	ErrConnectionFailed     = errors.New("docker connection failed")
 	ErrTimeout              = errors.New("operation timed out")
+
+	// Node verification errors
+	ErrMinionUnverified = errors.New("minion failed verification")
+
+	// Transport errors: a minion payload over its size limit
+	ErrPayloadTooLarge = minion.ErrPayloadTooLarge
 )
 
 // DockerError wraps errors with additional context.
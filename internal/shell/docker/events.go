@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// =============================================================================
+// Container Lifecycle Events
+// =============================================================================
+
+// ContainerEventSource reads the container die and oom events the Docker
+// daemon still holds, so a crash is seen even when a restart policy brought
+// the container back before the next inspect. SSHDockerClient and
+// DockerClient implement it.
+type ContainerEventSource interface {
+	ContainerEvents(ctx context.Context, since, until time.Time, filters map[string]string) ([]minion.ContainerLifecycleEvent, error)
+}
+
+// ContainerEvents returns the local daemon's container die and oom events in
+// the range.
+func (d *DockerClient) ContainerEvents(ctx context.Context, since, until time.Time, extra map[string]string) ([]minion.ContainerLifecycleEvent, error) {
+	f := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionOOM)),
+	)
+	for k, v := range extra {
+		f.Add(k, v)
+	}
+
+	msgs, errs := d.cli.Events(ctx, events.ListOptions{
+		Since:   strconv.FormatInt(since.Unix(), 10),
+		Until:   strconv.FormatInt(until.Unix(), 10),
+		Filters: f,
+	})
+
+	var result []minion.ContainerLifecycleEvent
+	for {
+		select {
+		case msg := <-msgs:
+			ev := minion.ContainerLifecycleEvent{
+				ContainerID: msg.Actor.ID,
+				Name:        msg.Actor.Attributes["name"],
+				Action:      string(msg.Action),
+				Time:        time.Unix(0, msg.TimeNano).UTC(),
+				Labels:      msg.Actor.Attributes,
+			}
+			if code, err := strconv.Atoi(msg.Actor.Attributes["exitCode"]); err == nil {
+				ev.ExitCode = code
+			}
+			result = append(result, ev)
+		case err := <-errs:
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, NewDockerError("ContainerEvents", "events", "", err.Error(), err)
+			}
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Image Transfer
+// =============================================================================
+//
+// Air-gapped nodes cannot reach a registry. Their images are pulled by a
+// connected relay (another node, or the hoster host itself), exported with
+// docker save and streamed through the control plane into docker load on the
+// target. The stream is hashed in flight and checked against the checksum the
+// target computed while loading it, and the loaded image ID must equal the
+// source's before any container is created from it.
+
+// ImageSource is a Docker host that can pull an image and export it.
+// SSHDockerClient and DockerClient implement it.
+type ImageSource interface {
+	PullImage(image string, opts PullOptions) error
+	InspectImage(ctx context.Context, image string) (*minion.ImageExistsResult, error)
+	SaveImage(ctx context.Context, image string, w io.Writer) error
+}
+
+// ImageSink is a Docker host that can load an exported image.
+// SSHDockerClient implements it.
+type ImageSink interface {
+	InspectImage(ctx context.Context, image string) (*minion.ImageExistsResult, error)
+	LoadImage(ctx context.Context, r io.Reader) (*minion.ImageLoadResult, error)
+}
+
+// ImageTransferResult describes a verified image transfer.
+type ImageTransferResult struct {
+	ImageID string
+	Size    int64
+	SHA256  string
+	Skipped bool // The target already had the image
+}
+
+// TransferImage makes image available on dst by exporting it from src,
+// pulling it on src first if needed. A target that already holds the same
+// image ID is left alone.
+func TransferImage(ctx context.Context, src ImageSource, dst ImageSink, image string) (*ImageTransferResult, error) {
+	have, err := src.InspectImage(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s on source: %w", image, err)
+	}
+	if !have.Exists {
+		if err := src.PullImage(image, PullOptions{}); err != nil {
+			return nil, fmt.Errorf("pull %s on source: %w", image, err)
+		}
+		if have, err = src.InspectImage(ctx, image); err != nil {
+			return nil, fmt.Errorf("inspect %s on source: %w", image, err)
+		}
+		if !have.Exists {
+			return nil, NewDockerError("TransferImage", "image", image, "missing on source after pull", ErrImageNotFound)
+		}
+	}
+
+	if existing, err := dst.InspectImage(ctx, image); err == nil && existing.Exists && existing.ID == have.ID {
+		return &ImageTransferResult{ImageID: have.ID, Skipped: true}, nil
+	}
+
+	sent, loaded, err := copyImage(ctx, src, dst, image)
+	if err != nil {
+		return nil, err
+	}
+	if loaded.Size != sent.n || loaded.SHA256 != sent.sum() {
+		return nil, NewDockerError("TransferImage", "image", image,
+			fmt.Sprintf("sent %d bytes (sha256 %s), target loaded %d bytes (sha256 %s)", sent.n, sent.sum(), loaded.Size, loaded.SHA256),
+			ErrImageMismatch)
+	}
+
+	got, err := dst.InspectImage(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s on target: %w", image, err)
+	}
+	if !got.Exists || got.ID != have.ID {
+		return nil, NewDockerError("TransferImage", "image", image,
+			fmt.Sprintf("target has image ID %q, source has %q", got.ID, have.ID), ErrImageMismatch)
+	}
+	return &ImageTransferResult{ImageID: have.ID, Size: loaded.Size, SHA256: loaded.SHA256}, nil
+}
+
+// imageStream hashes and counts the bytes sent to the target.
+type imageStream struct {
+	h hash.Hash
+	n int64
+}
+
+func (s *imageStream) Write(p []byte) (int, error) {
+	s.h.Write(p)
+	s.n += int64(len(p))
+	return len(p), nil
+}
+
+func (s *imageStream) sum() string {
+	return hex.EncodeToString(s.h.Sum(nil))
+}
+
+// copyImage streams the export of image on src into a load on dst.
+func copyImage(ctx context.Context, src ImageSource, dst ImageSink, image string) (*imageStream, *minion.ImageLoadResult, error) {
+	pr, pw := io.Pipe()
+	sent := &imageStream{h: sha256.New()}
+
+	saveErr := make(chan error, 1)
+	go func() {
+		err := src.SaveImage(ctx, image, io.MultiWriter(pw, sent))
+		pw.CloseWithError(err) // nil closes with EOF
+		saveErr <- err
+	}()
+
+	loaded, err := dst.LoadImage(ctx, pr)
+	pr.CloseWithError(errReceiveDone) // unblocks a source still writing
+	serr := <-saveErr
+	if serr != nil && !errors.Is(serr, errReceiveDone) {
+		return nil, nil, fmt.Errorf("export %s from source: %w", image, serr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("load %s on target: %w", image, err)
+	}
+	return sent, loaded, nil
+}
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Fake Image Host
+// =============================================================================
+
+// memImageHost keeps image archives in memory. An image's ID is the digest of
+// its archive, and a host with a registry entry can pull it.
+type memImageHost struct {
+	mu        sync.Mutex
+	images    map[string][]byte
+	registry  map[string][]byte
+	pulls     int
+	loads     int
+	failSave  bool // fail SaveImage midway through the archive
+	corrupt   bool // load a different image than the one received
+	misreport bool // report a wrong checksum for the received archive
+}
+
+func newMemImageHost() *memImageHost {
+	return &memImageHost{images: map[string][]byte{}, registry: map[string][]byte{}}
+}
+
+func (m *memImageHost) PullImage(image string, _ PullOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pulls++
+	data, ok := m.registry[image]
+	if !ok {
+		return ErrImageNotFound
+	}
+	m.images[image] = data
+	return nil
+}
+
+func (m *memImageHost) InspectImage(_ context.Context, image string) (*minion.ImageExistsResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.images[image]
+	if !ok {
+		return &minion.ImageExistsResult{Exists: false}, nil
+	}
+	return &minion.ImageExistsResult{Exists: true, ID: "sha256:" + digestOf(data)}, nil
+}
+
+func (m *memImageHost) SaveImage(_ context.Context, image string, w io.Writer) error {
+	m.mu.Lock()
+	data, ok := m.images[image]
+	fail := m.failSave
+	m.mu.Unlock()
+	if !ok {
+		return ErrImageNotFound
+	}
+	if fail {
+		w.Write(data[:len(data)/2])
+		return errors.New("connection reset")
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// LoadImage stores the archive as testImage; every test moves that one image.
+func (m *memImageHost) LoadImage(_ context.Context, r io.Reader) (*minion.ImageLoadResult, error) {
+	data, _ := io.ReadAll(r) // a failed source just ends the stream early
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads++
+	sum := digestOf(data)
+	if m.misreport {
+		sum = digestOf([]byte("other"))
+	}
+	stored := data
+	if m.corrupt {
+		stored = append(append([]byte(nil), data...), 0)
+	}
+	m.images[testImage] = stored
+	return &minion.ImageLoadResult{Size: int64(len(data)), SHA256: sum, Loaded: []string{testImage}}, nil
+}
+
+const testImage = "nginx:1.27"
+
+// =============================================================================
+// TransferImage Tests
+// =============================================================================
+
+func TestTransferImage_PullsOnSourceAndLoadsOnTarget(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.registry[testImage] = testVolumeData(4096)
+
+	res, err := TransferImage(context.Background(), src, dst, testImage)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, src.pulls)
+	assert.Equal(t, 1, dst.loads)
+	assert.False(t, res.Skipped)
+	assert.Equal(t, int64(4096), res.Size)
+	assert.Equal(t, "sha256:"+digestOf(src.images[testImage]), res.ImageID)
+	assert.Equal(t, src.images[testImage], dst.images[testImage])
+}
+
+func TestTransferImage_UsesImageAlreadyOnSource(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+
+	_, err := TransferImage(context.Background(), src, dst, testImage)
+	require.NoError(t, err)
+	assert.Equal(t, 0, src.pulls)
+	assert.Equal(t, 1, dst.loads)
+}
+
+func TestTransferImage_SkipsTargetWithSameImage(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+	dst.images[testImage] = testVolumeData(1024)
+
+	res, err := TransferImage(context.Background(), src, dst, testImage)
+	require.NoError(t, err)
+	assert.True(t, res.Skipped)
+	assert.Equal(t, 0, dst.loads)
+}
+
+func TestTransferImage_ReplacesStaleTargetImage(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+	dst.images[testImage] = testVolumeData(512)
+
+	res, err := TransferImage(context.Background(), src, dst, testImage)
+	require.NoError(t, err)
+	assert.False(t, res.Skipped)
+	assert.Equal(t, src.images[testImage], dst.images[testImage])
+}
+
+func TestTransferImage_SourcePullFails(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+
+	_, err := TransferImage(context.Background(), src, dst, testImage)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrImageNotFound)
+	assert.Equal(t, 0, dst.loads)
+}
+
+func TestTransferImage_SourceFailsMidStream(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+	src.failSave = true
+
+	_, err := TransferImage(context.Background(), src, dst, testImage)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "export")
+}
+
+func TestTransferImage_ChecksumMismatchFails(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+	dst.misreport = true
+
+	_, err := TransferImage(context.Background(), src, dst, testImage)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrImageMismatch)
+}
+
+func TestTransferImage_ImageIDMismatchFails(t *testing.T) {
+	src, dst := newMemImageHost(), newMemImageHost()
+	src.images[testImage] = testVolumeData(1024)
+	dst.corrupt = true
+
+	_, err := TransferImage(context.Background(), src, dst, testImage)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrImageMismatch)
+	assert.Contains(t, err.Error(), "image ID")
+}
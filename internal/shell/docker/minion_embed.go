@@ -4,6 +4,8 @@ import (
 	"embed"
 	"fmt"
 	"runtime"
+
+	"github.com/artpar/hoster/internal/core/minion"
 )
 
 // Embedded minion binaries for Linux platforms.
@@ -15,6 +17,9 @@ import (
 // This will create:
 //   internal/shell/docker/binaries/minion-linux-amd64
 //   internal/shell/docker/binaries/minion-linux-arm64
+//
+// and, when HOSTER_MINION_SIGNING_KEY is set, their signatures
+// (minion-linux-amd64.sig, minion-linux-arm64.sig).
 
 //go:embed binaries/*
 var minionBinaries embed.FS
@@ -44,6 +49,16 @@ func GetMinionBinary(goos, goarch string) ([]byte, error) {
 	return data, nil
 }
 
+// GetMinionSignature returns the signature file of the minion binary for the
+// specified OS and architecture, or nil if the binary was built unsigned.
+func GetMinionSignature(goos, goarch string) []byte {
+	data, err := minionBinaries.ReadFile("binaries/minion-" + goos + "-" + goarch + minion.SignatureSuffix)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
 // GetMinionBinaryForCurrentPlatform returns the minion binary for the current platform.
 // Useful for testing on the local machine.
 func GetMinionBinaryForCurrentPlatform() ([]byte, error) {
@@ -52,4 +67,4 @@ func GetMinionBinaryForCurrentPlatform() ([]byte, error) {
 
 // MinionVersion is the version of the embedded minion binaries.
 // This should match the version in cmd/hoster-minion/main.go.
-var MinionVersion = "1.2.0"
+var MinionVersion = "1.20.0"
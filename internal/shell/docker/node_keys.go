@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/nodekey"
+)
+
+// =============================================================================
+// Node Keys
+// =============================================================================
+//
+// Node keys are the SSH keys hoster issues to a node. They are installed in
+// and removed from the node user's authorized_keys over the current
+// connection; each entry carries a nodekey.CommentPrefix comment so the
+// node's owner can tell hoster's keys from their own.
+
+// authorizedKeysFile is the node user's authorized_keys file.
+const authorizedKeysFile = `"$HOME/.ssh/authorized_keys"`
+
+// AuthorizeKey installs a node key's public key in the node's
+// authorized_keys, unless it is already there.
+func (c *SSHDockerClient) AuthorizeKey(ctx context.Context, publicKey, keyRefID string) error {
+	line, blob, err := nodekey.Entry(publicKey, keyRefID)
+	if err != nil {
+		return err
+	}
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	cmd := `mkdir -p "$HOME/.ssh" && chmod 700 "$HOME/.ssh" && touch ` + authorizedKeysFile +
+		` && chmod 600 ` + authorizedKeysFile +
+		` && { grep -qF '` + blob + `' ` + authorizedKeysFile + ` || printf '%s\n' '` + line + `' >> ` + authorizedKeysFile + `; }`
+	if _, err := c.runShell(ctx, cmd, 30*time.Second); err != nil {
+		return fmt.Errorf("authorize node key: %w", err)
+	}
+	return nil
+}
+
+// RemoveKey removes every authorized_keys entry of a public key from the
+// node. The file is rewritten in place so it keeps its owner and mode.
+func (c *SSHDockerClient) RemoveKey(ctx context.Context, publicKey string) error {
+	_, blob, err := nodekey.Entry(publicKey, "")
+	if err != nil {
+		return err
+	}
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	cmd := `f=` + authorizedKeysFile + `; [ -f "$f" ] || exit 0; ` +
+		`grep -vF '` + blob + `' "$f" > "$f.hoster"; cat "$f.hoster" > "$f" && rm -f "$f.hoster"`
+	if _, err := c.runShell(ctx, cmd, 30*time.Second); err != nil {
+		return fmt.Errorf("remove node key: %w", err)
+	}
+	return nil
+}
+
+// CheckKey connects to a node with a private key and disconnects, proving
+// the node accepts the key.
+func CheckKey(ctx context.Context, node *domain.Node, privateKey []byte, config SSHClientConfig) error {
+	client, err := NewSSHDockerClient(node, privateKey, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.connect(ctx)
+}
+
+// AuthorizeKey installs a node key on a node. Like AuditLog it does not
+// require the node to be available. Sandbox nodes have no SSH to install
+// keys in, so they accept any key.
+func (p *NodePool) AuthorizeKey(ctx context.Context, nodeID, publicKey, keyRefID string) error {
+	if p.sandbox {
+		return nil
+	}
+	client, err := p.VolumeEndpoint(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	return client.(*SSHDockerClient).AuthorizeKey(ctx, publicKey, keyRefID)
+}
+
+// RemoveKey removes a node key from a node.
+func (p *NodePool) RemoveKey(ctx context.Context, nodeID, publicKey string) error {
+	if p.sandbox {
+		return nil
+	}
+	client, err := p.VolumeEndpoint(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	return client.(*SSHDockerClient).RemoveKey(ctx, publicKey)
+}
+
+// CheckKey proves a node accepts a private key, over a connection of its own.
+func (p *NodePool) CheckKey(ctx context.Context, nodeID string, privateKey []byte) error {
+	if p.sandbox {
+		return nil
+	}
+	node, err := p.store.GetNode(ctx, nodeID)
+	if err != nil {
+		return fmt.Errorf("get node: %w", err)
+	}
+	return CheckKey(ctx, node, privateKey, p.config)
+}
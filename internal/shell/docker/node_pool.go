@@ -3,10 +3,12 @@ package docker
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/artpar/hoster/internal/core/crypto"
 	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
 )
 
 // NodeStore is the minimal store interface NodePool needs to look up nodes and SSH keys.
@@ -18,16 +20,25 @@ type NodeStore interface {
 // NodePool manages SSH Docker clients for remote nodes.
 // It provides lazy initialization and connection caching.
 type NodePool struct {
-	clients       map[string]*SSHDockerClient // nodeID -> client
+	clients       map[string]Client // nodeID -> client
 	store         NodeStore
 	encryptionKey []byte        // Key for decrypting SSH private keys
 	config        SSHClientConfig
 	mu            sync.RWMutex
+
+	sandbox   bool                      // Hand out sandbox clients instead of SSH clients
+	sandboxes map[string]*SandboxClient // nodeID -> sandbox, kept when its client is removed
+	sandboxMu sync.Mutex                // Protects sandboxes
 }
 
 // NodePoolConfig configures the node pool.
 type NodePoolConfig struct {
 	SSHClientConfig SSHClientConfig
+
+	// Sandbox replaces every node's Docker host with an in-memory
+	// SandboxClient, for development and tests without Docker or SSH.
+	// Nodes need no SSH key.
+	Sandbox bool
 }
 
 // DefaultNodePoolConfig returns the default configuration.
@@ -41,11 +52,32 @@ func DefaultNodePoolConfig() NodePoolConfig {
 // The encryptionKey is used to decrypt SSH private keys stored in the database.
 func NewNodePool(s NodeStore, encryptionKey []byte, config NodePoolConfig) *NodePool {
 	return &NodePool{
-		clients:       make(map[string]*SSHDockerClient),
+		clients:       make(map[string]Client),
 		store:         s,
 		encryptionKey: encryptionKey,
 		config:        config.SSHClientConfig,
+		sandbox:       config.Sandbox,
+		sandboxes:     make(map[string]*SandboxClient),
+	}
+}
+
+// Sandbox reports whether the pool hands out sandbox clients.
+func (p *NodePool) Sandbox() bool {
+	return p.sandbox
+}
+
+// sandboxClient returns a node's sandbox, creating it on first use. A node
+// keeps its sandbox, and so its containers, when its client is removed or
+// refreshed.
+func (p *NodePool) sandboxClient(nodeID string) *SandboxClient {
+	p.sandboxMu.Lock()
+	defer p.sandboxMu.Unlock()
+	s, ok := p.sandboxes[nodeID]
+	if !ok {
+		s = NewSandboxClient()
+		p.sandboxes[nodeID] = s
 	}
+	return s
 }
 
 // GetClient returns a Docker client for the given node ID.
@@ -81,6 +113,12 @@ func (p *NodePool) GetClient(ctx context.Context, nodeID string) (Client, error)
 		return nil, fmt.Errorf("node %s is not available (status: %s)", nodeID, node.Status)
 	}
 
+	if p.sandbox {
+		client = p.sandboxClient(nodeID)
+		p.clients[nodeID] = client
+		return client, nil
+	}
+
 	// Get SSH key from store
 	if node.SSHKeyID == 0 {
 		return nil, fmt.Errorf("node %s has no SSH key configured", nodeID)
@@ -131,6 +169,12 @@ func (p *NodePool) GetClientForNode(ctx context.Context, node *domain.Node, priv
 		return client, nil
 	}
 
+	if p.sandbox {
+		client = p.sandboxClient(node.ReferenceID)
+		p.clients[node.ReferenceID] = client
+		return client, nil
+	}
+
 	// Create SSH Docker client
 	client, err := NewSSHDockerClient(node, privateKey, p.config)
 	if err != nil {
@@ -208,6 +252,13 @@ func (p *NodePool) PingNode(ctx context.Context, nodeID string) error {
 		return fmt.Errorf("get node: %w", err)
 	}
 
+	if p.sandbox {
+		p.mu.Lock()
+		p.clients[nodeID] = p.sandboxClient(node.ReferenceID)
+		p.mu.Unlock()
+		return nil
+	}
+
 	if node.SSHKeyID == 0 {
 		return fmt.Errorf("node %s has no SSH key configured", nodeID)
 	}
@@ -241,6 +292,236 @@ func (p *NodePool) PingNode(ctx context.Context, nodeID string) error {
 	return nil
 }
 
+// NodeMetrics collects node-level metrics (load, disk, dockerd, journal errors)
+// from an available node via its minion.
+func (p *NodePool) NodeMetrics(ctx context.Context, nodeID string, opts minion.NodeMetricsOptions) (*minion.NodeMetrics, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	switch c := client.(type) {
+	case *SSHDockerClient:
+		return c.NodeMetrics(opts)
+	case *SandboxClient:
+		return c.NodeMetrics(opts)
+	}
+	return nil, fmt.Errorf("node %s client does not support node metrics", nodeID)
+}
+
+// NetworkAddresses detects an available node's private and public address via its minion.
+func (p *NodePool) NetworkAddresses(ctx context.Context, nodeID string, opts minion.NetworkAddressOptions) (*minion.NetworkAddresses, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	switch c := client.(type) {
+	case *SSHDockerClient:
+		return c.NetworkAddresses(ctx, opts)
+	case *SandboxClient:
+		return c.NetworkAddresses(ctx, opts)
+	}
+	return nil, fmt.Errorf("node %s client does not support network address detection", nodeID)
+}
+
+// DockerVersion returns the Docker versions a node's last successful ping
+// reported, or nil if it has no connected client.
+func (p *NodePool) DockerVersion(nodeID string) *minion.PingInfo {
+	p.mu.RLock()
+	client := p.clients[nodeID]
+	p.mu.RUnlock()
+	switch c := client.(type) {
+	case *SSHDockerClient:
+		return c.PingInfo()
+	case *SandboxClient:
+		return c.PingInfo()
+	}
+	return nil
+}
+
+// GPUInfo reports an available node's NVIDIA GPUs via its minion.
+func (p *NodePool) GPUInfo(ctx context.Context, nodeID string) (*minion.GPUInfo, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support gpu info", nodeID)
+	}
+	return sshClient.GPUInfo(ctx)
+}
+
+// OpenTunnel relays in to a port of a container on an available node, and
+// the container's replies to out, via the node's minion.
+func (p *NodePool) OpenTunnel(ctx context.Context, nodeID, containerID string, port int, in io.Reader, out io.Writer) error {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return fmt.Errorf("node %s client does not support tunnels", nodeID)
+	}
+	return sshClient.OpenTunnel(ctx, containerID, port, in, out)
+}
+
+// ExecContainer runs a one-off command in a container on an available node
+// via its minion.
+func (p *NodePool) ExecContainer(ctx context.Context, nodeID, containerID string, spec minion.ExecSpec) (*minion.ExecResult, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	switch c := client.(type) {
+	case *SSHDockerClient:
+		return c.ExecContainer(ctx, containerID, spec)
+	case *SandboxClient:
+		return c.ExecContainer(ctx, containerID, spec)
+	}
+	return nil, fmt.Errorf("node %s client does not support exec", nodeID)
+}
+
+// UpdateContainerCPU changes the CPU limit of a container on an available
+// node via its minion.
+func (p *NodePool) UpdateContainerCPU(ctx context.Context, nodeID, containerID string, cores float64) error {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	switch c := client.(type) {
+	case *SSHDockerClient:
+		return c.UpdateContainerCPU(ctx, containerID, cores)
+	case *SandboxClient:
+		return c.UpdateContainerCPU(ctx, containerID, cores)
+	}
+	return fmt.Errorf("node %s client does not support container updates", nodeID)
+}
+
+// WriteTraefikConfig writes a Traefik dynamic configuration file on an available node via its minion.
+func (p *NodePool) WriteTraefikConfig(ctx context.Context, nodeID string, in minion.TraefikConfigInput) (*minion.TraefikConfigResult, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support traefik config", nodeID)
+	}
+	return sshClient.WriteTraefikConfig(ctx, in)
+}
+
+// CheckpointSupport reports whether an available node can checkpoint and restore containers.
+func (p *NodePool) CheckpointSupport(ctx context.Context, nodeID string) (*minion.CheckpointSupport, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support checkpoints", nodeID)
+	}
+	return sshClient.CheckpointSupport(ctx)
+}
+
+// CheckpointEndpoint returns a node's client for checkpoint transfers. Like
+// VolumeEndpoint it does not require the node to be available.
+func (p *NodePool) CheckpointEndpoint(ctx context.Context, nodeID string) (CheckpointEndpoint, error) {
+	client, err := p.VolumeEndpoint(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, ok := client.(CheckpointEndpoint)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support checkpoints", nodeID)
+	}
+	return endpoint, nil
+}
+
+// Housekeeping runs (or previews) cleanup tasks on an available node via its minion.
+func (p *NodePool) Housekeeping(ctx context.Context, nodeID string, opts minion.HousekeepingOptions) (*minion.HousekeepingReport, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support housekeeping", nodeID)
+	}
+	return sshClient.Housekeeping(ctx, opts)
+}
+
+// AuditLog reads a node's minion command audit log. Like VolumeEndpoint it
+// does not require the node to be available, so a node in maintenance can
+// still be audited.
+func (p *NodePool) AuditLog(ctx context.Context, nodeID string, opts minion.AuditLogOptions) (*minion.AuditLog, error) {
+	client, err := p.VolumeEndpoint(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support audit logs", nodeID)
+	}
+	return sshClient.AuditLog(ctx, opts)
+}
+
+// VolumeUsage reports the bytes and files stored in a volume on an available node.
+func (p *NodePool) VolumeUsage(ctx context.Context, nodeID, volumeName string) (*minion.VolumeUsage, error) {
+	client, err := p.GetClient(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	sshClient, ok := client.(*SSHDockerClient)
+	if !ok {
+		return nil, fmt.Errorf("node %s client does not support volume usage", nodeID)
+	}
+	return sshClient.VolumeUsage(ctx, volumeName)
+}
+
+// VolumeEndpoint returns a node's client for volume transfers. Unlike GetClient
+// it does not require the node to be available, so data can still be moved off
+// a node that is draining or in maintenance.
+func (p *NodePool) VolumeEndpoint(ctx context.Context, nodeID string) (VolumeEndpoint, error) {
+	if p.sandbox {
+		return nil, fmt.Errorf("node %s client does not support volume transfers", nodeID)
+	}
+
+	p.mu.RLock()
+	client, exists := p.clients[nodeID]
+	p.mu.RUnlock()
+	if exists {
+		return client.(*SSHDockerClient), nil
+	}
+
+	node, err := p.store.GetNode(ctx, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node: %w", err)
+	}
+	if node.SSHKeyID == 0 {
+		return nil, fmt.Errorf("node %s has no SSH key configured", nodeID)
+	}
+	sshKey, err := p.store.GetSSHKey(ctx, node.SSHKeyRefID)
+	if err != nil {
+		return nil, fmt.Errorf("get SSH key: %w", err)
+	}
+	privateKey, err := crypto.DecryptSSHKey(sshKey.PrivateKeyEncrypted, p.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt SSH key: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client, exists := p.clients[nodeID]; exists {
+		return client.(*SSHDockerClient), nil
+	}
+	sshClient, err := NewSSHDockerClient(node, privateKey, p.config)
+	if err != nil {
+		return nil, fmt.Errorf("create SSH client: %w", err)
+	}
+	p.clients[nodeID] = sshClient
+	return sshClient, nil
+}
+
 // RefreshClient forces recreation of a client for the given node.
 // Useful when node configuration has changed.
 func (p *NodePool) RefreshClient(ctx context.Context, nodeID string) (Client, error) {
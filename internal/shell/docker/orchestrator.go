@@ -3,17 +3,25 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/artpar/hoster/internal/core/compose"
 	coredeployment "github.com/artpar/hoster/internal/core/deployment"
 	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
 	"github.com/artpar/hoster/internal/core/monitoring"
+	"github.com/artpar/hoster/internal/core/traefik"
 	"github.com/google/uuid"
 )
 
@@ -33,8 +41,40 @@ type Orchestrator struct {
 	logger    *slog.Logger
 	configDir string // Base directory for storing config files
 	store     StoreInterface
+	fetch     ImageFetcher // Optional; replaces registry pulls (air-gapped nodes)
+	// Optional; service -> checkpoint transfer ID to restore new containers from
+	checkpoints map[string]string
+	// Optional; routes primary containers through Traefik's Docker provider
+	traefikLabels *traefik.RouteOptions
+	// Pull image tags even when the node has them, to pick up new digests
+	pullLatest bool
+	// Optional; service -> GPU UUIDs exposed to its new containers
+	gpuDevices map[string][]string
+	// Services of one dependency level started at once; 0 for the default
+	startConcurrency int
+	// Optional; told how each StartDeployment went
+	startObserver func(StartReport)
+	// Address the deployment's bridge ports are published on; "" for all
+	// interfaces
+	bridgeAddress string
 }
 
+// DefaultStartConcurrency is how many services of one dependency level
+// StartDeployment starts at once unless SetStartConcurrency says otherwise.
+const DefaultStartConcurrency = 4
+
+// StartReport describes one StartDeployment, for start duration metrics.
+type StartReport struct {
+	Duration time.Duration
+	Services int
+	Levels   int   // Dependency levels the services were started in
+	Err      error // Why the start failed, nil if it succeeded
+}
+
+// ImageFetcher makes an image available on the orchestrator's Docker host by
+// some means other than a registry pull, e.g. TransferImage from a relay.
+type ImageFetcher func(ctx context.Context, image string) error
+
 // NewOrchestrator creates a new orchestrator.
 // configDir is the base directory for storing deployment config files.
 // store is optional - if nil, events will not be recorded.
@@ -53,6 +93,92 @@ func NewOrchestrator(docker Client, logger *slog.Logger, configDir string, store
 	}
 }
 
+// SetImageFetcher makes the orchestrator fetch missing images with f instead
+// of pulling them from a registry.
+func (o *Orchestrator) SetImageFetcher(f ImageFetcher) {
+	o.fetch = f
+}
+
+// CheckpointRestorer starts a created container from a checkpoint unpacked
+// on its node (experimental). SSHDockerClient implements it.
+type CheckpointRestorer interface {
+	RestoreCheckpoint(ctx context.Context, transferID, containerID string) error
+}
+
+// ImageResolver resolves image tags to repository digests, so containers
+// can be created from the exact image a tag pointed at when it was deployed.
+// SSHDockerClient and DockerClient implement it.
+type ImageResolver interface {
+	InspectImage(ctx context.Context, image string) (*minion.ImageExistsResult, error)
+	RegistryDigest(ctx context.Context, image string) (string, error)
+}
+
+// FileWriter writes files on the Docker host for bind mounting, when that
+// host is not this one. SSHDockerClient implements it.
+type FileWriter interface {
+	WriteFile(ctx context.Context, in minion.WriteFileInput) (*minion.WriteFileResult, error)
+}
+
+// SetCheckpoints makes StartDeployment start newly created containers of the
+// given services from their migrated checkpoints (service -> transfer ID).
+func (o *Orchestrator) SetCheckpoints(checkpoints map[string]string) {
+	o.checkpoints = checkpoints
+}
+
+// SetGPUDevices makes StartDeployment expose the given GPUs to newly created
+// containers of each service (service -> device UUIDs).
+func (o *Orchestrator) SetGPUDevices(devices map[string][]string) {
+	o.gpuDevices = devices
+}
+
+// SetPullLatest makes StartDeployment pull image tags the node already has,
+// so services that are not pinned to a digest get the one the tag points
+// at now.
+func (o *Orchestrator) SetPullLatest() {
+	o.pullLatest = true
+}
+
+// SetStartConcurrency bounds how many services of one dependency level
+// StartDeployment starts at once; 1 starts services one at a time.
+func (o *Orchestrator) SetStartConcurrency(n int) {
+	o.startConcurrency = n
+}
+
+// SetStartObserver makes StartDeployment report how each start went.
+func (o *Orchestrator) SetStartObserver(f func(StartReport)) {
+	o.startObserver = f
+}
+
+func (o *Orchestrator) concurrency() int {
+	if o.startConcurrency > 0 {
+		return o.startConcurrency
+	}
+	return DefaultStartConcurrency
+}
+
+func (o *Orchestrator) reportStart(r StartReport) {
+	if o.startObserver != nil {
+		o.startObserver(r)
+	}
+}
+
+// SetBridgeAddress makes StartDeployment publish the deployment's bridge
+// ports on addr, the node's private address, rather than on every
+// interface. Hostnames can't be bound, so they are ignored.
+func (o *Orchestrator) SetBridgeAddress(addr string) {
+	if net.ParseIP(addr) != nil {
+		o.bridgeAddress = addr
+	}
+}
+
+// SetTraefikLabels makes StartDeployment label each deployment's primary
+// container with its Traefik routes, and the containers of its TCP and UDP
+// streams with their stream routes. Labels are fixed when a container is
+// created, so later domain changes need the container recreated.
+func (o *Orchestrator) SetTraefikLabels(opts traefik.RouteOptions) {
+	o.traefikLabels = &opts
+}
+
 // =============================================================================
 // Start Deployment
 // =============================================================================
@@ -67,24 +193,28 @@ func (o *Orchestrator) StartDeployment(ctx context.Context, deployment *domain.D
 		"config_files", len(configFiles),
 	)
 
-	// 1. Write config files to disk
-	configMounts, err := o.writeConfigFiles(deployment.ReferenceID, configFiles)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write config files: %w", err)
-	}
-
-	// 2. Parse compose spec
+	// 1. Parse compose spec
 	parsedSpec, err := compose.ParseComposeSpec(composeSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse compose spec: %w", err)
 	}
+	return o.startSpec(ctx, deployment, parsedSpec, configFiles)
+}
 
+// startSpec creates and starts the containers of a parsed compose spec.
+func (o *Orchestrator) startSpec(ctx context.Context, deployment *domain.Deployment, parsedSpec *compose.ParsedSpec, configFiles []domain.ConfigFile) ([]domain.ContainerInfo, error) {
 	o.logger.Debug("parsed compose spec",
 		"services", len(parsedSpec.Services),
 		"networks", len(parsedSpec.Networks),
 		"volumes", len(parsedSpec.Volumes),
 	)
 
+	// 2. Render config files and write them to the node
+	configMounts, err := o.placeConfigFiles(ctx, deployment, deployment.ReferenceID, parsedSpec.Services, configFiles)
+	if err != nil {
+		return nil, err
+	}
+
 	// 2. Create network for deployment
 	networkName := coredeployment.NetworkName(deployment.ReferenceID)
 	networkID, err := o.createDeploymentNetwork(ctx, deployment.ReferenceID, networkName)
@@ -107,23 +237,14 @@ func (o *Orchestrator) StartDeployment(ctx context.Context, deployment *domain.D
 		o.logger.Debug("created volume", "volume_name", volumeName)
 	}
 
-	// 4. Pull images
+	// 4. Pull images, pinning each tag to a digest
+	images := make(map[string]pinnedImage, len(parsedSpec.Services))
 	for _, svc := range parsedSpec.Services {
-		if svc.Image == "" {
-			continue // Skip services with build (not supported yet)
-		}
-		exists, _ := o.docker.ImageExists(svc.Image)
-		if !exists {
-			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventImagePulling, svc.Image)
-			o.logger.Info("pulling image", "image", svc.Image)
-			if err := o.docker.PullImage(svc.Image, PullOptions{}); err != nil {
-				return nil, fmt.Errorf("failed to pull image %s: %w", svc.Image, err)
-			}
-			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventImagePulled, svc.Image)
-			o.logger.Info("pulled image", "image", svc.Image)
-		} else {
-			o.logger.Debug("image already exists", "image", svc.Image)
+		pinned, err := o.pinImage(ctx, deployment, svc)
+		if err != nil {
+			return nil, err
 		}
+		images[svc.Name] = pinned
 	}
 
 	// 5. Check for existing containers (restart case)
@@ -134,11 +255,8 @@ func (o *Orchestrator) StartDeployment(ctx context.Context, deployment *domain.D
 		},
 	})
 
-	// 6. Create and start containers (respecting depends_on order)
-	var containers []domain.ContainerInfo
-	createdContainers := make(map[string]string) // serviceName -> containerID
-
-	// Build map of existing containers by service name
+	// 6. Create and start containers level by level (respecting depends_on
+	// order), starting each level's services concurrently
 	existingByService := make(map[string]ContainerInfo)
 	for _, c := range existingContainers {
 		if svc, ok := c.Labels[LabelService]; ok {
@@ -147,87 +265,392 @@ func (o *Orchestrator) StartDeployment(ctx context.Context, deployment *domain.D
 	}
 
 	orderedServices := coredeployment.TopologicalSort(parsedSpec.Services)
+	servicesByName := make(map[string]compose.Service, len(parsedSpec.Services))
+	for _, svc := range parsedSpec.Services {
+		servicesByName[svc.Name] = svc
+	}
+
+	// Determine which service and port the deployment's ProxyPort is bound to.
+	// This is the x-hoster routing if set, otherwise the first service
+	// (in topological order) that has exposed ports.
+	primaryServiceName, proxyTarget, _ := compose.ProxyRoute(parsedSpec, orderedServices)
+
+	plan := &servicePlan{
+		deployment:  deployment,
+		networkName: networkName,
+		volumes:     parsedSpec.Volumes,
+		configMount: configMounts,
+		images:      images,
+		services:    servicesByName,
+		existing:    existingByService,
+		primary:     primaryServiceName,
+		proxyTarget: proxyTarget,
+	}
 
-	// Determine which service is the "primary" service for proxy port binding.
-	// The primary service is the first service (in topological order) that has exposed ports.
-	primaryServiceName := ""
-	for _, svc := range orderedServices {
-		if len(svc.Ports) > 0 {
-			primaryServiceName = svc.Name
+	began := time.Now()
+	levels := coredeployment.StartLevels(parsedSpec.Services)
+	createdContainers := make(map[string]string) // serviceName -> containerID
+	var containers []domain.ContainerInfo
+	for i, level := range levels {
+		started, err := o.startLevel(ctx, plan, level, createdContainers)
+		for _, r := range started {
+			if r.containerID != "" {
+				createdContainers[r.service] = r.containerID
+			}
+		}
+		if err != nil {
+			o.cleanupCreatedContainers(ctx, createdContainers)
+			_ = o.docker.RemoveNetwork(networkID)
+			if len(levels) > 1 {
+				err = fmt.Errorf("level %d of %d: %w", i+1, len(levels), err)
+			}
+			o.reportStart(StartReport{Duration: time.Since(began), Services: len(parsedSpec.Services), Levels: len(levels), Err: err})
+			return nil, err
+		}
+		for _, r := range started {
+			containers = append(containers, r.info)
+		}
+	}
+	duration := time.Since(began)
+	o.reportStart(StartReport{Duration: duration, Services: len(parsedSpec.Services), Levels: len(levels)})
+
+	o.logger.Info("deployment started",
+		"deployment_id", deployment.ReferenceID,
+		"containers", len(containers),
+		"levels", len(levels),
+		"duration", duration,
+	)
+
+	return containers, nil
+}
+
+// servicePlan is what starting a deployment's services needs to know,
+// shared read-only by the services of a level starting concurrently.
+type servicePlan struct {
+	deployment  *domain.Deployment
+	networkName string
+	volumes     []compose.Volume
+	configMount map[string]string
+	images      map[string]pinnedImage
+	services    map[string]compose.Service
+	existing    map[string]ContainerInfo // serviceName -> container from an earlier start
+	primary     string
+	proxyTarget uint32
+}
+
+// serviceStart is the outcome of starting one service. containerID is set
+// as soon as a container exists, so a failed start can be cleaned up.
+type serviceStart struct {
+	service     string
+	containerID string
+	info        domain.ContainerInfo
+}
+
+// startLevel starts one dependency level's services, at most
+// startConcurrency at a time, in the level's order. started holds the
+// containers of earlier levels, which the level's dependencies are among.
+// When a service fails, the services still waiting to start are skipped and
+// those starting are waited for; the error names every service that failed.
+func (o *Orchestrator) startLevel(ctx context.Context, plan *servicePlan, level []compose.Service, started map[string]string) ([]serviceStart, error) {
+	levelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]serviceStart, len(level))
+	errs := make([]error, len(level))
+	sem := make(chan struct{}, o.concurrency())
+	var wg sync.WaitGroup
+	for i, svc := range level {
+		results[i].service = svc.Name
+		select {
+		case sem <- struct{}{}:
+		case <-levelCtx.Done():
+		}
+		if levelCtx.Err() != nil {
 			break
 		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if err := o.startService(levelCtx, plan, svc, started, &results[i]); err != nil {
+				errs[i] = err
+				cancel()
+			}
+		}()
 	}
+	wg.Wait()
 
-	for _, svc := range orderedServices {
-		var containerID string
-		var err error
+	// Services interrupted because another one failed are not failures themselves
+	var failed []error
+	for _, err := range errs {
+		if err != nil && !(errors.Is(err, context.Canceled) && ctx.Err() == nil) {
+			failed = append(failed, err)
+		}
+	}
+	if ctx.Err() != nil && len(failed) == 0 {
+		failed = append(failed, ctx.Err())
+	}
+	return results, errors.Join(failed...)
+}
 
-		// Check if container already exists (restart case)
-		isRestart := false
-		if existing, found := existingByService[svc.Name]; found {
-			containerID = existing.ID
-			isRestart = true
-			o.logger.Debug("using existing container", "service", svc.Name, "container_id", containerID[:12])
-		} else {
-			// Create new container
-			containerName := coredeployment.ContainerName(deployment.ReferenceID, svc.Name)
-			isPrimaryService := svc.Name == primaryServiceName
-			spec := o.buildContainerSpec(deployment, svc, containerName, networkName, parsedSpec.Volumes, configMounts, isPrimaryService)
+// startService creates a service's container, or reuses the one left by an
+// earlier start, and starts it once its dependencies are ready.
+func (o *Orchestrator) startService(ctx context.Context, plan *servicePlan, svc compose.Service, started map[string]string, result *serviceStart) error {
+	deployment := plan.deployment
 
-			containerID, err = o.docker.CreateContainer(spec)
-			if err != nil {
-				// Cleanup on failure
-				o.cleanupCreatedContainers(ctx, createdContainers)
-				_ = o.docker.RemoveNetwork(networkID)
-				return nil, fmt.Errorf("failed to create container %s: %w", svc.Name, err)
+	// Block until dependencies with a depends_on condition are ready
+	if err := o.waitForDependencies(ctx, deployment, svc, plan.services, started); err != nil {
+		return err
+	}
+
+	// Check if container already exists (restart case)
+	var containerID string
+	isRestart := false
+	if existing, found := plan.existing[svc.Name]; found {
+		containerID = existing.ID
+		isRestart = true
+		o.logger.Debug("using existing container", "service", svc.Name, "container_id", containerID[:12])
+	} else {
+		// Create new container
+		containerName := coredeployment.ContainerName(deployment.ReferenceID, svc.Name)
+		var serviceProxyTarget uint32
+		if svc.Name == plan.primary {
+			serviceProxyTarget = plan.proxyTarget
+		}
+		spec := o.buildContainerSpec(deployment, svc, containerName, plan.networkName, plan.volumes, plan.configMount, serviceProxyTarget)
+		spec.Image = plan.images[svc.Name].ref
+		spec.Ports = append(spec.Ports, o.bridgeBindings(deployment, svc.Name)...)
+		if o.traefikLabels != nil {
+			if params, ok := o.traefikLabels.Params(deployment.ReferenceID, svc.Name, deployment.Domains, int(serviceProxyTarget)); ok {
+				maps.Copy(spec.Labels, traefik.GenerateLabels(params.WithRouting(deployment.RoutingOptions)))
+			}
+			for _, stream := range deployment.Streams {
+				if stream.Service != svc.Name {
+					continue
+				}
+				if params, ok := o.traefikLabels.StreamParams(deployment.ReferenceID, deployment.Domains, stream); ok {
+					maps.Copy(spec.Labels, traefik.GenerateLabels(params))
+				}
 			}
-			o.logger.Debug("created container", "service", svc.Name, "container_id", containerID[:12])
-			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerCreated, svc.Name)
 		}
 
-		createdContainers[svc.Name] = containerID
+		var err error
+		containerID, err = o.docker.CreateContainer(spec)
+		if err != nil {
+			return fmt.Errorf("failed to create container %s: %w", svc.Name, err)
+		}
+		o.logger.Debug("created container", "service", svc.Name, "container_id", containerID[:12])
+		o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerCreated, svc.Name)
+	}
+	result.containerID = containerID
 
-		// Start the container (works for both new and existing stopped containers)
+	// Start the container (works for both new and existing stopped containers),
+	// from its checkpoint if one was migrated with the deployment
+	if isRestart || !o.restoreCheckpoint(ctx, svc.Name, containerID) {
 		if err := o.docker.StartContainer(containerID); err != nil {
 			// Ignore error if already running
 			if !strings.Contains(err.Error(), "already started") && !strings.Contains(err.Error(), "is already running") {
-				o.cleanupCreatedContainers(ctx, createdContainers)
-				_ = o.docker.RemoveNetwork(networkID)
-				return nil, fmt.Errorf("failed to start container %s: %w", svc.Name, err)
+				return fmt.Errorf("failed to start container %s: %w", svc.Name, err)
 			}
 		}
-		o.logger.Debug("started container", "service", svc.Name, "container_id", containerID[:12])
+	}
+	o.logger.Debug("started container", "service", svc.Name, "container_id", containerID[:12])
 
-		// Record event: restarted if existing, started if new
-		if isRestart {
-			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerRestarted, svc.Name)
-		} else {
-			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerStarted, svc.Name)
+	// Record event: restarted if existing, started if new
+	if isRestart {
+		o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerRestarted, svc.Name)
+	} else {
+		o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerStarted, svc.Name)
+	}
+
+	// Get container info
+	info, err := o.docker.InspectContainer(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %w", svc.Name, err)
+	}
+	result.info = domain.ContainerInfo{
+		ID:          info.ID,
+		ServiceName: svc.Name,
+		Image:       svc.Image,
+		Digest:      plan.images[svc.Name].digest,
+		Status:      string(info.Status),
+		Ports:       o.convertPorts(info.Ports),
+	}
+	return nil
+}
+
+// pinnedImage is the reference a service's container is created from, and
+// the digest it pins, if any.
+type pinnedImage struct {
+	ref    string
+	digest string
+}
+
+// pinImage makes the service's image available and pins it to a digest. A
+// service the deployment already runs keeps its digest, so restarts and
+// migrations don't pick up whatever the tag points at now; otherwise the
+// tag is resolved to the digest just pulled. Images without a repository
+// digest, such as ones built locally or loaded onto air-gapped nodes, are
+// used by tag.
+func (o *Orchestrator) pinImage(ctx context.Context, deployment *domain.Deployment, svc compose.Service) (pinnedImage, error) {
+	unpinned := pinnedImage{ref: svc.Image}
+	resolver, ok := o.docker.(ImageResolver)
+	if svc.Image == "" || o.fetch != nil || !ok {
+		return unpinned, o.ensureImage(ctx, deployment, svc.Image)
+	}
+
+	if digest := deployment.ImageDigest(svc.Name, svc.Image); digest != "" && !coredeployment.IsDigestReference(svc.Image) {
+		pinned := pinnedImage{ref: coredeployment.PinnedImage(svc.Image, digest), digest: digest}
+		if err := o.ensureImage(ctx, deployment, pinned.ref); err != nil {
+			return pinnedImage{}, err
 		}
+		return pinned, nil
+	}
 
-		// Get container info
-		info, err := o.docker.InspectContainer(containerID)
-		if err != nil {
-			o.cleanupCreatedContainers(ctx, createdContainers)
-			_ = o.docker.RemoveNetwork(networkID)
-			return nil, fmt.Errorf("failed to inspect container %s: %w", svc.Name, err)
+	if err := o.ensureImage(ctx, deployment, svc.Image); err != nil {
+		return pinnedImage{}, err
+	}
+	info, err := resolver.InspectImage(ctx, svc.Image)
+	if err != nil {
+		return pinnedImage{}, fmt.Errorf("failed to inspect image %s: %w", svc.Image, err)
+	}
+	digest, ok := coredeployment.RepoDigest(svc.Image, info.RepoDigests)
+	if !ok {
+		o.logger.Debug("image has no repository digest, using tag", "image", svc.Image)
+		return unpinned, nil
+	}
+	pinned := pinnedImage{ref: svc.Image, digest: digest}
+	if !coredeployment.IsDigestReference(svc.Image) {
+		pinned.ref = coredeployment.PinnedImage(svc.Image, digest)
+	}
+	return pinned, nil
+}
+
+// ensureImage pulls (or fetches) an image if the node lacks it.
+func (o *Orchestrator) ensureImage(ctx context.Context, deployment *domain.Deployment, image string) error {
+	if image == "" {
+		return nil // Skip services with build (not supported yet)
+	}
+	exists, _ := o.docker.ImageExists(image)
+	if exists && !(o.pullLatest && !coredeployment.IsDigestReference(image)) {
+		o.logger.Debug("image already exists", "image", image)
+		return nil
+	}
+	o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventImagePulling, image)
+	o.logger.Info("pulling image", "image", image)
+	if o.fetch != nil {
+		if err := o.fetch(ctx, image); err != nil {
+			return fmt.Errorf("failed to fetch image %s: %w", image, err)
 		}
+	} else if err := o.docker.PullImage(image, PullOptions{}); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventImagePulled, image)
+	o.logger.Info("pulled image", "image", image)
+	return nil
+}
 
-		containers = append(containers, domain.ContainerInfo{
-			ID:          info.ID,
-			ServiceName: svc.Name,
-			Image:       svc.Image,
-			Status:      string(info.Status),
-			Ports:       o.convertPorts(info.Ports),
-		})
+// =============================================================================
+// Dependency Conditions
+// =============================================================================
+
+// dependencyPollInterval is how often a dependency's container is inspected
+// while a dependent service waits for its depends_on condition.
+var dependencyPollInterval = 5 * time.Second
+
+// restoreCheckpoint starts a new container from its service's migrated
+// checkpoint. It reports false when there is none or the restore failed, in
+// which case the caller starts the container fresh.
+func (o *Orchestrator) restoreCheckpoint(ctx context.Context, service, containerID string) bool {
+	transferID, ok := o.checkpoints[service]
+	if !ok {
+		return false
+	}
+	restorer, ok := o.docker.(CheckpointRestorer)
+	if !ok {
+		o.logger.Warn("docker client cannot restore checkpoints, starting fresh", "service", service)
+		return false
+	}
+	if err := restorer.RestoreCheckpoint(ctx, transferID, containerID); err != nil {
+		o.logger.Warn("checkpoint restore failed, starting fresh", "service", service, "error", err)
+		return false
+	}
+	o.logger.Info("restored container from checkpoint", "service", service, "transfer_id", transferID)
+	return true
+}
+
+// waitForDependencies blocks until every service_healthy and
+// service_completed_successfully dependency of svc meets its condition.
+// service_started needs no wait: dependencies are started in topological order.
+func (o *Orchestrator) waitForDependencies(ctx context.Context, deployment *domain.Deployment, svc compose.Service, services map[string]compose.Service, started map[string]string) error {
+	deps := make([]string, 0, len(svc.DependsOnConditions))
+	for dep, cond := range svc.DependsOnConditions {
+		if cond != compose.ConditionServiceStarted {
+			deps = append(deps, dep)
+		}
 	}
+	sort.Strings(deps)
 
-	o.logger.Info("deployment started",
-		"deployment_id", deployment.ReferenceID,
-		"containers", len(containers),
-	)
+	for _, dep := range deps {
+		cond := svc.DependsOnConditions[dep]
+		containerID, ok := started[dep]
+		if !ok {
+			return fmt.Errorf("service %s depends on %s, which was not started", svc.Name, dep)
+		}
+		timeout := coredeployment.DependencyTimeout(cond, services[dep])
+
+		o.logger.Info("waiting for dependency",
+			"deployment_id", deployment.ReferenceID,
+			"service", svc.Name,
+			"dependency", dep,
+			"condition", cond,
+			"timeout", timeout,
+		)
+		if err := o.waitForDependency(ctx, dep, containerID, cond, timeout); err != nil {
+			if cond == compose.ConditionServiceHealthy {
+				o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventHealthUnhealthy, dep)
+			}
+			return fmt.Errorf("service %s: dependency %s (%s): %w", svc.Name, dep, cond, err)
+		}
+		if cond == compose.ConditionServiceHealthy {
+			o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventHealthHealthy, dep)
+		}
+	}
+	return nil
+}
 
-	return containers, nil
+// waitForDependency polls a dependency's container until the condition holds,
+// fails, or the timeout passes.
+func (o *Orchestrator) waitForDependency(ctx context.Context, name, containerID string, cond compose.DependencyCondition, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(dependencyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := o.docker.InspectContainer(containerID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect container %s: %w", name, err)
+		}
+		result, reason := coredeployment.EvaluateDependency(cond, coredeployment.DependencyState{
+			Status:   string(info.Status),
+			Health:   info.Health,
+			ExitCode: info.ExitCode,
+		})
+		switch result {
+		case coredeployment.DependencySatisfied:
+			return nil
+		case coredeployment.DependencyFailed:
+			return errors.New(reason)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s (status %s, health %q)", timeout, info.Status, info.Health)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
 // =============================================================================
@@ -383,6 +806,163 @@ func (o *Orchestrator) RemoveDeployment(ctx context.Context, deployment *domain.
 	return nil
 }
 
+// =============================================================================
+// Replicas
+// =============================================================================
+
+// StartReplica starts a spread deployment's replica on this orchestrator's
+// node, which is one of the deployment's extra nodes: the replicated services
+// in replica.Services, and relays to the deployment's other services on
+// address, its node's private address. The routed service, if the node runs
+// it, is bound to replica.ProxyPort. Replicas get no streams, and Traefik
+// routes only if SetTraefikLabels was called; StopDeployment and RemoveDeployment stop and remove them like any
+// deployment.
+func (o *Orchestrator) StartReplica(ctx context.Context, deployment *domain.Deployment, composeSpec string, configFiles []domain.ConfigFile, replica domain.ReplicaNode, address string) ([]domain.ContainerInfo, error) {
+	o.logger.Info("starting replica",
+		"deployment_id", deployment.ReferenceID,
+		"node_id", replica.NodeID,
+		"services", replica.Services,
+	)
+
+	parsedSpec, err := compose.ParseComposeSpec(composeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compose spec: %w", err)
+	}
+
+	depl := *deployment
+	depl.ProxyPort = replica.ProxyPort
+	depl.Streams = nil
+	depl.Bridges = nil
+	return o.startSpec(ctx, &depl, coredeployment.ReplicaSpec(parsedSpec, replica.Services, deployment.Bridges, address), configFiles)
+}
+
+// bridgeBindings returns the port bindings publishing a service's bridge
+// ports for the deployment's replica nodes.
+func (o *Orchestrator) bridgeBindings(deployment *domain.Deployment, service string) []PortBinding {
+	var bindings []PortBinding
+	for _, b := range deployment.Bridges {
+		if b.Service != service || b.HostPort == 0 {
+			continue
+		}
+		bindings = append(bindings, PortBinding{
+			ContainerPort: b.Port,
+			HostPort:      b.HostPort,
+			Protocol:      "tcp",
+			HostIP:        o.bridgeAddress,
+		})
+	}
+	return bindings
+}
+
+// =============================================================================
+// Canary
+// =============================================================================
+
+// StartCanary starts the new version of the deployment's routed service next
+// to its running containers, with the routed port bound to canaryPort. The
+// canary joins the deployment network without the service alias, so the
+// other services keep talking to the stable container, and it mounts the
+// same volumes. Its container is labelled with the canary ID, so
+// RemoveDeployment leaves it running and RemoveCanary removes it.
+func (o *Orchestrator) StartCanary(ctx context.Context, deployment *domain.Deployment, composeSpec string, configFiles []domain.ConfigFile, canaryPort int) (domain.ContainerInfo, error) {
+	canaryID := coredeployment.CanaryID(deployment.ReferenceID)
+	o.logger.Info("starting canary", "deployment_id", deployment.ReferenceID, "canary_port", canaryPort)
+
+	parsedSpec, err := compose.ParseComposeSpec(composeSpec)
+	if err != nil {
+		return domain.ContainerInfo{}, fmt.Errorf("failed to parse compose spec: %w", err)
+	}
+	orderedServices := coredeployment.TopologicalSort(parsedSpec.Services)
+	serviceName, proxyTarget, ok := compose.ProxyRoute(parsedSpec, orderedServices)
+	if !ok || proxyTarget == 0 {
+		return domain.ContainerInfo{}, fmt.Errorf("compose spec has no routed service")
+	}
+	var svc compose.Service
+	for _, s := range orderedServices {
+		if s.Name == serviceName {
+			svc = s
+		}
+	}
+	if svc.Name == "" {
+		return domain.ContainerInfo{}, fmt.Errorf("routed service %s not found", serviceName)
+	}
+
+	image, err := o.pinImage(ctx, deployment, svc)
+	if err != nil {
+		return domain.ContainerInfo{}, err
+	}
+	// Remove a canary left behind by an earlier attempt
+	if err := o.RemoveCanary(ctx, deployment); err != nil {
+		return domain.ContainerInfo{}, err
+	}
+	configMounts, err := o.placeConfigFiles(ctx, deployment, canaryID, parsedSpec.Services, configFiles)
+	if err != nil {
+		return domain.ContainerInfo{}, err
+	}
+
+	canary := *deployment
+	canary.ProxyPort = canaryPort
+	networkName := coredeployment.NetworkName(deployment.ReferenceID)
+	spec := o.buildContainerSpec(&canary, svc, coredeployment.ContainerName(canaryID, svc.Name), networkName, parsedSpec.Volumes, configMounts, proxyTarget)
+	spec.Image = image.ref
+	spec.Labels[LabelDeployment] = canaryID
+	spec.NetworkAliases = nil
+
+	containerID, err := o.docker.CreateContainer(spec)
+	if err != nil {
+		return domain.ContainerInfo{}, fmt.Errorf("failed to create canary container %s: %w", svc.Name, err)
+	}
+	if err := o.docker.StartContainer(containerID); err != nil {
+		_ = o.docker.RemoveContainer(containerID, RemoveOptions{Force: true})
+		return domain.ContainerInfo{}, fmt.Errorf("failed to start canary container %s: %w", svc.Name, err)
+	}
+	o.recordEvent(ctx, deployment.ID, deployment.ReferenceID, domain.EventContainerStarted, svc.Name+" (canary)")
+
+	info, err := o.docker.InspectContainer(containerID)
+	if err != nil {
+		_ = o.docker.RemoveContainer(containerID, RemoveOptions{Force: true})
+		return domain.ContainerInfo{}, fmt.Errorf("failed to inspect canary container %s: %w", svc.Name, err)
+	}
+	return domain.ContainerInfo{
+		ID:          info.ID,
+		ServiceName: svc.Name,
+		Image:       svc.Image,
+		Digest:      image.digest,
+		Status:      string(info.Status),
+		Ports:       o.convertPorts(info.Ports),
+	}, nil
+}
+
+// RemoveCanary removes the deployment's canary container and config files,
+// if any. The deployment's network and volumes are left alone.
+func (o *Orchestrator) RemoveCanary(ctx context.Context, deployment *domain.Deployment) error {
+	canaryID := coredeployment.CanaryID(deployment.ReferenceID)
+	containers, err := o.docker.ListContainers(ListOptions{
+		All: true,
+		Filters: map[string]string{
+			"label": fmt.Sprintf("%s=%s", LabelDeployment, canaryID),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list canary containers: %w", err)
+	}
+
+	timeout := 10 * time.Second
+	for _, c := range containers {
+		if c.Status == ContainerStatusRunning {
+			_ = o.docker.StopContainer(c.ID, &timeout)
+		}
+		if err := o.docker.RemoveContainer(c.ID, RemoveOptions{Force: true, RemoveVolumes: false}); err != nil {
+			return fmt.Errorf("failed to remove canary container %s: %w", c.Name, err)
+		}
+		o.logger.Debug("removed canary container", "deployment_id", deployment.ReferenceID, "container_id", c.ID[:12])
+	}
+	if o.configDir == "" {
+		return nil
+	}
+	return o.CleanupConfigFiles(canaryID)
+}
+
 // =============================================================================
 // Get Container Logs
 // =============================================================================
@@ -452,8 +1032,9 @@ func (o *Orchestrator) createDeploymentVolume(ctx context.Context, deploymentID,
 
 // buildContainerSpec builds a ContainerSpec from a compose service.
 // configMounts maps container paths to host file paths for config file bind mounts.
-// isPrimaryService indicates if this service should use the deployment's ProxyPort.
-func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc compose.Service, containerName, networkName string, volumes []compose.Volume, configMounts map[string]string, isPrimaryService bool) ContainerSpec {
+// proxyTarget is the container port bound to the deployment's ProxyPort, or 0
+// if this service is not the one the App Proxy routes to.
+func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc compose.Service, containerName, networkName string, volumes []compose.Volume, configMounts map[string]string, proxyTarget uint32) ContainerSpec {
 	spec := ContainerSpec{
 		Name:       containerName,
 		Image:      svc.Image,
@@ -467,7 +1048,7 @@ func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc com
 			LabelService:    svc.Name,
 		},
 		Networks:       []string{networkName},
-		NetworkAliases: map[string][]string{networkName: {svc.Name}},
+		NetworkAliases: map[string][]string{networkName: append([]string{svc.Name}, svc.Aliases...)},
 	}
 
 	// Merge environment: service env + deployment variables
@@ -476,15 +1057,16 @@ func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc com
 	}
 
 	// Port bindings
-	// If this is the primary service and deployment has a ProxyPort, bind the first
-	// exposed port to the ProxyPort on localhost (for App Proxy routing)
+	// If this is the primary service and deployment has a ProxyPort, bind the
+	// routed container port to the ProxyPort (for App Proxy routing)
+	bindProxy := proxyTarget > 0 && deployment.ProxyPort > 0
 	proxyPortUsed := false
 	for _, p := range svc.Ports {
 		hostPort := int(p.Published)
 		hostIP := p.HostIP
 
-		// Use ProxyPort for primary service's first exposed port
-		if isPrimaryService && deployment.ProxyPort > 0 && !proxyPortUsed {
+		// Use ProxyPort for primary service's routed port
+		if bindProxy && p.Target == proxyTarget && !proxyPortUsed {
 			hostPort = deployment.ProxyPort
 			hostIP = "0.0.0.0"
 			proxyPortUsed = true
@@ -502,6 +1084,15 @@ func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc com
 			HostIP:        hostIP,
 		})
 	}
+	// An x-hoster routed port need not be listed under the service's ports
+	if bindProxy && !proxyPortUsed {
+		spec.Ports = append(spec.Ports, PortBinding{
+			ContainerPort: int(proxyTarget),
+			HostPort:      deployment.ProxyPort,
+			Protocol:      "tcp",
+			HostIP:        "0.0.0.0",
+		})
+	}
 
 	// Volume mounts
 	for _, v := range svc.Volumes {
@@ -549,13 +1140,35 @@ func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc com
 		}
 	}
 
-	// Resource limits
+	// Resource limits, with the customer's overrides applied
 	if svc.Resources.CPULimit > 0 {
 		spec.Resources.CPULimit = svc.Resources.CPULimit
 	}
 	if svc.Resources.MemoryLimit > 0 {
 		spec.Resources.MemoryLimit = svc.Resources.MemoryLimit
 	}
+	if o, ok := deployment.ServiceOverrides[svc.Name]; ok {
+		if o.CPUCores > 0 {
+			spec.Resources.CPULimit = o.CPUCores
+		}
+		if o.MemoryMB > 0 {
+			spec.Resources.MemoryLimit = o.MemoryMB * 1024 * 1024
+		}
+	}
+	spec.Resources.GPUDeviceIDs = o.gpuDevices[svc.Name]
+
+	// Init process, ulimits and device cgroup rules
+	spec.Init = svc.Init
+	for _, name := range slices.Sorted(maps.Keys(svc.Ulimits)) {
+		u := svc.Ulimits[name]
+		spec.Ulimits = append(spec.Ulimits, Ulimit{Name: name, Soft: u.Soft, Hard: u.Hard})
+	}
+	spec.DeviceCgroupRules = svc.DeviceCgroupRules
+
+	// Extra /etc/hosts entries
+	for _, h := range svc.ExtraHosts {
+		spec.ExtraHosts = append(spec.ExtraHosts, h.String())
+	}
 
 	// Restart policy
 	switch svc.Restart {
@@ -574,6 +1187,9 @@ func (o *Orchestrator) buildContainerSpec(deployment *domain.Deployment, svc com
 		spec.Labels[k] = v
 	}
 
+	// Customer labels, namespaced so they can't shadow the ones above
+	maps.Copy(spec.Labels, deployment.ContainerLabels())
+
 	return spec
 }
 
@@ -649,6 +1265,55 @@ func (o *Orchestrator) RefreshContainerInfo(ctx context.Context, deployment *dom
 // Config File Management
 // =============================================================================
 
+// placeConfigFiles renders the config files marked as templates and writes
+// them all to the Docker host, through its minion if the client is a
+// FileWriter and to configDir otherwise. dir names the set of files, e.g.
+// the deployment or its canary. It returns a map of container paths to host
+// paths for bind mounting.
+func (o *Orchestrator) placeConfigFiles(ctx context.Context, deployment *domain.Deployment, dir string, services []compose.Service, configFiles []domain.ConfigFile) (map[string]string, error) {
+	rendered, err := coredeployment.RenderConfigFiles(configFiles, coredeployment.NewConfigData(deployment, services))
+	if err != nil {
+		return nil, err
+	}
+	writer, ok := o.docker.(FileWriter)
+	if !ok {
+		mounts, err := o.writeConfigFiles(dir, rendered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write config files: %w", err)
+		}
+		return mounts, nil
+	}
+
+	mounts := make(map[string]string, len(rendered))
+	for _, cf := range rendered {
+		in := minion.WriteFileInput{Dir: dir, Name: configFileHostName(cf), Content: cf.Content, Mode: configFileMode(cf)}
+		res, err := writer.WriteFile(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write config file %s: %w", cf.Name, err)
+		}
+		o.logger.Debug("wrote config file on node", "name", cf.Name, "host_path", res.Path, "container_path", cf.Path, "changed", res.Changed)
+		mounts[cf.Path] = res.Path
+	}
+	return mounts, nil
+}
+
+// configFileHostName returns the name a config file is stored under on the host.
+func configFileHostName(cf domain.ConfigFile) string {
+	if name := sanitizeFileName(cf.Name); name != "" {
+		return name
+	}
+	return sanitizeFileName(filepath.Base(cf.Path))
+}
+
+// configFileMode parses a config file's octal permission bits, defaulting to 0644.
+func configFileMode(cf domain.ConfigFile) uint32 {
+	var mode uint32
+	if _, err := fmt.Sscanf(cf.Mode, "%o", &mode); err != nil || mode == 0 {
+		return 0644
+	}
+	return mode & 0o777
+}
+
 // writeConfigFiles writes config files to the host filesystem and returns a map
 // of container paths to host paths for bind mounting.
 func (o *Orchestrator) writeConfigFiles(deploymentID string, configFiles []domain.ConfigFile) (map[string]string, error) {
@@ -676,21 +1341,8 @@ func (o *Orchestrator) writeConfigFiles(deploymentID string, configFiles []domai
 
 	for _, cf := range configFiles {
 		// Sanitize the config file name for the host filesystem
-		// Use a hash or sanitized version of the path as the filename
-		hostFileName := sanitizeFileName(cf.Name)
-		if hostFileName == "" {
-			hostFileName = sanitizeFileName(filepath.Base(cf.Path))
-		}
-		hostPath := filepath.Join(deploymentDir, hostFileName)
-
-		// Parse file mode (default to 0644)
-		fileMode := os.FileMode(0644)
-		if cf.Mode != "" {
-			var mode uint32
-			if _, err := fmt.Sscanf(cf.Mode, "%o", &mode); err == nil {
-				fileMode = os.FileMode(mode)
-			}
-		}
+		hostPath := filepath.Join(deploymentDir, configFileHostName(cf))
+		fileMode := os.FileMode(configFileMode(cf))
 
 		// Write the config file
 		if err := os.WriteFile(hostPath, []byte(cf.Content), fileMode); err != nil {
@@ -1,13 +1,23 @@
 package docker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/artpar/hoster/internal/core/compose"
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
 	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/traefik"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -200,7 +210,631 @@ func TestCleanupConfigFiles_NonExistent(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// =============================================================================
+// Dependency Condition Tests
+// =============================================================================
+
+// inspectClient returns a sequence of container states from InspectContainer.
+// Other Client methods are not used by the dependency wait and panic if called.
+type inspectClient struct {
+	Client
+	states []ContainerInfo
+	calls  int
+}
+
+func (c *inspectClient) InspectContainer(_ string) (*ContainerInfo, error) {
+	info := c.states[min(c.calls, len(c.states)-1)]
+	c.calls++
+	return &info, nil
+}
+
+func withFastDependencyPoll(t *testing.T) {
+	prev := dependencyPollInterval
+	dependencyPollInterval = time.Millisecond
+	t.Cleanup(func() { dependencyPollInterval = prev })
+}
+
+func TestWaitForDependency_Healthy(t *testing.T) {
+	withFastDependencyPoll(t)
+	client := &inspectClient{states: []ContainerInfo{
+		{Status: ContainerStatusCreated},
+		{Status: ContainerStatusRunning, Health: "starting"},
+		{Status: ContainerStatusRunning, Health: "healthy"},
+	}}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+
+	err := o.waitForDependency(context.Background(), "db", "c1", compose.ConditionServiceHealthy, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 3, client.calls)
+}
+
+func TestWaitForDependency_Failures(t *testing.T) {
+	withFastDependencyPoll(t)
+
+	o := &Orchestrator{logger: setupTestLogger()}
+	o.docker = &inspectClient{states: []ContainerInfo{{Status: ContainerStatusRunning, Health: "unhealthy"}}}
+	err := o.waitForDependency(context.Background(), "db", "c1", compose.ConditionServiceHealthy, time.Minute)
+	assert.EqualError(t, err, "container is unhealthy")
+
+	o.docker = &inspectClient{states: []ContainerInfo{{Status: ContainerStatusExited, ExitCode: 1}}}
+	err = o.waitForDependency(context.Background(), "migrate", "c2", compose.ConditionServiceCompletedSuccessfully, time.Minute)
+	assert.EqualError(t, err, "container exited with code 1")
+
+	o.docker = &inspectClient{states: []ContainerInfo{{Status: ContainerStatusRunning, Health: "starting"}}}
+	err = o.waitForDependency(context.Background(), "db", "c1", compose.ConditionServiceHealthy, 5*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out after 5ms")
+}
+
+func TestWaitForDependencies_PropagatesServiceAndDependency(t *testing.T) {
+	withFastDependencyPoll(t)
+	o := &Orchestrator{
+		docker: &inspectClient{states: []ContainerInfo{{Status: ContainerStatusExited, ExitCode: 2}}},
+		logger: setupTestLogger(),
+	}
+	web := compose.Service{
+		Name:      "web",
+		DependsOn: []string{"cache", "migrate"},
+		DependsOnConditions: map[string]compose.DependencyCondition{
+			"cache":   compose.ConditionServiceStarted,
+			"migrate": compose.ConditionServiceCompletedSuccessfully,
+		},
+	}
+	services := map[string]compose.Service{"web": web, "cache": {Name: "cache"}, "migrate": {Name: "migrate"}}
+
+	err := o.waitForDependencies(context.Background(), &domain.Deployment{ReferenceID: "depl_1"}, web, services,
+		map[string]string{"cache": "c1", "migrate": "c2"})
+	assert.EqualError(t, err, "service web: dependency migrate (service_completed_successfully): container exited with code 2")
+}
+
+// =============================================================================
+// Canary Tests
+// =============================================================================
+
+// canaryClient records created containers and lists them back by label.
+type canaryClient struct {
+	Client
+	mu      sync.Mutex
+	created []ContainerSpec
+	removed []string
+}
+
+func (c *canaryClient) ImageExists(_ string) (bool, error) { return true, nil }
+
+func (c *canaryClient) CreateContainer(spec ContainerSpec) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, spec)
+	return "canary000000001", nil
+}
+
+func (c *canaryClient) StartContainer(_ string) error { return nil }
+
+func (c *canaryClient) InspectContainer(id string) (*ContainerInfo, error) {
+	return &ContainerInfo{ID: id, Status: ContainerStatusRunning}, nil
+}
+
+func (c *canaryClient) ListContainers(opts ListOptions) ([]ContainerInfo, error) {
+	var out []ContainerInfo
+	for _, spec := range c.created {
+		if opts.Filters["label"] == LabelDeployment+"="+spec.Labels[LabelDeployment] {
+			out = append(out, ContainerInfo{ID: "canary000000001", Name: spec.Name, Status: ContainerStatusRunning})
+		}
+	}
+	return out, nil
+}
+
+func (c *canaryClient) StopContainer(_ string, _ *time.Duration) error { return nil }
+
+func (c *canaryClient) RemoveContainer(id string, _ RemoveOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removed = append(c.removed, id)
+	return nil
+}
+
+func TestStartCanary(t *testing.T) {
+	client := &canaryClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	depl := &domain.Deployment{ReferenceID: "depl_1", ProxyPort: 30001}
+	spec := `
+services:
+  web:
+    image: app:2
+    ports:
+      - "8080:80"
+    volumes:
+      - data:/data
+    depends_on: [db]
+  db:
+    image: postgres:16
+volumes:
+  data:
+`
+
+	info, err := o.StartCanary(context.Background(), depl, spec, nil, 30002)
+	require.NoError(t, err)
+	assert.Equal(t, "web", info.ServiceName)
+	assert.Equal(t, "app:2", info.Image)
+
+	require.Len(t, client.created, 1)
+	created := client.created[0]
+	assert.Equal(t, "hoster_depl_1-canary_web", created.Name)
+	assert.Equal(t, "depl_1-canary", created.Labels[LabelDeployment])
+	assert.Equal(t, []string{"hoster_depl_1"}, created.Networks)
+	assert.Empty(t, created.NetworkAliases)
+	assert.Equal(t, 30002, created.Ports[0].HostPort)
+	assert.Equal(t, "hoster_depl_1_data", created.Volumes[0].Source)
+	assert.Equal(t, 30001, depl.ProxyPort)
+
+	require.NoError(t, o.RemoveCanary(context.Background(), depl))
+	assert.Equal(t, []string{"canary000000001"}, client.removed)
+}
+
+// =============================================================================
+// Traefik Label Tests
+// =============================================================================
+
+// traefikClient also creates the networks StartDeployment needs.
+type traefikClient struct {
+	canaryClient
+}
+
+func (c *traefikClient) CreateNetwork(_ NetworkSpec) (string, error) { return "net000000000001", nil }
+
+func TestStartDeployment_TraefikLabels(t *testing.T) {
+	client := &traefikClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	o.SetTraefikLabels(traefik.RouteOptions{EnableTLS: true})
+	depl := &domain.Deployment{
+		ReferenceID: "depl_1",
+		ProxyPort:   30001,
+		Domains: []domain.Domain{
+			{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto},
+			{Hostname: "blog.example.com", Type: domain.DomainTypeCustom, VerificationStatus: domain.DomainVerificationPending},
+		},
+	}
+	spec := `
+services:
+  web:
+    image: app:1
+    ports:
+      - "8080:80"
+  db:
+    image: postgres:16
+`
+
+	_, err := o.StartDeployment(context.Background(), depl, spec, nil)
+	require.NoError(t, err)
+
+	labels := map[string]map[string]string{}
+	for _, c := range client.created {
+		labels[c.Labels[LabelService]] = c.Labels
+	}
+	require.Len(t, labels, 2)
+	assert.Equal(t, "true", labels["web"]["traefik.enable"])
+	assert.Equal(t, "Host(`blog.apps.hoster.io`)", labels["web"]["traefik.http.routers.depl_1-web-secure.rule"])
+	assert.Equal(t, "80", labels["web"]["traefik.http.services.depl_1-web.loadbalancer.server.port"])
+	assert.NotContains(t, labels["db"], "traefik.enable")
+}
+
+// =============================================================================
+// Config File Rendering Tests
+// =============================================================================
+
+// fileWriterClient writes config files through the minion, as SSHDockerClient does.
+type fileWriterClient struct {
+	traefikClient
+	written []minion.WriteFileInput
+}
+
+func (c *fileWriterClient) WriteFile(_ context.Context, in minion.WriteFileInput) (*minion.WriteFileResult, error) {
+	c.written = append(c.written, in)
+	return &minion.WriteFileResult{Path: "/home/hoster/.hoster/configs/" + in.Dir + "/" + in.Name, Changed: true, Bytes: len(in.Content)}, nil
+}
+
+func TestStartDeployment_RendersConfigFilesOnNode(t *testing.T) {
+	client := &fileWriterClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger(), configDir: t.TempDir()}
+	depl := &domain.Deployment{
+		ReferenceID: "depl_1",
+		Variables:   map[string]string{"UPSTREAM_PORT": "3000"},
+		Domains:     []domain.Domain{{Hostname: "blog.apps.hoster.io", Type: domain.DomainTypeAuto}},
+	}
+	spec := `
+services:
+  web:
+    image: nginx:1
+  app:
+    image: app:1
+`
+	files := []domain.ConfigFile{
+		{Name: "nginx.conf", Path: "/etc/nginx/nginx.conf", Mode: "0600", Template: true,
+			Content: `server_name {{ .Domain }}; proxy_pass http://{{ .ServiceHost "app" }}:{{ .Variables.UPSTREAM_PORT }};`},
+	}
+
+	_, err := o.StartDeployment(context.Background(), depl, spec, files)
+	require.NoError(t, err)
+
+	require.Len(t, client.written, 1)
+	assert.Equal(t, minion.WriteFileInput{
+		Dir: "depl_1", Name: "nginx.conf", Mode: 0o600,
+		Content: "server_name blog.apps.hoster.io; proxy_pass http://app:3000;",
+	}, client.written[0])
+	require.Len(t, client.created, 2)
+	for _, c := range client.created {
+		assert.Contains(t, c.Volumes, VolumeMount{Source: "/home/hoster/.hoster/configs/depl_1/nginx.conf", Target: "/etc/nginx/nginx.conf", ReadOnly: true})
+	}
+}
+
+func TestStartDeployment_ConfigTemplateError(t *testing.T) {
+	client := &fileWriterClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	files := []domain.ConfigFile{{Name: "app.conf", Path: "/etc/app.conf", Template: true, Content: `{{ .ServiceHost "db" }}`}}
+
+	_, err := o.StartDeployment(context.Background(), &domain.Deployment{ReferenceID: "depl_1"}, "services:\n  web:\n    image: app:1\n", files)
+	assert.ErrorContains(t, err, `unknown service "db"`)
+	assert.Empty(t, client.written)
+	assert.Empty(t, client.created)
+}
+
+// =============================================================================
+// Replica Tests
+// =============================================================================
+
+const replicaSpec = `
+services:
+  web:
+    image: app:1
+    ports: ["8080:80"]
+    depends_on: [db]
+  db:
+    image: postgres:16
+    expose: ["5432"]
+x-hoster:
+  replicas:
+    web: 2
+`
+
+func TestStartDeployment_PublishesBridgePorts(t *testing.T) {
+	client := &traefikClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	o.SetBridgeAddress("10.0.0.5")
+	depl := &domain.Deployment{
+		ReferenceID: "depl_1",
+		ProxyPort:   30001,
+		Bridges:     []domain.BridgePort{{Service: "db", Port: 5432, HostPort: 30002}},
+	}
+
+	_, err := o.StartDeployment(context.Background(), depl, replicaSpec, nil)
+	require.NoError(t, err)
+
+	for _, c := range client.created {
+		if c.Labels[LabelService] == "db" {
+			assert.Equal(t, []PortBinding{{ContainerPort: 5432, HostPort: 30002, Protocol: "tcp", HostIP: "10.0.0.5"}}, c.Ports)
+		}
+	}
+}
+
+func TestStartReplica(t *testing.T) {
+	client := &traefikClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	depl := &domain.Deployment{
+		ReferenceID: "depl_1",
+		ProxyPort:   30001,
+		Bridges:     []domain.BridgePort{{Service: "db", Port: 5432, HostPort: 30002}},
+	}
+	replica := domain.ReplicaNode{NodeID: "node_b", Services: []string{"web"}, ProxyPort: 30101}
+
+	containers, err := o.StartReplica(context.Background(), depl, replicaSpec, nil, replica, "10.0.0.5")
+	require.NoError(t, err)
+	assert.Len(t, containers, 2)
+
+	created := map[string]ContainerSpec{}
+	for _, c := range client.created {
+		created[c.Labels[LabelService]] = c
+	}
+	assert.Equal(t, coredeployment.RelayImage, created["db"].Image)
+	assert.Equal(t, []string{"db"}, created["db"].NetworkAliases["hoster_depl_1"])
+	assert.Empty(t, created["db"].Ports, "bridge ports are published on the deployment's node only")
+	assert.Contains(t, created["web"].Ports, PortBinding{ContainerPort: 80, HostPort: 30101, Protocol: "tcp", HostIP: "0.0.0.0"})
+	assert.Equal(t, 30001, depl.ProxyPort)
+}
+
+// =============================================================================
+// Image Pinning Tests
+// =============================================================================
+
+// pinningClient resolves every image to a repository digest and records
+// the images it is asked to pull.
+type pinningClient struct {
+	traefikClient
+	pulled []string
+}
+
+func (c *pinningClient) ImageExists(image string) (bool, error) {
+	return !strings.Contains(image, "@"), nil
+}
+
+func (c *pinningClient) PullImage(image string, _ PullOptions) error {
+	c.pulled = append(c.pulled, image)
+	return nil
+}
+
+func (c *pinningClient) InspectImage(_ context.Context, image string) (*minion.ImageExistsResult, error) {
+	return &minion.ImageExistsResult{Exists: true, RepoDigests: []string{"app@sha256:new", "postgres@sha256:db"}}, nil
+}
+
+func (c *pinningClient) RegistryDigest(_ context.Context, _ string) (string, error) {
+	return "sha256:new", nil
+}
+
+func TestStartDeployment_PinsImageDigests(t *testing.T) {
+	spec := `
+services:
+  web:
+    image: app:latest
+  db:
+    image: postgres:16
+`
+	client := &pinningClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	depl := &domain.Deployment{ReferenceID: "depl_1"}
+
+	containers, err := o.StartDeployment(context.Background(), depl, spec, nil)
+	require.NoError(t, err)
+	images := map[string]string{}
+	for _, c := range client.created {
+		images[c.Labels[LabelService]] = c.Image
+	}
+	assert.Equal(t, map[string]string{"web": "app@sha256:new", "db": "postgres@sha256:db"}, images)
+	digests := map[string]string{}
+	for _, c := range containers {
+		digests[c.ServiceName] = c.Digest
+	}
+	assert.Equal(t, map[string]string{"web": "sha256:new", "db": "sha256:db"}, digests)
+	assert.Empty(t, client.pulled)
+
+	// A service keeps its pinned digest even though the tag has moved on
+	client.created = nil
+	depl.Containers = []domain.ContainerInfo{{ServiceName: "web", Image: "app:latest", Digest: "sha256:old"}}
+	containers, err = o.StartDeployment(context.Background(), depl, spec, nil)
+	require.NoError(t, err)
+	for _, c := range client.created {
+		images[c.Labels[LabelService]] = c.Image
+	}
+	assert.Equal(t, "app@sha256:old", images["web"])
+	assert.Equal(t, []string{"app@sha256:old"}, client.pulled)
+	for _, c := range containers {
+		digests[c.ServiceName] = c.Digest
+	}
+	assert.Equal(t, "sha256:old", digests["web"])
+}
+
+// =============================================================================
+// Parallel Start Tests
+// =============================================================================
+
+// levelClient records the order containers start in and how many start at
+// once, and fails to start the services in fail.
+type levelClient struct {
+	traefikClient
+	fail    map[string]bool
+	running int
+	peak    int
+	order   []string
+	ids     map[string]string // containerID -> service
+}
+
+func (c *levelClient) CreateContainer(spec ContainerSpec) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, spec)
+	id := fmt.Sprintf("container%06d", len(c.created))
+	if c.ids == nil {
+		c.ids = map[string]string{}
+	}
+	c.ids[id] = spec.Labels[LabelService]
+	return id, nil
+}
+
+func (c *levelClient) StartContainer(id string) error {
+	c.mu.Lock()
+	service := c.ids[id]
+	c.running++
+	c.peak = max(c.peak, c.running)
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running--
+	c.order = append(c.order, service)
+	if c.fail[service] {
+		return errors.New("port already allocated")
+	}
+	return nil
+}
+
+func (c *levelClient) RemoveNetwork(_ string) error { return nil }
+
+const levelSpec = `
+services:
+  web:
+    image: app:1
+    depends_on: [api]
+  api:
+    image: app:1
+    depends_on: [db, cache, queue]
+  db:
+    image: postgres:16
+  cache:
+    image: redis:7
+  queue:
+    image: rabbitmq:3
+`
+
+func TestStartDeployment_StartsLevelsConcurrently(t *testing.T) {
+	client := &levelClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	var report StartReport
+	o.SetStartObserver(func(r StartReport) { report = r })
+
+	containers, err := o.StartDeployment(context.Background(), &domain.Deployment{ReferenceID: "depl_1"}, levelSpec, nil)
+	require.NoError(t, err)
+	require.Len(t, containers, 5)
+	assert.Equal(t, 3, client.peak, "db, cache and queue start together")
+	assert.ElementsMatch(t, []string{"db", "cache", "queue"}, client.order[:3])
+	assert.Equal(t, []string{"api", "web"}, client.order[3:])
+	assert.Equal(t, StartReport{Duration: report.Duration, Services: 5, Levels: 3}, report)
+
+	// Bounded to one at a time, the start is sequential again
+	client = &levelClient{}
+	o = &Orchestrator{docker: client, logger: setupTestLogger()}
+	o.SetStartConcurrency(1)
+	_, err = o.StartDeployment(context.Background(), &domain.Deployment{ReferenceID: "depl_1"}, levelSpec, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, client.peak)
+}
+
+func TestStartDeployment_LevelFailure(t *testing.T) {
+	client := &levelClient{fail: map[string]bool{"cache": true}}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	var report StartReport
+	o.SetStartObserver(func(r StartReport) { report = r })
+
+	_, err := o.StartDeployment(context.Background(), &domain.Deployment{ReferenceID: "depl_1"}, levelSpec, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "level 1 of 3")
+	assert.Contains(t, err.Error(), "failed to start container cache")
+	assert.Equal(t, err, report.Err)
+	assert.NotContains(t, client.order, "api", "later levels don't start")
+	assert.Len(t, client.removed, 3, "the level's containers are cleaned up")
+}
+
+func TestBuildContainerSpec_ServiceOverrides(t *testing.T) {
+	o := &Orchestrator{logger: setupTestLogger()}
+	depl := &domain.Deployment{
+		ReferenceID:      "depl_1",
+		ServiceOverrides: map[string]domain.ServiceOverride{"web": {MemoryMB: 256}},
+	}
+	web := compose.Service{Name: "web", Image: "app:1", Resources: compose.ServiceResources{CPULimit: 0.5, MemoryLimit: 128 * 1024 * 1024}}
+	db := compose.Service{Name: "db", Image: "postgres:16", Resources: compose.ServiceResources{CPULimit: 1}}
+
+	spec := o.buildContainerSpec(depl, web, "hoster_depl_1_web", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, int64(256*1024*1024), spec.Resources.MemoryLimit)
+	assert.Equal(t, 0.5, spec.Resources.CPULimit, "unset override keeps the compose limit")
+
+	spec = o.buildContainerSpec(depl, db, "hoster_depl_1_db", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, ResourceLimits{CPULimit: 1}, spec.Resources)
+}
+
+func TestBuildContainerSpec_GPUDevices(t *testing.T) {
+	o := &Orchestrator{logger: setupTestLogger()}
+	o.SetGPUDevices(map[string][]string{"train": {"GPU-a", "GPU-b"}})
+	depl := &domain.Deployment{ReferenceID: "depl_1"}
+
+	spec := o.buildContainerSpec(depl, compose.Service{Name: "train", Image: "pytorch:2"}, "hoster_depl_1_train", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, []string{"GPU-a", "GPU-b"}, spec.Resources.GPUDeviceIDs)
+
+	spec = o.buildContainerSpec(depl, compose.Service{Name: "web", Image: "app:1"}, "hoster_depl_1_web", "hoster_depl_1", nil, nil, 0)
+	assert.Empty(t, spec.Resources.GPUDeviceIDs)
+}
+
+func TestBuildContainerSpec_EngineOptions(t *testing.T) {
+	o := &Orchestrator{logger: setupTestLogger()}
+	depl := &domain.Deployment{ReferenceID: "depl_1"}
+	runInit := true
+	web := compose.Service{
+		Name:              "web",
+		Image:             "app:1",
+		Init:              &runInit,
+		Ulimits:           map[string]compose.Ulimit{"nproc": {Soft: 100, Hard: 100}, "nofile": {Soft: 20000, Hard: 40000}},
+		DeviceCgroupRules: []string{"c 189:* rmw"},
+	}
+
+	spec := o.buildContainerSpec(depl, web, "hoster_depl_1_web", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, &runInit, spec.Init)
+	assert.Equal(t, []Ulimit{{Name: "nofile", Soft: 20000, Hard: 40000}, {Name: "nproc", Soft: 100, Hard: 100}}, spec.Ulimits)
+	assert.Equal(t, []string{"c 189:* rmw"}, spec.DeviceCgroupRules)
+
+	m := toMinionContainerSpec(spec)
+	assert.Equal(t, &runInit, m.Init)
+	assert.Equal(t, []minion.Ulimit{{Name: "nofile", Soft: 20000, Hard: 40000}, {Name: "nproc", Soft: 100, Hard: 100}}, m.Ulimits)
+	assert.Equal(t, []string{"c 189:* rmw"}, m.DeviceCgroupRules)
+}
+
+func TestBuildContainerSpec_AliasesAndExtraHosts(t *testing.T) {
+	o := &Orchestrator{logger: setupTestLogger()}
+	depl := &domain.Deployment{ReferenceID: "depl_1"}
+	db := compose.Service{
+		Name:       "db",
+		Image:      "postgres:16",
+		Aliases:    []string{"database"},
+		ExtraHosts: []compose.ExtraHost{{Host: "gw.internal", IP: compose.HostGateway}},
+	}
+
+	spec := o.buildContainerSpec(depl, db, "hoster_depl_1_db", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, map[string][]string{"hoster_depl_1": {"db", "database"}}, spec.NetworkAliases)
+	assert.Equal(t, []string{"gw.internal:host-gateway"}, spec.ExtraHosts)
+
+	m := toMinionContainerSpec(spec)
+	assert.Equal(t, spec.NetworkAliases, m.NetworkAliases)
+	assert.Equal(t, spec.ExtraHosts, m.ExtraHosts)
+}
+
+func TestBuildContainerSpec_UserLabels(t *testing.T) {
+	o := &Orchestrator{logger: setupTestLogger()}
+	depl := &domain.Deployment{
+		ReferenceID: "depl_1",
+		Labels:      map[string]string{"team": "payments", "service": "spoofed"},
+	}
+	web := compose.Service{Name: "web", Image: "app:1", Labels: map[string]string{"tier": "frontend"}}
+
+	spec := o.buildContainerSpec(depl, web, "hoster_depl_1_web", "hoster_depl_1", nil, nil, 0)
+	assert.Equal(t, "payments", spec.Labels["hoster.user.team"])
+	assert.Equal(t, "spoofed", spec.Labels["hoster.user.service"])
+	assert.Equal(t, "web", spec.Labels[LabelService], "customer labels can't shadow hoster's")
+	assert.Equal(t, "frontend", spec.Labels["tier"])
+}
+
 // setupTestLogger creates a logger for tests that discards output
 func setupTestLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(io.Discard, nil))
 }
+
+// =============================================================================
+// Checkpoint Restore Tests
+// =============================================================================
+
+// restoreClient restores checkpoints unless fail is set.
+type restoreClient struct {
+	Client
+	fail     bool
+	restored []string
+}
+
+func (c *restoreClient) RestoreCheckpoint(_ context.Context, transferID, containerID string) error {
+	if c.fail {
+		return errors.New("criu restore failed")
+	}
+	c.restored = append(c.restored, transferID+"/"+containerID)
+	return nil
+}
+
+func TestRestoreCheckpoint(t *testing.T) {
+	client := &restoreClient{}
+	o := &Orchestrator{docker: client, logger: setupTestLogger()}
+	assert.False(t, o.restoreCheckpoint(context.Background(), "web", "c1"), "no checkpoints set")
+
+	o.SetCheckpoints(map[string]string{"web": "vm_1.checkpoint-web"})
+	assert.True(t, o.restoreCheckpoint(context.Background(), "web", "c1"))
+	assert.False(t, o.restoreCheckpoint(context.Background(), "db", "c2"), "service without a checkpoint")
+	assert.Equal(t, []string{"vm_1.checkpoint-web/c1"}, client.restored)
+
+	client.fail = true
+	assert.False(t, o.restoreCheckpoint(context.Background(), "web", "c1"), "failed restore starts fresh")
+
+	o.docker = &canaryClient{}
+	assert.False(t, o.restoreCheckpoint(context.Background(), "web", "c1"), "client without checkpoint support")
+}
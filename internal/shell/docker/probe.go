@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// =============================================================================
+// Container Probes
+// =============================================================================
+
+// ContainerProber runs health probes against containers from outside them,
+// for services without a Docker health check. SSHDockerClient and
+// DockerClient implement it.
+type ContainerProber interface {
+	ProbeContainer(ctx context.Context, containerID string, spec minion.ProbeSpec) (*minion.ProbeResult, error)
+}
+
+// maxProbeOutput bounds the command output returned by a probe.
+const maxProbeOutput = 4096
+
+// ProbeContainer runs a TCP or command probe against a local container. A
+// TCP probe connects to the container's address on one of its networks.
+func (d *DockerClient) ProbeContainer(ctx context.Context, containerID string, spec minion.ProbeSpec) (*minion.ProbeResult, error) {
+	if (spec.TCPPort == 0) == (len(spec.Command) == 0) {
+		return nil, fmt.Errorf("probe: exactly one of tcp_port and command is required")
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = 5 * time.Second
+	}
+	if spec.MaxOutput <= 0 || spec.MaxOutput > maxProbeOutput {
+		spec.MaxOutput = maxProbeOutput
+	}
+	ctx, cancel := context.WithTimeout(ctx, spec.Timeout)
+	defer cancel()
+
+	inspect, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, NewDockerError("ProbeContainer", "container", containerID, "container not found", ErrContainerNotFound)
+		}
+		return nil, NewDockerError("ProbeContainer", "container", containerID, err.Error(), err)
+	}
+
+	start := time.Now()
+	var result minion.ProbeResult
+	switch {
+	case !inspect.State.Running:
+		result.Output = "container is " + inspect.State.Status
+	case spec.TCPPort != 0:
+		result = d.probeTCP(ctx, &inspect, spec.TCPPort)
+	default:
+		result = d.probeCommand(ctx, containerID, spec)
+	}
+	result.Duration = time.Since(start)
+	return &result, nil
+}
+
+func (d *DockerClient) probeTCP(ctx context.Context, inspect *container.InspectResponse, port int) minion.ProbeResult {
+	host := "127.0.0.1"
+	if inspect.NetworkSettings != nil {
+		for _, n := range inspect.NetworkSettings.Networks {
+			if n != nil && n.IPAddress != "" {
+				host = n.IPAddress
+				break
+			}
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return minion.ProbeResult{Output: err.Error()}
+	}
+	conn.Close()
+	return minion.ProbeResult{Passed: true}
+}
+
+// probeCommand runs the command unprivileged as spec.User. A command still
+// running when ctx ends fails the probe as timed out; unlike the minion, a
+// local client cannot kill the exec, which ends with its container.
+func (d *DockerClient) probeCommand(ctx context.Context, containerID string, spec minion.ProbeSpec) minion.ProbeResult {
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          spec.Command,
+		User:         spec.User,
+		Privileged:   false,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+	defer attach.Close()
+
+	// The hijacked connection ignores ctx, so copy in the background
+	var out bytes.Buffer
+	w := &limitedWriter{buf: &out, max: spec.MaxOutput}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(w, w, attach.Reader)
+		copied <- err
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return minion.ProbeResult{Output: "exec: " + err.Error()}
+		}
+	case <-ctx.Done():
+		attach.Close()
+		<-copied
+		return minion.ProbeResult{
+			Output:    fmt.Sprintf("timed out after %s", spec.Timeout),
+			TimedOut:  true,
+			Truncated: w.truncated,
+		}
+	}
+
+	state, err := d.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return minion.ProbeResult{Output: "exec: " + err.Error()}
+	}
+	output := strings.TrimSpace(out.String())
+	if state.ExitCode != 0 {
+		if output == "" {
+			output = fmt.Sprintf("exit code %d", state.ExitCode)
+		}
+		return minion.ProbeResult{Output: output, Truncated: w.truncated}
+	}
+	return minion.ProbeResult{Passed: true, Output: output, Truncated: w.truncated}
+}
+
+// limitedWriter discards writes past max bytes.
+type limitedWriter struct {
+	buf       *bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	room := w.max - w.buf.Len()
+	if len(p) > room {
+		w.truncated = true
+	}
+	if room > 0 {
+		w.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,782 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+)
+
+// =============================================================================
+// Sandbox Client
+// =============================================================================
+//
+// SandboxClient is an in-memory Docker host for local development and tests,
+// so the API, scheduler and UI can be exercised without Docker or SSH. It
+// keeps containers, networks, volumes and images in memory and simulates
+// their lifecycle: containers go from created to running to exited, write
+// log lines while they run and report resource stats that change over time.
+// Nothing is actually run, so a sandbox container has no process to reach.
+// NodePool hands out SandboxClients in place of SSH clients when its config
+// sets Sandbox.
+
+// SandboxDockerVersion and SandboxAPIVersion are the Docker versions a
+// sandbox reports on ping.
+const (
+	SandboxDockerVersion = "27.3.1-sandbox"
+	SandboxAPIVersion    = "1.47"
+)
+
+// Sandbox host resources, reported by NodeMetrics and used as the memory
+// limit of containers without one.
+const (
+	sandboxCPUCores      = 4
+	sandboxMemoryTotalMB = 8192
+	sandboxDiskTotalMB   = 100 * 1024
+)
+
+const (
+	// sandboxHeartbeat is how often a running sandbox container logs.
+	sandboxHeartbeat = 10 * time.Second
+	// sandboxMaxLogLines bounds the log lines kept per container.
+	sandboxMaxLogLines = 1000
+	// sandboxFirstHostPort is the first host port auto-assigned to containers.
+	sandboxFirstHostPort = 32768
+)
+
+// SandboxClient implements Client in memory. It is safe for concurrent use.
+type SandboxClient struct {
+	now     func() time.Time
+	started time.Time
+
+	mu         sync.Mutex
+	containers map[string]*sandboxContainer // id -> container
+	networks   map[string]*sandboxNetwork   // id -> network
+	volumes    map[string]VolumeSpec        // name -> spec
+	images     map[string]bool
+	events     []minion.ContainerLifecycleEvent
+	nextPort   int
+}
+
+type sandboxContainer struct {
+	info      ContainerInfo
+	spec      ContainerSpec
+	logs      []sandboxLogLine
+	heartbeat time.Time // when the last heartbeat line was logged
+}
+
+type sandboxLogLine struct {
+	at   time.Time
+	text string
+}
+
+type sandboxNetwork struct {
+	id         string
+	spec       NetworkSpec
+	containers map[string]bool
+}
+
+// NewSandboxClient creates an empty sandbox Docker host.
+func NewSandboxClient() *SandboxClient {
+	return newSandboxClient(time.Now)
+}
+
+func newSandboxClient(now func() time.Time) *SandboxClient {
+	return &SandboxClient{
+		now:        now,
+		started:    now(),
+		containers: make(map[string]*sandboxContainer),
+		networks:   make(map[string]*sandboxNetwork),
+		volumes:    make(map[string]VolumeSpec),
+		images:     make(map[string]bool),
+		nextPort:   sandboxFirstHostPort,
+	}
+}
+
+// sandboxID returns a random 64 character hex ID, like Docker's.
+func sandboxID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// =============================================================================
+// Container Operations
+// =============================================================================
+
+// CreateContainer creates a container from an image the sandbox has pulled.
+func (s *SandboxClient) CreateContainer(spec ContainerSpec) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if spec.Name != "" {
+		if _, ok := s.container(spec.Name); ok {
+			return "", NewDockerError("CreateContainer", "container", spec.Name, "container name already in use", ErrContainerAlreadyExists)
+		}
+	}
+	if !s.images[spec.Image] {
+		return "", NewDockerError("CreateContainer", "image", spec.Image, "no such image", ErrImageNotFound)
+	}
+	for _, name := range spec.Networks {
+		if _, ok := s.network(name); !ok {
+			return "", NewDockerError("CreateContainer", "network", name, "network not found", ErrNetworkNotFound)
+		}
+	}
+
+	id := sandboxID()
+	if spec.Name == "" {
+		spec.Name = "sandbox_" + id[:12]
+	}
+	spec.Ports = slices.Clone(spec.Ports)
+	for i, p := range spec.Ports {
+		if p.Protocol == "" {
+			spec.Ports[i].Protocol = "tcp"
+		}
+		if p.HostPort == 0 {
+			spec.Ports[i].HostPort = s.nextPort
+			s.nextPort++
+		}
+	}
+	labels := make(map[string]string, len(spec.Labels))
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+
+	c := &sandboxContainer{
+		spec: spec,
+		info: ContainerInfo{
+			ID:        id,
+			Name:      spec.Name,
+			Image:     spec.Image,
+			Status:    ContainerStatusCreated,
+			State:     string(ContainerStatusCreated),
+			CreatedAt: s.now(),
+			Ports:     spec.Ports,
+			Labels:    labels,
+		},
+	}
+	s.containers[id] = c
+	for _, name := range spec.Networks {
+		n, _ := s.network(name)
+		n.containers[id] = true
+	}
+	return id, nil
+}
+
+// StartContainer starts a created or exited container. Its host ports must
+// not be bound by another running container.
+func (s *SandboxClient) StartContainer(containerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("StartContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	if c.info.Status == ContainerStatusRunning {
+		return NewDockerError("StartContainer", "container", containerID, "container is already running", ErrContainerAlreadyRunning)
+	}
+	for _, other := range s.containers {
+		if other == c || other.info.Status != ContainerStatusRunning {
+			continue
+		}
+		for _, p := range c.spec.Ports {
+			for _, q := range other.spec.Ports {
+				if p.HostPort == q.HostPort && p.Protocol == q.Protocol {
+					msg := fmt.Sprintf("bind for 0.0.0.0:%d failed: port is already allocated", p.HostPort)
+					return NewDockerError("StartContainer", "container", containerID, msg, ErrPortAlreadyAllocated)
+				}
+			}
+		}
+	}
+
+	now := s.now()
+	if c.info.StartedAt != nil {
+		c.info.RestartCount++
+	}
+	c.info.Status = ContainerStatusRunning
+	c.info.State = string(ContainerStatusRunning)
+	c.info.StartedAt = &now
+	c.info.FinishedAt = nil
+	c.info.ExitCode = 0
+	c.info.Health = ""
+	if c.spec.HealthCheck != nil {
+		c.info.Health = "starting"
+	}
+	c.heartbeat = now
+	c.log(now, "sandbox: starting "+c.spec.Image)
+	for _, p := range c.spec.Ports {
+		c.log(now, fmt.Sprintf("sandbox: listening on %d/%s", p.ContainerPort, p.Protocol))
+	}
+	return nil
+}
+
+// StopContainer stops a running container, which exits with code 0.
+func (s *SandboxClient) StopContainer(containerID string, _ *time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("StopContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+	if c.info.Status != ContainerStatusRunning {
+		return NewDockerError("StopContainer", "container", containerID, "container is not running", ErrContainerNotRunning)
+	}
+	s.exit(c, 0)
+	return nil
+}
+
+// RemoveContainer removes a container. A running container is only removed
+// with Force, which kills it first.
+func (s *SandboxClient) RemoveContainer(containerID string, opts RemoveOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("RemoveContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	if c.info.Status == ContainerStatusRunning {
+		if !opts.Force {
+			return NewDockerError("RemoveContainer", "container", containerID, "cannot remove a running container, stop it or use force", ErrContainerAlreadyRunning)
+		}
+		s.exit(c, 137)
+	}
+	delete(s.containers, c.info.ID)
+	for _, n := range s.networks {
+		delete(n.containers, c.info.ID)
+	}
+	return nil
+}
+
+// InspectContainer returns a container's current state.
+func (s *SandboxClient) InspectContainer(containerID string) (*ContainerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return nil, NewDockerError("InspectContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+	info := c.info
+	return &info, nil
+}
+
+// ListContainers lists containers, newest first. It supports the label,
+// name, id and status filters.
+func (s *SandboxClient) ListContainers(opts ListOptions) ([]ContainerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []ContainerInfo
+	for _, c := range s.containers {
+		s.refresh(c)
+		if !opts.All && c.info.Status != ContainerStatusRunning {
+			continue
+		}
+		if !sandboxFiltersMatch(c.info, opts.Filters) {
+			continue
+		}
+		result = append(result, c.info)
+	}
+	slices.SortFunc(result, func(a, b ContainerInfo) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return result, nil
+}
+
+// ContainerLogs returns the lines a container has logged. Follow is not
+// supported; the logs so far are returned.
+func (s *SandboxClient) ContainerLogs(containerID string, opts LogOptions) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return nil, NewDockerError("ContainerLogs", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+
+	var lines []sandboxLogLine
+	for _, l := range c.logs {
+		if !opts.Since.IsZero() && l.at.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && l.at.After(opts.Until) {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if n, err := strconv.Atoi(opts.Tail); err == nil && n >= 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+
+	var b strings.Builder
+	for _, l := range lines {
+		if opts.Timestamps {
+			b.WriteString(l.at.UTC().Format(time.RFC3339Nano) + " ")
+		}
+		b.WriteString(l.text + "\n")
+	}
+	return io.NopCloser(strings.NewReader(b.String())), nil
+}
+
+// ContainerStats returns simulated resource usage. Each container has its
+// own baseline, CPU wobbles around it and network and disk counters grow
+// with uptime; stopped containers report no usage.
+func (s *SandboxClient) ContainerStats(containerID string) (*ContainerResourceStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return nil, NewDockerError("ContainerStats", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+	return s.stats(c), nil
+}
+
+// UpdateContainerCPU changes the CPU limit of a container.
+func (s *SandboxClient) UpdateContainerCPU(_ context.Context, containerID string, cores float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("UpdateContainerCPU", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	c.spec.Resources.CPULimit = cores
+	return nil
+}
+
+// ProbeContainer passes TCP and command probes against running containers.
+// Commands are not run.
+func (s *SandboxClient) ProbeContainer(_ context.Context, containerID string, spec minion.ProbeSpec) (*minion.ProbeResult, error) {
+	if (spec.TCPPort == 0) == (len(spec.Command) == 0) {
+		return nil, fmt.Errorf("probe: exactly one of tcp_port and command is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return nil, NewDockerError("ProbeContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+	if c.info.Status != ContainerStatusRunning {
+		return &minion.ProbeResult{Output: "container is " + c.info.State}, nil
+	}
+	return &minion.ProbeResult{Passed: true, Duration: time.Millisecond}, nil
+}
+
+// ExecContainer accepts commands against running containers and reports
+// them as exiting 0 with no output. Commands are not run.
+func (s *SandboxClient) ExecContainer(_ context.Context, containerID string, spec minion.ExecSpec) (*minion.ExecResult, error) {
+	if len(spec.Command) == 0 {
+		return nil, fmt.Errorf("exec: command is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.container(containerID)
+	if !ok {
+		return nil, NewDockerError("ExecContainer", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	s.refresh(c)
+	if c.info.Status != ContainerStatusRunning {
+		return nil, NewDockerError("ExecContainer", "container", containerID, "container is "+c.info.State, ErrContainerNotRunning)
+	}
+	return &minion.ExecResult{Duration: time.Millisecond}, nil
+}
+
+// ContainerEvents returns the die events of containers that stopped or were
+// removed in the range. Filters on label and container are applied.
+func (s *SandboxClient) ContainerEvents(_ context.Context, since, until time.Time, filters map[string]string) ([]minion.ContainerLifecycleEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []minion.ContainerLifecycleEvent
+	for _, ev := range s.events {
+		if ev.Time.Before(since) || ev.Time.After(until) {
+			continue
+		}
+		if f, ok := filters["label"]; ok && !sandboxLabelMatch(ev.Labels, f) {
+			continue
+		}
+		if f, ok := filters["container"]; ok && f != ev.ContainerID && f != ev.Name {
+			continue
+		}
+		result = append(result, ev)
+	}
+	return result, nil
+}
+
+// container finds a container by ID or name. The caller holds s.mu.
+func (s *SandboxClient) container(idOrName string) (*sandboxContainer, bool) {
+	if c, ok := s.containers[idOrName]; ok {
+		return c, true
+	}
+	for _, c := range s.containers {
+		if c.info.Name == strings.TrimPrefix(idOrName, "/") {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// refresh brings a running container up to date: it logs the heartbeats
+// due and turns healthy once its first health check would have run.
+func (s *SandboxClient) refresh(c *sandboxContainer) {
+	if c.info.Status != ContainerStatusRunning {
+		return
+	}
+	now := s.now()
+	healthy := func(upTo time.Time) {
+		hc := c.spec.HealthCheck
+		if hc == nil || c.info.Health != "starting" {
+			return
+		}
+		interval := hc.Interval
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		if at := c.info.StartedAt.Add(interval); !at.After(upTo) {
+			c.info.Health = "healthy"
+			c.log(at, "sandbox: health check passed")
+		}
+	}
+	for t := c.heartbeat.Add(sandboxHeartbeat); !t.After(now); t = t.Add(sandboxHeartbeat) {
+		healthy(t)
+		c.log(t, fmt.Sprintf("sandbox: heartbeat uptime=%s", t.Sub(*c.info.StartedAt)))
+		c.heartbeat = t
+	}
+	healthy(now)
+}
+
+// exit stops a running container with an exit code and records a die event.
+func (s *SandboxClient) exit(c *sandboxContainer, code int) {
+	now := s.now()
+	c.info.Status = ContainerStatusExited
+	c.info.State = string(ContainerStatusExited)
+	c.info.FinishedAt = &now
+	c.info.ExitCode = code
+	c.info.Health = ""
+	c.log(now, fmt.Sprintf("sandbox: exited with code %d", code))
+
+	labels := make(map[string]string, len(c.info.Labels)+1)
+	for k, v := range c.info.Labels {
+		labels[k] = v
+	}
+	labels["name"] = c.info.Name
+	s.events = append(s.events, minion.ContainerLifecycleEvent{
+		ContainerID: c.info.ID,
+		Name:        c.info.Name,
+		Action:      "die",
+		ExitCode:    code,
+		Time:        now,
+		Labels:      labels,
+	})
+}
+
+// stats returns a container's simulated resource usage. The caller holds s.mu.
+func (s *SandboxClient) stats(c *sandboxContainer) *ContainerResourceStats {
+	memLimit := c.spec.Resources.MemoryLimit
+	if memLimit <= 0 {
+		memLimit = sandboxMemoryTotalMB << 20
+	}
+	stats := &ContainerResourceStats{MemoryLimitBytes: memLimit}
+	if c.info.Status != ContainerStatusRunning {
+		return stats
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(c.info.ID))
+	seed := int64(h.Sum32())
+	uptime := int64(s.now().Sub(*c.info.StartedAt).Seconds())
+
+	cpu := float64(2+seed%18) + 3*math.Sin(float64(uptime)/30)
+	if limit := c.spec.Resources.CPULimit; limit > 0 {
+		cpu = math.Min(cpu, limit*100)
+	}
+	stats.CPUPercent = math.Max(cpu, 0)
+	stats.MemoryUsageBytes = min((32+seed%96)<<20+uptime*1024, memLimit)
+	stats.MemoryPercent = float64(stats.MemoryUsageBytes) / float64(memLimit) * 100
+	stats.NetworkRxBytes = uptime * 2048
+	stats.NetworkTxBytes = uptime * 1024
+	stats.BlockReadBytes = 4 << 20
+	stats.BlockWriteBytes = uptime * 512
+	stats.PIDs = 1 + int(seed%8)
+	return stats
+}
+
+func (c *sandboxContainer) log(at time.Time, text string) {
+	c.logs = append(c.logs, sandboxLogLine{at: at, text: text})
+	if n := len(c.logs) - sandboxMaxLogLines; n > 0 {
+		c.logs = slices.Delete(c.logs, 0, n)
+	}
+}
+
+// sandboxFiltersMatch applies ListContainers filters to a container.
+func sandboxFiltersMatch(info ContainerInfo, filters map[string]string) bool {
+	for key, value := range filters {
+		switch key {
+		case "label":
+			if !sandboxLabelMatch(info.Labels, value) {
+				return false
+			}
+		case "name":
+			if !strings.Contains(info.Name, strings.TrimPrefix(value, "/")) {
+				return false
+			}
+		case "id":
+			if !strings.HasPrefix(info.ID, value) {
+				return false
+			}
+		case "status":
+			if string(info.Status) != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sandboxLabelMatch matches a "key" or "key=value" label filter.
+func sandboxLabelMatch(labels map[string]string, filter string) bool {
+	key, value, hasValue := strings.Cut(filter, "=")
+	v, ok := labels[key]
+	return ok && (!hasValue || v == value)
+}
+
+// =============================================================================
+// Network Operations
+// =============================================================================
+
+// CreateNetwork creates a network. Names are unique.
+func (s *SandboxClient) CreateNetwork(spec NetworkSpec) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.network(spec.Name); ok {
+		return "", NewDockerError("CreateNetwork", "network", spec.Name, fmt.Sprintf("network with name %s already exists", spec.Name), ErrNetworkAlreadyExists)
+	}
+	id := sandboxID()
+	s.networks[id] = &sandboxNetwork{id: id, spec: spec, containers: make(map[string]bool)}
+	return id, nil
+}
+
+// RemoveNetwork removes a network no running container is connected to.
+func (s *SandboxClient) RemoveNetwork(networkID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.network(networkID)
+	if !ok {
+		return NewDockerError("RemoveNetwork", "network", networkID, "network not found", ErrNetworkNotFound)
+	}
+	for id := range n.containers {
+		if c, ok := s.containers[id]; ok && c.info.Status == ContainerStatusRunning {
+			return NewDockerError("RemoveNetwork", "network", networkID, "network has active endpoints", ErrNetworkInUse)
+		}
+	}
+	delete(s.networks, n.id)
+	return nil
+}
+
+// ConnectNetwork connects a container to a network.
+func (s *SandboxClient) ConnectNetwork(networkID, containerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.network(networkID)
+	if !ok {
+		return NewDockerError("ConnectNetwork", "network", networkID, "network not found", ErrNetworkNotFound)
+	}
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("ConnectNetwork", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	n.containers[c.info.ID] = true
+	return nil
+}
+
+// DisconnectNetwork disconnects a container from a network.
+func (s *SandboxClient) DisconnectNetwork(networkID, containerID string, _ bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.network(networkID)
+	if !ok {
+		return NewDockerError("DisconnectNetwork", "network", networkID, "network not found", ErrNetworkNotFound)
+	}
+	c, ok := s.container(containerID)
+	if !ok {
+		return NewDockerError("DisconnectNetwork", "container", containerID, "container not found", ErrContainerNotFound)
+	}
+	delete(n.containers, c.info.ID)
+	return nil
+}
+
+// network finds a network by ID or name. The caller holds s.mu.
+func (s *SandboxClient) network(idOrName string) (*sandboxNetwork, bool) {
+	if n, ok := s.networks[idOrName]; ok {
+		return n, true
+	}
+	for _, n := range s.networks {
+		if n.spec.Name == idOrName {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// =============================================================================
+// Volume Operations
+// =============================================================================
+
+// CreateVolume creates a volume, or returns an existing one of the same name
+// as Docker does.
+func (s *SandboxClient) CreateVolume(spec VolumeSpec) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if spec.Name == "" {
+		spec.Name = sandboxID()
+	}
+	if _, ok := s.volumes[spec.Name]; !ok {
+		s.volumes[spec.Name] = spec
+	}
+	return spec.Name, nil
+}
+
+// RemoveVolume removes a volume. A volume mounted by a container is only
+// removed with force.
+func (s *SandboxClient) RemoveVolume(volumeName string, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.volumes[volumeName]; !ok {
+		return NewDockerError("RemoveVolume", "volume", volumeName, "volume not found", ErrVolumeNotFound)
+	}
+	if !force {
+		for _, c := range s.containers {
+			for _, m := range c.spec.Volumes {
+				if m.Source == volumeName {
+					return NewDockerError("RemoveVolume", "volume", volumeName, "volume is in use", ErrVolumeInUse)
+				}
+			}
+		}
+	}
+	delete(s.volumes, volumeName)
+	return nil
+}
+
+// =============================================================================
+// Image Operations
+// =============================================================================
+
+// PullImage records an image as pulled. No registry is contacted.
+func (s *SandboxClient) PullImage(image string, _ PullOptions) error {
+	if image == "" {
+		return NewDockerError("PullImage", "image", image, "image name is required", ErrImagePullFailed)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[image] = true
+	return nil
+}
+
+// ImageExists reports whether an image has been pulled.
+func (s *SandboxClient) ImageExists(image string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images[image], nil
+}
+
+// =============================================================================
+// Node Operations
+// =============================================================================
+
+// Ping always succeeds.
+func (s *SandboxClient) Ping() error {
+	return nil
+}
+
+// PingInfo returns the Docker versions the sandbox reports.
+func (s *SandboxClient) PingInfo() *minion.PingInfo {
+	return &minion.PingInfo{
+		DockerVersion: SandboxDockerVersion,
+		APIVersion:    SandboxAPIVersion,
+		OS:            "linux",
+		Arch:          runtime.GOARCH,
+	}
+}
+
+// NodeMetrics reports the sandbox host's simulated load, memory and disks.
+func (s *SandboxClient) NodeMetrics(opts minion.NodeMetricsOptions) (*minion.NodeMetrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	m := &minion.NodeMetrics{
+		CPUCores:      sandboxCPUCores,
+		MemoryTotalMB: sandboxMemoryTotalMB,
+		UptimeSeconds: int64(now.Sub(s.started).Seconds()),
+		DockerdStatus: "active",
+		CollectedAt:   now,
+	}
+	var cpu float64
+	var mem int64
+	for _, c := range s.containers {
+		s.refresh(c)
+		stats := s.stats(c)
+		cpu += stats.CPUPercent
+		mem += stats.MemoryUsageBytes
+	}
+	m.CPUUsedPct = math.Min(cpu/sandboxCPUCores, 100)
+	m.MemoryUsedMB = min(mem>>20, sandboxMemoryTotalMB)
+	m.LoadAvg1 = cpu / 100
+	m.LoadAvg5 = m.LoadAvg1
+	m.LoadAvg15 = m.LoadAvg1
+
+	mounts := opts.Mounts
+	if len(mounts) == 0 {
+		mounts = []string{"/", "/var/lib/docker"}
+	}
+	for _, mount := range mounts {
+		m.Disks = append(m.Disks, minion.DiskUsage{
+			Mount:   mount,
+			TotalMB: sandboxDiskTotalMB,
+			UsedMB:  int64(len(s.images))*256 + int64(len(s.volumes))*64,
+		})
+	}
+	return m, nil
+}
+
+// NetworkAddresses reports the loopback address; a sandbox has no public
+// address.
+func (s *SandboxClient) NetworkAddresses(_ context.Context, _ minion.NetworkAddressOptions) (*minion.NetworkAddresses, error) {
+	return &minion.NetworkAddresses{
+		PrivateAddress: "127.0.0.1",
+		Error:          "sandbox nodes have no public address",
+	}, nil
+}
+
+// Close is a no-op; the sandbox keeps its state.
+func (s *SandboxClient) Close() error {
+	return nil
+}
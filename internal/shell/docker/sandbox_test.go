@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Sandbox Client Tests
+// =============================================================================
+
+type sandboxClock struct{ t time.Time }
+
+func (c *sandboxClock) now() time.Time          { return c.t }
+func (c *sandboxClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestSandbox() (*SandboxClient, *sandboxClock) {
+	clock := &sandboxClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return newSandboxClient(clock.now), clock
+}
+
+func TestSandboxClient_Interfaces(t *testing.T) {
+	var c any = NewSandboxClient()
+	assert.Implements(t, (*Client)(nil), c)
+	assert.Implements(t, (*ContainerProber)(nil), c)
+	assert.Implements(t, (*ContainerEventSource)(nil), c)
+}
+
+func TestSandboxClient_Lifecycle(t *testing.T) {
+	s, clock := newTestSandbox()
+
+	spec := ContainerSpec{
+		Name:     "web",
+		Image:    "nginx:1.27",
+		Labels:   map[string]string{LabelDeployment: "depl_1", LabelService: "web"},
+		Ports:    []PortBinding{{ContainerPort: 80}},
+		Networks: []string{"hoster_depl_1"},
+	}
+	_, err := s.CreateContainer(spec)
+	assert.ErrorIs(t, err, ErrImageNotFound, "image must be pulled first")
+
+	require.NoError(t, s.PullImage("nginx:1.27", PullOptions{}))
+	_, err = s.CreateContainer(spec)
+	assert.ErrorIs(t, err, ErrNetworkNotFound)
+
+	_, err = s.CreateNetwork(NetworkSpec{Name: "hoster_depl_1"})
+	require.NoError(t, err)
+	_, err = s.CreateNetwork(NetworkSpec{Name: "hoster_depl_1"})
+	assert.ErrorContains(t, err, "already exists")
+
+	id, err := s.CreateContainer(spec)
+	require.NoError(t, err)
+	assert.Len(t, id, 64)
+	_, err = s.CreateContainer(spec)
+	assert.ErrorIs(t, err, ErrContainerAlreadyExists)
+
+	info, err := s.InspectContainer(id)
+	require.NoError(t, err)
+	assert.Equal(t, ContainerStatusCreated, info.Status)
+	assert.Equal(t, sandboxFirstHostPort, info.Ports[0].HostPort, "host port auto-assigned")
+
+	require.NoError(t, s.StartContainer(id))
+	assert.ErrorIs(t, s.StartContainer(id), ErrContainerAlreadyRunning)
+	info, _ = s.InspectContainer("web")
+	assert.Equal(t, ContainerStatusRunning, info.Status)
+	require.NotNil(t, info.StartedAt)
+
+	running, err := s.ListContainers(ListOptions{Filters: map[string]string{"label": LabelDeployment + "=depl_1"}})
+	require.NoError(t, err)
+	assert.Len(t, running, 1)
+	other, _ := s.ListContainers(ListOptions{Filters: map[string]string{"label": LabelDeployment + "=depl_2"}})
+	assert.Empty(t, other)
+
+	assert.ErrorIs(t, s.RemoveNetwork("hoster_depl_1"), ErrNetworkInUse)
+	assert.ErrorIs(t, s.RemoveContainer(id, RemoveOptions{}), ErrContainerAlreadyRunning)
+
+	clock.advance(time.Minute)
+	require.NoError(t, s.StopContainer(id, nil))
+	assert.ErrorIs(t, s.StopContainer(id, nil), ErrContainerNotRunning)
+	info, _ = s.InspectContainer(id)
+	assert.Equal(t, ContainerStatusExited, info.Status)
+	assert.Equal(t, 0, info.ExitCode)
+
+	running, _ = s.ListContainers(ListOptions{})
+	assert.Empty(t, running, "stopped containers are listed with All only")
+	all, _ := s.ListContainers(ListOptions{All: true})
+	assert.Len(t, all, 1)
+
+	events, err := s.ContainerEvents(t.Context(), clock.now().Add(-time.Second), clock.now(), map[string]string{"label": LabelDeployment + "=depl_1"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "die", events[0].Action)
+	assert.Equal(t, "web", events[0].Name)
+
+	require.NoError(t, s.StartContainer(id))
+	info, _ = s.InspectContainer(id)
+	assert.Equal(t, 1, info.RestartCount)
+
+	require.NoError(t, s.RemoveContainer(id, RemoveOptions{Force: true}))
+	_, err = s.InspectContainer(id)
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	require.NoError(t, s.RemoveNetwork("hoster_depl_1"))
+}
+
+func TestSandboxClient_PortConflict(t *testing.T) {
+	s, _ := newTestSandbox()
+	require.NoError(t, s.PullImage("app", PullOptions{}))
+
+	a, err := s.CreateContainer(ContainerSpec{Name: "a", Image: "app", Ports: []PortBinding{{ContainerPort: 80, HostPort: 8080}}})
+	require.NoError(t, err)
+	b, err := s.CreateContainer(ContainerSpec{Name: "b", Image: "app", Ports: []PortBinding{{ContainerPort: 80, HostPort: 8080}}})
+	require.NoError(t, err)
+
+	require.NoError(t, s.StartContainer(a))
+	assert.ErrorIs(t, s.StartContainer(b), ErrPortAlreadyAllocated)
+	require.NoError(t, s.StopContainer(a, nil))
+	assert.NoError(t, s.StartContainer(b))
+}
+
+func TestSandboxClient_LogsAndHealth(t *testing.T) {
+	s, clock := newTestSandbox()
+	require.NoError(t, s.PullImage("app", PullOptions{}))
+	id, err := s.CreateContainer(ContainerSpec{
+		Name:        "app",
+		Image:       "app",
+		HealthCheck: &HealthCheck{Test: []string{"CMD", "true"}, Interval: 15 * time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.StartContainer(id))
+
+	info, _ := s.InspectContainer(id)
+	assert.Equal(t, "starting", info.Health)
+
+	clock.advance(35 * time.Second)
+	info, _ = s.InspectContainer(id)
+	assert.Equal(t, "healthy", info.Health)
+
+	rc, err := s.ContainerLogs(id, LogOptions{})
+	require.NoError(t, err)
+	logs, _ := io.ReadAll(rc)
+	assert.Contains(t, string(logs), "sandbox: starting app")
+	assert.Equal(t, 3, strings.Count(string(logs), "heartbeat"), "one heartbeat per 10s")
+
+	rc, _ = s.ContainerLogs(id, LogOptions{Tail: "1", Timestamps: true})
+	logs, _ = io.ReadAll(rc)
+	assert.Equal(t, "2026-01-01T12:00:30Z sandbox: heartbeat uptime=30s\n", string(logs))
+
+	rc, _ = s.ContainerLogs(id, LogOptions{Since: clock.now().Add(-10 * time.Second)})
+	logs, _ = io.ReadAll(rc)
+	assert.Equal(t, "sandbox: heartbeat uptime=30s\n", string(logs))
+}
+
+func TestSandboxClient_Stats(t *testing.T) {
+	s, clock := newTestSandbox()
+	require.NoError(t, s.PullImage("app", PullOptions{}))
+	id, err := s.CreateContainer(ContainerSpec{Name: "app", Image: "app", Resources: ResourceLimits{CPULimit: 0.01, MemoryLimit: 256 << 20}})
+	require.NoError(t, err)
+
+	stats, err := s.ContainerStats(id)
+	require.NoError(t, err)
+	assert.Zero(t, stats.CPUPercent, "created containers use nothing")
+	assert.Equal(t, int64(256<<20), stats.MemoryLimitBytes)
+
+	require.NoError(t, s.StartContainer(id))
+	clock.advance(100 * time.Second)
+	stats, err = s.ContainerStats(id)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, stats.CPUPercent, 1.0, "capped at the CPU limit")
+	assert.Positive(t, stats.MemoryUsageBytes)
+	assert.LessOrEqual(t, stats.MemoryUsageBytes, int64(256<<20))
+	assert.Equal(t, int64(204800), stats.NetworkRxBytes)
+
+	clock.advance(100 * time.Second)
+	later, _ := s.ContainerStats(id)
+	assert.Greater(t, later.NetworkRxBytes, stats.NetworkRxBytes, "counters grow with uptime")
+
+	require.NoError(t, s.UpdateContainerCPU(t.Context(), id, 2))
+	updated, _ := s.ContainerStats(id)
+	assert.Greater(t, updated.CPUPercent, 1.0)
+
+	m, err := s.NodeMetrics(minion.NodeMetricsOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "active", m.DockerdStatus)
+	assert.Len(t, m.Disks, 2)
+	assert.Positive(t, m.MemoryUsedMB)
+}
+
+func TestSandboxClient_Exec(t *testing.T) {
+	s, _ := newTestSandbox()
+	require.NoError(t, s.PullImage("app", PullOptions{}))
+	id, err := s.CreateContainer(ContainerSpec{Name: "app", Image: "app"})
+	require.NoError(t, err)
+
+	spec := minion.ExecSpec{Command: []string{"php", "artisan", "migrate"}}
+	_, err = s.ExecContainer(t.Context(), id, spec)
+	assert.ErrorIs(t, err, ErrContainerNotRunning)
+
+	require.NoError(t, s.StartContainer(id))
+	result, err := s.ExecContainer(t.Context(), id, spec)
+	require.NoError(t, err)
+	assert.Zero(t, result.ExitCode)
+
+	_, err = s.ExecContainer(t.Context(), "missing", spec)
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	_, err = s.ExecContainer(t.Context(), id, minion.ExecSpec{})
+	assert.Error(t, err)
+}
+
+func TestSandboxClient_Volumes(t *testing.T) {
+	s, _ := newTestSandbox()
+	name, err := s.CreateVolume(VolumeSpec{Name: "data"})
+	require.NoError(t, err)
+	assert.Equal(t, "data", name)
+	_, err = s.CreateVolume(VolumeSpec{Name: "data"})
+	assert.NoError(t, err, "existing volumes are returned")
+
+	require.NoError(t, s.PullImage("db", PullOptions{}))
+	_, err = s.CreateContainer(ContainerSpec{Name: "db", Image: "db", Volumes: []VolumeMount{{Source: "data", Target: "/var/lib/db"}}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.RemoveVolume("data", false), ErrVolumeInUse)
+	assert.NoError(t, s.RemoveVolume("data", true))
+	assert.ErrorIs(t, s.RemoveVolume("data", false), ErrVolumeNotFound)
+}
@@ -1,11 +1,16 @@
 package docker
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -14,26 +19,47 @@ import (
 
 	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/shell/logging"
 	"golang.org/x/crypto/ssh"
 )
 
 // SSHDockerClient implements the Client interface by executing minion commands via SSH.
 // The minion binary must be deployed to the remote node.
 type SSHDockerClient struct {
-	node           *domain.Node
-	sshClient      *ssh.Client
-	signer         ssh.Signer
-	minionPath     string        // Path to minion binary on remote node
-	timeout        time.Duration // Command timeout
-	mu             sync.Mutex    // Protects sshClient
-	minionEnsured  sync.Once     // Ensures minion is deployed once per client
+	node          *domain.Node
+	sshClient     *ssh.Client
+	signer        ssh.Signer
+	minionPath    string                  // Path to minion binary on remote node
+	timeout       time.Duration           // Command timeout
+	mu            sync.Mutex              // Protects sshClient
+	minionEnsured sync.Once               // Ensures minion is deployed once per client
+	handshake     *minion.HandshakePolicy // Nil disables handshake verification
+	verifyMu      sync.Mutex              // Protects verified
+	verified      bool                    // Handshake passed (failures are retried)
+	protoMu       sync.Mutex              // Protects protocol and protocolKnown
+	protocol      string                  // Protocol version the minion reported
+	protocolKnown bool                    // A handshake was attempted
+	pingMu        sync.Mutex              // Protects pingInfo
+	pingInfo      *minion.PingInfo        // Docker versions from the last ping
+	faults        FaultInjector           // Nil injects no faults
 }
 
 // SSHClientConfig configures the SSH Docker client.
 type SSHClientConfig struct {
-	MinionPath     string        // Default: ~/.hoster/minion
-	CommandTimeout time.Duration // Default: 30 seconds
-	ConnectTimeout time.Duration // Default: 10 seconds
+	MinionPath     string                  // Default: ~/.hoster/minion
+	CommandTimeout time.Duration           // Default: 30 seconds
+	ConnectTimeout time.Duration           // Default: 10 seconds
+	Handshake      *minion.HandshakePolicy // Signature + protocol checks before dispatch (nil = off)
+	Faults         FaultInjector           // Chaos testing faults in minion commands (nil = off)
+}
+
+// FaultInjector injects faults into minion commands for chaos testing.
+type FaultInjector interface {
+	// MinionFault runs before a command is sent to a node's minion. It may
+	// delay the command; a non-nil ErrorInfo fails it with that minion error,
+	// and a non-nil error fails it as if the node didn't answer. subject
+	// identifies what the command acts on.
+	MinionFault(ctx context.Context, nodeID, command, subject string) (*minion.ErrorInfo, error)
 }
 
 // DefaultSSHClientConfig returns the default configuration.
@@ -68,6 +94,8 @@ func NewSSHDockerClient(node *domain.Node, privateKey []byte, config SSHClientCo
 		signer:     signer,
 		minionPath: config.MinionPath,
 		timeout:    config.CommandTimeout,
+		handshake:  config.Handshake,
+		faults:     config.Faults,
 	}, nil
 }
 
@@ -127,29 +155,49 @@ func (c *SSHDockerClient) Close() error {
 
 // EnsureMinion ensures the minion binary is deployed and up-to-date on the remote node.
 // It checks if the minion exists and matches the expected version, uploading if needed.
-func (c *SSHDockerClient) EnsureMinion(ctx context.Context, minionBinary []byte, expectedVersion string) error {
+// signature is the binary's signature file, installed beside it (nil if unsigned).
+func (c *SSHDockerClient) EnsureMinion(ctx context.Context, minionBinary, signature []byte, expectedVersion string) error {
 	if err := c.connect(ctx); err != nil {
 		return err
 	}
 
-	// Check if minion exists and get version
-	currentVersion, err := c.getMinionVersion(ctx)
-	if err == nil && currentVersion == expectedVersion {
+	// Check if minion exists and get version. Minions that predate the
+	// current protocol (no protocol_version) are replaced too.
+	info, err := c.getMinionVersionInfo(ctx)
+	if err == nil && info.Version == expectedVersion && info.ProtocolVersion == minion.Version && c.installedMatches(ctx, minionBinary, signature) {
 		// Minion exists and is up-to-date
 		return nil
 	}
 
 	// Deploy minion binary
-	return c.deployMinion(ctx, minionBinary)
+	return c.deployMinion(ctx, minionBinary, signature)
+}
+
+// installedMatches reports whether the node already has this signed binary
+// and its signature installed. Unsigned binaries always match.
+func (c *SSHDockerClient) installedMatches(ctx context.Context, binary, signature []byte) bool {
+	if len(signature) == 0 {
+		return true
+	}
+	out, err := c.runShell(ctx, "sha256sum "+c.minionPath, 30*time.Second)
+	if err != nil {
+		return false
+	}
+	digest, err := minion.ParseSHA256Sum(out)
+	if err != nil || digest != minion.Digest(binary) {
+		return false
+	}
+	installed, err := c.runShell(ctx, "cat "+c.minionPath+minion.SignatureSuffix+" 2>/dev/null || true", 5*time.Second)
+	return err == nil && strings.TrimSpace(installed) == strings.TrimSpace(string(signature))
 }
 
-// getMinionVersion returns the version of the minion binary on the remote node.
-func (c *SSHDockerClient) getMinionVersion(ctx context.Context) (string, error) {
+// getMinionVersionInfo runs the "version" handshake on the remote node.
+func (c *SSHDockerClient) getMinionVersionInfo(ctx context.Context) (*minion.VersionInfo, error) {
 	c.mu.Lock()
 	session, err := c.sshClient.NewSession()
 	c.mu.Unlock()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer session.Close()
 
@@ -163,51 +211,178 @@ func (c *SSHDockerClient) getMinionVersion(ctx context.Context) (string, error)
 
 	select {
 	case <-ctx.Done():
-		return "", ctx.Err()
+		return nil, ctx.Err()
 	case <-time.After(5 * time.Second):
-		return "", fmt.Errorf("timeout checking minion version")
+		return nil, fmt.Errorf("timeout checking minion version")
 	case err := <-done:
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 
 	resp, err := minion.ParseResponse(stdout.Bytes())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !resp.Success {
-		return "", fmt.Errorf("minion version check failed")
+		return nil, fmt.Errorf("minion version check failed")
 	}
 
 	var version minion.VersionInfo
 	if err := resp.UnmarshalData(&version); err != nil {
-		return "", err
+		return nil, err
+	}
+
+	c.setProtocol(version.ProtocolVersion)
+	return &version, nil
+}
+
+// setProtocol records the protocol version the minion speaks.
+func (c *SSHDockerClient) setProtocol(version string) {
+	c.protoMu.Lock()
+	c.protocol = version
+	c.protocolKnown = true
+	c.protoMu.Unlock()
+}
+
+// transport returns the transport features the minion supports. The
+// protocol version comes from the handshake; if no handshake has run yet
+// one is made, and a minion that fails it is treated as predating the
+// transport features.
+func (c *SSHDockerClient) transport(ctx context.Context) minion.Transport {
+	c.protoMu.Lock()
+	known, version := c.protocolKnown, c.protocol
+	c.protoMu.Unlock()
+	if !known {
+		if _, err := c.getMinionVersionInfo(ctx); err != nil {
+			c.setProtocol("")
+		}
+		c.protoMu.Lock()
+		version = c.protocol
+		c.protoMu.Unlock()
+	}
+	return minion.NegotiateTransport(version)
+}
+
+// VerifyMinion runs the version handshake and checks it against the handshake
+// policy. Returns an error wrapping ErrMinionUnverified if the minion fails
+// signature or protocol verification. A successful result is cached for the
+// lifetime of the client; failures are re-checked on the next call.
+func (c *SSHDockerClient) VerifyMinion(ctx context.Context) error {
+	if c.handshake == nil {
+		return nil
+	}
+
+	c.verifyMu.Lock()
+	defer c.verifyMu.Unlock()
+	if c.verified {
+		return nil
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	h := minion.Handshake{}
+	info, err := c.getMinionVersionInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("minion handshake: %w", err)
+	}
+	h.Info = *info
+	if c.handshake.PublicKey != nil {
+		// The digest comes from the node's sha256sum, not from the minion,
+		// so a tampered binary cannot report a genuine one's digest
+		out, err := c.runShell(ctx, "sha256sum "+c.minionPath, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("minion handshake: hash binary: %w", err)
+		}
+		if h.Digest, err = minion.ParseSHA256Sum(out); err != nil {
+			return fmt.Errorf("%w: %v", ErrMinionUnverified, err)
+		}
+		// A missing signature file reads as empty and fails as unsigned
+		if h.Signature, err = c.runShell(ctx, "cat "+c.minionPath+minion.SignatureSuffix+" 2>/dev/null || true", 5*time.Second); err != nil {
+			return fmt.Errorf("minion handshake: read signature: %w", err)
+		}
+	}
+	if err := minion.VerifyHandshake(h, *c.handshake); err != nil {
+		return fmt.Errorf("%w: %v", ErrMinionUnverified, err)
 	}
 
-	return version.Version, nil
+	c.verified = true
+	return nil
 }
 
-// deployMinion uploads the minion binary to the remote node.
-func (c *SSHDockerClient) deployMinion(ctx context.Context, binary []byte) error {
+// runShell runs a shell command on the remote node and returns its stdout.
+func (c *SSHDockerClient) runShell(ctx context.Context, cmd string, timeout time.Duration) (string, error) {
 	c.mu.Lock()
 	session, err := c.sshClient.NewSession()
 	c.mu.Unlock()
 	if err != nil {
-		return fmt.Errorf("create session: %w", err)
+		return "", fmt.Errorf("create session: %w", err)
 	}
 	defer session.Close()
 
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(timeout):
+		return "", fmt.Errorf("timeout running %q", cmd)
+	case err := <-done:
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", cmd, err)
+		}
+	}
+	return stdout.String(), nil
+}
+
+// deployMinion uploads the minion binary to the remote node, with its
+// signature file when it has one.
+func (c *SSHDockerClient) deployMinion(ctx context.Context, binary, signature []byte) error {
 	// Expand ~ to home directory using a simple mkdir command
 	minionDir := "~/.hoster"
 	minionPath := minionDir + "/minion"
+	sigPath := minionPath + minion.SignatureSuffix
 
 	// Create directory and write file using cat
 	// This avoids issues with tilde expansion
 	cmd := fmt.Sprintf("mkdir -p %s && cat > %s && chmod +x %s", minionDir, minionPath, minionPath)
+	if err := c.upload(ctx, cmd, binary, 60*time.Second); err != nil { // Allow more time for upload
+		return fmt.Errorf("deploy minion: %w", err)
+	}
+
+	// Replace the signature too, so a stale one never sits beside a new binary
+	cmd = fmt.Sprintf("rm -f %s", sigPath)
+	if len(signature) > 0 {
+		cmd = fmt.Sprintf("cat > %s", sigPath)
+	}
+	if err := c.upload(ctx, cmd, signature, 10*time.Second); err != nil {
+		return fmt.Errorf("deploy minion signature: %w", err)
+	}
+
+	// The deployed binary speaks this backend's protocol
+	c.setProtocol(minion.Version)
+	return nil
+}
+
+// upload runs cmd on the remote node with data on its stdin.
+func (c *SSHDockerClient) upload(ctx context.Context, cmd string, data []byte, timeout time.Duration) error {
+	c.mu.Lock()
+	session, err := c.sshClient.NewSession()
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	defer session.Close()
 
-	session.Stdin = bytes.NewReader(binary)
+	session.Stdin = bytes.NewReader(data)
 
 	done := make(chan error, 1)
 	go func() {
@@ -217,15 +392,11 @@ func (c *SSHDockerClient) deployMinion(ctx context.Context, binary []byte) error
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case <-time.After(60 * time.Second): // Allow more time for upload
-		return fmt.Errorf("timeout deploying minion binary")
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout running %q", cmd)
 	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("deploy minion: %w", err)
-		}
+		return err
 	}
-
-	return nil
 }
 
 // AutoEnsureMinion detects the remote node's OS and architecture and deploys the correct
@@ -260,7 +431,7 @@ func (c *SSHDockerClient) AutoEnsureMinion(ctx context.Context) error {
 	}
 
 	// Deploy if missing or outdated
-	return c.EnsureMinion(ctx, binary, MinionVersion)
+	return c.EnsureMinion(ctx, binary, GetMinionSignature("linux", goarch), MinionVersion)
 }
 
 // detectOS runs `uname -s` on the remote node and returns the OS name in lowercase.
@@ -340,10 +511,11 @@ func (c *SSHDockerClient) detectArch(ctx context.Context) (string, error) {
 // Minion Execution
 // =============================================================================
 
-// execMinion executes a minion command via SSH and returns the response.
-func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args []string, input any) (*minion.Response, error) {
+// prepare connects to the node, makes sure the minion is deployed and
+// verified, and returns the transport features it supports.
+func (c *SSHDockerClient) prepare(ctx context.Context) (minion.Transport, error) {
 	if err := c.connect(ctx); err != nil {
-		return nil, err
+		return minion.Transport{}, err
 	}
 
 	// Auto-deploy/update minion binary on first command
@@ -354,6 +526,29 @@ func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args [
 		}
 	})
 
+	// Refuse to dispatch to a minion that fails signature/protocol verification
+	if err := c.VerifyMinion(ctx); err != nil {
+		return minion.Transport{}, err
+	}
+
+	return c.transport(ctx), nil
+}
+
+// execMinion executes a minion command via SSH and returns the response.
+// Input is compressed and the response may be, when the minion supports it;
+// responses over minion.MaxEnvelopeBytes fail with minion.ErrPayloadTooLarge.
+func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args []string, input any) (_ *minion.Response, err error) {
+	ctx, logger := c.minionLogger(ctx, command)
+	defer logMinionCommand(logger, time.Now(), &err)
+
+	if err := c.injectFault(ctx, command, args, input); err != nil {
+		return nil, err
+	}
+	tr, err := c.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	c.mu.Lock()
 	session, err := c.sshClient.NewSession()
 	c.mu.Unlock()
@@ -362,28 +557,20 @@ func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args [
 	}
 	defer session.Close()
 
-	// Build command with DOCKER_HOST if node has a custom docker socket
-	cmdParts := []string{c.minionPath, command}
-	cmdParts = append(cmdParts, args...)
-	cmdStr := strings.Join(cmdParts, " ")
-	if c.node.DockerSocket != "" && c.node.DockerSocket != "/var/run/docker.sock" {
-		cmdStr = fmt.Sprintf("DOCKER_HOST=unix://%s %s", c.node.DockerSocket, cmdStr)
-	}
+	cmdStr := c.minionCommand(ctx, command, args, tr)
 
 	// Set up stdin if input is provided
-	var stdin io.Reader
 	if input != nil {
-		inputJSON, err := json.Marshal(input)
+		inputJSON, err := encodeInput(input, tr)
 		if err != nil {
-			return nil, fmt.Errorf("marshal input: %w", err)
+			return nil, fmt.Errorf("%s: %w", command, err)
 		}
-		stdin = bytes.NewReader(inputJSON)
-		session.Stdin = stdin
+		session.Stdin = bytes.NewReader(inputJSON)
 	}
 
-	// Capture stdout
-	var stdout bytes.Buffer
-	session.Stdout = &stdout
+	// Capture stdout, up to the envelope limit
+	stdout := &limitedBuffer{limit: minion.MaxEnvelopeBytes}
+	session.Stdout = stdout
 
 	// Run command with timeout — use context deadline if set, else default
 	cmdTimeout := c.timeout
@@ -402,6 +589,9 @@ func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args [
 	case <-time.After(cmdTimeout):
 		return nil, fmt.Errorf("command timeout after %v", cmdTimeout)
 	case err := <-done:
+		if stdout.overflow {
+			return nil, fmt.Errorf("%s: %w", command, minion.TooLargeError("response", 0, minion.MaxEnvelopeBytes))
+		}
 		// Parse response even if there was an exit error - minion writes JSON errors
 		resp, parseErr := minion.ParseResponse(stdout.Bytes())
 		if parseErr != nil {
@@ -414,6 +604,233 @@ func (c *SSHDockerClient) execMinion(ctx context.Context, command string, args [
 	}
 }
 
+// minionLogger returns the context's logger with the node and command. A
+// context without a request ID gets one, so the log can be matched with the
+// node's audit log.
+func (c *SSHDockerClient) minionLogger(ctx context.Context, command string) (context.Context, *slog.Logger) {
+	fields := []any{"node_id", c.node.ReferenceID, "minion_command", command}
+	if _, ok := RequestID(ctx); !ok {
+		id := minionRequestID(ctx)
+		ctx = WithRequestID(ctx, id)
+		fields = append(fields, "request_id", id)
+	}
+	_, logger := logging.With(ctx, nil, fields...)
+	return ctx, logger
+}
+
+// logMinionCommand logs a finished minion command at debug level.
+func logMinionCommand(logger *slog.Logger, start time.Time, err *error) {
+	if *err != nil {
+		logger.Debug("minion command failed", "duration", time.Since(start), "error", *err)
+		return
+	}
+	logger.Debug("minion command", "duration", time.Since(start))
+}
+
+// injectFault applies the fault injector, if any, to a minion command. The
+// command's arguments and container name identify what it acts on.
+func (c *SSHDockerClient) injectFault(ctx context.Context, command string, args []string, input any) error {
+	if c.faults == nil {
+		return nil
+	}
+	subject := strings.Join(args, " ")
+	if spec, ok := input.(minion.ContainerSpec); ok {
+		subject += " " + spec.Name
+	}
+	info, err := c.faults.MinionFault(ctx, c.node.ReferenceID, command, subject)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		return c.translateError(info)
+	}
+	return nil
+}
+
+// minionCommand builds the command line for a minion command. It sets
+// DOCKER_HOST if the node has a custom docker socket, and passes the
+// request ID recorded in the node's audit log and the transport features
+// to use.
+func (c *SSHDockerClient) minionCommand(ctx context.Context, command string, args []string, tr minion.Transport) string {
+	cmdParts := []string{c.minionPath, command}
+	cmdParts = append(cmdParts, args...)
+	cmdStr := strings.Join(cmdParts, " ")
+	if c.node.DockerSocket != "" && c.node.DockerSocket != "/var/run/docker.sock" {
+		cmdStr = fmt.Sprintf("DOCKER_HOST=unix://%s %s", c.node.DockerSocket, cmdStr)
+	}
+	if features := tr.String(); features != "" {
+		cmdStr = fmt.Sprintf("%s=%s %s", minion.TransportEnv, features, cmdStr)
+	}
+	return fmt.Sprintf("%s=%s %s", minion.RequestIDEnv, minionRequestID(ctx), cmdStr)
+}
+
+// encodeInput marshals a command's JSON input, compressed when the minion
+// accepts it.
+func encodeInput(input any, tr minion.Transport) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal input: %w", err)
+	}
+	return minion.EncodeEnvelope(data, tr.Gzip)
+}
+
+// limitedBuffer is a buffer that keeps at most limit bytes and records
+// whether more were written, so an oversized response is reported rather
+// than parsed from a prefix.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.overflow = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// requestIDKey is the context key for the request ID passed to the minion.
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose minion commands carry id, so a node's
+// audit log can be matched with backend logs.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID a context's minion commands carry.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// minionRequestID returns the context's request ID, or a new one when it has
+// none or it cannot be passed on the command line.
+func minionRequestID(ctx context.Context) string {
+	if id, ok := RequestID(ctx); ok && minion.ValidRequestID(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "mn_" + hex.EncodeToString(b)
+}
+
+// execMinionRaw runs a minion command whose stdin or stdout carries raw data
+// rather than JSON. It returns whatever the command wrote to stderr. Unlike
+// execMinion there is no default timeout; the caller bounds it with ctx.
+func (c *SSHDockerClient) execMinionRaw(ctx context.Context, command string, args []string, stdin io.Reader, stdout io.Writer) (_ []byte, err error) {
+	ctx, logger := c.minionLogger(ctx, command)
+	defer logMinionCommand(logger, time.Now(), &err)
+
+	if err := c.injectFault(ctx, command, args, nil); err != nil {
+		return nil, err
+	}
+	tr, err := c.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	session, err := c.sshClient.NewSession()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	cmdStr := c.minionCommand(ctx, command, args, tr)
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmdStr)
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Closing the session unblocks Run and any pending stdin copy
+		session.Close()
+		return stderr.Bytes(), ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return stderr.Bytes(), fmt.Errorf("%s failed: %w", command, err)
+		}
+		return stderr.Bytes(), nil
+	}
+}
+
+// execMinionStream runs a minion command that writes a chunked stream to
+// stdout and passes the stream's data to consume. A failure the minion
+// reports in the stream's trailer, or a JSON error envelope in place of the
+// stream (a command refused before it started), is returned as a Docker
+// error; a stream cut short fails with minion.ErrStreamTruncated.
+func (c *SSHDockerClient) execMinionStream(ctx context.Context, command string, args []string, stdin io.Reader, limit int64, consume func(io.Reader) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.execMinionRaw(ctx, command, args, stdin, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	if err := consume(c.streamReader(pr, limit)); err != nil {
+		cancel()
+		pr.CloseWithError(err)
+		<-done
+		return err
+	}
+	// Anything after the trailer is discarded
+	go io.Copy(io.Discard, pr)
+	return <-done
+}
+
+// streamReader returns a reader of the chunked stream on r.
+func (c *SSHDockerClient) streamReader(r io.Reader, limit int64) io.Reader {
+	br := bufio.NewReader(r)
+	// A frame header never starts with '{': that would be a frame far over
+	// minion.MaxChunkBytes
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		data, _ := io.ReadAll(io.LimitReader(br, minion.MaxEnvelopeBytes))
+		resp, err := minion.ParseResponse(data)
+		if err == nil && resp.Error != nil {
+			return errReader{c.translateError(resp.Error)}
+		}
+		return errReader{fmt.Errorf("unexpected minion output: %s", data)}
+	}
+	return &chunkStreamReader{c: c, r: minion.NewChunkReader(br, limit)}
+}
+
+// chunkStreamReader translates failures reported in a stream's trailer.
+type chunkStreamReader struct {
+	c *SSHDockerClient
+	r *minion.ChunkReader
+}
+
+func (s *chunkStreamReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	var streamErr *minion.StreamError
+	if errors.As(err, &streamErr) {
+		err = s.c.translateError(&streamErr.Info)
+	}
+	return n, err
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
 // translateError converts a minion error to a Docker error.
 func (c *SSHDockerClient) translateError(errInfo *minion.ErrorInfo) error {
 	switch errInfo.Code {
@@ -433,6 +850,10 @@ func (c *SSHDockerClient) translateError(errInfo *minion.ErrorInfo) error {
 		return NewDockerError(errInfo.Command, "", "", errInfo.Message, ErrConnectionFailed)
 	case minion.ErrCodePullFailed:
 		return NewDockerError(errInfo.Command, "", "", errInfo.Message, ErrImagePullFailed)
+	case minion.ErrCodeChecksumMismatch:
+		return NewDockerError(errInfo.Command, "", "", errInfo.Message, ErrChecksumMismatch)
+	case minion.ErrCodeTooLarge:
+		return NewDockerError(errInfo.Command, "", "", errInfo.Message, ErrPayloadTooLarge)
 	default:
 		return NewDockerError(errInfo.Command, "", "", errInfo.Message, nil)
 	}
@@ -583,6 +1004,29 @@ func (c *SSHDockerClient) ContainerLogs(containerID string, opts LogOptions) (io
 		Timestamps: opts.Timestamps,
 	}
 
+	// Minions with chunked streams return logs of any size up to
+	// minion.MaxLogStreamBytes; older ones up to what fits an envelope
+	tr, err := c.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Chunked {
+		input, err := encodeInput(mOpts, tr)
+		if err != nil {
+			return nil, err
+		}
+		var logs bytes.Buffer
+		err = c.execMinionStream(ctx, "container-logs-stream", []string{containerID}, bytes.NewReader(input), minion.MaxLogStreamBytes,
+			func(r io.Reader) error {
+				_, err := io.Copy(&logs, r)
+				return err
+			})
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(&logs), nil
+	}
+
 	resp, err := c.execMinion(ctx, "container-logs", []string{containerID}, mOpts)
 	if err != nil {
 		return nil, err
@@ -631,56 +1075,193 @@ func (c *SSHDockerClient) ContainerStats(containerID string) (*ContainerResource
 	}, nil
 }
 
-// =============================================================================
-// Network Operations
-// =============================================================================
-
-// CreateNetwork creates a new network.
-func (c *SSHDockerClient) CreateNetwork(spec NetworkSpec) (string, error) {
-	ctx := context.Background()
+// UpdateContainerCPU changes a container's CPU limit, in cores.
+func (c *SSHDockerClient) UpdateContainerCPU(ctx context.Context, containerID string, cores float64) error {
+	resp, err := c.execMinion(ctx, "update-container", []string{containerID}, minion.ContainerUpdate{CPULimit: cores})
+	if err != nil {
+		return err
+	}
 
-	mSpec := minion.NetworkSpec{
-		Name:   spec.Name,
-		Driver: spec.Driver,
-		Labels: spec.Labels,
+	if !resp.Success {
+		return c.translateError(resp.Error)
 	}
+	return nil
+}
 
-	resp, err := c.execMinion(ctx, "create-network", nil, mSpec)
+// ProbeContainer runs a TCP or command probe against a container on the node.
+func (c *SSHDockerClient) ProbeContainer(ctx context.Context, containerID string, spec minion.ProbeSpec) (*minion.ProbeResult, error) {
+	resp, err := c.execMinion(ctx, "probe-container", []string{containerID}, spec)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
 	if !resp.Success {
-		return "", c.translateError(resp.Error)
+		return nil, c.translateError(resp.Error)
 	}
 
-	var result minion.CreateResult
+	var result minion.ProbeResult
 	if err := resp.UnmarshalData(&result); err != nil {
-		return "", fmt.Errorf("unmarshal result: %w", err)
+		return nil, fmt.Errorf("unmarshal result: %w", err)
 	}
-	return result.ID, nil
+	return &result, nil
 }
 
-// RemoveNetwork removes a network.
-func (c *SSHDockerClient) RemoveNetwork(networkID string) error {
-	ctx := context.Background()
-
-	resp, err := c.execMinion(ctx, "remove-network", []string{networkID}, nil)
+// ExecContainer runs a one-off command in a container on the node.
+func (c *SSHDockerClient) ExecContainer(ctx context.Context, containerID string, spec minion.ExecSpec) (*minion.ExecResult, error) {
+	resp, err := c.execMinion(ctx, "exec-container", []string{containerID}, spec)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	if !resp.Success {
-		return c.translateError(resp.Error)
+		return nil, c.translateError(resp.Error)
 	}
-	return nil
-}
 
-// ConnectNetwork connects a container to a network.
-func (c *SSHDockerClient) ConnectNetwork(networkID, containerID string) error {
-	ctx := context.Background()
+	var result minion.ExecResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal result: %w", err)
+	}
+	return &result, nil
+}
 
-	resp, err := c.execMinion(ctx, "connect-network", []string{networkID, containerID}, nil)
+// OpenTunnel connects to a port of a container on the node and relays in to
+// it and its replies to out, until the container closes the connection or
+// ctx ends. The end of in half-closes the connection.
+//
+// Unlike execMinionRaw, in is copied by hand: the minion exits as soon as
+// the container closes, and waiting for in to end too would hold the
+// tunnel open. The caller unblocks a pending read of in once this returns.
+func (c *SSHDockerClient) OpenTunnel(ctx context.Context, containerID string, port int, in io.Reader, out io.Writer) (err error) {
+	const command = "tunnel"
+	args := []string{containerID, strconv.Itoa(port)}
+	ctx, logger := c.minionLogger(ctx, command)
+	defer logMinionCommand(logger, time.Now(), &err)
+
+	if err := c.injectFault(ctx, command, args, nil); err != nil {
+		return err
+	}
+	tr, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	session, err := c.sshClient.NewSession()
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("open stdin: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("open stdout: %w", err)
+	}
+	if err := session.Start(c.minionCommand(ctx, command, args, tr)); err != nil {
+		return fmt.Errorf("start %s: %w", command, err)
+	}
+
+	go func() {
+		io.Copy(stdin, in)
+		stdin.Close()
+	}()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	_, copyErr := io.Copy(out, stdout)
+	if copyErr != nil {
+		// The client is gone; closing the session ends the minion.
+		session.Close()
+	}
+	waitErr := session.Wait()
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case copyErr != nil:
+		return copyErr
+	case waitErr != nil:
+		// Errors before the connection is up come as a JSON envelope on stderr
+		if resp, parseErr := minion.ParseResponse(stderr.Bytes()); parseErr == nil && resp.Error != nil {
+			return c.translateError(resp.Error)
+		}
+		return fmt.Errorf("%s failed: %w", command, waitErr)
+	}
+	return nil
+}
+
+// ContainerEvents returns the container die and oom events on the node in
+// the range.
+func (c *SSHDockerClient) ContainerEvents(ctx context.Context, since, until time.Time, filters map[string]string) ([]minion.ContainerLifecycleEvent, error) {
+	opts := minion.EventsOptions{Since: since, Until: until, Filters: filters}
+	resp, err := c.execMinion(ctx, "container-events", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result []minion.ContainerLifecycleEvent
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal result: %w", err)
+	}
+	return result, nil
+}
+
+// =============================================================================
+// Network Operations
+// =============================================================================
+
+// CreateNetwork creates a new network.
+func (c *SSHDockerClient) CreateNetwork(spec NetworkSpec) (string, error) {
+	ctx := context.Background()
+
+	mSpec := minion.NetworkSpec{
+		Name:   spec.Name,
+		Driver: spec.Driver,
+		Labels: spec.Labels,
+	}
+
+	resp, err := c.execMinion(ctx, "create-network", nil, mSpec)
+	if err != nil {
+		return "", err
+	}
+
+	if !resp.Success {
+		return "", c.translateError(resp.Error)
+	}
+
+	var result minion.CreateResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return "", fmt.Errorf("unmarshal result: %w", err)
+	}
+	return result.ID, nil
+}
+
+// RemoveNetwork removes a network.
+func (c *SSHDockerClient) RemoveNetwork(networkID string) error {
+	ctx := context.Background()
+
+	resp, err := c.execMinion(ctx, "remove-network", []string{networkID}, nil)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return c.translateError(resp.Error)
+	}
+	return nil
+}
+
+// ConnectNetwork connects a container to a network.
+func (c *SSHDockerClient) ConnectNetwork(networkID, containerID string) error {
+	ctx := context.Background()
+
+	resp, err := c.execMinion(ctx, "connect-network", []string{networkID, containerID}, nil)
 	if err != nil {
 		return err
 	}
@@ -761,6 +1342,216 @@ func (c *SSHDockerClient) RemoveVolume(volumeName string, force bool) error {
 	return nil
 }
 
+// VolumeUsage returns the bytes and files stored in a volume.
+func (c *SSHDockerClient) VolumeUsage(ctx context.Context, volumeName string) (*minion.VolumeUsage, error) {
+	resp, err := c.execMinion(ctx, "volume-usage", []string{volumeName}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var usage minion.VolumeUsage
+	if err := resp.UnmarshalData(&usage); err != nil {
+		return nil, fmt.Errorf("unmarshal volume usage: %w", err)
+	}
+	return &usage, nil
+}
+
+// =============================================================================
+// Volume Transfer Operations
+// =============================================================================
+
+// SnapshotVolume stages a tar archive of a volume for transfer, or returns
+// the existing stage for transferID.
+func (c *SSHDockerClient) SnapshotVolume(ctx context.Context, transferID, volumeName string) (*minion.VolumeSnapshot, error) {
+	resp, err := c.execMinion(ctx, "volume-snapshot", []string{transferID, volumeName}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var snap minion.VolumeSnapshot
+	if err := resp.UnmarshalData(&snap); err != nil {
+		return nil, fmt.Errorf("unmarshal volume snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// ReadVolumeChunk streams length bytes of the staged archive at offset into w.
+func (c *SSHDockerClient) ReadVolumeChunk(ctx context.Context, transferID string, offset, length int64, w io.Writer) error {
+	args := []string{transferID, strconv.FormatInt(offset, 10), strconv.FormatInt(length, 10)}
+	stderr, err := c.execMinionRaw(ctx, "volume-read", args, nil, w)
+	if err != nil {
+		// volume-read reports errors as a JSON envelope on stderr
+		if resp, parseErr := minion.ParseResponse(stderr); parseErr == nil && resp.Error != nil {
+			return c.translateError(resp.Error)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReceiveVolumeChunk writes r into the target's stage at offset.
+func (c *SSHDockerClient) ReceiveVolumeChunk(ctx context.Context, transferID string, offset int64, r io.Reader) (*minion.VolumeChunkResult, error) {
+	var stdout bytes.Buffer
+	_, err := c.execMinionRaw(ctx, "volume-receive", []string{transferID, strconv.FormatInt(offset, 10)}, r, &stdout)
+	resp, parseErr := minion.ParseResponse(stdout.Bytes())
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("command failed: %w, output: %s", err, stdout.String())
+		}
+		return nil, fmt.Errorf("parse response: %w", parseErr)
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.VolumeChunkResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal chunk result: %w", err)
+	}
+	return &result, nil
+}
+
+// StagedVolumeSize returns how many bytes of a transfer are staged on the node.
+func (c *SSHDockerClient) StagedVolumeSize(ctx context.Context, transferID string) (int64, error) {
+	resp, err := c.execMinion(ctx, "volume-staged", []string{transferID}, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Success {
+		return 0, c.translateError(resp.Error)
+	}
+
+	var info minion.VolumeStageInfo
+	if err := resp.UnmarshalData(&info); err != nil {
+		return 0, fmt.Errorf("unmarshal stage info: %w", err)
+	}
+	return info.Size, nil
+}
+
+// RestoreVolume verifies the staged archive against sha256 and extracts it
+// into the volume described by spec, creating the volume if needed.
+func (c *SSHDockerClient) RestoreVolume(ctx context.Context, transferID, sha256 string, spec VolumeSpec) (*minion.VolumeRestoreResult, error) {
+	mSpec := minion.VolumeSpec{
+		Name:   spec.Name,
+		Driver: spec.Driver,
+		Labels: spec.Labels,
+	}
+
+	resp, err := c.execMinion(ctx, "volume-restore", []string{transferID, sha256}, mSpec)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.VolumeRestoreResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal restore result: %w", err)
+	}
+	return &result, nil
+}
+
+// DiscardVolumeStage removes a transfer's stage from the node.
+func (c *SSHDockerClient) DiscardVolumeStage(ctx context.Context, transferID string) error {
+	resp, err := c.execMinion(ctx, "volume-discard", []string{transferID}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return c.translateError(resp.Error)
+	}
+	return nil
+}
+
+// =============================================================================
+// Checkpoint Operations (experimental)
+// =============================================================================
+
+// CheckpointSupport reports whether the node can checkpoint and restore
+// containers with CRIU.
+func (c *SSHDockerClient) CheckpointSupport(ctx context.Context) (*minion.CheckpointSupport, error) {
+	resp, err := c.execMinion(ctx, "checkpoint-support", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var support minion.CheckpointSupport
+	if err := resp.UnmarshalData(&support); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint support: %w", err)
+	}
+	return &support, nil
+}
+
+// CheckpointContainer checkpoints a running container, which stops it, and
+// stages the checkpoint for transfer, or returns the existing stage.
+func (c *SSHDockerClient) CheckpointContainer(ctx context.Context, transferID, containerID string) (*minion.VolumeSnapshot, error) {
+	resp, err := c.execMinion(ctx, "checkpoint-create", []string{transferID, containerID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var snap minion.VolumeSnapshot
+	if err := resp.UnmarshalData(&snap); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// UnpackCheckpoint verifies a staged checkpoint against sha256 and unpacks
+// it, ready for RestoreCheckpoint.
+func (c *SSHDockerClient) UnpackCheckpoint(ctx context.Context, transferID, sha256 string) (*minion.VolumeRestoreResult, error) {
+	resp, err := c.execMinion(ctx, "checkpoint-unpack", []string{transferID, sha256}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.VolumeRestoreResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint unpack result: %w", err)
+	}
+	return &result, nil
+}
+
+// RestoreCheckpoint starts a created container from an unpacked checkpoint.
+func (c *SSHDockerClient) RestoreCheckpoint(ctx context.Context, transferID, containerID string) error {
+	resp, err := c.execMinion(ctx, "checkpoint-restore", []string{transferID, containerID}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return c.translateError(resp.Error)
+	}
+	return nil
+}
+
+// DiscardCheckpoint removes a transfer's checkpoint and stage from the node.
+func (c *SSHDockerClient) DiscardCheckpoint(ctx context.Context, transferID string) error {
+	resp, err := c.execMinion(ctx, "checkpoint-discard", []string{transferID}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return c.translateError(resp.Error)
+	}
+	return nil
+}
+
 // =============================================================================
 // Image Operations
 // =============================================================================
@@ -807,6 +1598,89 @@ func (c *SSHDockerClient) ImageExists(imageName string) (bool, error) {
 	return result.Exists, nil
 }
 
+// InspectImage reports whether an image exists on the node and its ID.
+func (c *SSHDockerClient) InspectImage(ctx context.Context, imageName string) (*minion.ImageExistsResult, error) {
+	resp, err := c.execMinion(ctx, "image-exists", []string{imageName}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.ImageExistsResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal result: %w", err)
+	}
+	return &result, nil
+}
+
+// RegistryDigest returns the digest an image tag points at in its registry
+// now, as seen from the node, without pulling the image.
+func (c *SSHDockerClient) RegistryDigest(ctx context.Context, imageName string) (string, error) {
+	resp, err := c.execMinion(ctx, "image-digest", []string{imageName}, nil)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", c.translateError(resp.Error)
+	}
+
+	var result minion.ImageDigestResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return "", fmt.Errorf("unmarshal result: %w", err)
+	}
+	return result.Digest, nil
+}
+
+// SaveImage streams a docker save archive of an image on the node into w.
+// Minions with chunked streams frame the archive, so a save that fails
+// partway is reported rather than leaving a short archive in w.
+func (c *SSHDockerClient) SaveImage(ctx context.Context, imageName string, w io.Writer) error {
+	tr, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	if tr.Chunked {
+		return c.execMinionStream(ctx, "image-save", []string{imageName}, nil, 0, func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		})
+	}
+
+	stderr, err := c.execMinionRaw(ctx, "image-save", []string{imageName}, nil, w)
+	if err != nil {
+		// image-save reports errors as a JSON envelope on stderr
+		if resp, parseErr := minion.ParseResponse(stderr); parseErr == nil && resp.Error != nil {
+			return c.translateError(resp.Error)
+		}
+		return err
+	}
+	return nil
+}
+
+// LoadImage loads a docker save archive read from r into the node.
+func (c *SSHDockerClient) LoadImage(ctx context.Context, r io.Reader) (*minion.ImageLoadResult, error) {
+	var stdout bytes.Buffer
+	_, err := c.execMinionRaw(ctx, "image-load", nil, r, &stdout)
+	resp, parseErr := minion.ParseResponse(stdout.Bytes())
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("command failed: %w, output: %s", err, stdout.String())
+		}
+		return nil, fmt.Errorf("parse response: %w", parseErr)
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.ImageLoadResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal load result: %w", err)
+	}
+	return &result, nil
+}
+
 // =============================================================================
 // Health Operations
 // =============================================================================
@@ -815,6 +1689,12 @@ func (c *SSHDockerClient) ImageExists(imageName string) (bool, error) {
 func (c *SSHDockerClient) Ping() error {
 	ctx := context.Background()
 
+	// Re-run the handshake on every ping so a minion replaced after the
+	// first verification is caught by the next health check.
+	c.verifyMu.Lock()
+	c.verified = false
+	c.verifyMu.Unlock()
+
 	resp, err := c.execMinion(ctx, "ping", nil, nil)
 	if err != nil {
 		return err
@@ -823,9 +1703,24 @@ func (c *SSHDockerClient) Ping() error {
 	if !resp.Success {
 		return c.translateError(resp.Error)
 	}
+
+	var info minion.PingInfo
+	if err := resp.UnmarshalData(&info); err == nil {
+		c.pingMu.Lock()
+		c.pingInfo = &info
+		c.pingMu.Unlock()
+	}
 	return nil
 }
 
+// PingInfo returns the Docker versions the last successful ping reported,
+// or nil before one.
+func (c *SSHDockerClient) PingInfo() *minion.PingInfo {
+	c.pingMu.Lock()
+	defer c.pingMu.Unlock()
+	return c.pingInfo
+}
+
 // SystemInfo collects host-level CPU, memory, and disk metrics from the remote node.
 func (c *SSHDockerClient) SystemInfo() (*minion.SystemInfo, error) {
 	ctx := context.Background()
@@ -846,6 +1741,134 @@ func (c *SSHDockerClient) SystemInfo() (*minion.SystemInfo, error) {
 	return &info, nil
 }
 
+// NodeMetrics collects node-level load, disk, dockerd, and journal metrics from the remote node.
+func (c *SSHDockerClient) NodeMetrics(opts minion.NodeMetricsOptions) (*minion.NodeMetrics, error) {
+	ctx := context.Background()
+
+	resp, err := c.execMinion(ctx, "node-metrics", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var m minion.NodeMetrics
+	if err := resp.UnmarshalData(&m); err != nil {
+		return nil, fmt.Errorf("unmarshal node metrics: %w", err)
+	}
+	return &m, nil
+}
+
+// NetworkAddresses reports the remote node's private address and the public
+// address the internet sees it at.
+func (c *SSHDockerClient) NetworkAddresses(ctx context.Context, opts minion.NetworkAddressOptions) (*minion.NetworkAddresses, error) {
+	resp, err := c.execMinion(ctx, "network-addresses", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var addrs minion.NetworkAddresses
+	if err := resp.UnmarshalData(&addrs); err != nil {
+		return nil, fmt.Errorf("unmarshal network addresses: %w", err)
+	}
+	return &addrs, nil
+}
+
+// GPUInfo reports the remote node's NVIDIA GPUs.
+func (c *SSHDockerClient) GPUInfo(ctx context.Context) (*minion.GPUInfo, error) {
+	resp, err := c.execMinion(ctx, "gpu-info", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var info minion.GPUInfo
+	if err := resp.UnmarshalData(&info); err != nil {
+		return nil, fmt.Errorf("unmarshal gpu info: %w", err)
+	}
+	return &info, nil
+}
+
+// WriteTraefikConfig writes a Traefik dynamic configuration file on the
+// remote node.
+func (c *SSHDockerClient) WriteTraefikConfig(ctx context.Context, in minion.TraefikConfigInput) (*minion.TraefikConfigResult, error) {
+	resp, err := c.execMinion(ctx, "traefik-config", nil, in)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.TraefikConfigResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal traefik config result: %w", err)
+	}
+	return &result, nil
+}
+
+// WriteFile writes a file under the minion's config directory on the
+// remote node and returns its path there.
+func (c *SSHDockerClient) WriteFile(ctx context.Context, in minion.WriteFileInput) (*minion.WriteFileResult, error) {
+	resp, err := c.execMinion(ctx, "write-file", nil, in)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var result minion.WriteFileResult
+	if err := resp.UnmarshalData(&result); err != nil {
+		return nil, fmt.Errorf("unmarshal write file result: %w", err)
+	}
+	return &result, nil
+}
+
+// Housekeeping runs cleanup tasks (docker prune, log rotation, journal vacuum,
+// tmp cleanup) on the remote node, or previews them when opts.DryRun is set.
+func (c *SSHDockerClient) Housekeeping(ctx context.Context, opts minion.HousekeepingOptions) (*minion.HousekeepingReport, error) {
+	resp, err := c.execMinion(ctx, "node-housekeeping", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var report minion.HousekeepingReport
+	if err := resp.UnmarshalData(&report); err != nil {
+		return nil, fmt.Errorf("unmarshal housekeeping report: %w", err)
+	}
+	return &report, nil
+}
+
+// AuditLog reads entries of the remote minion's command audit log.
+func (c *SSHDockerClient) AuditLog(ctx context.Context, opts minion.AuditLogOptions) (*minion.AuditLog, error) {
+	resp, err := c.execMinion(ctx, "audit-log", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, c.translateError(resp.Error)
+	}
+
+	var log minion.AuditLog
+	if err := resp.UnmarshalData(&log); err != nil {
+		return nil, fmt.Errorf("unmarshal audit log: %w", err)
+	}
+	return &log, nil
+}
+
 // =============================================================================
 // Type Conversions
 // =============================================================================
@@ -867,8 +1890,9 @@ func toMinionContainerSpec(spec ContainerSpec) minion.ContainerSpec {
 			MaximumRetryCount: spec.RestartPolicy.MaximumRetryCount,
 		},
 		Resources: minion.ResourceLimits{
-			CPULimit:    spec.Resources.CPULimit,
-			MemoryLimit: spec.Resources.MemoryLimit,
+			CPULimit:     spec.Resources.CPULimit,
+			MemoryLimit:  spec.Resources.MemoryLimit,
+			GPUDeviceIDs: spec.Resources.GPUDeviceIDs,
 		},
 	}
 
@@ -899,23 +1923,33 @@ func toMinionContainerSpec(spec ContainerSpec) minion.ContainerSpec {
 		}
 	}
 
+	mSpec.Init = spec.Init
+	for _, u := range spec.Ulimits {
+		mSpec.Ulimits = append(mSpec.Ulimits, minion.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	mSpec.DeviceCgroupRules = spec.DeviceCgroupRules
+	mSpec.NetworkAliases = spec.NetworkAliases
+	mSpec.ExtraHosts = spec.ExtraHosts
+
 	return mSpec
 }
 
 // fromMinionContainerInfo converts minion ContainerInfo to our format.
 func fromMinionContainerInfo(m *minion.ContainerInfo) *ContainerInfo {
 	info := &ContainerInfo{
-		ID:         m.ID,
-		Name:       m.Name,
-		Image:      m.Image,
-		Status:     ContainerStatus(m.Status),
-		State:      m.State,
-		Health:     m.Health,
-		CreatedAt:  m.CreatedAt,
-		StartedAt:  m.StartedAt,
-		FinishedAt: m.FinishedAt,
-		Labels:     m.Labels,
-		ExitCode:   m.ExitCode,
+		ID:           m.ID,
+		Name:         m.Name,
+		Image:        m.Image,
+		Status:       ContainerStatus(m.Status),
+		State:        m.State,
+		Health:       m.Health,
+		CreatedAt:    m.CreatedAt,
+		StartedAt:    m.StartedAt,
+		FinishedAt:   m.FinishedAt,
+		Labels:       m.Labels,
+		ExitCode:     m.ExitCode,
+		OOMKilled:    m.OOMKilled,
+		RestartCount: m.RestartCount,
 	}
 
 	for _, p := range m.Ports {
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/domain"
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Transport Tests
+// =============================================================================
+
+func TestMinionCommand_Transport(t *testing.T) {
+	c := &SSHDockerClient{node: &domain.Node{}, minionPath: "~/.hoster/minion"}
+	ctx := WithRequestID(t.Context(), "req_1")
+
+	cmd := c.minionCommand(ctx, "container-logs", []string{"abc"}, minion.Transport{})
+	assert.Equal(t, "HOSTER_REQUEST_ID=req_1 ~/.hoster/minion container-logs abc", cmd)
+
+	cmd = c.minionCommand(ctx, "container-logs", []string{"abc"}, minion.Transport{Gzip: true, Chunked: true})
+	assert.Equal(t, "HOSTER_REQUEST_ID=req_1 HOSTER_MINION_TRANSPORT=gzip,chunked ~/.hoster/minion container-logs abc", cmd)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{limit: 8}
+	n, err := b.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.False(t, b.overflow)
+
+	_, err = b.Write([]byte("6789"))
+	require.NoError(t, err)
+	assert.True(t, b.overflow)
+	assert.Equal(t, "12345678", b.String())
+}
+
+func TestStreamReader(t *testing.T) {
+	c := &SSHDockerClient{}
+
+	var ok bytes.Buffer
+	w := minion.NewChunkWriter(&ok)
+	_, err := w.Write([]byte("log line\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(c.streamReader(&ok, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "log line\n", string(out))
+
+	// A failure in the trailer becomes a Docker error
+	var failed bytes.Buffer
+	w = minion.NewChunkWriter(&failed)
+	require.NoError(t, w.CloseWithError(minion.ErrorInfo{Command: "container-logs-stream", Code: minion.ErrCodeNotFound, Message: "No such container: abc"}))
+	_, err = io.ReadAll(c.streamReader(&failed, 0))
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+
+	// A refused command answers with an error envelope instead of a stream
+	refused, err := json.Marshal(minion.NewErrorResponse("image-save", minion.ErrCodeTooLarge, "too big"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(c.streamReader(bytes.NewReader(refused), 0))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	// A stream cut short is never taken as complete
+	_, err = io.ReadAll(c.streamReader(strings.NewReader("\x00\x00\x00\x10short"), 0))
+	assert.ErrorIs(t, err, minion.ErrStreamTruncated)
+}
@@ -27,6 +27,17 @@ type ContainerSpec struct {
 	RestartPolicy RestartPolicy
 	Resources     ResourceLimits
 	HealthCheck   *HealthCheck
+	Init              *bool    // Run an init process as PID 1
+	Ulimits           []Ulimit // Resource limit overrides
+	DeviceCgroupRules []string // Rules added to the device cgroup
+	ExtraHosts        []string // /etc/hosts entries ("host:ip")
+}
+
+// Ulimit defines a resource limit override.
+type Ulimit struct {
+	Name string // e.g. "nofile"
+	Soft int64
+	Hard int64
 }
 
 // PortBinding defines a port mapping.
@@ -54,6 +65,8 @@ type RestartPolicy struct {
 type ResourceLimits struct {
 	CPULimit    float64 // CPU cores
 	MemoryLimit int64   // Bytes
+	// GPU UUIDs exposed through the nvidia driver
+	GPUDeviceIDs []string
 }
 
 // HealthCheck defines container health check configuration.
@@ -84,18 +97,20 @@ const (
 
 // ContainerInfo contains information about a container.
 type ContainerInfo struct {
-	ID         string
-	Name       string
-	Image      string
-	Status     ContainerStatus
-	State      string // "running", "exited", "created", etc.
-	Health     string // "healthy", "unhealthy", "starting", ""
-	CreatedAt  time.Time
-	StartedAt  *time.Time
-	FinishedAt *time.Time
-	Ports      []PortBinding
-	Labels     map[string]string
-	ExitCode   int
+	ID           string
+	Name         string
+	Image        string
+	Status       ContainerStatus
+	State        string // "running", "exited", "created", etc.
+	Health       string // "healthy", "unhealthy", "starting", ""
+	CreatedAt    time.Time
+	StartedAt    *time.Time
+	FinishedAt   *time.Time
+	Ports        []PortBinding
+	Labels       map[string]string
+	ExitCode     int
+	OOMKilled    bool
+	RestartCount int
 }
 
 // =============================================================================
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+)
+
+// =============================================================================
+// Volume Transfer
+// =============================================================================
+//
+// Volumes are copied between nodes with the control plane as relay: nodes hold
+// no credentials for each other, but the backend holds SSH access to both.
+// Each chunk is streamed from the source minion's stdout straight into the
+// target minion's stdin, hashed in flight, and checked against the checksum
+// the target computed while writing it. The target's stage is the resume
+// point, so a transfer interrupted at any time continues where it stopped.
+
+// VolumeEndpoint is a node that can send and receive staged volume archives.
+// SSHDockerClient implements it.
+type VolumeEndpoint interface {
+	SnapshotVolume(ctx context.Context, transferID, volumeName string) (*minion.VolumeSnapshot, error)
+	ReadVolumeChunk(ctx context.Context, transferID string, offset, length int64, w io.Writer) error
+	ReceiveVolumeChunk(ctx context.Context, transferID string, offset int64, r io.Reader) (*minion.VolumeChunkResult, error)
+	StagedVolumeSize(ctx context.Context, transferID string) (int64, error)
+	RestoreVolume(ctx context.Context, transferID, sha256 string, spec VolumeSpec) (*minion.VolumeRestoreResult, error)
+	DiscardVolumeStage(ctx context.Context, transferID string) error
+}
+
+// VolumeTransfer describes one volume to copy.
+type VolumeTransfer struct {
+	TransferID string
+	Source     string     // Volume name on the source node
+	Target     VolumeSpec // Volume to restore into on the target node
+	ChunkSize  int64      // 0 uses transfer.DefaultChunkSize
+	// ChunkTimeout bounds a single chunk copy (default 10 minutes).
+	ChunkTimeout time.Duration
+}
+
+// VolumeTransferProgress is reported after the snapshot and after each chunk.
+type VolumeTransferProgress struct {
+	TransferID       string
+	Phase            string // "snapshot", "transfer", "verify"
+	TotalBytes       int64
+	TransferredBytes int64
+}
+
+// VolumeTransferResult describes a verified transfer.
+type VolumeTransferResult struct {
+	Snapshot minion.VolumeSnapshot
+	Restore  minion.VolumeRestoreResult
+	Resumed  int64 // Bytes that were already staged when the transfer started
+}
+
+// TransferVolume copies a volume from src to dst and verifies it. On success
+// both stages are removed. On failure they are kept so calling again with the
+// same TransferID resumes, except that a stage that fails its checksum is
+// dropped by the target and restarts from zero.
+func TransferVolume(ctx context.Context, src, dst VolumeEndpoint, t VolumeTransfer, progress func(VolumeTransferProgress)) (*VolumeTransferResult, error) {
+	if err := transfer.ValidateTransferID(t.TransferID); err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = func(VolumeTransferProgress) {}
+	}
+	chunkSize := transfer.NormalizeChunkSize(t.ChunkSize)
+	chunkTimeout := t.ChunkTimeout
+	if chunkTimeout == 0 {
+		chunkTimeout = 10 * time.Minute
+	}
+
+	snap, err := src.SnapshotVolume(ctx, t.TransferID, t.Source)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", t.Source, err)
+	}
+	report := func(phase string, done int64) {
+		progress(VolumeTransferProgress{TransferID: t.TransferID, Phase: phase, TotalBytes: snap.Size, TransferredBytes: done})
+	}
+
+	staged, err := dst.StagedVolumeSize(ctx, t.TransferID)
+	if err != nil {
+		return nil, fmt.Errorf("check staged data: %w", err)
+	}
+	offset := transfer.ResumeOffset(staged, snap.Size)
+	result := &VolumeTransferResult{Snapshot: *snap, Resumed: offset}
+	report("snapshot", offset)
+
+	for {
+		length := transfer.ChunkLength(offset, snap.Size, chunkSize)
+		if length == 0 {
+			break
+		}
+		if err := copyChunkWithRetry(ctx, src, dst, t.TransferID, offset, length, chunkTimeout); err != nil {
+			return nil, err
+		}
+		offset += length
+		report("transfer", offset)
+	}
+
+	report("verify", offset)
+	restored, err := dst.RestoreVolume(ctx, t.TransferID, snap.SHA256, t.Target)
+	if err != nil {
+		return nil, fmt.Errorf("restore %s: %w", t.Target.Name, err)
+	}
+	if err := transfer.VerifyRestore(*snap, *restored); err != nil {
+		return nil, err
+	}
+	result.Restore = *restored
+
+	// The data is verified on the target; stage cleanup is best effort.
+	_ = dst.DiscardVolumeStage(ctx, t.TransferID)
+	_ = src.DiscardVolumeStage(ctx, t.TransferID)
+	return result, nil
+}
+
+// copyChunkWithRetry copies one chunk, retrying transient failures.
+func copyChunkWithRetry(ctx context.Context, src, dst VolumeEndpoint, transferID string, offset, length int64, timeout time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= transfer.MaxChunkAttempts; attempt++ {
+		chunkCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = copyChunk(chunkCtx, src, dst, transferID, offset, length)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("copy chunk at offset %d: %w", offset, err)
+	}
+	return nil
+}
+
+// errReceiveDone stops a chunk read once the target has stopped consuming it.
+var errReceiveDone = errors.New("target stopped receiving")
+
+// copyChunk streams [offset, offset+length) from src into dst and checks the
+// target's checksum of what it wrote against the bytes that were sent.
+func copyChunk(ctx context.Context, src, dst VolumeEndpoint, transferID string, offset, length int64) error {
+	pr, pw := io.Pipe()
+	hash := sha256.New()
+
+	readErr := make(chan error, 1)
+	go func() {
+		err := src.ReadVolumeChunk(ctx, transferID, offset, length, io.MultiWriter(pw, hash))
+		pw.CloseWithError(err) // nil closes with EOF
+		readErr <- err
+	}()
+
+	res, err := dst.ReceiveVolumeChunk(ctx, transferID, offset, pr)
+	pr.CloseWithError(errReceiveDone) // unblocks a source still writing
+	rerr := <-readErr
+	if rerr != nil && !errors.Is(rerr, errReceiveDone) {
+		return fmt.Errorf("read from source: %w", rerr)
+	}
+	if err != nil {
+		return fmt.Errorf("write to target: %w", err)
+	}
+	return transfer.VerifyChunk(offset, length, hex.EncodeToString(hash.Sum(nil)), *res)
+}
+
+// =============================================================================
+// Checkpoint Transfer (experimental)
+// =============================================================================
+//
+// A container checkpoint is staged like a volume archive, so it is relayed
+// with the same chunked copy. Only the two ends differ: the source stage is
+// produced by checkpointing the container, and the target unpacks the
+// verified stage for a later RestoreCheckpoint instead of filling a volume.
+
+// CheckpointEndpoint is a node that can stage, send, receive and unpack
+// container checkpoints. SSHDockerClient implements it.
+type CheckpointEndpoint interface {
+	VolumeEndpoint
+	CheckpointContainer(ctx context.Context, transferID, containerID string) (*minion.VolumeSnapshot, error)
+	UnpackCheckpoint(ctx context.Context, transferID, sha256 string) (*minion.VolumeRestoreResult, error)
+	DiscardCheckpoint(ctx context.Context, transferID string) error
+}
+
+// checkpointStage adapts a CheckpointEndpoint to TransferVolume.
+type checkpointStage struct {
+	CheckpointEndpoint
+}
+
+func (c checkpointStage) SnapshotVolume(ctx context.Context, transferID, containerID string) (*minion.VolumeSnapshot, error) {
+	return c.CheckpointContainer(ctx, transferID, containerID)
+}
+
+func (c checkpointStage) RestoreVolume(ctx context.Context, transferID, sha256 string, _ VolumeSpec) (*minion.VolumeRestoreResult, error) {
+	return c.UnpackCheckpoint(ctx, transferID, sha256)
+}
+
+// TransferCheckpoint checkpoints the container t.Source on src, copies the
+// checkpoint to dst and unpacks it there. It resumes like TransferVolume;
+// the container is only checkpointed on the first call. t.Target.Name only
+// names the checkpoint in errors.
+func TransferCheckpoint(ctx context.Context, src, dst CheckpointEndpoint, t VolumeTransfer, progress func(VolumeTransferProgress)) (*VolumeTransferResult, error) {
+	return TransferVolume(ctx, checkpointStage{src}, checkpointStage{dst}, t, progress)
+}
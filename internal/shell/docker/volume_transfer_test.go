@@ -0,0 +1,289 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	"github.com/artpar/hoster/internal/core/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// Fake Volume Endpoint
+// =============================================================================
+
+// memEndpoint keeps stages and volumes in memory. A volume's "manifest digest"
+// is the hash of its bytes, which is enough to exercise verification.
+type memEndpoint struct {
+	mu       sync.Mutex
+	volumes  map[string][]byte
+	stages   map[string][]byte
+	reads    int
+	failRead int  // fail this many ReadVolumeChunk calls midway
+	corrupt  bool // flip a byte in every received chunk
+	received []int64
+}
+
+func newMemEndpoint() *memEndpoint {
+	return &memEndpoint{volumes: map[string][]byte{}, stages: map[string][]byte{}}
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *memEndpoint) SnapshotVolume(_ context.Context, id, name string) (*minion.VolumeSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.volumes[name]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	if _, staged := m.stages[id]; !staged {
+		m.stages[id] = append([]byte(nil), data...)
+	}
+	stage := m.stages[id]
+	return &minion.VolumeSnapshot{TransferID: id, Volume: name, Size: int64(len(stage)),
+		SHA256: digestOf(stage), Files: 1, ManifestDigest: digestOf(stage)}, nil
+}
+
+func (m *memEndpoint) ReadVolumeChunk(_ context.Context, id string, offset, length int64, w io.Writer) error {
+	m.mu.Lock()
+	stage := m.stages[id]
+	m.reads++
+	fail := m.failRead > 0
+	if fail {
+		m.failRead--
+	}
+	m.mu.Unlock()
+
+	chunk := stage[offset : offset+length]
+	if fail {
+		w.Write(chunk[:len(chunk)/2])
+		return errors.New("connection reset")
+	}
+	_, err := w.Write(chunk)
+	return err
+}
+
+func (m *memEndpoint) ReceiveVolumeChunk(_ context.Context, id string, offset int64, r io.Reader) (*minion.VolumeChunkResult, error) {
+	data, _ := io.ReadAll(r) // a failed source just ends the stream early
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.corrupt && len(data) > 0 {
+		data[0] ^= 0xff
+	}
+	stage := append(m.stages[id][:offset:offset], data...)
+	m.stages[id] = stage
+	m.received = append(m.received, offset)
+	return &minion.VolumeChunkResult{Offset: offset, Written: int64(len(data)), SHA256: digestOf(data), StagedSize: int64(len(stage))}, nil
+}
+
+func (m *memEndpoint) StagedVolumeSize(_ context.Context, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.stages[id])), nil
+}
+
+func (m *memEndpoint) RestoreVolume(_ context.Context, id, sum string, spec VolumeSpec) (*minion.VolumeRestoreResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stage, ok := m.stages[id]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	if digestOf(stage) != sum {
+		delete(m.stages, id)
+		return nil, ErrChecksumMismatch
+	}
+	m.volumes[spec.Name] = append([]byte(nil), stage...)
+	return &minion.VolumeRestoreResult{Volume: spec.Name, Size: int64(len(stage)), SHA256: sum, Files: 1, ManifestDigest: digestOf(stage)}, nil
+}
+
+func (m *memEndpoint) DiscardVolumeStage(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.stages, id)
+	return nil
+}
+
+func testVolumeData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+// =============================================================================
+// TransferVolume Tests
+// =============================================================================
+
+func TestTransferVolume_CopiesAndVerifies(t *testing.T) {
+	src, dst := newMemEndpoint(), newMemEndpoint()
+	src.volumes["vol_a"] = testVolumeData(int(2*transfer.MinChunkSize + 100))
+
+	var phases []string
+	var last VolumeTransferProgress
+	res, err := TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.vol_a",
+		Source:     "vol_a",
+		Target:     VolumeSpec{Name: "vol_a"},
+		ChunkSize:  transfer.MinChunkSize,
+	}, func(p VolumeTransferProgress) {
+		phases = append(phases, p.Phase)
+		last = p
+	})
+	require.NoError(t, err)
+
+	assert.True(t, bytes.Equal(src.volumes["vol_a"], dst.volumes["vol_a"]))
+	assert.Equal(t, []int64{0, transfer.MinChunkSize, 2 * transfer.MinChunkSize}, dst.received)
+	assert.Equal(t, []string{"snapshot", "transfer", "transfer", "transfer", "verify"}, phases)
+	assert.Equal(t, last.TotalBytes, last.TransferredBytes)
+	assert.Equal(t, int64(0), res.Resumed)
+	assert.Empty(t, src.stages, "source stage removed")
+	assert.Empty(t, dst.stages, "target stage removed")
+}
+
+func TestTransferVolume_ResumesFromStagedOffset(t *testing.T) {
+	src, dst := newMemEndpoint(), newMemEndpoint()
+	data := testVolumeData(int(3 * transfer.MinChunkSize))
+	src.volumes["v"] = data
+	dst.stages["vm_1.v"] = append([]byte(nil), data[:transfer.MinChunkSize]...)
+
+	res, err := TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"}, ChunkSize: transfer.MinChunkSize,
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, transfer.MinChunkSize, res.Resumed)
+	assert.Equal(t, []int64{transfer.MinChunkSize, 2 * transfer.MinChunkSize}, dst.received)
+	assert.True(t, bytes.Equal(data, dst.volumes["v"]))
+}
+
+func TestTransferVolume_RetriesFailedChunk(t *testing.T) {
+	src, dst := newMemEndpoint(), newMemEndpoint()
+	src.volumes["v"] = testVolumeData(int(transfer.MinChunkSize))
+	src.failRead = 1
+
+	_, err := TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, src.reads)
+	assert.True(t, bytes.Equal(src.volumes["v"], dst.volumes["v"]))
+}
+
+func TestTransferVolume_ChunkChecksumMismatchFails(t *testing.T) {
+	src, dst := newMemEndpoint(), newMemEndpoint()
+	src.volumes["v"] = testVolumeData(1024)
+	dst.corrupt = true
+
+	_, err := TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, transfer.ErrChunkMismatch)
+	assert.Equal(t, transfer.MaxChunkAttempts, src.reads)
+	assert.NotContains(t, dst.volumes, "v", "target volume untouched")
+	assert.Contains(t, src.stages, "vm_1.v", "source stage kept for resume")
+}
+
+func TestTransferVolume_RestoreChecksumMismatchDropsStage(t *testing.T) {
+	src, dst := newMemEndpoint(), newMemEndpoint()
+	data := testVolumeData(2048)
+	src.volumes["v"] = data
+	// A stale stage of the right size but wrong contents resumes past the end.
+	dst.stages["vm_1.v"] = make([]byte, len(data))
+
+	_, err := TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NotContains(t, dst.stages, "vm_1.v")
+
+	// The next attempt starts over and succeeds.
+	_, err = TransferVolume(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, dst.volumes["v"]))
+}
+
+func TestTransferVolume_InvalidTransferID(t *testing.T) {
+	_, err := TransferVolume(context.Background(), newMemEndpoint(), newMemEndpoint(), VolumeTransfer{
+		TransferID: "../etc", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	assert.ErrorIs(t, err, transfer.ErrInvalidTransferID)
+}
+
+func TestTransferVolume_MissingSourceVolume(t *testing.T) {
+	_, err := TransferVolume(context.Background(), newMemEndpoint(), newMemEndpoint(), VolumeTransfer{
+		TransferID: "vm_1.v", Source: "v", Target: VolumeSpec{Name: "v"},
+	}, nil)
+	assert.ErrorIs(t, err, ErrVolumeNotFound)
+}
+
+// =============================================================================
+// TransferCheckpoint Tests
+// =============================================================================
+
+// memCheckpointEndpoint treats "containers" as volumes: a checkpoint stages
+// the container's state once, and unpacking keeps the verified stage.
+type memCheckpointEndpoint struct {
+	*memEndpoint
+	checkpoints int
+	unpacked    map[string][]byte
+}
+
+func (m *memCheckpointEndpoint) CheckpointContainer(ctx context.Context, id, containerID string) (*minion.VolumeSnapshot, error) {
+	m.mu.Lock()
+	if _, staged := m.stages[id]; !staged {
+		m.checkpoints++
+	}
+	m.mu.Unlock()
+	return m.SnapshotVolume(ctx, id, containerID)
+}
+
+func (m *memCheckpointEndpoint) UnpackCheckpoint(ctx context.Context, id, sum string) (*minion.VolumeRestoreResult, error) {
+	res, err := m.RestoreVolume(ctx, id, sum, VolumeSpec{Name: id})
+	if err != nil {
+		return nil, err
+	}
+	m.unpacked[id] = m.volumes[id]
+	return res, nil
+}
+
+func (m *memCheckpointEndpoint) DiscardCheckpoint(ctx context.Context, id string) error {
+	delete(m.unpacked, id)
+	return m.DiscardVolumeStage(ctx, id)
+}
+
+func TestTransferCheckpoint_CopiesAndUnpacks(t *testing.T) {
+	src := &memCheckpointEndpoint{memEndpoint: newMemEndpoint(), unpacked: map[string][]byte{}}
+	dst := &memCheckpointEndpoint{memEndpoint: newMemEndpoint(), unpacked: map[string][]byte{}}
+	src.volumes["c1"] = testVolumeData(int(transfer.MinChunkSize + 10))
+	src.failRead = 1
+
+	res, err := TransferCheckpoint(context.Background(), src, dst, VolumeTransfer{
+		TransferID: "vm_1.checkpoint-web",
+		Source:     "c1",
+		ChunkSize:  transfer.MinChunkSize,
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, src.checkpoints)
+	assert.True(t, bytes.Equal(src.volumes["c1"], dst.unpacked["vm_1.checkpoint-web"]))
+	assert.Equal(t, res.Snapshot.SHA256, res.Restore.SHA256)
+	assert.Empty(t, src.stages, "source stage removed")
+	assert.Empty(t, dst.stages, "target stage removed")
+}
@@ -0,0 +1,135 @@
+// Package logging carries a request- or command-scoped slog logger in a
+// context, so logs written while serving a request, running a bus command or
+// calling a node's minion share its correlation fields (request ID, user,
+// resource, node), and samples noisy debug logs.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// =============================================================================
+// Context Logger
+// =============================================================================
+
+type loggerKey struct{}
+
+// scoped is a context's logger and the field keys added to it with With.
+type scoped struct {
+	logger *slog.Logger
+	keys   map[string]bool
+}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, scoped{logger: logger})
+}
+
+// FromContext returns the context's logger, or fallback when it has none.
+// A nil fallback means slog.Default().
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if s, ok := ctx.Value(loggerKey{}).(scoped); ok {
+		return s.logger
+	}
+	if fallback == nil {
+		return slog.Default()
+	}
+	return fallback
+}
+
+// With returns a context whose logger, the context's or fallback, also
+// carries args, and that logger. args are key-value pairs or slog.Attrs;
+// keys already added to the context's logger with With are kept as they
+// are rather than repeated, so the outermost scope names the request.
+func With(ctx context.Context, fallback *slog.Logger, args ...any) (context.Context, *slog.Logger) {
+	s, _ := ctx.Value(loggerKey{}).(scoped)
+	keys := make(map[string]bool, len(s.keys)+len(args)/2)
+	for k := range s.keys {
+		keys[k] = true
+	}
+	var kept []any
+	for i := 0; i < len(args); {
+		var key string
+		var field []any
+		switch a := args[i].(type) {
+		case slog.Attr:
+			key, field = a.Key, args[i:i+1]
+			i++
+		case string:
+			if i+1 == len(args) {
+				kept = append(kept, a)
+				i++
+				continue
+			}
+			key, field = a, args[i:i+2]
+			i += 2
+		default:
+			kept = append(kept, a)
+			i++
+			continue
+		}
+		if keys[key] {
+			continue
+		}
+		keys[key] = true
+		kept = append(kept, field...)
+	}
+
+	logger := FromContext(ctx, fallback).With(kept...)
+	return context.WithValue(ctx, loggerKey{}, scoped{logger: logger, keys: keys}), logger
+}
+
+// =============================================================================
+// Debug Sampling
+// =============================================================================
+
+// SamplingHandler passes every record at Info and above, and one in every
+// `every` debug records with the same message: the first, then each
+// every-th. Loggers derived with With share the counts, so per-request
+// loggers are sampled together.
+type SamplingHandler struct {
+	next   slog.Handler
+	every  uint64
+	counts *sync.Map // message -> *atomic.Uint64
+}
+
+// NewSamplingHandler wraps next to sample debug records. every <= 1 keeps
+// them all and returns next unchanged.
+func NewSamplingHandler(next slog.Handler, every int) slog.Handler {
+	if every <= 1 {
+		return next
+	}
+	return &SamplingHandler{next: next, every: uint64(every), counts: &sync.Map{}}
+}
+
+// Enabled implements slog.Handler.
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler. Kept debug records carry the sample rate.
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelInfo {
+		return h.next.Handle(ctx, r)
+	}
+	v, _ := h.counts.LoadOrStore(r.Message, new(atomic.Uint64))
+	if n := v.(*atomic.Uint64).Add(1); (n-1)%h.every != 0 {
+		return nil
+	}
+	r = r.Clone()
+	r.AddAttrs(slog.Uint64("sample_rate", h.every))
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{next: h.next.WithAttrs(attrs), every: h.every, counts: h.counts}
+}
+
+// WithGroup implements slog.Handler.
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{next: h.next.WithGroup(name), every: h.every, counts: h.counts}
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromContext(t *testing.T) {
+	fallback := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	assert.Same(t, fallback, FromContext(context.Background(), fallback))
+	assert.Same(t, slog.Default(), FromContext(context.Background(), nil))
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	ctx := WithLogger(context.Background(), logger)
+	assert.Same(t, logger, FromContext(ctx, fallback))
+
+	ctx, enriched := With(ctx, fallback, "request_id", "req_1")
+	assert.Same(t, enriched, FromContext(ctx, nil))
+	_, nested := With(ctx, nil, "node_id", "node_1", slog.String("request_id", "req_2"))
+	nested.Info("hello")
+	assert.Contains(t, buf.String(), "request_id=req_1 node_id=node_1\n", "keys already set are not repeated")
+}
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	base := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	assert.Equal(t, slog.Handler(base), NewSamplingHandler(base, 1), "1 keeps everything")
+
+	logger := slog.New(NewSamplingHandler(base, 3))
+	for i := 0; i < 7; i++ {
+		// Derived loggers share the counts
+		logger.With("i", i).Debug("polling")
+	}
+	logger.Debug("other")
+	for i := 0; i < 2; i++ {
+		logger.Info("kept")
+	}
+
+	out := buf.String()
+	assert.Equal(t, 3, strings.Count(out, "msg=polling"), "first, fourth and seventh")
+	assert.Contains(t, out, "i=0 sample_rate=3")
+	assert.Contains(t, out, "i=3 sample_rate=3")
+	assert.Contains(t, out, "i=6 sample_rate=3")
+	assert.Equal(t, 1, strings.Count(out, "msg=other"), "counted per message")
+	assert.Equal(t, 2, strings.Count(out, "msg=kept"), "info is never sampled")
+}
@@ -0,0 +1,167 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpServer is a minimal SMTP relay that records one session.
+type smtpServer struct {
+	ln       net.Listener
+	auth     bool
+	rcptCode string
+	done     chan struct{}
+
+	from  string
+	to    []string
+	plain string
+	data  string
+}
+
+func newSMTPServer(t *testing.T, auth bool) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpServer{ln: ln, auth: auth, rcptCode: "250", done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 test ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			if s.auth {
+				reply("250-test")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250 test")
+			}
+		case "AUTH":
+			fields := strings.Fields(line)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.plain = string(decoded)
+			reply("235 ok")
+		case "MAIL":
+			s.from = line
+			reply("250 ok")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply(s.rcptCode + " rcpt")
+		case "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.data = b.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestNewSMTPMailer_Errors(t *testing.T) {
+	_, err := NewSMTPMailer(SMTPConfig{Port: 25, From: "hoster@example.com"})
+	assert.ErrorContains(t, err, "host")
+	_, err = NewSMTPMailer(SMTPConfig{Host: "smtp.example.com", Port: 0, From: "hoster@example.com"})
+	assert.ErrorContains(t, err, "port")
+	_, err = NewSMTPMailer(SMTPConfig{Host: "smtp.example.com", Port: 25, From: "hoster"})
+	assert.ErrorContains(t, err, "from address")
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	srv := newSMTPServer(t, true)
+	m, err := NewSMTPMailer(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     srv.port(),
+		Username: "relay",
+		Password: "s3cret",
+		From:     "Hoster <hoster@example.com>",
+	})
+	require.NoError(t, err)
+	m.now = func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	err = m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Invitation to deployment blog",
+		Body:    "Hello\nAccept here.",
+	})
+	require.NoError(t, err)
+	<-srv.done
+
+	assert.Equal(t, "\x00relay\x00s3cret", srv.plain)
+	assert.Equal(t, "MAIL FROM:<hoster@example.com>", srv.from)
+	assert.Equal(t, []string{"RCPT TO:<alice@example.com>"}, srv.to)
+	assert.Contains(t, srv.data, "From: \"Hoster\" <hoster@example.com>\r\n")
+	assert.Contains(t, srv.data, "To: <alice@example.com>\r\n")
+	assert.Contains(t, srv.data, "Subject: Invitation to deployment blog\r\n")
+	assert.Contains(t, srv.data, "Date: Sat, 01 Mar 2025 12:00:00 +0000\r\n")
+	assert.Contains(t, srv.data, "@example.com>\r\n")
+	assert.True(t, strings.HasSuffix(srv.data, "\r\n\r\nHello\r\nAccept here.\r\n"), srv.data)
+}
+
+func TestSMTPMailer_SendRejected(t *testing.T) {
+	srv := newSMTPServer(t, false)
+	srv.rcptCode = "550"
+	m, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: srv.port(), From: "hoster@example.com"})
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), Message{To: "nobody@example.com", Subject: "x", Body: "x"})
+	assert.ErrorContains(t, err, "rcpt to")
+
+	err = m.Send(context.Background(), Message{To: "not an address", Subject: "x", Body: "x"})
+	assert.ErrorContains(t, err, "recipient")
+}
+
+func TestSMTPMailer_DialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	m, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: port, From: "hoster@example.com", Timeout: time.Second})
+	require.NoError(t, err)
+	err = m.Send(context.Background(), Message{To: "alice@example.com", Subject: "x", Body: "x"})
+	assert.ErrorContains(t, err, "smtp dial")
+}
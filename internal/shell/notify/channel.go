@@ -0,0 +1,81 @@
+// Package notify delivers user notifications to external channels (Slack,
+// browser Web Push). Each channel kind implements NotificationChannel and is
+// registered by type, so new kinds plug in without touching the callers.
+// WebhookSender posts signed events to users' webhook endpoints.
+// This is part of the Imperative Shell - handles I/O (HTTP calls to Slack,
+// push services and webhook endpoints).
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+)
+
+// NotificationChannel delivers events to one configured destination.
+type NotificationChannel interface {
+	// Type returns the kind of channel.
+	Type() corenotify.ChannelType
+	// Send delivers one event. It returns ErrChannelGone when the destination
+	// no longer exists and the channel should be disabled.
+	Send(ctx context.Context, ev corenotify.Event) error
+}
+
+// ErrChannelGone is returned when a destination has been removed, e.g. an
+// expired push subscription or an uninstalled Slack webhook.
+var ErrChannelGone = errors.New("notification destination no longer exists")
+
+// Factory builds a channel from its JSON configuration.
+type Factory func(config []byte) (NotificationChannel, error)
+
+// =============================================================================
+// Registry
+// =============================================================================
+
+// Registry maps channel types to the factories that build them.
+type Registry struct {
+	factories map[corenotify.ChannelType]Factory
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[corenotify.ChannelType]Factory)}
+}
+
+// Register adds (or replaces) the factory for a channel type.
+func (r *Registry) Register(t corenotify.ChannelType, f Factory) {
+	r.factories[t] = f
+}
+
+// Supports reports whether channels of type t can be built.
+func (r *Registry) Supports(t corenotify.ChannelType) bool {
+	_, ok := r.factories[t]
+	return ok
+}
+
+// Types lists the registered channel types in name order.
+func (r *Registry) Types() []corenotify.ChannelType {
+	types := make([]corenotify.ChannelType, 0, len(r.factories))
+	for t := range r.factories {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// New builds a channel of type t from its configuration.
+func (r *Registry) New(t corenotify.ChannelType, config []byte) (NotificationChannel, error) {
+	f, ok := r.factories[t]
+	if !ok {
+		return nil, fmt.Errorf("channel type %q is not enabled", t)
+	}
+	return f(config)
+}
+
+// defaultHTTPClient is shared by channels built without a client.
+var defaultHTTPClient = &http.Client{Timeout: 15 * time.Second}
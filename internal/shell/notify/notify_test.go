@@ -0,0 +1,324 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+	corewebhook "github.com/artpar/hoster/internal/core/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvent = corenotify.Event{
+	Type:       corenotify.EventDeploymentFailed,
+	Severity:   corenotify.SeverityCritical,
+	Title:      "Deployment blog failed",
+	Message:    "image not found",
+	ResourceID: "depl_1",
+}
+
+// =============================================================================
+// Registry Tests
+// =============================================================================
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register(corenotify.ChannelSlack, SlackFactory(nil))
+
+	assert.True(t, r.Supports(corenotify.ChannelSlack))
+	assert.False(t, r.Supports(corenotify.ChannelWebPush))
+	assert.Equal(t, []corenotify.ChannelType{corenotify.ChannelSlack}, r.Types())
+
+	ch, err := r.New(corenotify.ChannelSlack, []byte(`{"webhook_url":"https://hooks.slack.com/services/x"}`))
+	require.NoError(t, err)
+	assert.Equal(t, corenotify.ChannelSlack, ch.Type())
+
+	_, err = r.New(corenotify.ChannelSlack, []byte(`{}`))
+	assert.ErrorIs(t, err, corenotify.ErrInvalidConfig)
+
+	_, err = r.New(corenotify.ChannelWebPush, []byte(`{}`))
+	assert.ErrorContains(t, err, "not enabled")
+}
+
+// =============================================================================
+// Slack Tests
+// =============================================================================
+
+func TestSlackChannel_Webhook(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/services/T0/B0/x", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	ch, err := NewSlackChannel(corenotify.SlackConfig{WebhookURL: server.URL + "/services/T0/B0/x"}, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, ch.Send(context.Background(), testEvent))
+	assert.Equal(t, ":rotating_light: Deployment blog failed", got["text"])
+	assert.NotContains(t, got, "channel")
+}
+
+func TestSlackChannel_WebhookErrors(t *testing.T) {
+	reply := "no_service"
+	status := http.StatusNotFound
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	defer server.Close()
+
+	ch, err := NewSlackChannel(corenotify.SlackConfig{WebhookURL: server.URL}, server.Client())
+	require.NoError(t, err)
+
+	err = ch.Send(context.Background(), testEvent)
+	assert.ErrorIs(t, err, ErrChannelGone)
+
+	status, reply = http.StatusInternalServerError, "rollup_error"
+	err = ch.Send(context.Background(), testEvent)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrChannelGone)
+	assert.ErrorContains(t, err, "500 rollup_error")
+}
+
+func TestSlackChannel_PostMessage(t *testing.T) {
+	var got map[string]any
+	reply := `{"ok":true}`
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat.postMessage", r.URL.Path)
+		assert.Equal(t, "Bearer xoxb-1-2-abc", r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		io.WriteString(w, reply)
+	}))
+	defer server.Close()
+
+	ch, err := NewSlackChannel(corenotify.SlackConfig{Token: "xoxb-1-2-abc", Channel: "#ops"}, server.Client())
+	require.NoError(t, err)
+	ch.apiURL = server.URL + "/api"
+
+	require.NoError(t, ch.Send(context.Background(), testEvent))
+	assert.Equal(t, "#ops", got["channel"])
+
+	reply = `{"ok":false,"error":"channel_not_found"}`
+	assert.ErrorIs(t, ch.Send(context.Background(), testEvent), ErrChannelGone)
+
+	reply = `{"ok":false,"error":"ratelimited"}`
+	err = ch.Send(context.Background(), testEvent)
+	assert.NotErrorIs(t, err, ErrChannelGone)
+	assert.ErrorContains(t, err, "ratelimited")
+}
+
+// =============================================================================
+// Web Push Tests
+// =============================================================================
+
+func testVAPID(t *testing.T) *VAPID {
+	t.Helper()
+	pub, priv, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	v, err := NewVAPID(VAPIDConfig{PublicKey: pub, PrivateKey: priv, Subject: "mailto:ops@example.com"})
+	require.NoError(t, err)
+	return v
+}
+
+func TestNewVAPID_Errors(t *testing.T) {
+	pub, priv, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	otherPub, _, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+
+	_, err = NewVAPID(VAPIDConfig{PublicKey: pub, PrivateKey: priv, Subject: "ops@example.com"})
+	assert.ErrorContains(t, err, "subject")
+	_, err = NewVAPID(VAPIDConfig{PublicKey: otherPub, PrivateKey: priv, Subject: "mailto:ops@example.com"})
+	assert.ErrorContains(t, err, "does not match")
+	_, err = NewVAPID(VAPIDConfig{PublicKey: pub, PrivateKey: "!!", Subject: "mailto:ops@example.com"})
+	assert.ErrorContains(t, err, "private key")
+}
+
+// browser is a push subscriber: it holds the keys a subscription is made of
+// and can decrypt what the push service delivers.
+type browser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &browser{key: key, auth: auth}
+}
+
+func (b *browser) subscription(endpoint string) corenotify.WebPushSubscription {
+	var s corenotify.WebPushSubscription
+	s.Endpoint = endpoint
+	s.Keys.P256dh = base64.RawURLEncoding.EncodeToString(b.key.PublicKey().Bytes())
+	s.Keys.Auth = base64.RawURLEncoding.EncodeToString(b.auth)
+	return s
+}
+
+// decrypt reverses the aes128gcm coding of a single-record push message.
+func (b *browser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	assert.Equal(t, uint32(4096), rs)
+	asPublic, ciphertext := body[21:21+idLen], body[21+idLen:]
+
+	asKey, err := ecdh.P256().NewPublicKey(asPublic)
+	require.NoError(t, err)
+	secret, err := b.key.ECDH(asKey)
+	require.NoError(t, err)
+	info := "WebPush: info\x00" + string(b.key.PublicKey().Bytes()) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, secret, b.auth, info, 32)
+	require.NoError(t, err)
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	require.NoError(t, err)
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	require.NoError(t, err)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x02), plain[len(plain)-1], "final record delimiter")
+	return plain[:len(plain)-1]
+}
+
+// verifyVAPID checks the Authorization header against the server's key and
+// returns the token claims.
+func verifyVAPID(t *testing.T, header string, v *VAPID) map[string]any {
+	t.Helper()
+	require.True(t, strings.HasPrefix(header, "vapid t="))
+	parts := strings.SplitN(strings.TrimPrefix(header, "vapid t="), ", k=", 2)
+	require.Len(t, parts, 2)
+	assert.Equal(t, v.PublicKey(), parts[1])
+
+	segments := strings.Split(parts[0], ".")
+	require.Len(t, segments, 3)
+	sig, err := base64.RawURLEncoding.DecodeString(segments[2])
+	require.NoError(t, err)
+	require.Len(t, sig, 64)
+	pub, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	assert.True(t, ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:],
+		new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])), "ES256 signature")
+
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(segments[1])
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+	return claims
+}
+
+func TestWebPushChannel_Send(t *testing.T) {
+	v := testVAPID(t)
+	b := newBrowser(t)
+	var got map[string]any
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "86400", r.Header.Get("TTL"))
+		assert.Equal(t, "high", r.Header.Get("Urgency"))
+
+		claims := verifyVAPID(t, r.Header.Get("Authorization"), v)
+		assert.Equal(t, "https://"+r.Host, claims["aud"])
+		assert.Equal(t, "mailto:ops@example.com", claims["sub"])
+
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b.decrypt(t, body), &got)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	ch, err := NewWebPushChannel(b.subscription(server.URL+"/push/abc"), v, server.Client())
+	require.NoError(t, err)
+	require.NoError(t, ch.Send(context.Background(), testEvent))
+	assert.Equal(t, "Deployment blog failed", got["title"])
+	assert.Equal(t, "image not found", got["body"])
+	assert.Equal(t, "deployment.failed:depl_1", got["tag"])
+}
+
+func TestWebPushChannel_Errors(t *testing.T) {
+	v := testVAPID(t)
+	status := http.StatusGone
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, "quota exceeded")
+	}))
+	defer server.Close()
+
+	ch, err := NewWebPushChannel(newBrowser(t).subscription(server.URL), v, server.Client())
+	require.NoError(t, err)
+	assert.ErrorIs(t, ch.Send(context.Background(), testEvent), ErrChannelGone)
+
+	status = http.StatusTooManyRequests
+	err = ch.Send(context.Background(), testEvent)
+	assert.NotErrorIs(t, err, ErrChannelGone)
+	assert.ErrorContains(t, err, "429 quota exceeded")
+
+	var bad corenotify.WebPushSubscription
+	bad.Endpoint = server.URL
+	_, err = NewWebPushChannel(bad, v, nil)
+	assert.ErrorIs(t, err, corenotify.ErrInvalidConfig)
+}
+
+// =============================================================================
+// Webhook Tests
+// =============================================================================
+
+func TestWebhookSender_Post(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(strings.Repeat("x", corewebhook.MaxResponseBodyBytes*2)))
+	}))
+	defer srv.Close()
+
+	resp, err := NewWebhookSender(srv.Client()).Post(context.Background(), srv.URL, secret, corewebhook.Payload{
+		ID:   "evt_1",
+		Type: string(testEvent.Type),
+		Data: testEvent,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Len(t, resp.Body, corewebhook.MaxResponseBodyBytes+1)
+
+	assert.Equal(t, "evt_1", got.Header.Get(corewebhook.EventIDHeader))
+	assert.Equal(t, "deployment.failed", got.Header.Get(corewebhook.EventTypeHeader))
+	assert.NoError(t, corewebhook.Verify(secret, got.Header.Get(corewebhook.SignatureHeader), gotBody, time.Now(), time.Minute))
+}
+
+func TestWebhookSender_NoRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	resp, err := NewWebhookSender(nil).Post(context.Background(), srv.URL, []byte("0123456789abcdef"), corewebhook.Payload{ID: "evt_1"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+)
+
+// slackAPIURL is the Slack Web API base used for token-based posting.
+const slackAPIURL = "https://slack.com/api"
+
+// SlackChannel posts events to Slack through an incoming webhook or, with an
+// app token, chat.postMessage.
+type SlackChannel struct {
+	config     corenotify.SlackConfig
+	apiURL     string
+	httpClient *http.Client
+}
+
+// NewSlackChannel creates a Slack channel. httpClient may be nil.
+func NewSlackChannel(config corenotify.SlackConfig, httpClient *http.Client) (*SlackChannel, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+	return &SlackChannel{config: config, apiURL: slackAPIURL, httpClient: httpClient}, nil
+}
+
+// SlackFactory returns a Factory for Slack channels.
+func SlackFactory(httpClient *http.Client) Factory {
+	return func(config []byte) (NotificationChannel, error) {
+		var c corenotify.SlackConfig
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, fmt.Errorf("%w: %v", corenotify.ErrInvalidConfig, err)
+		}
+		return NewSlackChannel(c, httpClient)
+	}
+}
+
+// Type returns corenotify.ChannelSlack.
+func (s *SlackChannel) Type() corenotify.ChannelType {
+	return corenotify.ChannelSlack
+}
+
+// Send posts the event.
+func (s *SlackChannel) Send(ctx context.Context, ev corenotify.Event) error {
+	if s.config.WebhookURL != "" {
+		return s.sendWebhook(ctx, ev)
+	}
+	return s.postMessage(ctx, ev)
+}
+
+// sendWebhook posts to an incoming webhook, which answers "ok" or an error
+// string such as "no_service" for a removed webhook.
+func (s *SlackChannel) sendWebhook(ctx context.Context, ev corenotify.Event) error {
+	status, body, err := s.post(ctx, s.config.WebhookURL, "", corenotify.SlackMessage(ev, ""))
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	reason := strings.TrimSpace(string(body))
+	if status == http.StatusNotFound || status == http.StatusGone || reason == "no_service" || reason == "channel_is_archived" {
+		return fmt.Errorf("%w: slack webhook: %s", ErrChannelGone, reason)
+	}
+	return fmt.Errorf("slack webhook: %d %s", status, reason)
+}
+
+// slackAPIResponse is the envelope of a Slack Web API call.
+type slackAPIResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// postMessage calls chat.postMessage with the app token.
+func (s *SlackChannel) postMessage(ctx context.Context, ev corenotify.Event) error {
+	status, body, err := s.post(ctx, s.apiURL+"/chat.postMessage", s.config.Token, corenotify.SlackMessage(ev, s.config.Channel))
+	if err != nil {
+		return err
+	}
+	var parsed slackAPIResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return fmt.Errorf("slack chat.postMessage: %d %s", status, http.StatusText(status))
+	}
+	if parsed.OK {
+		return nil
+	}
+	switch parsed.Error {
+	case "channel_not_found", "is_archived", "account_inactive", "token_revoked", "invalid_auth":
+		return fmt.Errorf("%w: slack chat.postMessage: %s", ErrChannelGone, parsed.Error)
+	}
+	return fmt.Errorf("slack chat.postMessage: %s", parsed.Error)
+}
+
+// post sends a JSON body and returns the status and (bounded) response body.
+func (s *SlackChannel) post(ctx context.Context, url, token string, payload any) (int, []byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("marshal slack message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return 0, nil, fmt.Errorf("slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("slack post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return 0, nil, fmt.Errorf("slack post: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
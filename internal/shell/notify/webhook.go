@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corewebhook "github.com/artpar/hoster/internal/core/webhook"
+)
+
+// WebhookSender posts signed event payloads to users' webhook endpoints.
+type WebhookSender struct {
+	httpClient *http.Client
+}
+
+// WebhookResponse is what an endpoint answered to a delivery. Body holds at
+// most corewebhook.MaxResponseBodyBytes+1 bytes, so callers can tell it was
+// cut.
+type WebhookResponse struct {
+	StatusCode int
+	Body       []byte
+	Duration   time.Duration
+}
+
+// NewWebhookSender creates a sender. httpClient may be nil. Redirects are
+// not followed: an endpoint answering 3xx has not received the event.
+func NewWebhookSender(httpClient *http.Client) *WebhookSender {
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: defaultHTTPClient.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return &WebhookSender{httpClient: httpClient}
+}
+
+// Post sends a payload signed with secret. An error means no response was
+// received; any response, successful or not, is returned as is.
+func (s *WebhookSender) Post(ctx context.Context, url string, secret []byte, p corewebhook.Payload) (*WebhookResponse, error) {
+	body, err := corewebhook.Body(p)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Hoster-Webhooks/1")
+	req.Header.Set(corewebhook.EventIDHeader, p.ID)
+	req.Header.Set(corewebhook.EventTypeHeader, p.Type)
+	req.Header.Set(corewebhook.SignatureHeader, corewebhook.Sign(secret, time.Now(), body))
+
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhook post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, corewebhook.MaxResponseBodyBytes+1))
+	return &WebhookResponse{
+		StatusCode: resp.StatusCode,
+		Body:       respBody,
+		Duration:   time.Since(start),
+	}, nil
+}
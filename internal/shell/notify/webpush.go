@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corenotify "github.com/artpar/hoster/internal/core/notify"
+)
+
+// =============================================================================
+// VAPID
+// =============================================================================
+
+// VAPIDConfig holds the server's application server keys (RFC 8292). The
+// public key is what browsers pass to pushManager.subscribe.
+type VAPIDConfig struct {
+	// PublicKey is the uncompressed P-256 public key, base64url-encoded.
+	PublicKey string
+	// PrivateKey is the 32-byte P-256 private key, base64url-encoded.
+	PrivateKey string
+	// Subject is a contact for the push service: a mailto: or https: URL.
+	Subject string
+}
+
+// VAPID signs the tokens that identify this server to push services.
+type VAPID struct {
+	key       *ecdsa.PrivateKey
+	publicKey string
+	subject   string
+	now       func() time.Time
+}
+
+// NewVAPID parses and checks a key pair.
+func NewVAPID(cfg VAPIDConfig) (*VAPID, error) {
+	if !strings.HasPrefix(cfg.Subject, "mailto:") && !strings.HasPrefix(cfg.Subject, "https://") {
+		return nil, fmt.Errorf("vapid subject must be a mailto: or https:// URL")
+	}
+	raw, err := corenotify.DecodeBase64URL(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	priv, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	pub := priv.PublicKey().Bytes()
+	if want, err := corenotify.DecodeBase64URL(cfg.PublicKey); err != nil || !bytes.Equal(want, pub) {
+		return nil, fmt.Errorf("vapid public key does not match the private key")
+	}
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+	return &VAPID{
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(pub),
+		subject:   cfg.Subject,
+		now:       time.Now,
+	}, nil
+}
+
+// GenerateVAPIDKeys creates a new key pair, base64url-encoded.
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		base64.RawURLEncoding.EncodeToString(priv.Bytes()), nil
+}
+
+// PublicKey returns the application server key browsers subscribe with.
+func (v *VAPID) PublicKey() string {
+	return v.publicKey
+}
+
+// authorization returns the Authorization header for a push endpoint: an
+// ES256 JWT for the endpoint's origin, valid for 12 hours.
+func (v *VAPID) authorization(endpoint string) (string, error) {
+	aud, err := corenotify.VAPIDAudience(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]any{
+		"aud": aud,
+		"exp": v.now().Add(12 * time.Hour).Unix(),
+		"sub": v.subject,
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign vapid token: %w", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	token := signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return "vapid t=" + token + ", k=" + v.publicKey, nil
+}
+
+// =============================================================================
+// Web Push Channel
+// =============================================================================
+
+// webPushTTL is how long a push service keeps a message for an offline browser.
+const webPushTTL = 24 * time.Hour
+
+// WebPushChannel sends events to one browser push subscription.
+type WebPushChannel struct {
+	subscription corenotify.WebPushSubscription
+	vapid        *VAPID
+	httpClient   *http.Client
+}
+
+// NewWebPushChannel creates a Web Push channel. httpClient may be nil.
+func NewWebPushChannel(sub corenotify.WebPushSubscription, vapid *VAPID, httpClient *http.Client) (*WebPushChannel, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+	return &WebPushChannel{subscription: sub, vapid: vapid, httpClient: httpClient}, nil
+}
+
+// WebPushFactory returns a Factory for Web Push channels signed by vapid.
+func WebPushFactory(vapid *VAPID, httpClient *http.Client) Factory {
+	return func(config []byte) (NotificationChannel, error) {
+		var sub corenotify.WebPushSubscription
+		if err := json.Unmarshal(config, &sub); err != nil {
+			return nil, fmt.Errorf("%w: %v", corenotify.ErrInvalidConfig, err)
+		}
+		return NewWebPushChannel(sub, vapid, httpClient)
+	}
+}
+
+// Type returns corenotify.ChannelWebPush.
+func (c *WebPushChannel) Type() corenotify.ChannelType {
+	return corenotify.ChannelWebPush
+}
+
+// Send encrypts the event for the subscription and hands it to its push
+// service.
+func (c *WebPushChannel) Send(ctx context.Context, ev corenotify.Event) error {
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate push key: %w", err)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generate push salt: %w", err)
+	}
+	body, err := corenotify.EncryptWebPush(corenotify.WebPushMessage(ev), c.subscription, asKey, salt)
+	if err != nil {
+		return err
+	}
+	auth, err := c.vapid.authorization(c.subscription.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(webPushTTL.Seconds())))
+	req.Header.Set("Urgency", corenotify.PushUrgency(ev.Severity))
+	req.Header.Set("Authorization", auth)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("push: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: push subscription expired (%d)", ErrChannelGone, resp.StatusCode)
+	}
+	return fmt.Errorf("push: %d %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+}
@@ -8,14 +8,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/artpar/hoster/internal/core/acme"
 	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/artpar/hoster/internal/core/proxy"
 	"github.com/artpar/hoster/internal/engine"
@@ -38,6 +41,22 @@ type Config struct {
 	ReadTimeout  time.Duration // HTTP read timeout
 	WriteTimeout time.Duration // HTTP write timeout
 	IdleTimeout  time.Duration // HTTP idle timeout
+
+	// WebSocketTimeout is how long an upgraded connection may stay open
+	// when the deployment's routing options don't say.
+	WebSocketTimeout time.Duration
+
+	// Meter counts responses for deployments with a canary upgrade running.
+	// Nil disables canary metering.
+	Meter *engine.TrafficMeter
+
+	// Requests counts every response's status and time to first byte for
+	// SLOs. Nil disables request metering.
+	Requests *engine.RequestMeter
+
+	// Challenges answers ACME HTTP-01 challenges for custom domains. Nil
+	// passes challenge requests to the deployment like any other.
+	Challenges http.Handler
 }
 
 // DefaultConfig returns sensible default configuration.
@@ -48,6 +67,8 @@ func DefaultConfig() Config {
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  120 * time.Second,
+
+		WebSocketTimeout: time.Hour,
 	}
 }
 
@@ -115,6 +136,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ACME challenges for custom domains are answered by hoster
+	if s.config.Challenges != nil && strings.HasPrefix(r.URL.Path, acme.ChallengePathPrefix) {
+		s.config.Challenges.ServeHTTP(w, r)
+		return
+	}
+
 	// Strip port from hostname for matching (browsers include port in Host header)
 	hostnameWithoutPort := hostname
 	if idx := strings.LastIndex(hostname, ":"); idx != -1 {
@@ -158,7 +185,30 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. Get upstream URL
+	// Count the response for SLOs. WebSocket connections are left out:
+	// they last as long as the client stays.
+	if s.config.Requests != nil && !isUpgrade(r) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK, start: time.Now()}
+		defer func() { s.config.Requests.Record(target.DeploymentID, sw.status, sw.latency()) }()
+		w = sw
+	}
+
+	// 4. Reject bodies over the deployment's limit
+	if target.MaxBodyBytes > 0 {
+		if r.ContentLength > target.MaxBodyBytes {
+			s.serveError(w, r, proxy.NewBodyTooLargeError(hostnameWithoutPort, target.MaxBodyBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, target.MaxBodyBytes)
+	}
+
+	// 5. Split traffic between a canary and the stable containers, keeping
+	// sticky sessions on the backend they started on, and spread it over
+	// the replica nodes
+	target = s.split(w, r, target)
+	target = target.Spread(spreadPick(r, target))
+
+	// 6. Get upstream URL
 	upstreamURL, err := s.getUpstreamURL(ctx, target)
 	if err != nil {
 		s.logger.Error("failed to get upstream URL", "hostname", hostname, "error", err)
@@ -166,10 +216,127 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 5. Proxy the request
+	// 7. Let WebSocket connections outlive the server's timeouts
+	if isUpgrade(r) {
+		s.extendDeadlines(w, target)
+	}
+
+	// 8. Proxy the request, counting responses while a canary runs
+	if target.CanaryPort > 0 && s.config.Meter != nil {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		s.proxyRequest(sw, r, upstreamURL, target)
+		s.config.Meter.Record(target.DeploymentID, target.Canary, sw.status)
+		return
+	}
 	s.proxyRequest(w, r, upstreamURL, target)
 }
 
+// statusWriter records the status code written through it and, when start
+// is set, how long it took to be written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+
+	start, wroteAt time.Time
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	if w.wroteAt.IsZero() {
+		w.wroteAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// latency returns the time to first byte, or the time so far if no header
+// was written.
+func (w *statusWriter) latency() time.Duration {
+	if w.wroteAt.IsZero() {
+		return time.Since(w.start)
+	}
+	return w.wroteAt.Sub(w.start)
+}
+
+// Flush lets streamed responses through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// split picks the stable or canary port for a request. With sticky sessions,
+// the cookie pins the client to the backend it was first sent to.
+func (s *Server) split(w http.ResponseWriter, r *http.Request, target proxy.ProxyTarget) proxy.ProxyTarget {
+	if target.StickyCookie == "" || target.CanaryPort == 0 {
+		return target.Split(rand.IntN(100))
+	}
+	pinned := ""
+	if c, err := r.Cookie(target.StickyCookie); err == nil {
+		pinned = c.Value
+	}
+	target = target.SplitSticky(pinned, rand.IntN(100))
+	if pinned != target.Backend() {
+		http.SetCookie(w, &http.Cookie{
+			Name:     target.StickyCookie,
+			Value:    target.Backend(),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return target
+}
+
+// spreadPick returns the number Spread picks a request's backend with:
+// random, or with sticky sessions a hash of the client's address, so a
+// client keeps its replica.
+func spreadPick(r *http.Request, target proxy.ProxyTarget) int {
+	if len(target.Replicas) == 0 {
+		return 0
+	}
+	if target.StickyCookie == "" {
+		return rand.IntN(len(target.Replicas) + 1)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(getRealIP(r)))
+	return int(h.Sum32() % uint32(len(target.Replicas)+1))
+}
+
+// isUpgrade reports whether a request asks to switch protocols, as
+// WebSocket handshakes do.
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return r.Header.Get("Upgrade") != ""
+			}
+		}
+	}
+	return false
+}
+
+// extendDeadlines replaces the server's read and write timeouts on an
+// upgrade request's connection with the WebSocket timeout. The connection
+// keeps these deadlines once the reverse proxy takes it over.
+func (s *Server) extendDeadlines(w http.ResponseWriter, target proxy.ProxyTarget) {
+	timeout := target.WebSocketTimeout
+	if timeout <= 0 {
+		timeout = s.config.WebSocketTimeout
+	}
+	if timeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		s.logger.Debug("failed to extend read deadline", "deployment", target.DeploymentID, "error", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		s.logger.Debug("failed to extend write deadline", "deployment", target.DeploymentID, "error", err)
+	}
+}
+
 func (s *Server) resolveTarget(ctx context.Context, slug, hostname string) (proxy.ProxyTarget, error) {
 	// Query database for deployment by domain hostname
 	deployment, err := s.store.GetDeploymentByDomain(ctx, hostname)
@@ -191,12 +358,14 @@ func (s *Server) resolveTarget(ctx context.Context, slug, hostname string) (prox
 	}
 
 	target := proxy.ProxyTarget{
-		DeploymentID: deployment.ReferenceID,
-		NodeID:       deployment.NodeID,
-		Port:         deployment.ProxyPort,
-		Status:       string(deployment.Status),
-		CustomerID:   fmt.Sprintf("%d", deployment.CustomerID),
-	}
+		DeploymentID:  deployment.ReferenceID,
+		NodeID:        deployment.NodeID,
+		Port:          deployment.ProxyPort,
+		Status:        string(deployment.Status),
+		CustomerID:    fmt.Sprintf("%d", deployment.CustomerID),
+		CanaryPort:    deployment.CanaryPort,
+		CanaryPercent: deployment.CanaryPercent,
+	}.WithRouting(deployment.RoutingOptions)
 
 	// Look up node IP for remote deployments
 	if !target.IsLocal() && deployment.NodeID != "" {
@@ -208,6 +377,19 @@ func (s *Server) resolveTarget(ctx context.Context, slug, hostname string) (prox
 		target.NodeIP = sshHost
 	}
 
+	// Running replica nodes of the routed service share the traffic
+	for _, r := range deployment.Replicas {
+		if r.Status != domain.ReplicaRunning || r.ProxyPort == 0 {
+			continue
+		}
+		host, err := s.store.GetNodeSSHHost(ctx, r.NodeID)
+		if err != nil {
+			s.logger.Warn("failed to get replica node SSH host", "deployment", deployment.ReferenceID, "node_id", r.NodeID, "error", err)
+			continue
+		}
+		target.Replicas = append(target.Replicas, proxy.Backend{NodeID: r.NodeID, NodeIP: host, Port: r.ProxyPort})
+	}
+
 	return target, nil
 }
 
@@ -233,6 +415,11 @@ func (s *Server) proxyRequest(w http.ResponseWriter, r *http.Request, upstream *
 
 	// Handle errors
 	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.serveError(w, r, proxy.NewBodyTooLargeError(r.Host, tooLarge.Limit))
+			return
+		}
 		s.logger.Error("proxy error",
 			"hostname", r.Host,
 			"deployment", target.DeploymentID,
@@ -263,6 +450,8 @@ func (s *Server) serveError(w http.ResponseWriter, r *http.Request, err proxy.Pr
 		tmplName = "stopped.html"
 	case proxy.ErrorVerificationPending:
 		tmplName = "verification_pending.html"
+	case proxy.ErrorBodyTooLarge:
+		tmplName = "too_large.html"
 	default:
 		tmplName = "unavailable.html"
 	}
@@ -300,9 +489,9 @@ func getRealIP(r *http.Request) string {
 
 // HealthResponse is the JSON response for the health endpoint.
 type HealthResponse struct {
-	Status               string `json:"status"`
-	DeploymentsRoutable  int    `json:"deployments_routable"`
-	BaseDomain           string `json:"base_domain"`
+	Status              string `json:"status"`
+	DeploymentsRoutable int    `json:"deployments_routable"`
+	BaseDomain          string `json:"base_domain"`
 }
 
 // serveHealth handles the /health endpoint for APIGate health checks.
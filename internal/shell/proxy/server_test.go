@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/artpar/hoster/internal/core/domain"
 	"github.com/artpar/hoster/internal/engine"
@@ -277,6 +278,249 @@ func TestServer_ServeHTTP_RemoteNodeNotFound(t *testing.T) {
 	assert.Contains(t, rec.Body.String(), "Unavailable")
 }
 
+func TestServer_ServeHTTP_CanarySplit(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stable"))
+	}))
+	defer stable.Close()
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("canary"))
+	}))
+	defer canary.Close()
+
+	depl := &domain.Deployment{
+		ReferenceID: "depl_123",
+		NodeID:      "local",
+		ProxyPort:   backendPort(t, stable.URL),
+		CanaryPort:  backendPort(t, canary.URL),
+		Status:      domain.StatusRunning,
+	}
+	ms := &mockProxyStore{deployments: map[string]*domain.Deployment{"my-app.apps.test.io": depl}}
+	meter := engine.NewTrafficMeter()
+	server, err := NewServer(Config{BaseDomain: "apps.test.io", Meter: meter}, ms, nil)
+	require.NoError(t, err)
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "http://my-app.apps.test.io/", nil))
+		return rec
+	}
+
+	// No share for the canary: everything goes to the stable containers
+	rec := serve()
+	assert.Equal(t, "stable", rec.Body.String())
+
+	// The whole share for the canary, as during promotion
+	depl.CanaryPercent = 100
+	rec = serve()
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "canary", rec.Body.String())
+
+	stats := meter.Take("depl_123")
+	assert.Equal(t, int64(1), stats.Stable.Requests)
+	assert.Equal(t, int64(0), stats.Stable.Errors)
+	assert.Equal(t, int64(1), stats.Canary.Requests)
+	assert.Equal(t, int64(1), stats.Canary.Errors)
+}
+
+func TestServer_ServeHTTP_RequestMeter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	depl := &domain.Deployment{
+		ReferenceID: "depl_123",
+		NodeID:      "local",
+		ProxyPort:   backendPort(t, backend.URL),
+		Status:      domain.StatusRunning,
+	}
+	ms := &mockProxyStore{deployments: map[string]*domain.Deployment{"my-app.apps.test.io": depl}}
+	meter := engine.NewRequestMeter()
+	server, err := NewServer(Config{BaseDomain: "apps.test.io", Requests: meter}, ms, nil)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/", "/slow", "/fail"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://my-app.apps.test.io"+path, nil))
+	}
+
+	traffic := meter.TakeAll()["depl_123"]
+	assert.Equal(t, int64(3), traffic.Requests)
+	assert.Equal(t, int64(1), traffic.Errors)
+	assert.Equal(t, int64(1), traffic.SlowerThan(50*time.Millisecond))
+	assert.Empty(t, meter.TakeAll(), "taking resets the counts")
+}
+
+func TestServer_ServeHTTP_StickySessions(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stable"))
+	}))
+	defer stable.Close()
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("canary"))
+	}))
+	defer canary.Close()
+
+	sticky := true
+	depl := &domain.Deployment{
+		ReferenceID:    "depl_123",
+		NodeID:         "local",
+		ProxyPort:      backendPort(t, stable.URL),
+		CanaryPort:     backendPort(t, canary.URL),
+		CanaryPercent:  100,
+		Status:         domain.StatusRunning,
+		RoutingOptions: domain.RoutingOptions{StickySessions: &sticky, StickyCookie: "sid"},
+	}
+	ms := &mockProxyStore{deployments: map[string]*domain.Deployment{"my-app.apps.test.io": depl}}
+	server, err := NewServer(Config{BaseDomain: "apps.test.io"}, ms, nil)
+	require.NoError(t, err)
+
+	// A new client is split and pinned to the backend it got
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "http://my-app.apps.test.io/", nil))
+	assert.Equal(t, "canary", rec.Body.String())
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "sid", cookies[0].Name)
+	assert.Equal(t, "canary", cookies[0].Value)
+
+	// A client pinned to the stable containers stays there
+	req := httptest.NewRequest("GET", "http://my-app.apps.test.io/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "stable"})
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, "stable", rec.Body.String())
+	assert.Empty(t, rec.Result().Cookies(), "the pin is already set")
+}
+
+func TestServer_ServeHTTP_Replicas(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("replica"))
+	}))
+	defer replica.Close()
+
+	depl := &domain.Deployment{
+		ReferenceID: "depl_123",
+		NodeID:      "local",
+		ProxyPort:   backendPort(t, primary.URL),
+		Status:      domain.StatusRunning,
+		Replicas: []domain.ReplicaNode{
+			{NodeID: "node_b", ProxyPort: backendPort(t, replica.URL), Status: domain.ReplicaRunning},
+			{NodeID: "node_c", ProxyPort: 1, Status: domain.ReplicaFailed},
+		},
+	}
+	ms := &mockProxyStore{
+		deployments: map[string]*domain.Deployment{"my-app.apps.test.io": depl},
+		nodeHosts:   map[string]string{"node_b": "127.0.0.1"},
+	}
+	server, err := NewServer(Config{BaseDomain: "apps.test.io"}, ms, nil)
+	require.NoError(t, err)
+
+	served := map[string]int{}
+	for range 64 {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "http://my-app.apps.test.io/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		served[rec.Body.String()]++
+	}
+	assert.Len(t, served, 2, "requests are spread over the running nodes")
+
+	// With sticky sessions a client keeps its node
+	sticky := true
+	depl.RoutingOptions = domain.RoutingOptions{StickySessions: &sticky}
+	first := ""
+	for range 8 {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", "http://my-app.apps.test.io/", nil))
+		if first == "" {
+			first = rec.Body.String()
+		}
+		assert.Equal(t, first, rec.Body.String())
+	}
+}
+
+func TestServer_ServeHTTP_MaxBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	ms := &mockProxyStore{deployments: map[string]*domain.Deployment{
+		"my-app.apps.test.io": {
+			ReferenceID:    "depl_123",
+			NodeID:         "local",
+			ProxyPort:      backendPort(t, backend.URL),
+			Status:         domain.StatusRunning,
+			RoutingOptions: domain.RoutingOptions{MaxBodyMB: 1},
+		},
+	}}
+	server, err := NewServer(Config{BaseDomain: "apps.test.io"}, ms, nil)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("POST", "http://my-app.apps.test.io/", strings.NewReader("small")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("POST", "http://my-app.apps.test.io/", strings.NewReader(strings.Repeat("x", 2<<20))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Request Too Large")
+}
+
+func TestServer_ServeHTTP_ACMEChallenge(t *testing.T) {
+	ms := &mockProxyStore{deployments: map[string]*domain.Deployment{}}
+	challenges := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "key-authorization")
+	})
+
+	server, err := NewServer(Config{BaseDomain: "apps.test.io", Challenges: challenges}, ms, nil)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://shop.example.com/.well-known/acme-challenge/abc", nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "key-authorization", rec.Body.String())
+
+	// Other paths still route to deployments
+	req = httptest.NewRequest("GET", "http://shop.example.com/", nil)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, 404, rec.Code)
+}
+
+func TestIsUpgrade(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://my-app.apps.test.io/ws", nil)
+	assert.False(t, isUpgrade(req))
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	assert.True(t, isUpgrade(req))
+
+	req.Header.Del("Upgrade")
+	assert.False(t, isUpgrade(req), "Connection: upgrade needs an Upgrade header")
+}
+
+func backendPort(t *testing.T, rawURL string) int {
+	t.Helper()
+	var port int
+	_, err := fmt.Sscanf(rawURL[strings.LastIndex(rawURL, ":")+1:], "%d", &port)
+	require.NoError(t, err)
+	return port
+}
+
 func TestGetRealIP(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,106 @@
+// Package scan checks uploaded files for malware with a ClamAV daemon.
+// This is part of the Imperative Shell - handles I/O (clamd connections).
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrInfected is returned for a file the scanner reports as malware.
+var ErrInfected = errors.New("file contains malware")
+
+// Clamd scans streams with clamd's INSTREAM command.
+type Clamd struct {
+	// Network and Address locate clamd, such as "tcp" and "127.0.0.1:3310",
+	// or "unix" and "/run/clamav/clamd.ctl".
+	Network string
+	Address string
+	// Timeout bounds one scan. Zero means 30 seconds.
+	Timeout time.Duration
+}
+
+// NewClamd returns a scanner for a clamd address: a host:port, or a unix
+// socket path starting with "/".
+func NewClamd(addr string) *Clamd {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	return &Clamd{Network: network, Address: addr}
+}
+
+// chunkSize is the size of the chunks a stream is sent to clamd in.
+const chunkSize = 32 << 10
+
+// Scan sends r to clamd. It returns an error wrapping ErrInfected naming
+// the signature when clamd finds malware, and other errors when the scan
+// could not be done.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("send to clamd: %w", err)
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, rerr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return fmt.Errorf("send to clamd: %w", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return fmt.Errorf("read upload: %w", rerr)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply interprets clamd's reply to a scan, such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseReply(reply string) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return fmt.Errorf("%w: %s", ErrInfected, strings.TrimSuffix(result, " FOUND"))
+	default:
+		return fmt.Errorf("clamd: %s", result)
+	}
+}
@@ -0,0 +1,96 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClamd answers INSTREAM scans, reporting streams containing "virus"
+// as infected.
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				cmd, err := br.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var body bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&body, br, int64(size)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(body.String(), "virus") {
+					conn.Write([]byte("stream: Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	c := NewClamd(fakeClamd(t))
+	assert.Equal(t, "tcp", c.Network)
+
+	require.NoError(t, c.Scan(context.Background(), strings.NewReader("server { listen 80; }")))
+
+	big := strings.Repeat("a", 3*chunkSize) + "virus"
+	err := c.Scan(context.Background(), strings.NewReader(big))
+	assert.ErrorIs(t, err, ErrInfected)
+	assert.Contains(t, err.Error(), "Test-Signature")
+}
+
+func TestClamdScan_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	err = NewClamd(addr).Scan(context.Background(), strings.NewReader("x"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInfected)
+}
+
+func TestNewClamd_Unix(t *testing.T) {
+	assert.Equal(t, "unix", NewClamd("/run/clamav/clamd.ctl").Network)
+}
+
+func TestParseReply(t *testing.T) {
+	assert.NoError(t, parseReply("stream: OK"))
+	assert.ErrorIs(t, parseReply("stream: Eicar-Test-Signature FOUND"), ErrInfected)
+	err := parseReply("INSTREAM size limit exceeded. ERROR")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInfected)
+}
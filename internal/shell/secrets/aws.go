@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+)
+
+// AWSConfig holds the configuration for the AWS Secrets Manager resolver.
+type AWSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the regional endpoint (e.g. for VPC endpoints).
+	Endpoint string
+	// Timeout is the HTTP client timeout.
+	Timeout time.Duration
+}
+
+// AWSResolver reads secrets with the Secrets Manager GetSecretValue API.
+type AWSResolver struct {
+	region      string
+	endpoint    string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// NewAWSResolver creates an AWS Secrets Manager resolver.
+func NewAWSResolver(cfg AWSConfig) *AWSResolver {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", cfg.Region)
+	}
+	return &AWSResolver{
+		region:   cfg.Region,
+		endpoint: endpoint,
+		credentials: aws.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+		signer:     v4.NewSigner(),
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		now:        time.Now,
+	}
+}
+
+// awsSecretValue is the GetSecretValue response (or error) body.
+type awsSecretValue struct {
+	SecretString string `json:"SecretString"`
+	Type         string `json:"__type"`
+	Message      string `json:"message"`
+	MessageUpper string `json:"Message"`
+}
+
+// Resolve fetches the secret named by ref.Path and returns the whole
+// SecretString, or ref.Key from it when set.
+func (a *AWSResolver) Resolve(ctx context.Context, ref coresecrets.Reference) (string, error) {
+	payload, _ := json.Marshal(map[string]string{"SecretId": ref.Path})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("secrets manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	hash := sha256.Sum256(payload)
+	if err := a.signer.SignHTTP(ctx, a.credentials, req, hex.EncodeToString(hash[:]), "secretsmanager", a.region, a.now()); err != nil {
+		return "", fmt.Errorf("sign secrets manager request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secrets manager get %s: %w", ref.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("secrets manager get %s: %w", ref.Path, err)
+	}
+
+	var parsed awsSecretValue
+	_ = json.Unmarshal(body, &parsed)
+	if resp.StatusCode != http.StatusOK {
+		msg := parsed.Message
+		if msg == "" {
+			msg = parsed.MessageUpper
+		}
+		code := parsed.Type
+		if i := strings.LastIndex(code, "#"); i >= 0 {
+			code = code[i+1:]
+		}
+		return "", fmt.Errorf("secrets manager get %s: %d %s %s", ref.Path, resp.StatusCode, code, msg)
+	}
+	if parsed.SecretString == "" {
+		return "", fmt.Errorf("secrets manager get %s: secret has no SecretString", ref.Path)
+	}
+	return coresecrets.SelectFromString(parsed.SecretString, ref.Key)
+}
@@ -0,0 +1,176 @@
+// Package secrets resolves secret-reference variable values against external
+// secret managers (HashiCorp Vault, AWS Secrets Manager).
+// This is part of the Imperative Shell - handles I/O (HTTP calls to secret managers).
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+)
+
+// Resolver fetches the value a reference points at.
+type Resolver interface {
+	Resolve(ctx context.Context, ref coresecrets.Reference) (string, error)
+}
+
+// =============================================================================
+// Audit
+// =============================================================================
+
+// Source values recorded for a resolution.
+const (
+	SourceProvider = "provider"
+	SourceCache    = "cache"
+	SourceStore    = "store" // A customer's stored secret, resolved by the engine
+)
+
+// Resolution is an audit record of one reference being resolved. It never
+// contains the secret value.
+type Resolution struct {
+	DeploymentID string
+	Variable     string
+	Reference    string
+	Source       string // SourceProvider or SourceCache
+	Error        string
+	ResolvedAt   time.Time
+}
+
+// AuditSink records resolutions.
+type AuditSink interface {
+	RecordSecretResolution(ctx context.Context, res Resolution) error
+}
+
+// =============================================================================
+// Manager
+// =============================================================================
+
+// Manager dispatches references to the resolver for their scheme, caches
+// resolved values for a TTL, and audits every resolution.
+type Manager struct {
+	resolvers map[string]Resolver
+	ttl       time.Duration
+	audit     AuditSink
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]coresecrets.CacheEntry
+}
+
+// NewManager creates a manager. resolvers is keyed by reference scheme;
+// schemes without a resolver fail to resolve. audit may be nil.
+func NewManager(resolvers map[string]Resolver, ttl time.Duration, audit AuditSink, logger *slog.Logger) *Manager {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if ttl <= 0 {
+		ttl = coresecrets.DefaultCacheTTL
+	}
+	return &Manager{
+		resolvers: resolvers,
+		ttl:       ttl,
+		audit:     audit,
+		logger:    logger.With("component", "secrets"),
+		now:       time.Now,
+		cache:     make(map[string]coresecrets.CacheEntry),
+	}
+}
+
+// Resolve returns the value for a reference, from cache when fresh.
+// The bool reports whether the value came from cache.
+func (m *Manager) Resolve(ctx context.Context, ref coresecrets.Reference) (string, bool, error) {
+	key := ref.String()
+	now := m.now()
+
+	m.mu.Lock()
+	entry, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok && entry.Fresh(now) {
+		return entry.Value, true, nil
+	}
+
+	resolver, ok := m.resolvers[ref.Scheme]
+	if !ok {
+		return "", false, fmt.Errorf("no secret manager configured for %s:// references", ref.Scheme)
+	}
+	value, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", false, err
+	}
+
+	m.mu.Lock()
+	m.cache[key] = coresecrets.CacheEntry{Value: value, ExpiresAt: now.Add(m.ttl)}
+	m.mu.Unlock()
+	return value, false, nil
+}
+
+// ResolveVariables returns a copy of vars with every secret reference replaced
+// by its value. Each reference is audited against deploymentID. The first
+// failure aborts resolution.
+func (m *Manager) ResolveVariables(ctx context.Context, deploymentID string, vars map[string]string) (map[string]string, error) {
+	refs, err := coresecrets.FindReferences(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(vars))
+	for k, v := range vars {
+		out[k] = v
+	}
+	if len(refs) == 0 {
+		return out, nil
+	}
+
+	for name, ref := range refs {
+		value, cached, err := m.Resolve(ctx, ref)
+
+		res := Resolution{
+			DeploymentID: deploymentID,
+			Variable:     name,
+			Reference:    ref.String(),
+			Source:       SourceProvider,
+			ResolvedAt:   m.now().UTC(),
+		}
+		if cached {
+			res.Source = SourceCache
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		m.record(ctx, res)
+
+		if err != nil {
+			return nil, fmt.Errorf("variable %s (%s): %w", name, ref, err)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// Invalidate drops all cached values.
+func (m *Manager) Invalidate() {
+	m.mu.Lock()
+	m.cache = make(map[string]coresecrets.CacheEntry)
+	m.mu.Unlock()
+}
+
+func (m *Manager) record(ctx context.Context, res Resolution) {
+	m.logger.Info("secret resolved",
+		"deployment", res.DeploymentID,
+		"variable", res.Variable,
+		"reference", res.Reference,
+		"source", res.Source,
+		"error", res.Error,
+	)
+	if m.audit == nil {
+		return
+	}
+	if err := m.audit.RecordSecretResolution(ctx, res); err != nil {
+		m.logger.Error("failed to record secret resolution", "deployment", res.DeploymentID, "error", err)
+	}
+}
@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubResolver struct {
+	calls int
+	value string
+	err   error
+}
+
+func (s *stubResolver) Resolve(_ context.Context, _ coresecrets.Reference) (string, error) {
+	s.calls++
+	return s.value, s.err
+}
+
+type memoryAudit struct {
+	records []Resolution
+}
+
+func (m *memoryAudit) RecordSecretResolution(_ context.Context, res Resolution) error {
+	m.records = append(m.records, res)
+	return nil
+}
+
+func TestManager_ResolveVariables(t *testing.T) {
+	vault := &stubResolver{value: "s3cret"}
+	audit := &memoryAudit{}
+	m := NewManager(map[string]Resolver{coresecrets.SchemeVault: vault}, time.Minute, audit, nil)
+
+	vars := map[string]string{
+		"DB_PASSWORD": "vault://secret/data/db#password",
+		"APP_NAME":    "shop",
+	}
+	out, err := m.ResolveVariables(context.Background(), "depl_1", vars)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", out["DB_PASSWORD"])
+	assert.Equal(t, "shop", out["APP_NAME"])
+	assert.Equal(t, "vault://secret/data/db#password", vars["DB_PASSWORD"], "input must not be modified")
+
+	require.Len(t, audit.records, 1)
+	assert.Equal(t, "depl_1", audit.records[0].DeploymentID)
+	assert.Equal(t, "DB_PASSWORD", audit.records[0].Variable)
+	assert.Equal(t, "vault://secret/data/db#password", audit.records[0].Reference)
+	assert.Equal(t, SourceProvider, audit.records[0].Source)
+	assert.Empty(t, audit.records[0].Error)
+}
+
+func TestManager_CacheTTL(t *testing.T) {
+	vault := &stubResolver{value: "s3cret"}
+	audit := &memoryAudit{}
+	m := NewManager(map[string]Resolver{coresecrets.SchemeVault: vault}, time.Minute, audit, nil)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	vars := map[string]string{"DB_PASSWORD": "vault://secret/data/db#password"}
+	_, err := m.ResolveVariables(context.Background(), "depl_1", vars)
+	require.NoError(t, err)
+	_, err = m.ResolveVariables(context.Background(), "depl_1", vars)
+	require.NoError(t, err)
+	assert.Equal(t, 1, vault.calls)
+	assert.Equal(t, SourceCache, audit.records[1].Source)
+
+	now = now.Add(time.Minute)
+	_, err = m.ResolveVariables(context.Background(), "depl_1", vars)
+	require.NoError(t, err)
+	assert.Equal(t, 2, vault.calls)
+}
+
+func TestManager_Errors(t *testing.T) {
+	vault := &stubResolver{err: errors.New("permission denied")}
+	audit := &memoryAudit{}
+	m := NewManager(map[string]Resolver{coresecrets.SchemeVault: vault}, time.Minute, audit, nil)
+
+	_, err := m.ResolveVariables(context.Background(), "depl_1", map[string]string{"DB_PASSWORD": "vault://secret/data/db#password"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DB_PASSWORD")
+	require.Len(t, audit.records, 1)
+	assert.Equal(t, "permission denied", audit.records[0].Error)
+
+	// Scheme with no configured resolver
+	_, err = m.ResolveVariables(context.Background(), "depl_1", map[string]string{"KEY": "awssm://prod/api"})
+	assert.ErrorContains(t, err, "no secret manager configured for awssm://")
+
+	// Malformed reference
+	_, err = m.ResolveVariables(context.Background(), "depl_1", map[string]string{"KEY": "vault://secret/data/db"})
+	assert.ErrorIs(t, err, coresecrets.ErrInvalidReference)
+}
+
+func TestVaultResolver_KVv2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/secret/data/db", r.URL.Path)
+		assert.Equal(t, "tok", r.Header.Get("X-Vault-Token"))
+		assert.Equal(t, "team", r.Header.Get("X-Vault-Namespace"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data":     map[string]any{"password": "s3cret"},
+				"metadata": map[string]any{"version": 3},
+			},
+		})
+	}))
+	defer server.Close()
+
+	v := NewVaultResolver(VaultConfig{Address: server.URL, Token: "tok", Namespace: "team"})
+	value, err := v.Resolve(context.Background(), coresecrets.Reference{Scheme: "vault", Path: "secret/data/db", Key: "password"})
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+}
+
+func TestVaultResolver_KVv1AndErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/kv/db" {
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"password": "v1secret"}})
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+	}))
+	defer server.Close()
+
+	v := NewVaultResolver(VaultConfig{Address: server.URL, Token: "tok"})
+	value, err := v.Resolve(context.Background(), coresecrets.Reference{Scheme: "vault", Path: "kv/db", Key: "password"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1secret", value)
+
+	_, err = v.Resolve(context.Background(), coresecrets.Reference{Scheme: "vault", Path: "kv/other", Key: "password"})
+	assert.ErrorContains(t, err, "403 permission denied")
+
+	_, err = v.Resolve(context.Background(), coresecrets.Reference{Scheme: "vault", Path: "kv/db", Key: "missing"})
+	assert.ErrorIs(t, err, coresecrets.ErrKeyNotFound)
+}
+
+func TestAWSResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/secretsmanager/aws4_request")
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body["SecretId"] {
+		case "prod/db":
+			json.NewEncoder(w).Encode(map[string]any{"SecretString": `{"user":"admin","password":"pw"}`})
+		case "prod/token":
+			json.NewEncoder(w).Encode(map[string]any{"SecretString": "raw-token"})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{
+				"__type":  "com.amazonaws.secretsmanager#ResourceNotFoundException",
+				"message": "Secrets Manager can't find the specified secret.",
+			})
+		}
+	}))
+	defer server.Close()
+
+	a := NewAWSResolver(AWSConfig{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: server.URL})
+
+	value, err := a.Resolve(context.Background(), coresecrets.Reference{Scheme: "awssm", Path: "prod/db", Key: "password"})
+	require.NoError(t, err)
+	assert.Equal(t, "pw", value)
+
+	value, err = a.Resolve(context.Background(), coresecrets.Reference{Scheme: "awssm", Path: "prod/token"})
+	require.NoError(t, err)
+	assert.Equal(t, "raw-token", value)
+
+	_, err = a.Resolve(context.Background(), coresecrets.Reference{Scheme: "awssm", Path: "prod/missing"})
+	assert.ErrorContains(t, err, "ResourceNotFoundException")
+}
+
+func TestNewAWSResolver_DefaultEndpoint(t *testing.T) {
+	a := NewAWSResolver(AWSConfig{Region: "eu-west-1"})
+	assert.Equal(t, "https://secretsmanager.eu-west-1.amazonaws.com", a.endpoint)
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	coresecrets "github.com/artpar/hoster/internal/core/secrets"
+)
+
+// VaultConfig holds the configuration for the Vault resolver.
+type VaultConfig struct {
+	// Address is the Vault server URL (e.g. "https://vault.example.com:8200").
+	Address string
+	// Token is the Vault token used to read secrets.
+	Token string
+	// Namespace is the Vault Enterprise namespace (optional).
+	Namespace string
+	// Timeout is the HTTP client timeout.
+	Timeout time.Duration
+}
+
+// VaultResolver reads secrets over the Vault HTTP API. References use the API
+// path after /v1/, so KV v2 paths include "data/" (vault://secret/data/db#password).
+type VaultResolver struct {
+	address    string
+	token      string
+	namespace  string
+	httpClient *http.Client
+}
+
+// NewVaultResolver creates a Vault resolver.
+func NewVaultResolver(cfg VaultConfig) *VaultResolver {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &VaultResolver{
+		address:    strings.TrimRight(cfg.Address, "/"),
+		token:      cfg.Token,
+		namespace:  cfg.Namespace,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// vaultResponse is the envelope of a Vault read.
+type vaultResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []string       `json:"errors"`
+}
+
+// Resolve reads ref.Path and returns ref.Key from the secret data.
+func (v *VaultResolver) Resolve(ctx context.Context, ref coresecrets.Reference) (string, error) {
+	segments := strings.Split(ref.Path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+strings.Join(segments, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault read %s: %w", ref.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("vault read %s: %w", ref.Path, err)
+	}
+
+	var parsed vaultResponse
+	_ = json.Unmarshal(body, &parsed)
+	if resp.StatusCode != http.StatusOK {
+		msg := http.StatusText(resp.StatusCode)
+		if len(parsed.Errors) > 0 {
+			msg = strings.Join(parsed.Errors, "; ")
+		}
+		return "", fmt.Errorf("vault read %s: %d %s", ref.Path, resp.StatusCode, msg)
+	}
+
+	data := parsed.Data
+	// KV v2 nests the secret under data.data alongside data.metadata.
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, hasMeta := data["metadata"]; hasMeta {
+			data = inner
+		}
+	}
+	return coresecrets.SelectKey(data, ref.Key)
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	coredeployment "github.com/artpar/hoster/internal/core/deployment"
+	"github.com/artpar/hoster/internal/core/minion"
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+	"github.com/artpar/hoster/internal/shell/docker"
+)
+
+// LabelBucket marks containers and volumes that serve a managed bucket.
+// Bucket containers deliberately do not carry docker.LabelDeployment so that
+// removing the deployment's containers leaves retained data alone.
+const LabelBucket = "com.hoster.bucket"
+
+const (
+	// DefaultMinIOImage is the image used for node-hosted buckets.
+	DefaultMinIOImage = "minio/minio:latest"
+
+	minioPort    = 9000
+	minioDataDir = "/data"
+	nodeRegion   = "us-east-1"
+)
+
+// NodeClients is the part of docker.NodePool the node provider uses.
+type NodeClients interface {
+	GetClient(ctx context.Context, nodeID string) (docker.Client, error)
+	VolumeUsage(ctx context.Context, nodeID, volumeName string) (*minion.VolumeUsage, error)
+}
+
+// NodeProvider hosts each bucket in its own MinIO container on the
+// deployment's node, attached to the deployment network so services reach it
+// at http://storage-<name>:9000.
+type NodeProvider struct {
+	nodes NodeClients
+	image string
+}
+
+// NewNodeProvider creates a provider that runs image (DefaultMinIOImage if
+// empty) on the nodes in pool.
+func NewNodeProvider(nodes NodeClients, image string) *NodeProvider {
+	if image == "" {
+		image = DefaultMinIOImage
+	}
+	return &NodeProvider{nodes: nodes, image: image}
+}
+
+// ContainerName returns the name of the container serving a bucket.
+func ContainerName(referenceID string) string {
+	return "hoster_bucket_" + referenceID
+}
+
+// VolumeName returns the name of the volume holding a bucket's data.
+func VolumeName(referenceID string) string {
+	return "hoster_bucket_" + referenceID + "_data"
+}
+
+// Hostname returns the network alias a bucket is reachable at from its
+// deployment's services.
+func Hostname(name string) string {
+	return "storage-" + name
+}
+
+// Provision creates the bucket's volume and (re)creates its MinIO container
+// with fresh root credentials.
+func (p *NodeProvider) Provision(ctx context.Context, b Bucket) (*corestorage.Credentials, error) {
+	if b.NodeID == "" {
+		return nil, errors.New("node backend requires the deployment to be placed on a node")
+	}
+	client, err := p.nodes.GetClient(ctx, b.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("connect to node %s: %w", b.NodeID, err)
+	}
+
+	accessKey, err := randomKey(10)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := randomKey(20)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		docker.LabelManaged: "true",
+		LabelBucket:         b.ReferenceID,
+	}
+
+	networkName := coredeployment.NetworkName(b.DeploymentID)
+	if _, err := client.CreateNetwork(docker.NetworkSpec{
+		Name:   networkName,
+		Driver: "bridge",
+		Labels: map[string]string{docker.LabelManaged: "true", docker.LabelDeployment: b.DeploymentID},
+	}); err != nil && !strings.Contains(err.Error(), "already exists") {
+		return nil, fmt.Errorf("create network %s: %w", networkName, err)
+	}
+
+	volumeName := VolumeName(b.ReferenceID)
+	if _, err := client.CreateVolume(docker.VolumeSpec{Name: volumeName, Labels: labels}); err != nil && !strings.Contains(err.Error(), "already exists") {
+		return nil, fmt.Errorf("create volume %s: %w", volumeName, err)
+	}
+
+	exists, err := client.ImageExists(p.image)
+	if err != nil {
+		return nil, fmt.Errorf("check image %s: %w", p.image, err)
+	}
+	if !exists {
+		if err := client.PullImage(p.image, docker.PullOptions{}); err != nil {
+			return nil, fmt.Errorf("pull image %s: %w", p.image, err)
+		}
+	}
+
+	// A retry replaces any container left by an earlier attempt, since the
+	// credentials it was started with are gone.
+	if err := p.removeContainer(client, b.ReferenceID); err != nil {
+		return nil, err
+	}
+
+	containerName := ContainerName(b.ReferenceID)
+	id, err := client.CreateContainer(docker.ContainerSpec{
+		Name:       containerName,
+		Image:      p.image,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{fmt.Sprintf("mkdir -p %s/%s && exec minio server %s", minioDataDir, b.BucketName, minioDataDir)},
+		Env: map[string]string{
+			"MINIO_ROOT_USER":     accessKey,
+			"MINIO_ROOT_PASSWORD": secretKey,
+		},
+		Labels:         labels,
+		Volumes:        []docker.VolumeMount{{Source: volumeName, Target: minioDataDir}},
+		Networks:       []string{networkName},
+		NetworkAliases: map[string][]string{networkName: {Hostname(b.Name)}},
+		RestartPolicy:  docker.RestartPolicy{Name: "unless-stopped"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create container %s: %w", containerName, err)
+	}
+	if err := client.StartContainer(id); err != nil {
+		return nil, fmt.Errorf("start container %s: %w", containerName, err)
+	}
+
+	return &corestorage.Credentials{
+		Endpoint:        fmt.Sprintf("http://%s:%d", Hostname(b.Name), minioPort),
+		Region:          nodeRegion,
+		Bucket:          b.BucketName,
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		PathStyle:       true,
+	}, nil
+}
+
+// Usage reports the size of the bucket's volume. MinIO keeps metadata files
+// alongside object data, so objects are not counted.
+func (p *NodeProvider) Usage(ctx context.Context, b Bucket, _ corestorage.Credentials) (Usage, error) {
+	usage, err := p.nodes.VolumeUsage(ctx, b.NodeID, VolumeName(b.ReferenceID))
+	if err != nil {
+		return Usage{}, fmt.Errorf("measure volume %s: %w", VolumeName(b.ReferenceID), err)
+	}
+	return Usage{Bytes: usage.Bytes}, nil
+}
+
+// Release stops and removes the MinIO container, keeping the data volume.
+func (p *NodeProvider) Release(ctx context.Context, b Bucket, _ corestorage.Credentials) error {
+	if b.NodeID == "" {
+		return nil
+	}
+	client, err := p.nodes.GetClient(ctx, b.NodeID)
+	if err != nil {
+		return fmt.Errorf("connect to node %s: %w", b.NodeID, err)
+	}
+	return p.removeContainer(client, b.ReferenceID)
+}
+
+// Delete removes the MinIO container and the data volume.
+func (p *NodeProvider) Delete(ctx context.Context, b Bucket, creds corestorage.Credentials) error {
+	if b.NodeID == "" {
+		return nil
+	}
+	client, err := p.nodes.GetClient(ctx, b.NodeID)
+	if err != nil {
+		return fmt.Errorf("connect to node %s: %w", b.NodeID, err)
+	}
+	if err := p.removeContainer(client, b.ReferenceID); err != nil {
+		return err
+	}
+	volumeName := VolumeName(b.ReferenceID)
+	if err := client.RemoveVolume(volumeName, true); err != nil && !isNotFound(err) {
+		return fmt.Errorf("remove volume %s: %w", volumeName, err)
+	}
+	return nil
+}
+
+// removeContainer stops and removes any container serving the bucket.
+func (p *NodeProvider) removeContainer(client docker.Client, referenceID string) error {
+	containers, err := client.ListContainers(docker.ListOptions{
+		All:     true,
+		Filters: map[string]string{"label": LabelBucket + "=" + referenceID},
+	})
+	if err != nil {
+		return fmt.Errorf("list bucket containers: %w", err)
+	}
+	timeout := 10 * time.Second
+	for _, c := range containers {
+		if c.State == "running" {
+			if err := client.StopContainer(c.ID, &timeout); err != nil && !isNotFound(err) {
+				return fmt.Errorf("stop container %s: %w", c.Name, err)
+			}
+		}
+		if err := client.RemoveContainer(c.ID, docker.RemoveOptions{Force: true}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("remove container %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, docker.ErrContainerNotFound) || errors.Is(err, docker.ErrVolumeNotFound) ||
+		strings.Contains(strings.ToLower(err.Error()), "no such")
+}
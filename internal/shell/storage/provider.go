@@ -0,0 +1,57 @@
+// Package storage provisions managed object storage buckets for deployments,
+// either as a MinIO container on the deployment's node or on an external
+// S3-compatible service.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+)
+
+// Bucket identifies a managed bucket to a provider.
+type Bucket struct {
+	// ReferenceID is the bucket's public identifier (e.g. "bkt_1a2b3c4d").
+	ReferenceID string
+	// Name is the customer's name for the bucket within the deployment.
+	Name string
+	// BucketName is the provisioned (globally unique) bucket name.
+	BucketName string
+	// DeploymentID is the owning deployment's reference ID.
+	DeploymentID string
+	// NodeID is the node hosting the deployment (node backend only).
+	NodeID string
+}
+
+// Usage is the measured size of a bucket.
+type Usage struct {
+	Bytes   int64
+	Objects int64 // 0 when the backend cannot count objects
+}
+
+// Provider creates, meters and removes buckets on one backend.
+type Provider interface {
+	// Provision creates the bucket and returns the credentials a deployment
+	// uses to reach it. It is safe to retry after a failure.
+	Provision(ctx context.Context, b Bucket) (*corestorage.Credentials, error)
+	// Usage measures the data stored in the bucket.
+	Usage(ctx context.Context, b Bucket, creds corestorage.Credentials) (Usage, error)
+	// Release stops serving the bucket once its deployment is deleted.
+	// Stored data is kept until Delete.
+	Release(ctx context.Context, b Bucket, creds corestorage.Credentials) error
+	// Delete removes the bucket, its data and its credentials. Deleting a
+	// bucket that does not exist is not an error.
+	Delete(ctx context.Context, b Bucket, creds corestorage.Credentials) error
+}
+
+// randomKey returns n random bytes hex-encoded, for generated credentials.
+func randomKey(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
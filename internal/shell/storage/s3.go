@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+)
+
+// S3Config holds the configuration for the external S3 provider.
+type S3Config struct {
+	// Endpoint is the S3 API endpoint (default https://s3.<region>.amazonaws.com).
+	Endpoint string
+	// PublicEndpoint is the endpoint handed to deployments (default Endpoint).
+	PublicEndpoint string
+	Region         string
+	// AccessKeyID and SecretAccessKey are the platform's credentials. They
+	// must be allowed to create and delete buckets (and IAM users with IAM).
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle addresses buckets as <endpoint>/<bucket> instead of
+	// <bucket>.<host>; most self-hosted S3 services need it.
+	PathStyle bool
+	// IAM creates an IAM user per bucket whose access key is limited to that
+	// bucket. Without it deployments receive the platform's credentials.
+	IAM bool
+	// IAMEndpoint overrides the IAM endpoint (default https://iam.amazonaws.com).
+	IAMEndpoint string
+	// Timeout is the HTTP client timeout.
+	Timeout time.Duration
+}
+
+// S3Provider creates buckets on an S3-compatible service using the REST API.
+type S3Provider struct {
+	cfg         S3Config
+	endpoint    *url.URL
+	iamEndpoint string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// NewS3Provider creates an S3 provider.
+func NewS3Provider(cfg S3Config) (*S3Provider, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.PublicEndpoint == "" {
+		cfg.PublicEndpoint = cfg.Endpoint
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	iamEndpoint := strings.TrimRight(cfg.IAMEndpoint, "/")
+	if iamEndpoint == "" {
+		iamEndpoint = "https://iam.amazonaws.com"
+	}
+	return &S3Provider{
+		cfg:         cfg,
+		endpoint:    endpoint,
+		iamEndpoint: iamEndpoint,
+		credentials: aws.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+		// S3 signs the request path as sent rather than escaping it again.
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			o.DisableURIPathEscaping = true
+		}),
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		now:        time.Now,
+	}, nil
+}
+
+// =============================================================================
+// Provider
+// =============================================================================
+
+// Provision creates the bucket and, with IAM enabled, a user whose access key
+// is limited to it.
+func (p *S3Provider) Provision(ctx context.Context, b Bucket) (*corestorage.Credentials, error) {
+	if err := p.createBucket(ctx, b.BucketName); err != nil {
+		return nil, err
+	}
+	creds := &corestorage.Credentials{
+		Endpoint:        p.cfg.PublicEndpoint,
+		Region:          p.cfg.Region,
+		Bucket:          b.BucketName,
+		AccessKeyID:     p.cfg.AccessKeyID,
+		SecretAccessKey: p.cfg.SecretAccessKey,
+		PathStyle:       p.cfg.PathStyle,
+	}
+	if !p.cfg.IAM {
+		return creds, nil
+	}
+
+	user := iamUserName(b.ReferenceID)
+	if err := p.iam(ctx, url.Values{"Action": {"CreateUser"}, "UserName": {user}}, nil, "EntityAlreadyExists"); err != nil {
+		return nil, err
+	}
+	if err := p.iam(ctx, url.Values{
+		"Action":         {"PutUserPolicy"},
+		"UserName":       {user},
+		"PolicyName":     {"hoster-bucket"},
+		"PolicyDocument": {bucketPolicy(b.BucketName)},
+	}, nil); err != nil {
+		return nil, err
+	}
+	var key struct {
+		AccessKeyID     string `xml:"CreateAccessKeyResult>AccessKey>AccessKeyId"`
+		SecretAccessKey string `xml:"CreateAccessKeyResult>AccessKey>SecretAccessKey"`
+	}
+	if err := p.iam(ctx, url.Values{"Action": {"CreateAccessKey"}, "UserName": {user}}, &key); err != nil {
+		return nil, err
+	}
+	creds.AccessKeyID = key.AccessKeyID
+	creds.SecretAccessKey = key.SecretAccessKey
+	return creds, nil
+}
+
+// Usage sums the sizes of the objects in the bucket.
+func (p *S3Provider) Usage(ctx context.Context, b Bucket, _ corestorage.Credentials) (Usage, error) {
+	var usage Usage
+	err := p.listObjects(ctx, b.BucketName, "", func(o s3Object) error {
+		usage.Bytes += o.Size
+		usage.Objects++
+		return nil
+	})
+	return usage, err
+}
+
+// Release revokes the bucket's IAM access key, if it has one.
+func (p *S3Provider) Release(ctx context.Context, b Bucket, creds corestorage.Credentials) error {
+	if !p.cfg.IAM || creds.AccessKeyID == "" || creds.AccessKeyID == p.cfg.AccessKeyID {
+		return nil
+	}
+	return p.iam(ctx, url.Values{
+		"Action":      {"DeleteAccessKey"},
+		"UserName":    {iamUserName(b.ReferenceID)},
+		"AccessKeyId": {creds.AccessKeyID},
+	}, nil, "NoSuchEntity")
+}
+
+// Delete empties and removes the bucket, then its IAM user.
+func (p *S3Provider) Delete(ctx context.Context, b Bucket, creds corestorage.Credentials) error {
+	err := p.listObjects(ctx, b.BucketName, "", func(o s3Object) error {
+		return p.do(ctx, http.MethodDelete, b.BucketName, objectPath(o.Key), nil, nil, nil)
+	})
+	if err != nil && !isS3Code(err, "NoSuchBucket") {
+		return err
+	}
+	if err := p.do(ctx, http.MethodDelete, b.BucketName, "", nil, nil, nil); err != nil && !isS3Code(err, "NoSuchBucket") {
+		return err
+	}
+	if !p.cfg.IAM {
+		return nil
+	}
+	if err := p.Release(ctx, b, creds); err != nil {
+		return err
+	}
+	user := iamUserName(b.ReferenceID)
+	if err := p.iam(ctx, url.Values{"Action": {"DeleteUserPolicy"}, "UserName": {user}, "PolicyName": {"hoster-bucket"}}, nil, "NoSuchEntity"); err != nil {
+		return err
+	}
+	return p.iam(ctx, url.Values{"Action": {"DeleteUser"}, "UserName": {user}}, nil, "NoSuchEntity")
+}
+
+// =============================================================================
+// Objects
+// =============================================================================
+
+// S3Objects stores files under a key prefix of one existing bucket. The
+// control plane uses it for its own files, such as database backups.
+type S3Objects struct {
+	p      *S3Provider
+	bucket string
+	prefix string
+}
+
+// Objects returns the files under prefix in bucket. A non-empty prefix is
+// treated as a directory.
+func (p *S3Provider) Objects(bucket, prefix string) *S3Objects {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Objects{p: p, bucket: bucket, prefix: prefix}
+}
+
+// Put uploads a file.
+func (o *S3Objects) Put(ctx context.Context, name string, data []byte) error {
+	if err := o.p.do(ctx, http.MethodPut, o.bucket, objectPath(o.prefix+name), nil, data, nil); err != nil {
+		return fmt.Errorf("put %s: %w", o.prefix+name, err)
+	}
+	return nil
+}
+
+// Get downloads a file into w.
+func (o *S3Objects) Get(ctx context.Context, name string, w io.Writer) error {
+	if err := o.p.do(ctx, http.MethodGet, o.bucket, objectPath(o.prefix+name), nil, nil, w); err != nil {
+		return fmt.Errorf("get %s: %w", o.prefix+name, err)
+	}
+	return nil
+}
+
+// List returns the names of the files directly under the prefix.
+func (o *S3Objects) List(ctx context.Context) ([]string, error) {
+	var names []string
+	err := o.p.listObjects(ctx, o.bucket, o.prefix, func(obj s3Object) error {
+		name := strings.TrimPrefix(obj.Key, o.prefix)
+		if name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", o.bucket+"/"+o.prefix, err)
+	}
+	return names, nil
+}
+
+// Delete removes a file. Deleting a missing file succeeds.
+func (o *S3Objects) Delete(ctx context.Context, name string) error {
+	if err := o.p.do(ctx, http.MethodDelete, o.bucket, objectPath(o.prefix+name), nil, nil, nil); err != nil {
+		return fmt.Errorf("delete %s: %w", o.prefix+name, err)
+	}
+	return nil
+}
+
+// =============================================================================
+// S3 REST
+// =============================================================================
+
+// s3Object is an entry of a ListObjectsV2 response.
+type s3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+// listObjectsResult is the ListObjectsV2 response body.
+type listObjectsResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// s3Error is an S3 or IAM error response.
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+	// IAM wraps the error in <ErrorResponse><Error>.
+	Inner *struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("%d %s %s", e.Status, e.Code, e.Message)
+}
+
+func isS3Code(err error, code string) bool {
+	e, ok := err.(*s3Error)
+	return ok && e.Code == code
+}
+
+func (p *S3Provider) createBucket(ctx context.Context, bucket string) error {
+	var body []byte
+	if p.cfg.Region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>` +
+			p.cfg.Region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	err := p.do(ctx, http.MethodPut, bucket, "", nil, body, nil)
+	if err != nil && !isS3Code(err, "BucketAlreadyOwnedByYou") {
+		return fmt.Errorf("create bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// listObjects calls fn for every object in the bucket whose key starts with
+// prefix, following pagination.
+func (p *S3Provider) listObjects(ctx context.Context, bucket, prefix string, fn func(s3Object) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page listObjectsResult
+		if err := p.do(ctx, http.MethodGet, bucket, "", query, nil, &page); err != nil {
+			return err
+		}
+		for _, o := range page.Contents {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// bucketURL addresses a bucket path-style or virtual-hosted-style. path is
+// already escaped (see objectPath).
+func (p *S3Provider) bucketURL(bucket, path string, query url.Values) string {
+	host := p.endpoint.Host
+	base := strings.TrimRight(p.endpoint.EscapedPath(), "/")
+	if p.cfg.PathStyle {
+		base += "/" + bucket
+	} else {
+		host = bucket + "." + host
+	}
+	if path == "" {
+		path = "/"
+	}
+	u := p.endpoint.Scheme + "://" + host + base + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// objectPath returns the escaped request path of an object key.
+func objectPath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// do sends a signed S3 request and decodes an XML response into out.
+func (p *S3Provider) do(ctx context.Context, method, bucket, path string, query url.Values, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.bucketURL(bucket, path, query), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("s3 request: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := p.signer.SignHTTP(ctx, p.credentials, req, payloadHash, "s3", p.cfg.Region, p.now()); err != nil {
+		return fmt.Errorf("sign s3 request: %w", err)
+	}
+	return p.send(req, out)
+}
+
+// =============================================================================
+// IAM
+// =============================================================================
+
+// iamUserName returns the IAM user created for a bucket.
+func iamUserName(referenceID string) string {
+	return "hoster-bucket-" + referenceID
+}
+
+// bucketPolicy returns an inline policy granting full access to one bucket.
+func bucketPolicy(bucket string) string {
+	policy, _ := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect":   "Allow",
+			"Action":   []string{"s3:*"},
+			"Resource": []string{"arn:aws:s3:::" + bucket, "arn:aws:s3:::" + bucket + "/*"},
+		}},
+	})
+	return string(policy)
+}
+
+// iam calls an IAM query API action. Errors whose code is in ignore are
+// treated as success.
+func (p *S3Provider) iam(ctx context.Context, params url.Values, out any, ignore ...string) error {
+	params.Set("Version", "2010-05-08")
+	body := []byte(params.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.iamEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("iam request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	hash := sha256.Sum256(body)
+	if err := p.signer.SignHTTP(ctx, p.credentials, req, hex.EncodeToString(hash[:]), "iam", "us-east-1", p.now()); err != nil {
+		return fmt.Errorf("sign iam request: %w", err)
+	}
+	err = p.send(req, out)
+	for _, code := range ignore {
+		if isS3Code(err, code) {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("iam %s: %w", params.Get("Action"), err)
+	}
+	return nil
+}
+
+// send performs a signed request, returning an *s3Error for error responses.
+// A successful response body is copied into out when it is an io.Writer and
+// decoded as XML otherwise.
+func (p *S3Provider) send(req *http.Request, out any) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if w, ok := out.(io.Writer); ok && resp.StatusCode < 300 {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		e := &s3Error{Status: resp.StatusCode}
+		_ = xml.Unmarshal(data, e)
+		if e.Code == "" && e.Inner != nil {
+			e.Code, e.Message = e.Inner.Code, e.Inner.Message
+		}
+		if e.Code == "" {
+			e.Code = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	if out != nil && len(data) > 0 {
+		if err := xml.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/artpar/hoster/internal/core/minion"
+	corestorage "github.com/artpar/hoster/internal/core/storage"
+	"github.com/artpar/hoster/internal/shell/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// =============================================================================
+// S3 Provider
+// =============================================================================
+
+// fakeS3 is a minimal in-memory S3 and IAM endpoint.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]int
+	bodies  map[string][]byte
+	iam     []string
+	paths   []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: map[string]map[string]int{}, bodies: map[string][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodPost {
+		_ = r.ParseForm()
+		action := r.PostForm.Get("Action")
+		f.iam = append(f.iam, action)
+		if action == "CreateAccessKey" {
+			fmt.Fprint(w, `<CreateAccessKeyResponse><CreateAccessKeyResult><AccessKey><AccessKeyId>AKUSER</AccessKeyId><SecretAccessKey>usersecret</SecretAccessKey></AccessKey></CreateAccessKeyResult></CreateAccessKeyResponse>`)
+		}
+		if action == "DeleteUserPolicy" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>NoSuchEntity</Code></Error></ErrorResponse>`)
+		}
+		return
+	}
+
+	f.paths = append(f.paths, r.Method+" "+r.URL.EscapedPath())
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	objects, exists := f.buckets[bucket]
+	switch {
+	case r.Method == http.MethodPut && key == "":
+		if exists {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `<Error><Code>BucketAlreadyOwnedByYou</Code></Error>`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<LocationConstraint>eu-west-1</LocationConstraint>") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.buckets[bucket] = map[string]int{}
+	case !exists:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code><Message>gone</Message></Error>`)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		objects[key] = len(body)
+		f.bodies[bucket+"/"+key] = body
+	case r.Method == http.MethodGet && key != "":
+		body, ok := f.bodies[bucket+"/"+key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Write(body)
+	case r.Method == http.MethodGet:
+		// Two objects per page, continuing after the last key returned.
+		var keys []string
+		for k := range objects {
+			if k > r.URL.Query().Get("continuation-token") && strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		if len(keys) > 2 {
+			keys = keys[:2]
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[1])
+		}
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, objects[k])
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == http.MethodDelete && key != "":
+		delete(objects, key)
+		delete(f.bodies, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Provider_Lifecycle(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p, err := NewS3Provider(S3Config{
+		Endpoint: srv.URL, PublicEndpoint: "https://files.example.com", Region: "eu-west-1",
+		AccessKeyID: "AKPLATFORM", SecretAccessKey: "platform", PathStyle: true,
+		IAM: true, IAMEndpoint: srv.URL,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	b := Bucket{ReferenceID: "bkt_1", Name: "media", BucketName: "hoster-bkt1-media"}
+
+	creds, err := p.Provision(ctx, b)
+	require.NoError(t, err)
+	assert.Equal(t, corestorage.Credentials{
+		Endpoint: "https://files.example.com", Region: "eu-west-1", Bucket: "hoster-bkt1-media",
+		AccessKeyID: "AKUSER", SecretAccessKey: "usersecret", PathStyle: true,
+	}, *creds)
+	assert.Equal(t, []string{"CreateUser", "PutUserPolicy", "CreateAccessKey"}, fake.iam)
+
+	// Provisioning again is idempotent.
+	_, err = p.Provision(ctx, b)
+	require.NoError(t, err)
+
+	fake.buckets[b.BucketName]["a.txt"] = 10
+	fake.buckets[b.BucketName]["dir/b c.txt"] = 20
+	fake.buckets[b.BucketName]["c.txt"] = 30
+
+	usage, err := p.Usage(ctx, b, *creds)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Bytes: 60, Objects: 3}, usage)
+
+	fake.iam = nil
+	require.NoError(t, p.Delete(ctx, b, *creds))
+	assert.NotContains(t, fake.buckets, b.BucketName)
+	assert.Contains(t, fake.paths, "DELETE /hoster-bkt1-media/dir/b%20c.txt")
+	assert.Equal(t, []string{"DeleteAccessKey", "DeleteUserPolicy", "DeleteUser"}, fake.iam)
+
+	// Deleting a missing bucket succeeds.
+	require.NoError(t, p.Delete(ctx, b, *creds))
+}
+
+func TestS3Provider_SharedCredentials(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p, err := NewS3Provider(S3Config{Endpoint: srv.URL, Region: "eu-west-1", AccessKeyID: "AK", SecretAccessKey: "SK", PathStyle: true})
+	require.NoError(t, err)
+
+	creds, err := p.Provision(context.Background(), Bucket{ReferenceID: "bkt_2", BucketName: "b2"})
+	require.NoError(t, err)
+	assert.Equal(t, "AK", creds.AccessKeyID)
+	assert.Equal(t, srv.URL, creds.Endpoint)
+	assert.Empty(t, fake.iam)
+
+	_, err = p.Usage(context.Background(), Bucket{BucketName: "missing"}, *creds)
+	assert.ErrorContains(t, err, "NoSuchBucket")
+}
+
+func TestS3Objects(t *testing.T) {
+	fake := newFakeS3()
+	fake.buckets["ops"] = map[string]int{"other/x.json": 1}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p, err := NewS3Provider(S3Config{Endpoint: srv.URL, Region: "eu-west-1", AccessKeyID: "AK", SecretAccessKey: "SK", PathStyle: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	objs := p.Objects("ops", "/hoster/backups/")
+
+	for _, name := range []string{"a.json", "b.db.gz", "c.json"} {
+		require.NoError(t, objs.Put(ctx, name, []byte("data-"+name)))
+	}
+	assert.Contains(t, fake.paths, "PUT /ops/hoster/backups/b.db.gz")
+
+	names, err := objs.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.json", "b.db.gz", "c.json"}, names)
+
+	var buf strings.Builder
+	require.NoError(t, objs.Get(ctx, "b.db.gz", &buf))
+	assert.Equal(t, "data-b.db.gz", buf.String())
+
+	err = objs.Get(ctx, "missing.json", &buf)
+	assert.ErrorContains(t, err, "NoSuchKey")
+
+	require.NoError(t, objs.Delete(ctx, "a.json"))
+	names, err = objs.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.db.gz", "c.json"}, names)
+	assert.Contains(t, fake.buckets["ops"], "other/x.json")
+}
+
+func TestS3Provider_BucketURL(t *testing.T) {
+	p, err := NewS3Provider(S3Config{Region: "eu-west-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://b.s3.eu-west-1.amazonaws.com/k?list-type=2", p.bucketURL("b", "/k", map[string][]string{"list-type": {"2"}}))
+
+	p, err = NewS3Provider(S3Config{Endpoint: "http://minio:9000/", PathStyle: true})
+	require.NoError(t, err)
+	assert.Equal(t, "http://minio:9000/b/", p.bucketURL("b", "", nil))
+
+	_, err = NewS3Provider(S3Config{Endpoint: "not a url"})
+	assert.Error(t, err)
+}
+
+// =============================================================================
+// Node Provider
+// =============================================================================
+
+// fakeDocker records the calls the node provider makes.
+type fakeDocker struct {
+	docker.Client
+	specs      []docker.ContainerSpec
+	containers []docker.ContainerInfo
+	removed    []string
+	volumes    []string
+}
+
+func (f *fakeDocker) CreateNetwork(spec docker.NetworkSpec) (string, error) {
+	return "", errors.New("network already exists")
+}
+
+func (f *fakeDocker) CreateVolume(spec docker.VolumeSpec) (string, error) {
+	f.volumes = append(f.volumes, spec.Name)
+	return spec.Name, nil
+}
+
+func (f *fakeDocker) ImageExists(string) (bool, error) { return true, nil }
+
+func (f *fakeDocker) ListContainers(docker.ListOptions) ([]docker.ContainerInfo, error) {
+	return f.containers, nil
+}
+
+func (f *fakeDocker) StopContainer(string, *time.Duration) error { return nil }
+
+func (f *fakeDocker) RemoveContainer(id string, _ docker.RemoveOptions) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func (f *fakeDocker) CreateContainer(spec docker.ContainerSpec) (string, error) {
+	f.specs = append(f.specs, spec)
+	return "c1", nil
+}
+
+func (f *fakeDocker) StartContainer(string) error { return nil }
+
+func (f *fakeDocker) RemoveVolume(name string, _ bool) error {
+	return fmt.Errorf("remove %s: %w", name, docker.ErrVolumeNotFound)
+}
+
+type fakeNodes struct {
+	client *fakeDocker
+}
+
+func (n fakeNodes) GetClient(context.Context, string) (docker.Client, error) { return n.client, nil }
+
+func (n fakeNodes) VolumeUsage(_ context.Context, _ string, volume string) (*minion.VolumeUsage, error) {
+	return &minion.VolumeUsage{Volume: volume, Bytes: 4096, Files: 3}, nil
+}
+
+func TestNodeProvider(t *testing.T) {
+	client := &fakeDocker{containers: []docker.ContainerInfo{{ID: "old", State: "running"}}}
+	p := NewNodeProvider(fakeNodes{client: client}, "")
+	ctx := context.Background()
+	b := Bucket{ReferenceID: "bkt_1", Name: "media", BucketName: "hoster-bkt1-media", DeploymentID: "depl_1", NodeID: "node_1"}
+
+	creds, err := p.Provision(ctx, b)
+	require.NoError(t, err)
+	assert.Equal(t, "http://storage-media:9000", creds.Endpoint)
+	assert.Equal(t, "hoster-bkt1-media", creds.Bucket)
+	assert.True(t, creds.PathStyle)
+	assert.Len(t, creds.AccessKeyID, 20)
+	assert.Len(t, creds.SecretAccessKey, 40)
+	assert.Equal(t, []string{"old"}, client.removed, "container from an earlier attempt is replaced")
+
+	require.Len(t, client.specs, 1)
+	spec := client.specs[0]
+	assert.Equal(t, DefaultMinIOImage, spec.Image)
+	assert.Equal(t, "hoster_bucket_bkt_1", spec.Name)
+	assert.Equal(t, creds.AccessKeyID, spec.Env["MINIO_ROOT_USER"])
+	assert.Equal(t, "bkt_1", spec.Labels[LabelBucket])
+	assert.NotContains(t, spec.Labels, docker.LabelDeployment)
+	assert.Equal(t, []string{"storage-media"}, spec.NetworkAliases["hoster_depl_1"])
+	assert.Contains(t, spec.Command[0], "mkdir -p /data/hoster-bkt1-media")
+	assert.Equal(t, []docker.VolumeMount{{Source: "hoster_bucket_bkt_1_data", Target: "/data"}}, spec.Volumes)
+
+	usage, err := p.Usage(ctx, b, *creds)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Bytes: 4096}, usage)
+
+	require.NoError(t, p.Delete(ctx, b, *creds), "missing volume is not an error")
+
+	_, err = p.Provision(ctx, Bucket{ReferenceID: "bkt_2"})
+	assert.ErrorContains(t, err, "node")
+}
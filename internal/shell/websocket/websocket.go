@@ -0,0 +1,452 @@
+// Package websocket implements the parts of the WebSocket protocol (RFC 6455)
+// that tunnels need: a binary byte stream in each direction over one
+// upgraded HTTP/1.1 connection, on the server and on the client side.
+// This is part of the Imperative Shell - handles I/O (network connections).
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to compute the server's
+// Sec-WebSocket-Accept header.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload is the largest payload of a close, ping or pong frame.
+const maxControlPayload = 125
+
+// Close status codes.
+const (
+	StatusNormal        = 1000
+	StatusProtocolError = 1002
+	StatusPolicy        = 1008
+	StatusInternalError = 1011
+)
+
+// CloseError is returned by Read when the peer closed the connection with a
+// status other than StatusNormal.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with status %d: %s", e.Code, e.Reason)
+}
+
+// ErrBadHandshake is returned by Dial when the server did not upgrade the
+// connection, and by Accept for a request that is not a WebSocket upgrade.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// acceptKey returns the Sec-WebSocket-Accept value for a client's key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// =============================================================================
+// Conn
+// =============================================================================
+
+// Conn is a WebSocket connection carrying a byte stream: each Write sends
+// one binary frame, and Read returns the payloads of the peer's data frames
+// in order. Pings are answered; a close frame ends the stream. Read and
+// Write may be called from different goroutines.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// remaining and mask describe the data frame being read.
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	wmu        sync.Mutex
+	closeSent  bool
+	readClosed bool
+}
+
+// Read reads payload bytes of the peer's data frames. It returns io.EOF when
+// the peer closed with StatusNormal and a *CloseError for other statuses.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.readClosed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := range p[:n] {
+			p[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with payload starts,
+// handling control frames on the way.
+func (c *Conn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	// Clients mask their frames and servers don't (RFC 6455 section 5.1).
+	if masked == c.client {
+		return c.fail("frame masking is wrong for this side of the connection")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining, c.masked, c.mask, c.maskPos = length, masked, mask, 0
+		return nil
+	case opClose, opPing, opPong:
+	default:
+		return c.fail(fmt.Sprintf("unknown opcode %d", opcode))
+	}
+
+	if length > maxControlPayload {
+		return c.fail("control frame too large")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	if masked {
+		maskBytes(payload, mask)
+	}
+	switch opcode {
+	case opPing:
+		// After our close frame the peer gets no pong; it is closing anyway.
+		if err := c.writeFrame(opPong, payload); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	case opClose:
+		c.readClosed = true
+		code := StatusNormal
+		var reason string
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+			reason = string(payload[2:])
+		}
+		c.closeWithStatus(StatusNormal, "")
+		if code != StatusNormal {
+			return &CloseError{Code: code, Reason: reason}
+		}
+		return io.EOF
+	}
+	return nil
+}
+
+// fail closes the connection after a protocol error.
+func (c *Conn) fail(msg string) error {
+	c.closeWithStatus(StatusProtocolError, msg)
+	c.conn.Close()
+	return fmt.Errorf("websocket: %s", msg)
+}
+
+// Write sends p as one binary frame.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseWrite sends a normal close frame, telling the peer no more data
+// follows. The peer's remaining data can still be read.
+func (c *Conn) CloseWrite() error {
+	return c.closeWithStatus(StatusNormal, "")
+}
+
+// CloseWithStatus sends a close frame with code and reason and closes the
+// connection.
+func (c *Conn) CloseWithStatus(code int, reason string) error {
+	err := c.closeWithStatus(code, reason)
+	c.conn.Close()
+	return err
+}
+
+// Close closes the connection, sending a normal close frame first if none
+// was sent.
+func (c *Conn) Close() error {
+	c.closeWithStatus(StatusNormal, "")
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) closeWithStatus(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	return c.writeFrameLocked(opClose, payload)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(frame[start:], mask)
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func maskBytes(b []byte, mask [4]byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// =============================================================================
+// Server
+// =============================================================================
+
+// Accept upgrades an HTTP request to a WebSocket connection. A request that
+// is not a WebSocket upgrade fails with ErrBadHandshake before anything is
+// written, so the caller can still send an error response.
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet:
+		return nil, fmt.Errorf("%w: method must be GET", ErrBadHandshake)
+	case !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket"):
+		return nil, fmt.Errorf("%w: not a websocket upgrade request", ErrBadHandshake)
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		return nil, fmt.Errorf("%w: websocket version must be 13", ErrBadHandshake)
+	case key == "":
+		return nil, fmt.Errorf("%w: missing Sec-WebSocket-Key", ErrBadHandshake)
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack connection: %w", err)
+	}
+	// The server's read and write timeouts don't apply to a long-lived tunnel.
+	conn.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// compared case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Client
+// =============================================================================
+
+// maxErrorBody bounds the body kept from a refused handshake.
+const maxErrorBody = 64 << 10
+
+// Dial opens a WebSocket connection to rawURL, a ws, wss, http or https URL,
+// sending header with the handshake. When the server answers with anything
+// but an upgrade, Dial returns the response, with its body readable, and an
+// error wrapping ErrBadHandshake.
+func Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	useTLS := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme, useTLS = "https", true
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if useTLS {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+		Host:       u.Host,
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		conn.Close()
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		return nil, resp, fmt.Errorf("%w: %s", ErrBadHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, resp, fmt.Errorf("%w: wrong Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, br: br, client: true}, resp, nil
+}
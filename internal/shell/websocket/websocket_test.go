@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// serve starts a server that upgrades every request and hands the
+// connection to handle.
+func serve(t *testing.T, handle func(*Conn)) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handle(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := Dial(ctx, url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConn_Echo(t *testing.T) {
+	url := serve(t, func(c *Conn) {
+		defer c.Close()
+		io.Copy(c, c)
+	})
+	conn := dial(t, url)
+
+	large := strings.Repeat("x", 70000)
+	for _, msg := range []string{"hello", strings.Repeat("y", 300), large} {
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+		got := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, got)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(got))
+	}
+
+	require.NoError(t, conn.CloseWrite())
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "the server closes after the client does")
+}
+
+func TestConn_Ping(t *testing.T) {
+	url := serve(t, func(c *Conn) {
+		defer c.Close()
+		c.writeFrame(opPing, []byte("are you there"))
+		c.Write([]byte("data"))
+		io.Copy(io.Discard, c)
+	})
+	conn := dial(t, url)
+
+	got := make([]byte, 4)
+	_, err := io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(got), "the ping is answered, not returned as data")
+}
+
+func TestConn_CloseWithStatus(t *testing.T) {
+	url := serve(t, func(c *Conn) {
+		c.CloseWithStatus(StatusPolicy, "session expired")
+	})
+	conn := dial(t, url)
+
+	_, err := conn.Read(make([]byte, 1))
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, StatusPolicy, closeErr.Code)
+	assert.Equal(t, "session expired", closeErr.Reason)
+}
+
+func TestDial_Refused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no tunnels here", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, resp, err := Dial(context.Background(), srv.URL, nil)
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "no tunnels here")
+}
+
+func TestAccept_NotUpgrade(t *testing.T) {
+	url := serve(t, func(c *Conn) { c.Close() })
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
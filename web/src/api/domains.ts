@@ -18,6 +18,9 @@ export interface DomainInfo {
   verified_at?: string;
   last_check_error?: string;
   instructions?: DNSInstruction[];
+  verification_token?: string;
+  ownership_verified_at?: string;
+  cutover_requested_at?: string;
 }
 
 /** Raw fetch for domain endpoints — they return plain JSON, not JSON:API wrapped. */
@@ -65,4 +68,11 @@ export const domainsApi = {
       method: 'POST',
     });
   },
+
+  cutover: async (deploymentId: string, hostname: string, immediate = false): Promise<DomainInfo> => {
+    return domainFetch<DomainInfo>(`/deployments/${deploymentId}/domains/${hostname}/cutover`, {
+      method: 'POST',
+      body: JSON.stringify({ immediate }),
+    });
+  },
 };
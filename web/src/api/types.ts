@@ -221,7 +221,7 @@ export interface CreateDeploymentRequest {
 }
 
 // Node Types
-export type NodeStatus = 'online' | 'offline' | 'maintenance';
+export type NodeStatus = 'online' | 'offline' | 'maintenance' | 'unverified';
 
 export interface NodeCapacity {
   cpu_cores: number;
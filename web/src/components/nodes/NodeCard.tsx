@@ -36,12 +36,14 @@ const statusStyles: Record<string, string> = {
   online: 'bg-green-100 text-green-800',
   offline: 'bg-red-100 text-red-800',
   maintenance: 'bg-yellow-100 text-yellow-800',
+  unverified: 'bg-orange-100 text-orange-800',
 };
 
 const statusLabels: Record<string, string> = {
   online: 'Online',
   offline: 'Offline',
   maintenance: 'Maintenance',
+  unverified: 'Unverified',
 };
 
 function formatBytes(mb: number): string {